@@ -22,6 +22,7 @@ import (
 
 	"fmt"
 	"github.com/stretchr/testify/mock"
+	"github.com/uber/aresdb/common"
 	"github.com/uber/aresdb/diskstore"
 	diskMocks "github.com/uber/aresdb/diskstore/mocks"
 	"github.com/uber/aresdb/memstore"
@@ -57,7 +58,8 @@ func CreateMockHostMemoryManger() *memComMocks.HostMemoryManager {
 // CreateMemStore creates a mocked MemStore for testing.
 func CreateMemStore(schema *memstore.TableSchema, shardID int, metaStore metastore.MetaStore,
 	diskStore diskstore.DiskStore) *memMocks.MemStore {
-	shard := memstore.NewTableShard(schema, metaStore, diskStore, CreateMockHostMemoryManger(), shardID)
+	shard := memstore.NewTableShard(schema, metaStore, diskStore, CreateMockHostMemoryManger(), shardID,
+		memstore.Options{RedoLogSync: common.RedoLogSyncConfig{Policy: common.RedoLogSyncAsync}})
 
 	memStore := new(memMocks.MemStore)
 	memStore.On("GetTableShard", schema.Schema.Name, shardID).Return(shard, nil).
@@ -15,29 +15,140 @@
 package api
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/uber/aresdb/common"
 	"github.com/uber/aresdb/memstore"
+	memCom "github.com/uber/aresdb/memstore/common"
+	"github.com/uber/aresdb/metastore"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/query"
 	"github.com/uber/aresdb/utils"
 
+	"github.com/apache/arrow/go/arrow/ipc"
+	"github.com/apache/arrow/go/arrow/memory"
 	"github.com/gorilla/mux"
 )
 
+// ingestionRetryAfterSeconds is the number of seconds clients are asked to wait before retrying
+// rejected upsert batches.
+const ingestionRetryAfterSeconds = 1
+
+// maintenanceCacheTTL is how long the cluster level maintenance read from metastore is reused
+// by ingestion requests.
+const maintenanceCacheTTL = time.Second
+
 // DataHandler handles data ingestion requests from the ingestion pipeline.
 type DataHandler struct {
-	memStore memstore.MemStore
+	memStore  memstore.MemStore
+	metaStore metastore.MetaStore
+	sessions  *ingestionSessions
+
+	// cluster level maintenance cached from metastore.
+	maintenanceLock      sync.Mutex
+	maintenance          *metaCom.Maintenance
+	maintenanceFetchedAt time.Time
 }
 
-// NewDataHandler creates a new DataHandler.
-func NewDataHandler(memStore memstore.MemStore) *DataHandler {
+// NewDataHandler creates a new DataHandler, chunks of ingestion sessions are kept under sessionDir.
+func NewDataHandler(memStore memstore.MemStore, metaStore metastore.MetaStore, sessionDir string,
+	sessionConfig common.IngestionSessionsConfig) *DataHandler {
 	return &DataHandler{
-		memStore: memStore,
+		memStore:  memStore,
+		metaStore: metaStore,
+		sessions:  newIngestionSessions(sessionDir, sessionConfig),
 	}
 }
 
+// Run is a ticker function to remove expired ingestion sessions periodically.
+func (handler *DataHandler) Run() {
+	handler.sessions.Run()
+}
+
+// Stop stops removing expired ingestion sessions.
+func (handler *DataHandler) Stop() {
+	handler.sessions.Stop()
+}
+
 // Register registers http handlers.
 func (handler *DataHandler) Register(router *mux.Router, wrappers ...utils.HTTPHandlerWrapper) {
-	router.HandleFunc("/{table}/{shard}", utils.ApplyHTTPWrappers(handler.PostData, wrappers)).Methods(http.MethodPost)
+	router.HandleFunc("/{table}/{shard}", utils.ApplyHTTPWrappers(handler.withMaintenanceCheck(handler.PostData), wrappers)).Methods(http.MethodPost)
+	router.HandleFunc("/{table}/{shard}/arrow", utils.ApplyHTTPWrappers(handler.withMaintenanceCheck(handler.PostArrowData), wrappers)).Methods(http.MethodPost)
+	router.HandleFunc("/{table}/{shard}/json", utils.ApplyHTTPWrappers(handler.withMaintenanceCheck(handler.PostJSONData), wrappers)).Methods(http.MethodPost)
+	router.HandleFunc("/{table}/{shard}/sessions", utils.ApplyHTTPWrappers(handler.withMaintenanceCheck(handler.OpenIngestionSession), wrappers)).Methods(http.MethodPost)
+	router.HandleFunc("/{table}/{shard}/sessions/{session}", utils.ApplyHTTPWrappers(handler.GetIngestionSession, wrappers)).Methods(http.MethodGet)
+	router.HandleFunc("/{table}/{shard}/sessions/{session}", utils.ApplyHTTPWrappers(handler.AbortIngestionSession, wrappers)).Methods(http.MethodDelete)
+	router.HandleFunc("/{table}/{shard}/sessions/{session}/chunks/{chunk}", utils.ApplyHTTPWrappers(handler.withMaintenanceCheck(handler.PostIngestionChunk), wrappers)).Methods(http.MethodPut)
+	router.HandleFunc("/{table}/{shard}/sessions/{session}/commit", utils.ApplyHTTPWrappers(handler.withMaintenanceCheck(handler.CommitIngestionSession), wrappers)).Methods(http.MethodPost)
+	router.HandleFunc("/{table}", utils.ApplyHTTPWrappers(handler.withMaintenanceCheck(handler.DeleteData), wrappers)).Methods(http.MethodDelete)
+	router.HandleFunc("/{table}/deletes", utils.ApplyHTTPWrappers(handler.ListDeletePredicates, wrappers)).Methods(http.MethodGet)
+	router.HandleFunc("/{table}/deletes/{id}", utils.ApplyHTTPWrappers(handler.withMaintenanceCheck(handler.RemoveDeletePredicate), wrappers)).Methods(http.MethodDelete)
+}
+
+// withMaintenanceCheck rejects writes to the table with 503 while the table or the cluster is in
+// maintenance.
+func (handler *DataHandler) withMaintenanceCheck(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		table := mux.Vars(r)["table"]
+		now := utils.Now().Unix()
+		if maintenance := handler.getMaintenance(table, now); maintenance != nil {
+			w.Header().Set("Retry-After", strconv.FormatInt(maintenance.ExpiresAt-now, 10))
+			RespondWithError(w, utils.APIError{
+				Code: http.StatusServiceUnavailable,
+				Message: fmt.Sprintf("Ingestion of table %s is frozen for maintenance until %s: %s",
+					table, time.Unix(maintenance.ExpiresAt, 0).UTC().Format(time.RFC3339), maintenance.Reason),
+			})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// getMaintenance returns the active maintenance of the cluster or of the table, nil if none.
+func (handler *DataHandler) getMaintenance(table string, now int64) *metaCom.Maintenance {
+	if maintenance := handler.getClusterMaintenance(); maintenance.IsActive(now) {
+		return maintenance
+	}
+
+	// unknown tables are reported by the handlers.
+	schema, err := handler.memStore.GetSchema(table)
+	if err != nil {
+		return nil
+	}
+	schema.RLock()
+	maintenance := schema.Schema.Config.Maintenance
+	schema.RUnlock()
+	if maintenance.IsActive(now) {
+		return maintenance
+	}
+	return nil
+}
+
+// getClusterMaintenance returns the cluster level maintenance from metastore, which is cached for
+// maintenanceCacheTTL. The last maintenance read is kept if metastore fails.
+func (handler *DataHandler) getClusterMaintenance() *metaCom.Maintenance {
+	handler.maintenanceLock.Lock()
+	defer handler.maintenanceLock.Unlock()
+	if utils.Now().Sub(handler.maintenanceFetchedAt) < maintenanceCacheTTL {
+		return handler.maintenance
+	}
+
+	maintenance, err := handler.metaStore.GetMaintenance()
+	if err != nil {
+		utils.GetLogger().With("error", err).Warn("Failed to read maintenance from metastore")
+	} else {
+		handler.maintenance = maintenance
+	}
+	handler.maintenanceFetchedAt = utils.Now()
+	return handler.maintenance
 }
 
 // PostData swagger:route POST /data/{table}/{shard} postData
@@ -48,6 +159,7 @@ func (handler *DataHandler) Register(router *mux.Router, wrappers ...utils.HTTPH
 // Responses:
 //    default: errorResponse
 //        200: noContentResponse
+//        429: errorResponse
 func (handler *DataHandler) PostData(w http.ResponseWriter, r *http.Request) {
 	var postDataRequest PostDataRequest
 	err := ReadRequest(r, &postDataRequest)
@@ -61,12 +173,515 @@ func (handler *DataHandler) PostData(w http.ResponseWriter, r *http.Request) {
 		RespondWithBadRequest(w, err)
 		return
 	}
+	upsertBatch.IdempotencyKey = postDataRequest.IdempotencyKey
+
+	if err = handler.checkColumnWriteAccess(r, postDataRequest.TableName, upsertBatch); err != nil {
+		RespondWithError(w, err)
+		return
+	}
 
 	err = handler.memStore.HandleIngestion(postDataRequest.TableName, postDataRequest.Shard, upsertBatch)
 	if err != nil {
+		respondWithIngestionError(w, err)
+		return
+	}
+
+	RespondWithJSONObject(w, nil)
+}
+
+// PostArrowData swagger:route POST /data/{table}/{shard}/arrow postArrowData
+// Post arrow record batches in IPC stream format to a existing table shard.
+// Arrow fields are matched with table columns by name and each record batch
+// is ingested as a separate upsert batch. Nothing is applied if any record
+// batch is invalid, but the request is not atomic: if applying a record batch
+// fails, the record batches before it stay applied. Retrying the request with
+// the same Idempotency-Key skips them.
+// Consumes:
+//    - application/vnd.apache.arrow.stream
+//
+// Responses:
+//    default: errorResponse
+//        200: noContentResponse
+//        429: errorResponse
+func (handler *DataHandler) PostArrowData(w http.ResponseWriter, r *http.Request) {
+	var postArrowDataRequest PostArrowDataRequest
+	err := ReadRequest(r, &postArrowDataRequest)
+	if err != nil {
+		RespondWithError(w, err)
+		return
+	}
+
+	schema, err := handler.memStore.GetSchema(postArrowDataRequest.TableName)
+	if err != nil {
+		RespondWithError(w, err)
+		return
+	}
+
+	reader, err := ipc.NewReader(bytes.NewReader(postArrowDataRequest.Body), ipc.WithAllocator(memory.NewGoAllocator()))
+	if err != nil {
+		RespondWithBadRequest(w, utils.StackError(err, "Failed to read arrow stream"))
+		return
+	}
+	defer reader.Release()
+
+	// All record batches are converted and checked before any of them is applied.
+	var upsertBatches []*memstore.UpsertBatch
+	for recordIndex := 0; reader.Next(); recordIndex++ {
+		upsertBatch, err := memstore.NewUpsertBatchFromArrow(schema, reader.Record())
+		if err != nil {
+			RespondWithBadRequest(w, err)
+			return
+		}
+		// Each record is applied separately, so a retried request skips the records already applied.
+		if postArrowDataRequest.IdempotencyKey != "" {
+			upsertBatch.IdempotencyKey = postArrowDataRequest.IdempotencyKey + "/" + strconv.Itoa(recordIndex)
+		}
+
+		if err = handler.checkColumnWriteAccess(r, postArrowDataRequest.TableName, upsertBatch); err != nil {
+			RespondWithError(w, err)
+			return
+		}
+		upsertBatches = append(upsertBatches, upsertBatch)
+	}
+
+	if reader.Err() != nil {
+		RespondWithBadRequest(w, utils.StackError(reader.Err(), "Failed to read arrow stream"))
+		return
+	}
+
+	for _, upsertBatch := range upsertBatches {
+		err = handler.memStore.HandleIngestion(postArrowDataRequest.TableName, postArrowDataRequest.Shard, upsertBatch)
+		if err != nil {
+			respondWithIngestionError(w, err)
+			return
+		}
+	}
+
+	RespondWithJSONObject(w, nil)
+}
+
+// PostJSONData swagger:route POST /data/{table}/{shard}/json postJSONData
+// Post rows of nested JSON objects to a existing table shard, either as a JSON array
+// or as newline delimited objects. Nested objects are flattened into dotted paths
+// which are mapped to columns by the json ingestion config of the table, all rows
+// are ingested as a single upsert batch.
+// Consumes:
+//    - application/json
+//
+// Responses:
+//    default: errorResponse
+//        200: noContentResponse
+//        429: errorResponse
+func (handler *DataHandler) PostJSONData(w http.ResponseWriter, r *http.Request) {
+	var postJSONDataRequest PostJSONDataRequest
+	err := ReadRequest(r, &postJSONDataRequest)
+	if err != nil {
+		RespondWithError(w, err)
+		return
+	}
+
+	schema, err := handler.memStore.GetSchema(postJSONDataRequest.TableName)
+	if err != nil {
+		RespondWithError(w, err)
+		return
+	}
+
+	rows, err := decodeJSONRows(postJSONDataRequest.Body)
+	if err != nil {
+		RespondWithBadRequest(w, utils.StackError(err, "Failed to decode json rows"))
+		return
+	}
+
+	upsertBatch, err := memstore.NewUpsertBatchFromJSON(schema, rows)
+	if err != nil {
+		RespondWithBadRequest(w, err)
+		return
+	}
+	upsertBatch.IdempotencyKey = postJSONDataRequest.IdempotencyKey
+
+	if err = handler.checkColumnWriteAccess(r, postJSONDataRequest.TableName, upsertBatch); err != nil {
+		RespondWithError(w, err)
+		return
+	}
+
+	err = handler.memStore.HandleIngestion(postJSONDataRequest.TableName, postJSONDataRequest.Shard, upsertBatch)
+	if err != nil {
+		respondWithIngestionError(w, err)
+		return
+	}
+
+	RespondWithJSONObject(w, nil)
+}
+
+// decodeJSONRows decodes a JSON array of objects or newline delimited objects. Numbers are
+// decoded as json.Number to keep the precision of 64 bit integers.
+func decodeJSONRows(body []byte) ([]map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var rows []map[string]interface{}
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		err := decoder.Decode(&rows)
+		return rows, err
+	}
+
+	for {
+		var row map[string]interface{}
+		err := decoder.Decode(&row)
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+}
+
+// OpenIngestionSession swagger:route POST /data/{table}/{shard}/sessions openIngestionSession
+// Open a session to upload a large upsert batch in chunks. Chunks are uploaded in order and
+// the session can be resumed after the last acked chunk if the upload is interrupted or the server
+// restarts. Chunks are kept on disk and sessions expire after the configured ttl since they were
+// last used.
+//
+// Responses:
+//    default: errorResponse
+//        200: ingestionSessionResponse
+//        429: errorResponse
+func (handler *DataHandler) OpenIngestionSession(w http.ResponseWriter, r *http.Request) {
+	var request OpenIngestionSessionRequest
+	err := ReadRequest(r, &request)
+	if err != nil {
+		RespondWithError(w, err)
+		return
+	}
+
+	if _, err = handler.memStore.GetSchema(request.TableName); err != nil {
+		RespondWithError(w, ErrTableDoesNotExist)
+		return
+	}
+
+	var response IngestionSessionResponse
+	response.Body.SessionID, err = handler.sessions.open(request.TableName, request.Shard)
+	if err != nil {
+		RespondWithError(w, err)
+		return
+	}
+	response.Body.LastChunk = -1
+	RespondWithJSONObject(w, response.Body)
+}
+
+// GetIngestionSession swagger:route GET /data/{table}/{shard}/sessions/{session} getIngestionSession
+// Get the last acked chunk of an ingestion session to resume the upload from.
+//
+// Responses:
+//    default: errorResponse
+//        200: ingestionSessionResponse
+//        404: errorResponse
+func (handler *DataHandler) GetIngestionSession(w http.ResponseWriter, r *http.Request) {
+	var request IngestionSessionRequest
+	err := ReadRequest(r, &request)
+	if err != nil {
+		RespondWithError(w, err)
+		return
+	}
+
+	var response IngestionSessionResponse
+	response.Body.SessionID = request.SessionID
+	response.Body.LastChunk, response.Body.Committed, err = handler.sessions.status(request.SessionID, request.TableName, request.Shard)
+	if err != nil {
+		RespondWithError(w, err)
+		return
+	}
+	RespondWithJSONObject(w, response.Body)
+}
+
+// PostIngestionChunk swagger:route PUT /data/{table}/{shard}/sessions/{session}/chunks/{chunk} postIngestionChunk
+// Upload the next chunk of the serialized upsert batch. Chunks acked before are ignored, chunks
+// after the next one are rejected.
+// Consumes:
+//    - application/upsert-data
+//
+// Responses:
+//    default: errorResponse
+//        200: ingestionSessionResponse
+//        404: errorResponse
+//        409: errorResponse
+//        413: errorResponse
+func (handler *DataHandler) PostIngestionChunk(w http.ResponseWriter, r *http.Request) {
+	var request PostIngestionChunkRequest
+	err := ReadRequest(r, &request)
+	if err != nil {
+		RespondWithError(w, err)
+		return
+	}
+
+	var response IngestionSessionResponse
+	response.Body.SessionID = request.SessionID
+	response.Body.LastChunk, err = handler.sessions.addChunk(request.SessionID, request.TableName, request.Shard,
+		request.Chunk, request.Body)
+	if err != nil {
+		RespondWithError(w, err)
+		return
+	}
+	RespondWithJSONObject(w, response.Body)
+}
+
+// CommitIngestionSession swagger:route POST /data/{table}/{shard}/sessions/{session}/commit commitIngestionSession
+// Apply the uploaded chunks as a single upsert batch. The session is kept for retrying if the
+// commit fails, committing a committed session again does nothing.
+//
+// Responses:
+//    default: errorResponse
+//        200: noContentResponse
+//        404: errorResponse
+//        429: errorResponse
+func (handler *DataHandler) CommitIngestionSession(w http.ResponseWriter, r *http.Request) {
+	var request IngestionSessionRequest
+	err := ReadRequest(r, &request)
+	if err != nil {
+		RespondWithError(w, err)
+		return
+	}
+
+	var hostMemory memCom.HostMemoryManager
+	if shard, err := handler.memStore.GetTableShard(request.TableName, request.Shard); err == nil {
+		hostMemory = shard.HostMemoryManager
+		defer shard.Users.Done()
+	}
+
+	var ingestionErr error
+	err = handler.sessions.commit(request.SessionID, request.TableName, request.Shard, hostMemory, func(buffer []byte) error {
+		upsertBatch, err := memstore.NewUpsertBatch(buffer)
+		if err != nil {
+			return utils.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "Chunks of ingestion session are not a valid upsert batch",
+				Cause:   err,
+			}
+		}
+		if err = handler.checkColumnWriteAccess(r, request.TableName, upsertBatch); err != nil {
+			return err
+		}
+		ingestionErr = handler.memStore.HandleIngestion(request.TableName, request.Shard, upsertBatch)
+		return ingestionErr
+	})
+	if err != nil {
+		if ingestionErr != nil {
+			respondWithIngestionError(w, ingestionErr)
+		} else {
+			RespondWithError(w, err)
+		}
+		return
+	}
+
+	RespondWithJSONObject(w, nil)
+}
+
+// AbortIngestionSession swagger:route DELETE /data/{table}/{shard}/sessions/{session} abortIngestionSession
+// Discard an ingestion session and its uploaded chunks.
+//
+// Responses:
+//    default: errorResponse
+//        200: noContentResponse
+//        404: errorResponse
+func (handler *DataHandler) AbortIngestionSession(w http.ResponseWriter, r *http.Request) {
+	var request IngestionSessionRequest
+	err := ReadRequest(r, &request)
+	if err != nil {
+		RespondWithError(w, err)
+		return
+	}
+
+	if err = handler.sessions.abort(request.SessionID, request.TableName, request.Shard); err != nil {
 		RespondWithError(w, err)
 		return
 	}
+	RespondWithJSONObject(w, nil)
+}
+
+// checkColumnWriteAccess returns an APIError with StatusForbidden if the upsert batch has columns
+// the tenant of the request is not allowed to write. The tenant is identified by the tenant header
+// of queries. Unknown tables are reported by HandleIngestion.
+func (handler *DataHandler) checkColumnWriteAccess(r *http.Request, table string, upsertBatch *memstore.UpsertBatch) error {
+	schema, err := handler.memStore.GetSchema(table)
+	if err != nil {
+		return nil
+	}
+	tenant := getTenant(r, utils.GetConfig().Query.TenantLimits)
+
+	schema.RLock()
+	defer schema.RUnlock()
+	for col := 0; col < upsertBatch.NumColumns; col++ {
+		columnID, err := upsertBatch.GetColumnID(col)
+		if err != nil || columnID >= len(schema.Schema.Columns) {
+			continue
+		}
+		if column := schema.Schema.Columns[columnID]; !column.CanWrite(tenant) {
+			return utils.APIError{
+				Code:    http.StatusForbidden,
+				Message: fmt.Sprintf("Tenant %s is not allowed to write column %s of table %s", tenant, column.Name, table),
+			}
+		}
+	}
+	return nil
+}
+
+// respondWithIngestionError responds with the error of HandleIngestion. Clients are asked to retry
+// later if the upsert batch is rejected for too many upsert batches pending.
+func respondWithIngestionError(w http.ResponseWriter, err error) {
+	if err == memstore.ErrTooManyPendingUpsertBatches {
+		w.Header().Set("Retry-After", strconv.Itoa(ingestionRetryAfterSeconds))
+		RespondWithError(w, utils.APIError{
+			Code:    http.StatusTooManyRequests,
+			Message: err.Error(),
+		})
+		return
+	}
+	RespondWithError(w, err)
+}
 
+// checkTableWriteAccess returns an APIError with StatusForbidden if the tenant of the request is
+// not allowed to write every column of the table, which is required to manage the deleted rows
+// of the table. The tenant is identified by the tenant header of queries.
+func (handler *DataHandler) checkTableWriteAccess(r *http.Request, table string) error {
+	schema, err := handler.memStore.GetSchema(table)
+	if err != nil {
+		return utils.APIError{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	tenant := getTenant(r, utils.GetConfig().Query.TenantLimits)
+
+	schema.RLock()
+	defer schema.RUnlock()
+	for _, column := range schema.Schema.Columns {
+		if !column.Deleted && !column.CanWrite(tenant) {
+			return utils.APIError{
+				Code:    http.StatusForbidden,
+				Message: fmt.Sprintf("Tenant %s is not allowed to write column %s of table %s", tenant, column.Name, table),
+			}
+		}
+	}
+	return nil
+}
+
+// DeleteData swagger:route DELETE /data/{table} deleteData
+// Delete rows matching the filter with event time before now from both live and archive stores
+// of an existing fact table. Deleted rows are excluded from query results but the underlying
+// storage is not reclaimed. Rows ingested later are kept unless their event time is before the
+// deletion. Requires write access to every column of the table.
+// Consumes:
+//    - application/json
+//
+// Responses:
+//    default: errorResponse
+//        200: deletePredicateResponse
+//        403: errorResponse
+//        404: errorResponse
+func (handler *DataHandler) DeleteData(w http.ResponseWriter, r *http.Request) {
+	var deleteDataRequest DeleteDataRequest
+	err := ReadRequest(r, &deleteDataRequest)
+	if err != nil {
+		RespondWithError(w, err)
+		return
+	}
+
+	if deleteDataRequest.Body.Filter == "" {
+		RespondWithBadRequest(w, errors.New("must specify filter in the request body"))
+		return
+	}
+
+	if err = handler.checkTableWriteAccess(r, deleteDataRequest.TableName); err != nil {
+		RespondWithError(w, err)
+		return
+	}
+
+	// Compile a query with the filter to validate it against the table schema.
+	aqlQuery := query.AQLQuery{
+		Table:    deleteDataRequest.TableName,
+		Measures: []query.Measure{{Expr: "count(*)"}},
+		Filters:  []string{deleteDataRequest.Body.Filter},
+	}
+	qc := aqlQuery.Compile(handler.memStore, false)
+	if qc.Error != nil {
+		RespondWithBadRequest(w, qc.Error)
+		return
+	}
+
+	predicate, err := handler.memStore.DeleteRows(deleteDataRequest.TableName, deleteDataRequest.Body.Filter)
+	if err != nil {
+		if err == memstore.ErrDeleteDimensionTableRows {
+			RespondWithBadRequest(w, err)
+		} else {
+			RespondWithError(w, err)
+		}
+		return
+	}
+
+	RespondWithJSONObject(w, predicate)
+}
+
+// ListDeletePredicates swagger:route GET /data/{table}/deletes listDeletePredicates
+// List the predicates of rows deleted from the table. Requires write access to every column of
+// the table.
+//
+// Responses:
+//    default: errorResponse
+//        200: listDeletePredicatesResponse
+//        403: errorResponse
+//        404: errorResponse
+func (handler *DataHandler) ListDeletePredicates(w http.ResponseWriter, r *http.Request) {
+	var request ListDeletePredicatesRequest
+	err := ReadRequest(r, &request)
+	if err != nil {
+		RespondWithError(w, err)
+		return
+	}
+
+	if err = handler.checkTableWriteAccess(r, request.TableName); err != nil {
+		RespondWithError(w, err)
+		return
+	}
+
+	schema, err := handler.memStore.GetSchema(request.TableName)
+	if err != nil {
+		RespondWithError(w, utils.APIError{Code: http.StatusNotFound, Message: err.Error()})
+		return
+	}
+	schema.RLock()
+	predicates := append([]metaCom.DeletePredicate{}, schema.DeletePredicates...)
+	schema.RUnlock()
+	RespondWithJSONObject(w, predicates)
+}
+
+// RemoveDeletePredicate swagger:route DELETE /data/{table}/deletes/{id} removeDeletePredicate
+// Remove a delete predicate of the table, rows deleted by it are no longer excluded from query
+// results. Requires write access to every column of the table.
+//
+// Responses:
+//    default: errorResponse
+//        200: noContentResponse
+//        403: errorResponse
+//        404: errorResponse
+func (handler *DataHandler) RemoveDeletePredicate(w http.ResponseWriter, r *http.Request) {
+	var request RemoveDeletePredicateRequest
+	err := ReadRequest(r, &request)
+	if err != nil {
+		RespondWithError(w, err)
+		return
+	}
+
+	if err = handler.checkTableWriteAccess(r, request.TableName); err != nil {
+		RespondWithError(w, err)
+		return
+	}
+
+	if err = handler.memStore.RemoveDeletePredicate(request.TableName, request.ID); err != nil {
+		if err == metastore.ErrDeletePredicateDoesNotExist {
+			RespondWithError(w, utils.APIError{Code: http.StatusNotFound, Message: err.Error()})
+		} else {
+			RespondWithError(w, err)
+		}
+		return
+	}
 	RespondWithJSONObject(w, nil)
 }
@@ -16,20 +16,32 @@ package api
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"time"
 
+	"github.com/uber/aresdb/common"
 	"github.com/uber/aresdb/memstore"
 	memCom "github.com/uber/aresdb/memstore/common"
 	memMocks "github.com/uber/aresdb/memstore/mocks"
+	"github.com/uber/aresdb/metastore"
 	metaCom "github.com/uber/aresdb/metastore/common"
+	metaMocks "github.com/uber/aresdb/metastore/mocks"
 
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/ipc"
+	"github.com/apache/arrow/go/arrow/memory"
 	"github.com/gorilla/mux"
 	"github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/stretchr/testify/mock"
+	"github.com/uber/aresdb/utils"
 )
 
 var _ = ginkgo.Describe("DataHandler", func() {
@@ -37,16 +49,30 @@ var _ = ginkgo.Describe("DataHandler", func() {
 	var testSchema = memstore.NewTableSchema(&metaCom.Table{
 		Name:        "abc",
 		IsFactTable: false,
+		Columns: []metaCom.Column{
+			{Name: "status", Type: metaCom.Uint8},
+		},
+		PrimaryKeyColumns: []int{0},
 		Config: metaCom.TableConfig{
 			BatchSize: 10,
 		},
 	})
 
 	var memStore *memMocks.MemStore
+	var metaStore *metaMocks.MetaStore
+	var sessionDir string
 	ginkgo.BeforeEach(func() {
+		var err error
+		sessionDir, err = ioutil.TempDir("", "ingestion_sessions")
+		Ω(err).Should(BeNil())
 		memStore = CreateMemStore(testSchema, 0, nil, CreateMockDiskStore())
 		memStore.On("HandleIngestion", "abc", 0, mock.Anything).Return(nil)
-		dataHandler := NewDataHandler(memStore)
+		memStore.On("DeleteRows", "abc", "status = 1").Return(metaCom.DeletePredicate{ID: 1, Filter: "status = 1", Cutoff: 100}, nil)
+		memStore.On("RemoveDeletePredicate", "abc", 1).Return(nil)
+		memStore.On("RemoveDeletePredicate", "abc", 2).Return(metastore.ErrDeletePredicateDoesNotExist)
+		metaStore = &metaMocks.MetaStore{}
+		metaStore.On("GetMaintenance").Return(nil, nil)
+		dataHandler := NewDataHandler(memStore, metaStore, sessionDir, common.IngestionSessionsConfig{})
 		testRouter := mux.NewRouter()
 		dataHandler.Register(testRouter.PathPrefix("/data").Subrouter())
 
@@ -56,6 +82,7 @@ var _ = ginkgo.Describe("DataHandler", func() {
 
 	ginkgo.AfterEach(func() {
 		testServer.Close()
+		os.RemoveAll(sessionDir)
 	})
 
 	ginkgo.It("PostData fails on invalid request", func() {
@@ -76,4 +103,515 @@ var _ = ginkgo.Describe("DataHandler", func() {
 		Ω(err).Should(BeNil())
 		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
 	})
+
+	ginkgo.It("PostData should deny columns restricted to other tenants", func() {
+		testSchema.Lock()
+		testSchema.Schema.Columns[0].Config.WriteTenants = []string{"billing"}
+		testSchema.Unlock()
+		defer func() {
+			testSchema.Lock()
+			testSchema.Schema.Columns[0].Config.WriteTenants = nil
+			testSchema.Unlock()
+		}()
+
+		builder := memCom.NewUpsertBatchBuilder()
+		builder.AddColumn(0, memCom.Uint8)
+		builder.AddRow()
+		builder.SetValue(0, 0, uint8(1))
+		buffer, _ := builder.ToByteArray()
+		hostPort := testServer.Listener.Addr().String()
+		postData := func(tenant string) (int, string) {
+			req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/data/abc/0", hostPort), bytes.NewBuffer(buffer))
+			req.Header.Set("Content-Type", "application/upsert-data")
+			req.Header.Set("RPC-Caller", tenant)
+			resp, err := http.DefaultClient.Do(req)
+			Ω(err).Should(BeNil())
+			bs, err := ioutil.ReadAll(resp.Body)
+			Ω(err).Should(BeNil())
+			return resp.StatusCode, string(bs)
+		}
+
+		statusCode, body := postData("marketing")
+		Ω(statusCode).Should(Equal(http.StatusForbidden))
+		Ω(body).Should(ContainSubstring("Tenant marketing is not allowed to write column status of table abc"))
+
+		statusCode, _ = postData("billing")
+		Ω(statusCode).Should(Equal(http.StatusOK))
+	})
+
+	ginkgo.It("PostData should pass the idempotency key to the upsert batch", func() {
+		memStore.On("HandleIngestion", "abc", 2, mock.MatchedBy(func(upsertBatch *memstore.UpsertBatch) bool {
+			return upsertBatch.IdempotencyKey == "key"
+		})).Return(nil)
+		buffer, _ := memCom.NewUpsertBatchBuilder().ToByteArray()
+		hostPort := testServer.Listener.Addr().String()
+		req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/data/abc/2", hostPort), bytes.NewBuffer(buffer))
+		req.Header.Set("Content-Type", "application/upsert-data")
+		req.Header.Set("Idempotency-Key", "key")
+		resp, err := http.DefaultClient.Do(req)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+	})
+
+	ginkgo.It("PostData should ask to retry when too many upsert batches are pending", func() {
+		memStore.On("HandleIngestion", "abc", 1, mock.Anything).Return(memstore.ErrTooManyPendingUpsertBatches)
+		buffer, _ := memCom.NewUpsertBatchBuilder().ToByteArray()
+		hostPort := testServer.Listener.Addr().String()
+		resp, err := http.Post(fmt.Sprintf("http://%s/data/abc/1", hostPort), "application/upsert-data", bytes.NewBuffer(buffer))
+		Ω(err).Should(BeNil())
+		bs, err := ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusTooManyRequests))
+		Ω(resp.Header.Get("Retry-After")).Should(Equal("1"))
+		Ω(string(bs)).Should(ContainSubstring("too many upsert batches pending"))
+	})
+
+	ginkgo.It("ingestion sessions should resume uploads and commit atomically", func() {
+		var ingested []*memstore.UpsertBatch
+		memStore.On("HandleIngestion", "abc", 3, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			ingested = append(ingested, args.Get(2).(*memstore.UpsertBatch))
+		})
+		memStore.On("GetTableShard", "abc", 3).Return(nil, errors.New("some error"))
+		hostPort := testServer.Listener.Addr().String()
+		sessionURL := func(sessionID string, path string) string {
+			return fmt.Sprintf("http://%s/data/abc/3/sessions/%s%s", hostPort, sessionID, path)
+		}
+		do := func(method, url string, body []byte) (int, IngestionSessionResponse) {
+			req, _ := http.NewRequest(method, url, bytes.NewReader(body))
+			resp, err := http.DefaultClient.Do(req)
+			Ω(err).Should(BeNil())
+			var response IngestionSessionResponse
+			bs, err := ioutil.ReadAll(resp.Body)
+			Ω(err).Should(BeNil())
+			if resp.StatusCode == http.StatusOK && len(bs) > 0 {
+				Ω(json.Unmarshal(bs, &response.Body)).Should(BeNil())
+			}
+			return resp.StatusCode, response
+		}
+
+		builder := memCom.NewUpsertBatchBuilder()
+		builder.AddColumn(0, memCom.Uint8)
+		for row := 0; row < 100; row++ {
+			builder.AddRow()
+			builder.SetValue(row, 0, uint8(row))
+		}
+		buffer, _ := builder.ToByteArray()
+		chunkSize := len(buffer)/3 + 1
+		chunks := [][]byte{buffer[:chunkSize], buffer[chunkSize : 2*chunkSize], buffer[2*chunkSize:]}
+
+		code, response := do(http.MethodPost, fmt.Sprintf("http://%s/data/abc/3/sessions", hostPort), nil)
+		Ω(code).Should(Equal(http.StatusOK))
+		Ω(response.Body.LastChunk).Should(Equal(-1))
+		sessionID := response.Body.SessionID
+		Ω(sessionID).ShouldNot(BeEmpty())
+
+		code, response = do(http.MethodPut, sessionURL(sessionID, "/chunks/0"), chunks[0])
+		Ω(code).Should(Equal(http.StatusOK))
+		Ω(response.Body.LastChunk).Should(Equal(0))
+
+		// the upload fails before chunk 1 is acked, the client resumes from the session status.
+		code, _ = do(http.MethodPut, sessionURL(sessionID, "/chunks/2"), chunks[2])
+		Ω(code).Should(Equal(http.StatusConflict))
+		code, response = do(http.MethodGet, sessionURL(sessionID, ""), nil)
+		Ω(code).Should(Equal(http.StatusOK))
+		Ω(response.Body.LastChunk).Should(Equal(0))
+		Ω(response.Body.Committed).Should(BeFalse())
+		// resent chunks are ignored.
+		code, response = do(http.MethodPut, sessionURL(sessionID, "/chunks/0"), chunks[0])
+		Ω(code).Should(Equal(http.StatusOK))
+		Ω(response.Body.LastChunk).Should(Equal(0))
+		for i := 1; i < len(chunks); i++ {
+			code, response = do(http.MethodPut, sessionURL(sessionID, fmt.Sprintf("/chunks/%d", i)), chunks[i])
+			Ω(code).Should(Equal(http.StatusOK))
+			Ω(response.Body.LastChunk).Should(Equal(i))
+		}
+		// nothing is applied before commit.
+		Ω(ingested).Should(BeEmpty())
+
+		code, _ = do(http.MethodPost, sessionURL(sessionID, "/commit"), nil)
+		Ω(code).Should(Equal(http.StatusOK))
+		Ω(ingested).Should(HaveLen(1))
+		Ω(ingested[0].NumRows).Should(Equal(100))
+		Ω(ingested[0].GetBuffer()).Should(Equal(buffer))
+
+		// retried commits are not applied again and no more chunks are accepted.
+		code, _ = do(http.MethodPost, sessionURL(sessionID, "/commit"), nil)
+		Ω(code).Should(Equal(http.StatusOK))
+		Ω(ingested).Should(HaveLen(1))
+		code, _ = do(http.MethodPut, sessionURL(sessionID, "/chunks/3"), chunks[0])
+		Ω(code).Should(Equal(http.StatusConflict))
+		code, response = do(http.MethodGet, sessionURL(sessionID, ""), nil)
+		Ω(code).Should(Equal(http.StatusOK))
+		Ω(response.Body.Committed).Should(BeTrue())
+
+		code, _ = do(http.MethodDelete, sessionURL(sessionID, ""), nil)
+		Ω(code).Should(Equal(http.StatusOK))
+		code, _ = do(http.MethodGet, sessionURL(sessionID, ""), nil)
+		Ω(code).Should(Equal(http.StatusNotFound))
+		code, _ = do(http.MethodPost, fmt.Sprintf("http://%s/data/unknown/3/sessions", hostPort), nil)
+		Ω(code).Should(Equal(http.StatusBadRequest))
+	})
+
+	ginkgo.It("ingestion sessions should be kept for retrying failed commits", func() {
+		memStore.On("HandleIngestion", "abc", 4, mock.Anything).Return(memstore.ErrTooManyPendingUpsertBatches).Once()
+		memStore.On("HandleIngestion", "abc", 4, mock.Anything).Return(nil).Once()
+		hostMemory := CreateMockHostMemoryManger()
+		shard := memstore.NewTableShard(testSchema, metaStore, CreateMockDiskStore(), hostMemory, 4, memstore.Options{})
+		memStore.On("GetTableShard", "abc", 4).Return(shard, nil).Run(func(arguments mock.Arguments) {
+			shard.Users.Add(1)
+		})
+		hostPort := testServer.Listener.Addr().String()
+		handler := NewDataHandler(memStore, metaStore, sessionDir, common.IngestionSessionsConfig{})
+		sessionID, err := handler.sessions.open("abc", 4)
+		Ω(err).Should(BeNil())
+		buffer, _ := memCom.NewUpsertBatchBuilder().ToByteArray()
+		_, err = handler.sessions.addChunk(sessionID, "abc", 4, 0, buffer)
+		Ω(err).Should(BeNil())
+		// chunks are kept on disk.
+		Ω(ioutil.ReadFile(handler.sessions.chunkPath(sessionID, 0))).Should(Equal(buffer))
+		_, _, err = handler.sessions.status(sessionID, "abc", 3)
+		Ω(err).Should(Equal(ErrIngestionSessionNotFound))
+
+		router := mux.NewRouter()
+		handler.Register(router.PathPrefix("/data").Subrouter())
+		commit := func() *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/data/abc/4/sessions/%s/commit", hostPort, sessionID), nil)
+			router.ServeHTTP(recorder, req)
+			return recorder
+		}
+		recorder := commit()
+		Ω(recorder.Code).Should(Equal(http.StatusTooManyRequests))
+		Ω(recorder.Header().Get("Retry-After")).Should(Equal("1"))
+		lastChunk, committed, err := handler.sessions.status(sessionID, "abc", 4)
+		Ω(err).Should(BeNil())
+		Ω(lastChunk).Should(Equal(0))
+		Ω(committed).Should(BeFalse())
+
+		Ω(commit().Code).Should(Equal(http.StatusOK))
+		_, committed, err = handler.sessions.status(sessionID, "abc", 4)
+		Ω(err).Should(BeNil())
+		Ω(committed).Should(BeTrue())
+		// the commit buffer is reported to the host memory manager while it is alive.
+		hostMemory.AssertCalled(ginkgo.GinkgoT(), "ReportUnmanagedSpaceUsageChange", int64(len(buffer)))
+		hostMemory.AssertCalled(ginkgo.GinkgoT(), "ReportUnmanagedSpaceUsageChange", -int64(len(buffer)))
+		_, err = os.Stat(handler.sessions.chunkPath(sessionID, 0))
+		Ω(os.IsNotExist(err)).Should(BeTrue())
+
+		// expired sessions are swept from disk.
+		utils.SetClockImplementation(func() time.Time {
+			return time.Now().Add(2 * time.Hour)
+		})
+		defer utils.ResetClockImplementation()
+		handler.sessions.sweep()
+		_, _, err = handler.sessions.status(sessionID, "abc", 4)
+		Ω(err).Should(Equal(ErrIngestionSessionNotFound))
+		_, err = os.Stat(handler.sessions.sessionDir(sessionID))
+		Ω(os.IsNotExist(err)).Should(BeTrue())
+	})
+
+	ginkgo.It("ingestion sessions should be resumed after restart", func() {
+		handler := NewDataHandler(memStore, metaStore, sessionDir, common.IngestionSessionsConfig{})
+		sessionID, err := handler.sessions.open("abc", 5)
+		Ω(err).Should(BeNil())
+		_, err = handler.sessions.addChunk(sessionID, "abc", 5, 0, []byte{1, 2})
+		Ω(err).Should(BeNil())
+		_, err = handler.sessions.addChunk(sessionID, "abc", 5, 1, []byte{3})
+		Ω(err).Should(BeNil())
+		committedID, err := handler.sessions.open("abc", 5)
+		Ω(err).Should(BeNil())
+		Ω(handler.sessions.commit(committedID, "abc", 5, nil, func(buffer []byte) error {
+			return nil
+		})).Should(BeNil())
+		// a partially written chunk left by a crash is ignored.
+		Ω(ioutil.WriteFile(handler.sessions.chunkPath(sessionID, 2)+".tmp", []byte{4}, 0644)).Should(BeNil())
+
+		restarted := NewDataHandler(memStore, metaStore, sessionDir, common.IngestionSessionsConfig{})
+		lastChunk, committed, err := restarted.sessions.status(sessionID, "abc", 5)
+		Ω(err).Should(BeNil())
+		Ω(lastChunk).Should(Equal(1))
+		Ω(committed).Should(BeFalse())
+		_, committed, err = restarted.sessions.status(committedID, "abc", 5)
+		Ω(err).Should(BeNil())
+		Ω(committed).Should(BeTrue())
+
+		lastChunk, err = restarted.sessions.addChunk(sessionID, "abc", 5, 2, []byte{4})
+		Ω(err).Should(BeNil())
+		Ω(lastChunk).Should(Equal(2))
+		var applied []byte
+		Ω(restarted.sessions.commit(sessionID, "abc", 5, nil, func(buffer []byte) error {
+			applied = buffer
+			return nil
+		})).Should(BeNil())
+		Ω(applied).Should(Equal([]byte{1, 2, 3, 4}))
+	})
+
+	ginkgo.It("ingestion sessions should be bounded", func() {
+		handler := NewDataHandler(memStore, metaStore, sessionDir, common.IngestionSessionsConfig{
+			MaxChunkBytes:   2,
+			MaxSessionBytes: 3,
+			MaxSessions:     1,
+		})
+		sessionID, err := handler.sessions.open("abc", 5)
+		Ω(err).Should(BeNil())
+		_, err = handler.sessions.open("abc", 5)
+		Ω(err).Should(Equal(ErrTooManyIngestionSessions))
+
+		_, err = handler.sessions.addChunk(sessionID, "abc", 5, 0, []byte{1, 2, 3})
+		Ω(err.(utils.APIError).Code).Should(Equal(http.StatusRequestEntityTooLarge))
+		_, err = handler.sessions.addChunk(sessionID, "abc", 5, 0, []byte{1, 2})
+		Ω(err).Should(BeNil())
+		_, err = handler.sessions.addChunk(sessionID, "abc", 5, 1, []byte{3, 4})
+		Ω(err.(utils.APIError).Code).Should(Equal(http.StatusRequestEntityTooLarge))
+		lastChunk, err := handler.sessions.addChunk(sessionID, "abc", 5, 1, []byte{3})
+		Ω(err).Should(BeNil())
+		Ω(lastChunk).Should(Equal(1))
+
+		// aborted sessions free their slot.
+		Ω(handler.sessions.abort(sessionID, "abc", 5)).Should(BeNil())
+		_, err = handler.sessions.open("abc", 5)
+		Ω(err).Should(BeNil())
+	})
+
+	ginkgo.It("PostArrowData should work", func() {
+		hostPort := testServer.Listener.Addr().String()
+		arrowStream := func(field arrow.Field, values []uint8, valid []bool, numRecords int) *bytes.Buffer {
+			pool := memory.NewGoAllocator()
+			arrowSchema := arrow.NewSchema([]arrow.Field{field}, nil)
+			builder := array.NewRecordBuilder(pool, arrowSchema)
+			defer builder.Release()
+
+			var buffer bytes.Buffer
+			writer := ipc.NewWriter(&buffer, ipc.WithSchema(arrowSchema), ipc.WithAllocator(pool))
+			for i := 0; i < numRecords; i++ {
+				builder.Field(0).(*array.Uint8Builder).AppendValues(values, valid)
+				record := builder.NewRecord()
+				Ω(writer.Write(record)).Should(BeNil())
+				record.Release()
+			}
+			Ω(writer.Close()).Should(BeNil())
+			return &buffer
+		}
+		postArrowData := func(field arrow.Field, values []uint8, valid []bool) int {
+			buffer := arrowStream(field, values, valid, 1)
+			resp, err := http.Post(fmt.Sprintf("http://%s/data/abc/0/arrow", hostPort), "application/vnd.apache.arrow.stream", buffer)
+			Ω(err).Should(BeNil())
+			_, err = ioutil.ReadAll(resp.Body)
+			Ω(err).Should(BeNil())
+			return resp.StatusCode
+		}
+
+		Ω(postArrowData(arrow.Field{Name: "status", Type: arrow.PrimitiveTypes.Uint8, Nullable: true},
+			[]uint8{1, 0}, []bool{true, false})).Should(Equal(http.StatusOK))
+		memStore.AssertNumberOfCalls(ginkgo.GinkgoT(), "HandleIngestion", 1)
+		upsertBatch := memStore.Calls[len(memStore.Calls)-1].Arguments.Get(2).(*memstore.UpsertBatch)
+		rows, err := upsertBatch.ReadData(0, 2)
+		Ω(err).Should(BeNil())
+		Ω(rows).Should(Equal([][]interface{}{{uint8(1)}, {nil}}))
+
+		Ω(postArrowData(arrow.Field{Name: "unknown", Type: arrow.PrimitiveTypes.Uint8},
+			[]uint8{1}, nil)).Should(Equal(http.StatusBadRequest))
+		memStore.AssertNumberOfCalls(ginkgo.GinkgoT(), "HandleIngestion", 1)
+
+		resp, err := http.Post(fmt.Sprintf("http://%s/data/abc/0/arrow", hostPort), "application/vnd.apache.arrow.stream", bytes.NewBufferString("abc"))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+
+		// no record is applied if a later record in the stream is invalid.
+		stream := arrowStream(arrow.Field{Name: "status", Type: arrow.PrimitiveTypes.Uint8, Nullable: true},
+			[]uint8{1, 0}, []bool{true, false}, 2).Bytes()
+		resp, err = http.Post(fmt.Sprintf("http://%s/data/abc/0/arrow", hostPort), "application/vnd.apache.arrow.stream",
+			bytes.NewReader(stream[:len(stream)-20]))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+		memStore.AssertNumberOfCalls(ginkgo.GinkgoT(), "HandleIngestion", 1)
+	})
+
+	ginkgo.It("PostJSONData should work", func() {
+		hostPort := testServer.Listener.Addr().String()
+		postJSONData := func(body string) int {
+			resp, err := http.Post(fmt.Sprintf("http://%s/data/abc/0/json", hostPort), "application/json", bytes.NewBufferString(body))
+			Ω(err).Should(BeNil())
+			_, err = ioutil.ReadAll(resp.Body)
+			Ω(err).Should(BeNil())
+			return resp.StatusCode
+		}
+
+		Ω(postJSONData(`[{"status": 1, "unknown": {"a": 1}}, {"status": null}]`)).Should(Equal(http.StatusOK))
+		memStore.AssertNumberOfCalls(ginkgo.GinkgoT(), "HandleIngestion", 1)
+		upsertBatch := memStore.Calls[len(memStore.Calls)-1].Arguments.Get(2).(*memstore.UpsertBatch)
+		rows, err := upsertBatch.ReadData(0, 2)
+		Ω(err).Should(BeNil())
+		Ω(rows).Should(Equal([][]interface{}{{uint8(1)}, {nil}}))
+
+		// newline delimited objects.
+		Ω(postJSONData("{\"status\": 2}\n{\"status\": \"3\"}\n")).Should(Equal(http.StatusOK))
+		memStore.AssertNumberOfCalls(ginkgo.GinkgoT(), "HandleIngestion", 2)
+		upsertBatch = memStore.Calls[len(memStore.Calls)-1].Arguments.Get(2).(*memstore.UpsertBatch)
+		rows, err = upsertBatch.ReadData(0, 2)
+		Ω(err).Should(BeNil())
+		Ω(rows).Should(Equal([][]interface{}{{uint8(2)}, {uint8(3)}}))
+
+		Ω(postJSONData(`[{"status": 1.5}]`)).Should(Equal(http.StatusBadRequest))
+		Ω(postJSONData(`[{"status": 1}`)).Should(Equal(http.StatusBadRequest))
+		memStore.AssertNumberOfCalls(ginkgo.GinkgoT(), "HandleIngestion", 2)
+	})
+
+	ginkgo.It("DeleteData should work", func() {
+		hostPort := testServer.Listener.Addr().String()
+		deleteData := func(table, body, tenant string) (int, string) {
+			req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://%s/data/%s", hostPort, table), bytes.NewBufferString(body))
+			Ω(err).Should(BeNil())
+			req.Header.Set("RPC-Caller", tenant)
+			resp, err := http.DefaultClient.Do(req)
+			Ω(err).Should(BeNil())
+			bs, err := ioutil.ReadAll(resp.Body)
+			Ω(err).Should(BeNil())
+			return resp.StatusCode, string(bs)
+		}
+
+		statusCode, body := deleteData("abc", `{"filter": "status = 1"}`, "")
+		Ω(statusCode).Should(Equal(http.StatusOK))
+		Ω(body).Should(MatchJSON(`{"id": 1, "filter": "status = 1", "cutoff": 100}`))
+		memStore.AssertCalled(ginkgo.GinkgoT(), "DeleteRows", "abc", "status = 1")
+
+		statusCode, _ = deleteData("abc", `{}`, "")
+		Ω(statusCode).Should(Equal(http.StatusBadRequest))
+		statusCode, _ = deleteData("abc", `{"filter": "unknown_column = 1"}`, "")
+		Ω(statusCode).Should(Equal(http.StatusBadRequest))
+		statusCode, _ = deleteData("unknown", `{"filter": "status = 1"}`, "")
+		Ω(statusCode).Should(Equal(http.StatusNotFound))
+
+		testSchema.Lock()
+		testSchema.Schema.Columns[0].Config.WriteTenants = []string{"billing"}
+		testSchema.Unlock()
+		defer func() {
+			testSchema.Lock()
+			testSchema.Schema.Columns[0].Config.WriteTenants = nil
+			testSchema.Unlock()
+		}()
+		statusCode, body = deleteData("abc", `{"filter": "status = 1"}`, "marketing")
+		Ω(statusCode).Should(Equal(http.StatusForbidden))
+		Ω(body).Should(ContainSubstring("Tenant marketing is not allowed to write column status of table abc"))
+		statusCode, _ = deleteData("abc", `{"filter": "status = 1"}`, "billing")
+		Ω(statusCode).Should(Equal(http.StatusOK))
+		memStore.AssertNumberOfCalls(ginkgo.GinkgoT(), "DeleteRows", 2)
+	})
+
+	ginkgo.It("ListDeletePredicates should work", func() {
+		testSchema.Lock()
+		testSchema.DeletePredicates = []metaCom.DeletePredicate{{ID: 1, Filter: "status = 1", Cutoff: 100}}
+		testSchema.Unlock()
+		defer func() {
+			testSchema.Lock()
+			testSchema.DeletePredicates = nil
+			testSchema.Unlock()
+		}()
+
+		hostPort := testServer.Listener.Addr().String()
+		resp, err := http.Get(fmt.Sprintf("http://%s/data/abc/deletes", hostPort))
+		Ω(err).Should(BeNil())
+		bs, err := ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+		Ω(bs).Should(MatchJSON(`[{"id": 1, "filter": "status = 1", "cutoff": 100}]`))
+
+		resp, err = http.Get(fmt.Sprintf("http://%s/data/unknown/deletes", hostPort))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusNotFound))
+	})
+
+	ginkgo.It("RemoveDeletePredicate should work", func() {
+		hostPort := testServer.Listener.Addr().String()
+		removePredicate := func(table string, id int) int {
+			req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://%s/data/%s/deletes/%d", hostPort, table, id), nil)
+			Ω(err).Should(BeNil())
+			resp, err := http.DefaultClient.Do(req)
+			Ω(err).Should(BeNil())
+			_, err = ioutil.ReadAll(resp.Body)
+			Ω(err).Should(BeNil())
+			return resp.StatusCode
+		}
+
+		Ω(removePredicate("abc", 1)).Should(Equal(http.StatusOK))
+		memStore.AssertCalled(ginkgo.GinkgoT(), "RemoveDeletePredicate", "abc", 1)
+		Ω(removePredicate("abc", 2)).Should(Equal(http.StatusNotFound))
+		Ω(removePredicate("unknown", 1)).Should(Equal(http.StatusNotFound))
+		memStore.AssertNumberOfCalls(ginkgo.GinkgoT(), "RemoveDeletePredicate", 2)
+	})
+
+	ginkgo.It("rejects writes while in maintenance", func() {
+		hostPort := testServer.Listener.Addr().String()
+		buffer, _ := memCom.NewUpsertBatchBuilder().ToByteArray()
+		postData := func() *http.Response {
+			resp, err := http.Post(fmt.Sprintf("http://%s/data/abc/0", hostPort), "application/upsert-data", bytes.NewBuffer(buffer))
+			Ω(err).Should(BeNil())
+			return resp
+		}
+
+		now := time.Unix(1000, 0)
+		utils.SetCurrentTime(now)
+		defer utils.ResetClockImplementation()
+		testSchema.Schema.Config.Maintenance = &metaCom.Maintenance{Reason: "backfill", ExpiresAt: 1600}
+		defer func() {
+			testSchema.Schema.Config.Maintenance = nil
+		}()
+
+		resp := postData()
+		Ω(resp.StatusCode).Should(Equal(http.StatusServiceUnavailable))
+		Ω(resp.Header.Get("Retry-After")).Should(Equal("600"))
+		body, _ := ioutil.ReadAll(resp.Body)
+		Ω(string(body)).Should(ContainSubstring("abc"))
+		Ω(string(body)).Should(ContainSubstring("backfill"))
+
+		req, _ := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://%s/data/abc", hostPort), bytes.NewBufferString(`{"filter": "status = 1"}`))
+		resp, err := http.DefaultClient.Do(req)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusServiceUnavailable))
+		memStore.AssertNotCalled(ginkgo.GinkgoT(), "HandleIngestion", "abc", 0, mock.Anything)
+		memStore.AssertNotCalled(ginkgo.GinkgoT(), "DeleteRows", "abc", "status = 1")
+
+		// reads are still served.
+		resp, err = http.Get(fmt.Sprintf("http://%s/data/abc/0/sessions/unknown", hostPort))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusNotFound))
+
+		// writes are accepted again after the maintenance expires.
+		utils.SetCurrentTime(now.Add(600 * time.Second))
+		Ω(postData().StatusCode).Should(Equal(http.StatusOK))
+	})
+
+	ginkgo.It("rejects writes while the cluster is in maintenance", func() {
+		now := time.Unix(1000, 0)
+		utils.SetCurrentTime(now)
+		defer utils.ResetClockImplementation()
+		metaStore = &metaMocks.MetaStore{}
+		metaStore.On("GetMaintenance").Return(&metaCom.Maintenance{ExpiresAt: 1010}, nil).Once()
+		metaStore.On("GetMaintenance").Return(nil, errors.New("some error")).Once()
+		metaStore.On("GetMaintenance").Return(nil, nil)
+		handler := NewDataHandler(memStore, metaStore, sessionDir, common.IngestionSessionsConfig{})
+		router := mux.NewRouter()
+		handler.Register(router.PathPrefix("/data").Subrouter())
+		buffer, _ := memCom.NewUpsertBatchBuilder().ToByteArray()
+		postData := func() *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, "/data/abc/0", bytes.NewBuffer(buffer))
+			router.ServeHTTP(recorder, req)
+			return recorder
+		}
+
+		recorder := postData()
+		Ω(recorder.Code).Should(Equal(http.StatusServiceUnavailable))
+		Ω(recorder.Header().Get("Retry-After")).Should(Equal("10"))
+		// the maintenance read is cached.
+		Ω(postData().Code).Should(Equal(http.StatusServiceUnavailable))
+		metaStore.AssertNumberOfCalls(ginkgo.GinkgoT(), "GetMaintenance", 1)
+
+		// the last maintenance is kept when metastore fails.
+		utils.SetCurrentTime(now.Add(maintenanceCacheTTL))
+		Ω(postData().Code).Should(Equal(http.StatusServiceUnavailable))
+
+		utils.SetCurrentTime(now.Add(2 * maintenanceCacheTTL))
+		Ω(postData().Code).Should(Equal(http.StatusOK))
+	})
 })
@@ -14,6 +14,10 @@
 
 package api
 
+import (
+	metaCom "github.com/uber/aresdb/metastore/common"
+)
+
 // PostDataRequest represents post data request.
 // swagger:parameters postData
 type PostDataRequest struct {
@@ -21,6 +25,127 @@ type PostDataRequest struct {
 	TableName string `path:"table" json:"table"`
 	// in: path
 	Shard int `path:"shard" json:"shard"`
+	// Optional key of the batch, retried batches with a recently applied key are not applied again.
+	// in: header
+	IdempotencyKey string `header:"Idempotency-Key" json:"idempotencyKey"`
+	// in: body
+	Body []byte `body:""`
+}
+
+// PostArrowDataRequest represents post arrow data request.
+// swagger:parameters postArrowData
+type PostArrowDataRequest struct {
+	// in: path
+	TableName string `path:"table" json:"table"`
+	// in: path
+	Shard int `path:"shard" json:"shard"`
+	// Optional key of the batch, retried batches with a recently applied key are not applied again.
+	// in: header
+	IdempotencyKey string `header:"Idempotency-Key" json:"idempotencyKey"`
+	// in: body
+	Body []byte `body:""`
+}
+
+// PostJSONDataRequest represents post json data request.
+// swagger:parameters postJSONData
+type PostJSONDataRequest struct {
+	// in: path
+	TableName string `path:"table" json:"table"`
+	// in: path
+	Shard int `path:"shard" json:"shard"`
+	// Optional key of the batch, retried batches with a recently applied key are not applied again.
+	// in: header
+	IdempotencyKey string `header:"Idempotency-Key" json:"idempotencyKey"`
+	// in: body
+	Body []byte `body:""`
+}
+
+// OpenIngestionSessionRequest represents open ingestion session request.
+// swagger:parameters openIngestionSession
+type OpenIngestionSessionRequest struct {
+	// in: path
+	TableName string `path:"table" json:"table"`
+	// in: path
+	Shard int `path:"shard" json:"shard"`
+}
+
+// IngestionSessionRequest represents requests on an ingestion session.
+// swagger:parameters getIngestionSession commitIngestionSession abortIngestionSession
+type IngestionSessionRequest struct {
+	// in: path
+	TableName string `path:"table" json:"table"`
+	// in: path
+	Shard int `path:"shard" json:"shard"`
+	// in: path
+	SessionID string `path:"session" json:"session"`
+}
+
+// PostIngestionChunkRequest represents post ingestion chunk request.
+// swagger:parameters postIngestionChunk
+type PostIngestionChunkRequest struct {
+	// in: path
+	TableName string `path:"table" json:"table"`
+	// in: path
+	Shard int `path:"shard" json:"shard"`
+	// in: path
+	SessionID string `path:"session" json:"session"`
+	// Sequence number of the chunk, starting from 0.
+	// in: path
+	Chunk int `path:"chunk" json:"chunk"`
 	// in: body
 	Body []byte `body:""`
 }
+
+// IngestionSessionResponse represents the state of an ingestion session.
+// swagger:response ingestionSessionResponse
+type IngestionSessionResponse struct {
+	//in: body
+	Body struct {
+		SessionID string `json:"sessionID"`
+		// Sequence number of the last acked chunk, -1 if no chunk is acked.
+		LastChunk int  `json:"lastChunk"`
+		Committed bool `json:"committed"`
+	}
+}
+
+// DeleteDataRequest represents delete data request.
+// swagger:parameters deleteData
+type DeleteDataRequest struct {
+	// in: path
+	TableName string `path:"table" json:"table"`
+	// in: body
+	Body struct {
+		// Rows matching the filter are deleted.
+		Filter string `json:"filter"`
+	} `body:""`
+}
+
+// DeletePredicateResponse represents the delete predicate created by delete data request.
+// swagger:response deletePredicateResponse
+type DeletePredicateResponse struct {
+	//in: body
+	Body metaCom.DeletePredicate
+}
+
+// ListDeletePredicatesRequest represents list delete predicates request.
+// swagger:parameters listDeletePredicates
+type ListDeletePredicatesRequest struct {
+	// in: path
+	TableName string `path:"table" json:"table"`
+}
+
+// ListDeletePredicatesResponse represents list delete predicates response.
+// swagger:response listDeletePredicatesResponse
+type ListDeletePredicatesResponse struct {
+	//in: body
+	Body []metaCom.DeletePredicate
+}
+
+// RemoveDeletePredicateRequest represents remove delete predicate request.
+// swagger:parameters removeDeletePredicate
+type RemoveDeletePredicateRequest struct {
+	// in: path
+	TableName string `path:"table" json:"table"`
+	// in: path
+	ID int `path:"id" json:"id"`
+}
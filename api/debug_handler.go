@@ -21,11 +21,15 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/uber/aresdb/cluster"
+	"github.com/uber/aresdb/common"
 	"github.com/uber/aresdb/memstore"
 	memCom "github.com/uber/aresdb/memstore/common"
 	"github.com/uber/aresdb/metastore"
+	metaCom "github.com/uber/aresdb/metastore/common"
 	"github.com/uber/aresdb/utils"
 	"io"
 )
@@ -37,15 +41,27 @@ type DebugHandler struct {
 	metaStore          metastore.MetaStore
 	queryHandler       *QueryHandler
 	healthCheckHandler *HealthCheckHandler
+	// nil if the server does not fetch schemas from controller.
+	schemaFetchJob *metastore.SchemaFetchJob
+	// nil if the server is not in a cluster.
+	membershipManager cluster.MembershipManager
+	// transfers table shards between instances on rebalance.
+	shardTransport cluster.ShardTransport
+	// where tables can be exported to.
+	exportConfig common.ExportConfig
 }
 
 // NewDebugHandler returns a new DebugHandler.
-func NewDebugHandler(memStore memstore.MemStore, metaStore metastore.MetaStore, queryHandler *QueryHandler, healthCheckHandler *HealthCheckHandler) *DebugHandler {
+func NewDebugHandler(memStore memstore.MemStore, metaStore metastore.MetaStore, queryHandler *QueryHandler, healthCheckHandler *HealthCheckHandler, schemaFetchJob *metastore.SchemaFetchJob, membershipManager cluster.MembershipManager, exportConfig common.ExportConfig) *DebugHandler {
 	return &DebugHandler{
 		memStore:           memStore,
 		metaStore:          metaStore,
 		queryHandler:       queryHandler,
 		healthCheckHandler: healthCheckHandler,
+		schemaFetchJob:     schemaFetchJob,
+		membershipManager:  membershipManager,
+		shardTransport:     cluster.NewHTTPShardTransport(0),
+		exportConfig:       exportConfig,
 	}
 }
 
@@ -53,10 +69,24 @@ func NewDebugHandler(memStore memstore.MemStore, metaStore metastore.MetaStore,
 func (handler *DebugHandler) Register(router *mux.Router) {
 	router.HandleFunc("/health", handler.Health).Methods(http.MethodGet)
 	router.HandleFunc("/health/{onOrOff}", handler.HealthSwitch).Methods(http.MethodPost)
+	router.HandleFunc("/schema-fetch", handler.ShowSchemaFetch).Methods(http.MethodGet)
+	router.HandleFunc("/schema-fetch/{pauseOrResume}", handler.SchemaFetchSwitch).Methods(http.MethodPost)
+	router.HandleFunc("/schema-lag", handler.ShowSchemaLag).Methods(http.MethodGet)
+	router.HandleFunc("/rebalance", handler.Rebalance).Methods(http.MethodPost)
 	router.HandleFunc("/jobs/{jobType}", handler.ShowJobStatus).Methods(http.MethodGet)
 	router.HandleFunc("/devices", handler.ShowDeviceStatus).Methods(http.MethodGet)
 	router.HandleFunc("/host-memory", handler.ShowHostMemory).Methods(http.MethodGet)
+	router.HandleFunc("/tables", handler.ShowTableUsage).Methods(http.MethodGet)
+	router.HandleFunc("/tenant-limits", handler.ShowTenantLimits).Methods(http.MethodGet)
+	router.HandleFunc("/tenant-limits", handler.SetTenantLimits).Methods(http.MethodPut)
+	router.HandleFunc("/slow-query-log", handler.ShowSlowQueryLog).Methods(http.MethodGet)
+	router.HandleFunc("/slow-query-log", handler.SetSlowQueryLog).Methods(http.MethodPut)
+	router.HandleFunc("/tables/{table}/archive", handler.ArchiveTable).Methods(http.MethodPost)
+	router.HandleFunc("/tables/{table}/export", handler.ExportTable).Methods(http.MethodPost)
 	router.HandleFunc("/{table}/{shard}", handler.ShowShardMeta).Methods(http.MethodGet)
+	router.HandleFunc("/{table}/{shard}", handler.DropShard).Methods(http.MethodDelete)
+	router.HandleFunc("/{table}/{shard}/archived-data", handler.ReadArchivedData).Methods(http.MethodGet)
+	router.HandleFunc("/{table}/{shard}/archived-data", handler.LoadArchivedData).Methods(http.MethodPut)
 	router.HandleFunc("/{table}/{shard}/archive", handler.Archive).Methods(http.MethodPost)
 	router.HandleFunc("/{table}/{shard}/backfill", handler.Backfill).Methods(http.MethodPost)
 	router.HandleFunc("/{table}/{shard}/snapshot", handler.Snapshot).Methods(http.MethodPost)
@@ -106,6 +136,123 @@ func (handler *DebugHandler) HealthSwitch(w http.ResponseWriter, r *http.Request
 	io.WriteString(w, "OK")
 }
 
+// ShowSchemaFetch shows whether the schema fetch job is paused and when it last succeeded.
+func (handler *DebugHandler) ShowSchemaFetch(w http.ResponseWriter, r *http.Request) {
+	if handler.schemaFetchJob == nil {
+		RespondWithBadRequest(w, errors.New("schema fetch job is not running"))
+		return
+	}
+	RespondWithJSONObject(w, ShowSchemaFetchResponse{
+		Paused:      handler.schemaFetchJob.IsPaused(),
+		LastSuccess: handler.schemaFetchJob.LastSuccess(),
+	})
+}
+
+// ShowSchemaLag shows the schema versions applied by each instance of the cluster against the versions
+// in the metastore, lagging instances are flagged.
+func (handler *DebugHandler) ShowSchemaLag(w http.ResponseWriter, r *http.Request) {
+	if handler.membershipManager == nil {
+		RespondWithBadRequest(w, errors.New("cluster is not enabled"))
+		return
+	}
+	lags, err := handler.membershipManager.SchemaLag()
+	if err != nil {
+		RespondWithError(w, err)
+		return
+	}
+	response := ShowSchemaLagResponse{Instances: lags}
+	for _, lag := range lags {
+		if lag.Lagging {
+			response.LaggingInstances = append(response.LaggingInstances, lag.Instance)
+		}
+	}
+	RespondWithJSONObject(w, response)
+}
+
+// Rebalance computes the moves balancing the shards owned by the instances of the cluster and
+// executes them unless in dry run. Each move copies the archived data of the shard of every fact
+// table to the target instance, reassigns the shard and then drops it from the source instance.
+func (handler *DebugHandler) Rebalance(w http.ResponseWriter, r *http.Request) {
+	var request RebalanceRequest
+	err := ReadRequest(r, &request)
+	if err != nil {
+		RespondWithBadRequest(w, err)
+		return
+	}
+	if handler.membershipManager == nil {
+		RespondWithBadRequest(w, errors.New("cluster is not enabled"))
+		return
+	}
+
+	instances, err := handler.membershipManager.ListInstances(utils.GetConfig().Cluster.ClusterName)
+	if err != nil {
+		RespondWithError(w, err)
+		return
+	}
+	response := RebalanceResponse{
+		DryRun:   request.Body.DryRun,
+		Moves:    cluster.ComputeRebalancePlan(instances),
+		Executed: []cluster.ShardMove{},
+	}
+	if response.Moves == nil {
+		response.Moves = []cluster.ShardMove{}
+	}
+	if request.Body.DryRun || len(response.Moves) == 0 {
+		RespondWithJSONObject(w, response)
+		return
+	}
+
+	tableNames, err := handler.metaStore.ListTables()
+	if err != nil {
+		RespondWithError(w, err)
+		return
+	}
+	tables := make([]metaCom.Table, 0, len(tableNames))
+	for _, name := range tableNames {
+		table, err := handler.metaStore.GetTable(name)
+		if err != nil {
+			RespondWithError(w, err)
+			return
+		}
+		tables = append(tables, *table)
+	}
+
+	executed, err := cluster.ExecuteRebalancePlan(response.Moves, instances, tables, handler.shardTransport,
+		handler.membershipManager)
+	if err != nil {
+		RespondWithError(w, utils.StackError(err, "Rebalance stopped after %d of %d moves", len(executed),
+			len(response.Moves)))
+		return
+	}
+	response.Executed = executed
+	RespondWithJSONObject(w, response)
+}
+
+// SchemaFetchSwitch pauses or resumes periodic fetching of schemas from controller, the connection to
+// controller is kept while paused.
+func (handler *DebugHandler) SchemaFetchSwitch(w http.ResponseWriter, r *http.Request) {
+	var request SchemaFetchSwitchRequest
+	if err := ReadRequest(r, &request); err != nil {
+		RespondWithBadRequest(w, err)
+		return
+	}
+	if handler.schemaFetchJob == nil {
+		RespondWithBadRequest(w, errors.New("schema fetch job is not running"))
+		return
+	}
+
+	switch request.PauseOrResume {
+	case "pause":
+		handler.schemaFetchJob.Pause()
+	case "resume":
+		handler.schemaFetchJob.Resume()
+	default:
+		RespondWithBadRequest(w, errors.New("must specify pause or resume in the url"))
+		return
+	}
+	handler.ShowSchemaFetch(w, r)
+}
+
 // ShowBatch will only show batches that is present in memory, it will not request batch
 // from DiskStore.
 func (handler *DebugHandler) ShowBatch(w http.ResponseWriter, r *http.Request) {
@@ -205,7 +352,7 @@ func (handler *DebugHandler) ShowBatch(w http.ResponseWriter, r *http.Request) {
 	// translate enums
 	schema.RLock()
 	for columnID, column := range schema.Schema.Columns {
-		if !column.Deleted && column.IsEnumColumn() && columnID < len(response.Body.Vectors) {
+		if !column.Deleted && column.IsEnumColumn() && !column.IsEnumArrayColumn() && columnID < len(response.Body.Vectors) {
 			vector := &response.Body.Vectors[columnID]
 			err = translateEnums(vector, schema.EnumDicts[column.Name].ReverseDict)
 		}
@@ -315,6 +462,88 @@ func (handler *DebugHandler) Archive(w http.ResponseWriter, r *http.Request) {
 	RespondJSONObjectWithCode(w, http.StatusOK, "Archiving job submitted")
 }
 
+// ArchiveTable archives all shards of a table on demand and waits for archiving to finish.
+func (handler *DebugHandler) ArchiveTable(w http.ResponseWriter, r *http.Request) {
+	var request ArchiveTableRequest
+	err := ReadRequest(r, &request)
+	if err != nil {
+		RespondWithBadRequest(w, err)
+		return
+	}
+
+	schema, err := handler.memStore.GetSchema(request.TableName)
+	if err != nil {
+		RespondWithBadRequest(w, err)
+		return
+	}
+	schema.RLock()
+	isFactTable := schema.Schema.IsFactTable
+	schema.RUnlock()
+	if !isFactTable {
+		RespondWithBadRequest(w, utils.APIError{
+			Message: fmt.Sprintf("table %s is not a fact table", request.TableName),
+		})
+		return
+	}
+
+	if request.Cutoff == 0 {
+		request.Cutoff = uint32(utils.Now().Unix())
+	}
+
+	result, err := handler.memStore.ArchiveTable(request.TableName, request.Cutoff)
+	if err == memstore.ErrArchivingInProgress {
+		RespondWithError(w, utils.APIError{
+			Code:    http.StatusConflict,
+			Message: err.Error(),
+		})
+		return
+	} else if err == memstore.ErrArchivingCutoffInFuture {
+		RespondWithBadRequest(w, err)
+		return
+	} else if err != nil {
+		RespondWithError(w, err)
+		return
+	}
+	RespondWithJSONObject(w, result)
+}
+
+// ExportTable exports archived rows of a table within the time range to Parquet files and waits
+// for the export to finish.
+func (handler *DebugHandler) ExportTable(w http.ResponseWriter, r *http.Request) {
+	var request ExportTableRequest
+	err := ReadRequest(r, &request)
+	if err != nil {
+		RespondWithBadRequest(w, err)
+		return
+	}
+
+	if request.Body.From >= request.Body.To || request.Body.OutputPath == "" {
+		RespondWithBadRequest(w, utils.APIError{
+			Message: "from must be less than to and outputPath must be specified",
+		})
+		return
+	}
+
+	if _, err = handler.memStore.GetSchema(request.TableName); err != nil {
+		RespondWithBadRequest(w, err)
+		return
+	}
+
+	result, err := handler.memStore.ExportTable(request.TableName, request.Body.From, request.Body.To,
+		request.Body.OutputPath, handler.exportConfig)
+	if err == memstore.ErrExportOutputNotAllowed {
+		RespondWithBadRequest(w, utils.APIError{
+			Message: fmt.Sprintf("%s: %s", err, request.Body.OutputPath),
+		})
+		return
+	}
+	if err != nil {
+		RespondWithError(w, err)
+		return
+	}
+	RespondWithJSONObject(w, result)
+}
+
 // Backfill starts an backfill process on demand.
 func (handler *DebugHandler) Backfill(w http.ResponseWriter, r *http.Request) {
 	var request BackfillRequest
@@ -428,6 +657,67 @@ func (handler *DebugHandler) ShowShardMeta(w http.ResponseWriter, r *http.Reques
 	return
 }
 
+// DropShard unloads a table shard and deletes its data from disk.
+func (handler *DebugHandler) DropShard(w http.ResponseWriter, r *http.Request) {
+	var request DropShardRequest
+	err := ReadRequest(r, &request)
+	if err != nil {
+		RespondWithBadRequest(w, err)
+		return
+	}
+	if err = handler.memStore.DropShard(request.TableName, request.ShardID); err != nil {
+		RespondWithError(w, err)
+		return
+	}
+	RespondJSONObjectWithCode(w, http.StatusOK, "Shard dropped")
+}
+
+// ReadArchivedData streams the archived data of a fact table shard as a tar file, to be loaded by
+// LoadArchivedData on another instance. A failure while streaming appends the error to the
+// incomplete tar file, which then fails to be loaded.
+func (handler *DebugHandler) ReadArchivedData(w http.ResponseWriter, r *http.Request) {
+	var request ArchivedDataRequest
+	err := ReadRequest(r, &request)
+	if err != nil {
+		RespondWithBadRequest(w, err)
+		return
+	}
+	shard, err := handler.memStore.GetTableShard(request.TableName, request.ShardID)
+	if err != nil {
+		RespondWithBadRequest(w, err)
+		return
+	}
+	shard.Users.Done()
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	if err = handler.memStore.WriteArchivedShard(request.TableName, request.ShardID, w); err != nil {
+		RespondWithError(w, err)
+	}
+}
+
+// LoadArchivedData loads the archived data streamed by ReadArchivedData into a fact table shard
+// without archived data.
+func (handler *DebugHandler) LoadArchivedData(w http.ResponseWriter, r *http.Request) {
+	var request ArchivedDataRequest
+	err := ReadRequest(r, &request)
+	if err != nil {
+		RespondWithBadRequest(w, err)
+		return
+	}
+	err = handler.memStore.LoadArchivedShard(request.TableName, request.ShardID, r.Body)
+	if err == memstore.ErrArchivedShardExists {
+		RespondWithError(w, utils.APIError{
+			Code:    http.StatusConflict,
+			Message: err.Error(),
+		})
+		return
+	} else if err != nil {
+		RespondWithError(w, err)
+		return
+	}
+	RespondJSONObjectWithCode(w, http.StatusOK, "Archived data loaded")
+}
+
 // ListRedoLogs lists all the redo log files for a given shard.
 func (handler *DebugHandler) ListRedoLogs(w http.ResponseWriter, r *http.Request) {
 	var request ListRedoLogsRequest
@@ -642,6 +932,65 @@ func (handler *DebugHandler) ShowHostMemory(w http.ResponseWriter, r *http.Reque
 	RespondWithJSONObject(w, memoryUsageByTableShard)
 }
 
+// ShowTableUsage shows the memory and disk usage of all tables, or of the table given by the
+// table query param.
+func (handler *DebugHandler) ShowTableUsage(w http.ResponseWriter, r *http.Request) {
+	var request ShowTableUsageRequest
+	if err := ReadRequest(r, &request); err != nil {
+		RespondWithBadRequest(w, err)
+		return
+	}
+
+	tableUsages, err := handler.memStore.GetTableUsageDetails(request.TableName)
+	if err != nil {
+		RespondWithBadRequest(w, err)
+		return
+	}
+	RespondWithJSONObject(w, tableUsages)
+}
+
+// ShowTenantLimits shows the per tenant query limits and the running queries of each tenant.
+func (handler *DebugHandler) ShowTenantLimits(w http.ResponseWriter, r *http.Request) {
+	limiter := handler.queryHandler.tenantLimiter
+	RespondWithJSONObject(w, ShowTenantLimitsResponse{
+		Limits:  limiter.Config(),
+		Running: limiter.Running(),
+	})
+}
+
+// SetTenantLimits replaces the per tenant query limits without restarting.
+func (handler *DebugHandler) SetTenantLimits(w http.ResponseWriter, r *http.Request) {
+	var request SetTenantLimitsRequest
+	if err := ReadRequest(r, &request); err != nil {
+		RespondWithBadRequest(w, err)
+		return
+	}
+	if err := validateTenantLimitsConfig(request.Body); err != nil {
+		RespondWithBadRequest(w, err)
+		return
+	}
+	handler.queryHandler.tenantLimiter.SetConfig(request.Body)
+	RespondWithJSONObject(w, request.Body)
+}
+
+// ShowSlowQueryLog shows the threshold of the slow query log.
+func (handler *DebugHandler) ShowSlowQueryLog(w http.ResponseWriter, r *http.Request) {
+	RespondWithJSONObject(w, SlowQueryLogConfig{
+		ThresholdInMilliseconds: int(handler.queryHandler.slowQueryLog.Threshold() / time.Millisecond),
+	})
+}
+
+// SetSlowQueryLog changes the threshold of the slow query log without restarting.
+func (handler *DebugHandler) SetSlowQueryLog(w http.ResponseWriter, r *http.Request) {
+	var request SetSlowQueryLogRequest
+	if err := ReadRequest(r, &request); err != nil {
+		RespondWithBadRequest(w, err)
+		return
+	}
+	handler.queryHandler.slowQueryLog.SetThreshold(time.Duration(request.Body.ThresholdInMilliseconds) * time.Millisecond)
+	RespondWithJSONObject(w, request.Body)
+}
+
 // ReadBackfillQueueUpsertBatch reads upsert batch inside backfill manager backfill queue
 func (handler *DebugHandler) ReadBackfillQueueUpsertBatch(w http.ResponseWriter, r *http.Request) {
 	var request ReadBackfillQueueUpsertBatchRequest
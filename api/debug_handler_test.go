@@ -17,6 +17,7 @@ package api
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -26,12 +27,16 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	clientsMocks "github.com/uber/aresdb/clients/mocks"
+	"github.com/uber/aresdb/cluster"
+	clusterMocks "github.com/uber/aresdb/cluster/mocks"
 	"github.com/uber/aresdb/diskstore"
 	"github.com/uber/aresdb/memstore"
 	memCom "github.com/uber/aresdb/memstore/common"
 	memMocks "github.com/uber/aresdb/memstore/mocks"
 	"github.com/uber/aresdb/metastore"
 	metaCom "github.com/uber/aresdb/metastore/common"
+	metaMocks "github.com/uber/aresdb/metastore/mocks"
 	"github.com/uber/aresdb/utils"
 	utilsMocks "github.com/uber/aresdb/utils/mocks"
 
@@ -48,6 +53,21 @@ import (
 	"unsafe"
 )
 
+// fakeShardTransport records the shards copied and dropped.
+type fakeShardTransport struct {
+	calls []string
+}
+
+func (t *fakeShardTransport) CopyShard(table string, shard uint32, source, target cluster.Instance) error {
+	t.calls = append(t.calls, fmt.Sprintf("copy %s/%d %s %s", table, shard, source.Name, target.Name))
+	return nil
+}
+
+func (t *fakeShardTransport) DropShard(table string, shard uint32, instance cluster.Instance) error {
+	t.calls = append(t.calls, fmt.Sprintf("drop %s/%d %s", table, shard, instance.Name))
+	return nil
+}
+
 // convertToAPIError wraps up an error into APIError
 func convertToAPIError(err error) error {
 	if _, ok := err.(utils.APIError); ok {
@@ -112,6 +132,7 @@ var _ = ginkgo.Describe("DebugHandler", func() {
 	var memStore *memMocks.MemStore
 	var testServer *httptest.Server
 	var debugHandler *DebugHandler
+	var schemaFetchJob *metastore.SchemaFetchJob
 	var scheduler *memMocks.Scheduler
 
 	ginkgo.BeforeEach(func() {
@@ -127,7 +148,7 @@ var _ = ginkgo.Describe("DebugHandler", func() {
 		mockMetaStore := CreateMockMetaStore()
 		mockDiskStore := CreateMockDiskStore()
 		testRootPath := "../testing/data/integration/sample-ares-root"
-		testDiskStore := diskstore.NewLocalDiskStore(testRootPath)
+		testDiskStore := diskstore.NewLocalDiskStore(testRootPath, common.RedoLogSyncConfig{})
 		testMetaStore, err := metastore.NewDiskMetaStore(filepath.Join(testRootPath, "metastore"))
 		Ω(err).Should(BeNil())
 
@@ -187,7 +208,7 @@ var _ = ginkgo.Describe("DebugHandler", func() {
 		redoLogTableSchema := &memstore.TableSchema{
 			Schema: *redoLogTable,
 		}
-		redoLogShard := memstore.NewTableShard(redoLogTableSchema, mockMetaStore, testDiskStore, CreateMockHostMemoryManger(), redoLogShardID)
+		redoLogShard := memstore.NewTableShard(redoLogTableSchema, mockMetaStore, testDiskStore, CreateMockHostMemoryManger(), redoLogShardID, memstore.Options{})
 
 		mockShardNotExistErr := convertToAPIError(errors.New("Failed to get shard"))
 		memStore.On("GetTableShard", redoLogTableName, redoLogShardID).Return(redoLogShard, nil).
@@ -230,7 +251,9 @@ var _ = ginkgo.Describe("DebugHandler", func() {
 		})
 
 		healthCheckHandler := NewHealthCheckHandler()
-		debugHandler = NewDebugHandler(memStore, mockMetaStore, queryHandler, healthCheckHandler)
+		schemaFetchJob = metastore.NewSchemaFetchJob(1, mockMetaStore, metastore.NewTableSchameValidator(), &clientsMocks.ControllerClient{}, "cluster1", "")
+		debugHandler = NewDebugHandler(memStore, mockMetaStore, queryHandler, healthCheckHandler, schemaFetchJob, nil,
+			common.ExportConfig{BaseDir: "/tmp/export"})
 		testRouter := mux.NewRouter()
 		debugHandler.Register(testRouter.PathPrefix("/debug").Subrouter())
 		testServer = httptest.NewUnstartedServer(testRouter)
@@ -281,6 +304,156 @@ var _ = ginkgo.Describe("DebugHandler", func() {
 		Ω(resp.StatusCode).Should(Equal(400))
 		Ω(debugHandler.healthCheckHandler.disable).Should(BeFalse())
 	})
+
+	ginkgo.It("Rebalance", func() {
+		hostPort := testServer.Listener.Addr().String()
+		rebalanceURL := fmt.Sprintf("http://%s/debug/rebalance", hostPort)
+		resp, err := http.Post(rebalanceURL, "application/json", bytes.NewBufferString(`{"dryRun":true}`))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(400))
+
+		instances := []cluster.Instance{
+			{Name: "instance0", Shards: []uint32{0, 1, 2}},
+			{Name: "instance1"},
+		}
+		membershipManager := &clusterMocks.MembershipManager{}
+		membershipManager.On("ListInstances", mock.Anything).Return(instances, nil)
+		debugHandler.membershipManager = membershipManager
+		move := cluster.ShardMove{Shard: 0, Source: "instance0", Target: "instance1"}
+
+		resp, err = http.Post(rebalanceURL, "application/json", bytes.NewBufferString(`{"dryRun":true}`))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(200))
+		var response RebalanceResponse
+		Ω(json.NewDecoder(resp.Body).Decode(&response)).Should(BeNil())
+		Ω(response).Should(Equal(RebalanceResponse{DryRun: true, Moves: []cluster.ShardMove{move}, Executed: []cluster.ShardMove{}}))
+		membershipManager.AssertNotCalled(ginkgo.GinkgoT(), "MoveShard", mock.Anything)
+
+		metaStore := &metaMocks.MetaStore{}
+		metaStore.On("ListTables").Return([]string{"trips", "cities"}, nil)
+		metaStore.On("GetTable", "trips").Return(&metaCom.Table{Name: "trips", IsFactTable: true, NumShards: 3}, nil)
+		metaStore.On("GetTable", "cities").Return(&metaCom.Table{Name: "cities"}, nil)
+		debugHandler.metaStore = metaStore
+		transport := &fakeShardTransport{}
+		debugHandler.shardTransport = transport
+		membershipManager.On("MoveShard", move).Return(nil).Once()
+		resp, err = http.Post(rebalanceURL, "application/json", bytes.NewBufferString(`{}`))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(200))
+		response = RebalanceResponse{}
+		Ω(json.NewDecoder(resp.Body).Decode(&response)).Should(BeNil())
+		Ω(response.Executed).Should(Equal([]cluster.ShardMove{move}))
+		Ω(transport.calls).Should(Equal([]string{"copy trips/0 instance0 instance1", "drop trips/0 instance0"}))
+
+		membershipManager.On("MoveShard", move).Return(errors.New("bad version")).Once()
+		resp, err = http.Post(rebalanceURL, "application/json", bytes.NewBufferString(`{}`))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(500))
+		body, _ := ioutil.ReadAll(resp.Body)
+		Ω(string(body)).Should(ContainSubstring("Rebalance stopped after 0 of 1 moves"))
+	})
+
+	ginkgo.It("transfers the archived data of a shard", func() {
+		hostPort := testServer.Listener.Addr().String()
+		archivedDataURL := fmt.Sprintf("http://%s/debug/%s/%d/archived-data", hostPort, testTableName, testTableShardID)
+		memStore.On("WriteArchivedShard", testTableName, testTableShardID, mock.Anything).Return(nil).
+			Run(func(args mock.Arguments) {
+				args.Get(2).(io.Writer).Write([]byte("archived data"))
+			})
+		resp, err := http.Get(archivedDataURL)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(200))
+		Ω(resp.Header.Get("Content-Type")).Should(Equal("application/x-tar"))
+		body, _ := ioutil.ReadAll(resp.Body)
+		Ω(string(body)).Should(Equal("archived data"))
+
+		var loaded string
+		memStore.On("LoadArchivedShard", testTableName, testTableShardID, mock.Anything).Return(nil).
+			Run(func(args mock.Arguments) {
+				data, _ := ioutil.ReadAll(args.Get(2).(io.Reader))
+				loaded = string(data)
+			}).Once()
+		request, _ := http.NewRequest(http.MethodPut, archivedDataURL, bytes.NewBufferString("archived data"))
+		resp, err = http.DefaultClient.Do(request)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(200))
+		Ω(loaded).Should(Equal("archived data"))
+
+		memStore.On("LoadArchivedShard", testTableName, testTableShardID, mock.Anything).
+			Return(memstore.ErrArchivedShardExists).Once()
+		request, _ = http.NewRequest(http.MethodPut, archivedDataURL, bytes.NewBufferString("archived data"))
+		resp, err = http.DefaultClient.Do(request)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(409))
+
+		memStore.On("DropShard", testTableName, testTableShardID).Return(nil).Once()
+		request, _ = http.NewRequest(http.MethodDelete, fmt.Sprintf("http://%s/debug/%s/%d", hostPort, testTableName, testTableShardID), nil)
+		resp, err = http.DefaultClient.Do(request)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(200))
+		memStore.AssertCalled(ginkgo.GinkgoT(), "DropShard", testTableName, testTableShardID)
+	})
+
+	ginkgo.It("ShowSchemaLag", func() {
+		hostPort := testServer.Listener.Addr().String()
+		resp, err := http.Get(fmt.Sprintf("http://%s/debug/schema-lag", hostPort))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(400))
+
+		version := 2
+		membershipManager := &clusterMocks.MembershipManager{}
+		membershipManager.On("SchemaLag").Return([]cluster.InstanceSchemaLag{
+			{Instance: "instance0", Tables: []cluster.TableSchemaLag{{Table: "trips", AppliedVersion: &version, LatestVersion: &version}}},
+			{Instance: "instance1", Lagging: true, Tables: []cluster.TableSchemaLag{{Table: "trips", LatestVersion: &version, Lagging: true}}},
+		}, nil).Once()
+		debugHandler.membershipManager = membershipManager
+		resp, err = http.Get(fmt.Sprintf("http://%s/debug/schema-lag", hostPort))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(200))
+		var lag ShowSchemaLagResponse
+		Ω(json.NewDecoder(resp.Body).Decode(&lag)).Should(BeNil())
+		Ω(lag.LaggingInstances).Should(Equal([]string{"instance1"}))
+		Ω(lag.Instances).Should(HaveLen(2))
+		Ω(*lag.Instances[0].Tables[0].AppliedVersion).Should(Equal(2))
+		Ω(lag.Instances[1].Tables[0].AppliedVersion).Should(BeNil())
+
+		membershipManager.On("SchemaLag").Return(nil, errors.New("not connected")).Once()
+		resp, err = http.Get(fmt.Sprintf("http://%s/debug/schema-lag", hostPort))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(500))
+	})
+
+	ginkgo.It("SchemaFetchSwitch", func() {
+		hostPort := testServer.Listener.Addr().String()
+
+		resp, err := http.Post(fmt.Sprintf("http://%s/debug/schema-fetch/pause", hostPort), "", nil)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(200))
+		Ω(schemaFetchJob.IsPaused()).Should(BeTrue())
+
+		resp, err = http.Get(fmt.Sprintf("http://%s/debug/schema-fetch", hostPort))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(200))
+		var status ShowSchemaFetchResponse
+		Ω(json.NewDecoder(resp.Body).Decode(&status)).Should(BeNil())
+		Ω(status.Paused).Should(BeTrue())
+
+		resp, err = http.Post(fmt.Sprintf("http://%s/debug/schema-fetch/resume", hostPort), "", nil)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(200))
+		Ω(schemaFetchJob.IsPaused()).Should(BeFalse())
+
+		resp, err = http.Post(fmt.Sprintf("http://%s/debug/schema-fetch/stop", hostPort), "", nil)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(400))
+		Ω(schemaFetchJob.IsPaused()).Should(BeFalse())
+
+		debugHandler.schemaFetchJob = nil
+		resp, err = http.Get(fmt.Sprintf("http://%s/debug/schema-fetch", hostPort))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(400))
+	})
+
 	ginkgo.It("ShowBatch", func() {
 		hostPort := testServer.Listener.Addr().String()
 		resp, err := http.Get(fmt.Sprintf("http://%s/debug/%s/%d/batches/%d?startRow=0&numRows=10", hostPort, testTableName, testTableShardID, batchID))
@@ -379,6 +552,77 @@ var _ = ginkgo.Describe("DebugHandler", func() {
 		Ω(string(bs)).Should(ContainSubstring("Failed to get shard"))
 	})
 
+	ginkgo.It("ArchiveTable should work", func() {
+		hostPort := testServer.Listener.Addr().String()
+		memStore.On("ArchiveTable", testTableName, uint32(100)).Return(memstore.ArchiveTableResult{
+			NumArchivedBatches: 2,
+			ArchivingCutoff:    100,
+		}, nil).Once()
+		resp, err := http.Post(fmt.Sprintf("http://%s/debug/tables/%s/archive", hostPort, testTableName), "", nil)
+		Ω(err).Should(BeNil())
+		bs, err := ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+		Ω(bs).Should(MatchJSON(`{"numArchivedBatches": 2, "archivingCutoff": 100}`))
+
+		// concurrent archiving of the same table.
+		memStore.On("ArchiveTable", testTableName, uint32(200)).Return(memstore.ArchiveTableResult{},
+			memstore.ErrArchivingInProgress).Once()
+		resp, err = http.Post(fmt.Sprintf("http://%s/debug/tables/%s/archive?cutoff=200", hostPort, testTableName), "", nil)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusConflict))
+
+		// cutoff later than now.
+		memStore.On("ArchiveTable", testTableName, uint32(300)).Return(memstore.ArchiveTableResult{},
+			memstore.ErrArchivingCutoffInFuture).Once()
+		resp, err = http.Post(fmt.Sprintf("http://%s/debug/tables/%s/archive?cutoff=300", hostPort, testTableName), "", nil)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+
+		// table does not exist.
+		resp, err = http.Post(fmt.Sprintf("http://%s/debug/tables/unknown/archive", hostPort), "", nil)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+	})
+
+	ginkgo.It("ExportTable should work", func() {
+		hostPort := testServer.Listener.Addr().String()
+		memStore.On("ExportTable", testTableName, uint32(100), uint32(200), "export",
+			common.ExportConfig{BaseDir: "/tmp/export"}).Return(
+			memstore.ExportTableResult{
+				Files:   []string{"trips/date=1970-01-01/0.parquet"},
+				NumRows: 10,
+			}, nil).Once()
+		resp, err := http.Post(fmt.Sprintf("http://%s/debug/tables/%s/export", hostPort, testTableName), "",
+			bytes.NewBufferString(`{"from": 100, "to": 200, "outputPath": "export"}`))
+		Ω(err).Should(BeNil())
+		bs, err := ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+		Ω(bs).Should(MatchJSON(`{"files": ["trips/date=1970-01-01/0.parquet"], "numRows": 10}`))
+
+		// output path not allowed.
+		memStore.On("ExportTable", testTableName, uint32(100), uint32(200), "../export",
+			common.ExportConfig{BaseDir: "/tmp/export"}).Return(
+			memstore.ExportTableResult{}, memstore.ErrExportOutputNotAllowed).Once()
+		resp, err = http.Post(fmt.Sprintf("http://%s/debug/tables/%s/export", hostPort, testTableName), "",
+			bytes.NewBufferString(`{"from": 100, "to": 200, "outputPath": "../export"}`))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+
+		// invalid time range.
+		resp, err = http.Post(fmt.Sprintf("http://%s/debug/tables/%s/export", hostPort, testTableName), "",
+			bytes.NewBufferString(`{"from": 200, "to": 200, "outputPath": "export"}`))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+
+		// table does not exist.
+		resp, err = http.Post(fmt.Sprintf("http://%s/debug/tables/unknown/export", hostPort), "",
+			bytes.NewBufferString(`{"from": 100, "to": 200, "outputPath": "export"}`))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+	})
+
 	ginkgo.It("ListRedoLogs should work", func() {
 		hostPort := testServer.Listener.Addr().String()
 		resp, err := http.Get(
@@ -628,12 +872,16 @@ var _ = ginkgo.Describe("DebugHandler", func() {
     "redoLogManager": {
       "rotationInterval": 0,
       "maxRedoLogSize": 0,
+      "retentionInterval": 0,
       "currentRedoLogSize": 0,
       "totalRedologSize": 0,
       "maxEventTimePerFile": {},
       "batchCountPerFile": {},
       "sizePerFile": {},
-      "currentFileCreationTime": 0
+      "currentFileCreationTime": 0,
+      "syncConfig": {
+        "policy": "async"
+      }
     },
     "snapshotManager": null
   },
@@ -836,6 +1084,65 @@ var _ = ginkgo.Describe("DebugHandler", func() {
 		Ω(bs).Should(MatchJSON(expectedResponse))
 	})
 
+	ginkgo.It("ShowTableUsage should work", func() {
+		lastArchivingTime := time.Unix(1500000000, 0).UTC()
+		memStore.On("GetTableUsageDetails", "").Return(map[string]memstore.TableUsage{
+			"table1": {
+				LiveBytes:         100,
+				NumArchiveBatches: 2,
+				DiskBytes:         300,
+				RedoLogBytes:      50,
+				NumLiveRows:       10,
+				NumArchiveRows:    20,
+				LastArchivingTime: &lastArchivingTime,
+			},
+			"table2": {},
+		}, nil)
+		memStore.On("GetTableUsageDetails", "table2").Return(map[string]memstore.TableUsage{
+			"table2": {},
+		}, nil)
+		memStore.On("GetTableUsageDetails", "unknown").Return(nil, errors.New("Failed to get table schema for table unknown"))
+
+		hostPort := testServer.Listener.Addr().String()
+		resp, err := http.Get(fmt.Sprintf("http://%s/debug/tables", hostPort))
+		Ω(err).Should(BeNil())
+		bs, err := ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+		Ω(bs).Should(MatchJSON(`{
+			"table1": {
+				"liveBytes": 100,
+				"numArchiveBatches": 2,
+				"diskBytes": 300,
+				"redoLogBytes": 50,
+				"numLiveRows": 10,
+				"numArchiveRows": 20,
+				"lastArchivingTime": "2017-07-14T02:40:00Z"
+			},
+			"table2": {
+				"liveBytes": 0,
+				"numArchiveBatches": 0,
+				"diskBytes": 0,
+				"redoLogBytes": 0,
+				"numLiveRows": 0,
+				"numArchiveRows": 0
+			}
+		}`))
+
+		resp, err = http.Get(fmt.Sprintf("http://%s/debug/tables?table=table2", hostPort))
+		Ω(err).Should(BeNil())
+		bs, err = ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+		var respBody map[string]memstore.TableUsage
+		Ω(json.Unmarshal(bs, &respBody)).Should(BeNil())
+		Ω(respBody).Should(Equal(map[string]memstore.TableUsage{"table2": {}}))
+
+		resp, err = http.Get(fmt.Sprintf("http://%s/debug/tables?table=unknown", hostPort))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+	})
+
 	ginkgo.It("ReadBackfillQueueUpsertBatch should work", func() {
 		builder := memCom.NewUpsertBatchBuilder()
 		builder.AddRow()
@@ -914,6 +1221,47 @@ var _ = ginkgo.Describe("DebugHandler", func() {
 		Ω(bs).Should(MatchJSON(expectedStatus))
 	})
 
+	ginkgo.It("ShowTenantLimits and SetTenantLimits should work", func() {
+		hostPort := testServer.Listener.Addr().String()
+		limits := `{
+			"header": "X-Tenant",
+			"default": {"maxConcurrentQueries": 2, "qps": 0, "burst": 0},
+			"tenants": {"dashboard": {"maxConcurrentQueries": 1, "qps": 5, "burst": 10}}
+		}`
+		req, _ := http.NewRequest(http.MethodPut, fmt.Sprintf("http://%s/debug/tenant-limits", hostPort), bytes.NewBufferString(limits))
+		resp, err := http.DefaultClient.Do(req)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+
+		resp, err = http.Get(fmt.Sprintf("http://%s/debug/tenant-limits", hostPort))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+		bs, err := ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		Ω(bs).Should(MatchJSON(`{"limits": ` + limits + `, "running": {}}`))
+
+		req, _ = http.NewRequest(http.MethodPut, fmt.Sprintf("http://%s/debug/tenant-limits", hostPort), bytes.NewBufferString("{"))
+		resp, err = http.DefaultClient.Do(req)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+	})
+
+	ginkgo.It("ShowSlowQueryLog and SetSlowQueryLog should work", func() {
+		hostPort := testServer.Listener.Addr().String()
+		req, _ := http.NewRequest(http.MethodPut, fmt.Sprintf("http://%s/debug/slow-query-log", hostPort),
+			bytes.NewBufferString(`{"thresholdInMilliseconds": 500}`))
+		resp, err := http.DefaultClient.Do(req)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+
+		resp, err = http.Get(fmt.Sprintf("http://%s/debug/slow-query-log", hostPort))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+		bs, err := ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		Ω(bs).Should(MatchJSON(`{"thresholdInMilliseconds": 500}`))
+	})
+
 	ginkgo.It("Backfill request should work", func() {
 		hostPort := testServer.Listener.Addr().String()
 		request := &BackfillRequest{}
@@ -14,6 +14,10 @@
 
 package api
 
+import (
+	"github.com/uber/aresdb/common"
+)
+
 // ShardRequest is the common request struct for all shard related operations.
 type ShardRequest struct {
 	TableName string `path:"table" json:"table"`
@@ -48,6 +52,43 @@ type ArchiveRequest struct {
 	} `body:""`
 }
 
+// ArchiveTableRequest represents request to archive all shards of a table synchronously. Cutoff
+// defaults to now.
+type ArchiveTableRequest struct {
+	TableName string `path:"table" json:"table"`
+	Cutoff    uint32 `query:"cutoff,optional" json:"cutoff"`
+}
+
+// ExportTableRequest represents request to export archived rows of a table with event time in
+// [from, to) to Parquet files under the output path, which is relative to the configured export
+// base directory or an url under one of the configured export url prefixes.
+type ExportTableRequest struct {
+	TableName string `path:"table" json:"table"`
+	Body      struct {
+		From       uint32 `json:"from"`
+		To         uint32 `json:"to"`
+		OutputPath string `json:"outputPath"`
+	} `body:""`
+}
+
+// ArchivedDataRequest represents request to read or load the archived data of a table shard.
+type ArchivedDataRequest struct {
+	ShardRequest
+}
+
+// DropShardRequest represents request to delete a table shard from the instance.
+type DropShardRequest struct {
+	ShardRequest
+}
+
+// RebalanceRequest represents request to rebalance the shards of the cluster, only the plan is
+// computed in dry run.
+type RebalanceRequest struct {
+	Body struct {
+		DryRun bool `json:"dryRun"`
+	} `body:""`
+}
+
 // BackfillRequest represents request to start an on demand backfill.
 type BackfillRequest struct {
 	ShardRequest
@@ -125,7 +166,27 @@ type ShowJobStatusRequest struct {
 	JobType string `path:"jobType" json:"jobType"`
 }
 
+// ShowTableUsageRequest represents the request to show the memory and disk usage of tables.
+type ShowTableUsageRequest struct {
+	TableName string `query:"table,optional" json:"table"`
+}
+
+// SetTenantLimitsRequest represents the request to change the per tenant query limits.
+type SetTenantLimitsRequest struct {
+	Body common.TenantLimitsConfig `body:""`
+}
+
+// SetSlowQueryLogRequest represents the request to change the threshold of the slow query log.
+type SetSlowQueryLogRequest struct {
+	Body SlowQueryLogConfig `body:""`
+}
+
 // HealthSwitchRequest represents the request to  turn on/off the health check.
 type HealthSwitchRequest struct {
 	OnOrOff string `path:"onOrOff" json:"onOrOff"`
 }
+
+// SchemaFetchSwitchRequest represents the request to pause or resume the schema fetch job.
+type SchemaFetchSwitchRequest struct {
+	PauseOrResume string `path:"pauseOrResume" json:"pauseOrResume"`
+}
@@ -15,9 +15,43 @@
 package api
 
 import (
+	"time"
+
+	"github.com/uber/aresdb/cluster"
+	aresCommon "github.com/uber/aresdb/common"
 	"github.com/uber/aresdb/memstore/common"
 )
 
+// ShowTenantLimitsResponse represents ShowTenantLimits response.
+type ShowTenantLimitsResponse struct {
+	Limits aresCommon.TenantLimitsConfig `json:"limits"`
+	// number of running queries by tenant
+	Running map[string]int `json:"running"`
+}
+
+// ShowSchemaFetchResponse represents ShowSchemaFetch response.
+type ShowSchemaFetchResponse struct {
+	Paused bool `json:"paused"`
+	// time of the last successful fetch, zero if none succeeded yet
+	LastSuccess time.Time `json:"lastSuccess"`
+}
+
+// ShowSchemaLagResponse represents ShowSchemaLag response.
+type ShowSchemaLagResponse struct {
+	// names of the instances lagging on any table
+	LaggingInstances []string                    `json:"laggingInstances"`
+	Instances        []cluster.InstanceSchemaLag `json:"instances"`
+}
+
+// RebalanceResponse represents Rebalance response.
+type RebalanceResponse struct {
+	DryRun bool `json:"dryRun"`
+	// moves of the plan, in execution order
+	Moves []cluster.ShardMove `json:"moves"`
+	// moves executed, empty in dry run
+	Executed []cluster.ShardMove `json:"executed"`
+}
+
 // ShowBatchResponse represents ShowBatch response.
 type ShowBatchResponse struct {
 	Body struct {
@@ -81,7 +81,7 @@ func (handler *EnumHandler) ListEnumCases(w http.ResponseWriter, r *http.Request
 
 // AddEnumCase swagger:route POST /schema/tables/{table}/columns/{column}/enum-cases addEnumCase
 // add an enum case to given column of given table
-// return the id of the enum
+// return the id of the enum, -1 if the max enum cardinality of the column is reached
 //
 // Responses:
 //    default: errorResponse
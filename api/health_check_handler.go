@@ -28,6 +28,21 @@ type HealthCheckHandler struct {
 	// Useful when server is lagging behind too much so developper manually call an API in debug handler
 	// to disable the health check.
 	disable bool
+	// Conditions all required to be met for the server to be ready for traffic.
+	readinessChecks []readinessCheck
+}
+
+// readinessCheck is a named condition checked by the readiness endpoint.
+type readinessCheck struct {
+	name  string
+	check func() bool
+}
+
+// ReadinessResponse represents the readiness check response.
+type ReadinessResponse struct {
+	Ready bool `json:"ready"`
+	// Names of the unmet conditions.
+	Unmet []string `json:"unmet,omitempty"`
 }
 
 // NewHealthCheckHandler return a new http handler for health check.
@@ -47,6 +62,44 @@ func (handler *HealthCheckHandler) HealthCheck(w http.ResponseWriter, r *http.Re
 	}
 }
 
+// AddReadinessCheck registers a named condition required for readiness.
+func (handler *HealthCheckHandler) AddReadinessCheck(name string, check func() bool) {
+	handler.Lock()
+	defer handler.Unlock()
+	handler.readinessChecks = append(handler.readinessChecks, readinessCheck{name: name, check: check})
+}
+
+// Liveness is the liveness probe endpoint, it succeeds as long as the process is serving http requests.
+func (handler *HealthCheckHandler) Liveness(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, "OK")
+}
+
+// Readiness is the readiness probe endpoint, it responds 503 with the unmet conditions
+// if health check is disabled or any registered readiness check fails.
+func (handler *HealthCheckHandler) Readiness(w http.ResponseWriter, r *http.Request) {
+	handler.RLock()
+	disabled := handler.disable
+	checks := handler.readinessChecks
+	handler.RUnlock()
+
+	response := ReadinessResponse{Ready: true}
+	if disabled {
+		response.Unmet = append(response.Unmet, "health_check_enabled")
+	}
+	for _, c := range checks {
+		if !c.check() {
+			response.Unmet = append(response.Unmet, c.name)
+		}
+	}
+
+	if len(response.Unmet) > 0 {
+		response.Ready = false
+		RespondJSONObjectWithCode(w, http.StatusServiceUnavailable, response)
+		return
+	}
+	RespondJSONObjectWithCode(w, http.StatusOK, response)
+}
+
 // Version is the Version check endpoint.
 func (handler *HealthCheckHandler) Version(w http.ResponseWriter, r *http.Request) {
 	io.WriteString(w, utils.GetConfig().Version)
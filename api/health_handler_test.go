@@ -57,4 +57,46 @@ var _ = ginkgo.Describe("HealthCheck", func() {
 		Ω(err).Should(BeNil())
 		Ω(resp.StatusCode).Should(Equal(http.StatusServiceUnavailable))
 	})
+
+	ginkgo.It("Liveness and Readiness should work", func() {
+		handler := NewHealthCheckHandler()
+		schemaFetched, controllerReachable := false, false
+		handler.AddReadinessCheck("schema_fetched", func() bool { return schemaFetched })
+		handler.AddReadinessCheck("controller_reachable", func() bool { return controllerReachable })
+
+		readiness := func() (int, string) {
+			w := httptest.NewRecorder()
+			handler.Readiness(w, httptest.NewRequest(http.MethodGet, "/readiness", nil))
+			return w.Code, w.Body.String()
+		}
+
+		w := httptest.NewRecorder()
+		handler.Liveness(w, httptest.NewRequest(http.MethodGet, "/liveness", nil))
+		Ω(w.Code).Should(Equal(http.StatusOK))
+		Ω(w.Body.String()).Should(Equal("OK"))
+
+		code, body := readiness()
+		Ω(code).Should(Equal(http.StatusServiceUnavailable))
+		Ω(body).Should(MatchJSON(`{"ready": false, "unmet": ["schema_fetched", "controller_reachable"]}`))
+
+		schemaFetched = true
+		code, body = readiness()
+		Ω(code).Should(Equal(http.StatusServiceUnavailable))
+		Ω(body).Should(MatchJSON(`{"ready": false, "unmet": ["controller_reachable"]}`))
+
+		controllerReachable = true
+		code, body = readiness()
+		Ω(code).Should(Equal(http.StatusOK))
+		Ω(body).Should(MatchJSON(`{"ready": true}`))
+
+		handler.disable = true
+		code, body = readiness()
+		Ω(code).Should(Equal(http.StatusServiceUnavailable))
+		Ω(body).Should(MatchJSON(`{"ready": false, "unmet": ["health_check_enabled"]}`))
+
+		// liveness is not affected by disabling health check.
+		w = httptest.NewRecorder()
+		handler.Liveness(w, httptest.NewRequest(http.MethodGet, "/liveness", nil))
+		Ω(w.Code).Should(Equal(http.StatusOK))
+	})
 })
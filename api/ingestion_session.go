@@ -0,0 +1,445 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/uber/aresdb/common"
+	memCom "github.com/uber/aresdb/memstore/common"
+	"github.com/uber/aresdb/utils"
+)
+
+const (
+	defaultIngestionSessionMaxChunkBytes   = 1 << 26
+	defaultIngestionSessionMaxSessionBytes = 1 << 30
+	defaultIngestionSessionMaxSessions     = 100
+	defaultIngestionSessionTTLInSeconds    = 3600
+
+	// ingestionSessionSweepInterval is how often expired sessions are removed from disk.
+	ingestionSessionSweepInterval = time.Minute
+
+	// ingestionSessionMetaFile is the file in the session directory storing the session meta.
+	ingestionSessionMetaFile = "session"
+)
+
+var (
+	// ErrIngestionSessionNotFound represents api error for unknown or expired ingestion sessions.
+	ErrIngestionSessionNotFound = utils.APIError{
+		Code:    http.StatusNotFound,
+		Message: "Ingestion session does not exist or has expired",
+	}
+	// ErrIngestionSessionCommitted represents api error for uploading chunks to committed sessions.
+	ErrIngestionSessionCommitted = utils.APIError{
+		Code:    http.StatusConflict,
+		Message: "Ingestion session is already committed",
+	}
+	// ErrIngestionSessionCommitting represents api error for using a session being committed.
+	ErrIngestionSessionCommitting = utils.APIError{
+		Code:    http.StatusConflict,
+		Message: "Ingestion session is being committed",
+	}
+	// ErrIngestionSessionUploading represents api error for using a session while a chunk is being written.
+	ErrIngestionSessionUploading = utils.APIError{
+		Code:    http.StatusConflict,
+		Message: "A chunk of the ingestion session is being uploaded",
+	}
+	// ErrTooManyIngestionSessions represents api error for opening sessions beyond the limit.
+	ErrTooManyIngestionSessions = utils.APIError{
+		Code:    http.StatusTooManyRequests,
+		Message: "Too many open ingestion sessions",
+	}
+)
+
+// ingestionSession is an upload of a serialized upsert batch in chunks. Chunks are numbered from
+// 0 and acked in order, so an interrupted upload resumes after the last acked chunk. The chunks are
+// concatenated and applied as a single upsert batch on commit.
+type ingestionSession struct {
+	Table     string `json:"table"`
+	Shard     int    `json:"shard"`
+	Committed bool   `json:"committed"`
+
+	numChunks  int
+	bytes      int64
+	uploading  bool
+	committing bool
+	lastUsed   time.Time
+}
+
+// lastChunk returns the sequence number of the last acked chunk, -1 if there is none.
+func (s *ingestionSession) lastChunk() int {
+	return s.numChunks - 1
+}
+
+// busy tells whether the session is being written to.
+func (s *ingestionSession) busy() error {
+	if s.committing {
+		return ErrIngestionSessionCommitting
+	}
+	if s.uploading {
+		return ErrIngestionSessionUploading
+	}
+	return nil
+}
+
+// ingestionSessions keeps the chunks of ingestion sessions on disk under dir, one directory per
+// session, so uploads are not held in memory and can be resumed after a restart.
+type ingestionSessions struct {
+	sync.Mutex
+	dir             string
+	maxChunkBytes   int64
+	maxSessionBytes int64
+	maxSessions     int
+	ttl             time.Duration
+	sessions        map[string]*ingestionSession
+	stopChan        chan struct{}
+}
+
+// newIngestionSessions creates the session store and loads the sessions left under dir.
+func newIngestionSessions(dir string, config common.IngestionSessionsConfig) *ingestionSessions {
+	s := &ingestionSessions{
+		dir:             dir,
+		maxChunkBytes:   config.MaxChunkBytes,
+		maxSessionBytes: config.MaxSessionBytes,
+		maxSessions:     config.MaxSessions,
+		ttl:             time.Duration(config.TTLInSeconds) * time.Second,
+		sessions:        make(map[string]*ingestionSession),
+		stopChan:        make(chan struct{}),
+	}
+	if s.maxChunkBytes <= 0 {
+		s.maxChunkBytes = defaultIngestionSessionMaxChunkBytes
+	}
+	if s.maxSessionBytes <= 0 {
+		s.maxSessionBytes = defaultIngestionSessionMaxSessionBytes
+	}
+	if s.maxSessions <= 0 {
+		s.maxSessions = defaultIngestionSessionMaxSessions
+	}
+	if s.ttl <= 0 {
+		s.ttl = defaultIngestionSessionTTLInSeconds * time.Second
+	}
+	s.load()
+	return s
+}
+
+// load reads the sessions under dir, sessions that cannot be read are removed. The ttl of loaded
+// sessions restarts from now.
+func (s *ingestionSessions) load() {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			utils.GetLogger().With("dir", s.dir, "error", err).Error("Failed to read ingestion sessions")
+		}
+		return
+	}
+	now := utils.Now()
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		id := file.Name()
+		session, err := s.readSession(id)
+		if err != nil {
+			utils.GetLogger().With("session", id, "error", err).Error("Failed to load ingestion session")
+			os.RemoveAll(s.sessionDir(id))
+			continue
+		}
+		session.lastUsed = now
+		s.sessions[id] = session
+	}
+}
+
+// readSession reads the meta and the acked chunks of the session from disk.
+func (s *ingestionSessions) readSession(id string) (*ingestionSession, error) {
+	bytes, err := ioutil.ReadFile(filepath.Join(s.sessionDir(id), ingestionSessionMetaFile))
+	if err != nil {
+		return nil, err
+	}
+	var session ingestionSession
+	if err = json.Unmarshal(bytes, &session); err != nil {
+		return nil, err
+	}
+	for {
+		info, err := os.Stat(s.chunkPath(id, session.numChunks))
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		session.numChunks++
+		session.bytes += info.Size()
+	}
+	return &session, nil
+}
+
+func (s *ingestionSessions) sessionDir(id string) string {
+	return filepath.Join(s.dir, id)
+}
+
+func (s *ingestionSessions) chunkPath(id string, sequence int) string {
+	return filepath.Join(s.sessionDir(id), strconv.Itoa(sequence))
+}
+
+// writeFile writes the file through a temp file so a crash never leaves a partial file behind.
+func writeFile(path string, bytes []byte) error {
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, bytes, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// writeMeta persists the meta of the session.
+func (s *ingestionSessions) writeMeta(id string, session *ingestionSession) error {
+	bytes, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(s.sessionDir(id), ingestionSessionMetaFile), bytes)
+}
+
+// expired tells whether the session was last used before the ttl.
+func (s *ingestionSessions) expired(session *ingestionSession, now time.Time) bool {
+	return now.Sub(session.lastUsed) > s.ttl && session.busy() == nil
+}
+
+// sweep removes the expired sessions and their chunks.
+func (s *ingestionSessions) sweep() {
+	s.Lock()
+	defer s.Unlock()
+	now := utils.Now()
+	for id, session := range s.sessions {
+		if s.expired(session, now) {
+			s.remove(id)
+		}
+	}
+}
+
+// remove removes the session and its chunks, the caller must hold the lock.
+func (s *ingestionSessions) remove(id string) {
+	delete(s.sessions, id)
+	if err := os.RemoveAll(s.sessionDir(id)); err != nil {
+		utils.GetLogger().With("session", id, "error", err).Error("Failed to remove ingestion session")
+	}
+}
+
+// Run is a ticker function to remove expired sessions periodically.
+func (s *ingestionSessions) Run() {
+	ticker := time.NewTicker(ingestionSessionSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.sweep()
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// Stop stops the sweeping.
+func (s *ingestionSessions) Stop() {
+	close(s.stopChan)
+}
+
+// open opens a new session for the table shard and returns its id.
+func (s *ingestionSessions) open(table string, shard int) (string, error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", utils.StackError(err, "Failed to generate ingestion session id")
+	}
+	id := hex.EncodeToString(idBytes)
+
+	s.Lock()
+	defer s.Unlock()
+	now := utils.Now()
+	for sessionID, session := range s.sessions {
+		if s.expired(session, now) {
+			s.remove(sessionID)
+		}
+	}
+	if len(s.sessions) >= s.maxSessions {
+		return "", ErrTooManyIngestionSessions
+	}
+
+	session := &ingestionSession{Table: table, Shard: shard, lastUsed: now}
+	if err := os.MkdirAll(s.sessionDir(id), 0755); err != nil {
+		return "", utils.StackError(err, "Failed to create ingestion session %s", id)
+	}
+	if err := s.writeMeta(id, session); err != nil {
+		os.RemoveAll(s.sessionDir(id))
+		return "", utils.StackError(err, "Failed to write ingestion session %s", id)
+	}
+	s.sessions[id] = session
+	return id, nil
+}
+
+// get returns the session of the table shard, the caller must hold the lock.
+func (s *ingestionSessions) get(id, table string, shard int) (*ingestionSession, error) {
+	session := s.sessions[id]
+	now := utils.Now()
+	if session == nil || session.Table != table || session.Shard != shard || s.expired(session, now) {
+		return nil, ErrIngestionSessionNotFound
+	}
+	session.lastUsed = now
+	return session, nil
+}
+
+// status returns the last acked chunk and whether the session is committed.
+func (s *ingestionSessions) status(id, table string, shard int) (int, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	session, err := s.get(id, table, shard)
+	if err != nil {
+		return 0, false, err
+	}
+	return session.lastChunk(), session.Committed, nil
+}
+
+// addChunk writes the chunk with the sequence number to disk and returns the last acked chunk.
+// Chunks acked before are ignored so clients can resend chunks whose acks were lost.
+func (s *ingestionSessions) addChunk(id, table string, shard int, sequence int, chunk []byte) (int, error) {
+	s.Lock()
+	session, err := s.get(id, table, shard)
+	if err == nil && session.Committed {
+		err = ErrIngestionSessionCommitted
+	}
+	if err == nil {
+		err = session.busy()
+	}
+	if err == nil && (sequence > session.numChunks || sequence < 0) {
+		err = utils.APIError{
+			Code:    http.StatusConflict,
+			Message: fmt.Sprintf("Expect chunk %d of ingestion session, but got %d", session.numChunks, sequence),
+		}
+	}
+	if err != nil || sequence < session.numChunks {
+		s.Unlock()
+		if err != nil {
+			return 0, err
+		}
+		return session.lastChunk(), nil
+	}
+	if int64(len(chunk)) > s.maxChunkBytes {
+		s.Unlock()
+		return 0, utils.APIError{
+			Code:    http.StatusRequestEntityTooLarge,
+			Message: fmt.Sprintf("Chunk of %d bytes exceeds the limit of %d bytes", len(chunk), s.maxChunkBytes),
+		}
+	}
+	if session.bytes+int64(len(chunk)) > s.maxSessionBytes {
+		s.Unlock()
+		return 0, utils.APIError{
+			Code:    http.StatusRequestEntityTooLarge,
+			Message: fmt.Sprintf("Ingestion session exceeds the limit of %d bytes", s.maxSessionBytes),
+		}
+	}
+	session.uploading = true
+	s.Unlock()
+
+	err = writeFile(s.chunkPath(id, sequence), chunk)
+
+	s.Lock()
+	defer s.Unlock()
+	session.uploading = false
+	session.lastUsed = utils.Now()
+	if err != nil {
+		return 0, utils.StackError(err, "Failed to write chunk %d of ingestion session %s", sequence, id)
+	}
+	session.numChunks++
+	session.bytes += int64(len(chunk))
+	return session.lastChunk(), nil
+}
+
+// commit reads the acked chunks of the session into a single upsert batch and applies it with
+// apply. The buffer is reported to hostMemory, which can be nil, while it is alive. The session is
+// kept for retrying if apply fails, committing a committed session again does nothing.
+func (s *ingestionSessions) commit(id, table string, shard int, hostMemory memCom.HostMemoryManager,
+	apply func(buffer []byte) error) error {
+	s.Lock()
+	session, err := s.get(id, table, shard)
+	if err == nil {
+		err = session.busy()
+	}
+	if err != nil || session.Committed {
+		s.Unlock()
+		return err
+	}
+	session.committing = true
+	numChunks, size := session.numChunks, session.bytes
+	s.Unlock()
+
+	if hostMemory != nil {
+		hostMemory.ReportUnmanagedSpaceUsageChange(size)
+		defer hostMemory.ReportUnmanagedSpaceUsageChange(-size)
+	}
+	buffer := make([]byte, 0, size)
+	for sequence := 0; sequence < numChunks && err == nil; sequence++ {
+		var chunk []byte
+		if chunk, err = ioutil.ReadFile(s.chunkPath(id, sequence)); err != nil {
+			err = utils.StackError(err, "Failed to read chunk %d of ingestion session %s", sequence, id)
+		}
+		buffer = append(buffer, chunk...)
+	}
+	if err == nil {
+		err = apply(buffer)
+	}
+	if err == nil {
+		committed := &ingestionSession{Table: table, Shard: shard, Committed: true}
+		if writeErr := s.writeMeta(id, committed); writeErr != nil {
+			utils.GetLogger().With("session", id, "error", writeErr).Error("Failed to mark ingestion session committed")
+		}
+		for sequence := 0; sequence < numChunks; sequence++ {
+			os.Remove(s.chunkPath(id, sequence))
+		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	session.committing = false
+	session.lastUsed = utils.Now()
+	if err == nil {
+		session.Committed = true
+		session.numChunks = 0
+		session.bytes = 0
+	}
+	return err
+}
+
+// abort discards the session and its chunks.
+func (s *ingestionSessions) abort(id, table string, shard int) error {
+	s.Lock()
+	defer s.Unlock()
+	session, err := s.get(id, table, shard)
+	if err != nil {
+		return err
+	}
+	if err = session.busy(); err != nil {
+		return err
+	}
+	s.remove(id)
+	return nil
+}
@@ -0,0 +1,85 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/uber/aresdb/query"
+)
+
+// defaultMaxPreparedQueries is the number of prepared queries kept if not configured.
+const defaultMaxPreparedQueries = 1000
+
+// preparedQueryCache keeps prepared queries by name in memory, evicting the least recently
+// prepared or executed queries once full.
+type preparedQueryCache struct {
+	sync.Mutex
+	maxEntries int
+	// least recently used at the back.
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type preparedQueryCacheEntry struct {
+	name          string
+	preparedQuery *query.PreparedQuery
+}
+
+// newPreparedQueryCache creates a preparedQueryCache keeping at most maxEntries queries,
+// defaultMaxPreparedQueries if maxEntries is not positive.
+func newPreparedQueryCache(maxEntries int) *preparedQueryCache {
+	if maxEntries <= 0 {
+		maxEntries = defaultMaxPreparedQueries
+	}
+	return &preparedQueryCache{
+		maxEntries: maxEntries,
+		lru:        list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// get returns the prepared query by the name, nil if not prepared or evicted.
+func (c *preparedQueryCache) get(name string) *query.PreparedQuery {
+	c.Lock()
+	defer c.Unlock()
+	element, found := c.entries[name]
+	if !found {
+		return nil
+	}
+	c.lru.MoveToFront(element)
+	return element.Value.(*preparedQueryCacheEntry).preparedQuery
+}
+
+// put keeps the prepared query by the name, replacing the previous query of the name.
+func (c *preparedQueryCache) put(name string, preparedQuery *query.PreparedQuery) {
+	c.Lock()
+	defer c.Unlock()
+	if element, found := c.entries[name]; found {
+		element.Value.(*preparedQueryCacheEntry).preparedQuery = preparedQuery
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.entries[name] = c.lru.PushFront(&preparedQueryCacheEntry{
+		name:          name,
+		preparedQuery: preparedQuery,
+	})
+	for c.lru.Len() > c.maxEntries {
+		element := c.lru.Back()
+		c.lru.Remove(element)
+		delete(c.entries, element.Value.(*preparedQueryCacheEntry).name)
+	}
+}
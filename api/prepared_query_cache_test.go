@@ -0,0 +1,44 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/query"
+)
+
+var _ = ginkgo.Describe("prepared query cache", func() {
+	ginkgo.It("evicts least recently used prepared queries", func() {
+		Ω(newPreparedQueryCache(0).maxEntries).Should(Equal(defaultMaxPreparedQueries))
+
+		a, b, c := &query.PreparedQuery{}, &query.PreparedQuery{}, &query.PreparedQuery{}
+		cache := newPreparedQueryCache(2)
+		cache.put("a", a)
+		cache.put("b", b)
+		Ω(cache.get("a")).Should(BeIdenticalTo(a))
+
+		// b is the least recently used.
+		cache.put("c", c)
+		Ω(cache.get("b")).Should(BeNil())
+		Ω(cache.get("c")).Should(BeIdenticalTo(c))
+
+		// preparing under an existing name replaces the query without evicting others.
+		cache.put("a", b)
+		Ω(cache.get("a")).Should(BeIdenticalTo(b))
+		Ω(cache.get("c")).Should(BeIdenticalTo(c))
+		Ω(cache.lru.Len()).Should(Equal(2))
+	})
+})
@@ -0,0 +1,156 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	metaCom "github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/query"
+	queryCom "github.com/uber/aresdb/query/common"
+)
+
+// queryResultCache caches the results of queries over immutable time ranges, evicting the least
+// recently used results once full. Results are bound to the schema version of their table and are
+// invalidated once the table schema changes. Results are keyed by the delete predicates of the table
+// since adding or removing one changes the rows visible without changing the schema version.
+type queryResultCache struct {
+	sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	// least recently used at the back.
+	lru     *list.List
+	entries map[string]*list.Element
+	// latest schema version seen by table.
+	schemaVersions map[string]int
+}
+
+type queryResultCacheEntry struct {
+	key           string
+	table         string
+	schemaVersion int
+	result        queryCom.AQLTimeSeriesResult
+	expiry        time.Time
+}
+
+// newQueryResultCache creates a queryResultCache, or returns nil if maxEntries is not positive.
+func newQueryResultCache(maxEntries int, ttl time.Duration) *queryResultCache {
+	if maxEntries <= 0 {
+		return nil
+	}
+	return &queryResultCache{
+		maxEntries:     maxEntries,
+		ttl:            ttl,
+		lru:            list.New(),
+		entries:        make(map[string]*list.Element),
+		schemaVersions: make(map[string]int),
+	}
+}
+
+// normalizeQuery marshals the query without its time filter range, which must be done before the query
+// is compiled since compilation rewrites parts of the query.
+func normalizeQuery(aqlQuery query.AQLQuery) []byte {
+	aqlQuery.TimeFilter.From, aqlQuery.TimeFilter.To = "", ""
+	bytes, _ := json.Marshal(aqlQuery)
+	return bytes
+}
+
+// queryResultCacheKey returns the hash of the normalized query, its resolved time range and the delete
+// predicates of the table, so that the same range written differently shares the same key.
+func queryResultCacheKey(normalizedQuery []byte, from, to int64, deletePredicates []metaCom.DeletePredicate) string {
+	predicates, _ := json.Marshal(deletePredicates)
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%d-%d:", from, to)
+	hash.Write(predicates)
+	hash.Write(normalizedQuery)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// get returns the unexpired result cached by the key for the schema version of the table.
+func (c *queryResultCache) get(key, table string, schemaVersion int, now time.Time) (
+	result queryCom.AQLTimeSeriesResult, ok bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.checkSchemaVersion(table, schemaVersion)
+	element, found := c.entries[key]
+	if !found {
+		return
+	}
+	entry := element.Value.(*queryResultCacheEntry)
+	if entry.schemaVersion != schemaVersion {
+		return
+	}
+	if !now.Before(entry.expiry) {
+		c.remove(element)
+		return
+	}
+	c.lru.MoveToFront(element)
+	return entry.result, true
+}
+
+// put caches the result of the table at the schema version by the key.
+func (c *queryResultCache) put(key, table string, schemaVersion int, result queryCom.AQLTimeSeriesResult,
+	now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+	c.checkSchemaVersion(table, schemaVersion)
+	if schemaVersion < c.schemaVersions[table] {
+		return
+	}
+	if element, found := c.entries[key]; found {
+		c.remove(element)
+	}
+	c.entries[key] = c.lru.PushFront(&queryResultCacheEntry{
+		key:           key,
+		table:         table,
+		schemaVersion: schemaVersion,
+		result:        result,
+		expiry:        now.Add(c.ttl),
+	})
+	for c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+// checkSchemaVersion removes the results of the table cached for older schema versions once a newer
+// version is seen.
+func (c *queryResultCache) checkSchemaVersion(table string, schemaVersion int) {
+	latest, found := c.schemaVersions[table]
+	if found && schemaVersion <= latest {
+		return
+	}
+	c.schemaVersions[table] = schemaVersion
+	if !found {
+		return
+	}
+	for element := c.lru.Front(); element != nil; {
+		next := element.Next()
+		if element.Value.(*queryResultCacheEntry).table == table {
+			c.remove(element)
+		}
+		element = next
+	}
+}
+
+func (c *queryResultCache) remove(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*queryResultCacheEntry).key)
+}
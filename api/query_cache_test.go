@@ -0,0 +1,154 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	"github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/memstore"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	metaMocks "github.com/uber/aresdb/metastore/mocks"
+	"github.com/uber/aresdb/query"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("query result cache", func() {
+	now := time.Unix(1000, 0)
+	result := queryCom.AQLTimeSeriesResult{"1": 1.0}
+
+	ginkgo.It("expires and evicts results", func() {
+		Ω(newQueryResultCache(0, time.Minute)).Should(BeNil())
+
+		cache := newQueryResultCache(2, time.Minute)
+		cache.put("a", "trips", 1, result, now)
+		cache.put("b", "trips", 1, result, now)
+		_, ok := cache.get("a", "trips", 1, now)
+		Ω(ok).Should(BeTrue())
+
+		// b is the least recently used.
+		cache.put("c", "trips", 1, result, now)
+		_, ok = cache.get("b", "trips", 1, now)
+		Ω(ok).Should(BeFalse())
+		cached, ok := cache.get("c", "trips", 1, now)
+		Ω(ok).Should(BeTrue())
+		Ω(cached).Should(Equal(result))
+
+		_, ok = cache.get("a", "trips", 1, now.Add(time.Minute))
+		Ω(ok).Should(BeFalse())
+		Ω(cache.lru.Len()).Should(Equal(1))
+	})
+
+	ginkgo.It("invalidates results of tables with schema changes", func() {
+		cache := newQueryResultCache(10, time.Minute)
+		cache.put("a", "trips", 1, result, now)
+		cache.put("b", "trips", 1, result, now)
+		cache.put("c", "drivers", 1, result, now)
+
+		_, ok := cache.get("a", "trips", 2, now)
+		Ω(ok).Should(BeFalse())
+		Ω(cache.entries).Should(HaveLen(1))
+		_, ok = cache.get("c", "drivers", 1, now)
+		Ω(ok).Should(BeTrue())
+
+		// results of older schema versions are not cached.
+		cache.put("a", "trips", 1, result, now)
+		Ω(cache.entries).Should(HaveLen(1))
+	})
+
+	ginkgo.It("normalizes time filters of queries", func() {
+		q1 := query.AQLQuery{Table: "trips", TimeFilter: query.TimeFilter{Column: "request_at", From: "-2d", To: "-1d"}}
+		q2 := query.AQLQuery{Table: "trips", TimeFilter: query.TimeFilter{Column: "request_at", From: "1000"}}
+		Ω(queryResultCacheKey(normalizeQuery(q1), 0, 86400, nil)).Should(Equal(queryResultCacheKey(normalizeQuery(q2), 0, 86400, nil)))
+		Ω(queryResultCacheKey(normalizeQuery(q1), 0, 86400, nil)).ShouldNot(Equal(queryResultCacheKey(normalizeQuery(q1), 0, 86401, nil)))
+		Ω(q1.TimeFilter.From).Should(Equal("-2d"))
+
+		predicates := []metaCom.DeletePredicate{{ID: 1, Filter: "fare > 10", Cutoff: 86400}}
+		Ω(queryResultCacheKey(normalizeQuery(q1), 0, 86400, nil)).ShouldNot(Equal(queryResultCacheKey(normalizeQuery(q1), 0, 86400, predicates)))
+	})
+
+	ginkgo.It("serves only queries over immutable time ranges from cache", func() {
+		schema := memstore.NewTableSchema(&metaCom.Table{
+			Name:        "trips",
+			IsFactTable: true,
+			Columns: []metaCom.Column{
+				{Name: "request_at", Type: metaCom.Uint32},
+				{Name: "fare", Type: metaCom.Float32},
+			},
+			Config: metaCom.TableConfig{
+				BatchSize:             10,
+				RecordRetentionInDays: 30,
+			},
+			Version: 1,
+		})
+		metaStore := new(metaMocks.MetaStore)
+		metaStore.On("GetArchiveBatchVersion", "trips", 0, mock.Anything, mock.Anything).
+			Return(uint32(0), uint32(0), 0, nil)
+		memStore := CreateMemStore(schema, 0, metaStore, CreateMockDiskStore())
+		shard, _ := memStore.GetTableShard("trips", 0)
+		shard.Users.Done()
+		shard.ArchiveStore.CurrentVersion = memstore.NewArchiveStoreVersion(1500086400, shard)
+		handler := NewQueryHandler(memStore, common.QueryConfig{
+			DeviceMemoryUtilization: 1.0,
+			ResultCacheSize:         10,
+			ResultCacheTTL:          60,
+		})
+
+		archived := query.AQLQuery{
+			Table:      "trips",
+			Measures:   []query.Measure{{Expr: "sum(fare)"}},
+			TimeFilter: query.TimeFilter{Column: "request_at", From: "1500000000", To: "1500086400"},
+		}
+		key := queryResultCacheKey(normalizeQuery(archived), 1500000000, 1500086400, nil)
+		utils.SetCurrentTime(time.Unix(1600000000, 0))
+		defer utils.ResetClockImplementation()
+		handler.resultCache.put(key, "trips", 1, result, utils.Now())
+
+		request := AQLRequest{Body: query.AQLRequest{Queries: []query.AQLQuery{archived}}}
+		rw := NewJSONQueryResponseWriter(1)
+		handler.handleQuery(context.Background(), request, 0, "", query.QueryLimits{}, rw)
+		Ω(rw.(*JSONQueryResponseWriter).response.Results[0]).Should(Equal(result))
+
+		// a delete predicate was added since.
+		schema.DeletePredicates = []metaCom.DeletePredicate{{ID: 1, Filter: "fare > 10", Cutoff: 1600000000}}
+		rw = NewJSONQueryResponseWriter(1)
+		handler.handleQuery(context.Background(), request, 0, "", query.QueryLimits{}, rw)
+		Ω(rw.(*JSONQueryResponseWriter).response.Errors).Should(BeNil())
+		Ω(rw.(*JSONQueryResponseWriter).response.Results[0]).Should(BeEmpty())
+		schema.DeletePredicates = nil
+
+		// the archiving cutoff is before the end of the time range.
+		shard.ArchiveStore.CurrentVersion.ArchivingCutoff = 1499990000
+		rw = NewJSONQueryResponseWriter(1)
+		handler.handleQuery(context.Background(), request, 0, "", query.QueryLimits{}, rw)
+		Ω(rw.(*JSONQueryResponseWriter).response.Errors).Should(BeNil())
+		Ω(rw.(*JSONQueryResponseWriter).response.Results[0]).Should(BeEmpty())
+
+		// live window up to now.
+		live := archived
+		live.TimeFilter.To = ""
+		request.Body.Queries[0] = live
+		rw = NewJSONQueryResponseWriter(1)
+		handler.handleQuery(context.Background(), request, 0, "", query.QueryLimits{}, rw)
+		Ω(rw.(*JSONQueryResponseWriter).response.Errors).Should(BeNil())
+		Ω(rw.(*JSONQueryResponseWriter).response.Results[0]).Should(BeEmpty())
+		Ω(handler.resultCache.entries).Should(HaveLen(2))
+	})
+})
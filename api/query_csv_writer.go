@@ -0,0 +1,237 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/uber/aresdb/query"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// CSVQueryResponseWriter writes the result of a single query as csv into the http response as soon as
+// the query finishes. Each group is written as a row of its dimension values followed by its measure
+// values, after a header row of the dimension and measure expressions. Groups of queries without steps
+// after aggregation are written as they are read from the result buffers. Rows of row queries are written
+// as they are after a header row of the selected columns. NULLs are written as empty cells.
+// Errors reported before the result are responded as json with the error status code.
+type CSVQueryResponseWriter struct {
+	rw         http.ResponseWriter
+	bw         *bufio.Writer
+	cw         *csv.Writer
+	query      query.AQLQuery
+	rows       int
+	errors     []error
+	statusCode int
+}
+
+// NewCSVQueryResponseWriter creates a new CSVQueryResponseWriter writing the result of aqlQuery into rw.
+func NewCSVQueryResponseWriter(rw http.ResponseWriter, aqlQuery query.AQLQuery) QueryResponseWriter {
+	return &CSVQueryResponseWriter{
+		rw:         rw,
+		query:      aqlQuery,
+		statusCode: http.StatusOK,
+	}
+}
+
+// ReportError writes the error of the query to the response.
+func (w *CSVQueryResponseWriter) ReportError(queryIndex int, table string, err error, statusCode int) {
+	if w.cw == nil && statusCode > w.statusCode {
+		w.statusCode = statusCode
+	}
+	w.errors = append(w.errors, err)
+	utils.GetRootReporter().GetChildCounter(map[string]string{
+		"table": table,
+	}, utils.QueryFailed).Inc(1)
+}
+
+// ReportQueryContext is ignored since csv is for exporting results only.
+func (w *CSVQueryResponseWriter) ReportQueryContext(qc *query.AQLQueryContext) {
+}
+
+// ReportResult writes the query result to the response.
+func (w *CSVQueryResponseWriter) ReportResult(queryIndex int, qc *query.AQLQueryContext) {
+	if qc.Results == nil && qc.StreamsGroups() {
+		w.writeGroups(qc.StreamGroups)
+		return
+	}
+	postprocess(qc)
+	if qc.Error != nil {
+		w.ReportError(queryIndex, qc.Query.Table, qc.Error, http.StatusInternalServerError)
+		return
+	}
+	w.ReportCachedResult(queryIndex, qc.Results)
+}
+
+// ReportCachedResult writes the cached query result to the response.
+func (w *CSVQueryResponseWriter) ReportCachedResult(queryIndex int, result queryCom.AQLTimeSeriesResult) {
+	w.start()
+	if len(w.query.Select) > 0 {
+		rows, _ := result[query.RowResultMatrixData].([][]interface{})
+		for _, row := range rows {
+			values := make([]string, len(row))
+			for i, value := range row {
+				values[i] = formatCSVValue(value)
+			}
+			w.writeRow(values)
+		}
+	} else {
+		w.writeRows(map[string]interface{}(result), nil)
+	}
+	w.flush()
+}
+
+// writeGroups writes a row for each group visited one at a time, without building the nested result.
+func (w *CSVQueryResponseWriter) writeGroups(streamGroups func(visit func(dimValues []string, measureValue *float64))) {
+	w.start()
+	streamGroups(func(dimValues []string, measureValue *float64) {
+		values := make([]string, len(dimValues), len(dimValues)+1)
+		for i, dimValue := range dimValues {
+			values[i] = formatCSVDimValue(dimValue)
+		}
+		if measureValue == nil {
+			w.writeRow(append(values, ""))
+		} else {
+			w.writeRow(append(values, formatCSVValue(*measureValue)))
+		}
+	})
+	w.flush()
+}
+
+// ReportProfile is ignored for the same reason as ReportQueryContext.
+func (w *CSVQueryResponseWriter) ReportProfile(queryIndex int, profile *query.QueryProfile) {
+}
+
+// Respond writes the rest of the response into ResponseWriter.
+func (w *CSVQueryResponseWriter) Respond(rw http.ResponseWriter) {
+	if w.cw == nil && w.errors != nil {
+		RespondJSONObjectWithCode(rw, w.statusCode, query.AQLResponse{
+			Results: make([]queryCom.AQLTimeSeriesResult, 1),
+			Errors:  w.errors,
+		})
+		return
+	}
+	w.start()
+	w.flush()
+}
+
+// GetStatusCode returns the status code written into response.
+func (w *CSVQueryResponseWriter) GetStatusCode() int {
+	return w.statusCode
+}
+
+// start writes the header and the header row of the response if not yet.
+func (w *CSVQueryResponseWriter) start() {
+	if w.cw != nil {
+		return
+	}
+	setCommonHeaders(w.rw)
+	w.rw.Header().Set("Content-Type", ContentTypeCSV)
+	w.rw.WriteHeader(w.statusCode)
+	w.bw = bufio.NewWriterSize(w.rw, streamingBufferSize)
+	w.cw = csv.NewWriter(w.bw)
+
+	if len(w.query.Select) > 0 {
+		w.cw.Write(w.query.Select)
+		return
+	}
+	header := make([]string, 0, len(w.query.Dimensions)+1)
+	for _, dim := range w.query.Dimensions {
+		header = append(header, dim.Expr)
+	}
+	for _, measure := range w.query.Measures {
+		header = append(header, measure.Expr)
+	}
+	w.cw.Write(header)
+}
+
+// writeRows writes a row for each leaf of the nested result with keys sorted the same way as json.Marshal.
+// The innermost layer of results of multiple measures, keyed by the measure indexes, is written as one row.
+func (w *CSVQueryResponseWriter) writeRows(result map[string]interface{}, dimValues []string) {
+	if len(w.query.Measures) > 1 && len(dimValues) == len(w.query.Dimensions) {
+		values := append([]string(nil), dimValues...)
+		for i := range w.query.Measures {
+			values = append(values, formatCSVValue(result[strconv.Itoa(i)]))
+		}
+		w.writeRow(values)
+		return
+	}
+
+	keys := make([]string, 0, len(result))
+	for key := range result {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		values := append(append([]string(nil), dimValues...), formatCSVDimValue(key))
+		if child, ok := result[key].(map[string]interface{}); ok {
+			w.writeRows(child, values)
+			continue
+		}
+
+		w.writeRow(append(values, formatCSVValue(result[key])))
+	}
+}
+
+// writeRow writes a row of cells, flushing every streamingFlushRows rows.
+func (w *CSVQueryResponseWriter) writeRow(values []string) {
+	w.cw.Write(values)
+	w.rows++
+	if w.rows%streamingFlushRows == 0 {
+		w.flush()
+	}
+}
+
+// flush flushes the buffered rows to the client.
+func (w *CSVQueryResponseWriter) flush() {
+	w.cw.Flush()
+	w.bw.Flush()
+	if flusher, ok := w.rw.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// formatCSVDimValue formats a dimension value as a csv cell.
+func formatCSVDimValue(dimValue string) string {
+	if dimValue == "NULL" {
+		return ""
+	}
+	return dimValue
+}
+
+// formatCSVValue formats a measure value or a row value as a csv cell.
+func formatCSVValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return v
+	}
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		utils.GetLogger().With("error", err, "value", value).Error("Failed to marshal query result")
+		return ""
+	}
+	return string(bytes)
+}
@@ -0,0 +1,148 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/query"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("CSVQueryResponseWriter", func() {
+	aqlQuery := query.AQLQuery{
+		Table:      "trips",
+		Dimensions: []query.Dimension{{Expr: "city_id"}, {Expr: "status"}},
+		Measures:   []query.Measure{{Expr: "sum(fare)"}},
+	}
+	result := queryCom.AQLTimeSeriesResult{
+		"1":    map[string]interface{}{"completed": 10.5, "canceled, by driver": 2.0},
+		"2":    map[string]interface{}{"say \"hi\"": nil},
+		"NULL": map[string]interface{}{"NULL": 1e21},
+	}
+
+	ginkgo.It("writes the same rows as the json result", func() {
+		jsonRecorder := httptest.NewRecorder()
+		jsonWriter := NewStreamingJSONQueryResponseWriter(jsonRecorder, 1)
+		jsonWriter.ReportCachedResult(0, result)
+		jsonWriter.Respond(jsonRecorder)
+		var response query.AQLResponse
+		Ω(json.Unmarshal(jsonRecorder.Body.Bytes(), &response)).Should(BeNil())
+
+		csvRecorder := httptest.NewRecorder()
+		csvWriter := NewCSVQueryResponseWriter(csvRecorder, aqlQuery)
+		csvWriter.ReportCachedResult(0, result)
+		csvWriter.Respond(csvRecorder)
+		Ω(csvRecorder.Code).Should(Equal(http.StatusOK))
+		Ω(csvRecorder.Header().Get("Content-Type")).Should(Equal(ContentTypeCSV))
+		Ω(csvRecorder.Body.String()).Should(Equal(`city_id,status,sum(fare)
+1,"canceled, by driver",2
+1,completed,10.5
+2,"say ""hi""",
+,,1000000000000000000000
+`))
+
+		records, err := csv.NewReader(csvRecorder.Body).ReadAll()
+		Ω(err).Should(BeNil())
+		Ω(records[0]).Should(Equal([]string{"city_id", "status", "sum(fare)"}))
+		Ω(records[1:]).Should(HaveLen(4))
+		for _, record := range records[1:] {
+			cityID, status := record[0], record[1]
+			if cityID == "" {
+				cityID = "NULL"
+			}
+			if status == "" {
+				status = "NULL"
+			}
+			Ω(response.Results[0]).Should(HaveKey(cityID))
+			expected := response.Results[0][cityID].(map[string]interface{})[status]
+			Ω(record[2]).Should(Equal(formatCSVValue(expected)))
+		}
+	})
+
+	ginkgo.It("writes streamed groups", func() {
+		fare := 10.5
+		recorder := httptest.NewRecorder()
+		w := NewCSVQueryResponseWriter(recorder, aqlQuery).(*CSVQueryResponseWriter)
+		w.writeGroups(func(visit func(dimValues []string, measureValue *float64)) {
+			visit([]string{"1", "completed"}, &fare)
+			visit([]string{"NULL", "canceled"}, nil)
+		})
+		w.Respond(recorder)
+		Ω(recorder.Body.String()).Should(Equal(`city_id,status,sum(fare)
+1,completed,10.5
+,canceled,
+`))
+	})
+
+	ginkgo.It("writes a column for each measure", func() {
+		recorder := httptest.NewRecorder()
+		w := NewCSVQueryResponseWriter(recorder, query.AQLQuery{
+			Table:      "trips",
+			Dimensions: []query.Dimension{{Expr: "city_id"}},
+			Measures:   []query.Measure{{Expr: "sum(fare)"}, {Expr: "count(*)"}},
+		})
+		w.ReportCachedResult(0, queryCom.AQLTimeSeriesResult{
+			"1": map[string]interface{}{"0": 10.5, "1": 3.0},
+			"2": map[string]interface{}{"0": nil, "1": 1.0},
+		})
+		w.Respond(recorder)
+		Ω(recorder.Body.String()).Should(Equal(`city_id,sum(fare),count(*)
+1,10.5,3
+2,,1
+`))
+	})
+
+	ginkgo.It("writes rows of row queries", func() {
+		recorder := httptest.NewRecorder()
+		w := NewCSVQueryResponseWriter(recorder, query.AQLQuery{
+			Table:  "trips",
+			Select: []string{"city_id", "status"},
+			Limit:  10,
+		})
+		w.ReportCachedResult(0, queryCom.AQLTimeSeriesResult{
+			query.RowResultHeaders:    []string{"city_id", "status"},
+			query.RowResultMatrixData: [][]interface{}{{"2", "completed"}, {"1", nil}},
+		})
+		w.Respond(recorder)
+		Ω(recorder.Body.String()).Should(Equal(`city_id,status
+2,completed
+1,
+`))
+	})
+
+	ginkgo.It("responds errors before the result as json", func() {
+		recorder := httptest.NewRecorder()
+		w := NewCSVQueryResponseWriter(recorder, aqlQuery)
+		w.ReportError(0, "trips", utils.StackError(nil, "test err"), http.StatusBadRequest)
+		w.Respond(recorder)
+		Ω(w.GetStatusCode()).Should(Equal(http.StatusBadRequest))
+		Ω(recorder.Code).Should(Equal(http.StatusBadRequest))
+		Ω(recorder.Body.String()).Should(ContainSubstring("test err"))
+
+		// an empty result only has the header row.
+		recorder = httptest.NewRecorder()
+		w = NewCSVQueryResponseWriter(recorder, aqlQuery)
+		w.Respond(recorder)
+		Ω(strings.TrimSpace(recorder.Body.String())).Should(Equal("city_id,status,sum(fare)"))
+	})
+})
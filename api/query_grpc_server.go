@@ -0,0 +1,407 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/uber/aresdb/api/rpc"
+	"github.com/uber/aresdb/query"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// grpcQueryMethod is the url of the requests tenants and traces of gRPC queries are read from.
+const grpcQueryMethod = "/aresdb.rpc.QueryService/Query"
+
+// QueryServer serves the gRPC QueryService with the executor of the query handler. Queries are
+// limited and checked against the column access of their tenant the same way as over http, with
+// the tenant header and RPC-Caller read from the metadata of the call.
+type QueryServer struct {
+	rpc.UnimplementedQueryServiceServer
+	handler *QueryHandler
+}
+
+// NewQueryServer creates a new QueryServer executing queries with the query handler.
+func NewQueryServer(handler *QueryHandler) *QueryServer {
+	return &QueryServer{handler: handler}
+}
+
+// Query executes the queries of the request in order and streams the result of each query as it
+// finishes. Errors of queries are sent in the stream, the call only fails when the tenant exceeds
+// its limits or the stream is broken.
+func (s *QueryServer) Query(request *rpc.QueryRequest, stream rpc.QueryService_QueryServer) error {
+	r := grpcHTTPRequest(stream.Context())
+	aqlRequest := AQLRequest{
+		Device:                -1,
+		DeviceChoosingTimeout: int(request.DeviceChoosingTimeout),
+		Body:                  query.AQLRequest{Queries: make([]query.AQLQuery, len(request.Queries))},
+	}
+	if request.Device != nil {
+		aqlRequest.Device = int(*request.Device)
+	}
+	for i, rpcQuery := range request.Queries {
+		aqlRequest.Body.Queries[i] = fromRPCQuery(rpcQuery)
+	}
+
+	limiter := s.handler.tenantLimiter
+	tenant := limiter.tenant(r)
+	usage, limitErr := limiter.acquire(tenant)
+	if limitErr != nil {
+		utils.GetRootReporter().GetChildCounter(map[string]string{"tenant": limitErr.bucket}, utils.TenantThrottledQueries).Inc(1)
+		return status.Error(codes.ResourceExhausted, limitErr.Message)
+	}
+	defer limiter.release(usage)
+
+	span, ctx := utils.StartSpanFromRequest(r, tracingOperationRequest)
+	defer span.Finish()
+
+	limits := limiter.queryLimits(r, s.handler.queryLimits)
+	responseWriter := newGRPCQueryResponseWriter(stream, aqlRequest.Body.Queries)
+	queryTimer := utils.GetRootReporter().GetTimer(utils.QueryLatency)
+	start := utils.Now()
+	for i := range aqlRequest.Body.Queries {
+		// queries are cancelled once the client cancels the call.
+		s.handler.handleQuery(ctx, aqlRequest, i, tenant, limits, responseWriter)
+		if responseWriter.err != nil {
+			return responseWriter.err
+		}
+	}
+	queryTimer.Record(utils.Now().Sub(start))
+	return nil
+}
+
+// grpcHTTPRequest returns a request with the peer address and the metadata of the gRPC call as
+// headers, for identifying the tenant and extracting the trace of the call same as http requests.
+func grpcHTTPRequest(ctx context.Context) *http.Request {
+	r, _ := http.NewRequest(http.MethodPost, grpcQueryMethod, nil)
+	r = r.WithContext(ctx)
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		r.RemoteAddr = p.Addr.String()
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for key, values := range md {
+		for _, value := range values {
+			r.Header.Add(key, value)
+		}
+	}
+	return r
+}
+
+// fromRPCQuery converts the query of the gRPC request to an AQLQuery.
+func fromRPCQuery(q *rpc.AQLQuery) query.AQLQuery {
+	aqlQuery := query.AQLQuery{
+		Table:       q.Table,
+		Tables:      q.Tables,
+		Select:      q.Select,
+		Filters:     q.RowFilters,
+		FilterTree:  fromRPCFilterNode(q.FilterTree),
+		Having:      q.Having,
+		Limit:       int(q.Limit),
+		Paginate:    q.Paginate,
+		Cursor:      q.Cursor,
+		Timezone:    q.Timezone,
+		Now:         q.Now,
+		Consistency: q.Consistency,
+	}
+	for _, join := range q.Joins {
+		aqlQuery.Joins = append(aqlQuery.Joins, query.Join{
+			Table:      join.Table,
+			Alias:      join.Alias,
+			Conditions: join.Conditions,
+		})
+	}
+	for _, dim := range q.Dimensions {
+		dimension := query.Dimension{
+			Expr:           dim.SqlExpression,
+			TimeBucketizer: dim.TimeBucketizer,
+			TimeUnit:       dim.TimeUnit,
+			Fill:           dim.Fill,
+		}
+		if dim.NumericBucketizer != nil {
+			dimension.NumericBucketizer = query.NumericBucketizerDef{
+				BucketWidth:      dim.NumericBucketizer.BucketWidth,
+				LogBase:          dim.NumericBucketizer.LogBase,
+				ManualPartitions: dim.NumericBucketizer.ManualPartitions,
+			}
+		}
+		aqlQuery.Dimensions = append(aqlQuery.Dimensions, dimension)
+	}
+	for _, measure := range q.Measures {
+		aqlQuery.Measures = append(aqlQuery.Measures, query.Measure{
+			Expr:    measure.SqlExpression,
+			Filters: measure.RowFilters,
+			Running: measure.Running,
+			Window:  int(measure.Window),
+		})
+	}
+	for _, sortField := range q.Sorts {
+		aqlQuery.Sorts = append(aqlQuery.Sorts, query.SortField{
+			Expr: sortField.SqlExpression,
+			Desc: sortField.Desc,
+		})
+	}
+	if q.TimeFilter != nil {
+		aqlQuery.TimeFilter = query.TimeFilter{
+			Column: q.TimeFilter.Column,
+			From:   q.TimeFilter.From,
+			To:     q.TimeFilter.To,
+		}
+	}
+	return aqlQuery
+}
+
+// fromRPCFilterNode converts the filter tree of the gRPC request, nil if not set.
+func fromRPCFilterNode(node *rpc.FilterNode) *query.FilterNode {
+	if node == nil {
+		return nil
+	}
+	filterNode := &query.FilterNode{
+		Expr: node.SqlExpression,
+		Not:  fromRPCFilterNode(node.Not),
+	}
+	for _, child := range node.And {
+		filterNode.And = append(filterNode.And, *fromRPCFilterNode(child))
+	}
+	for _, child := range node.Or {
+		filterNode.Or = append(filterNode.Or, *fromRPCFilterNode(child))
+	}
+	return filterNode
+}
+
+// grpcQueryResponseWriter streams the result of each query into the gRPC stream in responses of
+// up to streamingFlushRows rows encoded by column. Groups of queries without steps after aggregation
+// are sent as they are read from the result buffers, other results are flattened the same way as csv.
+type grpcQueryResponseWriter struct {
+	stream  rpc.QueryService_QueryServer
+	queries []query.AQLQuery
+	// response being filled with rows of the query being written.
+	response *rpc.QueryResponse
+	rows     int
+	// error of sending to the stream, nothing is sent after it.
+	err        error
+	statusCode int
+}
+
+func newGRPCQueryResponseWriter(stream rpc.QueryService_QueryServer, queries []query.AQLQuery) *grpcQueryResponseWriter {
+	return &grpcQueryResponseWriter{
+		stream:     stream,
+		queries:    queries,
+		statusCode: http.StatusOK,
+	}
+}
+
+// ReportError sends the error of the query to the stream.
+func (w *grpcQueryResponseWriter) ReportError(queryIndex int, table string, err error, statusCode int) {
+	if statusCode > w.statusCode {
+		w.statusCode = statusCode
+	}
+	message := err.Error()
+	// stack traces are left out of the messages.
+	if stackedErr, ok := err.(*utils.StackedError); ok {
+		message = stackedErr.Message()
+	}
+	w.response = nil
+	w.send(&rpc.QueryResponse{
+		QueryIndex: int32(queryIndex),
+		Done:       true,
+		Error: &rpc.Error{
+			Code:    int32(statusCode),
+			Message: message,
+		},
+	})
+	utils.GetRootReporter().GetChildCounter(map[string]string{
+		"table": table,
+	}, utils.QueryFailed).Inc(1)
+}
+
+// ReportQueryContext is ignored since query contexts are for debugging over http.
+func (w *grpcQueryResponseWriter) ReportQueryContext(qc *query.AQLQueryContext) {
+}
+
+// ReportResult sends the query result to the stream.
+func (w *grpcQueryResponseWriter) ReportResult(queryIndex int, qc *query.AQLQueryContext) {
+	if qc.Results == nil && qc.StreamsGroups() {
+		w.start(queryIndex)
+		numDims := len(w.queries[queryIndex].Dimensions)
+		qc.StreamGroups(func(dimValues []string, measureValue *float64) {
+			for i, dimValue := range dimValues {
+				w.appendString(i, dimValue, dimValue == "NULL")
+			}
+			if measureValue == nil {
+				w.appendDouble(numDims, nil)
+			} else {
+				w.appendDouble(numDims, *measureValue)
+			}
+			w.endRow()
+		})
+		w.finish(qc.NextCursor)
+		return
+	}
+	postprocess(qc)
+	if qc.Error != nil {
+		w.ReportError(queryIndex, qc.Query.Table, qc.Error, http.StatusInternalServerError)
+		return
+	}
+	w.writeResult(queryIndex, qc.Results, qc.NextCursor)
+}
+
+// ReportCachedResult sends the cached query result to the stream.
+func (w *grpcQueryResponseWriter) ReportCachedResult(queryIndex int, result queryCom.AQLTimeSeriesResult) {
+	w.writeResult(queryIndex, result, "")
+}
+
+// ReportProfile is ignored for the same reason as ReportQueryContext.
+func (w *grpcQueryResponseWriter) ReportProfile(queryIndex int, profile *query.QueryProfile) {
+}
+
+// Respond does nothing since results are sent as soon as they are reported.
+func (w *grpcQueryResponseWriter) Respond(rw http.ResponseWriter) {
+}
+
+// GetStatusCode returns the status code of the most severe error reported.
+func (w *grpcQueryResponseWriter) GetStatusCode() int {
+	return w.statusCode
+}
+
+// writeResult sends the nested result, or the rows of row queries, to the stream.
+func (w *grpcQueryResponseWriter) writeResult(queryIndex int, result queryCom.AQLTimeSeriesResult, cursor string) {
+	w.start(queryIndex)
+	if len(w.queries[queryIndex].Select) > 0 {
+		rows, _ := result[query.RowResultMatrixData].([][]interface{})
+		for _, row := range rows {
+			for i, value := range row {
+				w.appendString(i, formatCSVValue(value), value == nil)
+			}
+			w.endRow()
+		}
+	} else {
+		w.writeRows(queryIndex, map[string]interface{}(result), nil)
+	}
+	w.finish(cursor)
+}
+
+// writeRows appends a row for each leaf of the nested result with keys sorted the same way as
+// json.Marshal. The innermost layer of results of multiple measures, keyed by the measure indexes,
+// is appended as one row.
+func (w *grpcQueryResponseWriter) writeRows(queryIndex int, result map[string]interface{}, dimValues []string) {
+	aqlQuery := w.queries[queryIndex]
+	if len(aqlQuery.Measures) > 1 && len(dimValues) == len(aqlQuery.Dimensions) {
+		w.appendDimValues(dimValues)
+		for i := range aqlQuery.Measures {
+			w.appendDouble(len(dimValues)+i, result[strconv.Itoa(i)])
+		}
+		w.endRow()
+		return
+	}
+
+	keys := make([]string, 0, len(result))
+	for key := range result {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		values := append(append([]string(nil), dimValues...), key)
+		if child, ok := result[key].(map[string]interface{}); ok {
+			w.writeRows(queryIndex, child, values)
+			continue
+		}
+		w.appendDimValues(values)
+		w.appendDouble(len(values), result[key])
+		w.endRow()
+	}
+}
+
+// start starts the first response of the query with the names of its columns.
+func (w *grpcQueryResponseWriter) start(queryIndex int) {
+	aqlQuery := w.queries[queryIndex]
+	columnNames := aqlQuery.Select
+	if len(columnNames) == 0 {
+		columnNames = make([]string, 0, len(aqlQuery.Dimensions)+len(aqlQuery.Measures))
+		for _, dim := range aqlQuery.Dimensions {
+			columnNames = append(columnNames, dim.Expr)
+		}
+		for _, measure := range aqlQuery.Measures {
+			columnNames = append(columnNames, measure.Expr)
+		}
+	}
+	w.response = &rpc.QueryResponse{
+		QueryIndex:  int32(queryIndex),
+		ColumnNames: columnNames,
+	}
+	w.rows = 0
+}
+
+// column returns the column of the response being filled, adding the columns up to it.
+func (w *grpcQueryResponseWriter) column(index int) *rpc.Column {
+	for len(w.response.Columns) <= index {
+		w.response.Columns = append(w.response.Columns, &rpc.Column{})
+	}
+	return w.response.Columns[index]
+}
+
+func (w *grpcQueryResponseWriter) appendDimValues(dimValues []string) {
+	for i, dimValue := range dimValues {
+		w.appendString(i, dimValue, dimValue == "NULL")
+	}
+}
+
+func (w *grpcQueryResponseWriter) appendString(index int, value string, null bool) {
+	column := w.column(index)
+	if null {
+		value = ""
+	}
+	column.StringValues = append(column.StringValues, value)
+	column.Nulls = append(column.Nulls, null)
+}
+
+// appendDouble appends a measure value, values other than numbers are appended as NULL.
+func (w *grpcQueryResponseWriter) appendDouble(index int, value interface{}) {
+	column := w.column(index)
+	number, ok := value.(float64)
+	column.DoubleValues = append(column.DoubleValues, number)
+	column.Nulls = append(column.Nulls, !ok)
+}
+
+// endRow ends the row being appended, sending the response every streamingFlushRows rows.
+func (w *grpcQueryResponseWriter) endRow() {
+	w.rows++
+	if w.rows%streamingFlushRows == 0 {
+		w.send(w.response)
+		w.response = &rpc.QueryResponse{QueryIndex: w.response.QueryIndex}
+	}
+}
+
+// finish sends the last response of the query.
+func (w *grpcQueryResponseWriter) finish(cursor string) {
+	w.response.Done = true
+	w.response.Cursor = cursor
+	w.send(w.response)
+	w.response = nil
+}
+
+func (w *grpcQueryResponseWriter) send(response *rpc.QueryResponse) {
+	if w.err == nil {
+		w.err = w.stream.Send(response)
+	}
+}
@@ -0,0 +1,309 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/uber/aresdb/api/rpc"
+	"github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/memstore"
+	memMocks "github.com/uber/aresdb/memstore/mocks"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/query"
+	queryCom "github.com/uber/aresdb/query/common"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// sentResponses is a gRPC query stream keeping the sent responses.
+type sentResponses struct {
+	grpc.ServerStream
+	responses []*rpc.QueryResponse
+}
+
+func (s *sentResponses) Send(response *rpc.QueryResponse) error {
+	s.responses = append(s.responses, response)
+	return nil
+}
+
+var _ = ginkgo.Describe("QueryServer", func() {
+	var testSchema = memstore.NewTableSchema(&metaCom.Table{
+		Name: "trips",
+		Columns: []metaCom.Column{
+			{Name: "request_at", Type: "Uint32"},
+			{Name: "city_id", Type: "Uint16"},
+		},
+		Config: metaCom.TableConfig{
+			BatchSize: 10,
+		},
+	})
+
+	var memStore *memMocks.MemStore
+	var server *grpc.Server
+	var conn *grpc.ClientConn
+
+	startServer := func(cfg common.QueryConfig) rpc.QueryServiceClient {
+		cfg.DeviceMemoryUtilization = 1.0
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Ω(err).Should(BeNil())
+		server = grpc.NewServer()
+		rpc.RegisterQueryServiceServer(server, NewQueryServer(NewQueryHandler(memStore, cfg)))
+		go server.Serve(listener)
+		conn, err = grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		Ω(err).Should(BeNil())
+		return rpc.NewQueryServiceClient(conn)
+	}
+
+	receiveAll := func(stream rpc.QueryService_QueryClient) ([]*rpc.QueryResponse, error) {
+		var responses []*rpc.QueryResponse
+		for {
+			response, err := stream.Recv()
+			if err == io.EOF {
+				return responses, nil
+			}
+			if err != nil {
+				return responses, err
+			}
+			responses = append(responses, response)
+		}
+	}
+
+	groupByCity := &rpc.AQLQuery{
+		Table:      "trips",
+		Dimensions: []*rpc.Dimension{{SqlExpression: "city_id"}},
+		Measures:   []*rpc.Measure{{SqlExpression: "count(*)"}},
+	}
+
+	ginkgo.BeforeEach(func() {
+		memStore = CreateMemStore(testSchema, 0, nil, CreateMockDiskStore())
+	})
+
+	ginkgo.AfterEach(func() {
+		conn.Close()
+		server.Stop()
+	})
+
+	ginkgo.It("streams the result and the error of each query", func() {
+		client := startServer(common.QueryConfig{})
+		stream, err := client.Query(context.Background(), &rpc.QueryRequest{
+			Queries: []*rpc.AQLQuery{
+				groupByCity,
+				{Table: "dropped", Measures: []*rpc.Measure{{SqlExpression: "count(*)"}}},
+			},
+		})
+		Ω(err).Should(BeNil())
+		responses, err := receiveAll(stream)
+		Ω(err).Should(BeNil())
+		Ω(responses).Should(HaveLen(2))
+
+		Ω(responses[0].QueryIndex).Should(BeEquivalentTo(0))
+		Ω(responses[0].ColumnNames).Should(Equal([]string{"city_id", "count(*)"}))
+		Ω(responses[0].Columns).Should(BeEmpty())
+		Ω(responses[0].Done).Should(BeTrue())
+		Ω(responses[0].Error).Should(BeNil())
+
+		Ω(responses[1].QueryIndex).Should(BeEquivalentTo(1))
+		Ω(responses[1].Done).Should(BeTrue())
+		Ω(responses[1].Error.Code).Should(BeEquivalentTo(http.StatusNotFound))
+		Ω(responses[1].Error.Message).Should(ContainSubstring("dropped"))
+	})
+
+	ginkgo.It("checks column access of the tenant in the call metadata", func() {
+		testSchema.Lock()
+		testSchema.Schema.Columns[1].Config.ReadTenants = []string{"finance"}
+		testSchema.Unlock()
+		defer func() {
+			testSchema.Lock()
+			testSchema.Schema.Columns[1].Config.ReadTenants = nil
+			testSchema.Unlock()
+		}()
+
+		client := startServer(common.QueryConfig{})
+		for tenant, code := range map[string]int32{"marketing": http.StatusForbidden, "finance": 0} {
+			ctx := metadata.AppendToOutgoingContext(context.Background(), "RPC-Caller", tenant)
+			stream, err := client.Query(ctx, &rpc.QueryRequest{Queries: []*rpc.AQLQuery{groupByCity}})
+			Ω(err).Should(BeNil())
+			responses, err := receiveAll(stream)
+			Ω(err).Should(BeNil())
+			Ω(responses).Should(HaveLen(1))
+			Ω(responses[0].Error.GetCode()).Should(Equal(code))
+		}
+	})
+
+	ginkgo.It("rejects calls of tenants exceeding their limits", func() {
+		client := startServer(common.QueryConfig{
+			TenantLimits: common.TenantLimitsConfig{
+				Default: common.TenantLimit{QPS: 0.001, Burst: 1},
+				Tenants: map[string]common.TenantLimit{"finance": {QPS: 0.001, Burst: 1}},
+			},
+		})
+		request := &rpc.QueryRequest{Queries: []*rpc.AQLQuery{groupByCity}}
+		stream, err := client.Query(context.Background(), request)
+		Ω(err).Should(BeNil())
+		_, err = receiveAll(stream)
+		Ω(err).Should(BeNil())
+
+		stream, err = client.Query(context.Background(), request)
+		Ω(err).Should(BeNil())
+		_, err = receiveAll(stream)
+		Ω(status.Code(err)).Should(Equal(codes.ResourceExhausted))
+
+		// the tenant is read from the metadata of the call.
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "RPC-Caller", "finance")
+		stream, err = client.Query(ctx, request)
+		Ω(err).Should(BeNil())
+		_, err = receiveAll(stream)
+		Ω(err).Should(BeNil())
+	})
+
+	ginkgo.It("traces the call under the span propagated in the call metadata", func() {
+		tracer := mocktracer.New()
+		opentracing.SetGlobalTracer(tracer)
+		defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+		parent := tracer.StartSpan("client")
+		carrier := opentracing.TextMapCarrier{}
+		Ω(tracer.Inject(parent.Context(), opentracing.HTTPHeaders, carrier)).Should(BeNil())
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.New(carrier))
+
+		client := startServer(common.QueryConfig{})
+		stream, err := client.Query(ctx, &rpc.QueryRequest{Queries: []*rpc.AQLQuery{groupByCity}})
+		Ω(err).Should(BeNil())
+		_, err = receiveAll(stream)
+		Ω(err).Should(BeNil())
+
+		spans := make(map[string]*mocktracer.MockSpan)
+		for _, span := range tracer.FinishedSpans() {
+			spans[span.OperationName] = span
+		}
+		parentContext := parent.Context().(mocktracer.MockSpanContext)
+		Ω(spans).Should(HaveKey(tracingOperationRequest))
+		Ω(spans[tracingOperationRequest].ParentID).Should(Equal(parentContext.SpanID))
+		Ω(spans[tracingOperationQuery].ParentID).Should(Equal(spans[tracingOperationRequest].SpanContext.SpanID))
+	})
+
+	ginkgo.It("converts queries of the request", func() {
+		aqlQuery := fromRPCQuery(&rpc.AQLQuery{
+			Table:      "trips",
+			Tables:     []string{"trips_1", "trips_2"},
+			Select:     []string{"city_id"},
+			Joins:      []*rpc.Join{{Table: "cities", Alias: "c", Conditions: []string{"c.id = city_id"}}},
+			Dimensions: []*rpc.Dimension{{SqlExpression: "fare", NumericBucketizer: &rpc.NumericBucketizer{BucketWidth: 10}, Fill: "zero"}},
+			Measures:   []*rpc.Measure{{SqlExpression: "sum(fare)", RowFilters: []string{"status = 'completed'"}, Running: "moving_average", Window: 3}},
+			RowFilters: []string{"city_id = 1"},
+			FilterTree: &rpc.FilterNode{Or: []*rpc.FilterNode{
+				{SqlExpression: "city_id = 1"},
+				{Not: &rpc.FilterNode{SqlExpression: "city_id = 2"}},
+			}},
+			Sorts:       []*rpc.SortField{{SqlExpression: "sum(fare)", Desc: true}},
+			Limit:       5,
+			Paginate:    true,
+			Cursor:      "cursor",
+			TimeFilter:  &rpc.TimeFilter{From: "-1d"},
+			Consistency: "archiveOnly",
+		})
+		Ω(aqlQuery).Should(Equal(query.AQLQuery{
+			Table:      "trips",
+			Tables:     []string{"trips_1", "trips_2"},
+			Select:     []string{"city_id"},
+			Joins:      []query.Join{{Table: "cities", Alias: "c", Conditions: []string{"c.id = city_id"}}},
+			Dimensions: []query.Dimension{{Expr: "fare", NumericBucketizer: query.NumericBucketizerDef{BucketWidth: 10}, Fill: "zero"}},
+			Measures:   []query.Measure{{Expr: "sum(fare)", Filters: []string{"status = 'completed'"}, Running: "moving_average", Window: 3}},
+			Filters:    []string{"city_id = 1"},
+			FilterTree: &query.FilterNode{Or: []query.FilterNode{
+				{Expr: "city_id = 1"},
+				{Not: &query.FilterNode{Expr: "city_id = 2"}},
+			}},
+			Sorts:       []query.SortField{{Expr: "sum(fare)", Desc: true}},
+			Limit:       5,
+			Paginate:    true,
+			Cursor:      "cursor",
+			TimeFilter:  query.TimeFilter{From: "-1d"},
+			Consistency: "archiveOnly",
+		}))
+	})
+
+	ginkgo.It("encodes nested results by column in chunks", func() {
+		stream := &sentResponses{}
+		rw := newGRPCQueryResponseWriter(stream, []query.AQLQuery{{
+			Dimensions: []query.Dimension{{Expr: "city_id"}, {Expr: "status"}},
+			Measures:   []query.Measure{{Expr: "count(*)"}},
+		}, {
+			Dimensions: []query.Dimension{{Expr: "city_id"}},
+			Measures:   []query.Measure{{Expr: "count(*)"}, {Expr: "sum(fare)"}},
+		}, {
+			Select: []string{"city_id", "status"},
+		}})
+		rw.ReportCachedResult(0, queryCom.AQLTimeSeriesResult{
+			"2":    map[string]interface{}{"NULL": 1.0},
+			"NULL": map[string]interface{}{"a": nil, "b": 3.0},
+		})
+		rw.ReportCachedResult(1, queryCom.AQLTimeSeriesResult{
+			"1": map[string]interface{}{"0": 2.0, "1": nil},
+		})
+		rw.ReportCachedResult(2, queryCom.AQLTimeSeriesResult{
+			query.RowResultMatrixData: [][]interface{}{{1.0, "a"}, {nil, "b"}},
+		})
+
+		Ω(stream.responses).Should(HaveLen(3))
+		Ω(stream.responses[0].ColumnNames).Should(Equal([]string{"city_id", "status", "count(*)"}))
+		Ω(stream.responses[0].Columns).Should(Equal([]*rpc.Column{
+			{StringValues: []string{"2", "", ""}, Nulls: []bool{false, true, true}},
+			{StringValues: []string{"", "a", "b"}, Nulls: []bool{true, false, false}},
+			{DoubleValues: []float64{1, 0, 3}, Nulls: []bool{false, true, false}},
+		}))
+		Ω(stream.responses[1].Columns).Should(Equal([]*rpc.Column{
+			{StringValues: []string{"1"}, Nulls: []bool{false}},
+			{DoubleValues: []float64{2}, Nulls: []bool{false}},
+			{DoubleValues: []float64{0}, Nulls: []bool{true}},
+		}))
+		Ω(stream.responses[2].ColumnNames).Should(Equal([]string{"city_id", "status"}))
+		Ω(stream.responses[2].Columns).Should(Equal([]*rpc.Column{
+			{StringValues: []string{"1", ""}, Nulls: []bool{false, true}},
+			{StringValues: []string{"a", "b"}, Nulls: []bool{false, false}},
+		}))
+		for _, response := range stream.responses {
+			Ω(response.Done).Should(BeTrue())
+		}
+
+		// large results are split into responses of streamingFlushRows rows.
+		stream.responses = nil
+		result := queryCom.AQLTimeSeriesResult{}
+		for i := 0; i < 2500; i++ {
+			result[fmt.Sprintf("%04d", i)] = map[string]interface{}{"a": float64(i)}
+		}
+		rw.ReportCachedResult(0, result)
+		Ω(stream.responses).Should(HaveLen(3))
+		for i, response := range stream.responses {
+			Ω(response.QueryIndex).Should(BeEquivalentTo(0))
+			Ω(response.Done).Should(Equal(i == 2))
+			Ω(response.ColumnNames == nil).Should(Equal(i > 0))
+		}
+		Ω(stream.responses[1].Columns[0].StringValues[0]).Should(Equal("1000"))
+		Ω(stream.responses[2].Columns[2].DoubleValues).Should(HaveLen(500))
+	})
+})
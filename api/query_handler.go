@@ -15,8 +15,14 @@
 package api
 
 import (
+	"bufio"
+	"context"
+	"crypto/rand"
 	"encoding/json"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/uber/aresdb/memstore"
 	"github.com/uber/aresdb/query"
@@ -26,23 +32,95 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
 	"github.com/uber/aresdb/common"
+	"golang.org/x/net/websocket"
+)
+
+// Operation names of the spans tracing queries, executing a query on device is traced by the
+// query package.
+const (
+	tracingOperationRequest   = "query_request"
+	tracingOperationQuery     = "query"
+	tracingOperationCompile   = "compile"
+	tracingOperationSerialize = "serialize"
 )
 
 // QueryHandler handles query execution.
 type QueryHandler struct {
 	memStore     memstore.MemStore
 	deviceManger *query.DeviceManager
+	// max duration of processing a query, 0 means no limit.
+	maxQueryDuration time.Duration
+	// how long cursors of paginated queries can be used.
+	cursorTTL time.Duration
+	// key signing cursors of paginated queries.
+	cursorKey []byte
+	// default limits of queries, overridden by the limits of tenants.
+	queryLimits query.QueryLimits
+
+	// prepared queries by name, kept in memory only.
+	preparedQueries *preparedQueryCache
+
+	tenantLimiter *tenantLimiter
+
+	// results of queries over immutable time ranges, nil if disabled.
+	resultCache *queryResultCache
+
+	slowQueryLog *slowQueryLog
+
+	// min and default interval between two pushes of query subscriptions.
+	subscriptionPushInterval time.Duration
+	// max number of messages buffered for a query subscriber.
+	subscriptionBufferSize int
 }
 
 // NewQueryHandler creates a new QueryHandler.
 func NewQueryHandler(memStore memstore.MemStore, cfg common.QueryConfig) *QueryHandler {
+	subscriptionPushInterval := time.Duration(cfg.SubscriptionPushInterval) * time.Millisecond
+	if subscriptionPushInterval <= 0 {
+		subscriptionPushInterval = defaultSubscriptionPushInterval
+	}
+	subscriptionBufferSize := cfg.SubscriptionBufferSize
+	if subscriptionBufferSize <= 0 {
+		subscriptionBufferSize = defaultSubscriptionBufferSize
+	}
+	if err := validateTenantLimitsConfig(cfg.TenantLimits); err != nil {
+		utils.GetLogger().With("error", err).Error("Invalid tenant limits config")
+	}
 	return &QueryHandler{
-		memStore:     memStore,
-		deviceManger: query.NewDeviceManager(cfg),
+		memStore:         memStore,
+		deviceManger:     query.NewDeviceManager(cfg),
+		maxQueryDuration: time.Duration(cfg.MaxQueryDuration) * time.Second,
+		cursorTTL:        time.Duration(cfg.CursorTTL) * time.Second,
+		cursorKey:        newCursorKey(cfg.CursorKey),
+		preparedQueries:  newPreparedQueryCache(cfg.MaxPreparedQueries),
+		tenantLimiter:    newTenantLimiter(cfg.TenantLimits),
+		resultCache:      newQueryResultCache(cfg.ResultCacheSize, time.Duration(cfg.ResultCacheTTL)*time.Second),
+		slowQueryLog:     newSlowQueryLog(time.Duration(cfg.SlowQueryThreshold) * time.Millisecond),
+		queryLimits: query.QueryLimits{
+			MaxRowsScanned:      cfg.MaxRowsScanned,
+			MaxResultRows:       cfg.MaxResultRows,
+			MaxJoinTableRecords: cfg.MaxJoinTableRecords,
+		},
+		subscriptionPushInterval: subscriptionPushInterval,
+		subscriptionBufferSize:   subscriptionBufferSize,
 	}
 }
 
+// newCursorKey returns the configured key signing cursors, or a random key if not configured.
+func newCursorKey(configured string) []byte {
+	if configured != "" {
+		return []byte(configured)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		utils.GetLogger().With("error", err).Fatal("Failed to generate query cursor key")
+	}
+	return key
+}
+
 // GetDeviceManager returns the device manager of query handler.
 func (handler *QueryHandler) GetDeviceManager() *query.DeviceManager {
 	return handler.deviceManger
@@ -50,7 +128,11 @@ func (handler *QueryHandler) GetDeviceManager() *query.DeviceManager {
 
 // Register registers http handlers.
 func (handler *QueryHandler) Register(router *mux.Router, wrappers ...utils.HTTPHandlerWrapper) {
-	router.HandleFunc("/aql", utils.ApplyHTTPWrappers(handler.HandleAQL, wrappers)).Methods(http.MethodGet, http.MethodPost)
+	router.HandleFunc("/aql", utils.ApplyHTTPWrappers(handler.tenantLimiter.Wrap(handler.HandleAQL), wrappers)).Methods(http.MethodGet, http.MethodPost)
+	router.HandleFunc("/prepared/{name}", utils.ApplyHTTPWrappers(handler.PrepareQuery, wrappers)).Methods(http.MethodPut)
+	router.HandleFunc("/prepared/{name}", utils.ApplyHTTPWrappers(handler.tenantLimiter.Wrap(handler.ExecutePreparedQuery), wrappers)).Methods(http.MethodPost)
+	// Subscriptions are long lived so they are not counted as concurrent queries of the tenant.
+	router.HandleFunc("/subscribe", utils.ApplyHTTPWrappers(handler.Subscribe, wrappers)).Methods(http.MethodGet)
 }
 
 // HandleAQL swagger:route POST /query/aql queryAQL
@@ -128,25 +210,334 @@ func (handler *QueryHandler) HandleAQL(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	returnHLL := aqlRequest.Accept == ContentTypeHyperLogLog
-	requestResponseWriter := getReponseWriter(returnHLL, len(aqlRequest.Body.Queries))
+	qcs, duration, statusCode = handler.executeQueries(w, r, aqlRequest)
+}
+
+// PrepareQuery swagger:route PUT /query/prepared/{name} prepareQuery
+// registers an AQL query template with parameters like $city_id in its row filters,
+// registering under an existing name replaces the previous query. Prepared queries are
+// only kept in memory of the server, they are lost on restart and the least recently used
+// ones are evicted beyond max_prepared_queries, so clients should prepare the query again
+// when executing it returns 404.
+//
+// Consumes:
+//    - application/json
+//
+// Produces:
+//    - application/json
+//
+// Responses:
+//    default: errorResponse
+//        200: preparedQueryResponse
+//        400: errorResponse
+func (handler *QueryHandler) PrepareQuery(w http.ResponseWriter, r *http.Request) {
+	var prepareRequest PrepareQueryRequest
+	if err := ReadRequest(r, &prepareRequest); err != nil {
+		RespondWithBadRequest(w, err)
+		return
+	}
+
+	preparedQuery, err := prepareRequest.Body.Prepare()
+	if err != nil {
+		RespondWithBadRequest(w, err)
+		return
+	}
+
+	handler.preparedQueries.put(prepareRequest.Name, preparedQuery)
+	RespondWithJSONObject(w, preparedQuery)
+}
+
+// ExecutePreparedQuery swagger:route POST /query/prepared/{name} executePreparedQuery
+// executes a prepared query with parameter values bound.
+//
+// Consumes:
+//    - application/json
+//
+// Produces:
+//    - application/json
+//
+// Responses:
+//    default: errorResponse
+//        200: aqlResponse
+//        400: aqlResponse
+//        404: errorResponse
+func (handler *QueryHandler) ExecutePreparedQuery(w http.ResponseWriter, r *http.Request) {
+	executeRequest := ExecutePreparedQueryRequest{Device: -1}
+	var err error
+	var duration time.Duration
+	var qcs []*query.AQLQueryContext
+	var statusCode int
+
+	defer func() {
+		var errStr string
+		if err != nil {
+			errStr = err.Error()
+		}
+
+		l := utils.GetQueryLogger().With(
+			"error", errStr,
+			"request", executeRequest,
+			"duration", duration,
+			"statusCode", statusCode,
+			"contexts_enabled_", qcs,
+			"headers", r.Header,
+		)
+
+		if statusCode == http.StatusOK {
+			l.Info("Prepared query succeeded")
+		} else {
+			l.Error("Prepared query finished with error")
+		}
+	}()
 
+	if err = ReadRequest(r, &executeRequest); err != nil {
+		statusCode = http.StatusBadRequest
+		RespondWithBadRequest(w, err)
+		return
+	}
+
+	preparedQuery := handler.preparedQueries.get(executeRequest.Name)
+	if preparedQuery == nil {
+		statusCode = http.StatusNotFound
+		RespondWithError(w, utils.APIError{
+			Code:    http.StatusNotFound,
+			Message: "Prepared query does not exist",
+		})
+		return
+	}
+
+	var boundQuery *query.AQLQuery
+	if boundQuery, err = preparedQuery.Bind(handler.memStore, executeRequest.Body.Parameters); err != nil {
+		statusCode = http.StatusBadRequest
+		RespondWithBadRequest(w, err)
+		return
+	}
+
+	qcs, duration, statusCode = handler.executeQueries(w, r, AQLRequest{
+		Device:                executeRequest.Device,
+		Verbose:               executeRequest.Verbose,
+		DeviceChoosingTimeout: executeRequest.DeviceChoosingTimeout,
+		Body:                  query.AQLRequest{Queries: []query.AQLQuery{*boundQuery}},
+	})
+}
+
+// Subscribe swagger:route GET /query/subscribe subscribeAQL
+// subscribes to the result of an AQL query over websocket. The query is executed at the push
+// interval, the full result is pushed first, followed by the groups changed as new data is
+// ingested. Subscribers not reading fast enough are disconnected. Tenants with as many open
+// subscriptions as their max_subscriptions limit are rejected with 429.
+//
+// Produces:
+//    - application/json
+//
+// Responses:
+//    default: errorResponse
+//        101: querySubscriptionMessage
+//        400: errorResponse
+//        403: errorResponse
+//        429: errorResponse
+func (handler *QueryHandler) Subscribe(w http.ResponseWriter, r *http.Request) {
+	subscribeRequest := SubscribeQueryRequest{Device: -1}
+	if err := ReadRequest(r, &subscribeRequest); err != nil {
+		RespondWithBadRequest(w, err)
+		return
+	}
+
+	var aqlQuery query.AQLQuery
+	if err := json.Unmarshal([]byte(subscribeRequest.Query), &aqlQuery); err != nil {
+		RespondWithBadRequest(w, utils.APIError{
+			Code:    http.StatusBadRequest,
+			Message: ErrMsgFailedToUnmarshalRequest,
+			Cause:   err,
+		})
+		return
+	}
+
+	// Reject invalid queries before upgrading the connection.
+	tenant := handler.tenantLimiter.tenant(r)
+	aqlQuery.CursorKey = handler.cursorKey
+	qc := aqlQuery.Compile(handler.memStore, false)
+	if qc.TableNotFound() {
+		RespondWithError(w, utils.APIError{
+			Code:    http.StatusNotFound,
+			Message: qc.Error.Error(),
+		})
+		return
+	}
+	if qc.Error != nil {
+		RespondWithBadRequest(w, qc.Error)
+		return
+	}
+	if err := qc.CheckColumnAccess(tenant); err != nil {
+		RespondWithError(w, utils.APIError{
+			Code:    http.StatusForbidden,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	usage, limitErr := handler.tenantLimiter.acquireSubscription(tenant)
+	if limitErr != nil {
+		utils.GetRootReporter().GetChildCounter(map[string]string{"tenant": limitErr.bucket}, utils.TenantThrottledQueries).Inc(1)
+		w.Header().Set("Retry-After", strconv.Itoa(limitErr.retryAfter))
+		RespondWithError(w, limitErr.APIError)
+		return
+	}
+	defer handler.tenantLimiter.releaseSubscription(usage)
+
+	interval := time.Duration(subscribeRequest.PushInterval) * time.Millisecond
+	if interval < handler.subscriptionPushInterval {
+		interval = handler.subscriptionPushInterval
+	}
+	limits := handler.tenantLimiter.queryLimits(r, handler.queryLimits)
+	subscription := newQuerySubscription(interval, handler.subscriptionBufferSize,
+		func(ctx context.Context) (queryCom.AQLTimeSeriesResult, int, error) {
+			responseWriter := NewJSONQueryResponseWriter(1).(*JSONQueryResponseWriter)
+			handler.handleQuery(ctx, AQLRequest{
+				Device: subscribeRequest.Device,
+				Body:   query.AQLRequest{Queries: []query.AQLQuery{aqlQuery}},
+			}, 0, tenant, limits, responseWriter)
+			if responseWriter.response.Errors != nil && responseWriter.response.Errors[0] != nil {
+				return nil, responseWriter.statusCode, responseWriter.response.Errors[0]
+			}
+			return responseWriter.response.Results[0], http.StatusOK, nil
+		})
+	// Origins are not checked, same as other endpoints.
+	websocket.Server{Handler: subscription.serve}.ServeHTTP(w, r)
+}
+
+// executeQueries executes the queries of the request and writes their results into the response.
+func (handler *QueryHandler) executeQueries(w http.ResponseWriter, r *http.Request, aqlRequest AQLRequest) (
+	qcs []*query.AQLQueryContext, duration time.Duration, statusCode int) {
+	if aqlRequest.Explain > 0 {
+		return handler.explainQueries(w, r, aqlRequest)
+	}
+
+	returnCSV := aqlRequest.Accept == ContentTypeCSV || aqlRequest.Format == "csv"
+	if returnCSV && len(aqlRequest.Body.Queries) != 1 {
+		statusCode = http.StatusBadRequest
+		RespondWithBadRequest(w, utils.APIError{
+			Code:    http.StatusBadRequest,
+			Message: ErrMsgMissingParameter,
+			Cause:   utils.StackError(nil, "csv format requires exactly one query"),
+		})
+		return
+	}
+
+	var requestResponseWriter QueryResponseWriter
+	if returnCSV {
+		requestResponseWriter = NewCSVQueryResponseWriter(w, aqlRequest.Body.Queries[0])
+	} else {
+		requestResponseWriter = getReponseWriter(w, aqlRequest.Accept == ContentTypeHyperLogLog, len(aqlRequest.Body.Queries))
+	}
+
+	span, ctx := utils.StartSpanFromRequest(r, tracingOperationRequest)
+	defer span.Finish()
+
+	limits := handler.tenantLimiter.queryLimits(r, handler.queryLimits)
+	tenant := handler.tenantLimiter.tenant(r)
 	queryTimer := utils.GetRootReporter().GetTimer(utils.QueryLatency)
 	start := utils.Now()
+	logSlowQueries := handler.slowQueryLog.Threshold() > 0
 	for i := range aqlRequest.Body.Queries {
-		qcs = append(qcs, handler.handleQuery(aqlRequest, i, requestResponseWriter))
+		var normalizedQuery []byte
+		if logSlowQueries {
+			normalizedQuery = normalizeQuery(aqlRequest.Body.Queries[i])
+		}
+		queryStart := utils.Now()
+		// queries are cancelled once the client disconnects.
+		qc := handler.handleQuery(ctx, aqlRequest, i, tenant, limits, requestResponseWriter)
+		if logSlowQueries {
+			handler.slowQueryLog.log(qc, normalizedQuery, tenant, utils.Now().Sub(queryStart))
+		}
+		qcs = append(qcs, qc)
 	}
 	duration = utils.Now().Sub(start)
 	queryTimer.Record(duration)
 	requestResponseWriter.Respond(w)
 	statusCode = requestResponseWriter.GetStatusCode()
+	return
+}
+
+// explainQueries compiles the queries of the request and writes their plans into the response
+// without executing them. Queries are checked against the column access and limits of the tenant
+// the same way as executed queries.
+func (handler *QueryHandler) explainQueries(w http.ResponseWriter, r *http.Request, aqlRequest AQLRequest) (
+	qcs []*query.AQLQueryContext, duration time.Duration, statusCode int) {
+	start := utils.Now()
+	statusCode = http.StatusOK
+	response := query.AQLExplainResponse{
+		Plans: make([]*query.QueryPlan, len(aqlRequest.Body.Queries)),
+	}
+	limits := handler.tenantLimiter.queryLimits(r, handler.queryLimits)
+	tenant := handler.tenantLimiter.tenant(r)
+	for i, aqlQuery := range aqlRequest.Body.Queries {
+		aqlQuery.CursorKey = handler.cursorKey
+		qc := aqlQuery.Compile(handler.memStore, aqlRequest.Accept == ContentTypeHyperLogLog)
+		qcs = append(qcs, qc)
+		err, errorCode := qc.Error, http.StatusBadRequest
+		switch {
+		case qc.TableNotFound():
+			errorCode = http.StatusNotFound
+		case err != nil:
+		default:
+			if err = qc.CheckColumnAccess(tenant); err != nil {
+				errorCode = http.StatusForbidden
+				break
+			}
+			qc.Limits = limits
+			response.Plans[i] = qc.Explain(handler.memStore)
+			err, errorCode = qc.Error, http.StatusInternalServerError
+		}
+		if err != nil {
+			if response.Errors == nil {
+				response.Errors = make([]string, len(aqlRequest.Body.Queries))
+			}
+			response.Errors[i] = err.Error()
+			// stack traces are left out of the messages.
+			if stackedErr, ok := err.(*utils.StackedError); ok {
+				response.Errors[i] = stackedErr.Message()
+			}
+			if errorCode > statusCode {
+				statusCode = errorCode
+			}
+		}
+	}
+	duration = utils.Now().Sub(start)
+	RespondJSONObjectWithCode(w, statusCode, response)
+	return
 }
 
-func (handler *QueryHandler) handleQuery(request AQLRequest, index int, responseWriter QueryResponseWriter) (qc *query.AQLQueryContext) {
+func (handler *QueryHandler) handleQuery(ctx context.Context, request AQLRequest, index int, tenant string,
+	limits query.QueryLimits, responseWriter QueryResponseWriter) (qc *query.AQLQueryContext) {
 	returnHLL := request.Accept == ContentTypeHyperLogLog
 
 	query := request.Body.Queries[index]
+	// union tables are reported together in metrics and errors.
+	table := query.Table
+	if len(query.Tables) > 0 {
+		table = strings.Join(query.Tables, ",")
+	}
+	span, ctx := opentracing.StartSpanFromContext(ctx, tracingOperationQuery)
+	ext.Component.Set(span, utils.TracingComponent)
+	span.SetTag("table", table)
+	span.SetTag("query_index", index)
+	defer func() {
+		if qc.Error != nil {
+			ext.Error.Set(span, true)
+			span.LogKV("error", qc.Error.Error())
+		}
+		span.Finish()
+	}()
+
+	var normalizedQuery []byte
+	if handler.resultCache != nil {
+		normalizedQuery = normalizeQuery(query)
+	}
+	compileSpan := opentracing.StartSpan(tracingOperationCompile, opentracing.ChildOf(span.Context()))
+	query.CursorKey = handler.cursorKey
 	qc = query.Compile(handler.memStore, returnHLL)
+	compileSpan.Finish()
 
 	for tableName := range qc.TableSchemaByName {
 		utils.GetRootReporter().GetChildCounter(map[string]string{
@@ -161,13 +552,55 @@ func (handler *QueryHandler) handleQuery(request AQLRequest, index int, response
 		qc.Debug = true
 	}
 	qc.Profiling = request.Profiling
+	if request.Profile > 0 {
+		qc.Profile = newQueryProfile()
+	}
+
+	// Queries over tables that do not exist or have been dropped are not found.
+	if qc.TableNotFound() {
+		responseWriter.ReportError(index, table, qc.Error, http.StatusNotFound)
+		return
+	}
 
 	// Compilation error, should be bad request
 	if qc.Error != nil {
-		responseWriter.ReportError(index, query.Table, qc.Error, http.StatusBadRequest)
+		responseWriter.ReportError(index, table, qc.Error, http.StatusBadRequest)
 		return
 	}
 
+	// Columns restricted to other tenants can not be referenced anywhere in the query.
+	if err := qc.CheckColumnAccess(tenant); err != nil {
+		responseWriter.ReportError(index, table, err, http.StatusForbidden)
+		return
+	}
+
+	// Serve queries over immutable time ranges from the result cache.
+	var cacheKey string
+	var schemaVersion int
+	// Paginated queries are not cached since each page comes with the cursor of the next page.
+	if handler.resultCache != nil && !qc.Debug && request.Profile == 0 && !query.Paginate && query.Cursor == "" {
+		if from, to, ok := qc.ImmutableTimeRange(handler.memStore, utils.Now()); ok {
+			schema := qc.TableScanners[0].Schema
+			schema.RLock()
+			schemaVersion = schema.Schema.Version
+			cacheKey = queryResultCacheKey(normalizedQuery, from, to, schema.DeletePredicates)
+			schema.RUnlock()
+			if result, found := handler.resultCache.get(cacheKey, table, schemaVersion, utils.Now()); found {
+				utils.GetRootReporter().GetChildCounter(map[string]string{
+					"table": table,
+				}, utils.QueryCacheHits).Inc(1)
+				responseWriter.ReportCachedResult(index, result)
+				utils.GetRootReporter().GetChildCounter(map[string]string{
+					"table": table,
+				}, utils.QuerySucceeded).Inc(1)
+				return
+			}
+			utils.GetRootReporter().GetChildCounter(map[string]string{
+				"table": table,
+			}, utils.QueryCacheMisses).Inc(1)
+		}
+	}
+
 	deviceChoosingTimeout := -1
 	if request.DeviceChoosingTimeout > 0 {
 		deviceChoosingTimeout = request.DeviceChoosingTimeout
@@ -175,42 +608,115 @@ func (handler *QueryHandler) handleQuery(request AQLRequest, index int, response
 	// Find a device that meets the resource requirement of this query
 	// Use query specified device as hint
 	qc.FindDeviceForQuery(handler.memStore, request.Device, handler.deviceManger, int(deviceChoosingTimeout))
+	if qc.LimitExceeded() {
+		// the query is estimated to use more device memory than allowed.
+		utils.GetRootReporter().GetChildCounter(map[string]string{
+			"table": table,
+		}, utils.QueryLimitExceeded).Inc(1)
+		responseWriter.ReportError(index, table, qc.Error, http.StatusUnprocessableEntity)
+		return
+	}
 	// Unable to find a device for the query.
 	if qc.Error != nil {
 		// Unable to fulfill this request due to resource not available, clients need to try sometimes later.
-		responseWriter.ReportError(index, query.Table, qc.Error, http.StatusServiceUnavailable)
+		responseWriter.ReportError(index, table, qc.Error, http.StatusServiceUnavailable)
 		return
 	}
 	defer handler.deviceManger.ReleaseReservedMemory(qc.Device, qc.Query)
+
+	if handler.maxQueryDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, handler.maxQueryDuration)
+		defer cancel()
+	}
+	qc.Context = ctx
+	qc.Limits = limits
+	qc.CursorTTL = handler.cursorTTL
+
 	// Execute.
 	qc.ProcessQuery(handler.memStore)
-	if qc.Error != nil {
+	if qc.LimitExceeded() {
+		// the query is too expensive to be served, retrying it won't help.
+		utils.GetRootReporter().GetChildCounter(map[string]string{
+			"table": table,
+		}, utils.QueryLimitExceeded).Inc(1)
+		responseWriter.ReportError(index, table, qc.Error, http.StatusUnprocessableEntity)
+		if request.Profile > 0 {
+			reportQueryProfile(qc, index, 0, responseWriter)
+		}
+	} else if qc.Error != nil {
 		utils.GetQueryLogger().With(
 			"error", qc.Error,
 			"request", request,
 			"context", qc,
 		).Error("Error happened when processing query")
-		responseWriter.ReportError(index, query.Table, qc.Error, http.StatusInternalServerError)
+		responseWriter.ReportError(index, table, qc.Error, http.StatusInternalServerError)
 	} else {
 		// Postprocess
 		utils.GetRootReporter().GetChildCounter(map[string]string{
-			"table": query.Table,
+			"table": table,
 		}, utils.QueryRowsReturned).Inc(int64(qc.OOPK.ResultSize))
 
+		start := utils.Now()
+		serializeSpan := opentracing.StartSpan(tracingOperationSerialize, opentracing.ChildOf(span.Context()))
+		if cacheKey != "" {
+			// the result to cache is built instead of streamed from the result buffers.
+			postprocess(qc)
+		}
 		responseWriter.ReportResult(index, qc)
+		serializeSpan.Finish()
+		if request.Profile > 0 {
+			reportQueryProfile(qc, index, utils.Now().Sub(start), responseWriter)
+		} else if qc.Profile != nil {
+			recordSerializeDuration(qc, utils.Now().Sub(start))
+		}
+		if cacheKey != "" && qc.Error == nil {
+			handler.resultCache.put(cacheKey, table, schemaVersion, qc.Results, utils.Now())
+		}
 		qc.ReleaseHostResultsBuffers()
 		utils.GetRootReporter().GetChildCounter(map[string]string{
-			"table": query.Table,
+			"table": table,
 		}, utils.QuerySucceeded).Inc(1)
 	}
 	return
 }
 
-func getReponseWriter(returnHLL bool, nQueries int) QueryResponseWriter {
+// postprocess builds the result of the query unless it was already built.
+func postprocess(qc *query.AQLQueryContext) queryCom.AQLTimeSeriesResult {
+	if qc.Results == nil {
+		qc.Results = qc.Postprocess()
+	}
+	return qc.Results
+}
+
+// newQueryProfile creates a query profile, for handleQuery where the query package is shadowed.
+func newQueryProfile() *query.QueryProfile {
+	return query.NewQueryProfile()
+}
+
+// recordSerializeDuration records the time spent serializing the result of the query in its profile.
+func recordSerializeDuration(qc *query.AQLQueryContext, duration time.Duration) {
+	qc.Profile.Record(query.ProfileStageSerialize, duration)
+}
+
+// reportQueryProfile completes the profile of the query with the time spent serializing its result,
+// emits it to metrics and writes it to the response.
+func reportQueryProfile(qc *query.AQLQueryContext, index int, serializeDuration time.Duration, responseWriter QueryResponseWriter) {
+	recordSerializeDuration(qc, serializeDuration)
+	qc.Profile.Report(qc.Query.Table)
+	responseWriter.ReportProfile(index, qc.Profile)
+}
+
+func getReponseWriter(w http.ResponseWriter, returnHLL bool, nQueries int) QueryResponseWriter {
 	if returnHLL {
 		return NewHLLQueryResponseWriter()
 	}
-	return NewJSONQueryResponseWriter(nQueries)
+	// the status code of requests of multiple queries reflects the errors of all queries, so their
+	// response cannot be started before the last query is done.
+	if nQueries > 1 {
+		return NewJSONQueryResponseWriter(nQueries)
+	}
+	return NewStreamingJSONQueryResponseWriter(w, nQueries)
 }
 
 // QueryResponseWriter defines the interface to write query result and error to final response.
@@ -218,6 +724,8 @@ type QueryResponseWriter interface {
 	ReportError(queryIndex int, table string, err error, statusCode int)
 	ReportQueryContext(*query.AQLQueryContext)
 	ReportResult(int, *query.AQLQueryContext)
+	ReportCachedResult(int, queryCom.AQLTimeSeriesResult)
+	ReportProfile(int, *query.QueryProfile)
 	Respond(w http.ResponseWriter)
 	GetStatusCode() int
 }
@@ -260,11 +768,30 @@ func (w *JSONQueryResponseWriter) ReportQueryContext(qc *query.AQLQueryContext)
 
 // ReportResult writes the query result to the response.
 func (w *JSONQueryResponseWriter) ReportResult(queryIndex int, qc *query.AQLQueryContext) {
-	qc.Results = qc.Postprocess()
+	postprocess(qc)
 	if qc.Error != nil {
 		w.ReportError(queryIndex, qc.Query.Table, qc.Error, http.StatusInternalServerError)
 	}
 	w.response.Results[queryIndex] = qc.Results
+	if qc.NextCursor != "" {
+		if w.response.Cursors == nil {
+			w.response.Cursors = make([]string, len(w.response.Results))
+		}
+		w.response.Cursors[queryIndex] = qc.NextCursor
+	}
+}
+
+// ReportCachedResult writes the cached query result to the response.
+func (w *JSONQueryResponseWriter) ReportCachedResult(queryIndex int, result queryCom.AQLTimeSeriesResult) {
+	w.response.Results[queryIndex] = result
+}
+
+// ReportProfile writes the profile of the query to the response.
+func (w *JSONQueryResponseWriter) ReportProfile(queryIndex int, profile *query.QueryProfile) {
+	if w.response.Profiles == nil {
+		w.response.Profiles = make([]*query.QueryProfile, len(w.response.Results))
+	}
+	w.response.Profiles[queryIndex] = profile
 }
 
 // Respond writes the final response into ResponseWriter.
@@ -277,6 +804,251 @@ func (w *JSONQueryResponseWriter) GetStatusCode() int {
 	return w.statusCode
 }
 
+const (
+	// streamingBufferSize is the size of the buffer between the streaming writer and the http response.
+	streamingBufferSize = 32 * 1024
+	// streamingFlushRows is the number of result rows written between two flushes of the http response.
+	streamingFlushRows = 1000
+)
+
+// StreamingJSONQueryResponseWriter writes query results as json into the http response as soon as each query
+// finishes, instead of marshaling the whole response in memory. The response envelope is the same as
+// JSONQueryResponseWriter. Groups of queries without steps after aggregation are written as they are read from
+// the result buffers, without building the nested result. The status code is written together with the first
+// result, errors reported after that will only be reflected in the errors field of the response, so it is only
+// used for requests of a single query.
+type StreamingJSONQueryResponseWriter struct {
+	rw       http.ResponseWriter
+	bw       *bufio.Writer
+	nQueries int
+	// number of results written, the results of failed queries are written as null.
+	nResults   int
+	rows       int
+	errors     []error
+	contexts   []*query.AQLQueryContext
+	profiles   []*query.QueryProfile
+	cursors    []string
+	statusCode int
+}
+
+// NewStreamingJSONQueryResponseWriter creates a new StreamingJSONQueryResponseWriter writing into rw.
+func NewStreamingJSONQueryResponseWriter(rw http.ResponseWriter, nQueries int) QueryResponseWriter {
+	return &StreamingJSONQueryResponseWriter{
+		rw:         rw,
+		nQueries:   nQueries,
+		statusCode: http.StatusOK,
+	}
+}
+
+// ReportError writes the error of the query to the response.
+func (w *StreamingJSONQueryResponseWriter) ReportError(queryIndex int, table string, err error, statusCode int) {
+	// Status code cannot be changed once the response is started.
+	if w.bw == nil && statusCode > w.statusCode {
+		w.statusCode = statusCode
+	}
+	if w.errors == nil {
+		w.errors = make([]error, w.nQueries)
+	}
+	w.errors[queryIndex] = err
+	utils.GetRootReporter().GetChildCounter(map[string]string{
+		"table": table,
+	}, utils.QueryFailed).Inc(1)
+}
+
+// ReportQueryContext writes the query context to the response.
+func (w *StreamingJSONQueryResponseWriter) ReportQueryContext(qc *query.AQLQueryContext) {
+	w.contexts = append(w.contexts, qc)
+}
+
+// ReportResult writes the query result to the response.
+func (w *StreamingJSONQueryResponseWriter) ReportResult(queryIndex int, qc *query.AQLQueryContext) {
+	if qc.Results == nil && qc.StreamsGroups() {
+		w.writeGroups(queryIndex, qc.StreamGroups)
+		return
+	}
+	postprocess(qc)
+	if qc.Error != nil {
+		w.ReportError(queryIndex, qc.Query.Table, qc.Error, http.StatusInternalServerError)
+		return
+	}
+	if qc.NextCursor != "" {
+		if w.cursors == nil {
+			w.cursors = make([]string, w.nQueries)
+		}
+		w.cursors[queryIndex] = qc.NextCursor
+	}
+	w.ReportCachedResult(queryIndex, qc.Results)
+}
+
+// ReportCachedResult writes the cached query result to the response.
+func (w *StreamingJSONQueryResponseWriter) ReportCachedResult(queryIndex int, result queryCom.AQLTimeSeriesResult) {
+	w.start()
+	w.writeNullResults(queryIndex)
+	if w.nResults > 0 {
+		w.bw.WriteByte(',')
+	}
+	w.writeValue(map[string]interface{}(result))
+	w.nResults++
+	w.flush()
+}
+
+// writeGroups writes the result of the query with its groups visited one at a time in the order of
+// their dimension values, without building the nested result.
+func (w *StreamingJSONQueryResponseWriter) writeGroups(queryIndex int, streamGroups func(visit func(dimValues []string, measureValue *float64))) {
+	w.start()
+	w.writeNullResults(queryIndex)
+	if w.nResults > 0 {
+		w.bw.WriteByte(',')
+	}
+	w.bw.WriteByte('{')
+	var previous []string
+	streamGroups(func(dimValues []string, measureValue *float64) {
+		// number of outer dimension layers shared with the previous group.
+		shared := 0
+		if previous != nil {
+			for shared < len(dimValues)-1 && dimValues[shared] == previous[shared] {
+				shared++
+			}
+			for i := shared; i < len(dimValues)-1; i++ {
+				w.bw.WriteByte('}')
+			}
+			w.bw.WriteByte(',')
+		}
+		for i := shared; i < len(dimValues)-1; i++ {
+			w.writeKey(dimValues[i])
+			w.bw.WriteByte('{')
+		}
+		w.writeKey(dimValues[len(dimValues)-1])
+		if measureValue == nil {
+			w.writeValue(nil)
+		} else {
+			w.writeValue(*measureValue)
+		}
+		previous = dimValues
+	})
+	for i := 0; i < len(previous)-1; i++ {
+		w.bw.WriteByte('}')
+	}
+	w.bw.WriteByte('}')
+	w.nResults++
+	w.flush()
+}
+
+// ReportProfile writes the profile of the query to the response.
+func (w *StreamingJSONQueryResponseWriter) ReportProfile(queryIndex int, profile *query.QueryProfile) {
+	if w.profiles == nil {
+		w.profiles = make([]*query.QueryProfile, w.nQueries)
+	}
+	w.profiles[queryIndex] = profile
+}
+
+// Respond writes the rest of the response into ResponseWriter.
+func (w *StreamingJSONQueryResponseWriter) Respond(rw http.ResponseWriter) {
+	w.start()
+	w.writeNullResults(w.nQueries)
+	w.bw.WriteByte(']')
+	if w.errors != nil {
+		w.writeField("errors", w.errors)
+	}
+	if len(w.contexts) > 0 {
+		w.writeField("context", w.contexts)
+	}
+	if w.profiles != nil {
+		w.writeField("profiles", w.profiles)
+	}
+	if w.cursors != nil {
+		w.writeField("cursors", w.cursors)
+	}
+	w.bw.WriteByte('}')
+	w.flush()
+}
+
+// GetStatusCode returns the status code written into response.
+func (w *StreamingJSONQueryResponseWriter) GetStatusCode() int {
+	return w.statusCode
+}
+
+// start writes the header and the beginning of the response if not yet.
+func (w *StreamingJSONQueryResponseWriter) start() {
+	if w.bw != nil {
+		return
+	}
+	setCommonHeaders(w.rw)
+	w.rw.Header().Set("Content-Type", "application/json")
+	w.rw.WriteHeader(w.statusCode)
+	w.bw = bufio.NewWriterSize(w.rw, streamingBufferSize)
+	w.bw.WriteString(`{"results":[`)
+}
+
+// writeNullResults writes null for results of failed queries before queryIndex.
+func (w *StreamingJSONQueryResponseWriter) writeNullResults(queryIndex int) {
+	for ; w.nResults < queryIndex; w.nResults++ {
+		if w.nResults > 0 {
+			w.bw.WriteByte(',')
+		}
+		w.bw.WriteString("null")
+	}
+}
+
+func (w *StreamingJSONQueryResponseWriter) writeField(name string, value interface{}) {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		utils.GetLogger().With("error", err, "field", name).Error("Failed to marshal query response field")
+		bytes = []byte("null")
+	}
+	w.bw.WriteString(`,"` + name + `":`)
+	w.bw.Write(bytes)
+}
+
+// writeKey writes the key of an object.
+func (w *StreamingJSONQueryResponseWriter) writeKey(key string) {
+	keyBytes, _ := json.Marshal(key)
+	w.bw.Write(keyBytes)
+	w.bw.WriteByte(':')
+}
+
+// writeValue writes the nested result with keys sorted the same way as json.Marshal.
+func (w *StreamingJSONQueryResponseWriter) writeValue(value interface{}) {
+	if m, ok := value.(map[string]interface{}); ok {
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		w.bw.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				w.bw.WriteByte(',')
+			}
+			w.writeKey(key)
+			w.writeValue(m[key])
+		}
+		w.bw.WriteByte('}')
+		return
+	}
+
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		utils.GetLogger().With("error", err, "value", value).Error("Failed to marshal query result")
+		bytes = []byte("null")
+	}
+	w.bw.Write(bytes)
+
+	w.rows++
+	if w.rows%streamingFlushRows == 0 {
+		w.flush()
+	}
+}
+
+// flush flushes the buffered bytes to the client.
+func (w *StreamingJSONQueryResponseWriter) flush() {
+	w.bw.Flush()
+	if flusher, ok := w.rw.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 // HLLQueryResponseWriter writes query result as application/hll. For more inforamtion, please refer to
 // https://github.com/uber/aresdb/wiki/HyperLogLog.
 type HLLQueryResponseWriter struct {
@@ -311,6 +1083,16 @@ func (w *HLLQueryResponseWriter) ReportResult(queryIndex int, qc *query.AQLQuery
 	w.response.WriteResult(qc.HLLQueryResult)
 }
 
+// ReportCachedResult is not supported since hll results are never cached.
+func (w *HLLQueryResponseWriter) ReportCachedResult(queryIndex int, result queryCom.AQLTimeSeriesResult) {
+	w.ReportError(queryIndex, "", utils.StackError(nil, "cached results are not supported for hll queries"),
+		http.StatusInternalServerError)
+}
+
+// ReportProfile is ignored for the same reason as ReportQueryContext.
+func (w *HLLQueryResponseWriter) ReportProfile(queryIndex int, profile *query.QueryProfile) {
+}
+
 // Respond writes the final response into ResponseWriter.
 func (w *HLLQueryResponseWriter) Respond(rw http.ResponseWriter) {
 	rw.Header().Set("Content-Type", ContentTypeHyperLogLog)
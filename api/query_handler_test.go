@@ -16,10 +16,12 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"time"
 
 	"github.com/uber/aresdb/memstore"
 	memMocks "github.com/uber/aresdb/memstore/mocks"
@@ -29,9 +31,12 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
 	"github.com/pkg/errors"
 	"github.com/uber/aresdb/common"
 	"github.com/uber/aresdb/query"
+	queryCom "github.com/uber/aresdb/query/common"
 )
 
 var _ = ginkgo.Describe("QueryHandler", func() {
@@ -70,6 +75,8 @@ var _ = ginkgo.Describe("QueryHandler", func() {
 		})
 		testRouter := mux.NewRouter()
 		testRouter.HandleFunc("/aql", queryHandler.HandleAQL).Methods(http.MethodGet, http.MethodPost)
+		testRouter.HandleFunc("/prepared/{name}", queryHandler.PrepareQuery).Methods(http.MethodPut)
+		testRouter.HandleFunc("/prepared/{name}", queryHandler.ExecutePreparedQuery).Methods(http.MethodPost)
 		testServer = httptest.NewUnstartedServer(WithPanicHandling(testRouter))
 		testServer.Start()
 	})
@@ -121,6 +128,172 @@ var _ = ginkgo.Describe("QueryHandler", func() {
 		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
 	})
 
+	ginkgo.It("HandleAQL should return query profiles", func() {
+		hostPort := testServer.Listener.Addr().String()
+		query := `{"queries": [{"measures": [{"sqlExpression": "count(*)"}], "table": "trips"}]}`
+		resp, err := http.Post(fmt.Sprintf("http://%s/aql?profile=1", hostPort), "application/json", bytes.NewBuffer([]byte(query)))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+		var response struct {
+			Profiles []struct {
+				Stages map[string]float64 `json:"stages"`
+			} `json:"profiles"`
+		}
+		Ω(json.NewDecoder(resp.Body).Decode(&response)).Should(BeNil())
+		Ω(response.Profiles).Should(HaveLen(1))
+		Ω(response.Profiles[0].Stages).Should(HaveKey("serialize"))
+
+		// no profiles without the flag.
+		resp, err = http.Post(fmt.Sprintf("http://%s/aql", hostPort), "application/json", bytes.NewBuffer([]byte(query)))
+		Ω(err).Should(BeNil())
+		bs, err := ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		Ω(string(bs)).Should(MatchJSON(`{"results": [{}]}`))
+	})
+
+	ginkgo.It("HandleAQL should trace queries under the span propagated by the client", func() {
+		tracer := mocktracer.New()
+		opentracing.SetGlobalTracer(tracer)
+		defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+		parent := tracer.StartSpan("client")
+		hostPort := testServer.Listener.Addr().String()
+		body := `{"queries": [{"measures": [{"sqlExpression": "count(*)"}], "table": "trips"}]}`
+		req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/aql", hostPort), bytes.NewBuffer([]byte(body)))
+		Ω(tracer.Inject(parent.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))).Should(BeNil())
+		resp, err := http.DefaultClient.Do(req)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+		parent.Finish()
+
+		spans := make(map[string]*mocktracer.MockSpan)
+		for _, span := range tracer.FinishedSpans() {
+			spans[span.OperationName] = span
+		}
+		Ω(spans).Should(HaveLen(6))
+		parentContext := parent.Context().(mocktracer.MockSpanContext)
+		request := spans[tracingOperationRequest]
+		Ω(request.SpanContext.TraceID).Should(Equal(parentContext.TraceID))
+		Ω(request.ParentID).Should(Equal(parentContext.SpanID))
+		Ω(request.Tag("http.method")).Should(Equal(http.MethodPost))
+
+		querySpan := spans[tracingOperationQuery]
+		Ω(querySpan.ParentID).Should(Equal(request.SpanContext.SpanID))
+		Ω(querySpan.Tag("table")).Should(Equal("trips"))
+		for _, operation := range []string{tracingOperationCompile, query.TracingOperationExecute, tracingOperationSerialize} {
+			Ω(spans[operation].ParentID).Should(Equal(querySpan.SpanContext.SpanID), operation)
+			Ω(spans[operation].SpanContext.TraceID).Should(Equal(parentContext.TraceID), operation)
+		}
+		execute := spans[query.TracingOperationExecute]
+		Ω(execute.Tags()).Should(HaveKeyWithValue("rows_scanned", 0))
+		Ω(execute.Tags()).Should(HaveKey("result_rows"))
+		// stage timings are tagged without profiling the query.
+		Ω(execute.Tags()).Should(HaveKey(query.ProfileStageScan + "_ms"))
+		Ω(execute.Tags()).Should(HaveKey(query.ProfileStageAggregate + "_ms"))
+	})
+
+	ginkgo.It("HandleAQL should return csv", func() {
+		hostPort := testServer.Listener.Addr().String()
+		query := `{"queries": [{"measures": [{"sqlExpression": "count(*)"}], "table": "trips",
+			"dimensions": [{"sqlExpression": "city_id"}]}]}`
+		req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/aql", hostPort), bytes.NewBuffer([]byte(query)))
+		req.Header.Set("Accept", ContentTypeCSV)
+		resp, err := http.DefaultClient.Do(req)
+		Ω(err).Should(BeNil())
+		bs, err := ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+		Ω(resp.Header.Get("Content-Type")).Should(Equal(ContentTypeCSV))
+		Ω(string(bs)).Should(Equal("city_id,count(*)\n"))
+
+		// csv requires exactly one query.
+		queries := `{"queries": [{"measures": [{"sqlExpression": "count(*)"}], "table": "trips"},
+			{"measures": [{"sqlExpression": "count(*)"}], "table": "trips"}]}`
+		resp, err = http.Post(fmt.Sprintf("http://%s/aql?format=csv", hostPort), "application/json", bytes.NewBuffer([]byte(queries)))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+	})
+
+	ginkgo.It("HandleAQL should deny queries referencing columns restricted to other tenants", func() {
+		testSchema.Lock()
+		testSchema.Schema.Columns[2].Config.ReadTenants = []string{"finance"}
+		testSchema.Unlock()
+		defer func() {
+			testSchema.Lock()
+			testSchema.Schema.Columns[2].Config.ReadTenants = nil
+			testSchema.Unlock()
+		}()
+
+		hostPort := testServer.Listener.Addr().String()
+		postQuery := func(tenant, query string) (int, string) {
+			req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/aql", hostPort), bytes.NewBuffer([]byte(query)))
+			req.Header.Set("RPC-Caller", tenant)
+			resp, err := http.DefaultClient.Do(req)
+			Ω(err).Should(BeNil())
+			bs, err := ioutil.ReadAll(resp.Body)
+			Ω(err).Should(BeNil())
+			return resp.StatusCode, string(bs)
+		}
+
+		groupByQuery := `{"queries": [{"measures": [{"sqlExpression": "count(*)"}], "table": "trips",
+			"dimensions": [{"sqlExpression": "city_id"}]}]}`
+		filterQuery := `{"queries": [{"measures": [{"sqlExpression": "count(*)"}], "table": "trips",
+			"rowFilters": ["city_id = 1"]}]}`
+		for _, query := range []string{groupByQuery, filterQuery} {
+			statusCode, body := postQuery("marketing", query)
+			Ω(statusCode).Should(Equal(http.StatusForbidden))
+			Ω(body).Should(ContainSubstring("tenant marketing is not allowed to read column city_id of table trips"))
+
+			statusCode, body = postQuery("finance", query)
+			Ω(statusCode).Should(Equal(http.StatusOK))
+			Ω(body).Should(MatchJSON(`{"results": [{}]}`))
+		}
+
+		// queries not using the column are not restricted.
+		statusCode, _ := postQuery("marketing", `{"queries": [{"measures": [{"sqlExpression": "count(*)"}], "table": "trips"}]}`)
+		Ω(statusCode).Should(Equal(http.StatusOK))
+	})
+
+	ginkgo.It("HandleAQL should return not found for queries over tables that do not exist", func() {
+		hostPort := testServer.Listener.Addr().String()
+		for _, body := range []string{
+			`{"queries": [{"measures": [{"sqlExpression": "count(*)"}], "table": "dropped"}]}`,
+			`{"queries": [{"measures": [{"sqlExpression": "count(*)"}], "table": "trips",
+				"joins": [{"alias": "d", "table": "dropped", "conditions": ["d.id = trips.city_id"]}]}]}`,
+		} {
+			resp, err := http.Post(fmt.Sprintf("http://%s/aql", hostPort), "application/json", bytes.NewBuffer([]byte(body)))
+			Ω(err).Should(BeNil())
+			bs, err := ioutil.ReadAll(resp.Body)
+			Ω(err).Should(BeNil())
+			Ω(resp.StatusCode).Should(Equal(http.StatusNotFound))
+			Ω(string(bs)).Should(ContainSubstring("dropped"))
+		}
+	})
+
+	ginkgo.It("HandleAQL should not respond with 200 if a later query fails", func() {
+		hostPort := testServer.Listener.Addr().String()
+		body := `{"queries": [
+			{"measures": [{"sqlExpression": "count(*)"}], "table": "trips"},
+			{"measures": [{"sqlExpression": "count(*)"}], "table": "dropped"}
+		]}`
+		resp, err := http.Post(fmt.Sprintf("http://%s/aql", hostPort), "application/json", bytes.NewBuffer([]byte(body)))
+		Ω(err).Should(BeNil())
+		bs, err := ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusNotFound))
+		var response struct {
+			Results []interface{} `json:"results"`
+			Errors  []interface{} `json:"errors"`
+		}
+		Ω(json.Unmarshal(bs, &response)).Should(BeNil())
+		Ω(response.Results).Should(HaveLen(2))
+		Ω(response.Results[0]).ShouldNot(BeNil())
+		Ω(response.Results[1]).Should(BeNil())
+		Ω(response.Errors).Should(HaveLen(2))
+		Ω(response.Errors[0]).Should(BeNil())
+		Ω(response.Errors[1]).ShouldNot(BeNil())
+	})
+
 	ginkgo.It("HandleAQL should fail on request that cannot be unmarshaled", func() {
 		hostPort := testServer.Listener.Addr().String()
 		resp, err := http.Post(fmt.Sprintf("http://%s/aql", hostPort), "application/json", bytes.NewBuffer([]byte{}))
@@ -176,6 +349,144 @@ var _ = ginkgo.Describe("QueryHandler", func() {
 		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
 	})
 
+	ginkgo.It("HandleAQL should return query plans when explain is set", func() {
+		hostPort := testServer.Listener.Addr().String()
+		request := `
+			{
+			  "queries": [
+				{
+				  "measures": [
+					{
+					  "sqlExpression": "count(*)"
+					}
+				  ],
+				  "rowFilters": [
+					"city_id = 1"
+				  ],
+				  "table": "trips",
+				  "dimensions": [
+					{
+					  "sqlExpression": "status"
+					}
+				  ]
+				},
+				{
+				  "measures": [
+					{
+					  "sqlExpression": "count(*)"
+					}
+				  ],
+				  "table": "unknown"
+				}
+			  ]
+			}
+		`
+		resp, err := http.Post(fmt.Sprintf("http://%s/aql?explain=1", hostPort), "application/json", bytes.NewBuffer([]byte(request)))
+		Ω(err).Should(BeNil())
+		bs, err := ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusNotFound))
+
+		var response query.AQLExplainResponse
+		Ω(json.Unmarshal(bs, &response)).Should(BeNil())
+		Ω(response.Plans).Should(HaveLen(2))
+		Ω(response.Plans[1]).Should(BeNil())
+		Ω(response.Errors).Should(HaveLen(2))
+		Ω(response.Errors[0]).Should(BeEmpty())
+		Ω(response.Errors[1]).Should(Equal("unknown main table unknown\nTable not found"))
+
+		plan := response.Plans[0]
+		Ω(plan.Table).Should(Equal("trips"))
+		Ω(plan.MainTableFilters).Should(Equal([]string{"city_id = 1"}))
+		Ω(plan.Dimensions).Should(Equal([]string{"status"}))
+		Ω(plan.Stages).Should(ContainElement("filterEval"))
+		Ω(plan.Shards).Should(Equal([]query.ShardPlan{
+			{Shard: 0, LiveBatches: []query.BatchPlan{}, ArchiveBatches: []query.BatchPlan{}},
+		}))
+		Ω(plan.EstimatedRows).Should(Equal(0))
+
+		// explained queries are checked against the column access of the tenant.
+		testSchema.Lock()
+		testSchema.Schema.Columns[2].Config.ReadTenants = []string{"finance"}
+		testSchema.Unlock()
+		defer func() {
+			testSchema.Lock()
+			testSchema.Schema.Columns[2].Config.ReadTenants = nil
+			testSchema.Unlock()
+		}()
+		req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/aql?explain=1", hostPort), bytes.NewBuffer([]byte(
+			`{"queries": [{"measures": [{"sqlExpression": "count(*)"}], "table": "trips", "rowFilters": ["city_id = 1"]}]}`)))
+		req.Header.Set("RPC-Caller", "marketing")
+		resp, err = http.DefaultClient.Do(req)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusForbidden))
+		bs, err = ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		response = query.AQLExplainResponse{}
+		Ω(json.Unmarshal(bs, &response)).Should(BeNil())
+		Ω(response.Plans).Should(Equal([]*query.QueryPlan{nil}))
+		Ω(response.Errors).Should(Equal([]string{
+			"tenant marketing is not allowed to read column city_id of table trips\nColumn access denied"}))
+	})
+
+	ginkgo.It("PrepareQuery and ExecutePreparedQuery should work", func() {
+		hostPort := testServer.Listener.Addr().String()
+		query := `
+			{
+			  "table": "trips",
+			  "measures": [
+				{
+				  "sqlExpression": "count(*)"
+				}
+			  ],
+			  "rowFilters": [
+				"city_id = $city_id"
+			  ]
+			}
+		`
+		req, _ := http.NewRequest(http.MethodPut, fmt.Sprintf("http://%s/prepared/trips_by_city", hostPort), bytes.NewBuffer([]byte(query)))
+		resp, err := http.DefaultClient.Do(req)
+		Ω(err).Should(BeNil())
+		bs, err := ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+		Ω(string(bs)).Should(ContainSubstring(`"parameters":{"city_id":"city_id"}`))
+
+		resp, err = http.Post(fmt.Sprintf("http://%s/prepared/trips_by_city", hostPort), "application/json",
+			bytes.NewBuffer([]byte(`{"parameters": {"city_id": 1}}`)))
+		Ω(err).Should(BeNil())
+		bs, err = ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+		Ω(string(bs)).Should(MatchJSON(`{
+				"results": [
+				  {}
+				]
+			  }`))
+
+		resp, err = http.Post(fmt.Sprintf("http://%s/prepared/trips_by_city", hostPort), "application/json",
+			bytes.NewBuffer([]byte(`{"parameters": {"city_id": "sf"}}`)))
+		Ω(err).Should(BeNil())
+		bs, err = ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+		Ω(string(bs)).Should(ContainSubstring("Parameter $city_id expects a Uint16 value for column city_id, got sf"))
+
+		resp, err = http.Post(fmt.Sprintf("http://%s/prepared/unknown", hostPort), "application/json",
+			bytes.NewBuffer([]byte(`{"parameters": {}}`)))
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusNotFound))
+
+		req, _ = http.NewRequest(http.MethodPut, fmt.Sprintf("http://%s/prepared/invalid", hostPort),
+			bytes.NewBuffer([]byte(`{"table": "trips", "rowFilters": ["$city_id"]}`)))
+		resp, err = http.DefaultClient.Do(req)
+		Ω(err).Should(BeNil())
+		bs, err = ioutil.ReadAll(resp.Body)
+		Ω(err).Should(BeNil())
+		Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+		Ω(string(bs)).Should(ContainSubstring("must be compared with a column"))
+	})
+
 	ginkgo.It("ReportError should work", func() {
 		rw := NewHLLQueryResponseWriter()
 		Ω(rw.GetStatusCode()).Should(Equal(http.StatusOK))
@@ -254,6 +565,155 @@ var _ = ginkgo.Describe("QueryHandler", func() {
 		Ω(rw.(*JSONQueryResponseWriter).response.Errors[1]).Should(BeNil())
 	})
 
+	ginkgo.It("HandleAQL should cancel queries on client disconnect or timeout", func() {
+		query := `
+			{
+			  "queries": [
+				{
+				  "measures": [
+					{
+					  "sqlExpression": "count(*)"
+					}
+				  ],
+				  "table": "trips",
+				  "dimensions": [
+					{
+					  "sqlExpression": "trips.city_id"
+					}
+				  ]
+				}
+			  ]
+			}
+		`
+		queryHandler := NewQueryHandler(memStore, common.QueryConfig{
+			DeviceMemoryUtilization: 1.0,
+		})
+
+		// client disconnected.
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		r := httptest.NewRequest(http.MethodPost, "/aql", bytes.NewBuffer([]byte(query))).WithContext(ctx)
+		w := httptest.NewRecorder()
+		queryHandler.HandleAQL(w, r)
+		Ω(w.Code).Should(Equal(http.StatusInternalServerError))
+		Ω(w.Body.String()).Should(ContainSubstring("query is cancelled"))
+		Ω(w.Body.String()).Should(ContainSubstring(context.Canceled.Error()))
+
+		// exceeds max query duration.
+		queryHandler.maxQueryDuration = time.Nanosecond
+		r = httptest.NewRequest(http.MethodPost, "/aql", bytes.NewBuffer([]byte(query)))
+		w = httptest.NewRecorder()
+		queryHandler.HandleAQL(w, r)
+		Ω(w.Code).Should(Equal(http.StatusInternalServerError))
+		Ω(w.Body.String()).Should(ContainSubstring("query is cancelled"))
+		Ω(w.Body.String()).Should(ContainSubstring(context.DeadlineExceeded.Error()))
+	})
+
+	ginkgo.It("StreamingJSONQueryResponseWriter should keep response envelope", func() {
+		data, err := ioutil.ReadFile("../testing/data/query/hll")
+		Ω(err).Should(BeNil())
+
+		recorder := httptest.NewRecorder()
+		rw := NewStreamingJSONQueryResponseWriter(recorder, 3)
+		rw.ReportError(0, "trips", errors.New("test err"), http.StatusBadRequest)
+		rw.ReportResult(1, &query.AQLQueryContext{
+			Query: &query.AQLQuery{Table: "trips"},
+			// C.AGGR_HLL
+			OOPK:           query.OOPKContext{AggregateType: 10},
+			HLLQueryResult: data,
+		})
+		// status code cannot be changed after the first result is written.
+		rw.ReportError(2, "trips", errors.New("test err"), http.StatusInternalServerError)
+		rw.Respond(recorder)
+
+		Ω(rw.GetStatusCode()).Should(Equal(http.StatusBadRequest))
+		Ω(recorder.Code).Should(Equal(http.StatusBadRequest))
+		Ω(recorder.Header().Get("Content-Type")).Should(Equal("application/json"))
+		Ω(recorder.Body.String()).Should(MatchJSON(`
+		{
+			"results": [
+				null,
+				{
+					"1": {
+						"c": {
+							"2": 2
+						}
+					},
+					"4294967295": {
+						"d": {
+							"514": 4
+						}
+					},
+					"NULL": {
+						"NULL": {
+							"NULL": 3
+						}
+					}
+				},
+				null
+			],
+			"errors": [{}, null, {}]
+		}
+		`))
+	})
+
+	ginkgo.It("StreamingJSONQueryResponseWriter should write large result incrementally", func() {
+		result := map[string]interface{}{}
+		expected := map[string]interface{}{}
+		for i := 0; i < 100; i++ {
+			child := map[string]interface{}{}
+			for j := 0; j < 1000; j++ {
+				child[fmt.Sprintf("%d", j)] = float64(i * j)
+			}
+			result[fmt.Sprintf("%d", i)] = child
+			expected[fmt.Sprintf("%d", i)] = child
+		}
+
+		recorder := &flushCountingRecorder{ResponseRecorder: httptest.NewRecorder()}
+		rw := NewStreamingJSONQueryResponseWriter(recorder, 1).(*StreamingJSONQueryResponseWriter)
+		rw.start()
+		rw.writeValue(result)
+		// result is sent out before the response is finished.
+		Ω(recorder.flushes).Should(BeNumerically(">=", 100))
+		Ω(recorder.Body.Len()).Should(BeNumerically(">", 1000000))
+		Ω(recorder.maxWrite).Should(BeNumerically("<=", streamingBufferSize))
+
+		rw.nResults++
+		rw.Respond(recorder)
+		Ω(recorder.Code).Should(Equal(http.StatusOK))
+		expectedJSON, _ := json.Marshal(query.AQLResponse{Results: []queryCom.AQLTimeSeriesResult{expected}})
+		Ω(recorder.Body.String()).Should(MatchJSON(expectedJSON))
+	})
+
+	ginkgo.It("StreamingJSONQueryResponseWriter should write streamed groups as nested result", func() {
+		one, two := 1.0, 2.0
+		groups := []struct {
+			dimValues []string
+			value     *float64
+		}{
+			{[]string{"a", "x", "1"}, &one},
+			{[]string{"a", "x", "2"}, nil},
+			{[]string{"a", "y", "1"}, &two},
+			{[]string{"b", "x", "1"}, &one},
+		}
+		recorder := httptest.NewRecorder()
+		rw := NewStreamingJSONQueryResponseWriter(recorder, 3).(*StreamingJSONQueryResponseWriter)
+		rw.writeGroups(1, func(visit func(dimValues []string, measureValue *float64)) {
+			for _, group := range groups {
+				visit(group.dimValues, group.value)
+			}
+		})
+		rw.writeGroups(2, func(visit func(dimValues []string, measureValue *float64)) {})
+		rw.Respond(recorder)
+		Ω(recorder.Body.String()).Should(MatchJSON(`{
+			"results": [
+				null,
+				{"a": {"x": {"1": 1, "2": null}, "y": {"1": 2}}, "b": {"x": {"1": 1}}},
+				{}
+			]
+		}`))
+	})
+
 	ginkgo.It("Verbose should work", func() {
 		hostPort := testServer.Listener.Addr().String()
 		query := `
@@ -293,3 +753,22 @@ var _ = ginkgo.Describe("QueryHandler", func() {
 		Ω(string(bs)).Should(ContainSubstring("allBatches"))
 	})
 })
+
+// flushCountingRecorder records the number of flushes and the max size of a single write.
+type flushCountingRecorder struct {
+	*httptest.ResponseRecorder
+	flushes  int
+	maxWrite int
+}
+
+func (r *flushCountingRecorder) Write(p []byte) (int, error) {
+	if len(p) > r.maxWrite {
+		r.maxWrite = len(p)
+	}
+	return r.ResponseRecorder.Write(p)
+}
+
+func (r *flushCountingRecorder) Flush() {
+	r.flushes++
+	r.ResponseRecorder.Flush()
+}
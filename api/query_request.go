@@ -31,10 +31,19 @@ type AQLRequest struct {
 	Debug int `query:"debug,optional" json:"debug"`
 	// in: query
 	Profiling string `query:"profiling,optional" json:"profiling"`
+	// Returns the time spent in each executor stage of the queries.
+	// in: query
+	Profile int `query:"profile,optional" json:"profile"`
 	// in: query
 	Query string `query:"q,optional" json:"q"`
 	// in: query
 	DeviceChoosingTimeout int `query:"timeout,optional" json:"timeout"`
+	// Returns the query result as csv if set to csv, same as accepting text/csv.
+	// in: query
+	Format string `query:"format,optional" json:"format"`
+	// Returns the query plans instead of executing the queries.
+	// in: query
+	Explain int `query:"explain,optional" json:"explain"`
 	// in: header
 	Accept string `header:"Accept" json:"accept"`
 	// in: header
@@ -42,3 +51,43 @@ type AQLRequest struct {
 	// in: body
 	Body query.AQLRequest `body:""`
 }
+
+// PrepareQueryRequest represents the request to register a prepared query.
+// swagger:parameters prepareQuery
+type PrepareQueryRequest struct {
+	// in: path
+	Name string `path:"name" json:"name"`
+	// in: body
+	Body query.AQLQuery `body:""`
+}
+
+// ExecutePreparedQueryRequest represents the request to execute a prepared query.
+// swagger:parameters executePreparedQuery
+type ExecutePreparedQueryRequest struct {
+	// in: path
+	Name string `path:"name" json:"name"`
+	// in: query
+	Device int `query:"device,optional" json:"device"`
+	// in: query
+	Verbose int `query:"verbose,optional" json:"verbose"`
+	// in: query
+	DeviceChoosingTimeout int `query:"timeout,optional" json:"timeout"`
+	// in: body
+	Body struct {
+		Parameters map[string]interface{} `json:"parameters"`
+	} `body:""`
+}
+
+// SubscribeQueryRequest represents the request to subscribe to the result of a query over websocket.
+// swagger:parameters subscribeAQL
+type SubscribeQueryRequest struct {
+	// The AQL query in json.
+	// in: query
+	Query string `query:"q" json:"q"`
+	// Milliseconds between two pushes of the result, the server configured interval is used if
+	// shorter.
+	// in: query
+	PushInterval int `query:"interval,optional" json:"interval"`
+	// in: query
+	Device int `query:"device,optional" json:"device"`
+}
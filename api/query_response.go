@@ -24,3 +24,24 @@ type AQLResponse struct {
 	//in: body
 	Body query.AQLResponse
 }
+
+// AQLExplainResponse represents queryAQL response when explain is set.
+// swagger:response aqlExplainResponse
+type AQLExplainResponse struct {
+	//in: body
+	Body query.AQLExplainResponse
+}
+
+// PreparedQueryResponse represents prepareQuery response.
+// swagger:response preparedQueryResponse
+type PreparedQueryResponse struct {
+	//in: body
+	Body query.PreparedQuery
+}
+
+// QuerySubscriptionResponse represents the messages pushed to subscribeAQL subscribers.
+// swagger:response querySubscriptionMessage
+type QuerySubscriptionResponse struct {
+	//in: body
+	Body QuerySubscriptionMessage
+}
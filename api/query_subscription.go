@@ -0,0 +1,218 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	defaultSubscriptionPushInterval = time.Second
+	defaultSubscriptionBufferSize   = 16
+	// max duration of writing one message to a subscriber.
+	subscriptionWriteTimeout = 10 * time.Second
+)
+
+// number of active query subscriptions, accessed atomically.
+var numSubscriptions int64
+
+// Types of messages pushed to query subscribers.
+const (
+	// SubscriptionMessageResult carries the full result of the query.
+	SubscriptionMessageResult = "result"
+	// SubscriptionMessageDelta carries the groups changed since the previous message.
+	SubscriptionMessageDelta = "delta"
+	// SubscriptionMessageError carries the error of the query.
+	SubscriptionMessageError = "error"
+)
+
+// QuerySubscriptionMessage is pushed to query subscribers over websocket.
+type QuerySubscriptionMessage struct {
+	Type string `json:"type"`
+	// The full result for result messages. For delta messages only the groups added or changed
+	// since the previous message are included, nested the same way as results, groups removed
+	// from the result are null.
+	Result queryCom.AQLTimeSeriesResult `json:"result"`
+	Error  string                       `json:"error,omitempty"`
+}
+
+// querySubscription runs a query at the push interval and pushes its result to a subscriber,
+// first the full result then deltas whenever the result changes as new data is ingested.
+type querySubscription struct {
+	interval time.Duration
+	// runs the query, returns the status code the query would be responded with over http.
+	run func(ctx context.Context) (queryCom.AQLTimeSeriesResult, int, error)
+	// messages waiting to be written to the subscriber, the subscriber is disconnected as a slow
+	// consumer once it's full.
+	messages chan QuerySubscriptionMessage
+	// result pushed last, nil before the first push.
+	result queryCom.AQLTimeSeriesResult
+	slow   bool
+}
+
+func newQuerySubscription(interval time.Duration, bufferSize int,
+	run func(ctx context.Context) (queryCom.AQLTimeSeriesResult, int, error)) *querySubscription {
+	return &querySubscription{
+		interval: interval,
+		run:      run,
+		messages: make(chan QuerySubscriptionMessage, bufferSize),
+	}
+}
+
+// serve pushes results to the subscriber until it disconnects or falls behind, or the query
+// fails with a client error. Server errors are pushed and the query is retried at the next push.
+func (s *querySubscription) serve(ws *websocket.Conn) {
+	defer ws.Close()
+	ctx, cancel := context.WithCancel(ws.Request().Context())
+	defer cancel()
+
+	// The server read and write timeouts do not apply to subscriptions.
+	ws.SetDeadline(time.Time{})
+	go func() {
+		// Messages from the subscriber are ignored, reading only detects the disconnect.
+		defer cancel()
+		var message []byte
+		for websocket.Message.Receive(ws, &message) == nil {
+		}
+	}()
+
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		for message := range s.messages {
+			ws.SetWriteDeadline(time.Now().Add(subscriptionWriteTimeout))
+			if err := websocket.JSON.Send(ws, message); err != nil {
+				cancel()
+				// drain the buffer so that pushes never block.
+				for range s.messages {
+				}
+				return
+			}
+		}
+	}()
+
+	utils.GetRootReporter().GetGauge(utils.QuerySubscriptions).Update(float64(atomic.AddInt64(&numSubscriptions, 1)))
+	defer func() {
+		utils.GetRootReporter().GetGauge(utils.QuerySubscriptions).Update(float64(atomic.AddInt64(&numSubscriptions, -1)))
+	}()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for pushing := s.push(ctx); pushing; {
+		select {
+		case <-ctx.Done():
+			pushing = false
+		case <-ticker.C:
+			pushing = s.push(ctx)
+		}
+	}
+
+	close(s.messages)
+	if s.slow {
+		// do not wait for a slow subscriber to read the buffered messages.
+		ws.Close()
+	}
+	<-written
+}
+
+// push runs the query and buffers the message for the subscriber, returns whether the
+// subscription should go on.
+func (s *querySubscription) push(ctx context.Context) bool {
+	result, statusCode, err := s.run(ctx)
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		// retrying queries failed with client errors won't help.
+		return s.send(QuerySubscriptionMessage{Type: SubscriptionMessageError, Error: err.Error()}) &&
+			statusCode >= http.StatusInternalServerError
+	}
+
+	message := QuerySubscriptionMessage{Type: SubscriptionMessageResult, Result: result}
+	if s.result != nil {
+		delta := diffResults(s.result, result)
+		if len(delta) == 0 {
+			return true
+		}
+		// The full result is pushed instead if it's not larger than the delta, e.g. when all
+		// groups changed or the query is not aggregated.
+		if countResultGroups(delta) < countResultGroups(result) {
+			message = QuerySubscriptionMessage{Type: SubscriptionMessageDelta, Result: delta}
+		}
+	}
+	s.result = result
+	return s.send(message)
+}
+
+func (s *querySubscription) send(message QuerySubscriptionMessage) bool {
+	select {
+	case s.messages <- message:
+		utils.GetRootReporter().GetCounter(utils.QuerySubscriptionPushes).Inc(1)
+		return true
+	default:
+		s.slow = true
+		utils.GetRootReporter().GetCounter(utils.QuerySubscribersDisconnected).Inc(1)
+		utils.GetLogger().With("buffered", len(s.messages)).Warn("Disconnecting slow query subscriber")
+		return false
+	}
+}
+
+// diffResults returns the groups of the new result added or changed from the old result, nested
+// the same way as results. Groups only in the old result are null.
+func diffResults(old, new map[string]interface{}) map[string]interface{} {
+	delta := make(map[string]interface{})
+	for key, value := range new {
+		oldValue, found := old[key]
+		if !found {
+			delta[key] = value
+			continue
+		}
+		child, isMap := value.(map[string]interface{})
+		oldChild, wasMap := oldValue.(map[string]interface{})
+		if isMap && wasMap {
+			if childDelta := diffResults(oldChild, child); len(childDelta) > 0 {
+				delta[key] = childDelta
+			}
+		} else if !reflect.DeepEqual(oldValue, value) {
+			delta[key] = value
+		}
+	}
+	for key := range old {
+		if _, found := new[key]; !found {
+			delta[key] = nil
+		}
+	}
+	return delta
+}
+
+// countResultGroups returns the number of leaf values in the result.
+func countResultGroups(result map[string]interface{}) (count int) {
+	for _, value := range result {
+		if child, ok := value.(map[string]interface{}); ok {
+			count += countResultGroups(child)
+		} else {
+			count++
+		}
+	}
+	return
+}
@@ -0,0 +1,227 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/memstore"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	queryCom "github.com/uber/aresdb/query/common"
+	"golang.org/x/net/websocket"
+)
+
+var _ = ginkgo.Describe("query subscription", func() {
+	receive := func(ws *websocket.Conn) QuerySubscriptionMessage {
+		var message QuerySubscriptionMessage
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		Ω(websocket.JSON.Receive(ws, &message)).Should(BeNil())
+		return message
+	}
+
+	ginkgo.It("pushes updated results as new rows are ingested", func() {
+		// rows ingested by city.
+		var lock sync.Mutex
+		rows := map[string]float64{"1": 1}
+		ingest := func(cities ...string) {
+			lock.Lock()
+			for _, city := range cities {
+				rows[city]++
+			}
+			lock.Unlock()
+		}
+		subscription := newQuerySubscription(10*time.Millisecond, 16,
+			func(ctx context.Context) (queryCom.AQLTimeSeriesResult, int, error) {
+				lock.Lock()
+				defer lock.Unlock()
+				result := queryCom.AQLTimeSeriesResult{}
+				for city, count := range rows {
+					result[city] = map[string]interface{}{"count": count}
+				}
+				return result, http.StatusOK, nil
+			})
+		server := httptest.NewServer(websocket.Handler(subscription.serve))
+		defer server.Close()
+		ws, err := websocket.Dial("ws"+server.URL[len("http"):], "", server.URL)
+		Ω(err).Should(BeNil())
+		defer ws.Close()
+
+		Ω(receive(ws)).Should(Equal(QuerySubscriptionMessage{
+			Type:   SubscriptionMessageResult,
+			Result: queryCom.AQLTimeSeriesResult{"1": map[string]interface{}{"count": 1.0}},
+		}))
+
+		// only the changed group is pushed.
+		ingest("2", "2")
+		Ω(receive(ws)).Should(Equal(QuerySubscriptionMessage{
+			Type:   SubscriptionMessageDelta,
+			Result: queryCom.AQLTimeSeriesResult{"2": map[string]interface{}{"count": 2.0}},
+		}))
+
+		// the full result is pushed once all groups changed.
+		ingest("1", "2")
+		Ω(receive(ws)).Should(Equal(QuerySubscriptionMessage{
+			Type: SubscriptionMessageResult,
+			Result: queryCom.AQLTimeSeriesResult{
+				"1": map[string]interface{}{"count": 2.0},
+				"2": map[string]interface{}{"count": 3.0},
+			},
+		}))
+	})
+
+	ginkgo.It("stops on client errors and retries server errors", func() {
+		statusCode := http.StatusServiceUnavailable
+		subscription := newQuerySubscription(time.Millisecond, 16,
+			func(ctx context.Context) (queryCom.AQLTimeSeriesResult, int, error) {
+				return nil, statusCode, errors.New("query failed")
+			})
+		Ω(subscription.push(context.Background())).Should(BeTrue())
+		statusCode = http.StatusUnprocessableEntity
+		Ω(subscription.push(context.Background())).Should(BeFalse())
+		Ω(<-subscription.messages).Should(Equal(QuerySubscriptionMessage{Type: SubscriptionMessageError, Error: "query failed"}))
+		Ω(subscription.slow).Should(BeFalse())
+	})
+
+	ginkgo.It("disconnects slow subscribers once the buffer is full", func() {
+		count := 0.0
+		subscription := newQuerySubscription(time.Millisecond, 2,
+			func(ctx context.Context) (queryCom.AQLTimeSeriesResult, int, error) {
+				count++
+				return queryCom.AQLTimeSeriesResult{"1": count}, http.StatusOK, nil
+			})
+		Ω(subscription.push(context.Background())).Should(BeTrue())
+		Ω(subscription.push(context.Background())).Should(BeTrue())
+		Ω(subscription.push(context.Background())).Should(BeFalse())
+		Ω(subscription.slow).Should(BeTrue())
+	})
+
+	ginkgo.It("diffs results by group", func() {
+		old := map[string]interface{}{
+			"1": map[string]interface{}{"a": 1.0, "b": 2.0},
+			"2": map[string]interface{}{"a": 3.0},
+			"3": 4.0,
+		}
+		new := map[string]interface{}{
+			"1": map[string]interface{}{"a": 1.0, "b": 5.0, "c": 6.0},
+			"3": 4.0,
+			"4": nil,
+		}
+		Ω(diffResults(old, new)).Should(Equal(map[string]interface{}{
+			"1": map[string]interface{}{"b": 5.0, "c": 6.0},
+			"2": nil,
+			"4": nil,
+		}))
+		Ω(diffResults(old, old)).Should(BeEmpty())
+		Ω(countResultGroups(old)).Should(Equal(4))
+	})
+
+	ginkgo.Context("Subscribe", func() {
+		var testServer *httptest.Server
+		var queryHandler *QueryHandler
+		testSchema := memstore.NewTableSchema(&metaCom.Table{
+			Name: "trips",
+			Columns: []metaCom.Column{
+				{Name: "request_at", Type: metaCom.Uint32},
+				{Name: "city_id", Type: metaCom.Uint16},
+				{Name: "fare", Type: metaCom.Float32, Config: metaCom.ColumnConfig{
+					ReadTenants: []string{"finance"},
+				}},
+			},
+			Config: metaCom.TableConfig{BatchSize: 10},
+		})
+
+		ginkgo.BeforeEach(func() {
+			memStore := CreateMemStore(testSchema, 0, nil, CreateMockDiskStore())
+			queryHandler = NewQueryHandler(memStore, common.QueryConfig{
+				DeviceMemoryUtilization:  1.0,
+				SubscriptionPushInterval: 10,
+			})
+			testRouter := mux.NewRouter()
+			queryHandler.Register(testRouter)
+			testServer = httptest.NewServer(WithPanicHandling(testRouter))
+		})
+
+		ginkgo.AfterEach(func() {
+			testServer.Close()
+		})
+
+		subscribeURL := func(query string) string {
+			return fmt.Sprintf("%s/subscribe?q=%s", testServer.URL, url.QueryEscape(query))
+		}
+
+		ginkgo.It("pushes the result of the query", func() {
+			ws, err := websocket.Dial("ws"+subscribeURL(`{"table": "trips", "measures": [{"sqlExpression": "count(*)"}]}`)[len("http"):],
+				"", testServer.URL)
+			Ω(err).Should(BeNil())
+			defer ws.Close()
+			Ω(receive(ws)).Should(Equal(QuerySubscriptionMessage{
+				Type:   SubscriptionMessageResult,
+				Result: queryCom.AQLTimeSeriesResult{},
+			}))
+		})
+
+		ginkgo.It("rejects invalid queries before upgrading", func() {
+			resp, err := http.Get(subscribeURL(`{"table": "trips"`))
+			Ω(err).Should(BeNil())
+			Ω(resp.StatusCode).Should(Equal(http.StatusBadRequest))
+
+			resp, err = http.Get(subscribeURL(`{"table": "unknown", "measures": [{"sqlExpression": "count(*)"}]}`))
+			Ω(err).Should(BeNil())
+			Ω(resp.StatusCode).Should(Equal(http.StatusNotFound))
+
+			resp, err = http.Get(subscribeURL(`{"table": "trips", "measures": [{"sqlExpression": "sum(fare)"}]}`))
+			Ω(err).Should(BeNil())
+			Ω(resp.StatusCode).Should(Equal(http.StatusForbidden))
+		})
+
+		ginkgo.It("rejects subscriptions of tenants over their limit", func() {
+			queryHandler.tenantLimiter.SetConfig(common.TenantLimitsConfig{
+				Default: common.TenantLimit{MaxSubscriptions: 1},
+			})
+			query := `{"table": "trips", "measures": [{"sqlExpression": "count(*)"}]}`
+			ws, err := websocket.Dial("ws"+subscribeURL(query)[len("http"):], "", testServer.URL)
+			Ω(err).Should(BeNil())
+			Ω(receive(ws).Type).Should(Equal(SubscriptionMessageResult))
+
+			resp, err := http.Get(subscribeURL(query))
+			Ω(err).Should(BeNil())
+			Ω(resp.StatusCode).Should(Equal(http.StatusTooManyRequests))
+			Ω(resp.Header.Get("Retry-After")).Should(Equal("1"))
+
+			// the subscription is released once the subscriber disconnects.
+			ws.Close()
+			Eventually(func() int {
+				queryHandler.tenantLimiter.Lock()
+				defer queryHandler.tenantLimiter.Unlock()
+				return queryHandler.tenantLimiter.tenants[otherTenants].subscriptions
+			}).Should(Equal(0))
+			ws, err = websocket.Dial("ws"+subscribeURL(query)[len("http"):], "", testServer.URL)
+			Ω(err).Should(BeNil())
+			defer ws.Close()
+			Ω(receive(ws).Type).Should(Equal(SubscriptionMessageResult))
+		})
+	})
+})
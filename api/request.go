@@ -87,7 +87,12 @@ func ReadRequest(r *http.Request, obj interface{}) error {
 			paramValue = r.Form.Get(paramName)
 		}
 
-		if isPathParam || isQueryParam {
+		if isHeaderParam {
+			// Header params are optional strings.
+			if field.Type.Kind() == reflect.String {
+				valueField.SetString(paramValue)
+			}
+		} else if isPathParam || isQueryParam {
 			if paramValue == "" {
 				if optional {
 					continue
@@ -151,6 +156,8 @@ const (
 	ContentTypeUpsertBatch = "application/upsert-data"
 	// ContentTypeHyperLogLog defines the hyperloglog query result content type.
 	ContentTypeHyperLogLog = "application/hll"
+	// ContentTypeCSV defines the csv query result content type.
+	ContentTypeCSV = "text/csv"
 	// ContentTypeJSON defines the json content type.
 	ContentTypeJSON = "application/json"
 )
@@ -16,9 +16,12 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/uber/aresdb/metastore"
+	metaCom "github.com/uber/aresdb/metastore/common"
 	"github.com/uber/aresdb/utils"
 
 	"github.com/gorilla/mux"
@@ -47,6 +50,13 @@ func (handler *SchemaHandler) Register(router *mux.Router, wrappers ...utils.HTT
 	router.HandleFunc("/tables/{table}/columns", utils.ApplyHTTPWrappers(handler.AddColumn, wrappers)).Methods(http.MethodPost)
 	router.HandleFunc("/tables/{table}/columns/{column}", utils.ApplyHTTPWrappers(handler.UpdateColumn, wrappers)).Methods(http.MethodPut)
 	router.HandleFunc("/tables/{table}/columns/{column}", utils.ApplyHTTPWrappers(handler.DeleteColumn, wrappers)).Methods(http.MethodDelete)
+	router.HandleFunc("/tables/{table}/columns/{column}/rename", utils.ApplyHTTPWrappers(handler.RenameColumn, wrappers)).Methods(http.MethodPost)
+	router.HandleFunc("/tables/{table}/maintenance", utils.ApplyHTTPWrappers(handler.SetTableMaintenance, wrappers)).Methods(http.MethodPut)
+	router.HandleFunc("/tables/{table}/maintenance", utils.ApplyHTTPWrappers(handler.ClearTableMaintenance, wrappers)).Methods(http.MethodDelete)
+	router.HandleFunc("/maintenance", utils.ApplyHTTPWrappers(handler.GetMaintenance, wrappers)).Methods(http.MethodGet)
+	router.HandleFunc("/maintenance", utils.ApplyHTTPWrappers(handler.SetMaintenance, wrappers)).Methods(http.MethodPut)
+	router.HandleFunc("/maintenance", utils.ApplyHTTPWrappers(handler.ClearMaintenance, wrappers)).Methods(http.MethodDelete)
+	router.HandleFunc("/validate", utils.ApplyHTTPWrappers(handler.ValidateTable, wrappers)).Methods(http.MethodPost)
 }
 
 // RegisterForDebug register handlers for debug port
@@ -170,7 +180,7 @@ func (handler *SchemaHandler) UpdateTableConfig(w http.ResponseWriter, r *http.R
 }
 
 // DeleteTable swagger:route DELETE /schema/tables/{table} deleteTable
-// delete table from metaStore
+// delete table from metaStore, the data of the table is purged once queries over it complete
 //
 // Responses:
 //    default: errorResponse
@@ -185,6 +195,13 @@ func (handler *SchemaHandler) DeleteTable(w http.ResponseWriter, r *http.Request
 
 	err = handler.metaStore.DeleteTable(deleteTableRequest.TableName)
 	if err != nil {
+		if err.Error() == metastore.ErrTableDoesNotExist.Error() {
+			RespondWithError(w, utils.APIError{
+				Code:    http.StatusNotFound,
+				Message: err.Error(),
+			})
+			return
+		}
 		// TODO: need mapping from metaStore error to api error
 		/// for metaStore error might also be user error
 		RespondWithError(w, err)
@@ -280,3 +297,220 @@ func (handler *SchemaHandler) DeleteColumn(w http.ResponseWriter, r *http.Reques
 
 	RespondWithJSONObject(w, nil)
 }
+
+// RenameColumn swagger:route POST /schema/tables/{table}/columns/{column}/rename renameColumn
+// rename column of existing table, data of the column is kept and
+// queried with the new name
+//
+// Consumes:
+//    - application/json
+//
+// Responses:
+//    default: errorResponse
+//        200: noContentResponse
+func (handler *SchemaHandler) RenameColumn(w http.ResponseWriter, r *http.Request) {
+	var renameColumnRequest RenameColumnRequest
+
+	err := ReadRequest(r, &renameColumnRequest)
+	if err != nil {
+		RespondWithError(w, err)
+		return
+	}
+
+	if err = handler.metaStore.RenameColumn(renameColumnRequest.TableName,
+		renameColumnRequest.ColumnName, renameColumnRequest.Body.Name); err != nil {
+		RespondWithError(w, err)
+		return
+	}
+
+	RespondWithJSONObject(w, nil)
+}
+
+// ValidateTable swagger:route POST /schema/validate validateTable
+// validate the table schema without applying it, the schema of an existing table
+// is validated as an update to it
+//
+// Consumes:
+//    - application/json
+//
+// Produces:
+//    - application/json
+//
+// Responses:
+//    default: errorResponse
+//        200: validateTableResponse
+//        400: validateTableResponse
+func (handler *SchemaHandler) ValidateTable(w http.ResponseWriter, r *http.Request) {
+	var validateTableRequest ValidateTableRequest
+	err := ReadRequest(r, &validateTableRequest)
+	if err != nil {
+		RespondWithBadRequest(w, err)
+		return
+	}
+
+	validator := metastore.NewTableSchameValidator()
+	validator.SetNewTable(validateTableRequest.Body)
+	oldTable, err := handler.metaStore.GetTable(validateTableRequest.Body.Name)
+	if err == nil {
+		validator.SetOldTable(*oldTable)
+	} else if err.Error() != metastore.ErrTableDoesNotExist.Error() {
+		RespondWithError(w, err)
+		return
+	}
+
+	var response ValidateTableResponse
+	for _, err := range validator.ValidateAll() {
+		response.Body.Errors = append(response.Body.Errors, err.Error())
+	}
+	response.Body.Valid = len(response.Body.Errors) == 0
+	if !response.Body.Valid {
+		RespondJSONObjectWithCode(w, http.StatusBadRequest, response.Body)
+		return
+	}
+	RespondWithJSONObject(w, response.Body)
+}
+
+// GetMaintenance swagger:route GET /schema/maintenance getMaintenance
+// get the cluster level maintenance, null if not set
+//
+// Produces:
+//    - application/json
+//
+// Responses:
+//    default: errorResponse
+//        200: getMaintenanceResponse
+func (handler *SchemaHandler) GetMaintenance(w http.ResponseWriter, r *http.Request) {
+	var response GetMaintenanceResponse
+	maintenance, err := handler.metaStore.GetMaintenance()
+	if err != nil {
+		RespondWithError(w, err)
+		return
+	}
+	response.Body = maintenance
+	RespondWithJSONObject(w, response.Body)
+}
+
+// SetMaintenance swagger:route PUT /schema/maintenance setMaintenance
+// reject ingestion of all tables until the maintenance expires, queries are served normally
+//
+// Consumes:
+//    - application/json
+//
+// Responses:
+//    default: errorResponse
+//        200: noContentResponse
+func (handler *SchemaHandler) SetMaintenance(w http.ResponseWriter, r *http.Request) {
+	var request SetMaintenanceRequest
+	err := ReadRequest(r, &request)
+	if err != nil {
+		RespondWithBadRequest(w, err)
+		return
+	}
+
+	maintenance, err := newMaintenance(request.Body)
+	if err != nil {
+		RespondWithBadRequest(w, err)
+		return
+	}
+
+	if err = handler.metaStore.UpdateMaintenance(maintenance); err != nil {
+		RespondWithError(w, err)
+		return
+	}
+	RespondWithJSONObject(w, nil)
+}
+
+// ClearMaintenance swagger:route DELETE /schema/maintenance clearMaintenance
+// clear the cluster level maintenance
+//
+// Responses:
+//    default: errorResponse
+//        200: noContentResponse
+func (handler *SchemaHandler) ClearMaintenance(w http.ResponseWriter, r *http.Request) {
+	if err := handler.metaStore.UpdateMaintenance(nil); err != nil {
+		RespondWithError(w, err)
+		return
+	}
+	RespondWithJSONObject(w, nil)
+}
+
+// SetTableMaintenance swagger:route PUT /schema/tables/{table}/maintenance setTableMaintenance
+// reject ingestion of the table until the maintenance expires, queries are served normally
+//
+// Consumes:
+//    - application/json
+//
+// Responses:
+//    default: errorResponse
+//        200: noContentResponse
+func (handler *SchemaHandler) SetTableMaintenance(w http.ResponseWriter, r *http.Request) {
+	var request SetTableMaintenanceRequest
+	err := ReadRequest(r, &request)
+	if err != nil {
+		RespondWithBadRequest(w, err)
+		return
+	}
+
+	maintenance, err := newMaintenance(request.Body)
+	if err != nil {
+		RespondWithBadRequest(w, err)
+		return
+	}
+
+	if err = handler.updateTableMaintenance(request.TableName, maintenance); err != nil {
+		RespondWithError(w, err)
+		return
+	}
+	RespondWithJSONObject(w, nil)
+}
+
+// ClearTableMaintenance swagger:route DELETE /schema/tables/{table}/maintenance clearTableMaintenance
+// clear the maintenance of the table
+//
+// Responses:
+//    default: errorResponse
+//        200: noContentResponse
+func (handler *SchemaHandler) ClearTableMaintenance(w http.ResponseWriter, r *http.Request) {
+	var request ClearTableMaintenanceRequest
+	err := ReadRequest(r, &request)
+	if err != nil {
+		RespondWithError(w, err)
+		return
+	}
+
+	if err = handler.updateTableMaintenance(request.TableName, nil); err != nil {
+		RespondWithError(w, err)
+		return
+	}
+	RespondWithJSONObject(w, nil)
+}
+
+// updateTableMaintenance overwrites the maintenance in the config of the table.
+func (handler *SchemaHandler) updateTableMaintenance(tableName string, maintenance *metaCom.Maintenance) error {
+	table, err := handler.metaStore.GetTable(tableName)
+	if err != nil {
+		return err
+	}
+	table.Config.Maintenance = maintenance
+	return handler.metaStore.UpdateTableConfig(tableName, table.Config)
+}
+
+// newMaintenance creates the maintenance expiring after the requested duration, which is capped
+// by the max maintenance duration so that ingestion is never frozen forever.
+func newMaintenance(request MaintenanceRequestBody) (*metaCom.Maintenance, error) {
+	maxDuration := utils.GetConfig().MaxMaintenanceDuration
+	duration := request.DurationSeconds
+	if duration == 0 {
+		duration = maxDuration
+	}
+	if duration <= 0 {
+		return nil, errors.New("must specify a positive durationSeconds for maintenance")
+	}
+	if maxDuration > 0 && duration > maxDuration {
+		return nil, fmt.Errorf("durationSeconds %d exceeds the max maintenance duration %d", duration, maxDuration)
+	}
+	return &metaCom.Maintenance{
+		Reason:    request.Reason,
+		ExpiresAt: utils.Now().Unix() + duration,
+	}, nil
+}
@@ -22,6 +22,8 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"time"
 
 	"github.com/uber/aresdb/memstore"
 	memMocks "github.com/uber/aresdb/memstore/mocks"
@@ -171,6 +173,11 @@ var _ = ginkgo.Describe("SchemaHandler", func() {
 		err = json.Unmarshal(bs, &errResp)
 		Ω(err).Should(BeNil())
 		Ω(errResp.Message).Should(Equal("Failed to delete table"))
+
+		testMetaStore.On("DeleteTable", mock.Anything).Return(metastore.ErrTableDoesNotExist).Once()
+		req, _ = http.NewRequest(http.MethodDelete, fmt.Sprintf("http://%s/schema/tables/%s", hostPort, "unknown"), &bytes.Buffer{})
+		resp, _ = http.DefaultClient.Do(req)
+		Ω(resp.StatusCode).Should(Equal(http.StatusNotFound))
 	})
 
 	ginkgo.It("AddColumn should work", func() {
@@ -229,4 +236,106 @@ var _ = ginkgo.Describe("SchemaHandler", func() {
 		resp, _ = http.DefaultClient.Do(req)
 		Ω(resp.StatusCode).Should(Equal(http.StatusInternalServerError))
 	})
+
+	ginkgo.It("RenameColumn should work", func() {
+		testMetaStore.On("RenameColumn", "testTable", "testColumn", "newColumn").Return(nil).Once()
+		resp, _ := http.Post(fmt.Sprintf("http://%s/schema/tables/%s/columns/%s/rename", hostPort, "testTable", "testColumn"),
+			"application/json", bytes.NewBufferString(`{"name": "newColumn"}`))
+		Ω(resp.StatusCode).Should(Equal(http.StatusOK))
+
+		testMetaStore.On("RenameColumn", "testTable", "testColumn", "otherColumn").
+			Return(metastore.ErrDuplicatedColumnName).Once()
+		resp, _ = http.Post(fmt.Sprintf("http://%s/schema/tables/%s/columns/%s/rename", hostPort, "testTable", "testColumn"),
+			"application/json", bytes.NewBufferString(`{"name": "otherColumn"}`))
+		Ω(resp.StatusCode).Should(Equal(http.StatusInternalServerError))
+	})
+
+	ginkgo.It("ValidateTable should work", func() {
+		validate := func(table metaCom.Table) (int, ValidateTableResponse) {
+			b, err := json.Marshal(table)
+			Ω(err).Should(BeNil())
+			resp, err := http.Post(fmt.Sprintf("http://%s/schema/validate", hostPort), "application/json", bytes.NewReader(b))
+			Ω(err).Should(BeNil())
+			var response ValidateTableResponse
+			respBody, err := ioutil.ReadAll(resp.Body)
+			Ω(err).Should(BeNil())
+			Ω(json.Unmarshal(respBody, &response.Body)).Should(BeNil())
+			return resp.StatusCode, response
+		}
+
+		testMetaStore.On("GetTable", "newTable").Return(nil, metastore.ErrTableDoesNotExist)
+		newTable := metaCom.Table{
+			Name: "newTable",
+			Columns: []metaCom.Column{
+				{Name: "col1", Type: metaCom.Int32},
+				{Name: "col2", Type: metaCom.Float32},
+			},
+			PrimaryKeyColumns: []int{0},
+		}
+		code, response := validate(newTable)
+		Ω(code).Should(Equal(http.StatusOK))
+		Ω(response.Body.Valid).Should(BeTrue())
+		Ω(response.Body.Errors).Should(BeEmpty())
+
+		defaultValue := "abc"
+		newTable.Columns = append(newTable.Columns,
+			metaCom.Column{Name: "COL1", Type: metaCom.Int32},
+			metaCom.Column{Name: "from", Type: metaCom.Int32},
+			metaCom.Column{Name: "col5", Type: metaCom.Int32, DefaultValue: &defaultValue},
+		)
+		newTable.PrimaryKeyColumns = []int{0, 0}
+		code, response = validate(newTable)
+		Ω(code).Should(Equal(http.StatusBadRequest))
+		Ω(response.Body.Valid).Should(BeFalse())
+		Ω(response.Body.Errors).Should(HaveLen(4))
+		Ω(response.Body.Errors[0]).Should(ContainSubstring(metastore.ErrDuplicatedColumnName.Error()))
+		Ω(response.Body.Errors[1]).Should(ContainSubstring(metastore.ErrReservedColumnName.Error()))
+		Ω(response.Body.Errors[2]).ShouldNot(BeEmpty())
+		Ω(response.Body.Errors[3]).Should(Equal(metastore.ErrDuplicatedColumn.Error()))
+
+		// existing tables are validated as updates.
+		testMetaStore.On("GetTable", "testTable").Return(&testTable, nil)
+		updatedTable := testTable
+		updatedTable.Columns = []metaCom.Column{{Name: "col1", Type: metaCom.Int64}}
+		code, response = validate(updatedTable)
+		Ω(code).Should(Equal(http.StatusBadRequest))
+		Ω(response.Body.Errors).Should(Equal([]string{metastore.ErrSchemaUpdateNotAllowed.Error()}))
+	})
+
+	ginkgo.It("Maintenance should work", func() {
+		utils.SetCurrentTime(time.Unix(1000, 0))
+		defer utils.ResetClockImplementation()
+		do := func(method, url, body string) int {
+			req, _ := http.NewRequest(method, fmt.Sprintf("http://%s/schema%s", hostPort, url), bytes.NewBufferString(body))
+			resp, err := http.DefaultClient.Do(req)
+			Ω(err).Should(BeNil())
+			return resp.StatusCode
+		}
+
+		maintenance := &metaCom.Maintenance{Reason: "backfill", ExpiresAt: 1600}
+		testMetaStore.On("UpdateMaintenance", maintenance).Return(nil).Once()
+		Ω(do(http.MethodPut, "/maintenance", `{"reason": "backfill", "durationSeconds": 600}`)).Should(Equal(http.StatusOK))
+		Ω(do(http.MethodPut, "/maintenance", `{"reason": "backfill", "durationSeconds": -1}`)).Should(Equal(http.StatusBadRequest))
+
+		testMetaStore.On("GetMaintenance").Return(maintenance, nil).Once()
+		resp, err := http.Get(fmt.Sprintf("http://%s/schema/maintenance", hostPort))
+		Ω(err).Should(BeNil())
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		Ω(string(respBody)).Should(MatchJSON(`{"reason": "backfill", "expiresAt": 1600}`))
+
+		testMetaStore.On("UpdateMaintenance", (*metaCom.Maintenance)(nil)).Return(nil).Once()
+		Ω(do(http.MethodDelete, "/maintenance", "")).Should(Equal(http.StatusOK))
+
+		table := testTable
+		table.Name = "maintained"
+		testMetaStore.On("GetTable", "maintained").Return(&table, nil)
+		testMetaStore.On("UpdateTableConfig", "maintained", mock.MatchedBy(func(config metaCom.TableConfig) bool {
+			return reflect.DeepEqual(config.Maintenance, maintenance)
+		})).Return(nil).Once()
+		Ω(do(http.MethodPut, "/tables/maintained/maintenance", `{"reason": "backfill", "durationSeconds": 600}`)).Should(Equal(http.StatusOK))
+		testMetaStore.On("UpdateTableConfig", "maintained", mock.MatchedBy(func(config metaCom.TableConfig) bool {
+			return config.Maintenance == nil
+		})).Return(nil).Once()
+		Ω(do(http.MethodDelete, "/tables/maintained/maintenance", "")).Should(Equal(http.StatusOK))
+	})
 })
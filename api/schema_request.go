@@ -32,6 +32,13 @@ type AddTableRequest struct {
 	Body metaCom.Table `body:""`
 }
 
+// ValidateTableRequest represents ValidateTable request.
+// swagger:parameters validateTable
+type ValidateTableRequest struct {
+	// in: body
+	Body metaCom.Table `body:""`
+}
+
 // AddColumnRequest represents AddColumn request.
 // swagger:parameters addColumn
 type AddColumnRequest struct {
@@ -70,6 +77,20 @@ type DeleteColumnRequest struct {
 	ColumnName string `path:"column" json:"column"`
 }
 
+// RenameColumnRequest represents RenameColumn request.
+// swagger:parameters renameColumn
+type RenameColumnRequest struct {
+	// in: path
+	TableName string `path:"table" json:"table"`
+	// in: path
+	ColumnName string `path:"column" json:"column"`
+	// in: body
+	Body struct {
+		// New name of the column.
+		Name string `json:"name"`
+	} `body:""`
+}
+
 // ListEnumCasesRequest represents ListEnumCases request.
 // swagger:parameters listEnumCases
 type ListEnumCasesRequest struct {
@@ -105,3 +126,34 @@ type AddEnumCaseRequest struct {
 		EnumCases []string `json:"enumCases"`
 	} `body:""`
 }
+
+// MaintenanceRequestBody is the body of maintenance requests.
+type MaintenanceRequestBody struct {
+	// Reason returned to rejected ingestion requests.
+	Reason string `json:"reason"`
+	// Seconds after which the maintenance expires, 0 means the max maintenance duration.
+	DurationSeconds int64 `json:"durationSeconds"`
+}
+
+// SetMaintenanceRequest represents SetMaintenance request.
+// swagger:parameters setMaintenance
+type SetMaintenanceRequest struct {
+	// in: body
+	Body MaintenanceRequestBody `body:""`
+}
+
+// SetTableMaintenanceRequest represents SetTableMaintenance request.
+// swagger:parameters setTableMaintenance
+type SetTableMaintenanceRequest struct {
+	// in: path
+	TableName string `path:"table" json:"table"`
+	// in: body
+	Body MaintenanceRequestBody `body:""`
+}
+
+// ClearTableMaintenanceRequest represents ClearTableMaintenance request.
+// swagger:parameters clearTableMaintenance
+type ClearTableMaintenanceRequest struct {
+	// in: path
+	TableName string `path:"table" json:"table"`
+}
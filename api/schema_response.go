@@ -40,3 +40,20 @@ type ListEnumCasesResponse struct {
 	EnumCases  []string
 	JSONBuffer []byte `json:"-"`
 }
+
+// ValidateTableResponse represents ValidateTable response.
+// swagger:response validateTableResponse
+type ValidateTableResponse struct {
+	//in: body
+	Body struct {
+		Valid  bool     `json:"valid"`
+		Errors []string `json:"errors,omitempty"`
+	}
+}
+
+// GetMaintenanceResponse represents GetMaintenance response.
+// swagger:response getMaintenanceResponse
+type GetMaintenanceResponse struct {
+	//in: body
+	Body *metaCom.Maintenance
+}
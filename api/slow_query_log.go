@@ -0,0 +1,86 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/uber/aresdb/query"
+	"github.com/uber/aresdb/utils"
+)
+
+// SlowQueryLogConfig is the configuration of the slow query log.
+type SlowQueryLogConfig struct {
+	// milliseconds a query takes before it's logged, 0 disables the log.
+	ThresholdInMilliseconds int `json:"thresholdInMilliseconds"`
+}
+
+// slowQueryLog emits the queries taking longer than its threshold in wall-clock time to the query
+// logger with their normalized AQL, tenant, rows scanned and the stage timings of profiled queries.
+type slowQueryLog struct {
+	// threshold in nanoseconds, accessed atomically.
+	threshold int64
+}
+
+func newSlowQueryLog(threshold time.Duration) *slowQueryLog {
+	return &slowQueryLog{threshold: int64(threshold)}
+}
+
+// Threshold returns the duration a query takes before it's logged, 0 means the log is disabled.
+func (l *slowQueryLog) Threshold() time.Duration {
+	return time.Duration(atomic.LoadInt64(&l.threshold))
+}
+
+// SetThreshold changes the threshold of the log, it applies to queries finishing afterwards.
+func (l *slowQueryLog) SetThreshold(threshold time.Duration) {
+	atomic.StoreInt64(&l.threshold, int64(threshold))
+}
+
+// log logs the query of the tenant if it took longer than the threshold. normalizedQuery is the
+// query normalized before compilation, the stage timings are only logged for profiled queries.
+func (l *slowQueryLog) log(qc *query.AQLQueryContext, normalizedQuery []byte, tenant string, duration time.Duration) {
+	threshold := l.Threshold()
+	if threshold <= 0 || duration < threshold || qc == nil || qc.Query == nil {
+		return
+	}
+
+	var errStr string
+	if qc.Error != nil {
+		errStr = qc.Error.Error()
+	}
+	var stages map[string]float64
+	if qc.Profile != nil {
+		stages = make(map[string]float64)
+		qc.Profile.Lock()
+		for stage, milliseconds := range qc.Profile.Stages {
+			stages[stage] = milliseconds
+		}
+		qc.Profile.Unlock()
+	}
+
+	utils.GetRootReporter().GetChildCounter(map[string]string{
+		"table": qc.Query.Table,
+	}, utils.SlowQueries).Inc(1)
+	utils.GetQueryLogger().With(
+		"query", string(normalizedQuery),
+		"table", qc.Query.Table,
+		"tenant", tenant,
+		"duration", duration,
+		"rowsScanned", qc.RowsScanned(),
+		"stages", stages,
+		"error", errStr,
+	).Warn("Slow query")
+}
@@ -0,0 +1,97 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber-go/tally"
+	"github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/query"
+	"github.com/uber/aresdb/utils"
+)
+
+// recordingLogger records the fields of the warnings logged, other log levels are not supported.
+type recordingLogger struct {
+	common.Logger
+	fields   []interface{}
+	warnings *[]map[interface{}]interface{}
+}
+
+func (l *recordingLogger) With(args ...interface{}) common.Logger {
+	return &recordingLogger{fields: append(l.fields, args...), warnings: l.warnings}
+}
+
+func (l *recordingLogger) Warn(args ...interface{}) {
+	fields := make(map[interface{}]interface{})
+	for i := 0; i+1 < len(l.fields); i += 2 {
+		fields[l.fields[i]] = l.fields[i+1]
+	}
+	*l.warnings = append(*l.warnings, fields)
+}
+
+var _ = ginkgo.Describe("slow query log", func() {
+	var warnings []map[interface{}]interface{}
+
+	ginkgo.BeforeEach(func() {
+		warnings = nil
+		utils.Init(common.AresServerConfig{}, utils.GetLogger(), &recordingLogger{warnings: &warnings},
+			tally.NewTestScope("test", nil))
+	})
+
+	ginkgo.AfterEach(func() {
+		utils.ResetDefaults()
+	})
+
+	ginkgo.It("logs queries over the threshold", func() {
+		qc := &query.AQLQueryContext{
+			Query:   &query.AQLQuery{Table: "trips", Measures: []query.Measure{{Expr: "count(*)"}}},
+			Profile: query.NewQueryProfile(),
+		}
+		qc.Profile.Record(query.ProfileStageFilter, 80*time.Millisecond)
+
+		log := newSlowQueryLog(100 * time.Millisecond)
+		log.log(qc, normalizeQuery(*qc.Query), "dashboard", 99*time.Millisecond)
+		Ω(warnings).Should(BeEmpty())
+
+		log.log(qc, normalizeQuery(*qc.Query), "dashboard", 120*time.Millisecond)
+		Ω(warnings).Should(HaveLen(1))
+		Ω(warnings[0]["table"]).Should(Equal("trips"))
+		Ω(warnings[0]["tenant"]).Should(Equal("dashboard"))
+		Ω(warnings[0]["duration"]).Should(Equal(120 * time.Millisecond))
+		Ω(warnings[0]["query"]).Should(ContainSubstring(`"count(*)"`))
+		Ω(warnings[0]["stages"]).Should(Equal(map[string]float64{query.ProfileStageFilter: 80}))
+		testScope := utils.GetRootReporter().GetRootScope().(tally.TestScope)
+		Ω(testScope.Snapshot().Counters()["test.slow_queries+component=query,table=trips"].Value()).
+			Should(BeEquivalentTo(1))
+
+		// stage timings are only logged for profiled queries.
+		log.log(&query.AQLQueryContext{Query: qc.Query}, normalizeQuery(*qc.Query), "dashboard", time.Second)
+		Ω(warnings).Should(HaveLen(2))
+		Ω(warnings[1]["stages"]).Should(BeNil())
+		warnings = warnings[:1]
+
+		// the threshold is reloadable, 0 disables the log.
+		log.SetThreshold(0)
+		log.log(qc, normalizeQuery(*qc.Query), "dashboard", time.Hour)
+		Ω(warnings).Should(HaveLen(1))
+		log.SetThreshold(time.Millisecond)
+		Ω(log.Threshold()).Should(Equal(time.Millisecond))
+		log.log(qc, normalizeQuery(*qc.Query), "dashboard", 2*time.Millisecond)
+		Ω(warnings).Should(HaveLen(2))
+	})
+})
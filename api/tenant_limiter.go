@@ -0,0 +1,325 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/query"
+	"github.com/uber/aresdb/utils"
+)
+
+// otherTenants is the usage bucket and metrics tag shared by the tenants not listed in the config.
+const otherTenants = "other"
+
+// unknownTenant is the tenant of requests without a trusted tenant header.
+const unknownTenant = "UNKNOWN"
+
+// tenantLimiterSweepInterval is the min interval between two evictions of idle usages.
+const tenantLimiterSweepInterval = time.Minute
+
+// tenantLimiter limits the number of concurrent queries, the qps and the number of query
+// subscriptions of each tenant.
+// The qps limit is enforced by a token bucket per tenant holding up to Burst tokens,
+// refilled QPS tokens per second. Only the tenants listed in the config have their own usage,
+// the other tenants share the usage of otherTenants limited by the default limit, so the
+// number of usages and metrics tags is bounded by the config.
+type tenantLimiter struct {
+	sync.Mutex
+	cfg     common.TenantLimitsConfig
+	tenants map[string]*tenantUsage
+	// time of the last eviction of idle usages.
+	sweptAt time.Time
+}
+
+// tenantUsage is the current usage of a tenant.
+type tenantUsage struct {
+	bucket  string
+	running int
+	// number of open query subscriptions.
+	subscriptions int
+	// tokens left in the bucket when it was last refilled.
+	tokens     float64
+	refilledAt time.Time
+}
+
+func newTenantLimiter(cfg common.TenantLimitsConfig) *tenantLimiter {
+	return &tenantLimiter{
+		cfg:     cfg,
+		tenants: make(map[string]*tenantUsage),
+	}
+}
+
+// Config returns the current limits.
+func (l *tenantLimiter) Config() common.TenantLimitsConfig {
+	l.Lock()
+	defer l.Unlock()
+	return l.cfg
+}
+
+// SetConfig replaces the limits, which apply to the queries received afterwards.
+func (l *tenantLimiter) SetConfig(cfg common.TenantLimitsConfig) {
+	l.Lock()
+	defer l.Unlock()
+	l.cfg = cfg
+}
+
+// Running returns the number of running queries by tenant.
+func (l *tenantLimiter) Running() map[string]int {
+	l.Lock()
+	defer l.Unlock()
+	running := make(map[string]int, len(l.tenants))
+	for tenant, usage := range l.tenants {
+		running[tenant] = usage.running
+	}
+	return running
+}
+
+// Wrap rejects the queries of a tenant with 429 when it exceeds its limits.
+func (l *tenantLimiter) Wrap(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenant := l.tenant(r)
+		usage, err := l.acquire(tenant)
+		if err != nil {
+			utils.GetRootReporter().GetChildCounter(map[string]string{"tenant": err.bucket}, utils.TenantThrottledQueries).Inc(1)
+			w.Header().Set("Retry-After", strconv.Itoa(err.retryAfter))
+			RespondWithError(w, err.APIError)
+			return
+		}
+		defer l.release(usage)
+		h(w, r)
+	}
+}
+
+// tenantLimitError is returned when the tenant exceeds its limits.
+type tenantLimitError struct {
+	utils.APIError
+	// seconds to wait before retrying.
+	retryAfter int
+	// usage bucket of the tenant.
+	bucket string
+}
+
+func (l *tenantLimiter) tenant(r *http.Request) string {
+	l.Lock()
+	cfg := l.cfg
+	l.Unlock()
+	return getTenant(r, cfg)
+}
+
+// getTenant returns the tenant of the request identified by the tenant header of the config, or
+// by the RPC-Caller header if the header is empty. The header is only trusted if the request comes
+// from one of the trusted proxies, which authenticate the clients and set the header, otherwise
+// any client could claim to be any tenant.
+func getTenant(r *http.Request, cfg common.TenantLimitsConfig) string {
+	if !fromTrustedProxy(r, cfg.TrustedProxies) {
+		return unknownTenant
+	}
+	if cfg.Header == "" {
+		return utils.GetOrigin(r)
+	}
+	if tenant := r.Header.Get(cfg.Header); tenant != "" {
+		return tenant
+	}
+	return unknownTenant
+}
+
+// fromTrustedProxy tells whether the request is sent from an address in one of the CIDRs, or from
+// a loopback address if there are none. Invalid CIDRs are rejected by validateTenantLimitsConfig
+// and never match.
+func fromTrustedProxy(r *http.Request, trustedProxies []string) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	if len(trustedProxies) == 0 {
+		return ip.IsLoopback()
+	}
+	for _, cidr := range trustedProxies {
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateTenantLimitsConfig returns an error if the trusted proxies of the config are not CIDRs.
+func validateTenantLimitsConfig(cfg common.TenantLimitsConfig) error {
+	for _, cidr := range cfg.TrustedProxies {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return utils.StackError(err, "invalid trusted proxy %s", cidr)
+		}
+	}
+	return nil
+}
+
+// queryLimits returns the limits of the queries of the tenant of the request, the limits set for the
+// tenant override the defaults.
+func (l *tenantLimiter) queryLimits(r *http.Request, defaults query.QueryLimits) query.QueryLimits {
+	tenant := l.tenant(r)
+	l.Lock()
+	defer l.Unlock()
+	limit, ok := l.cfg.Tenants[tenant]
+	if !ok {
+		limit = l.cfg.Default
+	}
+	if limit.MaxRowsScanned > 0 {
+		defaults.MaxRowsScanned = limit.MaxRowsScanned
+	}
+	if limit.MaxResultRows > 0 {
+		defaults.MaxResultRows = limit.MaxResultRows
+	}
+	return defaults
+}
+
+// bucket returns the usage bucket and the limit of the tenant, the caller must hold the lock.
+func (l *tenantLimiter) bucket(tenant string) (string, common.TenantLimit) {
+	if limit, ok := l.cfg.Tenants[tenant]; ok {
+		return tenant, limit
+	}
+	return otherTenants, l.cfg.Default
+}
+
+// acquire admits a query of the tenant, release must be called with the returned usage after the
+// query finishes.
+func (l *tenantLimiter) acquire(tenant string) (*tenantUsage, *tenantLimitError) {
+	l.Lock()
+	defer l.Unlock()
+
+	bucket, limit := l.bucket(tenant)
+	now := utils.Now()
+	if now.Sub(l.sweptAt) >= tenantLimiterSweepInterval {
+		l.evictIdle(now)
+	}
+	usage := l.tenants[bucket]
+	if usage == nil {
+		usage = &tenantUsage{bucket: bucket, tokens: float64(burst(limit)), refilledAt: now}
+		l.tenants[bucket] = usage
+	}
+
+	if limit.MaxConcurrentQueries > 0 && usage.running >= limit.MaxConcurrentQueries {
+		return nil, &tenantLimitError{
+			APIError: utils.APIError{
+				Code:    http.StatusTooManyRequests,
+				Message: "Too many concurrent queries of tenant " + tenant,
+			},
+			retryAfter: 1,
+			bucket:     bucket,
+		}
+	}
+
+	if limit.QPS > 0 {
+		usage.tokens = math.Min(float64(burst(limit)), usage.tokens+now.Sub(usage.refilledAt).Seconds()*limit.QPS)
+		usage.refilledAt = now
+		if usage.tokens < 1 {
+			return nil, &tenantLimitError{
+				APIError: utils.APIError{
+					Code:    http.StatusTooManyRequests,
+					Message: "Too many queries per second of tenant " + tenant,
+				},
+				retryAfter: int(math.Ceil((1 - usage.tokens) / limit.QPS)),
+				bucket:     bucket,
+			}
+		}
+		usage.tokens--
+	}
+
+	usage.running++
+	utils.GetRootReporter().GetChildGauge(map[string]string{"tenant": bucket}, utils.TenantRunningQueries).Update(float64(usage.running))
+	return usage, nil
+}
+
+func (l *tenantLimiter) release(usage *tenantUsage) {
+	l.Lock()
+	defer l.Unlock()
+	usage.running--
+	utils.GetRootReporter().GetChildGauge(map[string]string{"tenant": usage.bucket}, utils.TenantRunningQueries).Update(float64(usage.running))
+}
+
+// acquireSubscription admits a query subscription of the tenant, releaseSubscription must be called
+// with the returned usage after the subscriber disconnects. Subscriptions are long lived, so they are
+// only limited by number and not counted as concurrent queries.
+func (l *tenantLimiter) acquireSubscription(tenant string) (*tenantUsage, *tenantLimitError) {
+	l.Lock()
+	defer l.Unlock()
+
+	bucket, limit := l.bucket(tenant)
+	usage := l.tenants[bucket]
+	if usage == nil {
+		usage = &tenantUsage{bucket: bucket, tokens: float64(burst(limit)), refilledAt: utils.Now()}
+		l.tenants[bucket] = usage
+	}
+	if limit.MaxSubscriptions > 0 && usage.subscriptions >= limit.MaxSubscriptions {
+		return nil, &tenantLimitError{
+			APIError: utils.APIError{
+				Code:    http.StatusTooManyRequests,
+				Message: "Too many query subscriptions of tenant " + tenant,
+			},
+			retryAfter: 1,
+			bucket:     bucket,
+		}
+	}
+
+	usage.subscriptions++
+	utils.GetRootReporter().GetChildGauge(map[string]string{"tenant": bucket}, utils.TenantQuerySubscriptions).Update(float64(usage.subscriptions))
+	return usage, nil
+}
+
+func (l *tenantLimiter) releaseSubscription(usage *tenantUsage) {
+	l.Lock()
+	defer l.Unlock()
+	usage.subscriptions--
+	utils.GetRootReporter().GetChildGauge(map[string]string{"tenant": usage.bucket}, utils.TenantQuerySubscriptions).Update(float64(usage.subscriptions))
+}
+
+// evictIdle removes the usages without running queries or subscriptions whose token bucket is full again, which
+// are recreated the same on the next query, and the usages of tenants removed from the config.
+// The caller must hold the lock.
+func (l *tenantLimiter) evictIdle(now time.Time) {
+	l.sweptAt = now
+	for bucket, usage := range l.tenants {
+		if usage.running > 0 || usage.subscriptions > 0 {
+			continue
+		}
+		_, configured := l.cfg.Tenants[bucket]
+		if bucket != otherTenants && !configured {
+			delete(l.tenants, bucket)
+			continue
+		}
+		_, limit := l.bucket(bucket)
+		if limit.QPS <= 0 ||
+			usage.tokens+now.Sub(usage.refilledAt).Seconds()*limit.QPS >= float64(burst(limit)) {
+			delete(l.tenants, bucket)
+		}
+	}
+}
+
+// burst returns the capacity of the token bucket of the limit.
+func burst(limit common.TenantLimit) int {
+	if limit.Burst > 0 {
+		return limit.Burst
+	}
+	return int(math.Ceil(limit.QPS))
+}
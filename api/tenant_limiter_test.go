@@ -0,0 +1,215 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/query"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("tenant limiter", func() {
+	var limiter *tenantLimiter
+	var handler http.HandlerFunc
+	var unblock chan struct{}
+	var now time.Time
+
+	ginkgo.BeforeEach(func() {
+		limiter = newTenantLimiter(common.TenantLimitsConfig{
+			Header: "X-Tenant",
+			Tenants: map[string]common.TenantLimit{
+				"dashboard": {MaxConcurrentQueries: 1},
+				"batch":     {QPS: 1, Burst: 2},
+			},
+		})
+		unblock = make(chan struct{})
+		handler = limiter.Wrap(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("block") != "" {
+				<-unblock
+			}
+			w.WriteHeader(http.StatusOK)
+		})
+
+		now = time.Unix(1000, 0)
+		utils.SetClockImplementation(func() time.Time {
+			return now
+		})
+	})
+
+	ginkgo.AfterEach(func() {
+		utils.ResetClockImplementation()
+	})
+
+	serve := func(tenant string, block bool) *httptest.ResponseRecorder {
+		url := "/query/aql"
+		if block {
+			url += "?block=1"
+		}
+		r := httptest.NewRequest(http.MethodPost, url, nil)
+		r.RemoteAddr = "127.0.0.1:1234"
+		if tenant != "" {
+			r.Header.Set("X-Tenant", tenant)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	ginkgo.It("throttles tenants over their concurrency cap while others proceed", func() {
+		done := make(chan int)
+		go func() {
+			done <- serve("dashboard", true).Code
+		}()
+		Eventually(func() int { return limiter.Running()["dashboard"] }).Should(Equal(1))
+
+		w := serve("dashboard", false)
+		Ω(w.Code).Should(Equal(http.StatusTooManyRequests))
+		Ω(w.Header().Get("Retry-After")).Should(Equal("1"))
+		Ω(w.Body.String()).Should(ContainSubstring("Too many concurrent queries of tenant dashboard"))
+
+		// other tenants and queries without tenant are not limited, and share the usage of other
+		// tenants.
+		Ω(serve("unlisted", false).Code).Should(Equal(http.StatusOK))
+		Ω(serve("", false).Code).Should(Equal(http.StatusOK))
+		Ω(limiter.Running()).Should(Equal(map[string]int{"dashboard": 1, "other": 0}))
+
+		close(unblock)
+		Ω(<-done).Should(Equal(http.StatusOK))
+		Ω(serve("dashboard", false).Code).Should(Equal(http.StatusOK))
+	})
+
+	ginkgo.It("throttles tenants over their qps", func() {
+		// burst of 2 queries is allowed.
+		Ω(serve("batch", false).Code).Should(Equal(http.StatusOK))
+		Ω(serve("batch", false).Code).Should(Equal(http.StatusOK))
+		w := serve("batch", false)
+		Ω(w.Code).Should(Equal(http.StatusTooManyRequests))
+		Ω(w.Header().Get("Retry-After")).Should(Equal("1"))
+		Ω(w.Body.String()).Should(ContainSubstring("Too many queries per second of tenant batch"))
+		Ω(serve("other", false).Code).Should(Equal(http.StatusOK))
+
+		// refilled by 1 token per second.
+		now = now.Add(500 * time.Millisecond)
+		Ω(serve("batch", false).Code).Should(Equal(http.StatusTooManyRequests))
+		now = now.Add(500 * time.Millisecond)
+		Ω(serve("batch", false).Code).Should(Equal(http.StatusOK))
+		Ω(serve("batch", false).Code).Should(Equal(http.StatusTooManyRequests))
+
+		// bucket does not grow beyond burst.
+		now = now.Add(time.Minute)
+		Ω(serve("batch", false).Code).Should(Equal(http.StatusOK))
+		Ω(serve("batch", false).Code).Should(Equal(http.StatusOK))
+		Ω(serve("batch", false).Code).Should(Equal(http.StatusTooManyRequests))
+	})
+
+	ginkgo.It("applies new limits without restart", func() {
+		Ω(serve("batch", false).Code).Should(Equal(http.StatusOK))
+		Ω(serve("batch", false).Code).Should(Equal(http.StatusOK))
+		Ω(serve("batch", false).Code).Should(Equal(http.StatusTooManyRequests))
+
+		limiter.SetConfig(common.TenantLimitsConfig{
+			Header:  "X-Tenant",
+			Default: common.TenantLimit{QPS: 0.5},
+		})
+		Ω(limiter.Config().Default.QPS).Should(Equal(0.5))
+		// batch falls back to the default limit.
+		now = now.Add(2 * time.Second)
+		Ω(serve("batch", false).Code).Should(Equal(http.StatusOK))
+		w := serve("batch", false)
+		Ω(w.Code).Should(Equal(http.StatusTooManyRequests))
+		Ω(w.Header().Get("Retry-After")).Should(Equal("2"))
+		// dashboard is not capped on concurrency any more, but shares the qps of other tenants.
+		now = now.Add(2 * time.Second)
+		done := make(chan int)
+		go func() {
+			done <- serve("dashboard", true).Code
+		}()
+		Eventually(func() int { return limiter.Running()["other"] }).Should(Equal(1))
+		Ω(serve("batch", false).Code).Should(Equal(http.StatusTooManyRequests))
+		close(unblock)
+		Ω(<-done).Should(Equal(http.StatusOK))
+	})
+
+	ginkgo.It("evicts idle usages", func() {
+		for i := 0; i < 10; i++ {
+			Ω(serve(fmt.Sprintf("tenant%d", i), false).Code).Should(Equal(http.StatusOK))
+		}
+		Ω(serve("batch", false).Code).Should(Equal(http.StatusOK))
+		Ω(serve("dashboard", false).Code).Should(Equal(http.StatusOK))
+		Ω(limiter.Running()).Should(Equal(map[string]int{"batch": 0, "dashboard": 0, "other": 0}))
+
+		// the bucket of batch is not full again yet, the others are idle.
+		now = now.Add(tenantLimiterSweepInterval)
+		batch, err := limiter.acquire("batch")
+		Ω(err).Should(BeNil())
+		limiter.release(batch)
+		Ω(limiter.Running()).Should(Equal(map[string]int{"batch": 0}))
+
+		// usages of tenants removed from the config are evicted.
+		limiter.SetConfig(common.TenantLimitsConfig{Header: "X-Tenant"})
+		now = now.Add(tenantLimiterSweepInterval)
+		Ω(serve("batch", false).Code).Should(Equal(http.StatusOK))
+		Ω(limiter.Running()).Should(Equal(map[string]int{"other": 0}))
+	})
+
+	ginkgo.It("overrides query limits by tenant", func() {
+		limiter.SetConfig(common.TenantLimitsConfig{
+			Header: "X-Tenant",
+			Tenants: map[string]common.TenantLimit{
+				"dashboard": {MaxRowsScanned: 100},
+				"batch":     {MaxRowsScanned: 1000, MaxResultRows: 10},
+			},
+		})
+		defaults := query.QueryLimits{MaxRowsScanned: 500, MaxResultRows: 50}
+		request := func(tenant string) *http.Request {
+			r := httptest.NewRequest(http.MethodPost, "/query/aql", nil)
+			r.RemoteAddr = "127.0.0.1:1234"
+			r.Header.Set("X-Tenant", tenant)
+			return r
+		}
+		Ω(limiter.queryLimits(request("dashboard"), defaults)).Should(Equal(query.QueryLimits{MaxRowsScanned: 100, MaxResultRows: 50}))
+		Ω(limiter.queryLimits(request("batch"), defaults)).Should(Equal(query.QueryLimits{MaxRowsScanned: 1000, MaxResultRows: 10}))
+		Ω(limiter.queryLimits(request("other"), defaults)).Should(Equal(defaults))
+	})
+
+	ginkgo.It("trusts the tenant header only from trusted proxies", func() {
+		request := func(remoteAddr string) *http.Request {
+			r := httptest.NewRequest(http.MethodPost, "/query/aql", nil)
+			r.RemoteAddr = remoteAddr
+			r.Header.Set("X-Tenant", "dashboard")
+			return r
+		}
+		// loopback addresses are trusted by default.
+		Ω(limiter.tenant(request("127.0.0.1:1234"))).Should(Equal("dashboard"))
+		Ω(limiter.tenant(request("[::1]:1234"))).Should(Equal("dashboard"))
+		Ω(limiter.tenant(request("10.0.0.1:1234"))).Should(Equal(unknownTenant))
+
+		cfg := common.TenantLimitsConfig{Header: "X-Tenant", TrustedProxies: []string{"10.0.0.0/24"}}
+		Ω(validateTenantLimitsConfig(cfg)).Should(BeNil())
+		limiter.SetConfig(cfg)
+		Ω(limiter.tenant(request("10.0.0.1:1234"))).Should(Equal("dashboard"))
+		Ω(limiter.tenant(request("10.0.1.1:1234"))).Should(Equal(unknownTenant))
+		Ω(limiter.tenant(request("127.0.0.1:1234"))).Should(Equal(unknownTenant))
+
+		Ω(validateTenantLimitsConfig(common.TenantLimitsConfig{TrustedProxies: []string{"10.0.0.1"}})).ShouldNot(BeNil())
+	})
+})
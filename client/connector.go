@@ -16,11 +16,17 @@ package client
 
 import (
 	"bytes"
+	cryptoRand "crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
+	"net/url"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/uber-go/tally"
@@ -39,7 +45,20 @@ const (
 	// default schema refresh interval in seconds
 	defaultSchemaRefreshInterval = 600
 	dataIngestionHeader          = "application/upsert-data"
+	idempotencyKeyHeader         = "Idempotency-Key"
 	applicationJSONHeader        = "application/json"
+
+	// default initial backoff in milliseconds between retries
+	defaultRetryBackoff = 100
+	// max backoff in milliseconds between retries
+	maxRetryBackoff = 5000
+
+	// default health check interval in seconds
+	defaultHealthCheckInterval = 10
+	// default number of consecutive failed health checks before a host is marked down
+	defaultUnhealthyThreshold = 3
+	// default max number of idle connections kept for each host
+	defaultMaxIdleConnsPerHost = 8
 )
 
 // Row represents a row of insert data.
@@ -52,6 +71,19 @@ type Connector interface {
 	// updateModes are optional, if ignored for all columns, no need to set
 	// if set, then all columns needs to be set
 	Insert(tableName string, columnNames []string, rows []Row, updateModes ...memCom.ColumnUpdateMode) (int, error)
+
+	// PrepareQuery registers an AQL query template whose row filters reference parameters
+	// like $city_id under name, so that it can be executed repeatedly without being parsed again.
+	// query is the AQL query to be marshaled as json.
+	PrepareQuery(name string, query interface{}) error
+
+	// ExecutePreparedQuery executes the prepared query with the parameter values bound,
+	// returns the raw json AQL response.
+	ExecutePreparedQuery(name string, parameters map[string]interface{}) (json.RawMessage, error)
+
+	// Close stops the background schema refresh and health checks and closes
+	// the idle connections in the pool.
+	Close()
 }
 
 // enumCasesWrapper is a response/request body which wraps enum cases
@@ -74,6 +106,7 @@ type connector struct {
 
 	cfg         ConnectorConfig
 	httpClient  http.Client
+	transport   *http.Transport
 	logger      *zap.SugaredLogger
 	metricScope tally.Scope
 
@@ -88,6 +121,21 @@ type connector struct {
 	// map from table to columnID to default enum id. Initialized during bootstrap
 	// and will be set only if default value is non nil.
 	enumDefaultValueMappings map[string]map[int]int
+
+	// index into dataAddresses of the host which served the last successful upsert batch,
+	// upsert batches are sent to it first.
+	activeHost int32
+
+	// health of each host in dataAddresses, the map itself is never modified after creation.
+	hostHealth map[string]*hostHealth
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// hostHealth tracks the consecutive failed health checks of a host.
+type hostHealth struct {
+	consecutiveFailures int32
 }
 
 // ConnectorConfig holds the configurations for ares Connector.
@@ -101,6 +149,26 @@ type ConnectorConfig struct {
 	// fetch and refresh schema from ares
 	// if <= 0, will use default
 	SchemaRefreshInterval int `yaml:"schemaRefreshInterval"`
+	// ReplicaAddresses are the host:port of other replicas serving the same shard,
+	// upsert batches fail over to them when posting to the current host fails.
+	ReplicaAddresses []string `yaml:"replicaAddresses"`
+	// MaxRetries is the max number of retries after a failed post of upsert batch,
+	// each retry goes to the next host. if <= 0, will not retry
+	MaxRetries int `yaml:"maxRetries"`
+	// RetryBackoff is the initial backoff in milliseconds before a retry, it doubles
+	// for each retry up to 5 seconds and is jittered to avoid retrying in lockstep.
+	// if <= 0, will use default
+	RetryBackoff int `yaml:"retryBackoff"`
+	// HealthCheckInterval is the interval in seconds to check the health of each host,
+	// if <= 0, will use default
+	HealthCheckInterval int `yaml:"healthCheckInterval"`
+	// UnhealthyThreshold is the number of consecutive failed health checks after which a host
+	// is excluded from upsert batches until it passes a health check again.
+	// if <= 0, will use default
+	UnhealthyThreshold int `yaml:"unhealthyThreshold"`
+	// MaxIdleConnsPerHost is the max number of idle connections kept in the pool for each host,
+	// if <= 0, will use default
+	MaxIdleConnsPerHost int `yaml:"maxIdleConnsPerHost"`
 }
 
 // NewConnector returns a new ares Connector
@@ -113,6 +181,22 @@ func (cfg ConnectorConfig) NewConnector(logger *zap.SugaredLogger, metricScope t
 		cfg.Timeout = defaultRequestTimeout
 	}
 
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = defaultRetryBackoff
+	}
+
+	if cfg.HealthCheckInterval <= 0 {
+		cfg.HealthCheckInterval = defaultHealthCheckInterval
+	}
+
+	if cfg.UnhealthyThreshold <= 0 {
+		cfg.UnhealthyThreshold = defaultUnhealthyThreshold
+	}
+
+	if cfg.MaxIdleConnsPerHost <= 0 {
+		cfg.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+
 	connector := &connector{
 		cfg:                      cfg,
 		logger:                   logger,
@@ -120,40 +204,114 @@ func (cfg ConnectorConfig) NewConnector(logger *zap.SugaredLogger, metricScope t
 		schemas:                  make(map[string]*tableSchema),
 		enumMappings:             make(map[string]map[int]enumDict),
 		enumDefaultValueMappings: make(map[string]map[int]int),
+		hostHealth:               make(map[string]*hostHealth),
+		done:                     make(chan struct{}),
+	}
+
+	for _, address := range connector.dataAddresses() {
+		connector.hostHealth[address] = &hostHealth{}
 	}
 
 	connector.initHTTPClient()
 
 	err := connector.fetchAllTables()
 	if err != nil {
+		connector.transport.CloseIdleConnections()
 		return nil, err
 	}
 
 	err = connector.fetchAllEnumDicts()
 	if err != nil {
+		connector.transport.CloseIdleConnections()
 		return nil, err
 	}
 
-	go func(refreshInterval int) {
-		ticks := time.Tick(time.Duration(refreshInterval) * time.Second)
-		for range ticks {
-			err = connector.fetchAllTables()
-			if err != nil {
-				logger.With(
-					"error", err.Error()).Errorf("Failed to fetch table schema")
-			}
+	go connector.runPeriodically(cfg.SchemaRefreshInterval, func() {
+		if err := connector.fetchAllTables(); err != nil {
+			logger.With(
+				"error", err.Error()).Errorf("Failed to fetch table schema")
 		}
-	}(cfg.SchemaRefreshInterval)
+	})
+	go connector.runPeriodically(cfg.HealthCheckInterval, connector.checkHealth)
 
 	return connector, nil
 }
 
 func (c *connector) initHTTPClient() {
+	// connections are pooled per host and reused across requests.
+	c.transport = &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		MaxIdleConnsPerHost: c.cfg.MaxIdleConnsPerHost,
+		IdleConnTimeout:     90 * time.Second,
+	}
 	c.httpClient = http.Client{
-		Timeout: time.Duration(c.cfg.Timeout) * time.Second,
+		Transport: c.transport,
+		Timeout:   time.Duration(c.cfg.Timeout) * time.Second,
 	}
 }
 
+// runPeriodically calls fn every interval seconds until the connector is closed.
+func (c *connector) runPeriodically(interval int, fn func()) {
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			fn()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// checkHealth calls the health endpoint of every host. A host is marked down after
+// UnhealthyThreshold consecutive failures and back up once a health check passes.
+func (c *connector) checkHealth() {
+	for address, health := range c.hostHealth {
+		err := c.pingHost(address)
+		if err == nil {
+			if atomic.SwapInt32(&health.consecutiveFailures, 0) >= int32(c.cfg.UnhealthyThreshold) {
+				c.logger.With("host", address).Info("Host is healthy again")
+			}
+			continue
+		}
+
+		if atomic.AddInt32(&health.consecutiveFailures, 1) == int32(c.cfg.UnhealthyThreshold) {
+			c.logger.With(
+				"error", err.Error(),
+				"host", address,
+			).Warn("Host is marked down after failed health checks")
+		}
+	}
+}
+
+func (c *connector) pingHost(address string) error {
+	resp, err := c.httpClient.Get(c.healthPath(address))
+	if err != nil {
+		return err
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return utils.StackError(nil, "Received error response %d from %s", resp.StatusCode, address)
+	}
+	return nil
+}
+
+// isHealthy tells whether the host has not failed UnhealthyThreshold health checks in a row.
+func (c *connector) isHealthy(address string) bool {
+	health, ok := c.hostHealth[address]
+	return !ok || atomic.LoadInt32(&health.consecutiveFailures) < int32(c.cfg.UnhealthyThreshold)
+}
+
+// Close stops the background goroutines and closes the idle connections in the pool.
+func (c *connector) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.transport.CloseIdleConnections()
+	})
+}
+
 // Insert inserts a batch of rows into ares
 func (c *connector) Insert(tableName string, columnNames []string, rows []Row, updateModes ...memCom.ColumnUpdateMode) (int, error) {
 	if len(columnNames) == 0 {
@@ -184,21 +342,195 @@ func (c *connector) Insert(tableName string, columnNames []string, rows []Row, u
 		}
 	}
 
-	upsertBatchBytes, numRows, err := c.prepareUpsertBatch(tableName, columnNames, updateModes, rows)
+	shards, rowsByShard, err := c.partitionRows(tableName, columnNames, rows)
 	if err != nil {
-		return numRows, err
+		return 0, err
 	}
 
-	//TODO: currently always use shard zero for single instance version
-	resp, err := c.httpClient.Post(c.dataPath(tableName, 0), dataIngestionHeader, bytes.NewReader(upsertBatchBytes))
-	if err != nil || resp.StatusCode != http.StatusOK {
-		//TODO: break status code check and error check into two parts for more specific handling like retrying on 5xx
-		return 0, utils.StackError(err, "Failed to post upsert batch, table: %s, shard: %d", tableName, 0)
+	var numRows int
+	for _, shard := range shards {
+		upsertBatchBytes, numShardRows, err := c.prepareUpsertBatch(tableName, columnNames, updateModes, rowsByShard[shard])
+		if err != nil {
+			return numRows + numShardRows, err
+		}
+
+		if err = c.postUpsertBatch(tableName, shard, upsertBatchBytes); err != nil {
+			return numRows, err
+		}
+		numRows += numShardRows
 	}
 
 	return numRows, nil
 }
 
+// partitionRows groups the rows by the shards they belong to in ascending shard order. Rows of
+// tables not hash sharded all belong to shard 0. Rows with invalid shard key values are also kept
+// in shard 0 and rejected when preparing the upsert batch.
+func (c *connector) partitionRows(tableName string, columnNames []string, rows []Row) ([]int, map[int][]Row, error) {
+	schema, err := c.getTableSchema(tableName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	numShards := schema.Table.NumShards
+	shardKeyIndex := -1
+	for i, columnName := range columnNames {
+		if columnID, exist := schema.ColumnDict[columnName]; exist && columnID == schema.Table.ShardKeyColumn {
+			shardKeyIndex = i
+		}
+	}
+	if numShards <= 1 || shardKeyIndex < 0 {
+		return []int{0}, map[int][]Row{0: rows}, nil
+	}
+
+	dataType := memCom.DataTypeForColumn(schema.Table.Columns[schema.Table.ShardKeyColumn])
+	rowsByShard := make(map[int][]Row)
+	for _, row := range rows {
+		shard, _ := memCom.GetShardForValue(row[shardKeyIndex], dataType, numShards)
+		rowsByShard[shard] = append(rowsByShard[shard], row)
+	}
+
+	shards := make([]int, 0, len(rowsByShard))
+	for shard := range rowsByShard {
+		shards = append(shards, shard)
+	}
+	sort.Ints(shards)
+	return shards, rowsByShard, nil
+}
+
+// postUpsertBatch posts the upsert batch to the active host of the shard. On network errors
+// or 5xx responses it retries against the next replica with jittered exponential backoff,
+// until MaxRetries is reached. Hosts marked down by health checks are skipped unless all
+// hosts are down. All attempts carry the same idempotency key, so a host does not apply the
+// batch twice when a timed out attempt actually succeeded.
+func (c *connector) postUpsertBatch(tableName string, shard int, upsertBatchBytes []byte) error {
+	idempotencyKey, err := newIdempotencyKey()
+	if err != nil {
+		return err
+	}
+
+	addresses := c.dataAddresses()
+	hostIndexes := c.selectHosts(addresses)
+	attemptedHosts := make([]string, 0, c.cfg.MaxRetries+1)
+	backoff := c.cfg.RetryBackoff
+
+	for attempt := 0; attempt <= c.cfg.MaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(backoff/2+rand.Intn(backoff/2+1)) * time.Millisecond)
+			if backoff *= 2; backoff > maxRetryBackoff {
+				backoff = maxRetryBackoff
+			}
+		}
+
+		hostIndex := hostIndexes[attempt%len(hostIndexes)]
+		address := addresses[hostIndex]
+		attemptedHosts = append(attemptedHosts, address)
+
+		var req *http.Request
+		req, err = http.NewRequest(http.MethodPost, c.dataPath(address, tableName, shard), bytes.NewReader(upsertBatchBytes))
+		if err != nil {
+			return utils.StackError(err, "Failed to create request")
+		}
+		req.Header.Set("Content-Type", dataIngestionHeader)
+		req.Header.Set(idempotencyKeyHeader, idempotencyKey)
+
+		var resp *http.Response
+		resp, err = c.httpClient.Do(req)
+		if err == nil {
+			respBytes, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				atomic.StoreInt32(&c.activeHost, int32(hostIndex))
+				return nil
+			}
+			err = utils.StackError(nil, "Received error response %d:%s from %s", resp.StatusCode, respBytes, address)
+			if resp.StatusCode < http.StatusInternalServerError {
+				// client errors will fail on any replica.
+				break
+			}
+		}
+
+		c.logger.With(
+			"error", err.Error(),
+			"table", tableName,
+			"shard", shard,
+			"host", address,
+			"attempt", attempt,
+		).Warn("Failed to post upsert batch")
+	}
+
+	return utils.StackError(err, "Failed to post upsert batch, table: %s, shard: %d, attempted hosts: %s",
+		tableName, shard, strings.Join(attemptedHosts, ", "))
+}
+
+// newIdempotencyKey returns a random key identifying an upsert batch across retries.
+func newIdempotencyKey() (string, error) {
+	key := make([]byte, 16)
+	if _, err := cryptoRand.Read(key); err != nil {
+		return "", utils.StackError(err, "Failed to generate idempotency key")
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// PrepareQuery registers an AQL query template under name.
+func (c *connector) PrepareQuery(name string, query interface{}) error {
+	queryBytes, err := json.Marshal(query)
+	if err != nil {
+		return utils.StackError(err, "Failed to marshal query")
+	}
+
+	req, err := http.NewRequest(http.MethodPut, c.preparedQueryPath(name), bytes.NewReader(queryBytes))
+	if err != nil {
+		return utils.StackError(err, "Failed to create request")
+	}
+	req.Header.Set("Content-Type", applicationJSONHeader)
+
+	var preparedQuery json.RawMessage
+	resp, err := c.httpClient.Do(req)
+	if err = c.readJSONResponse(resp, err, &preparedQuery); err != nil {
+		return utils.StackError(err, "Failed to prepare query %s", name)
+	}
+	return nil
+}
+
+// ExecutePreparedQuery executes the prepared query with the parameter values bound.
+func (c *connector) ExecutePreparedQuery(name string, parameters map[string]interface{}) (json.RawMessage, error) {
+	requestBytes, err := json.Marshal(map[string]interface{}{"parameters": parameters})
+	if err != nil {
+		return nil, utils.StackError(err, "Failed to marshal parameters")
+	}
+
+	var response json.RawMessage
+	resp, err := c.httpClient.Post(c.preparedQueryPath(name), applicationJSONHeader, bytes.NewReader(requestBytes))
+	if err = c.readJSONResponse(resp, err, &response); err != nil {
+		return nil, utils.StackError(err, "Failed to execute prepared query %s", name)
+	}
+	return response, nil
+}
+
+// dataAddresses returns all hosts serving the shard, with the primary address first.
+func (c *connector) dataAddresses() []string {
+	return append([]string{c.cfg.Address}, c.cfg.ReplicaAddresses...)
+}
+
+// selectHosts returns the indexes into addresses of the healthy hosts in the order to try,
+// starting from the active host. All hosts are returned if none of them is healthy.
+func (c *connector) selectHosts(addresses []string) []int {
+	activeHost := int(atomic.LoadInt32(&c.activeHost))
+	all := make([]int, len(addresses))
+	healthy := make([]int, 0, len(addresses))
+	for i := range addresses {
+		all[i] = (activeHost + i) % len(addresses)
+		if c.isHealthy(addresses[all[i]]) {
+			healthy = append(healthy, all[i])
+		}
+	}
+	if len(healthy) == 0 {
+		return all
+	}
+	return healthy
+}
+
 // computeHLLValue populate hyperloglog value
 func computeHLLValue(dataType memCom.DataType, value interface{}) (uint32, error) {
 	var ok bool
@@ -321,7 +653,19 @@ func (c *connector) prepareUpsertBatch(tableName string, columnNames []string, u
 				break
 			}
 
-			if column.IsEnumColumn() {
+			if column.IsEnumArrayColumn() {
+				value, err = c.translateEnumArray(tableName, columnID, value, column.CaseInsensitive, column.GetMaxEnumCardinality())
+				if err != nil {
+					upsertBatchBuilder.RemoveRow()
+					c.logger.With(
+						"name", "prepareUpsertBatch",
+						"error", err.Error(),
+						"table", tableName,
+						"columnID", columnID,
+						"value", value).Error("Failed to translate enum array")
+					break
+				}
+			} else if column.IsEnumColumn() {
 				value, err = c.translateEnum(tableName, columnID, value, column.CaseInsensitive)
 				if err != nil {
 					upsertBatchBuilder.RemoveRow()
@@ -416,15 +760,17 @@ func (c *connector) prepareEnumCases(tableName, columnName string, colIndex, col
 			continue
 		}
 
-		if enumCase, ok := value.(string); ok {
-			convertedEnumCase := enumCase
-			if caseInsensitive {
-				convertedEnumCase = strings.ToLower(convertedEnumCase)
-			}
+		if enumCases, ok := toEnumCases(value); ok {
 			c.RLock()
-			// pre creation should make sure the mapping all exists
-			if _, valueExist := c.enumMappings[tableName][columnID][convertedEnumCase]; !valueExist {
-				newEnumCasesSet[enumCase] = nil
+			for _, enumCase := range enumCases {
+				convertedEnumCase := enumCase
+				if caseInsensitive {
+					convertedEnumCase = strings.ToLower(convertedEnumCase)
+				}
+				// pre creation should make sure the mapping all exists
+				if _, valueExist := c.enumMappings[tableName][columnID][convertedEnumCase]; !valueExist {
+					newEnumCasesSet[enumCase] = nil
+				}
 			}
 			c.RUnlock()
 		} else {
@@ -469,6 +815,66 @@ func (c *connector) prepareEnumCases(tableName, columnName string, colIndex, col
 	return c.extendEnumDict(tableName, columnName, columnID, newEnumCases, caseInsensitive)
 }
 
+// toEnumCases returns the enum cases of an enum value, which is a string for enum columns or a
+// list of strings for enum array columns.
+func toEnumCases(value interface{}) ([]string, bool) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, true
+	case []string:
+		return v, true
+	case []interface{}:
+		enumCases := make([]string, len(v))
+		for i, element := range v {
+			enumCase, ok := element.(string)
+			if !ok {
+				return nil, false
+			}
+			enumCases[i] = enumCase
+		}
+		return enumCases, true
+	}
+	return nil, false
+}
+
+// translateEnumArray translates the list of enum cases into the bitmap of their enum ids.
+// Enum cases without enum ids or with enum ids beyond the max enum cardinality are ignored.
+func (c *connector) translateEnumArray(tableName string, columnID int, value interface{}, caseInsensitive bool,
+	maxEnumCardinality int) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	enumCases, ok := toEnumCases(value)
+	if _, isString := value.(string); !ok || isString {
+		return nil, utils.StackError(nil, "Enum array value should be list of strings, but got: %T", value)
+	}
+
+	var bitmap uint32
+	var numIgnored int64
+	c.RLock()
+	for _, enumCase := range enumCases {
+		if caseInsensitive {
+			enumCase = strings.ToLower(enumCase)
+		}
+		enumID, ok := c.enumMappings[tableName][columnID][enumCase]
+		if !ok || enumID < 0 || enumID >= maxEnumCardinality {
+			numIgnored++
+			continue
+		}
+		bitmap |= 1 << uint(enumID)
+	}
+	c.RUnlock()
+	if numIgnored > 0 {
+		c.metricScope.Tagged(
+			map[string]string{
+				"TableName": tableName,
+				"ColumnID":  strconv.Itoa(columnID),
+			},
+		).Counter("new_enum_cases_ignored").Inc(numIgnored)
+	}
+	return bitmap, nil
+}
+
 func (c *connector) translateEnum(tableName string, columnID int, value interface{}, caseInsensitive bool) (enumID int, err error) {
 	if value == nil {
 		return -1, nil
@@ -613,6 +1019,11 @@ func (c *connector) extendEnumDict(tableName, columnName string, columnID int, e
 
 	c.Lock()
 	for index, enumCase := range enumCases {
+		// enum cases beyond the max enum cardinality are not assigned enum ids,
+		// translated as the default value.
+		if enumIDs[index] < 0 {
+			continue
+		}
 		if caseInsensitive {
 			enumCase = strings.ToLower(enumCase)
 		}
@@ -664,24 +1075,7 @@ func (c *connector) fetchTableSchema(tableName string) (*tableSchema, error) {
 }
 
 func (c *connector) readJSONResponse(response *http.Response, err error, data interface{}) error {
-	if err != nil {
-		return utils.StackError(err, "Failed call remote endpoint")
-	}
-
-	respBytes, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		return utils.StackError(err, "Failed to read response body")
-	}
-
-	if response.StatusCode != http.StatusOK {
-		return utils.StackError(nil, "Received error response %d:%s from remote endpoint", response.StatusCode, respBytes)
-	}
-
-	err = json.Unmarshal(respBytes, data)
-	if err != nil {
-		return utils.StackError(err, "Failed to unmarshal json")
-	}
-	return nil
+	return readJSONResponse(response, err, data)
 }
 
 func (c *connector) listTablesPath() string {
@@ -692,10 +1086,18 @@ func (c *connector) tablePath(tableName string) string {
 	return fmt.Sprintf("%s/%s", c.listTablesPath(), tableName)
 }
 
-func (c *connector) dataPath(tableName string, shard int) string {
-	return fmt.Sprintf("http://%s/data/%s/%d", c.cfg.Address, tableName, shard)
+func (c *connector) dataPath(address, tableName string, shard int) string {
+	return fmt.Sprintf("http://%s/data/%s/%d", address, tableName, shard)
+}
+
+func (c *connector) healthPath(address string) string {
+	return fmt.Sprintf("http://%s/health", address)
 }
 
 func (c *connector) enumDictPath(tableName, columnName string) string {
 	return fmt.Sprintf("%s/%s/columns/%s/enum-cases", c.listTablesPath(), tableName, columnName)
 }
+
+func (c *connector) preparedQueryPath(name string) string {
+	return fmt.Sprintf("http://%s/query/prepared/%s", c.cfg.Address, url.PathEscape(name))
+}
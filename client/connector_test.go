@@ -16,6 +16,8 @@ package client
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"regexp"
@@ -29,9 +31,36 @@ import (
 	"github.com/uber/aresdb/utils"
 	"go.uber.org/zap"
 	"io/ioutil"
+	"sync"
 	"time"
 )
 
+// failingTransport fails data and health requests to failedHosts and forwards other requests to target.
+type failingTransport struct {
+	sync.Mutex
+	failedHosts    map[string]bool
+	target         string
+	attemptedHosts []string
+	attemptedPaths []string
+}
+
+func (t *failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.Contains(req.URL.Path, "data") || req.URL.Path == "/health" {
+		t.Lock()
+		if req.URL.Path != "/health" {
+			t.attemptedHosts = append(t.attemptedHosts, req.URL.Host)
+			t.attemptedPaths = append(t.attemptedPaths, req.URL.Path)
+		}
+		failed := t.failedHosts[req.URL.Host]
+		t.Unlock()
+		if failed {
+			return nil, errors.New("connection refused")
+		}
+	}
+	req.URL.Host = t.target
+	return http.DefaultTransport.RoundTrip(req)
+}
+
 var _ = ginkgo.Describe("AresDB connector", func() {
 	var hostPort string
 	var testServer *httptest.Server
@@ -92,7 +121,7 @@ var _ = ginkgo.Describe("AresDB connector", func() {
 	// extendedEnumIDs
 	column2extendedEnumIDs := []int{2}
 
-	var insertBytes []byte
+	var insertBytes, preparedQueryBytes, executeBytes []byte
 	ginkgo.BeforeEach(func() {
 		testServer = httptest.NewUnstartedServer(
 			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -119,6 +148,20 @@ var _ = ginkgo.Describe("AresDB connector", func() {
 						w.WriteHeader(http.StatusOK)
 						w.Write(enumIDBytes)
 					}
+				} else if r.URL.Path == "/query/prepared/trips_by_city" {
+					if r.Method == http.MethodPut {
+						preparedQueryBytes, _ = ioutil.ReadAll(r.Body)
+						w.WriteHeader(http.StatusOK)
+						w.Write([]byte(`{"parameters": {"city_id": "city_id"}}`))
+					} else {
+						executeBytes, _ = ioutil.ReadAll(r.Body)
+						w.WriteHeader(http.StatusOK)
+						w.Write([]byte(`{"results": [{"1": 10}]}`))
+					}
+				} else if r.URL.Path == "/health" {
+					w.WriteHeader(http.StatusOK)
+				} else if strings.HasPrefix(r.URL.Path, "/query/prepared/") {
+					w.WriteHeader(http.StatusNotFound)
 				} else if strings.Contains(r.URL.Path, "data") && r.Method == http.MethodPost {
 					var err error
 					insertBytes, err = ioutil.ReadAll(r.Body)
@@ -258,6 +301,182 @@ var _ = ginkgo.Describe("AresDB connector", func() {
 		Ω(n).Should(Equal(1))
 	})
 
+	ginkgo.It("Insert should fail over to replicas", func() {
+		config := ConnectorConfig{
+			Address:          hostPort,
+			ReplicaAddresses: []string{"replica1:9374", "replica2:9374"},
+			MaxRetries:       2,
+			RetryBackoff:     1,
+		}
+
+		logger := zap.NewExample().Sugar()
+		rootScope, _, _ := common.NewNoopMetrics().NewRootScope()
+		conn, err := config.NewConnector(logger, rootScope)
+		Ω(err).Should(BeNil())
+
+		transport := &failingTransport{
+			failedHosts: map[string]bool{hostPort: true},
+			target:      hostPort,
+		}
+		conn.(*connector).httpClient.Transport = transport
+
+		insertBytes = nil
+		n, err := conn.Insert("a", []string{"col0", "col1"}, []Row{{100, 1}})
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(1))
+		Ω(insertBytes).ShouldNot(BeEmpty())
+		Ω(transport.attemptedHosts).Should(Equal([]string{hostPort, "replica1:9374"}))
+
+		// healthy replica is tried first afterwards.
+		transport.attemptedHosts = nil
+		_, err = conn.Insert("a", []string{"col0", "col1"}, []Row{{100, 1}})
+		Ω(err).Should(BeNil())
+		Ω(transport.attemptedHosts).Should(Equal([]string{"replica1:9374"}))
+
+		// error contains all attempted hosts once retries are exhausted.
+		transport.attemptedHosts = nil
+		transport.failedHosts = map[string]bool{hostPort: true, "replica1:9374": true, "replica2:9374": true}
+		_, err = conn.Insert("a", []string{"col0", "col1"}, []Row{{100, 1}})
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("attempted hosts: replica1:9374, replica2:9374, " + hostPort))
+		Ω(transport.attemptedHosts).Should(HaveLen(3))
+	})
+
+	ginkgo.It("Insert should route rows to shards by shard key", func() {
+		table := testTables["a"]
+		table.NumShards = 4
+		table.ShardKeyColumn = 1
+		testTables["a"] = table
+		defer func() {
+			table.NumShards = 0
+			table.ShardKeyColumn = 0
+			testTables["a"] = table
+		}()
+
+		logger := zap.NewExample().Sugar()
+		rootScope, _, _ := common.NewNoopMetrics().NewRootScope()
+		conn, err := ConnectorConfig{Address: hostPort}.NewConnector(logger, rootScope)
+		Ω(err).Should(BeNil())
+		transport := &failingTransport{target: hostPort}
+		conn.(*connector).httpClient.Transport = transport
+
+		var rows []Row
+		expectedPaths := map[string]bool{}
+		for key := 0; key < 20; key++ {
+			// two versions of each row.
+			rows = append(rows, Row{100, key}, Row{200, key})
+			shard, err := memCom.GetShardForValue(key, memCom.Int32, 4)
+			Ω(err).Should(BeNil())
+			expectedPaths[fmt.Sprintf("/data/a/%d", shard)] = true
+		}
+		n, err := conn.Insert("a", []string{"col0", "col1"}, rows)
+		Ω(err).Should(BeNil())
+		Ω(n).Should(Equal(40))
+		// one upsert batch is posted to each shard holding any of the keys.
+		Ω(transport.attemptedPaths).Should(HaveLen(len(expectedPaths)))
+		for _, path := range transport.attemptedPaths {
+			Ω(expectedPaths).Should(HaveKey(path))
+		}
+
+		// all rows of a key go to the same shard.
+		keyShard, err := memCom.GetShardForValue(7, memCom.Int32, 4)
+		Ω(err).Should(BeNil())
+		transport.attemptedPaths = nil
+		_, err = conn.Insert("a", []string{"col0", "col1"}, []Row{{100, 7}, {300, 7}})
+		Ω(err).Should(BeNil())
+		Ω(transport.attemptedPaths).Should(Equal([]string{fmt.Sprintf("/data/a/%d", keyShard)}))
+	})
+
+	ginkgo.It("Insert should skip hosts marked down until they recover", func() {
+		config := ConnectorConfig{
+			Address:            hostPort,
+			ReplicaAddresses:   []string{"replica1:9374"},
+			MaxRetries:         1,
+			RetryBackoff:       1,
+			UnhealthyThreshold: 2,
+		}
+
+		logger := zap.NewExample().Sugar()
+		rootScope, _, _ := common.NewNoopMetrics().NewRootScope()
+		conn, err := config.NewConnector(logger, rootScope)
+		Ω(err).Should(BeNil())
+		defer conn.Close()
+
+		c := conn.(*connector)
+		transport := &failingTransport{
+			failedHosts: map[string]bool{hostPort: true},
+			target:      hostPort,
+		}
+		c.httpClient.Transport = transport
+
+		// a single failed health check does not mark the host down.
+		c.checkHealth()
+		Ω(c.isHealthy(hostPort)).Should(BeTrue())
+		c.checkHealth()
+		Ω(c.isHealthy(hostPort)).Should(BeFalse())
+		Ω(c.isHealthy("replica1:9374")).Should(BeTrue())
+
+		_, err = conn.Insert("a", []string{"col0", "col1"}, []Row{{100, 1}})
+		Ω(err).Should(BeNil())
+		Ω(transport.attemptedHosts).Should(Equal([]string{"replica1:9374"}))
+
+		// host recovers and is selected again.
+		transport.failedHosts = map[string]bool{}
+		c.checkHealth()
+		Ω(c.isHealthy(hostPort)).Should(BeTrue())
+		transport.attemptedHosts = nil
+		transport.failedHosts = map[string]bool{"replica1:9374": true}
+		_, err = conn.Insert("a", []string{"col0", "col1"}, []Row{{100, 1}})
+		Ω(err).Should(BeNil())
+		Ω(transport.attemptedHosts).Should(Equal([]string{"replica1:9374", hostPort}))
+
+		// all hosts are tried when all of them are down.
+		transport.failedHosts = map[string]bool{hostPort: true, "replica1:9374": true}
+		c.checkHealth()
+		c.checkHealth()
+		transport.attemptedHosts = nil
+		_, err = conn.Insert("a", []string{"col0", "col1"}, []Row{{100, 1}})
+		Ω(err).ShouldNot(BeNil())
+		Ω(transport.attemptedHosts).Should(Equal([]string{hostPort, "replica1:9374"}))
+
+		// closing twice is fine.
+		conn.Close()
+		conn.Close()
+		Ω(c.done).Should(BeClosed())
+	})
+
+	ginkgo.It("PrepareQuery and ExecutePreparedQuery should work", func() {
+		config := ConnectorConfig{
+			Address: hostPort,
+		}
+
+		logger := zap.NewExample().Sugar()
+		rootScope, _, _ := common.NewNoopMetrics().NewRootScope()
+		conn, err := config.NewConnector(logger, rootScope)
+		Ω(err).Should(BeNil())
+
+		err = conn.PrepareQuery("trips_by_city", map[string]interface{}{
+			"table":      "trips",
+			"measures":   []map[string]string{{"sqlExpression": "count(*)"}},
+			"rowFilters": []string{"city_id = $city_id"},
+		})
+		Ω(err).Should(BeNil())
+		Ω(preparedQueryBytes).Should(MatchJSON(`{
+			"table": "trips",
+			"measures": [{"sqlExpression": "count(*)"}],
+			"rowFilters": ["city_id = $city_id"]
+		}`))
+
+		response, err := conn.ExecutePreparedQuery("trips_by_city", map[string]interface{}{"city_id": 1})
+		Ω(err).Should(BeNil())
+		Ω(executeBytes).Should(MatchJSON(`{"parameters": {"city_id": 1}}`))
+		Ω([]byte(response)).Should(MatchJSON(`{"results": [{"1": 10}]}`))
+
+		_, err = conn.ExecutePreparedQuery("unknown", nil)
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("Failed to execute prepared query unknown"))
+	})
+
 	ginkgo.It("computeHLLValue should work", func() {
 		tests := [][]interface{}{
 			{memCom.UUID, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}, uint32(329736)},
@@ -273,4 +492,40 @@ var _ = ginkgo.Describe("AresDB connector", func() {
 			Ω(out).Should(Equal(expected))
 		}
 	})
+
+	ginkgo.It("translateEnumArray should work", func() {
+		rootScope, _, _ := common.NewNoopMetrics().NewRootScope()
+		conn := &connector{
+			metricScope: rootScope,
+			enumMappings: map[string]map[int]enumDict{
+				"a": {1: {"a": 0, "b": 1, "c": 5}},
+			},
+		}
+
+		value, err := conn.translateEnumArray("a", 1, []string{"a", "C", "unknown"}, true, metaCom.EnumArrayCapacity)
+		Ω(err).Should(BeNil())
+		Ω(value).Should(Equal(uint32(0x21)))
+
+		value, err = conn.translateEnumArray("a", 1, []interface{}{"b"}, false, metaCom.EnumArrayCapacity)
+		Ω(err).Should(BeNil())
+		Ω(value).Should(Equal(uint32(0x2)))
+
+		value, err = conn.translateEnumArray("a", 1, []string{}, false, metaCom.EnumArrayCapacity)
+		Ω(err).Should(BeNil())
+		Ω(value).Should(Equal(uint32(0)))
+
+		value, err = conn.translateEnumArray("a", 1, nil, false, metaCom.EnumArrayCapacity)
+		Ω(err).Should(BeNil())
+		Ω(value).Should(BeNil())
+
+		_, err = conn.translateEnumArray("a", 1, "a", false, metaCom.EnumArrayCapacity)
+		Ω(err).ShouldNot(BeNil())
+		_, err = conn.translateEnumArray("a", 1, []interface{}{1}, false, metaCom.EnumArrayCapacity)
+		Ω(err).ShouldNot(BeNil())
+
+		// enum ids beyond the configured max enum cardinality are ignored.
+		value, err = conn.translateEnumArray("a", 1, []string{"a", "c"}, false, 4)
+		Ω(err).Should(BeNil())
+		Ω(value).Should(Equal(uint32(0x1)))
+	})
 })
@@ -0,0 +1,644 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uber/aresdb/cluster"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/query/expr"
+	"github.com/uber/aresdb/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	// aggregates whose partial aggregates of shards can be merged.
+	countAggregate            = "count"
+	sumAggregate              = "sum"
+	minAggregate              = "min"
+	maxAggregate              = "max"
+	countDistinctHLLAggregate = "countdistincthll"
+
+	// content type of AQL results with the HLL of each group, see query.HLLQueryResults.
+	applicationHLLHeader = "application/hll"
+)
+
+// InstanceLister lists the instances registered in a cluster, e.g. a read only
+// cluster.MembershipManager.
+type InstanceLister interface {
+	ListInstances(cluster string) ([]cluster.Instance, error)
+}
+
+// CoordinatorConfig holds the configurations for a query Coordinator.
+type CoordinatorConfig struct {
+	// ClusterName is the cluster whose instances are queried.
+	ClusterName string `yaml:"clusterName"`
+	// Timeout is the request timeout in seconds of each instance query,
+	// if <= 0, will use default
+	Timeout int `yaml:"timeout"`
+	// AllowPartialResults returns the merged result of the other shards if a shard has no
+	// available replica, instead of failing the query.
+	AllowPartialResults bool `yaml:"allowPartialResults"`
+}
+
+// Coordinator executes AQL queries on a cluster by fanning each query out to one replica of each
+// shard and merging the results.
+type Coordinator interface {
+	// Query executes the aggregate AQL query on all shards of its table. query is the AQL query
+	// to be marshaled as json, with a single count, sum, min, max or countDistinctHLL measure.
+	// The limit and sorts of the query apply to the merged result.
+	Query(query interface{}) (*ClusterQueryResult, error)
+}
+
+// ClusterQueryResult is the merged result of a query on a cluster.
+type ClusterQueryResult struct {
+	// Result is the merged nested AQL result, see DecodeResult.
+	Result json.RawMessage
+	// Partial is set if the rows of MissingShards are not in Result, only with AllowPartialResults.
+	Partial bool
+	// MissingShards are the shards without an available replica.
+	MissingShards []int
+}
+
+// clusterQuery is the part of an AQL query the coordinator plans and merges by.
+type clusterQuery struct {
+	Table      string `json:"table"`
+	Dimensions []struct {
+		Expr string `json:"sqlExpression"`
+		Fill string `json:"fill"`
+	} `json:"dimensions"`
+	Measures []struct {
+		Expr    string `json:"sqlExpression"`
+		Running string `json:"running"`
+	} `json:"measures"`
+	Tables   []string `json:"tables"`
+	Select   []string `json:"select"`
+	Having   string   `json:"having"`
+	Paginate bool     `json:"paginate"`
+	Limit    int      `json:"limit"`
+	Sorts    []struct {
+		Expr string `json:"sqlExpression"`
+		Desc bool   `json:"desc"`
+	} `json:"sorts"`
+}
+
+// coordinator is the Coordinator implementation.
+type coordinator struct {
+	cfg        CoordinatorConfig
+	lister     InstanceLister
+	httpClient http.Client
+	logger     *zap.SugaredLogger
+}
+
+// NewCoordinator returns a new Coordinator querying the instances listed by lister.
+func (cfg CoordinatorConfig) NewCoordinator(lister InstanceLister, logger *zap.SugaredLogger) Coordinator {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultRequestTimeout
+	}
+	return &coordinator{
+		cfg:    cfg,
+		lister: lister,
+		httpClient: http.Client{
+			Timeout: time.Duration(cfg.Timeout) * time.Second,
+		},
+		logger: logger,
+	}
+}
+
+func (c *coordinator) Query(query interface{}) (*ClusterQueryResult, error) {
+	queryBytes, err := json.Marshal(query)
+	if err != nil {
+		return nil, utils.StackError(err, "Failed to marshal query")
+	}
+	var spec clusterQuery
+	if err = json.Unmarshal(queryBytes, &spec); err != nil {
+		return nil, utils.StackError(err, "Failed to unmarshal query")
+	}
+	aggregate, err := spec.aggregate()
+	if err != nil {
+		return nil, err
+	}
+	ranking, err := spec.ranking()
+	if err != nil {
+		return nil, err
+	}
+
+	// the shard queries keep all other fields of the query as is.
+	var shardQuery map[string]interface{}
+	if err = json.Unmarshal(queryBytes, &shardQuery); err != nil {
+		return nil, utils.StackError(err, "Failed to unmarshal query")
+	}
+	delete(shardQuery, "limit")
+	delete(shardQuery, "sorts")
+
+	instances, err := c.lister.ListInstances(c.cfg.ClusterName)
+	if err != nil {
+		return nil, utils.StackError(err, "Failed to list instances of cluster %s", c.cfg.ClusterName)
+	}
+	shards, err := c.fetchShards(instances, spec.Table)
+	if err != nil {
+		return nil, err
+	}
+
+	shardResults, missingShards, err := c.fanOut(shardQuery, instances, shards, aggregate == countDistinctHLLAggregate)
+	if err != nil {
+		return nil, err
+	}
+	if len(missingShards) > 0 {
+		if !c.cfg.AllowPartialResults {
+			return nil, utils.StackError(nil, "No available replica of shards %v of table %s", missingShards, spec.Table)
+		}
+		c.logger.With("table", spec.Table, "missingShards", missingShards).Warn("Returning partial result")
+	}
+
+	merged := make(map[string]interface{})
+	for _, shardResult := range shardResults {
+		mergeResults(aggregate, merged, shardResult)
+	}
+	if aggregate == countDistinctHLLAggregate {
+		merged = queryCom.ComputeHLLResult(merged)
+	}
+	if ranking != nil {
+		ranking.apply(merged)
+	}
+
+	resultBytes, err := json.Marshal(merged)
+	if err != nil {
+		return nil, utils.StackError(err, "Failed to marshal merged result")
+	}
+	return &ClusterQueryResult{
+		Result:        resultBytes,
+		Partial:       len(missingShards) > 0,
+		MissingShards: missingShards,
+	}, nil
+}
+
+// aggregate returns the aggregate function of the measure if the query can be merged across shards.
+func (q clusterQuery) aggregate() (string, error) {
+	switch {
+	case q.Table == "":
+		return "", utils.StackError(nil, "Cluster queries require a main table")
+	case len(q.Tables) > 0:
+		return "", utils.StackError(nil, "Union is not supported for cluster queries")
+	case len(q.Select) > 0:
+		return "", utils.StackError(nil, "Non aggregate queries are not supported for cluster queries")
+	case q.Having != "":
+		return "", utils.StackError(nil, "Having is not supported for cluster queries")
+	case q.Paginate:
+		return "", utils.StackError(nil, "Pagination is not supported for cluster queries")
+	case len(q.Measures) != 1:
+		return "", utils.StackError(nil, "Cluster queries require exactly one measure, got %d", len(q.Measures))
+	case q.Measures[0].Running != "":
+		return "", utils.StackError(nil, "Running aggregates are not supported for cluster queries")
+	}
+	for _, dim := range q.Dimensions {
+		if dim.Fill != "" {
+			return "", utils.StackError(nil, "Fill is not supported for cluster queries")
+		}
+	}
+
+	measure := q.Measures[0].Expr
+	measureExpr, err := expr.ParseExpr(measure)
+	if err != nil {
+		return "", utils.StackError(err, "Failed to parse measure: %s", measure)
+	}
+	if call, ok := measureExpr.(*expr.Call); ok {
+		switch name := strings.ToLower(call.Name); name {
+		case countAggregate, sumAggregate, minAggregate, maxAggregate, countDistinctHLLAggregate:
+			return name, nil
+		}
+	}
+	return "", utils.StackError(nil, "Cluster queries only support count, sum, min, max and countDistinctHLL aggregates, got %s", measure)
+}
+
+// fetchShards returns the shards of the table from the schema served by any of the instances.
+func (c *coordinator) fetchShards(instances []cluster.Instance, tableName string) ([]int, error) {
+	var err error = utils.StackError(nil, "No instance in cluster %s", c.cfg.ClusterName)
+	for _, instance := range instances {
+		var table metaCom.Table
+		resp, getErr := c.httpClient.Get(fmt.Sprintf("http://%s/schema/tables/%s", instanceAddress(instance), url.PathEscape(tableName)))
+		if err = readJSONResponse(resp, getErr, &table); err == nil {
+			return table.GetShards(), nil
+		}
+	}
+	return nil, utils.StackError(err, "Failed to get schema of table %s", tableName)
+}
+
+// shardQueryResponse is the response of an instance to the query of some shards.
+type shardQueryResponse struct {
+	instance string
+	shards   []int
+	result   queryCom.AQLTimeSeriesResult
+	// set if the replica is not available, the shards are queried on other replicas.
+	unavailable bool
+	err         error
+}
+
+// fanOut queries each shard on one of its replicas, the shards assigned to the same replica are
+// queried together. The shards of an unavailable replica are queried on their other replicas. It
+// returns the results and the shards without an available replica.
+func (c *coordinator) fanOut(shardQuery map[string]interface{}, instances []cluster.Instance, shards []int, returnHLL bool) ([]queryCom.AQLTimeSeriesResult, []int, error) {
+	replicas := make(map[int][]cluster.Instance)
+	for _, instance := range instances {
+		for _, shard := range instance.Shards {
+			replicas[int(shard)] = append(replicas[int(shard)], instance)
+		}
+	}
+	tried := make(map[int]map[string]bool)
+
+	var results []queryCom.AQLTimeSeriesResult
+	var missingShards []int
+	for pending := shards; len(pending) > 0; {
+		assignments := make(map[string][]int)
+		assigned := make(map[string]cluster.Instance)
+		for _, shard := range pending {
+			var candidates []cluster.Instance
+			for _, replica := range replicas[shard] {
+				if !tried[shard][replica.Name] {
+					candidates = append(candidates, replica)
+				}
+			}
+			if len(candidates) == 0 {
+				missingShards = append(missingShards, shard)
+				continue
+			}
+			// spreads the shards over their replicas.
+			replica := candidates[shard%len(candidates)]
+			if tried[shard] == nil {
+				tried[shard] = make(map[string]bool)
+			}
+			tried[shard][replica.Name] = true
+			assignments[replica.Name] = append(assignments[replica.Name], shard)
+			assigned[replica.Name] = replica
+		}
+
+		responses := make(chan shardQueryResponse, len(assignments))
+		for name, replicaShards := range assignments {
+			go func(instance cluster.Instance, replicaShards []int) {
+				responses <- c.queryShards(instance, shardQuery, replicaShards, returnHLL)
+			}(assigned[name], replicaShards)
+		}
+		pending = nil
+		var err error
+		for range assignments {
+			response := <-responses
+			switch {
+			case response.unavailable:
+				c.logger.With("instance", response.instance, "shards", response.shards, "error", response.err).
+					Warn("Failed to query replica, retrying on other replicas")
+				pending = append(pending, response.shards...)
+			case response.err != nil:
+				if err == nil {
+					err = utils.StackError(response.err, "Query failed on instance %s", response.instance)
+				}
+			default:
+				results = append(results, response.result)
+			}
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		sort.Ints(pending)
+	}
+	sort.Ints(missingShards)
+	return results, missingShards, nil
+}
+
+// queryShards queries the shards on the instance.
+func (c *coordinator) queryShards(instance cluster.Instance, shardQuery map[string]interface{}, shards []int, returnHLL bool) shardQueryResponse {
+	response := shardQueryResponse{instance: instance.Name, shards: shards}
+	query := make(map[string]interface{}, len(shardQuery)+1)
+	for key, value := range shardQuery {
+		query[key] = value
+	}
+	query["shards"] = shards
+	requestBytes, err := json.Marshal(map[string]interface{}{"queries": []interface{}{query}})
+	if err != nil {
+		response.err = utils.StackError(err, "Failed to marshal query")
+		return response
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/query/aql", instanceAddress(instance)), bytes.NewReader(requestBytes))
+	if err != nil {
+		response.err = utils.StackError(err, "Failed to create query request")
+		return response
+	}
+	req.Header.Set("Content-Type", applicationJSONHeader)
+	if returnHLL {
+		req.Header.Set("Accept", applicationHLLHeader)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		response.unavailable, response.err = true, err
+		return response
+	}
+	respBytes, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		response.unavailable, response.err = true, err
+		return response
+	}
+	if resp.StatusCode != http.StatusOK {
+		response.unavailable = resp.StatusCode >= http.StatusInternalServerError
+		response.err = utils.StackError(nil, "Received error response %d:%s", resp.StatusCode, respBytes)
+		return response
+	}
+
+	if returnHLL {
+		results, queryErrors, err := queryCom.ParseHLLQueryResults(respBytes)
+		switch {
+		case err != nil:
+			response.err = utils.StackError(err, "Failed to parse hll result")
+		case len(results) != 1:
+			response.err = utils.StackError(nil, "Expect 1 hll result, got %d", len(results))
+		case queryErrors[0] != nil:
+			response.err = queryErrors[0]
+		default:
+			response.result = results[0]
+		}
+		return response
+	}
+
+	var envelope aqlResponse
+	if err = json.Unmarshal(respBytes, &envelope); err != nil {
+		response.err = utils.StackError(err, "Failed to unmarshal AQL response")
+		return response
+	}
+	if len(envelope.Errors) > 0 && !isJSONNull(envelope.Errors[0]) {
+		response.err = utils.StackError(nil, "Query failed: %s", envelope.Errors[0])
+		return response
+	}
+	if len(envelope.Results) != 1 {
+		response.err = utils.StackError(nil, "Expect 1 result, got %d", len(envelope.Results))
+		return response
+	}
+	if err = json.Unmarshal(envelope.Results[0], &response.result); err != nil {
+		response.err = utils.StackError(err, "Failed to unmarshal AQL result")
+	}
+	return response
+}
+
+// instanceAddress returns the host:port of the instance.
+func instanceAddress(instance cluster.Instance) string {
+	return net.JoinHostPort(instance.Host, strconv.Itoa(instance.Port))
+}
+
+// readJSONResponse unmarshals the body of a successful response into data.
+func readJSONResponse(response *http.Response, err error, data interface{}) error {
+	if err != nil {
+		return utils.StackError(err, "Failed call remote endpoint")
+	}
+	defer response.Body.Close()
+	respBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return utils.StackError(err, "Failed to read response body")
+	}
+	if response.StatusCode != http.StatusOK {
+		return utils.StackError(nil, "Received error response %d:%s from remote endpoint", response.StatusCode, respBytes)
+	}
+	if err = json.Unmarshal(respBytes, data); err != nil {
+		return utils.StackError(err, "Failed to unmarshal json")
+	}
+	return nil
+}
+
+// mergeResults merges the groups of the shard result into the result. The partial aggregates of a
+// group found in both results are merged by the aggregate function, nulls are ignored.
+func mergeResults(aggregate string, result, shardResult map[string]interface{}) {
+	for key, value := range shardResult {
+		existing, found := result[key]
+		if !found || existing == nil {
+			result[key] = value
+			continue
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if child, ok := existing.(map[string]interface{}); ok {
+				mergeResults(aggregate, child, v)
+			}
+		case queryCom.HLL:
+			if e, ok := existing.(queryCom.HLL); ok {
+				e.Merge(v)
+				result[key] = e
+			}
+		case float64:
+			if e, ok := existing.(float64); ok {
+				result[key] = mergeAggregates(aggregate, e, v)
+			}
+		}
+	}
+}
+
+// mergeAggregates merges two partial aggregates of the same group.
+func mergeAggregates(aggregate string, a, b float64) float64 {
+	switch aggregate {
+	case minAggregate:
+		if b < a {
+			return b
+		}
+		return a
+	case maxAggregate:
+		if b > a {
+			return b
+		}
+		return a
+	}
+	return a + b
+}
+
+// measureRankKey is the dimension index of a sort key on the measure.
+const measureRankKey = -1
+
+// resultRanking keeps the top groups of a merged result, ranking groups the same way as the limit
+// of a query on an instance: by the sort keys with NULLs last, then by the dimension values.
+type resultRanking struct {
+	limit   int
+	numDims int
+	// dimension index of each sort key, or measureRankKey.
+	keys []int
+	desc []bool
+}
+
+// rankedGroup is a flattened group of the nested result.
+type rankedGroup struct {
+	dimValues []string
+	value     interface{}
+}
+
+// ranking resolves the sorts of the query against its measure and dimensions, returns nil if the
+// query has no limit.
+func (q clusterQuery) ranking() (*resultRanking, error) {
+	if q.Limit <= 0 {
+		return nil, nil
+	}
+	r := &resultRanking{limit: q.Limit, numDims: len(q.Dimensions)}
+	for _, sortField := range q.Sorts {
+		key, ok := q.resolveSortKey(sortField.Expr)
+		if !ok {
+			return nil, utils.StackError(nil, "Sort %s is neither the measure nor a dimension", sortField.Expr)
+		}
+		r.keys = append(r.keys, key)
+		r.desc = append(r.desc, sortField.Desc)
+	}
+	return r, nil
+}
+
+// resolveSortKey returns the dimension index of the sort expression, or measureRankKey if it is
+// the measure.
+func (q clusterQuery) resolveSortKey(expression string) (int, bool) {
+	if expression == "" {
+		return 0, false
+	}
+	if expression == q.Measures[0].Expr {
+		return measureRankKey, true
+	}
+	for dimIndex, dim := range q.Dimensions {
+		if expression == dim.Expr {
+			return dimIndex, true
+		}
+	}
+	return 0, false
+}
+
+// apply keeps the top groups of the nested result in place.
+func (r *resultRanking) apply(result map[string]interface{}) {
+	if r.numDims == 0 {
+		return
+	}
+	var groups []rankedGroup
+	r.flatten(result, nil, &groups)
+	if len(groups) <= r.limit {
+		return
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return r.less(groups[i], groups[j])
+	})
+
+	for key := range result {
+		delete(result, key)
+	}
+	for _, group := range groups[:r.limit] {
+		current := result
+		for i, dimValue := range group.dimValues {
+			if i == len(group.dimValues)-1 {
+				current[dimValue] = group.value
+				break
+			}
+			child, ok := current[dimValue].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				current[dimValue] = child
+			}
+			current = child
+		}
+	}
+}
+
+func (r *resultRanking) flatten(result map[string]interface{}, dimValues []string, groups *[]rankedGroup) {
+	for key, value := range result {
+		values := append(append([]string(nil), dimValues...), key)
+		if len(values) < r.numDims {
+			if child, ok := value.(map[string]interface{}); ok {
+				r.flatten(child, values, groups)
+			}
+			continue
+		}
+		*groups = append(*groups, rankedGroup{dimValues: values, value: value})
+	}
+}
+
+// less orders groups by the sort keys and then by the dimension values in order.
+func (r *resultRanking) less(a, b rankedGroup) bool {
+	for i, key := range r.keys {
+		var c int
+		if key == measureRankKey {
+			c = compareMeasureValues(a.value, b.value, r.desc[i])
+		} else {
+			c = compareDimensionValues(a.dimValues[key], b.dimValues[key], r.desc[i])
+		}
+		if c != 0 {
+			return c < 0
+		}
+	}
+	for dimIndex := range a.dimValues {
+		if c := compareDimensionValues(a.dimValues[dimIndex], b.dimValues[dimIndex], false); c != 0 {
+			return c < 0
+		}
+	}
+	return false
+}
+
+// compareMeasureValues compares numeric measure values with NULLs last in both directions.
+func compareMeasureValues(a, b interface{}, desc bool) int {
+	x, xOK := a.(float64)
+	y, yOK := b.(float64)
+	switch {
+	case !xOK && !yOK:
+		return 0
+	case !xOK:
+		return 1
+	case !yOK:
+		return -1
+	}
+	return compareFloats(x, y, desc)
+}
+
+// compareDimensionValues compares dimension values numerically when both are numbers and as
+// strings otherwise, with NULLs last in both directions.
+func compareDimensionValues(a, b string, desc bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a == nullDimensionValue:
+		return 1
+	case b == nullDimensionValue:
+		return -1
+	}
+	x, xErr := strconv.ParseFloat(a, 64)
+	y, yErr := strconv.ParseFloat(b, 64)
+	if xErr == nil && yErr == nil && x != y {
+		return compareFloats(x, y, desc)
+	}
+	c := strings.Compare(a, b)
+	if desc {
+		return -c
+	}
+	return c
+}
+
+func compareFloats(x, y float64, desc bool) int {
+	c := 0
+	if x < y {
+		c = -1
+	} else if x > y {
+		c = 1
+	}
+	if desc {
+		return -c
+	}
+	return c
+}
@@ -0,0 +1,344 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/cluster"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+	"go.uber.org/zap"
+)
+
+// tripRow is a row of the trips table served by the fake instances.
+type tripRow struct {
+	shard  int
+	city   string
+	status string
+	fare   float64
+}
+
+// fakeInstanceLister lists fixed instances.
+type fakeInstanceLister []cluster.Instance
+
+func (l fakeInstanceLister) ListInstances(clusterName string) ([]cluster.Instance, error) {
+	return l, nil
+}
+
+// aggregateTrips computes the nested result of the query over the rows of shards, all shards if
+// shards is nil, as a single instance holding the rows would.
+func aggregateTrips(rows []tripRow, dims []string, measure string, shards []int) map[string]interface{} {
+	inShards := func(shard int) bool {
+		if shards == nil {
+			return true
+		}
+		for _, s := range shards {
+			if s == shard {
+				return true
+			}
+		}
+		return false
+	}
+	result := make(map[string]interface{})
+	for _, row := range rows {
+		if !inShards(row.shard) {
+			continue
+		}
+		current := result
+		for i, dim := range dims {
+			value := row.city
+			if dim == "status" {
+				value = row.status
+			}
+			if i < len(dims)-1 {
+				child, ok := current[value].(map[string]interface{})
+				if !ok {
+					child = make(map[string]interface{})
+					current[value] = child
+				}
+				current = child
+				continue
+			}
+			existing, found := current[value].(float64)
+			switch measure {
+			case "count(*)":
+				current[value] = existing + 1
+			case "sum(fare)":
+				current[value] = existing + row.fare
+			case "min(fare)":
+				if !found || row.fare < existing {
+					current[value] = row.fare
+				}
+			case "max(fare)":
+				if !found || row.fare > existing {
+					current[value] = row.fare
+				}
+			}
+		}
+	}
+	return result
+}
+
+// aqlTestRequest is the part of the AQL request handled by the fake instances.
+type aqlTestRequest struct {
+	Queries []struct {
+		Dimensions []struct {
+			Expr string `json:"sqlExpression"`
+		} `json:"dimensions"`
+		Measures []struct {
+			Expr string `json:"sqlExpression"`
+		} `json:"measures"`
+		Shards []int `json:"shards"`
+		Limit  int   `json:"limit"`
+	} `json:"queries"`
+}
+
+var _ = ginkgo.Describe("query coordinator", func() {
+	var rows []tripRow
+	var servers []*httptest.Server
+	var instances fakeInstanceLister
+	var lock sync.Mutex
+	// shards queried on each instance.
+	var queried map[string][]int
+
+	startInstance := func(name string, shards ...uint32) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/schema/tables/trips":
+				json.NewEncoder(w).Encode(metaCom.Table{Name: "trips", NumShards: 4})
+			case "/query/aql":
+				var request aqlTestRequest
+				Ω(json.NewDecoder(r.Body).Decode(&request)).Should(Succeed())
+				q := request.Queries[0]
+				if q.Measures[0].Expr == "avg(fare)" {
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte(`{"errors":["unsupported"]}`))
+					return
+				}
+				// the limit applies to the merged result.
+				Ω(q.Limit).Should(BeZero())
+				lock.Lock()
+				queried[name] = append(queried[name], q.Shards...)
+				lock.Unlock()
+				var dims []string
+				for _, dim := range q.Dimensions {
+					dims = append(dims, dim.Expr)
+				}
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"results": []interface{}{aggregateTrips(rows, dims, q.Measures[0].Expr, q.Shards)},
+				})
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		servers = append(servers, server)
+		host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+		portNum, _ := strconv.Atoi(port)
+		instances = append(instances, cluster.Instance{Name: name, Host: host, Port: portNum, Shards: shards})
+	}
+
+	newCoordinator := func(allowPartialResults bool) Coordinator {
+		return CoordinatorConfig{ClusterName: "test", AllowPartialResults: allowPartialResults}.
+			NewCoordinator(instances, zap.NewExample().Sugar())
+	}
+
+	query := func(c Coordinator, q map[string]interface{}) (map[string]interface{}, *ClusterQueryResult, error) {
+		result, err := c.Query(q)
+		if err != nil {
+			return nil, nil, err
+		}
+		var merged map[string]interface{}
+		Ω(json.Unmarshal(result.Result, &merged)).Should(Succeed())
+		return merged, result, nil
+	}
+
+	aqlQuery := func(measure string, dims ...string) map[string]interface{} {
+		var dimensions []map[string]interface{}
+		for _, dim := range dims {
+			dimensions = append(dimensions, map[string]interface{}{"sqlExpression": dim})
+		}
+		return map[string]interface{}{
+			"table":      "trips",
+			"dimensions": dimensions,
+			"measures":   []map[string]interface{}{{"sqlExpression": measure}},
+		}
+	}
+
+	ginkgo.BeforeEach(func() {
+		rows = nil
+		for i := 0; i < 200; i++ {
+			rows = append(rows, tripRow{
+				shard:  i % 4,
+				city:   strconv.Itoa(i % 7),
+				status: []string{"completed", "canceled", "NULL"}[i%3],
+				fare:   float64((i*37)%101) + 0.5,
+			})
+		}
+		servers, instances, queried = nil, nil, map[string][]int{}
+		// each shard has two replicas.
+		startInstance("instance0", 0, 1)
+		startInstance("instance1", 1, 2, 3)
+		startInstance("instance2", 0, 2, 3)
+	})
+
+	ginkgo.AfterEach(func() {
+		for _, server := range servers {
+			server.Close()
+		}
+	})
+
+	ginkgo.It("merges the results of all shards as a single instance", func() {
+		c := newCoordinator(false)
+		for _, measure := range []string{"count(*)", "sum(fare)", "min(fare)", "max(fare)"} {
+			for _, dims := range [][]string{{"city_id"}, {"city_id", "status"}} {
+				queried = map[string][]int{}
+				merged, result, err := query(c, aqlQuery(measure, dims...))
+				Ω(err).Should(BeNil())
+				Ω(result.Partial).Should(BeFalse())
+				Ω(merged).Should(Equal(aggregateTrips(rows, dims, measure, nil)), measure)
+
+				// each shard is queried on one replica.
+				var shards []int
+				for _, instanceShards := range queried {
+					shards = append(shards, instanceShards...)
+				}
+				sort.Ints(shards)
+				Ω(shards).Should(Equal([]int{0, 1, 2, 3}))
+			}
+		}
+	})
+
+	ginkgo.It("applies the limit and sorts to the merged result", func() {
+		q := aqlQuery("sum(fare)", "city_id")
+		q["limit"] = 3
+		q["sorts"] = []map[string]interface{}{{"sqlExpression": "sum(fare)", "desc": true}}
+		merged, _, err := query(newCoordinator(false), q)
+		Ω(err).Should(BeNil())
+
+		baseline := aggregateTrips(rows, []string{"city_id"}, "sum(fare)", nil)
+		var cities []string
+		for city := range baseline {
+			cities = append(cities, city)
+		}
+		sort.Slice(cities, func(i, j int) bool {
+			return baseline[cities[i]].(float64) > baseline[cities[j]].(float64)
+		})
+		expected := map[string]interface{}{}
+		for _, city := range cities[:3] {
+			expected[city] = baseline[city]
+		}
+		Ω(merged).Should(Equal(expected))
+
+		q["sorts"] = []map[string]interface{}{{"sqlExpression": "fare"}}
+		_, err = newCoordinator(false).Query(q)
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("queries the shards of an unavailable replica on other replicas", func() {
+		servers[1].Close()
+		merged, result, err := query(newCoordinator(false), aqlQuery("count(*)", "city_id"))
+		Ω(err).Should(BeNil())
+		Ω(result.Partial).Should(BeFalse())
+		Ω(merged).Should(Equal(aggregateTrips(rows, []string{"city_id"}, "count(*)", nil)))
+	})
+
+	ginkgo.It("fails or returns a partial result if a shard has no available replica", func() {
+		servers[0].Close()
+		servers[1].Close()
+		_, err := newCoordinator(false).Query(aqlQuery("sum(fare)", "city_id"))
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("No available replica of shards [1]"))
+
+		merged, result, err := query(newCoordinator(true), aqlQuery("sum(fare)", "city_id"))
+		Ω(err).Should(BeNil())
+		Ω(result.Partial).Should(BeTrue())
+		Ω(result.MissingShards).Should(Equal([]int{1}))
+		Ω(merged).Should(Equal(aggregateTrips(rows, []string{"city_id"}, "sum(fare)", []int{0, 2, 3})))
+	})
+
+	ginkgo.It("rejects queries whose results cannot be merged", func() {
+		c := newCoordinator(true)
+		_, err := c.Query(aqlQuery("avg(fare)", "city_id"))
+		Ω(err).ShouldNot(BeNil())
+		_, err = c.Query(aqlQuery("sum(fare) / count(*)", "city_id"))
+		Ω(err).ShouldNot(BeNil())
+		q := aqlQuery("count(*)", "city_id")
+		q["having"] = "count(*) > 1"
+		_, err = c.Query(q)
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("fails the query on query errors of an instance", func() {
+		c := newCoordinator(true).(*coordinator)
+		results, missingShards, err := c.fanOut(aqlQuery("avg(fare)", "city_id"), instances, []int{0, 1, 2, 3}, false)
+		Ω(err).ShouldNot(BeNil())
+		Ω(results).Should(BeNil())
+		Ω(missingShards).Should(BeNil())
+	})
+
+	ginkgo.It("merges the HLLs of distinct counts as a single instance", func() {
+		newHLL := func(values []uint64) queryCom.HLL {
+			rhos := map[uint16]byte{}
+			for _, value := range values {
+				// spreads the values over the hash space.
+				hllValue := utils.ComputeHLLValue(value * 0x9E3779B97F4A7C15)
+				index, rho := uint16(hllValue&0xffff), byte(hllValue>>16)
+				if rho > rhos[index] {
+					rhos[index] = rho
+				}
+			}
+			var hll queryCom.HLL
+			for index, rho := range rhos {
+				hll.Set(index, rho)
+			}
+			return hll
+		}
+		var all, shard0, shard1 []uint64
+		for i := uint64(0); i < 5000; i++ {
+			all = append(all, i)
+			if i%2 == 0 {
+				shard0 = append(shard0, i)
+			}
+			// overlapping values, counted once.
+			if i%3 != 0 {
+				shard1 = append(shard1, i)
+			}
+		}
+		for i := uint64(0); i < 5000; i += 6 {
+			shard1 = append(shard1, i+3)
+		}
+
+		merged := map[string]interface{}{}
+		mergeResults(countDistinctHLLAggregate, merged, queryCom.AQLTimeSeriesResult{"1": map[string]interface{}{"a": newHLL(shard0)}})
+		mergeResults(countDistinctHLLAggregate, merged, queryCom.AQLTimeSeriesResult{"1": map[string]interface{}{"a": newHLL(shard1)}, "2": map[string]interface{}{"b": newHLL(shard1)}})
+		computed := queryCom.ComputeHLLResult(merged)
+
+		baseline := newHLL(all)
+		Ω(computed["1"].(map[string]interface{})["a"]).Should(Equal(baseline.Compute()))
+		shard1HLL := newHLL(shard1)
+		Ω(computed["2"].(map[string]interface{})["b"]).Should(Equal(shard1HLL.Compute()))
+		Ω(computed).Should(HaveLen(2))
+	})
+})
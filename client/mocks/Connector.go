@@ -16,6 +16,7 @@
 package mocks
 
 import client "github.com/uber/aresdb/client"
+import json "encoding/json"
 import mock "github.com/stretchr/testify/mock"
 
 // Connector is an autogenerated mock type for the Connector type
@@ -23,6 +24,34 @@ type Connector struct {
 	mock.Mock
 }
 
+// Close provides a mock function with given fields:
+func (_m *Connector) Close() {
+	_m.Called()
+}
+
+// ExecutePreparedQuery provides a mock function with given fields: name, parameters
+func (_m *Connector) ExecutePreparedQuery(name string, parameters map[string]interface{}) (json.RawMessage, error) {
+	ret := _m.Called(name, parameters)
+
+	var r0 json.RawMessage
+	if rf, ok := ret.Get(0).(func(string, map[string]interface{}) json.RawMessage); ok {
+		r0 = rf(name, parameters)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(json.RawMessage)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, map[string]interface{}) error); ok {
+		r1 = rf(name, parameters)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Insert provides a mock function with given fields: tableName, columnNames, rows
 func (_m *Connector) Insert(tableName string, columnNames []string, rows []client.Row) (int, error) {
 	ret := _m.Called(tableName, columnNames, rows)
@@ -43,3 +72,17 @@ func (_m *Connector) Insert(tableName string, columnNames []string, rows []clien
 
 	return r0, r1
 }
+
+// PrepareQuery provides a mock function with given fields: name, query
+func (_m *Connector) PrepareQuery(name string, query interface{}) error {
+	ret := _m.Called(name, query)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, interface{}) error); ok {
+		r0 = rf(name, query)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
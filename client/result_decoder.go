@@ -0,0 +1,315 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/uber/aresdb/utils"
+)
+
+const (
+	// resultTag is the struct field tag naming the result column decoded into the field.
+	resultTag = "aql"
+	// nullDimensionValue is the dimension value of NULLs in AQL results.
+	nullDimensionValue = "NULL"
+)
+
+// layouts of time dimension values formatted by the time bucketizers.
+var timeDimensionLayouts = []string{"2006-01-02 15:04", "2006-01-02"}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// aqlResponse is the response envelope of AQL queries.
+type aqlResponse struct {
+	Results []json.RawMessage `json:"results"`
+	Errors  []json.RawMessage `json:"errors"`
+}
+
+// DecodeResponse decodes the result of the query at queryIndex in the raw AQL response, e.g. the
+// response of ExecutePreparedQuery, into rows. See DecodeResult for columns and rows.
+func DecodeResponse(response json.RawMessage, queryIndex int, columns []string, rows interface{}) error {
+	var envelope aqlResponse
+	if err := json.Unmarshal(response, &envelope); err != nil {
+		return utils.StackError(err, "Failed to unmarshal AQL response")
+	}
+	if queryIndex < len(envelope.Errors) && !isJSONNull(envelope.Errors[queryIndex]) {
+		return utils.StackError(nil, "Query %d failed: %s", queryIndex, envelope.Errors[queryIndex])
+	}
+	if queryIndex < 0 || queryIndex >= len(envelope.Results) {
+		return utils.StackError(nil, "Query %d is not in AQL response with %d results", queryIndex, len(envelope.Results))
+	}
+	return DecodeResult(envelope.Results[queryIndex], columns, rows)
+}
+
+// DecodeResult decodes the nested result of an AQL query into rows, which should be a pointer to a
+// slice of structs or of pointers to structs. columns names the dimensions of the query from the
+// outermost, followed by the measure. Each leaf of the result is decoded as a row, ordered by
+// dimension values.
+//
+// Struct fields are matched with columns by the aql tag, e.g. `aql:"city_id"`, untagged fields are
+// left untouched. NULL dimensions and null measures can only be decoded into pointer or interface
+// fields. Dimension values are converted into the field type, e.g. "12" into int, and time.Time
+// fields accept both seconds since epoch and the formats of time bucketizers. Values that cannot
+// be converted into the field type are reported as errors.
+func DecodeResult(result json.RawMessage, columns []string, rows interface{}) error {
+	rowsValue := reflect.ValueOf(rows)
+	if rowsValue.Kind() != reflect.Ptr || rowsValue.Elem().Kind() != reflect.Slice {
+		return utils.StackError(nil, "Expect a pointer to slice of structs, got %T", rows)
+	}
+	sliceValue := rowsValue.Elem()
+	elemType := sliceValue.Type().Elem()
+	structType := elemType
+	if structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+	if structType.Kind() != reflect.Struct {
+		return utils.StackError(nil, "Expect a pointer to slice of structs, got %T", rows)
+	}
+	if len(columns) == 0 {
+		return utils.StackError(nil, "Expect at least one column for the measure")
+	}
+
+	// index of the field decoded from each column, -1 if the column is not decoded.
+	fieldIndices := make([]int, len(columns))
+	for i := range fieldIndices {
+		fieldIndices[i] = -1
+	}
+	for i := 0; i < structType.NumField(); i++ {
+		name := structType.Field(i).Tag.Get(resultTag)
+		if name == "" || name == "-" {
+			continue
+		}
+		found := false
+		for col, column := range columns {
+			if column == name {
+				fieldIndices[col] = i
+				found = true
+			}
+		}
+		if !found {
+			return utils.StackError(nil, "Field %s: column %s is not in the result", structType.Field(i).Name, name)
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(result))
+	// keep the precision of large integers.
+	decoder.UseNumber()
+	var nested interface{}
+	if err := decoder.Decode(&nested); err != nil {
+		return utils.StackError(err, "Failed to unmarshal AQL result")
+	}
+	if nested == nil {
+		sliceValue.Set(reflect.MakeSlice(sliceValue.Type(), 0, 0))
+		return nil
+	}
+
+	rowsDecoder := &resultRowsDecoder{
+		columns:      columns,
+		fieldIndices: fieldIndices,
+		structType:   structType,
+		isPtr:        elemType.Kind() == reflect.Ptr,
+		rows:         reflect.MakeSlice(sliceValue.Type(), 0, 0),
+		dimValues:    make([]string, len(columns)-1),
+	}
+	if err := rowsDecoder.decode(nested, 0); err != nil {
+		return err
+	}
+	sliceValue.Set(rowsDecoder.rows)
+	return nil
+}
+
+// resultRowsDecoder decodes the leaves of nested AQL results into rows.
+type resultRowsDecoder struct {
+	columns      []string
+	fieldIndices []int
+	structType   reflect.Type
+	isPtr        bool
+	rows         reflect.Value
+	// dimension values from the outermost layer to the current layer.
+	dimValues []string
+}
+
+// decode decodes the layer of the result at depth.
+func (d *resultRowsDecoder) decode(layer interface{}, depth int) error {
+	if depth == len(d.dimValues) {
+		return d.appendRow(layer)
+	}
+
+	children, ok := layer.(map[string]interface{})
+	if !ok {
+		return utils.StackError(nil, "Expect dimension %s in the result, got %v", d.columns[depth], layer)
+	}
+	keys := make([]string, 0, len(children))
+	for key := range children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		d.dimValues[depth] = key
+		if err := d.decode(children[key], depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendRow appends the row of the current dimension values and the measure value.
+func (d *resultRowsDecoder) appendRow(measure interface{}) error {
+	if _, ok := measure.(map[string]interface{}); ok {
+		return utils.StackError(nil, "Expect measure %s in the result, got more dimensions",
+			d.columns[len(d.columns)-1])
+	}
+
+	row := reflect.New(d.structType).Elem()
+	for col, fieldIndex := range d.fieldIndices {
+		if fieldIndex < 0 {
+			continue
+		}
+		var value interface{}
+		if col < len(d.dimValues) {
+			if d.dimValues[col] != nullDimensionValue {
+				value = d.dimValues[col]
+			}
+		} else {
+			value = measure
+		}
+		field := d.structType.Field(fieldIndex)
+		if err := setResultValue(row.Field(fieldIndex), value); err != nil {
+			return utils.StackError(err, "Field %s: invalid value of column %s", field.Name, d.columns[col])
+		}
+	}
+
+	if d.isPtr {
+		row = row.Addr()
+	}
+	d.rows = reflect.Append(d.rows, row)
+	return nil
+}
+
+// setResultValue sets the dimension value (string) or the measure value (json.Number) into
+// the field, value is nil for NULLs.
+func setResultValue(field reflect.Value, value interface{}) error {
+	if field.Kind() == reflect.Interface {
+		if value != nil {
+			if number, ok := value.(json.Number); ok {
+				value, _ = number.Float64()
+			}
+			field.Set(reflect.ValueOf(value))
+		}
+		return nil
+	}
+	if value == nil {
+		if field.Kind() != reflect.Ptr {
+			return utils.StackError(nil, "NULL cannot be decoded into non pointer type %s", field.Type())
+		}
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+	if field.Kind() == reflect.Ptr {
+		ptr := reflect.New(field.Type().Elem())
+		if err := setResultValue(ptr.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(ptr)
+		return nil
+	}
+
+	var str string
+	switch v := value.(type) {
+	case string:
+		str = v
+	case json.Number:
+		str = v.String()
+	default:
+		return utils.StackError(nil, "Unsupported value %v", value)
+	}
+
+	if field.Type() == timeType {
+		t, err := parseTimeDimension(str)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(str)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(str)
+		if err != nil {
+			return utils.StackError(err, "Cannot decode %s into %s", str, field.Type())
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(str, 10, field.Type().Bits())
+		if err != nil {
+			// measures of integers may be formatted as floats, e.g. 1e+06.
+			f, ferr := strconv.ParseFloat(str, 64)
+			if ferr != nil || f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 ||
+				field.OverflowInt(int64(f)) {
+				return utils.StackError(err, "Cannot decode %s into %s", str, field.Type())
+			}
+			i = int64(f)
+		}
+		field.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(str, 10, field.Type().Bits())
+		if err != nil {
+			f, ferr := strconv.ParseFloat(str, 64)
+			if ferr != nil || f != math.Trunc(f) || f < 0 || f >= math.MaxUint64 || field.OverflowUint(uint64(f)) {
+				return utils.StackError(err, "Cannot decode %s into %s", str, field.Type())
+			}
+			u = uint64(f)
+		}
+		field.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(str, field.Type().Bits())
+		if err != nil {
+			return utils.StackError(err, "Cannot decode %s into %s", str, field.Type())
+		}
+		field.SetFloat(f)
+	default:
+		return utils.StackError(nil, "Unsupported field type %s", field.Type())
+	}
+	return nil
+}
+
+// parseTimeDimension parses the time dimension value in seconds since epoch or formatted by
+// time bucketizers.
+func parseTimeDimension(str string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(str, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	for _, layout := range timeDimensionLayouts {
+		if t, err := time.Parse(layout, str); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, utils.StackError(nil, "Cannot decode %s into time", str)
+}
+
+// isJSONNull tells whether the raw json is null.
+func isJSONNull(raw json.RawMessage) bool {
+	return len(bytes.TrimSpace(raw)) == 0 || string(bytes.TrimSpace(raw)) == "null"
+}
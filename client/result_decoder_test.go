@@ -0,0 +1,113 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("result decoder", func() {
+	type tripsRow struct {
+		Day    time.Time `aql:"day"`
+		CityID *int      `aql:"city_id"`
+		Status string    `aql:"status"`
+		Trips  *int64    `aql:"trips"`
+		Note   string
+	}
+
+	intPtr := func(i int) *int {
+		return &i
+	}
+	int64Ptr := func(i int64) *int64 {
+		return &i
+	}
+	day := time.Date(2019, 4, 24, 0, 0, 0, 0, time.UTC)
+	columns := []string{"day", "city_id", "status", "trips"}
+
+	ginkgo.It("decodes results into structs with nullable fields", func() {
+		result := json.RawMessage(`{
+			"2019-04-24": {
+				"2": {"completed": 9007199254740993, "canceled": null},
+				"NULL": {"completed": 3}
+			},
+			"1556150400": {"1": {"completed": 1e+06}}
+		}`)
+		var rows []tripsRow
+		Ω(DecodeResult(result, columns, &rows)).Should(Succeed())
+		Ω(rows).Should(Equal([]tripsRow{
+			{Day: day.Add(24 * time.Hour), CityID: intPtr(1), Status: "completed", Trips: int64Ptr(1000000)},
+			{Day: day, CityID: intPtr(2), Status: "canceled"},
+			{Day: day, CityID: intPtr(2), Status: "completed", Trips: int64Ptr(9007199254740993)},
+			{Day: day, Status: "completed", Trips: int64Ptr(3)},
+		}))
+
+		// pointers to structs and raw values.
+		var ptrRows []*struct {
+			Status string      `aql:"status"`
+			Trips  interface{} `aql:"trips"`
+		}
+		Ω(DecodeResult(result, columns, &ptrRows)).Should(Succeed())
+		Ω(ptrRows).Should(HaveLen(4))
+		Ω(ptrRows[0].Trips).Should(Equal(1e+06))
+		Ω(ptrRows[1].Trips).Should(BeNil())
+	})
+
+	ginkgo.It("decodes the results in raw responses", func() {
+		response := json.RawMessage(`{"results": [null, {"1": 1.5}], "errors": [{"message": "some error"}, null]}`)
+		var rows []struct {
+			CityID uint8   `aql:"city_id"`
+			Fare   float32 `aql:"fare"`
+		}
+		Ω(DecodeResponse(response, 1, []string{"city_id", "fare"}, &rows)).Should(Succeed())
+		Ω(rows).Should(HaveLen(1))
+		Ω(rows[0].CityID).Should(Equal(uint8(1)))
+		Ω(rows[0].Fare).Should(Equal(float32(1.5)))
+
+		err := DecodeResponse(response, 0, []string{"city_id", "fare"}, &rows)
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("some error"))
+		Ω(DecodeResponse(response, 2, []string{"city_id", "fare"}, &rows)).ShouldNot(Succeed())
+	})
+
+	ginkgo.It("reports type mismatches", func() {
+		var rows []tripsRow
+		for _, result := range []string{
+			// NULL into non pointer fields.
+			`{"NULL": {"1": {"completed": 1}}}`,
+			`{"2019-04-24": {"1": {"NULL": 1}}}`,
+			// fractions into integers.
+			`{"2019-04-24": {"1": {"completed": 1.5}}}`,
+			`{"2019-04-24": {"1.5": {"completed": 1}}}`,
+			// values out of range.
+			`{"2019-04-24": {"1": {"completed": 1e+20}}}`,
+			// layers not matching the columns.
+			`{"2019-04-24": {"1": 1}}`,
+			`{"2019-04-24": {"1": {"completed": {"2": 1}}}}`,
+			`{"today": {"1": {"completed": 1}}}`,
+		} {
+			Ω(DecodeResult(json.RawMessage(result), columns, &rows)).ShouldNot(Succeed(), result)
+		}
+
+		result := json.RawMessage(`{"1": 1}`)
+		Ω(DecodeResult(result, []string{"city_id", "trips"}, rows)).ShouldNot(Succeed())
+		Ω(DecodeResult(result, []string{"city_id", "trips"}, &[]int{})).ShouldNot(Succeed())
+		// tagged fields should be in the result.
+		Ω(DecodeResult(result, []string{"city_id", "trips"}, &rows)).ShouldNot(Succeed())
+	})
+})
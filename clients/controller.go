@@ -18,6 +18,8 @@ const (
 type ControllerClient interface {
 	GetSchemaHash(namespace string) (string, error)
 	GetAllSchema(namespace string) ([]common.Table, error)
+	// GetMaintenance returns the cluster level maintenance of the namespace, nil if not set.
+	GetMaintenance(namespace string) (*common.Maintenance, error)
 }
 
 // ControllerHTTPClient implements ControllerClient over http
@@ -92,11 +94,45 @@ func (c *ControllerHTTPClient) GetAllSchema(namespace string) (tables []common.T
 	return
 }
 
+func (c *ControllerHTTPClient) GetMaintenance(namespace string) (maintenance *common.Maintenance, err error) {
+	var req *http.Request
+	req, err = c.newRequest(namespace, "maintenance")
+	if err != nil {
+		return
+	}
+	var resp *http.Response
+	resp, err = c.c.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	// the namespace has no maintenance.
+	if resp.StatusCode == http.StatusNotFound {
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = utils.StackError(nil, fmt.Sprintf("controller client error fetching maintenance, status code %d", resp.StatusCode))
+		return
+	}
+
+	var b []byte
+	b, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(b, &maintenance)
+	return
+}
+
 func (c *ControllerHTTPClient) getRequest(namespace string, hash bool) (req *http.Request, err error) {
 	suffix := "tables"
 	if hash {
 		suffix = "hash"
 	}
+	return c.newRequest(namespace, suffix)
+}
+
+func (c *ControllerHTTPClient) newRequest(namespace, suffix string) (req *http.Request, err error) {
 	url := fmt.Sprintf("http://%s:%d/schema/%s/%s", c.controllerHost, c.controllerPort, namespace, suffix)
 	req, err = http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
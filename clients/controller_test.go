@@ -47,6 +47,12 @@ var _ = ginkgo.Describe("Controller", func() {
 		testRouter.HandleFunc("/schema/ns1/hash", func(w http.ResponseWriter, r *http.Request) {
 			w.Write([]byte("123"))
 		})
+		testRouter.HandleFunc("/schema/ns1/maintenance", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"reason": "migration", "expiresAt": 100}`))
+		})
+		testRouter.HandleFunc("/schema/ns_error/maintenance", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		})
 		testServer.Start()
 		hostPort := testServer.Listener.Addr().String()
 		comps := strings.SplitN(hostPort, ":", 2)
@@ -71,6 +77,15 @@ var _ = ginkgo.Describe("Controller", func() {
 		tablesGot, err := c.GetAllSchema("ns1")
 		Ω(err).Should(BeNil())
 		Ω(tablesGot).Should(Equal(tables))
+
+		maintenance, err := c.GetMaintenance("ns1")
+		Ω(err).Should(BeNil())
+		Ω(maintenance).Should(Equal(&common.Maintenance{Reason: "migration", ExpiresAt: 100}))
+
+		// namespaces without maintenance.
+		maintenance, err = c.GetMaintenance("ns2")
+		Ω(err).Should(BeNil())
+		Ω(maintenance).Should(BeNil())
 	})
 
 	ginkgo.It("should fail with errors", func() {
@@ -83,5 +98,8 @@ var _ = ginkgo.Describe("Controller", func() {
 
 		_, err = c.GetAllSchema("ns_baddata")
 		Ω(err).ShouldNot(BeNil())
+
+		_, err = c.GetMaintenance("ns_error")
+		Ω(err).ShouldNot(BeNil())
 	})
 })
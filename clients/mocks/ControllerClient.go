@@ -32,6 +32,29 @@ func (_m *ControllerClient) GetAllSchema(namespace string) ([]common.Table, erro
 	return r0, r1
 }
 
+// GetMaintenance provides a mock function with given fields: namespace
+func (_m *ControllerClient) GetMaintenance(namespace string) (*common.Maintenance, error) {
+	ret := _m.Called(namespace)
+
+	var r0 *common.Maintenance
+	if rf, ok := ret.Get(0).(func(string) *common.Maintenance); ok {
+		r0 = rf(namespace)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*common.Maintenance)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(namespace)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetSchemaHash provides a mock function with given fields: namespace
 func (_m *ControllerClient) GetSchemaHash(namespace string) (string, error) {
 	ret := _m.Called(namespace)
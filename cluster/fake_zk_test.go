@@ -0,0 +1,377 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+	"github.com/uber/aresdb/utils"
+)
+
+// fakeZNode is a znode of fakeZNamespace.
+type fakeZNode struct {
+	data []byte
+	acl  []zk.ACL
+	// session of the ephemeral node, 0 if persistent.
+	owner   int64
+	version int32
+	// sequence number of the next sequential child.
+	nextSequence int
+}
+
+// fakeZWatch is a watch set by a session.
+type fakeZWatch struct {
+	session int64
+	events  chan zk.Event
+}
+
+// fakeZNamespace is an in memory ZooKeeper namespace shared by the connections of fakeZK.
+type fakeZNamespace struct {
+	sync.Mutex
+	nodes       map[string]*fakeZNode
+	watches     map[string][]fakeZWatch
+	lastSession int64
+}
+
+// fakeZK is a connection to a fakeZNamespace implementing zkConn.
+type fakeZK struct {
+	*fakeZNamespace
+	// protected by the lock of the namespace.
+	session int64
+	closed  bool
+	// scheme:auth of the added credentials.
+	auths []string
+	// session events of the connection, closed with the connection.
+	events chan zk.Event
+}
+
+// newFakeZK creates a connection to a new namespace with the persistent nodes of paths and their
+// parents.
+func newFakeZK(paths ...string) *fakeZK {
+	ns := &fakeZNamespace{
+		nodes:       map[string]*fakeZNode{},
+		watches:     map[string][]fakeZWatch{},
+		lastSession: 1000,
+	}
+	for _, p := range paths {
+		for ; p != "/"; p = path.Dir(p) {
+			ns.nodes[p] = &fakeZNode{}
+		}
+	}
+	return &fakeZK{fakeZNamespace: ns}
+}
+
+// newConn creates another connection to the namespace, sessions are established by fakeConnector.
+func (z *fakeZK) newConn() *fakeZK {
+	return &fakeZK{fakeZNamespace: z.fakeZNamespace}
+}
+
+func (z *fakeZK) Create(p string, data []byte, flags int32, acl []zk.ACL) (string, error) {
+	z.Lock()
+	defer z.Unlock()
+	if z.closed {
+		return "", zk.ErrClosing
+	}
+	parent, ok := z.nodes[path.Dir(p)]
+	if !ok && path.Dir(p) != "/" {
+		return "", zk.ErrNoNode
+	}
+	if flags&zk.FlagSequence != 0 {
+		p = fmt.Sprintf("%s%010d", p, parent.nextSequence)
+		parent.nextSequence++
+	}
+	if _, ok := z.nodes[p]; ok {
+		return "", zk.ErrNodeExists
+	}
+	node := &fakeZNode{data: data, acl: acl}
+	if flags&zk.FlagEphemeral != 0 {
+		node.owner = z.session
+	}
+	z.nodes[p] = node
+	z.fire(p, zk.EventNodeCreated)
+	return p, nil
+}
+
+func (z *fakeZK) Get(p string) ([]byte, *zk.Stat, error) {
+	z.Lock()
+	defer z.Unlock()
+	if z.closed {
+		return nil, nil, zk.ErrClosing
+	}
+	node, ok := z.nodes[p]
+	if !ok {
+		return nil, nil, zk.ErrNoNode
+	}
+	return node.data, &zk.Stat{EphemeralOwner: node.owner, Version: node.version}, nil
+}
+
+func (z *fakeZK) GetW(p string) ([]byte, *zk.Stat, <-chan zk.Event, error) {
+	z.Lock()
+	defer z.Unlock()
+	if z.closed {
+		return nil, nil, nil, zk.ErrClosing
+	}
+	node, ok := z.nodes[p]
+	if !ok {
+		return nil, nil, nil, zk.ErrNoNode
+	}
+	events := make(chan zk.Event, 1)
+	z.watches[p] = append(z.watches[p], fakeZWatch{session: z.session, events: events})
+	return node.data, &zk.Stat{EphemeralOwner: node.owner, Version: node.version}, events, nil
+}
+
+func (z *fakeZK) Exists(p string) (bool, *zk.Stat, error) {
+	z.Lock()
+	defer z.Unlock()
+	if z.closed {
+		return false, nil, zk.ErrClosing
+	}
+	node, ok := z.nodes[p]
+	if !ok {
+		return false, &zk.Stat{}, nil
+	}
+	return true, &zk.Stat{EphemeralOwner: node.owner, Version: node.version}, nil
+}
+
+func (z *fakeZK) ExistsW(p string) (bool, *zk.Stat, <-chan zk.Event, error) {
+	z.Lock()
+	defer z.Unlock()
+	if z.closed {
+		return false, nil, nil, zk.ErrClosing
+	}
+	events := make(chan zk.Event, 1)
+	z.watches[p] = append(z.watches[p], fakeZWatch{session: z.session, events: events})
+	node, ok := z.nodes[p]
+	if !ok {
+		return false, &zk.Stat{}, events, nil
+	}
+	return true, &zk.Stat{EphemeralOwner: node.owner, Version: node.version}, events, nil
+}
+
+func (z *fakeZK) Children(p string) ([]string, *zk.Stat, error) {
+	z.Lock()
+	defer z.Unlock()
+	if z.closed {
+		return nil, nil, zk.ErrClosing
+	}
+	if _, ok := z.nodes[p]; !ok {
+		return nil, nil, zk.ErrNoNode
+	}
+	children := []string{}
+	for child := range z.nodes {
+		if path.Dir(child) == p {
+			children = append(children, path.Base(child))
+		}
+	}
+	return children, &zk.Stat{NumChildren: int32(len(children))}, nil
+}
+
+func (z *fakeZK) Set(p string, data []byte, version int32) (*zk.Stat, error) {
+	z.Lock()
+	defer z.Unlock()
+	if z.closed {
+		return nil, zk.ErrClosing
+	}
+	node, ok := z.nodes[p]
+	if !ok {
+		return nil, zk.ErrNoNode
+	}
+	if version != -1 && version != node.version {
+		return nil, zk.ErrBadVersion
+	}
+	node.data = data
+	node.version++
+	z.fire(p, zk.EventNodeDataChanged)
+	return &zk.Stat{EphemeralOwner: node.owner, Version: node.version}, nil
+}
+
+func (z *fakeZK) Delete(p string, version int32) error {
+	z.Lock()
+	defer z.Unlock()
+	if z.closed {
+		return zk.ErrClosing
+	}
+	node, ok := z.nodes[p]
+	if !ok {
+		return zk.ErrNoNode
+	}
+	if version != -1 && version != node.version {
+		return zk.ErrBadVersion
+	}
+	delete(z.nodes, p)
+	z.fire(p, zk.EventNodeDeleted)
+	return nil
+}
+
+func (z *fakeZK) AddAuth(scheme string, auth []byte) error {
+	z.Lock()
+	defer z.Unlock()
+	if z.closed {
+		return zk.ErrClosing
+	}
+	z.auths = append(z.auths, scheme+":"+string(auth))
+	return nil
+}
+
+func (z *fakeZK) SessionID() int64 {
+	z.Lock()
+	defer z.Unlock()
+	return z.session
+}
+
+// Close closes the session, removing its ephemeral nodes and watches.
+func (z *fakeZK) Close() {
+	z.Lock()
+	defer z.Unlock()
+	if z.closed {
+		return
+	}
+	z.closed = true
+	if z.events != nil {
+		close(z.events)
+	}
+	z.endSession()
+}
+
+// endSession removes the ephemeral nodes and the watches of the session, the lock must be held.
+func (z *fakeZK) endSession() {
+	for p, node := range z.nodes {
+		if node.owner == z.session {
+			delete(z.nodes, p)
+			z.fire(p, zk.EventNodeDeleted)
+		}
+	}
+	for p, watches := range z.watches {
+		var kept []fakeZWatch
+		for _, watch := range watches {
+			if watch.session == z.session {
+				watch.events <- zk.Event{Type: zk.EventNotWatching, Path: p, Err: zk.ErrSessionExpired}
+			} else {
+				kept = append(kept, watch)
+			}
+		}
+		z.watches[p] = kept
+	}
+}
+
+// fire triggers the watches of p, the lock must be held.
+func (z *fakeZNamespace) fire(p string, eventType zk.EventType) {
+	for _, watch := range z.watches[p] {
+		watch.events <- zk.Event{Type: eventType, Path: p}
+	}
+	delete(z.watches, p)
+}
+
+// node returns the znode at p, nil if it does not exist.
+func (z *fakeZNamespace) node(p string) *fakeZNode {
+	z.Lock()
+	defer z.Unlock()
+	return z.nodes[p]
+}
+
+// putNode creates the node at p as if created by the session owner.
+func (z *fakeZNamespace) putNode(p string, data []byte, owner int64) {
+	z.Lock()
+	defer z.Unlock()
+	z.nodes[p] = &fakeZNode{data: data, owner: owner}
+}
+
+// setData sets the data of the persistent node at p, creating it if missing.
+func (z *fakeZNamespace) setData(p string, data []byte) {
+	z.Lock()
+	defer z.Unlock()
+	if node, ok := z.nodes[p]; ok {
+		node.data = data
+		node.version++
+		z.fire(p, zk.EventNodeDataChanged)
+		return
+	}
+	z.nodes[p] = &fakeZNode{data: data}
+	z.fire(p, zk.EventNodeCreated)
+}
+
+// deleteNode deletes the node at p as if its session expired.
+func (z *fakeZNamespace) deleteNode(p string) {
+	z.Lock()
+	defer z.Unlock()
+	delete(z.nodes, p)
+	z.fire(p, zk.EventNodeDeleted)
+}
+
+func (z *fakeZK) isClosed() bool {
+	z.Lock()
+	defer z.Unlock()
+	return z.closed
+}
+
+// sendEvent sends a session event of state to the connection.
+func (z *fakeZK) sendEvent(state zk.State) {
+	z.Lock()
+	defer z.Unlock()
+	z.events <- zk.Event{Type: zk.EventSession, State: state}
+}
+
+// expire expires the session, the connection reconnects with a new session on the next
+// zk.StateHasSession event.
+func (z *fakeZK) expire() {
+	z.Lock()
+	z.endSession()
+	z.lastSession++
+	z.session = z.lastSession
+	z.Unlock()
+	z.sendEvent(zk.StateDisconnected)
+	z.sendEvent(zk.StateExpired)
+}
+
+// fakeConnector is a zkConnector that fails the first failures attempts, then connects zkc with a
+// new session.
+type fakeConnector struct {
+	sync.Mutex
+	zkc      *fakeZK
+	failures int
+	attempts int
+	// timeouts of the last attempt.
+	sessionTimeout time.Duration
+	connectTimeout time.Duration
+}
+
+func (c *fakeConnector) connect(servers []string, sessionTimeout, connectTimeout time.Duration) (zkConn, <-chan zk.Event, error) {
+	c.Lock()
+	defer c.Unlock()
+	c.attempts++
+	c.sessionTimeout, c.connectTimeout = sessionTimeout, connectTimeout
+	if c.attempts <= c.failures {
+		return nil, nil, utils.StackError(zk.ErrNoServer, "attempt %d", c.attempts)
+	}
+	events := make(chan zk.Event, 10)
+	events <- zk.Event{Type: zk.EventSession, State: zk.StateHasSession}
+	c.zkc.Lock()
+	c.zkc.events = events
+	c.zkc.closed = false
+	c.zkc.lastSession++
+	c.zkc.session = c.zkc.lastSession
+	c.zkc.Unlock()
+	return c.zkc, events, nil
+}
+
+func (c *fakeConnector) getAttempts() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.attempts
+}
@@ -0,0 +1,67 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"net"
+	"os"
+
+	"github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// AutoAdvertiseHost is the cluster.advertise_host to advertise the first non loopback IP.
+const AutoAdvertiseHost = "auto"
+
+// interfaceAddrs returns the addresses of the network interfaces, replaced in tests.
+var interfaceAddrs = net.InterfaceAddrs
+
+// Instance is an aresdb instance registered in the cluster, stored as json in its instance node.
+type Instance struct {
+	Name   string   `json:"name"`
+	Host   string   `json:"host"`
+	Port   int      `json:"port"`
+	Shards []uint32 `json:"shards,omitempty"`
+	Zone   string   `json:"zone,omitempty"`
+	// port of the debug server, which serves the shard transfer endpoints.
+	DebugPort int `json:"debugPort,omitempty"`
+	// schema versions of the tables applied by the instance by table name.
+	SchemaVersions map[string]int `json:"schemaVersions,omitempty"`
+}
+
+// advertisedHost returns the host of the instance to advertise in its instance node.
+func advertisedHost(cfg common.ClusterConfig) (string, error) {
+	switch cfg.AdvertiseHost {
+	case "":
+		hostname, err := os.Hostname()
+		if err != nil {
+			return "", utils.StackError(err, "Failed to get host name")
+		}
+		return hostname, nil
+	case AutoAdvertiseHost:
+		addrs, err := interfaceAddrs()
+		if err != nil {
+			return "", utils.StackError(err, "Failed to get interface addresses")
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+				return ipNet.IP.String(), nil
+			}
+		}
+		return "", utils.StackError(nil, "No non loopback IP to advertise")
+	default:
+		return cfg.AdvertiseHost, nil
+	}
+}
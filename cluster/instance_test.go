@@ -0,0 +1,70 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"errors"
+	"net"
+	"os"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/common"
+)
+
+var _ = ginkgo.Describe("advertisedHost", func() {
+	ginkgo.AfterEach(func() {
+		interfaceAddrs = net.InterfaceAddrs
+	})
+
+	ginkgo.It("uses the configured host verbatim", func() {
+		host, err := advertisedHost(common.ClusterConfig{AdvertiseHost: "ares-0.ares.svc"})
+		Ω(err).Should(BeNil())
+		Ω(host).Should(Equal("ares-0.ares.svc"))
+	})
+
+	ginkgo.It("uses the first non loopback IP if auto", func() {
+		interfaceAddrs = func() ([]net.Addr, error) {
+			return []net.Addr{
+				&net.IPNet{IP: net.ParseIP("127.0.0.1"), Mask: net.CIDRMask(8, 32)},
+				&net.IPNet{IP: net.ParseIP("::1"), Mask: net.CIDRMask(128, 128)},
+				&net.IPNet{IP: net.ParseIP("10.0.0.5"), Mask: net.CIDRMask(24, 32)},
+				&net.IPNet{IP: net.ParseIP("10.0.1.5"), Mask: net.CIDRMask(24, 32)},
+			}, nil
+		}
+		host, err := advertisedHost(common.ClusterConfig{AdvertiseHost: AutoAdvertiseHost})
+		Ω(err).Should(BeNil())
+		Ω(host).Should(Equal("10.0.0.5"))
+
+		interfaceAddrs = func() ([]net.Addr, error) {
+			return []net.Addr{&net.IPNet{IP: net.ParseIP("127.0.0.1"), Mask: net.CIDRMask(8, 32)}}, nil
+		}
+		_, err = advertisedHost(common.ClusterConfig{AdvertiseHost: AutoAdvertiseHost})
+		Ω(err).ShouldNot(BeNil())
+
+		interfaceAddrs = func() ([]net.Addr, error) {
+			return nil, errors.New("no interfaces")
+		}
+		_, err = advertisedHost(common.ClusterConfig{AdvertiseHost: AutoAdvertiseHost})
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("uses the host name by default", func() {
+		hostname, _ := os.Hostname()
+		host, err := advertisedHost(common.ClusterConfig{})
+		Ω(err).Should(BeNil())
+		Ω(host).Should(Equal(hostname))
+	})
+})
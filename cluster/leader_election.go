@@ -0,0 +1,208 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+	"github.com/uber/aresdb/utils"
+)
+
+// leaderCandidatePrefix is the prefix of the sequential candidate nodes of leader elections.
+const leaderCandidatePrefix = "candidate_"
+
+// leaderElection is the candidacy of the instance in the leader election of a role. Candidates
+// create sequential ephemeral nodes under the election node, the candidate with the lowest sequence
+// is the leader and every other candidate watches the node right before its own.
+type leaderElection struct {
+	zkc  zkConn
+	path string
+	data []byte
+	acl  []zk.ACL
+	// interval to wait before retrying after ZooKeeper errors.
+	retryInterval time.Duration
+
+	isLeader   chan bool
+	done       chan struct{}
+	stopped    chan struct{}
+	resignOnce sync.Once
+}
+
+// ElectLeader joins the leader election of role. true is delivered on the returned channel when the
+// instance becomes the leader and false when it loses leadership, the instance joins the election
+// again after losing its candidate node. resign leaves the election, deleting the candidate node,
+// and closes the channel. Elections are resigned on Disconnect.
+func (mm *membershipManagerImpl) ElectLeader(role string) (isLeader <-chan bool, resign func(), err error) {
+	mm.Lock()
+	defer mm.Unlock()
+	zkc := mm.zkc
+	if zkc == nil {
+		return nil, nil, utils.StackError(nil, "Not connected to ZooKeeper")
+	}
+
+	e := &leaderElection{
+		zkc:           zkc,
+		path:          leaderElectionPath(mm.cfg.Cluster, role),
+		data:          []byte(mm.cfg.Cluster.InstanceName),
+		acl:           zkACL(*mm.cfg.Clients.ZK),
+		retryInterval: newZKRetryPolicy(*mm.cfg.Clients.ZK).initialInterval,
+		isLeader:      make(chan bool),
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+	if err = ensurePath(zkc, e.path, e.acl); err != nil {
+		return nil, nil, err
+	}
+	node, err := e.join()
+	if err != nil {
+		return nil, nil, err
+	}
+	go e.run(node)
+	mm.elections = append(mm.elections, e)
+	return e.isLeader, e.resign, nil
+}
+
+// join creates the candidate node, returning its name.
+func (e *leaderElection) join() (string, error) {
+	node, err := e.zkc.Create(e.path+"/"+leaderCandidatePrefix, e.data, zk.FlagEphemeral|zk.FlagSequence, e.acl)
+	if err != nil {
+		return "", utils.StackError(err, "Failed to join leader election %s", e.path)
+	}
+	return node[strings.LastIndex(node, "/")+1:], nil
+}
+
+// run follows the election until resigned.
+func (e *leaderElection) run(node string) {
+	defer close(e.stopped)
+	defer close(e.isLeader)
+	logger := utils.GetLogger().With("election", e.path)
+
+	leader := false
+	for {
+		var err error
+		if node == "" {
+			node, err = e.join()
+		}
+		var watch <-chan zk.Event
+		var lowest bool
+		if err == nil {
+			watch, lowest, err = e.watch(node)
+		}
+		if err != nil {
+			logger.With("error", err).Warn("Failed to follow leader election")
+			if !e.sleep(e.retryInterval) {
+				break
+			}
+			continue
+		}
+
+		if watch == nil {
+			// the candidate node is gone with an expired session.
+			node = ""
+			lowest = false
+		}
+		if lowest != leader {
+			leader = lowest
+			logger.With("node", node, "leader", leader).Info("Leadership changed")
+			if !e.send(leader) {
+				break
+			}
+		}
+		if watch != nil && !e.wait(watch) {
+			break
+		}
+	}
+
+	if node != "" {
+		if err := e.zkc.Delete(e.path+"/"+node, -1); err != nil && err != zk.ErrNoNode {
+			logger.With("error", err).Warn("Failed to delete candidate node")
+		}
+	}
+}
+
+// watch watches the candidate node itself if it has the lowest sequence, otherwise the candidate
+// right before it. The returned watch is nil if node does not exist.
+func (e *leaderElection) watch(node string) (watch <-chan zk.Event, lowest bool, err error) {
+	children, _, err := e.zkc.Children(e.path)
+	if err != nil {
+		return nil, false, utils.StackError(err, "Failed to list candidates of %s", e.path)
+	}
+	// the sequence numbers are zero padded so the names sort by sequence.
+	sort.Strings(children)
+	i := sort.SearchStrings(children, node)
+	if i == len(children) || children[i] != node {
+		return nil, false, nil
+	}
+
+	watched := node
+	if i > 0 {
+		watched = children[i-1]
+	}
+	exists, _, watch, err := e.zkc.ExistsW(e.path + "/" + watched)
+	if err != nil {
+		return nil, false, utils.StackError(err, "Failed to watch candidate %s of %s", watched, e.path)
+	}
+	if !exists {
+		// check again right away.
+		closed := make(chan zk.Event)
+		close(closed)
+		return closed, false, nil
+	}
+	return watch, i == 0, nil
+}
+
+// wait returns true once events delivers, false if resigned.
+func (e *leaderElection) wait(events <-chan zk.Event) bool {
+	select {
+	case <-events:
+		return true
+	case <-e.done:
+		return false
+	}
+}
+
+// sleep returns true after d, false if resigned.
+func (e *leaderElection) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-e.done:
+		return false
+	}
+}
+
+// send delivers isLeader, it returns false if resigned.
+func (e *leaderElection) send(isLeader bool) bool {
+	select {
+	case e.isLeader <- isLeader:
+		return true
+	case <-e.done:
+		return false
+	}
+}
+
+// resign leaves the election, it returns once the candidate node is deleted.
+func (e *leaderElection) resign() {
+	e.resignOnce.Do(func() {
+		close(e.done)
+	})
+	<-e.stopped
+}
@@ -0,0 +1,100 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"sort"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/common"
+)
+
+var _ = ginkgo.Describe("leader election", func() {
+	const electionPath = "/ares_controller/test_cluster/leaders/compaction"
+
+	var zkc *fakeZK
+	var cfg common.AresServerConfig
+
+	connect := func(zkc *fakeZK, instanceName string) *membershipManagerImpl {
+		instanceCfg := cfg
+		instanceCfg.Cluster.InstanceName = instanceName
+		mm := newMembershipManager(instanceCfg, nil, nil, (&fakeConnector{zkc: zkc}).connect)
+		Ω(mm.Connect()).Should(Succeed())
+		return mm
+	}
+
+	candidates := func() []string {
+		children, _, err := zkc.Children(electionPath)
+		Ω(err).Should(BeNil())
+		sort.Strings(children)
+		return children
+	}
+
+	ginkgo.BeforeEach(func() {
+		zkc = newFakeZK("/ares_controller/test_cluster/instances")
+		cfg = common.AresServerConfig{
+			Cluster: common.ClusterConfig{
+				Enable:      true,
+				ClusterName: "test_cluster",
+			},
+			Clients: common.ClientsConfig{
+				ZK: &common.ZKConfig{
+					Server:                     "zk1:2181",
+					SessionTimeoutSeconds:      1,
+					ConnectTimeoutSeconds:      1,
+					RetryInitialIntervalMillis: 1,
+				},
+			},
+		}
+	})
+
+	ginkgo.It("elects a new leader once the leader's node disappears", func() {
+		mm1 := connect(zkc, "instance1")
+		isLeader1, _, err := mm1.ElectLeader("compaction")
+		Ω(err).Should(BeNil())
+		Eventually(isLeader1).Should(Receive(BeTrue()))
+
+		mm2 := connect(zkc.newConn(), "instance2")
+		isLeader2, resign2, err := mm2.ElectLeader("compaction")
+		Ω(err).Should(BeNil())
+		Consistently(isLeader2, 100*time.Millisecond).ShouldNot(Receive())
+		Ω(candidates()).Should(Equal([]string{"candidate_0000000000", "candidate_0000000001"}))
+
+		zkc.deleteNode(electionPath + "/candidate_0000000000")
+		Eventually(isLeader1).Should(Receive(BeFalse()))
+		Eventually(isLeader2).Should(Receive(BeTrue()))
+		// instance1 joins again behind instance2.
+		Eventually(candidates).Should(Equal([]string{"candidate_0000000001", "candidate_0000000002"}))
+		Consistently(isLeader1, 100*time.Millisecond).ShouldNot(Receive())
+
+		resign2()
+		Eventually(isLeader2).Should(BeClosed())
+		Eventually(isLeader1).Should(Receive(BeTrue()))
+		Ω(candidates()).Should(Equal([]string{"candidate_0000000002"}))
+
+		mm1.Disconnect()
+		Eventually(isLeader1).Should(BeClosed())
+		Ω(zkc.node(electionPath + "/candidate_0000000002")).Should(BeNil())
+		mm2.Disconnect()
+	})
+
+	ginkgo.It("fails before connecting", func() {
+		mm := newMembershipManager(cfg, nil, nil, (&fakeConnector{zkc: zkc}).connect)
+		_, _, err := mm.ElectLeader("compaction")
+		Ω(err).ShouldNot(BeNil())
+	})
+})
@@ -0,0 +1,610 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+	"github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/metastore"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// MembershipManager manages the membership of the instance in the cluster.
+type MembershipManager interface {
+	// Connect registers the instance in the cluster and starts fetching schemas from the controller.
+	Connect() error
+	// Disconnect stops fetching schemas and leaves the cluster.
+	Disconnect()
+	// SessionState returns the last observed state of the ZooKeeper session.
+	SessionState() zk.State
+	// ElectLeader joins the leader election of role in the cluster.
+	ElectLeader(role string) (isLeader <-chan bool, resign func(), err error)
+	// ListInstances returns the instances registered in the cluster.
+	ListInstances(cluster string) ([]Instance, error)
+	// BeginDrain leaves the cluster and blocks for d while in-flight work finishes.
+	BeginDrain(d time.Duration)
+	// MoveShard reassigns the shard from the source instance to the target instance of the cluster.
+	MoveShard(move ShardMove) error
+	// SchemaLag compares the schema versions reported by the instances of the cluster with the
+	// versions in the metastore of the instance.
+	SchemaLag() ([]InstanceSchemaLag, error)
+}
+
+type membershipManagerImpl struct {
+	sync.Mutex
+	cfg            common.AresServerConfig
+	metaStore      metastore.TableSchemaReader
+	schemaFetchJob *metastore.SchemaFetchJob
+	connector      zkConnector
+	// serializes writes of the instance node.
+	instanceLock sync.Mutex
+	// nil until connected, or if ZooKeeper is not configured.
+	zkc zkConn
+	// last observed session state, zk.StateDisconnected until connected.
+	sessionState zk.State
+	// whether the schema fetch job is running.
+	fetching bool
+	// leader elections joined, resigned on Disconnect.
+	elections []*leaderElection
+	// whether draining started, the instance is not registered again once draining.
+	draining bool
+	// whether the instance node is deleted by BeginDrain.
+	drained bool
+
+	// cancels connecting on Disconnect.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewMembershipManager creates a MembershipManager of the instance, which runs the schema fetch
+// job once connected. The instance is registered in ZooKeeper only if clients.zk is configured,
+// reporting the schema versions of the tables in metaStore.
+func NewMembershipManager(cfg common.AresServerConfig, metaStore metastore.TableSchemaReader, schemaFetchJob *metastore.SchemaFetchJob) MembershipManager {
+	return newMembershipManager(cfg, metaStore, schemaFetchJob, connectZK)
+}
+
+func newMembershipManager(cfg common.AresServerConfig, metaStore metastore.TableSchemaReader, schemaFetchJob *metastore.SchemaFetchJob, connector zkConnector) *membershipManagerImpl {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &membershipManagerImpl{
+		cfg:            cfg,
+		metaStore:      metaStore,
+		schemaFetchJob: schemaFetchJob,
+		connector:      connector,
+		ctx:            ctx,
+		cancel:         cancel,
+	}
+}
+
+// Connect connects to ZooKeeper, retrying until the configured deadline, and creates the ephemeral
+// instance node unless the instance is read only, then fetches schemas and starts the periodic
+// schema fetch job.
+func (mm *membershipManagerImpl) Connect() error {
+	if zkCfg := mm.cfg.Clients.ZK; zkCfg != nil {
+		zkc, events, err := initZKConnection(mm.ctx, *zkCfg, mm.connector)
+		if err != nil {
+			return err
+		}
+		mm.Lock()
+		// Disconnect was called while connecting.
+		if mm.ctx.Err() != nil {
+			mm.Unlock()
+			zkc.Close()
+			return utils.StackError(mm.ctx.Err(), "Disconnected while connecting")
+		}
+		mm.zkc = zkc
+		mm.sessionState = zk.StateHasSession
+		mm.Unlock()
+
+		if err = mm.register(zkc); err != nil {
+			return err
+		}
+		go mm.watchSession(zkc, events)
+		if mm.schemaFetchJob != nil && mm.metaStore != nil {
+			mm.schemaFetchJob.OnSchemaApplied(func(string, *metaCom.Table) {
+				mm.reportSchemaVersions(zkc)
+			})
+		}
+	}
+
+	if mm.schemaFetchJob != nil {
+		// immediate initial fetch
+		mm.schemaFetchJob.FetchSchema()
+		mm.Lock()
+		defer mm.Unlock()
+		// Disconnect was called while connecting.
+		if mm.ctx.Err() != nil {
+			return utils.StackError(mm.ctx.Err(), "Disconnected while connecting")
+		}
+		if mm.cfg.Cluster.SchemaFetchMode == "watch" && mm.zkc != nil {
+			mm.schemaFetchJob.WatchSchemas(&zkSchemaWatcher{zkc: mm.zkc, path: schemaPath(mm.cfg.Cluster)})
+		}
+		go mm.schemaFetchJob.Run()
+		mm.fetching = true
+	}
+	return nil
+}
+
+// register creates the ephemeral instance node of the instance, unless it is read only.
+func (mm *membershipManagerImpl) register(zkc zkConn) error {
+	mm.Lock()
+	draining := mm.draining
+	mm.Unlock()
+	if mm.cfg.Cluster.ReadOnly || draining {
+		return nil
+	}
+
+	path := mm.instancePath()
+	acl := zkACL(*mm.cfg.Clients.ZK)
+	// the parents are missing on a new cluster, other instances may be creating them concurrently.
+	if err := ensurePath(zkc, instancesPath(mm.cfg.Cluster), acl); err != nil {
+		return err
+	}
+	deadline := utils.Now().Add(mm.registerWait())
+	for {
+		// marshaled with each attempt, so that schema versions applied meanwhile are not lost.
+		mm.instanceLock.Lock()
+		instanceBytes, err := mm.marshalInstance()
+		if err != nil {
+			mm.instanceLock.Unlock()
+			return err
+		}
+		_, err = zkc.Create(path, instanceBytes, zk.FlagEphemeral, acl)
+		mm.instanceLock.Unlock()
+		if err == zk.ErrNoNode && utils.Now().Before(deadline) {
+			// the parents were deleted meanwhile.
+			if err = ensurePath(zkc, instancesPath(mm.cfg.Cluster), acl); err != nil {
+				return err
+			}
+			continue
+		}
+		if err == nil {
+			utils.GetLogger().With("path", path).Info("Registered instance")
+			return nil
+		}
+		if err != zk.ErrNodeExists {
+			return utils.StackError(err, "Failed to create instance node %s", path)
+		}
+		var registered bool
+		if registered, err = mm.resolveExistingNode(zkc, path, instanceBytes, deadline); err != nil {
+			return err
+		}
+		if registered {
+			return nil
+		}
+	}
+}
+
+// marshalInstance returns the data of the instance node.
+func (mm *membershipManagerImpl) marshalInstance() ([]byte, error) {
+	host, err := advertisedHost(mm.cfg.Cluster)
+	if err != nil {
+		return nil, err
+	}
+	instance := Instance{
+		Name:      mm.cfg.Cluster.InstanceName,
+		Host:      host,
+		Port:      mm.cfg.Port,
+		Shards:    mm.cfg.Cluster.Shards,
+		Zone:      mm.cfg.Cluster.Zone,
+		DebugPort: mm.cfg.DebugPort,
+	}
+	if mm.metaStore != nil {
+		if instance.SchemaVersions, err = TableSchemaVersions(mm.metaStore); err != nil {
+			return nil, err
+		}
+	}
+	instanceBytes, err := json.Marshal(instance)
+	if err != nil {
+		return nil, utils.StackError(err, "Failed to marshal instance")
+	}
+	return instanceBytes, nil
+}
+
+// reportSchemaVersions updates the instance node with the schema versions in the metastore after a
+// schema change is applied.
+func (mm *membershipManagerImpl) reportSchemaVersions(zkc zkConn) {
+	mm.Lock()
+	draining := mm.draining
+	mm.Unlock()
+	if mm.cfg.Cluster.ReadOnly || draining {
+		return
+	}
+
+	mm.instanceLock.Lock()
+	defer mm.instanceLock.Unlock()
+	path := mm.instancePath()
+	logger := utils.GetLogger().With("path", path)
+	_, stat, err := zkc.Get(path)
+	if err == zk.ErrNoNode {
+		// not registered yet, or registering again with the current versions.
+		return
+	}
+	if err != nil {
+		logger.With("error", err).Warn("Failed to get instance node to report schema versions")
+		return
+	}
+	if stat.EphemeralOwner != zkc.SessionID() {
+		return
+	}
+	instanceBytes, err := mm.marshalInstance()
+	if err != nil {
+		logger.With("error", err).Warn("Failed to report schema versions")
+		return
+	}
+	if _, err = zkc.Set(path, instanceBytes, stat.Version); err != nil && err != zk.ErrNoNode {
+		logger.With("error", err).Warn("Failed to report schema versions")
+	}
+}
+
+// resolveExistingNode handles an existing instance node at path before creating it again, it returns
+// true if the node is already registered by the current session. A node left by a previous run of the
+// instance, identified by the same instance data or not being ephemeral, is deleted. A node of another
+// session is waited on to expire until deadline.
+func (mm *membershipManagerImpl) resolveExistingNode(zkc zkConn, path string, instanceBytes []byte, deadline time.Time) (bool, error) {
+	data, stat, err := zkc.Get(path)
+	if err == zk.ErrNoNode {
+		return false, nil
+	}
+	if err != nil {
+		return false, utils.StackError(err, "Failed to get instance node %s", path)
+	}
+	if stat.EphemeralOwner == zkc.SessionID() {
+		return true, nil
+	}
+
+	logger := utils.GetLogger().With("path", path, "owner", stat.EphemeralOwner)
+	if stat.EphemeralOwner == 0 || bytes.Equal(data, instanceBytes) {
+		logger.Info("Deleting stale instance node")
+		// a new node created meanwhile is kept by the version check.
+		if err = zkc.Delete(path, stat.Version); err != nil && err != zk.ErrNoNode && err != zk.ErrBadVersion {
+			return false, utils.StackError(err, "Failed to delete stale instance node %s", path)
+		}
+		return false, nil
+	}
+
+	exists, _, events, err := zkc.ExistsW(path)
+	if err != nil {
+		return false, utils.StackError(err, "Failed to watch instance node %s", path)
+	}
+	if !exists {
+		return false, nil
+	}
+	logger.Warn("Waiting for the instance node of another session to expire")
+	timer := time.NewTimer(deadline.Sub(utils.Now()))
+	defer timer.Stop()
+	select {
+	case <-events:
+		return false, nil
+	case <-timer.C:
+		return false, utils.StackError(zk.ErrNodeExists, "Instance node %s is still owned by session %d", path, stat.EphemeralOwner)
+	case <-mm.ctx.Done():
+		return false, utils.StackError(mm.ctx.Err(), "Disconnected while registering")
+	}
+}
+
+// registerWait returns how long to wait for the instance node of another session to expire.
+func (mm *membershipManagerImpl) registerWait() time.Duration {
+	zkCfg := mm.cfg.Clients.ZK
+	if zkCfg.RegisterWaitSeconds > 0 {
+		return time.Duration(zkCfg.RegisterWaitSeconds) * time.Second
+	}
+	return time.Duration(zkCfg.SessionTimeoutSeconds) * time.Second
+}
+
+func (mm *membershipManagerImpl) instancePath() string {
+	return fmt.Sprintf("%s/%s", instancesPath(mm.cfg.Cluster), mm.cfg.Cluster.InstanceName)
+}
+
+// watchSession records the session state from events until the connection is closed. The instance
+// node is removed by ZooKeeper with an expired session, so the instance registers again once the
+// session is reestablished after being lost.
+func (mm *membershipManagerImpl) watchSession(zkc zkConn, events <-chan zk.Event) {
+	lost := false
+	for event := range events {
+		if event.Type != zk.EventSession {
+			continue
+		}
+		mm.Lock()
+		mm.sessionState = event.State
+		mm.Unlock()
+
+		switch event.State {
+		case zk.StateExpired:
+			utils.GetLogger().Warn("ZooKeeper session expired")
+			lost = true
+		case zk.StateDisconnected:
+			utils.GetLogger().Warn("Disconnected from ZooKeeper")
+			lost = true
+		case zk.StateHasSession:
+			if !lost {
+				continue
+			}
+			lost = false
+			// the node is kept if the session survived the disconnection.
+			if err := mm.register(zkc); err != nil && mm.ctx.Err() == nil {
+				utils.GetLogger().With("error", err).Error("Failed to register instance after reconnecting")
+			}
+		}
+	}
+}
+
+// ListInstances returns the instances registered in cluster, sorted by name. The shards of the
+// instances in the shard assignment of the cluster are the assigned ones instead of the advertised
+// ones.
+func (mm *membershipManagerImpl) ListInstances(cluster string) ([]Instance, error) {
+	mm.Lock()
+	zkc := mm.zkc
+	mm.Unlock()
+	if zkc == nil {
+		return nil, utils.StackError(nil, "Not connected to ZooKeeper")
+	}
+
+	clusterCfg := mm.cfg.Cluster
+	clusterCfg.ClusterName = cluster
+	instances, err := listAdvertisedInstances(zkc, clusterCfg)
+	if err != nil {
+		return nil, err
+	}
+	assignment, _, err := getShardAssignment(zkc, clusterCfg)
+	if err != nil {
+		return nil, err
+	}
+	for i := range instances {
+		if shards, ok := assignment[instances[i].Name]; ok {
+			instances[i].Shards = shards
+		}
+	}
+	return instances, nil
+}
+
+// listAdvertisedInstances returns the instances registered in the cluster as advertised in their
+// instance nodes, sorted by name.
+func listAdvertisedInstances(zkc zkConn, clusterCfg common.ClusterConfig) ([]Instance, error) {
+	parent := instancesPath(clusterCfg)
+	names, _, err := zkc.Children(parent)
+	if err == zk.ErrNoNode {
+		return []Instance{}, nil
+	}
+	if err != nil {
+		return nil, utils.StackError(err, "Failed to list instance nodes of %s", parent)
+	}
+	sort.Strings(names)
+
+	instances := make([]Instance, 0, len(names))
+	for _, name := range names {
+		data, _, err := zkc.Get(parent + "/" + name)
+		if err == zk.ErrNoNode {
+			// the instance left after listing.
+			continue
+		}
+		if err != nil {
+			return nil, utils.StackError(err, "Failed to get instance node %s/%s", parent, name)
+		}
+		var instance Instance
+		if err = json.Unmarshal(data, &instance); err != nil {
+			return nil, utils.StackError(err, "Invalid instance node %s/%s", parent, name)
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
+
+// getShardAssignment returns the shards assigned to each instance of the cluster and the version of
+// the assignment node, a nil assignment if no shard was moved yet.
+func getShardAssignment(zkc zkConn, clusterCfg common.ClusterConfig) (map[string][]uint32, int32, error) {
+	path := shardAssignmentPath(clusterCfg)
+	data, stat, err := zkc.Get(path)
+	if err == zk.ErrNoNode {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, utils.StackError(err, "Failed to get shard assignment %s", path)
+	}
+	var assignment map[string][]uint32
+	if err = json.Unmarshal(data, &assignment); err != nil {
+		return nil, 0, utils.StackError(err, "Invalid shard assignment %s", path)
+	}
+	return assignment, stat.Version, nil
+}
+
+// MoveShard reassigns the shard from the source instance to the target instance in the shard
+// assignment of the cluster with a single versioned write, so that the ownership of both instances
+// changes atomically. The assignment is initialized with the advertised shards of the registered
+// instances on the first move, and is updated again if modified concurrently.
+func (mm *membershipManagerImpl) MoveShard(move ShardMove) error {
+	mm.Lock()
+	zkc := mm.zkc
+	mm.Unlock()
+	if zkc == nil {
+		return utils.StackError(nil, "Not connected to ZooKeeper")
+	}
+
+	clusterCfg := mm.cfg.Cluster
+	path := shardAssignmentPath(clusterCfg)
+	for {
+		assignment, version, err := getShardAssignment(zkc, clusterCfg)
+		if err != nil {
+			return err
+		}
+		exists := assignment != nil
+		if !exists {
+			instances, err := listAdvertisedInstances(zkc, clusterCfg)
+			if err != nil {
+				return err
+			}
+			assignment = make(map[string][]uint32, len(instances))
+			for _, instance := range instances {
+				assignment[instance.Name] = instance.Shards
+			}
+		}
+		if err = applyShardMove(assignment, move); err != nil {
+			return err
+		}
+		data, err := json.Marshal(assignment)
+		if err != nil {
+			return utils.StackError(err, "Failed to marshal shard assignment")
+		}
+
+		if exists {
+			_, err = zkc.Set(path, data, version)
+		} else if err = ensurePath(zkc, clusterPath(clusterCfg), zkACL(*mm.cfg.Clients.ZK)); err == nil {
+			_, err = zkc.Create(path, data, 0, zkACL(*mm.cfg.Clients.ZK))
+		}
+		if err == zk.ErrBadVersion || err == zk.ErrNodeExists {
+			// assigned concurrently.
+			continue
+		}
+		if err != nil {
+			return utils.StackError(err, "Failed to write shard assignment %s", path)
+		}
+		utils.GetLogger().With("shard", move.Shard, "source", move.Source, "target", move.Target).
+			Info("Reassigned shard")
+		return nil
+	}
+}
+
+// applyShardMove moves the shard from the source instance to the target instance in assignment.
+func applyShardMove(assignment map[string][]uint32, move ShardMove) error {
+	sourceShards := assignment[move.Source]
+	index := -1
+	for i, shard := range sourceShards {
+		if shard == move.Shard {
+			index = i
+		}
+	}
+	if index < 0 {
+		return utils.StackError(nil, "Shard %d is not owned by %s", move.Shard, move.Source)
+	}
+	for _, shard := range assignment[move.Target] {
+		if shard == move.Shard {
+			return utils.StackError(nil, "Shard %d is already owned by %s", move.Shard, move.Target)
+		}
+	}
+	assignment[move.Source] = append(append([]uint32{}, sourceShards[:index]...), sourceShards[index+1:]...)
+	targetShards := append(append([]uint32{}, assignment[move.Target]...), move.Shard)
+	sort.Slice(targetShards, func(i, j int) bool { return targetShards[i] < targetShards[j] })
+	assignment[move.Target] = targetShards
+	return nil
+}
+
+// SchemaLag compares the schema versions reported by the instances of the cluster with the
+// versions in the metastore of the instance.
+func (mm *membershipManagerImpl) SchemaLag() ([]InstanceSchemaLag, error) {
+	if mm.metaStore == nil {
+		return nil, utils.StackError(nil, "No metastore to read schema versions from")
+	}
+	latestVersions, err := TableSchemaVersions(mm.metaStore)
+	if err != nil {
+		return nil, err
+	}
+	instances, err := mm.ListInstances(mm.cfg.Cluster.ClusterName)
+	if err != nil {
+		return nil, err
+	}
+	return ComputeSchemaLag(instances, latestVersions), nil
+}
+
+// SessionState returns the last observed state of the ZooKeeper session.
+func (mm *membershipManagerImpl) SessionState() zk.State {
+	mm.Lock()
+	defer mm.Unlock()
+	return mm.sessionState
+}
+
+// deregister deletes the instance node if it is owned by the session of zkc, so that the instance
+// leaves the cluster without waiting for the session to expire. Read only instances have no node.
+func (mm *membershipManagerImpl) deregister(zkc zkConn) error {
+	if mm.cfg.Cluster.ReadOnly {
+		return nil
+	}
+	path := mm.instancePath()
+	_, stat, err := zkc.Get(path)
+	if err == zk.ErrNoNode {
+		return nil
+	}
+	if err != nil {
+		return utils.StackError(err, "Failed to get instance node %s", path)
+	}
+	if stat.EphemeralOwner != zkc.SessionID() {
+		return nil
+	}
+	if err = zkc.Delete(path, stat.Version); err != nil && err != zk.ErrNoNode {
+		return utils.StackError(err, "Failed to delete instance node %s", path)
+	}
+	utils.GetLogger().With("path", path).Info("Deregistered instance")
+	return nil
+}
+
+// BeginDrain deletes the instance node so that the cluster stops routing new queries to the
+// instance, then blocks for d while in-flight queries finish, or until Disconnect is called. The
+// instance is not registered again afterwards, the ZooKeeper connection and the schema fetch job
+// are kept until Disconnect.
+func (mm *membershipManagerImpl) BeginDrain(d time.Duration) {
+	mm.Lock()
+	mm.draining = true
+	if mm.zkc != nil {
+		if err := mm.deregister(mm.zkc); err != nil {
+			utils.GetLogger().With("error", err).Warn("Failed to deregister instance for draining")
+		} else {
+			mm.drained = true
+		}
+	}
+	mm.Unlock()
+
+	utils.GetLogger().With("drain", d).Info("Draining instance")
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-mm.ctx.Done():
+	}
+}
+
+// Disconnect aborts connecting, stops the schema fetch job, deletes the instance node and closes the
+// ZooKeeper connection. It can be called without or before a successful Connect.
+func (mm *membershipManagerImpl) Disconnect() {
+	mm.cancel()
+	mm.Lock()
+	defer mm.Unlock()
+	if mm.fetching && mm.schemaFetchJob != nil {
+		mm.schemaFetchJob.Stop()
+	}
+	mm.fetching = false
+	for _, e := range mm.elections {
+		e.resign()
+	}
+	mm.elections = nil
+	if mm.zkc != nil {
+		// the node is already deleted if drained.
+		if !mm.drained {
+			if err := mm.deregister(mm.zkc); err != nil {
+				// the node is removed by ZooKeeper once the session expires.
+				utils.GetLogger().With("error", err).Warn("Failed to deregister instance")
+			}
+		}
+		mm.zkc.Close()
+		mm.zkc = nil
+	}
+	mm.sessionState = zk.StateDisconnected
+}
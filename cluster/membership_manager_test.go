@@ -0,0 +1,453 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	clientsMocks "github.com/uber/aresdb/clients/mocks"
+	"github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/metastore"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	metaMocks "github.com/uber/aresdb/metastore/mocks"
+)
+
+var _ = ginkgo.Describe("MembershipManager", func() {
+	const instancePath = "/ares_controller/test_cluster/instances/instance0"
+
+	var zkc *fakeZK
+	var cfg common.AresServerConfig
+
+	ginkgo.BeforeEach(func() {
+		zkc = newFakeZK("/ares_controller/test_cluster/instances")
+		cfg = common.AresServerConfig{
+			Port: 9374,
+			Cluster: common.ClusterConfig{
+				Enable:       true,
+				ClusterName:  "test_cluster",
+				InstanceName: "instance0",
+			},
+			Clients: common.ClientsConfig{
+				ZK: &common.ZKConfig{
+					Server:                     "zk1:2181,zk2:2181",
+					SessionTimeoutSeconds:      1,
+					ConnectTimeoutSeconds:      1,
+					RetryInitialIntervalMillis: 1,
+					RetryMaxIntervalMillis:     4,
+					RetryMaxElapsedSeconds:     1,
+				},
+			},
+		}
+	})
+
+	ginkgo.It("retries connecting and registers the instance", func() {
+		connector := &fakeConnector{zkc: zkc, failures: 3}
+		mm := newMembershipManager(cfg, nil, nil, connector.connect)
+		Ω(mm.Connect()).Should(Succeed())
+		Ω(connector.getAttempts()).Should(Equal(4))
+
+		node := zkc.node(instancePath)
+		Ω(node).ShouldNot(BeNil())
+		Ω(node.owner).Should(Equal(zkc.SessionID()))
+		var instance Instance
+		Ω(json.Unmarshal(node.data, &instance)).Should(Succeed())
+		hostname, _ := os.Hostname()
+		Ω(instance).Should(Equal(Instance{Name: "instance0", Host: hostname, Port: 9374}))
+
+		mm.Disconnect()
+		Ω(zkc.isClosed()).Should(BeTrue())
+		Ω(zkc.node(instancePath)).Should(BeNil())
+	})
+
+	ginkgo.It("keeps the instance node of another session on Disconnect", func() {
+		mm := newMembershipManager(cfg, nil, nil, (&fakeConnector{zkc: zkc}).connect)
+		// never connected.
+		mm.Disconnect()
+
+		mm = newMembershipManager(cfg, nil, nil, (&fakeConnector{zkc: zkc}).connect)
+		Ω(mm.Connect()).Should(Succeed())
+		zkc.deleteNode(instancePath)
+		zkc.putNode(instancePath, []byte("other"), 1)
+		mm.Disconnect()
+		Ω(zkc.node(instancePath)).ShouldNot(BeNil())
+		Ω(zkc.isClosed()).Should(BeTrue())
+	})
+
+	ginkgo.It("registers again once the session is reestablished", func() {
+		connector := &fakeConnector{zkc: zkc}
+		mm := newMembershipManager(cfg, nil, nil, connector.connect)
+		Ω(mm.Connect()).Should(Succeed())
+		Ω(mm.SessionState()).Should(Equal(zk.StateHasSession))
+
+		zkc.expire()
+		Eventually(mm.SessionState).Should(Equal(zk.StateExpired))
+		Ω(zkc.node(instancePath)).Should(BeNil())
+		zkc.sendEvent(zk.StateHasSession)
+		Eventually(func() *fakeZNode {
+			return zkc.node(instancePath)
+		}).ShouldNot(BeNil())
+		Ω(mm.SessionState()).Should(Equal(zk.StateHasSession))
+
+		// the node is kept if the session survives a disconnection.
+		zkc.sendEvent(zk.StateDisconnected)
+		Eventually(mm.SessionState).Should(Equal(zk.StateDisconnected))
+		zkc.sendEvent(zk.StateHasSession)
+		Eventually(mm.SessionState).Should(Equal(zk.StateHasSession))
+		Ω(zkc.node(instancePath)).ShouldNot(BeNil())
+
+		mm.Disconnect()
+		Ω(mm.SessionState()).Should(Equal(zk.StateDisconnected))
+	})
+
+	ginkgo.It("replaces the instance node left by a previous run", func() {
+		hostname, _ := os.Hostname()
+		instanceBytes, _ := json.Marshal(Instance{Name: "instance0", Host: hostname, Port: 9374})
+		for _, owner := range []int64{1, 0} {
+			data := instanceBytes
+			if owner == 0 {
+				data = []byte("stale")
+			}
+			zkc.putNode(instancePath, data, owner)
+			mm := newMembershipManager(cfg, nil, nil, (&fakeConnector{zkc: zkc}).connect)
+			Ω(mm.Connect()).Should(Succeed())
+			Ω(zkc.node(instancePath).owner).Should(Equal(zkc.SessionID()))
+			Ω(zkc.node(instancePath).data).Should(Equal(instanceBytes))
+			mm.Disconnect()
+			zkc = newFakeZK("/ares_controller/test_cluster/instances")
+		}
+	})
+
+	ginkgo.It("waits for the instance node of another session to expire", func() {
+		cfg.Clients.ZK.RegisterWaitSeconds = 10
+		zkc.putNode(instancePath, []byte(`{"name":"instance0","host":"other"}`), 1)
+		mm := newMembershipManager(cfg, nil, nil, (&fakeConnector{zkc: zkc}).connect)
+		errChan := make(chan error)
+		go func() {
+			errChan <- mm.Connect()
+		}()
+		Consistently(errChan, 100*time.Millisecond).ShouldNot(Receive())
+		zkc.deleteNode(instancePath)
+		Eventually(errChan, time.Second).Should(Receive(BeNil()))
+		Ω(zkc.node(instancePath).owner).Should(Equal(zkc.SessionID()))
+		mm.Disconnect()
+
+		// fails if the node does not expire in time.
+		cfg.Clients.ZK.RegisterWaitSeconds = 0
+		zkc = newFakeZK("/ares_controller/test_cluster/instances")
+		zkc.putNode(instancePath, []byte(`{"name":"instance0","host":"other"}`), 1)
+		mm = newMembershipManager(cfg, nil, nil, (&fakeConnector{zkc: zkc}).connect)
+		err := mm.Connect()
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("still owned by session 1"))
+		mm.Disconnect()
+	})
+
+	ginkgo.It("creates the parent nodes in an empty namespace", func() {
+		zkc = newFakeZK()
+		cfg.Clients.ZK.AuthScheme = "digest"
+		cfg.Clients.ZK.Username = "ares"
+		mm := newMembershipManager(cfg, nil, nil, (&fakeConnector{zkc: zkc}).connect)
+		Ω(mm.Connect()).Should(Succeed())
+		for _, p := range []string{"/ares_controller", "/ares_controller/test_cluster", "/ares_controller/test_cluster/instances"} {
+			node := zkc.node(p)
+			Ω(node).ShouldNot(BeNil(), p)
+			Ω(node.owner).Should(BeZero())
+			Ω(node.acl).Should(Equal(zkACL(*cfg.Clients.ZK)))
+		}
+		Ω(zkc.node(instancePath).owner).Should(Equal(zkc.SessionID()))
+		mm.Disconnect()
+		Ω(zkc.node("/ares_controller/test_cluster/instances")).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("registers instances creating the parent nodes concurrently", func() {
+		zkc = newFakeZK()
+		var managers []MembershipManager
+		for i := 0; i < 5; i++ {
+			instanceCfg := cfg
+			instanceCfg.Cluster.InstanceName = fmt.Sprintf("instance%d", i)
+			managers = append(managers, newMembershipManager(instanceCfg, nil, nil, (&fakeConnector{zkc: zkc.newConn()}).connect))
+		}
+		errChan := make(chan error)
+		for _, mm := range managers {
+			go func(mm MembershipManager) {
+				errChan <- mm.Connect()
+			}(mm)
+		}
+		for range managers {
+			Eventually(errChan).Should(Receive(BeNil()))
+		}
+		instances, err := managers[0].ListInstances("test_cluster")
+		Ω(err).Should(BeNil())
+		Ω(instances).Should(HaveLen(5))
+		for _, mm := range managers {
+			mm.Disconnect()
+		}
+	})
+
+	ginkgo.It("registers under the configured root", func() {
+		cfg.Cluster.ZKRoot = "/shared/ares"
+		zkc = newFakeZK("/shared/ares/test_cluster/instances")
+		mm := newMembershipManager(cfg, nil, nil, (&fakeConnector{zkc: zkc}).connect)
+		Ω(mm.Connect()).Should(Succeed())
+		Ω(zkc.node("/shared/ares/test_cluster/instances/instance0")).ShouldNot(BeNil())
+		mm.Disconnect()
+	})
+
+	ginkgo.It("lists the registered instances with their shards and zone", func() {
+		cfg.Cluster.Shards = []uint32{0, 2}
+		cfg.Cluster.Zone = "zone1"
+		mm := newMembershipManager(cfg, nil, nil, (&fakeConnector{zkc: zkc}).connect)
+		Ω(mm.Connect()).Should(Succeed())
+		// instance nodes written before shards and zone were advertised.
+		zkc.putNode("/ares_controller/test_cluster/instances/instance1", []byte(`{"name":"instance1","host":"host1","port":9374}`), 1)
+
+		instances, err := mm.ListInstances("test_cluster")
+		Ω(err).Should(BeNil())
+		hostname, _ := os.Hostname()
+		Ω(instances).Should(Equal([]Instance{
+			{Name: "instance0", Host: hostname, Port: 9374, Shards: []uint32{0, 2}, Zone: "zone1"},
+			{Name: "instance1", Host: "host1", Port: 9374},
+		}))
+
+		instances, err = mm.ListInstances("other_cluster")
+		Ω(err).Should(BeNil())
+		Ω(instances).Should(BeEmpty())
+
+		zkc.putNode("/ares_controller/test_cluster/instances/instance2", []byte("{"), 1)
+		_, err = mm.ListInstances("test_cluster")
+		Ω(err).ShouldNot(BeNil())
+		mm.Disconnect()
+	})
+
+	ginkgo.It("omits unset shards and zone in the instance node", func() {
+		data, err := json.Marshal(Instance{Name: "instance0", Host: "host0", Port: 9374})
+		Ω(err).Should(BeNil())
+		Ω(string(data)).Should(Equal(`{"name":"instance0","host":"host0","port":9374}`))
+	})
+
+	ginkgo.It("moves shards in the shard assignment overriding the advertised shards", func() {
+		const assignmentPath = "/ares_controller/test_cluster/assignment"
+		cfg.Cluster.Shards = []uint32{0, 1, 2}
+		mm := newMembershipManager(cfg, nil, nil, (&fakeConnector{zkc: zkc}).connect)
+		Ω(mm.Connect()).Should(Succeed())
+		zkc.putNode("/ares_controller/test_cluster/instances/instance1", []byte(`{"name":"instance1","host":"host1","port":9374,"shards":[3]}`), 1)
+
+		// the first move initializes the assignment with the advertised shards.
+		Ω(mm.MoveShard(ShardMove{Shard: 1, Source: "instance0", Target: "instance1"})).Should(Succeed())
+		Ω(string(zkc.node(assignmentPath).data)).Should(Equal(`{"instance0":[0,2],"instance1":[1,3]}`))
+		Ω(mm.MoveShard(ShardMove{Shard: 0, Source: "instance0", Target: "instance1"})).Should(Succeed())
+		Ω(string(zkc.node(assignmentPath).data)).Should(Equal(`{"instance0":[2],"instance1":[0,1,3]}`))
+		Ω(zkc.node(assignmentPath).version).Should(Equal(int32(1)))
+
+		instances, err := mm.ListInstances("test_cluster")
+		Ω(err).Should(BeNil())
+		hostname, _ := os.Hostname()
+		Ω(instances).Should(Equal([]Instance{
+			{Name: "instance0", Host: hostname, Port: 9374, Shards: []uint32{2}},
+			{Name: "instance1", Host: "host1", Port: 9374, Shards: []uint32{0, 1, 3}},
+		}))
+
+		Ω(mm.MoveShard(ShardMove{Shard: 3, Source: "instance0", Target: "instance1"})).ShouldNot(Succeed())
+		Ω(mm.MoveShard(ShardMove{Shard: 2, Source: "instance0", Target: "instance0"})).ShouldNot(Succeed())
+		Ω(string(zkc.node(assignmentPath).data)).Should(Equal(`{"instance0":[2],"instance1":[0,1,3]}`))
+
+		zkc.setData(assignmentPath, []byte("{"))
+		_, err = mm.ListInstances("test_cluster")
+		Ω(err).ShouldNot(BeNil())
+		mm.Disconnect()
+	})
+
+	ginkgo.It("authenticates and creates nodes with the ACL of the credentials", func() {
+		mm := newMembershipManager(cfg, nil, nil, (&fakeConnector{zkc: zkc}).connect)
+		Ω(mm.Connect()).Should(Succeed())
+		Ω(zkc.auths).Should(BeEmpty())
+		Ω(zkc.node(instancePath).acl).Should(Equal(zk.WorldACL(zk.PermAll)))
+		mm.Disconnect()
+
+		cfg.Clients.ZK.AuthScheme = "digest"
+		cfg.Clients.ZK.Username = "ares"
+		cfg.Clients.ZK.Password = "secret"
+		zkc = newFakeZK("/ares_controller/test_cluster/instances")
+		mm = newMembershipManager(cfg, nil, nil, (&fakeConnector{zkc: zkc}).connect)
+		Ω(mm.Connect()).Should(Succeed())
+		Ω(zkc.auths).Should(Equal([]string{"digest:ares:secret"}))
+		Ω(zkc.node(instancePath).acl).Should(Equal(zk.DigestACL(zk.PermAll, "ares", "secret")))
+		mm.Disconnect()
+
+		cfg.Clients.ZK.ACLScheme = "world"
+		Ω(zkACL(*cfg.Clients.ZK)).Should(Equal(zk.WorldACL(zk.PermAll)))
+	})
+
+	ginkgo.It("watches schemas in ZooKeeper in watch mode", func() {
+		cfg.Cluster.SchemaFetchMode = "watch"
+		zkc = newFakeZK("/ares_controller/test_cluster/instances", "/ares_controller/test_cluster/schema")
+		controllerClient := &clientsMocks.ControllerClient{}
+		controllerClient.On("GetSchemaHash", "test_cluster").Return("123", nil)
+		job := metastore.NewSchemaFetchJob(60, &metaMocks.TableSchemaMutator{}, &metaMocks.TableSchemaValidator{}, controllerClient, "test_cluster", "123")
+		mm := newMembershipManager(cfg, nil, job, (&fakeConnector{zkc: zkc}).connect)
+		Ω(mm.Connect()).Should(Succeed())
+		Ω(job.Mode).Should(Equal(metastore.SchemaFetchModeWatch))
+		Ω(job.LastSuccess()).ShouldNot(BeZero())
+		mm.Disconnect()
+	})
+
+	ginkgo.It("reports the applied schema versions in the instance node", func() {
+		tableV1 := metaCom.Table{Name: "trips", Version: 1}
+		tableV2 := metaCom.Table{Name: "trips", Version: 2}
+		metaStore := &metaMocks.TableSchemaReader{}
+		metaStore.On("ListTables").Return([]string{"trips"}, nil)
+		metaStore.On("GetTable", "trips").Return(&tableV1, nil).Once()
+		metaStore.On("GetTable", "trips").Return(&tableV2, nil)
+
+		controllerClient := &clientsMocks.ControllerClient{}
+		controllerClient.On("GetSchemaHash", "test_cluster").Return("456", nil)
+		controllerClient.On("GetAllSchema", "test_cluster").Return([]metaCom.Table{tableV2}, nil)
+		schemaMutator := &metaMocks.TableSchemaMutator{}
+		schemaMutator.On("ListTables").Return([]string{"trips"}, nil)
+		schemaMutator.On("GetTable", "trips").Return(&tableV1, nil)
+		schemaMutator.On("ApplySchemas", mock.Anything).Return(nil)
+		schemaValidator := &metaMocks.TableSchemaValidator{}
+		schemaValidator.On("SetNewTable", mock.Anything).Return()
+		schemaValidator.On("SetOldTable", mock.Anything).Return()
+		schemaValidator.On("Validate").Return(nil)
+		job := metastore.NewSchemaFetchJob(60, schemaMutator, schemaValidator, controllerClient, "test_cluster", "123")
+
+		mm := newMembershipManager(cfg, metaStore, job, (&fakeConnector{zkc: zkc}).connect)
+		Ω(mm.Connect()).Should(Succeed())
+		reportedVersions := func() map[string]int {
+			var instance Instance
+			Ω(json.Unmarshal(zkc.node(instancePath).data, &instance)).Should(Succeed())
+			return instance.SchemaVersions
+		}
+		Eventually(reportedVersions).Should(Equal(map[string]int{"trips": 2}))
+		Ω(zkc.node(instancePath).owner).Should(Equal(zkc.SessionID()))
+
+		zkc.putNode("/ares_controller/test_cluster/instances/instance1",
+			[]byte(`{"name":"instance1","schemaVersions":{"trips":1}}`), 1)
+		lags, err := mm.SchemaLag()
+		Ω(err).Should(BeNil())
+		Ω(lags).Should(HaveLen(2))
+		Ω(lags[0].Instance).Should(Equal("instance0"))
+		Ω(lags[0].Lagging).Should(BeFalse())
+		Ω(lags[1].Instance).Should(Equal("instance1"))
+		Ω(lags[1].Lagging).Should(BeTrue())
+		mm.Disconnect()
+
+		mm = newMembershipManager(cfg, nil, nil, (&fakeConnector{zkc: zkc}).connect)
+		_, err = mm.SchemaLag()
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("fetches schemas without registering in read only mode", func() {
+		cfg.Cluster.ReadOnly = true
+		controllerClient := &clientsMocks.ControllerClient{}
+		controllerClient.On("GetSchemaHash", "test_cluster").Return("123", nil)
+		job := metastore.NewSchemaFetchJob(60, &metaMocks.TableSchemaMutator{}, &metaMocks.TableSchemaValidator{}, controllerClient, "test_cluster", "123")
+		mm := newMembershipManager(cfg, nil, job, (&fakeConnector{zkc: zkc}).connect)
+		Ω(mm.Connect()).Should(Succeed())
+		Ω(job.LastSuccess()).ShouldNot(BeZero())
+		Ω(zkc.node(instancePath)).Should(BeNil())
+
+		// reconnecting does not register either.
+		zkc.expire()
+		zkc.sendEvent(zk.StateHasSession)
+		Eventually(mm.SessionState).Should(Equal(zk.StateHasSession))
+		Ω(zkc.node(instancePath)).Should(BeNil())
+		mm.Disconnect()
+		Ω(zkc.isClosed()).Should(BeTrue())
+	})
+
+	ginkgo.It("leaves the cluster before draining", func() {
+		mm := newMembershipManager(cfg, nil, nil, (&fakeConnector{zkc: zkc}).connect)
+		Ω(mm.Connect()).Should(Succeed())
+		drained := make(chan struct{})
+		go func() {
+			mm.BeginDrain(200 * time.Millisecond)
+			close(drained)
+		}()
+		Eventually(func() *fakeZNode {
+			return zkc.node(instancePath)
+		}).Should(BeNil())
+		Consistently(drained, 100*time.Millisecond).ShouldNot(BeClosed())
+		Eventually(drained).Should(BeClosed())
+
+		// not registered again on reconnection while draining.
+		zkc.expire()
+		zkc.sendEvent(zk.StateHasSession)
+		Eventually(mm.SessionState).Should(Equal(zk.StateHasSession))
+		Ω(zkc.node(instancePath)).Should(BeNil())
+		Ω(zkc.isClosed()).Should(BeFalse())
+		mm.Disconnect()
+		Ω(zkc.isClosed()).Should(BeTrue())
+	})
+
+	ginkgo.It("stops draining on Disconnect", func() {
+		mm := newMembershipManager(cfg, nil, nil, (&fakeConnector{zkc: zkc}).connect)
+		Ω(mm.Connect()).Should(Succeed())
+		drained := make(chan struct{})
+		go func() {
+			mm.BeginDrain(time.Hour)
+			close(drained)
+		}()
+		Eventually(func() *fakeZNode {
+			return zkc.node(instancePath)
+		}).Should(BeNil())
+		mm.Disconnect()
+		Eventually(drained).Should(BeClosed())
+	})
+
+	ginkgo.It("fails once the retry deadline is reached", func() {
+		connector := &fakeConnector{zkc: zkc, failures: 1 << 30}
+		mm := newMembershipManager(cfg, nil, nil, connector.connect)
+		err := mm.Connect()
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("Failed to connect to ZooKeeper after"))
+		Ω(connector.getAttempts()).Should(BeNumerically(">", 1))
+		Ω(zkc.node(instancePath)).Should(BeNil())
+	})
+
+	ginkgo.It("aborts connecting on Disconnect", func() {
+		cfg.Clients.ZK.RetryInitialIntervalMillis = 10000
+		cfg.Clients.ZK.RetryMaxElapsedSeconds = 60
+		connector := &fakeConnector{zkc: zkc, failures: 1 << 30}
+		mm := newMembershipManager(cfg, nil, nil, connector.connect)
+		errChan := make(chan error)
+		go func() {
+			errChan <- mm.Connect()
+		}()
+		Eventually(connector.getAttempts).Should(Equal(1))
+		mm.Disconnect()
+		var err error
+		Eventually(errChan, time.Second).Should(Receive(&err))
+		Ω(err.Error()).Should(ContainSubstring("aborted"))
+		Ω(connector.getAttempts()).Should(Equal(1))
+	})
+
+	ginkgo.It("skips ZooKeeper if not configured", func() {
+		cfg.Clients.ZK = nil
+		connector := &fakeConnector{zkc: zkc}
+		mm := newMembershipManager(cfg, nil, nil, connector.connect)
+		Ω(mm.Connect()).Should(Succeed())
+		Ω(connector.getAttempts()).Should(Equal(0))
+		mm.Disconnect()
+	})
+})
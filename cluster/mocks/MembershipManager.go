@@ -0,0 +1,142 @@
+// Code generated by mockery v1.0.0
+package mocks
+
+import cluster "github.com/uber/aresdb/cluster"
+import mock "github.com/stretchr/testify/mock"
+import time "time"
+import zk "github.com/go-zookeeper/zk"
+
+// MembershipManager is an autogenerated mock type for the MembershipManager type
+type MembershipManager struct {
+	mock.Mock
+}
+
+// BeginDrain provides a mock function with given fields: d
+func (_m *MembershipManager) BeginDrain(d time.Duration) {
+	_m.Called(d)
+}
+
+// Connect provides a mock function with given fields:
+func (_m *MembershipManager) Connect() error {
+	ret := _m.Called()
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Disconnect provides a mock function with given fields:
+func (_m *MembershipManager) Disconnect() {
+	_m.Called()
+}
+
+// ElectLeader provides a mock function with given fields: role
+func (_m *MembershipManager) ElectLeader(role string) (<-chan bool, func(), error) {
+	ret := _m.Called(role)
+
+	var r0 <-chan bool
+	if rf, ok := ret.Get(0).(func(string) <-chan bool); ok {
+		r0 = rf(role)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(<-chan bool)
+		}
+	}
+
+	var r1 func()
+	if rf, ok := ret.Get(1).(func(string) func()); ok {
+		r1 = rf(role)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(func())
+		}
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(string) error); ok {
+		r2 = rf(role)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// ListInstances provides a mock function with given fields: _a0
+func (_m *MembershipManager) ListInstances(_a0 string) ([]cluster.Instance, error) {
+	ret := _m.Called(_a0)
+
+	var r0 []cluster.Instance
+	if rf, ok := ret.Get(0).(func(string) []cluster.Instance); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]cluster.Instance)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MoveShard provides a mock function with given fields: move
+func (_m *MembershipManager) MoveShard(move cluster.ShardMove) error {
+	ret := _m.Called(move)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(cluster.ShardMove) error); ok {
+		r0 = rf(move)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SchemaLag provides a mock function with given fields:
+func (_m *MembershipManager) SchemaLag() ([]cluster.InstanceSchemaLag, error) {
+	ret := _m.Called()
+
+	var r0 []cluster.InstanceSchemaLag
+	if rf, ok := ret.Get(0).(func() []cluster.InstanceSchemaLag); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]cluster.InstanceSchemaLag)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SessionState provides a mock function with given fields:
+func (_m *MembershipManager) SessionState() zk.State {
+	ret := _m.Called()
+
+	var r0 zk.State
+	if rf, ok := ret.Get(0).(func() zk.State); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(zk.State)
+	}
+
+	return r0
+}
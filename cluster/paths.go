@@ -0,0 +1,58 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"fmt"
+
+	"github.com/uber/aresdb/common"
+)
+
+// DefaultZKRoot is the root znode of the clusters if cluster.zk_root is not configured.
+const DefaultZKRoot = "/ares_controller"
+
+// zkRoot returns the root znode of the clusters.
+func zkRoot(cfg common.ClusterConfig) string {
+	if cfg.ZKRoot == "" {
+		return DefaultZKRoot
+	}
+	return cfg.ZKRoot
+}
+
+// clusterPath returns the znode of the cluster.
+func clusterPath(cfg common.ClusterConfig) string {
+	return fmt.Sprintf("%s/%s", zkRoot(cfg), cfg.ClusterName)
+}
+
+// instancesPath returns the parent znode of the instance nodes of the cluster.
+func instancesPath(cfg common.ClusterConfig) string {
+	return clusterPath(cfg) + "/instances"
+}
+
+// shardAssignmentPath returns the znode of the shard assignment of the cluster, which overrides
+// the shards advertised by the instances once shards are moved.
+func shardAssignmentPath(cfg common.ClusterConfig) string {
+	return clusterPath(cfg) + "/assignment"
+}
+
+// schemaPath returns the parent znode of the table schema nodes of the cluster.
+func schemaPath(cfg common.ClusterConfig) string {
+	return clusterPath(cfg) + "/schema"
+}
+
+// leaderElectionPath returns the parent znode of the candidate nodes of the leader election of role.
+func leaderElectionPath(cfg common.ClusterConfig, role string) string {
+	return fmt.Sprintf("%s/leaders/%s", clusterPath(cfg), role)
+}
@@ -0,0 +1,248 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	metaCom "github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// defaultShardTransferTimeout is the timeout of each shard transfer request if not configured.
+const defaultShardTransferTimeout = 10 * time.Minute
+
+// ShardMove moves a shard from the source instance to the target instance.
+type ShardMove struct {
+	Shard  uint32 `json:"shard"`
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// ComputeRebalancePlan returns the moves balancing the number of shards owned by the instances,
+// so that they differ by at most one. Each move takes a shard from one of the most loaded
+// instances to one of the least loaded instances not owning a replica of it yet, the lowest shard
+// and instance names first so that the plan is deterministic. The replicas of a shard are kept on
+// distinct instances: an instance owning at least two shards more than another always owns one the
+// other does not.
+func ComputeRebalancePlan(instances []Instance) []ShardMove {
+	names := make([]string, 0, len(instances))
+	owned := make(map[string]map[uint32]bool, len(instances))
+	for _, instance := range instances {
+		if owned[instance.Name] == nil {
+			names = append(names, instance.Name)
+			owned[instance.Name] = make(map[uint32]bool)
+		}
+		for _, shard := range instance.Shards {
+			owned[instance.Name][shard] = true
+		}
+	}
+
+	var moves []ShardMove
+	for {
+		// most loaded first, by name on ties.
+		sort.Slice(names, func(i, j int) bool {
+			if len(owned[names[i]]) != len(owned[names[j]]) {
+				return len(owned[names[i]]) > len(owned[names[j]])
+			}
+			return names[i] < names[j]
+		})
+		move, found := nextShardMove(names, owned)
+		if !found {
+			return moves
+		}
+		delete(owned[move.Source], move.Shard)
+		owned[move.Target][move.Shard] = true
+		moves = append(moves, move)
+	}
+}
+
+// nextShardMove returns the move from the most loaded instance to the least loaded instance of
+// names, which are sorted by load descending, that reduces the imbalance.
+func nextShardMove(names []string, owned map[string]map[uint32]bool) (ShardMove, bool) {
+	for i := 0; i < len(names); i++ {
+		source := names[i]
+		for j := len(names) - 1; j > i; j-- {
+			target := names[j]
+			if len(owned[source])-len(owned[target]) <= 1 {
+				break
+			}
+			var shards []uint32
+			for shard := range owned[source] {
+				if !owned[target][shard] {
+					shards = append(shards, shard)
+				}
+			}
+			if len(shards) == 0 {
+				continue
+			}
+			sort.Slice(shards, func(a, b int) bool { return shards[a] < shards[b] })
+			return ShardMove{Shard: shards[0], Source: source, Target: target}, true
+		}
+	}
+	return ShardMove{}, false
+}
+
+// ShardTransport transfers the archived data of table shards between instances.
+type ShardTransport interface {
+	// CopyShard copies the archived data of the table shard from the source instance to the target
+	// instance, which serves it once copied.
+	CopyShard(table string, shard uint32, source, target Instance) error
+	// DropShard deletes the table shard from the instance.
+	DropShard(table string, shard uint32, instance Instance) error
+}
+
+// ShardAssigner updates the shard ownership of the cluster.
+type ShardAssigner interface {
+	// MoveShard reassigns the shard of the move from the source instance to the target instance.
+	MoveShard(move ShardMove) error
+}
+
+// ExecuteRebalancePlan executes the moves in order and returns the moves executed. For each move,
+// the shard of each sharded fact table is copied to the target instance, then the ownership of the
+// shard is reassigned, and only then the shard is dropped from the source instance. Executing stops
+// at the first failure. A shard that failed to be dropped is served by the target instance only,
+// the failure is returned with the move counted as executed.
+func ExecuteRebalancePlan(moves []ShardMove, instances []Instance, tables []metaCom.Table,
+	transport ShardTransport, assigner ShardAssigner) ([]ShardMove, error) {
+	instancesByName := make(map[string]Instance, len(instances))
+	for _, instance := range instances {
+		instancesByName[instance.Name] = instance
+	}
+
+	var executed []ShardMove
+	for _, move := range moves {
+		source, sourceFound := instancesByName[move.Source]
+		target, targetFound := instancesByName[move.Target]
+		if !sourceFound || !targetFound {
+			return executed, utils.StackError(nil, "Instance %s or %s of shard %d is not registered",
+				move.Source, move.Target, move.Shard)
+		}
+
+		var movedTables []string
+		for _, table := range tables {
+			if !table.IsFactTable || int(move.Shard) >= len(table.GetShards()) {
+				continue
+			}
+			if err := transport.CopyShard(table.Name, move.Shard, source, target); err != nil {
+				return executed, utils.StackError(err, "Failed to copy shard %d of table %s from %s to %s",
+					move.Shard, table.Name, move.Source, move.Target)
+			}
+			movedTables = append(movedTables, table.Name)
+		}
+
+		if err := assigner.MoveShard(move); err != nil {
+			return executed, utils.StackError(err, "Failed to assign shard %d to %s", move.Shard, move.Target)
+		}
+		executed = append(executed, move)
+		utils.GetLogger().With("shard", move.Shard, "source", move.Source, "target", move.Target,
+			"tables", movedTables).Info("Moved shard")
+
+		for _, table := range movedTables {
+			if err := transport.DropShard(table, move.Shard, source); err != nil {
+				return executed, utils.StackError(err, "Failed to drop shard %d of table %s from %s",
+					move.Shard, table, move.Source)
+			}
+		}
+	}
+	return executed, nil
+}
+
+// httpShardTransport transfers table shards through the debug servers of the instances.
+type httpShardTransport struct {
+	httpClient http.Client
+}
+
+// NewHTTPShardTransport returns a ShardTransport calling the debug servers of the instances, with
+// the timeout for each request, 10 minutes if <= 0.
+func NewHTTPShardTransport(timeout time.Duration) ShardTransport {
+	if timeout <= 0 {
+		timeout = defaultShardTransferTimeout
+	}
+	return &httpShardTransport{httpClient: http.Client{Timeout: timeout}}
+}
+
+// CopyShard archives the table on the source instance so that recent rows are copied too, then
+// streams the archived data of the shard from the source instance to the target instance.
+func (t *httpShardTransport) CopyShard(table string, shard uint32, source, target Instance) error {
+	sourceAddress, err := debugAddress(source)
+	if err != nil {
+		return err
+	}
+	targetAddress, err := debugAddress(target)
+	if err != nil {
+		return err
+	}
+
+	archiveURL := fmt.Sprintf("http://%s/dbg/tables/%s/archive", sourceAddress, table)
+	if err = t.do(http.MethodPost, archiveURL, nil); err != nil {
+		return err
+	}
+
+	response, err := t.httpClient.Get(archivedDataURL(sourceAddress, table, shard))
+	if err != nil {
+		return utils.StackError(err, "Failed to get archived data from %s", source.Name)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return utils.StackError(nil, "Failed to get archived data from %s, status: %s", source.Name, response.Status)
+	}
+	return t.do(http.MethodPut, archivedDataURL(targetAddress, table, shard), response.Body)
+}
+
+// DropShard deletes the table shard from the instance.
+func (t *httpShardTransport) DropShard(table string, shard uint32, instance Instance) error {
+	address, err := debugAddress(instance)
+	if err != nil {
+		return err
+	}
+	return t.do(http.MethodDelete, fmt.Sprintf("http://%s/dbg/%s/%d", address, table, shard), nil)
+}
+
+// do sends the request and fails on non 2xx responses.
+func (t *httpShardTransport) do(method, url string, body io.Reader) error {
+	request, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return utils.StackError(err, "Failed to create request %s %s", method, url)
+	}
+	response, err := t.httpClient.Do(request)
+	if err != nil {
+		return utils.StackError(err, "Failed to send request %s %s", method, url)
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return utils.StackError(nil, "Request %s %s failed, status: %s", method, url, response.Status)
+	}
+	return nil
+}
+
+// debugAddress returns the address of the debug server of the instance.
+func debugAddress(instance Instance) (string, error) {
+	if instance.DebugPort == 0 {
+		return "", utils.StackError(nil, "Instance %s does not advertise a debug port", instance.Name)
+	}
+	return net.JoinHostPort(instance.Host, strconv.Itoa(instance.DebugPort)), nil
+}
+
+// archivedDataURL returns the url of the archived data of the table shard on the debug server.
+func archivedDataURL(address, table string, shard uint32) string {
+	return fmt.Sprintf("http://%s/dbg/%s/%d/archived-data", address, table, shard)
+}
@@ -0,0 +1,254 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metaCom "github.com/uber/aresdb/metastore/common"
+)
+
+// fakeShardTransport records the shard transfers as "copy|drop table/shard instance".
+type fakeShardTransport struct {
+	calls *[]string
+	// fails the call of the record.
+	failOn string
+}
+
+func (t fakeShardTransport) record(call string) error {
+	*t.calls = append(*t.calls, call)
+	if call == t.failOn {
+		return errors.New("transfer failed")
+	}
+	return nil
+}
+
+func (t fakeShardTransport) CopyShard(table string, shard uint32, source, target Instance) error {
+	return t.record(fmt.Sprintf("copy %s/%d %s %s", table, shard, source.Name, target.Name))
+}
+
+func (t fakeShardTransport) DropShard(table string, shard uint32, instance Instance) error {
+	return t.record(fmt.Sprintf("drop %s/%d %s", table, shard, instance.Name))
+}
+
+// fakeShardAssigner records the moves as "assign shard target".
+type fakeShardAssigner struct {
+	calls *[]string
+	err   error
+}
+
+func (a fakeShardAssigner) MoveShard(move ShardMove) error {
+	*a.calls = append(*a.calls, fmt.Sprintf("assign %d %s", move.Shard, move.Target))
+	return a.err
+}
+
+var _ = ginkgo.Describe("rebalance", func() {
+	// loads returns the shards owned by each instance after the moves.
+	loads := func(instances []Instance, moves []ShardMove) map[string][]uint32 {
+		owned := map[string]map[uint32]bool{}
+		for _, instance := range instances {
+			owned[instance.Name] = map[uint32]bool{}
+			for _, shard := range instance.Shards {
+				owned[instance.Name][shard] = true
+			}
+		}
+		for _, move := range moves {
+			Ω(owned[move.Source]).Should(HaveKey(move.Shard))
+			Ω(owned[move.Target]).ShouldNot(HaveKey(move.Shard))
+			delete(owned[move.Source], move.Shard)
+			owned[move.Target][move.Shard] = true
+		}
+		result := map[string][]uint32{}
+		for name, shards := range owned {
+			result[name] = []uint32{}
+			for shard := uint32(0); shard < 16; shard++ {
+				if shards[shard] {
+					result[name] = append(result[name], shard)
+				}
+			}
+		}
+		return result
+	}
+
+	ginkgo.It("balances the shards of the instances", func() {
+		instances := []Instance{
+			{Name: "a", Shards: []uint32{0, 1, 2, 3, 4, 5}},
+			{Name: "b", Shards: []uint32{6, 7}},
+			{Name: "c"},
+		}
+		moves := ComputeRebalancePlan(instances)
+		Ω(moves).Should(Equal([]ShardMove{
+			{Shard: 0, Source: "a", Target: "c"},
+			{Shard: 1, Source: "a", Target: "c"},
+			{Shard: 2, Source: "a", Target: "c"},
+		}))
+		Ω(loads(instances, moves)).Should(Equal(map[string][]uint32{
+			"a": {3, 4, 5},
+			"b": {6, 7},
+			"c": {0, 1, 2},
+		}))
+		// the plan is deterministic.
+		Ω(ComputeRebalancePlan(instances)).Should(Equal(moves))
+	})
+
+	ginkgo.It("keeps the replicas of a shard on distinct instances", func() {
+		instances := []Instance{
+			{Name: "a", Shards: []uint32{0, 1, 2, 3}},
+			{Name: "b", Shards: []uint32{0, 1}},
+			{Name: "c", Shards: []uint32{2, 3}},
+		}
+		moves := ComputeRebalancePlan(instances)
+		Ω(loads(instances, moves)).Should(Equal(map[string][]uint32{
+			"a": {1, 2, 3},
+			"b": {0, 1},
+			"c": {0, 2, 3},
+		}))
+
+		instances = []Instance{
+			{Name: "a", Shards: []uint32{0, 1, 2}},
+			{Name: "b", Shards: []uint32{0, 1, 2}},
+			{Name: "c", Shards: []uint32{0}},
+		}
+		Ω(loads(instances, ComputeRebalancePlan(instances))).Should(Equal(map[string][]uint32{
+			"a": {0, 2},
+			"b": {0, 1, 2},
+			"c": {0, 1},
+		}))
+	})
+
+	ginkgo.It("does not move shards of a balanced cluster", func() {
+		Ω(ComputeRebalancePlan(nil)).Should(BeEmpty())
+		Ω(ComputeRebalancePlan([]Instance{{Name: "a", Shards: []uint32{0}}})).Should(BeEmpty())
+		Ω(ComputeRebalancePlan([]Instance{
+			{Name: "a", Shards: []uint32{0, 1}},
+			{Name: "b", Shards: []uint32{2}},
+			{Name: "c", Shards: []uint32{3, 4}},
+		})).Should(BeEmpty())
+	})
+
+	ginkgo.Context("ExecuteRebalancePlan", func() {
+		var calls []string
+		instances := []Instance{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+		tables := []metaCom.Table{
+			{Name: "trips", IsFactTable: true, NumShards: 4},
+			{Name: "events", IsFactTable: true, NumShards: 2},
+			{Name: "cities"},
+		}
+		moves := []ShardMove{
+			{Shard: 0, Source: "a", Target: "b"},
+			{Shard: 3, Source: "a", Target: "c"},
+		}
+
+		ginkgo.BeforeEach(func() {
+			calls = nil
+		})
+
+		ginkgo.It("copies, assigns and then drops each shard of the fact tables", func() {
+			executed, err := ExecuteRebalancePlan(moves, instances, tables, fakeShardTransport{calls: &calls},
+				fakeShardAssigner{calls: &calls})
+			Ω(err).Should(BeNil())
+			Ω(executed).Should(Equal(moves))
+			Ω(calls).Should(Equal([]string{
+				"copy trips/0 a b",
+				"copy events/0 a b",
+				"assign 0 b",
+				"drop trips/0 a",
+				"drop events/0 a",
+				// events has no shard 3.
+				"copy trips/3 a c",
+				"assign 3 c",
+				"drop trips/3 a",
+			}))
+		})
+
+		ginkgo.It("keeps the shard on the source if it fails to be copied or assigned", func() {
+			executed, err := ExecuteRebalancePlan(moves, instances, tables,
+				fakeShardTransport{calls: &calls, failOn: "copy events/0 a b"}, fakeShardAssigner{calls: &calls})
+			Ω(err).ShouldNot(BeNil())
+			Ω(executed).Should(BeEmpty())
+			Ω(calls).Should(Equal([]string{"copy trips/0 a b", "copy events/0 a b"}))
+
+			calls = nil
+			executed, err = ExecuteRebalancePlan(moves, instances, tables, fakeShardTransport{calls: &calls},
+				fakeShardAssigner{calls: &calls, err: errors.New("bad version")})
+			Ω(err).ShouldNot(BeNil())
+			Ω(executed).Should(BeEmpty())
+			Ω(calls).Should(Equal([]string{"copy trips/0 a b", "copy events/0 a b", "assign 0 b"}))
+		})
+
+		ginkgo.It("counts the move as executed if the shard fails to be dropped", func() {
+			executed, err := ExecuteRebalancePlan(moves, instances, tables,
+				fakeShardTransport{calls: &calls, failOn: "drop trips/0 a"}, fakeShardAssigner{calls: &calls})
+			Ω(err).ShouldNot(BeNil())
+			Ω(executed).Should(Equal(moves[:1]))
+
+			_, err = ExecuteRebalancePlan([]ShardMove{{Shard: 0, Source: "a", Target: "d"}}, instances, tables,
+				fakeShardTransport{calls: &calls}, fakeShardAssigner{calls: &calls})
+			Ω(err).ShouldNot(BeNil())
+		})
+	})
+
+	ginkgo.It("streams the archived data of a shard between debug servers", func() {
+		var lock sync.Mutex
+		var requests []string
+		var loaded string
+		newServer := func(name string) (*httptest.Server, Instance) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				lock.Lock()
+				defer lock.Unlock()
+				requests = append(requests, fmt.Sprintf("%s %s %s", name, r.Method, r.URL.Path))
+				switch {
+				case r.Method == http.MethodGet && r.URL.Path == "/dbg/trips/1/archived-data":
+					w.Write([]byte("archived data of " + name))
+				case r.Method == http.MethodPut:
+					loaded = string(body)
+				case r.URL.Path == "/dbg/unknown/1/archived-data":
+					w.WriteHeader(http.StatusBadRequest)
+				}
+			}))
+			host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+			debugPort, _ := strconv.Atoi(port)
+			return server, Instance{Name: name, Host: host, DebugPort: debugPort}
+		}
+		sourceServer, source := newServer("source")
+		defer sourceServer.Close()
+		targetServer, target := newServer("target")
+		defer targetServer.Close()
+
+		transport := NewHTTPShardTransport(0)
+		Ω(transport.CopyShard("trips", 1, source, target)).Should(Succeed())
+		Ω(transport.DropShard("trips", 1, source)).Should(Succeed())
+		Ω(loaded).Should(Equal("archived data of source"))
+		Ω(requests).Should(Equal([]string{
+			"source POST /dbg/tables/trips/archive",
+			"source GET /dbg/trips/1/archived-data",
+			"target PUT /dbg/trips/1/archived-data",
+			"source DELETE /dbg/trips/1",
+		}))
+
+		Ω(transport.CopyShard("unknown", 1, source, target)).ShouldNot(Succeed())
+		Ω(transport.CopyShard("trips", 1, source, Instance{Name: "nodebug"})).ShouldNot(Succeed())
+	})
+})
@@ -0,0 +1,94 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"sort"
+
+	"github.com/uber/aresdb/metastore"
+	"github.com/uber/aresdb/utils"
+)
+
+// TableSchemaLag compares the schema version of a table applied by an instance with the version
+// in the metastore.
+type TableSchemaLag struct {
+	Table string `json:"table"`
+	// nil if the instance has not applied the table.
+	AppliedVersion *int `json:"appliedVersion"`
+	// nil if the table is deleted from the metastore.
+	LatestVersion *int `json:"latestVersion"`
+	Lagging       bool `json:"lagging"`
+}
+
+// InstanceSchemaLag is the schema lag of an instance, lagging if any of its tables is lagging.
+type InstanceSchemaLag struct {
+	Instance string           `json:"instance"`
+	Lagging  bool             `json:"lagging"`
+	Tables   []TableSchemaLag `json:"tables"`
+}
+
+// TableSchemaVersions returns the schema versions of the tables in the metastore by table name.
+func TableSchemaVersions(reader metastore.TableSchemaReader) (map[string]int, error) {
+	tables, err := reader.ListTables()
+	if err != nil {
+		return nil, utils.StackError(err, "Failed to list tables")
+	}
+	versions := make(map[string]int, len(tables))
+	for _, name := range tables {
+		table, err := reader.GetTable(name)
+		if err != nil {
+			return nil, utils.StackError(err, "Failed to get table %s", name)
+		}
+		versions[name] = table.Version
+	}
+	return versions, nil
+}
+
+// ComputeSchemaLag compares the schema versions reported by each instance with latestVersions, the
+// versions in the metastore. Tables applied by an instance but deleted from the metastore are
+// lagging as well. Tables are sorted by name.
+func ComputeSchemaLag(instances []Instance, latestVersions map[string]int) []InstanceSchemaLag {
+	lags := make([]InstanceSchemaLag, 0, len(instances))
+	for _, instance := range instances {
+		names := make(map[string]bool, len(latestVersions))
+		for name := range latestVersions {
+			names[name] = true
+		}
+		for name := range instance.SchemaVersions {
+			names[name] = true
+		}
+
+		lag := InstanceSchemaLag{Instance: instance.Name, Tables: make([]TableSchemaLag, 0, len(names))}
+		for name := range names {
+			tableLag := TableSchemaLag{Table: name}
+			applied, hasApplied := instance.SchemaVersions[name]
+			if hasApplied {
+				tableLag.AppliedVersion = &applied
+			}
+			latest, hasLatest := latestVersions[name]
+			if hasLatest {
+				tableLag.LatestVersion = &latest
+			}
+			tableLag.Lagging = !hasApplied || !hasLatest || applied < latest
+			lag.Lagging = lag.Lagging || tableLag.Lagging
+			lag.Tables = append(lag.Tables, tableLag)
+		}
+		sort.Slice(lag.Tables, func(i, j int) bool {
+			return lag.Tables[i].Table < lag.Tables[j].Table
+		})
+		lags = append(lags, lag)
+	}
+	return lags
+}
@@ -0,0 +1,74 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"errors"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	metaMocks "github.com/uber/aresdb/metastore/mocks"
+)
+
+var _ = ginkgo.Describe("schema lag", func() {
+	version := func(v int) *int {
+		return &v
+	}
+
+	ginkgo.It("compares the schema versions of instances at mixed versions", func() {
+		lags := ComputeSchemaLag([]Instance{
+			{Name: "current", SchemaVersions: map[string]int{"trips": 3, "cities": 1}},
+			{Name: "behind", SchemaVersions: map[string]int{"trips": 2, "cities": 1}},
+			{Name: "missing", SchemaVersions: map[string]int{"trips": 3}},
+			{Name: "deleted", SchemaVersions: map[string]int{"trips": 3, "cities": 1, "drivers": 4}},
+			{Name: "ahead", SchemaVersions: map[string]int{"trips": 4, "cities": 1}},
+			{Name: "unreported"},
+		}, map[string]int{"trips": 3, "cities": 1})
+		Ω(lags).Should(HaveLen(6))
+
+		Ω(lags[0]).Should(Equal(InstanceSchemaLag{Instance: "current", Tables: []TableSchemaLag{
+			{Table: "cities", AppliedVersion: version(1), LatestVersion: version(1)},
+			{Table: "trips", AppliedVersion: version(3), LatestVersion: version(3)},
+		}}))
+		Ω(lags[1]).Should(Equal(InstanceSchemaLag{Instance: "behind", Lagging: true, Tables: []TableSchemaLag{
+			{Table: "cities", AppliedVersion: version(1), LatestVersion: version(1)},
+			{Table: "trips", AppliedVersion: version(2), LatestVersion: version(3), Lagging: true},
+		}}))
+		Ω(lags[2]).Should(Equal(InstanceSchemaLag{Instance: "missing", Lagging: true, Tables: []TableSchemaLag{
+			{Table: "cities", LatestVersion: version(1), Lagging: true},
+			{Table: "trips", AppliedVersion: version(3), LatestVersion: version(3)},
+		}}))
+		Ω(lags[3].Lagging).Should(BeTrue())
+		Ω(lags[3].Tables[1]).Should(Equal(TableSchemaLag{Table: "drivers", AppliedVersion: version(4), Lagging: true}))
+		Ω(lags[4].Lagging).Should(BeFalse())
+		Ω(lags[5].Lagging).Should(BeTrue())
+		Ω(lags[5].Tables).Should(HaveLen(2))
+	})
+
+	ginkgo.It("reads the schema versions of the metastore", func() {
+		reader := &metaMocks.TableSchemaReader{}
+		reader.On("ListTables").Return([]string{"trips", "cities"}, nil).Once()
+		reader.On("GetTable", "trips").Return(&metaCom.Table{Name: "trips", Version: 3}, nil)
+		reader.On("GetTable", "cities").Return(&metaCom.Table{Name: "cities", Version: 1}, nil)
+		versions, err := TableSchemaVersions(reader)
+		Ω(err).Should(BeNil())
+		Ω(versions).Should(Equal(map[string]int{"trips": 3, "cities": 1}))
+
+		reader.On("ListTables").Return(nil, errors.New("read failed")).Once()
+		_, err = TableSchemaVersions(reader)
+		Ω(err).ShouldNot(BeNil())
+	})
+})
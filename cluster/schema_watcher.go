@@ -0,0 +1,74 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"encoding/json"
+
+	"github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// zkSchemaWatcher is a metastore.SchemaWatcher of the table schemas the controller publishes under
+// the schema node of the cluster, one child node per table holding its json schema. The controller
+// sets the data of the schema node on every table change, so a single watch covers all tables.
+type zkSchemaWatcher struct {
+	zkc  zkConn
+	path string
+}
+
+// Watch returns the versions of the table nodes, the returned channel is closed once the schema node
+// changes or the watch is lost with the session.
+func (w *zkSchemaWatcher) Watch() (map[string]int32, <-chan struct{}, error) {
+	// watched before listing so that no change after listing is missed.
+	_, _, events, err := w.zkc.GetW(w.path)
+	if err != nil {
+		return nil, nil, utils.StackError(err, "Failed to watch schema node %s", w.path)
+	}
+	changed := make(chan struct{})
+	go func() {
+		<-events
+		close(changed)
+	}()
+
+	names, _, err := w.zkc.Children(w.path)
+	if err != nil {
+		return nil, nil, utils.StackError(err, "Failed to list table nodes of %s", w.path)
+	}
+	versions := make(map[string]int32, len(names))
+	for _, name := range names {
+		exists, stat, err := w.zkc.Exists(w.path + "/" + name)
+		if err != nil {
+			return nil, nil, utils.StackError(err, "Failed to get table node %s/%s", w.path, name)
+		}
+		if exists {
+			versions[name] = stat.Version
+		}
+	}
+	return versions, changed, nil
+}
+
+// GetTable returns the schema of the table node.
+func (w *zkSchemaWatcher) GetTable(name string) (*common.Table, error) {
+	data, _, err := w.zkc.Get(w.path + "/" + name)
+	if err != nil {
+		return nil, utils.StackError(err, "Failed to get table node %s/%s", w.path, name)
+	}
+	var table common.Table
+	if err = json.Unmarshal(data, &table); err != nil {
+		return nil, utils.StackError(err, "Invalid table node %s/%s", w.path, name)
+	}
+	return &table, nil
+}
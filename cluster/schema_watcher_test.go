@@ -0,0 +1,65 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/metastore/common"
+)
+
+var _ = ginkgo.Describe("zkSchemaWatcher", func() {
+	const schemaPath = "/ares_controller/test_cluster/schema"
+
+	ginkgo.It("watches the versions of the table nodes", func() {
+		zkc := newFakeZK(schemaPath)
+		zkc.setData(schemaPath+"/trips", []byte(`{"name":"trips","version":1}`))
+		zkc.setData(schemaPath+"/cities", []byte(`{"name":"cities","version":1}`))
+		watcher := &zkSchemaWatcher{zkc: zkc, path: schemaPath}
+
+		versions, changed, err := watcher.Watch()
+		Ω(err).Should(BeNil())
+		Ω(versions).Should(Equal(map[string]int32{"trips": 0, "cities": 0}))
+		Consistently(changed).ShouldNot(BeClosed())
+
+		// the controller updates the table node, then the schema node.
+		zkc.setData(schemaPath+"/trips", []byte(`{"name":"trips","version":2}`))
+		zkc.setData(schemaPath, []byte("hash2"))
+		Eventually(changed).Should(BeClosed())
+
+		versions, changed, err = watcher.Watch()
+		Ω(err).Should(BeNil())
+		Ω(versions).Should(Equal(map[string]int32{"trips": 1, "cities": 0}))
+		table, err := watcher.GetTable("trips")
+		Ω(err).Should(BeNil())
+		Ω(table).Should(Equal(&common.Table{Name: "trips", Version: 2}))
+
+		// the watch is lost with the session.
+		zkc.Close()
+		Eventually(changed).Should(BeClosed())
+		_, _, err = watcher.Watch()
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("fails on invalid table nodes", func() {
+		zkc := newFakeZK(schemaPath)
+		zkc.setData(schemaPath+"/trips", []byte("{"))
+		watcher := &zkSchemaWatcher{zkc: zkc, path: schemaPath}
+		_, err := watcher.GetTable("trips")
+		Ω(err).ShouldNot(BeNil())
+		_, err = watcher.GetTable("cities")
+		Ω(err).ShouldNot(BeNil())
+	})
+})
@@ -0,0 +1,292 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+	"github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/utils"
+)
+
+const (
+	defaultZKRetryInitialInterval = 500 * time.Millisecond
+	defaultZKRetryMaxInterval     = 10 * time.Second
+	defaultZKRetryMaxElapsed      = 60 * time.Second
+)
+
+// zkConn is the subset of *zk.Conn used for cluster membership.
+type zkConn interface {
+	Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error)
+	Get(path string) ([]byte, *zk.Stat, error)
+	GetW(path string) ([]byte, *zk.Stat, <-chan zk.Event, error)
+	Exists(path string) (bool, *zk.Stat, error)
+	ExistsW(path string) (bool, *zk.Stat, <-chan zk.Event, error)
+	Children(path string) ([]string, *zk.Stat, error)
+	Set(path string, data []byte, version int32) (*zk.Stat, error)
+	Delete(path string, version int32) error
+	SessionID() int64
+	AddAuth(scheme string, auth []byte) error
+	Close()
+}
+
+// zkACL returns the ACL of the nodes created with the config.
+func zkACL(cfg common.ZKConfig) []zk.ACL {
+	if cfg.ACLScheme == "digest" || (cfg.ACLScheme == "" && cfg.AuthScheme == "digest") {
+		return zk.DigestACL(zk.PermAll, cfg.Username, cfg.Password)
+	}
+	return zk.WorldACL(zk.PermAll)
+}
+
+// ensurePath creates the persistent node at path and its parents if missing.
+func ensurePath(zkc zkConn, path string, acl []zk.ACL) error {
+	for i := 1; i <= len(path); i++ {
+		if i < len(path) && path[i] != '/' {
+			continue
+		}
+		if _, err := zkc.Create(path[:i], nil, 0, acl); err != nil && err != zk.ErrNodeExists {
+			return utils.StackError(err, "Failed to create node %s", path[:i])
+		}
+	}
+	return nil
+}
+
+// srvServerPrefix is the prefix of a ZooKeeper server resolved by DNS SRV lookup of the rest.
+const srvServerPrefix = "srv+"
+
+// lookupSRV looks up the SRV records of a name, replaced in tests.
+var lookupSRV = func(name string) ([]*net.SRV, error) {
+	_, addrs, err := net.LookupSRV("", "", name)
+	return addrs, err
+}
+
+// parseZKServers returns the comma separated servers, trimmed and without duplicates.
+func parseZKServers(server string) ([]string, error) {
+	var servers []string
+	seen := make(map[string]bool)
+	for _, s := range strings.Split(server, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" || seen[s] {
+			continue
+		}
+		seen[s] = true
+		servers = append(servers, s)
+	}
+	if len(servers) == 0 {
+		return nil, utils.StackError(nil, "No ZooKeeper server in %q", server)
+	}
+	for _, s := range servers {
+		if strings.HasPrefix(s, srvServerPrefix) && len(servers) > 1 {
+			return nil, utils.StackError(nil, "ZooKeeper server %s must be the only server", s)
+		}
+	}
+	return servers, nil
+}
+
+// resolveZKServers returns the ensemble members of the SRV record if servers is a single
+// srv+<name> entry, servers otherwise.
+func resolveZKServers(servers []string) ([]string, error) {
+	if len(servers) != 1 || !strings.HasPrefix(servers[0], srvServerPrefix) {
+		return servers, nil
+	}
+	name := strings.TrimPrefix(servers[0], srvServerPrefix)
+	addrs, err := lookupSRV(name)
+	if err != nil {
+		return nil, utils.StackError(err, "Failed to look up SRV records of %s", name)
+	}
+	var resolved []string
+	seen := make(map[string]bool)
+	for _, addr := range addrs {
+		s := net.JoinHostPort(strings.TrimSuffix(addr.Target, "."), strconv.Itoa(int(addr.Port)))
+		if !seen[s] {
+			seen[s] = true
+			resolved = append(resolved, s)
+		}
+	}
+	if len(resolved) == 0 {
+		return nil, utils.StackError(nil, "No SRV records of %s", name)
+	}
+	return resolved, nil
+}
+
+// zkConnector starts connecting to the ZooKeeper servers, requesting sessionTimeout for the
+// session and dialing each server up to connectTimeout, returning the connection and its
+// session events.
+type zkConnector func(servers []string, sessionTimeout, connectTimeout time.Duration) (zkConn, <-chan zk.Event, error)
+
+// connectZK connects with zk.Connect, logging through the server logger.
+func connectZK(servers []string, sessionTimeout, connectTimeout time.Duration) (zkConn, <-chan zk.Event, error) {
+	dialer := func(network, address string, _ time.Duration) (net.Conn, error) {
+		return net.DialTimeout(network, address, connectTimeout)
+	}
+	conn, events, err := zk.Connect(servers, sessionTimeout,
+		zk.WithDialer(dialer), zk.WithLogger(zkLogger{requestedSessionTimeout: sessionTimeout}))
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, events, nil
+}
+
+// zkSessionEstablishedFormat is the format of the message logged by the ZooKeeper client once a
+// session is established, with the session id and the negotiated session timeout in ms.
+const zkSessionEstablishedFormat = "authenticated: id=%d, timeout=%d"
+
+// zkLogger logs messages of the ZooKeeper client as info, and the negotiated session timeout of
+// each established session.
+type zkLogger struct {
+	requestedSessionTimeout time.Duration
+}
+
+func (l zkLogger) Printf(format string, args ...interface{}) {
+	if format == zkSessionEstablishedFormat && len(args) == 2 {
+		l.logSessionEstablished(args[0], args[1])
+		return
+	}
+	utils.GetLogger().Infof(format, args...)
+}
+
+// logSessionEstablished logs the negotiated session timeout, warning if the ensemble did not
+// grant the requested one.
+func (l zkLogger) logSessionEstablished(sessionID, timeoutMillis interface{}) {
+	negotiated, ok := timeoutMillis.(int32)
+	if !ok {
+		utils.GetLogger().Infof(zkSessionEstablishedFormat, sessionID, timeoutMillis)
+		return
+	}
+	negotiatedTimeout := time.Duration(negotiated) * time.Millisecond
+	logger := utils.GetLogger().With("sessionID", sessionID,
+		"sessionTimeout", negotiatedTimeout, "requestedSessionTimeout", l.requestedSessionTimeout)
+	if negotiatedTimeout != l.requestedSessionTimeout {
+		logger.Warn("ZooKeeper negotiated a different session timeout")
+		return
+	}
+	logger.Info("Established ZooKeeper session")
+}
+
+// zkRetryPolicy is the exponential backoff between connect attempts.
+type zkRetryPolicy struct {
+	initialInterval time.Duration
+	maxInterval     time.Duration
+	maxElapsed      time.Duration
+}
+
+func newZKRetryPolicy(cfg common.ZKConfig) zkRetryPolicy {
+	policy := zkRetryPolicy{
+		initialInterval: time.Duration(cfg.RetryInitialIntervalMillis) * time.Millisecond,
+		maxInterval:     time.Duration(cfg.RetryMaxIntervalMillis) * time.Millisecond,
+		maxElapsed:      time.Duration(cfg.RetryMaxElapsedSeconds) * time.Second,
+	}
+	if policy.initialInterval <= 0 {
+		policy.initialInterval = defaultZKRetryInitialInterval
+	}
+	if policy.maxInterval <= 0 {
+		policy.maxInterval = defaultZKRetryMaxInterval
+	}
+	if policy.maxElapsed <= 0 {
+		policy.maxElapsed = defaultZKRetryMaxElapsed
+	}
+	return policy
+}
+
+// initZKConnection connects to the ZooKeeper servers of the config and waits for a session,
+// retrying with backoff until the max elapsed time of the retry policy. Connecting is aborted
+// once ctx is done. The session events following the established session are delivered on the
+// returned channel, which is closed with the connection.
+func initZKConnection(ctx context.Context, cfg common.ZKConfig, connector zkConnector) (zkConn, <-chan zk.Event, error) {
+	servers, err := parseZKServers(cfg.Server)
+	if err != nil {
+		return nil, nil, err
+	}
+	sessionTimeout := time.Duration(cfg.SessionTimeoutSeconds) * time.Second
+	connectTimeout := time.Duration(cfg.ConnectTimeoutSeconds) * time.Second
+	policy := newZKRetryPolicy(cfg)
+	deadline := utils.Now().Add(policy.maxElapsed)
+	interval := policy.initialInterval
+	for attempt := 1; ; attempt++ {
+		var conn zkConn
+		var events <-chan zk.Event
+		// resolved on each attempt to follow changes of the ensemble.
+		var resolved []string
+		if resolved, err = resolveZKServers(servers); err == nil {
+			conn, events, err = connectZKSession(ctx, resolved, sessionTimeout, connectTimeout, connector)
+		}
+		if err == nil && cfg.AuthScheme != "" {
+			// the credentials are sent again by the client on reconnection.
+			if err = conn.AddAuth(cfg.AuthScheme, []byte(cfg.Username+":"+cfg.Password)); err != nil {
+				conn.Close()
+				err = utils.StackError(err, "Failed to authenticate with ZooKeeper")
+			}
+		}
+		if err == nil {
+			utils.GetLogger().With("attempts", attempt).Info("Connected to ZooKeeper")
+			return conn, events, nil
+		}
+		if ctx.Err() != nil {
+			return nil, nil, utils.StackError(ctx.Err(), "Connecting to ZooKeeper is aborted")
+		}
+		if !utils.Now().Add(interval).Before(deadline) {
+			utils.GetLogger().With("attempts", attempt, "error", err).Error("Failed to connect to ZooKeeper")
+			return nil, nil, utils.StackError(err, "Failed to connect to ZooKeeper after %d attempts", attempt)
+		}
+		utils.GetLogger().With("attempt", attempt, "error", err, "retryIn", interval).Warn("Failed to connect to ZooKeeper")
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return nil, nil, utils.StackError(ctx.Err(), "Connecting to ZooKeeper is aborted")
+		}
+		if interval *= 2; interval > policy.maxInterval {
+			interval = policy.maxInterval
+		}
+	}
+}
+
+// connectZKSession makes a connect attempt, waiting for a session to be established for up to
+// connectTimeout per server.
+func connectZKSession(ctx context.Context, servers []string, sessionTimeout, connectTimeout time.Duration, connector zkConnector) (zkConn, <-chan zk.Event, error) {
+	conn, events, err := connector(servers, sessionTimeout, connectTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	// each server is dialed once before giving up the attempt.
+	timeout := connectTimeout * time.Duration(len(servers))
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return nil, nil, utils.StackError(nil, "ZooKeeper connection closed")
+			}
+			if event.State == zk.StateHasSession {
+				return conn, events, nil
+			}
+			if event.State == zk.StateAuthFailed {
+				conn.Close()
+				return nil, nil, utils.StackError(nil, "ZooKeeper authentication failed")
+			}
+		case <-timer.C:
+			conn.Close()
+			return nil, nil, utils.StackError(nil, "No ZooKeeper session within %v", timeout)
+		case <-ctx.Done():
+			conn.Close()
+			return nil, nil, ctx.Err()
+		}
+	}
+}
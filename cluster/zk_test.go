@@ -0,0 +1,92 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/common"
+)
+
+var _ = ginkgo.Describe("ZooKeeper servers", func() {
+	defaultLookupSRV := lookupSRV
+
+	ginkgo.AfterEach(func() {
+		lookupSRV = defaultLookupSRV
+	})
+
+	ginkgo.It("trims and dedupes the server list", func() {
+		servers, err := parseZKServers(" zk1:2181, zk2:2181 ,,zk1:2181,")
+		Ω(err).Should(BeNil())
+		Ω(servers).Should(Equal([]string{"zk1:2181", "zk2:2181"}))
+
+		_, err = parseZKServers(" , ")
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("No ZooKeeper server"))
+
+		_, err = parseZKServers("srv+zk.svc,zk2:2181")
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("resolves a srv+ server by SRV lookup", func() {
+		lookupSRV = func(name string) ([]*net.SRV, error) {
+			Ω(name).Should(Equal("_client._tcp.zk.ns.svc.cluster.local"))
+			return []*net.SRV{
+				{Target: "zk-0.zk.ns.svc.cluster.local.", Port: 2181},
+				{Target: "zk-1.zk.ns.svc.cluster.local.", Port: 2181},
+				{Target: "zk-0.zk.ns.svc.cluster.local.", Port: 2181},
+			}, nil
+		}
+		servers, err := parseZKServers("srv+_client._tcp.zk.ns.svc.cluster.local")
+		Ω(err).Should(BeNil())
+		resolved, err := resolveZKServers(servers)
+		Ω(err).Should(BeNil())
+		Ω(resolved).Should(Equal([]string{"zk-0.zk.ns.svc.cluster.local:2181", "zk-1.zk.ns.svc.cluster.local:2181"}))
+
+		lookupSRV = func(name string) ([]*net.SRV, error) {
+			return nil, nil
+		}
+		_, err = resolveZKServers(servers)
+		Ω(err).ShouldNot(BeNil())
+
+		lookupSRV = func(name string) ([]*net.SRV, error) {
+			return nil, errors.New("no such host")
+		}
+		_, err = resolveZKServers(servers)
+		Ω(err).ShouldNot(BeNil())
+
+		resolved, err = resolveZKServers([]string{"zk1:2181"})
+		Ω(err).Should(BeNil())
+		Ω(resolved).Should(Equal([]string{"zk1:2181"}))
+	})
+
+	ginkgo.It("requests the session timeout separately from the connect timeout", func() {
+		connector := &fakeConnector{zkc: newFakeZK()}
+		conn, _, err := initZKConnection(context.Background(), common.ZKConfig{
+			Server:                "zk1:2181",
+			SessionTimeoutSeconds: 30,
+			ConnectTimeoutSeconds: 2,
+		}, connector.connect)
+		Ω(err).Should(BeNil())
+		conn.Close()
+		Ω(connector.sessionTimeout).Should(Equal(30 * time.Second))
+		Ω(connector.connectTimeout).Should(Equal(2 * time.Second))
+	})
+})
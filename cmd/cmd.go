@@ -16,12 +16,16 @@ package cmd
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/pprof"
 	"path/filepath"
+	"time"
 	"unsafe"
 
 	"github.com/uber/aresdb/api"
+	"github.com/uber/aresdb/api/rpc"
+	"github.com/uber/aresdb/cluster"
 	"github.com/uber/aresdb/common"
 	"github.com/uber/aresdb/diskstore"
 	"github.com/uber/aresdb/memstore"
@@ -30,9 +34,18 @@ import (
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+	"github.com/opentracing/opentracing-go"
 	"github.com/spf13/cobra"
 	"github.com/uber/aresdb/clients"
 	"github.com/uber/aresdb/memutils"
+	"google.golang.org/grpc"
+)
+
+const (
+	// interval of fetching schema from controller in cluster mode.
+	schemaFetchIntervalInSeconds = 5 * 60
+	// server is not ready if schema was not fetched from controller successfully for longer than this.
+	schemaFetchStaleness = 3 * schemaFetchIntervalInSeconds * time.Second
 )
 
 // Options represents options for executing command
@@ -42,6 +55,8 @@ type Options struct {
 	QueryLogger  common.Logger
 	Metrics      common.Metrics
 	HTTPWrappers []utils.HTTPHandlerWrapper
+	// Tracer traces queries, queries are not traced if nil.
+	Tracer opentracing.Tracer
 }
 
 // Option is for setting option
@@ -54,7 +69,7 @@ func Execute(setters ...Option) {
 	options := &Options{
 		ServerLogger: loggerFactory.GetDefaultLogger(),
 		QueryLogger:  loggerFactory.GetLogger("query"),
-		Metrics:      common.NewNoopMetrics(),
+		Metrics:      common.NewPrometheusMetrics(),
 	}
 
 	for _, setter := range setters {
@@ -73,6 +88,10 @@ func Execute(setters ...Option) {
 				options.ServerLogger.With("err", err.Error()).Fatal("failed to read configs")
 			}
 
+			if options.Tracer != nil {
+				opentracing.SetGlobalTracer(options.Tracer)
+			}
+
 			start(
 				cfg,
 				options.ServerLogger,
@@ -90,6 +109,14 @@ func Execute(setters ...Option) {
 func start(cfg common.AresServerConfig, logger common.Logger, queryLogger common.Logger, metricsCfg common.Metrics, httpWrappers ...utils.HTTPHandlerWrapper) {
 	logger.With("config", cfg).Info("Bootstrapping service")
 
+	// Fail fast on incomplete cluster configs before touching any state.
+	if err := utils.ValidateClusterConfig(cfg); err != nil {
+		logger.Fatal(err)
+	}
+	if err := utils.ValidateDiskStoreConfig(cfg.DiskStore); err != nil {
+		logger.Fatal(err)
+	}
+
 	// Check whether we have a correct device running environment
 	memutils.DeviceFree(unsafe.Pointer(nil), 0)
 
@@ -110,38 +137,42 @@ func start(cfg common.AresServerConfig, logger common.Logger, queryLogger common
 
 	// Create MetaStore.
 	metaStorePath := filepath.Join(cfg.RootPath, "metastore")
-	metaStore, err := metastore.NewDiskMetaStore(metaStorePath)
+	metaStore, err := metastore.NewMetaStore(cfg.MetaStore, metaStorePath)
 	if err != nil {
 		logger.Panic(err)
 	}
 
+	// create health check handler.
+	healthCheckHandler := api.NewHealthCheckHandler()
+
 	// fetch schema from controller and start periodical job
+	var schemaFetchJob *metastore.SchemaFetchJob
+	var membershipManager cluster.MembershipManager
 	if cfg.Cluster.Enable {
-		if cfg.Cluster.ClusterName == "" {
-			logger.Fatal("Missing cluster name")
-		}
 		controllerClientCfg := cfg.Clients.Controller
-		if controllerClientCfg == nil {
-			logger.Fatal("Missing controller client config", err)
-		}
-		if cfg.Cluster.InstanceName != "" {
-			controllerClientCfg.Headers.Add(clients.InstanceNameHeaderKey, cfg.Cluster.InstanceName)
-		}
+		controllerClientCfg.Headers.Add(clients.InstanceNameHeaderKey, cfg.Cluster.InstanceName)
 		controllerClient := clients.NewControllerHTTPClient(controllerClientCfg.Host, controllerClientCfg.Port, controllerClientCfg.Headers)
-		schemaFetchJob := metastore.NewSchemaFetchJob(5*60, metaStore, metastore.NewTableSchameValidator(), controllerClient, cfg.Cluster.ClusterName, "")
-		// immediate initial fetch
-		schemaFetchJob.FetchSchema()
-		go schemaFetchJob.Run()
+		schemaFetchJob = metastore.NewSchemaFetchJob(schemaFetchIntervalInSeconds, metaStore, metastore.NewTableSchameValidator(), controllerClient, cfg.Cluster.ClusterName, "")
+		healthCheckHandler.AddReadinessCheck("schema_fetched", schemaFetchJob.IsReady)
+		healthCheckHandler.AddReadinessCheck("controller_reachable", func() bool {
+			return utils.Now().Sub(schemaFetchJob.LastSuccess()) < schemaFetchStaleness
+		})
+		membershipManager = cluster.NewMembershipManager(cfg, metaStore, schemaFetchJob)
+		if err = membershipManager.Connect(); err != nil {
+			logger.Fatal(err)
+		}
 	}
 
 	// Create DiskStore.
-	diskStore := diskstore.NewLocalDiskStore(cfg.RootPath)
+	memStoreOptions := memstore.NewOptions(cfg)
+	diskStore := diskstore.NewLocalDiskStore(cfg.RootPath, memStoreOptions.RedoLogSync)
+	utils.GetLogger().With("redologSync", memStoreOptions.RedoLogSync).Info("Redo log sync config")
 
 	// Create MemStore.
-	memStore := memstore.NewMemStore(metaStore, diskStore)
+	memStore := memstore.NewMemStore(metaStore, diskStore, memStoreOptions)
 
 	// Read schema.
-	utils.GetLogger().Infof("Reading schema from local MetaStore %s", metaStorePath)
+	utils.GetLogger().Info("Reading schema from MetaStore")
 	err = memStore.FetchSchema()
 	if err != nil {
 		utils.GetLogger().Fatal(err)
@@ -156,14 +187,11 @@ func start(cfg common.AresServerConfig, logger common.Logger, queryLogger common
 	// create query hanlder.
 	queryHandler := api.NewQueryHandler(memStore, cfg.Query)
 
-	// create health check handler.
-	healthCheckHandler := api.NewHealthCheckHandler()
-
 	nodeModulesHandler := http.StripPrefix("/node_modules/", http.FileServer(http.Dir("./api/ui/node_modules/")))
 
 	// Start HTTP server for debugging.
 	go func() {
-		debugHandler := api.NewDebugHandler(memStore, metaStore, queryHandler, healthCheckHandler)
+		debugHandler := api.NewDebugHandler(memStore, metaStore, queryHandler, healthCheckHandler, schemaFetchJob, membershipManager, cfg.Export)
 
 		debugStaticHandler := http.StripPrefix("/static/", utils.NoCache(
 			http.FileServer(http.Dir("./api/ui/debug/"))))
@@ -188,7 +216,9 @@ func start(cfg common.AresServerConfig, logger common.Logger, queryLogger common
 	memStore.InitShards(cfg.SchedulerOff)
 
 	// Start serving.
-	dataHandler := api.NewDataHandler(memStore)
+	dataHandler := api.NewDataHandler(memStore, metaStore, filepath.Join(cfg.RootPath, "ingestion_sessions"),
+		cfg.IngestionSessions)
+	go dataHandler.Run()
 	router := mux.NewRouter()
 
 	httpWrappers = append([]utils.HTTPHandlerWrapper{utils.WithMetricsFunc}, httpWrappers...)
@@ -207,6 +237,12 @@ func start(cfg common.AresServerConfig, logger common.Logger, queryLogger common
 	router.PathPrefix("/node_modules/").Handler(nodeModulesHandler)
 	router.HandleFunc("/health", utils.WithMetricsFunc(healthCheckHandler.HealthCheck))
 	router.HandleFunc("/version", healthCheckHandler.Version)
+	router.HandleFunc("/liveness", healthCheckHandler.Liveness)
+	router.HandleFunc("/readiness", healthCheckHandler.Readiness)
+	// Metrics reporting to prometheus are also scraped from the server.
+	if metricsHandler, ok := metricsCfg.(http.Handler); ok {
+		router.Handle("/metrics", metricsHandler)
+	}
 
 	// Support CORS calls.
 	allowOrigins := handlers.AllowedOrigins([]string{"*"})
@@ -218,7 +254,46 @@ func start(cfg common.AresServerConfig, logger common.Logger, queryLogger common
 	batchStatsReporter := memstore.NewBatchStatsReporter(5*60, memStore, metaStore)
 	go batchStatsReporter.Run()
 
+	scrubber := diskstore.NewScrubber(cfg.RootPath, cfg.DiskStore.Scrubber,
+		memstore.VectorPartyHeader, memstore.CompressedVectorPartyHeader)
+	go scrubber.Run()
+
+	// Start gRPC server for queries.
+	var grpcServer *grpc.Server
+	if cfg.GRPCPort > 0 {
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.GRPCPort))
+		if err != nil {
+			utils.GetLogger().Fatal(err)
+		}
+		grpcServer = grpc.NewServer()
+		rpc.RegisterQueryServiceServer(grpcServer, api.NewQueryServer(queryHandler))
+		go func() {
+			utils.GetLogger().Infof("Starting gRPC server on port %d", cfg.GRPCPort)
+			if err := grpcServer.Serve(listener); err != nil {
+				utils.GetLogger().Fatal(err)
+			}
+		}()
+	}
+
 	utils.GetLogger().Infof("Starting HTTP server on port %d with max connection %d", cfg.Port, cfg.HTTP.MaxConnections)
-	utils.LimitServe(cfg.Port, handlers.CORS(allowOrigins, allowHeaders, allowMethods)(router), cfg.HTTP)
+	var beforeShutdown []func()
+	if membershipManager != nil {
+		// leave the cluster first so that no new query is routed to the instance while draining.
+		beforeShutdown = append(beforeShutdown, func() {
+			membershipManager.BeginDrain(time.Duration(cfg.Cluster.DrainSeconds) * time.Second)
+		})
+	}
+	utils.LimitServe(cfg.Port, handlers.CORS(allowOrigins, allowHeaders, allowMethods)(router), cfg.HTTP, beforeShutdown...)
+	if grpcServer != nil {
+		grpcServer.GracefulStop()
+	}
 	batchStatsReporter.Stop()
+	scrubber.Stop()
+	dataHandler.Stop()
+	if membershipManager != nil {
+		membershipManager.Disconnect()
+	}
+	// no more upsert batches are ingested once the server is shut down.
+	memStore.Close()
+	utils.GetLogger().Info("Redo log files closed, exiting")
 }
@@ -24,6 +24,12 @@ type TimezoneConfig struct {
 	TableName string `yaml:"table_name"`
 }
 
+// Strategies to choose the device of a query.
+const (
+	DeviceChoosingStrategyLeastQueryCount = "least_query_count"
+	DeviceChoosingStrategyRoundRobin      = "round_robin"
+)
+
 // QueryConfig is the static configuration for query.
 type QueryConfig struct {
 	// how much portion of the device memory we are allowed use
@@ -31,11 +37,177 @@ type QueryConfig struct {
 	// timeout in seconds for choosing device
 	DeviceChoosingTimeout int            `yaml:"device_choosing_timeout"`
 	TimezoneTable         TimezoneConfig `yaml:"timezone_table"`
+	// ids of the CUDA devices queries run on, all devices are used if empty
+	Devices []int `yaml:"devices"`
+	// strategy to choose the device of a query among devices with enough free memory, either
+	// least_query_count (default) or round_robin
+	DeviceChoosingStrategy string `yaml:"device_choosing_strategy"`
+	// max device memory in MB a query is estimated to use before it's rejected, 0 means no limit
+	MaxQueryDeviceMemory int `yaml:"max_query_device_memory"`
+	// max duration in seconds of processing a query before it's cancelled, 0 means no limit
+	MaxQueryDuration int `yaml:"max_query_duration"`
+	// max number of records of a dimension table that can be joined in a query, 0 means no limit
+	MaxJoinTableRecords int `yaml:"max_join_table_records"`
+	// max number of rows a query can scan before it's aborted, 0 means no limit
+	MaxRowsScanned int `yaml:"max_rows_scanned"`
+	// max number of groups in the result of a query before it's aborted, 0 means no limit
+	MaxResultRows int `yaml:"max_result_rows"`
+	// limits of queries by tenant, can be changed at runtime through the debug handler
+	TenantLimits TenantLimitsConfig `yaml:"tenant_limits"`
+	// max number of cached results of queries over archived data past retention, 0 disables the cache
+	ResultCacheSize int `yaml:"result_cache_size"`
+	// seconds before a cached query result expires
+	ResultCacheTTL int `yaml:"result_cache_ttl"`
+	// seconds the cursor of a page of a paginated query can be used to fetch the next page,
+	// 10 minutes if 0
+	CursorTTL int `yaml:"cursor_ttl"`
+	// key signing the cursors of paginated queries so clients can not forge them, a random key
+	// is generated at startup if empty, in which case cursors are only valid on the same server
+	// until it restarts.
+	CursorKey string `yaml:"cursor_key"`
+	// max number of prepared queries kept, the least recently used ones are evicted beyond it,
+	// 1000 if 0. Prepared queries are kept in memory only and lost on restart.
+	MaxPreparedQueries int `yaml:"max_prepared_queries"`
+	// milliseconds a query takes before it's logged as a slow query, 0 disables the slow query log,
+	// can be changed at runtime through the debug handler. Stage timings are only logged for profiled queries.
+	SlowQueryThreshold int `yaml:"slow_query_threshold"`
+	// min and default milliseconds between two pushes of the result of a query subscription,
+	// 1 second if 0
+	SubscriptionPushInterval int `yaml:"subscription_push_interval"`
+	// max number of messages buffered for a query subscriber before it's disconnected as a slow
+	// consumer, 16 if 0
+	SubscriptionBufferSize int `yaml:"subscription_buffer_size"`
+}
+
+// TenantLimitsConfig is the configuration of per tenant query limits.
+type TenantLimitsConfig struct {
+	// header identifying the tenant of a query, the RPC-Caller header is used if empty
+	Header string `yaml:"header" json:"header"`
+	// CIDRs of the hops trusted to set the tenant header, e.g. the local sidecar or the gateway
+	// authenticating the clients, loopback addresses if empty. Requests from other hops are of the
+	// UNKNOWN tenant.
+	TrustedProxies []string `yaml:"trusted_proxies" json:"trustedProxies,omitempty"`
+	// limit shared by all tenants not listed in Tenants, which are reported as tenant "other"
+	Default TenantLimit `yaml:"default" json:"default"`
+	// limits by tenant
+	Tenants map[string]TenantLimit `yaml:"tenants" json:"tenants"`
+}
+
+// TenantLimit limits the queries of a tenant, 0 means no limit.
+type TenantLimit struct {
+	// max number of queries of the tenant being processed at the same time
+	MaxConcurrentQueries int `yaml:"max_concurrent_queries" json:"maxConcurrentQueries"`
+	// max number of queries per second of the tenant
+	QPS float64 `yaml:"qps" json:"qps"`
+	// max number of queries the tenant can issue at once above QPS, defaults to QPS rounded up
+	Burst int `yaml:"burst" json:"burst"`
+	// max number of rows a query of the tenant can scan, overrides the query config if set
+	MaxRowsScanned int `yaml:"max_rows_scanned" json:"maxRowsScanned,omitempty"`
+	// max number of groups in the result of a query of the tenant, overrides the query config if set
+	MaxResultRows int `yaml:"max_result_rows" json:"maxResultRows,omitempty"`
+	// max number of query subscriptions of the tenant open at the same time
+	MaxSubscriptions int `yaml:"max_subscriptions" json:"maxSubscriptions,omitempty"`
 }
 
 // DiskStoreConfig is the static configuration for disk store.
 type DiskStoreConfig struct {
 	WriteSync bool `yaml:"write_sync"`
+	// fsync policy of redo log files
+	RedoLogSync RedoLogSyncConfig `yaml:"redolog_sync"`
+	// background verification of vector party files
+	Scrubber ScrubberConfig `yaml:"scrubber"`
+}
+
+// RedoLogSyncPolicy decides when records appended to redo log files are fsynced to disk.
+type RedoLogSyncPolicy string
+
+const (
+	// RedoLogSyncPerRecord writes redo log files with O_SYNC, records acknowledged to ingestion
+	// requests are never lost.
+	RedoLogSyncPerRecord RedoLogSyncPolicy = "sync"
+	// RedoLogSyncBatched fsyncs redo log files every batch_records records or batch_interval_ms
+	// milliseconds, records acknowledged since the last fsync can be lost on machine crashes.
+	RedoLogSyncBatched RedoLogSyncPolicy = "batched"
+	// RedoLogSyncAsync leaves redo log files in the OS page cache until the OS writes them back,
+	// records can be lost on machine crashes but not on process crashes.
+	RedoLogSyncAsync RedoLogSyncPolicy = "async"
+)
+
+// RedoLogSyncConfig is the configuration of fsync of redo log files.
+type RedoLogSyncConfig struct {
+	// sync, batched or async, defaults to sync if write_sync is true and to async otherwise
+	Policy RedoLogSyncPolicy `yaml:"policy" json:"policy"`
+	// fsync after this many records under batched policy, 0 means no limit of records
+	BatchRecords int `yaml:"batch_records" json:"batchRecords,omitempty"`
+	// fsync within this many milliseconds after a record is appended under batched policy,
+	// 0 means no limit of time
+	BatchIntervalMs int `yaml:"batch_interval_ms" json:"batchIntervalMs,omitempty"`
+}
+
+// ScrubberConfig is the configuration of the background verification of vector party files.
+type ScrubberConfig struct {
+	// whether to verify vector party files in the background
+	Enable bool `yaml:"enable"`
+	// seconds between the starts of two scans of all files
+	IntervalInSeconds int `yaml:"interval_in_seconds"`
+	// bytes read per second while scanning, 0 means no throttling
+	MaxBytesPerSecond int64 `yaml:"max_bytes_per_second"`
+	// whether to move corrupt files under {root_path}/quarantine, otherwise they are only reported
+	Quarantine bool `yaml:"quarantine"`
+}
+
+// ExportConfig restricts where table data is exported to by the debug export endpoint.
+type ExportConfig struct {
+	// local directory exports are written under, local output paths are relative to it,
+	// local exports are disabled if empty
+	BaseDir string `yaml:"base_dir"`
+	// url prefixes exports can be uploaded to, e.g. https://bucket.s3.amazonaws.com/aresdb/
+	URLPrefixes []string `yaml:"url_prefixes"`
+	// timeout in seconds of each upload request, defaults to 60
+	UploadTimeoutInSeconds int `yaml:"upload_timeout_in_seconds"`
+}
+
+// IngestionSessionsConfig bounds the chunked ingestion sessions of the data API.
+type IngestionSessionsConfig struct {
+	// max bytes of a chunk, defaults to 64MB
+	MaxChunkBytes int64 `yaml:"max_chunk_bytes"`
+	// max total bytes of the chunks of a session, defaults to 1GB
+	MaxSessionBytes int64 `yaml:"max_session_bytes"`
+	// max number of sessions not expired yet, defaults to 100
+	MaxSessions int `yaml:"max_sessions"`
+	// seconds a session is kept after it was last used, defaults to 3600
+	TTLInSeconds int `yaml:"ttl_in_seconds"`
+}
+
+// Backends of the metastore.
+const (
+	MetaStoreBackendDisk = "disk"
+	MetaStoreBackendEtcd = "etcd"
+)
+
+// MetaStoreConfig is the static configuration for metastore.
+type MetaStoreConfig struct {
+	// backend storing the metastore, disk (default) or etcd
+	Backend string     `yaml:"backend"`
+	Etcd    EtcdConfig `yaml:"etcd"`
+}
+
+// EtcdConfig is the configuration of the etcd metastore backend.
+type EtcdConfig struct {
+	// addresses of the etcd v3 JSON gateway, e.g. http://localhost:2379
+	Endpoints []string `yaml:"endpoints"`
+	// key prefix of the metastore, defaults to /aresdb/metastore
+	Prefix string `yaml:"prefix"`
+	// timeout in seconds of etcd requests, defaults to 5
+	TimeoutInSeconds int `yaml:"timeout_in_seconds"`
+	// CA file to verify https endpoints with, the system CAs are used if empty
+	CAFile string `yaml:"ca_file"`
+	// client certificate and key files for https endpoints requiring client auth
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// user and password to authenticate with if auth is enabled in etcd
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
 }
 
 // HTTPConfig is the static configuration for main http server (query and schema).
@@ -52,9 +224,37 @@ type ControllerConfig struct {
 	Headers http.Header `yaml:"headers"`
 }
 
+// ZKConfig is the config for the ZooKeeper client registering the instance in the cluster.
+type ZKConfig struct {
+	// comma separated host:port of the ZooKeeper servers
+	Server string `yaml:"server"`
+	// seconds without heartbeat after which the ensemble expires the session, removing the instance node
+	SessionTimeoutSeconds int `yaml:"session_timeout_seconds"`
+	// seconds to dial each server on each connect attempt
+	ConnectTimeoutSeconds int `yaml:"connect_timeout_seconds"`
+	// tick time of the ensemble, which negotiates session timeouts from 2 to 20 ticks, 2000 if 0
+	ServerTickTimeMillis int `yaml:"server_tick_time_millis"`
+	// milliseconds to wait before retrying to connect, doubled after each failed attempt, 500 if 0
+	RetryInitialIntervalMillis int `yaml:"retry_initial_interval_millis"`
+	// max milliseconds to wait between two connect attempts, 10000 if 0
+	RetryMaxIntervalMillis int `yaml:"retry_max_interval_millis"`
+	// seconds since the first attempt after which connecting fails, 60 if 0
+	RetryMaxElapsedSeconds int `yaml:"retry_max_elapsed_seconds"`
+	// seconds to wait for the instance node of another live session to expire, session_timeout_seconds if 0
+	RegisterWaitSeconds int `yaml:"register_wait_seconds"`
+	// scheme of the credentials to authenticate with, only digest is supported, no authentication if empty
+	AuthScheme string `yaml:"auth_scheme"`
+	Username   string `yaml:"username"`
+	Password   string `yaml:"password"`
+	// scheme of the ACL of created nodes, world or digest, digest if authenticated and world otherwise if empty
+	ACLScheme string `yaml:"acl_scheme"`
+}
+
 // ClientsConfig is the config for all clients
 type ClientsConfig struct {
 	Controller *ControllerConfig `yaml:"controller,omitempty"`
+	// instances register in ZooKeeper in cluster mode if set
+	ZK *ZKConfig `yaml:"zk,omitempty"`
 }
 
 // ClusterConfig is the config for starting current instance with cluster mode
@@ -66,6 +266,25 @@ type ClusterConfig struct {
 	// InstanceName is the cluster wide unique name to identify current instance
 	// it can be static configured in yaml, or dynamically set on start up
 	InstanceName string `yaml:"instance_name"`
+	// ZKRoot is the root znode of the clusters in ZooKeeper, /ares_controller if empty
+	ZKRoot string `yaml:"zk_root"`
+	// Shards are the shards served by the instance, advertised in its instance node
+	Shards []uint32 `yaml:"shards"`
+	// Zone is the availability zone of the instance, advertised in its instance node
+	Zone string `yaml:"zone"`
+	// AdvertiseHost is the host advertised in the instance node, used verbatim if set, the first
+	// non loopback IP of the instance if auto, the host name if empty
+	AdvertiseHost string `yaml:"advertise_host"`
+	// DrainSeconds is how long the instance keeps serving in-flight queries on shutdown after
+	// leaving the cluster
+	DrainSeconds int `yaml:"drain_seconds"`
+	// ReadOnly instances fetch schemas from the cluster but never register an instance node, so
+	// they are not served any query by the cluster, e.g. analytics sidecars
+	ReadOnly bool `yaml:"read_only"`
+	// SchemaFetchMode is poll or watch, poll if empty. In watch mode, table schemas changed in
+	// ZooKeeper are applied once notified, and all schemas are still fetched from controller
+	// periodically to reconcile. Watch mode requires clients.zk.
+	SchemaFetchMode string `yaml:"schema_fetch_mode"`
 }
 
 // AresServerConfig is config specific for ares server.
@@ -76,12 +295,41 @@ type AresServerConfig struct {
 	// HTTP port for debugging.
 	DebugPort int `yaml:"debug_port"`
 
+	// gRPC port for serving queries, the gRPC server is not started if 0.
+	GRPCPort int `yaml:"grpc_port"`
+
 	// Directory path that stores the data and schema on local disk.
 	RootPath string `yaml:"root_path"`
 
 	// Total memory size ares can use.
 	TotalMemorySize int64 `yaml:"total_memory_size"`
 
+	// Default size limit in bytes of the live store of a fact table shard, archiving is
+	// triggered early when exceeded. 0 means unlimited. Can be overridden per table.
+	LiveStoreMemoryLimit int64 `yaml:"live_store_memory_limit"`
+
+	// Max number of upsert batches of a table shard waiting to be written into the redo log,
+	// ingestion requests are rejected when exceeded. 1000 if 0.
+	MaxPendingUpsertBatches int `yaml:"max_pending_upsert_batches"`
+
+	// Default max number of distinct primary keys in the live store of a table shard, rows
+	// adding keys beyond it are dropped. 0 means unlimited. Can be overridden per table.
+	MaxPrimaryKeys int `yaml:"max_primary_keys"`
+
+	// Max number of idempotency keys of recently applied upsert batches remembered per table
+	// shard, upsert batches with a remembered key are acknowledged without being applied again.
+	// 0 disables deduplication.
+	MaxIngestionKeys int `yaml:"max_ingestion_keys"`
+
+	// Seconds to remember the idempotency key of an applied upsert batch. Keys are replayed from
+	// redo logs on restart, keys of batches in purged redo logs or recovered from a snapshot are
+	// forgotten.
+	IngestionKeyTTL int64 `yaml:"ingestion_key_ttl"`
+
+	// Max seconds a maintenance set through the schema API freezes ingestion before it expires,
+	// also the duration of maintenances set without one. 0 means unlimited.
+	MaxMaintenanceDuration int64 `yaml:"max_maintenance_duration"`
+
 	// Whether to turn off scheduler.
 	SchedulerOff bool `yaml:"scheduler_off"`
 
@@ -93,7 +341,11 @@ type AresServerConfig struct {
 
 	Query     QueryConfig     `yaml:"query"`
 	DiskStore DiskStoreConfig `yaml:"disk_store"`
+	MetaStore MetaStoreConfig `yaml:"meta_store"`
 	HTTP      HTTPConfig      `yaml:"http"`
 	Cluster   ClusterConfig   `yaml:"cluster"`
 	Clients   ClientsConfig   `yaml:"clients"`
+	Export    ExportConfig    `yaml:"export"`
+
+	IngestionSessions IngestionSessionsConfig `yaml:"ingestion_sessions"`
 }
@@ -0,0 +1,384 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uber-go/tally"
+)
+
+const (
+	// interval of reporting counters and gauges from tally scopes to the prometheus reporter.
+	prometheusReportInterval = time.Second
+	// ContentTypePrometheus is the content type of the prometheus text exposition format.
+	ContentTypePrometheus = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// prometheusLabels are the tags exported as prometheus labels. Other tags like batch ids and
+// request origins are dropped and their metrics merged to keep the number of time series bounded.
+var prometheusLabels = map[string]bool{
+	"component":   true,
+	"operation":   true,
+	"handler":     true,
+	"status_code": true,
+	"table":       true,
+	"shard":       true,
+	"store":       true,
+	"stage":       true,
+	"device":      true,
+	"tenant":      true,
+	"columnName":  true,
+	"columnID":    true,
+}
+
+// prometheusBoundedLabels are the labels whose values come from requests, with the max number of
+// distinct values exported for each. Values seen after the max is reached are exported as
+// prometheusOtherLabelValue.
+var prometheusBoundedLabels = map[string]int{
+	"tenant": 100,
+}
+
+// prometheusOtherLabelValue replaces the values of bounded labels beyond their max.
+const prometheusOtherLabelValue = "other"
+
+// Prometheus metric types.
+const (
+	prometheusCounter   = "counter"
+	prometheusGauge     = "gauge"
+	prometheusSummary   = "summary"
+	prometheusHistogram = "histogram"
+)
+
+// prometheusSeries is a time series of a metric identified by its labels.
+type prometheusSeries struct {
+	labels string
+	// total of counters, value of gauges and sum in seconds of timers.
+	value float64
+	// number of samples of timers and histograms.
+	count int64
+	// number of histogram samples by bucket upper bound.
+	buckets map[float64]int64
+}
+
+type prometheusMetric struct {
+	metricType string
+	series     map[string]*prometheusSeries
+}
+
+// PrometheusReporter is a tally.StatsReporter accumulating the reported metrics and serving them
+// in the Prometheus text exposition format.
+type PrometheusReporter struct {
+	sync.RWMutex
+	metrics map[string]*prometheusMetric
+	// values exported of each bounded label.
+	labelValues map[string]map[string]bool
+}
+
+// NewPrometheusReporter returns a new PrometheusReporter.
+func NewPrometheusReporter() *PrometheusReporter {
+	return &PrometheusReporter{
+		metrics:     make(map[string]*prometheusMetric),
+		labelValues: make(map[string]map[string]bool),
+	}
+}
+
+// NewPrometheusMetrics returns a Metrics reporting to a PrometheusReporter, the returned
+// Metrics is also a http.Handler serving the reported metrics.
+func NewPrometheusMetrics() Metrics {
+	return prometheusMetrics{NewPrometheusReporter()}
+}
+
+type prometheusMetrics struct {
+	*PrometheusReporter
+}
+
+// NewRootScope returns a root scope reporting to the prometheus reporter.
+func (m prometheusMetrics) NewRootScope() (tally.Scope, io.Closer, error) {
+	scope, closer := tally.NewRootScope(tally.ScopeOptions{
+		Reporter:  m.PrometheusReporter,
+		Separator: "_",
+	}, prometheusReportInterval)
+	return scope, closer, nil
+}
+
+// getSeries returns the series of the metric with the given name and tags, the caller must hold
+// the writer lock. Nil is returned if the metric is already reported with another type.
+func (r *PrometheusReporter) getSeries(name string, tags map[string]string, metricType string) *prometheusSeries {
+	name = sanitizePrometheusName(name)
+	metric := r.metrics[name]
+	if metric == nil {
+		metric = &prometheusMetric{
+			metricType: metricType,
+			series:     make(map[string]*prometheusSeries),
+		}
+		r.metrics[name] = metric
+	} else if metric.metricType != metricType {
+		return nil
+	}
+
+	labels := formatPrometheusLabels(r.boundLabelValues(tags))
+	series := metric.series[labels]
+	if series == nil {
+		series = &prometheusSeries{labels: labels}
+		metric.series[labels] = series
+	}
+	return series
+}
+
+// ReportCounter reports a counter value.
+func (r *PrometheusReporter) ReportCounter(name string, tags map[string]string, value int64) {
+	r.Lock()
+	defer r.Unlock()
+	if series := r.getSeries(name, tags, prometheusCounter); series != nil {
+		// tally reports the deltas since the last report.
+		series.value += float64(value)
+	}
+}
+
+// ReportGauge reports a gauge value.
+func (r *PrometheusReporter) ReportGauge(name string, tags map[string]string, value float64) {
+	r.Lock()
+	defer r.Unlock()
+	if series := r.getSeries(name, tags, prometheusGauge); series != nil {
+		series.value = value
+	}
+}
+
+// ReportTimer reports a timer value.
+func (r *PrometheusReporter) ReportTimer(name string, tags map[string]string, interval time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+	if series := r.getSeries(name, tags, prometheusSummary); series != nil {
+		series.value += interval.Seconds()
+		series.count++
+	}
+}
+
+// ReportHistogramValueSamples reports histogram samples for a bucket.
+func (r *PrometheusReporter) ReportHistogramValueSamples(name string, tags map[string]string,
+	buckets tally.Buckets, bucketLowerBound, bucketUpperBound float64, samples int64) {
+	r.reportHistogramSamples(name, tags, bucketUpperBound, samples)
+}
+
+// ReportHistogramDurationSamples reports histogram samples for a bucket.
+func (r *PrometheusReporter) ReportHistogramDurationSamples(name string, tags map[string]string,
+	buckets tally.Buckets, bucketLowerBound, bucketUpperBound time.Duration, samples int64) {
+	upperBound := bucketUpperBound.Seconds()
+	if bucketUpperBound == time.Duration(math.MaxInt64) {
+		upperBound = math.Inf(1)
+	}
+	r.reportHistogramSamples(name, tags, upperBound, samples)
+}
+
+func (r *PrometheusReporter) reportHistogramSamples(name string, tags map[string]string, upperBound float64, samples int64) {
+	if upperBound >= math.MaxFloat64 {
+		upperBound = math.Inf(1)
+	}
+	r.Lock()
+	defer r.Unlock()
+	if series := r.getSeries(name, tags, prometheusHistogram); series != nil {
+		if series.buckets == nil {
+			series.buckets = make(map[float64]int64)
+		}
+		series.buckets[upperBound] += samples
+		series.count += samples
+	}
+}
+
+// Capabilities returns the capabilities description of the reporter.
+func (r *PrometheusReporter) Capabilities() tally.Capabilities {
+	return r
+}
+
+// Reporting returns whether the reporter has the ability to actively report.
+func (r *PrometheusReporter) Reporting() bool {
+	return true
+}
+
+// Tagging returns whether the reporter has the capability for tagged metrics.
+func (r *PrometheusReporter) Tagging() bool {
+	return true
+}
+
+// Flush does nothing as metrics are served on scraping.
+func (r *PrometheusReporter) Flush() {}
+
+// ServeHTTP writes all reported metrics in the prometheus text exposition format.
+func (r *PrometheusReporter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ContentTypePrometheus)
+	w.WriteHeader(http.StatusOK)
+	r.WriteTo(w)
+}
+
+// WriteTo writes all reported metrics in the prometheus text exposition format, sorted by metric
+// names and labels.
+func (r *PrometheusReporter) WriteTo(w io.Writer) (int64, error) {
+	r.RLock()
+	defer r.RUnlock()
+
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buffer bytes.Buffer
+	for _, name := range names {
+		metric := r.metrics[name]
+		fmt.Fprintf(&buffer, "# TYPE %s %s\n", name, metric.metricType)
+
+		labels := make([]string, 0, len(metric.series))
+		for label := range metric.series {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+
+		for _, label := range labels {
+			series := metric.series[label]
+			switch metric.metricType {
+			case prometheusCounter, prometheusGauge:
+				writePrometheusSample(&buffer, name, series.labels, "", series.value)
+			case prometheusSummary:
+				writePrometheusSample(&buffer, name+"_sum", series.labels, "", series.value)
+				writePrometheusSample(&buffer, name+"_count", series.labels, "", float64(series.count))
+			case prometheusHistogram:
+				upperBounds := make([]float64, 0, len(series.buckets))
+				for upperBound := range series.buckets {
+					upperBounds = append(upperBounds, upperBound)
+				}
+				sort.Float64s(upperBounds)
+				// prometheus buckets are cumulative and always end with +Inf.
+				var cumulative int64
+				for _, upperBound := range upperBounds {
+					if math.IsInf(upperBound, 1) {
+						continue
+					}
+					cumulative += series.buckets[upperBound]
+					writePrometheusSample(&buffer, name+"_bucket", series.labels,
+						formatPrometheusValue(upperBound), float64(cumulative))
+				}
+				writePrometheusSample(&buffer, name+"_bucket", series.labels, "+Inf", float64(series.count))
+				writePrometheusSample(&buffer, name+"_count", series.labels, "", float64(series.count))
+			}
+		}
+	}
+	return buffer.WriteTo(w)
+}
+
+func writePrometheusSample(w io.Writer, name, labels, le string, value float64) {
+	if le != "" {
+		leLabel := fmt.Sprintf("le=%q", le)
+		if labels == "" {
+			labels = leLabel
+		} else {
+			labels += "," + leLabel
+		}
+	}
+	if labels != "" {
+		fmt.Fprintf(w, "%s{%s} %s\n", name, labels, formatPrometheusValue(value))
+	} else {
+		fmt.Fprintf(w, "%s %s\n", name, formatPrometheusValue(value))
+	}
+}
+
+func formatPrometheusValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// boundLabelValues replaces the values of bounded labels beyond their max, the caller must hold the
+// writer lock.
+func (r *PrometheusReporter) boundLabelValues(tags map[string]string) map[string]string {
+	bounded, copied := tags, false
+	for name, maxValues := range prometheusBoundedLabels {
+		value, ok := tags[name]
+		if !ok {
+			continue
+		}
+		values := r.labelValues[name]
+		if values == nil {
+			values = make(map[string]bool)
+			r.labelValues[name] = values
+		}
+		if values[value] {
+			continue
+		}
+		if len(values) < maxValues {
+			values[value] = true
+			continue
+		}
+		if !copied {
+			bounded = make(map[string]string, len(tags))
+			for k, v := range tags {
+				bounded[k] = v
+			}
+			copied = true
+		}
+		bounded[name] = prometheusOtherLabelValue
+	}
+	return bounded
+}
+
+// formatPrometheusLabels formats the allowed tags as labels sorted by names.
+func formatPrometheusLabels(tags map[string]string) string {
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		if prometheusLabels[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	labels := make([]string, len(names))
+	for i, name := range names {
+		labels[i] = fmt.Sprintf("%s=\"%s\"", sanitizePrometheusName(name), escapePrometheusLabelValue(tags[name]))
+	}
+	return strings.Join(labels, ",")
+}
+
+var prometheusLabelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapePrometheusLabelValue(value string) string {
+	return prometheusLabelValueEscaper.Replace(value)
+}
+
+// sanitizePrometheusName replaces characters not allowed in prometheus metric and label names
+// with underscores.
+func sanitizePrometheusName(name string) string {
+	sanitized := []byte(name)
+	for i, c := range sanitized {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			sanitized[i] = '_'
+		}
+	}
+	return string(sanitized)
+}
@@ -0,0 +1,120 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber-go/tally"
+)
+
+var _ = ginkgo.Describe("prometheus metrics", func() {
+	sampleLine := regexp.MustCompile(`^([a-zA-Z_][a-zA-Z0-9_]*)(\{(?:[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\]|\\.)*",?)*\})? (\S+)$`)
+	typeLine := regexp.MustCompile(`^# TYPE [a-zA-Z_][a-zA-Z0-9_]* (counter|gauge|summary|histogram)$`)
+
+	// scrape returns the samples served by the handler by name and labels.
+	scrape := func(handler http.Handler) map[string]float64 {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		Ω(recorder.Code).Should(Equal(http.StatusOK))
+		Ω(recorder.Header().Get("Content-Type")).Should(Equal(ContentTypePrometheus))
+
+		samples := make(map[string]float64)
+		for _, line := range strings.Split(strings.TrimSpace(recorder.Body.String()), "\n") {
+			if strings.HasPrefix(line, "#") {
+				Ω(line).Should(MatchRegexp(typeLine.String()))
+				continue
+			}
+			match := sampleLine.FindStringSubmatch(line)
+			Ω(match).ShouldNot(BeNil(), line)
+			value, err := strconv.ParseFloat(match[3], 64)
+			Ω(err).Should(BeNil())
+			samples[match[1]+match[2]] = value
+		}
+		return samples
+	}
+
+	ginkgo.It("serves reported metrics in text exposition format", func() {
+		metrics := NewPrometheusMetrics()
+		scope, closer, err := metrics.NewRootScope()
+		Ω(err).Should(BeNil())
+
+		tableScope := scope.Tagged(map[string]string{"table": "trips", "shard": "0"})
+		tableScope.Counter("ingested_records").Inc(3)
+		// batch ids are dropped from the labels.
+		tableScope.Tagged(map[string]string{"batch": "1"}).Counter("ingested_records").Inc(2)
+		tableScope.Tagged(map[string]string{"batch": "2"}).Counter("ingested_records").Inc(1)
+		tableScope.Gauge("live_store_memory_bytes").Update(1024)
+		scope.Tagged(map[string]string{"handler": `say "hi"`}).Timer("query_latency").Record(1500 * time.Millisecond)
+		scope.Tagged(map[string]string{"handler": `say "hi"`}).Timer("query_latency").Record(500 * time.Millisecond)
+		histogram := scope.Histogram("query_rows", tally.ValueBuckets{10, 100})
+		histogram.RecordValue(5)
+		histogram.RecordValue(50)
+		histogram.RecordValue(500)
+		// flushes counters, gauges and histograms to the reporter.
+		Ω(closer.Close()).Should(BeNil())
+
+		samples := scrape(metrics.(http.Handler))
+		Ω(samples).Should(Equal(map[string]float64{
+			`ingested_records{shard="0",table="trips"}`:        6,
+			`live_store_memory_bytes{shard="0",table="trips"}`: 1024,
+			`query_latency_sum{handler="say \"hi\""}`:          2,
+			`query_latency_count{handler="say \"hi\""}`:        2,
+			`query_rows_bucket{le="10"}`:                       1,
+			`query_rows_bucket{le="100"}`:                      2,
+			`query_rows_bucket{le="+Inf"}`:                     3,
+			`query_rows_count`:                                 3,
+		}))
+	})
+
+	ginkgo.It("accumulates counters across reports", func() {
+		reporter := NewPrometheusReporter()
+		reporter.ReportCounter("schema_fetch_attempts", nil, 1)
+		reporter.ReportCounter("schema_fetch_attempts", nil, 2)
+		// metrics reported with another type are ignored.
+		reporter.ReportGauge("schema_fetch_attempts", nil, 10)
+		reporter.ReportGauge("estimated-device.memory", map[string]string{"device": "0"}, 10)
+		reporter.ReportGauge("estimated-device.memory", map[string]string{"device": "0"}, 20)
+		Ω(scrape(reporter)).Should(Equal(map[string]float64{
+			`schema_fetch_attempts`:               3,
+			`estimated_device_memory{device="0"}`: 20,
+		}))
+	})
+
+	ginkgo.It("bounds the values of labels from requests", func() {
+		reporter := NewPrometheusReporter()
+		maxTenants := prometheusBoundedLabels["tenant"]
+		for i := 0; i < maxTenants+10; i++ {
+			reporter.ReportCounter("queries", map[string]string{"tenant": fmt.Sprintf("tenant%d", i)}, 1)
+		}
+		// tenants exported before the max is reached keep their own series.
+		tags := map[string]string{"tenant": "tenant0"}
+		reporter.ReportCounter("queries", tags, 1)
+		Ω(tags["tenant"]).Should(Equal("tenant0"))
+
+		samples := scrape(reporter)
+		Ω(samples).Should(HaveLen(maxTenants + 1))
+		Ω(samples[`queries{tenant="tenant0"}`]).Should(BeEquivalentTo(2))
+		Ω(samples[`queries{tenant="other"}`]).Should(BeEquivalentTo(10))
+	})
+})
@@ -0,0 +1,84 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diskstore
+
+import (
+	"bytes"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/uber/aresdb/utils"
+)
+
+// ChecksumFooterMagic is the magic number of the checksum footer appended to the end of each vector
+// party file, followed by the crc32 (IEEE) checksum of all bytes before the footer.
+const ChecksumFooterMagic uint32 = 0xFADEC0DE
+
+// checksumFooterSize is the size in bytes of the checksum footer.
+const checksumFooterSize = 8
+
+// checksumWriteCloser computes the checksum of all bytes written into the underlying file and
+// appends the checksum footer on close. Readers of vector parties only read the bytes they need,
+// so the footer is transparent to them.
+type checksumWriteCloser struct {
+	file io.WriteCloser
+	hash hash.Hash32
+}
+
+func newChecksumWriteCloser(file io.WriteCloser) io.WriteCloser {
+	return &checksumWriteCloser{
+		file: file,
+		hash: crc32.NewIEEE(),
+	}
+}
+
+// Write writes p into the underlying file and adds the bytes written into the checksum.
+func (w *checksumWriteCloser) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.hash.Write(p[:n])
+	return n, err
+}
+
+// TrimChecksumFooter returns the content of the vector party file data without its checksum footer,
+// failing if the checksum does not match. Files written before checksums were added have no footer
+// and are returned as is.
+func TrimChecksumFooter(data []byte) ([]byte, error) {
+	if len(data) < checksumFooterSize {
+		return data, nil
+	}
+	content := data[:len(data)-checksumFooterSize]
+	footerReader := utils.NewStreamDataReader(bytes.NewReader(data[len(content):]))
+	if magic, _ := footerReader.ReadUint32(); magic != ChecksumFooterMagic {
+		return data, nil
+	}
+	if expected, _ := footerReader.ReadUint32(); expected != crc32.ChecksumIEEE(content) {
+		return nil, utils.StackError(nil, "Checksum mismatch")
+	}
+	return content, nil
+}
+
+// Close appends the checksum footer and closes the underlying file.
+func (w *checksumWriteCloser) Close() error {
+	writer := utils.NewStreamDataWriter(w.file)
+	err := writer.WriteUint32(ChecksumFooterMagic)
+	if err == nil {
+		err = writer.WriteUint32(w.hash.Sum32())
+	}
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
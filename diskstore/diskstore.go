@@ -26,6 +26,8 @@ type DiskStore interface {
 
 	// Completely wipe out a table shard.
 	DeleteTableShard(table string, shard int) error
+	// Returns the total bytes of redo logs, snapshot files and archived vector party files of a table shard.
+	GetTableShardDiskUsage(table string, shard int) (int64, error)
 
 	// Redo logs.
 
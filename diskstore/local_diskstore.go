@@ -33,13 +33,16 @@ import (
 type LocalDiskStore struct {
 	rootPath        string
 	diskStoreConfig common.DiskStoreConfig
+	// fsync policy of redo log files, shared with the redo log managers of the memstore.
+	redoLogSync common.RedoLogSyncConfig
 }
 
-// NewLocalDiskStore is used to init a LocalDiskStore with rootPath.
-func NewLocalDiskStore(rootPath string) DiskStore {
+// NewLocalDiskStore is used to init a LocalDiskStore with rootPath and the fsync policy of redo log files.
+func NewLocalDiskStore(rootPath string, redoLogSync common.RedoLogSyncConfig) DiskStore {
 	return LocalDiskStore{
 		rootPath:        rootPath,
 		diskStoreConfig: utils.GetConfig().DiskStore,
+		redoLogSync:     redoLogSync,
 	}
 }
 
@@ -53,6 +56,29 @@ func (l LocalDiskStore) DeleteTableShard(table string, shard int) error {
 	return os.RemoveAll(tableRedologDir)
 }
 
+// GetTableShardDiskUsage : Returns the total bytes of all files of a table shard.
+func (l LocalDiskStore) GetTableShardDiskUsage(table string, shard int) (int64, error) {
+	var bytes int64
+	tableShardDir := getPathForTableShard(l.rootPath, table, shard)
+	err := filepath.Walk(tableShardDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Files can be deleted by archiving, snapshot and purge jobs during the walk.
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			bytes += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, utils.StackError(err, "Failed to get disk usage of table shard dir: %s", tableShardDir)
+	}
+	return bytes, nil
+}
+
 // Redo Logs
 
 // ListLogFiles : Returns the file creation unix time in second for each log file as a sorted slice.
@@ -103,7 +129,8 @@ func (l LocalDiskStore) OpenLogFileForAppend(table string, shard int, creationTi
 	}
 	logFilePath := GetPathForRedologFile(l.rootPath, table, shard, creationTime)
 	mode := os.O_APPEND | os.O_CREATE | os.O_WRONLY
-	if l.diskStoreConfig.WriteSync {
+	// redo log files of other policies are fsynced by the redo log manager or the OS.
+	if l.redoLogSync.Policy == common.RedoLogSyncPerRecord {
 		mode |= os.O_SYNC
 	}
 	f, err := os.OpenFile(logFilePath, mode, 0644)
@@ -226,7 +253,7 @@ func (l LocalDiskStore) OpenSnapshotVectorPartyFileForWrite(table string, shard
 	if err != nil {
 		return nil, utils.StackError(err, "Failed to open snapshot file: %s for write", snapshotFilePath)
 	}
-	return f, nil
+	return newChecksumWriteCloser(f), nil
 }
 
 // DeleteSnapshot : Deletes snapshot directories **older than** the specified version (redolog file and offset).
@@ -306,7 +333,7 @@ func (l LocalDiskStore) OpenVectorPartyFileForWrite(table string, columnID int,
 	vectorPartyFilePath := GetPathForTableArchiveBatchColumnFile(l.rootPath, table, shard, batchIDTimeStr, batchVersion,
 		seqNum, columnID)
 
-	mode := os.O_CREATE | os.O_WRONLY
+	mode := os.O_CREATE | os.O_TRUNC | os.O_WRONLY
 	if l.diskStoreConfig.WriteSync {
 		mode |= os.O_SYNC
 	}
@@ -315,7 +342,7 @@ func (l LocalDiskStore) OpenVectorPartyFileForWrite(table string, columnID int,
 	if err != nil {
 		return nil, utils.StackError(err, "Failed to open vector party file: %s for write", vectorPartyFilePath)
 	}
-	return f, nil
+	return newChecksumWriteCloser(f), nil
 }
 
 // DeleteBatchVersions deletes all old batches with the specified batchID that have version lower than or equal to
@@ -26,6 +26,7 @@ import (
 	. "github.com/onsi/gomega"
 
 	"fmt"
+	"github.com/uber/aresdb/common"
 	"github.com/uber/aresdb/utils"
 	"path/filepath"
 )
@@ -57,7 +58,7 @@ var _ = ginkgo.Describe("DiskStore", func() {
 			createdUnixTs[i] = randInt64
 			ioutil.WriteFile(filePath, nil, os.ModePerm)
 		}
-		l := NewLocalDiskStore(prefix)
+		l := NewLocalDiskStore(prefix, common.RedoLogSyncConfig{})
 		logsCreatedUnixTs, err := l.ListLogFiles(table, shard)
 		sort.Sort(utils.Int64Array(createdUnixTs))
 		Ω(err).Should(BeNil())
@@ -129,7 +130,7 @@ var _ = ginkgo.Describe("DiskStore", func() {
 			createdUnixTs[i] = randInt64
 			ioutil.WriteFile(filePath, nil, os.ModePerm)
 		}
-		l := NewLocalDiskStore(prefix)
+		l := NewLocalDiskStore(prefix, common.RedoLogSyncConfig{})
 		// Write
 		for i := 0; i < numFiles; i++ {
 			writerCloser, err := l.OpenLogFileForAppend(table, shard, createdUnixTs[i])
@@ -181,12 +182,32 @@ var _ = ginkgo.Describe("DiskStore", func() {
 	})
 
 	ginkgo.It("works with non-existing redolog file directory", func() {
-		l := NewLocalDiskStore(prefix)
+		l := NewLocalDiskStore(prefix, common.RedoLogSyncConfig{})
 		files, err := l.ListLogFiles(table, shard)
 		Ω(err).Should(BeNil())
 		Ω(files).Should(BeNil())
 	})
 
+	ginkgo.It("GetTableShardDiskUsage should work", func() {
+		l := NewLocalDiskStore(prefix, common.RedoLogSyncConfig{})
+		bytes, err := l.GetTableShardDiskUsage(table, shard)
+		Ω(err).Should(BeNil())
+		Ω(bytes).Should(BeZero())
+
+		redologDirPath := GetPathForTableRedologs(prefix, table, shard)
+		os.MkdirAll(redologDirPath, os.ModeDir|os.ModePerm)
+		ioutil.WriteFile(GetPathForRedologFile(prefix, table, shard, 1), make([]byte, 10), os.ModePerm)
+		snapshotBatchDir := GetPathForTableSnapshotBatchDir(prefix, table, shard, 1, 0, 0)
+		os.MkdirAll(snapshotBatchDir, os.ModeDir|os.ModePerm)
+		ioutil.WriteFile(GetPathForTableSnapshotColumnFilePath(prefix, table, shard, 1, 0, 0, 0), make([]byte, 20), os.ModePerm)
+		// other shards are not counted.
+		ioutil.WriteFile(GetPathForRedologFile(prefix, table, shard+1, 1), make([]byte, 40), os.ModePerm)
+
+		bytes, err = l.GetTableShardDiskUsage(table, shard)
+		Ω(err).Should(BeNil())
+		Ω(bytes).Should(Equal(int64(30)))
+	})
+
 	ginkgo.It("Test List Snapshot Dir for LocalDiskstore", func() {
 		// Setup directory
 		snapshotDirPath := GetPathForTableSnapshotDir(prefix, table, shard)
@@ -205,7 +226,7 @@ var _ = ginkgo.Describe("DiskStore", func() {
 		}
 
 		sort.Ints(randomBatches)
-		l := NewLocalDiskStore(prefix)
+		l := NewLocalDiskStore(prefix, common.RedoLogSyncConfig{})
 
 		batches, err := l.ListSnapshotBatches(table, shard, redoLogFile, offset)
 		Ω(err).Should(BeNil())
@@ -234,7 +255,7 @@ var _ = ginkgo.Describe("DiskStore", func() {
 		}
 
 		sort.Ints(randomColumns)
-		l := NewLocalDiskStore(prefix)
+		l := NewLocalDiskStore(prefix, common.RedoLogSyncConfig{})
 
 		columns, err := l.ListSnapshotVectorPartyFiles(table, shard, redoLogFile, offset, batchID)
 		Ω(err).Should(BeNil())
@@ -261,7 +282,7 @@ var _ = ginkgo.Describe("DiskStore", func() {
 			randomColumns[i] = randomColumn
 			ioutil.WriteFile(filePath, randomThingToWrite, os.ModePerm)
 		}
-		l := NewLocalDiskStore(prefix)
+		l := NewLocalDiskStore(prefix, common.RedoLogSyncConfig{})
 
 		// Read
 		for _, column := range randomColumns {
@@ -287,7 +308,7 @@ var _ = ginkgo.Describe("DiskStore", func() {
 
 		randomThingToWrite := []byte("Test Write Snapshot Files for LocalDiskstore")
 		randomColumns := make([]int, numFiles)
-		l := NewLocalDiskStore(prefix)
+		l := NewLocalDiskStore(prefix, common.RedoLogSyncConfig{})
 		// Initial set and write something longer string.
 		for i := 0; i < numFiles; i++ {
 			randomColumn := int(rand.Int31())
@@ -349,7 +370,7 @@ var _ = ginkgo.Describe("DiskStore", func() {
 		randomThingToWrite := []byte("Another Test Write Snapshot Files for LocalDiskstore")
 		randomRedologFiles := make([]int64, numFiles)
 		randomOffsets := make([]uint32, numFiles)
-		l := NewLocalDiskStore(prefix)
+		l := NewLocalDiskStore(prefix, common.RedoLogSyncConfig{})
 
 		var redoLogFileLimit int64 = 3
 		var offsetLimit uint32 = 10
@@ -402,7 +423,7 @@ var _ = ginkgo.Describe("DiskStore", func() {
 	})
 
 	ginkgo.It("Test Read/Write Archiving Column and DeleteBatchVersions for LocalDiskstore", func() {
-		l := NewLocalDiskStore(prefix)
+		l := NewLocalDiskStore(prefix, common.RedoLogSyncConfig{})
 		// Setup directory
 		batchID := "1988-06-17"
 		batchIDSinceEpoch := 6742
@@ -460,7 +481,7 @@ var _ = ginkgo.Describe("DiskStore", func() {
 	})
 
 	ginkgo.It("Test DeleteBatches with batchIDCutoff for LocalDiskstore", func() {
-		l := NewLocalDiskStore(prefix)
+		l := NewLocalDiskStore(prefix, common.RedoLogSyncConfig{})
 		// Setup directory
 
 		startBatchID := "2017-06-17"
@@ -514,7 +535,7 @@ var _ = ginkgo.Describe("DiskStore", func() {
 	})
 
 	ginkgo.It("Test DeleteColumn for LocalDiskstore", func() {
-		l := NewLocalDiskStore(prefix)
+		l := NewLocalDiskStore(prefix, common.RedoLogSyncConfig{})
 		// Setup directory
 		numSubDir := 10
 		randomThingToWrite := []byte("Test Read Snapshot Files for LocalDiskstore")
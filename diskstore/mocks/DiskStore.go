@@ -115,6 +115,27 @@ func (_m *DiskStore) DeleteTableShard(table string, shard int) error {
 	return r0
 }
 
+// GetTableShardDiskUsage provides a mock function with given fields: table, shard
+func (_m *DiskStore) GetTableShardDiskUsage(table string, shard int) (int64, error) {
+	ret := _m.Called(table, shard)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(string, int) int64); ok {
+		r0 = rf(table, shard)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, int) error); ok {
+		r1 = rf(table, shard)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ListLogFiles provides a mock function with given fields: table, shard
 func (_m *DiskStore) ListLogFiles(table string, shard int) ([]int64, error) {
 	ret := _m.Called(table, shard)
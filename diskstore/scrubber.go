@@ -0,0 +1,246 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diskstore
+
+import (
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/utils"
+)
+
+const (
+	quarantine = "quarantine"
+	// size of each read while scrubbing a file.
+	scrubChunkSize = 64 * 1024
+	// files modified more recently may still be being written.
+	scrubMinFileAge = time.Minute
+)
+
+var errScrubberStopped = errors.New("Scrubber stopped")
+
+// Scrubber periodically reads all vector party files under the root path in the background and
+// verifies their headers and checksums to detect corruption on disk, e.g. bit rot. Corrupt files
+// are reported and optionally moved under {root_path}/quarantine. Scans are throttled by the
+// configured rate so that they do not compete with serving for disk bandwidth.
+type Scrubber struct {
+	rootPath     string
+	config       common.ScrubberConfig
+	validHeaders map[uint32]bool
+	stopChan     chan struct{}
+
+	// start time and bytes read of the current scan for throttling.
+	scanStart time.Time
+	scanBytes int64
+}
+
+// NewScrubber creates a new Scrubber of the vector party files under rootPath, files should start
+// with one of validHeaders.
+func NewScrubber(rootPath string, config common.ScrubberConfig, validHeaders ...uint32) *Scrubber {
+	scrubber := &Scrubber{
+		rootPath:     rootPath,
+		config:       config,
+		validHeaders: make(map[uint32]bool),
+		stopChan:     make(chan struct{}),
+	}
+	for _, header := range validHeaders {
+		scrubber.validHeaders[header] = true
+	}
+	return scrubber
+}
+
+// Run is a ticker function to scrub all files periodically.
+func (s *Scrubber) Run() {
+	if !s.config.Enable || s.config.IntervalInSeconds <= 0 {
+		return
+	}
+	tickChan := time.NewTicker(time.Second * time.Duration(s.config.IntervalInSeconds)).C
+
+	for {
+		select {
+		case <-tickChan:
+			corruptFiles, err := s.Scrub()
+			if err == errScrubberStopped {
+				return
+			}
+			if err != nil {
+				utils.GetLogger().With("error", err).Error("Failed to scrub vector party files")
+			}
+			utils.GetLogger().With("corruptFiles", corruptFiles).Info("Scrubbed vector party files")
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// Stop stops the scrubber, including the scan in progress.
+func (s *Scrubber) Stop() {
+	close(s.stopChan)
+}
+
+// Scrub scans all vector party files once and returns the paths of corrupt files relative to
+// the data directory.
+func (s *Scrubber) Scrub() ([]string, error) {
+	dataDir := filepath.Join(s.rootPath, data)
+	s.scanStart = utils.Now()
+	s.scanBytes = 0
+
+	var corruptFiles []string
+	err := filepath.Walk(dataDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Files and directories can be deleted during the scan, e.g. by purge.
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".data") ||
+			utils.Now().Sub(info.ModTime()) < scrubMinFileAge {
+			return nil
+		}
+
+		corrupt, err := s.verifyFile(path)
+		if err == errScrubberStopped {
+			return err
+		}
+		if err != nil {
+			if !os.IsNotExist(err) {
+				utils.GetLogger().With("file", path, "error", err).Warn("Failed to scrub vector party file")
+			}
+			return nil
+		}
+		utils.GetRootReporter().GetCounter(utils.ScrubbedFiles).Inc(1)
+		if !corrupt {
+			return nil
+		}
+
+		relPath, _ := filepath.Rel(dataDir, path)
+		corruptFiles = append(corruptFiles, relPath)
+		utils.GetRootReporter().GetCounter(utils.CorruptFiles).Inc(1)
+		utils.GetLogger().With("file", path).Error("Corrupt vector party file")
+		if s.config.Quarantine {
+			if err := s.quarantineFile(path, relPath); err != nil {
+				utils.GetLogger().With("file", path, "error", err).Error("Failed to quarantine vector party file")
+			}
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return corruptFiles, err
+}
+
+// verifyFile tells whether the file is corrupt. Files should start with a valid header, and the
+// checksum in the footer should match the bytes before it. Files written before checksums were added
+// have no footer and only their headers are verified.
+func (s *Scrubber) verifyFile(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	checksum := crc32.NewIEEE()
+	// last bytes read, which are the footer at the end of the file.
+	var tail []byte
+	var header []byte
+	buffer := make([]byte, scrubChunkSize)
+	for {
+		n, err := io.ReadFull(file, buffer)
+		if n > 0 {
+			for i := 0; i < n && len(header) < 4; i++ {
+				header = append(header, buffer[i])
+			}
+			tail = append(tail, buffer[:n]...)
+			if len(tail) > checksumFooterSize {
+				checksum.Write(tail[:len(tail)-checksumFooterSize])
+				tail = append(tail[:0], tail[len(tail)-checksumFooterSize:]...)
+			}
+			if err := s.throttle(n); err != nil {
+				return false, err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if len(header) < 4 {
+		return true, nil
+	}
+	headerReader := utils.NewStreamDataReader(bytes.NewReader(header))
+	if magic, _ := headerReader.ReadUint32(); !s.validHeaders[magic] {
+		return true, nil
+	}
+	if len(tail) < checksumFooterSize {
+		return false, nil
+	}
+	footerReader := utils.NewStreamDataReader(bytes.NewReader(tail))
+	magic, _ := footerReader.ReadUint32()
+	if magic != ChecksumFooterMagic {
+		return false, nil
+	}
+	expected, _ := footerReader.ReadUint32()
+	return expected != checksum.Sum32(), nil
+}
+
+// throttle accounts n bytes read and sleeps until the scan is within the configured rate.
+func (s *Scrubber) throttle(n int) error {
+	utils.GetRootReporter().GetCounter(utils.ScrubbedBytes).Inc(int64(n))
+	s.scanBytes += int64(n)
+	var wait time.Duration
+	if s.config.MaxBytesPerSecond > 0 {
+		expected := time.Duration(float64(s.scanBytes) / float64(s.config.MaxBytesPerSecond) * float64(time.Second))
+		wait = expected - utils.Now().Sub(s.scanStart)
+	}
+
+	if wait <= 0 {
+		select {
+		case <-s.stopChan:
+			return errScrubberStopped
+		default:
+			return nil
+		}
+	}
+	select {
+	case <-s.stopChan:
+		return errScrubberStopped
+	case <-time.After(wait):
+		return nil
+	}
+}
+
+// quarantineFile moves the corrupt file to the same relative path under the quarantine directory.
+func (s *Scrubber) quarantineFile(path, relPath string) error {
+	quarantinePath := filepath.Join(s.rootPath, quarantine, relPath)
+	if err := os.MkdirAll(filepath.Dir(quarantinePath), 0755); err != nil {
+		return utils.StackError(err, "Failed to make dirs for path: %s", filepath.Dir(quarantinePath))
+	}
+	if err := os.Rename(path, quarantinePath); err != nil {
+		return utils.StackError(err, "Failed to move %s to %s", path, quarantinePath)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diskstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("scrubber", func() {
+	prefix := "/tmp/testScrubberSuite"
+	table := "myTable"
+	shard := 1
+	header := []byte{0xce, 0xfa, 0xde, 0xfa}
+	batchDir := daysSinceEpochToTimeStr(17000)
+
+	ginkgo.BeforeEach(func() {
+		os.RemoveAll(prefix)
+		os.MkdirAll(prefix, 0755)
+		// files just written are skipped by the scrubber.
+		utils.SetCurrentTime(time.Now().Add(time.Hour))
+	})
+
+	ginkgo.AfterEach(func() {
+		utils.ResetClockImplementation()
+		os.RemoveAll(prefix)
+	})
+
+	writeFile := func(l DiskStore, columnID int, content []byte) {
+		writer, err := l.OpenVectorPartyFileForWrite(table, columnID, shard, 17000, 1, 0)
+		Ω(err).Should(BeNil())
+		_, err = writer.Write(content)
+		Ω(err).Should(BeNil())
+		Ω(writer.Close()).Should(BeNil())
+	}
+
+	ginkgo.It("detects and quarantines corrupt files", func() {
+		l := NewLocalDiskStore(prefix, common.RedoLogSyncConfig{})
+		content := append(append([]byte{}, header...), []byte("vector party data")...)
+		for columnID := 0; columnID < 3; columnID++ {
+			writeFile(l, columnID, content)
+		}
+		snapshotWriter, err := l.OpenSnapshotVectorPartyFileForWrite(table, shard, 1, 1, 0, 0)
+		Ω(err).Should(BeNil())
+		snapshotWriter.Write(content)
+		Ω(snapshotWriter.Close()).Should(BeNil())
+
+		// files written before checksums were added only have their headers verified.
+		legacyPath := GetPathForTableArchiveBatchColumnFile(prefix, table, shard, batchDir, 1, 0, 3)
+		Ω(ioutil.WriteFile(legacyPath, content, 0644)).Should(BeNil())
+
+		scrubber := NewScrubber(prefix, common.ScrubberConfig{Quarantine: true}, 0xFADEFACE)
+		corruptFiles, err := scrubber.Scrub()
+		Ω(err).Should(BeNil())
+		Ω(corruptFiles).Should(BeEmpty())
+
+		// flip a bit in the data of column 1 and the header of column 2.
+		path1 := GetPathForTableArchiveBatchColumnFile(prefix, table, shard, batchDir, 1, 0, 1)
+		bytes, err := ioutil.ReadFile(path1)
+		Ω(err).Should(BeNil())
+		Ω(bytes).Should(HaveLen(len(content) + checksumFooterSize))
+		bytes[10] ^= 0x10
+		Ω(ioutil.WriteFile(path1, bytes, 0644)).Should(BeNil())
+
+		path2 := GetPathForTableArchiveBatchColumnFile(prefix, table, shard, batchDir, 1, 0, 2)
+		bytes, err = ioutil.ReadFile(path2)
+		Ω(err).Should(BeNil())
+		bytes[0] ^= 0x01
+		Ω(ioutil.WriteFile(path2, bytes, 0644)).Should(BeNil())
+
+		corruptFiles, err = scrubber.Scrub()
+		Ω(err).Should(BeNil())
+		Ω(corruptFiles).Should(Equal([]string{
+			"myTable_1/archiving_batches/2016-07-18_1/1.data",
+			"myTable_1/archiving_batches/2016-07-18_1/2.data",
+		}))
+		_, err = os.Stat(path1)
+		Ω(os.IsNotExist(err)).Should(BeTrue())
+		_, err = os.Stat(filepath.Join(prefix, "quarantine", corruptFiles[0]))
+		Ω(err).Should(BeNil())
+
+		corruptFiles, err = scrubber.Scrub()
+		Ω(err).Should(BeNil())
+		Ω(corruptFiles).Should(BeEmpty())
+	})
+
+	ginkgo.It("overwrites files with a new checksum", func() {
+		l := NewLocalDiskStore(prefix, common.RedoLogSyncConfig{})
+		writeFile(l, 0, append(append([]byte{}, header...), []byte("longer vector party data")...))
+		writeFile(l, 0, append(append([]byte{}, header...), []byte("data")...))
+
+		scrubber := NewScrubber(prefix, common.ScrubberConfig{}, 0xFADEFACE)
+		corruptFiles, err := scrubber.Scrub()
+		Ω(err).Should(BeNil())
+		Ω(corruptFiles).Should(BeEmpty())
+	})
+
+	ginkgo.It("trims the checksum footer of files", func() {
+		l := NewLocalDiskStore(prefix, common.RedoLogSyncConfig{})
+		content := append(append([]byte{}, header...), []byte("vector party data")...)
+		writeFile(l, 0, content)
+		path := GetPathForTableArchiveBatchColumnFile(prefix, table, shard, batchDir, 1, 0, 0)
+		bytes, err := ioutil.ReadFile(path)
+		Ω(err).Should(BeNil())
+		Ω(TrimChecksumFooter(bytes)).Should(Equal(content))
+		// files written before checksums were added.
+		Ω(TrimChecksumFooter(content)).Should(Equal(content))
+		Ω(TrimChecksumFooter(header)).Should(Equal(header))
+
+		bytes[10] ^= 0x10
+		_, err = TrimChecksumFooter(bytes)
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("stops scans in progress", func() {
+		l := NewLocalDiskStore(prefix, common.RedoLogSyncConfig{})
+		writeFile(l, 0, header)
+		scrubber := NewScrubber(prefix, common.ScrubberConfig{}, 0xFADEFACE)
+		scrubber.Stop()
+		_, err := scrubber.Scrub()
+		Ω(err).Should(Equal(errScrubberStopped))
+	})
+})
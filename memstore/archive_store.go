@@ -153,9 +153,14 @@ func (v *ArchiveStoreVersion) RequestBatch(batchID int32) *ArchiveBatch {
 // WriteToDisk writes each column of a batch to disk. It happens on archiving
 // stage for merged archive batch so there is no need to lock it.
 func (b *ArchiveBatch) WriteToDisk() error {
+	compression := common.CompressionCodecFromString(b.Shard.Schema.Schema.Config.ArchiveCompression)
+	if compression == common.UnknownCompression {
+		return utils.StackError(nil, "Unknown archive compression %s", b.Shard.Schema.Schema.Config.ArchiveCompression)
+	}
+
 	for columnID, column := range b.Columns {
 		serializer := NewVectorPartyArchiveSerializer(
-			b.Shard.HostMemoryManager, b.Shard.diskStore, b.Shard.Schema.Schema.Name, b.Shard.ShardID, columnID, int(b.BatchID), b.Version, b.SeqNum)
+			b.Shard.HostMemoryManager, b.Shard.diskStore, b.Shard.Schema.Schema.Name, b.Shard.ShardID, columnID, int(b.BatchID), b.Version, b.SeqNum, compression)
 		if err := serializer.WriteVectorParty(column); err != nil {
 			return err
 		}
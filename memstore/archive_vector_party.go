@@ -116,7 +116,7 @@ func (vp *archiveVectorParty) Pin() {
 func (vp *archiveVectorParty) LoadFromDisk(hostMemManager common.HostMemoryManager, diskStore diskstore.DiskStore, table string, shardID int, columnID, batchID int, batchVersion uint32, seqNum uint32) {
 	vp.Loader.Add(1)
 	go func() {
-		serializer := NewVectorPartyArchiveSerializer(hostMemManager, diskStore, table, shardID, columnID, batchID, batchVersion, seqNum)
+		serializer := NewVectorPartyArchiveSerializer(hostMemManager, diskStore, table, shardID, columnID, batchID, batchVersion, seqNum, common.NoCompression)
 		err := serializer.ReadVectorParty(vp)
 		if err != nil {
 			utils.GetLogger().Panic(err)
@@ -15,6 +15,7 @@
 package memstore
 
 import (
+	"errors"
 	"sort"
 
 	"github.com/uber/aresdb/memstore/common"
@@ -166,6 +167,37 @@ func (s *LiveStore) getBatchIDsToPurge(cutoff uint32) []int32 {
 	return batchIDs
 }
 
+// getEarlyArchivingCutoff returns the lowest cutoff that allows the oldest fully written
+// batch in live store to be purged after archiving, 0 if there is no such batch.
+func (s *LiveStore) getEarlyArchivingCutoff(allowMissingEventTime bool) uint32 {
+	s.RLock()
+	defer s.RUnlock()
+
+	var oldestBatch *LiveBatch
+	var oldestBatchID int32
+	for batchID, batch := range s.Batches {
+		if batchID >= s.LastReadRecord.BatchID {
+			continue
+		}
+		if oldestBatch == nil || batchID < oldestBatchID {
+			oldestBatch, oldestBatchID = batch, batchID
+		}
+	}
+
+	if oldestBatch == nil {
+		return 0
+	}
+
+	var cutoff uint32
+	if timeColumn := oldestBatch.Columns[0]; timeColumn != nil {
+		_, cutoff = timeColumn.(common.LiveVectorParty).GetMinMaxValue()
+	}
+	if allowMissingEventTime && oldestBatch.MaxArrivalTime > cutoff {
+		cutoff = oldestBatch.MaxArrivalTime
+	}
+	return cutoff + 1
+}
+
 func (ap archivingPatch) Len() int {
 	return len(ap.recordIDs)
 }
@@ -327,6 +359,105 @@ func (m *memStoreImpl) Archive(table string, shardID int, cutoff uint32, reporte
 	return nil
 }
 
+// ErrArchivingInProgress is returned by ArchiveTable if the table is already being archived on demand.
+var ErrArchivingInProgress = errors.New("archiving of the table is already in progress")
+
+// ErrArchivingCutoffInFuture is returned by ArchiveTable if the cutoff is later than now, as rows
+// with event times up to the cutoff can still be ingested into the live store.
+var ErrArchivingCutoffInFuture = errors.New("archiving cutoff is in the future")
+
+// ArchiveTableResult is the result of archiving a table on demand.
+type ArchiveTableResult struct {
+	// Number of live batches archived and purged from the live store of all shards.
+	NumArchivedBatches int `json:"numArchivedBatches"`
+	// Archiving cutoff of the table after archiving, which is the minimum of all shards.
+	ArchivingCutoff uint32 `json:"archivingCutoff"`
+}
+
+// ArchiveTable archives all shards of the fact table up to the cutoff. Archiving jobs are submitted
+// to the scheduler so that they never run concurrently with scheduled jobs. Shards already archived
+// up to the cutoff are skipped. Cutoffs later than now are rejected.
+func (m *memStoreImpl) ArchiveTable(table string, cutoff uint32) (result ArchiveTableResult, err error) {
+	if int64(cutoff) > utils.Now().Unix() {
+		return result, ErrArchivingCutoffInFuture
+	}
+
+	m.archivingTablesLock.Lock()
+	if m.archivingTables[table] {
+		m.archivingTablesLock.Unlock()
+		return result, ErrArchivingInProgress
+	}
+	if m.archivingTables == nil {
+		m.archivingTables = make(map[string]bool)
+	}
+	m.archivingTables[table] = true
+	m.archivingTablesLock.Unlock()
+
+	defer func() {
+		m.archivingTablesLock.Lock()
+		delete(m.archivingTables, table)
+		m.archivingTablesLock.Unlock()
+	}()
+
+	schema, err := m.GetSchema(table)
+	if err != nil {
+		return result, err
+	}
+	schema.RLock()
+	isFactTable := schema.Schema.IsFactTable
+	schema.RUnlock()
+	if !isFactTable {
+		return result, utils.StackError(nil, "Table %s is not a fact table", table)
+	}
+
+	m.RLock()
+	var shardIDs []int
+	for shardID := range m.TableShards[table] {
+		shardIDs = append(shardIDs, shardID)
+	}
+	m.RUnlock()
+	sort.Ints(shardIDs)
+
+	result.ArchivingCutoff = cutoff
+	for _, shardID := range shardIDs {
+		shard, err := m.GetTableShard(table, shardID)
+		if err != nil {
+			return result, err
+		}
+		batchIDsBefore, _ := shard.LiveStore.GetBatchIDs()
+		if cutoff > shard.getArchivingCutoff() {
+			if err = <-m.scheduler.SubmitJob(m.scheduler.NewArchivingJob(table, shardID, cutoff)); err != nil {
+				shard.Users.Done()
+				return result, err
+			}
+		}
+		batchIDsAfter, _ := shard.LiveStore.GetBatchIDs()
+		shardCutoff := shard.getArchivingCutoff()
+		shard.Users.Done()
+
+		remaining := make(map[int32]bool, len(batchIDsAfter))
+		for _, batchID := range batchIDsAfter {
+			remaining[batchID] = true
+		}
+		for _, batchID := range batchIDsBefore {
+			if !remaining[batchID] {
+				result.NumArchivedBatches++
+			}
+		}
+		if shardCutoff < result.ArchivingCutoff {
+			result.ArchivingCutoff = shardCutoff
+		}
+	}
+	return result, nil
+}
+
+// getArchivingCutoff returns the archiving cutoff of the current archive store version.
+func (shard *TableShard) getArchivingCutoff() uint32 {
+	version := shard.ArchiveStore.GetCurrentVersion()
+	defer version.Users.Done()
+	return version.ArchivingCutoff
+}
+
 func (shard *TableShard) createNewArchiveStoreVersion(cutoff uint32, reporter ArchiveJobDetailReporter, jobKey string) (
 	patchByDay map[int32]*archivingPatch, oldVersion *ArchiveStoreVersion, unmanagedMemoryBytes int64, err error) {
 
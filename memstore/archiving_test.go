@@ -16,6 +16,7 @@ package memstore
 
 import (
 	"sort"
+	"time"
 
 	diskMocks "github.com/uber/aresdb/diskstore/mocks"
 	metaMocks "github.com/uber/aresdb/metastore/mocks"
@@ -24,6 +25,8 @@ import (
 	"github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/stretchr/testify/mock"
+	"github.com/uber-go/tally"
+	aresCommon "github.com/uber/aresdb/common"
 	memCom "github.com/uber/aresdb/memstore/common"
 	metaCom "github.com/uber/aresdb/metastore/common"
 	"github.com/uber/aresdb/utils"
@@ -64,7 +67,7 @@ var _ = ginkgo.Describe("archiving", func() {
 		},
 		ValueTypeByColumn: []memCom.DataType{memCom.Uint32, memCom.Bool, memCom.Float32},
 		DefaultValues:     []*memCom.DataValue{&memCom.NullDataValue, &memCom.NullDataValue, &memCom.NullDataValue},
-	}, nil, nil, hostMemoryManager, shardID)
+	}, nil, nil, hostMemoryManager, shardID, Options{})
 
 	shard.ArchiveStore = &ArchiveStore{CurrentVersion: &ArchiveStoreVersion{
 		ArchivingCutoff: 0,
@@ -123,7 +126,7 @@ var _ = ginkgo.Describe("archiving", func() {
 		shardMap[shardID].ArchiveStore.CurrentVersion.shard = shardMap[shardID]
 		shardMap[shardID].ArchiveStore.CurrentVersion.Batches[0] = archiveBatch0
 		// Map from max event time to file creation time.
-		shardMap[shardID].LiveStore.RedoLogManager = NewRedoLogManager(10800, 1<<30, m.diskStore, table, shardID)
+		shardMap[shardID].LiveStore.RedoLogManager = NewRedoLogManager(10800, 1<<30, aresCommon.RedoLogSyncConfig{}, m.diskStore, table, shardID)
 		shardMap[shardID].LiveStore.RedoLogManager.MaxEventTimePerFile = make(map[int64]uint32)
 		shardMap[shardID].LiveStore.RedoLogManager.MaxEventTimePerFile[1] = 1
 		// make purge to pass
@@ -289,6 +292,113 @@ var _ = ginkgo.Describe("archiving", func() {
 		utils.ResetClockImplementation()
 	})
 
+	ginkgo.It("archives oldest live batch early when live store exceeds memory limit", func() {
+		utils.ResetDefaults()
+		testScope := utils.GetRootReporter().GetRootScope().(tally.TestScope)
+		tableShard := shardMap[shardID]
+
+		(m.metaStore).(*metaMocks.MetaStore).On(
+			"AddArchiveBatchVersion", table, shardID, day, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		(m.metaStore).(*metaMocks.MetaStore).On(
+			"UpdateArchivingCutoff", table, shardID, mock.Anything).Return(nil)
+		(m.diskStore).(*diskMocks.DiskStore).On(
+			"DeleteBatchVersions", table, shardID, day, mock.Anything, mock.Anything).Return(nil)
+		(m.diskStore).(*diskMocks.DiskStore).On(
+			"DeleteLogFile", table, shardID, int64(1)).Return(nil)
+		writer := new(utilsMocks.WriteCloser)
+		writer.On("Write", mock.Anything).Return(0, nil)
+		writer.On("Close").Return(nil)
+		(m.diskStore).(*diskMocks.DiskStore).On(
+			"OpenVectorPartyFileForWrite", table, mock.Anything, shardID, day, mock.Anything, mock.Anything).Return(writer, nil)
+		tableShard.LiveStore.RedoLogManager.CurrentFileCreationTime = 2
+
+		// regular archiving is not due until archiving delay and interval elapse after current cutoff.
+		utils.SetClockImplementation(func() time.Time {
+			return time.Unix(30100, 0)
+		})
+		defer utils.ResetClockImplementation()
+		Ω(jobManager.generateJobs()).Should(BeEmpty())
+
+		tableShard.Schema.Schema.Config.LiveStoreMemoryLimit = 1
+		defer func() {
+			tableShard.Schema.Schema.Config.LiveStoreMemoryLimit = 0
+		}()
+		jobs := jobManager.generateJobs()
+		Ω(jobs).Should(HaveLen(1))
+		job := jobs[0].(*ArchivingJob)
+		// max event time of oldest batch is 140.
+		Ω(job.cutoff).Should(BeEquivalentTo(141))
+		Ω(job.Run()).Should(BeNil())
+
+		Ω(tableShard.ArchiveStore.CurrentVersion.ArchivingCutoff).Should(BeEquivalentTo(141))
+		// 5 existing archived records and 8 live records within [100, 141).
+		Ω(tableShard.ArchiveStore.CurrentVersion.Batches[0].Size).Should(BeEquivalentTo(13))
+		// oldest batch is purged after its records are archived, the partially read batch is kept.
+		Ω(tableShard.LiveStore.Batches).ShouldNot(HaveKey(int32(-110)))
+		Ω(tableShard.LiveStore.Batches).Should(HaveKey(int32(-101)))
+
+		counters := testScope.Snapshot().Counters()
+		Ω(counters["test.live_store_evictions+component=memstore,operation=archiving"].Value()).
+			Should(BeEquivalentTo(1))
+	})
+
+	ginkgo.It("archives table on demand", func() {
+		tableShard := shardMap[shardID]
+		(m.metaStore).(*metaMocks.MetaStore).On(
+			"AddArchiveBatchVersion", table, shardID, day, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		(m.metaStore).(*metaMocks.MetaStore).On(
+			"UpdateArchivingCutoff", table, shardID, mock.Anything).Return(nil)
+		(m.diskStore).(*diskMocks.DiskStore).On(
+			"DeleteBatchVersions", table, shardID, day, mock.Anything, mock.Anything).Return(nil)
+		(m.diskStore).(*diskMocks.DiskStore).On(
+			"DeleteLogFile", table, shardID, int64(1)).Return(nil)
+		writer := new(utilsMocks.WriteCloser)
+		writer.On("Write", mock.Anything).Return(0, nil)
+		writer.On("Close").Return(nil)
+		(m.diskStore).(*diskMocks.DiskStore).On(
+			"OpenVectorPartyFileForWrite", table, mock.Anything, shardID, day, mock.Anything, mock.Anything).Return(writer, nil)
+		tableShard.LiveStore.RedoLogManager.CurrentFileCreationTime = 2
+		m.TableSchemas[table] = tableShard.Schema
+
+		m.scheduler.Start()
+		defer m.scheduler.Stop()
+
+		// cutoffs later than now are rejected.
+		_, err := m.ArchiveTable(table, uint32(utils.Now().Unix()+3600))
+		Ω(err).Should(Equal(ErrArchivingCutoffInFuture))
+
+		// concurrent archiving of the same table is rejected.
+		m.archivingTables = map[string]bool{table: true}
+		_, err = m.ArchiveTable(table, 141)
+		Ω(err).Should(Equal(ErrArchivingInProgress))
+		delete(m.archivingTables, table)
+
+		result, err := m.ArchiveTable(table, 141)
+		Ω(err).Should(BeNil())
+		Ω(result).Should(Equal(ArchiveTableResult{
+			NumArchivedBatches: 1,
+			ArchivingCutoff:    141,
+		}))
+		Ω(m.archivingTables).ShouldNot(HaveKey(table))
+
+		// live records before the cutoff are now read from the archive batch.
+		Ω(tableShard.ArchiveStore.CurrentVersion.ArchivingCutoff).Should(BeEquivalentTo(141))
+		archiveBatch := tableShard.ArchiveStore.CurrentVersion.Batches[0]
+		Ω(archiveBatch.Size).Should(BeEquivalentTo(13))
+		Ω(archiveBatch.Columns[0].GetLength()).Should(BeEquivalentTo(13))
+		Ω(tableShard.LiveStore.Batches).ShouldNot(HaveKey(int32(-110)))
+
+		// shards already archived up to the cutoff are skipped.
+		result, err = m.ArchiveTable(table, 141)
+		Ω(err).Should(BeNil())
+		Ω(result).Should(Equal(ArchiveTableResult{ArchivingCutoff: 141}))
+
+		// dimension tables cannot be archived.
+		m.TableSchemas["dim"] = &TableSchema{Schema: metaCom.Table{Name: "dim"}}
+		_, err = m.ArchiveTable("dim", 141)
+		Ω(err).ShouldNot(BeNil())
+	})
+
 	ginkgo.It("create patch for table with invalid event time", func() {
 		table := "table2"
 		shardID := 0
@@ -375,4 +485,100 @@ var _ = ginkgo.Describe("archiving", func() {
 		batchIDs := liveStore.getBatchIDsToPurge(cutoff)
 		Ω(batchIDs).Should(Equal([]int32{-110}))
 	})
+
+	ginkgo.It("ingests and archives enum array columns", func() {
+		table := "tags_table"
+		metaStore := &metaMocks.MetaStore{}
+		diskStore := &diskMocks.DiskStore{}
+		metaStore.On("AddArchiveBatchVersion", table, shardID, day, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		metaStore.On("UpdateArchivingCutoff", table, shardID, mock.Anything).Return(nil)
+		metaStore.On("GetArchiveBatchVersion", table, shardID, day, mock.Anything).Return(uint32(0), uint32(0), 0, nil)
+		diskStore.On("DeleteBatchVersions", table, shardID, day, mock.Anything, mock.Anything).Return(nil)
+		diskStore.On("DeleteLogFile", table, shardID, mock.Anything).Return(nil)
+		diskStore.On("OpenVectorPartyFileForRead", table, mock.Anything, shardID, day, mock.Anything, mock.Anything).Return(nil, nil)
+		writer := new(utilsMocks.WriteCloser)
+		writer.On("Write", mock.Anything).Return(0, nil)
+		writer.On("Close").Return(nil)
+		diskStore.On("OpenVectorPartyFileForWrite", table, mock.Anything, shardID, day, mock.Anything, mock.Anything).Return(writer, nil)
+
+		tableSchema := NewTableSchema(&metaCom.Table{
+			Name: table,
+			Columns: []metaCom.Column{
+				{Name: "request_at", Type: metaCom.Uint32},
+				{Name: "id", Type: metaCom.Uint32},
+				{Name: "tags", Type: metaCom.EnumArray},
+			},
+			PrimaryKeyColumns: []int{1},
+			IsFactTable:       true,
+			Config: metaCom.TableConfig{
+				BatchSize:                10,
+				BackfillMaxBufferSize:    1 << 32,
+				BackfillThresholdInBytes: 1 << 21,
+			},
+		})
+		for columnID := range tableSchema.Schema.Columns {
+			tableSchema.SetDefaultValue(columnID)
+		}
+		tableSchema.createEnumDict("tags", []string{"pool", "airport", "night"})
+		Ω(tableSchema.ValueTypeByColumn[2]).Should(Equal(memCom.Uint32))
+
+		memStore := NewMemStore(metaStore, diskStore, Options{}).(*memStoreImpl)
+		tagsShard := NewTableShard(tableSchema, metaStore, diskStore, NewHostMemoryManager(memStore, 1<<32), shardID, Options{})
+		memStore.TableShards[table] = map[int]*TableShard{shardID: tagsShard}
+		memStore.TableSchemas[table] = tableSchema
+
+		// ids 1, 2 and 3 with tags [pool, night], null and [].
+		builder := memCom.NewUpsertBatchBuilder()
+		builder.AddColumn(0, memCom.Uint32)
+		builder.AddColumn(1, memCom.Uint32)
+		builder.AddColumn(2, memCom.Uint32)
+		for row, bitmap := range []interface{}{uint32(0x5), nil, uint32(0)} {
+			builder.AddRow()
+			builder.SetValue(row, 0, uint32(100+row))
+			builder.SetValue(row, 1, uint32(row+1))
+			builder.SetValue(row, 2, bitmap)
+		}
+		buffer, err := builder.ToByteArray()
+		Ω(err).Should(BeNil())
+		upsertBatch, err := NewUpsertBatch(buffer)
+		Ω(err).Should(BeNil())
+		_, err = tagsShard.ApplyUpsertBatch(upsertBatch, 0, 0, false)
+		Ω(err).Should(BeNil())
+
+		// primaryKeyBytes returns the primary key of the uint32 id.
+		primaryKeyBytes := func(id byte) []byte {
+			return []byte{id, 0, 0, 0}
+		}
+		value, valid := ReadShardValue(tagsShard, 2, primaryKeyBytes(1))
+		Ω(valid).Should(BeTrue())
+		Ω(*(*uint32)(value)).Should(Equal(uint32(0x5)))
+		_, valid = ReadShardValue(tagsShard, 2, primaryKeyBytes(2))
+		Ω(valid).Should(BeFalse())
+		value, valid = ReadShardValue(tagsShard, 2, primaryKeyBytes(3))
+		Ω(valid).Should(BeTrue())
+		Ω(*(*uint32)(value)).Should(Equal(uint32(0)))
+
+		Ω(memStore.Archive(table, shardID, 200, func(key string, mutator ArchiveJobDetailMutator) {})).Should(BeNil())
+		archivedBatch := tagsShard.ArchiveStore.CurrentVersion.Batches[0]
+		Ω(archivedBatch).ShouldNot(BeNil())
+		Ω(archivedBatch.Size).Should(Equal(3))
+
+		// tags of the archived rows by id.
+		archivedTags := map[uint32]interface{}{}
+		for row := 0; row < archivedBatch.Size; row++ {
+			id := archivedBatch.Columns[1].GetDataValue(row)
+			Ω(id.Valid).Should(BeTrue())
+			tags := archivedBatch.Columns[2].GetDataValue(row)
+			if tags.Valid {
+				archivedTags[*(*uint32)(id.OtherVal)] = *(*uint32)(tags.OtherVal)
+			} else {
+				archivedTags[*(*uint32)(id.OtherVal)] = nil
+			}
+		}
+		Ω(archivedTags).Should(Equal(map[uint32]interface{}{
+			1: uint32(0x5),
+			2: nil,
+			3: uint32(0),
+		}))
+	})
 })
@@ -0,0 +1,182 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"time"
+
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	memCom "github.com/uber/aresdb/memstore/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// arrowKind groups arrow data types by the ares data types they can be coerced into.
+type arrowKind int
+
+const (
+	arrowKindUnsupported arrowKind = iota
+	arrowKindBool
+	arrowKindInteger
+	arrowKindFloat
+	arrowKindTime
+	arrowKindString
+	arrowKindBinary
+)
+
+// arrowCoercions lists ares data types each arrow kind can be converted into.
+// Integer values are range checked against the target type when converted,
+// timestamps and dates are converted into epoch seconds, and strings are
+// looked up in the enum dictionary for enum columns.
+var arrowCoercions = map[arrowKind][]memCom.DataType{
+	arrowKindBool: {memCom.Bool},
+	arrowKindInteger: {memCom.Bool, memCom.Int8, memCom.Uint8, memCom.Int16, memCom.Uint16, memCom.Int32,
+		memCom.Uint32, memCom.Int64, memCom.Float32, memCom.SmallEnum, memCom.BigEnum},
+	arrowKindFloat:  {memCom.Float32},
+	arrowKindTime:   {memCom.Int32, memCom.Uint32, memCom.Int64},
+	arrowKindString: {memCom.SmallEnum, memCom.BigEnum, memCom.UUID, memCom.GeoPoint, memCom.GeoShape},
+	arrowKindBinary: {memCom.UUID, memCom.GeoShape},
+}
+
+func getArrowKind(dataType arrow.DataType) arrowKind {
+	switch dataType.ID() {
+	case arrow.BOOL:
+		return arrowKindBool
+	case arrow.INT8, arrow.UINT8, arrow.INT16, arrow.UINT16, arrow.INT32, arrow.UINT32, arrow.INT64, arrow.UINT64:
+		return arrowKindInteger
+	case arrow.FLOAT32, arrow.FLOAT64:
+		return arrowKindFloat
+	case arrow.TIMESTAMP, arrow.DATE32:
+		return arrowKindTime
+	case arrow.STRING:
+		return arrowKindString
+	case arrow.BINARY, arrow.FIXED_SIZE_BINARY:
+		return arrowKindBinary
+	}
+	return arrowKindUnsupported
+}
+
+// getArrowValue returns the go value at the given row of an arrow array, or nil if the value is null.
+func getArrowValue(column array.Interface, row int) interface{} {
+	if column.IsNull(row) {
+		return nil
+	}
+
+	switch c := column.(type) {
+	case *array.Boolean:
+		return c.Value(row)
+	case *array.Int8:
+		return c.Value(row)
+	case *array.Uint8:
+		return c.Value(row)
+	case *array.Int16:
+		return c.Value(row)
+	case *array.Uint16:
+		return c.Value(row)
+	case *array.Int32:
+		return c.Value(row)
+	case *array.Uint32:
+		return c.Value(row)
+	case *array.Int64:
+		return c.Value(row)
+	case *array.Uint64:
+		return c.Value(row)
+	case *array.Float32:
+		return c.Value(row)
+	case *array.Float64:
+		return c.Value(row)
+	case *array.Timestamp:
+		unit := c.DataType().(*arrow.TimestampType).Unit.Multiplier()
+		return int64(c.Value(row)) * int64(unit) / int64(time.Second)
+	case *array.Date32:
+		return int64(c.Value(row)) * 86400
+	case *array.String:
+		return c.Value(row)
+	case *array.Binary:
+		return c.Value(row)
+	case *array.FixedSizeBinary:
+		return c.Value(row)
+	}
+	return nil
+}
+
+// NewUpsertBatchFromArrow converts an arrow record batch into an upsert batch of the table.
+// Arrow fields are matched with table columns by name, a field that does not match any
+// column or whose type cannot be converted into the column data type fails the whole batch.
+// User should not lock schema.
+func NewUpsertBatchFromArrow(schema *TableSchema, record array.Record) (*UpsertBatch, error) {
+	schema.RLock()
+	defer schema.RUnlock()
+
+	builder := memCom.NewUpsertBatchBuilder()
+	columnIDs := make([]int, record.NumCols())
+	seen := make(map[int]bool)
+	for col, field := range record.Schema().Fields() {
+		columnID, ok := schema.ColumnIDs[field.Name]
+		if !ok {
+			return nil, utils.StackError(nil, "Column %s does not exist in table %s",
+				field.Name, schema.Schema.Name)
+		}
+		if seen[columnID] {
+			return nil, utils.StackError(nil, "Column %s appears more than once in the record batch", field.Name)
+		}
+		seen[columnID] = true
+
+		dataType := schema.ValueTypeByColumn[columnID]
+		compatible := false
+		for _, target := range arrowCoercions[getArrowKind(field.Type)] {
+			if target == dataType {
+				compatible = true
+				break
+			}
+		}
+		if !compatible {
+			return nil, utils.StackError(nil, "Column %s: arrow type %s cannot be converted to %s",
+				field.Name, field.Type, memCom.DataTypeName[dataType])
+		}
+
+		if err := builder.AddColumn(columnID, dataType); err != nil {
+			return nil, err
+		}
+		columnIDs[col] = columnID
+	}
+
+	for row := 0; row < int(record.NumRows()); row++ {
+		builder.AddRow()
+		for col, columnID := range columnIDs {
+			columnName := schema.Schema.Columns[columnID].Name
+			value := getArrowValue(record.Column(col), row)
+			if enumCase, ok := value.(string); ok && schema.Schema.Columns[columnID].IsEnumColumn() &&
+				!schema.Schema.Columns[columnID].IsEnumArrayColumn() {
+				enumID, exist := schema.EnumDicts[columnName].Dict[enumCase]
+				if !exist {
+					return nil, utils.StackError(nil, "Column %s: unknown enum case %s at row %d",
+						columnName, enumCase, row)
+				}
+				value = enumID
+			}
+
+			if err := builder.SetValue(row, col, value); err != nil {
+				return nil, utils.StackError(err, "Column %s: invalid value %v at row %d", columnName, value, row)
+			}
+		}
+	}
+
+	buffer, err := builder.ToByteArray()
+	if err != nil {
+		return nil, err
+	}
+	return NewUpsertBatch(buffer)
+}
@@ -0,0 +1,174 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/memory"
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metaCom "github.com/uber/aresdb/metastore/common"
+)
+
+var _ = ginkgo.Describe("arrow upsert batch", func() {
+	var schema *TableSchema
+	var pool *memory.GoAllocator
+
+	ginkgo.BeforeEach(func() {
+		schema = NewTableSchema(&metaCom.Table{
+			Name:        "trips",
+			IsFactTable: true,
+			Columns: []metaCom.Column{
+				{Name: "request_at", Type: metaCom.Uint32},
+				{Name: "completed", Type: metaCom.Bool},
+				{Name: "count", Type: metaCom.Int16},
+				{Name: "fare", Type: metaCom.Float32},
+				{Name: "city", Type: metaCom.SmallEnum},
+				{Name: "uuid", Type: metaCom.UUID},
+				{Name: "old", Type: metaCom.Int32, Deleted: true},
+			},
+			PrimaryKeyColumns: []int{5},
+		})
+		schema.EnumDicts["city"] = EnumDict{
+			Capacity:    0x100,
+			Dict:        map[string]int{"sf": 0, "nyc": 1},
+			ReverseDict: []string{"sf", "nyc"},
+		}
+		pool = memory.NewGoAllocator()
+	})
+
+	newRecord := func(fields []arrow.Field, fill func(builder *array.RecordBuilder)) array.Record {
+		builder := array.NewRecordBuilder(pool, arrow.NewSchema(fields, nil))
+		defer builder.Release()
+		fill(builder)
+		return builder.NewRecord()
+	}
+
+	ginkgo.It("converts common types with nulls", func() {
+		record := newRecord([]arrow.Field{
+			{Name: "request_at", Type: &arrow.TimestampType{Unit: arrow.Millisecond}, Nullable: true},
+			{Name: "completed", Type: arrow.FixedWidthTypes.Boolean, Nullable: true},
+			{Name: "count", Type: arrow.PrimitiveTypes.Int64, Nullable: true},
+			{Name: "fare", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+			{Name: "city", Type: arrow.BinaryTypes.String, Nullable: true},
+			{Name: "uuid", Type: arrow.BinaryTypes.String, Nullable: true},
+		}, func(builder *array.RecordBuilder) {
+			builder.Field(0).(*array.TimestampBuilder).AppendValues([]arrow.Timestamp{1000, 2500}, nil)
+			builder.Field(1).(*array.BooleanBuilder).AppendValues([]bool{true, false}, []bool{true, false})
+			builder.Field(2).(*array.Int64Builder).AppendValues([]int64{-3, 0}, []bool{true, false})
+			builder.Field(3).(*array.Float64Builder).AppendValues([]float64{1.5, 0}, []bool{true, false})
+			builder.Field(4).(*array.StringBuilder).AppendValues([]string{"nyc", ""}, []bool{true, false})
+			builder.Field(5).(*array.StringBuilder).AppendValues([]string{
+				"01234567-89ab-cdef-0123-456789abcdef",
+				"fedcba98-7654-3210-fedc-ba9876543210",
+			}, nil)
+		})
+		defer record.Release()
+
+		upsertBatch, err := NewUpsertBatchFromArrow(schema, record)
+		Ω(err).Should(BeNil())
+		Ω(upsertBatch.NumRows).Should(Equal(2))
+		Ω(upsertBatch.NumColumns).Should(Equal(6))
+
+		columnNames, err := upsertBatch.GetColumnNames(schema)
+		Ω(err).Should(BeNil())
+		Ω(columnNames).Should(Equal([]string{"request_at", "completed", "count", "fare", "city", "uuid"}))
+
+		rows, err := upsertBatch.ReadData(0, 2)
+		Ω(err).Should(BeNil())
+		Ω(rows).Should(Equal([][]interface{}{
+			{uint32(1), true, int16(-3), float32(1.5), uint8(1), "01234567-89ab-cdef-0123-456789abcdef"},
+			{uint32(2), nil, nil, nil, nil, "fedcba98-7654-3210-fedc-ba9876543210"},
+		}))
+	})
+
+	ginkgo.It("converts integers into enum ids and dates into seconds", func() {
+		record := newRecord([]arrow.Field{
+			{Name: "request_at", Type: arrow.FixedWidthTypes.Date32},
+			{Name: "city", Type: arrow.PrimitiveTypes.Uint8},
+		}, func(builder *array.RecordBuilder) {
+			builder.Field(0).(*array.Date32Builder).Append(1)
+			builder.Field(1).(*array.Uint8Builder).Append(0)
+		})
+		defer record.Release()
+
+		upsertBatch, err := NewUpsertBatchFromArrow(schema, record)
+		Ω(err).Should(BeNil())
+		rows, err := upsertBatch.ReadData(0, 1)
+		Ω(err).Should(BeNil())
+		Ω(rows).Should(Equal([][]interface{}{{uint32(86400), uint8(0)}}))
+	})
+
+	ginkgo.It("rejects mismatched schemas", func() {
+		record := newRecord([]arrow.Field{
+			{Name: "unknown", Type: arrow.PrimitiveTypes.Int64},
+		}, func(builder *array.RecordBuilder) {
+			builder.Field(0).(*array.Int64Builder).Append(1)
+		})
+		_, err := NewUpsertBatchFromArrow(schema, record)
+		Ω(err.Error()).Should(ContainSubstring("Column unknown does not exist in table trips"))
+		record.Release()
+
+		record = newRecord([]arrow.Field{
+			{Name: "old", Type: arrow.PrimitiveTypes.Int64},
+		}, func(builder *array.RecordBuilder) {
+			builder.Field(0).(*array.Int64Builder).Append(1)
+		})
+		_, err = NewUpsertBatchFromArrow(schema, record)
+		Ω(err.Error()).Should(ContainSubstring("Column old does not exist in table trips"))
+		record.Release()
+
+		record = newRecord([]arrow.Field{
+			{Name: "fare", Type: arrow.BinaryTypes.String},
+		}, func(builder *array.RecordBuilder) {
+			builder.Field(0).(*array.StringBuilder).Append("1.5")
+		})
+		_, err = NewUpsertBatchFromArrow(schema, record)
+		Ω(err.Error()).Should(ContainSubstring("Column fare: arrow type utf8 cannot be converted to Float32"))
+		record.Release()
+
+		record = newRecord([]arrow.Field{
+			{Name: "count", Type: arrow.PrimitiveTypes.Int64},
+			{Name: "count", Type: arrow.PrimitiveTypes.Int64},
+		}, func(builder *array.RecordBuilder) {
+			builder.Field(0).(*array.Int64Builder).Append(1)
+			builder.Field(1).(*array.Int64Builder).Append(1)
+		})
+		_, err = NewUpsertBatchFromArrow(schema, record)
+		Ω(err.Error()).Should(ContainSubstring("Column count appears more than once"))
+		record.Release()
+	})
+
+	ginkgo.It("rejects invalid values", func() {
+		record := newRecord([]arrow.Field{
+			{Name: "count", Type: arrow.PrimitiveTypes.Int64},
+		}, func(builder *array.RecordBuilder) {
+			builder.Field(0).(*array.Int64Builder).AppendValues([]int64{1, 1 << 20}, nil)
+		})
+		_, err := NewUpsertBatchFromArrow(schema, record)
+		Ω(err.Error()).Should(ContainSubstring("Column count: invalid value 1048576 at row 1"))
+		record.Release()
+
+		record = newRecord([]arrow.Field{
+			{Name: "city", Type: arrow.BinaryTypes.String},
+		}, func(builder *array.RecordBuilder) {
+			builder.Field(0).(*array.StringBuilder).Append("la")
+		})
+		_, err = NewUpsertBatchFromArrow(schema, record)
+		Ω(err.Error()).Should(ContainSubstring("Column city: unknown enum case la at row 0"))
+		record.Release()
+	})
+})
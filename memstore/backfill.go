@@ -111,6 +111,8 @@ func (shard *TableShard) createNewArchiveStoreVersionForBackfill(
 	dataTypes := shard.Schema.ValueTypeByColumn
 	defaultValues := shard.Schema.DefaultValues
 	numColumns := len(shard.Schema.ValueTypeByColumn)
+	conflictResolutionColumn := shard.Schema.GetConflictResolutionColumn()
+	derivedColumns := shard.Schema.derivedColumns
 	shard.Schema.RUnlock()
 
 	var numAffectedDays int
@@ -144,6 +146,8 @@ func (shard *TableShard) createNewArchiveStoreVersionForBackfill(
 
 		backfillCtx := newBackfillContext(baseBatch, patch, shard.Schema, columnDeletions, sortColumns,
 			primaryKeyColumns, dataTypes, defaultValues, shard.HostMemoryManager)
+		backfillCtx.conflictColumn = conflictResolutionColumn
+		backfillCtx.derivedColumns = derivedColumns
 
 		// Real backfill implementation.
 		if err = backfillCtx.backfill(reporter, jobKey); err != nil {
@@ -332,6 +336,10 @@ type backfillContext struct {
 	sortColumns       []int
 	primaryKeyColumns []int
 	defaultValues     []*common.DataValue
+	// column resolving conflicts of patch records, -1 if the last arrived record wins.
+	conflictColumn int
+	// derived columns recomputed for each patched row.
+	derivedColumns []*derivedColumn
 
 	// keep track of which columns have been forked already.
 	columnsForked []bool
@@ -391,6 +399,7 @@ func newBackfillContext(baseBatch *ArchiveBatch, patch *backfillPatch, tableSche
 		columnsForked:     make([]bool, len(baseBatch.Columns)),
 		dataTypes:         dataTypes,
 		defaultValues:     defaultValues,
+		conflictColumn:    -1,
 	}
 }
 
@@ -437,7 +446,8 @@ func (ctx *backfillContext) backfill(reporter BackfillJobDetailReporter, jobKey
 	// inplaceUpdateRecords: records that modifies unsortedColumns and can be updated inplace
 	// deleteThenInsertRecords: records that modifies sortedColumns and needs to be deleted from base and inserted again into temp live store
 	// noEffectRecords: records that does not modify any column
-	var newRecords, inplaceUpdateRecords, deleteThenInsertRecords, noEffectRecords int64
+	// losingConflictRecords: records older than the existing records by conflict resolution column
+	var newRecords, inplaceUpdateRecords, deleteThenInsertRecords, noEffectRecords, losingConflictRecords int64
 
 	// We will do backfill row by row in patch.
 	for _, patchRecordID := range ctx.patch.recordIDs {
@@ -483,8 +493,14 @@ func (ctx *backfillContext) backfill(reporter BackfillJobDetailReporter, jobKey
 			return err
 		}
 
+		if exists && !ctx.winsConflict(recordID, changedPatchRow) {
+			losingConflictRecords++
+			continue
+		}
+
 		if exists && recordID.BatchID >= 0 {
 			// record is already in base batch.
+			ctx.computeDerivedColumns(recordID, changedPatchRow)
 
 			// first detect if there are any changes to sort columns.
 			changedBaseRow := ctx.getChangedBaseRow(recordID, changedPatchRow)
@@ -515,6 +531,7 @@ func (ctx *backfillContext) backfill(reporter BackfillJobDetailReporter, jobKey
 	utils.GetReporter(tableName, shardID).GetCounter(utils.BackfillNoEffectRecords).Inc(noEffectRecords)
 	utils.GetReporter(tableName, shardID).GetCounter(utils.BackfillInplaceUpdateRecords).Inc(inplaceUpdateRecords)
 	utils.GetReporter(tableName, shardID).GetCounter(utils.BackfillDeleteThenInsertRecords).Inc(deleteThenInsertRecords)
+	utils.GetReporter(tableName, shardID).GetCounter(utils.RecordsLosingConflict).Inc(losingConflictRecords)
 
 	// in case we fork the column but does not invoke the merge procedure (which also call column.Prune()).
 	// column.Prune is idempotent so it's safe to call multiple times.
@@ -553,6 +570,28 @@ func (ctx *backfillContext) merge(reporter BackfillJobDetailReporter, jobKey str
 	ctx.unmanagedMemoryBytes += mergeCtx.unmanagedMemoryBytes
 }
 
+// winsConflict tells whether the patch row replaces the existing record in base batch or temp live
+// store by conflict resolution column.
+func (ctx *backfillContext) winsConflict(recordID RecordID, changedPatchRow []*common.DataValue) bool {
+	columnID := ctx.conflictColumn
+	if columnID < 0 {
+		return true
+	}
+	newValue := common.NullDataValue
+	if changedPatchRow[columnID] != nil {
+		newValue = *changedPatchRow[columnID]
+	}
+	var oldValue common.DataValue
+	if recordID.BatchID >= 0 {
+		oldValue = ctx.new.Columns[columnID].GetDataValueByRow(int(recordID.Index))
+	} else {
+		backfillBatch := ctx.backfillStore.GetBatchForRead(recordID.BatchID)
+		oldValue = backfillBatch.GetDataValue(int(recordID.Index), columnID)
+		backfillBatch.RUnlock()
+	}
+	return winsConflict(newValue, oldValue)
+}
+
 // getChangedPatchRow get the upsert batch row as a slice of pointer of data value format to be consistent with changed
 // base row. Note an upsert batch row may not have values for all columns so some of the data value may be nil.
 func (ctx *backfillContext) getChangedPatchRow(patchRecordID RecordID, upsertBatch *UpsertBatch) ([]*common.DataValue, error) {
@@ -580,6 +619,20 @@ func (ctx *backfillContext) getChangedPatchRow(patchRecordID RecordID, upsertBat
 	return changedRow, nil
 }
 
+// computeDerivedColumns sets the derived columns of the patch row for the record in base batch.
+// Source values missing in the patch row are read from base batch.
+func (ctx *backfillContext) computeDerivedColumns(baseRecordID RecordID, changedPatchRow []*common.DataValue) {
+	for _, column := range ctx.derivedColumns {
+		value := column.compute(func(columnID int) common.DataValue {
+			if changedPatchRow[columnID] != nil {
+				return *changedPatchRow[columnID]
+			}
+			return ctx.new.Columns[columnID].GetDataValueByRow(int(baseRecordID.Index))
+		})
+		changedPatchRow[column.columnID] = &value
+	}
+}
+
 // getChangedBaseRow get changed row from base batch if there are any changes to sort columns. It will fetch the whole
 // row in base batch and apply patch value to it.
 func (ctx *backfillContext) getChangedBaseRow(baseRecordID RecordID, changedPatchRow []*common.DataValue) []*common.DataValue {
@@ -644,7 +697,8 @@ func (ctx *backfillContext) writePatchValueForUnsortedColumn(baseRecordID Record
 	return
 }
 
-// applyChangedRowToLiveStore applies changes in changedRow to temp live store.
+// applyChangedRowToLiveStore applies changes in changedRow to temp live store and recomputes the
+// derived columns of the row.
 func (ctx backfillContext) applyChangedRowToLiveStore(recordID RecordID, changedRow []*common.DataValue) {
 	backfillBatch := ctx.backfillStore.GetBatchForWrite(recordID.BatchID)
 	defer backfillBatch.Unlock()
@@ -654,4 +708,5 @@ func (ctx backfillContext) applyChangedRowToLiveStore(recordID RecordID, changed
 			backfillStoreVP.SetDataValue(int(recordID.Index), *changedDataValue, IgnoreCount)
 		}
 	}
+	computeLiveRow(backfillBatch, int(recordID.Index), ctx.derivedColumns, ctx.defaultValues)
 }
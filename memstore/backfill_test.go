@@ -22,7 +22,9 @@ import (
 	memCom "github.com/uber/aresdb/memstore/common"
 	metaCom "github.com/uber/aresdb/metastore/common"
 	metaMocks "github.com/uber/aresdb/metastore/mocks"
+	"github.com/uber/aresdb/query/expr"
 	utilsMocks "github.com/uber/aresdb/utils/mocks"
+	"strconv"
 	"sync"
 )
 
@@ -105,7 +107,7 @@ var _ = ginkgo.Describe("backfill", func() {
 			0, uint32(0), uint32(0)).Return(nil)
 
 		hostMemoryManager = NewHostMemoryManager(m, 1<<32)
-		shard = NewTableShard(tableSchema, m.metaStore, m.diskStore, hostMemoryManager, shardID)
+		shard = NewTableShard(tableSchema, m.metaStore, m.diskStore, hostMemoryManager, shardID, Options{})
 		batch, err := getFactory().ReadArchiveBatch("backfill/backfillBase")
 		Ω(err).Should(BeNil())
 		baseBatch = &ArchiveBatch{
@@ -350,6 +352,40 @@ var _ = ginkgo.Describe("backfill", func() {
 		Ω(forkedColumn).Should(Equal(backfillCtx.new.Columns[4]))
 	})
 
+	ginkgo.It("winsConflict should resolve conflicts by conflict column", func() {
+		changedPatchRow, err := backfillCtx.getChangedPatchRow(RecordID{0, 1}, upsertBatches[0])
+		Ω(err).Should(BeNil())
+		// the last arrived record wins without conflict column.
+		Ω(backfillCtx.winsConflict(RecordID{0, 2}, changedPatchRow)).Should(BeTrue())
+
+		backfillCtx.conflictColumn = 4
+		baseValue := backfillCtx.new.Columns[4].GetDataValueByRow(2)
+		Ω(baseValue.Valid).Should(BeTrue())
+		baseEventTime := *(*uint32)(baseValue.OtherVal)
+
+		eventTime := func(value uint32) *memCom.DataValue {
+			dataValue, err := memCom.ValueFromString(strconv.Itoa(int(value)), memCom.Uint32)
+			Ω(err).Should(BeNil())
+			dataValue.CmpFunc = memCom.GetCompareFunc(memCom.Uint32)
+			return &dataValue
+		}
+		changedPatchRow[4] = eventTime(baseEventTime - 1)
+		Ω(backfillCtx.winsConflict(RecordID{0, 2}, changedPatchRow)).Should(BeFalse())
+		changedPatchRow[4] = nil
+		Ω(backfillCtx.winsConflict(RecordID{0, 2}, changedPatchRow)).Should(BeFalse())
+		changedPatchRow[4] = eventTime(baseEventTime)
+		Ω(backfillCtx.winsConflict(RecordID{0, 2}, changedPatchRow)).Should(BeTrue())
+
+		// records in temp live store.
+		recordID := backfillCtx.backfillStore.NextWriteRecord
+		backfillCtx.backfillStore.AdvanceNextWriteRecord()
+		backfillCtx.applyChangedRowToLiveStore(recordID, changedPatchRow)
+		changedPatchRow[4] = eventTime(baseEventTime - 1)
+		Ω(backfillCtx.winsConflict(recordID, changedPatchRow)).Should(BeFalse())
+		changedPatchRow[4] = eventTime(baseEventTime + 1)
+		Ω(backfillCtx.winsConflict(recordID, changedPatchRow)).Should(BeTrue())
+	})
+
 	ginkgo.It("apply backfill patch should work", func() {
 		err := backfillCtx.backfill(jobManager.reportBackfillJobDetail, jobKey)
 		Ω(err).Should(BeNil())
@@ -374,6 +410,31 @@ var _ = ginkgo.Describe("backfill", func() {
 		Ω(newBatch.Equals(&backfillCtx.new.Batch)).Should(BeTrue())
 	})
 
+	ginkgo.It("apply backfill patch should compute derived columns", func() {
+		derivedExpr, err := expr.ParseExpr("c0 + 0")
+		Ω(err).Should(BeNil())
+		backfillCtx.derivedColumns = []*derivedColumn{{
+			name:            "c4",
+			columnID:        4,
+			dataType:        memCom.Uint32,
+			expr:            derivedExpr,
+			sourceColumnIDs: map[string]int{"c0": 0},
+			sourceDataTypes: map[int]memCom.DataType{0: memCom.Uint32},
+		}}
+		err = backfillCtx.backfill(jobManager.reportBackfillJobDetail, jobKey)
+		Ω(err).Should(BeNil())
+
+		// values of c4 in base batch and patches are overwritten by c0, for inserted, updated in
+		// place and merged rows.
+		Ω(backfillCtx.new.Size).Should(Equal(6))
+		for row := 0; row < backfillCtx.new.Size; row++ {
+			c0 := backfillCtx.new.Columns[0].GetDataValueByRow(row)
+			c4 := backfillCtx.new.Columns[4].GetDataValueByRow(row)
+			Ω(c4.Valid).Should(BeTrue())
+			Ω(*(*uint32)(c4.OtherVal)).Should(Equal(*(*uint32)(c0.OtherVal)))
+		}
+	})
+
 	ginkgo.It("createArchivingPatch should work", func() {
 		err := backfillCtx.backfill(jobManager.reportBackfillJobDetail, jobKey)
 		Ω(err).Should(BeNil())
@@ -0,0 +1,42 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+// CompressionCodec is the codec used to compress archived vector party files.
+type CompressionCodec uint32
+
+// Supported compression codecs. The codec value is persisted in the header of
+// compressed vector party files, so existing values must never be changed.
+const (
+	NoCompression CompressionCodec = iota
+	GzipCompression
+	UnknownCompression
+)
+
+// StringToCompressionCodec maps table config representation to CompressionCodec.
+var StringToCompressionCodec = map[string]CompressionCodec{
+	"":     NoCompression,
+	"none": NoCompression,
+	"gzip": GzipCompression,
+}
+
+// CompressionCodecFromString converts table config representation of compression codec
+// into CompressionCodec.
+func CompressionCodecFromString(str string) CompressionCodec {
+	if codec, exist := StringToCompressionCodec[str]; exist {
+		return codec
+	}
+	return UnknownCompression
+}
@@ -127,10 +127,11 @@ func DataTypeBits(dataType DataType) int {
 	return int(0x0000FFFF & dataType)
 }
 
-// DataTypeForColumn returns the in memory data type for a column
+// DataTypeForColumn returns the in memory data type for a column. Hll columns and
+// enum array columns are stored as Uint32.
 func DataTypeForColumn(column metaCom.Column) DataType {
 	dataType := DataTypeFromString(column.Type)
-	if column.HLLConfig.IsHLLColumn {
+	if column.HLLConfig.IsHLLColumn || column.IsEnumArrayColumn() {
 		return Uint32
 	}
 	return dataType
@@ -26,4 +26,8 @@ const (
 	SnapshotJobType JobType = "snapshot"
 	// PurgeJobType is the purge job type.
 	PurgeJobType JobType = "purge"
+	// DerivedColumnJobType is the job type backfilling derived columns.
+	DerivedColumnJobType JobType = "derived_column"
+	// RollupJobType is the job type aggregating base tables into rollup tables.
+	RollupJobType JobType = "rollup"
 )
@@ -0,0 +1,53 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"reflect"
+	"unsafe"
+
+	"github.com/uber/aresdb/utils"
+)
+
+// GetShardForDataValue returns the shard of a row in a table hash sharded into numShards given the
+// value of its shard key column. The value is hashed by its in memory bytes, so clients ingesting
+// rows, the shards storing them and queries filtering by the shard key always agree on the shard.
+// Null values belong to shard 0.
+func GetShardForDataValue(value DataValue, dataType DataType, numShards int) int {
+	if numShards <= 1 || !value.Valid || value.OtherVal == nil {
+		return 0
+	}
+	hash := utils.Murmur3Sum32(value.OtherVal, DataTypeBytes(dataType), 0)
+	return int(hash % uint32(numShards))
+}
+
+// GetShardForValue returns the shard for a shard key value not yet converted to the data type of
+// the shard key column.
+func GetShardForValue(value interface{}, dataType DataType, numShards int) (int, error) {
+	if numShards <= 1 || value == nil {
+		return 0, nil
+	}
+	converted, err := ConvertValueForType(dataType, value)
+	if err != nil {
+		return 0, err
+	}
+	ptr := reflect.New(reflect.TypeOf(converted))
+	ptr.Elem().Set(reflect.ValueOf(converted))
+	return GetShardForDataValue(DataValue{
+		Valid:    true,
+		DataType: dataType,
+		OtherVal: unsafe.Pointer(ptr.Pointer()),
+	}, dataType, numShards), nil
+}
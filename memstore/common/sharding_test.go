@@ -0,0 +1,58 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"unsafe"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("sharding", func() {
+	ginkgo.It("assigns rows with the same key to the same shard", func() {
+		shards := make(map[int]bool)
+		for key := uint32(0); key < 1000; key++ {
+			v := key
+			shard := GetShardForDataValue(DataValue{Valid: true, OtherVal: unsafe.Pointer(&v)}, Uint32, 8)
+			Ω(shard).Should(BeNumerically(">=", 0))
+			Ω(shard).Should(BeNumerically("<", 8))
+			shards[shard] = true
+
+			// values sent by clients are converted to the column type before hashing.
+			Ω(GetShardForValue(float64(key), Uint32, 8)).Should(Equal(shard))
+			Ω(GetShardForValue(int(key), Uint32, 8)).Should(Equal(shard))
+		}
+		// keys are spread over all shards.
+		Ω(shards).Should(HaveLen(8))
+
+		uuid := "0123456789abcdef0123456789abcdef"
+		shard, err := GetShardForValue(uuid, UUID, 8)
+		Ω(err).Should(BeNil())
+		value, err := ValueFromString(uuid, UUID)
+		Ω(err).Should(BeNil())
+		Ω(GetShardForDataValue(value, UUID, 8)).Should(Equal(shard))
+	})
+
+	ginkgo.It("assigns nulls and unsharded tables to shard 0", func() {
+		Ω(GetShardForDataValue(NullDataValue, Uint32, 8)).Should(Equal(0))
+		Ω(GetShardForValue(nil, Uint32, 8)).Should(Equal(0))
+		Ω(GetShardForValue(12345, Uint32, 0)).Should(Equal(0))
+		Ω(GetShardForValue(12345, Uint32, 1)).Should(Equal(0))
+
+		_, err := GetShardForValue("abc", Uint32, 8)
+		Ω(err).ShouldNot(BeNil())
+	})
+})
@@ -0,0 +1,365 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"math"
+	"reflect"
+	"unsafe"
+
+	memCom "github.com/uber/aresdb/memstore/common"
+	"github.com/uber/aresdb/query/expr"
+	"github.com/uber/aresdb/utils"
+)
+
+// defaultDerivedColumnBatchesPerRun is the number of archive batches backfilled by each run of
+// the derived column job if not configured for the table.
+const defaultDerivedColumnBatchesPerRun = 10
+
+// derivedColumn computes the value of a derived column from the source columns of the same row.
+type derivedColumn struct {
+	name     string
+	columnID int
+	dataType memCom.DataType
+	expr     expr.Expr
+	// ids of source columns by name.
+	sourceColumnIDs map[string]int
+	// data types of source columns by id.
+	sourceDataTypes map[int]memCom.DataType
+}
+
+// newDerivedColumn creates the derived column with the expression already validated by metaStore.
+// Caller should hold the schema lock.
+func newDerivedColumn(t *TableSchema, columnID int) *derivedColumn {
+	column := t.Schema.Columns[columnID]
+	derivedExpr, err := expr.ParseExpr(column.DerivedExpr)
+	if err != nil {
+		// Should not happen since the expression is validated by metaStore.
+		utils.GetLogger().With(
+			"table", t.Schema.Name,
+			"column", column.Name,
+			"expr", column.DerivedExpr,
+		).Panic("Cannot parse derived column expression")
+	}
+
+	c := &derivedColumn{
+		name:            column.Name,
+		columnID:        columnID,
+		dataType:        t.ValueTypeByColumn[columnID],
+		expr:            derivedExpr,
+		sourceColumnIDs: make(map[string]int),
+		sourceDataTypes: make(map[int]memCom.DataType),
+	}
+	expr.WalkFunc(derivedExpr, func(e expr.Expr) {
+		if varRef, ok := e.(*expr.VarRef); ok {
+			sourceColumnID := t.ColumnIDs[varRef.Val]
+			c.sourceColumnIDs[varRef.Val] = sourceColumnID
+			c.sourceDataTypes[sourceColumnID] = t.ValueTypeByColumn[sourceColumnID]
+		}
+	})
+	return c
+}
+
+// compute returns the value of the derived column given the source values of the row. The value
+// is null if any source value is null, the divisor is zero or the result overflows the data type.
+func (c *derivedColumn) compute(getValue func(columnID int) memCom.DataValue) memCom.DataValue {
+	result, ok := c.eval(c.expr, getValue)
+	if !ok {
+		return memCom.NullDataValue
+	}
+
+	var value interface{}
+	var err error
+	if c.dataType == memCom.Float32 {
+		value, err = memCom.ConvertValueForType(c.dataType, result)
+	} else {
+		// Integer results are truncated toward zero.
+		if result < math.MinInt64 || result > math.MaxInt64 {
+			return memCom.NullDataValue
+		}
+		value, err = memCom.ConvertValueForType(c.dataType, int64(result))
+	}
+	if err != nil {
+		return memCom.NullDataValue
+	}
+
+	ptr := reflect.New(reflect.TypeOf(value))
+	ptr.Elem().Set(reflect.ValueOf(value))
+	return memCom.DataValue{
+		Valid:    true,
+		DataType: c.dataType,
+		OtherVal: unsafe.Pointer(ptr.Pointer()),
+		CmpFunc:  memCom.GetCompareFunc(c.dataType),
+	}
+}
+
+func (c *derivedColumn) eval(e expr.Expr, getValue func(columnID int) memCom.DataValue) (float64, bool) {
+	switch e := e.(type) {
+	case *expr.ParenExpr:
+		return c.eval(e.Expr, getValue)
+	case *expr.NumberLiteral:
+		return e.Val, true
+	case *expr.VarRef:
+		columnID := c.sourceColumnIDs[e.Val]
+		return memCom.ConvertToFloat64(getValue(columnID).ConvertToHumanReadable(c.sourceDataTypes[columnID]))
+	case *expr.UnaryExpr:
+		value, ok := c.eval(e.Expr, getValue)
+		return -value, ok
+	case *expr.BinaryExpr:
+		lhs, ok := c.eval(e.LHS, getValue)
+		if !ok {
+			return 0, false
+		}
+		rhs, ok := c.eval(e.RHS, getValue)
+		if !ok {
+			return 0, false
+		}
+		switch e.Op {
+		case expr.ADD:
+			return lhs + rhs, true
+		case expr.SUB:
+			return lhs - rhs, true
+		case expr.MUL:
+			return lhs * rhs, true
+		case expr.DIV:
+			if rhs == 0 {
+				return 0, false
+			}
+			return lhs / rhs, true
+		}
+	}
+	return 0, false
+}
+
+// computeLiveRow computes the derived columns for the row of the live batch. Caller should lock
+// the batch for write, or for read if vector parties of the derived columns are already created.
+func computeLiveRow(batch *LiveBatch, row int, derivedColumns []*derivedColumn, defaultValues []*memCom.DataValue) {
+	for _, column := range derivedColumns {
+		value := column.compute(func(columnID int) memCom.DataValue {
+			return batch.GetDataValueWithDefault(row, columnID, *defaultValues[columnID])
+		})
+		batch.GetOrCreateVectorParty(column.columnID, true).SetDataValue(row, value, IgnoreCount)
+	}
+}
+
+// BackfillDerivedColumns backfills the derived columns of the table shard one after another.
+// On the first run of a column it computes the column for all rows in the live store and records
+// the last archive batch to backfill in metaStore, then each run backfills a limited number of
+// archive batches in place and advances the progress in metaStore so that it resumes from the
+// next batch after restart. Rows ingested after the column is added are computed on ingestion.
+func (m *memStoreImpl) BackfillDerivedColumns(tableName string, shardID int, reporter DerivedColumnJobDetailReporter) error {
+	start := utils.Now()
+	jobKey := getIdentifier(tableName, shardID, memCom.DerivedColumnJobType)
+	derivedColumnTimer := utils.GetReporter(tableName, shardID).GetTimer(utils.DerivedColumnTimingTotal)
+	defer func() {
+		duration := utils.Now().Sub(start)
+		derivedColumnTimer.Record(duration)
+		reporter(jobKey, func(status *DerivedColumnJobDetail) {
+			status.LastDuration = duration
+		})
+	}()
+
+	shard, err := m.GetTableShard(tableName, shardID)
+	if err != nil {
+		return err
+	}
+	defer shard.Users.Done()
+
+	// Block column deletion.
+	shard.columnDeletion.Lock()
+	defer shard.columnDeletion.Unlock()
+
+	shard.Schema.RLock()
+	derivedColumns := shard.Schema.derivedColumns
+	defaultValues := shard.Schema.DefaultValues
+	batchesPerRun := shard.Schema.Schema.Config.DerivedColumnBatchesPerRun
+	shard.Schema.RUnlock()
+
+	if batchesPerRun <= 0 {
+		batchesPerRun = defaultDerivedColumnBatchesPerRun
+	}
+
+	for _, column := range derivedColumns {
+		numBatches, err := shard.backfillDerivedColumn(column, defaultValues, batchesPerRun, reporter, jobKey)
+		if err != nil {
+			return err
+		}
+		if batchesPerRun -= numBatches; batchesPerRun <= 0 {
+			break
+		}
+	}
+	return nil
+}
+
+// backfillDerivedColumn backfills at most maxBatches archive batches of the derived column and
+// returns the number of archive batches backfilled.
+func (shard *TableShard) backfillDerivedColumn(column *derivedColumn, defaultValues []*memCom.DataValue, maxBatches int,
+	reporter DerivedColumnJobDetailReporter, jobKey string) (int, error) {
+	tableName := shard.Schema.Schema.Name
+	lastBatchID, endBatchID, err := shard.metaStore.GetDerivedColumnProgress(tableName, column.name, shard.ShardID)
+	if err != nil {
+		return 0, err
+	}
+
+	if endBatchID < 0 {
+		reporter(jobKey, func(status *DerivedColumnJobDetail) {
+			status.Stage = DerivedColumnLiveStore
+			status.Column = column.name
+		})
+
+		// Rows archived afterwards are merged from live batches already computed, so only
+		// archive batches up to the current cutoff need backfill.
+		archiveStore := shard.ArchiveStore.GetCurrentVersion()
+		endBatchID = int(archiveStore.ArchivingCutoff / 86400)
+		archiveStore.Users.Done()
+
+		batchIDs, numRecordsInLastBatch := shard.LiveStore.GetBatchIDs()
+		for i, batchID := range batchIDs {
+			batch := shard.LiveStore.GetBatchForWrite(batchID)
+			if batch == nil {
+				continue
+			}
+			numRecords := batch.Capacity
+			if i == len(batchIDs)-1 {
+				numRecords = numRecordsInLastBatch
+			}
+			for row := 0; row < numRecords; row++ {
+				computeLiveRow(batch, row, []*derivedColumn{column}, defaultValues)
+			}
+			batch.Unlock()
+		}
+
+		if err = shard.metaStore.UpdateDerivedColumnProgress(tableName, column.name, shard.ShardID, lastBatchID, endBatchID); err != nil {
+			return 0, err
+		}
+	}
+
+	if lastBatchID >= endBatchID {
+		return 0, nil
+	}
+
+	archiveBatchIDs, err := shard.metaStore.GetArchiveBatchIDs(tableName, shard.ShardID)
+	if err != nil {
+		return 0, err
+	}
+
+	var batchIDsToBackfill []int
+	for _, batchID := range archiveBatchIDs {
+		if batchID > lastBatchID && batchID <= endBatchID {
+			batchIDsToBackfill = append(batchIDsToBackfill, batchID)
+		}
+	}
+	complete := len(batchIDsToBackfill) <= maxBatches
+	if !complete {
+		batchIDsToBackfill = batchIDsToBackfill[:maxBatches]
+	}
+
+	reporter(jobKey, func(status *DerivedColumnJobDetail) {
+		status.Stage = DerivedColumnArchiveStore
+		status.Column = column.name
+		status.LastBatchID = lastBatchID
+		status.EndBatchID = endBatchID
+		status.Current = 0
+		status.Total = len(batchIDsToBackfill)
+	})
+
+	for i, batchID := range batchIDsToBackfill {
+		if err = shard.backfillDerivedColumnForArchiveBatch(column, int32(batchID)); err != nil {
+			return i, err
+		}
+
+		if err = shard.metaStore.UpdateDerivedColumnProgress(tableName, column.name, shard.ShardID, batchID, endBatchID); err != nil {
+			return i, err
+		}
+		utils.GetReporter(tableName, shard.ShardID).GetCounter(utils.DerivedColumnBackfilledBatches).Inc(1)
+		reporter(jobKey, func(status *DerivedColumnJobDetail) {
+			status.Current = i + 1
+			status.LastBatchID = batchID
+		})
+	}
+
+	if complete {
+		if err = shard.metaStore.UpdateDerivedColumnProgress(tableName, column.name, shard.ShardID, endBatchID, endBatchID); err != nil {
+			return len(batchIDsToBackfill), err
+		}
+		reporter(jobKey, func(status *DerivedColumnJobDetail) {
+			status.Stage = DerivedColumnComplete
+			status.LastBatchID = endBatchID
+		})
+	}
+	return len(batchIDsToBackfill), nil
+}
+
+// backfillDerivedColumnForArchiveBatch computes the derived column for all rows of the archive
+// batch from its source columns, overwrites the vector party file of the current batch version
+// on disk and replaces the vector party in memory.
+func (shard *TableShard) backfillDerivedColumnForArchiveBatch(column *derivedColumn, batchID int32) error {
+	archiveStore := shard.ArchiveStore.GetCurrentVersion()
+	defer archiveStore.Users.Done()
+
+	batch := archiveStore.RequestBatch(batchID)
+	if batch.Size == 0 {
+		return nil
+	}
+
+	sourceVPs := make(map[int]memCom.ArchiveVectorParty, len(column.sourceDataTypes))
+	var requestedVPs []memCom.ArchiveVectorParty
+	for columnID := range column.sourceDataTypes {
+		vp := batch.RequestVectorParty(columnID)
+		vp.WaitForDiskLoad()
+		sourceVPs[columnID] = vp
+		requestedVPs = append(requestedVPs, vp)
+	}
+	defer UnpinVectorParties(requestedVPs)
+
+	bytes := int64(CalculateVectorPartyBytes(column.dataType, batch.Size, true, false))
+	shard.HostMemoryManager.ReportUnmanagedSpaceUsageChange(bytes)
+	defer shard.HostMemoryManager.ReportUnmanagedSpaceUsageChange(-bytes)
+
+	vp := newArchiveVectorParty(batch.Size, column.dataType, memCom.NullDataValue, batch.RWMutex)
+	vp.Allocate(false)
+	for row := 0; row < batch.Size; row++ {
+		value := column.compute(func(columnID int) memCom.DataValue {
+			return sourceVPs[columnID].GetDataValueByRow(row)
+		})
+		vp.SetDataValue(row, value, IncrementCount)
+	}
+	vp.Prune()
+
+	// Hold the existing vector party during the write so that it will not be loaded from the
+	// file being overwritten.
+	existingVP := batch.RequestVectorParty(column.columnID)
+	existingVP.WaitForDiskLoad()
+
+	compression := memCom.CompressionCodecFromString(shard.Schema.Schema.Config.ArchiveCompression)
+	serializer := NewVectorPartyArchiveSerializer(shard.HostMemoryManager, shard.diskStore, shard.Schema.Schema.Name,
+		shard.ShardID, column.columnID, int(batchID), batch.Version, batch.SeqNum, compression)
+	err := serializer.WriteVectorParty(vp)
+	existingVP.Release()
+	if err != nil {
+		vp.SafeDestruct()
+		return err
+	}
+
+	batch.Lock()
+	if existing := batch.Columns[column.columnID]; existing != nil {
+		existing.(memCom.ArchiveVectorParty).WaitForUsers(true)
+		existing.SafeDestruct()
+	}
+	batch.Columns[column.columnID] = vp
+	batch.Unlock()
+	shard.HostMemoryManager.ReportManagedObject(shard.Schema.Schema.Name, shard.ShardID, int(batchID), column.columnID, vp.GetBytes())
+	return nil
+}
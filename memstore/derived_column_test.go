@@ -0,0 +1,213 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"unsafe"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	diskStoreMocks "github.com/uber/aresdb/diskstore/mocks"
+	"github.com/uber/aresdb/memstore/common"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	metaStoreMocks "github.com/uber/aresdb/metastore/mocks"
+	"github.com/uber/aresdb/utils"
+	utilsMocks "github.com/uber/aresdb/utils/mocks"
+)
+
+var _ = ginkgo.Describe("derived column", func() {
+	uint32Value := func(value common.DataValue) interface{} {
+		if !value.Valid {
+			return nil
+		}
+		return *(*uint32)(value.OtherVal)
+	}
+
+	ginkgo.It("computes derived values", func() {
+		schema := NewTableSchema(&metaCom.Table{
+			Name: "test",
+			Columns: []metaCom.Column{
+				{Name: "a", Type: metaCom.Int32},
+				{Name: "b", Type: metaCom.Float32},
+				{Name: "div", Type: metaCom.Int32, DerivedExpr: "-(a + 1) / b"},
+				{Name: "ratio", Type: metaCom.Float32, DerivedExpr: "a / b"},
+				{Name: "small", Type: metaCom.Uint8, DerivedExpr: "a * 100"},
+			},
+		})
+		Ω(schema.derivedColumns).Should(HaveLen(3))
+
+		values := map[int]common.DataValue{}
+		getValue := func(columnID int) common.DataValue {
+			return values[columnID]
+		}
+		a, b := int32(6), float32(4)
+		values[0] = common.DataValue{Valid: true, OtherVal: unsafe.Pointer(&a)}
+		values[1] = common.DataValue{Valid: true, OtherVal: unsafe.Pointer(&b)}
+
+		value := schema.derivedColumns[0].compute(getValue)
+		Ω(value.Valid).Should(BeTrue())
+		// truncated toward zero.
+		Ω(*(*int32)(value.OtherVal)).Should(Equal(int32(-1)))
+		value = schema.derivedColumns[1].compute(getValue)
+		Ω(value.Valid).Should(BeTrue())
+		Ω(*(*float32)(value.OtherVal)).Should(Equal(float32(1.5)))
+		// overflow yields null.
+		Ω(schema.derivedColumns[2].compute(getValue).Valid).Should(BeFalse())
+
+		// dividing by zero yields null.
+		b = 0
+		Ω(schema.derivedColumns[1].compute(getValue).Valid).Should(BeFalse())
+
+		// null source yields null.
+		values[1] = common.NullDataValue
+		Ω(schema.derivedColumns[0].compute(getValue).Valid).Should(BeFalse())
+	})
+
+	ginkgo.It("computes derived columns on ingestion", func() {
+		memStore := createMemStore("abc", 0, []common.DataType{common.Uint32, common.Uint32, common.Uint32}, []int{0}, 10, false, false, nil, CreateMockDiskStore())
+		schema := memStore.TableSchemas["abc"]
+		table := schema.Schema
+		table.Columns[0].Name = "id"
+		table.Columns[1].Name = "clicks"
+		table.Columns[2].Name = "score"
+		table.Columns[2].DerivedExpr = "clicks * 2 + id"
+		schema.SetTable(&table)
+
+		builder := common.NewUpsertBatchBuilder()
+		builder.AddColumn(0, common.Uint32)
+		builder.AddColumn(1, common.Uint32)
+		builder.AddRow()
+		builder.SetValue(0, 0, uint32(1))
+		builder.SetValue(0, 1, uint32(10))
+		builder.AddRow()
+		builder.SetValue(1, 0, uint32(2))
+		buffer, _ := builder.ToByteArray()
+		upsertBatch, _ := NewUpsertBatch(buffer)
+		Ω(memStore.HandleIngestion("abc", 0, upsertBatch)).Should(BeNil())
+
+		shard, _ := memStore.GetTableShard("abc", 0)
+		value, valid := ReadShardValue(shard, 2, []byte{1, 0, 0, 0})
+		Ω(valid).Should(BeTrue())
+		Ω(*(*uint32)(value)).Should(Equal(uint32(21)))
+		_, valid = ReadShardValue(shard, 2, []byte{2, 0, 0, 0})
+		Ω(valid).Should(BeFalse())
+
+		// updates recompute the derived column.
+		builder = common.NewUpsertBatchBuilder()
+		builder.AddColumn(0, common.Uint32)
+		builder.AddColumn(1, common.Uint32)
+		builder.AddRow()
+		builder.SetValue(0, 0, uint32(1))
+		builder.SetValue(0, 1, uint32(20))
+		buffer, _ = builder.ToByteArray()
+		upsertBatch, _ = NewUpsertBatch(buffer)
+		Ω(memStore.HandleIngestion("abc", 0, upsertBatch)).Should(BeNil())
+		value, valid = ReadShardValue(shard, 2, []byte{1, 0, 0, 0})
+		Ω(valid).Should(BeTrue())
+		Ω(*(*uint32)(value)).Should(Equal(uint32(41)))
+	})
+
+	ginkgo.It("backfills live and archive batches", func() {
+		metaStore := &metaStoreMocks.MetaStore{}
+		diskStore := &diskStoreMocks.DiskStore{}
+		tableSchema := NewTableSchema(&metaCom.Table{
+			Name: "test",
+			Columns: []metaCom.Column{
+				{Name: "c0", Type: metaCom.Uint32},
+				{Name: "c1", Type: metaCom.Bool},
+				{Name: "c2", Type: metaCom.Float32},
+				{Name: "c3", Type: metaCom.Uint32, DerivedExpr: "c0 * 2 + c2"},
+			},
+			IsFactTable: true,
+			Config: metaCom.TableConfig{
+				BatchSize:                  10,
+				DerivedColumnBatchesPerRun: 1,
+			},
+		})
+		for columnID := range tableSchema.Schema.Columns {
+			tableSchema.SetDefaultValue(columnID)
+		}
+
+		memStore := NewMemStore(metaStore, diskStore, Options{}).(*memStoreImpl)
+		tableShard := NewTableShard(tableSchema, metaStore, diskStore, NewHostMemoryManager(memStore, 1<<32), 0, Options{})
+		memStore.TableShards["test"] = map[int]*TableShard{0: tableShard}
+		memStore.TableSchemas["test"] = tableSchema
+
+		// live rows ingested before the derived column was added.
+		liveBatch := tableShard.LiveStore.getOrCreateBatch(BaseBatchID)
+		c0, c2 := uint32(50), float32(0.5)
+		liveBatch.GetOrCreateVectorParty(0, true).SetDataValue(0, common.DataValue{Valid: true, OtherVal: unsafe.Pointer(&c0)}, IgnoreCount)
+		liveBatch.GetOrCreateVectorParty(2, true).SetDataValue(0, common.DataValue{Valid: true, OtherVal: unsafe.Pointer(&c2)}, IgnoreCount)
+		liveBatch.Unlock()
+		tableShard.LiveStore.LastReadRecord = RecordID{BatchID: BaseBatchID, Index: 1}
+
+		// archive batches 1 and 2 with c0 [0, 10, 20, 30, 40] and c2 [null, 1.1, 1.2, 1.3, null].
+		tableShard.ArchiveStore.CurrentVersion = NewArchiveStoreVersion(86400*2, tableShard)
+		for batchID := int32(1); batchID <= 2; batchID++ {
+			archiveBatch, err := testFactory.ReadArchiveBatch("archiving/archiveBatch0")
+			Ω(err).Should(BeNil())
+			tableShard.ArchiveStore.CurrentVersion.Batches[batchID] = &ArchiveBatch{
+				Batch:   *archiveBatch,
+				Size:    5,
+				Version: 86400 * 2,
+				BatchID: batchID,
+				Shard:   tableShard,
+			}
+		}
+
+		writer := new(utilsMocks.WriteCloser)
+		writer.On("Write", mock.Anything).Return(0, nil)
+		writer.On("Close").Return(nil)
+		diskStore.On("OpenVectorPartyFileForRead", "test", 3, 0, mock.Anything, uint32(86400*2), uint32(0)).Return(nil, nil)
+		diskStore.On("OpenVectorPartyFileForWrite", "test", 3, 0, mock.Anything, uint32(86400*2), uint32(0)).Return(writer, nil)
+		metaStore.On("GetArchiveBatchIDs", "test", 0).Return([]int{1, 2}, nil)
+		metaStore.On("GetDerivedColumnProgress", "test", "c3", 0).Return(-1, -1, nil).Once()
+		metaStore.On("UpdateDerivedColumnProgress", "test", "c3", 0, mock.Anything, mock.Anything).Return(nil)
+
+		jobDetail := &DerivedColumnJobDetail{}
+		reporter := func(key string, mutator DerivedColumnJobDetailMutator) {
+			mutator(jobDetail)
+		}
+		Ω(memStore.BackfillDerivedColumns("test", 0, reporter)).Should(BeNil())
+		metaStore.AssertCalled(utils.TestingT, "UpdateDerivedColumnProgress", "test", "c3", 0, -1, 2)
+		metaStore.AssertCalled(utils.TestingT, "UpdateDerivedColumnProgress", "test", "c3", 0, 1, 2)
+		Ω(jobDetail.Stage).Should(Equal(DerivedColumnArchiveStore))
+		Ω(jobDetail.LastBatchID).Should(Equal(1))
+
+		liveVP := liveBatch.GetVectorParty(3)
+		Ω(liveVP).ShouldNot(BeNil())
+		Ω(uint32Value(liveVP.GetDataValue(0))).Should(Equal(uint32(100)))
+		Ω(uint32Value(liveVP.GetDataValue(1))).Should(BeNil())
+
+		archiveValues := func(batchID int32) []interface{} {
+			vp := tableShard.ArchiveStore.CurrentVersion.Batches[batchID].Columns[3]
+			var values []interface{}
+			for row := 0; row < 5; row++ {
+				values = append(values, uint32Value(vp.GetDataValueByRow(row)))
+			}
+			return values
+		}
+		Ω(archiveValues(1)).Should(Equal([]interface{}{nil, uint32(21), uint32(41), uint32(61), nil}))
+		Ω(tableShard.ArchiveStore.CurrentVersion.Batches[2].Columns).Should(HaveLen(3))
+
+		// the next run resumes from the next batch.
+		metaStore.On("GetDerivedColumnProgress", "test", "c3", 0).Return(1, 2, nil).Once()
+		Ω(memStore.BackfillDerivedColumns("test", 0, reporter)).Should(BeNil())
+		metaStore.AssertCalled(utils.TestingT, "UpdateDerivedColumnProgress", "test", "c3", 0, 2, 2)
+		Ω(jobDetail.Stage).Should(Equal(DerivedColumnComplete))
+		Ω(archiveValues(2)).Should(Equal([]interface{}{nil, uint32(21), uint32(41), uint32(61), nil}))
+	})
+})
@@ -0,0 +1,387 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	aresCommon "github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/memstore/common"
+	"github.com/uber/aresdb/utils"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// ErrExportOutputNotAllowed is returned by ExportTable if the output path is neither under the
+// configured base directory nor under one of the configured url prefixes.
+var ErrExportOutputNotAllowed = errors.New("export output path is not allowed")
+
+// defaultExportUploadTimeout is the timeout of each upload request if not configured.
+const defaultExportUploadTimeout = 60 * time.Second
+
+// ExportTableResult is the result of exporting archived data of a table.
+type ExportTableResult struct {
+	// Paths of the files written relative to the output path.
+	Files []string `json:"files"`
+	// Number of rows exported from all shards.
+	NumRows int `json:"numRows"`
+}
+
+// parquetColumn maps a table column to a column of the exported Parquet files.
+type parquetColumn struct {
+	columnID int
+	dataType common.DataType
+	// Parquet metadata of the column in the format of parquet-go csv writer.
+	metadata string
+	// enum cases of enum and enum array columns.
+	enumCases []string
+	enumArray bool
+}
+
+// newParquetColumn creates the Parquet column for the column, caller should hold the schema lock.
+// Integers are stored as INT32 or INT64 annotated with their width and signedness, enums,
+// enum arrays, UUIDs and geo columns are stored as UTF8 strings.
+func newParquetColumn(schema *TableSchema, columnID int) parquetColumn {
+	column := schema.Schema.Columns[columnID]
+	c := parquetColumn{
+		columnID:  columnID,
+		dataType:  schema.ValueTypeByColumn[columnID],
+		enumArray: column.IsEnumArrayColumn(),
+	}
+	if column.IsEnumColumn() {
+		c.enumCases = schema.EnumDicts[column.Name].ReverseDict
+	}
+
+	parquetType := "UTF8"
+	if !c.enumArray {
+		switch c.dataType {
+		case common.Bool:
+			parquetType = "BOOLEAN"
+		case common.Int8:
+			parquetType = "INT_8"
+		case common.Uint8:
+			parquetType = "UINT_8"
+		case common.Int16:
+			parquetType = "INT_16"
+		case common.Uint16:
+			parquetType = "UINT_16"
+		case common.Int32:
+			parquetType = "INT32"
+		case common.Uint32, common.Int64:
+			parquetType = "INT64"
+		case common.Float32:
+			parquetType = "FLOAT"
+		}
+	}
+	c.metadata = fmt.Sprintf("name=%s, type=%s", column.Name, parquetType)
+	return c
+}
+
+// convert converts the value of the column into the Parquet value, nil if the value is null.
+func (c parquetColumn) convert(value common.DataValue) interface{} {
+	if !value.Valid {
+		return nil
+	}
+
+	if c.enumArray {
+		bitmap := *(*uint32)(value.OtherVal)
+		enumCases := []string{}
+		for enumID := 0; bitmap != 0; enumID++ {
+			if bitmap&1 != 0 {
+				enumCases = append(enumCases, c.enumCase(enumID))
+			}
+			bitmap >>= 1
+		}
+		enumCasesJSON, _ := json.Marshal(enumCases)
+		return string(enumCasesJSON)
+	}
+
+	switch c.dataType {
+	case common.GeoPoint:
+		lng, lat := formatLngLat(*(*[2]float32)(value.OtherVal))
+		return fmt.Sprintf("Point(%s,%s)", lng, lat)
+	case common.GeoShape:
+		shape, ok := value.GoVal.(*common.GeoShapeGo)
+		if !ok {
+			return nil
+		}
+		polygons := make([]string, len(shape.Polygons))
+		for i, points := range shape.Polygons {
+			pointStrs := make([]string, len(points))
+			for j, point := range points {
+				lng, lat := formatLngLat(point)
+				pointStrs[j] = fmt.Sprintf("%s+%s", lng, lat)
+			}
+			polygons[i] = fmt.Sprintf("(%s)", strings.Join(pointStrs, ","))
+		}
+		return fmt.Sprintf("Polygon(%s)", strings.Join(polygons, ","))
+	}
+
+	switch v := value.ConvertToHumanReadable(c.dataType).(type) {
+	case int8:
+		return int32(v)
+	case uint8:
+		if c.dataType == common.SmallEnum {
+			return c.enumCase(int(v))
+		}
+		return int32(v)
+	case int16:
+		return int32(v)
+	case uint16:
+		if c.dataType == common.BigEnum {
+			return c.enumCase(int(v))
+		}
+		return int32(v)
+	case uint32:
+		// stored as INT64 so that readers ignoring the UINT_32 annotation do not see negative values.
+		return int64(v)
+	default:
+		return v
+	}
+}
+
+// enumCase returns the enum case of the enum id, it is empty if the enum case has not arrived
+// in memory yet.
+func (c parquetColumn) enumCase(enumID int) string {
+	if enumID < len(c.enumCases) {
+		return c.enumCases[enumID]
+	}
+	return ""
+}
+
+// formatLngLat formats the longitude and latitude of the geo point stored in lat, lng order
+// with the full precision.
+func formatLngLat(point [2]float32) (lng, lat string) {
+	return strconv.FormatFloat(float64(point[1]), 'g', -1, 32), strconv.FormatFloat(float64(point[0]), 'g', -1, 32)
+}
+
+// exportSink stores an exported file under the path relative to the output path.
+type exportSink func(path string, data []byte) error
+
+// newExportSink returns the sink of the output path. Files are uploaded with PUT requests
+// if the output path is an http(s) url under one of the configured url prefixes, e.g. of an
+// object store bucket, and are written under the output directory relative to the configured
+// base directory otherwise.
+func newExportSink(output string, config aresCommon.ExportConfig) (exportSink, error) {
+	if strings.HasPrefix(output, "http://") || strings.HasPrefix(output, "https://") {
+		if !isExportURLAllowed(output, config.URLPrefixes) {
+			return nil, ErrExportOutputNotAllowed
+		}
+		timeout := time.Duration(config.UploadTimeoutInSeconds) * time.Second
+		if timeout <= 0 {
+			timeout = defaultExportUploadTimeout
+		}
+		client := &http.Client{Timeout: timeout}
+		baseURL := strings.TrimSuffix(output, "/")
+		return func(path string, data []byte) error {
+			request, err := http.NewRequest(http.MethodPut, baseURL+"/"+path, bytes.NewReader(data))
+			if err != nil {
+				return utils.StackError(err, "Failed to create request to upload %s", path)
+			}
+			response, err := client.Do(request)
+			if err != nil {
+				return utils.StackError(err, "Failed to upload %s", path)
+			}
+			defer response.Body.Close()
+			if response.StatusCode/100 != 2 {
+				return utils.StackError(nil, "Failed to upload %s, status: %s", path, response.Status)
+			}
+			return nil
+		}, nil
+	}
+
+	if config.BaseDir == "" || filepath.IsAbs(output) {
+		return nil, ErrExportOutputNotAllowed
+	}
+	outputDir := filepath.Join(config.BaseDir, output)
+	if relPath, err := filepath.Rel(config.BaseDir, outputDir); err != nil ||
+		relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return nil, ErrExportOutputNotAllowed
+	}
+	return func(path string, data []byte) error {
+		filePath := filepath.Join(outputDir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return utils.StackError(err, "Failed to create directory for %s", filePath)
+		}
+		if err := ioutil.WriteFile(filePath, data, 0644); err != nil {
+			return utils.StackError(err, "Failed to write %s", filePath)
+		}
+		return nil
+	}, nil
+}
+
+// isExportURLAllowed tells whether the url is one of the prefixes or under one of them.
+func isExportURLAllowed(url string, prefixes []string) bool {
+	for _, segment := range strings.Split(url, "/") {
+		if segment == ".." {
+			return false
+		}
+	}
+	url = strings.TrimSuffix(url, "/")
+	for _, prefix := range prefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if prefix != "" && (url == prefix || strings.HasPrefix(url, prefix+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExportTable writes the archived rows of the fact table with event time in [from, to) to
+// Parquet files under the output path, which is a local directory relative to the base directory
+// of the config or an http(s) url under one of the url prefixes of the config accepting PUT requests. Files are chunked by shard and archive batch, and are named
+// <table>/date=<yyyy-mm-dd>/<shard>.parquet after the day of the archive batch.
+// Rows not archived yet are not exported.
+func (m *memStoreImpl) ExportTable(table string, from, to uint32, output string,
+	config aresCommon.ExportConfig) (result ExportTableResult, err error) {
+	schema, err := m.GetSchema(table)
+	if err != nil {
+		return result, err
+	}
+
+	var columns []parquetColumn
+	schema.RLock()
+	isFactTable := schema.Schema.IsFactTable
+	for columnID, column := range schema.Schema.Columns {
+		if !column.Deleted {
+			columns = append(columns, newParquetColumn(schema, columnID))
+		}
+	}
+	schema.RUnlock()
+	if !isFactTable {
+		return result, utils.StackError(nil, "Table %s is not a fact table", table)
+	}
+
+	m.RLock()
+	var shardIDs []int
+	for shardID := range m.TableShards[table] {
+		shardIDs = append(shardIDs, shardID)
+	}
+	m.RUnlock()
+	sort.Ints(shardIDs)
+
+	sink, err := newExportSink(output, config)
+	if err != nil {
+		return result, err
+	}
+	for _, shardID := range shardIDs {
+		shard, err := m.GetTableShard(table, shardID)
+		if err != nil {
+			return result, err
+		}
+		err = shard.exportArchiveBatches(columns, from, to, sink, &result)
+		shard.Users.Done()
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// exportArchiveBatches exports the rows of archive batches of the shard in [from, to).
+func (shard *TableShard) exportArchiveBatches(columns []parquetColumn, from, to uint32, sink exportSink,
+	result *ExportTableResult) error {
+	tableName := shard.Schema.Schema.Name
+	batchIDs, err := shard.metaStore.GetArchiveBatchIDs(tableName, shard.ShardID)
+	if err != nil {
+		return err
+	}
+
+	for _, batchID := range batchIDs {
+		if uint32(batchID+1)*86400 <= from || uint32(batchID)*86400 >= to {
+			continue
+		}
+		data, numRows, err := shard.exportArchiveBatch(columns, int32(batchID), from, to)
+		if err != nil {
+			return err
+		}
+		if numRows == 0 {
+			continue
+		}
+
+		path := fmt.Sprintf("%s/date=%s/%d.parquet", tableName,
+			time.Unix(int64(batchID)*86400, 0).UTC().Format("2006-01-02"), shard.ShardID)
+		if err = sink(path, data); err != nil {
+			return err
+		}
+		result.Files = append(result.Files, path)
+		result.NumRows += numRows
+	}
+	return nil
+}
+
+// exportArchiveBatch returns the Parquet file of the archive batch rows in [from, to) and
+// the number of rows in it.
+func (shard *TableShard) exportArchiveBatch(columns []parquetColumn, batchID int32, from, to uint32) (
+	[]byte, int, error) {
+	archiveStore := shard.ArchiveStore.GetCurrentVersion()
+	defer archiveStore.Users.Done()
+
+	batch := archiveStore.RequestBatch(batchID)
+	if batch.Size == 0 {
+		return nil, 0, nil
+	}
+
+	vps := make([]common.ArchiveVectorParty, len(columns))
+	for i, column := range columns {
+		vps[i] = batch.RequestVectorParty(column.columnID)
+		vps[i].WaitForDiskLoad()
+	}
+	defer UnpinVectorParties(vps)
+
+	metadata := make([]string, len(columns))
+	for i, column := range columns {
+		metadata[i] = column.metadata
+	}
+	var buffer bytes.Buffer
+	parquetWriter, err := writer.NewCSVWriterFromWriter(metadata, &buffer, 1)
+	if err != nil {
+		return nil, 0, utils.StackError(err, "Failed to create parquet writer")
+	}
+
+	var numRows int
+	// the event time column can not be deleted and is always the first column.
+	eventTimeVP := vps[0]
+	for row := 0; row < batch.Size; row++ {
+		eventTime := eventTimeVP.GetDataValueByRow(row)
+		if !eventTime.Valid || *(*uint32)(eventTime.OtherVal) < from || *(*uint32)(eventTime.OtherVal) >= to {
+			continue
+		}
+
+		record := make([]interface{}, len(columns))
+		for i, column := range columns {
+			record[i] = column.convert(vps[i].GetDataValueByRow(row))
+		}
+		if err = parquetWriter.Write(record); err != nil {
+			return nil, 0, utils.StackError(err, "Failed to write row %d of batch %d", row, batchID)
+		}
+		numRows++
+	}
+
+	if err = parquetWriter.WriteStop(); err != nil {
+		return nil, 0, utils.StackError(err, "Failed to write parquet file of batch %d", batchID)
+	}
+	return buffer.Bytes(), numRows, nil
+}
@@ -0,0 +1,237 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	aresCommon "github.com/uber/aresdb/common"
+	diskStoreMocks "github.com/uber/aresdb/diskstore/mocks"
+	"github.com/uber/aresdb/memstore/common"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	metaStoreMocks "github.com/uber/aresdb/metastore/mocks"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+var _ = ginkgo.Describe("export", func() {
+	var memStore *memStoreImpl
+	var outputDir string
+	var config aresCommon.ExportConfig
+
+	columns := []metaCom.Column{
+		{Name: "request_at", Type: metaCom.Uint32},
+		{Name: "completed", Type: metaCom.Bool},
+		{Name: "rating", Type: metaCom.Int8},
+		{Name: "seats", Type: metaCom.Uint8},
+		{Name: "delta", Type: metaCom.Int16},
+		{Name: "distance", Type: metaCom.Uint16},
+		{Name: "duration", Type: metaCom.Int32},
+		{Name: "driver_id", Type: metaCom.Int64},
+		{Name: "fare", Type: metaCom.Float32},
+		{Name: "status", Type: metaCom.SmallEnum},
+		{Name: "city", Type: metaCom.BigEnum},
+		{Name: "uuid", Type: metaCom.UUID},
+		{Name: "pickup", Type: metaCom.GeoPoint},
+		{Name: "tags", Type: metaCom.EnumArray},
+		{Name: "removed", Type: metaCom.Int32, Deleted: true},
+	}
+
+	// archiveBatch creates the archive batch of the rows of string values, empty strings are nulls.
+	archiveBatch := func(shard *TableShard, batchID int32, rows [][]string) *ArchiveBatch {
+		batch := &ArchiveBatch{
+			Batch:   Batch{RWMutex: &sync.RWMutex{}},
+			Size:    len(rows),
+			BatchID: batchID,
+			Shard:   shard,
+		}
+		for columnID := range columns {
+			dataType := shard.Schema.ValueTypeByColumn[columnID]
+			vp := newArchiveVectorParty(len(rows), dataType, common.NullDataValue, batch.RWMutex)
+			vp.Allocate(false)
+			for row, values := range rows {
+				value, err := common.ValueFromString(values[columnID], dataType)
+				Ω(err).Should(BeNil())
+				vp.SetDataValue(row, value, IncrementCount)
+			}
+			vp.Prune()
+			batch.Columns = append(batch.Columns, vp)
+		}
+		return batch
+	}
+
+	ginkgo.BeforeEach(func() {
+		metaStore := &metaStoreMocks.MetaStore{}
+		diskStore := &diskStoreMocks.DiskStore{}
+		tableSchema := NewTableSchema(&metaCom.Table{
+			Name:        "trips",
+			Columns:     columns,
+			IsFactTable: true,
+		})
+		for columnID := range columns {
+			tableSchema.SetDefaultValue(columnID)
+		}
+		tableSchema.createEnumDict("status", []string{"completed", "canceled"})
+		tableSchema.createEnumDict("city", []string{"sf", "la"})
+		tableSchema.createEnumDict("tags", []string{"pool", "airport", "night"})
+
+		memStore = NewMemStore(metaStore, diskStore, Options{}).(*memStoreImpl)
+		shard := NewTableShard(tableSchema, metaStore, diskStore, NewHostMemoryManager(memStore, 1<<32), 0, Options{})
+		memStore.TableShards["trips"] = map[int]*TableShard{0: shard}
+		memStore.TableSchemas["trips"] = tableSchema
+
+		shard.ArchiveStore.CurrentVersion = NewArchiveStoreVersion(86400*3, shard)
+		shard.ArchiveStore.CurrentVersion.Batches[1] = archiveBatch(shard, 1, [][]string{
+			{"86400", "true", "-1", "2", "-3", "4", "-5", "-6", "7.5", "0", "1",
+				"0x0123456789abcdef0123456789abcdef", "Point(-122.4194,37.7749)", "5", "1"},
+			{"86410", "false", "127", "255", "-32768", "65535", "2147483647", "9223372036854775807", "-0.25", "1", "0",
+				"fedcba98-7654-3210-fedc-ba9876543210", "Point(2.3522,48.8566)", "0", "2"},
+			{"86420", "", "", "", "", "", "", "", "", "", "", "", "", "", ""},
+		})
+		shard.ArchiveStore.CurrentVersion.Batches[2] = archiveBatch(shard, 2, [][]string{
+			{"172800", "true", "0", "0", "0", "0", "0", "0", "0", "1", "1",
+				"00000000000000000000000000000000", "Point(0,0)", "2", ""},
+		})
+		metaStore.On("GetArchiveBatchIDs", "trips", 0).Return([]int{1, 2}, nil)
+
+		var err error
+		outputDir, err = ioutil.TempDir("", "export")
+		Ω(err).Should(BeNil())
+		config = aresCommon.ExportConfig{BaseDir: outputDir}
+	})
+
+	ginkgo.AfterEach(func() {
+		os.RemoveAll(outputDir)
+	})
+
+	// readParquetFile reads the values of all columns of the parquet file.
+	readParquetFile := func(path string) ([]string, [][]interface{}) {
+		file, err := local.NewLocalFileReader(path)
+		Ω(err).Should(BeNil())
+		defer file.Close()
+		parquetReader, err := reader.NewParquetColumnReader(file, 1)
+		Ω(err).Should(BeNil())
+		defer parquetReader.ReadStop()
+
+		var names []string
+		for _, info := range parquetReader.SchemaHandler.Infos[1:] {
+			names = append(names, info.ExName)
+		}
+		numRows := parquetReader.GetNumRows()
+		var values [][]interface{}
+		for i := range names {
+			columnValues, _, _, err := parquetReader.ReadColumnByIndex(int64(i), numRows)
+			Ω(err).Should(BeNil())
+			values = append(values, columnValues)
+		}
+		return names, values
+	}
+
+	ginkgo.It("round-trips archived rows within the time range through parquet files", func() {
+		result, err := memStore.ExportTable("trips", 86405, 172801, "data", config)
+		Ω(err).Should(BeNil())
+		Ω(result.Files).Should(Equal([]string{
+			"trips/date=1970-01-02/0.parquet",
+			"trips/date=1970-01-03/0.parquet",
+		}))
+		Ω(result.NumRows).Should(Equal(3))
+
+		names, values := readParquetFile(filepath.Join(outputDir, "data", result.Files[0]))
+		Ω(names).Should(Equal([]string{"request_at", "completed", "rating", "seats", "delta", "distance",
+			"duration", "driver_id", "fare", "status", "city", "uuid", "pickup", "tags"}))
+		Ω(values).Should(Equal([][]interface{}{
+			{int64(86410), int64(86420)},
+			{false, nil},
+			{int32(127), nil},
+			{int32(255), nil},
+			{int32(-32768), nil},
+			{int32(65535), nil},
+			{int32(2147483647), nil},
+			{int64(9223372036854775807), nil},
+			{float32(-0.25), nil},
+			{"canceled", nil},
+			{"sf", nil},
+			{"fedcba98-7654-3210-fedc-ba9876543210", nil},
+			{"Point(2.3522,48.8566)", nil},
+			{"[]", nil},
+		}))
+
+		_, values = readParquetFile(filepath.Join(outputDir, "data", result.Files[1]))
+		Ω(values[0]).Should(Equal([]interface{}{int64(172800)}))
+		Ω(values[9]).Should(Equal([]interface{}{"canceled"}))
+		Ω(values[11]).Should(Equal([]interface{}{"00000000-0000-0000-0000-000000000000"}))
+		Ω(values[13]).Should(Equal([]interface{}{`["airport"]`}))
+
+		// rows with event time out of range are not exported.
+		result, err = memStore.ExportTable("trips", 0, 86401, "data", config)
+		Ω(err).Should(BeNil())
+		Ω(result.NumRows).Should(Equal(1))
+		_, values = readParquetFile(filepath.Join(outputDir, "data", result.Files[0]))
+		Ω(values[1]).Should(Equal([]interface{}{true}))
+		Ω(values[3]).Should(Equal([]interface{}{int32(2)}))
+		Ω(values[10]).Should(Equal([]interface{}{"la"}))
+		Ω(values[11]).Should(Equal([]interface{}{"01234567-89ab-cdef-0123-456789abcdef"}))
+		Ω(values[12]).Should(Equal([]interface{}{"Point(-122.4194,37.7749)"}))
+		Ω(values[13]).Should(Equal([]interface{}{`["pool","night"]`}))
+
+		_, err = memStore.ExportTable("unknown", 0, 86401, "data", config)
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("rejects output paths outside of the export base directory", func() {
+		for _, output := range []string{"../data", "data/../../data", outputDir, "http://localhost/bucket"} {
+			_, err := memStore.ExportTable("trips", 0, 86401, output, config)
+			Ω(err).Should(Equal(ErrExportOutputNotAllowed))
+		}
+
+		_, err := memStore.ExportTable("trips", 0, 86401, "data", aresCommon.ExportConfig{})
+		Ω(err).Should(Equal(ErrExportOutputNotAllowed))
+	})
+
+	ginkgo.It("uploads parquet files to http output path", func() {
+		uploaded := make(map[string]int)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Ω(r.Method).Should(Equal(http.MethodPut))
+			body, err := ioutil.ReadAll(r.Body)
+			Ω(err).Should(BeNil())
+			uploaded[r.URL.Path] = len(body)
+		}))
+		defer server.Close()
+		config = aresCommon.ExportConfig{URLPrefixes: []string{server.URL + "/bucket/"}}
+
+		for _, output := range []string{server.URL, server.URL + "/bucket2", server.URL + "/bucket/../other"} {
+			_, err := memStore.ExportTable("trips", 0, 86400*3, output, config)
+			Ω(err).Should(Equal(ErrExportOutputNotAllowed))
+		}
+
+		result, err := memStore.ExportTable("trips", 0, 86400*3, server.URL+"/bucket/", config)
+		Ω(err).Should(BeNil())
+		Ω(result.NumRows).Should(Equal(4))
+		Ω(uploaded).Should(HaveLen(2))
+		Ω(uploaded["/bucket/trips/date=1970-01-02/0.parquet"]).Should(BeNumerically(">", 0))
+		Ω(uploaded["/bucket/trips/date=1970-01-03/0.parquet"]).Should(BeNumerically(">", 0))
+
+		server.Config.Handler = http.NotFoundHandler()
+		_, err = memStore.ExportTable("trips", 0, 86400*3, server.URL+"/bucket", config)
+		Ω(err).ShouldNot(BeNil())
+	})
+})
@@ -68,7 +68,7 @@ var _ = ginkgo.Describe("HostMemoryManager", func() {
 
 		testDiskStore = CreateMockDiskStore()
 		testMetaStore = CreateMockMetaStore()
-		testMemStore = NewMemStore(testMetaStore, testDiskStore).(*memStoreImpl)
+		testMemStore = NewMemStore(testMetaStore, testDiskStore, Options{}).(*memStoreImpl)
 		testHostMemoryManager = NewHostMemoryManager(testMemStore, int64(1000)).(*hostMemoryManager)
 		testMemStore.HostMemManager = testHostMemoryManager
 
@@ -80,7 +80,7 @@ var _ = ginkgo.Describe("HostMemoryManager", func() {
 			Return(nil, nil).Once()
 
 		serializer := &vectorPartyArchiveSerializer{
-			vectorPartyBaseSerializer{
+			vectorPartyBaseSerializer: vectorPartyBaseSerializer{
 				table:             "test",
 				diskstore:         testDiskStore,
 				hostMemoryManager: testHostMemoryManager,
@@ -422,7 +422,7 @@ var _ = ginkgo.Describe("HostMemoryManager", func() {
 		}
 		testMetaStore, err := metastore.NewDiskMetaStore(testBasePath)
 		Ω(err).Should(BeNil())
-		testMemStore = NewMemStore(testMetaStore, testDiskStore).(*memStoreImpl)
+		testMemStore = NewMemStore(testMetaStore, testDiskStore, Options{}).(*memStoreImpl)
 		// Init HostMemoryManager
 		testHostMemoryManager = NewHostMemoryManager(testMemStore, int64(20000)).(*hostMemoryManager)
 		testMemStore.HostMemManager = testHostMemoryManager
@@ -435,7 +435,7 @@ var _ = ginkgo.Describe("HostMemoryManager", func() {
 		}
 		testMemStore.TableShards[testTableName] = make(map[int]*TableShard)
 		testMemStore.TableShards[testTableName][0] = NewTableShard(testSchema, testMetaStore,
-			testDiskStore, testHostMemoryManager, 0)
+			testDiskStore, testHostMemoryManager, 0, Options{})
 		testMemStore.TableSchemas[testTableName] = testSchema
 
 		testMemStore.TableShards[testTableName][0].ArchiveStore = &ArchiveStore{
@@ -596,9 +596,9 @@ var _ = ginkgo.Describe("HostMemoryManager", func() {
 		testSchema := NewTableSchema(testTable)
 		testMemStore.TableShards[testTableName] = make(map[int]*TableShard)
 		testMemStore.TableShards[testTableName][0] = NewTableShard(testSchema, testMetaStore,
-			testDiskStore, testHostMemoryManager, 0)
+			testDiskStore, testHostMemoryManager, 0, Options{})
 		testMemStore.TableShards[testTableName][1] = NewTableShard(testSchema, testMetaStore,
-			testDiskStore, testHostMemoryManager, 1)
+			testDiskStore, testHostMemoryManager, 1, Options{})
 		testMemStore.TableSchemas[testTableName] = testSchema
 
 		testMemStore.TableShards[testTableName][0].ArchiveStore = &ArchiveStore{
@@ -745,7 +745,7 @@ var _ = ginkgo.Describe("HostMemoryManager", func() {
 		testSchema := NewTableSchema(testTable)
 		testMemStore.TableShards[testTableName] = make(map[int]*TableShard)
 		testMemStore.TableShards[testTableName][0] = NewTableShard(testSchema, testMetaStore,
-			testDiskStore, testHostMemoryManager, 0)
+			testDiskStore, testHostMemoryManager, 0, Options{})
 		testMemStore.TableSchemas[testTableName] = testSchema
 
 		testMemStore.TableShards[testTableName][0].ArchiveStore = &ArchiveStore{
@@ -852,9 +852,9 @@ var _ = ginkgo.Describe("HostMemoryManager", func() {
 		testSchema := NewTableSchema(testTable)
 		testMemStore.TableShards[testTableName] = make(map[int]*TableShard)
 		testMemStore.TableShards[testTableName][0] = NewTableShard(testSchema, testMetaStore,
-			testDiskStore, testHostMemoryManager, 0)
+			testDiskStore, testHostMemoryManager, 0, Options{})
 		testMemStore.TableShards[testTableName][1] = NewTableShard(testSchema, testMetaStore,
-			testDiskStore, testHostMemoryManager, 1)
+			testDiskStore, testHostMemoryManager, 1, Options{})
 		testMemStore.TableSchemas[testTableName] = testSchema
 
 		testBatchID1 := int32(15739)
@@ -925,7 +925,7 @@ var _ = ginkgo.Describe("HostMemoryManager", func() {
 		testSchema := NewTableSchema(testTable)
 		testMemStore.TableShards[testTableName] = make(map[int]*TableShard)
 		testMemStore.TableShards[testTableName][0] = NewTableShard(testSchema, testMetaStore,
-			testDiskStore, testHostMemoryManager, 0)
+			testDiskStore, testHostMemoryManager, 0, Options{})
 
 		for i := 0; i < 10; i++ {
 			liveBatch := &LiveBatch{
@@ -973,6 +973,58 @@ var _ = ginkgo.Describe("HostMemoryManager", func() {
 			}
 		}`))
 	})
+
+	ginkgo.It("GetTableUsageDetails", func() {
+		diskStore := CreateMockDiskStore()
+		diskStore.On("GetTableShardDiskUsage", "abc", 0).Return(int64(1000), nil)
+		memStore := createMemStore("abc", 0, []common.DataType{common.Uint16, common.Uint32},
+			[]int{0}, 10, false, false, nil, diskStore)
+		memStore.TableSchemas["def"] = NewTableSchema(&metaCom.Table{Name: "def"})
+
+		builder := common.NewUpsertBatchBuilder()
+		builder.AddColumn(0, common.Uint16)
+		builder.AddColumn(1, common.Uint32)
+		for i := 0; i < 12; i++ {
+			builder.AddRow()
+			builder.SetValue(i, 0, uint16(i))
+			builder.SetValue(i, 1, uint32(i))
+		}
+		buffer, _ := builder.ToByteArray()
+		upsertBatch, _ := NewUpsertBatch(buffer)
+		Ω(memStore.HandleIngestion("abc", 0, upsertBatch)).Should(BeNil())
+
+		shard := memStore.TableShards["abc"][0]
+		shard.ArchiveStore.CurrentVersion.Batches[1] = &ArchiveBatch{Size: 5}
+		shard.ArchiveStore.CurrentVersion.Batches[2] = &ArchiveBatch{Size: 7}
+
+		lastRun := time.Unix(10000, 0).UTC()
+		memStore.GetScheduler().(*schedulerImpl).reportJob(getIdentifier("abc", 0, common.ArchivingJobType),
+			func(jobDetail *JobDetail) {
+				jobDetail.LastRun = lastRun
+			})
+
+		tableUsages, err := memStore.GetTableUsageDetails("")
+		Ω(err).Should(BeNil())
+		Ω(tableUsages).Should(HaveLen(2))
+		Ω(tableUsages["def"]).Should(Equal(TableUsage{}))
+
+		usage := tableUsages["abc"]
+		Ω(usage.LiveBytes).Should(Equal(uint(shard.LiveStore.GetMemoryUsage()) + shard.LiveStore.PrimaryKey.AllocatedBytes()))
+		Ω(usage.LiveBytes).Should(BeNumerically(">", 0))
+		Ω(usage.NumLiveRows).Should(Equal(12))
+		Ω(usage.NumArchiveBatches).Should(Equal(2))
+		Ω(usage.NumArchiveRows).Should(Equal(12))
+		Ω(usage.RedoLogBytes).Should(Equal(uint(len(buffer) + 16)))
+		Ω(usage.DiskBytes).Should(Equal(uint(1000)))
+		Ω(*usage.LastArchivingTime).Should(Equal(lastRun))
+
+		tableUsages, err = memStore.GetTableUsageDetails("def")
+		Ω(err).Should(BeNil())
+		Ω(tableUsages).Should(Equal(map[string]TableUsage{"def": {}}))
+
+		_, err = memStore.GetTableUsageDetails("unknown")
+		Ω(err).ShouldNot(BeNil())
+	})
 })
 
 // CreateMemStore creates a mocked MetaStore for testing.
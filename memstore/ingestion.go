@@ -15,12 +15,28 @@
 package memstore
 
 import (
-	"github.com/uber/aresdb/memstore/common"
-	"github.com/uber/aresdb/utils"
+	"errors"
 	"math"
 	"strconv"
+
+	"github.com/uber/aresdb/memstore/common"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/utils"
 )
 
+// ErrTooManyPendingUpsertBatches is returned by HandleIngestion when the upsert batch is rejected
+// because too many upsert batches of the shard are waiting to be written into the redo log.
+var ErrTooManyPendingUpsertBatches = errors.New("too many upsert batches pending to be written into the redo log")
+
+// defaultMaxPendingUpsertBatches is the capacity of the queue of upsert batches being ingested into
+// a shard if not configured.
+const defaultMaxPendingUpsertBatches = 1000
+
+// ErrTooManyPrimaryKeys is returned by HandleIngestion when rows of the upsert batch adding new
+// primary keys are dropped because the shard has the max number of primary keys. The other rows of
+// the upsert batch are applied.
+var ErrTooManyPrimaryKeys = errors.New("too many primary keys")
+
 // HandleIngestion logs an upsert batch and applies it to the in-memory store.
 func (m *memStoreImpl) HandleIngestion(table string, shardID int, upsertBatch *UpsertBatch) error {
 	utils.GetReporter(table, shardID).GetCounter(utils.IngestedUpsertBatches).Inc(1)
@@ -31,17 +47,63 @@ func (m *memStoreImpl) HandleIngestion(table string, shardID int, upsertBatch *U
 	// Release the wait group that proctects the shard to be deleted.
 	defer shard.Users.Done()
 
+	// Reject the upsert batch instead of queueing up more in memory when the redo log writer
+	// falls behind.
+	pending, accepted := shard.LiveStore.enqueueUpsertBatch()
+	utils.GetReporter(table, shardID).GetGauge(utils.PendingUpsertBatches).Update(float64(pending))
+	if !accepted {
+		utils.GetReporter(table, shardID).GetCounter(utils.RejectedUpsertBatches).Inc(1)
+		return ErrTooManyPendingUpsertBatches
+	}
+	defer func() {
+		pending := shard.LiveStore.dequeueUpsertBatch()
+		utils.GetReporter(table, shardID).GetGauge(utils.PendingUpsertBatches).Update(float64(pending))
+	}()
+
+	if err := shard.checkShardKeys(upsertBatch); err != nil {
+		return err
+	}
+
+	if err := shard.checkNonFiniteValues(upsertBatch); err != nil {
+		return err
+	}
+
 	// Put the memStore in writer lock mode so other writers cannot enter.
 	shard.LiveStore.WriterLock.Lock()
 
+	// Acknowledge retried upsert batches without applying them again.
+	dedup := upsertBatch.IdempotencyKey != "" && shard.ingestionKeys.enabled()
+	now := utils.Now().Unix()
+	if dedup {
+		if shard.isIngestionKeyApplied(upsertBatch.IdempotencyKey, now) {
+			shard.LiveStore.WriterLock.Unlock()
+			utils.GetReporter(table, shardID).GetCounter(utils.DuplicateUpsertBatches).Inc(1)
+			return nil
+		}
+		upsertBatch.IdempotencyKeyExpiry = now + shard.ingestionKeys.ttl
+	} else {
+		// The key is not recorded in the redo log if deduplication is disabled.
+		upsertBatch.IdempotencyKey = ""
+	}
+
 	// Persist to disk first.
 	redoFile, offset := shard.LiveStore.RedoLogManager.WriteUpsertBatch(upsertBatch)
 
 	// Apply it to the memstore shard.
-	needToWaitForBackfillBuffer, err := shard.ApplyUpsertBatch(upsertBatch, redoFile, offset, false)
+	needToWaitForBackfillBuffer, numRejectedKeys, err := shard.applyUpsertBatch(upsertBatch, redoFile, offset, false)
+
+	if dedup && err == nil {
+		shard.recordIngestionKey(upsertBatch.IdempotencyKey, upsertBatch.IdempotencyKeyExpiry, now)
+	}
 
 	shard.LiveStore.WriterLock.Unlock()
 
+	if err == nil && numRejectedKeys > 0 {
+		err = utils.StackError(ErrTooManyPrimaryKeys,
+			"Dropped %d rows of upsert batch adding new primary keys to shard %d of table %s with the limit of %d primary keys",
+			numRejectedKeys, shard.ShardID, table, shard.maxPrimaryKeys())
+	}
+
 	// return immediately if it does not need to wait for backfill buffer availability
 	if !needToWaitForBackfillBuffer {
 		return err
@@ -50,16 +112,101 @@ func (m *memStoreImpl) HandleIngestion(table string, shardID int, upsertBatch *U
 	// otherwise: block until backfill buffer becomes available again
 	shard.LiveStore.BackfillManager.WaitForBackfillBufferAvailability()
 
+	return err
+}
+
+// checkShardKeys rejects the upsert batch if any of its rows belongs to another shard of the hash
+// sharded table.
+func (shard *TableShard) checkShardKeys(upsertBatch *UpsertBatch) error {
+	shard.Schema.RLock()
+	numShards := shard.Schema.Schema.NumShards
+	shardKeyColumn := shard.Schema.Schema.ShardKeyColumn
+	shard.Schema.RUnlock()
+	if numShards <= 1 {
+		return nil
+	}
+
+	col, err := upsertBatch.GetColumnIndex(shardKeyColumn)
+	if err != nil {
+		return utils.StackError(err, "Shard key column %d is missing in upsert batch", shardKeyColumn)
+	}
+	dataType, _ := upsertBatch.GetColumnType(col)
+	for row := 0; row < upsertBatch.NumRows; row++ {
+		value, err := upsertBatch.GetDataValue(row, col)
+		if err != nil {
+			return err
+		}
+		if shardID := common.GetShardForDataValue(value, dataType, numShards); shardID != shard.ShardID {
+			return utils.StackError(nil, "Row %d of upsert batch belongs to shard %d instead of shard %d of table %s",
+				row, shardID, shard.ShardID, shard.Schema.Schema.Name)
+		}
+	}
+	return nil
+}
+
+// maxPrimaryKeys returns the max number of primary keys of the shard, 0 means unlimited.
+func (shard *TableShard) maxPrimaryKeys() int {
+	shard.Schema.RLock()
+	maxPrimaryKeys := shard.Schema.Schema.Config.MaxPrimaryKeys
+	shard.Schema.RUnlock()
+	if maxPrimaryKeys <= 0 {
+		maxPrimaryKeys = shard.options.MaxPrimaryKeys
+	}
+	return maxPrimaryKeys
+}
+
+// checkNonFiniteValues rejects the upsert batch if any float column configured to reject NaN and
+// infinite values has such a value.
+func (shard *TableShard) checkNonFiniteValues(upsertBatch *UpsertBatch) error {
+	var rejectColumns []int
+	shard.Schema.RLock()
+	for columnID, column := range shard.Schema.Schema.Columns {
+		if !column.Deleted && column.Type == metaCom.Float32 && column.GetNonFinitePolicy() == metaCom.NonFiniteReject {
+			rejectColumns = append(rejectColumns, columnID)
+		}
+	}
+	shard.Schema.RUnlock()
+
+	for _, columnID := range rejectColumns {
+		col, err := upsertBatch.GetColumnIndex(columnID)
+		if err != nil {
+			continue
+		}
+		for row := 0; row < upsertBatch.NumRows; row++ {
+			value, valid, err := upsertBatch.GetValue(row, col)
+			if err != nil {
+				return err
+			}
+			if !valid {
+				continue
+			}
+			if f := float64(*(*float32)(value)); math.IsNaN(f) || math.IsInf(f, 0) {
+				return utils.StackError(nil, "Row %d of upsert batch has non finite value %v for column %d of table %s",
+					row, f, columnID, shard.Schema.Schema.Name)
+			}
+		}
+	}
 	return nil
 }
 
 // ApplyUpsertBatch applies the upsert batch to the memstore shard.
 // Returns true if caller needs to wait for availability of backfill buffer
 func (shard *TableShard) ApplyUpsertBatch(upsertBatch *UpsertBatch, redoLogFile int64, offset uint32, skipBackfillRows bool) (bool, error) {
+	needToWaitForBackfillBuffer, _, err := shard.applyUpsertBatch(upsertBatch, redoLogFile, offset, skipBackfillRows)
+	return needToWaitForBackfillBuffer, err
+}
+
+// applyUpsertBatch applies the upsert batch to the memstore shard and also returns the number of
+// rows dropped for adding new primary keys beyond the max number of primary keys of the shard.
+// The rows are dropped during recovery as well so the live store is recovered as ingested.
+func (shard *TableShard) applyUpsertBatch(upsertBatch *UpsertBatch, redoLogFile int64, offset uint32, skipBackfillRows bool) (bool, int, error) {
 	shard.Schema.RLock()
 	valueTypeByColumn := shard.Schema.ValueTypeByColumn
 	columnDeletions := shard.Schema.GetColumnDeletions()
 	allowMissingEventTime := shard.Schema.Schema.Config.AllowMissingEventTime
+	derivedColumns := shard.Schema.derivedColumns
+	defaultValues := shard.Schema.DefaultValues
+	conflictResolutionColumn := shard.Schema.GetConflictResolutionColumn()
 	shard.Schema.RUnlock()
 	primaryKeyColumns := shard.Schema.GetPrimaryKeyColumns()
 	// IsFactTable should be immutable.
@@ -73,12 +220,12 @@ func (shard *TableShard) ApplyUpsertBatch(upsertBatch *UpsertBatch, redoLogFile
 	for i := 0; i < upsertBatch.NumColumns; i++ {
 		columnID, _ := upsertBatch.GetColumnID(i)
 		if columnID >= len(valueTypeByColumn) {
-			return false, utils.StackError(nil, "Unrecognized column id %d in upsert batch", columnID)
+			return false, 0, utils.StackError(nil, "Unrecognized column id %d in upsert batch", columnID)
 		}
 
 		columnType, _ := upsertBatch.GetColumnType(i)
 		if valueTypeByColumn[columnID] != columnType {
-			return false, utils.StackError(
+			return false, 0, utils.StackError(
 				nil,
 				"Mismatched data type (upsert batch: %s, schema %s) for table %s shard %d column %d", columnType, valueTypeByColumn[columnID], shard.Schema.Schema.Name, shard.ShardID, columnID)
 		}
@@ -92,32 +239,35 @@ func (shard *TableShard) ApplyUpsertBatch(upsertBatch *UpsertBatch, redoLogFile
 	// have to validate the column type in the upsertbatch because the loop above already handled it.
 	if isFactTable && eventTimeColumnIndex < 0 && !allowMissingEventTime {
 		utils.GetReporter(shard.Schema.Schema.Name, shard.ShardID).GetCounter(utils.TimeColumnMissing).Inc(1)
-		return false, utils.StackError(nil, "Fact table's event time column (first column) is missing")
+		return false, 0, utils.StackError(nil, "Fact table's event time column (first column) is missing")
 	}
 
-	updateRecords, insertRecords, backfillUpsertBatch, err := shard.insertPrimaryKeys(primaryKeyColumns, eventTimeColumnIndex,
-		redoLogFile, upsertBatch, skipBackfillRows)
+	updateRecords, insertRecords, backfillUpsertBatch, numRejectedKeys, err := shard.insertPrimaryKeys(primaryKeyColumns,
+		eventTimeColumnIndex, redoLogFile, upsertBatch, skipBackfillRows)
 
 	if err != nil {
-		return false, err
+		return false, 0, err
 	}
 
 	// We write insert records first so records with the same primary key in a upsert batch
 	// will be updated in order.
 	for batchID, records := range insertRecords {
-		if err := writeBatchRecords(columnDeletions, upsertBatch, batchID, records, false, shard); err != nil {
-			return false, err
+		if err := writeBatchRecords(columnDeletions, derivedColumns, defaultValues, conflictResolutionColumn,
+			upsertBatch, batchID, records, false, shard); err != nil {
+			return false, 0, err
 		}
 	}
 	for batchID, records := range updateRecords {
-		if err := writeBatchRecords(columnDeletions, upsertBatch, batchID, records, true, shard); err != nil {
-			return false, err
+		if err := writeBatchRecords(columnDeletions, derivedColumns, defaultValues, conflictResolutionColumn,
+			upsertBatch, batchID, records, true, shard); err != nil {
+			return false, 0, err
 		}
 	}
 
 	shard.LiveStore.AdvanceLastReadRecord()
 	numMutations := len(insertRecords) + len(updateRecords)
-	return shard.postUpsertBatchApplication(upsertBatch, backfillUpsertBatch, redoLogFile, offset, numMutations), nil
+	return shard.postUpsertBatchApplication(upsertBatch, backfillUpsertBatch, redoLogFile, offset, numMutations),
+		numRejectedKeys, nil
 }
 
 func (shard *TableShard) postUpsertBatchApplication(upsertBatch, backfillUpsertBatch *UpsertBatch, redoLogFile int64,
@@ -149,22 +299,26 @@ type recordInfo struct {
 
 // Insert primary keys and return the records for update, insert grouped by batch.
 // eventTimeColumnIndex will be used to extract the event time value per row if it >= 0.
+// Rows adding new primary keys once the shard has the max number of primary keys are dropped and
+// counted, rows out of retention or to be backfilled are not inserted so they are never counted.
 func (shard *TableShard) insertPrimaryKeys(primaryKeyColumns []int, eventTimeColumnIndex int, redoLogFile int64,
 	upsertBatch *UpsertBatch, skipBackfillRows bool) (
-	map[int32][]recordInfo, map[int32][]recordInfo, *UpsertBatch, error) {
+	map[int32][]recordInfo, map[int32][]recordInfo, *UpsertBatch, int, error) {
 	// Get primary key column indices and calculate the primary key width.
 	primaryKeyBytes := shard.Schema.PrimaryKeyBytes
 	primaryKeyCols, err := upsertBatch.GetPrimaryKeyCols(primaryKeyColumns)
 	if err != nil {
 		utils.GetReporter(shard.Schema.Schema.Name, shard.ShardID).GetCounter(utils.PrimaryKeyMissing).Inc(1)
-		return nil, nil, nil, err
+		return nil, nil, nil, 0, err
 	}
 
 	shard.Schema.RLock()
 	recordRetentionDays := shard.Schema.Schema.Config.RecordRetentionInDays
 	allowMissingEventTime := shard.Schema.Schema.Config.AllowMissingEventTime
+	lateArrivalWindow := shard.Schema.Schema.Config.LateArrivalWindowInSeconds
 	isFactTable := shard.Schema.Schema.IsFactTable
 	shard.Schema.RUnlock()
+	maxPrimaryKeys := shard.maxPrimaryKeys()
 
 	key := make([]byte, primaryKeyBytes)
 	updateRecords := make(map[int32][]recordInfo)
@@ -180,18 +334,20 @@ func (shard *TableShard) insertPrimaryKeys(primaryKeyColumns []int, eventTimeCol
 	var numRecordsIngested int64
 	var numRecordsAppended int64
 	var numRecordsUpdated int64
+	var numRecordsTooLate int64
+	var numRejectedKeys int
 	var maxUpsertBatchEventTime uint32
 	for row := 0; row < upsertBatch.NumRows; row++ {
 		// Get primary key bytes for each record.
 		if err := upsertBatch.GetPrimaryKeyBytes(row, primaryKeyCols, key); err != nil {
-			return nil, nil, nil, utils.StackError(err, "Failed to create primary key at row %d", row)
+			return nil, nil, nil, 0, utils.StackError(err, "Failed to create primary key at row %d", row)
 		}
 
 		// For fact table we need to get the event time from the first column.
 		if eventTimeColumnIndex >= 0 {
 			value, validity, err := upsertBatch.GetValue(row, eventTimeColumnIndex)
 			if err != nil {
-				return nil, nil, nil, utils.StackError(err, "Failed to get event time for row %d", row)
+				return nil, nil, nil, 0, utils.StackError(err, "Failed to get event time for row %d", row)
 			}
 
 			isEventTimeValid = validity
@@ -203,7 +359,7 @@ func (shard *TableShard) insertPrimaryKeys(primaryKeyColumns []int, eventTimeCol
 		var primaryKeyEventTime uint32
 		if !isEventTimeValid {
 			if isFactTable && !allowMissingEventTime {
-				return nil, nil, nil, utils.StackError(err, "Event time for row %d is null", row)
+				return nil, nil, nil, 0, utils.StackError(err, "Event time for row %d is null", row)
 			}
 			primaryKeyEventTime = upsertBatch.ArrivalTime
 		} else {
@@ -238,6 +394,14 @@ func (shard *TableShard) insertPrimaryKeys(primaryKeyColumns []int, eventTimeCol
 			// 2. during recovery, the event should be ignored, because it was already put into
 			//    a backfill queue at ingestion time.
 			if eventTime < shard.LiveStore.ArchivingCutoffHighWatermark {
+				// Reject this record if it arrives later than the late arrival window.
+				if lateArrivalWindow > 0 && shard.LiveStore.ArchivingCutoffHighWatermark-eventTime > lateArrivalWindow {
+					if !skipBackfillRows {
+						numRecordsTooLate++
+					}
+					continue
+				}
+
 				if !skipBackfillRows {
 					// mark this row as backfill row
 					backfillRows = append(backfillRows, row)
@@ -254,10 +418,18 @@ func (shard *TableShard) insertPrimaryKeys(primaryKeyColumns []int, eventTimeCol
 			shard.LiveStore.RedoLogManager.UpdateMaxEventTime(eventTime, redoLogFile)
 		}
 
+		// Only look up the key when the shard is at the limit.
+		if maxPrimaryKeys > 0 && int(shard.LiveStore.PrimaryKey.Size()) >= maxPrimaryKeys {
+			if _, found := shard.LiveStore.PrimaryKey.Find(key); !found {
+				numRejectedKeys++
+				continue
+			}
+		}
+
 		numRecordsIngested++
 		existing, record, err := shard.LiveStore.PrimaryKey.FindOrInsert(key, nextWriteRecord, primaryKeyEventTime)
 		if err != nil {
-			return nil, nil, nil, utils.StackError(err, "Failed to insert key for row %d", row)
+			return nil, nil, nil, 0, utils.StackError(err, "Failed to insert key for row %d", row)
 		}
 
 		if !existing {
@@ -287,7 +459,7 @@ func (shard *TableShard) insertPrimaryKeys(primaryKeyColumns []int, eventTimeCol
 		for col := 0; col < upsertBatch.NumColumns; col++ {
 			columnID, err := upsertBatch.GetColumnID(col)
 			if err != nil {
-				return nil, nil, nil, utils.StackError(err, "Failed to get column id for col %d", col)
+				return nil, nil, nil, 0, utils.StackError(err, "Failed to get column id for col %d", col)
 			}
 
 			for columnID >= len(shard.LiveStore.lastModifiedTimePerColumn) {
@@ -309,6 +481,17 @@ func (shard *TableShard) insertPrimaryKeys(primaryKeyColumns []int, eventTimeCol
 	utils.GetReporter(tableName, shardID).GetCounter(utils.AppendedRecords).Inc(numRecordsAppended)
 	utils.GetReporter(tableName, shardID).GetCounter(utils.UpdatedRecords).Inc(numRecordsUpdated)
 	utils.GetReporter(tableName, shardID).GetCounter(utils.BackfillRecords).Inc(int64(len(backfillRows)))
+	if numRejectedKeys > 0 {
+		utils.GetReporter(tableName, shardID).GetCounter(utils.RejectedPrimaryKeys).Inc(int64(numRejectedKeys))
+	}
+	if numRecordsTooLate > 0 {
+		utils.GetReporter(tableName, shardID).GetCounter(utils.RecordsTooLate).Inc(numRecordsTooLate)
+		utils.GetLogger().With(
+			"table", tableName,
+			"shard", shardID,
+			"records", numRecordsTooLate,
+			"lateArrivalWindow", lateArrivalWindow).Warn("Rejected records arriving later than the late arrival window")
+	}
 
 	// update ratio gauge of backfill rows/total rows
 	if upsertBatch.NumRows > 0 {
@@ -319,7 +502,7 @@ func (shard *TableShard) insertPrimaryKeys(primaryKeyColumns []int, eventTimeCol
 	// create backfill upsertBatch if applicable
 	if len(backfillRows) == upsertBatch.NumRows {
 		// all rows are for backfill
-		return updateRecords, insertRecords, upsertBatch, nil
+		return updateRecords, insertRecords, upsertBatch, numRejectedKeys, nil
 	}
 
 	backfillBatch := upsertBatch.ExtractBackfillBatch(backfillRows)
@@ -328,12 +511,15 @@ func (shard *TableShard) insertPrimaryKeys(primaryKeyColumns []int, eventTimeCol
 		utils.GetReporter(tableName, shardID).GetCounter(utils.BackfillRecordsColumnRemoved).Inc(int64(len(backfillRows)))
 
 	}
-	return updateRecords, insertRecords, backfillBatch, nil
+	return updateRecords, insertRecords, backfillBatch, numRejectedKeys, nil
 }
 
 // Read rows from a batch group and write to memStore. Batch id = 0 is for records to be inserted.
-func writeBatchRecords(columnDeletions []bool,
-	upsertBatch *UpsertBatch, batchID int32, records []recordInfo, forUpdate bool, shard *TableShard) error {
+// Derived columns are computed after the other columns of each record are written. Updates losing
+// the conflict on conflictResolutionColumn (if >= 0) to the existing records are skipped.
+func writeBatchRecords(columnDeletions []bool, derivedColumns []*derivedColumn, defaultValues []*common.DataValue,
+	conflictResolutionColumn int, upsertBatch *UpsertBatch, batchID int32, records []recordInfo, forUpdate bool,
+	shard *TableShard) error {
 	var batch *LiveBatch
 	if forUpdate {
 		// We need to lock the batch for update to achieve row level consistency.
@@ -349,6 +535,9 @@ func writeBatchRecords(columnDeletions []bool,
 			}
 			batch.GetOrCreateVectorParty(columnID, true)
 		}
+		for _, column := range derivedColumns {
+			batch.GetOrCreateVectorParty(column.columnID, true)
+		}
 		batch.Unlock()
 
 		batch.RLock()
@@ -359,7 +548,30 @@ func writeBatchRecords(columnDeletions []bool,
 		batch.MaxArrivalTime = upsertBatch.ArrivalTime
 	}
 
+	// column index of the conflict resolution column in upsert batch, -1 if missing.
+	conflictCol := -1
+	if forUpdate && conflictResolutionColumn >= 0 {
+		if col, err := upsertBatch.GetColumnIndex(conflictResolutionColumn); err == nil {
+			conflictCol = col
+		}
+	}
+	var numRecordsLosingConflict int64
+
 	for _, recordInfo := range records {
+		if forUpdate && conflictResolutionColumn >= 0 {
+			newEventTime := common.NullDataValue
+			if conflictCol >= 0 {
+				var err error
+				if newEventTime, err = upsertBatch.GetDataValue(recordInfo.row, conflictCol); err != nil {
+					return utils.StackError(err, "Failed to get conflict resolution value for row %d", recordInfo.row)
+				}
+			}
+			if !winsConflict(newEventTime, batch.GetDataValue(recordInfo.index, conflictResolutionColumn)) {
+				numRecordsLosingConflict++
+				continue
+			}
+		}
+
 		for col := 0; col < upsertBatch.NumColumns; col++ {
 			columnID, err := upsertBatch.GetColumnID(col)
 			if err != nil {
@@ -408,6 +620,19 @@ func writeBatchRecords(columnDeletions []bool,
 				vectorParty.SetDataValue(recordInfo.index, *newValue, IgnoreCount)
 			}
 		}
+		computeLiveRow(batch, recordInfo.index, derivedColumns, defaultValues)
+	}
+
+	if numRecordsLosingConflict > 0 {
+		utils.GetReporter(shard.Schema.Schema.Name, shard.ShardID).
+			GetCounter(utils.RecordsLosingConflict).Inc(numRecordsLosingConflict)
 	}
 	return nil
 }
+
+// winsConflict tells whether a record with the event time newValue replaces the existing record
+// with the event time oldValue. Ties are won by the newer arrival, and null event time is older
+// than any event time.
+func winsConflict(newValue, oldValue common.DataValue) bool {
+	return newValue.Compare(oldValue) >= 0
+}
@@ -0,0 +1,71 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+// ingestionKeys remembers the idempotency keys of upsert batches recently applied to a table
+// shard, so that batches retried by clients are acknowledged without being applied twice.
+// Keys are written into the redo log records of their batches and remembered again when the
+// redo logs are replayed on restart. Keys are not persisted anywhere else: keys of batches in redo
+// logs purged after archiving, backfill or snapshot are lost on restart, and so are keys of batches
+// recovered by loading a snapshot instead of replaying their redo logs. Retries of those batches
+// after a restart are applied again. Protected by LiveStore.WriterLock.
+type ingestionKeys struct {
+	// Idempotency key to expiry time in unix seconds.
+	keys map[string]int64
+	// Max number of keys remembered, 0 disables deduplication.
+	maxKeys int
+	// Seconds to remember a key.
+	ttl int64
+}
+
+// enabled returns whether upsert batches with idempotency keys are deduplicated.
+func (k *ingestionKeys) enabled() bool {
+	return k.maxKeys > 0
+}
+
+// isIngestionKeyApplied returns whether an upsert batch with the key has been applied and the
+// key has not expired. Caller must hold LiveStore.WriterLock.
+func (shard *TableShard) isIngestionKeyApplied(key string, now int64) bool {
+	expiry, ok := shard.ingestionKeys.keys[key]
+	return ok && expiry > now
+}
+
+// recordIngestionKey remembers the key of an applied upsert batch until the expiry. Expired keys
+// are dropped, and the keys expiring first are evicted when there are more than the max number of
+// keys. Caller must hold LiveStore.WriterLock.
+func (shard *TableShard) recordIngestionKey(key string, expiry, now int64) {
+	if expiry <= now {
+		return
+	}
+	if shard.ingestionKeys.keys == nil {
+		shard.ingestionKeys.keys = make(map[string]int64)
+	}
+	keys := shard.ingestionKeys.keys
+	for k, e := range keys {
+		if e <= now {
+			delete(keys, k)
+		}
+	}
+	keys[key] = expiry
+	for len(keys) > shard.ingestionKeys.maxKeys {
+		oldestKey, oldestExpiry := "", int64(0)
+		for k, e := range keys {
+			if oldestKey == "" || e < oldestExpiry {
+				oldestKey, oldestExpiry = k, e
+			}
+		}
+		delete(keys, oldestKey)
+	}
+}
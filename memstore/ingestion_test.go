@@ -15,11 +15,16 @@
 package memstore
 
 import (
+	"math"
+	"time"
+
 	"github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/uber-go/tally"
 	"github.com/uber/aresdb/memstore/common"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	metaStoreMocks "github.com/uber/aresdb/metastore/mocks"
 	"github.com/uber/aresdb/utils"
-	"time"
 )
 
 var _ = ginkgo.Describe("ingestion", func() {
@@ -33,6 +38,43 @@ var _ = ginkgo.Describe("ingestion", func() {
 		Ω(shard.LiveStore.lastModifiedTimePerColumn).Should(BeNil())
 	})
 
+	ginkgo.It("rejects upsert batches when too many are pending", func() {
+		utils.ResetDefaults()
+		testScope := utils.GetRootReporter().GetRootScope().(tally.TestScope)
+
+		memstore := createMemStore("abc", 0, []common.DataType{}, []int{}, 10, false, false, nil, CreateMockDiskStore())
+		buffer, _ := common.NewUpsertBatchBuilder().ToByteArray()
+		upsertBatch, _ := NewUpsertBatch(buffer)
+		shard := recreateTableShard(memstore, "abc", 0, Options{MaxPendingUpsertBatches: 1})
+
+		// Block the redo log writer.
+		shard.LiveStore.WriterLock.Lock()
+		done := make(chan error)
+		go func() {
+			done <- memstore.HandleIngestion("abc", 0, upsertBatch)
+		}()
+		Eventually(func() int {
+			return len(shard.LiveStore.pendingUpsertBatches)
+		}).Should(Equal(1))
+		Ω(cap(shard.LiveStore.pendingUpsertBatches)).Should(Equal(1))
+
+		Ω(memstore.HandleIngestion("abc", 0, upsertBatch)).Should(Equal(ErrTooManyPendingUpsertBatches))
+		Ω(testScope.Snapshot().Counters()["test.rejected_upsert_batches+component=memstore,operation=ingestion"].Value()).
+			Should(BeEquivalentTo(1))
+
+		// Backpressure clears once the writer catches up.
+		shard.LiveStore.WriterLock.Unlock()
+		Ω(<-done).Should(BeNil())
+		Ω(shard.LiveStore.pendingUpsertBatches).Should(BeEmpty())
+		Ω(memstore.HandleIngestion("abc", 0, upsertBatch)).Should(BeNil())
+
+		// The queue is bounded by default.
+		memstore = createMemStore("abc", 0, []common.DataType{}, []int{}, 10, false, false, nil, CreateMockDiskStore())
+		shard, _ = memstore.GetTableShard("abc", 0)
+		shard.Users.Done()
+		Ω(cap(shard.LiveStore.pendingUpsertBatches)).Should(Equal(defaultMaxPendingUpsertBatches))
+	})
+
 	ginkgo.It("returns error for unrecognized table", func() {
 		memstore := createMemStore("abc", 0, []common.DataType{}, []int{}, 10, false, false, nil, CreateMockDiskStore())
 		buffer, _ := common.NewUpsertBatchBuilder().ToByteArray()
@@ -100,6 +142,179 @@ var _ = ginkgo.Describe("ingestion", func() {
 		Ω(shard.LiveStore.LastReadRecord.Index).Should(Equal(uint32(1)))
 	})
 
+	ginkgo.It("rejects rows belonging to another shard", func() {
+		memstore := createMemStore("abc", 0, []common.DataType{common.Uint8}, []int{0}, 10, false, false, nil, CreateMockDiskStore())
+		shard, err := memstore.GetTableShard("abc", 0)
+		Ω(err).Should(BeNil())
+		shard.Users.Done()
+		shard.Schema.Schema.NumShards = 4
+		shard.Schema.Schema.ShardKeyColumn = 0
+
+		var ownKey, otherKey uint8
+		for key := 1; key < 256; key++ {
+			shardID, err := common.GetShardForValue(key, common.Uint8, 4)
+			Ω(err).Should(BeNil())
+			if shardID == 0 {
+				ownKey = uint8(key)
+			} else {
+				otherKey = uint8(key)
+			}
+		}
+
+		newUpsertBatch := func(keys ...uint8) *UpsertBatch {
+			builder := common.NewUpsertBatchBuilder()
+			builder.AddColumn(0, common.Uint8)
+			for row, key := range keys {
+				builder.AddRow()
+				builder.SetValue(row, 0, key)
+			}
+			buffer, _ := builder.ToByteArray()
+			upsertBatch, _ := NewUpsertBatch(buffer)
+			return upsertBatch
+		}
+		Ω(memstore.HandleIngestion("abc", 0, newUpsertBatch(ownKey, otherKey))).ShouldNot(BeNil())
+		Ω(shard.LiveStore.LastReadRecord.Index).Should(Equal(uint32(0)))
+
+		Ω(memstore.HandleIngestion("abc", 0, newUpsertBatch(ownKey))).Should(BeNil())
+		_, valid := ReadShardValue(shard, 0, []byte{ownKey})
+		Ω(valid).Should(BeTrue())
+	})
+
+	ginkgo.It("rejects non finite values of columns configured to reject them", func() {
+		memstore := createMemStore("abc", 0, []common.DataType{common.Uint8, common.Float32}, []int{0}, 10, false, false, nil, CreateMockDiskStore())
+		shard, err := memstore.GetTableShard("abc", 0)
+		Ω(err).Should(BeNil())
+		shard.Users.Done()
+
+		// values parsed from strings are not checked for NaN and infinity.
+		newUpsertBatch := func(values ...interface{}) *UpsertBatch {
+			builder := common.NewUpsertBatchBuilder()
+			builder.AddColumn(0, common.Uint8)
+			builder.AddColumn(1, common.Float32)
+			for row, value := range values {
+				builder.AddRow()
+				builder.SetValue(row, 0, uint8(row))
+				builder.SetValue(row, 1, value)
+			}
+			buffer, _ := builder.ToByteArray()
+			upsertBatch, _ := NewUpsertBatch(buffer)
+			return upsertBatch
+		}
+
+		// NaN is stored by default and skipped at query time.
+		Ω(memstore.HandleIngestion("abc", 0, newUpsertBatch(1, "NaN"))).Should(BeNil())
+		value, valid := ReadShardValue(shard, 1, []byte{1})
+		Ω(valid).Should(BeTrue())
+		Ω(math.IsNaN(float64(*(*float32)(value)))).Should(BeTrue())
+
+		shard.Schema.Schema.Columns[1].Config.NonFinitePolicy = metaCom.NonFiniteReject
+		Ω(memstore.HandleIngestion("abc", 0, newUpsertBatch(1, "-Inf"))).ShouldNot(BeNil())
+		Ω(memstore.HandleIngestion("abc", 0, newUpsertBatch("NaN"))).ShouldNot(BeNil())
+		Ω(memstore.HandleIngestion("abc", 0, newUpsertBatch(2, 3))).Should(BeNil())
+		value, valid = ReadShardValue(shard, 1, []byte{1})
+		Ω(valid).Should(BeTrue())
+		Ω(*(*float32)(value)).Should(Equal(float32(3)))
+	})
+
+	ginkgo.It("drops rows with new primary keys beyond the max while updating existing keys", func() {
+		memstore := createMemStore("abc", 0, []common.DataType{common.Uint8, common.Uint8}, []int{0}, 10, false, false, nil, CreateMockDiskStore())
+		shard, err := memstore.GetTableShard("abc", 0)
+		Ω(err).Should(BeNil())
+		shard.Users.Done()
+		testScope := utils.GetRootReporter().GetRootScope().(tally.TestScope)
+		rejectedKeys := func() int64 {
+			counter, ok := testScope.Snapshot().Counters()["test.rejected_primary_keys+component=memstore,operation=ingestion"]
+			if !ok {
+				return 0
+			}
+			return counter.Value()
+		}
+
+		// rows of key and value.
+		newUpsertBatch := func(rows ...[2]uint8) *UpsertBatch {
+			builder := common.NewUpsertBatchBuilder()
+			builder.AddColumn(0, common.Uint8)
+			builder.AddColumn(1, common.Uint8)
+			for row, values := range rows {
+				builder.AddRow()
+				builder.SetValue(row, 0, values[0])
+				builder.SetValue(row, 1, values[1])
+			}
+			buffer, _ := builder.ToByteArray()
+			upsertBatch, _ := NewUpsertBatch(buffer)
+			return upsertBatch
+		}
+		readValue := func(key uint8) uint8 {
+			value, valid := ReadShardValue(shard, 1, []byte{key})
+			Ω(valid).Should(BeTrue())
+			return *(*uint8)(value)
+		}
+
+		rejectedBefore := rejectedKeys()
+		shard.Schema.Schema.Config.MaxPrimaryKeys = 2
+		Ω(memstore.HandleIngestion("abc", 0, newUpsertBatch([2]uint8{1, 10}, [2]uint8{1, 11}, [2]uint8{2, 20}))).Should(BeNil())
+		Ω(shard.LiveStore.PrimaryKey.Size()).Should(BeEquivalentTo(2))
+
+		// the update of key 1 in the same upsert batch still applies.
+		err = memstore.HandleIngestion("abc", 0, newUpsertBatch([2]uint8{3, 30}, [2]uint8{1, 12}, [2]uint8{4, 40}))
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring(ErrTooManyPrimaryKeys.Error()))
+		Ω(err.Error()).Should(ContainSubstring("Dropped 2 rows of upsert batch adding new primary keys to shard 0 of table abc with the limit of 2 primary keys"))
+		Ω(rejectedKeys() - rejectedBefore).Should(BeEquivalentTo(2))
+		Ω(shard.LiveStore.PrimaryKey.Size()).Should(BeEquivalentTo(2))
+		Ω(readValue(1)).Should(Equal(uint8(12)))
+		_, found := shard.LiveStore.PrimaryKey.Find([]byte{3})
+		Ω(found).Should(BeFalse())
+
+		Ω(memstore.HandleIngestion("abc", 0, newUpsertBatch([2]uint8{1, 13}, [2]uint8{2, 21}))).Should(BeNil())
+		Ω(readValue(1)).Should(Equal(uint8(13)))
+		Ω(readValue(2)).Should(Equal(uint8(21)))
+
+		// the server default applies to tables without a max.
+		shard.options.MaxPrimaryKeys = 3
+		shard.Schema.Schema.Config.MaxPrimaryKeys = 0
+		Ω(memstore.HandleIngestion("abc", 0, newUpsertBatch([2]uint8{3, 30}))).Should(BeNil())
+		Ω(memstore.HandleIngestion("abc", 0, newUpsertBatch([2]uint8{4, 40}))).ShouldNot(BeNil())
+		Ω(shard.LiveStore.PrimaryKey.Size()).Should(BeEquivalentTo(3))
+	})
+
+	ginkgo.It("does not count rows out of retention or to be backfilled against the max primary keys", func() {
+		day := uint32(86400)
+		utils.SetCurrentTime(time.Unix(int64(20*day), 0))
+		defer utils.ResetClockImplementation()
+		memstore := createMemStore("abc", 0, []common.DataType{common.Uint32}, []int{0}, 10, true, false, nil, CreateMockDiskStore())
+		shard, err := memstore.GetTableShard("abc", 0)
+		Ω(err).Should(BeNil())
+		shard.Users.Done()
+		shard.Schema.Schema.Config.RecordRetentionInDays = 10
+		shard.Schema.Schema.Config.MaxPrimaryKeys = 1
+		shard.LiveStore.PrimaryKey.UpdateEventTimeCutoff(15 * day)
+		shard.LiveStore.ArchivingCutoffHighWatermark = 15 * day
+
+		newUpsertBatch := func(eventTimes ...uint32) *UpsertBatch {
+			builder := common.NewUpsertBatchBuilder()
+			builder.AddColumn(0, common.Uint32)
+			for row, eventTime := range eventTimes {
+				builder.AddRow()
+				builder.SetValue(row, 0, eventTime)
+			}
+			buffer, _ := builder.ToByteArray()
+			upsertBatch, _ := NewUpsertBatch(buffer)
+			return upsertBatch
+		}
+
+		Ω(memstore.HandleIngestion("abc", 0, newUpsertBatch(16*day))).Should(BeNil())
+		Ω(memstore.HandleIngestion("abc", 0, newUpsertBatch(day, 14*day, 16*day))).Should(BeNil())
+		Ω(shard.LiveStore.PrimaryKey.Size()).Should(BeEquivalentTo(1))
+		Ω(shard.LiveStore.BackfillManager.UpsertBatches).Should(HaveLen(1))
+		Ω(shard.LiveStore.BackfillManager.UpsertBatches[0].NumRows).Should(Equal(1))
+
+		err = memstore.HandleIngestion("abc", 0, newUpsertBatch(17*day))
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring(ErrTooManyPrimaryKeys.Error()))
+		Ω(shard.LiveStore.PrimaryKey.Size()).Should(BeEquivalentTo(1))
+	})
+
 	ginkgo.It("skip old records", func() {
 		memstore := createMemStore("abc", 0, []common.DataType{common.Uint8}, []int{0}, 10, true, false, nil, CreateMockDiskStore())
 		shard, err := memstore.GetTableShard("abc", 0)
@@ -242,6 +457,91 @@ var _ = ginkgo.Describe("ingestion", func() {
 		Ω(*(*float32)(value)).Should(Equal(float32(4.56)))
 	})
 
+	ginkgo.It("applies column default values to columns missing in upsert batch", func() {
+		memstore := createMemStore("abc", 0, []common.DataType{common.Uint16, common.Int32, common.Float32, common.Bool, common.SmallEnum, common.Uint32},
+			[]int{0}, 10, false, false, nil, CreateMockDiskStore())
+		schema := memstore.TableSchemas["abc"]
+		schema.EnumDicts["status"] = EnumDict{
+			Dict:        map[string]int{"unknown": 0, "active": 1},
+			ReverseDict: []string{"unknown", "active"},
+		}
+		schema.Schema.Columns[4].Name = "status"
+		for columnID, defaultValue := range []string{1: "-1", 2: "1.5", 3: "true", 4: "unknown"} {
+			if defaultValue == "" {
+				continue
+			}
+			value := defaultValue
+			schema.Schema.Columns[columnID].DefaultValue = &value
+			schema.DefaultValues[columnID] = nil
+			schema.SetDefaultValue(columnID)
+		}
+
+		// first batch only has primary key and a column without default value.
+		builder := common.NewUpsertBatchBuilder()
+		builder.AddColumn(0, common.Uint16)
+		builder.AddColumn(5, common.Uint32)
+		builder.AddRow()
+		builder.SetValue(0, 0, uint16(1))
+		builder.SetValue(0, 1, uint32(10))
+		buffer, _ := builder.ToByteArray()
+		upsertBatch, _ := NewUpsertBatch(buffer)
+		Ω(memstore.HandleIngestion("abc", 0, upsertBatch)).Should(BeNil())
+
+		// second batch has explicit nulls for new and existing rows.
+		builder = common.NewUpsertBatchBuilder()
+		builder.AddColumn(0, common.Uint16)
+		builder.AddColumn(1, common.Int32)
+		builder.AddColumn(5, common.Uint32)
+		builder.AddRow()
+		builder.SetValue(0, 0, uint16(1))
+		builder.SetValue(0, 1, int32(5))
+		builder.AddRow()
+		builder.SetValue(1, 0, uint16(2))
+		buffer, _ = builder.ToByteArray()
+		upsertBatch, _ = NewUpsertBatch(buffer)
+		Ω(memstore.HandleIngestion("abc", 0, upsertBatch)).Should(BeNil())
+
+		shard, _ := memstore.GetTableShard("abc", 0)
+		// columns never specified have no vector party in live batch, which are read as the
+		// default value by queries and archiving.
+		read := func(id uint16, columnID int) interface{} {
+			record, found := shard.LiveStore.PrimaryKey.Find([]byte{byte(id), byte(id >> 8)})
+			Ω(found).Should(BeTrue())
+			batch := shard.LiveStore.GetBatchForRead(record.BatchID)
+			defer batch.RUnlock()
+			value := batch.GetDataValueWithDefault(int(record.Index), columnID, *schema.DefaultValues[columnID])
+			if !value.Valid {
+				return nil
+			}
+			switch value.DataType {
+			case common.Int32:
+				return *(*int32)(value.OtherVal)
+			case common.Float32:
+				return *(*float32)(value.OtherVal)
+			case common.Bool:
+				return value.BoolVal
+			case common.SmallEnum:
+				return *(*uint8)(value.OtherVal)
+			default:
+				return *(*uint32)(value.OtherVal)
+			}
+		}
+
+		for _, id := range []uint16{1, 2} {
+			Ω(read(id, 2)).Should(Equal(float32(1.5)))
+			Ω(read(id, 3)).Should(Equal(true))
+			Ω(read(id, 4)).Should(Equal(uint8(0)))
+		}
+		// specified values overwrite the default value.
+		Ω(read(1, 1)).Should(Equal(int32(5)))
+		// explicit nulls of new rows are not written so the default value is kept.
+		Ω(read(2, 1)).Should(Equal(int32(-1)))
+		// columns without default value are null unless specified, explicit nulls of existing
+		// rows do not overwrite existing values.
+		Ω(read(1, 5)).Should(Equal(uint32(10)))
+		Ω(read(2, 5)).Should(BeNil())
+	})
+
 	ginkgo.It("batch grows correctly", func() {
 		// Make sure batch is going correctly.
 		memstore := createMemStore("abc", 0, []common.DataType{common.Uint8}, []int{0}, 2, false, false, nil, CreateMockDiskStore())
@@ -408,6 +708,37 @@ var _ = ginkgo.Describe("ingestion", func() {
 
 	})
 
+	ginkgo.It("rejects records arriving later than the late arrival window", func() {
+		memstore := createMemStore("abc", 0, []common.DataType{common.Uint32}, []int{0}, 10, true, false, nil, CreateMockDiskStore())
+		builder := common.NewUpsertBatchBuilder()
+		builder.AddColumn(0, common.Uint32)
+		for row, eventTime := range []uint32{12, 7, 2} {
+			builder.AddRow()
+			builder.SetValue(row, 0, eventTime)
+		}
+		buffer, _ := builder.ToByteArray()
+		upsertBatch, _ := NewUpsertBatch(buffer)
+		shard, err := memstore.GetTableShard("abc", 0)
+		Ω(err).Should(BeNil())
+		shard.Schema.Schema.Config.LateArrivalWindowInSeconds = 5
+		shard.LiveStore.PrimaryKey.UpdateEventTimeCutoff(10)
+		shard.LiveStore.ArchivingCutoffHighWatermark = 10
+		Ω(memstore.HandleIngestion("abc", 0, upsertBatch)).Should(BeNil())
+		_, valid := ReadShardValue(shard, 0, []byte{12, 0, 0, 0})
+		Ω(valid).Should(BeTrue())
+
+		// 7 is within the window and backfilled, 2 is rejected.
+		backfillUpsertBatches := shard.LiveStore.BackfillManager.UpsertBatches
+		Ω(backfillUpsertBatches).Should(HaveLen(1))
+		Ω(backfillUpsertBatches[0].NumRows).Should(Equal(1))
+		value, valid, err := backfillUpsertBatches[0].GetValue(0, 0)
+		Ω(err).Should(BeNil())
+		Ω(valid).Should(BeTrue())
+		Ω(*(*uint32)(value)).Should(Equal(uint32(7)))
+		_, valid = ReadShardValue(shard, 0, []byte{2, 0, 0, 0})
+		Ω(valid).Should(BeFalse())
+	})
+
 	ginkgo.It("returns error fact table's first column is not uint32", func() {
 		memstore := createMemStore("abc", 0, []common.DataType{common.Uint32}, []int{0}, 10, true, false, nil, CreateMockDiskStore())
 		builder := common.NewUpsertBatchBuilder()
@@ -681,6 +1012,106 @@ var _ = ginkgo.Describe("ingestion", func() {
 		Ω(*(*uint8)(value)).Should(Equal(uint8(3)))
 	})
 
+	ginkgo.It("resolves conflicts of upserts by event time", func() {
+		memstore := createMemStore("abc", 0, []common.DataType{common.Uint8, common.Uint32, common.Uint8}, []int{0}, 10, false, false, nil, CreateMockDiskStore())
+		shard, _ := memstore.GetTableShard("abc", 0)
+		shard.Schema.Schema.Config.ConflictResolutionColumn = "updated_at"
+		shard.Schema.ColumnIDs["updated_at"] = 1
+
+		upsert := func(rows ...[]interface{}) {
+			builder := common.NewUpsertBatchBuilder()
+			builder.AddColumn(0, common.Uint8)
+			builder.AddColumn(2, common.Uint8)
+			builder.AddColumn(1, common.Uint32)
+			for row, values := range rows {
+				builder.AddRow()
+				for col, value := range values {
+					builder.SetValue(row, col, value)
+				}
+			}
+			buffer, _ := builder.ToByteArray()
+			upsertBatch, _ := NewUpsertBatch(buffer)
+			Ω(memstore.HandleIngestion("abc", 0, upsertBatch)).Should(BeNil())
+		}
+		readValue := func() uint8 {
+			value, valid := ReadShardValue(shard, 2, []byte{1})
+			Ω(valid).Should(BeTrue())
+			return *(*uint8)(value)
+		}
+
+		upsert([]interface{}{uint8(1), uint8(2), uint32(200)})
+		Ω(readValue()).Should(Equal(uint8(2)))
+		// late but older record is ignored.
+		upsert([]interface{}{uint8(1), uint8(1), uint32(100)})
+		Ω(readValue()).Should(Equal(uint8(2)))
+		// record without event time is ignored.
+		upsert([]interface{}{uint8(1), uint8(0), nil})
+		Ω(readValue()).Should(Equal(uint8(2)))
+		// newer record wins, ties are won by the later arrival.
+		upsert([]interface{}{uint8(1), uint8(3), uint32(300)})
+		Ω(readValue()).Should(Equal(uint8(3)))
+		upsert([]interface{}{uint8(1), uint8(4), uint32(300)})
+		Ω(readValue()).Should(Equal(uint8(4)))
+		// out of order records within an upsert batch.
+		upsert([]interface{}{uint8(1), uint8(6), uint32(600)}, []interface{}{uint8(1), uint8(5), uint32(500)})
+		Ω(readValue()).Should(Equal(uint8(6)))
+		value, _ := ReadShardValue(shard, 1, []byte{1})
+		Ω(*(*uint32)(value)).Should(Equal(uint32(600)))
+	})
+
+	ginkgo.It("skips upsert batches with recently applied idempotency keys", func() {
+		utils.ResetDefaults()
+		utils.SetCurrentTime(time.Unix(100, 0))
+		defer utils.ResetClockImplementation()
+		testScope := utils.GetRootReporter().GetRootScope().(tally.TestScope)
+
+		metaStore := &metaStoreMocks.MetaStore{}
+		memstore := createMemStore("abc", 0, []common.DataType{common.Uint8, common.Uint8}, []int{0}, 10, false, false, metaStore, CreateMockDiskStore())
+		shard := recreateTableShard(memstore, "abc", 0, Options{MaxIngestionKeys: 2, IngestionKeyTTL: 60})
+		ingest := func(key string) {
+			builder := common.NewUpsertBatchBuilder()
+			builder.AddColumn(0, common.Uint8)
+			builder.AddColumnWithUpdateMode(1, common.Uint8, common.UpdateWithAddition)
+			builder.AddRow()
+			builder.SetValue(0, 0, uint8(123))
+			builder.SetValue(0, 1, uint8(1))
+			buffer, _ := builder.ToByteArray()
+			upsertBatch, _ := NewUpsertBatch(buffer)
+			upsertBatch.IdempotencyKey = key
+			Ω(memstore.HandleIngestion("abc", 0, upsertBatch)).Should(BeNil())
+		}
+		readValue := func() uint8 {
+			value, valid := ReadShardValue(shard, 1, []byte{123})
+			Ω(valid).Should(BeTrue())
+			return *(*uint8)(value)
+		}
+
+		ingest("a")
+		ingest("a")
+		Ω(readValue()).Should(Equal(uint8(1)))
+		Ω(shard.ingestionKeys.keys).Should(Equal(map[string]int64{"a": 160}))
+		Ω(testScope.Snapshot().Counters()["test.duplicate_upsert_batches+component=memstore,operation=ingestion"].Value()).
+			Should(BeEquivalentTo(1))
+
+		// batches without key are always applied.
+		ingest("")
+		Ω(readValue()).Should(Equal(uint8(2)))
+
+		// the keys expiring first are evicted beyond the max number of keys.
+		utils.SetCurrentTime(time.Unix(110, 0))
+		ingest("b")
+		utils.SetCurrentTime(time.Unix(120, 0))
+		ingest("c")
+		Ω(shard.ingestionKeys.keys).Should(Equal(map[string]int64{"b": 170, "c": 180}))
+		ingest("a")
+		Ω(readValue()).Should(Equal(uint8(5)))
+
+		// expired keys are applied again.
+		utils.SetCurrentTime(time.Unix(200, 0))
+		ingest("c")
+		Ω(readValue()).Should(Equal(uint8(6)))
+	})
+
 	ginkgo.It("ingestion works for missing event time", func() {
 		memstore := createMemStore("abc", 0, []common.DataType{common.Uint32, common.Uint8}, []int{1}, 10, true, true, nil, CreateMockDiskStore())
 		utils.SetCurrentTime(time.Unix(10, 0))
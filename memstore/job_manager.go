@@ -68,6 +68,17 @@ func (m *archiveJobManager) generateJobs() []Job {
 				currentCutoff := tableShard.ArchiveStore.CurrentVersion.ArchivingCutoff
 				newCutoff := now - delay
 
+				memoryLimit := tableShard.Schema.Schema.Config.LiveStoreMemoryLimit
+				if memoryLimit == 0 {
+					memoryLimit = m.memStore.options.LiveStoreMemoryLimit
+				}
+				liveStoreBytes := tableShard.LiveStore.GetMemoryUsage()
+				utils.GetReporter(tableName, shardID).GetGauge(utils.LiveStoreMemoryBytes).Update(float64(liveStoreBytes))
+				var earlyCutoff uint32
+				if memoryLimit > 0 && liveStoreBytes > memoryLimit {
+					earlyCutoff = m.getEarlyArchivingCutoff(tableShard, newCutoff, now)
+				}
+
 				key := getIdentifier(tableName, shardID, common.ArchivingJobType)
 				if newCutoff > currentCutoff+interval {
 					job := m.scheduler.NewArchivingJob(tableName, shardID, newCutoff)
@@ -76,6 +87,16 @@ func (m *archiveJobManager) generateJobs() []Job {
 						jobDetail.Status = JobReady
 						jobDetail.CurrentCutoff = currentCutoff
 					})
+				} else if earlyCutoff > currentCutoff {
+					// live store is over its memory limit, archive the oldest live batch
+					// without waiting for the archiving interval.
+					job := m.scheduler.NewArchivingJob(tableName, shardID, earlyCutoff)
+					jobs = append(jobs, job)
+					utils.GetReporter(tableName, shardID).GetCounter(utils.LiveStoreEvictionCount).Inc(1)
+					m.reportArchiveJobDetail(key, func(jobDetail *ArchiveJobDetail) {
+						jobDetail.Status = JobReady
+						jobDetail.CurrentCutoff = currentCutoff
+					})
 				} else {
 					m.reportArchiveJobDetail(key, func(jobDetail *ArchiveJobDetail) {
 						jobDetail.Status = JobWaiting
@@ -90,6 +111,20 @@ func (m *archiveJobManager) generateJobs() []Job {
 	return jobs
 }
 
+// getEarlyArchivingCutoff returns the cutoff for archiving the oldest live batch of the table shard
+// under memory pressure, which is no less than the regular cutoff and no greater than now.
+// Caller needs to hold the read lock of table schema.
+func (m *archiveJobManager) getEarlyArchivingCutoff(tableShard *TableShard, regularCutoff, now uint32) uint32 {
+	cutoff := tableShard.LiveStore.getEarlyArchivingCutoff(tableShard.Schema.Schema.Config.AllowMissingEventTime)
+	if cutoff < regularCutoff {
+		cutoff = regularCutoff
+	}
+	if cutoff > now {
+		cutoff = now
+	}
+	return cutoff
+}
+
 func (m *archiveJobManager) getJobDetails() interface{} {
 	m.RLock()
 	defer m.RUnlock()
@@ -531,3 +566,263 @@ func (job *PurgeJob) String() string {
 	return fmt.Sprintf("PurgeJob<Table: %s, ShardID: %d>",
 		job.tableName, job.shardID)
 }
+
+type derivedColumnJobManager struct {
+	sync.RWMutex
+	// derived column job details for different tables, shard. Key is {tableName}|{shardID}|derived_column,
+	jobDetails map[string]*DerivedColumnJobDetail
+	memStore   *memStoreImpl
+	scheduler  *schedulerImpl
+}
+
+// newDerivedColumnJobManager creates a new jobManager to manage derived column jobs.
+func newDerivedColumnJobManager(scheduler *schedulerImpl) jobManager {
+	return &derivedColumnJobManager{
+		jobDetails: make(map[string]*DerivedColumnJobDetail),
+		memStore:   scheduler.memStore,
+		scheduler:  scheduler,
+	}
+}
+
+// generateJobs iterates each fact table shard from memStore and prepare list of derived column
+// jobs for shards having derived columns not completely backfilled.
+func (m *derivedColumnJobManager) generateJobs() []Job {
+	m.memStore.RLock()
+	defer m.memStore.RUnlock()
+
+	var jobs []Job
+	for tableName, shardMap := range m.memStore.TableShards {
+		for shardID, tableShard := range shardMap {
+			if !tableShard.Schema.Schema.IsFactTable {
+				continue
+			}
+			tableShard.Schema.RLock()
+			derivedColumns := tableShard.Schema.derivedColumns
+			tableShard.Schema.RUnlock()
+
+			for _, column := range derivedColumns {
+				lastBatchID, endBatchID, err := m.memStore.metaStore.GetDerivedColumnProgress(tableName, column.name, shardID)
+				if err != nil {
+					utils.GetLogger().With("table", tableName, "shard", shardID, "column", column.name,
+						"error", err.Error()).Error("Failed to get derived column progress")
+					continue
+				}
+				if endBatchID < 0 || lastBatchID < endBatchID {
+					jobs = append(jobs, m.scheduler.NewDerivedColumnJob(tableName, shardID))
+					m.reportDerivedColumnJobDetail(getIdentifier(tableName, shardID, common.DerivedColumnJobType),
+						func(jobDetail *DerivedColumnJobDetail) {
+							jobDetail.Status = JobReady
+						})
+					break
+				}
+			}
+		}
+	}
+	return jobs
+}
+
+func (m *derivedColumnJobManager) getJobDetails() interface{} {
+	m.RLock()
+	defer m.RUnlock()
+	return m.jobDetails
+}
+
+func (m *derivedColumnJobManager) getJobDetail(key string) *DerivedColumnJobDetail {
+	jobDetail, found := m.jobDetails[key]
+	if !found {
+		jobDetail = &DerivedColumnJobDetail{}
+		m.jobDetails[key] = jobDetail
+	}
+	return jobDetail
+}
+
+func (m *derivedColumnJobManager) reportJobDetail(key string, jobMutator jobDetailMutator) {
+	m.Lock()
+	defer m.Unlock()
+	derivedColumnJobDetail := m.getJobDetail(key)
+	jobDetail := &derivedColumnJobDetail.JobDetail
+	jobMutator(jobDetail)
+}
+
+// deleteTable deletes metadata for the table in derivedColumnJobManager.
+func (m *derivedColumnJobManager) deleteTable(table string) {
+	m.Lock()
+	defer m.Unlock()
+	for key := range m.jobDetails {
+		if strings.HasPrefix(key, table) {
+			delete(m.jobDetails, key)
+		}
+	}
+}
+
+func (m *derivedColumnJobManager) reportDerivedColumnJobDetail(key string, jobMutator DerivedColumnJobDetailMutator) {
+	m.Lock()
+	defer m.Unlock()
+	jobMutator(m.getJobDetail(key))
+}
+
+// DerivedColumnJob defines the structure that a derived column job needs.
+type DerivedColumnJob struct {
+	tableName string
+	shardID   int
+	memStore  MemStore
+	reporter  DerivedColumnJobDetailReporter
+}
+
+// Run starts the derived column backfill process and wait for it to finish.
+func (job *DerivedColumnJob) Run() error {
+	return job.memStore.BackfillDerivedColumns(job.tableName, job.shardID, job.reporter)
+}
+
+// GetIdentifier returns a unique identifier of this job.
+func (job *DerivedColumnJob) GetIdentifier() string {
+	return getIdentifier(job.tableName, job.shardID, common.DerivedColumnJobType)
+}
+
+// String gives meaningful string representation for this job
+func (job *DerivedColumnJob) String() string {
+	return fmt.Sprintf("DerivedColumnJob<Table: %s, ShardID: %d>",
+		job.tableName, job.shardID)
+}
+
+type rollupJobManager struct {
+	sync.RWMutex
+	// rollup job details for different tables, shard. Key is {tableName}|{shardID}|rollup,
+	jobDetails map[string]*RollupJobDetail
+	memStore   *memStoreImpl
+	scheduler  *schedulerImpl
+}
+
+// newRollupJobManager creates a new jobManager to manage rollup jobs.
+func newRollupJobManager(scheduler *schedulerImpl) jobManager {
+	return &rollupJobManager{
+		jobDetails: make(map[string]*RollupJobDetail),
+		memStore:   scheduler.memStore,
+		scheduler:  scheduler,
+	}
+}
+
+// generateJobs iterates each rollup table shard from memStore and prepare list of rollup jobs
+// for shards whose base table shard has archive batches archived or backfilled since they were
+// last aggregated. Each shard is checked against all archive batches in metaStore by the first
+// run after startup, and against the batches in memory afterwards.
+func (m *rollupJobManager) generateJobs() []Job {
+	m.memStore.RLock()
+	defer m.memStore.RUnlock()
+
+	var jobs []Job
+	for tableName, shardMap := range m.memStore.TableShards {
+		for shardID, tableShard := range shardMap {
+			tableShard.Schema.RLock()
+			config := tableShard.Schema.Schema.Config.Rollup
+			tableShard.Schema.RUnlock()
+			if config == nil {
+				continue
+			}
+
+			baseShard, ok := m.memStore.TableShards[config.BaseTable][shardID]
+			if !ok {
+				continue
+			}
+			if _, err := newRollup(tableShard.Schema, baseShard.Schema); err != nil {
+				utils.GetLogger().With("table", tableName, "shard", shardID, "baseTable", config.BaseTable,
+					"error", err.Error()).Error("Invalid rollup table")
+				continue
+			}
+
+			key := getIdentifier(tableName, shardID, common.RollupJobType)
+			if m.getJobStatus(key) == JobSucceeded {
+				progress, err := m.memStore.metaStore.GetRollupProgress(tableName, shardID)
+				if err != nil {
+					utils.GetLogger().With("table", tableName, "shard", shardID,
+						"error", err.Error()).Error("Failed to get rollup progress")
+					continue
+				}
+				if !baseShard.hasBatchesToRollup(progress) {
+					continue
+				}
+			}
+
+			jobs = append(jobs, m.scheduler.NewRollupJob(tableName, shardID))
+			m.reportRollupJobDetail(key, func(jobDetail *RollupJobDetail) {
+				jobDetail.Status = JobReady
+				jobDetail.BaseTable = config.BaseTable
+			})
+		}
+	}
+	return jobs
+}
+
+// getJobStatus returns the status of the last run of the job.
+func (m *rollupJobManager) getJobStatus(key string) JobStatus {
+	m.RLock()
+	defer m.RUnlock()
+	if jobDetail, found := m.jobDetails[key]; found {
+		return jobDetail.Status
+	}
+	return ""
+}
+
+func (m *rollupJobManager) getJobDetails() interface{} {
+	m.RLock()
+	defer m.RUnlock()
+	return m.jobDetails
+}
+
+func (m *rollupJobManager) getJobDetail(key string) *RollupJobDetail {
+	jobDetail, found := m.jobDetails[key]
+	if !found {
+		jobDetail = &RollupJobDetail{}
+		m.jobDetails[key] = jobDetail
+	}
+	return jobDetail
+}
+
+func (m *rollupJobManager) reportJobDetail(key string, jobMutator jobDetailMutator) {
+	m.Lock()
+	defer m.Unlock()
+	rollupJobDetail := m.getJobDetail(key)
+	jobDetail := &rollupJobDetail.JobDetail
+	jobMutator(jobDetail)
+}
+
+// deleteTable deletes metadata for the table in rollupJobManager.
+func (m *rollupJobManager) deleteTable(table string) {
+	m.Lock()
+	defer m.Unlock()
+	for key := range m.jobDetails {
+		if strings.HasPrefix(key, table) {
+			delete(m.jobDetails, key)
+		}
+	}
+}
+
+func (m *rollupJobManager) reportRollupJobDetail(key string, jobMutator RollupJobDetailMutator) {
+	m.Lock()
+	defer m.Unlock()
+	jobMutator(m.getJobDetail(key))
+}
+
+// RollupJob defines the structure that a rollup job needs.
+type RollupJob struct {
+	tableName string
+	shardID   int
+	memStore  MemStore
+	reporter  RollupJobDetailReporter
+}
+
+// Run starts the rollup process and wait for it to finish.
+func (job *RollupJob) Run() error {
+	return job.memStore.MaintainRollup(job.tableName, job.shardID, job.reporter)
+}
+
+// GetIdentifier returns a unique identifier of this job.
+func (job *RollupJob) GetIdentifier() string {
+	return getIdentifier(job.tableName, job.shardID, common.RollupJobType)
+}
+
+// String gives meaningful string representation for this job
+func (job *RollupJob) String() string {
+	return fmt.Sprintf("RollupJob<Table: %s, ShardID: %d>",
+		job.tableName, job.shardID)
+}
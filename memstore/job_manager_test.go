@@ -38,6 +38,7 @@ var _ = ginkgo.Describe("job manager", func() {
 	table1 := "Table1"
 	table2 := "Table2"
 	table3 := "Table3"
+	table4 := "Table4"
 
 	now := uint32(1498600000)
 
@@ -66,7 +67,7 @@ var _ = ginkgo.Describe("job manager", func() {
 			},
 			IsFactTable: true,
 		},
-	}, m.metaStore, m.diskStore, hostMemoryManager, 1)
+	}, m.metaStore, m.diskStore, hostMemoryManager, 1, Options{})
 
 	shard1.ArchiveStore = &ArchiveStore{
 		PurgeManager: NewPurgeManager(shard1),
@@ -86,7 +87,7 @@ var _ = ginkgo.Describe("job manager", func() {
 			},
 			IsFactTable: true,
 		},
-	}, m.metaStore, m.diskStore, hostMemoryManager, 2)
+	}, m.metaStore, m.diskStore, hostMemoryManager, 2, Options{})
 
 	shard2.LiveStore.BackfillManager.CurrentBufferSize = 15
 
@@ -109,7 +110,7 @@ var _ = ginkgo.Describe("job manager", func() {
 			},
 			IsFactTable: true,
 		},
-	}, m.metaStore, m.diskStore, hostMemoryManager, 1)
+	}, m.metaStore, m.diskStore, hostMemoryManager, 1, Options{})
 
 	shard3.LiveStore.BackfillManager.CurrentBufferSize = 15
 
@@ -129,7 +130,7 @@ var _ = ginkgo.Describe("job manager", func() {
 			},
 			IsFactTable: false,
 		},
-	}, m.metaStore, m.diskStore, hostMemoryManager, 1)
+	}, m.metaStore, m.diskStore, hostMemoryManager, 1, Options{})
 
 	shard4.LiveStore.SnapshotManager.NumMutations = 200
 
@@ -486,6 +487,102 @@ var _ = ginkgo.Describe("job manager", func() {
 		scheduler.RUnlock()
 	})
 
+	ginkgo.It("Test prepareDerivedColumnJobs", func() {
+		shard2.Schema.derivedColumns = []*derivedColumn{{name: "c1"}, {name: "c2"}}
+		defer func() {
+			shard2.Schema.derivedColumns = nil
+		}()
+		(m.metaStore).(*metaMocks.MetaStore).On(
+			"GetDerivedColumnProgress", table1, "c1", 2).Return(5, 5, nil).Once()
+		(m.metaStore).(*metaMocks.MetaStore).On(
+			"GetDerivedColumnProgress", table1, "c2", 2).Return(3, 5, nil).Once()
+
+		scheduler := newScheduler(m)
+		jobManager := scheduler.jobManagers[memCom.DerivedColumnJobType]
+		jobs := jobManager.generateJobs()
+		Ω(jobs).Should(HaveLen(1))
+		Ω(jobs[0]).Should(BeAssignableToTypeOf(&DerivedColumnJob{}))
+		derivedColumnJob := jobs[0].(*DerivedColumnJob)
+		Ω(derivedColumnJob.memStore).Should(Equal(m))
+		Ω(derivedColumnJob.tableName).Should(Equal(table1))
+		Ω(derivedColumnJob.shardID).Should(Equal(2))
+		Ω(derivedColumnJob.GetIdentifier()).Should(Equal("Table1|2|derived_column"))
+
+		scheduler.RLock()
+		jsonStr, _ := json.Marshal(jobManager.getJobDetails())
+		Ω(jsonStr).Should(MatchJSON(`
+		{
+			"Table1|2|derived_column": {
+				"status": "ready",
+				"nextRun": "0001-01-01T00:00:00Z",
+				"lastRun": "0001-01-01T00:00:00Z",
+				"lastStartTime": "0001-01-01T00:00:00Z",
+				"stage": "",
+				"column": "",
+				"lastBatchID": 0,
+				"endBatchID": 0
+			}
+		}
+		`))
+		scheduler.RUnlock()
+	})
+
+	ginkgo.It("Test prepareRollupJobs", func() {
+		rollupShard := NewTableShard(NewTableSchema(&metaCom.Table{
+			Name:        table4,
+			IsFactTable: true,
+			Columns: []metaCom.Column{
+				{Name: "hour", Type: metaCom.Uint32},
+				{Name: "trips", Type: metaCom.Uint32},
+			},
+			PrimaryKeyColumns: []int{0},
+			Config: metaCom.TableConfig{
+				Rollup: &metaCom.RollupConfig{
+					BaseTable:  table2,
+					TimeBucket: metaCom.RollupHour,
+					Measures:   []metaCom.RollupMeasure{{Column: "trips", Aggregate: metaCom.RollupCount}},
+				},
+			},
+		}), m.metaStore, m.diskStore, hostMemoryManager, 1, Options{})
+		m.TableShards[table4] = map[int]*TableShard{1: rollupShard}
+		defer delete(m.TableShards, table4)
+
+		scheduler := newScheduler(m)
+		jobManager := scheduler.jobManagers[memCom.RollupJobType]
+		jobs := jobManager.generateJobs()
+		Ω(jobs).Should(HaveLen(1))
+		Ω(jobs[0]).Should(BeAssignableToTypeOf(&RollupJob{}))
+		rollupJob := jobs[0].(*RollupJob)
+		Ω(rollupJob.memStore).Should(Equal(m))
+		Ω(rollupJob.tableName).Should(Equal(table4))
+		Ω(rollupJob.shardID).Should(Equal(1))
+		Ω(rollupJob.GetIdentifier()).Should(Equal("Table4|1|rollup"))
+
+		scheduler.RLock()
+		jsonStr, _ := json.Marshal(jobManager.getJobDetails())
+		Ω(jsonStr).Should(MatchJSON(`
+		{
+			"Table4|1|rollup": {
+				"status": "ready",
+				"nextRun": "0001-01-01T00:00:00Z",
+				"lastRun": "0001-01-01T00:00:00Z",
+				"lastStartTime": "0001-01-01T00:00:00Z",
+				"baseTable": "Table2",
+				"lastBatchID": 0
+			}
+		}
+		`))
+		scheduler.RUnlock()
+
+		// no jobs after the last run if no base batches changed.
+		jobManager.(*rollupJobManager).reportRollupJobDetail("Table4|1|rollup", func(jobDetail *RollupJobDetail) {
+			jobDetail.Status = JobSucceeded
+		})
+		(m.metaStore).(*metaMocks.MetaStore).On(
+			"GetRollupProgress", table4, 1).Return(map[int]metaCom.BatchVersion{}, nil).Once()
+		Ω(jobManager.generateJobs()).Should(BeEmpty())
+	})
+
 	ginkgo.It("Test Purge job", func() {
 		purgeJob := PurgeJob{
 			tableName: tableName,
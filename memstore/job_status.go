@@ -71,6 +71,16 @@ const (
 	PurgeComplete PurgeStage = "complete"
 )
 
+// DerivedColumnStage represents different stages of a running derived column job.
+type DerivedColumnStage string
+
+// List of derived column stages
+const (
+	DerivedColumnLiveStore    DerivedColumnStage = "backfill live store"
+	DerivedColumnArchiveStore DerivedColumnStage = "backfill archive store"
+	DerivedColumnComplete     DerivedColumnStage = "complete"
+)
+
 // ArchiveJobDetailMutator is the mutator functor to change ArchiveJobDetail.
 type ArchiveJobDetailMutator func(jobDetail *ArchiveJobDetail)
 
@@ -95,6 +105,18 @@ type SnapshotJobDetailReporter func(key string, mutator SnapshotJobDetailMutator
 // PurgeJobDetailReporter is the functor to apply mutator changes to corresponding JobDetail.
 type PurgeJobDetailReporter func(key string, mutator PurgeJobDetailMutator)
 
+// DerivedColumnJobDetailMutator is the mutator functor to change DerivedColumnJobDetail.
+type DerivedColumnJobDetailMutator func(jobDetail *DerivedColumnJobDetail)
+
+// DerivedColumnJobDetailReporter is the functor to apply mutator changes to corresponding JobDetail.
+type DerivedColumnJobDetailReporter func(key string, mutator DerivedColumnJobDetailMutator)
+
+// RollupJobDetailMutator is the mutator functor to change RollupJobDetail.
+type RollupJobDetailMutator func(jobDetail *RollupJobDetail)
+
+// RollupJobDetailReporter is the functor to apply mutator changes to corresponding JobDetail.
+type RollupJobDetailReporter func(key string, mutator RollupJobDetailMutator)
+
 // jobDetailMutator is the functor that change JobDetail.
 type jobDetailMutator func(jobDetail *JobDetail)
 
@@ -182,3 +204,25 @@ type PurgeJobDetail struct {
 	BatchIDStart int `json:"batchIDStart"`
 	BatchIDEnd   int `json:"batchIDEnd"`
 }
+
+// DerivedColumnJobDetail represents derived column job status of a table shard.
+type DerivedColumnJobDetail struct {
+	JobDetail
+	// Stage of the job is running.
+	Stage DerivedColumnStage `json:"stage"`
+	// Derived column being backfilled.
+	Column string `json:"column"`
+	// Last archive batch backfilled.
+	LastBatchID int `json:"lastBatchID"`
+	// Last archive batch to backfill.
+	EndBatchID int `json:"endBatchID"`
+}
+
+// RollupJobDetail represents rollup job status of a table shard.
+type RollupJobDetail struct {
+	JobDetail
+	// Base table aggregated.
+	BaseTable string `json:"baseTable"`
+	// Last archive batch of the base table aggregated.
+	LastBatchID int `json:"lastBatchID"`
+}
@@ -19,6 +19,7 @@ import (
 
 	"github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	aresCommon "github.com/uber/aresdb/common"
 	"github.com/uber/aresdb/memstore/common"
 	metaCom "github.com/uber/aresdb/metastore/common"
 	"sync"
@@ -39,7 +40,7 @@ var _ = ginkgo.Describe("json marshaller", func() {
 		Batches: map[int32]*LiveBatch{
 			int32(1): &liveBatch,
 		},
-		RedoLogManager: NewRedoLogManager(1, 1<<30, nil, "test", 1),
+		RedoLogManager: NewRedoLogManager(1, 1<<30, aresCommon.RedoLogSyncConfig{Policy: aresCommon.RedoLogSyncAsync}, nil, "test", 1),
 		BackfillManager: NewBackfillManager("ares_trips", 0, metaCom.TableConfig{
 			BackfillMaxBufferSize:    1 << 32,
 			BackfillThresholdInBytes: 1 << 21,
@@ -77,7 +78,7 @@ var _ = ginkgo.Describe("json marshaller", func() {
 			Batches: map[int32]*LiveBatch{
 				int32(1): &liveBatch,
 			},
-			RedoLogManager: NewRedoLogManager(1, 1<<30, nil, "test", 1),
+			RedoLogManager: NewRedoLogManager(1, 1<<30, aresCommon.RedoLogSyncConfig{Policy: aresCommon.RedoLogSyncAsync}, nil, "test", 1),
 			BackfillManager: NewBackfillManager("ares_trips", 0, metaCom.TableConfig{
 				BackfillMaxBufferSize:    1 << 32,
 				BackfillThresholdInBytes: 1 << 21,
@@ -160,12 +161,16 @@ var _ = ginkgo.Describe("json marshaller", func() {
 			"redoLogManager": {
 			  "rotationInterval": 1,
 			  "maxRedoLogSize": 1073741824,
+			  "retentionInterval": 0,
 			  "currentRedoLogSize": 0,
 			  "maxEventTimePerFile": {},
 			  "sizePerFile": {},
 			  "totalRedologSize": 0,
 			  "batchCountPerFile": {},
-			  "currentFileCreationTime": 0
+			  "currentFileCreationTime": 0,
+			  "syncConfig": {
+			    "policy": "async"
+			  }
 			},
 			"backfillManager": {
               "currentBufferSize": 0,
@@ -229,12 +234,16 @@ var _ = ginkgo.Describe("json marshaller", func() {
 		Ω(jsonStr).Should(MatchJSON(`{
 			"rotationInterval": 1,
 			"maxRedoLogSize": 1073741824,
+			"retentionInterval": 0,
 			"totalRedologSize": 0,
 			"sizePerFile": {},
 			"currentRedoLogSize": 0,
 			"maxEventTimePerFile": {},
 			"batchCountPerFile": {},
-			"currentFileCreationTime": 0
+			"currentFileCreationTime": 0,
+			"syncConfig": {
+			  "policy": "async"
+			}
 		  }`))
 	})
 
@@ -289,12 +298,16 @@ var _ = ginkgo.Describe("json marshaller", func() {
           "redoLogManager": {
             "rotationInterval": 1,
 			"maxRedoLogSize": 1073741824,
+			"retentionInterval": 0,
 			"totalRedologSize": 0,
 			"currentRedoLogSize": 0,
 			"maxEventTimePerFile": {},
 			"sizePerFile": {},
             "batchCountPerFile": {},
-            "currentFileCreationTime": 0
+            "currentFileCreationTime": 0,
+            "syncConfig": {
+              "policy": "async"
+            }
           },
           "backfillManager": {
             "currentBufferSize": 0,
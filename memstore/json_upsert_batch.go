@@ -0,0 +1,235 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	memCom "github.com/uber/aresdb/memstore/common"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/utils"
+)
+
+const defaultJSONArraySeparator = ","
+
+// flattenJSON adds the values of the nested object into values by their dotted paths.
+func flattenJSON(prefix string, object map[string]interface{}, values map[string]interface{}) error {
+	for key, value := range object {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			if err := flattenJSON(path, nested, values); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, exist := values[path]; exist {
+			return utils.StackError(nil, "Path %s appears more than once", path)
+		}
+		values[path] = value
+	}
+	return nil
+}
+
+// jsonScalarString returns the string of a JSON string, number or bool.
+func jsonScalarString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
+
+// convertJSONValue converts the non null JSON value of the column into the value accepted by the
+// upsert batch builder. Strings of enum columns are looked up in the enum dictionary while numbers
+// are taken as enum ids, and arrays of enum array columns are converted into bitmaps of the enum
+// cases. Other values are coerced into the column data type, e.g. "12" into 12 for integer columns,
+// numbers with fractions are rejected for integer columns. Caller should hold the schema lock.
+func convertJSONValue(schema *TableSchema, column metaCom.Column, value interface{},
+	config metaCom.JSONIngestionConfig) (interface{}, error) {
+	if column.IsEnumArrayColumn() {
+		elements, ok := value.([]interface{})
+		if !ok {
+			return nil, utils.StackError(nil, "Expect an array of enum cases, got %v", value)
+		}
+		var bitmap uint32
+		for _, element := range elements {
+			enumCase, ok := element.(string)
+			enumID, exist := schema.EnumDicts[column.Name].Dict[enumCase]
+			if !ok || !exist {
+				return nil, utils.StackError(nil, "Unknown enum case %v", element)
+			}
+			bitmap |= 1 << uint(enumID)
+		}
+		return bitmap, nil
+	}
+
+	if elements, ok := value.([]interface{}); ok {
+		if config.Arrays != metaCom.JSONArrayJoin {
+			return nil, utils.StackError(nil, "Arrays are not allowed")
+		}
+		separator := config.ArraySeparator
+		if separator == "" {
+			separator = defaultJSONArraySeparator
+		}
+		strs := make([]string, 0, len(elements))
+		for _, element := range elements {
+			if element == nil {
+				continue
+			}
+			str, ok := jsonScalarString(element)
+			if !ok {
+				return nil, utils.StackError(nil, "Nested array element %v cannot be joined", element)
+			}
+			strs = append(strs, str)
+		}
+		value = strings.Join(strs, separator)
+	}
+
+	if column.IsEnumColumn() {
+		if enumCase, ok := value.(string); ok {
+			enumID, exist := schema.EnumDicts[column.Name].Dict[enumCase]
+			if !exist {
+				return nil, utils.StackError(nil, "Unknown enum case %s", enumCase)
+			}
+			return enumID, nil
+		}
+	}
+
+	switch v := value.(type) {
+	case json.Number:
+		// parsed by the data type, so fractions are not truncated for integer columns.
+		return v.String(), nil
+	case string, bool, float64:
+		return v, nil
+	}
+	return nil, utils.StackError(nil, "Unsupported JSON value %v", value)
+}
+
+// NewUpsertBatchFromJSON converts rows of nested JSON objects into an upsert batch of the table.
+// Rows are flattened into columns by the JSON ingestion config of the table, numbers should be
+// decoded as json.Number to keep the precision of int64 values. A value that cannot be converted
+// into the column data type fails the whole batch. Unless missing paths are rejected, columns
+// missing in a row are ingested with the default value of the column, or null without one.
+// User should not lock schema.
+func NewUpsertBatchFromJSON(schema *TableSchema, rows []map[string]interface{}) (*UpsertBatch, error) {
+	schema.RLock()
+	defer schema.RUnlock()
+
+	var config metaCom.JSONIngestionConfig
+	if schema.Schema.Config.JSONIngestion != nil {
+		config = *schema.Schema.Config.JSONIngestion
+	}
+
+	// values of rows by column id.
+	rowValues := make([]map[int]interface{}, len(rows))
+	seen := make(map[int]bool)
+	var columnIDs []int
+	for row, object := range rows {
+		values := make(map[string]interface{})
+		if err := flattenJSON("", object, values); err != nil {
+			return nil, utils.StackError(err, "Invalid object at row %d", row)
+		}
+
+		rowValues[row] = make(map[int]interface{}, len(values))
+		for path, value := range values {
+			columnName := path
+			if name, ok := config.Paths[path]; ok {
+				columnName = name
+			}
+			columnID, ok := schema.ColumnIDs[columnName]
+			if !ok || schema.Schema.Columns[columnID].Deleted {
+				continue
+			}
+			if _, exist := rowValues[row][columnID]; exist {
+				return nil, utils.StackError(nil, "Column %s is ingested from more than one path at row %d",
+					columnName, row)
+			}
+			rowValues[row][columnID] = value
+			if !seen[columnID] {
+				seen[columnID] = true
+				columnIDs = append(columnIDs, columnID)
+			}
+		}
+	}
+
+	for columnID, column := range schema.Schema.Columns {
+		if column.Deleted || column.DerivedExpr != "" {
+			continue
+		}
+		for row := range rowValues {
+			if _, ok := rowValues[row][columnID]; ok {
+				continue
+			}
+			if config.MissingPaths == metaCom.JSONMissingPathError {
+				return nil, utils.StackError(nil, "Column %s is missing at row %d", column.Name, row)
+			}
+			// enum array columns have no default value.
+			if column.DefaultValue == nil || column.IsEnumArrayColumn() {
+				break
+			}
+			rowValues[row][columnID] = *column.DefaultValue
+			if !seen[columnID] {
+				seen[columnID] = true
+				columnIDs = append(columnIDs, columnID)
+			}
+		}
+	}
+
+	sort.Ints(columnIDs)
+	builder := memCom.NewUpsertBatchBuilder()
+	for _, columnID := range columnIDs {
+		if err := builder.AddColumn(columnID, schema.ValueTypeByColumn[columnID]); err != nil {
+			return nil, err
+		}
+	}
+
+	for row, values := range rowValues {
+		builder.AddRow()
+		for col, columnID := range columnIDs {
+			// null values and missing values of columns without default value are ingested as null.
+			value := values[columnID]
+			if value == nil {
+				continue
+			}
+
+			column := schema.Schema.Columns[columnID]
+			value, err := convertJSONValue(schema, column, value, config)
+			if err != nil {
+				return nil, utils.StackError(err, "Column %s: invalid value at row %d", column.Name, row)
+			}
+			if err = builder.SetValue(row, col, value); err != nil {
+				return nil, utils.StackError(err, "Column %s: invalid value %v at row %d", column.Name, value, row)
+			}
+		}
+	}
+
+	buffer, err := builder.ToByteArray()
+	if err != nil {
+		return nil, err
+	}
+	return NewUpsertBatch(buffer)
+}
@@ -0,0 +1,177 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metaCom "github.com/uber/aresdb/metastore/common"
+)
+
+var _ = ginkgo.Describe("json upsert batch", func() {
+	var schema *TableSchema
+
+	ginkgo.BeforeEach(func() {
+		schema = NewTableSchema(&metaCom.Table{
+			Name:        "trips",
+			IsFactTable: true,
+			Columns: []metaCom.Column{
+				{Name: "request_at", Type: metaCom.Uint32},
+				{Name: "completed", Type: metaCom.Bool},
+				{Name: "user.id", Type: metaCom.Int64},
+				{Name: "country", Type: metaCom.SmallEnum},
+				{Name: "fare", Type: metaCom.Float32},
+				{Name: "tags", Type: metaCom.EnumArray},
+				{Name: "notes", Type: metaCom.UUID},
+				{Name: "old", Type: metaCom.Int32, Deleted: true},
+			},
+			PrimaryKeyColumns: []int{2},
+			Config: metaCom.TableConfig{
+				JSONIngestion: &metaCom.JSONIngestionConfig{
+					Paths: map[string]string{"user.address.country": "country"},
+				},
+			},
+		})
+		schema.EnumDicts["country"] = EnumDict{
+			Capacity:    0x100,
+			Dict:        map[string]int{"us": 0, "fr": 1},
+			ReverseDict: []string{"us", "fr"},
+		}
+		schema.EnumDicts["tags"] = EnumDict{
+			Capacity:    32,
+			Dict:        map[string]int{"pool": 0, "airport": 1, "night": 2},
+			ReverseDict: []string{"pool", "airport", "night"},
+		}
+	})
+
+	decodeRows := func(data string) []map[string]interface{} {
+		decoder := json.NewDecoder(bytes.NewBufferString(data))
+		decoder.UseNumber()
+		var rows []map[string]interface{}
+		Ω(decoder.Decode(&rows)).Should(BeNil())
+		return rows
+	}
+
+	ginkgo.It("flattens nested objects into columns", func() {
+		upsertBatch, err := NewUpsertBatchFromJSON(schema, decodeRows(`[
+			{"request_at": 100, "completed": true, "fare": 1.5, "tags": ["night", "pool"],
+			 "user": {"id": 9007199254740993, "address": {"country": "fr", "city": "paris"}}, "old": 1},
+			{"request_at": 200, "completed": null, "user": {"id": 2, "address": {}}, "tags": []}
+		]`))
+		Ω(err).Should(BeNil())
+		Ω(upsertBatch.NumRows).Should(Equal(2))
+
+		columnNames, err := upsertBatch.GetColumnNames(schema)
+		Ω(err).Should(BeNil())
+		Ω(columnNames).Should(Equal([]string{"request_at", "completed", "user.id", "country", "fare", "tags"}))
+
+		rows, err := upsertBatch.ReadData(0, 2)
+		Ω(err).Should(BeNil())
+		Ω(rows).Should(Equal([][]interface{}{
+			{uint32(100), true, int64(9007199254740993), uint8(1), float32(1.5), uint32(5)},
+			{uint32(200), nil, int64(2), nil, nil, uint32(0)},
+		}))
+	})
+
+	ginkgo.It("coerces values into column types", func() {
+		schema.Schema.Config.JSONIngestion.Arrays = metaCom.JSONArrayJoin
+		upsertBatch, err := NewUpsertBatchFromJSON(schema, decodeRows(`[
+			{"request_at": "100", "completed": "false", "user": {"id": "3"}, "country": 0, "fare": "2"},
+			{"request_at": 200, "completed": 1, "user": {"id": -4}, "fare": 3}
+		]`))
+		Ω(err).Should(BeNil())
+		rows, err := upsertBatch.ReadData(0, 2)
+		Ω(err).Should(BeNil())
+		Ω(rows).Should(Equal([][]interface{}{
+			{uint32(100), false, int64(3), uint8(0), float32(2)},
+			{uint32(200), true, int64(-4), nil, float32(3)},
+		}))
+
+		schema.Schema.Config.JSONIngestion.ArraySeparator = "-"
+		upsertBatch, err = NewUpsertBatchFromJSON(schema, decodeRows(`[
+			{"request_at": 100, "user": {"id": 1}, "notes": ["01234567", "89ab", "cdef", "0123", "456789abcdef"]}
+		]`))
+		Ω(err).Should(BeNil())
+		rows, err = upsertBatch.ReadData(0, 1)
+		Ω(err).Should(BeNil())
+		Ω(rows).Should(Equal([][]interface{}{
+			{uint32(100), int64(1), "01234567-89ab-cdef-0123-456789abcdef"},
+		}))
+	})
+
+	ginkgo.It("rejects invalid values", func() {
+		for _, data := range []string{
+			// fractions for integer columns.
+			`[{"request_at": 1.5}]`,
+			// bools for integer columns.
+			`[{"request_at": 100, "user": {"id": true}}]`,
+			// unknown enum cases.
+			`[{"request_at": 100, "user": {"address": {"country": "de"}}}]`,
+			`[{"request_at": 100, "tags": ["pool", "rain"]}]`,
+			// arrays are rejected by default.
+			`[{"request_at": 100, "fare": [1, 2]}]`,
+			// the same column from two paths.
+			`[{"request_at": 100, "country": "us", "user": {"address": {"country": "fr"}}}]`,
+			// the same path twice.
+			`[{"request_at": 100, "user.id": 1, "user": {"id": 2}}]`,
+		} {
+			_, err := NewUpsertBatchFromJSON(schema, decodeRows(data))
+			Ω(err).ShouldNot(BeNil(), data)
+		}
+	})
+
+	ginkgo.It("handles missing paths by config", func() {
+		completeRow := `{"request_at": 100, "completed": true, "user": {"id": 1, "address": {"country": "us"}},
+			"fare": 1, "tags": [], "notes": "01234567-89ab-cdef-0123-456789abcdef"}`
+		data := "[" + completeRow + `, {"request_at": 200}]`
+		upsertBatch, err := NewUpsertBatchFromJSON(schema, decodeRows(data))
+		Ω(err).Should(BeNil())
+		rows, err := upsertBatch.ReadData(1, 1)
+		Ω(err).Should(BeNil())
+		Ω(rows).Should(Equal([][]interface{}{{uint32(200), nil, nil, nil, nil, nil, nil}}))
+
+		// missing paths get the default value of their columns while nulls stay null.
+		defaultFare, defaultCountry, defaultCompleted := "2.5", "fr", "true"
+		schema.Schema.Columns[1].DefaultValue = &defaultCompleted
+		schema.Schema.Columns[3].DefaultValue = &defaultCountry
+		schema.Schema.Columns[4].DefaultValue = &defaultFare
+		upsertBatch, err = NewUpsertBatchFromJSON(schema, decodeRows(`[
+			{"request_at": 100, "completed": null, "user": {"id": 1, "address": {}}},
+			{"request_at": 200, "fare": 1, "country": "us"}
+		]`))
+		Ω(err).Should(BeNil())
+		columnNames, err := upsertBatch.GetColumnNames(schema)
+		Ω(err).Should(BeNil())
+		Ω(columnNames).Should(Equal([]string{"request_at", "completed", "user.id", "country", "fare"}))
+		rows, err = upsertBatch.ReadData(0, 2)
+		Ω(err).Should(BeNil())
+		Ω(rows).Should(Equal([][]interface{}{
+			{uint32(100), nil, int64(1), uint8(1), float32(2.5)},
+			{uint32(200), true, nil, uint8(0), float32(1)},
+		}))
+
+		schema.Schema.Config.JSONIngestion.MissingPaths = metaCom.JSONMissingPathError
+		_, err = NewUpsertBatchFromJSON(schema, decodeRows(data))
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("Column completed is missing at row 1"))
+
+		upsertBatch, err = NewUpsertBatchFromJSON(schema, decodeRows("["+completeRow+"]"))
+		Ω(err).Should(BeNil())
+		Ω(upsertBatch.NumRows).Should(Equal(1))
+	})
+})
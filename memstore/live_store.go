@@ -77,6 +77,11 @@ type LiveStore struct {
 	// For convenience. Schema locks should be acquired after data locks.
 	tableSchema *TableSchema
 
+	// Bounded queue of the upsert batches being ingested, including those waiting for the writer
+	// lock. An upsert batch holds a slot from the time it's accepted until it's applied, and is
+	// rejected if the queue is full. Nil means unbounded.
+	pendingUpsertBatches chan struct{}
+
 	// The writer lock is to guarantee single writer to a Shard at all time. To ensure this, writers
 	// (ingestion, archiving etc) need to hold this lock at all times. This lock
 	// should be acquired before the VectorStore and Batch locks.
@@ -114,9 +119,11 @@ func NewLiveStore(batchSize int, shard *TableShard) *LiveStore {
 		PrimaryKey:      NewPrimaryKey(schema.PrimaryKeyBytes, schema.Schema.IsFactTable, schema.Schema.Config.InitialPrimaryKeyNumBuckets, shard.HostMemoryManager),
 		// TODO: support table specific log rotation interval.
 		RedoLogManager: NewRedoLogManager(int64(tableCfg.RedoLogRotationInterval), int64(tableCfg.MaxRedoLogFileSize),
-			shard.diskStore, schema.Schema.Name, shard.ShardID),
-		HostMemoryManager: shard.HostMemoryManager,
+			shard.options.RedoLogSync, shard.diskStore, schema.Schema.Name, shard.ShardID),
+		HostMemoryManager:    shard.HostMemoryManager,
+		pendingUpsertBatches: make(chan struct{}, shard.options.maxPendingUpsertBatches()),
 	}
+	ls.RedoLogManager.RetentionInterval = int64(tableCfg.RedoLogRetentionInterval)
 
 	if schema.Schema.IsFactTable {
 		ls.BackfillManager = NewBackfillManager(schema.Schema.Name, shard.ShardID, schema.Schema.Config)
@@ -129,6 +136,39 @@ func NewLiveStore(batchSize int, shard *TableShard) *LiveStore {
 	return ls
 }
 
+// maxPendingUpsertBatches returns the capacity of the queue of upsert batches being ingested
+// into a shard.
+func (o Options) maxPendingUpsertBatches() int {
+	if o.MaxPendingUpsertBatches > 0 {
+		return o.MaxPendingUpsertBatches
+	}
+	return defaultMaxPendingUpsertBatches
+}
+
+// enqueueUpsertBatch takes a slot in the queue of pending upsert batches, it returns false if the
+// queue is full. It also returns the number of upsert batches pending.
+func (s *LiveStore) enqueueUpsertBatch() (int, bool) {
+	if s.pendingUpsertBatches == nil {
+		return 0, true
+	}
+	select {
+	case s.pendingUpsertBatches <- struct{}{}:
+		return len(s.pendingUpsertBatches), true
+	default:
+		return len(s.pendingUpsertBatches), false
+	}
+}
+
+// dequeueUpsertBatch releases the slot of an upsert batch taken by enqueueUpsertBatch and returns
+// the number of upsert batches pending.
+func (s *LiveStore) dequeueUpsertBatch() int {
+	if s.pendingUpsertBatches == nil {
+		return 0
+	}
+	<-s.pendingUpsertBatches
+	return len(s.pendingUpsertBatches)
+}
+
 // GetBatchIDs snapshots the batches and returns a list of batch ids for read
 // with the number of records in batchIDs[len()-1].
 func (s *LiveStore) GetBatchIDs() (batchIDs []int32, numRecordsInLastBatch int) {
@@ -395,6 +435,23 @@ func (s *LiveStore) GetMemoryUsageForColumn(valueType common.DataType, columnID
 	return liveStoreMemory
 }
 
+// GetMemoryUsage returns the total bytes of all vector parties in the live store.
+func (s *LiveStore) GetMemoryUsage() int64 {
+	var bytes int64
+	s.RLock()
+	for _, batch := range s.Batches {
+		batch.RLock()
+		for _, column := range batch.Columns {
+			if column != nil {
+				bytes += column.GetBytes()
+			}
+		}
+		batch.RUnlock()
+	}
+	s.RUnlock()
+	return bytes
+}
+
 // GetOrCreateVectorParty returns LiveVectorParty for the specified column from
 // the live batch. locked specifies whether the batch has been locked.
 // The lock will be left in the same state after the function returns.
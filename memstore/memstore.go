@@ -15,26 +15,55 @@
 package memstore
 
 import (
+	"errors"
+	"io"
 	"sync"
+	"time"
 
 	"fmt"
+	aresCommon "github.com/uber/aresdb/common"
 	"github.com/uber/aresdb/diskstore"
 	"github.com/uber/aresdb/memstore/common"
 	"github.com/uber/aresdb/metastore"
+	metaCom "github.com/uber/aresdb/metastore/common"
 	"github.com/uber/aresdb/utils"
 )
 
+// ErrDeleteDimensionTableRows is returned by DeleteRows for dimension tables.
+var ErrDeleteDimensionTableRows = errors.New("rows can only be deleted from fact tables")
+
 // TableShardMemoryUsage contains memory usage for column memory and primary key memory usage
 type TableShardMemoryUsage struct {
 	ColumnMemory     map[string]*common.ColumnMemoryUsage `json:"cols"`
 	PrimaryKeyMemory uint                                 `json:"pk"`
 }
 
+// TableUsage contains the memory and disk usage of a table summed across its shards.
+type TableUsage struct {
+	// Bytes of vector parties and primary key of the live store.
+	LiveBytes uint `json:"liveBytes"`
+	// Number of batches in the current archive store version.
+	NumArchiveBatches int `json:"numArchiveBatches"`
+	// Bytes of redo logs, snapshots and archived vector parties on disk.
+	DiskBytes uint `json:"diskBytes"`
+	// Bytes of redo logs on disk.
+	RedoLogBytes uint `json:"redoLogBytes"`
+	// Number of rows in live batches and archive batches.
+	NumLiveRows    int `json:"numLiveRows"`
+	NumArchiveRows int `json:"numArchiveRows"`
+	// Finish time of the last archiving job of any shard, not set for dimension tables or fact
+	// tables that are never archived.
+	LastArchivingTime *time.Time `json:"lastArchivingTime,omitempty"`
+}
+
 // MemStore defines the interface for managing multiple table shards in memory. This is for mocking
 // in unit tests
 type MemStore interface {
 	// GetMemoryUsageDetails
 	GetMemoryUsageDetails() (map[string]TableShardMemoryUsage, error)
+	// GetTableUsageDetails returns the memory and disk usage by table name. Only the usage of
+	// the given table is returned if table is not empty.
+	GetTableUsageDetails(table string) (map[string]TableUsage, error)
 	// GetScheduler returns the scheduler for scheduling archiving and backfill jobs.
 	GetScheduler() Scheduler
 	// GetTableShard gets the data for a pinned table Shard. Caller needs to unpin after use.
@@ -64,6 +93,50 @@ type MemStore interface {
 	// Purge is the process to purge out of retention archive batches
 	Purge(table string, shardID, batchIDStart, batchIDEnd int, reporter PurgeJobDetailReporter) error
 
+	// BackfillDerivedColumns is the process computing values of derived columns for rows
+	// ingested before the columns were added, a limited number of archive batches per run.
+	BackfillDerivedColumns(table string, shardID int, reporter DerivedColumnJobDetailReporter) error
+
+	// MaintainRollup is the process aggregating archive batches of the base table into the rollup
+	// table, for batches archived or backfilled since they were last aggregated.
+	MaintainRollup(table string, shardID int, reporter RollupJobDetailReporter) error
+
+	// ArchiveTable archives all shards of the fact table up to the cutoff through the scheduler
+	// and waits for archiving to finish. It fails with ErrArchivingInProgress if the table is
+	// already being archived on demand, and with ErrArchivingCutoffInFuture if the cutoff is later
+	// than now.
+	ArchiveTable(table string, cutoff uint32) (ArchiveTableResult, error)
+
+	// ExportTable writes the archived rows of the fact table with event time in [from, to) to
+	// Parquet files under the output path, chunked by shard and archive batch. Returns
+	// ErrExportOutputNotAllowed if the output path is outside of the export locations of the config.
+	ExportTable(table string, from, to uint32, output string, config aresCommon.ExportConfig) (ExportTableResult, error)
+
+	// WriteArchivedShard writes the archive batches of the fact table shard to w as a tar stream,
+	// to be loaded by LoadArchivedShard on another instance.
+	WriteArchivedShard(table string, shardID int, w io.Writer) error
+
+	// LoadArchivedShard loads the archive batches written by WriteArchivedShard into the fact table
+	// shard and reloads it. Returns ErrArchivedShardExists if the shard already has archive batches.
+	LoadArchivedShard(table string, shardID int, r io.Reader) error
+
+	// DropShard unloads the table shard and deletes its data from disk.
+	DropShard(table string, shardID int) error
+
+	// DeleteRows marks rows of the fact table matching the filter with event time before now as
+	// deleted in both live and archive stores. The predicate is persisted in metaStore and
+	// deleted rows are excluded from queries, rows ingested later are kept unless their event
+	// time is before the deletion.
+	DeleteRows(table string, filter string) (metaCom.DeletePredicate, error)
+
+	// RemoveDeletePredicate removes the delete predicate of the table, so that rows it deleted are
+	// no longer excluded from queries.
+	RemoveDeletePredicate(table string, id int) error
+
+	// Close fsyncs and closes the redo log files of all table shards on shutdown, after ingestion
+	// has stopped.
+	Close()
+
 	// Provide exclusive access to read/write data protected by MemStore.
 	utils.RWLocker
 }
@@ -98,6 +171,50 @@ type memStoreImpl struct {
 
 	// each MemStore should only have one scheduler instance.
 	scheduler Scheduler
+
+	// Protects archivingTables.
+	archivingTablesLock sync.Mutex
+	// Tables being archived on demand.
+	archivingTables map[string]bool
+
+	options Options
+}
+
+// Options configures a MemStore and the table shards it creates.
+type Options struct {
+	// Default size limit in bytes of the live store of a fact table shard, archiving is
+	// triggered early when exceeded. 0 means unlimited. Can be overridden per table.
+	LiveStoreMemoryLimit int64
+
+	// Max number of upsert batches of a table shard waiting to be written into the redo log,
+	// ingestion requests are rejected when exceeded. 1000 if 0.
+	MaxPendingUpsertBatches int
+
+	// Default max number of distinct primary keys in the live store of a table shard, rows
+	// adding keys beyond it are dropped. 0 means unlimited. Can be overridden per table.
+	MaxPrimaryKeys int
+
+	// Max number of idempotency keys of recently applied upsert batches remembered per table
+	// shard. 0 disables deduplication.
+	MaxIngestionKeys int
+
+	// Seconds to remember the idempotency key of an applied upsert batch.
+	IngestionKeyTTL int64
+
+	// Fsync policy of redo log files, the disk store must be created with the same policy.
+	RedoLogSync aresCommon.RedoLogSyncConfig
+}
+
+// NewOptions creates the Options of a MemStore from the server config.
+func NewOptions(cfg aresCommon.AresServerConfig) Options {
+	return Options{
+		LiveStoreMemoryLimit:    cfg.LiveStoreMemoryLimit,
+		MaxPendingUpsertBatches: cfg.MaxPendingUpsertBatches,
+		MaxPrimaryKeys:          cfg.MaxPrimaryKeys,
+		MaxIngestionKeys:        cfg.MaxIngestionKeys,
+		IngestionKeyTTL:         cfg.IngestionKeyTTL,
+		RedoLogSync:             utils.GetRedoLogSyncConfig(cfg.DiskStore),
+	}
 }
 
 func getTableShardKey(tableName string, shardID int) string {
@@ -105,12 +222,13 @@ func getTableShardKey(tableName string, shardID int) string {
 }
 
 // NewMemStore creates a MemStore from the specified MetaStore.
-func NewMemStore(metaStore metastore.MetaStore, diskStore diskstore.DiskStore) MemStore {
+func NewMemStore(metaStore metastore.MetaStore, diskStore diskstore.DiskStore, options Options) MemStore {
 	memStore := &memStoreImpl{
 		TableShards:  make(map[string]map[int]*TableShard),
 		TableSchemas: make(map[string]*TableSchema),
 		metaStore:    metaStore,
 		diskStore:    diskStore,
+		options:      options,
 	}
 	// Create HostMemoryManager
 	memStore.HostMemManager = NewHostMemoryManager(memStore, utils.GetConfig().TotalMemorySize)
@@ -118,6 +236,20 @@ func NewMemStore(metaStore metastore.MetaStore, diskStore diskstore.DiskStore) M
 	return memStore
 }
 
+// Close fsyncs and closes the redo log files of all table shards. Upsert batches ingested
+// afterwards are written into new redo log files.
+func (m *memStoreImpl) Close() {
+	m.RLock()
+	defer m.RUnlock()
+	for _, shards := range m.TableShards {
+		for _, shard := range shards {
+			shard.LiveStore.WriterLock.Lock()
+			shard.LiveStore.RedoLogManager.Close()
+			shard.LiveStore.WriterLock.Unlock()
+		}
+	}
+}
+
 func (m *memStoreImpl) GetMemoryUsageDetails() (map[string]TableShardMemoryUsage, error) {
 	archiveMemoryUsageByTableShard, err := m.HostMemManager.GetArchiveMemoryUsageByTableShard()
 	if err != nil {
@@ -167,6 +299,89 @@ func (m *memStoreImpl) GetMemoryUsageDetails() (map[string]TableShardMemoryUsage
 	return totalMemoryUsageByTableShard, nil
 }
 
+func (m *memStoreImpl) GetTableUsageDetails(table string) (map[string]TableUsage, error) {
+	tableUsages := map[string]TableUsage{}
+	tableShardsSnapshot := map[string][]int{}
+	m.RLock()
+	if _, ok := m.TableSchemas[table]; table != "" && !ok {
+		m.RUnlock()
+		return nil, utils.StackError(nil, "Failed to get table schema for table %s", table)
+	}
+	for tableName := range m.TableSchemas {
+		if table != "" && tableName != table {
+			continue
+		}
+		tableUsages[tableName] = TableUsage{}
+		tableShardsSnapshot[tableName] = []int{}
+		for shardID := range m.TableShards[tableName] {
+			tableShardsSnapshot[tableName] = append(tableShardsSnapshot[tableName], shardID)
+		}
+	}
+	m.RUnlock()
+
+	lastArchivingTimes := m.getLastArchivingTimes()
+
+	for tableName, shardIDs := range tableShardsSnapshot {
+		tableUsage := tableUsages[tableName]
+		for _, shardID := range shardIDs {
+			shard, err := m.GetTableShard(tableName, shardID)
+			if err != nil {
+				// The shard is deleted after taking the snapshot.
+				continue
+			}
+
+			diskBytes, err := m.diskStore.GetTableShardDiskUsage(tableName, shardID)
+			if err != nil {
+				shard.Users.Done()
+				return tableUsages, err
+			}
+			tableUsage.DiskBytes += uint(diskBytes)
+
+			shard.LiveStore.WriterLock.RLock()
+			tableUsage.LiveBytes += uint(shard.LiveStore.GetMemoryUsage()) + shard.LiveStore.PrimaryKey.AllocatedBytes()
+			tableUsage.RedoLogBytes += shard.LiveStore.RedoLogManager.TotalRedoLogSize
+			shard.LiveStore.WriterLock.RUnlock()
+
+			batchIDs, numRecordsInLastBatch := shard.LiveStore.GetBatchIDs()
+			if len(batchIDs) > 0 {
+				tableUsage.NumLiveRows += (len(batchIDs)-1)*shard.LiveStore.BatchSize + numRecordsInLastBatch
+			}
+
+			version := shard.ArchiveStore.GetCurrentVersion()
+			version.RLock()
+			tableUsage.NumArchiveBatches += len(version.Batches)
+			for _, batch := range version.Batches {
+				tableUsage.NumArchiveRows += batch.Size
+			}
+			version.RUnlock()
+			version.Users.Done()
+
+			if lastRun, ok := lastArchivingTimes[getIdentifier(tableName, shardID, common.ArchivingJobType)]; ok {
+				if tableUsage.LastArchivingTime == nil || lastRun.After(*tableUsage.LastArchivingTime) {
+					tableUsage.LastArchivingTime = &lastRun
+				}
+			}
+			shard.Users.Done()
+		}
+		tableUsages[tableName] = tableUsage
+	}
+	return tableUsages, nil
+}
+
+// getLastArchivingTimes returns the finish time of the last archiving job by job identifier.
+func (m *memStoreImpl) getLastArchivingTimes() map[string]time.Time {
+	lastArchivingTimes := map[string]time.Time{}
+	m.scheduler.RLock()
+	defer m.scheduler.RUnlock()
+	jobDetails, _ := m.scheduler.GetJobDetails(common.ArchivingJobType).(map[string]*ArchiveJobDetail)
+	for key, jobDetail := range jobDetails {
+		if jobDetail.LastRun.Unix() > 0 {
+			lastArchivingTimes[key] = jobDetail.LastRun
+		}
+	}
+	return lastArchivingTimes
+}
+
 func (shard *TableShard) getLiveMemoryUsageByColumns(columnMemory map[string]*common.ColumnMemoryUsage) {
 	shard.Schema.RLock()
 	valueTypeByColumn := shard.Schema.GetValueTypeByColumn()
@@ -222,6 +437,58 @@ func (m *memStoreImpl) GetSchemas() map[string]*TableSchema {
 	return m.TableSchemas
 }
 
+// DeleteRows persists the row deletion predicate for the table and applies it to the in-memory
+// table schema so that subsequent queries exclude the matching rows.
+func (m *memStoreImpl) DeleteRows(table string, filter string) (predicate metaCom.DeletePredicate, err error) {
+	schema, err := m.GetSchema(table)
+	if err != nil {
+		return
+	}
+
+	schema.RLock()
+	isFactTable := schema.Schema.IsFactTable
+	schema.RUnlock()
+	// rows of dimension tables have no event time to tell existing rows from later ones.
+	if !isFactTable {
+		err = ErrDeleteDimensionTableRows
+		return
+	}
+
+	if predicate, err = m.metaStore.AddDeletePredicate(table, filter, uint32(utils.Now().Unix())); err != nil {
+		err = utils.StackError(err, "Failed to persist delete predicate for table %s", table)
+		return
+	}
+
+	schema.Lock()
+	schema.DeletePredicates = append(schema.DeletePredicates, predicate)
+	schema.Unlock()
+	return
+}
+
+// RemoveDeletePredicate removes the row deletion predicate from the metastore and from the in-memory
+// table schema.
+func (m *memStoreImpl) RemoveDeletePredicate(table string, id int) error {
+	schema, err := m.GetSchema(table)
+	if err != nil {
+		return err
+	}
+
+	if err = m.metaStore.RemoveDeletePredicate(table, id); err != nil {
+		return err
+	}
+
+	schema.Lock()
+	defer schema.Unlock()
+	predicates := make([]metaCom.DeletePredicate, 0, len(schema.DeletePredicates))
+	for _, predicate := range schema.DeletePredicates {
+		if predicate.ID != id {
+			predicates = append(predicates, predicate)
+		}
+	}
+	schema.DeletePredicates = predicates
+	return nil
+}
+
 // GetScheduler returns the scheduler instance bound to the MemStore.
 func (m *memStoreImpl) GetScheduler() Scheduler {
 	return m.scheduler
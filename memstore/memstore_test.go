@@ -59,10 +59,10 @@ func createMemStore(tableName string, shardID int, columnTypes []memCom.DataType
 		schema.SetDefaultValue(i)
 	}
 
-	memStore := NewMemStore(metaStore, diskStore).(*memStoreImpl)
+	memStore := NewMemStore(metaStore, diskStore, Options{}).(*memStoreImpl)
 	// Create shards.
 	shards := map[int]*TableShard{
-		shardID: NewTableShard(schema, metaStore, diskStore, NewHostMemoryManager(memStore, 1<<32), shardID),
+		shardID: NewTableShard(schema, metaStore, diskStore, NewHostMemoryManager(memStore, 1<<32), shardID, Options{}),
 	}
 	memStore.TableShards[tableName] = shards
 	memStore.TableSchemas[tableName] = schema
@@ -70,6 +70,14 @@ func createMemStore(tableName string, shardID int, columnTypes []memCom.DataType
 	return memStore
 }
 
+// recreateTableShard replaces a shard created by createMemStore with a shard created with the options.
+func recreateTableShard(memStore *memStoreImpl, tableName string, shardID int, options Options) *TableShard {
+	shard := memStore.TableShards[tableName][shardID]
+	shard = NewTableShard(shard.Schema, shard.metaStore, shard.diskStore, shard.HostMemoryManager, shardID, options)
+	memStore.TableShards[tableName][shardID] = shard
+	return shard
+}
+
 // ReadShardValue reads a value from a shard at given position.
 func ReadShardValue(shard *TableShard, columnID int, primaryKey []byte) (unsafe.Pointer, bool) {
 	vp, index := getVectorParty(shard, columnID, primaryKey)
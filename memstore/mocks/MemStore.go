@@ -15,6 +15,9 @@
 // Code generated by mockery v1.0.0. DO NOT EDIT.
 package mocks
 
+import aresCommon "github.com/uber/aresdb/common"
+import common "github.com/uber/aresdb/metastore/common"
+import io "io"
 import memstore "github.com/uber/aresdb/memstore"
 import mock "github.com/stretchr/testify/mock"
 
@@ -37,6 +40,27 @@ func (_m *MemStore) Archive(table string, shardID int, cutoff uint32, reporter m
 	return r0
 }
 
+// ArchiveTable provides a mock function with given fields: table, cutoff
+func (_m *MemStore) ArchiveTable(table string, cutoff uint32) (memstore.ArchiveTableResult, error) {
+	ret := _m.Called(table, cutoff)
+
+	var r0 memstore.ArchiveTableResult
+	if rf, ok := ret.Get(0).(func(string, uint32) memstore.ArchiveTableResult); ok {
+		r0 = rf(table, cutoff)
+	} else {
+		r0 = ret.Get(0).(memstore.ArchiveTableResult)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, uint32) error); ok {
+		r1 = rf(table, cutoff)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Backfill provides a mock function with given fields: table, shardID, reporter
 func (_m *MemStore) Backfill(table string, shardID int, reporter memstore.BackfillJobDetailReporter) error {
 	ret := _m.Called(table, shardID, reporter)
@@ -51,6 +75,81 @@ func (_m *MemStore) Backfill(table string, shardID int, reporter memstore.Backfi
 	return r0
 }
 
+// BackfillDerivedColumns provides a mock function with given fields: table, shardID, reporter
+func (_m *MemStore) BackfillDerivedColumns(table string, shardID int, reporter memstore.DerivedColumnJobDetailReporter) error {
+	ret := _m.Called(table, shardID, reporter)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, int, memstore.DerivedColumnJobDetailReporter) error); ok {
+		r0 = rf(table, shardID, reporter)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Close provides a mock function with given fields:
+func (_m *MemStore) Close() {
+	_m.Called()
+}
+
+// DeleteRows provides a mock function with given fields: table, filter
+func (_m *MemStore) DeleteRows(table string, filter string) (common.DeletePredicate, error) {
+	ret := _m.Called(table, filter)
+
+	var r0 common.DeletePredicate
+	if rf, ok := ret.Get(0).(func(string, string) common.DeletePredicate); ok {
+		r0 = rf(table, filter)
+	} else {
+		r0 = ret.Get(0).(common.DeletePredicate)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string) error); ok {
+		r1 = rf(table, filter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DropShard provides a mock function with given fields: table, shardID
+func (_m *MemStore) DropShard(table string, shardID int) error {
+	ret := _m.Called(table, shardID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, int) error); ok {
+		r0 = rf(table, shardID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ExportTable provides a mock function with given fields: table, from, to, output, config
+func (_m *MemStore) ExportTable(table string, from uint32, to uint32, output string, config aresCommon.ExportConfig) (memstore.ExportTableResult, error) {
+	ret := _m.Called(table, from, to, output, config)
+
+	var r0 memstore.ExportTableResult
+	if rf, ok := ret.Get(0).(func(string, uint32, uint32, string, aresCommon.ExportConfig) memstore.ExportTableResult); ok {
+		r0 = rf(table, from, to, output, config)
+	} else {
+		r0 = ret.Get(0).(memstore.ExportTableResult)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, uint32, uint32, string, aresCommon.ExportConfig) error); ok {
+		r1 = rf(table, from, to, output, config)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // FetchSchema provides a mock function with given fields:
 func (_m *MemStore) FetchSchema() error {
 	ret := _m.Called()
@@ -166,6 +265,29 @@ func (_m *MemStore) GetTableShard(table string, shardID int) (*memstore.TableSha
 	return r0, r1
 }
 
+// GetTableUsageDetails provides a mock function with given fields: table
+func (_m *MemStore) GetTableUsageDetails(table string) (map[string]memstore.TableUsage, error) {
+	ret := _m.Called(table)
+
+	var r0 map[string]memstore.TableUsage
+	if rf, ok := ret.Get(0).(func(string) map[string]memstore.TableUsage); ok {
+		r0 = rf(table)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(map[string]memstore.TableUsage)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(table)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // HandleIngestion provides a mock function with given fields: table, shardID, upsertBatch
 func (_m *MemStore) HandleIngestion(table string, shardID int, upsertBatch *memstore.UpsertBatch) error {
 	ret := _m.Called(table, shardID, upsertBatch)
@@ -185,11 +307,39 @@ func (_m *MemStore) InitShards(schedulerOff bool) {
 	_m.Called(schedulerOff)
 }
 
+// LoadArchivedShard provides a mock function with given fields: table, shardID, r
+func (_m *MemStore) LoadArchivedShard(table string, shardID int, r io.Reader) error {
+	ret := _m.Called(table, shardID, r)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, int, io.Reader) error); ok {
+		r0 = rf(table, shardID, r)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Lock provides a mock function with given fields:
 func (_m *MemStore) Lock() {
 	_m.Called()
 }
 
+// MaintainRollup provides a mock function with given fields: table, shardID, reporter
+func (_m *MemStore) MaintainRollup(table string, shardID int, reporter memstore.RollupJobDetailReporter) error {
+	ret := _m.Called(table, shardID, reporter)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, int, memstore.RollupJobDetailReporter) error); ok {
+		r0 = rf(table, shardID, reporter)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Purge provides a mock function with given fields: table, shardID, batchIDStart, batchIDEnd, reporter
 func (_m *MemStore) Purge(table string, shardID int, batchIDStart int, batchIDEnd int, reporter memstore.PurgeJobDetailReporter) error {
 	ret := _m.Called(table, shardID, batchIDStart, batchIDEnd, reporter)
@@ -214,6 +364,20 @@ func (_m *MemStore) RUnlock() {
 	_m.Called()
 }
 
+// RemoveDeletePredicate provides a mock function with given fields: table, id
+func (_m *MemStore) RemoveDeletePredicate(table string, id int) error {
+	ret := _m.Called(table, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, int) error); ok {
+		r0 = rf(table, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Snapshot provides a mock function with given fields: table, shardID, reporter
 func (_m *MemStore) Snapshot(table string, shardID int, reporter memstore.SnapshotJobDetailReporter) error {
 	ret := _m.Called(table, shardID, reporter)
@@ -232,3 +396,17 @@ func (_m *MemStore) Snapshot(table string, shardID int, reporter memstore.Snapsh
 func (_m *MemStore) Unlock() {
 	_m.Called()
 }
+
+// WriteArchivedShard provides a mock function with given fields: table, shardID, w
+func (_m *MemStore) WriteArchivedShard(table string, shardID int, w io.Writer) error {
+	ret := _m.Called(table, shardID, w)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, int, io.Writer) error); ok {
+		r0 = rf(table, shardID, w)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
@@ -84,6 +84,22 @@ func (_m *Scheduler) NewBackfillJob(tableName string, shardID int) memstore.Job
 	return r0
 }
 
+// NewDerivedColumnJob provides a mock function with given fields: tableName, shardID
+func (_m *Scheduler) NewDerivedColumnJob(tableName string, shardID int) memstore.Job {
+	ret := _m.Called(tableName, shardID)
+
+	var r0 memstore.Job
+	if rf, ok := ret.Get(0).(func(string, int) memstore.Job); ok {
+		r0 = rf(tableName, shardID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(memstore.Job)
+		}
+	}
+
+	return r0
+}
+
 // NewPurgeJob provides a mock function with given fields: tableName, shardID, batchIDStart, batchIDEnd
 func (_m *Scheduler) NewPurgeJob(tableName string, shardID int, batchIDStart int, batchIDEnd int) memstore.Job {
 	ret := _m.Called(tableName, shardID, batchIDStart, batchIDEnd)
@@ -100,6 +116,22 @@ func (_m *Scheduler) NewPurgeJob(tableName string, shardID int, batchIDStart int
 	return r0
 }
 
+// NewRollupJob provides a mock function with given fields: tableName, shardID
+func (_m *Scheduler) NewRollupJob(tableName string, shardID int) memstore.Job {
+	ret := _m.Called(tableName, shardID)
+
+	var r0 memstore.Job
+	if rf, ok := ret.Get(0).(func(string, int) memstore.Job); ok {
+		r0 = rf(tableName, shardID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(memstore.Job)
+		}
+	}
+
+	return r0
+}
+
 // NewSnapshotJob provides a mock function with given fields: tableName, shardID
 func (_m *Scheduler) NewSnapshotJob(tableName string, shardID int) memstore.Job {
 	ret := _m.Called(tableName, shardID)
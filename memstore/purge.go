@@ -19,7 +19,8 @@ import (
 	"github.com/uber/aresdb/utils"
 )
 
-// Purge purges out of retention data for table shard
+// Purge purges out of retention data for table shard. Batches not archived yet are never purged, and
+// data files of a batch are only deleted after queries using the batch finish.
 func (m *memStoreImpl) Purge(tableName string, shardID, batchIDStart, batchIDEnd int, reporter PurgeJobDetailReporter) error {
 	start := utils.Now()
 	jobKey := getIdentifier(tableName, shardID, memCom.PurgeJobType)
@@ -41,6 +42,13 @@ func (m *memStoreImpl) Purge(tableName string, shardID, batchIDStart, batchIDEnd
 	currentVersion := shard.ArchiveStore.GetCurrentVersion()
 	defer currentVersion.Users.Done()
 
+	if archivedBatchIDEnd := int(currentVersion.ArchivingCutoff / 86400); batchIDEnd > archivedBatchIDEnd {
+		batchIDEnd = archivedBatchIDEnd
+	}
+	if batchIDEnd <= batchIDStart {
+		return nil
+	}
+
 	reporter(jobKey, func(status *PurgeJobDetail) {
 		status.Stage = PurgeMetaData
 		status.BatchIDStart = batchIDStart
@@ -66,26 +74,14 @@ func (m *memStoreImpl) Purge(tableName string, shardID, batchIDStart, batchIDEnd
 		return err
 	}
 
-	reporter(jobKey, func(status *PurgeJobDetail) {
-		status.Stage = PurgeDataFile
-	})
-
-	// delete data file on disk of batches within range
-	numBatches, err := shard.diskStore.DeleteBatches(tableName, shardID, batchIDStart, batchIDEnd)
-	if err != nil {
-		return err
-	}
-	reporter(jobKey, func(status *PurgeJobDetail) {
-		status.NumBatches = numBatches
-	})
-	utils.GetReporter(tableName, shardID).GetCounter(utils.PurgedBatches).Inc(int64(numBatches))
-
 	reporter(jobKey, func(status *PurgeJobDetail) {
 		status.Stage = PurgeMemory
 		status.Current = 0
 		status.Total = len(batchesToPurge)
 	})
 
+	// only the host memory of batches loaded into memory is counted, data files are not.
+	var purgedBytes int64
 	for id, batch := range batchesToPurge {
 		batch.Lock()
 		reporter(jobKey, func(status *PurgeJobDetail) {
@@ -95,12 +91,37 @@ func (m *memStoreImpl) Purge(tableName string, shardID, batchIDStart, batchIDEnd
 			if vp != nil {
 				// wait for users to finish
 				vp.(memCom.ArchiveVectorParty).WaitForUsers(true)
+				purgedBytes += vp.GetBytes()
 				vp.SafeDestruct()
 				shard.HostMemoryManager.ReportManagedObject(tableName, shardID, int(batch.BatchID), columnID, 0)
 			}
 		}
 		batch.Unlock()
 	}
+	utils.GetReporter(tableName, shardID).GetCounter(utils.PurgedMemoryBytes).Inc(purgedBytes)
+
+	reporter(jobKey, func(status *PurgeJobDetail) {
+		status.Stage = PurgeDataFile
+	})
+
+	// delete data file on disk of batches within range after queries using them finish.
+	numBatches, err := shard.diskStore.DeleteBatches(tableName, shardID, batchIDStart, batchIDEnd)
+	if err != nil {
+		return err
+	}
+	reporter(jobKey, func(status *PurgeJobDetail) {
+		status.NumBatches = numBatches
+	})
+	utils.GetReporter(tableName, shardID).GetCounter(utils.PurgedBatches).Inc(int64(numBatches))
+
+	// delete redo log files with records all out of retention.
+	if backfillMgr := shard.LiveStore.BackfillManager; backfillMgr != nil {
+		redoFile, batchOffset := backfillMgr.GetLatestRedoFileAndOffset()
+		if err = shard.LiveStore.RedoLogManager.PurgeRedologFileAndData(
+			uint32(batchIDEnd*86400), redoFile, batchOffset); err != nil {
+			return err
+		}
+	}
 
 	reporter(jobKey, func(status *PurgeJobDetail) {
 		status.Stage = PurgeComplete
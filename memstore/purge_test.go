@@ -15,6 +15,8 @@
 package memstore
 
 import (
+	"time"
+
 	"github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/stretchr/testify/mock"
@@ -71,7 +73,7 @@ var _ = ginkgo.Describe("Purge", func() {
 			HostMemManager: hostMemoryManager,
 		}
 		hostMemoryManager = NewHostMemoryManager(memStore, 1<<10)
-		tableShard = NewTableShard(tableSchema, metaStore, diskStore, hostMemoryManager, testShardID)
+		tableShard = NewTableShard(tableSchema, metaStore, diskStore, hostMemoryManager, testShardID, Options{})
 
 		archiveBatch0, err := testFactory.ReadArchiveBatch("archiving/archiveBatch0")
 		Ω(err).Should(BeNil())
@@ -122,4 +124,27 @@ var _ = ginkgo.Describe("Purge", func() {
 		Ω(jobDetail.Stage).Should(BeEquivalentTo("complete"))
 	})
 
+	ginkgo.It("purge should only drop data past retention", func() {
+		mockReporter := func(key string, mutator PurgeJobDetailMutator) {}
+		utils.SetCurrentTime(time.Unix(86400*10, 0))
+		tableShard.LiveStore.BackfillManager = NewBackfillManager(testTable, testShardID, metaCom.TableConfig{})
+		tableShard.LiveStore.BackfillManager.LastRedoFile = 3
+		redoLogManager := &tableShard.LiveStore.RedoLogManager
+		redoLogManager.CurrentFileCreationTime = 4
+		redoLogManager.MaxEventTimePerFile = map[int64]uint32{1: 86400, 2: 86400 * 2, 4: 86400 * 2}
+		redoLogManager.BatchCountPerFile = map[int64]uint32{}
+		redoLogManager.SizePerFile = map[int64]uint32{1: 10, 2: 10, 4: 10}
+
+		// batches not archived yet are never purged.
+		diskStore.On("DeleteBatches", testTable, testShardID, 0, 2).Return(1, nil).Once()
+		diskStore.On("DeleteLogFile", testTable, testShardID, int64(1)).Return(nil).Once()
+		err := memStore.Purge(testTable, testShardID, 0, 5, mockReporter)
+		Ω(err).Should(BeNil())
+		Ω(tableShard.ArchiveStore.CurrentVersion.Batches).ShouldNot(HaveKey(int32(1)))
+		Ω(tableShard.ArchiveStore.CurrentVersion.Batches).Should(HaveKey(int32(2)))
+		metaStore.AssertCalled(utils.TestingT, "PurgeArchiveBatches", testTable, testShardID, 0, 2)
+		diskStore.AssertExpectations(utils.TestingT)
+		Ω(redoLogManager.MaxEventTimePerFile).Should(Equal(map[int64]uint32{2: 86400 * 2, 4: 86400 * 2}))
+	})
+
 })
@@ -34,11 +34,16 @@ func (shard *TableShard) ReplayRedoLogs() {
 
 	var redoLogFilePersisted int64
 	var offsetPersisted uint32
+	// upsert batches up to redoLogFileApplied and offsetApplied are already in the live store
+	// loaded from snapshot.
+	var redoLogFileApplied int64
+	var offsetApplied uint32
 
 	if backfillMgr := shard.LiveStore.BackfillManager; backfillMgr != nil {
 		redoLogFilePersisted, offsetPersisted = backfillMgr.GetLatestRedoFileAndOffset()
 	} else {
 		redoLogFilePersisted, offsetPersisted, _, _ = shard.LiveStore.SnapshotManager.GetLastSnapshotInfo()
+		redoLogFileApplied, offsetApplied, _, _ = shard.LiveStore.SnapshotManager.StartSnapshot()
 	}
 	utils.GetLogger().Infof("Checkpointed redoLogFile=%d offset=%d", redoLogFilePersisted, offsetPersisted)
 
@@ -57,6 +62,18 @@ func (shard *TableShard) ReplayRedoLogs() {
 		// Put a 0 in maxEventTimePerFile in case this is redolog is full of backfill batches.
 		shard.LiveStore.RedoLogManager.UpdateMaxEventTime(0, redoLogFile)
 
+		// Batches in the redo log were all applied, remember their keys to dedupe retries.
+		if upsertBatch.IdempotencyKey != "" && shard.ingestionKeys.enabled() {
+			shard.recordIngestionKey(upsertBatch.IdempotencyKey, upsertBatch.IdempotencyKeyExpiry,
+				utils.Now().Unix())
+		}
+
+		// check if this batch has already been applied to the live store by loading snapshot.
+		if redoLogFile < redoLogFileApplied || (redoLogFile == redoLogFileApplied && offset <= offsetApplied) {
+			shard.LiveStore.WriterLock.Unlock()
+			continue
+		}
+
 		// check if this batch has already been backfilled and persisted
 		skipBackfillRows := redoLogFile < redoLogFilePersisted ||
 			(redoLogFile == redoLogFilePersisted && offset <= offsetPersisted)
@@ -259,19 +276,8 @@ func (m *memStoreImpl) InitShards(schedulerOff bool) {
 					utils.GetLogger().Panic(err)
 				}
 			} else {
-				// Unload the Shard.
-				var shard *TableShard
-				// Detach first.
-				m.Lock()
-				shards := m.TableShards[event.TableName]
-				if shards != nil {
-					shard = shards[event.Shard]
-					delete(shards, event.Shard)
-					utils.DeleteTableShardReporter(event.TableName, event.Shard)
-				}
-				m.Unlock()
-				// Destruct.
-				if shard != nil {
+				// Unload the Shard, detach first.
+				if shard := m.detachShard(event.TableName, event.Shard); shard != nil {
 					shard.Destruct()
 				}
 				// Do not delete the file on diskstore.
@@ -283,11 +289,16 @@ func (m *memStoreImpl) InitShards(schedulerOff bool) {
 }
 
 // LoadShard loads/recovers the specified Shard and attaches it to memStoreImpl for serving. If will load the metadata
-// first and then replay redologs only if replayRedologs is true.
+// first and then load the snapshot of dimension tables and replay redologs only if replayRedologs is true.
 func (m *memStoreImpl) LoadShard(schema *TableSchema, shard int, replayRedologs bool) error {
-	tableShard := NewTableShard(schema, m.metaStore, m.diskStore, m.HostMemManager, shard)
+	tableShard := NewTableShard(schema, m.metaStore, m.diskStore, m.HostMemManager, shard, m.options)
 	tableShard.LoadMetaData()
 	if replayRedologs {
+		if !schema.Schema.IsFactTable {
+			if err := tableShard.LoadSnapshot(); err != nil {
+				return err
+			}
+		}
 		tableShard.ReplayRedoLogs()
 	}
 
@@ -357,6 +368,8 @@ func (shard *TableShard) LoadSnapshot() error {
 	shard.LiveStore.LastReadRecord = lastReadRecord
 	shard.LiveStore.Unlock()
 	shard.LiveStore.NextWriteRecord = lastReadRecord
+	// redo log replay will start after the snapshot.
+	shard.LiveStore.SnapshotManager.ApplyUpsertBatch(redoLogFile, offset, 0, lastReadRecord)
 	return nil
 }
 
@@ -15,6 +15,9 @@
 package memstore
 
 import (
+	"time"
+
+	aresCommon "github.com/uber/aresdb/common"
 	"github.com/uber/aresdb/memstore/common"
 	memCom "github.com/uber/aresdb/memstore/common"
 	"github.com/uber/aresdb/metastore/mocks"
@@ -102,6 +105,42 @@ var _ = ginkgo.Describe("recovery", func() {
 		Ω(shard.LiveStore.BackfillManager.CurrentBatchOffset).Should(BeEquivalentTo(0))
 	})
 
+	ginkgo.It("remembers idempotency keys of replayed upsert batches", func() {
+		utils.SetCurrentTime(time.Unix(100, 0))
+		defer utils.ResetClockImplementation()
+
+		builder := common.NewUpsertBatchBuilder()
+		builder.AddColumn(0, common.Uint32)
+		builder.AddRow()
+		builder.SetValue(0, 0, uint32(123))
+		buffer, _ := builder.ToByteArray()
+
+		file := &testing.TestReadWriteCloser{}
+		diskStore := &diskMocks.DiskStore{}
+		diskStore.On("OpenLogFileForAppend", "abc", 0, mock.Anything).Return(file, nil)
+		redoManager := NewRedoLogManager(10, 1<<30, aresCommon.RedoLogSyncConfig{}, diskStore, "abc", 0)
+		for _, key := range []string{"a", "expired", ""} {
+			upsertBatch, _ := NewUpsertBatch(buffer)
+			upsertBatch.IdempotencyKey = key
+			upsertBatch.IdempotencyKeyExpiry = 160
+			if key == "expired" {
+				upsertBatch.IdempotencyKeyExpiry = 90
+			}
+			redoManager.WriteUpsertBatch(upsertBatch)
+		}
+
+		diskStore.On("ListLogFiles", "abc", 0).Return([]int64{1}, nil)
+		diskStore.On("OpenLogFileForReplay", "abc", 0, int64(1)).Return(file, nil)
+		metaStore := &mocks.MetaStore{}
+		metaStore.On("GetArchivingCutoff", "abc", 0).Return(uint32(0), nil)
+		metaStore.On("GetBackfillProgressInfo", "abc", 0).Return(int64(0), uint32(0), nil)
+
+		memstore := createMemStore("abc", 0, []common.DataType{common.Uint32}, []int{0}, 10, true, false, metaStore, diskStore)
+		shard := recreateTableShard(memstore, "abc", 0, Options{MaxIngestionKeys: 10, IngestionKeyTTL: 60})
+		shard.ReplayRedoLogs()
+		Ω(shard.ingestionKeys.keys).Should(Equal(map[string]int64{"a": 160}))
+	})
+
 	ginkgo.It("loadsnapshots should not panic", func() {
 		diskStore := &diskMocks.DiskStore{}
 		metaStore := &metaMocks.MetaStore{}
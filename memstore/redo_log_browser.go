@@ -15,6 +15,7 @@
 package memstore
 
 import (
+	"hash/crc32"
 	"io"
 
 	"github.com/uber/aresdb/diskstore"
@@ -62,7 +63,8 @@ func (rb *redoLogBrowser) ListUpsertBatch(creationTime int64) ([]int64, error) {
 		return nil, err
 	}
 
-	if header != UpsertHeader {
+	overhead, _, ok := getRecordOverhead(header)
+	if !ok {
 		return nil, utils.StackError(nil,
 			"Invalid header %#x", header)
 	}
@@ -78,13 +80,14 @@ func (rb *redoLogBrowser) ListUpsertBatch(creationTime int64) ([]int64, error) {
 		}
 
 		var newOffset int64
-		if newOffset, err = f.Seek(int64(size), io.SeekCurrent); err != nil {
+		// Skip the checksum (if any) and the upsert batch buffer.
+		if newOffset, err = f.Seek(int64(overhead-4)+int64(size), io.SeekCurrent); err != nil {
 			if err != nil {
 				return nil, err
 			}
 		}
 
-		desiredOffset := currentOffset + 4 + int64(size)
+		desiredOffset := currentOffset + int64(overhead) + int64(size)
 		if newOffset != desiredOffset {
 			return nil, utils.StackError(nil,
 				"Cannot seek to desired offset %d of redolog file ,current offset %d",
@@ -108,6 +111,19 @@ func (rb *redoLogBrowser) ReadData(creationTime int64, upsertBatchOffset int64,
 	}
 	defer f.Close()
 
+	// Read magic header to find out the format of upsert batches.
+	streamReader := utils.NewStreamDataReader(f)
+	var header uint32
+	if header, err = streamReader.ReadUint32(); err != nil {
+		return
+	}
+
+	_, withKey, ok := getRecordOverhead(header)
+	if !ok {
+		err = utils.StackError(nil, "Invalid header %#x", header)
+		return
+	}
+
 	var actualOffset int64
 	if actualOffset, err = f.Seek(upsertBatchOffset, io.SeekStart); err != nil {
 		return
@@ -121,7 +137,7 @@ func (rb *redoLogBrowser) ReadData(creationTime int64, upsertBatchOffset int64,
 	}
 
 	var upsertBatch *UpsertBatch
-	if upsertBatch, err = rb.readUpsertBatch(f); err != nil {
+	if upsertBatch, err = rb.readUpsertBatch(f, withKey); err != nil {
 		return
 	}
 
@@ -138,18 +154,35 @@ func (rb *redoLogBrowser) ReadData(creationTime int64, upsertBatchOffset int64,
 	return
 }
 
-// readUpsertBatch reads an upsert batch from current offset of a stream.
-func (rb *redoLogBrowser) readUpsertBatch(f utils.ReaderSeekerCloser) (*UpsertBatch, error) {
+// readUpsertBatch reads an upsert batch from current offset of a stream. If withKey is true, the
+// checksum following the size will be verified against the record, and the record starts with the
+// idempotency key of the upsert batch.
+func (rb *redoLogBrowser) readUpsertBatch(f utils.ReaderSeekerCloser, withKey bool) (*UpsertBatch, error) {
 	streamReader := utils.NewStreamDataReader(f)
 	size, err := streamReader.ReadUint32()
 	if err != nil {
 		return nil, err
 	}
+
+	var checksum uint32
+	if withKey {
+		if checksum, err = streamReader.ReadUint32(); err != nil {
+			return nil, err
+		}
+	}
+
 	buffer := make([]byte, size)
 	if err = streamReader.Read(buffer); err != nil {
 		return nil, err
 	}
 
+	if withKey && crc32.ChecksumIEEE(buffer) != checksum {
+		return nil, utils.StackError(nil, "Checksum mismatch for upsert batch of size %d", size)
+	}
+
+	if withKey {
+		return decodeRedoLogRecord(buffer)
+	}
 	return NewUpsertBatch(buffer)
 }
 
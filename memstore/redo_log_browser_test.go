@@ -19,13 +19,14 @@ import (
 
 	"github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	aresCommon "github.com/uber/aresdb/common"
 	"github.com/uber/aresdb/diskstore"
 	"github.com/uber/aresdb/metastore"
 )
 
 var _ = ginkgo.Describe("redo_log_browser", func() {
 	rootPath := "../testing/data/integration/sample-ares-root"
-	diskStore := diskstore.NewLocalDiskStore(rootPath)
+	diskStore := diskstore.NewLocalDiskStore(rootPath, aresCommon.RedoLogSyncConfig{})
 
 	metaStorePath := filepath.Join(rootPath, "metastore")
 	metaStore, _ := metastore.NewDiskMetaStore(metaStorePath)
@@ -35,7 +36,7 @@ var _ = ginkgo.Describe("redo_log_browser", func() {
 	m := getFactory().NewMockMemStore()
 	schema := NewTableSchema(t)
 
-	shard := NewTableShard(schema, metaStore, diskStore, NewHostMemoryManager(m, 1<<32), shardID)
+	shard := NewTableShard(schema, metaStore, diskStore, NewHostMemoryManager(m, 1<<32), shardID, Options{})
 
 	var rb *redoLogBrowser
 
@@ -15,17 +15,88 @@
 package memstore
 
 import (
+	"bytes"
 	"encoding/json"
+	"hash/crc32"
 	"io"
+	"io/ioutil"
 	"sync"
+	"time"
 
+	aresCommon "github.com/uber/aresdb/common"
 	"github.com/uber/aresdb/diskstore"
 	"github.com/uber/aresdb/utils"
 )
 
-// UpsertHeader is the magic header written into the beginning of each redo log file.
+// UpsertHeader is the magic header written into the beginning of each legacy redo log file, where each
+// upsert batch is only prefixed by its size.
 const UpsertHeader uint32 = 0xADDAFEED
 
+// UpsertWithKeyHeader is the magic header written into the beginning of each redo log file, where each
+// record is prefixed by its size and the CRC32 (IEEE) checksum of the record. A record is the idempotency
+// key of the upsert batch followed by its buffer, so that the key is durable if and only if the batch is.
+const UpsertWithKeyHeader uint32 = 0xADDAFEEF
+
+// getRecordOverhead returns the number of bytes preceding each record in a redo log file with the given
+// magic header and whether each record is checksummed and starts with the idempotency key.
+func getRecordOverhead(header uint32) (overhead uint32, withKey bool, ok bool) {
+	switch header {
+	case UpsertHeader:
+		return 4, false, true
+	case UpsertWithKeyHeader:
+		return 8, true, true
+	}
+	return 0, false, false
+}
+
+// encodeIngestionKey returns the idempotency key of the upsert batch preceding its buffer in redo log
+// records: the key expiry in unix seconds (uint32), the key length (uint32) and the key.
+func encodeIngestionKey(upsertBatch *UpsertBatch) []byte {
+	buffer := &bytes.Buffer{}
+	writer := utils.NewStreamDataWriter(buffer)
+	writer.WriteUint32(uint32(upsertBatch.IdempotencyKeyExpiry))
+	writer.WriteUint32(uint32(len(upsertBatch.IdempotencyKey)))
+	writer.Write([]byte(upsertBatch.IdempotencyKey))
+	return buffer.Bytes()
+}
+
+// decodeRedoLogRecord creates the upsert batch from a redo log record starting with the idempotency key.
+func decodeRedoLogRecord(record []byte) (*UpsertBatch, error) {
+	reader := utils.NewStreamDataReader(bytes.NewReader(record))
+	expiry, err := reader.ReadUint32()
+	if err != nil {
+		return nil, utils.StackError(err, "Failed to read idempotency key expiry")
+	}
+	keyLength, err := reader.ReadUint32()
+	if err != nil {
+		return nil, utils.StackError(err, "Failed to read idempotency key length")
+	}
+	if int64(keyLength) > int64(len(record)-8) {
+		return nil, utils.StackError(nil, "Invalid idempotency key length %d", keyLength)
+	}
+
+	upsertBatch, err := NewUpsertBatch(record[8+keyLength:])
+	if err != nil {
+		return nil, err
+	}
+	upsertBatch.IdempotencyKey = string(record[8 : 8+keyLength])
+	upsertBatch.IdempotencyKeyExpiry = int64(expiry)
+	return upsertBatch, nil
+}
+
+// countingReader counts the bytes read from the underlying reader, including the bytes of
+// partial reads.
+type countingReader struct {
+	reader    io.Reader
+	bytesRead int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.bytesRead += int64(n)
+	return n, err
+}
+
 // RedoLogManager manages the redo log file append, rotation, purge. It is used by ingestion,
 // recovery and archiving. Accessor must hold the TableShard.WriterLock to access it.
 type RedoLogManager struct {
@@ -38,6 +109,11 @@ type RedoLogManager struct {
 	// The limit of redo file size to trigger rotations.
 	MaxRedoLogSize int64 `json:"maxRedoLogSize"`
 
+	// The time interval to keep redo files after they are eligible to be purged, i.e. after all
+	// their upsert batches are archived, backfilled or snapshotted. Writers need to hold the
+	// writer lock.
+	RetentionInterval int64 `json:"retentionInterval"`
+
 	// Current redo log size
 	CurrentRedoLogSize uint32 `json:"currentRedoLogSize"`
 
@@ -55,12 +131,27 @@ type RedoLogManager struct {
 	// SizePerFile
 	SizePerFile map[int64]uint32 `json:"sizePerFile"`
 
+	// redo log creation time -> time in seconds the redo log was first found eligible to be purged.
+	// Files found eligible again after restart are kept for the retention interval again.
+	purgeableSince map[int64]int64
+
 	// Current log file points to the current redo log file used for appending new upsert batches.
 	currentLogFile io.WriteCloser
 
 	// Current file creation time in milliseconds.
 	CurrentFileCreationTime int64 `json:"currentFileCreationTime"`
 
+	// Effective fsync config of the redo log files.
+	SyncConfig aresCommon.RedoLogSyncConfig `json:"syncConfig"`
+
+	// Protects the current log file and the unsynced records from the sync timer under batched
+	// policy.
+	syncLock sync.Mutex
+	// Number of records appended to the current log file since the last fsync under batched policy.
+	unsyncedRecords int
+	// Fsyncs the current log file after the batch interval, nil if no record is unsynced.
+	syncTimer *time.Timer
+
 	// Pointer to the disk store for redo log access.
 	diskStore diskstore.DiskStore
 
@@ -71,41 +162,100 @@ type RedoLogManager struct {
 	shard int
 }
 
-// NewRedoLogManager creates a new RedoLogManager instance.
-func NewRedoLogManager(rotationInterval int64, maxRedoLogSize int64, diskStore diskstore.DiskStore, tableName string, shard int) RedoLogManager {
+// NewRedoLogManager creates a new RedoLogManager instance fsyncing redo log files under the effective
+// sync config, see utils.GetRedoLogSyncConfig.
+func NewRedoLogManager(rotationInterval int64, maxRedoLogSize int64, syncConfig aresCommon.RedoLogSyncConfig,
+	diskStore diskstore.DiskStore, tableName string, shard int) RedoLogManager {
 	return RedoLogManager{
 		RotationInterval:    rotationInterval,
 		MaxEventTimePerFile: make(map[int64]uint32),
 		BatchCountPerFile:   make(map[int64]uint32),
 		SizePerFile:         make(map[int64]uint32),
+		purgeableSince:      make(map[int64]int64),
 		diskStore:           diskStore,
 		tableName:           tableName,
 		shard:               shard,
 		MaxRedoLogSize:      maxRedoLogSize,
 		CurrentRedoLogSize:  0,
+		SyncConfig:          syncConfig,
 	}
 }
 
-// Close closes the current log file.
+// Close closes the current log file, records not fsynced yet under batched policy are fsynced
+// before closing.
 func (r *RedoLogManager) Close() {
+	r.syncLock.Lock()
+	defer r.syncLock.Unlock()
 	if r.currentLogFile != nil {
+		r.syncCurrentFile()
 		r.currentLogFile.Close()
+		r.currentLogFile = nil
+	}
+}
+
+// syncer is implemented by log files that can be fsynced, e.g. os.File.
+type syncer interface {
+	Sync() error
+}
+
+// syncCurrentFile fsyncs the records appended to the current log file since the last fsync.
+// Caller should hold the syncLock.
+func (r *RedoLogManager) syncCurrentFile() {
+	if r.syncTimer != nil {
+		r.syncTimer.Stop()
+		r.syncTimer = nil
+	}
+	if r.unsyncedRecords == 0 {
+		return
+	}
+	r.unsyncedRecords = 0
+	if file, ok := r.currentLogFile.(syncer); ok {
+		if err := file.Sync(); err != nil {
+			utils.GetLogger().With(
+				"table", r.tableName,
+				"shard", r.shard,
+				"error", err.Error()).Panic("Failed to sync redo log file")
+		}
+		utils.GetReporter(r.tableName, r.shard).GetCounter(utils.RedoLogSyncs).Inc(1)
+	}
+}
+
+// recordAppended fsyncs the current log file under batched policy once batch records are
+// unsynced, or makes sure it is fsynced within the batch interval. Caller should hold the
+// syncLock.
+func (r *RedoLogManager) recordAppended() {
+	if r.SyncConfig.Policy != aresCommon.RedoLogSyncBatched {
+		return
+	}
+	r.unsyncedRecords++
+	if r.SyncConfig.BatchRecords > 0 && r.unsyncedRecords >= r.SyncConfig.BatchRecords {
+		r.syncCurrentFile()
+		return
+	}
+	if r.SyncConfig.BatchIntervalMs > 0 && r.syncTimer == nil {
+		r.syncTimer = time.AfterFunc(time.Duration(r.SyncConfig.BatchIntervalMs)*time.Millisecond, func() {
+			r.syncLock.Lock()
+			defer r.syncLock.Unlock()
+			r.syncCurrentFile()
+		})
 	}
 }
 
 // openFileForWrite handles redo log file opening and rotation (if needed). It guarantees the
-// validity of the currentLogFile upon return.
+// validity of the currentLogFile upon return. The rotated file is fsynced before closing under
+// batched policy. Caller should hold the syncLock.
 func (r *RedoLogManager) openFileForWrite(upsertBatchSize uint32) {
 	dataTime := utils.Now().Unix()
 
 	// If current file is still valid we just return the writer back.
 	if r.currentLogFile != nil && dataTime < r.CurrentFileCreationTime+r.RotationInterval &&
-		int64(r.CurrentRedoLogSize+upsertBatchSize+4) < r.MaxRedoLogSize {
+		int64(r.CurrentRedoLogSize+upsertBatchSize+8) < r.MaxRedoLogSize {
 		return
 	}
 
 	var err error
 	if r.currentLogFile != nil {
+		r.syncCurrentFile()
 		if err = r.currentLogFile.Close(); err != nil {
 			utils.GetLogger().Panic("Failed to close current redo log file")
 		}
@@ -119,7 +269,7 @@ func (r *RedoLogManager) openFileForWrite(upsertBatchSize uint32) {
 	}
 
 	writer := utils.NewStreamDataWriter(r.currentLogFile)
-	if err = writer.WriteUint32(UpsertHeader); err != nil {
+	if err = writer.WriteUint32(UpsertWithKeyHeader); err != nil {
 		utils.GetLogger().Panic("Failed to write magic header to the new redo log")
 	}
 
@@ -132,30 +282,45 @@ func (r *RedoLogManager) openFileForWrite(upsertBatchSize uint32) {
 	r.CurrentRedoLogSize = 4
 }
 
-// WriteUpsertBatch saves an upsert batch into disk before applying it. Any errors from diskStore
-// will trigger system panic.
+// WriteUpsertBatch saves an upsert batch with its idempotency key into disk before applying it, which
+// is fsynced according to the sync config. Any errors from diskStore will trigger system panic.
 func (r *RedoLogManager) WriteUpsertBatch(upsertBatch *UpsertBatch) (int64, uint32) {
-	r.openFileForWrite(uint32(len(upsertBatch.buffer)))
+	start := utils.Now()
+	r.syncLock.Lock()
+	defer r.syncLock.Unlock()
 
+	key := encodeIngestionKey(upsertBatch)
 	buffer := upsertBatch.GetBuffer()
+	recordSize := uint32(len(key) + len(buffer))
+	r.openFileForWrite(recordSize)
 
 	writer := utils.NewStreamDataWriter(r.currentLogFile)
-	// Write buffer size.
-	if err := writer.WriteUint32(uint32(len(buffer))); err != nil {
+	// Write record size.
+	if err := writer.WriteUint32(recordSize); err != nil {
 		utils.GetLogger().With("error", err).Panic("Failed to write buffer size into the redo log")
 	}
 
+	// Write record checksum.
+	if err := writer.WriteUint32(crc32.Update(crc32.ChecksumIEEE(key), crc32.IEEETable, buffer)); err != nil {
+		utils.GetLogger().With("error", err).Panic("Failed to write buffer checksum into the redo log")
+	}
+
+	if _, err := r.currentLogFile.Write(key); err != nil {
+		utils.GetLogger().With("error", err).Panic("Failed to write idempotency key into the redo log")
+	}
 	if _, err := r.currentLogFile.Write(buffer); err != nil {
 		utils.GetLogger().With("error", err).Panic("Failed to write upsert buffer into the redo log")
 	}
+	r.recordAppended()
 
 	// update current redo log size
-	r.CurrentRedoLogSize += uint32(len(upsertBatch.buffer)) + 4
-	r.SizePerFile[r.CurrentFileCreationTime] += uint32(len(upsertBatch.buffer)) + 4
-	r.TotalRedoLogSize += uint(len(upsertBatch.buffer)) + 4
+	r.CurrentRedoLogSize += recordSize + 8
+	r.SizePerFile[r.CurrentFileCreationTime] += recordSize + 8
+	r.TotalRedoLogSize += uint(recordSize) + 8
 
 	utils.GetReporter(r.tableName, r.shard).GetGauge(utils.CurrentRedologSize).Update(float64(r.CurrentRedoLogSize))
 	utils.GetReporter(r.tableName, r.shard).GetGauge(utils.SizeOfRedologs).Update(float64(r.TotalRedoLogSize))
+	utils.GetReporter(r.tableName, r.shard).GetTimer(utils.RedoLogWriteLatency).Record(utils.Now().Sub(start))
 
 	// Update offset of the last batch for the current redolog
 	offset := r.UpdateBatchCount(r.CurrentFileCreationTime) - 1
@@ -179,7 +344,20 @@ func (r *RedoLogManager) UpdateMaxEventTime(eventTime uint32, redoFile int64) {
 }
 
 func (r *RedoLogManager) closeRedoLogFile(creationTime int64, offset uint32, currentFile *io.ReadCloser,
-	currentIndex *int, needToTruncate bool) {
+	fileReader *countingReader, currentIndex *int, needToTruncate bool) {
+	var bytesSkipped int64
+	if needToTruncate {
+		// Drain the rest of the file to find out how many bytes will be dropped by the truncation.
+		if _, err := io.Copy(ioutil.Discard, fileReader); err != nil {
+			utils.GetLogger().With(
+				"table", r.tableName,
+				"shard", r.shard,
+				"err", err,
+				"file", creationTime).Error("Failed to read the remaining bytes of corrupted redo log file")
+		}
+		bytesSkipped = fileReader.bytesRead - int64(offset)
+	}
+
 	// End of file encountered. Move to next file.
 	if err := (*currentFile).Close(); err != nil {
 		utils.GetLogger().With(
@@ -192,7 +370,12 @@ func (r *RedoLogManager) closeRedoLogFile(creationTime int64, offset uint32, cur
 	*currentIndex++
 
 	if needToTruncate {
-		utils.GetLogger().Error("Corrupted file found, truncating it to resume processing")
+		utils.GetLogger().With(
+			"table", r.tableName,
+			"shard", r.shard,
+			"file", creationTime,
+			"offset", offset,
+			"bytesSkipped", bytesSkipped).Error("Corrupted file found, truncating it to resume processing")
 		// truncate current file and move to next file.
 		if err := r.diskStore.TruncateLogFile(r.tableName, r.shard, creationTime, int64(offset)); err != nil {
 			utils.GetLogger().With(
@@ -202,6 +385,7 @@ func (r *RedoLogManager) closeRedoLogFile(creationTime int64, offset uint32, cur
 				"file", creationTime).Panic("Failed to truncate redo log file")
 		}
 		utils.GetReporter(r.tableName, r.shard).GetCounter(utils.RedoLogFileCorrupt).Inc(1)
+		utils.GetReporter(r.tableName, r.shard).GetCounter(utils.RedoLogCorruptBytesSkipped).Inc(bytesSkipped)
 	}
 }
 
@@ -218,7 +402,11 @@ func (r *RedoLogManager) NextUpsertBatch() func() (*UpsertBatch, int64, uint32)
 	currentIndex := 0
 	var currentReader utils.StreamDataReader
 	var currentFile io.ReadCloser
+	var fileReader *countingReader
+	// offset is the start of the next upsert batch record in current file.
 	var offset uint32
+	var overhead uint32
+	var withKey bool
 
 	return func() (*UpsertBatch, int64, uint32) {
 		for {
@@ -240,7 +428,8 @@ func (r *RedoLogManager) NextUpsertBatch() func() (*UpsertBatch, int64, uint32)
 					utils.GetLogger().Panicf("Failed to open redo log file %v for replay", key)
 				}
 
-				currentReader = utils.NewStreamDataReader(currentFile)
+				fileReader = &countingReader{reader: currentFile}
+				currentReader = utils.NewStreamDataReader(fileReader)
 
 				// Read magic header. If magic number mismatches, this means the whole redolog file is corrupted.
 				// We should immediately crash the server and let engineer to handle this.
@@ -249,50 +438,80 @@ func (r *RedoLogManager) NextUpsertBatch() func() (*UpsertBatch, int64, uint32)
 					utils.GetLogger().Panicf("Failed to read magic header for redo log file %v", key)
 				}
 
-				if header != UpsertHeader {
+				var ok bool
+				if overhead, withKey, ok = getRecordOverhead(header); !ok {
 					utils.GetLogger().Panicf("Invalid header %#x for redo log file %v", header, key)
 				}
 				offset = 4
 			}
 
-			// All later errors are recoverable and should be solved by truncate the redo log file.
+			// All later errors are recoverable and should be solved by truncating the redo log file at
+			// the start of the corrupted upsert batch.
 
 			// Try to read the next batch in the file.
 			size, err := currentReader.ReadUint32()
 			if err == io.EOF {
-				r.closeRedoLogFile(files[currentIndex], offset, &currentFile, &currentIndex, false)
+				// A partial size of the next upsert batch is left by a torn write and truncated as well.
+				r.closeRedoLogFile(files[currentIndex], offset, &currentFile, fileReader, &currentIndex,
+					fileReader.bytesRead > int64(offset))
+				continue
 			} else if err != nil {
 				utils.GetLogger().Errorf("Failed to read size info of the next upsert batch %v",
 					err)
-				r.closeRedoLogFile(files[currentIndex], offset, &currentFile, &currentIndex, true)
-			} else {
-				offset += 4
-				// Found an upsert batch to read.
-				buffer := make([]byte, size)
-				if err := currentReader.Read(buffer); err != nil {
-					utils.GetLogger().Errorf(
-						"Failed to read upsert batch of size %v from file %v at offset %v for table %v shard %v",
-						size, files[currentIndex], offset, r.tableName, r.shard)
-					r.closeRedoLogFile(files[currentIndex], offset-4, &currentFile, &currentIndex, true)
-				} else {
-					offset += size
-					upsertBatch, err := NewUpsertBatch(buffer)
-					if err != nil {
-						utils.GetLogger().Errorf(
-							"Failed to create upsert batch from buffer of size %v from file %v at offset %v for table %v shard %v",
-							size, files[currentIndex], offset, r.tableName, r.shard)
-						r.closeRedoLogFile(files[currentIndex], offset-4-size, &currentFile, &currentIndex, true)
-					} else {
-						// update total redolog size
-						r.TotalRedoLogSize += uint(size + 4)
-						// increment size per file
-						r.SizePerFile[files[currentIndex]] += size + 4
-
-						// update lastBatchOffset for the current redo log file
-						return upsertBatch, files[currentIndex], r.UpdateBatchCount(files[currentIndex]) - 1
-					}
+				r.closeRedoLogFile(files[currentIndex], offset, &currentFile, fileReader, &currentIndex, true)
+				continue
+			}
+
+			var checksum uint32
+			if withKey {
+				if checksum, err = currentReader.ReadUint32(); err != nil {
+					utils.GetLogger().Errorf("Failed to read checksum of the next upsert batch %v",
+						err)
+					r.closeRedoLogFile(files[currentIndex], offset, &currentFile, fileReader, &currentIndex, true)
+					continue
 				}
 			}
+
+			// Found an upsert batch to read.
+			buffer := make([]byte, size)
+			if err := currentReader.Read(buffer); err != nil {
+				utils.GetLogger().Errorf(
+					"Failed to read upsert batch of size %v from file %v at offset %v for table %v shard %v",
+					size, files[currentIndex], offset, r.tableName, r.shard)
+				r.closeRedoLogFile(files[currentIndex], offset, &currentFile, fileReader, &currentIndex, true)
+				continue
+			}
+
+			if withKey && crc32.ChecksumIEEE(buffer) != checksum {
+				utils.GetLogger().Errorf(
+					"Checksum mismatch for upsert batch of size %v from file %v at offset %v for table %v shard %v",
+					size, files[currentIndex], offset, r.tableName, r.shard)
+				r.closeRedoLogFile(files[currentIndex], offset, &currentFile, fileReader, &currentIndex, true)
+				continue
+			}
+
+			var upsertBatch *UpsertBatch
+			if withKey {
+				upsertBatch, err = decodeRedoLogRecord(buffer)
+			} else {
+				upsertBatch, err = NewUpsertBatch(buffer)
+			}
+			if err != nil {
+				utils.GetLogger().Errorf(
+					"Failed to create upsert batch from buffer of size %v from file %v at offset %v for table %v shard %v",
+					size, files[currentIndex], offset, r.tableName, r.shard)
+				r.closeRedoLogFile(files[currentIndex], offset, &currentFile, fileReader, &currentIndex, true)
+				continue
+			}
+
+			offset += overhead + size
+			// update total redolog size
+			r.TotalRedoLogSize += uint(size + overhead)
+			// increment size per file
+			r.SizePerFile[files[currentIndex]] += size + overhead
+
+			// update lastBatchOffset for the current redo log file
+			return upsertBatch, files[currentIndex], r.UpdateBatchCount(files[currentIndex]) - 1
 		}
 	}
 }
@@ -310,24 +529,42 @@ func (r *RedoLogManager) UpdateBatchCount(redoFile int64) uint32 {
 }
 
 // getRedoLogFilesToPurge returns all redo log files whose max event time is less than cutoff and thus
-// is eligible for purging. Readers need to hold the reader lock to access this function.
+// is eligible for purging, and have been eligible for the retention interval.
 // At the same, make sure all records should've backfilled successfully
 func (r *RedoLogManager) getRedoLogFilesToPurge(cutoff uint32, redoFileCheckpointed int64, batchOffset uint32) []int64 {
-	r.RLock()
+	r.Lock()
 	var creationTimes []int64
+	now := utils.Now().Unix()
 	for creationTime, maxEventTime := range r.MaxEventTimePerFile {
 		// exclude current redo file since it's used by ingestion
 		if (creationTime < r.CurrentFileCreationTime || r.CurrentFileCreationTime == 0) && maxEventTime < cutoff {
 			if creationTime < redoFileCheckpointed ||
 				(creationTime == redoFileCheckpointed && r.BatchCountPerFile[redoFileCheckpointed] == batchOffset+1) {
+				since, found := r.purgeableSince[creationTime]
+				if !found {
+					since = now
+					r.purgeableSince[creationTime] = since
+				}
+				// keep redo files within retention interval.
+				if now-since < r.RetentionInterval {
+					continue
+				}
 				creationTimes = append(creationTimes, creationTime)
 			}
 		}
 	}
-	r.RUnlock()
+	r.Unlock()
 	return creationTimes
 }
 
+// SetRetentionInterval sets the time interval to keep redo files after they are eligible to be
+// purged.
+func (r *RedoLogManager) SetRetentionInterval(retentionInterval int64) {
+	r.Lock()
+	r.RetentionInterval = retentionInterval
+	r.Unlock()
+}
+
 // evictRedoLogData evict data belongs to redologs already purged from disk
 func (r *RedoLogManager) evictRedoLogData(creationTime int64) {
 	r.Lock()
@@ -335,6 +572,7 @@ func (r *RedoLogManager) evictRedoLogData(creationTime int64) {
 	delete(r.BatchCountPerFile, creationTime)
 	r.TotalRedoLogSize -= uint(r.SizePerFile[creationTime])
 	delete(r.SizePerFile, creationTime)
+	delete(r.purgeableSince, creationTime)
 	utils.GetReporter(r.tableName, r.shard).GetGauge(utils.NumberOfRedologs).Update(float64(len(r.SizePerFile)))
 	utils.GetReporter(r.tableName, r.shard).GetGauge(utils.SizeOfRedologs).Update(float64(r.TotalRedoLogSize))
 	r.Unlock()
@@ -0,0 +1,81 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	aresCommon "github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/diskstore/mocks"
+	"github.com/uber/aresdb/memstore/common"
+)
+
+// benchmarkRedoLogWrite measures the throughput of writing upsert batches into a redo log file
+// on the local disk under the sync config.
+func benchmarkRedoLogWrite(b *testing.B, syncConfig aresCommon.RedoLogSyncConfig) {
+	dir, err := ioutil.TempDir("", "redolog")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	flags := os.O_APPEND | os.O_CREATE | os.O_WRONLY
+	if syncConfig.Policy == aresCommon.RedoLogSyncPerRecord {
+		flags |= os.O_SYNC
+	}
+	file, err := os.OpenFile(filepath.Join(dir, "1.redolog"), flags, 0644)
+	if err != nil {
+		b.Fatal(err)
+	}
+	diskStore := &mocks.DiskStore{}
+	diskStore.On("OpenLogFileForAppend", "abc", 0, mock.Anything).Return(file, nil)
+	redoManager := NewRedoLogManager(1<<30, 1<<40, syncConfig, diskStore, "abc", 0)
+
+	builder := common.NewUpsertBatchBuilder()
+	builder.AddColumn(1, common.Uint32)
+	for row := 0; row < 100; row++ {
+		builder.AddRow()
+		builder.SetValue(row, 0, uint32(row))
+	}
+	buffer, _ := builder.ToByteArray()
+	upsertBatch, _ := NewUpsertBatch(buffer)
+
+	b.SetBytes(int64(len(buffer)) + 16)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		redoManager.WriteUpsertBatch(upsertBatch)
+	}
+	redoManager.Close()
+}
+
+func BenchmarkRedoLogWrite_Sync(b *testing.B) {
+	benchmarkRedoLogWrite(b, aresCommon.RedoLogSyncConfig{Policy: aresCommon.RedoLogSyncPerRecord})
+}
+
+func BenchmarkRedoLogWrite_Batched(b *testing.B) {
+	benchmarkRedoLogWrite(b, aresCommon.RedoLogSyncConfig{
+		Policy:          aresCommon.RedoLogSyncBatched,
+		BatchRecords:    1000,
+		BatchIntervalMs: 100,
+	})
+}
+
+func BenchmarkRedoLogWrite_Async(b *testing.B) {
+	benchmarkRedoLogWrite(b, aresCommon.RedoLogSyncConfig{Policy: aresCommon.RedoLogSyncAsync})
+}
@@ -15,6 +15,9 @@
 package memstore
 
 import (
+	"bytes"
+	"hash/crc32"
+	"sync/atomic"
 	"time"
 
 	"sort"
@@ -22,20 +25,41 @@ import (
 	"github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/stretchr/testify/mock"
+	"github.com/uber-go/tally"
+	aresCommon "github.com/uber/aresdb/common"
 	"github.com/uber/aresdb/diskstore/mocks"
 	"github.com/uber/aresdb/memstore/common"
 	"github.com/uber/aresdb/testing"
 	"github.com/uber/aresdb/utils"
 )
 
+// syncTestFile is an in-memory redo log file counting fsyncs.
+type syncTestFile struct {
+	testing.TestReadWriteCloser
+	syncs int32
+}
+
+// Sync implements syncer.Sync.
+func (f *syncTestFile) Sync() error {
+	atomic.AddInt32(&f.syncs, 1)
+	return nil
+}
+
 var _ = ginkgo.Describe("redo_log_manager", func() {
+	createSyncTestRedoLogManager := func(syncConfig aresCommon.RedoLogSyncConfig) (*RedoLogManager, *syncTestFile) {
+		file := &syncTestFile{}
+		diskStore := &mocks.DiskStore{}
+		diskStore.On("OpenLogFileForAppend", "abc", 0, mock.Anything).Return(file, nil)
+		redoManager := NewRedoLogManager(10, 1<<30, syncConfig, diskStore, "abc", 0)
+		return &redoManager, file
+	}
 
 	ginkgo.It("create new redo log file if there's no redo file", func() {
 		utils.SetClockImplementation(func() time.Time {
 			return time.Unix(int64(5), 0)
 		})
 
-		redoManager := NewRedoLogManager(10, 1<<30, CreateMockDiskStore(), "abc", 0)
+		redoManager := NewRedoLogManager(10, 1<<30, aresCommon.RedoLogSyncConfig{}, CreateMockDiskStore(), "abc", 0)
 		Ω(redoManager.currentLogFile).Should(BeNil())
 
 		buffer, _ := common.NewUpsertBatchBuilder().ToByteArray()
@@ -53,7 +77,7 @@ var _ = ginkgo.Describe("redo_log_manager", func() {
 		utils.SetClockImplementation(func() time.Time {
 			return time.Unix(int64(5), 0)
 		})
-		redoManager := NewRedoLogManager(10, 1<<30, CreateMockDiskStore(), "abc", 0)
+		redoManager := NewRedoLogManager(10, 1<<30, aresCommon.RedoLogSyncConfig{}, CreateMockDiskStore(), "abc", 0)
 		buffer, _ := common.NewUpsertBatchBuilder().ToByteArray()
 		upsertBatch, _ := NewUpsertBatch(buffer)
 
@@ -76,7 +100,7 @@ var _ = ginkgo.Describe("redo_log_manager", func() {
 		utils.SetClockImplementation(func() time.Time {
 			return time.Unix(int64(5), 0)
 		})
-		redoManager := NewRedoLogManager(10, 1<<30, CreateMockDiskStore(), "abc", 0)
+		redoManager := NewRedoLogManager(10, 1<<30, aresCommon.RedoLogSyncConfig{}, CreateMockDiskStore(), "abc", 0)
 		buffer, _ := common.NewUpsertBatchBuilder().ToByteArray()
 		upsertBatch, _ := NewUpsertBatch(buffer)
 
@@ -99,10 +123,40 @@ var _ = ginkgo.Describe("redo_log_manager", func() {
 		utils.ResetClockImplementation()
 	})
 
+	ginkgo.It("rotate new redo log file if the previous one is too large", func() {
+		utils.SetClockImplementation(func() time.Time {
+			return time.Unix(int64(5), 0)
+		})
+		defer utils.ResetClockImplementation()
+
+		buffer, _ := common.NewUpsertBatchBuilder().ToByteArray()
+		upsertBatch, _ := NewUpsertBatch(buffer)
+		// size, checksum, key expiry, key length and buffer.
+		recordSize := 16 + len(buffer)
+		// Only allows two upsert batches per file.
+		maxRedoLogSize := int64(4 + 3*recordSize)
+		redoManager := NewRedoLogManager(10, maxRedoLogSize, aresCommon.RedoLogSyncConfig{}, CreateMockDiskStore(), "abc", 0)
+
+		redoManager.WriteUpsertBatch(upsertBatch)
+		redoManager.WriteUpsertBatch(upsertBatch)
+		Ω(redoManager.CurrentFileCreationTime).Should(Equal(int64(5)))
+		Ω(redoManager.BatchCountPerFile[5]).Should(Equal(uint32(2)))
+
+		utils.SetClockImplementation(func() time.Time {
+			return time.Unix(int64(6), 0)
+		})
+		redoManager.WriteUpsertBatch(upsertBatch)
+		Ω(redoManager.CurrentFileCreationTime).Should(Equal(int64(6)))
+		Ω(redoManager.BatchCountPerFile[6]).Should(Equal(uint32(1)))
+		Ω(redoManager.CurrentRedoLogSize).Should(Equal(uint32(4 + recordSize)))
+		Ω(redoManager.SizePerFile).Should(HaveLen(2))
+		Ω(redoManager.TotalRedoLogSize).Should(Equal(uint(3 * recordSize)))
+	})
+
 	ginkgo.It("works for NextUpsertBatch iterator with 0 files", func() {
 		diskStore := &mocks.DiskStore{}
 		diskStore.On("ListLogFiles", mock.Anything, mock.Anything).Return([]int64{}, nil)
-		redoManager := NewRedoLogManager(10, 1<<30, diskStore, "abc", 0)
+		redoManager := NewRedoLogManager(10, 1<<30, aresCommon.RedoLogSyncConfig{}, diskStore, "abc", 0)
 		nextUpsertBatch := redoManager.NextUpsertBatch()
 		Ω(nextUpsertBatch()).Should(BeNil())
 		diskStore.AssertExpectations(utils.TestingT)
@@ -129,7 +183,7 @@ var _ = ginkgo.Describe("redo_log_manager", func() {
 		diskStore.On("ListLogFiles", mock.Anything, mock.Anything).Return([]int64{1, 2}, nil)
 		diskStore.On("OpenLogFileForReplay", mock.Anything, mock.Anything, int64(1)).Return(file1, nil)
 		diskStore.On("OpenLogFileForReplay", mock.Anything, mock.Anything, int64(2)).Return(file2, nil)
-		redoManager := NewRedoLogManager(10, 1<<30, diskStore, "abc", 0)
+		redoManager := NewRedoLogManager(10, 1<<30, aresCommon.RedoLogSyncConfig{}, diskStore, "abc", 0)
 		nextUpsertBatch := redoManager.NextUpsertBatch()
 
 		batch, file, _ := nextUpsertBatch()
@@ -175,7 +229,7 @@ var _ = ginkgo.Describe("redo_log_manager", func() {
 		diskStore.On("OpenLogFileForReplay", mock.Anything, mock.Anything, int64(2)).Return(file2, nil)
 		// magic header (uint32) + size (uint32) + correctBufferSize
 		diskStore.On("TruncateLogFile", "abc", 0, int64(2), int64(4+4+correctBufferSize)).Return(nil)
-		redoManager := NewRedoLogManager(10, 1<<30, diskStore, "abc", 0)
+		redoManager := NewRedoLogManager(10, 1<<30, aresCommon.RedoLogSyncConfig{}, diskStore, "abc", 0)
 		nextUpsertBatch := redoManager.NextUpsertBatch()
 
 		batch, file, _ := nextUpsertBatch()
@@ -225,7 +279,7 @@ var _ = ginkgo.Describe("redo_log_manager", func() {
 		diskStore.On("OpenLogFileForReplay", mock.Anything, mock.Anything, int64(2)).Return(file2, nil)
 		// magic header (uint32) + size (uint32) + correctBufferSize
 		diskStore.On("TruncateLogFile", "abc", 0, int64(2), int64(4+4+correctBufferSize)).Return(nil)
-		redoManager := NewRedoLogManager(10, 1<<30, diskStore, "abc", 0)
+		redoManager := NewRedoLogManager(10, 1<<30, aresCommon.RedoLogSyncConfig{}, diskStore, "abc", 0)
 		nextUpsertBatch := redoManager.NextUpsertBatch()
 
 		batch, file, _ := nextUpsertBatch()
@@ -275,7 +329,7 @@ var _ = ginkgo.Describe("redo_log_manager", func() {
 		diskStore.On("OpenLogFileForReplay", mock.Anything, mock.Anything, int64(2)).Return(file2, nil)
 		// magic header (uint32) + size (uint32) + correctBufferSize
 		diskStore.On("TruncateLogFile", "abc", 0, int64(2), int64(4+4+correctBufferSize)).Return(nil)
-		redoManager := NewRedoLogManager(10, 1<<30, diskStore, "abc", 0)
+		redoManager := NewRedoLogManager(10, 1<<30, aresCommon.RedoLogSyncConfig{}, diskStore, "abc", 0)
 		nextUpsertBatch := redoManager.NextUpsertBatch()
 
 		batch, file, _ := nextUpsertBatch()
@@ -332,7 +386,7 @@ var _ = ginkgo.Describe("redo_log_manager", func() {
 		diskStore.On("OpenLogFileForReplay", mock.Anything, mock.Anything, int64(3)).Return(file3, nil)
 		// magic header (uint32) + size (uint32) + correctBufferSize
 		diskStore.On("TruncateLogFile", "abc", 0, int64(2), int64(4+4+correctBufferSize)).Return(nil)
-		redoManager := NewRedoLogManager(10, 1<<30, diskStore, "abc", 0)
+		redoManager := NewRedoLogManager(10, 1<<30, aresCommon.RedoLogSyncConfig{}, diskStore, "abc", 0)
 		nextUpsertBatch := redoManager.NextUpsertBatch()
 
 		batch, file, _ := nextUpsertBatch()
@@ -358,6 +412,194 @@ var _ = ginkgo.Describe("redo_log_manager", func() {
 		diskStore.AssertExpectations(utils.TestingT)
 	})
 
+	ginkgo.It("writes checksum and idempotency key for each upsert batch and replays them", func() {
+		utils.SetClockImplementation(func() time.Time {
+			return time.Unix(int64(5), 0)
+		})
+		defer utils.ResetClockImplementation()
+
+		buffer, _ := common.NewUpsertBatchBuilder().ToByteArray()
+		upsertBatch, _ := NewUpsertBatch(buffer)
+		keyedUpsertBatch, _ := NewUpsertBatch(buffer)
+		keyedUpsertBatch.IdempotencyKey = "key"
+		keyedUpsertBatch.IdempotencyKeyExpiry = 65
+
+		file := &testing.TestReadWriteCloser{}
+		diskStore := &mocks.DiskStore{}
+		diskStore.On("OpenLogFileForAppend", "abc", 0, int64(5)).Return(file, nil)
+		redoManager := NewRedoLogManager(10, 1<<30, aresCommon.RedoLogSyncConfig{}, diskStore, "abc", 0)
+		redoManager.WriteUpsertBatch(upsertBatch)
+		redoManager.WriteUpsertBatch(keyedUpsertBatch)
+		// magic header (uint32) + 2 * (size (uint32) + checksum (uint32) + key expiry (uint32) +
+		// key length (uint32) + buffer) + key
+		Ω(redoManager.CurrentRedoLogSize).Should(Equal(uint32(4 + 2*(16+len(buffer)) + 3)))
+		Ω(file.Len()).Should(Equal(4 + 2*(16+len(buffer)) + 3))
+
+		streamReader := utils.NewStreamDataReader(bytes.NewReader(file.Bytes()))
+		header, _ := streamReader.ReadUint32()
+		Ω(header).Should(Equal(UpsertWithKeyHeader))
+		size, _ := streamReader.ReadUint32()
+		Ω(size).Should(Equal(uint32(8 + len(buffer))))
+		checksum, _ := streamReader.ReadUint32()
+		record := make([]byte, size)
+		streamReader.Read(record)
+		Ω(checksum).Should(Equal(crc32.ChecksumIEEE(record)))
+
+		diskStore.On("ListLogFiles", "abc", 0).Return([]int64{5}, nil)
+		diskStore.On("OpenLogFileForReplay", "abc", 0, int64(5)).Return(file, nil)
+		replayManager := NewRedoLogManager(10, 1<<30, aresCommon.RedoLogSyncConfig{}, diskStore, "abc", 0)
+		nextUpsertBatch := replayManager.NextUpsertBatch()
+
+		batch, redoFile, offset := nextUpsertBatch()
+		Ω(batch).ShouldNot(BeNil())
+		Ω(batch.IdempotencyKey).Should(BeEmpty())
+		Ω(batch.GetBuffer()).Should(Equal(buffer))
+		Ω(redoFile).Should(Equal(int64(5)))
+		Ω(offset).Should(Equal(uint32(0)))
+
+		batch, redoFile, offset = nextUpsertBatch()
+		Ω(batch).ShouldNot(BeNil())
+		Ω(batch.IdempotencyKey).Should(Equal("key"))
+		Ω(batch.IdempotencyKeyExpiry).Should(Equal(int64(65)))
+		Ω(batch.GetBuffer()).Should(Equal(buffer))
+		Ω(redoFile).Should(Equal(int64(5)))
+		Ω(offset).Should(Equal(uint32(1)))
+
+		batch, _, _ = nextUpsertBatch()
+		Ω(batch).Should(BeNil())
+		Ω(replayManager.SizePerFile[5]).Should(Equal(uint32(2*(16+len(buffer)) + 3)))
+		diskStore.AssertExpectations(utils.TestingT)
+	})
+
+	ginkgo.It("recovers prior upsert batches from redo log file truncated in the middle of a record", func() {
+		utils.ResetDefaults()
+		testScope := utils.GetRootReporter().GetRootScope().(tally.TestScope)
+
+		buffer, _ := common.NewUpsertBatchBuilder().ToByteArray()
+		record := append(encodeIngestionKey(&UpsertBatch{}), buffer...)
+		correctRecordSize := 8 + len(record)
+
+		file := &testing.TestReadWriteCloser{}
+		streamWriter := utils.NewStreamDataWriter(file)
+		streamWriter.WriteUint32(UpsertWithKeyHeader)
+		for i := 0; i < 3; i++ {
+			streamWriter.WriteUint32(uint32(len(record)))
+			streamWriter.WriteUint32(crc32.ChecksumIEEE(record))
+			streamWriter.Write(record)
+		}
+		// Cut the last record in the middle of its buffer.
+		file.Truncate(4 + 2*correctRecordSize + 8 + len(record)/2)
+
+		diskStore := &mocks.DiskStore{}
+		diskStore.On("ListLogFiles", "abc", 0).Return([]int64{1}, nil)
+		diskStore.On("OpenLogFileForReplay", "abc", 0, int64(1)).Return(file, nil)
+		// magic header (uint32) + two valid records.
+		diskStore.On("TruncateLogFile", "abc", 0, int64(1), int64(4+2*correctRecordSize)).Return(nil)
+		redoManager := NewRedoLogManager(10, 1<<30, aresCommon.RedoLogSyncConfig{}, diskStore, "abc", 0)
+		nextUpsertBatch := redoManager.NextUpsertBatch()
+
+		batch, redoFile, _ := nextUpsertBatch()
+		Ω(batch).ShouldNot(BeNil())
+		Ω(redoFile).Should(Equal(int64(1)))
+
+		batch, redoFile, _ = nextUpsertBatch()
+		Ω(batch).ShouldNot(BeNil())
+		Ω(redoFile).Should(Equal(int64(1)))
+
+		// Last partial record is truncated.
+		batch, _, _ = nextUpsertBatch()
+		Ω(batch).Should(BeNil())
+		Ω(redoManager.SizePerFile[1]).Should(Equal(uint32(2 * correctRecordSize)))
+		diskStore.AssertExpectations(utils.TestingT)
+
+		counters := testScope.Snapshot().Counters()
+		Ω(counters["test.redo_log_file_corrupt+component=diskstore"].Value()).Should(BeEquivalentTo(1))
+		Ω(counters["test.redo_log_corrupt_bytes_skipped+component=diskstore"].Value()).Should(
+			BeEquivalentTo(8 + len(record)/2))
+	})
+
+	ginkgo.It("truncates redo log file ending with a partial size of the next record", func() {
+		buffer, _ := common.NewUpsertBatchBuilder().ToByteArray()
+		record := append(encodeIngestionKey(&UpsertBatch{}), buffer...)
+		correctRecordSize := 8 + len(record)
+
+		for tail := 1; tail < 4; tail++ {
+			utils.ResetDefaults()
+			testScope := utils.GetRootReporter().GetRootScope().(tally.TestScope)
+
+			file := &testing.TestReadWriteCloser{}
+			streamWriter := utils.NewStreamDataWriter(file)
+			streamWriter.WriteUint32(UpsertWithKeyHeader)
+			for i := 0; i < 2; i++ {
+				streamWriter.WriteUint32(uint32(len(record)))
+				streamWriter.WriteUint32(crc32.ChecksumIEEE(record))
+				streamWriter.Write(record)
+			}
+			// Cut the size of the second record.
+			file.Truncate(4 + correctRecordSize + tail)
+
+			diskStore := &mocks.DiskStore{}
+			diskStore.On("ListLogFiles", "abc", 0).Return([]int64{1}, nil)
+			diskStore.On("OpenLogFileForReplay", "abc", 0, int64(1)).Return(file, nil)
+			diskStore.On("TruncateLogFile", "abc", 0, int64(1), int64(4+correctRecordSize)).Return(nil)
+			redoManager := NewRedoLogManager(10, 1<<30, aresCommon.RedoLogSyncConfig{}, diskStore, "abc", 0)
+			nextUpsertBatch := redoManager.NextUpsertBatch()
+
+			batch, _, _ := nextUpsertBatch()
+			Ω(batch).ShouldNot(BeNil())
+			batch, _, _ = nextUpsertBatch()
+			Ω(batch).Should(BeNil())
+			Ω(redoManager.SizePerFile[1]).Should(Equal(uint32(correctRecordSize)))
+			diskStore.AssertExpectations(utils.TestingT)
+
+			counters := testScope.Snapshot().Counters()
+			Ω(counters["test.redo_log_file_corrupt+component=diskstore"].Value()).Should(BeEquivalentTo(1))
+			Ω(counters["test.redo_log_corrupt_bytes_skipped+component=diskstore"].Value()).Should(BeEquivalentTo(tail))
+		}
+	})
+
+	ginkgo.It("truncate redo log file works for checksum mismatch", func() {
+		utils.ResetDefaults()
+		testScope := utils.GetRootReporter().GetRootScope().(tally.TestScope)
+
+		buffer, _ := common.NewUpsertBatchBuilder().ToByteArray()
+		record := append(encodeIngestionKey(&UpsertBatch{}), buffer...)
+		correctRecordSize := 8 + len(record)
+
+		file := &testing.TestReadWriteCloser{}
+		streamWriter := utils.NewStreamDataWriter(file)
+		streamWriter.WriteUint32(UpsertWithKeyHeader)
+		streamWriter.WriteUint32(uint32(len(record)))
+		streamWriter.WriteUint32(crc32.ChecksumIEEE(record))
+		streamWriter.Write(record)
+		// Corrupted checksum.
+		streamWriter.WriteUint32(uint32(len(record)))
+		streamWriter.WriteUint32(crc32.ChecksumIEEE(record) + 1)
+		streamWriter.Write(record)
+		// Valid record after the corrupted one is dropped as well.
+		streamWriter.WriteUint32(uint32(len(record)))
+		streamWriter.WriteUint32(crc32.ChecksumIEEE(record))
+		streamWriter.Write(record)
+
+		diskStore := &mocks.DiskStore{}
+		diskStore.On("ListLogFiles", "abc", 0).Return([]int64{1}, nil)
+		diskStore.On("OpenLogFileForReplay", "abc", 0, int64(1)).Return(file, nil)
+		diskStore.On("TruncateLogFile", "abc", 0, int64(1), int64(4+correctRecordSize)).Return(nil)
+		redoManager := NewRedoLogManager(10, 1<<30, aresCommon.RedoLogSyncConfig{}, diskStore, "abc", 0)
+		nextUpsertBatch := redoManager.NextUpsertBatch()
+
+		batch, _, _ := nextUpsertBatch()
+		Ω(batch).ShouldNot(BeNil())
+
+		batch, _, _ = nextUpsertBatch()
+		Ω(batch).Should(BeNil())
+		diskStore.AssertExpectations(utils.TestingT)
+
+		counters := testScope.Snapshot().Counters()
+		Ω(counters["test.redo_log_corrupt_bytes_skipped+component=diskstore"].Value()).Should(
+			BeEquivalentTo(2 * correctRecordSize))
+	})
+
 	ginkgo.It("works for NextUpsertBatch iterator with empty file", func() {
 		buffer, _ := common.NewUpsertBatchBuilder().ToByteArray()
 
@@ -380,7 +622,7 @@ var _ = ginkgo.Describe("redo_log_manager", func() {
 		diskStore.On("OpenLogFileForReplay", mock.Anything, mock.Anything, int64(1)).Return(file1, nil)
 		diskStore.On("OpenLogFileForReplay", mock.Anything, mock.Anything, int64(2)).Return(file2, nil)
 		diskStore.On("OpenLogFileForReplay", mock.Anything, mock.Anything, int64(3)).Return(file3, nil)
-		redoManager := NewRedoLogManager(10, 1<<30, diskStore, "abc", 0)
+		redoManager := NewRedoLogManager(10, 1<<30, aresCommon.RedoLogSyncConfig{}, diskStore, "abc", 0)
 		nextUpsertBatch := redoManager.NextUpsertBatch()
 
 		batch, file, _ := nextUpsertBatch()
@@ -393,7 +635,7 @@ var _ = ginkgo.Describe("redo_log_manager", func() {
 	})
 
 	ginkgo.It("getRedoLogFilesToPurge should work", func() {
-		redoManager := NewRedoLogManager(10, 1<<30, CreateMockDiskStore(), "abc", 0)
+		redoManager := NewRedoLogManager(10, 1<<30, aresCommon.RedoLogSyncConfig{}, CreateMockDiskStore(), "abc", 0)
 		redoManager.MaxEventTimePerFile[1] = 100
 		redoManager.MaxEventTimePerFile[2] = 200
 		redoManager.MaxEventTimePerFile[3] = 300
@@ -418,10 +660,66 @@ var _ = ginkgo.Describe("redo_log_manager", func() {
 		Ω(creationTimes).Should(Equal([]int64{1, 2}))
 	})
 
+	ginkgo.It("getRedoLogFilesToPurge should keep redo log files within retention interval", func() {
+		setNow := func(now int64) {
+			utils.SetClockImplementation(func() time.Time {
+				return time.Unix(now, 0)
+			})
+		}
+		setNow(1000)
+		defer utils.ResetClockImplementation()
+
+		redoManager := NewRedoLogManager(10, 1<<30, aresCommon.RedoLogSyncConfig{}, CreateMockDiskStore(), "abc", 0)
+		redoManager.RetentionInterval = 300
+		redoManager.MaxEventTimePerFile[100] = 100
+		redoManager.MaxEventTimePerFile[600] = 200
+		redoManager.MaxEventTimePerFile[800] = 300
+		redoManager.MaxEventTimePerFile[900] = 400
+		redoManager.CurrentFileCreationTime = 900
+		redoManager.BatchCountPerFile[100] = 10
+		redoManager.BatchCountPerFile[600] = 20
+		redoManager.BatchCountPerFile[800] = 30
+		redoManager.BatchCountPerFile[900] = 40
+
+		// '100' becomes purgeable, '600' is not fully backfilled yet.
+		Ω(redoManager.getRedoLogFilesToPurge(500, 600, 15)).Should(BeEmpty())
+
+		// retention is measured from when the files became purgeable instead of their creation.
+		setNow(1300)
+		creationTimes := redoManager.getRedoLogFilesToPurge(500, 900, 0)
+		Ω(creationTimes).Should(Equal([]int64{100}))
+
+		// '600' and '800' became purgeable at 1300.
+		setNow(1599)
+		Ω(redoManager.getRedoLogFilesToPurge(500, 900, 0)).Should(Equal([]int64{100}))
+		redoManager.evictRedoLogData(100)
+		setNow(1600)
+		creationTimes = redoManager.getRedoLogFilesToPurge(500, 900, 0)
+		sort.Sort(utils.Int64Array(creationTimes))
+		Ω(creationTimes).Should(Equal([]int64{600, 800}))
+	})
+
+	ginkgo.It("getRedoLogFilesToPurge should use the updated retention interval", func() {
+		utils.SetClockImplementation(func() time.Time {
+			return time.Unix(int64(1000), 0)
+		})
+		defer utils.ResetClockImplementation()
+
+		redoManager := NewRedoLogManager(10, 1<<30, aresCommon.RedoLogSyncConfig{}, CreateMockDiskStore(), "abc", 0)
+		redoManager.SetRetentionInterval(300)
+		redoManager.MaxEventTimePerFile[100] = 100
+		redoManager.CurrentFileCreationTime = 900
+		redoManager.BatchCountPerFile[100] = 10
+		Ω(redoManager.getRedoLogFilesToPurge(500, 900, 0)).Should(BeEmpty())
+
+		redoManager.SetRetentionInterval(0)
+		Ω(redoManager.getRedoLogFilesToPurge(500, 900, 0)).Should(Equal([]int64{100}))
+	})
+
 	ginkgo.It("PurgeRedologFileAndData should work", func() {
 		diskStore := CreateMockDiskStore()
 		diskStore.On("DeleteLogFile", "abc", 0, mock.Anything).Return(nil)
-		redoManager := NewRedoLogManager(10, 1<<30, diskStore, "abc", 0)
+		redoManager := NewRedoLogManager(10, 1<<30, aresCommon.RedoLogSyncConfig{}, diskStore, "abc", 0)
 		redoManager.MaxEventTimePerFile[1] = 100
 		redoManager.MaxEventTimePerFile[2] = 200
 		redoManager.MaxEventTimePerFile[3] = 300
@@ -437,4 +735,78 @@ var _ = ginkgo.Describe("redo_log_manager", func() {
 		Ω(redoManager.BatchCountPerFile).ShouldNot(HaveKey(1))
 		Ω(redoManager.BatchCountPerFile).ShouldNot(HaveKey(2))
 	})
+
+	ginkgo.It("batched sync policy should fsync every batch records and on close", func() {
+		redoManager, file := createSyncTestRedoLogManager(aresCommon.RedoLogSyncConfig{
+			Policy:       aresCommon.RedoLogSyncBatched,
+			BatchRecords: 2,
+		})
+		buffer, _ := common.NewUpsertBatchBuilder().ToByteArray()
+		upsertBatch, _ := NewUpsertBatch(buffer)
+		redoManager.WriteUpsertBatch(upsertBatch)
+		Ω(atomic.LoadInt32(&file.syncs)).Should(BeEquivalentTo(0))
+		redoManager.WriteUpsertBatch(upsertBatch)
+		Ω(atomic.LoadInt32(&file.syncs)).Should(BeEquivalentTo(1))
+		redoManager.WriteUpsertBatch(upsertBatch)
+		Ω(atomic.LoadInt32(&file.syncs)).Should(BeEquivalentTo(1))
+
+		// Records not fsynced yet are fsynced on clean shutdown.
+		redoManager.Close()
+		Ω(atomic.LoadInt32(&file.syncs)).Should(BeEquivalentTo(2))
+	})
+
+	ginkgo.It("batched sync policy should fsync within batch interval", func() {
+		redoManager, file := createSyncTestRedoLogManager(aresCommon.RedoLogSyncConfig{
+			Policy:          aresCommon.RedoLogSyncBatched,
+			BatchIntervalMs: 10,
+		})
+		buffer, _ := common.NewUpsertBatchBuilder().ToByteArray()
+		upsertBatch, _ := NewUpsertBatch(buffer)
+		redoManager.WriteUpsertBatch(upsertBatch)
+		redoManager.WriteUpsertBatch(upsertBatch)
+		Eventually(func() int32 {
+			return atomic.LoadInt32(&file.syncs)
+		}).Should(BeEquivalentTo(1))
+
+		// Nothing left to fsync on close.
+		redoManager.Close()
+		Ω(atomic.LoadInt32(&file.syncs)).Should(BeEquivalentTo(1))
+	})
+
+	ginkgo.It("async and per record sync policies should not fsync from redo log manager", func() {
+		for _, policy := range []aresCommon.RedoLogSyncPolicy{aresCommon.RedoLogSyncAsync, aresCommon.RedoLogSyncPerRecord} {
+			redoManager, file := createSyncTestRedoLogManager(aresCommon.RedoLogSyncConfig{Policy: policy})
+			buffer, _ := common.NewUpsertBatchBuilder().ToByteArray()
+			upsertBatch, _ := NewUpsertBatch(buffer)
+			redoManager.WriteUpsertBatch(upsertBatch)
+			redoManager.Close()
+			Ω(atomic.LoadInt32(&file.syncs)).Should(BeEquivalentTo(0))
+		}
+	})
+
+	ginkgo.It("memstore close should fsync and close redo log files of all shards", func() {
+		file := &syncTestFile{}
+		diskStore := &mocks.DiskStore{}
+		diskStore.On("OpenLogFileForAppend", "abc", 0, mock.Anything).Return(file, nil)
+		shard := &TableShard{
+			LiveStore: &LiveStore{
+				RedoLogManager: NewRedoLogManager(10, 1<<30, aresCommon.RedoLogSyncConfig{
+					Policy:       aresCommon.RedoLogSyncBatched,
+					BatchRecords: 10,
+				}, diskStore, "abc", 0),
+			},
+		}
+		memStore := &memStoreImpl{
+			TableShards: map[string]map[int]*TableShard{"abc": {0: shard}},
+		}
+
+		buffer, _ := common.NewUpsertBatchBuilder().ToByteArray()
+		upsertBatch, _ := NewUpsertBatch(buffer)
+		shard.LiveStore.RedoLogManager.WriteUpsertBatch(upsertBatch)
+		Ω(atomic.LoadInt32(&file.syncs)).Should(BeEquivalentTo(0))
+
+		memStore.Close()
+		Ω(atomic.LoadInt32(&file.syncs)).Should(BeEquivalentTo(1))
+		Ω(shard.LiveStore.RedoLogManager.currentLogFile).Should(BeNil())
+	})
 })
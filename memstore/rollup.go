@@ -0,0 +1,406 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"fmt"
+
+	memCom "github.com/uber/aresdb/memstore/common"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/utils"
+)
+
+// rollup aggregates rows of archive batches of the base table into rows of the rollup table.
+type rollup struct {
+	// seconds of each time bucket.
+	bucketSeconds uint32
+	// ids of dimension columns in the base table and in the rollup table.
+	baseDimensionIDs []int
+	dimensionIDs     []int
+	// data types of dimension columns, the same in both tables.
+	dimensionTypes []memCom.DataType
+	measures       []rollupMeasure
+}
+
+// rollupMeasure aggregates a column of the base table into a column of the rollup table.
+type rollupMeasure struct {
+	aggregate string
+	// -1 for count.
+	baseColumnID int
+	baseDataType memCom.DataType
+	columnID     int
+	dataType     memCom.DataType
+}
+
+// rollupGroup holds the aggregates of the rows in a time bucket with the same dimension values.
+type rollupGroup struct {
+	bucket     uint32
+	dimensions []interface{}
+	aggregates []rollupAggregate
+}
+
+// rollupAggregate is the aggregate of a measure in a group, integers are aggregated in intValue
+// so that sums are exact.
+type rollupAggregate struct {
+	valid      bool
+	intValue   int64
+	floatValue float64
+}
+
+// newRollup creates the rollup from the rollup config of the table schema, validating the columns
+// against the base table schema, which may have changed since the rollup table was created.
+func newRollup(schema, baseSchema *TableSchema) (*rollup, error) {
+	schema.RLock()
+	defer schema.RUnlock()
+	baseSchema.RLock()
+	defer baseSchema.RUnlock()
+
+	config := schema.Schema.Config.Rollup
+	if config == nil {
+		return nil, utils.StackError(nil, "Table %s is not a rollup table", schema.Schema.Name)
+	}
+	if !baseSchema.Schema.IsFactTable {
+		return nil, utils.StackError(nil, "Base table %s is not a fact table", baseSchema.Schema.Name)
+	}
+
+	r := &rollup{bucketSeconds: 3600}
+	if config.TimeBucket == metaCom.RollupDay {
+		r.bucketSeconds = 86400
+	}
+
+	for _, name := range config.Dimensions {
+		baseID, ok := baseSchema.ColumnIDs[name]
+		if !ok {
+			return nil, utils.StackError(nil, "Dimension %s is not in base table %s", name, baseSchema.Schema.Name)
+		}
+		id := schema.ColumnIDs[name]
+		dataType := schema.ValueTypeByColumn[id]
+		if baseSchema.ValueTypeByColumn[baseID] != dataType {
+			return nil, utils.StackError(nil, "Dimension %s is %s in rollup table but %s in base table", name,
+				memCom.DataTypeName[dataType], memCom.DataTypeName[baseSchema.ValueTypeByColumn[baseID]])
+		}
+		r.baseDimensionIDs = append(r.baseDimensionIDs, baseID)
+		r.dimensionIDs = append(r.dimensionIDs, id)
+		r.dimensionTypes = append(r.dimensionTypes, dataType)
+	}
+
+	for _, m := range config.Measures {
+		id := schema.ColumnIDs[m.Column]
+		measure := rollupMeasure{
+			aggregate:    m.Aggregate,
+			baseColumnID: -1,
+			columnID:     id,
+			dataType:     schema.ValueTypeByColumn[id],
+		}
+		if m.Aggregate != metaCom.RollupCount {
+			baseID, ok := baseSchema.ColumnIDs[m.BaseColumn]
+			if !ok || !memCom.IsNumeric(baseSchema.ValueTypeByColumn[baseID]) {
+				return nil, utils.StackError(nil, "Measure %s: %s is not a numeric column of base table %s",
+					m.Column, m.BaseColumn, baseSchema.Schema.Name)
+			}
+			measure.baseColumnID = baseID
+			measure.baseDataType = baseSchema.ValueTypeByColumn[baseID]
+
+			expectedType := measure.baseDataType
+			if m.Aggregate == metaCom.RollupSum && expectedType != memCom.Float32 {
+				expectedType = memCom.Int64
+			}
+			if measure.dataType != expectedType {
+				return nil, utils.StackError(nil, "Measure %s: %s of %s should be stored in %s column",
+					m.Column, m.Aggregate, m.BaseColumn, memCom.DataTypeName[expectedType])
+			}
+		}
+		r.measures = append(r.measures, measure)
+	}
+	return r, nil
+}
+
+// add aggregates the value of the base column into the aggregate, value is ignored by count.
+func (a *rollupAggregate) add(measure rollupMeasure, value memCom.DataValue) {
+	if measure.aggregate == metaCom.RollupCount {
+		a.valid = true
+		a.intValue++
+		return
+	}
+	if !value.Valid {
+		return
+	}
+
+	humanReadable := value.ConvertToHumanReadable(measure.baseDataType)
+	if measure.baseDataType == memCom.Float32 {
+		f, ok := memCom.ConvertToFloat64(humanReadable)
+		if !ok {
+			return
+		}
+		switch {
+		case !a.valid:
+			a.floatValue = f
+		case measure.aggregate == metaCom.RollupSum:
+			a.floatValue += f
+		case measure.aggregate == metaCom.RollupMin && f < a.floatValue,
+			measure.aggregate == metaCom.RollupMax && f > a.floatValue:
+			a.floatValue = f
+		}
+	} else {
+		i, _ := memCom.ConvertToInt64(humanReadable)
+		switch {
+		case !a.valid:
+			a.intValue = i
+		case measure.aggregate == metaCom.RollupSum:
+			a.intValue += i
+		case measure.aggregate == metaCom.RollupMin && i < a.intValue,
+			measure.aggregate == metaCom.RollupMax && i > a.intValue:
+			a.intValue = i
+		}
+	}
+	a.valid = true
+}
+
+// value returns the value of the aggregate for the measure column, nil if no value was aggregated.
+func (a *rollupAggregate) value(measure rollupMeasure) interface{} {
+	if !a.valid {
+		return nil
+	}
+	if measure.dataType == memCom.Float32 {
+		return a.floatValue
+	}
+	return a.intValue
+}
+
+// aggregate aggregates the rows of the archive batch of the base table into an upsert batch of
+// the rollup table with a row for each time bucket and dimension values, nil if the batch has no
+// rows with event time.
+func (r *rollup) aggregate(batch *ArchiveBatch) (*UpsertBatch, error) {
+	// the event time column can not be deleted and is always the first column.
+	baseColumnIDs := append([]int{0}, r.baseDimensionIDs...)
+	for _, measure := range r.measures {
+		if measure.baseColumnID >= 0 {
+			baseColumnIDs = append(baseColumnIDs, measure.baseColumnID)
+		}
+	}
+	vps := make(map[int]memCom.ArchiveVectorParty, len(baseColumnIDs))
+	var requestedVPs []memCom.ArchiveVectorParty
+	for _, columnID := range baseColumnIDs {
+		if _, ok := vps[columnID]; ok {
+			continue
+		}
+		vp := batch.RequestVectorParty(columnID)
+		vp.WaitForDiskLoad()
+		vps[columnID] = vp
+		requestedVPs = append(requestedVPs, vp)
+	}
+	defer UnpinVectorParties(requestedVPs)
+
+	groups := make(map[string]*rollupGroup)
+	// keys of groups in the order of their first rows.
+	var keys []string
+	dimensions := make([]interface{}, len(r.baseDimensionIDs))
+	for row := 0; row < batch.Size; row++ {
+		eventTime := vps[0].GetDataValueByRow(row)
+		if !eventTime.Valid {
+			continue
+		}
+		bucket := *(*uint32)(eventTime.OtherVal) / r.bucketSeconds * r.bucketSeconds
+		for i, columnID := range r.baseDimensionIDs {
+			dimensions[i] = vps[columnID].GetDataValueByRow(row).ConvertToHumanReadable(r.dimensionTypes[i])
+		}
+
+		key := fmt.Sprint(bucket, dimensions)
+		group, ok := groups[key]
+		if !ok {
+			group = &rollupGroup{
+				bucket:     bucket,
+				dimensions: append([]interface{}(nil), dimensions...),
+				aggregates: make([]rollupAggregate, len(r.measures)),
+			}
+			groups[key] = group
+			keys = append(keys, key)
+		}
+		for i, measure := range r.measures {
+			var value memCom.DataValue
+			if measure.baseColumnID >= 0 {
+				value = vps[measure.baseColumnID].GetDataValueByRow(row)
+			}
+			group.aggregates[i].add(measure, value)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	builder := memCom.NewUpsertBatchBuilder()
+	builder.AddColumn(0, memCom.Uint32)
+	for i, columnID := range r.dimensionIDs {
+		builder.AddColumn(columnID, r.dimensionTypes[i])
+	}
+	for _, measure := range r.measures {
+		builder.AddColumn(measure.columnID, measure.dataType)
+	}
+	for row, key := range keys {
+		group := groups[key]
+		builder.AddRow()
+		builder.SetValue(row, 0, group.bucket)
+		for i, value := range group.dimensions {
+			if err := builder.SetValue(row, 1+i, value); err != nil {
+				return nil, err
+			}
+		}
+		for i, measure := range r.measures {
+			// aggregates overflowing the measure column are left null.
+			builder.SetValue(row, 1+len(group.dimensions)+i, group.aggregates[i].value(measure))
+		}
+	}
+
+	buffer, err := builder.ToByteArray()
+	if err != nil {
+		return nil, err
+	}
+	return NewUpsertBatch(buffer)
+}
+
+// hasBatchesToRollup tells whether any archive batch of the base table shard in memory has rows
+// and a version other than the version last aggregated in the rollup progress.
+func (shard *TableShard) hasBatchesToRollup(progress map[int]metaCom.BatchVersion) bool {
+	archiveStore := shard.ArchiveStore.GetCurrentVersion()
+	defer archiveStore.Users.Done()
+	archiveStore.RLock()
+	defer archiveStore.RUnlock()
+	for batchID, batch := range archiveStore.Batches {
+		if batch.Size > 0 && progress[int(batchID)] != getBatchVersion(batch) {
+			return true
+		}
+	}
+	return false
+}
+
+// getBatchVersion returns the version of the archive batch.
+func getBatchVersion(batch *ArchiveBatch) metaCom.BatchVersion {
+	return metaCom.BatchVersion{Version: batch.Version, SeqNum: batch.SeqNum}
+}
+
+// MaintainRollup aggregates the archive batches of the base table shard that were archived or
+// backfilled since they were last aggregated, and upserts the rows of their time buckets into the
+// rollup table shard. Rows are upserted by primary key, so buckets recomputed after late arriving
+// rows are backfilled replace the rows aggregated before. Rows excluded by delete predicates of
+// the base table are still aggregated. The version of each batch aggregated is recorded in
+// metaStore so that the job resumes after restart.
+func (m *memStoreImpl) MaintainRollup(table string, shardID int, reporter RollupJobDetailReporter) error {
+	start := utils.Now()
+	jobKey := getIdentifier(table, shardID, memCom.RollupJobType)
+	rollupTimer := utils.GetReporter(table, shardID).GetTimer(utils.RollupTimingTotal)
+	defer func() {
+		duration := utils.Now().Sub(start)
+		rollupTimer.Record(duration)
+		reporter(jobKey, func(status *RollupJobDetail) {
+			status.LastDuration = duration
+		})
+	}()
+
+	shard, err := m.GetTableShard(table, shardID)
+	if err != nil {
+		return err
+	}
+	defer shard.Users.Done()
+
+	shard.Schema.RLock()
+	config := shard.Schema.Schema.Config.Rollup
+	shard.Schema.RUnlock()
+	if config == nil {
+		return nil
+	}
+
+	baseShard, err := m.GetTableShard(config.BaseTable, shardID)
+	if err != nil {
+		// the base table can be deleted after the job was generated.
+		utils.GetLogger().With("table", table, "shard", shardID, "baseTable", config.BaseTable).
+			Warn("Base table shard of rollup table not found")
+		return nil
+	}
+	defer baseShard.Users.Done()
+
+	r, err := newRollup(shard.Schema, baseShard.Schema)
+	if err != nil {
+		return err
+	}
+
+	progress, err := m.metaStore.GetRollupProgress(table, shardID)
+	if err != nil {
+		return err
+	}
+	batchIDs, err := m.metaStore.GetArchiveBatchIDs(config.BaseTable, shardID)
+	if err != nil {
+		return err
+	}
+
+	archiveStore := baseShard.ArchiveStore.GetCurrentVersion()
+	defer archiveStore.Users.Done()
+
+	var batches []*ArchiveBatch
+	archived := make(map[int]bool, len(batchIDs))
+	for _, batchID := range batchIDs {
+		archived[batchID] = true
+		batch := archiveStore.RequestBatch(int32(batchID))
+		if batch.Size > 0 && progress[batchID] != getBatchVersion(batch) {
+			batches = append(batches, batch)
+		}
+	}
+	// rows of purged batches stay in the rollup table until purged by its own retention.
+	purged := false
+	for batchID := range progress {
+		if !archived[batchID] {
+			delete(progress, batchID)
+			purged = true
+		}
+	}
+	if purged && len(batches) == 0 {
+		if err = m.metaStore.UpdateRollupProgress(table, shardID, progress); err != nil {
+			return err
+		}
+	}
+
+	reporter(jobKey, func(status *RollupJobDetail) {
+		status.BaseTable = config.BaseTable
+		status.Current = 0
+		status.Total = len(batches)
+		status.NumAffectedDays = len(batches)
+		status.NumRecords = 0
+	})
+
+	for i, batch := range batches {
+		upsertBatch, err := r.aggregate(batch)
+		if err != nil {
+			return err
+		}
+		numRecords := 0
+		if upsertBatch != nil {
+			if err = m.HandleIngestion(table, shardID, upsertBatch); err != nil {
+				return err
+			}
+			numRecords = upsertBatch.NumRows
+		}
+
+		progress[int(batch.BatchID)] = getBatchVersion(batch)
+		if err = m.metaStore.UpdateRollupProgress(table, shardID, progress); err != nil {
+			return err
+		}
+		utils.GetReporter(table, shardID).GetCounter(utils.RolledUpBatches).Inc(1)
+		reporter(jobKey, func(status *RollupJobDetail) {
+			status.Current = i + 1
+			status.LastBatchID = int(batch.BatchID)
+			status.NumRecords += numRecords
+		})
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	"github.com/uber/aresdb/memstore/common"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	metaStoreMocks "github.com/uber/aresdb/metastore/mocks"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("rollup", func() {
+	var memStore *memStoreImpl
+	var metaStore *metaStoreMocks.MetaStore
+	var baseShard, rollupShard *TableShard
+
+	// rows of the base table: request_at, city_id, fare, distance.
+	baseRows := [][]string{
+		{"86410", "1", "2.5", "10"},
+		{"89999", "1", "1.5", ""},
+		{"90000", "1", "3", "5"},
+		{"86420", "2", "", "7"},
+		{"86430", "1", "4", "20"},
+		{"93600", "", "1.25", "-3"},
+	}
+
+	ginkgo.BeforeEach(func() {
+		metaStore = &metaStoreMocks.MetaStore{}
+		diskStore := CreateMockDiskStore()
+		memStore = NewMemStore(metaStore, diskStore, Options{}).(*memStoreImpl)
+
+		baseSchema := NewTableSchema(&metaCom.Table{
+			Name:        "trips",
+			IsFactTable: true,
+			Columns: []metaCom.Column{
+				{Name: "request_at", Type: metaCom.Uint32},
+				{Name: "city_id", Type: metaCom.Uint16},
+				{Name: "fare", Type: metaCom.Float32},
+				{Name: "distance", Type: metaCom.Int32},
+			},
+			PrimaryKeyColumns: []int{0},
+		})
+		rollupSchema := NewTableSchema(&metaCom.Table{
+			Name:        "trips_hourly",
+			IsFactTable: true,
+			Columns: []metaCom.Column{
+				{Name: "hour", Type: metaCom.Uint32},
+				{Name: "city_id", Type: metaCom.Uint16},
+				{Name: "trips", Type: metaCom.Uint32},
+				{Name: "total_fare", Type: metaCom.Float32},
+				{Name: "total_distance", Type: metaCom.Int64},
+				{Name: "max_distance", Type: metaCom.Int32},
+			},
+			PrimaryKeyColumns: []int{0, 1},
+			Config: metaCom.TableConfig{
+				BatchSize:                10,
+				BackfillMaxBufferSize:    1 << 32,
+				BackfillThresholdInBytes: 1 << 21,
+				Rollup: &metaCom.RollupConfig{
+					BaseTable:  "trips",
+					TimeBucket: metaCom.RollupHour,
+					Dimensions: []string{"city_id"},
+					Measures: []metaCom.RollupMeasure{
+						{Column: "trips", Aggregate: metaCom.RollupCount},
+						{Column: "total_fare", Aggregate: metaCom.RollupSum, BaseColumn: "fare"},
+						{Column: "total_distance", Aggregate: metaCom.RollupSum, BaseColumn: "distance"},
+						{Column: "max_distance", Aggregate: metaCom.RollupMax, BaseColumn: "distance"},
+					},
+				},
+			},
+		})
+		for _, schema := range []*TableSchema{baseSchema, rollupSchema} {
+			for columnID := range schema.Schema.Columns {
+				schema.SetDefaultValue(columnID)
+			}
+			memStore.TableSchemas[schema.Schema.Name] = schema
+		}
+
+		hostMemoryManager := NewHostMemoryManager(memStore, 1<<32)
+		baseShard = NewTableShard(baseSchema, metaStore, diskStore, hostMemoryManager, 0, Options{})
+		rollupShard = NewTableShard(rollupSchema, metaStore, diskStore, hostMemoryManager, 0, Options{})
+		memStore.TableShards["trips"] = map[int]*TableShard{0: baseShard}
+		memStore.TableShards["trips_hourly"] = map[int]*TableShard{0: rollupShard}
+		baseShard.ArchiveStore.CurrentVersion = NewArchiveStoreVersion(86400*2, baseShard)
+	})
+
+	// setArchiveBatch sets archive batch 1 of the base table with the rows.
+	setArchiveBatch := func(rows [][]string, seqNum uint32) {
+		batch := &ArchiveBatch{
+			Batch:   Batch{RWMutex: &sync.RWMutex{}},
+			Size:    len(rows),
+			Version: 86400 * 2,
+			SeqNum:  seqNum,
+			BatchID: 1,
+			Shard:   baseShard,
+		}
+		for columnID, dataType := range baseShard.Schema.ValueTypeByColumn {
+			vp := newArchiveVectorParty(len(rows), dataType, common.NullDataValue, batch.RWMutex)
+			vp.Allocate(false)
+			for row, values := range rows {
+				value, err := common.ValueFromString(values[columnID], dataType)
+				Ω(err).Should(BeNil())
+				vp.SetDataValue(row, value, IncrementCount)
+			}
+			batch.Columns = append(batch.Columns, vp)
+		}
+		baseShard.ArchiveStore.CurrentVersion.Batches[1] = batch
+	}
+
+	// aggregateDirectly aggregates the rows by hour and city, returning the values of the rollup
+	// columns by "hour,city".
+	aggregateDirectly := func(rows [][]string) map[string][]interface{} {
+		expected := map[string][]interface{}{}
+		for _, values := range rows {
+			var requestAt uint32
+			fmt.Sscan(values[0], &requestAt)
+			key := fmt.Sprintf("%d,%s", requestAt/3600*3600, values[1])
+			if expected[key] == nil {
+				expected[key] = []interface{}{uint32(0), nil, nil, nil}
+			}
+			aggregates := expected[key]
+			aggregates[0] = aggregates[0].(uint32) + 1
+			if values[2] != "" {
+				var fare float32
+				fmt.Sscan(values[2], &fare)
+				if aggregates[1] == nil {
+					aggregates[1] = float32(0)
+				}
+				aggregates[1] = aggregates[1].(float32) + fare
+			}
+			if values[3] != "" {
+				var distance int32
+				fmt.Sscan(values[3], &distance)
+				if aggregates[2] == nil {
+					aggregates[2], aggregates[3] = int64(0), distance
+				}
+				aggregates[2] = aggregates[2].(int64) + int64(distance)
+				if distance > aggregates[3].(int32) {
+					aggregates[3] = distance
+				}
+			}
+		}
+		return expected
+	}
+
+	// readRollup reads the values of the rollup columns of the row for the key "hour,city".
+	readRollup := func(key string) []interface{} {
+		var hour uint32
+		var city string
+		fmt.Sscanf(key, "%d,%s", &hour, &city)
+		primaryKey := make([]byte, 6)
+		binary.LittleEndian.PutUint32(primaryKey, hour)
+		if city != "" {
+			var cityID uint16
+			fmt.Sscan(city, &cityID)
+			binary.LittleEndian.PutUint16(primaryKey[4:], cityID)
+		}
+
+		var values []interface{}
+		for columnID := 2; columnID < 6; columnID++ {
+			vp, index := getVectorParty(rollupShard, columnID, primaryKey)
+			Ω(vp).ShouldNot(BeNil(), key)
+			values = append(values, vp.GetDataValue(index).ConvertToHumanReadable(rollupShard.Schema.ValueTypeByColumn[columnID]))
+		}
+		return values
+	}
+
+	ginkgo.It("maintains rollup rows matching direct aggregation of base rows", func() {
+		// rows of the base table with null city are not in the rollup, as null and zero
+		// have the same primary key.
+		rows := baseRows[:5]
+		setArchiveBatch(rows, 0)
+		metaStore.On("GetRollupProgress", "trips_hourly", 0).Return(map[int]metaCom.BatchVersion{
+			// progress of purged batches is dropped.
+			0: {Version: 86400},
+		}, nil).Once()
+		metaStore.On("GetArchiveBatchIDs", "trips", 0).Return([]int{1}, nil)
+		metaStore.On("UpdateRollupProgress", "trips_hourly", 0, mock.Anything).Return(nil)
+
+		jobDetail := &RollupJobDetail{}
+		reporter := func(key string, mutator RollupJobDetailMutator) {
+			mutator(jobDetail)
+		}
+		Ω(memStore.MaintainRollup("trips_hourly", 0, reporter)).Should(BeNil())
+		metaStore.AssertCalled(utils.TestingT, "UpdateRollupProgress", "trips_hourly", 0,
+			map[int]metaCom.BatchVersion{1: {Version: 86400 * 2}})
+		Ω(jobDetail.Total).Should(Equal(1))
+		Ω(jobDetail.LastBatchID).Should(Equal(1))
+
+		expected := aggregateDirectly(rows)
+		Ω(expected).Should(HaveLen(3))
+		Ω(jobDetail.NumRecords).Should(Equal(3))
+		for key, values := range expected {
+			Ω(readRollup(key)).Should(Equal(values), key)
+		}
+
+		// the batch is aggregated again after late arriving rows are backfilled into it.
+		rows = append(rows, []string{"86440", "2", "6", "1"}, []string{"90500", "3", "2", "2"})
+		setArchiveBatch(rows, 1)
+		Ω(baseShard.hasBatchesToRollup(map[int]metaCom.BatchVersion{1: {Version: 86400 * 2}})).Should(BeTrue())
+		metaStore.On("GetRollupProgress", "trips_hourly", 0).Return(map[int]metaCom.BatchVersion{
+			1: {Version: 86400 * 2},
+		}, nil).Once()
+		Ω(memStore.MaintainRollup("trips_hourly", 0, reporter)).Should(BeNil())
+		metaStore.AssertCalled(utils.TestingT, "UpdateRollupProgress", "trips_hourly", 0,
+			map[int]metaCom.BatchVersion{1: {Version: 86400 * 2, SeqNum: 1}})
+		Ω(baseShard.hasBatchesToRollup(map[int]metaCom.BatchVersion{1: {Version: 86400 * 2, SeqNum: 1}})).Should(BeFalse())
+
+		expected = aggregateDirectly(rows)
+		Ω(expected).Should(HaveLen(4))
+		for key, values := range expected {
+			Ω(readRollup(key)).Should(Equal(values), key)
+		}
+
+		// batches already aggregated are skipped.
+		metaStore.On("GetRollupProgress", "trips_hourly", 0).Return(map[int]metaCom.BatchVersion{
+			1: {Version: 86400 * 2, SeqNum: 1},
+		}, nil).Once()
+		Ω(memStore.MaintainRollup("trips_hourly", 0, reporter)).Should(BeNil())
+		Ω(jobDetail.Total).Should(Equal(0))
+	})
+
+	ginkgo.It("aggregates null values", func() {
+		setArchiveBatch(baseRows[1:2], 0)
+		r, err := newRollup(rollupShard.Schema, baseShard.Schema)
+		Ω(err).Should(BeNil())
+		upsertBatch, err := r.aggregate(baseShard.ArchiveStore.CurrentVersion.Batches[1])
+		Ω(err).Should(BeNil())
+		rows, err := upsertBatch.ReadData(0, upsertBatch.NumRows)
+		Ω(err).Should(BeNil())
+		Ω(rows).Should(Equal([][]interface{}{{uint32(86400), uint16(1), uint32(1), float32(1.5), nil, nil}}))
+
+		setArchiveBatch(nil, 0)
+		upsertBatch, err = r.aggregate(baseShard.ArchiveStore.CurrentVersion.Batches[1])
+		Ω(err).Should(BeNil())
+		Ω(upsertBatch).Should(BeNil())
+	})
+
+	ginkgo.It("rejects rollups not matching base table", func() {
+		table := rollupShard.Schema.Schema
+		table.Columns = append([]metaCom.Column(nil), table.Columns...)
+		table.Columns[1].Type = metaCom.Uint32
+		_, err := newRollup(NewTableSchema(&table), baseShard.Schema)
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("Dimension city_id is Uint32 in rollup table but Uint16 in base table"))
+
+		table.Columns[1].Type = metaCom.Uint16
+		table.Columns[4].Type = metaCom.Int32
+		_, err = newRollup(NewTableSchema(&table), baseShard.Schema)
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("Measure total_distance: sum of distance should be stored in Int64 column"))
+	})
+})
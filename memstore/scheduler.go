@@ -51,6 +51,8 @@ type Scheduler interface {
 	NewArchivingJob(tableName string, shardID int, cutoff uint32) Job
 	NewSnapshotJob(tableName string, shardID int) Job
 	NewPurgeJob(tableName string, shardID int, batchIDStart int, batchIDEnd int) Job
+	NewDerivedColumnJob(tableName string, shardID int) Job
+	NewRollupJob(tableName string, shardID int) Job
 	utils.RWLocker
 }
 
@@ -67,6 +69,8 @@ func newScheduler(m *memStoreImpl) *schedulerImpl {
 	s.jobManagers[common.BackfillJobType] = newBackfillJobManager(s)
 	s.jobManagers[common.SnapshotJobType] = newSnapshotJobManager(s)
 	s.jobManagers[common.PurgeJobType] = newPurgeJobManager(s)
+	s.jobManagers[common.DerivedColumnJobType] = newDerivedColumnJobManager(s)
+	s.jobManagers[common.RollupJobType] = newRollupJobManager(s)
 	return s
 }
 
@@ -117,6 +121,8 @@ func (scheduler *schedulerImpl) DeleteTable(table string, isFactTable bool) {
 		scheduler.jobManagers[common.ArchivingJobType].deleteTable(table)
 		scheduler.jobManagers[common.BackfillJobType].deleteTable(table)
 		scheduler.jobManagers[common.PurgeJobType].deleteTable(table)
+		scheduler.jobManagers[common.DerivedColumnJobType].deleteTable(table)
+		scheduler.jobManagers[common.RollupJobType].deleteTable(table)
 		return
 	}
 	scheduler.jobManagers[common.SnapshotJobType].deleteTable(table)
@@ -165,6 +171,26 @@ func (scheduler *schedulerImpl) NewPurgeJob(tableName string, shardID, batchIDSt
 	}
 }
 
+// NewDerivedColumnJob returns a new DerivedColumnJob.
+func (scheduler *schedulerImpl) NewDerivedColumnJob(tableName string, shardID int) Job {
+	return &DerivedColumnJob{
+		tableName: tableName,
+		shardID:   shardID,
+		memStore:  scheduler.memStore,
+		reporter:  scheduler.jobManagers[common.DerivedColumnJobType].(*derivedColumnJobManager).reportDerivedColumnJobDetail,
+	}
+}
+
+// NewRollupJob returns a new RollupJob.
+func (scheduler *schedulerImpl) NewRollupJob(tableName string, shardID int) Job {
+	return &RollupJob{
+		tableName: tableName,
+		shardID:   shardID,
+		memStore:  scheduler.memStore,
+		reporter:  scheduler.jobManagers[common.RollupJobType].(*rollupJobManager).reportRollupJobDetail,
+	}
+}
+
 // Start starts the scheduler. It creates a new time.Timer every time to wait
 // at least schedulerInterval time instead of running at every tick so that we
 // will skip the tick if a single round takes more than one minute. This prevents
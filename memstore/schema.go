@@ -43,6 +43,11 @@ type TableSchema struct {
 	PrimaryKeyColumnTypes []memCom.DataType `json:"primaryKeyColumnTypes"`
 	// Default values of each column. Mutable. Nil means default value is not set.
 	DefaultValues []*memCom.DataValue `json:"-"`
+	// Predicates of rows deleted from the table. Rows matching any of them
+	// are excluded from query results. Mutable.
+	DeletePredicates []metaCom.DeletePredicate `json:"deletePredicates,omitempty"`
+	// Derived columns computed on ingestion. Mutable.
+	derivedColumns []*derivedColumn
 }
 
 // EnumDict contains mapping from and to enum strings to numbers.
@@ -82,6 +87,7 @@ func NewTableSchema(table *metaCom.Table) *TableSchema {
 		}
 		tableSchema.PrimaryKeyBytes += dataBits / 8
 	}
+	tableSchema.setDerivedColumns()
 	return tableSchema
 }
 
@@ -98,11 +104,15 @@ func (t *TableSchema) MarshalJSON() ([]byte, error) {
 // should acquire lock before calling.
 func (t *TableSchema) SetTable(table *metaCom.Table) {
 	t.Schema = *table
+	for name, id := range t.ColumnIDs {
+		// drop names of deleted and renamed columns.
+		if id >= len(table.Columns) || table.Columns[id].Name != name || table.Columns[id].Deleted {
+			delete(t.ColumnIDs, name)
+		}
+	}
 	for id, column := range table.Columns {
 		if !column.Deleted {
 			t.ColumnIDs[column.Name] = id
-		} else {
-			delete(t.ColumnIDs, column.Name)
 		}
 
 		if id >= len(t.ValueTypeByColumn) {
@@ -113,6 +123,17 @@ func (t *TableSchema) SetTable(table *metaCom.Table) {
 			t.DefaultValues = append(t.DefaultValues, nil)
 		}
 	}
+	t.setDerivedColumns()
+}
+
+// setDerivedColumns creates the derived columns from the schema.
+func (t *TableSchema) setDerivedColumns() {
+	t.derivedColumns = nil
+	for id, column := range t.Schema.Columns {
+		if column.DerivedExpr != "" && !column.Deleted {
+			t.derivedColumns = append(t.derivedColumns, newDerivedColumn(t, id))
+		}
+	}
 }
 
 // SetDefaultValue parses the default value string if present and sets to TableSchema.
@@ -233,6 +254,16 @@ func (t *TableSchema) GetColumnDeletions() []bool {
 	return deletedByColumn
 }
 
+// GetConflictResolutionColumn returns the ID of the column resolving upserts to the same primary key,
+// or -1 if the last arrived record wins. Callers need to hold a read lock.
+func (t *TableSchema) GetConflictResolutionColumn() int {
+	name := t.Schema.Config.ConflictResolutionColumn
+	if columnID, ok := t.ColumnIDs[name]; ok && name != "" {
+		return columnID
+	}
+	return -1
+}
+
 // GetArchivingSortColumns makes a copy of the Schema.ArchivingSortColumns so
 // callers don't have to hold a read lock to access it.
 func (t *TableSchema) GetArchivingSortColumns() []int {
@@ -274,7 +305,7 @@ func (m *memStoreImpl) FetchSchema() error {
 	m.RLock()
 	for _, tableSchema := range m.TableSchemas {
 		for columnName, enumCases := range tableSchema.EnumDicts {
-			err := m.watchEnumCases(tableSchema.Schema.Name, columnName, len(enumCases.ReverseDict))
+			err := m.watchEnumCases(tableSchema.Schema.Name, columnName, tableSchema.ColumnIDs[columnName], len(enumCases.ReverseDict))
 			if err != nil {
 				return err
 			}
@@ -308,6 +339,14 @@ func (m *memStoreImpl) fetchTable(tableName string) error {
 			}
 			tableSchema.SetDefaultValue(columnID)
 		}
+		deletePredicates, err := m.metaStore.GetDeletePredicates(tableName)
+		if err != nil {
+			if err != metastore.ErrTableDoesNotExist {
+				return utils.StackError(err, "Failed to fetch delete predicates for table: %s", tableName)
+			}
+		} else {
+			tableSchema.DeletePredicates = deletePredicates
+		}
 		m.Lock()
 		m.TableSchemas[tableName] = tableSchema
 		m.Unlock()
@@ -316,14 +355,14 @@ func (m *memStoreImpl) fetchTable(tableName string) error {
 }
 
 // watch enumCases will setup watch channels for each enum column.
-func (m *memStoreImpl) watchEnumCases(tableName, columnName string, startCase int) error {
+func (m *memStoreImpl) watchEnumCases(tableName, columnName string, columnID, startCase int) error {
 	enumDictChangeEvents, done, err := m.metaStore.WatchEnumDictEvents(tableName, columnName, startCase)
 	if err != nil {
 		if err != metastore.ErrTableDoesNotExist && err != metastore.ErrColumnDoesNotExist {
 			return utils.StackError(err, "Failed to watch enum case events")
 		}
 	} else {
-		go m.handleEnumDictChange(tableName, columnName, enumDictChangeEvents, done)
+		go m.handleEnumDictChange(tableName, columnID, enumDictChangeEvents, done)
 	}
 	return nil
 }
@@ -337,26 +376,52 @@ func (m *memStoreImpl) handleTableListChange(tableListChangeEvents <-chan []stri
 	close(done)
 }
 
+// applyTableList drops all tables missing in the new table list.
 func (m *memStoreImpl) applyTableList(newTableList []string) {
+	type droppedTable struct {
+		name        string
+		isFactTable bool
+		shards      map[int]*TableShard
+	}
+	var droppedTables []droppedTable
 	m.Lock()
 	for tableName, tableSchema := range m.TableSchemas {
 		if utils.IndexOfStr(newTableList, tableName) < 0 {
 			// detach shards and schema from map
 			// to prevent new usage
-			tableShards := m.TableShards[tableName]
+			droppedTables = append(droppedTables, droppedTable{
+				name:        tableName,
+				isFactTable: tableSchema.Schema.IsFactTable,
+				shards:      m.TableShards[tableName],
+			})
 			delete(m.TableSchemas, tableName)
 			delete(m.TableShards, tableName)
-			// only one table deletion at a time
-			m.Unlock()
-			for shardID, shard := range tableShards {
-				shard.Destruct()
-				m.diskStore.DeleteTableShard(tableName, shardID)
-			}
-			m.scheduler.DeleteTable(tableName, tableSchema.Schema.IsFactTable)
-			return
 		}
 	}
 	m.Unlock()
+
+	// shards are purged outside of the memstore lock as it waits for their users.
+	for _, table := range droppedTables {
+		m.dropTable(table.name, table.isFactTable, table.shards)
+	}
+}
+
+// dropTable purges the memory and disk storage of the shards of a table already detached from
+// memstore. Shards are destructed only after in-flight queries and jobs release them.
+func (m *memStoreImpl) dropTable(tableName string, isFactTable bool, tableShards map[int]*TableShard) {
+	for shardID, shard := range tableShards {
+		shard.Destruct()
+		if err := m.diskStore.DeleteTableShard(tableName, shardID); err != nil {
+			utils.GetLogger().With(
+				"error", err.Error(),
+				"table", tableName,
+				"shard", shardID).
+				Error("Failed to delete table shard from disk")
+		}
+		utils.DeleteTableShardReporter(tableName, shardID)
+	}
+	m.scheduler.DeleteTable(tableName, isFactTable)
+	utils.GetLogger().With("table", tableName).Info("Table dropped")
 }
 
 // handleTableSchemaChange handles table schema change event from metaStore including new table schema.
@@ -370,12 +435,13 @@ func (m *memStoreImpl) handleTableSchemaChange(tableSchemaChangeEvents <-chan *m
 
 func (m *memStoreImpl) applyTableSchema(newTable *metaCom.Table) {
 	tableName := newTable.Name
-	newEnumColumns := []string{}
+	newEnumColumns := []int{}
 	// default start watching from first enumCase
 	startEnumID := 0
 	defer func() {
-		for _, column := range newEnumColumns {
-			err := m.watchEnumCases(tableName, column, startEnumID)
+		for _, columnID := range newEnumColumns {
+			column := newTable.Columns[columnID].Name
+			err := m.watchEnumCases(tableName, column, columnID, startEnumID)
 			if err != nil {
 				utils.GetLogger().With(
 					"error", err.Error(),
@@ -401,7 +467,7 @@ func (m *memStoreImpl) applyTableSchema(newTable *metaCom.Table) {
 						startEnumID = 1
 					}
 					tableSchema.createEnumDict(column.Name, enumCases)
-					newEnumColumns = append(newEnumColumns, column.Name)
+					newEnumColumns = append(newEnumColumns, columnID)
 				}
 			}
 			tableSchema.SetDefaultValue(columnID)
@@ -426,7 +492,18 @@ func (m *memStoreImpl) applyTableSchema(newTable *metaCom.Table) {
 				columnsToDelete = append(columnsToDelete, columnID)
 			}
 		} else {
+			if columnID < len(oldColumns) && oldColumns[columnID].Name != column.Name {
+				tableSchema.DeletePredicates, _ = metastore.RenameDeletePredicateColumn(
+					tableSchema.DeletePredicates, oldColumns[columnID].Name, column.Name)
+			}
 			if column.IsEnumColumn() {
+				// renamed columns keep their enum dict.
+				if columnID < len(oldColumns) && oldColumns[columnID].Name != column.Name {
+					if enumDict, renamed := tableSchema.EnumDicts[oldColumns[columnID].Name]; renamed {
+						delete(tableSchema.EnumDicts, oldColumns[columnID].Name)
+						tableSchema.EnumDicts[column.Name] = enumDict
+					}
+				}
 				_, exist := tableSchema.EnumDicts[column.Name]
 				if !exist {
 					var enumCases []string
@@ -436,7 +513,7 @@ func (m *memStoreImpl) applyTableSchema(newTable *metaCom.Table) {
 						startEnumID = 1
 					}
 					tableSchema.createEnumDict(column.Name, enumCases)
-					newEnumColumns = append(newEnumColumns, column.Name)
+					newEnumColumns = append(newEnumColumns, columnID)
 				}
 			}
 			var oldPreloadingDays int
@@ -452,6 +529,12 @@ func (m *memStoreImpl) applyTableSchema(newTable *metaCom.Table) {
 	}
 	tableSchema.Unlock()
 
+	m.RLock()
+	for _, shard := range m.TableShards[tableName] {
+		shard.LiveStore.RedoLogManager.SetRetentionInterval(int64(newTable.Config.RedoLogRetentionInterval))
+	}
+	m.RUnlock()
+
 	for _, columnID := range columnsToDelete {
 		var shards []*TableShard
 		m.RLock()
@@ -470,14 +553,15 @@ func (m *memStoreImpl) applyTableSchema(newTable *metaCom.Table) {
 }
 
 // handleEnumDictChange handles enum dict change event from metaStore for specific table and column.
-func (m *memStoreImpl) handleEnumDictChange(tableName, columnName string, enumDictChangeEvents <-chan string, done chan<- struct{}) {
+// The column is identified by id so enum cases keep being applied after the column is renamed.
+func (m *memStoreImpl) handleEnumDictChange(tableName string, columnID int, enumDictChangeEvents <-chan string, done chan<- struct{}) {
 	for newEnumCase := range enumDictChangeEvents {
-		m.applyEnumCase(tableName, columnName, newEnumCase)
+		m.applyEnumCase(tableName, columnID, newEnumCase)
 	}
 	close(done)
 }
 
-func (m *memStoreImpl) applyEnumCase(tableName, columnName string, newEnumCase string) {
+func (m *memStoreImpl) applyEnumCase(tableName string, columnID int, newEnumCase string) {
 	m.RLock()
 	tableSchema, tableExist := m.TableSchemas[tableName]
 	if !tableExist {
@@ -487,6 +571,11 @@ func (m *memStoreImpl) applyEnumCase(tableName, columnName string, newEnumCase s
 
 	tableSchema.Lock()
 	m.RUnlock()
+	if columnID >= len(tableSchema.Schema.Columns) || tableSchema.Schema.Columns[columnID].Deleted {
+		tableSchema.Unlock()
+		return
+	}
+	columnName := tableSchema.Schema.Columns[columnID].Name
 	enumDict, columnExist := tableSchema.EnumDicts[columnName]
 	if !columnExist {
 		tableSchema.Unlock()
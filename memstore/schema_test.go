@@ -16,13 +16,20 @@ package memstore
 
 import (
 	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	aresCommon "github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/diskstore"
 	memCom "github.com/uber/aresdb/memstore/common"
 	"github.com/uber/aresdb/metastore"
 	metaCom "github.com/uber/aresdb/metastore/common"
 	"github.com/uber/aresdb/metastore/mocks"
+	"github.com/uber/aresdb/utils"
 )
 
 var _ = ginkgo.Describe("memStoreImpl schema", func() {
@@ -159,7 +166,7 @@ var _ = ginkgo.Describe("memStoreImpl schema", func() {
 		testMemstore.TableSchemas[testTable.Name] = tableSchema
 
 		testTableShard := NewTableShard(tableSchema, mockMetastore, mockDiskstore,
-			NewHostMemoryManager(&testMemstore, 1<<32), 0)
+			NewHostMemoryManager(&testMemstore, 1<<32), 0, Options{})
 
 		testMemstore.TableShards[testTable.Name] = map[int]*TableShard{
 			0: testTableShard,
@@ -255,6 +262,7 @@ var _ = ginkgo.Describe("memStoreImpl schema", func() {
 		mockMetastore.On("GetTable", testTable.Name).Return(&testTable, nil).Once()
 		mockMetastore.On("GetEnumDict", testTable.Name, testColumn2.Name).Return(testColumn2EnumCases, nil).Once()
 		mockMetastore.On("GetEnumDict", testTable.Name, testColumn3.Name).Return(testColumn3EnumCases, nil).Once()
+		mockMetastore.On("GetDeletePredicates", testTable.Name).Return([]metaCom.DeletePredicate{{Filter: "col1 = true", Cutoff: 100}}, nil).Once()
 
 		mockMetastore.On("WatchTableListEvents").Return(recvTableListEvents, sendDoneChannel, nil).Once()
 		mockMetastore.On("WatchTableSchemaEvents").Return(recvTableSchemaEvents, sendDoneChannel, nil).Once()
@@ -269,6 +277,7 @@ var _ = ginkgo.Describe("memStoreImpl schema", func() {
 			testColumn2.Name: 1,
 			testColumn3.Name: 2,
 		}))
+		Ω(memstore.TableSchemas[testTable.Name].DeletePredicates).Should(Equal([]metaCom.DeletePredicate{{Filter: "col1 = true", Cutoff: 100}}))
 		Ω(memstore.TableSchemas[testTable.Name].ValueTypeByColumn).Should(Equal([]memCom.DataType{memCom.Bool, memCom.SmallEnum, memCom.BigEnum}))
 		Ω(memstore.TableSchemas[testTable.Name].EnumDicts).Should(Equal(
 			map[string]EnumDict{
@@ -337,11 +346,21 @@ var _ = ginkgo.Describe("memStoreImpl schema", func() {
 		err = memstore.FetchSchema()
 		Ω(err).ShouldNot(BeNil())
 
+		// failed with GetDeletePredicates error
+		mockMetastore.On("ListTables").Return([]string{"testTable"}, nil).Once()
+		mockMetastore.On("GetTable", testTable.Name).Return(&testTable, nil).Once()
+		mockMetastore.On("GetEnumDict", testTable.Name, testColumn2.Name).Return(testColumn2EnumCases, nil).Once()
+		mockMetastore.On("GetEnumDict", testTable.Name, testColumn3.Name).Return(testColumn3EnumCases, nil).Once()
+		mockMetastore.On("GetDeletePredicates", testTable.Name).Return(nil, errors.New("Failure GetDeletePredicates")).Once()
+		err = memstore.FetchSchema()
+		Ω(err).ShouldNot(BeNil())
+
 		// failed with column does not exist error
 		mockMetastore.On("ListTables").Return([]string{"testTable"}, nil).Once()
 		mockMetastore.On("GetTable", testTable.Name).Return(&testTable, nil).Once()
 		mockMetastore.On("GetEnumDict", testTable.Name, testColumn2.Name).Return(nil, metastore.ErrColumnDoesNotExist).Once()
 		mockMetastore.On("GetEnumDict", testTable.Name, testColumn3.Name).Return(testColumn3EnumCases, nil).Once()
+		mockMetastore.On("GetDeletePredicates", testTable.Name).Return([]metaCom.DeletePredicate{}, nil).Once()
 		doneChan = make(chan struct{})
 		mockMetastore.On("WatchTableListEvents").Return(recvTableListEvents, doneChan, nil).Once()
 		doneChan = make(chan struct{})
@@ -356,6 +375,42 @@ var _ = ginkgo.Describe("memStoreImpl schema", func() {
 		close(enumCol3ChangeEvents)
 	})
 
+	ginkgo.It("DeleteRows should work", func() {
+		testMemstore := getTestMemstore()
+		utils.SetCurrentTime(time.Unix(100, 0))
+		defer utils.ResetClockImplementation()
+
+		_, err := testMemstore.DeleteRows(testTable.Name, "col1 = true")
+		Ω(err).Should(Equal(ErrDeleteDimensionTableRows))
+
+		schema := testMemstore.TableSchemas[testTable.Name]
+		schema.Schema.IsFactTable = true
+		deleted := metaCom.DeletePredicate{ID: 1, Filter: "col1 = true", Cutoff: 100}
+		mockMetastore.On("AddDeletePredicate", testTable.Name, "col1 = true", uint32(100)).Return(deleted, nil).Once()
+		predicate, err := testMemstore.DeleteRows(testTable.Name, "col1 = true")
+		Ω(err).Should(BeNil())
+		Ω(predicate).Should(Equal(deleted))
+		Ω(schema.DeletePredicates).Should(Equal([]metaCom.DeletePredicate{deleted}))
+
+		mockMetastore.On("AddDeletePredicate", testTable.Name, "col1 = false", uint32(100)).
+			Return(metaCom.DeletePredicate{}, errors.New("Failure AddDeletePredicate")).Once()
+		_, err = testMemstore.DeleteRows(testTable.Name, "col1 = false")
+		Ω(err).ShouldNot(BeNil())
+		Ω(schema.DeletePredicates).Should(Equal([]metaCom.DeletePredicate{deleted}))
+
+		mockMetastore.On("RemoveDeletePredicate", testTable.Name, 2).Return(metastore.ErrDeletePredicateDoesNotExist).Once()
+		Ω(testMemstore.RemoveDeletePredicate(testTable.Name, 2)).Should(Equal(metastore.ErrDeletePredicateDoesNotExist))
+		Ω(schema.DeletePredicates).Should(HaveLen(1))
+		mockMetastore.On("RemoveDeletePredicate", testTable.Name, 1).Return(nil).Once()
+		Ω(testMemstore.RemoveDeletePredicate(testTable.Name, 1)).Should(BeNil())
+		Ω(schema.DeletePredicates).Should(BeEmpty())
+
+		_, err = testMemstore.DeleteRows("unknown", "col1 = true")
+		Ω(err).ShouldNot(BeNil())
+		Ω(testMemstore.RemoveDeletePredicate("unknown", 1)).ShouldNot(BeNil())
+		destroyTestMemstore(testMemstore)
+	})
+
 	ginkgo.It("applyTableList should work", func() {
 		testMemstore := getTestMemstore()
 		mockDiskstore.On("DeleteTableShard", testTable.Name, 0).Return(nil)
@@ -365,6 +420,58 @@ var _ = ginkgo.Describe("memStoreImpl schema", func() {
 		destroyTestMemstore(testMemstore)
 	})
 
+	ginkgo.It("applyTableList should drop all missing tables", func() {
+		testMemstore := getTestMemstore()
+		for _, tableName := range []string{"t1", "t2", "t3"} {
+			table := testTable
+			table.Name = tableName
+			tableSchema := NewTableSchema(&table)
+			testMemstore.TableSchemas[tableName] = tableSchema
+			testMemstore.TableShards[tableName] = map[int]*TableShard{
+				0: NewTableShard(tableSchema, mockMetastore, mockDiskstore, NewHostMemoryManager(testMemstore, 1<<32), 0, Options{}),
+			}
+			mockDiskstore.On("DeleteTableShard", tableName, 0).Return(nil)
+		}
+		mockDiskstore.On("DeleteTableShard", testTable.Name, 0).Return(nil)
+		testMemstore.applyTableList([]string{"t2"})
+		Ω(testMemstore.TableSchemas).Should(HaveLen(1))
+		Ω(testMemstore.TableSchemas).Should(HaveKey("t2"))
+		Ω(testMemstore.TableShards).Should(HaveLen(1))
+		Ω(testMemstore.TableShards).Should(HaveKey("t2"))
+		destroyTestMemstore(testMemstore)
+	})
+
+	ginkgo.It("applyTableList should purge the storage of dropped tables once queries complete", func() {
+		rootPath, err := ioutil.TempDir("", "drop_table")
+		Ω(err).Should(BeNil())
+		defer os.RemoveAll(rootPath)
+		shardPath := filepath.Join(rootPath, "data", testTable.Name+"_0")
+		Ω(os.MkdirAll(filepath.Join(shardPath, "redologs"), 0755)).Should(BeNil())
+		Ω(ioutil.WriteFile(filepath.Join(shardPath, "redologs", "1"), []byte{1}, 0644)).Should(BeNil())
+
+		testMemstore := getTestMemstore()
+		testMemstore.diskStore = diskstore.NewLocalDiskStore(rootPath, aresCommon.RedoLogSyncConfig{})
+		// an in-flight query holding the shard.
+		shard, err := testMemstore.GetTableShard(testTable.Name, 0)
+		Ω(err).Should(BeNil())
+
+		dropped := make(chan struct{})
+		go func() {
+			testMemstore.applyTableList([]string{})
+			close(dropped)
+		}()
+		Eventually(func() bool {
+			_, err := testMemstore.GetSchema(testTable.Name)
+			return err != nil
+		}).Should(BeTrue())
+		Consistently(dropped).ShouldNot(BeClosed())
+		Ω(shardPath).Should(BeADirectory())
+
+		shard.Users.Done()
+		Eventually(dropped).Should(BeClosed())
+		Ω(shardPath).ShouldNot(BeAnExistingFile())
+	})
+
 	ginkgo.It("handleTableListChange should work", func() {
 		testMemstore := getTestMemstore()
 
@@ -416,7 +523,12 @@ var _ = ginkgo.Describe("memStoreImpl schema", func() {
 
 		// test modifying a table
 		mockMetastore.On("WatchEnumDictEvents", testModifiedTable.Name, testColumn4.Name, 0).Return(recvEnumCol4ChangeEvents, sendDoneChannel, nil).Once()
+		modifiedTable := testModifiedTable
+		modifiedTable.Config.RedoLogRetentionInterval = 600
+		testMemstore.applyTableSchema(&modifiedTable)
+		Ω(testMemstore.TableShards[testTable.Name][0].LiveStore.RedoLogManager.RetentionInterval).Should(BeEquivalentTo(600))
 		testMemstore.applyTableSchema(&testModifiedTable)
+		Ω(testMemstore.TableShards[testTable.Name][0].LiveStore.RedoLogManager.RetentionInterval).Should(BeEquivalentTo(0))
 
 		Ω(testMemstore.TableSchemas[testTable.Name].Schema).Should(Equal(testModifiedTable))
 		Ω(testMemstore.TableSchemas[testTable.Name].ColumnIDs).Should(Equal(expectedColumnIDs))
@@ -501,6 +613,39 @@ var _ = ginkgo.Describe("memStoreImpl schema", func() {
 		destroyTestMemstore(testMemstore)
 	})
 
+	ginkgo.It("applyTableSchema should keep column ids and enum dicts of renamed columns", func() {
+		testMemstore := getTestMemstore()
+
+		renamedTable := testTable
+		renamedTable.Columns = []metaCom.Column{testColumn1, testColumn2, testColumn3}
+		renamedTable.Columns[2].Name = "city"
+		tableSchema := testMemstore.TableSchemas[testTable.Name]
+		tableSchema.DeletePredicates = []metaCom.DeletePredicate{
+			{ID: 1, Filter: "col3 = 'd'", Cutoff: 86400},
+			{ID: 2, Filter: "col2 IS NULL", Cutoff: 86400},
+		}
+		// no new enum dict is watched for the renamed column.
+		testMemstore.applyTableSchema(&renamedTable)
+
+		Ω(tableSchema.DeletePredicates).Should(Equal([]metaCom.DeletePredicate{
+			{ID: 1, Filter: "city = 'd'", Cutoff: 86400},
+			{ID: 2, Filter: "col2 IS NULL", Cutoff: 86400},
+		}))
+		Ω(tableSchema.ColumnIDs).Should(Equal(map[string]int{
+			testColumn1.Name: 0,
+			testColumn2.Name: 1,
+			"city":           2,
+		}))
+		Ω(tableSchema.EnumDicts).ShouldNot(HaveKey(testColumn3.Name))
+		Ω(tableSchema.EnumDicts["city"].ReverseDict).Should(Equal([]string{"d", "e"}))
+
+		// enum cases watched before the rename are applied to the renamed column.
+		testMemstore.applyEnumCase(testTable.Name, 2, "f")
+		Ω(tableSchema.EnumDicts["city"].ReverseDict).Should(Equal([]string{"d", "e", "f"}))
+		Ω(tableSchema.EnumDicts["city"].Dict["f"]).Should(Equal(2))
+		destroyTestMemstore(testMemstore)
+	})
+
 	ginkgo.It("applyEnumCase should work", func() {
 		testMemstore := getTestMemstore()
 
@@ -525,11 +670,11 @@ var _ = ginkgo.Describe("memStoreImpl schema", func() {
 			ReverseDict: []string{"a", "b", "c", "d"},
 		}
 
-		testMemstore.applyEnumCase("unknown", testColumn2.Name, "d")
+		testMemstore.applyEnumCase("unknown", 1, "d")
 		Ω(testMemstore.TableSchemas[testTable.Name].EnumDicts[testColumn2.Name]).Should(Equal(oldEnumDict))
-		testMemstore.applyEnumCase(testTable.Name, "unknown", "d")
+		testMemstore.applyEnumCase(testTable.Name, len(testTable.Columns), "d")
 		Ω(testMemstore.TableSchemas[testTable.Name].EnumDicts[testColumn2.Name]).Should(Equal(oldEnumDict))
-		testMemstore.applyEnumCase(testTable.Name, testColumn2.Name, "d")
+		testMemstore.applyEnumCase(testTable.Name, 1, "d")
 		Ω(testMemstore.TableSchemas[testTable.Name].EnumDicts[testColumn2.Name]).Should(Equal(newEnumDict))
 		destroyTestMemstore(testMemstore)
 	})
@@ -542,7 +687,7 @@ var _ = ginkgo.Describe("memStoreImpl schema", func() {
 		var recvEnumDictChanges <-chan string = enumDictChanges
 		var sendDoneChannel chan<- struct{} = doneChannel
 
-		go testMemstore.handleEnumDictChange(testTable.Name, testColumn2.Name, recvEnumDictChanges, sendDoneChannel)
+		go testMemstore.handleEnumDictChange(testTable.Name, 1, recvEnumDictChanges, sendDoneChannel)
 		enumDictChanges <- "d"
 		// block until processing done
 		close(enumDictChanges)
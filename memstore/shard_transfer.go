@@ -0,0 +1,293 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+
+	"github.com/uber/aresdb/diskstore"
+	"github.com/uber/aresdb/utils"
+)
+
+// ErrArchivedShardExists is returned by LoadArchivedShard if the table shard already has archive
+// batches, which would be overwritten.
+var ErrArchivedShardExists = errors.New("table shard already has archived data")
+
+const (
+	// archivedShardManifestName is the name of the first file of an archived shard stream.
+	archivedShardManifestName = "manifest.json"
+	// archivedShardEndName is the name of the empty last file of an archived shard stream, so that
+	// streams cut between two files are detected.
+	archivedShardEndName = "end"
+)
+
+// archivedShardManifest describes the archive batches of an archived shard stream.
+type archivedShardManifest struct {
+	ArchivingCutoff uint32              `json:"archivingCutoff"`
+	Batches         []archivedBatchInfo `json:"batches"`
+}
+
+// archivedBatchInfo is the metadata of an archive batch in an archived shard stream.
+type archivedBatchInfo struct {
+	BatchID int32  `json:"batchID"`
+	Version uint32 `json:"version"`
+	SeqNum  uint32 `json:"seqNum"`
+	Size    int    `json:"size"`
+}
+
+// archivedColumnFileName returns the name of the vector party file of the column of the batch in
+// an archived shard stream.
+func archivedColumnFileName(batchID int32, columnID int) string {
+	return fmt.Sprintf("%d/%d", batchID, columnID)
+}
+
+// WriteArchivedShard writes the vector party files of the archive batches of the fact table shard
+// to w as a tar stream, preceded by a manifest of the batches. The current archive store version is
+// pinned while writing so that its files are not deleted by archiving or backfill meanwhile. Rows
+// not archived yet are not written.
+func (m *memStoreImpl) WriteArchivedShard(table string, shardID int, w io.Writer) error {
+	shard, err := m.GetTableShard(table, shardID)
+	if err != nil {
+		return err
+	}
+	defer shard.Users.Done()
+
+	shard.Schema.RLock()
+	isFactTable := shard.Schema.Schema.IsFactTable
+	numColumns := len(shard.Schema.Schema.Columns)
+	shard.Schema.RUnlock()
+	if !isFactTable {
+		return utils.StackError(nil, "Table %s is not a fact table", table)
+	}
+
+	archiveStore := shard.ArchiveStore.GetCurrentVersion()
+	defer archiveStore.Users.Done()
+
+	batchIDs, err := shard.metaStore.GetArchiveBatchIDs(table, shardID)
+	if err != nil {
+		return err
+	}
+	manifest := archivedShardManifest{ArchivingCutoff: archiveStore.ArchivingCutoff}
+	for _, batchID := range batchIDs {
+		batch := archiveStore.RequestBatch(int32(batchID))
+		if batch.Size == 0 {
+			continue
+		}
+		manifest.Batches = append(manifest.Batches, archivedBatchInfo{
+			BatchID: batch.BatchID,
+			Version: batch.Version,
+			SeqNum:  batch.SeqNum,
+			Size:    batch.Size,
+		})
+	}
+
+	tarWriter := tar.NewWriter(w)
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		return utils.StackError(err, "Failed to marshal manifest of table %s shard %d", table, shardID)
+	}
+	if err = writeTarFile(tarWriter, archivedShardManifestName, manifestBytes); err != nil {
+		return err
+	}
+	for _, batch := range manifest.Batches {
+		for columnID := 0; columnID < numColumns; columnID++ {
+			reader, err := m.diskStore.OpenVectorPartyFileForRead(table, columnID, shardID, int(batch.BatchID),
+				batch.Version, batch.SeqNum)
+			if err != nil {
+				return err
+			}
+			// columns without data on disk are all nulls.
+			if reader == nil {
+				continue
+			}
+			data, err := ioutil.ReadAll(reader)
+			reader.Close()
+			if err == nil {
+				// the checksum is appended again when written by the target.
+				data, err = diskstore.TrimChecksumFooter(data)
+			}
+			if err != nil {
+				return utils.StackError(err, "Failed to read column %d of batch %d of table %s shard %d",
+					columnID, batch.BatchID, table, shardID)
+			}
+			if err = writeTarFile(tarWriter, archivedColumnFileName(batch.BatchID, columnID), data); err != nil {
+				return err
+			}
+		}
+	}
+	if err = writeTarFile(tarWriter, archivedShardEndName, nil); err != nil {
+		return err
+	}
+	if err = tarWriter.Close(); err != nil {
+		return utils.StackError(err, "Failed to write archived data of table %s shard %d", table, shardID)
+	}
+	return nil
+}
+
+// writeTarFile writes a file of data to the tar stream.
+func writeTarFile(tarWriter *tar.Writer, name string, data []byte) error {
+	header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data))}
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return utils.StackError(err, "Failed to write header of %s", name)
+	}
+	if _, err := tarWriter.Write(data); err != nil {
+		return utils.StackError(err, "Failed to write %s", name)
+	}
+	return nil
+}
+
+// LoadArchivedShard writes the archive batches of the tar stream written by WriteArchivedShard
+// into the fact table shard, which must not have archive batches yet, and reloads the shard to
+// serve them. The batches are recorded in metaStore only after all their files are written, so a
+// failed load leaves no batch behind and can be retried.
+func (m *memStoreImpl) LoadArchivedShard(table string, shardID int, r io.Reader) error {
+	schema, err := m.GetSchema(table)
+	if err != nil {
+		return err
+	}
+	schema.RLock()
+	isFactTable := schema.Schema.IsFactTable
+	numShards := len(schema.Schema.GetShards())
+	schema.RUnlock()
+	if !isFactTable {
+		return utils.StackError(nil, "Table %s is not a fact table", table)
+	}
+	if shardID < 0 || shardID >= numShards {
+		return utils.StackError(nil, "Table %s has no shard %d", table, shardID)
+	}
+
+	batchIDs, err := m.metaStore.GetArchiveBatchIDs(table, shardID)
+	if err != nil {
+		return err
+	}
+	if len(batchIDs) > 0 {
+		return ErrArchivedShardExists
+	}
+
+	tarReader := tar.NewReader(r)
+	header, err := tarReader.Next()
+	if err != nil || header.Name != archivedShardManifestName {
+		return utils.StackError(err, "Missing manifest of archived data of table %s shard %d", table, shardID)
+	}
+	var manifest archivedShardManifest
+	if err = json.NewDecoder(tarReader).Decode(&manifest); err != nil {
+		return utils.StackError(err, "Invalid manifest of archived data of table %s shard %d", table, shardID)
+	}
+	batches := make(map[int32]archivedBatchInfo, len(manifest.Batches))
+	for _, batch := range manifest.Batches {
+		batches[batch.BatchID] = batch
+	}
+
+	for {
+		header, err = tarReader.Next()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return utils.StackError(err, "Failed to read archived data of table %s shard %d", table, shardID)
+		}
+		if header.Name == archivedShardEndName {
+			break
+		}
+		var batchID int32
+		var columnID int
+		if _, err = fmt.Sscanf(header.Name, "%d/%d", &batchID, &columnID); err != nil {
+			return utils.StackError(err, "Invalid file %s in archived data of table %s shard %d", header.Name, table, shardID)
+		}
+		batch, ok := batches[batchID]
+		if !ok {
+			return utils.StackError(nil, "Batch %d of file %s is not in the manifest", batchID, header.Name)
+		}
+		if err = m.writeArchivedColumnFile(table, shardID, batch, columnID, tarReader); err != nil {
+			return err
+		}
+	}
+
+	for _, batch := range manifest.Batches {
+		if err = m.metaStore.AddArchiveBatchVersion(table, shardID, int(batch.BatchID), batch.Version, batch.SeqNum,
+			batch.Size); err != nil {
+			return err
+		}
+	}
+	if err = m.metaStore.UpdateArchivingCutoff(table, shardID, manifest.ArchivingCutoff); err != nil {
+		return err
+	}
+
+	if shard := m.detachShard(table, shardID); shard != nil {
+		shard.Destruct()
+	}
+	utils.GetLogger().With("table", table, "shard", shardID, "batches", len(manifest.Batches)).
+		Info("Loaded archived data of table shard")
+	return m.LoadShard(schema, shardID, true)
+}
+
+// writeArchivedColumnFile writes the vector party file of the column of the archive batch.
+func (m *memStoreImpl) writeArchivedColumnFile(table string, shardID int, batch archivedBatchInfo, columnID int,
+	r io.Reader) error {
+	writer, err := m.diskStore.OpenVectorPartyFileForWrite(table, columnID, shardID, int(batch.BatchID), batch.Version,
+		batch.SeqNum)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(writer, r); err != nil {
+		writer.Close()
+		return utils.StackError(err, "Failed to write column %d of batch %d of table %s shard %d", columnID,
+			batch.BatchID, table, shardID)
+	}
+	if err = writer.Close(); err != nil {
+		return utils.StackError(err, "Failed to close column %d of batch %d of table %s shard %d", columnID,
+			batch.BatchID, table, shardID)
+	}
+	return nil
+}
+
+// DropShard unloads the table shard and deletes its redo logs, snapshots and archive batches from
+// disk, e.g. once the shard is served by another instance.
+func (m *memStoreImpl) DropShard(table string, shardID int) error {
+	if _, err := m.GetSchema(table); err != nil {
+		return err
+	}
+	if shard := m.detachShard(table, shardID); shard != nil {
+		shard.Destruct()
+	}
+	if err := m.diskStore.DeleteTableShard(table, shardID); err != nil {
+		return err
+	}
+	if err := m.metaStore.PurgeArchiveBatches(table, shardID, 0, math.MaxInt32); err != nil {
+		return err
+	}
+	utils.GetLogger().With("table", table, "shard", shardID).Info("Dropped table shard")
+	return nil
+}
+
+// detachShard detaches the table shard from the memstore and returns it, nil if it is not loaded.
+// Caller should destruct the shard.
+func (m *memStoreImpl) detachShard(table string, shardID int) *TableShard {
+	m.Lock()
+	defer m.Unlock()
+	shards := m.TableShards[table]
+	shard := shards[shardID]
+	if shard != nil {
+		delete(shards, shardID)
+		utils.DeleteTableShardReporter(table, shardID)
+	}
+	return shard
+}
@@ -0,0 +1,172 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	aresCommon "github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/diskstore"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	metaStoreMocks "github.com/uber/aresdb/metastore/mocks"
+)
+
+var _ = ginkgo.Describe("shard transfer", func() {
+	var sourceRoot, targetRoot string
+	var sourceMetaStore, targetMetaStore *metaStoreMocks.MetaStore
+	var source, target *memStoreImpl
+	var sourceDiskStore, targetDiskStore diskstore.DiskStore
+	cutoff := uint32(86400 * 3)
+
+	table := &metaCom.Table{
+		Name: "trips",
+		Columns: []metaCom.Column{
+			{Name: "request_at", Type: metaCom.Uint32},
+			{Name: "fare", Type: metaCom.Float32},
+			{Name: "removed", Type: metaCom.Int32, Deleted: true},
+		},
+		IsFactTable: true,
+		NumShards:   2,
+		Config:      metaCom.TableConfig{BatchSize: 10},
+	}
+
+	// writeColumnFile writes the vector party file of the batch column.
+	writeColumnFile := func(diskStore diskstore.DiskStore, batchID int, version, seqNum uint32, columnID int, data string) {
+		writer, err := diskStore.OpenVectorPartyFileForWrite("trips", columnID, 1, batchID, version, seqNum)
+		Ω(err).Should(BeNil())
+		_, err = writer.Write([]byte(data))
+		Ω(err).Should(BeNil())
+		Ω(writer.Close()).Should(Succeed())
+	}
+
+	// readColumnFile reads the vector party file of the batch column, empty if it does not exist.
+	readColumnFile := func(diskStore diskstore.DiskStore, batchID int, version, seqNum uint32, columnID int) string {
+		reader, err := diskStore.OpenVectorPartyFileForRead("trips", columnID, 1, batchID, version, seqNum)
+		Ω(err).Should(BeNil())
+		if reader == nil {
+			return ""
+		}
+		defer reader.Close()
+		data, err := ioutil.ReadAll(reader)
+		Ω(err).Should(BeNil())
+		data, err = diskstore.TrimChecksumFooter(data)
+		Ω(err).Should(BeNil())
+		return string(data)
+	}
+
+	newMemStore := func(metaStore *metaStoreMocks.MetaStore, diskStore diskstore.DiskStore) *memStoreImpl {
+		m := NewMemStore(metaStore, diskStore, Options{}).(*memStoreImpl)
+		schema := NewTableSchema(table)
+		for columnID := range table.Columns {
+			schema.SetDefaultValue(columnID)
+		}
+		m.TableSchemas["trips"] = schema
+		shard := NewTableShard(schema, metaStore, diskStore, m.HostMemManager, 1, Options{})
+		shard.ArchiveStore.CurrentVersion = NewArchiveStoreVersion(0, shard)
+		m.TableShards["trips"] = map[int]*TableShard{1: shard}
+		return m
+	}
+
+	ginkgo.BeforeEach(func() {
+		var err error
+		sourceRoot, err = ioutil.TempDir("", "source")
+		Ω(err).Should(BeNil())
+		targetRoot, err = ioutil.TempDir("", "target")
+		Ω(err).Should(BeNil())
+		sourceDiskStore, targetDiskStore = diskstore.NewLocalDiskStore(sourceRoot, aresCommon.RedoLogSyncConfig{}), diskstore.NewLocalDiskStore(targetRoot, aresCommon.RedoLogSyncConfig{})
+		sourceMetaStore, targetMetaStore = &metaStoreMocks.MetaStore{}, &metaStoreMocks.MetaStore{}
+		source, target = newMemStore(sourceMetaStore, sourceDiskStore), newMemStore(targetMetaStore, targetDiskStore)
+		source.TableShards["trips"][1].ArchiveStore.CurrentVersion.ArchivingCutoff = cutoff
+
+		// batch 1 is backfilled, column 1 of batch 2 is all nulls and batch 3 is purged.
+		sourceMetaStore.On("GetArchiveBatchIDs", "trips", 1).Return([]int{1, 2, 3}, nil)
+		sourceMetaStore.On("GetArchiveBatchVersion", "trips", 1, 1, cutoff).Return(cutoff, uint32(2), 5, nil)
+		sourceMetaStore.On("GetArchiveBatchVersion", "trips", 1, 2, cutoff).Return(uint32(86400*2), uint32(0), 3, nil)
+		sourceMetaStore.On("GetArchiveBatchVersion", "trips", 1, 3, cutoff).Return(uint32(0), uint32(0), 0, nil)
+		writeColumnFile(sourceDiskStore, 1, cutoff, 2, 0, "batch 1 column 0")
+		writeColumnFile(sourceDiskStore, 1, cutoff, 2, 1, "batch 1 column 1")
+		writeColumnFile(sourceDiskStore, 2, 86400*2, 0, 0, "batch 2 column 0")
+		// an older version of batch 1 not referenced by the archive store version.
+		writeColumnFile(sourceDiskStore, 1, 86400, 0, 0, "old batch 1 column 0")
+	})
+
+	ginkgo.AfterEach(func() {
+		os.RemoveAll(sourceRoot)
+		os.RemoveAll(targetRoot)
+	})
+
+	ginkgo.It("copies the archive batches of a shard to another instance", func() {
+		var buffer bytes.Buffer
+		Ω(source.WriteArchivedShard("trips", 1, &buffer)).Should(Succeed())
+
+		targetMetaStore.On("GetArchiveBatchIDs", "trips", 1).Return([]int{}, nil).Once()
+		targetMetaStore.On("AddArchiveBatchVersion", "trips", 1, 1, cutoff, uint32(2), 5).Return(nil).Once()
+		targetMetaStore.On("AddArchiveBatchVersion", "trips", 1, 2, uint32(86400*2), uint32(0), 3).Return(nil).Once()
+		targetMetaStore.On("UpdateArchivingCutoff", "trips", 1, cutoff).Return(nil).Once()
+		targetMetaStore.On("GetArchivingCutoff", "trips", 1).Return(cutoff, nil)
+		targetMetaStore.On("GetBackfillProgressInfo", "trips", 1).Return(int64(0), uint32(0), nil)
+		oldShard := target.TableShards["trips"][1]
+		Ω(target.LoadArchivedShard("trips", 1, &buffer)).Should(Succeed())
+		targetMetaStore.AssertExpectations(ginkgo.GinkgoT())
+
+		Ω(readColumnFile(targetDiskStore, 1, cutoff, 2, 0)).Should(Equal("batch 1 column 0"))
+		Ω(readColumnFile(targetDiskStore, 1, cutoff, 2, 1)).Should(Equal("batch 1 column 1"))
+		Ω(readColumnFile(targetDiskStore, 2, 86400*2, 0, 0)).Should(Equal("batch 2 column 0"))
+		Ω(readColumnFile(targetDiskStore, 2, 86400*2, 0, 1)).Should(BeEmpty())
+		Ω(readColumnFile(targetDiskStore, 1, 86400, 0, 0)).Should(BeEmpty())
+
+		// the shard is reloaded with the archived batches.
+		shard := target.TableShards["trips"][1]
+		Ω(shard).ShouldNot(BeIdenticalTo(oldShard))
+		archiveStore := shard.ArchiveStore.GetCurrentVersion()
+		defer archiveStore.Users.Done()
+		Ω(archiveStore.ArchivingCutoff).Should(Equal(cutoff))
+	})
+
+	ginkgo.It("does not overwrite the archive batches of a shard", func() {
+		var buffer bytes.Buffer
+		Ω(source.WriteArchivedShard("trips", 1, &buffer)).Should(Succeed())
+		targetMetaStore.On("GetArchiveBatchIDs", "trips", 1).Return([]int{4}, nil).Once()
+		Ω(target.LoadArchivedShard("trips", 1, &buffer)).Should(Equal(ErrArchivedShardExists))
+		Ω(target.LoadArchivedShard("trips", 2, &buffer)).ShouldNot(Succeed())
+	})
+
+	ginkgo.It("fails to load incomplete archived data", func() {
+		var buffer bytes.Buffer
+		Ω(source.WriteArchivedShard("trips", 1, &buffer)).Should(Succeed())
+		targetMetaStore.On("GetArchiveBatchIDs", "trips", 1).Return([]int{}, nil)
+		// cut within the last column file and before the end file.
+		for _, length := range []int{buffer.Len() - 2048, buffer.Len() - 1536} {
+			incomplete := bytes.NewReader(buffer.Bytes()[:length])
+			Ω(target.LoadArchivedShard("trips", 1, incomplete)).ShouldNot(Succeed())
+		}
+		// no batch is recorded.
+		targetMetaStore.AssertNotCalled(ginkgo.GinkgoT(), "AddArchiveBatchVersion", "trips", 1, 1, cutoff, uint32(2), 5)
+	})
+
+	ginkgo.It("drops a shard", func() {
+		sourceMetaStore.On("PurgeArchiveBatches", "trips", 1, 0, math.MaxInt32).Return(nil).Once()
+		Ω(source.DropShard("trips", 1)).Should(Succeed())
+		sourceMetaStore.AssertCalled(ginkgo.GinkgoT(), "PurgeArchiveBatches", "trips", 1, 0, math.MaxInt32)
+		Ω(source.TableShards["trips"]).ShouldNot(HaveKey(1))
+		Ω(readColumnFile(sourceDiskStore, 1, cutoff, 2, 0)).Should(BeEmpty())
+		Ω(source.DropShard("unknown", 1)).ShouldNot(Succeed())
+	})
+})
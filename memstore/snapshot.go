@@ -15,6 +15,8 @@
 package memstore
 
 import (
+	"bytes"
+
 	memCom "github.com/uber/aresdb/memstore/common"
 	"github.com/uber/aresdb/utils"
 )
@@ -45,6 +47,12 @@ func (m *memStoreImpl) Snapshot(table string, shardID int, reporter SnapshotJobD
 		"job", "snapshot",
 		"table", table).Infof("Creating snapshot")
 
+	// Block column deletion.
+	shard.columnDeletion.Lock()
+	// Block ingestion so that the snapshot contains exactly the upsert batches up to the
+	// redo file and offset recorded, and recovery can replay the rest on top of it.
+	shard.LiveStore.WriterLock.RLock()
+
 	snapshotMgr := shard.LiveStore.SnapshotManager
 	// keep the current redofile and offset
 	redoFile, batchOffset, numMutations, lastReadRecord := snapshotMgr.StartSnapshot()
@@ -55,10 +63,18 @@ func (m *memStoreImpl) Snapshot(table string, shardID int, reporter SnapshotJobD
 		status.Stage = SnapshotSnapshot
 	})
 
+	// Only copying the batches blocks ingestion, they are written to disk afterwards.
+	var vps []snapshotVectorParty
 	if numMutations > 0 {
-		if err = m.createSnapshot(shard, redoFile, batchOffset); err != nil {
-			return err
-		}
+		vps, err = copySnapshot(shard)
+	}
+	shard.LiveStore.WriterLock.RUnlock()
+	if err == nil && numMutations > 0 {
+		err = m.createSnapshot(shard, redoFile, batchOffset, vps)
+	}
+	shard.columnDeletion.Unlock()
+	if err != nil {
+		return err
 	}
 
 	// checkpoint snapshot progress
@@ -81,13 +97,20 @@ func (m *memStoreImpl) Snapshot(table string, shardID int, reporter SnapshotJobD
 	return nil
 }
 
-func (m *memStoreImpl) createSnapshot(shard *TableShard, redoFile int64, batchOffset uint32) error {
-	// Block column deletion
-	shard.columnDeletion.Lock()
-	defer shard.columnDeletion.Unlock()
+// snapshotVectorParty is a vector party of a live batch serialized for a snapshot.
+type snapshotVectorParty struct {
+	batchID  int32
+	columnID int
+	data     []byte
+}
 
+// copySnapshot serializes all batches of the live store into memory, so that they can be written
+// to disk without blocking ingestion. Caller must hold shard.columnDeletion and
+// shard.LiveStore.WriterLock, so that the copy contains exactly the upsert batches up to the
+// redo file and offset of the snapshot.
+func copySnapshot(shard *TableShard) ([]snapshotVectorParty, error) {
+	var vps []snapshotVectorParty
 	batchIDs, _ := shard.LiveStore.GetBatchIDs()
-
 	for _, batchID := range batchIDs {
 		batch := shard.LiveStore.GetBatchForRead(batchID)
 		for colID, vp := range batch.Columns {
@@ -95,17 +118,38 @@ func (m *memStoreImpl) createSnapshot(shard *TableShard, redoFile int64, batchOf
 				// column deleted likely
 				continue
 			}
-			utils.GetLogger().With(
-				"job", "snapshot",
-				"table", shard.Schema.Schema.Name).Infof("batch: %d, columeID: %d", batchID, colID)
-
-			serializer := NewVectorPartySnapshotSerializer(shard, colID, int(batchID), 0, 0, redoFile, batchOffset)
-			if err := serializer.WriteVectorParty(vp); err != nil {
+			var buffer bytes.Buffer
+			if err := vp.Write(&buffer); err != nil {
 				batch.RUnlock()
-				return err
+				return nil, err
 			}
+			vps = append(vps, snapshotVectorParty{batchID: batchID, columnID: colID, data: buffer.Bytes()})
 		}
 		batch.RUnlock()
 	}
+	return vps, nil
+}
+
+// createSnapshot writes the vector parties copied by copySnapshot to snapshot files. Caller must
+// hold shard.columnDeletion.
+func (m *memStoreImpl) createSnapshot(shard *TableShard, redoFile int64, batchOffset uint32, vps []snapshotVectorParty) error {
+	for _, vp := range vps {
+		utils.GetLogger().With(
+			"job", "snapshot",
+			"table", shard.Schema.Schema.Name).Infof("batch: %d, columeID: %d", vp.batchID, vp.columnID)
+
+		writer, err := shard.diskStore.OpenSnapshotVectorPartyFileForWrite(shard.Schema.Schema.Name,
+			shard.ShardID, redoFile, batchOffset, int(vp.batchID), vp.columnID)
+		if err != nil {
+			return err
+		}
+		if _, err = writer.Write(vp.data); err != nil {
+			writer.Close()
+			return err
+		}
+		if err = writer.Close(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
@@ -15,6 +15,8 @@
 package memstore
 
 import (
+	aresCommon "github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/diskstore"
 	diskMocks "github.com/uber/aresdb/diskstore/mocks"
 	metaMocks "github.com/uber/aresdb/metastore/mocks"
 	utilsMocks "github.com/uber/aresdb/utils/mocks"
@@ -30,6 +32,7 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 // formt /tmp/data/myTable_0/snapshots/1499970253_200/-2147483648/1.data
@@ -163,8 +166,23 @@ var _ = ginkgo.Describe("snapshot", func() {
 		writer.On("Write", mock.Anything).Return(0, nil)
 		writer.On("Close").Return(nil)
 
+		// ingestion is not blocked while the snapshot is written to disk.
+		ingestionBlocked := false
 		diskStore.On(
 			"OpenSnapshotVectorPartyFileForWrite", tableName, 0, redoLogFile+10, offset, mock.Anything, mock.Anything).
+			Run(func(args mock.Arguments) {
+				locked := make(chan struct{})
+				go func() {
+					shard.LiveStore.WriterLock.Lock()
+					shard.LiveStore.WriterLock.Unlock()
+					close(locked)
+				}()
+				select {
+				case <-locked:
+				case <-time.After(time.Second):
+					ingestionBlocked = true
+				}
+			}).
 			Return(writer, nil)
 		diskStore.On("DeleteSnapshot", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
 
@@ -178,6 +196,8 @@ var _ = ginkgo.Describe("snapshot", func() {
 		Ω(lastOffset).Should(Equal(offset))
 		Ω(lastRecordID).Should(Equal(currentRecord))
 		diskStore.AssertCalled(utils.TestingT, "DeleteSnapshot", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		diskStore.AssertCalled(utils.TestingT, "OpenSnapshotVectorPartyFileForWrite", tableName, 0, redoLogFile+10, offset, mock.Anything, mock.Anything)
+		Ω(ingestionBlocked).Should(BeFalse())
 	})
 
 	ginkgo.It("dimension table snapshot and recovery", func() {
@@ -255,6 +275,95 @@ var _ = ginkgo.Describe("snapshot", func() {
 		}
 	})
 
+	ginkgo.It("recovery from snapshot should be same as full redo log replay", func() {
+		localDiskStore := diskstore.NewLocalDiskStore("/tmp/data", aresCommon.RedoLogSyncConfig{})
+		localMetaStore := &metaMocks.MetaStore{}
+		localMetaStore.On("UpdateSnapshotProgress", tableName, 0, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		dataTypes := []memCom.DataType{memCom.Uint16, memCom.Uint32, memCom.SmallEnum}
+
+		newShard := func() (*memStoreImpl, *TableShard) {
+			m := createMemStore(tableName, 0, dataTypes, []int{0}, batchSize, false, false, localMetaStore, localDiskStore)
+			s, _ := m.GetTableShard(tableName, 0)
+			s.Users.Done()
+			// keep all upsert batches in the same redo log file.
+			s.LiveStore.RedoLogManager.RotationInterval = math.MaxInt32
+			s.LiveStore.RedoLogManager.MaxRedoLogSize = math.MaxInt32
+			return m, s
+		}
+
+		ingest := func(m *memStoreImpl, minID, maxID int, count uint32, enum uint8) {
+			builder := memCom.NewUpsertBatchBuilder()
+			builder.AddColumn(0, memCom.Uint16)
+			builder.AddColumnWithUpdateMode(1, memCom.Uint32, memCom.UpdateWithAddition)
+			builder.AddColumn(2, memCom.SmallEnum)
+			for id := minID; id <= maxID; id++ {
+				row := id - minID
+				builder.AddRow()
+				builder.SetValue(row, 0, uint16(id))
+				builder.SetValue(row, 1, count)
+				builder.SetValue(row, 2, enum)
+			}
+			buffer, _ := builder.ToByteArray()
+			upsertBatch, _ := NewUpsertBatch(buffer)
+			Ω(m.HandleIngestion(tableName, 0, upsertBatch)).Should(BeNil())
+		}
+
+		readRow := func(s *TableShard, id int) []interface{} {
+			key := []byte{byte(id), byte(id >> 8)}
+			values := []interface{}{}
+			for columnID := 1; columnID < len(dataTypes); columnID++ {
+				value, valid := ReadShardValue(s, columnID, key)
+				switch {
+				case !valid:
+					values = append(values, nil)
+				case columnID == 1:
+					values = append(values, *(*uint32)(value))
+				default:
+					values = append(values, *(*uint8)(value))
+				}
+			}
+			return values
+		}
+
+		memStore, shard = newShard()
+		ingest(memStore, 1, 15, 1, 1)
+		ingest(memStore, 1, 5, 10, 2)
+		snapshotJobM := &snapshotJobManager{
+			jobDetails: make(map[string]*SnapshotJobDetail),
+			memStore:   memStore,
+		}
+		Ω(memStore.Snapshot(tableName, 0, snapshotJobM.reportSnapshotJobDetail)).Should(BeNil())
+		snapshotRedoFile, snapshotOffset, _, snapshotRecord := shard.LiveStore.SnapshotManager.GetLastSnapshotInfo()
+		Ω(snapshotRedoFile).ShouldNot(BeZero())
+		Ω(snapshotOffset).Should(BeEquivalentTo(1))
+		// additive updates after the snapshot.
+		ingest(memStore, 3, 18, 100, 3)
+		liveShard := shard
+
+		_, fullReplayShard := newShard()
+		fullReplayShard.ReplayRedoLogs()
+
+		_, snapshotShard := newShard()
+		snapshotShard.LiveStore.SnapshotManager.SetLastSnapshotInfo(snapshotRedoFile, snapshotOffset, snapshotRecord)
+		Ω(snapshotShard.LoadSnapshot()).Should(BeNil())
+		snapshotShard.ReplayRedoLogs()
+
+		Ω(readRow(liveShard, 1)).Should(Equal([]interface{}{uint32(11), uint8(2)}))
+		Ω(readRow(liveShard, 3)).Should(Equal([]interface{}{uint32(111), uint8(3)}))
+		Ω(readRow(liveShard, 18)).Should(Equal([]interface{}{uint32(100), uint8(3)}))
+		for _, s := range []*TableShard{fullReplayShard, snapshotShard} {
+			for id := 1; id <= 18; id++ {
+				Ω(readRow(s, id)).Should(Equal(readRow(liveShard, id)), fmt.Sprintf("id %d", id))
+			}
+			Ω(s.LiveStore.LastReadRecord).Should(Equal(liveShard.LiveStore.LastReadRecord))
+			Ω(s.LiveStore.NextWriteRecord).Should(Equal(liveShard.LiveStore.NextWriteRecord))
+			redoFile, offset, _, record := s.LiveStore.SnapshotManager.StartSnapshot()
+			Ω(redoFile).Should(Equal(snapshotRedoFile))
+			Ω(offset).Should(BeEquivalentTo(2))
+			Ω(record).Should(Equal(liveShard.LiveStore.LastReadRecord))
+		}
+	})
+
 	ginkgo.It("dimension table snapshot failure", func() {
 		snapshotJobM := &snapshotJobManager{
 			jobDetails: make(map[string]*SnapshotJobDetail),
@@ -49,17 +49,27 @@ type TableShard struct {
 
 	// For convenience.
 	HostMemoryManager common.HostMemoryManager `json:"-"`
+
+	// Idempotency keys of recently applied upsert batches.
+	ingestionKeys ingestionKeys
+
+	options Options
 }
 
 // NewTableShard creates and initiates a table shard based on the schema.
 func NewTableShard(schema *TableSchema, metaStore metastore.MetaStore,
-	diskStore diskstore.DiskStore, hostMemoryManager common.HostMemoryManager, shard int) *TableShard {
+	diskStore diskstore.DiskStore, hostMemoryManager common.HostMemoryManager, shard int, options Options) *TableShard {
 	tableShard := &TableShard{
 		ShardID:           shard,
 		Schema:            schema,
 		diskStore:         diskStore,
 		metaStore:         metaStore,
 		HostMemoryManager: hostMemoryManager,
+		ingestionKeys: ingestionKeys{
+			maxKeys: options.MaxIngestionKeys,
+			ttl:     options.IngestionKeyTTL,
+		},
+		options: options,
 	}
 	archiveStore := NewArchiveStore(tableShard)
 	tableShard.ArchiveStore = archiveStore
@@ -59,7 +59,7 @@ var _ = ginkgo.Describe("table Shard", func() {
 		}
 
 		shard := NewTableShard(schema, nil, diskStore,
-			NewHostMemoryManager(getFactory().NewMockMemStore(), 1<<32), 0)
+			NewHostMemoryManager(getFactory().NewMockMemStore(), 1<<32), 0, Options{})
 
 		// Prepare live store
 		shard.LiveStore.AdvanceNextWriteRecord()
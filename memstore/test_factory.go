@@ -48,7 +48,7 @@ type TestFactoryT struct {
 
 // NewMockMemStore returns a new memstore with mocked diskstore and metastore.
 func (t TestFactoryT) NewMockMemStore() *memStoreImpl {
-	return NewMemStore(new(metaMocks.MetaStore), new(diskMocks.DiskStore)).(*memStoreImpl)
+	return NewMemStore(new(metaMocks.MetaStore), new(diskMocks.DiskStore), Options{}).(*memStoreImpl)
 }
 
 // ReadArchiveBatch read batch and do pruning for every columns.
@@ -149,6 +149,14 @@ type UpsertBatch struct {
 	// Arrival Time of Upsert Batch
 	ArrivalTime uint32
 
+	// Optional key identifying the batch across client retries, batches with a recently
+	// applied key are not applied again. Not part of the serialized buffer, it is written
+	// into the redo log record with the buffer.
+	IdempotencyKey string
+
+	// Time in unix seconds until the idempotency key is remembered after the batch is applied.
+	IdempotencyKeyExpiry int64
+
 	// Serialized buffer of the batch, starts from NumRows, does not contain the 4-byte
 	// buffer size.
 	buffer []byte
@@ -15,6 +15,11 @@
 package memstore
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+
 	"github.com/uber/aresdb/diskstore"
 	"github.com/uber/aresdb/memstore/common"
 	"github.com/uber/aresdb/utils"
@@ -23,6 +28,10 @@ import (
 // VectorPartyHeader is the magic header written into the beginning of each vector party file.
 const VectorPartyHeader uint32 = 0xFADEFACE
 
+// CompressedVectorPartyHeader is the magic header written into the beginning of each compressed archive
+// vector party file. It is followed by the compression codec (uint32) and the compressed vector party data.
+const CompressedVectorPartyHeader uint32 = 0xFADEFACD
+
 // VectorPartyBaseSerializer is the base class contains basic data to read/write VectorParty
 type vectorPartyBaseSerializer struct {
 	shard, columnID, batchID int
@@ -55,6 +64,8 @@ func (s *vectorPartyBaseSerializer) CheckVectorPartySerializable(vp common.Vecto
 // VectorPartyArchiveSerializer is the class to read/write archive VectorParty
 type vectorPartyArchiveSerializer struct {
 	vectorPartyBaseSerializer
+	// Codec to compress vector party files on write.
+	compression common.CompressionCodec
 }
 
 // VectorPartyArchiveSerializer is the class to read/write snapshot VectorParty
@@ -64,10 +75,11 @@ type vectorPartySnapshotSerializer struct {
 	offset      uint32
 }
 
-// NewVectorPartyArchiveSerializer returns a new VectorPartySerializer
-func NewVectorPartyArchiveSerializer(hostMemManager common.HostMemoryManager, diskStore diskstore.DiskStore, table string, shardID int, columnID int, batchID int, batchVersion uint32, seqNum uint32) common.VectorPartySerializer {
+// NewVectorPartyArchiveSerializer returns a new VectorPartySerializer. compression is only used when writing
+// vector party files, the codec of files being read is detected from their headers.
+func NewVectorPartyArchiveSerializer(hostMemManager common.HostMemoryManager, diskStore diskstore.DiskStore, table string, shardID int, columnID int, batchID int, batchVersion uint32, seqNum uint32, compression common.CompressionCodec) common.VectorPartySerializer {
 	return &vectorPartyArchiveSerializer{
-		vectorPartyBaseSerializer{
+		vectorPartyBaseSerializer: vectorPartyBaseSerializer{
 			table:             table,
 			shard:             shardID,
 			columnID:          columnID,
@@ -77,6 +89,7 @@ func NewVectorPartyArchiveSerializer(hostMemManager common.HostMemoryManager, di
 			diskstore:         diskStore,
 			hostMemoryManager: hostMemManager,
 		},
+		compression: compression,
 	}
 }
 
@@ -115,7 +128,12 @@ func (s *vectorPartyArchiveSerializer) ReadVectorParty(vp common.VectorParty) er
 		return nil
 	}
 	defer readCloser.Close()
-	return vp.Read(readCloser, s)
+
+	reader, err := newDecompressingReader(readCloser)
+	if err != nil {
+		return err
+	}
+	return vp.Read(reader, s)
 }
 
 // WriteVectorParty writes vector party to disk
@@ -129,7 +147,80 @@ func (s *vectorPartyArchiveSerializer) WriteVectorParty(vp common.VectorParty) e
 		return err
 	}
 	defer writerCloser.Close()
-	return vp.Write(writerCloser)
+
+	if s.compression == common.NoCompression {
+		return vp.Write(writerCloser)
+	}
+
+	compressingWriter, err := newCompressingWriter(writerCloser, s.compression)
+	if err != nil {
+		return err
+	}
+
+	if err = vp.Write(compressingWriter); err != nil {
+		return err
+	}
+	// Flush compressed data into the file.
+	return compressingWriter.Close()
+}
+
+// newCompressingWriter writes the compressed vector party header into writer and returns a writer
+// to compress vector party data with the given codec. Caller needs to close the returned writer
+// to flush compressed data.
+func newCompressingWriter(writer io.Writer, codec common.CompressionCodec) (io.WriteCloser, error) {
+	dataWriter := utils.NewStreamDataWriter(writer)
+	if err := dataWriter.WriteUint32(CompressedVectorPartyHeader); err != nil {
+		return nil, err
+	}
+
+	if err := dataWriter.WriteUint32(uint32(codec)); err != nil {
+		return nil, err
+	}
+
+	switch codec {
+	case common.GzipCompression:
+		return gzip.NewWriterLevel(writer, gzip.BestSpeed)
+	}
+	return nil, utils.StackError(nil, "Unsupported compression codec %d", codec)
+}
+
+// newDecompressingReader returns a reader of uncompressed vector party data. Uncompressed vector
+// party files are returned as is.
+func newDecompressingReader(reader io.Reader) (io.Reader, error) {
+	bufReader := bufio.NewReader(reader)
+	headerBytes, err := bufReader.Peek(4)
+	if err != nil {
+		// Let vector party reader report invalid files.
+		return bufReader, nil
+	}
+
+	headerReader := utils.NewStreamDataReader(bytes.NewReader(headerBytes))
+	header, _ := headerReader.ReadUint32()
+	if header != CompressedVectorPartyHeader {
+		return bufReader, nil
+	}
+
+	dataReader := utils.NewStreamDataReader(bufReader)
+	if _, err = dataReader.ReadUint32(); err != nil {
+		return nil, err
+	}
+
+	codec, err := dataReader.ReadUint32()
+	if err != nil {
+		return nil, err
+	}
+
+	switch common.CompressionCodec(codec) {
+	case common.GzipCompression:
+		gzipReader, err := gzip.NewReader(bufReader)
+		if err != nil {
+			return nil, err
+		}
+		// Bytes after the compressed stream, e.g. the checksum footer, are not gzip members.
+		gzipReader.Multistream(false)
+		return gzipReader, nil
+	}
+	return nil, utils.StackError(nil, "Unsupported compression codec %d", codec)
 }
 
 // ReportVectorPartyMemoryUsage report memory usage according to underneath VectorParty property
@@ -16,6 +16,9 @@ package memstore
 
 import (
 	"io"
+	"sync"
+	"testing"
+	"unsafe"
 
 	"bytes"
 	"github.com/uber/aresdb/diskstore/mocks"
@@ -41,7 +44,7 @@ var _ = ginkgo.Describe("vector party serializer", func() {
 
 	ginkgo.BeforeEach(func() {
 		serializer = &vectorPartyArchiveSerializer{
-			vectorPartyBaseSerializer{
+			vectorPartyBaseSerializer: vectorPartyBaseSerializer{
 				table:             "test",
 				diskstore:         new(mocks.DiskStore),
 				hostMemoryManager: hostMemoryManager,
@@ -144,6 +147,46 @@ var _ = ginkgo.Describe("vector party serializer", func() {
 		Ω(mode3Int8.Equals(newVP)).Should(BeTrue())
 	})
 
+	ginkgo.It("compressed archive vector party should work", func() {
+		mode3Int8, err := getFactory().ReadArchiveVectorParty("serializer/mode3_int8", nil)
+		Ω(err).Should(BeNil())
+		defer mode3Int8.SafeDestruct()
+
+		serializer.compression = common.GzipCompression
+		Ω(serializer.WriteVectorParty(mode3Int8)).Should(BeNil())
+
+		dataReader := utils.NewStreamDataReader(bytes.NewReader(buf.Bytes()))
+		header, _ := dataReader.ReadUint32()
+		Ω(header).Should(Equal(CompressedVectorPartyHeader))
+		codec, _ := dataReader.ReadUint32()
+		Ω(common.CompressionCodec(codec)).Should(Equal(common.GzipCompression))
+
+		reader = &utils.ClosableReader{
+			Reader: bytes.NewReader(buf.Bytes()),
+		}
+		serializer.diskstore.(*mocks.DiskStore).On("OpenVectorPartyFileForRead",
+			serializer.table, serializer.columnID, serializer.shard,
+			serializer.batchID, serializer.batchVersion, serializer.seqNum).Return(reader, nil)
+		newVP := &cVectorParty{}
+		err = serializer.ReadVectorParty(newVP)
+		Ω(err).Should(BeNil())
+		Ω(mode3Int8.Equals(newVP)).Should(BeTrue())
+	})
+
+	ginkgo.It("reading vector party with unknown compression codec should fail", func() {
+		dataWriter := utils.NewStreamDataWriter(buf)
+		dataWriter.WriteUint32(CompressedVectorPartyHeader)
+		dataWriter.WriteUint32(uint32(common.UnknownCompression))
+
+		reader = &utils.ClosableReader{
+			Reader: bytes.NewReader(buf.Bytes()),
+		}
+		serializer.diskstore.(*mocks.DiskStore).On("OpenVectorPartyFileForRead",
+			serializer.table, serializer.columnID, serializer.shard,
+			serializer.batchID, serializer.batchVersion, serializer.seqNum).Return(reader, nil)
+		Ω(serializer.ReadVectorParty(&cVectorParty{})).ShouldNot(BeNil())
+	})
+
 	ginkgo.AfterEach(func() {
 	})
 
@@ -239,8 +282,8 @@ var _ = ginkgo.Describe("vector party serializer", func() {
 		diskStore := &mocks.DiskStore{}
 
 		shard := NewTableShard(schema, nil, diskStore,
-			NewHostMemoryManager(getFactory().NewMockMemStore(), 1<<32), 0)
-		archiveSerializer := NewVectorPartyArchiveSerializer(shard.HostMemoryManager, shard.diskStore, shard.Schema.Schema.Name, shard.ShardID, 0, 0, 0, 0)
+			NewHostMemoryManager(getFactory().NewMockMemStore(), 1<<32), 0, Options{})
+		archiveSerializer := NewVectorPartyArchiveSerializer(shard.HostMemoryManager, shard.diskStore, shard.Schema.Schema.Name, shard.ShardID, 0, 0, 0, 0, common.NoCompression)
 		snapshotSerializer := NewVectorPartySnapshotSerializer(shard, 0, 0, 0, 0, 0, 0)
 
 		// snapshotSerializer should always has no error
@@ -325,3 +368,83 @@ var _ = ginkgo.Describe("vector party serializer", func() {
 		Ω(err).ShouldNot(BeNil())
 	})
 })
+
+// newBenchmarkVectorParty creates an uint32 vector party with repeated values.
+func newBenchmarkVectorParty(length int) common.VectorParty {
+	vp := newArchiveVectorParty(length, common.Uint32, common.NullDataValue, &sync.RWMutex{})
+	vp.Allocate(false)
+	for i := 0; i < length; i++ {
+		value := uint32(i / 64)
+		vp.SetDataValue(i, common.DataValue{Valid: true, OtherVal: unsafe.Pointer(&value)}, IgnoreCount)
+	}
+	vp.nonDefaultValueCount = length
+	return vp
+}
+
+// serializeBenchmarkVectorParty writes the vector party into buf with the given codec.
+func serializeBenchmarkVectorParty(vp common.VectorParty, codec common.CompressionCodec, buf *bytes.Buffer) {
+	buf.Reset()
+	if codec == common.NoCompression {
+		vp.Write(buf)
+		return
+	}
+	writer, _ := newCompressingWriter(buf, codec)
+	vp.Write(writer)
+	writer.Close()
+}
+
+// benchmarkVectorPartyWrite reports throughput in uncompressed bytes and logs the file size.
+func benchmarkVectorPartyWrite(b *testing.B, codec common.CompressionCodec) {
+	vp := newBenchmarkVectorParty(1 << 20)
+	defer vp.SafeDestruct()
+
+	buf := &bytes.Buffer{}
+	serializeBenchmarkVectorParty(vp, common.NoCompression, buf)
+	rawSize := buf.Len()
+	b.SetBytes(int64(rawSize))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		serializeBenchmarkVectorParty(vp, codec, buf)
+	}
+	b.Logf("vector party file size: %d bytes, uncompressed size: %d bytes", buf.Len(), rawSize)
+}
+
+// benchmarkVectorPartyRead reports throughput in uncompressed bytes.
+func benchmarkVectorPartyRead(b *testing.B, codec common.CompressionCodec) {
+	vp := newBenchmarkVectorParty(1 << 20)
+	defer vp.SafeDestruct()
+
+	buf := &bytes.Buffer{}
+	serializeBenchmarkVectorParty(vp, common.NoCompression, buf)
+	b.SetBytes(int64(buf.Len()))
+	serializeBenchmarkVectorParty(vp, codec, buf)
+
+	serializer := &vectorPartyArchiveSerializer{
+		vectorPartyBaseSerializer: vectorPartyBaseSerializer{
+			hostMemoryManager: NewHostMemoryManager(getFactory().NewMockMemStore(), 1<<32),
+		},
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		reader, _ := newDecompressingReader(bytes.NewReader(buf.Bytes()))
+		newVP := &cVectorParty{}
+		newVP.Read(reader, serializer)
+		newVP.SafeDestruct()
+	}
+}
+
+func BenchmarkVectorPartyWrite_NoCompression(b *testing.B) {
+	benchmarkVectorPartyWrite(b, common.NoCompression)
+}
+
+func BenchmarkVectorPartyWrite_Gzip(b *testing.B) {
+	benchmarkVectorPartyWrite(b, common.GzipCompression)
+}
+
+func BenchmarkVectorPartyRead_NoCompression(b *testing.B) {
+	benchmarkVectorPartyRead(b, common.NoCompression)
+}
+
+func BenchmarkVectorPartyRead_Gzip(b *testing.B) {
+	benchmarkVectorPartyRead(b, common.GzipCompression)
+}
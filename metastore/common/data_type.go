@@ -30,4 +30,13 @@ const (
 	GeoPoint  = "GeoPoint"
 	GeoShape  = "GeoShape"
 	Int64     = "Int64"
+	// EnumArray is an array of distinct enum cases stored as an Uint32 bitmap, where bit i
+	// is set if the array contains the enum case with enum id i. Null means the array is
+	// missing and 0 means the array is empty.
+	EnumArray = "EnumArray"
 )
+
+// EnumArrayCapacity is the max number of enum cases of an enum array column. It is bound by the
+// Uint32 bitmap, as the query kernels only apply bitwise operators to values up to 4 bytes.
+// Columns can lower it with the maxEnumCardinality column config.
+const EnumArrayCapacity = 32
@@ -33,12 +33,41 @@ type ColumnConfig struct {
 	//     High number implies high priority.
 	PreloadingDays int   `json:"preloadingDays,omitempty"`
 	Priority       int64 `json:"priority,omitempty"`
+
+	// MaxEnumCardinality is the max number of enum cases of enum columns. New enum cases
+	// beyond it are not assigned enum ids and ingested as the default value. Zero means the
+	// capacity of the enum type, 0x100 for small_enum, 0x10000 for big_enum and 32 for enum_array.
+	MaxEnumCardinality int `json:"maxEnumCardinality,omitempty"`
+
+	// NonFinitePolicy is how NaN and infinite values of float columns are handled, one of
+	// NonFiniteSkip, NonFiniteReject and NonFinitePropagate. Empty means NonFiniteSkip.
+	NonFinitePolicy string `json:"nonFinitePolicy,omitempty"`
+
+	// ReadTenants are the tenants allowed to reference the column in queries, empty means all
+	// tenants. Queries of other tenants referencing the column anywhere, including filters and
+	// dimensions, are rejected.
+	ReadTenants []string `json:"readTenants,omitempty"`
+	// WriteTenants are the tenants allowed to ingest values of the column, empty means all
+	// tenants. Upsert batches of other tenants with the column are rejected.
+	WriteTenants []string `json:"writeTenants,omitempty"`
 }
 
+// Policies for NaN and infinite values of float columns.
+const (
+	// NonFiniteSkip skips the values in sum, avg, min and max aggregations, count
+	// still counts their rows.
+	NonFiniteSkip = "skip"
+	// NonFiniteReject rejects upsert batches containing the values at ingestion.
+	NonFiniteReject = "reject"
+	// NonFinitePropagate aggregates the values as they are, so a single NaN turns
+	// the aggregate of its group into NaN.
+	NonFinitePropagate = "propagate"
+)
+
 // Column defines the schema of a column from MetaStore.
 // swagger:model column
 type Column struct {
-	// Immutable, columns cannot be renamed.
+	// Columns are renamed through the rename column API, which keeps their ids and data.
 	Name string `json:"name"`
 	// Immutable, columns cannot have their types changed.
 	Type string `json:"type"`
@@ -57,6 +86,11 @@ type Column struct {
 	// Whether disable enum cases auto expansion.
 	DisableAutoExpand bool `json:"disableAutoExpand,omitempty"`
 
+	// Immutable, expression computing the value of the column from other numeric columns
+	// of the same row, e.g. price * qty. Values of derived columns are computed on
+	// ingestion and backfilled for existing archive batches by the derived column job.
+	DerivedExpr string `json:"derivedExpr,omitempty"`
+
 	// Mutable column configs.
 	Config ColumnConfig `json:"config,omitempty"`
 
@@ -89,12 +123,29 @@ type TableConfig struct {
 	// Specifies the size limit of a single redo log file.
 	MaxRedoLogFileSize int `json:"maxRedoLogFileSize,omitempty"`
 
+	// Specifies how long (in seconds) a redo log file is kept after all its
+	// upsert batches are archived, backfilled or snapshotted. 0 means purging it right away.
+	// Changes apply to the existing shards of the table.
+	RedoLogRetentionInterval int `json:"redoLogRetentionInterval,omitempty"`
+
+	// Max number of distinct primary keys in the live store of each shard. Rows adding keys
+	// beyond it are dropped while updates to existing keys still apply.
+	// 0 means using the server default.
+	MaxPrimaryKeys int `json:"maxPrimaryKeys,omitempty"`
+
 	// Fact table specific configs
 
 	// Number of minutes after event time before a record can be archived.
 	ArchivingDelayMinutes uint32 `json:"archivingDelayMinutes,omitempty"`
 	// Specifies how often archiving runs.
 	ArchivingIntervalMinutes uint32 `json:"archivingIntervalMinutes,omitempty"`
+	// Specifies the compression codec of archived columns on disk. Valid options are
+	// "none" and "gzip". Empty means "none".
+	ArchiveCompression string `json:"archiveCompression,omitempty"`
+	// Size limit in bytes of the live store of each shard. When exceeded, archiving runs
+	// before the archiving interval elapses to move the oldest live batches to disk.
+	// 0 means using the server default.
+	LiveStoreMemoryLimit int64 `json:"liveStoreMemoryLimit,omitempty"`
 
 	// Specifies how often backfill runs.
 	BackfillIntervalMinutes uint32 `json:"backfillIntervalMinutes,omitempty"`
@@ -108,10 +159,25 @@ type TableConfig struct {
 	// Size of each live batch used by backfill job.
 	BackfillStoreBatchSize int `json:"backfillStoreBatchSize,omitempty"`
 
+	// Number of archive batches backfilled by each run of the derived column job.
+	// 0 means using the server default.
+	DerivedColumnBatchesPerRun int `json:"derivedColumnBatchesPerRun,omitempty"`
+
 	// Records with timestamp older than now - RecordRetentionInDays will be skipped
 	// during ingestion and backfill. 0 means unlimited days.
 	RecordRetentionInDays int `json:"recordRetentionInDays,omitempty"`
 
+	// Records older than the archiving cutoff are backfilled into their archive batches,
+	// unless they are older than the cutoff by more than LateArrivalWindowInSeconds, in which
+	// case they are rejected during ingestion. 0 means no limit.
+	LateArrivalWindowInSeconds uint32 `json:"lateArrivalWindowInSeconds,omitempty"`
+
+	// Name of the uint32 event time column resolving upserts to the same primary key: the record
+	// with the max event time wins, ties are resolved by arrival order. Records with null event time
+	// never replace records with event time. Applied to both live records and backfilled archive
+	// records. Empty means the last arrived record wins.
+	ConflictResolutionColumn string `json:"conflictResolutionColumn,omitempty"`
+
 	// Dimension table specific configs
 
 	// Number of mutations to accumulate before creating a new snapshot.
@@ -121,8 +187,134 @@ type TableConfig struct {
 	SnapshotIntervalMinutes int `json:"snapshotIntervalMinutes,omitempty"`
 
 	AllowMissingEventTime bool `json:"allowMissingEventTime,omitempty"`
+
+	// Flattening of nested JSON objects ingested through the json data endpoint.
+	// Nil means fields are ingested into the columns named after their dotted paths.
+	JSONIngestion *JSONIngestionConfig `json:"jsonIngestion,omitempty"`
+
+	// Maintenance of the table rejecting ingestion while queries are served normally.
+	// Nil means no maintenance.
+	Maintenance *Maintenance `json:"maintenance,omitempty"`
+
+	// Declares the fact table as a rollup of a base fact table, maintained by the rollup job
+	// from the archived rows of the base table. Nil means not a rollup table.
+	Rollup *RollupConfig `json:"rollup,omitempty"`
+}
+
+// Maintenance freezes ingestion of a table or of the whole cluster, e.g. during schema
+// migrations or disk maintenance, while queries are served normally. It expires
+// automatically so that ingestion is never frozen forever.
+// swagger:model maintenance
+type Maintenance struct {
+	// Reason returned to rejected ingestion requests.
+	Reason string `json:"reason,omitempty"`
+	// Time in unix seconds when the maintenance expires.
+	ExpiresAt int64 `json:"expiresAt"`
 }
 
+// IsActive tells whether the maintenance is set and not expired at now in unix seconds.
+func (m *Maintenance) IsActive(now int64) bool {
+	return m != nil && now < m.ExpiresAt
+}
+
+// DeletePredicate deletes the rows of a fact table matching the filter with event time before the
+// cutoff. Rows ingested after the deletion are kept unless their event time is before the cutoff,
+// which only happens for late arriving rows.
+// swagger:model deletePredicate
+type DeletePredicate struct {
+	// ID of the predicate, unique within the table.
+	ID int `json:"id"`
+	// Filter expression matching the deleted rows.
+	Filter string `json:"filter"`
+	// Time in unix seconds when the rows were deleted.
+	Cutoff uint32 `json:"cutoff"`
+}
+
+// Time buckets of rollup tables.
+const (
+	RollupHour = "hour"
+	RollupDay  = "day"
+)
+
+// Aggregate functions of rollup measures.
+const (
+	RollupCount = "count"
+	RollupSum   = "sum"
+	RollupMin   = "min"
+	RollupMax   = "max"
+)
+
+// RollupConfig defines how rows of the rollup table are aggregated from the base table. Rows of
+// the base table are grouped by the time bucket of their event time and by the dimensions, the
+// time column of the rollup table stores the start of each bucket and the primary key of the
+// rollup table is the time column plus the dimension columns. Archive batches of the base table
+// are aggregated after they are archived, and again whenever late arriving rows are backfilled
+// into them, so the rollup table is up to date with the base table up to its archiving cutoff.
+// swagger:model rollupConfig
+type RollupConfig struct {
+	// Name of the base fact table, sharded the same as the rollup table.
+	BaseTable string `json:"baseTable"`
+	// Time bucket of rows: hour or day.
+	TimeBucket string `json:"timeBucket"`
+	// Dimension columns, with the same names and types in both tables.
+	Dimensions []string `json:"dimensions,omitempty"`
+	// Measure columns aggregated from the base table.
+	Measures []RollupMeasure `json:"measures"`
+}
+
+// RollupMeasure defines a measure column of a rollup table. count counts rows of the base table,
+// sum, min and max aggregate non null values of the base column. Sum of integers is stored in
+// int64 columns and sum of floats in float columns, min and max in columns of the base column type.
+// Aggregates overflowing the measure column are stored as null.
+// swagger:model rollupMeasure
+type RollupMeasure struct {
+	// Column of the rollup table storing the aggregate.
+	Column string `json:"column"`
+	// Aggregate function: count, sum, min or max.
+	Aggregate string `json:"aggregate"`
+	// Column of the base table to aggregate, empty for count.
+	BaseColumn string `json:"baseColumn,omitempty"`
+}
+
+// BatchVersion is the version and the backfill sequence number of an archive batch.
+type BatchVersion struct {
+	Version uint32 `json:"version"`
+	SeqNum  uint32 `json:"seqNum"`
+}
+
+// JSONIngestionConfig defines how nested JSON objects are flattened into columns. Nested fields
+// are named by their dotted paths, e.g. user.country.
+// swagger:model jsonIngestionConfig
+type JSONIngestionConfig struct {
+	// Column names by dotted paths of fields. Fields not mapped are ingested into the column named
+	// after their path, fields matching no column are ignored.
+	Paths map[string]string `json:"paths,omitempty"`
+
+	// How rows missing a column are handled, one of JSONMissingPathDefault and
+	// JSONMissingPathError. Empty means JSONMissingPathDefault.
+	MissingPaths string `json:"missingPaths,omitempty"`
+
+	// How arrays of columns other than enum array columns are handled, one of JSONArrayReject
+	// and JSONArrayJoin. Empty means JSONArrayReject.
+	Arrays string `json:"arrays,omitempty"`
+
+	// Separator of joined array elements. Empty means ",".
+	ArraySeparator string `json:"arraySeparator,omitempty"`
+}
+
+// Policies of JSON ingestion.
+const (
+	// JSONMissingPathDefault ingests missing columns with the default value of the column, or as
+	// null if the column has no default value.
+	JSONMissingPathDefault = "default"
+	// JSONMissingPathError rejects batches with rows missing a column other than derived columns.
+	JSONMissingPathError = "error"
+	// JSONArrayReject rejects batches with array values.
+	JSONArrayReject = "reject"
+	// JSONArrayJoin joins array elements into a string with the array separator.
+	JSONArrayJoin = "join"
+)
+
 // Table defines the schema and configurations of a table from MetaStore.
 // swagger:model table
 type Table struct {
@@ -142,12 +334,93 @@ type Table struct {
 	// IDs of columns to sort based upon.
 	ArchivingSortColumns []int `json:"archivingSortColumns,omitempty"`
 
+	// Fact table only.
+	// Number of shards rows are hash partitioned into by the value of the shard key column.
+	// 0 or 1 means the table is not sharded. This field is immutable.
+	NumShards int `json:"numShards,omitempty"`
+	// ID of the shard key column, which must be one of the primary key columns so that all
+	// versions of a row land in the same shard. This field is immutable.
+	ShardKeyColumn int `json:"shardKeyColumn,omitempty"`
+
 	Version int `json:"version"`
 }
 
+// SchemaChange defines a change of a table schema applied together with other changes.
+// swagger:model schemaChange
+type SchemaChange struct {
+	// Name of the table to change.
+	Name string `json:"name"`
+	// New schema of the table, the table is created if it does not exist.
+	// The table is deleted if nil.
+	Table *Table `json:"table,omitempty"`
+}
+
+// GetShards returns the IDs of all shards of the table.
+func (t *Table) GetShards() []int {
+	if t.NumShards <= 1 {
+		return []int{0}
+	}
+	shards := make([]int, t.NumShards)
+	for i := range shards {
+		shards[i] = i
+	}
+	return shards
+}
+
 // IsEnumColumn checks whether a column is enum column
 func (c *Column) IsEnumColumn() bool {
-	return c.Type == BigEnum || c.Type == SmallEnum
+	return c.Type == BigEnum || c.Type == SmallEnum || c.Type == EnumArray
+}
+
+// IsEnumArrayColumn checks whether a column is enum array column
+func (c *Column) IsEnumArrayColumn() bool {
+	return c.Type == EnumArray
+}
+
+// GetMaxEnumCardinality returns the max number of enum cases of the enum column,
+// capped by the capacity of the enum type.
+func (c *Column) GetMaxEnumCardinality() int {
+	capacity := 0x100
+	switch c.Type {
+	case BigEnum:
+		capacity = 0x10000
+	case EnumArray:
+		capacity = EnumArrayCapacity
+	}
+	if c.Config.MaxEnumCardinality > 0 && c.Config.MaxEnumCardinality < capacity {
+		return c.Config.MaxEnumCardinality
+	}
+	return capacity
+}
+
+// GetNonFinitePolicy returns the policy for NaN and infinite values of the column.
+func (c *Column) GetNonFinitePolicy() string {
+	if c.Config.NonFinitePolicy == "" {
+		return NonFiniteSkip
+	}
+	return c.Config.NonFinitePolicy
+}
+
+// CanRead tells whether the tenant is allowed to reference the column in queries.
+func (c *Column) CanRead(tenant string) bool {
+	return tenantAllowed(c.Config.ReadTenants, tenant)
+}
+
+// CanWrite tells whether the tenant is allowed to ingest values of the column.
+func (c *Column) CanWrite(tenant string) bool {
+	return tenantAllowed(c.Config.WriteTenants, tenant)
+}
+
+func tenantAllowed(tenants []string, tenant string) bool {
+	if len(tenants) == 0 {
+		return true
+	}
+	for _, allowed := range tenants {
+		if allowed == tenant {
+			return true
+		}
+	}
+	return false
 }
 
 // IsOverwriteOnlyDataType checks whether a column is overwrite only
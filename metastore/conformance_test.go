@@ -0,0 +1,256 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metastore
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	aresCommon "github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/metastore/common"
+)
+
+// describeMetaStoreConformance describes the behaviors every metastore backend must conform to.
+// newMetaStore returns an empty metastore and a function cleaning it up.
+func describeMetaStoreConformance(backend string, newMetaStore func() (MetaStore, func())) bool {
+	return ginkgo.Describe(backend+" metastore conformance", func() {
+		var metaStore MetaStore
+		var cleanUp func()
+
+		trips := common.Table{
+			Name: "trips",
+			Columns: []common.Column{
+				{Name: "request_at", Type: common.Uint32},
+				{Name: "uuid", Type: common.UUID},
+				{Name: "city", Type: common.SmallEnum},
+			},
+			IsFactTable:       true,
+			PrimaryKeyColumns: []int{1},
+			Config: common.TableConfig{
+				BatchSize: DefaultBatchSize,
+			},
+		}
+		cities := common.Table{
+			Name: "cities",
+			Columns: []common.Column{
+				{Name: "id", Type: common.Uint16},
+			},
+			PrimaryKeyColumns: []int{0},
+		}
+
+		ginkgo.BeforeEach(func() {
+			metaStore, cleanUp = newMetaStore()
+			table := trips
+			Ω(metaStore.CreateTable(&table)).Should(BeNil())
+		})
+
+		ginkgo.AfterEach(func() {
+			cleanUp()
+		})
+
+		ginkgo.It("creates, updates and deletes tables", func() {
+			table := trips
+			Ω(metaStore.CreateTable(&table)).Should(Equal(ErrTableAlreadyExist))
+			table = cities
+			Ω(metaStore.CreateTable(&table)).Should(BeNil())
+
+			tables, err := metaStore.ListTables()
+			Ω(err).Should(BeNil())
+			Ω(tables).Should(ConsistOf("trips", "cities"))
+
+			schema, err := metaStore.GetTable("trips")
+			Ω(err).Should(BeNil())
+			Ω(schema.Columns).Should(Equal(trips.Columns))
+			Ω(schema.IsFactTable).Should(BeTrue())
+
+			Ω(metaStore.AddColumn("trips", common.Column{Name: "fare", Type: common.Float32}, false)).Should(BeNil())
+			Ω(metaStore.AddColumn("trips", common.Column{Name: "fare", Type: common.Float32}, false)).ShouldNot(BeNil())
+			schema, err = metaStore.GetTable("trips")
+			Ω(err).Should(BeNil())
+			Ω(schema.Columns).Should(HaveLen(4))
+			Ω(schema.Columns[3].Name).Should(Equal("fare"))
+
+			// previous versions are retained.
+			previous, err := metaStore.GetSchemaVersion("trips", schema.Version-1)
+			Ω(err).Should(BeNil())
+			Ω(previous.Columns).Should(HaveLen(3))
+
+			Ω(metaStore.DeleteTable("cities")).Should(BeNil())
+			tables, err = metaStore.ListTables()
+			Ω(err).Should(BeNil())
+			Ω(tables).Should(Equal([]string{"trips"}))
+			_, err = metaStore.GetTable("cities")
+			Ω(err).Should(Equal(ErrTableDoesNotExist))
+		})
+
+		ginkgo.It("extends enum dicts", func() {
+			enumIDs, err := metaStore.ExtendEnumDict("trips", "city", []string{"sf", "la"})
+			Ω(err).Should(BeNil())
+			Ω(enumIDs).Should(Equal([]int{0, 1}))
+			enumIDs, err = metaStore.ExtendEnumDict("trips", "city", []string{"la", "nyc"})
+			Ω(err).Should(BeNil())
+			Ω(enumIDs).Should(Equal([]int{1, 2}))
+
+			enumCases, err := metaStore.GetEnumDict("trips", "city")
+			Ω(err).Should(BeNil())
+			Ω(enumCases).Should(Equal([]string{"sf", "la", "nyc"}))
+
+			_, err = metaStore.GetEnumDict("trips", "uuid")
+			Ω(err).Should(Equal(ErrNotEnumColumn))
+		})
+
+		ginkgo.It("renames columns", func() {
+			_, err := metaStore.ExtendEnumDict("trips", "city", []string{"sf", "la"})
+			Ω(err).Should(BeNil())
+			enumEvents, enumDone, err := metaStore.WatchEnumDictEvents("trips", "city", 2)
+			Ω(err).Should(BeNil())
+			_, err = metaStore.AddDeletePredicate("trips", "city = 'sf' AND uuid IS NOT NULL", 86400)
+			Ω(err).Should(BeNil())
+
+			Ω(metaStore.RenameColumn("trips", "city", "region")).Should(BeNil())
+			schema, err := metaStore.GetTable("trips")
+			Ω(err).Should(BeNil())
+			Ω(schema.Columns).Should(HaveLen(3))
+			Ω(schema.Columns[2].Name).Should(Equal("region"))
+			Ω(schema.Columns[2].Type).Should(Equal(common.SmallEnum))
+
+			// delete predicates refer to the new name.
+			predicates, err := metaStore.GetDeletePredicates("trips")
+			Ω(err).Should(BeNil())
+			Ω(predicates).Should(HaveLen(1))
+			Ω(predicates[0].Filter).Should(Equal("region = 'sf' AND uuid IS NOT NULL"))
+
+			// the enum dict and its watcher move to the new name.
+			enumIDs, err := metaStore.ExtendEnumDict("trips", "region", []string{"la", "nyc"})
+			Ω(err).Should(BeNil())
+			Ω(enumIDs).Should(Equal([]int{1, 2}))
+			Ω(<-enumEvents).Should(Equal("nyc"))
+			close(enumDone)
+			enumCases, err := metaStore.GetEnumDict("trips", "region")
+			Ω(err).Should(BeNil())
+			Ω(enumCases).Should(Equal([]string{"sf", "la", "nyc"}))
+			_, err = metaStore.GetEnumDict("trips", "city")
+			Ω(err).Should(Equal(ErrColumnDoesNotExist))
+
+			Ω(metaStore.RenameColumn("trips", "city", "town")).Should(Equal(ErrColumnDoesNotExist))
+			err = metaStore.RenameColumn("trips", "uuid", "Region")
+			Ω(err).ShouldNot(BeNil())
+			Ω(err.Error()).Should(ContainSubstring(ErrDuplicatedColumnName.Error()))
+			Ω(metaStore.RenameColumn("trips", "uuid", "and")).ShouldNot(BeNil())
+			Ω(metaStore.RenameColumn("unknown", "uuid", "id")).Should(Equal(ErrTableDoesNotExist))
+		})
+
+		ginkgo.It("keeps shard progress", func() {
+			Ω(metaStore.UpdateArchivingCutoff("trips", 0, 86400)).Should(BeNil())
+			cutoff, err := metaStore.GetArchivingCutoff("trips", 0)
+			Ω(err).Should(BeNil())
+			Ω(cutoff).Should(Equal(uint32(86400)))
+
+			// snapshots are taken for dimension tables.
+			table := cities
+			Ω(metaStore.CreateTable(&table)).Should(BeNil())
+			Ω(metaStore.UpdateSnapshotProgress("cities", 0, 10, 2, -1, 5)).Should(BeNil())
+			redoLog, offset, batchID, batchOffset, err := metaStore.GetSnapshotProgress("cities", 0)
+			Ω(err).Should(BeNil())
+			Ω([]interface{}{redoLog, offset, batchID, batchOffset}).Should(Equal([]interface{}{int64(10), uint32(2), int32(-1), uint32(5)}))
+
+			Ω(metaStore.UpdateBackfillProgress("trips", 0, 11, 3)).Should(BeNil())
+			redoLog, offset, err = metaStore.GetBackfillProgressInfo("trips", 0)
+			Ω(err).Should(BeNil())
+			Ω(redoLog).Should(Equal(int64(11)))
+			Ω(offset).Should(Equal(uint32(3)))
+
+			_, err = metaStore.GetArchivingCutoff("trips", 1)
+			Ω(err).Should(Equal(ErrShardDoesNotExist))
+		})
+
+		ginkgo.It("appends archive batch versions", func() {
+			Ω(metaStore.AddArchiveBatchVersion("trips", 0, 3, 100, 0, 10)).Should(BeNil())
+			Ω(metaStore.AddArchiveBatchVersion("trips", 0, 3, 200, 1, 20)).Should(BeNil())
+			Ω(metaStore.AddArchiveBatchVersion("trips", 0, 1, 100, 0, 5)).Should(BeNil())
+
+			version, seqNum, size, err := metaStore.GetArchiveBatchVersion("trips", 0, 3, 200)
+			Ω(err).Should(BeNil())
+			Ω([]interface{}{version, seqNum, size}).Should(Equal([]interface{}{uint32(200), uint32(1), 20}))
+			version, seqNum, size, err = metaStore.GetArchiveBatchVersion("trips", 0, 3, 150)
+			Ω(err).Should(BeNil())
+			Ω([]interface{}{version, seqNum, size}).Should(Equal([]interface{}{uint32(100), uint32(0), 10}))
+
+			batchIDs, err := metaStore.GetArchiveBatchIDs("trips", 0)
+			Ω(err).Should(BeNil())
+			Ω(batchIDs).Should(Equal([]int{1, 3}))
+
+			Ω(metaStore.PurgeArchiveBatches("trips", 0, 0, 2)).Should(BeNil())
+			batchIDs, err = metaStore.GetArchiveBatchIDs("trips", 0)
+			Ω(err).Should(BeNil())
+			Ω(batchIDs).Should(Equal([]int{3}))
+		})
+
+		ginkgo.It("keeps delete predicates", func() {
+			predicate, err := metaStore.AddDeletePredicate("trips", "city = 'sf'", 86400)
+			Ω(err).Should(BeNil())
+			Ω(predicate).Should(Equal(common.DeletePredicate{ID: 0, Filter: "city = 'sf'", Cutoff: 86400}))
+			predicate, err = metaStore.AddDeletePredicate("trips", "city = 'la'", 172800)
+			Ω(err).Should(BeNil())
+			Ω(predicate.ID).Should(Equal(1))
+			Ω(metaStore.RemoveDeletePredicate("trips", 0)).Should(BeNil())
+			Ω(metaStore.RemoveDeletePredicate("trips", 0)).Should(Equal(ErrDeletePredicateDoesNotExist))
+			predicates, err := metaStore.GetDeletePredicates("trips")
+			Ω(err).Should(BeNil())
+			Ω(predicates).Should(Equal([]common.DeletePredicate{{ID: 1, Filter: "city = 'la'", Cutoff: 172800}}))
+
+			// columns referenced by delete predicates cannot be deleted.
+			err = metaStore.DeleteColumn("trips", "city")
+			Ω(err).ShouldNot(BeNil())
+			Ω(err.Error()).Should(ContainSubstring(ErrDeletePredicateColumn.Error()))
+			schema, err := metaStore.GetTable("trips")
+			Ω(err).Should(BeNil())
+			Ω(schema.Columns[2].Deleted).Should(BeFalse())
+			schema.Columns[2].Deleted = true
+			schema.Version++
+			err = metaStore.UpdateTable(*schema)
+			Ω(err).ShouldNot(BeNil())
+			Ω(err.Error()).Should(ContainSubstring(ErrDeletePredicateColumn.Error()))
+
+			Ω(metaStore.RemoveDeletePredicate("trips", 1)).Should(BeNil())
+			Ω(metaStore.DeleteColumn("trips", "city")).Should(BeNil())
+		})
+	})
+}
+
+var _ = describeMetaStoreConformance(aresCommon.MetaStoreBackendDisk, func() (MetaStore, func()) {
+	basePath, err := ioutil.TempDir("", "metastore")
+	Ω(err).Should(BeNil())
+	metaStore, err := NewMetaStore(aresCommon.MetaStoreConfig{}, basePath)
+	Ω(err).Should(BeNil())
+	return metaStore, func() {
+		os.RemoveAll(basePath)
+	}
+})
+
+var _ = describeMetaStoreConformance(aresCommon.MetaStoreBackendEtcd, func() (MetaStore, func()) {
+	server := newFakeEtcdServer()
+	metaStore, err := NewMetaStore(aresCommon.MetaStoreConfig{
+		Backend: aresCommon.MetaStoreBackendEtcd,
+		Etcd: aresCommon.EtcdConfig{
+			Endpoints: []string{server.URL},
+			Prefix:    "/test/metastore",
+		},
+	}, "")
+	Ω(err).Should(BeNil())
+	return metaStore, server.Close
+})
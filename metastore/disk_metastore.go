@@ -32,6 +32,8 @@ import (
 
 const (
 	enumDelimiter = "\u0000\n"
+	// number of latest schema versions retained for each table.
+	schemaVersionsToRetain = 10
 )
 
 // meaningful defaults of table configurations.
@@ -50,7 +52,7 @@ const (
 	DefaultMaxRedoLogSize                 = 1 << 30              // 1 GB
 )
 
-// disk-based metastore implementation.
+// disk-based metastore implementation, the files can also be stored in etcd through utils.FileSystem.
 // all validation of user input (eg. table/column name and table/column struct) will be pushed to api layer,
 // which is the earliest point of user input, all schemas inside system will be already valid,
 // Note:
@@ -124,9 +126,46 @@ func (dm *diskMetaStore) GetTable(name string) (*common.Table, error) {
 	return dm.readSchemaFile(name)
 }
 
-// GetOwnedShards returns the list of shards that are owned by this instance.
+// GetSchemaVersion gets the given version of the table schema from its version history.
+// returns
+// 	ErrTableDoesNotExist if table does not exist
+// 	ErrSchemaVersionDoesNotExist if the version is not retained
+func (dm *diskMetaStore) GetSchemaVersion(tableName string, version int) (*common.Table, error) {
+	dm.RLock()
+	defer dm.RUnlock()
+	if err := dm.tableExists(tableName); err != nil {
+		return nil, err
+	}
+
+	table, err := dm.readSchemaVersionFile(tableName, version)
+	if err != ErrSchemaVersionDoesNotExist {
+		return table, err
+	}
+
+	// schemas written before version history was introduced are only kept in the schema file.
+	table, err = dm.readSchemaFile(tableName)
+	if err != nil {
+		return nil, err
+	}
+	if table.Version != version {
+		return nil, ErrSchemaVersionDoesNotExist
+	}
+	return table, nil
+}
+
+// GetOwnedShards returns the list of shards that are owned by this instance, which are all shards
+// of the table.
 func (dm *diskMetaStore) GetOwnedShards(table string) ([]int, error) {
-	return []int{0}, nil
+	dm.RLock()
+	defer dm.RUnlock()
+	if err := dm.tableExists(table); err != nil {
+		return nil, err
+	}
+	schema, err := dm.readSchemaFile(table)
+	if err != nil {
+		return nil, err
+	}
+	return schema.GetShards(), nil
 }
 
 // GetEnumDict gets the enum cases for given tableName and columnName
@@ -139,6 +178,16 @@ func (dm *diskMetaStore) GetEnumDict(tableName, columnName string) ([]string, er
 	return dm.readEnumFile(tableName, columnName)
 }
 
+// GetDeletePredicates gets the row deletion predicates for given table.
+func (dm *diskMetaStore) GetDeletePredicates(tableName string) ([]common.DeletePredicate, error) {
+	dm.RLock()
+	defer dm.RUnlock()
+	if err := dm.tableExists(tableName); err != nil {
+		return nil, err
+	}
+	return dm.readDeletePredicatesFile(tableName)
+}
+
 // GetArchivingCutoff gets the latest archiving cutoff for given table and shard.
 func (dm *diskMetaStore) GetArchivingCutoff(tableName string, shard int) (uint32, error) {
 	dm.RLock()
@@ -239,6 +288,196 @@ func (dm *diskMetaStore) GetBackfillProgressInfo(table string, shard int) (int64
 	return dm.readRedoLogFileAndOffset(file)
 }
 
+// GetArchiveBatchIDs returns the ids of archive batches with metadata for given table and shard.
+func (dm *diskMetaStore) GetArchiveBatchIDs(table string, shard int) ([]int, error) {
+	dm.RLock()
+	defer dm.RUnlock()
+	if err := dm.shardExists(table, shard); err != nil {
+		return nil, err
+	}
+
+	batchFiles, err := dm.ReadDir(dm.getArchiveBatchDirPath(table, shard))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, utils.StackError(err, "failed to read batch dir, table: %s, shard: %d", table, shard)
+	}
+
+	batchIDs := make([]int, 0, len(batchFiles))
+	for _, batchFile := range batchFiles {
+		batchID, err := strconv.ParseInt(batchFile.Name(), 10, 32)
+		if err != nil {
+			return nil, utils.StackError(err, "Invalid batch file %s, table: %s, shard: %d", batchFile.Name(), table, shard)
+		}
+		batchIDs = append(batchIDs, int(batchID))
+	}
+	sort.Ints(batchIDs)
+	return batchIDs, nil
+}
+
+// GetDerivedColumnProgress gets the derived column backfill progress for given table and shard.
+func (dm *diskMetaStore) GetDerivedColumnProgress(table, column string, shard int) (int, int, error) {
+	dm.RLock()
+	defer dm.RUnlock()
+	if err := dm.shardExists(table, shard); err != nil {
+		return 0, 0, err
+	}
+
+	filePath := dm.getDerivedColumnProgressFilePath(table, column, shard)
+	bytes, err := dm.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return -1, -1, nil
+	} else if err != nil {
+		return 0, 0, utils.StackError(err, "Failed to read file:%s\n", filePath)
+	}
+
+	var lastBatchID, endBatchID int
+	if _, err = fmt.Sscanf(string(bytes), "%d,%d", &lastBatchID, &endBatchID); err != nil {
+		return 0, 0, utils.StackError(err, "Invalid derived column progress file:%s\n", filePath)
+	}
+	return lastBatchID, endBatchID, nil
+}
+
+// GetRollupProgress gets the versions of base table archive batches aggregated into given rollup table and shard.
+func (dm *diskMetaStore) GetRollupProgress(table string, shard int) (map[int]common.BatchVersion, error) {
+	dm.RLock()
+	defer dm.RUnlock()
+	if err := dm.shardExists(table, shard); err != nil {
+		return nil, err
+	}
+
+	filePath := dm.getRollupProgressFilePath(table, shard)
+	progressBytes, err := dm.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return map[int]common.BatchVersion{}, nil
+	} else if err != nil {
+		return nil, utils.StackError(err, "Failed to read file:%s\n", filePath)
+	}
+
+	progress := map[int]common.BatchVersion{}
+	if err = json.Unmarshal(progressBytes, &progress); err != nil {
+		return nil, utils.StackError(err, "Invalid rollup progress file:%s\n", filePath)
+	}
+	return progress, nil
+}
+
+// GetMaintenance returns the maintenance of the cluster, nil if not set.
+func (dm *diskMetaStore) GetMaintenance() (*common.Maintenance, error) {
+	dm.RLock()
+	defer dm.RUnlock()
+
+	filePath := dm.getMaintenanceFilePath()
+	maintenanceBytes, err := dm.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, utils.StackError(err, "Failed to read file:%s\n", filePath)
+	}
+
+	var maintenance common.Maintenance
+	if err = json.Unmarshal(maintenanceBytes, &maintenance); err != nil {
+		return nil, utils.StackError(err, "Invalid maintenance file:%s\n", filePath)
+	}
+	return &maintenance, nil
+}
+
+// UpdateMaintenance overwrites the maintenance of the cluster, nil clears it.
+func (dm *diskMetaStore) UpdateMaintenance(maintenance *common.Maintenance) error {
+	dm.Lock()
+	defer dm.Unlock()
+
+	file := dm.getMaintenanceFilePath()
+	if maintenance == nil {
+		if err := dm.Remove(file); err != nil && !os.IsNotExist(err) {
+			return utils.StackError(err, "Failed to remove maintenance file %s", file)
+		}
+		return nil
+	}
+
+	maintenanceBytes, err := json.Marshal(maintenance)
+	if err != nil {
+		return utils.StackError(err, "Failed to marshal maintenance")
+	}
+
+	writer, err := dm.OpenFileForWrite(
+		file,
+		os.O_CREATE|os.O_TRUNC|os.O_WRONLY,
+		0644,
+	)
+	if err != nil {
+		return utils.StackError(err, "Failed to open maintenance file %s for write", file)
+	}
+	defer writer.Close()
+
+	_, err = writer.Write(maintenanceBytes)
+	return err
+}
+
+// UpdateRollupProgress overwrites the versions of base table archive batches aggregated into given rollup table and shard.
+func (dm *diskMetaStore) UpdateRollupProgress(table string, shard int, progress map[int]common.BatchVersion) error {
+	dm.Lock()
+	defer dm.Unlock()
+	if err := dm.shardExists(table, shard); err != nil {
+		return err
+	}
+
+	progressBytes, err := json.Marshal(progress)
+	if err != nil {
+		return utils.StackError(err, "Failed to marshal rollup progress")
+	}
+
+	file := dm.getRollupProgressFilePath(table, shard)
+	writer, err := dm.OpenFileForWrite(
+		file,
+		os.O_CREATE|os.O_TRUNC|os.O_WRONLY,
+		0644,
+	)
+	if err != nil {
+		return utils.StackError(err, "Failed to open rollup progress file %s for write", file)
+	}
+	defer writer.Close()
+
+	_, err = writer.Write(progressBytes)
+	return err
+}
+
+// UpdateDerivedColumnProgress updates the derived column backfill progress for given table (fact table) and shard.
+func (dm *diskMetaStore) UpdateDerivedColumnProgress(table, column string, shard, lastBatchID, endBatchID int) error {
+	dm.Lock()
+	defer dm.Unlock()
+	if err := dm.shardExists(table, shard); err != nil {
+		return err
+	}
+
+	schema, err := dm.readSchemaFile(table)
+	if err != nil {
+		return err
+	}
+
+	if !schema.IsFactTable {
+		return ErrNotFactTable
+	}
+
+	file := dm.getDerivedColumnProgressFilePath(table, column, shard)
+	if err := dm.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return utils.StackError(err, "Failed to create derived column progress directory")
+	}
+
+	writer, err := dm.OpenFileForWrite(
+		file,
+		os.O_CREATE|os.O_TRUNC|os.O_WRONLY,
+		0644,
+	)
+
+	if err != nil {
+		return utils.StackError(err, "Failed to open derived column progress file %s for write", file)
+	}
+	defer writer.Close()
+
+	_, err = io.WriteString(writer, fmt.Sprintf("%d,%d", lastBatchID, endBatchID))
+	return err
+}
+
 // WatchTableListEvents register a watcher to table list change events,
 // should only be called once,
 // returns ErrWatcherAlreadyExist once watcher already exists
@@ -339,7 +578,7 @@ func (dm *diskMetaStore) CreateTable(table *common.Table) (err error) {
 		dm.Unlock()
 		if err == nil {
 			dm.pushSchemaChange(table)
-			dm.pushShardOwnershipChange(table.Name)
+			dm.pushShardOwnershipChange(table)
 		}
 	}()
 
@@ -359,19 +598,23 @@ func (dm *diskMetaStore) CreateTable(table *common.Table) (err error) {
 		return err
 	}
 
-	if err = dm.MkdirAll(dm.getTableDirPath(table.Name), 0755); err != nil {
+	return dm.writeNewTable(table)
+}
+
+// writeNewTable writes the schema, the default enum cases and shard zero of a new table.
+func (dm *diskMetaStore) writeNewTable(table *common.Table) error {
+	if err := dm.MkdirAll(dm.getTableDirPath(table.Name), 0755); err != nil {
 		return err
 	}
 
-	if err = dm.writeSchemaFile(table); err != nil {
+	if err := dm.writeSchemaFile(table); err != nil {
 		return err
 	}
 
 	// append enum case for enum column with default value
 	for _, column := range table.Columns {
 		if column.DefaultValue != nil && column.IsEnumColumn() {
-			err = dm.writeEnumFile(table.Name, column.Name, []string{*column.DefaultValue})
-			if err != nil {
+			if err := dm.writeEnumFile(table.Name, column.Name, []string{*column.DefaultValue}); err != nil {
 				return err
 			}
 		}
@@ -407,6 +650,7 @@ func (dm *diskMetaStore) UpdateTableConfig(tableName string, config common.Table
 	}
 
 	table.Config = config
+	table.Version++
 	return dm.writeSchemaFile(table)
 }
 
@@ -430,7 +674,15 @@ func (dm *diskMetaStore) UpdateTable(table common.Table) (err error) {
 		return
 	}
 
-	if err = dm.writeSchemaFile(&table); err != nil {
+	if err = dm.checkDeletePredicates(&table); err != nil {
+		return
+	}
+	return dm.writeUpdatedTable(existingTable, &table)
+}
+
+// writeUpdatedTable writes the schema of an existing table and the default enum cases of its new columns.
+func (dm *diskMetaStore) writeUpdatedTable(existingTable, table *common.Table) error {
+	if err := dm.writeSchemaFile(table); err != nil {
 		return err
 	}
 
@@ -438,14 +690,200 @@ func (dm *diskMetaStore) UpdateTable(table common.Table) (err error) {
 	for i := len(existingTable.Columns); i < len(table.Columns); i++ {
 		column := table.Columns[i]
 		if column.DefaultValue != nil && column.IsEnumColumn() {
-			err = dm.writeEnumFile(table.Name, column.Name, []string{*column.DefaultValue})
-			if err != nil {
-				return
+			if err := dm.writeEnumFile(table.Name, column.Name, []string{*column.DefaultValue}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ApplySchemas validates all schema changes against the tables as changed by the preceding
+// changes, then applies them in order. Nothing is applied if any change is invalid. Changes are
+// applied while holding the metastore lock, so readers never observe a partial set of changes.
+// The files of the tables changed are backed up in memory before their first change, and restored
+// if writing any change fails, so that either all or none of the changes are applied. Watchers are
+// only notified once all changes are applied.
+// return
+// 	ErrTableDoesNotExist if a deleted table does not exist
+// 	ErrTableNameMismatch if the table of a change is not named as the change
+func (dm *diskMetaStore) ApplySchemas(changes []common.SchemaChange) (err error) {
+	dm.writeLock.Lock()
+	defer dm.writeLock.Unlock()
+
+	var existingTables []string
+	// tables after preceding changes, nil for deleted tables.
+	tables := make(map[string]*common.Table)
+	var applied []*common.Table
+	var created []*common.Table
+	var deleted []string
+	var tablesDeleted bool
+	// backups of the tables changed in the order of their first change.
+	var backups []*tableBackup
+	dm.Lock()
+	defer func() {
+		if err != nil {
+			for i := len(backups) - 1; i >= 0; i-- {
+				if restoreErr := dm.restoreTable(backups[i]); restoreErr != nil {
+					utils.GetLogger().With("table", backups[i].name, "error", restoreErr).
+						Error("Failed to restore table after failing to apply schema changes")
+				}
+			}
+			dm.Unlock()
+			return
+		}
+		for _, table := range deleted {
+			dm.closeEnumDictWatchers(table)
+		}
+		dm.Unlock()
+		for _, table := range applied {
+			dm.pushSchemaChange(table)
+		}
+		for _, table := range created {
+			dm.pushShardOwnershipChange(table)
+		}
+		if tablesDeleted && dm.tableListWatcher != nil {
+			dm.tableListWatcher <- existingTables
+			<-dm.tableListDone
+		}
+	}()
+
+	existingTables, err = dm.listTables()
+	if err != nil {
+		return err
+	}
+	for _, tableName := range existingTables {
+		var table *common.Table
+		if table, err = dm.readSchemaFile(tableName); err != nil {
+			return err
+		}
+		tables[tableName] = table
+	}
+
+	oldTables := make([]*common.Table, len(changes))
+	for i, change := range changes {
+		oldTable := tables[change.Name]
+		if change.Table == nil {
+			if oldTable == nil {
+				return utils.StackError(ErrTableDoesNotExist, "Invalid change %d of table %s", i, change.Name)
+			}
+		} else {
+			if change.Table.Name != change.Name {
+				return utils.StackError(ErrTableNameMismatch, "Invalid change %d of table %s", i, change.Name)
+			}
+			validator := NewTableSchameValidator()
+			if oldTable != nil {
+				validator.SetOldTable(*oldTable)
+			}
+			validator.SetNewTable(*change.Table)
+			if err = validator.Validate(); err != nil {
+				return utils.StackError(err, "Invalid change %d of table %s", i, change.Name)
+			}
+			// predicates of new tables are left behind by deleted tables of the same name.
+			if oldTable != nil {
+				if err = dm.checkDeletePredicates(change.Table); err != nil {
+					return utils.StackError(err, "Invalid change %d of table %s", i, change.Name)
+				}
+			}
+		}
+		oldTables[i] = oldTable
+		tables[change.Name] = change.Table
+	}
+
+	backedUp := make(map[string]bool)
+	for i, change := range changes {
+		oldTable := oldTables[i]
+		if !backedUp[change.Name] {
+			var backup *tableBackup
+			if backup, err = dm.backupTable(change.Name, oldTable != nil); err != nil {
+				return err
 			}
+			backups = append(backups, backup)
+			backedUp[change.Name] = true
 		}
+		switch {
+		case change.Table == nil:
+			if err = dm.RemoveAll(dm.getTableDirPath(change.Name)); err != nil {
+				err = utils.StackError(err, "Failed to remove directory, table: %s", change.Name)
+			}
+			deleted = append(deleted, change.Name)
+			tablesDeleted = true
+		case oldTable == nil:
+			err = dm.writeNewTable(change.Table)
+			created = append(created, change.Table)
+		default:
+			err = dm.writeUpdatedTable(oldTable, change.Table)
+		}
+		if err != nil {
+			return err
+		}
+		if change.Table != nil {
+			applied = append(applied, change.Table)
+		}
+	}
+
+	existingTables = existingTables[:0]
+	for tableName, table := range tables {
+		if table != nil {
+			existingTables = append(existingTables, tableName)
+		}
+	}
+	sort.Strings(existingTables)
+	return nil
+}
+
+// RollbackSchema rolls back table schema to the given version from its version history.
+// The rolled back schema is written as a new version after all versions in the history, so
+// the history of versions since the given one is kept and later changes never overwrite it.
+// return
+// 	ErrTableDoesNotExist if table does not exist
+// 	ErrSchemaVersionDoesNotExist if the version is not retained
+// 	ErrRollbackDropsColumns if the rollback would drop columns currently holding data
+func (dm *diskMetaStore) RollbackSchema(tableName string, version int) (err error) {
+	dm.writeLock.Lock()
+	defer dm.writeLock.Unlock()
+
+	var table *common.Table
+	dm.Lock()
+	defer func() {
+		dm.Unlock()
+		if err == nil {
+			dm.pushSchemaChange(table)
+		}
+	}()
+
+	if err = dm.tableExists(tableName); err != nil {
+		return err
+	}
+
+	var currentTable, targetTable *common.Table
+	if currentTable, err = dm.readSchemaFile(tableName); err != nil {
+		return err
+	}
+
+	if targetTable, err = dm.readSchemaVersionFile(tableName, version); err != nil {
+		return err
 	}
 
-	return
+	if table, err = rollbackSchema(NewTableSchameValidator(), currentTable, targetTable); err != nil {
+		return err
+	}
+	if err = dm.checkDeletePredicates(table); err != nil {
+		return err
+	}
+
+	var versions []int
+	if versions, err = dm.listSchemaVersions(tableName); err != nil {
+		return err
+	}
+	table.Version = currentTable.Version
+	for _, version := range versions {
+		if version > table.Version {
+			table.Version = version
+		}
+	}
+	table.Version++
+	return dm.writeSchemaFile(table)
 }
 
 // DeleteTable deletes a table
@@ -565,7 +1003,40 @@ func (dm *diskMetaStore) DeleteColumn(tableName string, columnName string) (err
 	return dm.removeColumn(table, columnName)
 }
 
-// ExtendEnumDict extends enum cases for given table column
+// RenameColumn renames a column. Only metadata is changed: data of the column stays
+// keyed by its column id, and its enum dict moves to the new name.
+// return
+// 	ErrTableDoesNotExist if table does not exist
+// 	ErrColumnDoesNotExist if column does not exist
+// 	ErrDuplicatedColumnName if another column already uses the new name
+// 	ErrRenameDerivedColumn if column is a derived column or used by one
+func (dm *diskMetaStore) RenameColumn(tableName string, columnName string, newName string) (err error) {
+	dm.writeLock.Lock()
+	defer dm.writeLock.Unlock()
+
+	var table *common.Table
+	dm.Lock()
+	defer func() {
+		dm.Unlock()
+		if err == nil {
+			dm.pushSchemaChange(table)
+		}
+	}()
+
+	if err = dm.tableExists(tableName); err != nil {
+		return err
+	}
+
+	if table, err = dm.readSchemaFile(tableName); err != nil {
+		return err
+	}
+
+	return dm.renameColumn(table, columnName, newName)
+}
+
+// ExtendEnumDict extends enum cases for given table column. New enum cases are assigned
+// ids in order until the max enum cardinality of the column is reached, enum id of new
+// enum cases beyond it will be -1.
 func (dm *diskMetaStore) ExtendEnumDict(table, column string, enumCases []string) (enumIDs []int, err error) {
 	dm.writeLock.Lock()
 	defer dm.writeLock.Unlock()
@@ -587,7 +1058,8 @@ func (dm *diskMetaStore) ExtendEnumDict(table, column string, enumCases []string
 		}
 	}()
 
-	if err = dm.enumColumnExists(table, column); err != nil {
+	var enumColumn *common.Column
+	if enumColumn, err = dm.getEnumColumn(table, column); err != nil {
 		return nil, err
 	}
 
@@ -602,11 +1074,16 @@ func (dm *diskMetaStore) ExtendEnumDict(table, column string, enumCases []string
 	}
 
 	newEnumID := len(existingCases)
+	maxCardinality := enumColumn.GetMaxEnumCardinality()
+	numRejected := 0
 
 	enumIDs = make([]int, len(enumCases))
 	for index, newCase := range enumCases {
 		if enumID, exist := enumDict[newCase]; exist {
 			enumIDs[index] = enumID
+		} else if newEnumID >= maxCardinality {
+			enumIDs[index] = -1
+			numRejected++
 		} else {
 			enumDict[newCase] = newEnumID
 			newEnumCases = append(newEnumCases, newCase)
@@ -615,6 +1092,13 @@ func (dm *diskMetaStore) ExtendEnumDict(table, column string, enumCases []string
 		}
 	}
 
+	if numRejected > 0 {
+		utils.GetRootReporter().GetChildCounter(map[string]string{
+			"table":      table,
+			"columnName": column,
+		}, utils.NewEnumCasesRejected).Inc(int64(numRejected))
+	}
+
 	if err = dm.writeEnumFile(table, column, newEnumCases); err != nil {
 		return nil, err
 	}
@@ -627,6 +1111,51 @@ func (dm *diskMetaStore) ExtendEnumDict(table, column string, enumCases []string
 	return enumIDs, nil
 }
 
+// AddDeletePredicate appends a row deletion predicate to given table, the predicate is assigned
+// the id following the largest id of the existing predicates.
+func (dm *diskMetaStore) AddDeletePredicate(tableName string, filter string, cutoff uint32) (common.DeletePredicate, error) {
+	dm.Lock()
+	defer dm.Unlock()
+	predicate := common.DeletePredicate{Filter: filter, Cutoff: cutoff}
+	if err := dm.tableExists(tableName); err != nil {
+		return predicate, err
+	}
+
+	predicates, err := dm.readDeletePredicatesFile(tableName)
+	if err != nil {
+		return predicate, err
+	}
+	for _, existing := range predicates {
+		if existing.ID >= predicate.ID {
+			predicate.ID = existing.ID + 1
+		}
+	}
+	return predicate, dm.writeDeletePredicatesFile(tableName, append(predicates, predicate))
+}
+
+// RemoveDeletePredicate removes the row deletion predicate with the id from given table.
+// return
+// 	ErrTableDoesNotExist if table does not exist
+// 	ErrDeletePredicateDoesNotExist if the table has no predicate with the id
+func (dm *diskMetaStore) RemoveDeletePredicate(tableName string, id int) error {
+	dm.Lock()
+	defer dm.Unlock()
+	if err := dm.tableExists(tableName); err != nil {
+		return err
+	}
+
+	predicates, err := dm.readDeletePredicatesFile(tableName)
+	if err != nil {
+		return err
+	}
+	for i, predicate := range predicates {
+		if predicate.ID == id {
+			return dm.writeDeletePredicatesFile(tableName, append(predicates[:i], predicates[i+1:]...))
+		}
+	}
+	return ErrDeletePredicateDoesNotExist
+}
+
 // PurgeArchiveBatches deletes the archive batches' metadata with batchID within [batchIDStart, batchIDEnd)
 func (dm *diskMetaStore) PurgeArchiveBatches(tableName string, shard, batchIDStart, batchIDEnd int) error {
 	dm.Lock()
@@ -810,13 +1339,15 @@ func (dm *diskMetaStore) pushSchemaChange(table *common.Table) {
 	}
 }
 
-func (dm *diskMetaStore) pushShardOwnershipChange(tableName string) {
+func (dm *diskMetaStore) pushShardOwnershipChange(table *common.Table) {
 	if dm.shardOwnershipWatcher != nil {
-		dm.shardOwnershipWatcher <- common.ShardOwnership{
-			TableName: tableName,
-			Shard:     0,
-			ShouldOwn: true}
-		<-dm.shardOwnershipDone
+		for _, shard := range table.GetShards() {
+			dm.shardOwnershipWatcher <- common.ShardOwnership{
+				TableName: table.Name,
+				Shard:     shard,
+				ShouldOwn: true}
+			<-dm.shardOwnershipDone
+		}
 	}
 }
 
@@ -826,9 +1357,13 @@ func (dm *diskMetaStore) listTables() ([]string, error) {
 	if err != nil {
 		return nil, utils.StackError(err, "Failed to list tables")
 	}
-	tableNames := make([]string, len(tableDirs))
-	for id, tableDir := range tableDirs {
-		tableNames[id] = tableDir.Name()
+	tableNames := make([]string, 0, len(tableDirs))
+	for _, tableDir := range tableDirs {
+		// skip cluster level files, e.g. the maintenance file.
+		if !tableDir.IsDir() {
+			continue
+		}
+		tableNames = append(tableNames, tableDir.Name())
 	}
 	return tableNames, nil
 }
@@ -837,7 +1372,12 @@ func (dm *diskMetaStore) removeTable(tableName string) error {
 	if err := dm.RemoveAll(dm.getTableDirPath(tableName)); err != nil {
 		return utils.StackError(err, "Failed to remove directory, table: %s", tableName)
 	}
+	dm.closeEnumDictWatchers(tableName)
+	return nil
+}
 
+// closeEnumDictWatchers closes the enum dict watchers of a removed table.
+func (dm *diskMetaStore) closeEnumDictWatchers(tableName string) {
 	// close all related enum dict watchers
 	// make sure all producer have done producing and detach
 	columnWatchers := dm.enumDictWatchers[tableName]
@@ -852,6 +1392,75 @@ func (dm *diskMetaStore) removeTable(tableName string) error {
 		for range doneWatchers[columnName] {
 		}
 	}
+}
+
+// tableBackup is the content of the files of a table, restored if applying schema changes fails
+// midway.
+type tableBackup struct {
+	name string
+	// whether the table existed, tables created by the failed changes are removed.
+	exists bool
+	// directories and the content of files by path.
+	dirs  []string
+	files map[string][]byte
+}
+
+// backupTable reads all files of the table into memory if it exists.
+func (dm *diskMetaStore) backupTable(tableName string, exists bool) (*tableBackup, error) {
+	backup := &tableBackup{name: tableName, exists: exists, files: make(map[string][]byte)}
+	if !exists {
+		return backup, nil
+	}
+	dirs := []string{dm.getTableDirPath(tableName)}
+	for len(dirs) > 0 {
+		dir := dirs[len(dirs)-1]
+		dirs = dirs[:len(dirs)-1]
+		backup.dirs = append(backup.dirs, dir)
+		fileInfos, err := dm.ReadDir(dir)
+		if err != nil {
+			return nil, utils.StackError(err, "Failed to back up directory %s, table: %s", dir, tableName)
+		}
+		for _, fileInfo := range fileInfos {
+			path := filepath.Join(dir, fileInfo.Name())
+			if fileInfo.IsDir() {
+				dirs = append(dirs, path)
+				continue
+			}
+			if backup.files[path], err = dm.ReadFile(path); err != nil {
+				return nil, utils.StackError(err, "Failed to back up file %s, table: %s", path, tableName)
+			}
+		}
+	}
+	return backup, nil
+}
+
+// restoreTable replaces the files of the table with the backup, or removes the table if it did not
+// exist.
+func (dm *diskMetaStore) restoreTable(backup *tableBackup) error {
+	if err := dm.RemoveAll(dm.getTableDirPath(backup.name)); err != nil {
+		return err
+	}
+	if !backup.exists {
+		return nil
+	}
+	for _, dir := range backup.dirs {
+		if err := dm.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	for path, content := range backup.files {
+		writer, err := dm.OpenFileForWrite(path, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = writer.Write(content)
+		if closeErr := writer.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -870,6 +1479,7 @@ func (dm *diskMetaStore) addColumn(table *common.Table, column common.Column, ap
 		return err
 	}
 
+	table.Version++
 	if err := dm.writeSchemaFile(table); err != nil {
 		return utils.StackError(err, "Failed to write schema file, table: %s", table.Name)
 	}
@@ -892,12 +1502,71 @@ func (dm *diskMetaStore) updateColumn(table *common.Table, columnName string, co
 			}
 			column.Config = config
 			table.Columns[id] = column
+			table.Version++
 			return dm.writeSchemaFile(table)
 		}
 	}
 	return ErrColumnDoesNotExist
 }
 
+func (dm *diskMetaStore) renameColumn(table *common.Table, columnName, newName string) error {
+	for id, column := range table.Columns {
+		if column.Name == columnName {
+			if column.Deleted {
+				// continue looking since there could be reused column name
+				// with different column id.
+				continue
+			}
+
+			if column.DerivedExpr != "" || findDerivedColumnUsingSource(table, columnName) != "" {
+				return fmt.Errorf("%s: %s", ErrRenameDerivedColumn, columnName)
+			}
+
+			if newName == columnName {
+				return nil
+			}
+
+			if err := validateColumnRename(table, id, newName); err != nil {
+				return err
+			}
+
+			column.Name = newName
+			table.Columns[id] = column
+			if table.Config.ConflictResolutionColumn == columnName {
+				table.Config.ConflictResolutionColumn = newName
+			}
+			if table.Config.JSONIngestion != nil {
+				for path, name := range table.Config.JSONIngestion.Paths {
+					if name == columnName {
+						table.Config.JSONIngestion.Paths[path] = newName
+					}
+				}
+			}
+			table.Version++
+
+			if column.IsEnumColumn() {
+				if err := dm.moveEnumColumn(table.Name, columnName, newName); err != nil {
+					return err
+				}
+			}
+			if err := dm.writeSchemaFile(table); err != nil {
+				return err
+			}
+
+			// delete predicates refer to columns by name.
+			predicates, err := dm.readDeletePredicatesFile(table.Name)
+			if err != nil {
+				return err
+			}
+			if predicates, changed := RenameDeletePredicateColumn(predicates, columnName, newName); changed {
+				return dm.writeDeletePredicatesFile(table.Name, predicates)
+			}
+			return nil
+		}
+	}
+	return ErrColumnDoesNotExist
+}
+
 func (dm *diskMetaStore) removeColumn(table *common.Table, columnName string) error {
 	for id, column := range table.Columns {
 		if column.Name == columnName {
@@ -916,8 +1585,16 @@ func (dm *diskMetaStore) removeColumn(table *common.Table, columnName string) er
 				return ErrDeletePrimaryKeyColumn
 			}
 
+			if derivedColumn := findDerivedColumnUsingSource(table, columnName); derivedColumn != "" {
+				return fmt.Errorf("%s: %s", ErrDeleteDerivedSourceColumn, derivedColumn)
+			}
+
 			column.Deleted = true
 			table.Columns[id] = column
+			if err := dm.checkDeletePredicates(table); err != nil {
+				return err
+			}
+			table.Version++
 			if err := dm.writeSchemaFile(table); err != nil {
 				return err
 			}
@@ -932,6 +1609,23 @@ func (dm *diskMetaStore) removeColumn(table *common.Table, columnName string) er
 	return ErrColumnDoesNotExist
 }
 
+// checkDeletePredicates returns ErrDeletePredicateColumn if the delete predicates of the table
+// reference columns missing or deleted from the table schema.
+func (dm *diskMetaStore) checkDeletePredicates(table *common.Table) error {
+	predicates, err := dm.readDeletePredicatesFile(table.Name)
+	if err != nil {
+		return err
+	}
+	if column := findColumnMissingFromDeletePredicates(table, predicates); column != "" {
+		return fmt.Errorf("%s: %s", ErrDeletePredicateColumn, column)
+	}
+	return nil
+}
+
+func (dm *diskMetaStore) getMaintenanceFilePath() string {
+	return filepath.Join(dm.basePath, ".maintenance")
+}
+
 func (dm *diskMetaStore) getTableDirPath(tableName string) string {
 	return filepath.Join(dm.basePath, tableName)
 }
@@ -948,6 +1642,18 @@ func (dm *diskMetaStore) getSchemaFilePath(tableName string) string {
 	return filepath.Join(dm.getTableDirPath(tableName), "schema")
 }
 
+func (dm *diskMetaStore) getDeletePredicatesFilePath(tableName string) string {
+	return filepath.Join(dm.getTableDirPath(tableName), "deletes")
+}
+
+func (dm *diskMetaStore) getSchemaVersionsDirPath(tableName string) string {
+	return filepath.Join(dm.getTableDirPath(tableName), "versions")
+}
+
+func (dm *diskMetaStore) getSchemaVersionFilePath(tableName string, version int) string {
+	return filepath.Join(dm.getSchemaVersionsDirPath(tableName), strconv.Itoa(version))
+}
+
 func (dm *diskMetaStore) getShardsDirPath(tableName string) string {
 	return filepath.Join(dm.getTableDirPath(tableName), "shards")
 }
@@ -972,6 +1678,14 @@ func (dm *diskMetaStore) getRedoLogVersionAndOffsetFilePath(tableName string, sh
 	return filepath.Join(dm.getShardDirPath(tableName, shard), "redolog-offset")
 }
 
+func (dm *diskMetaStore) getDerivedColumnProgressFilePath(tableName, columnName string, shard int) string {
+	return filepath.Join(dm.getShardDirPath(tableName, shard), "derived", columnName)
+}
+
+func (dm *diskMetaStore) getRollupProgressFilePath(tableName string, shard int) string {
+	return filepath.Join(dm.getShardDirPath(tableName, shard), "rollup")
+}
+
 func (dm *diskMetaStore) getSnapshotRedoLogVersionAndOffsetFilePath(tableName string, shard int) string {
 	return filepath.Join(dm.getShardDirPath(tableName, shard), "snapshot")
 }
@@ -1021,6 +1735,44 @@ func (dm *diskMetaStore) writeEnumFile(tableName, columnName string, enumCases [
 	return nil
 }
 
+// readDeletePredicatesFile reads the row deletion predicates of given table.
+func (dm *diskMetaStore) readDeletePredicatesFile(tableName string) ([]common.DeletePredicate, error) {
+	predicatesBytes, err := dm.ReadFile(dm.getDeletePredicatesFilePath(tableName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []common.DeletePredicate{}, nil
+		}
+		return nil, utils.StackError(err, "Failed to read delete predicates file, table: %s", tableName)
+	}
+
+	var predicates []common.DeletePredicate
+	if err = json.Unmarshal(predicatesBytes, &predicates); err != nil {
+		return nil, utils.StackError(err, "Failed to unmarshal delete predicates, table: %s", tableName)
+	}
+	return predicates, nil
+}
+
+// writeDeletePredicatesFile overwrites the row deletion predicates of given table.
+func (dm *diskMetaStore) writeDeletePredicatesFile(tableName string, predicates []common.DeletePredicate) error {
+	predicatesBytes, err := json.Marshal(predicates)
+	if err != nil {
+		return utils.StackError(err, "Failed to marshal delete predicates")
+	}
+
+	writer, err := dm.OpenFileForWrite(
+		dm.getDeletePredicatesFilePath(tableName),
+		os.O_WRONLY|os.O_TRUNC|os.O_CREATE,
+		0644,
+	)
+	if err != nil {
+		return utils.StackError(err, "Failed to open delete predicates file for write, table: %s", tableName)
+	}
+
+	defer writer.Close()
+	_, err = writer.Write(predicatesBytes)
+	return err
+}
+
 // readSchemaFile reads the schema file for given table.
 func (dm *diskMetaStore) readSchemaFile(tableName string) (*common.Table, error) {
 	jsonBytes, err := dm.ReadFile(dm.getSchemaFilePath(tableName))
@@ -1031,6 +1783,28 @@ func (dm *diskMetaStore) readSchemaFile(tableName string) (*common.Table, error)
 			tableName,
 		)
 	}
+	return unmarshalSchema(jsonBytes, tableName)
+}
+
+// readSchemaVersionFile reads the given version of the schema from version history of the table.
+func (dm *diskMetaStore) readSchemaVersionFile(tableName string, version int) (*common.Table, error) {
+	jsonBytes, err := dm.ReadFile(dm.getSchemaVersionFilePath(tableName, version))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrSchemaVersionDoesNotExist
+		}
+		return nil, utils.StackError(
+			err,
+			"Failed to read schema version file, table: %s, version: %d",
+			tableName,
+			version,
+		)
+	}
+	return unmarshalSchema(jsonBytes, tableName)
+}
+
+// unmarshalSchema unmarshals the table schema and fills in defaults for missing table configurations.
+func unmarshalSchema(jsonBytes []byte, tableName string) (*common.Table, error) {
 	var table common.Table
 	table.Config = common.TableConfig{
 		BatchSize:                DefaultBatchSize,
@@ -1047,7 +1821,7 @@ func (dm *diskMetaStore) readSchemaFile(tableName string) (*common.Table, error)
 		MaxRedoLogFileSize:       DefaultMaxRedoLogSize,
 	}
 
-	err = json.Unmarshal(jsonBytes, &table)
+	err := json.Unmarshal(jsonBytes, &table)
 	if err != nil {
 		return nil, utils.StackError(
 			err,
@@ -1081,8 +1855,71 @@ func (dm *diskMetaStore) writeSchemaFile(table *common.Table) error {
 	}
 
 	defer writer.Close()
-	_, err = writer.Write(tableSchemaBytes)
-	return err
+	if _, err = writer.Write(tableSchemaBytes); err != nil {
+		return err
+	}
+	return dm.writeSchemaVersionFile(table, tableSchemaBytes)
+}
+
+// writeSchemaVersionFile records the schema in the version history of the table
+// and purges versions beyond the latest schemaVersionsToRetain ones.
+func (dm *diskMetaStore) writeSchemaVersionFile(table *common.Table, tableSchemaBytes []byte) error {
+	versionsDir := dm.getSchemaVersionsDirPath(table.Name)
+	if err := dm.MkdirAll(versionsDir, 0755); err != nil {
+		return utils.StackError(err, "Failed to create schema versions directory, table: %s", table.Name)
+	}
+
+	writer, err := dm.OpenFileForWrite(
+		dm.getSchemaVersionFilePath(table.Name, table.Version),
+		os.O_WRONLY|os.O_TRUNC|os.O_CREATE,
+		0644,
+	)
+	if err != nil {
+		return utils.StackError(err, "Failed to open schema version file for write, table: %s, version: %d", table.Name, table.Version)
+	}
+	defer writer.Close()
+
+	if _, err = writer.Write(tableSchemaBytes); err != nil {
+		return utils.StackError(err, "Failed to write schema version file, table: %s, version: %d", table.Name, table.Version)
+	}
+
+	versions, err := dm.listSchemaVersions(table.Name)
+	if err != nil {
+		return err
+	}
+
+	if len(versions) <= schemaVersionsToRetain {
+		return nil
+	}
+
+	sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+	for _, version := range versions[schemaVersionsToRetain:] {
+		if err = dm.Remove(dm.getSchemaVersionFilePath(table.Name, version)); err != nil {
+			return utils.StackError(err, "Failed to purge schema version, table: %s, version: %d", table.Name, version)
+		}
+	}
+	return nil
+}
+
+// listSchemaVersions lists the versions in the version history of the table.
+func (dm *diskMetaStore) listSchemaVersions(tableName string) ([]int, error) {
+	versionFiles, err := dm.ReadDir(dm.getSchemaVersionsDirPath(tableName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, utils.StackError(err, "Failed to list schema versions, table: %s", tableName)
+	}
+
+	var versions []int
+	for _, versionFile := range versionFiles {
+		version, err := strconv.Atoi(versionFile.Name())
+		if err != nil {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
 }
 
 // readVersion reads the version from a given version file.
@@ -1234,6 +2071,29 @@ func (dm *diskMetaStore) writeSnapshotRedoLogVersionAndOffset(file string, redoL
 	return err
 }
 
+// moveEnumColumn moves the enum cases and enum watcher of a renamed column to its new name.
+func (dm *diskMetaStore) moveEnumColumn(tableName, columnName, newName string) error {
+	enumCases, err := dm.readEnumFile(tableName, columnName)
+	if err != nil {
+		return err
+	}
+	if err = dm.writeEnumFile(tableName, newName, enumCases); err != nil {
+		return err
+	}
+	if err = dm.Remove(dm.getEnumFilePath(tableName, columnName)); err != nil && !os.IsNotExist(err) {
+		return utils.StackError(err, "Failed to remove enum file, table: %s, column: %s", tableName, columnName)
+	}
+
+	if watchers, tableExist := dm.enumDictWatchers[tableName]; tableExist {
+		if watcher, watcherExist := watchers[columnName]; watcherExist {
+			watchers[newName], dm.enumDictDone[tableName][newName] = watcher, dm.enumDictDone[tableName][columnName]
+			delete(watchers, columnName)
+			delete(dm.enumDictDone[tableName], columnName)
+		}
+	}
+	return nil
+}
+
 // closeEnumWatcher try to close enum watcher and delete enum file
 func (dm *diskMetaStore) removeEnumColumn(tableName, columnName string) {
 	if _, tableExist := dm.enumDictWatchers[tableName]; tableExist {
@@ -1270,16 +2130,23 @@ func (dm *diskMetaStore) tableExists(tableName string) error {
 // enumColumnExists checks whether column exists and it is a enum column,
 // return ErrTableDoesNotExist, ErrColumnDoesNotExist, ErrNotEnumColumn.
 func (dm *diskMetaStore) enumColumnExists(tableName string, columnName string) error {
+	_, err := dm.getEnumColumn(tableName, columnName)
+	return err
+}
+
+// getEnumColumn returns the enum column of the table,
+// return ErrTableDoesNotExist, ErrColumnDoesNotExist, ErrNotEnumColumn.
+func (dm *diskMetaStore) getEnumColumn(tableName string, columnName string) (*common.Column, error) {
 	if err := dm.tableExists(tableName); err != nil {
-		return err
+		return nil, err
 	}
 
 	table, err := dm.readSchemaFile(tableName)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	for _, column := range table.Columns {
+	for i, column := range table.Columns {
 		if column.Name == columnName {
 			if column.Deleted {
 				// continue since column name can be reused
@@ -1288,13 +2155,13 @@ func (dm *diskMetaStore) enumColumnExists(tableName string, columnName string) e
 			}
 
 			if !column.IsEnumColumn() {
-				return ErrNotEnumColumn
+				return nil, ErrNotEnumColumn
 			}
 
-			return nil
+			return &table.Columns[i], nil
 		}
 	}
-	return ErrColumnDoesNotExist
+	return nil, ErrColumnDoesNotExist
 }
 
 // shardExists checks whether shard exists,
@@ -1331,8 +2198,13 @@ func (dm *diskMetaStore) createShard(tableName string, isFactTable bool, shard i
 
 // NewDiskMetaStore creates a new disk based metastore
 func NewDiskMetaStore(basePath string) (MetaStore, error) {
+	return newMetaStore(utils.OSFileSystem{}, basePath)
+}
+
+// newMetaStore creates a new metastore storing its files under basePath of the file system.
+func newMetaStore(fileSystem utils.FileSystem, basePath string) (MetaStore, error) {
 	metaStore := &diskMetaStore{
-		FileSystem:       utils.OSFileSystem{},
+		FileSystem:       fileSystem,
 		basePath:         basePath,
 		writeLock:        sync.Mutex{},
 		enumDictWatchers: make(map[string]map[string]chan<- string),
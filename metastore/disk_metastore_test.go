@@ -16,20 +16,28 @@ package metastore
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
 	"sync"
 
 	"github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
 	"github.com/uber/aresdb/metastore/common"
 	"github.com/uber/aresdb/testing"
+	"github.com/uber/aresdb/utils"
 	"github.com/uber/aresdb/utils/mocks"
 )
 
 var _ = ginkgo.Describe("disk metastore", func() {
 
 	mockWriterCloser := &testing.TestReadWriteCloser{}
+	mockVersionWriterCloser := &testing.TestReadWriteCloser{}
 
 	testColumn0 := common.Column{
 		Name: "column0",
@@ -121,6 +129,7 @@ var _ = ginkgo.Describe("disk metastore", func() {
 	}
 	mockTableADir := &mocks.FileInfo{}
 	mockTableADir.On("Name").Return("a")
+	mockTableADir.On("IsDir").Return(true)
 
 	mockeTableAShard0 := &mocks.FileInfo{}
 	mockeTableAShard0.On("Name").Return("0")
@@ -137,10 +146,15 @@ var _ = ginkgo.Describe("disk metastore", func() {
 
 	mockTableBDir := &mocks.FileInfo{}
 	mockTableBDir.On("Name").Return("b")
+	mockTableBDir.On("IsDir").Return(true)
 	mockeTableBShard0 := &mocks.FileInfo{}
 	mockeTableBShard0.On("Name").Return("0")
 	testTableBBytes, _ := json.MarshalIndent(testTableB, "", "  ")
 
+	mockMaintenanceFile := &mocks.FileInfo{}
+	mockMaintenanceFile.On("Name").Return(".maintenance")
+	mockMaintenanceFile.On("IsDir").Return(false)
+
 	testTableC := common.Table{
 		Name: "c",
 		Columns: []common.Column{
@@ -153,7 +167,8 @@ var _ = ginkgo.Describe("disk metastore", func() {
 	testTableCBytes, _ := json.MarshalIndent(testTableC, "", "  ")
 
 	mockFileSystem := &mocks.FileSystem{}
-	mockFileSystem.On("ReadDir", "base").Return([]os.FileInfo{mockTableADir, mockTableBDir}, nil)
+	mockFileSystem.On("ReadDir", "base").Return([]os.FileInfo{mockTableADir, mockTableBDir, mockMaintenanceFile}, nil)
+	mockFileSystem.On("ReadDir", "base/b").Return([]os.FileInfo{}, nil)
 	mockFileSystem.On("Stat", "base/a/schema").Return(&mocks.FileInfo{}, nil)
 	mockFileSystem.On("Stat", "base/b/schema").Return(&mocks.FileInfo{}, nil)
 	mockFileSystem.On("Stat", "base/c/schema").Return(&mocks.FileInfo{}, nil)
@@ -171,6 +186,8 @@ var _ = ginkgo.Describe("disk metastore", func() {
 	mockFileSystem.On("ReadFile", "base/b/shards/0/redolog-offset").Return([]byte("1,0"), nil)
 	mockFileSystem.On("ReadFile", "base/b/shards/0/snapshot").Return([]byte("1,0,-1,1"), nil)
 	mockFileSystem.On("ReadFile", "base/c/shards/0/version").Return([]byte("1"), nil)
+	mockFileSystem.On("ReadFile", "base/a/deletes").Return(nil, os.ErrNotExist)
+	mockFileSystem.On("ReadFile", "base/b/deletes").Return(nil, os.ErrNotExist)
 
 	mockFileSystem.On("OpenFileForWrite", "base/a/schema", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(0644)).Return(mockWriterCloser, nil)
 	mockFileSystem.On("OpenFileForWrite", "base/c/schema", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(0644)).Return(mockWriterCloser, nil)
@@ -186,6 +203,24 @@ var _ = ginkgo.Describe("disk metastore", func() {
 	mockFileSystem.On("MkdirAll", "base/b/shards/0", os.FileMode(0755)).Return(nil)
 	mockFileSystem.On("MkdirAll", "base/a/shards/0", os.FileMode(0755)).Return(nil)
 
+	// schema version history with one more version than retained.
+	var mockVersionFiles []os.FileInfo
+	for version := 0; version <= schemaVersionsToRetain; version++ {
+		mockVersionFile := &mocks.FileInfo{}
+		mockVersionFile.On("Name").Return(strconv.Itoa(version))
+		mockVersionFiles = append(mockVersionFiles, mockVersionFile)
+	}
+	isSchemaVersionFile := mock.MatchedBy(func(path string) bool {
+		return filepath.Base(filepath.Dir(path)) == "versions"
+	})
+	mockFileSystem.On("MkdirAll", "base/a/versions", os.FileMode(0755)).Return(nil)
+	mockFileSystem.On("MkdirAll", "base/c/versions", os.FileMode(0755)).Return(nil)
+	mockFileSystem.On("OpenFileForWrite", isSchemaVersionFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(0644)).Return(mockVersionWriterCloser, nil)
+	mockFileSystem.On("ReadDir", "base/a/versions").Return(mockVersionFiles, nil)
+	mockFileSystem.On("ReadDir", "base/c/versions").Return(mockVersionFiles, nil)
+	mockFileSystem.On("Remove", "base/a/versions/0").Return(nil)
+	mockFileSystem.On("Remove", "base/c/versions/0").Return(nil)
+
 	mockFileSystem.On("RemoveAll", "base/b").Return(nil)
 	mockFileSystem.On("Remove", "base/a/enums/column4").Return(nil)
 
@@ -202,6 +237,7 @@ var _ = ginkgo.Describe("disk metastore", func() {
 
 	ginkgo.BeforeEach(func() {
 		mockWriterCloser.Reset()
+		mockVersionWriterCloser.Reset()
 	})
 
 	ginkgo.It("ListTables", func() {
@@ -210,6 +246,7 @@ var _ = ginkgo.Describe("disk metastore", func() {
 		Ω(err).Should(BeNil())
 		Ω(tables).Should(ContainElement("a"))
 		Ω(tables).Should(ContainElement("b"))
+		Ω(tables).Should(HaveLen(2))
 	})
 
 	ginkgo.It("GetTable", func() {
@@ -221,6 +258,15 @@ var _ = ginkgo.Describe("disk metastore", func() {
 		Ω(err).Should(Equal(ErrTableDoesNotExist))
 	})
 
+	ginkgo.It("GetOwnedShards", func() {
+		diskMetaStore := createDiskMetastore("base")
+		shards, err := diskMetaStore.GetOwnedShards("a")
+		Ω(err).Should(BeNil())
+		Ω(shards).Should(Equal([]int{0}))
+		_, err = diskMetaStore.GetOwnedShards("unknown")
+		Ω(err).Should(Equal(ErrTableDoesNotExist))
+	})
+
 	ginkgo.It("GetEnumDict", func() {
 		diskMetaStore := createDiskMetastore("base")
 		enumCases, err := diskMetaStore.GetEnumDict("a", "column1")
@@ -228,6 +274,21 @@ var _ = ginkgo.Describe("disk metastore", func() {
 		Ω(enumCases).Should(Equal([]string{"foo", "bar"}))
 	})
 
+	ginkgo.It("GetDeletePredicates", func() {
+		diskMetaStore := createDiskMetastore("base")
+		mockFileSystem.On("ReadFile", "base/c/deletes").Return([]byte(`[{"id":0,"filter":"c1 = 'foo'","cutoff":100}]`), nil).Once()
+		predicates, err := diskMetaStore.GetDeletePredicates("c")
+		Ω(err).Should(BeNil())
+		Ω(predicates).Should(Equal([]common.DeletePredicate{{ID: 0, Filter: "c1 = 'foo'", Cutoff: 100}}))
+
+		predicates, err = diskMetaStore.GetDeletePredicates("b")
+		Ω(err).Should(BeNil())
+		Ω(predicates).Should(BeEmpty())
+
+		_, err = diskMetaStore.GetDeletePredicates("unknown")
+		Ω(err).Should(Equal(ErrTableDoesNotExist))
+	})
+
 	ginkgo.It("GetArchivingCutoff", func() {
 		diskMetaStore := createDiskMetastore("base")
 		archivingCutoff, err := diskMetaStore.GetArchivingCutoff("a", 0)
@@ -353,7 +414,7 @@ var _ = ginkgo.Describe("disk metastore", func() {
 		Ω(err).Should(Equal(ErrTableDoesNotExist))
 
 		err = diskMetaStore.AddColumn(testTableA.Name, testColumn1, true)
-		Ω(err).Should(Equal(ErrDuplicatedColumnName))
+		Ω(err).Should(MatchError(ErrDuplicatedColumnName.Error() + ": column1"))
 
 		err = diskMetaStore.AddColumn(testTableA.Name, testColumn2, true)
 		Ω(err).Should(BeNil())
@@ -468,6 +529,82 @@ var _ = ginkgo.Describe("disk metastore", func() {
 		Ω(enumIDs).Should(Equal([]int{2, 3}))
 	})
 
+	ginkgo.It("ExtendEnumDict should assign unique ids concurrently under max enum cardinality", func() {
+		basePath, err := ioutil.TempDir("", "metastore")
+		Ω(err).Should(BeNil())
+		defer os.RemoveAll(basePath)
+
+		diskMetaStore, err := NewDiskMetaStore(basePath)
+		Ω(err).Should(BeNil())
+		err = diskMetaStore.CreateTable(&common.Table{
+			Name: "events",
+			Columns: []common.Column{
+				{Name: "id", Type: common.Uint32},
+				{Name: "country", Type: common.SmallEnum, Config: common.ColumnConfig{MaxEnumCardinality: 10}},
+			},
+			PrimaryKeyColumns: []int{0},
+		})
+		Ω(err).Should(BeNil())
+
+		// every ingestion extends the same 6 common cases and 1 case of its own.
+		numIngestions := 8
+		enumIDs := make([][]int, numIngestions)
+		var wg sync.WaitGroup
+		for i := 0; i < numIngestions; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer ginkgo.GinkgoRecover()
+				defer wg.Done()
+				ids, err := diskMetaStore.ExtendEnumDict("events", "country",
+					[]string{"c0", "c1", "c2", "c3", "c4", "c5", "g" + strconv.Itoa(i)})
+				Ω(err).Should(BeNil())
+				enumIDs[i] = ids
+			}(i)
+		}
+		wg.Wait()
+
+		enumCases, err := diskMetaStore.GetEnumDict("events", "country")
+		Ω(err).Should(BeNil())
+		Ω(enumCases).Should(HaveLen(10))
+		Ω(enumCases[:6]).Should(Equal([]string{"c0", "c1", "c2", "c3", "c4", "c5"}))
+
+		numRejected := 0
+		for i := 0; i < numIngestions; i++ {
+			Ω(enumIDs[i][:6]).Should(Equal([]int{0, 1, 2, 3, 4, 5}))
+			if enumID := enumIDs[i][6]; enumID < 0 {
+				numRejected++
+			} else {
+				Ω(enumCases[enumID]).Should(Equal("g" + strconv.Itoa(i)))
+			}
+		}
+		Ω(numRejected).Should(Equal(4))
+	})
+
+	ginkgo.It("AddDeletePredicate", func() {
+		diskMetaStore := createDiskMetastore("base")
+		mockFileSystem.On("ReadFile", "base/c/deletes").Return([]byte(`[{"id":3,"filter":"c1 = 'foo'","cutoff":100}]`), nil).Once()
+		mockFileSystem.On("OpenFileForWrite", "base/c/deletes", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(0644)).Return(mockWriterCloser, nil).Once()
+		predicate, err := diskMetaStore.AddDeletePredicate("c", "c1 = 'bar'", 200)
+		Ω(err).Should(BeNil())
+		Ω(predicate).Should(Equal(common.DeletePredicate{ID: 4, Filter: "c1 = 'bar'", Cutoff: 200}))
+		Ω(mockWriterCloser.Bytes()).Should(Equal([]byte(`[{"id":3,"filter":"c1 = 'foo'","cutoff":100},{"id":4,"filter":"c1 = 'bar'","cutoff":200}]`)))
+
+		_, err = diskMetaStore.AddDeletePredicate("unknown", "c1 = 'bar'", 200)
+		Ω(err).Should(Equal(ErrTableDoesNotExist))
+	})
+
+	ginkgo.It("RemoveDeletePredicate", func() {
+		diskMetaStore := createDiskMetastore("base")
+		predicatesBytes := []byte(`[{"id":3,"filter":"c1 = 'foo'","cutoff":100},{"id":4,"filter":"c1 = 'bar'","cutoff":200}]`)
+		mockFileSystem.On("ReadFile", "base/c/deletes").Return(predicatesBytes, nil).Twice()
+		mockFileSystem.On("OpenFileForWrite", "base/c/deletes", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(0644)).Return(mockWriterCloser, nil).Once()
+		Ω(diskMetaStore.RemoveDeletePredicate("c", 3)).Should(BeNil())
+		Ω(mockWriterCloser.Bytes()).Should(Equal([]byte(`[{"id":4,"filter":"c1 = 'bar'","cutoff":200}]`)))
+
+		Ω(diskMetaStore.RemoveDeletePredicate("c", 5)).Should(Equal(ErrDeletePredicateDoesNotExist))
+		Ω(diskMetaStore.RemoveDeletePredicate("unknown", 3)).Should(Equal(ErrTableDoesNotExist))
+	})
+
 	ginkgo.It("AddArchiveBatchVersion: seqNum is 0", func() {
 		diskMetaStore := createDiskMetastore("base")
 		// seqNum is 0
@@ -507,6 +644,7 @@ var _ = ginkgo.Describe("disk metastore", func() {
 
 	ginkgo.It("UpdataTable", func() {
 		diskMetaStore := createDiskMetastore("base")
+		mockFileSystem.On("ReadFile", "base/c/deletes").Return(nil, os.ErrNotExist)
 
 		// should work without watchers
 		err := diskMetaStore.UpdateTable(testTableC)
@@ -531,6 +669,118 @@ var _ = ginkgo.Describe("disk metastore", func() {
 		Ω(*schemaEvent).Should(Equal(testTableC))
 	})
 
+	ginkgo.It("ApplySchemas", func() {
+		diskMetaStore := createDiskMetastore("base")
+		invalidTable := common.Table{Name: "d", IsFactTable: true}
+		numCalls := len(mockFileSystem.Calls)
+		err := diskMetaStore.ApplySchemas([]common.SchemaChange{
+			{Name: testTableC.Name, Table: &testTableC},
+			{Name: testTableB.Name},
+			{Name: invalidTable.Name, Table: &invalidTable},
+		})
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("Invalid change 2 of table d"))
+		// nothing should be written or removed.
+		for _, call := range mockFileSystem.Calls[numCalls:] {
+			Ω([]string{"ReadDir", "ReadFile", "Stat"}).Should(ContainElement(call.Method))
+		}
+		Ω(mockWriterCloser.Bytes()).Should(BeEmpty())
+
+		err = diskMetaStore.ApplySchemas([]common.SchemaChange{{Name: testTableC.Name}})
+		Ω(err.Error()).Should(ContainSubstring(ErrTableDoesNotExist.Error()))
+		err = diskMetaStore.ApplySchemas([]common.SchemaChange{{Name: invalidTable.Name, Table: &testTableC}})
+		Ω(err.Error()).Should(ContainSubstring(ErrTableNameMismatch.Error()))
+
+		tableListEvents, done, err := diskMetaStore.WatchTableListEvents()
+		Ω(err).Should(BeNil())
+		var newTables []string
+		go func() {
+			newTables = <-tableListEvents
+			done <- struct{}{}
+		}()
+		schemaEvents, done2, err := diskMetaStore.WatchTableSchemaEvents()
+		Ω(err).Should(BeNil())
+		var schemaEvent *common.Table
+		go func() {
+			schemaEvent = <-schemaEvents
+			done2 <- struct{}{}
+		}()
+		ownershipEvents, done3, err := diskMetaStore.WatchShardOwnershipEvents()
+		Ω(err).Should(BeNil())
+		go func() {
+			<-ownershipEvents
+			done3 <- struct{}{}
+		}()
+
+		err = diskMetaStore.ApplySchemas([]common.SchemaChange{
+			{Name: testTableC.Name, Table: &testTableC},
+			{Name: testTableB.Name},
+		})
+		Ω(err).Should(BeNil())
+		Ω(mockWriterCloser.Bytes()).Should(Equal(testTableCBytes))
+		// watchers should get the changes before ApplySchemas return
+		Ω(*schemaEvent).Should(Equal(testTableC))
+		Ω(newTables).Should(Equal([]string{"a", "c"}))
+	})
+
+	ginkgo.It("ApplySchemas should restore tables if applying a change fails", func() {
+		basePath, err := ioutil.TempDir("", "metastore")
+		Ω(err).Should(BeNil())
+		defer os.RemoveAll(basePath)
+
+		fileSystem := &failingFileSystem{failingPath: filepath.Join(basePath, "c", "schema")}
+		diskMetaStore, err := newMetaStore(fileSystem, basePath)
+		Ω(err).Should(BeNil())
+		tableA := &common.Table{
+			Name:              "a",
+			Columns:           []common.Column{{Name: "id", Type: common.Uint32}},
+			PrimaryKeyColumns: []int{0},
+		}
+		tableB := &common.Table{
+			Name: "b",
+			Columns: []common.Column{
+				{Name: "id", Type: common.Uint32},
+				{Name: "country", Type: common.SmallEnum},
+			},
+			PrimaryKeyColumns: []int{0},
+		}
+		Ω(diskMetaStore.CreateTable(tableA)).Should(BeNil())
+		Ω(diskMetaStore.CreateTable(tableB)).Should(BeNil())
+		_, err = diskMetaStore.ExtendEnumDict("b", "country", []string{"us"})
+		Ω(err).Should(BeNil())
+		schemaA, err := ioutil.ReadFile(filepath.Join(basePath, "a", "schema"))
+		Ω(err).Should(BeNil())
+		enumsB, err := ioutil.ReadFile(filepath.Join(basePath, "b", "enums", "country"))
+		Ω(err).Should(BeNil())
+
+		updatedTableA := *tableA
+		updatedTableA.Columns = append(updatedTableA.Columns, common.Column{Name: "fare", Type: common.Float32})
+		tableC := *tableA
+		tableC.Name = "c"
+		err = diskMetaStore.ApplySchemas([]common.SchemaChange{
+			{Name: "a", Table: &updatedTableA},
+			{Name: "b"},
+			{Name: "c", Table: &tableC},
+		})
+		Ω(err).ShouldNot(BeNil())
+
+		bytes, err := ioutil.ReadFile(filepath.Join(basePath, "a", "schema"))
+		Ω(err).Should(BeNil())
+		Ω(bytes).Should(Equal(schemaA))
+		bytes, err = ioutil.ReadFile(filepath.Join(basePath, "b", "enums", "country"))
+		Ω(err).Should(BeNil())
+		Ω(bytes).Should(Equal(enumsB))
+		_, err = os.Stat(filepath.Join(basePath, "c"))
+		Ω(os.IsNotExist(err)).Should(BeTrue())
+
+		table, err := diskMetaStore.GetTable("a")
+		Ω(err).Should(BeNil())
+		Ω(table.Columns).Should(HaveLen(1))
+		tables, err := diskMetaStore.ListTables()
+		Ω(err).Should(BeNil())
+		Ω(tables).Should(ConsistOf("a", "b"))
+	})
+
 	ginkgo.It("UpdateTableConfig", func() {
 		diskMetaStore := createDiskMetastore("base")
 		updateConfig := common.TableConfig{
@@ -552,6 +802,86 @@ var _ = ginkgo.Describe("disk metastore", func() {
 		Ω(newTable.Config).Should(Equal(updateConfig))
 	})
 
+	ginkgo.It("UpdateTableConfig should record schema version history", func() {
+		diskMetaStore := createDiskMetastore("base")
+		err := diskMetaStore.UpdateTableConfig(testTableA.Name, testTableA.Config)
+		Ω(err).Should(BeNil())
+
+		var newTable common.Table
+		err = json.Unmarshal(mockWriterCloser.Bytes(), &newTable)
+		Ω(err).Should(BeNil())
+		Ω(newTable.Version).Should(Equal(testTableA.Version + 1))
+		Ω(mockVersionWriterCloser.Bytes()).Should(Equal(mockWriterCloser.Bytes()))
+		mockFileSystem.AssertCalled(ginkgo.GinkgoT(), "OpenFileForWrite", "base/a/versions/1", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(0644))
+		// oldest version beyond retention is purged
+		mockFileSystem.AssertCalled(ginkgo.GinkgoT(), "Remove", "base/a/versions/0")
+		mockFileSystem.AssertNotCalled(ginkgo.GinkgoT(), "Remove", "base/a/versions/1")
+	})
+
+	ginkgo.It("GetSchemaVersion", func() {
+		diskMetaStore := createDiskMetastore("base")
+		testTableAv1 := testTableA
+		testTableAv1.Version = 1
+		testTableAv1Bytes, _ := json.Marshal(testTableAv1)
+		mockFileSystem.On("ReadFile", "base/a/versions/1").Return(testTableAv1Bytes, nil).Once()
+		table, err := diskMetaStore.GetSchemaVersion(testTableA.Name, 1)
+		Ω(err).Should(BeNil())
+		Ω(*table).Should(Equal(testTableAv1))
+
+		// current schema written before version history was introduced
+		mockFileSystem.On("ReadFile", "base/a/versions/0").Return(nil, os.ErrNotExist).Once()
+		table, err = diskMetaStore.GetSchemaVersion(testTableA.Name, 0)
+		Ω(err).Should(BeNil())
+		Ω(*table).Should(Equal(testTableA))
+
+		mockFileSystem.On("ReadFile", "base/a/versions/5").Return(nil, os.ErrNotExist).Once()
+		_, err = diskMetaStore.GetSchemaVersion(testTableA.Name, 5)
+		Ω(err).Should(Equal(ErrSchemaVersionDoesNotExist))
+
+		_, err = diskMetaStore.GetSchemaVersion("unknown", 1)
+		Ω(err).Should(Equal(ErrTableDoesNotExist))
+	})
+
+	ginkgo.It("RollbackSchema", func() {
+		diskMetaStore := createDiskMetastore("base")
+		err := diskMetaStore.RollbackSchema("unknown", 1)
+		Ω(err).Should(Equal(ErrTableDoesNotExist))
+
+		mockFileSystem.On("ReadFile", "base/a/versions/5").Return(nil, os.ErrNotExist).Once()
+		err = diskMetaStore.RollbackSchema(testTableA.Name, 5)
+		Ω(err).Should(Equal(ErrSchemaVersionDoesNotExist))
+
+		// version 1 does not have the deleted column5 yet.
+		testTableAv1 := testTableA
+		testTableAv1.Version = 1
+		testTableAv1.Columns = testTableA.Columns[:4]
+		testTableAv1.Config.BatchSize = 1000
+		testTableAv1Bytes, _ := json.Marshal(testTableAv1)
+		mockFileSystem.On("ReadFile", "base/a/versions/1").Return(testTableAv1Bytes, nil).Once()
+		err = diskMetaStore.RollbackSchema(testTableA.Name, 1)
+		Ω(err).Should(BeNil())
+
+		var newTable common.Table
+		err = json.Unmarshal(mockWriterCloser.Bytes(), &newTable)
+		Ω(err).Should(BeNil())
+		// the rollback continues after the latest version in the history.
+		Ω(newTable.Version).Should(Equal(schemaVersionsToRetain + 1))
+		Ω(newTable.Config.BatchSize).Should(Equal(1000))
+		mockFileSystem.AssertCalled(ginkgo.GinkgoT(), "OpenFileForWrite",
+			fmt.Sprintf("base/a/versions/%d", schemaVersionsToRetain+1), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(0644))
+		Ω(newTable.Columns).Should(Equal(testTableA.Columns))
+
+		// version 2 does not have column3 and column4 holding data.
+		testTableAv2 := testTableA
+		testTableAv2.Version = 2
+		testTableAv2.Columns = testTableA.Columns[:2]
+		testTableAv2.ArchivingSortColumns = nil
+		testTableAv2Bytes, _ := json.Marshal(testTableAv2)
+		mockFileSystem.On("ReadFile", "base/a/versions/2").Return(testTableAv2Bytes, nil).Once()
+		err = diskMetaStore.RollbackSchema(testTableA.Name, 2)
+		Ω(err).Should(MatchError(ErrRollbackDropsColumns.Error() + ": table a, version 2, columns: column3, column4"))
+	})
+
 	ginkgo.It("PurgeArchiveBatches", func() {
 		diskMetaStore := createDiskMetastore("base")
 		mockBatch1 := &mocks.FileInfo{}
@@ -573,4 +903,107 @@ var _ = ginkgo.Describe("disk metastore", func() {
 		err = diskMetaStore.PurgeArchiveBatches(testTableC.Name, 0, 0, 2)
 		Ω(err).Should(BeNil())
 	})
+
+	ginkgo.It("GetArchiveBatchIDs", func() {
+		diskMetaStore := createDiskMetastore("base")
+		mockBatch1 := &mocks.FileInfo{}
+		mockBatch2 := &mocks.FileInfo{}
+		mockBatch1.On("Name").Return("17001")
+		mockBatch2.On("Name").Return("17000")
+
+		mockFileSystem.On("ReadDir", "base/c/shards/0/batches").Return([]os.FileInfo{mockBatch1, mockBatch2}, nil).Once()
+		batchIDs, err := diskMetaStore.GetArchiveBatchIDs(testTableC.Name, 0)
+		Ω(err).Should(BeNil())
+		Ω(batchIDs).Should(Equal([]int{17000, 17001}))
+
+		mockFileSystem.On("ReadDir", "base/c/shards/0/batches").Return(nil, os.ErrNotExist).Once()
+		batchIDs, err = diskMetaStore.GetArchiveBatchIDs(testTableC.Name, 0)
+		Ω(err).Should(BeNil())
+		Ω(batchIDs).Should(BeEmpty())
+	})
+
+	ginkgo.It("GetDerivedColumnProgress", func() {
+		diskMetaStore := createDiskMetastore("base")
+		mockFileSystem.On("ReadFile", "base/c/shards/0/derived/column2").Return([]byte("17000,17010"), nil).Once()
+		lastBatchID, endBatchID, err := diskMetaStore.GetDerivedColumnProgress(testTableC.Name, "column2", 0)
+		Ω(err).Should(BeNil())
+		Ω(lastBatchID).Should(Equal(17000))
+		Ω(endBatchID).Should(Equal(17010))
+
+		mockFileSystem.On("ReadFile", "base/c/shards/0/derived/column2").Return(nil, os.ErrNotExist).Once()
+		lastBatchID, endBatchID, err = diskMetaStore.GetDerivedColumnProgress(testTableC.Name, "column2", 0)
+		Ω(err).Should(BeNil())
+		Ω(lastBatchID).Should(Equal(-1))
+		Ω(endBatchID).Should(Equal(-1))
+	})
+
+	ginkgo.It("UpdateDerivedColumnProgress", func() {
+		diskMetaStore := createDiskMetastore("base")
+		mockFileSystem.On("MkdirAll", "base/c/shards/0/derived", os.FileMode(0755)).Return(nil).Once()
+		mockFileSystem.On("OpenFileForWrite", "base/c/shards/0/derived/column2", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(0644)).Return(mockWriterCloser, nil).Once()
+		err := diskMetaStore.UpdateDerivedColumnProgress(testTableC.Name, "column2", 0, 17000, 17010)
+		Ω(err).Should(BeNil())
+		Ω(mockWriterCloser.Bytes()).Should(Equal([]byte("17000,17010")))
+
+		err = diskMetaStore.UpdateDerivedColumnProgress("b", "column2", 0, 17000, 17010)
+		Ω(err).Should(Equal(ErrNotFactTable))
+	})
+
+	ginkgo.It("GetRollupProgress", func() {
+		diskMetaStore := createDiskMetastore("base")
+		mockFileSystem.On("ReadFile", "base/c/shards/0/rollup").Return([]byte(`{"1":{"version":100,"seqNum":2}}`), nil).Once()
+		progress, err := diskMetaStore.GetRollupProgress(testTableC.Name, 0)
+		Ω(err).Should(BeNil())
+		Ω(progress).Should(Equal(map[int]common.BatchVersion{1: {Version: 100, SeqNum: 2}}))
+
+		mockFileSystem.On("ReadFile", "base/c/shards/0/rollup").Return(nil, os.ErrNotExist).Once()
+		progress, err = diskMetaStore.GetRollupProgress(testTableC.Name, 0)
+		Ω(err).Should(BeNil())
+		Ω(progress).Should(BeEmpty())
+	})
+
+	ginkgo.It("UpdateRollupProgress", func() {
+		diskMetaStore := createDiskMetastore("base")
+		mockFileSystem.On("OpenFileForWrite", "base/c/shards/0/rollup", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(0644)).Return(mockWriterCloser, nil).Once()
+		err := diskMetaStore.UpdateRollupProgress(testTableC.Name, 0, map[int]common.BatchVersion{1: {Version: 100}})
+		Ω(err).Should(BeNil())
+		Ω(mockWriterCloser.Bytes()).Should(Equal([]byte(`{"1":{"version":100,"seqNum":0}}`)))
+	})
+
+	ginkgo.It("GetMaintenance", func() {
+		diskMetaStore := createDiskMetastore("base")
+		mockFileSystem.On("ReadFile", "base/.maintenance").Return([]byte(`{"reason":"backfill","expiresAt":100}`), nil).Once()
+		maintenance, err := diskMetaStore.GetMaintenance()
+		Ω(err).Should(BeNil())
+		Ω(*maintenance).Should(Equal(common.Maintenance{Reason: "backfill", ExpiresAt: 100}))
+
+		mockFileSystem.On("ReadFile", "base/.maintenance").Return(nil, os.ErrNotExist).Once()
+		maintenance, err = diskMetaStore.GetMaintenance()
+		Ω(err).Should(BeNil())
+		Ω(maintenance).Should(BeNil())
+	})
+
+	ginkgo.It("UpdateMaintenance", func() {
+		diskMetaStore := createDiskMetastore("base")
+		mockFileSystem.On("OpenFileForWrite", "base/.maintenance", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(0644)).Return(mockWriterCloser, nil).Once()
+		err := diskMetaStore.UpdateMaintenance(&common.Maintenance{Reason: "backfill", ExpiresAt: 100})
+		Ω(err).Should(BeNil())
+		Ω(mockWriterCloser.Bytes()).Should(Equal([]byte(`{"reason":"backfill","expiresAt":100}`)))
+
+		mockFileSystem.On("Remove", "base/.maintenance").Return(os.ErrNotExist).Once()
+		Ω(diskMetaStore.UpdateMaintenance(nil)).Should(BeNil())
+	})
 })
+
+// failingFileSystem fails to open failingPath for write.
+type failingFileSystem struct {
+	utils.OSFileSystem
+	failingPath string
+}
+
+func (fs *failingFileSystem) OpenFileForWrite(name string, flag int, perm os.FileMode) (io.WriteCloser, error) {
+	if name == fs.failingPath {
+		return nil, errors.New("failed to open file")
+	}
+	return fs.OSFileSystem.OpenFileForWrite(name, flag, perm)
+}
@@ -21,6 +21,8 @@ import (
 var (
 	// ErrTableDoesNotExist indicates Table does not exist
 	ErrTableDoesNotExist = errors.New("Table does not exist")
+	// ErrTableNameMismatch indicates the table of a schema change is named differently
+	ErrTableNameMismatch = errors.New("Table name does not match schema change")
 	// ErrTableAlreadyExist indicates Table already exists
 	ErrTableAlreadyExist = errors.New("Table already exists")
 	// ErrColumnDoesNotExist indicates Column does not exist error
@@ -43,8 +45,22 @@ var (
 	ErrDeleteTimeColumn = errors.New("Time column cannot be deleted")
 	// ErrDeletePrimaryKeyColumn indicates column belongs to primary key cannot be deleted
 	ErrDeletePrimaryKeyColumn = errors.New("Primary key column cannot be deleted")
+	// ErrDeleteDerivedSourceColumn indicates column is used by a derived column and cannot be deleted
+	ErrDeleteDerivedSourceColumn = errors.New("Source column of derived column cannot be deleted")
+	// ErrDeletePredicateColumn indicates column is referenced by a delete predicate and cannot be deleted
+	ErrDeletePredicateColumn = errors.New("Column referenced by delete predicate cannot be deleted")
+	// ErrDeletePredicateDoesNotExist indicates the delete predicate does not exist
+	ErrDeletePredicateDoesNotExist = errors.New("Delete predicate does not exist")
+	// ErrRenameDerivedColumn indicates column is a derived column or used by one and cannot be renamed
+	ErrRenameDerivedColumn = errors.New("Derived column or its source column cannot be renamed")
 	// ErrChangePrimaryKeyColumn indicates primary key columns cannot be changed
 	ErrChangePrimaryKeyColumn = errors.New("Primary key column cannot be changed")
+	// ErrInvalidSharding indicates the number of shards is negative or a dimension table is sharded
+	ErrInvalidSharding = errors.New("Only fact tables can be sharded into a positive number of shards")
+	// ErrInvalidShardKeyColumn indicates the shard key column is not an integer or UUID primary key column
+	ErrInvalidShardKeyColumn = errors.New("Shard key column must be an integer or UUID primary key column")
+	// ErrChangeSharding indicates the number of shards or shard key column cannot be changed
+	ErrChangeSharding = errors.New("Number of shards and shard key column cannot be changed")
 	// ErrAllColumnsInvalid indicates all columns are invalid
 	ErrAllColumnsInvalid = errors.New("All columns are invalid")
 	// ErrMissingPrimaryKey indicates a schema does not have primary key
@@ -55,6 +71,10 @@ var (
 	ErrColumnDeleted = errors.New("Column already deleted")
 	// ErrInvalidDataType indicates invalid data type
 	ErrInvalidDataType = errors.New("Invalid data type")
+	// ErrSchemaVersionDoesNotExist indicates the schema version is not retained in version history
+	ErrSchemaVersionDoesNotExist = errors.New("Schema version does not exist")
+	// ErrRollbackDropsColumns indicates a schema rollback would drop columns holding data
+	ErrRollbackDropsColumns = errors.New("Schema rollback would drop columns holding data")
 	// ErrIllegalSchemaVersion indicates new schema is not greater than old one
 	ErrIllegalSchemaVersion = errors.New("New schema version not greater than old")
 	// ErrSchemaUpdateNotAllowed indicates changes attemped on immutable fields
@@ -70,11 +90,39 @@ var (
 	// ErrDuplicatedColumn indicates a column is used more than onces in sort or pk columns
 	ErrDuplicatedColumn = errors.New("Illegal deplicated use of column")
 	// ErrDuplicatedColumnName indicates duplicated column name in same table
-	ErrDuplicatedColumnName          = errors.New("Duplicated column name found")
+	ErrDuplicatedColumnName = errors.New("Duplicated column name found")
+	// ErrEmptyColumnName indicates a column without name
+	ErrEmptyColumnName = errors.New("Column name cannot be empty")
+	// ErrReservedColumnName indicates a column name colliding with a reserved AQL keyword
+	ErrReservedColumnName            = errors.New("Column name is a reserved keyword")
 	ErrMissingTimeColumn             = errors.New("Fact table has to have time column as first column")
 	ErrTimeColumnDoesNotAllowDefault = errors.New("Time column does not allow default value")
 	ErrDisallowMissingEventTime      = errors.New("Can not disallow missing event time")
 	// ErrTimeColumnDoesNotAllowHLLConfig indicates hll configured for time column
 	ErrTimeColumnDoesNotAllowHLLConfig   = errors.New("HLLConfig not allowed for time column")
 	ErrHLLColumnDoesNotAllowDefaultValue = errors.New("hll column does not allow default value")
+	// ErrInvalidArchiveCompression indicates unsupported compression codec for archived columns
+	ErrInvalidArchiveCompression = errors.New("Invalid archive compression codec")
+	// ErrInvalidConflictResolutionColumn indicates the conflict resolution column is missing, deleted,
+	// derived or not an uint32 column
+	ErrInvalidConflictResolutionColumn = errors.New("Invalid conflict resolution column")
+	// ErrInvalidJSONIngestionConfig indicates unknown JSON ingestion policies or paths mapped to
+	// missing, deleted or derived columns
+	ErrInvalidJSONIngestionConfig = errors.New("Invalid json ingestion config")
+
+	// ErrInvalidMaxEnumCardinality indicates max enum cardinality configured for non enum column
+	// or beyond the capacity of the enum type
+	ErrInvalidMaxEnumCardinality = errors.New("Invalid max enum cardinality")
+	// ErrInvalidNonFinitePolicy indicates unknown NaN policy or NaN policy configured for non float column
+	ErrInvalidNonFinitePolicy = errors.New("Invalid non finite policy")
+	// ErrInvalidColumnACL indicates empty tenants allowed to read or write the column
+	ErrInvalidColumnACL = errors.New("Invalid column acl")
+	// ErrInvalidDerivedColumn indicates invalid derived column config or expression
+	ErrInvalidDerivedColumn = errors.New("Invalid derived column")
+	// ErrInvalidEnumArrayColumn indicates enum array column used as primary key or sort column,
+	// or with default value, hll config or derived expression
+	ErrInvalidEnumArrayColumn = errors.New("Invalid enum array column")
+	// ErrInvalidRollupConfig indicates invalid base table, time bucket, dimensions or measures of
+	// rollup table, or primary key not matching its dimensions
+	ErrInvalidRollupConfig = errors.New("Invalid rollup config")
 )
@@ -0,0 +1,495 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metastore
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	aresCommon "github.com/uber/aresdb/common"
+	"github.com/uber/aresdb/utils"
+)
+
+const (
+	// default key prefix of the metastore in etcd.
+	defaultEtcdPrefix = "/aresdb/metastore"
+	// default timeout of etcd requests.
+	defaultEtcdTimeout = 5 * time.Second
+)
+
+var (
+	// ErrNoEtcdEndpoints indicates no etcd endpoint is configured for the etcd metastore
+	ErrNoEtcdEndpoints = errors.New("No etcd endpoints configured")
+	// errDirectoryNotEmpty is returned when removing a directory still holding keys.
+	errDirectoryNotEmpty = errors.New("directory not empty")
+)
+
+// etcdKeyValue is a key value pair in the requests and responses of the etcd v3 JSON gateway,
+// where bytes are encoded in base64.
+type etcdKeyValue struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value,omitempty"`
+}
+
+type etcdRangeRequest struct {
+	Key      []byte `json:"key"`
+	RangeEnd []byte `json:"range_end,omitempty"`
+	KeysOnly bool   `json:"keys_only,omitempty"`
+}
+
+type etcdRangeResponse struct {
+	Kvs []etcdKeyValue `json:"kvs"`
+}
+
+type etcdDeleteRangeRequest struct {
+	Key      []byte `json:"key"`
+	RangeEnd []byte `json:"range_end,omitempty"`
+}
+
+type etcdTxnRequest struct {
+	Success []etcdRequestOp `json:"success"`
+}
+
+type etcdRequestOp struct {
+	RequestDeleteRange *etcdDeleteRangeRequest `json:"request_delete_range,omitempty"`
+}
+
+type etcdAuthenticateRequest struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+type etcdAuthenticateResponse struct {
+	Token string `json:"token"`
+}
+
+// etcdFileSystem implements utils.FileSystem on the keys of an etcd cluster through the JSON
+// gateway of the etcd v3 API, so that the metastore keeps the same layout as on local disk.
+// Files are keys holding their content, directories are marker keys ending with a slash and
+// exist as long as they have a marker or any key under them.
+type etcdFileSystem struct {
+	client    *http.Client
+	endpoints []string
+
+	// credentials to authenticate with if auth is enabled in etcd.
+	username string
+	password string
+	// auth token of the last authentication.
+	tokenLock sync.Mutex
+	token     string
+}
+
+// call sends the request to the first reachable endpoint and decodes its response.
+func (fs *etcdFileSystem) call(method string, request, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return utils.StackError(err, "Failed to marshal etcd %s request", method)
+	}
+
+	var lastErr error
+	for _, endpoint := range fs.endpoints {
+		var statusCode int
+		var respBody []byte
+		statusCode, respBody, lastErr = fs.post(endpoint, "/v3/kv/"+method, body, true)
+		if lastErr != nil {
+			continue
+		}
+		// the token expired or was never acquired.
+		if statusCode == http.StatusUnauthorized && fs.username != "" {
+			if err = fs.authenticate(endpoint); err != nil {
+				return err
+			}
+			if statusCode, respBody, err = fs.post(endpoint, "/v3/kv/"+method, body, true); err != nil {
+				return utils.StackError(err, "Failed to send etcd %s request to %s", method, endpoint)
+			}
+		}
+		if statusCode != http.StatusOK {
+			return utils.StackError(nil, "Etcd %s request to %s failed with status %d: %s",
+				method, endpoint, statusCode, respBody)
+		}
+		if response == nil {
+			return nil
+		}
+		if err = json.Unmarshal(respBody, response); err != nil {
+			return utils.StackError(err, "Failed to unmarshal etcd %s response from %s", method, endpoint)
+		}
+		return nil
+	}
+	return utils.StackError(lastErr, "Failed to reach etcd endpoints %v", fs.endpoints)
+}
+
+// post posts the body to the path of the endpoint, with the auth token if withToken is set.
+func (fs *etcdFileSystem) post(endpoint, path string, body []byte, withToken bool) (int, []byte, error) {
+	req, err := http.NewRequest(http.MethodPost, endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if withToken {
+		fs.tokenLock.Lock()
+		token := fs.token
+		fs.tokenLock.Unlock()
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+	}
+	resp, err := fs.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, respBody, nil
+}
+
+// authenticate acquires a new auth token from the endpoint with the configured credentials.
+func (fs *etcdFileSystem) authenticate(endpoint string) error {
+	body, err := json.Marshal(etcdAuthenticateRequest{Name: fs.username, Password: fs.password})
+	if err != nil {
+		return utils.StackError(err, "Failed to marshal etcd authenticate request")
+	}
+	statusCode, respBody, err := fs.post(endpoint, "/v3/auth/authenticate", body, false)
+	if err != nil {
+		return utils.StackError(err, "Failed to authenticate with etcd %s", endpoint)
+	}
+	if statusCode != http.StatusOK {
+		return utils.StackError(nil, "Etcd authentication with %s failed with status %d: %s",
+			endpoint, statusCode, respBody)
+	}
+	var response etcdAuthenticateResponse
+	if err = json.Unmarshal(respBody, &response); err != nil {
+		return utils.StackError(err, "Failed to unmarshal etcd authenticate response from %s", endpoint)
+	}
+	fs.tokenLock.Lock()
+	fs.token = response.Token
+	fs.tokenLock.Unlock()
+	return nil
+}
+
+func (fs *etcdFileSystem) get(key string) ([]byte, bool, error) {
+	var response etcdRangeResponse
+	if err := fs.call("range", etcdRangeRequest{Key: []byte(key)}, &response); err != nil {
+		return nil, false, err
+	}
+	if len(response.Kvs) == 0 {
+		return nil, false, nil
+	}
+	return response.Kvs[0].Value, true, nil
+}
+
+// list returns the keys with the prefix in ascending order.
+func (fs *etcdFileSystem) list(prefix string) ([]string, error) {
+	var response etcdRangeResponse
+	request := etcdRangeRequest{Key: []byte(prefix), RangeEnd: etcdPrefixEnd(prefix), KeysOnly: true}
+	if err := fs.call("range", request, &response); err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(response.Kvs))
+	for i, kv := range response.Kvs {
+		keys[i] = string(kv.Key)
+	}
+	return keys, nil
+}
+
+func (fs *etcdFileSystem) put(key string, value []byte) error {
+	return fs.call("put", etcdKeyValue{Key: []byte(key), Value: value}, nil)
+}
+
+func (fs *etcdFileSystem) deleteRange(key string, rangeEnd []byte) error {
+	return fs.call("deleterange", etcdDeleteRangeRequest{Key: []byte(key), RangeEnd: rangeEnd}, nil)
+}
+
+// ReadFile reads the value of the key.
+func (fs *etcdFileSystem) ReadFile(name string) ([]byte, error) {
+	name = filepath.Clean(name)
+	value, found, err := fs.get(name)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return value, nil
+}
+
+// ReadDir lists the files and directories right under the directory sorted by name.
+func (fs *etcdFileSystem) ReadDir(dirname string) ([]os.FileInfo, error) {
+	dirname = filepath.Clean(dirname)
+	prefix := dirname + "/"
+	keys, err := fs.list(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, &os.PathError{Op: "open", Path: dirname, Err: os.ErrNotExist}
+	}
+
+	infos := make(map[string]*etcdFileInfo)
+	for _, key := range keys {
+		rest := key[len(prefix):]
+		if rest == "" {
+			// marker of the directory itself.
+			continue
+		}
+		name := rest
+		isDir := false
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			name, isDir = rest[:i], true
+		}
+		if info := infos[name]; info == nil || isDir {
+			infos[name] = &etcdFileInfo{name: name, dir: isDir}
+		}
+	}
+
+	fileInfos := make([]os.FileInfo, 0, len(infos))
+	for _, info := range infos {
+		fileInfos = append(fileInfos, info)
+	}
+	sort.Slice(fileInfos, func(i, j int) bool {
+		return fileInfos[i].Name() < fileInfos[j].Name()
+	})
+	return fileInfos, nil
+}
+
+// Stat returns the info of the file or directory.
+func (fs *etcdFileSystem) Stat(path string) (os.FileInfo, error) {
+	path = filepath.Clean(path)
+	value, found, err := fs.get(path)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return &etcdFileInfo{name: filepath.Base(path), size: int64(len(value))}, nil
+	}
+
+	keys, err := fs.list(path + "/")
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, &os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist}
+	}
+	return &etcdFileInfo{name: filepath.Base(path), dir: true}, nil
+}
+
+// Mkdir creates the marker of the directory.
+func (fs *etcdFileSystem) Mkdir(name string, perm os.FileMode) error {
+	name = filepath.Clean(name)
+	if _, err := fs.Stat(name); err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return fs.put(name+"/", nil)
+}
+
+// MkdirAll creates the marker of the directory, parent directories exist implicitly.
+func (fs *etcdFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	return fs.put(filepath.Clean(path)+"/", nil)
+}
+
+// Remove deletes the file or the empty directory.
+func (fs *etcdFileSystem) Remove(path string) error {
+	path = filepath.Clean(path)
+	info, err := fs.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fs.deleteRange(path, nil)
+	}
+
+	keys, err := fs.list(path + "/")
+	if err != nil {
+		return err
+	}
+	if len(keys) > 1 || keys[0] != path+"/" {
+		return &os.PathError{Op: "remove", Path: path, Err: errDirectoryNotEmpty}
+	}
+	return fs.deleteRange(keys[0], nil)
+}
+
+// RemoveAll deletes the file or the directory with everything under it in a single transaction.
+func (fs *etcdFileSystem) RemoveAll(path string) error {
+	path = filepath.Clean(path)
+	return fs.call("txn", etcdTxnRequest{Success: []etcdRequestOp{
+		{RequestDeleteRange: &etcdDeleteRangeRequest{Key: []byte(path)}},
+		{RequestDeleteRange: &etcdDeleteRangeRequest{Key: []byte(path + "/"), RangeEnd: etcdPrefixEnd(path + "/")}},
+	}}, nil)
+}
+
+// OpenFileForWrite opens the key for write. Writes are buffered and the content is put into etcd
+// with a single request on Close, which returns the failure of the put.
+func (fs *etcdFileSystem) OpenFileForWrite(name string, flag int, perm os.FileMode) (io.WriteCloser, error) {
+	name = filepath.Clean(name)
+	var content []byte
+	if flag&os.O_TRUNC == 0 {
+		value, found, err := fs.get(name)
+		if err != nil {
+			return nil, err
+		}
+		if !found && flag&os.O_CREATE == 0 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		if flag&os.O_APPEND != 0 {
+			content = value
+		}
+	}
+	return &etcdFileWriter{fs: fs, key: name, content: content}, nil
+}
+
+// etcdFileWriter buffers the content of a key until Close.
+type etcdFileWriter struct {
+	fs      *etcdFileSystem
+	key     string
+	content []byte
+	closed  bool
+}
+
+// Write appends to the buffered content of the key.
+func (w *etcdFileWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+	w.content = append(w.content, p...)
+	return len(p), nil
+}
+
+// Close puts the buffered content into etcd.
+func (w *etcdFileWriter) Close() error {
+	if w.closed {
+		return os.ErrClosed
+	}
+	w.closed = true
+	return w.fs.put(w.key, w.content)
+}
+
+// etcdFileInfo is the os.FileInfo of a key.
+type etcdFileInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (i *etcdFileInfo) Name() string       { return i.name }
+func (i *etcdFileInfo) Size() int64        { return i.size }
+func (i *etcdFileInfo) ModTime() time.Time { return time.Time{} }
+func (i *etcdFileInfo) IsDir() bool        { return i.dir }
+func (i *etcdFileInfo) Sys() interface{}   { return nil }
+
+func (i *etcdFileInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// etcdPrefixEnd returns the range end covering all keys with the prefix.
+func etcdPrefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// all keys after the prefix.
+	return []byte{0}
+}
+
+// NewEtcdMetaStore creates a new metastore storing its data in etcd under the configured prefix.
+func NewEtcdMetaStore(cfg aresCommon.EtcdConfig) (MetaStore, error) {
+	if len(cfg.Endpoints) == 0 {
+		return nil, ErrNoEtcdEndpoints
+	}
+	endpoints := make([]string, len(cfg.Endpoints))
+	for i, endpoint := range cfg.Endpoints {
+		endpoint = strings.TrimSuffix(endpoint, "/")
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "http://" + endpoint
+		}
+		endpoints[i] = endpoint
+	}
+
+	timeout := defaultEtcdTimeout
+	if cfg.TimeoutInSeconds > 0 {
+		timeout = time.Duration(cfg.TimeoutInSeconds) * time.Second
+	}
+	prefix := cfg.Prefix
+	if prefix == "" {
+		prefix = defaultEtcdPrefix
+	}
+
+	client, err := newEtcdHTTPClient(cfg, timeout)
+	if err != nil {
+		return nil, err
+	}
+	fs := &etcdFileSystem{
+		client:    client,
+		endpoints: endpoints,
+		username:  cfg.Username,
+		password:  cfg.Password,
+	}
+	metaStore, err := newMetaStore(fs, filepath.Clean(prefix))
+	if err != nil {
+		return nil, utils.StackError(err, "Failed to create etcd metastore on %v", endpoints)
+	}
+	return metaStore, nil
+}
+
+// newEtcdHTTPClient creates the http client of the etcd gateway, with the configured CA and client
+// certificate for https endpoints.
+func newEtcdHTTPClient(cfg aresCommon.EtcdConfig, timeout time.Duration) (*http.Client, error) {
+	if cfg.CAFile == "" && cfg.CertFile == "" {
+		return &http.Client{Timeout: timeout}, nil
+	}
+	tlsConfig := &tls.Config{}
+	if cfg.CAFile != "" {
+		caCert, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, utils.StackError(err, "Failed to read etcd CA file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+			return nil, utils.StackError(nil, "No certificate found in etcd CA file %s", cfg.CAFile)
+		}
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, utils.StackError(err, "Failed to load etcd client certificate %s", cfg.CertFile)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}, nil
+}
@@ -0,0 +1,261 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metastore
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	aresCommon "github.com/uber/aresdb/common"
+)
+
+// fakeEtcd serves the range, put, deleterange, txn and authenticate methods of the etcd v3 JSON
+// gateway from memory. Requests need the token of the last authentication if username is set.
+type fakeEtcd struct {
+	sync.Mutex
+	kvs      map[string][]byte
+	puts     int
+	username string
+	password string
+	token    string
+}
+
+// newFakeEtcdServer starts a fake etcd without auth.
+func newFakeEtcdServer() *httptest.Server {
+	return httptest.NewServer((&fakeEtcd{}).handler())
+}
+
+// keys returns the keys in [key, rangeEnd) in ascending order, or key itself if rangeEnd is empty.
+func (f *fakeEtcd) keys(key, rangeEnd []byte) []string {
+	var matched []string
+	for k := range f.kvs {
+		if len(rangeEnd) == 0 && k == string(key) ||
+			len(rangeEnd) > 0 && k >= string(key) && k < string(rangeEnd) {
+			matched = append(matched, k)
+		}
+	}
+	sort.Strings(matched)
+	return matched
+}
+
+func (f *fakeEtcd) deleteRange(request etcdDeleteRangeRequest) {
+	for _, k := range f.keys(request.Key, request.RangeEnd) {
+		delete(f.kvs, k)
+	}
+}
+
+func (f *fakeEtcd) handler() http.Handler {
+	f.kvs = make(map[string][]byte)
+	mux := http.NewServeMux()
+	// handle decodes the request and serves it under the lock if the request is authorized.
+	handle := func(path string, request func() interface{}, serve func(request interface{}) interface{}) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			req := request()
+			Ω(json.NewDecoder(r.Body).Decode(req)).Should(BeNil())
+			f.Lock()
+			defer f.Unlock()
+			if f.username != "" && path != "/v3/auth/authenticate" &&
+				(f.token == "" || r.Header.Get("Authorization") != f.token) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(serve(req))
+		})
+	}
+
+	handle("/v3/kv/range", func() interface{} { return &etcdRangeRequest{} }, func(req interface{}) interface{} {
+		request := req.(*etcdRangeRequest)
+		var response etcdRangeResponse
+		for _, k := range f.keys(request.Key, request.RangeEnd) {
+			kv := etcdKeyValue{Key: []byte(k)}
+			if !request.KeysOnly {
+				kv.Value = f.kvs[k]
+			}
+			response.Kvs = append(response.Kvs, kv)
+		}
+		return response
+	})
+	handle("/v3/kv/put", func() interface{} { return &etcdKeyValue{} }, func(req interface{}) interface{} {
+		request := req.(*etcdKeyValue)
+		f.kvs[string(request.Key)] = request.Value
+		f.puts++
+		return struct{}{}
+	})
+	handle("/v3/kv/deleterange", func() interface{} { return &etcdDeleteRangeRequest{} }, func(req interface{}) interface{} {
+		f.deleteRange(*req.(*etcdDeleteRangeRequest))
+		return struct{}{}
+	})
+	handle("/v3/kv/txn", func() interface{} { return &etcdTxnRequest{} }, func(req interface{}) interface{} {
+		for _, op := range req.(*etcdTxnRequest).Success {
+			Ω(op.RequestDeleteRange).ShouldNot(BeNil())
+			f.deleteRange(*op.RequestDeleteRange)
+		}
+		return map[string]bool{"succeeded": true}
+	})
+	handle("/v3/auth/authenticate", func() interface{} { return &etcdAuthenticateRequest{} }, func(req interface{}) interface{} {
+		request := req.(*etcdAuthenticateRequest)
+		Ω(request.Name).Should(Equal(f.username))
+		Ω(request.Password).Should(Equal(f.password))
+		f.token = fmt.Sprintf("token-%d", len(f.token)+1)
+		return etcdAuthenticateResponse{Token: f.token}
+	})
+	return mux
+}
+
+var _ = ginkgo.Describe("etcd metastore", func() {
+	var server *httptest.Server
+	var etcd *fakeEtcd
+	var fs *etcdFileSystem
+
+	ginkgo.BeforeEach(func() {
+		etcd = &fakeEtcd{}
+		server = httptest.NewServer(etcd.handler())
+		// the first endpoint is unreachable.
+		fs = &etcdFileSystem{
+			client:    http.DefaultClient,
+			endpoints: []string{"http://127.0.0.1:1", server.URL},
+		}
+	})
+
+	ginkgo.AfterEach(func() {
+		server.Close()
+	})
+
+	ginkgo.It("stores files and directories as keys", func() {
+		Ω(fs.MkdirAll("/base/a/0", 0755)).Should(BeNil())
+		writer, err := fs.OpenFileForWrite("/base/a/schema", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+		Ω(err).Should(BeNil())
+		_, err = writer.Write([]byte("{}"))
+		Ω(err).Should(BeNil())
+		Ω(writer.Close()).Should(BeNil())
+
+		writer, err = fs.OpenFileForWrite("/base/a/0/versions", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		Ω(err).Should(BeNil())
+		writer.Write([]byte("1,10\n"))
+		Ω(writer.Close()).Should(BeNil())
+		writer, err = fs.OpenFileForWrite("/base/a/0/versions", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		Ω(err).Should(BeNil())
+		writer.Write([]byte("2,20\n"))
+		Ω(writer.Close()).Should(BeNil())
+		content, err := fs.ReadFile("/base/a/0/versions")
+		Ω(err).Should(BeNil())
+		Ω(string(content)).Should(Equal("1,10\n2,20\n"))
+
+		infos, err := fs.ReadDir("/base/a")
+		Ω(err).Should(BeNil())
+		Ω(infos).Should(HaveLen(2))
+		Ω(infos[0].Name()).Should(Equal("0"))
+		Ω(infos[0].IsDir()).Should(BeTrue())
+		Ω(infos[1].Name()).Should(Equal("schema"))
+		Ω(infos[1].IsDir()).Should(BeFalse())
+
+		info, err := fs.Stat("/base/a/schema")
+		Ω(err).Should(BeNil())
+		Ω(info.Size()).Should(Equal(int64(2)))
+		_, err = fs.Stat("/base/b")
+		Ω(os.IsNotExist(err)).Should(BeTrue())
+		_, err = fs.ReadFile("/base/a/enums")
+		Ω(os.IsNotExist(err)).Should(BeTrue())
+		Ω(os.IsExist(fs.Mkdir("/base/a", 0755))).Should(BeTrue())
+
+		Ω(fs.Remove("/base/a")).ShouldNot(BeNil())
+		Ω(fs.Remove("/base/a/schema")).Should(BeNil())
+		Ω(os.IsNotExist(fs.Remove("/base/a/schema"))).Should(BeTrue())
+		Ω(fs.RemoveAll("/base/a")).Should(BeNil())
+		_, err = fs.ReadDir("/base")
+		Ω(os.IsNotExist(err)).Should(BeTrue())
+	})
+
+	ginkgo.It("puts buffered writes once on close", func() {
+		writer, err := fs.OpenFileForWrite("/base/a/schema", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+		Ω(err).Should(BeNil())
+		writer.Write([]byte("{"))
+		writer.Write([]byte("}"))
+		_, err = fs.ReadFile("/base/a/schema")
+		Ω(os.IsNotExist(err)).Should(BeTrue())
+		Ω(writer.Close()).Should(BeNil())
+		Ω(etcd.puts).Should(Equal(1))
+		content, err := fs.ReadFile("/base/a/schema")
+		Ω(err).Should(BeNil())
+		Ω(string(content)).Should(Equal("{}"))
+
+		_, err = writer.Write([]byte("{}"))
+		Ω(err).Should(Equal(os.ErrClosed))
+
+		// put failures are returned by close.
+		writer, err = fs.OpenFileForWrite("/base/a/schema", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+		Ω(err).Should(BeNil())
+		fs.endpoints = fs.endpoints[:1]
+		Ω(writer.Close()).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("connects with tls and authenticates", func() {
+		etcd = &fakeEtcd{username: "root", password: "secret"}
+		tlsServer := httptest.NewTLSServer(etcd.handler())
+		defer tlsServer.Close()
+
+		caFile, err := ioutil.TempFile("", "etcd_ca")
+		Ω(err).Should(BeNil())
+		defer os.Remove(caFile.Name())
+		pem.Encode(caFile, &pem.Block{Type: "CERTIFICATE", Bytes: tlsServer.Certificate().Raw})
+		caFile.Close()
+
+		cfg := aresCommon.EtcdConfig{Endpoints: []string{tlsServer.URL}, Username: "root", Password: "secret"}
+		// the server certificate is not trusted without the CA file.
+		_, err = NewEtcdMetaStore(cfg)
+		Ω(err).ShouldNot(BeNil())
+
+		cfg.CAFile = caFile.Name()
+		metaStore, err := NewEtcdMetaStore(cfg)
+		Ω(err).Should(BeNil())
+		_, err = metaStore.ListTables()
+		Ω(err).Should(BeNil())
+		Ω(etcd.token).Should(Equal("token-1"))
+
+		// expired tokens are renewed.
+		etcd.Lock()
+		etcd.token = "expired"
+		etcd.Unlock()
+		_, err = metaStore.ListTables()
+		Ω(err).Should(BeNil())
+		Ω(etcd.token).ShouldNot(Equal("expired"))
+
+		cfg.CAFile = "/nonexistent/ca"
+		_, err = NewEtcdMetaStore(cfg)
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("fails without reachable endpoints", func() {
+		_, err := NewEtcdMetaStore(aresCommon.EtcdConfig{})
+		Ω(err).Should(Equal(ErrNoEtcdEndpoints))
+
+		fs.endpoints = fs.endpoints[:1]
+		_, err = fs.ReadFile("/base/a/schema")
+		Ω(err).ShouldNot(BeNil())
+		Ω(os.IsNotExist(err)).Should(BeFalse())
+
+		_, err = NewMetaStore(aresCommon.MetaStoreConfig{Backend: "zk"}, "")
+		Ω(err).ShouldNot(BeNil())
+	})
+})
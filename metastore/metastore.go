@@ -15,11 +15,13 @@
 package metastore
 
 import (
+	aresCommon "github.com/uber/aresdb/common"
 	"github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/utils"
 )
 
 // MetaStore defines interfaces of the external metastore,
-// which can be implemented using file system, SQLite, Zookeeper etc.
+// which can be implemented using file system, etcd, SQLite, Zookeeper etc.
 type MetaStore interface {
 	GetEnumDict(table, column string) ([]string, error)
 
@@ -39,6 +41,15 @@ type MetaStore interface {
 	// Returns the version to use for the specified archive batch and size of the batch with the
 	// specified archiving/live cutoff.
 	GetArchiveBatchVersion(table string, shard, batchID int, cutoff uint32) (uint32, uint32, int, error)
+	// Returns the ids of archive batches of the specified shard in ascending order.
+	GetArchiveBatchIDs(table string, shard int) ([]int, error)
+	// Returns the progress of backfilling the derived column for the specified shard.
+	// the return value is: last backfilled archive batch id, last archive batch id to backfill,
+	// both are -1 if the backfill has not started.
+	GetDerivedColumnProgress(table, column string, shard int) (int, int, error)
+	// Returns the versions of the base table archive batches aggregated into the specified
+	// rollup table shard, by batch id.
+	GetRollupProgress(table string, shard int) (map[int]common.BatchVersion, error)
 	// Returns the latest snapshot version for the specified shard.
 	// the return value is: redoLogFile, offset, lastReadBatchID, lastReadBatchOffset
 	GetSnapshotProgress(table string, shard int) (int64, uint32, int32, uint32, error)
@@ -55,7 +66,8 @@ type MetaStore interface {
 	// A subset of newly added columns can be appended to the end of
 	// ArchivingSortColumns by adding their index in columns to archivingSortColumns
 	// Update column config.
-	// Returns the assigned case IDs for each case string.
+	// Returns the assigned case IDs for each case string, -1 for new cases beyond
+	// the max enum cardinality of the column.
 	ExtendEnumDict(table, column string, enumCases []string) ([]int, error)
 
 	// Adds a version and size for the specified archive batch.
@@ -74,14 +86,44 @@ type MetaStore interface {
 	// Retrieve the latest redolog/offset that have been backfilled for the specified shard.
 	GetBackfillProgressInfo(table string, shard int) (int64, uint32, error)
 
+	// Updates the last archive batch backfilled and the last archive batch to backfill
+	// for the derived column of the specified shard.
+	UpdateDerivedColumnProgress(table, column string, shard, lastBatchID, endBatchID int) error
+
+	// Overwrites the versions of the base table archive batches aggregated into the specified
+	// rollup table shard.
+	UpdateRollupProgress(table string, shard int, progress map[int]common.BatchVersion) error
+
+	// Returns the row deletion predicates of the specified table.
+	GetDeletePredicates(table string) ([]common.DeletePredicate, error)
+
+	// Appends a row deletion predicate to the specified table and returns it with its assigned id.
+	// Rows matching the filter with event time before the cutoff are excluded from query results.
+	AddDeletePredicate(table string, filter string, cutoff uint32) (common.DeletePredicate, error)
+
+	// Removes the row deletion predicate of the specified table, rows it deleted are no longer
+	// excluded from query results.
+	RemoveDeletePredicate(table string, id int) error
+
 	TableSchemaWatchable
 	TableSchemaMutator
+	MaintenanceMutator
+}
+
+// MaintenanceMutator mutates the cluster level maintenance
+type MaintenanceMutator interface {
+	// Returns the maintenance of the cluster, nil if not set.
+	GetMaintenance() (*common.Maintenance, error)
+	// Overwrites the maintenance of the cluster, nil clears it.
+	UpdateMaintenance(maintenance *common.Maintenance) error
 }
 
 // TableSchemaReader reads table schema
 type TableSchemaReader interface {
 	ListTables() ([]string, error)
 	GetTable(name string) (*common.Table, error)
+	// Returns the specified version of the table schema if it is retained in version history.
+	GetSchemaVersion(name string, version int) (*common.Table, error)
 }
 
 // TableSchemaWatchable watches table schema update events
@@ -112,4 +154,24 @@ type TableSchemaMutator interface {
 	// Update column config.
 	UpdateColumn(table string, column string, config common.ColumnConfig) error
 	DeleteColumn(table string, column string) error
+	// Renames column without rewriting its data, which stays keyed by column id.
+	RenameColumn(table string, column string, newName string) error
+	// Rolls back table schema to the specified version from version history.
+	// Rejected if columns added since that version still hold data.
+	RollbackSchema(table string, version int) error
+	// Validates all changes and applies them together, nothing is applied
+	// if any change is invalid.
+	ApplySchemas(changes []common.SchemaChange) error
+}
+
+// NewMetaStore creates the metastore of the configured backend, the disk backend stores the
+// metastore under basePath.
+func NewMetaStore(cfg aresCommon.MetaStoreConfig, basePath string) (MetaStore, error) {
+	switch cfg.Backend {
+	case "", aresCommon.MetaStoreBackendDisk:
+		return NewDiskMetaStore(basePath)
+	case aresCommon.MetaStoreBackendEtcd:
+		return NewEtcdMetaStore(cfg.Etcd)
+	}
+	return nil, utils.StackError(nil, "Unknown metastore backend %s", cfg.Backend)
 }
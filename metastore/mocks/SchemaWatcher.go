@@ -0,0 +1,66 @@
+// Code generated by mockery v1.0.0
+package mocks
+
+import common "github.com/uber/aresdb/metastore/common"
+
+import mock "github.com/stretchr/testify/mock"
+
+// SchemaWatcher is an autogenerated mock type for the SchemaWatcher type
+type SchemaWatcher struct {
+	mock.Mock
+}
+
+// GetTable provides a mock function with given fields: name
+func (_m *SchemaWatcher) GetTable(name string) (*common.Table, error) {
+	ret := _m.Called(name)
+
+	var r0 *common.Table
+	if rf, ok := ret.Get(0).(func(string) *common.Table); ok {
+		r0 = rf(name)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*common.Table)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(name)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Watch provides a mock function with given fields:
+func (_m *SchemaWatcher) Watch() (map[string]int32, <-chan struct{}, error) {
+	ret := _m.Called()
+
+	var r0 map[string]int32
+	if rf, ok := ret.Get(0).(func() map[string]int32); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(map[string]int32)
+		}
+	}
+
+	var r1 <-chan struct{}
+	if rf, ok := ret.Get(1).(func() <-chan struct{}); ok {
+		r1 = rf()
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(<-chan struct{})
+		}
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func() error); ok {
+		r2 = rf()
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
@@ -24,6 +24,20 @@ func (_m *TableSchemaMutator) AddColumn(table string, column common.Column, appe
 	return r0
 }
 
+// ApplySchemas provides a mock function with given fields: changes
+func (_m *TableSchemaMutator) ApplySchemas(changes []common.SchemaChange) error {
+	ret := _m.Called(changes)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func([]common.SchemaChange) error); ok {
+		r0 = rf(changes)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // CreateTable provides a mock function with given fields: table
 func (_m *TableSchemaMutator) CreateTable(table *common.Table) error {
 	ret := _m.Called(table)
@@ -66,6 +80,29 @@ func (_m *TableSchemaMutator) DeleteTable(name string) error {
 	return r0
 }
 
+// GetSchemaVersion provides a mock function with given fields: name, version
+func (_m *TableSchemaMutator) GetSchemaVersion(name string, version int) (*common.Table, error) {
+	ret := _m.Called(name, version)
+
+	var r0 *common.Table
+	if rf, ok := ret.Get(0).(func(string, int) *common.Table); ok {
+		r0 = rf(name, version)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*common.Table)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, int) error); ok {
+		r1 = rf(name, version)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetTable provides a mock function with given fields: name
 func (_m *TableSchemaMutator) GetTable(name string) (*common.Table, error) {
 	ret := _m.Called(name)
@@ -112,6 +149,34 @@ func (_m *TableSchemaMutator) ListTables() ([]string, error) {
 	return r0, r1
 }
 
+// RenameColumn provides a mock function with given fields: table, column, newName
+func (_m *TableSchemaMutator) RenameColumn(table string, column string, newName string) error {
+	ret := _m.Called(table, column, newName)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string, string) error); ok {
+		r0 = rf(table, column, newName)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RollbackSchema provides a mock function with given fields: table, version
+func (_m *TableSchemaMutator) RollbackSchema(table string, version int) error {
+	ret := _m.Called(table, version)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, int) error); ok {
+		r0 = rf(table, version)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // UpdateColumn provides a mock function with given fields: table, column, config
 func (_m *TableSchemaMutator) UpdateColumn(table string, column string, config common.ColumnConfig) error {
 	ret := _m.Called(table, column, config)
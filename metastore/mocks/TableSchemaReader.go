@@ -10,6 +10,29 @@ type TableSchemaReader struct {
 	mock.Mock
 }
 
+// GetSchemaVersion provides a mock function with given fields: name, version
+func (_m *TableSchemaReader) GetSchemaVersion(name string, version int) (*common.Table, error) {
+	ret := _m.Called(name, version)
+
+	var r0 *common.Table
+	if rf, ok := ret.Get(0).(func(string, int) *common.Table); ok {
+		r0 = rf(name, version)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*common.Table)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, int) error); ok {
+		r1 = rf(name, version)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetTable provides a mock function with given fields: name
 func (_m *TableSchemaReader) GetTable(name string) (*common.Table, error) {
 	ret := _m.Called(name)
@@ -33,3 +33,19 @@ func (_m *TableSchemaValidator) Validate() error {
 
 	return r0
 }
+
+// ValidateAll provides a mock function with given fields:
+func (_m *TableSchemaValidator) ValidateAll() []error {
+	ret := _m.Called()
+
+	var r0 []error
+	if rf, ok := ret.Get(0).(func() []error); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]error)
+		}
+	}
+
+	return r0
+}
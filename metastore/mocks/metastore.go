@@ -39,6 +39,41 @@ func (_m *MetaStore) AddColumn(table string, column common.Column, appendToArchi
 	return r0
 }
 
+// AddDeletePredicate provides a mock function with given fields: table, filter, cutoff
+func (_m *MetaStore) AddDeletePredicate(table string, filter string, cutoff uint32) (common.DeletePredicate, error) {
+	ret := _m.Called(table, filter, cutoff)
+
+	var r0 common.DeletePredicate
+	if rf, ok := ret.Get(0).(func(string, string, uint32) common.DeletePredicate); ok {
+		r0 = rf(table, filter, cutoff)
+	} else {
+		r0 = ret.Get(0).(common.DeletePredicate)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string, uint32) error); ok {
+		r1 = rf(table, filter, cutoff)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ApplySchemas provides a mock function with given fields: changes
+func (_m *MetaStore) ApplySchemas(changes []common.SchemaChange) error {
+	ret := _m.Called(changes)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func([]common.SchemaChange) error); ok {
+		r0 = rf(changes)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // CreateTable provides a mock function with given fields: table
 func (_m *MetaStore) CreateTable(table *common.Table) error {
 	ret := _m.Called(table)
@@ -104,6 +139,29 @@ func (_m *MetaStore) ExtendEnumDict(table string, column string, enumCases []str
 	return r0, r1
 }
 
+// GetArchiveBatchIDs provides a mock function with given fields: table, shard
+func (_m *MetaStore) GetArchiveBatchIDs(table string, shard int) ([]int, error) {
+	ret := _m.Called(table, shard)
+
+	var r0 []int
+	if rf, ok := ret.Get(0).(func(string, int) []int); ok {
+		r0 = rf(table, shard)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]int)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, int) error); ok {
+		r1 = rf(table, shard)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetArchiveBatchVersion provides a mock function with given fields: table, shard, batchID, cutoff
 func (_m *MetaStore) GetArchiveBatchVersion(table string, shard int, batchID int, cutoff uint32) (uint32, uint32, int, error) {
 	ret := _m.Called(table, shard, batchID, cutoff)
@@ -188,6 +246,57 @@ func (_m *MetaStore) GetBackfillProgressInfo(table string, shard int) (int64, ui
 	return r0, r1, r2
 }
 
+// GetDeletePredicates provides a mock function with given fields: table
+func (_m *MetaStore) GetDeletePredicates(table string) ([]common.DeletePredicate, error) {
+	ret := _m.Called(table)
+
+	var r0 []common.DeletePredicate
+	if rf, ok := ret.Get(0).(func(string) []common.DeletePredicate); ok {
+		r0 = rf(table)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]common.DeletePredicate)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(table)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetDerivedColumnProgress provides a mock function with given fields: table, column, shard
+func (_m *MetaStore) GetDerivedColumnProgress(table string, column string, shard int) (int, int, error) {
+	ret := _m.Called(table, column, shard)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(string, string, int) int); ok {
+		r0 = rf(table, column, shard)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 int
+	if rf, ok := ret.Get(1).(func(string, string, int) int); ok {
+		r1 = rf(table, column, shard)
+	} else {
+		r1 = ret.Get(1).(int)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(string, string, int) error); ok {
+		r2 = rf(table, column, shard)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
 // GetEnumDict provides a mock function with given fields: table, column
 func (_m *MetaStore) GetEnumDict(table string, column string) ([]string, error) {
 	ret := _m.Called(table, column)
@@ -211,6 +320,29 @@ func (_m *MetaStore) GetEnumDict(table string, column string) ([]string, error)
 	return r0, r1
 }
 
+// GetMaintenance provides a mock function with given fields:
+func (_m *MetaStore) GetMaintenance() (*common.Maintenance, error) {
+	ret := _m.Called()
+
+	var r0 *common.Maintenance
+	if rf, ok := ret.Get(0).(func() *common.Maintenance); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*common.Maintenance)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetOwnedShards provides a mock function with given fields: table
 func (_m *MetaStore) GetOwnedShards(table string) ([]int, error) {
 	ret := _m.Called(table)
@@ -234,6 +366,52 @@ func (_m *MetaStore) GetOwnedShards(table string) ([]int, error) {
 	return r0, r1
 }
 
+// GetRollupProgress provides a mock function with given fields: table, shard
+func (_m *MetaStore) GetRollupProgress(table string, shard int) (map[int]common.BatchVersion, error) {
+	ret := _m.Called(table, shard)
+
+	var r0 map[int]common.BatchVersion
+	if rf, ok := ret.Get(0).(func(string, int) map[int]common.BatchVersion); ok {
+		r0 = rf(table, shard)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(map[int]common.BatchVersion)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, int) error); ok {
+		r1 = rf(table, shard)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetSchemaVersion provides a mock function with given fields: name, version
+func (_m *MetaStore) GetSchemaVersion(name string, version int) (*common.Table, error) {
+	ret := _m.Called(name, version)
+
+	var r0 *common.Table
+	if rf, ok := ret.Get(0).(func(string, int) *common.Table); ok {
+		r0 = rf(name, version)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*common.Table)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, int) error); ok {
+		r1 = rf(name, version)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetSnapshotProgress provides a mock function with given fields: table, shard
 func (_m *MetaStore) GetSnapshotProgress(table string, shard int) (int64, uint32, int32, uint32, error) {
 	ret := _m.Called(table, shard)
@@ -336,6 +514,48 @@ func (_m *MetaStore) PurgeArchiveBatches(table string, shard int, batchIDStart i
 	return r0
 }
 
+// RemoveDeletePredicate provides a mock function with given fields: table, id
+func (_m *MetaStore) RemoveDeletePredicate(table string, id int) error {
+	ret := _m.Called(table, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, int) error); ok {
+		r0 = rf(table, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RenameColumn provides a mock function with given fields: table, column, newName
+func (_m *MetaStore) RenameColumn(table string, column string, newName string) error {
+	ret := _m.Called(table, column, newName)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string, string) error); ok {
+		r0 = rf(table, column, newName)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RollbackSchema provides a mock function with given fields: table, version
+func (_m *MetaStore) RollbackSchema(table string, version int) error {
+	ret := _m.Called(table, version)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, int) error); ok {
+		r0 = rf(table, version)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // UpdateArchivingCutoff provides a mock function with given fields: table, shard, cutoff
 func (_m *MetaStore) UpdateArchivingCutoff(table string, shard int, cutoff uint32) error {
 	ret := _m.Called(table, shard, cutoff)
@@ -378,6 +598,48 @@ func (_m *MetaStore) UpdateColumn(table string, column string, config common.Col
 	return r0
 }
 
+// UpdateDerivedColumnProgress provides a mock function with given fields: table, column, shard, lastBatchID, endBatchID
+func (_m *MetaStore) UpdateDerivedColumnProgress(table string, column string, shard int, lastBatchID int, endBatchID int) error {
+	ret := _m.Called(table, column, shard, lastBatchID, endBatchID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string, int, int, int) error); ok {
+		r0 = rf(table, column, shard, lastBatchID, endBatchID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateMaintenance provides a mock function with given fields: maintenance
+func (_m *MetaStore) UpdateMaintenance(maintenance *common.Maintenance) error {
+	ret := _m.Called(maintenance)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*common.Maintenance) error); ok {
+		r0 = rf(maintenance)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateRollupProgress provides a mock function with given fields: table, shard, progress
+func (_m *MetaStore) UpdateRollupProgress(table string, shard int, progress map[int]common.BatchVersion) error {
+	ret := _m.Called(table, shard, progress)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, int, map[int]common.BatchVersion) error); ok {
+		r0 = rf(table, shard, progress)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // UpdateSnapshotProgress provides a mock function with given fields: table, shard, redoLogFile, upsertBatchOffset, lastReadBatchID, lastReadBatchOffset
 func (_m *MetaStore) UpdateSnapshotProgress(table string, shard int, redoLogFile int64, upsertBatchOffset uint32, lastReadBatchID int32, lastReadBatchOffset uint32) error {
 	ret := _m.Called(table, shard, redoLogFile, upsertBatchOffset, lastReadBatchID, lastReadBatchOffset)
@@ -1,15 +1,55 @@
 package metastore
 
 import (
+	"context"
 	"github.com/uber/aresdb/clients"
 	"github.com/uber/aresdb/metastore/common"
 	"github.com/uber/aresdb/utils"
 	"reflect"
+	"sync"
 	"time"
 )
 
+const (
+	// failureChanSize is the number of fetch failures buffered for consumers of Failures
+	failureChanSize = 10
+	// watchRetryInterval is the interval to wait before watching again after failing to watch
+	watchRetryInterval = 5 * time.Second
+)
+
+// SchemaFetchMode is how SchemaFetchJob learns about schema changes.
+type SchemaFetchMode int
+
+const (
+	// SchemaFetchModePoll fetches all schemas from controller every interval.
+	SchemaFetchModePoll SchemaFetchMode = iota
+	// SchemaFetchModeWatch applies the tables changed in the SchemaWatcher once notified, and still
+	// fetches all schemas from controller every interval to reconcile.
+	SchemaFetchModeWatch
+)
+
+// SchemaWatcher watches the table schemas of a cluster.
+type SchemaWatcher interface {
+	// Watch returns the versions of all tables, and a channel closed once any table is created,
+	// updated or deleted, or the watch is lost.
+	Watch() (versions map[string]int32, changed <-chan struct{}, err error)
+	// GetTable returns the schema of a table.
+	GetTable(name string) (*common.Table, error)
+}
+
+// SchemaAppliedCallback is called with the table name and its new schema after a fetched schema
+// change is applied. schema is nil if the table was deleted.
+type SchemaAppliedCallback func(table string, schema *common.Table)
+
+// appliedSchema records a table schema change applied by a fetch
+type appliedSchema struct {
+	name   string
+	schema *common.Table
+}
+
 // SchemaFetchJob is a job that periodically pings ares-controller and updates table schemas if applicable
 type SchemaFetchJob struct {
+	sync.RWMutex
 	clusterName       string
 	hash              string
 	intervalInSeconds int
@@ -17,6 +57,29 @@ type SchemaFetchJob struct {
 	schemaValidator   TableSchemaValidator
 	controllerClient  clients.ControllerClient
 	stopChan          chan struct{}
+	failureChan       chan error
+	// closed after the first successful fetch
+	readyChan chan struct{}
+	readyOnce sync.Once
+	// time of last successful fetch, protected by the RWMutex
+	lastSuccess time.Time
+	// callbacks for applied schema changes, protected by the RWMutex
+	schemaAppliedCallbacks []SchemaAppliedCallback
+	// applied schema changes waiting to be delivered to callbacks, protected by the RWMutex
+	pendingApplied []appliedSchema
+	// signals the delivery goroutine of pending changes
+	appliedChan chan struct{}
+	// starts the delivery goroutine on the first applied change
+	deliveryOnce sync.Once
+	// serializes fetches so that Pause waits for the fetch in flight
+	fetchLock sync.Mutex
+	// whether periodic fetching is paused, protected by the RWMutex
+	paused bool
+	// Mode is SchemaFetchModeWatch if watcher is set by WatchSchemas.
+	Mode    SchemaFetchMode
+	watcher SchemaWatcher
+	// versions of the watched tables last applied.
+	watchedVersions map[string]int32
 }
 
 // NewSchemaFetchJob creates a new SchemaFetchJob
@@ -28,110 +91,542 @@ func NewSchemaFetchJob(intervalInSeconds int, schemaMutator TableSchemaMutator,
 		schemaMutator:     schemaMutator,
 		schemaValidator:   schemaValidator,
 		stopChan:          make(chan struct{}),
+		failureChan:       make(chan error, failureChanSize),
+		readyChan:         make(chan struct{}),
+		appliedChan:       make(chan struct{}, 1),
 		controllerClient:  controllerClient,
 	}
 }
 
+// WatchSchemas switches the job to SchemaFetchModeWatch with watcher, it must be called before Run.
+func (j *SchemaFetchJob) WatchSchemas(watcher SchemaWatcher) {
+	j.Mode = SchemaFetchModeWatch
+	j.watcher = watcher
+}
+
 // Run starts the scheduling
 func (j *SchemaFetchJob) Run() {
 	tickChan := time.NewTicker(time.Second * time.Duration(j.intervalInSeconds)).C
+	// never delivers in SchemaFetchModePoll.
+	var changed <-chan struct{}
+	if j.Mode == SchemaFetchModeWatch {
+		changed = j.watchChanges()
+	}
 
 	for {
 		select {
 		case <-tickChan:
-			j.FetchSchema()
+			j.fetchUnlessPaused()
+		case <-changed:
+			changed = j.watchChanges()
 		case <-j.stopChan:
 			return
 		}
 	}
 }
 
+// watchChanges applies the tables changed since the last watch unless paused, and returns the
+// channel to wait on before watching again. If watching fails, the returned channel is closed after
+// watchRetryInterval, so that watching resumes once reconnected after a transient disconnection.
+func (j *SchemaFetchJob) watchChanges() <-chan struct{} {
+	versions, changed, err := j.watcher.Watch()
+	if err != nil {
+		j.reportError(utils.StackError(err, "Failed to watch schemas"))
+		retry := make(chan struct{})
+		time.AfterFunc(watchRetryInterval, func() {
+			close(retry)
+		})
+		return retry
+	}
+
+	j.fetchLock.Lock()
+	defer j.fetchLock.Unlock()
+	// changes while paused are applied by the first watch after resuming.
+	if !j.IsPaused() {
+		if err = j.applyWatchedChanges(versions); err != nil {
+			j.reportError(err)
+		}
+	}
+	return changed
+}
+
+// applyWatchedChanges fetches and applies the tables whose version differs from the last applied
+// one, and deletes the watched tables no longer present. Failed tables are applied again by the next
+// watch.
+func (j *SchemaFetchJob) applyWatchedChanges(versions map[string]int32) error {
+	var err error
+	fail := func(name string, tableErr error) {
+		utils.GetLogger().With("table", name, "error", tableErr.Error()).Error("Failed to get watched table schema")
+		if err == nil {
+			err = tableErr
+		}
+	}
+
+	var changes []tableChange
+	for name, version := range versions {
+		if applied, ok := j.watchedVersions[name]; ok && applied == version {
+			continue
+		}
+		table, tableErr := j.watcher.GetTable(name)
+		if tableErr != nil {
+			fail(name, tableErr)
+			continue
+		}
+		changes = append(changes, tableChange{name: name, table: table})
+	}
+	for name := range j.watchedVersions {
+		if _, ok := versions[name]; !ok {
+			// found table deletion
+			changes = append(changes, tableChange{name: name})
+		}
+	}
+	if len(changes) == 0 {
+		return err
+	}
+
+	oldTablesMap, listErr := j.listTables()
+	if listErr != nil {
+		return listErr
+	}
+	applied, failedTables, applyErr := j.applyTableChanges(changes, oldTablesMap)
+	j.notifySchemaApplied(applied)
+	if err == nil {
+		err = applyErr
+	}
+
+	failed := make(map[string]bool)
+	for _, name := range failedTables {
+		failed[name] = true
+	}
+	if j.watchedVersions == nil {
+		j.watchedVersions = make(map[string]int32)
+	}
+	for _, tc := range changes {
+		if failed[tc.name] {
+			continue
+		}
+		if tc.table == nil {
+			delete(j.watchedVersions, tc.name)
+		} else {
+			j.watchedVersions[tc.name] = versions[tc.name]
+		}
+	}
+	if err != nil {
+		return utils.StackError(err, "Failed to apply watched schema changes")
+	}
+	utils.GetRootReporter().GetCounter(utils.SchemaApplySuccess).Inc(1)
+	return nil
+}
+
 // Stop stops the scheduling
 func (j *SchemaFetchJob) Stop() {
 	close(j.stopChan)
 }
 
+// Pause halts periodic fetching until Resume is called, it returns after the fetch in flight if any
+// finishes so that no schema change is applied once paused. Explicit calls of FetchSchema still fetch.
+func (j *SchemaFetchJob) Pause() {
+	j.fetchLock.Lock()
+	defer j.fetchLock.Unlock()
+	j.Lock()
+	j.paused = true
+	j.Unlock()
+	utils.GetLogger().Info("Paused schema fetch job")
+}
+
+// Resume resumes periodic fetching from the current schema hash at the next interval.
+func (j *SchemaFetchJob) Resume() {
+	j.Lock()
+	j.paused = false
+	j.Unlock()
+	utils.GetLogger().Info("Resumed schema fetch job")
+}
+
+// IsPaused returns whether periodic fetching is paused.
+func (j *SchemaFetchJob) IsPaused() bool {
+	j.RLock()
+	defer j.RUnlock()
+	return j.paused
+}
+
+// Failures returns the channel on which fetch failures are published.
+// Failures are dropped if the channel is full, so slow consumers never block fetching.
+func (j *SchemaFetchJob) Failures() <-chan error {
+	return j.failureChan
+}
+
+// LastSuccess returns the time of the last successful fetch, zero if none succeeded yet.
+func (j *SchemaFetchJob) LastSuccess() time.Time {
+	j.RLock()
+	defer j.RUnlock()
+	return j.lastSuccess
+}
+
+// OnSchemaApplied registers a callback invoked for every table schema change applied by the job.
+// Callbacks are invoked asynchronously so they never block fetching, but serially by a single goroutine
+// in the order changes were applied, also across fetches.
+func (j *SchemaFetchJob) OnSchemaApplied(callback SchemaAppliedCallback) {
+	j.Lock()
+	defer j.Unlock()
+	j.schemaAppliedCallbacks = append(j.schemaAppliedCallbacks, callback)
+}
+
+// WaitUntilReady blocks until the first schema fetch succeeds or ctx is done.
+func (j *SchemaFetchJob) WaitUntilReady(ctx context.Context) error {
+	select {
+	case <-j.readyChan:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// IsReady returns whether the first schema fetch has succeeded.
+func (j *SchemaFetchJob) IsReady() bool {
+	select {
+	case <-j.readyChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// FetchSchema fetches schemas from controller and applies them if the schema hash changed
 func (j *SchemaFetchJob) FetchSchema() {
+	j.fetchLock.Lock()
+	defer j.fetchLock.Unlock()
+	j.fetchSchema()
+}
+
+func (j *SchemaFetchJob) fetchUnlessPaused() {
+	j.fetchLock.Lock()
+	defer j.fetchLock.Unlock()
+	if j.IsPaused() {
+		return
+	}
+	j.fetchSchema()
+}
+
+func (j *SchemaFetchJob) fetchSchema() {
+	utils.GetRootReporter().GetCounter(utils.SchemaFetchAttempt).Inc(1)
 	newHash, err := j.controllerClient.GetSchemaHash(j.clusterName)
 	if err != nil {
-		reportError(err)
+		j.reportError(err)
 		return
 	}
 	if newHash != j.hash {
 		newSchemas, err := j.controllerClient.GetAllSchema(j.clusterName)
 		if err != nil {
-			reportError(err)
+			j.reportError(err)
 			return
 		}
-		err = j.applySchemaChange(newSchemas)
+		var applied []appliedSchema
+		applied, err = j.applySchemaChange(newSchemas)
+		j.notifySchemaApplied(applied)
 		if err != nil {
-			reportError(err)
+			j.reportError(err)
 			return
 		}
 		j.hash = newHash
+		utils.GetRootReporter().GetCounter(utils.SchemaApplySuccess).Inc(1)
 	}
+	// the cluster level maintenance is not covered by the schema hash, so it's fetched every time.
+	// Failing to apply it does not fail the schema fetch, the current maintenance is kept until the
+	// next fetch, so that a controller without maintenance support does not block readiness.
+	if maintenanceMutator, ok := j.schemaMutator.(MaintenanceMutator); ok {
+		if err = j.applyMaintenance(maintenanceMutator); err != nil {
+			utils.GetRootReporter().GetCounter(utils.MaintenanceFetchFailure).Inc(1)
+			utils.GetLogger().With("error", err).Warn("Failed to apply maintenance from controller")
+		}
+	}
+	j.Lock()
+	j.lastSuccess = utils.Now()
+	j.Unlock()
+	j.readyOnce.Do(func() {
+		close(j.readyChan)
+	})
 	utils.GetLogger().Info("Succeeded to run schema fetch job")
 	utils.GetRootReporter().GetCounter(utils.SchemaFetchSuccess).Inc(1)
 }
 
-func (j *SchemaFetchJob) applySchemaChange(tables []common.Table) (err error) {
-	oldTables, err := j.schemaMutator.ListTables()
+// notifySchemaApplied queues the applied changes for the delivery goroutine.
+func (j *SchemaFetchJob) notifySchemaApplied(applied []appliedSchema) {
+	j.Lock()
+	if len(applied) == 0 || len(j.schemaAppliedCallbacks) == 0 {
+		j.Unlock()
+		return
+	}
+	j.pendingApplied = append(j.pendingApplied, applied...)
+	j.Unlock()
+
+	j.deliveryOnce.Do(func() {
+		go j.deliverSchemaApplied()
+	})
+	select {
+	case j.appliedChan <- struct{}{}:
+	default:
+		// delivery goroutine is already signaled.
+	}
+}
+
+// deliverSchemaApplied invokes the callbacks for queued changes one at a time until the job stops.
+func (j *SchemaFetchJob) deliverSchemaApplied() {
+	for {
+		select {
+		case <-j.appliedChan:
+			j.Lock()
+			applied, callbacks := j.pendingApplied, j.schemaAppliedCallbacks
+			j.pendingApplied = nil
+			j.Unlock()
+			for _, change := range applied {
+				for _, callback := range callbacks {
+					callback(change.name, change.schema)
+				}
+			}
+		case <-j.stopChan:
+			return
+		}
+	}
+}
+
+// stagedTable is a validated change of a table waiting to be committed.
+type stagedTable struct {
+	name     string
+	oldTable *common.Table
+	newTable *common.Table
+	counter  utils.MetricName
+}
+
+// tableChange is a fetched schema of a table to apply, table is nil if the table is deleted.
+type tableChange struct {
+	name  string
+	table *common.Table
+}
+
+// applySchemaChange applies fetched tables and returns the changes applied, even if some tables fail.
+// Current tables missing in the fetched tables are deleted.
+func (j *SchemaFetchJob) applySchemaChange(tables []common.Table) (applied []appliedSchema, err error) {
+	oldTablesMap, err := j.listTables()
 	if err != nil {
 		return
 	}
 
+	changes := make([]tableChange, 0, len(tables))
+	addressed := make(map[string]bool)
+	for _, t := range tables {
+		table := t
+		addressed[table.Name] = true
+		changes = append(changes, tableChange{name: table.Name, table: &table})
+	}
+	for oldTableName := range oldTablesMap {
+		if !addressed[oldTableName] {
+			// found table deletion
+			changes = append(changes, tableChange{name: oldTableName})
+		}
+	}
+	applied, _, err = j.applyTableChanges(changes, oldTablesMap)
+	return
+}
+
+// listTables returns the names of the current tables.
+func (j *SchemaFetchJob) listTables() (map[string]bool, error) {
+	oldTables, err := j.schemaMutator.ListTables()
+	if err != nil {
+		return nil, err
+	}
 	oldTablesMap := make(map[string]bool)
 	for _, oldTableName := range oldTables {
 		oldTablesMap[oldTableName] = true
 	}
+	return oldTablesMap, nil
+}
 
-	for _, table := range tables {
-		if !oldTablesMap[table.Name] {
-			// found new table
-			err = j.schemaMutator.CreateTable(&table)
-			if err != nil {
-				return
-			}
-			utils.GetRootReporter().GetCounter(utils.SchemaCreationCount).Inc(1)
-		} else {
-			var oldTable *common.Table
-			oldTable, err = j.schemaMutator.GetTable(table.Name)
-			if err != nil {
-				return
-			}
-			if !reflect.DeepEqual(&table, oldTable) {
-				// found table update
-				j.schemaValidator.SetNewTable(table)
-				j.schemaValidator.SetOldTable(*oldTable)
-				err = j.schemaValidator.Validate()
-				if err != nil {
-					return
-				}
-				err = j.schemaMutator.UpdateTable(table)
-				if err != nil {
-					return
-				}
-				utils.GetRootReporter().GetCounter(utils.SchemaUpdateCount).Inc(1)
-			}
-			oldTablesMap[table.Name] = false
+// applyTableChanges applies changes of tables and returns the changes applied and the failed tables,
+// even if some tables fail. oldTablesMap has the names of the current tables.
+// All tables are validated and staged before any of them is committed, then each staged table is
+// committed in a single metastore operation. A table failing to stage or commit does not stop the
+// other tables from being applied, a table failing to commit is reverted to its previous schema.
+// The first error is returned with the names of all failed tables.
+func (j *SchemaFetchJob) applyTableChanges(changes []tableChange, oldTablesMap map[string]bool) (applied []appliedSchema, failedTables []string, err error) {
+	fail := func(name string, tableErr error) {
+		utils.GetLogger().With("table", name, "error", tableErr.Error()).Error("Failed to apply fetched table schema")
+		failedTables = append(failedTables, name)
+		if err == nil {
+			err = tableErr
 		}
 	}
 
-	for oldTableName, notAddressed := range oldTablesMap {
-		if notAddressed {
-			// found table deletion
-			err = j.schemaMutator.DeleteTable(oldTableName)
-			if err != nil {
-				return
-			}
-			utils.GetRootReporter().GetCounter(utils.SchemaDeletionCount).Inc(1)
+	var staged []stagedTable
+	for _, tc := range changes {
+		exists := oldTablesMap[tc.name]
+		if tc.table == nil && !exists {
+			continue
+		}
+		change, tableErr := j.stage(tc.name, tc.table, exists)
+		if tableErr != nil {
+			fail(tc.name, tableErr)
+		} else if change != nil {
+			staged = append(staged, *change)
 		}
 	}
 
+	for _, change := range staged {
+		committed, tableErr := j.commitTable(change)
+		if committed != nil {
+			applied = append(applied, *committed)
+		}
+		if tableErr != nil {
+			fail(change.name, tableErr)
+		}
+	}
+
+	if err != nil {
+		err = utils.StackError(err, "Failed to apply schema of tables %v", failedTables)
+	}
 	return
 }
 
-func reportError(err error) {
+// stage validates the fetched schema of a table, nil if the table is deleted, against its current
+// schema. It returns the change to commit, nil if the table is unchanged.
+func (j *SchemaFetchJob) stage(name string, table *common.Table, exists bool) (*stagedTable, error) {
+	var oldTable *common.Table
+	if exists {
+		var err error
+		if oldTable, err = j.schemaMutator.GetTable(name); err != nil {
+			return nil, err
+		}
+	}
+
+	newTable, counter, changed, err := j.stageTable(oldTable, table)
+	if err != nil || !changed {
+		return nil, err
+	}
+	return &stagedTable{name: name, oldTable: oldTable, newTable: newTable, counter: counter}, nil
+}
+
+// commitTable writes the staged change in a single metastore operation. If writing fails, the
+// table is reverted to its previous schema. It returns the change committed, which can be the
+// revert of the table if the revert changed it.
+func (j *SchemaFetchJob) commitTable(change stagedTable) (*appliedSchema, error) {
+	err := j.schemaMutator.ApplySchemas([]common.SchemaChange{{Name: change.name, Table: change.newTable}})
+	if err == nil {
+		utils.GetRootReporter().GetCounter(change.counter).Inc(1)
+		return &appliedSchema{name: change.name, schema: change.newTable}, nil
+	}
+
+	reverted, revertErr := j.revertTable(change.name, change.oldTable)
+	if revertErr != nil {
+		// the table is left as partially written, the fetched schema is applied again by the next
+		// fetch since the schema hash is not updated.
+		utils.GetLogger().With("table", change.name, "error", revertErr.Error()).Error("Failed to revert table schema")
+		return nil, utils.StackError(err, "Failed to revert table %s: %s", change.name, revertErr.Error())
+	}
+	utils.GetLogger().With("table", change.name).Info("Reverted table schema after failing to apply fetched schema")
+	return reverted, err
+}
+
+// stageTable returns the schema to write for the fetched table and the counter of the change, and
+// whether the table is changed at all.
+func (j *SchemaFetchJob) stageTable(oldTable, table *common.Table) (*common.Table, utils.MetricName, bool, error) {
+	switch {
+	case table == nil:
+		// found table deletion
+		return nil, utils.SchemaDeletionCount, true, nil
+	case oldTable == nil:
+		// found new table
+		return table, utils.SchemaCreationCount, true, nil
+	case table.Version < oldTable.Version:
+		// found table rollback, columns added since the rolled back version are kept if deleted
+		rolledBackTable, err := rollbackSchema(j.schemaValidator, oldTable, table)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		return rolledBackTable, utils.SchemaRollbackCount, true, nil
+	case !reflect.DeepEqual(table, oldTable):
+		// found table update
+		j.schemaValidator.SetNewTable(*table)
+		j.schemaValidator.SetOldTable(*oldTable)
+		if err := j.schemaValidator.Validate(); err != nil {
+			return nil, 0, false, err
+		}
+		return table, utils.SchemaUpdateCount, true, nil
+	}
+	return nil, 0, false, nil
+}
+
+// revertTable restores the schema the table had before a failed write, which may have written part
+// of the change. oldTable is nil if the table did not exist. Columns deleted or added by the failed
+// write stay deleted, since deleted columns cannot be restored. It returns the change made by the
+// revert if the table does not end up with oldTable.
+func (j *SchemaFetchJob) revertTable(name string, oldTable *common.Table) (*appliedSchema, error) {
+	currentTable, err := j.schemaMutator.GetTable(name)
+	if err != nil && err != ErrTableDoesNotExist {
+		return nil, err
+	}
+	var revertedTable *common.Table
+	switch {
+	case oldTable == nil:
+		if currentTable == nil {
+			return nil, nil
+		}
+	case currentTable == nil:
+		revertedTable = oldTable
+	case reflect.DeepEqual(currentTable, oldTable):
+		return nil, nil
+	default:
+		revertedTable = revertSchema(currentTable, oldTable)
+	}
+	if err = j.schemaMutator.ApplySchemas([]common.SchemaChange{{Name: name, Table: revertedTable}}); err != nil {
+		return nil, err
+	}
+	if reflect.DeepEqual(revertedTable, oldTable) {
+		return nil, nil
+	}
+	return &appliedSchema{name: name, schema: revertedTable}, nil
+}
+
+// revertSchema returns oldTable with the columns deleted in currentTable kept deleted, and the
+// columns currentTable added after the columns of oldTable deleted.
+func revertSchema(currentTable, oldTable *common.Table) *common.Table {
+	table := *oldTable
+	numColumns := len(oldTable.Columns)
+	if len(currentTable.Columns) > numColumns {
+		numColumns = len(currentTable.Columns)
+	}
+	table.Columns = make([]common.Column, numColumns)
+	copy(table.Columns, oldTable.Columns)
+	for id, column := range currentTable.Columns {
+		if id >= len(oldTable.Columns) {
+			column.Deleted = true
+			table.Columns[id] = column
+		} else if column.Deleted {
+			table.Columns[id].Deleted = true
+		}
+	}
+	return &table
+}
+
+// applyMaintenance overwrites the local cluster level maintenance with the one from controller if they differ.
+func (j *SchemaFetchJob) applyMaintenance(maintenanceMutator MaintenanceMutator) error {
+	newMaintenance, err := j.controllerClient.GetMaintenance(j.clusterName)
+	if err != nil {
+		return err
+	}
+	oldMaintenance, err := maintenanceMutator.GetMaintenance()
+	if err != nil {
+		return err
+	}
+	if reflect.DeepEqual(newMaintenance, oldMaintenance) {
+		return nil
+	}
+	return maintenanceMutator.UpdateMaintenance(newMaintenance)
+}
+
+func (j *SchemaFetchJob) reportError(err error) {
 	utils.GetRootReporter().GetCounter(utils.SchemaFetchFailure).Inc(1)
 	utils.GetLogger().Error(utils.StackError(err, "err running schema fetch job"))
+	select {
+	case j.failureChan <- err:
+	default:
+	}
 }
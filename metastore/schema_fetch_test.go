@@ -1,12 +1,18 @@
 package metastore
 
 import (
+	"context"
+	"errors"
 	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
 	"github.com/stretchr/testify/mock"
+	"github.com/uber-go/tally"
 	clientsMocks "github.com/uber/aresdb/clients/mocks"
 	"github.com/uber/aresdb/metastore/common"
 	metaMocks "github.com/uber/aresdb/metastore/mocks"
-	"errors"
+	"github.com/uber/aresdb/utils"
+	"sync/atomic"
+	"time"
 )
 
 var _ = ginkgo.Describe("schema fetch job", func() {
@@ -59,6 +65,22 @@ var _ = ginkgo.Describe("schema fetch job", func() {
 		Version: 2,
 	}
 
+	testTable4 := common.Table{
+		Name: "testTable4",
+		Columns: []common.Column{
+			{
+				Name: "col1",
+				Type: "Int32",
+			},
+		},
+		Version: 1,
+	}
+
+	// changeOf returns the schema changes committing the table in a single metastore operation.
+	changeOf := func(name string, table *common.Table) []common.SchemaChange {
+		return []common.SchemaChange{{Name: name, Table: table}}
+	}
+
 	ginkgo.BeforeEach(func() {
 		mockSchemaMutator = metaMocks.TableSchemaMutator{}
 		mockSchemaValidator = metaMocks.TableSchemaValidator{}
@@ -79,22 +101,212 @@ var _ = ginkgo.Describe("schema fetch job", func() {
 		// existing tables [          , testTable2, testTable3, testTable4]
 		// from controller [testTable1, testTable2m,testTable3, (deletion)]
 		mockSchemaMutator.On("ListTables").Return([]string{"testTable2", "testTable3", "testTable4"}, nil).Once()
-		mockSchemaMutator.On("CreateTable", mock.Anything).Return(nil).Once()
+		mockSchemaMutator.On("ApplySchemas", changeOf("testTable1", &testTable1)).Return(nil).Once()
 		mockSchemaMutator.On("GetTable", "testTable2").Return(&testTable2, nil).Once()
 		mockSchemaMutator.On("GetTable", "testTable3").Return(&testTable3, nil).Once()
-		mockSchemaMutator.On("UpdateTable", mock.Anything).Return(nil).Once()
-		mockSchemaMutator.On("DeleteTable", "testTable4").Return(nil).Once()
+		mockSchemaMutator.On("ApplySchemas", changeOf("testTable2", &testTable2m)).Return(nil).Once()
+		mockSchemaMutator.On("GetTable", "testTable4").Return(&testTable4, nil).Once()
+		mockSchemaMutator.On("ApplySchemas", changeOf("testTable4", nil)).Return(nil).Once()
 		mockSchemaValidator.On("SetNewTable", mock.Anything).Return(nil)
 		mockSchemaValidator.On("SetOldTable", mock.Anything).Return(nil)
 		mockSchemaValidator.On("Validate").Return(nil)
 		job.FetchSchema()
 	})
 
+	ginkgo.It("should roll back schema with lower version", func() {
+		utils.ResetDefaults()
+		testScope := utils.GetRootReporter().GetRootScope().(tally.TestScope)
+
+		mockControllerCli.On("GetSchemaHash", "cluster1").Return("456", nil).Once()
+		mockControllerCli.On("GetAllSchema", "cluster1").Return([]common.Table{testTable2}, nil).Once()
+		mockSchemaMutator.On("ListTables").Return([]string{"testTable2"}, nil).Once()
+		mockSchemaMutator.On("GetTable", "testTable2").Return(&testTable2m, nil).Once()
+		mockSchemaMutator.On("ApplySchemas", changeOf("testTable2", &testTable2)).Return(nil).Once()
+		mockSchemaValidator.On("SetNewTable", testTable2).Return(nil).Once()
+		mockSchemaValidator.On("SetOldTable", testTable2m).Return(nil).Once()
+		mockSchemaValidator.On("Validate").Return(nil).Once()
+		job.FetchSchema()
+		mockSchemaMutator.AssertExpectations(ginkgo.GinkgoT())
+		Ω(testScope.Snapshot().Counters()["test.schema_rollbacks+component=metastore"].Value()).Should(BeEquivalentTo(1))
+
+		// rollback dropping col2 is rejected
+		testTable2n := testTable2m
+		testTable2n.Columns = append(testTable2m.Columns, common.Column{Name: "col2", Type: "Int32"})
+		mockControllerCli.On("GetSchemaHash", "cluster1").Return("789", nil).Once()
+		mockControllerCli.On("GetAllSchema", "cluster1").Return([]common.Table{testTable2}, nil).Once()
+		mockSchemaMutator.On("ListTables").Return([]string{"testTable2"}, nil).Once()
+		mockSchemaMutator.On("GetTable", "testTable2").Return(&testTable2n, nil).Once()
+		job.FetchSchema()
+		var err error
+		Eventually(job.Failures()).Should(Receive(&err))
+		Ω(err.Error()).Should(ContainSubstring(ErrRollbackDropsColumns.Error()))
+		Ω(err.Error()).Should(ContainSubstring("col2"))
+		mockSchemaMutator.AssertNumberOfCalls(ginkgo.GinkgoT(), "ApplySchemas", 1)
+	})
+
+	ginkgo.It("should report fetch and apply metrics", func() {
+		utils.ResetDefaults()
+		testScope := utils.GetRootReporter().GetRootScope().(tally.TestScope)
+
+		mockControllerCli.On("GetSchemaHash", "cluster1").Return("123", nil).Once()
+		job.FetchSchema()
+
+		mockControllerCli.On("GetSchemaHash", "cluster1").Return("456", nil).Once()
+		mockControllerCli.On("GetAllSchema", "cluster1").Return([]common.Table{testTable1}, nil).Once()
+		mockSchemaMutator.On("ListTables").Return([]string{}, nil).Once()
+		mockSchemaMutator.On("ApplySchemas", changeOf("testTable1", &testTable1)).Return(nil).Once()
+		job.FetchSchema()
+
+		mockControllerCli.On("GetSchemaHash", "cluster1").Return("", errors.New("some error")).Once()
+		job.FetchSchema()
+
+		counters := testScope.Snapshot().Counters()
+		Ω(counters["test.schema_fetch_attempts+component=metastore"].Value()).Should(BeEquivalentTo(3))
+		Ω(counters["test.schema_fetch_success+component=metastore"].Value()).Should(BeEquivalentTo(2))
+		Ω(counters["test.schema_fetch_failure+component=metastore"].Value()).Should(BeEquivalentTo(1))
+		Ω(counters["test.schema_apply_success+component=metastore"].Value()).Should(BeEquivalentTo(1))
+		Ω(counters["test.schema_creations+component=metastore"].Value()).Should(BeEquivalentTo(1))
+	})
+
+	ginkgo.It("should publish failures and track last success", func() {
+		someError := errors.New("some error")
+		Ω(job.LastSuccess().IsZero()).Should(BeTrue())
+
+		mockControllerCli.On("GetSchemaHash", "cluster1").Return("", someError).Once()
+		job.FetchSchema()
+		Ω(job.LastSuccess().IsZero()).Should(BeTrue())
+		Eventually(job.Failures()).Should(Receive(Equal(someError)))
+
+		mockControllerCli.On("GetSchemaHash", "cluster1").Return("123", nil).Once()
+		job.FetchSchema()
+		Ω(job.LastSuccess().IsZero()).Should(BeFalse())
+		Consistently(job.Failures()).ShouldNot(Receive())
+	})
+
+	ginkgo.It("should not block when failures are not consumed", func() {
+		someError := errors.New("some error")
+		mockControllerCli.On("GetSchemaHash", "cluster1").Return("", someError)
+		for i := 0; i < failureChanSize+1; i++ {
+			job.FetchSchema()
+		}
+		Ω(job.Failures()).Should(HaveLen(failureChanSize))
+	})
+
+	ginkgo.It("WaitUntilReady should block until first successful fetch", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		Ω(job.WaitUntilReady(ctx)).Should(Equal(context.DeadlineExceeded))
+		Ω(job.IsReady()).Should(BeFalse())
+
+		mockControllerCli.On("GetSchemaHash", "cluster1").Return("", errors.New("some error")).Once()
+		job.FetchSchema()
+		ctx2, cancel2 := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel2()
+		Ω(job.WaitUntilReady(ctx2)).Should(Equal(context.DeadlineExceeded))
+		Ω(job.IsReady()).Should(BeFalse())
+
+		done := make(chan error)
+		go func() {
+			done <- job.WaitUntilReady(context.Background())
+		}()
+		mockControllerCli.On("GetSchemaHash", "cluster1").Return("123", nil)
+		job.FetchSchema()
+		Eventually(done).Should(Receive(BeNil()))
+		Ω(job.IsReady()).Should(BeTrue())
+
+		// stays ready after subsequent fetches
+		job.FetchSchema()
+		Ω(job.WaitUntilReady(context.Background())).Should(BeNil())
+	})
+
 	ginkgo.It("run and stop should work", func() {
 		go job.Run()
 		job.Stop()
 	})
 
+	ginkgo.It("should notify applied schema changes", func() {
+		type change struct {
+			name   string
+			schema *common.Table
+		}
+		changes := make(chan change, 10)
+		job.OnSchemaApplied(func(table string, schema *common.Table) {
+			changes <- change{table, schema}
+		})
+
+		mockControllerCli.On("GetSchemaHash", "cluster1").Return("456", nil).Once()
+		mockControllerCli.On("GetAllSchema", "cluster1").Return([]common.Table{testTable1, testTable2m, testTable3}, nil).Once()
+		mockSchemaMutator.On("ListTables").Return([]string{"testTable2", "testTable3", "testTable4"}, nil).Once()
+		mockSchemaMutator.On("ApplySchemas", changeOf("testTable1", &testTable1)).Return(nil).Once()
+		mockSchemaMutator.On("GetTable", "testTable2").Return(&testTable2, nil).Once()
+		mockSchemaMutator.On("GetTable", "testTable3").Return(&testTable3, nil).Once()
+		mockSchemaMutator.On("ApplySchemas", changeOf("testTable2", &testTable2m)).Return(nil).Once()
+		mockSchemaMutator.On("GetTable", "testTable4").Return(&testTable4, nil).Once()
+		mockSchemaMutator.On("ApplySchemas", changeOf("testTable4", nil)).Return(nil).Once()
+		mockSchemaValidator.On("SetNewTable", mock.Anything).Return(nil)
+		mockSchemaValidator.On("SetOldTable", mock.Anything).Return(nil)
+		mockSchemaValidator.On("Validate").Return(nil)
+		job.FetchSchema()
+
+		var c change
+		Eventually(changes).Should(Receive(&c))
+		Ω(c.name).Should(Equal("testTable1"))
+		Ω(*c.schema).Should(Equal(testTable1))
+		Eventually(changes).Should(Receive(&c))
+		Ω(c.name).Should(Equal("testTable2"))
+		Ω(*c.schema).Should(Equal(testTable2m))
+		Eventually(changes).Should(Receive(&c))
+		Ω(c.name).Should(Equal("testTable4"))
+		Ω(c.schema).Should(BeNil())
+		Consistently(changes).ShouldNot(Receive())
+	})
+
+	ginkgo.It("should deliver applied schema changes of all fetches serially in order", func() {
+		var running, maxRunning int32
+		release := make(chan struct{})
+		changes := make(chan string, 10)
+		job.OnSchemaApplied(func(table string, schema *common.Table) {
+			if n := atomic.AddInt32(&running, 1); n > atomic.LoadInt32(&maxRunning) {
+				atomic.StoreInt32(&maxRunning, n)
+			}
+			if table == "testTable1" {
+				<-release
+			}
+			changes <- table
+			atomic.AddInt32(&running, -1)
+		})
+		defer job.Stop()
+
+		// changes of later fetches wait for the blocked callback of the first fetch.
+		job.notifySchemaApplied([]appliedSchema{{name: "testTable1"}})
+		job.notifySchemaApplied([]appliedSchema{{name: "testTable2"}})
+		job.notifySchemaApplied([]appliedSchema{{name: "testTable3"}, {name: "testTable4"}})
+		Consistently(changes).ShouldNot(Receive())
+		close(release)
+
+		for _, table := range []string{"testTable1", "testTable2", "testTable3", "testTable4"} {
+			Eventually(changes).Should(Receive(Equal(table)))
+		}
+		Ω(atomic.LoadInt32(&maxRunning)).Should(BeEquivalentTo(1))
+	})
+
+	ginkgo.It("should notify changes applied when other tables fail", func() {
+		changes := make(chan string, 10)
+		job.OnSchemaApplied(func(table string, schema *common.Table) {
+			changes <- table
+		})
+
+		mockControllerCli.On("GetSchemaHash", "cluster1").Return("456", nil).Once()
+		mockControllerCli.On("GetAllSchema", "cluster1").Return([]common.Table{testTable1, testTable2m}, nil).Once()
+		mockSchemaMutator.On("ListTables").Return([]string{"testTable2"}, nil).Once()
+		mockSchemaMutator.On("ApplySchemas", changeOf("testTable1", &testTable1)).Return(nil).Once()
+		mockSchemaMutator.On("GetTable", "testTable2").Return(nil, errors.New("some error")).Once()
+		job.FetchSchema()
+
+		Eventually(changes).Should(Receive(Equal("testTable1")))
+		Consistently(changes).ShouldNot(Receive())
+	})
+
 	ginkgo.It("should report errors", func() {
 		someError := errors.New("some error")
 
@@ -117,42 +329,314 @@ var _ = ginkgo.Describe("schema fetch job", func() {
 		mockControllerCli.On("GetSchemaHash", "cluster1").Return("456", nil).Once()
 		mockControllerCli.On("GetAllSchema", "cluster1").Return([]common.Table{testTable1, testTable2m, testTable3}, nil).Once()
 		mockSchemaMutator.On("ListTables").Return([]string{"testTable2", "testTable3", "testTable4"}, nil).Once()
-		mockSchemaMutator.On("CreateTable", mock.Anything).Return(someError).Once()
+		mockSchemaMutator.On("ApplySchemas", changeOf("testTable1", &testTable1)).Return(nil).Once()
+		mockSchemaMutator.On("GetTable", "testTable2").Return(nil, someError).Once()
+		mockSchemaMutator.On("GetTable", "testTable3").Return(nil, someError).Once()
+		mockSchemaMutator.On("GetTable", "testTable4").Return(nil, someError).Once()
 		job.FetchSchema()
 
+		for i := 0; i < 4; i++ {
+			Eventually(job.Failures()).Should(Receive())
+		}
+		Consistently(job.Failures()).ShouldNot(Receive())
+		Ω(job.hash).Should(BeEmpty())
+		mockSchemaMutator.AssertExpectations(ginkgo.GinkgoT())
+	})
+
+	ginkgo.It("should revert tables failing to apply and apply the other tables", func() {
+		someError := errors.New("some error")
+		changes := make(chan string, 10)
+		job.OnSchemaApplied(func(table string, schema *common.Table) {
+			changes <- table
+		})
+
 		mockControllerCli.On("GetSchemaHash", "cluster1").Return("456", nil).Once()
 		mockControllerCli.On("GetAllSchema", "cluster1").Return([]common.Table{testTable1, testTable2m, testTable3}, nil).Once()
 		mockSchemaMutator.On("ListTables").Return([]string{"testTable2", "testTable3", "testTable4"}, nil).Once()
-		mockSchemaMutator.On("CreateTable", mock.Anything).Return(nil).Once()
-		mockSchemaMutator.On("GetTable", "testTable2").Return(nil, someError).Once()
+		mockSchemaMutator.On("ApplySchemas", changeOf("testTable1", &testTable1)).Return(nil).Once()
+		mockSchemaMutator.On("GetTable", "testTable2").Return(&testTable2, nil).Once()
+		mockSchemaMutator.On("GetTable", "testTable3").Return(&testTable3, nil).Once()
+		mockSchemaMutator.On("GetTable", "testTable4").Return(&testTable4, nil).Once()
+		mockSchemaValidator.On("SetNewTable", testTable2m).Return(nil).Once()
+		mockSchemaValidator.On("SetOldTable", testTable2).Return(nil).Once()
+		mockSchemaValidator.On("Validate").Return(nil).Once()
+		// the update of testTable2 fails after being partially written, and is reverted.
+		mockSchemaMutator.On("ApplySchemas", changeOf("testTable2", &testTable2m)).Return(someError).Once()
+		mockSchemaMutator.On("GetTable", "testTable2").Return(&testTable2m, nil).Once()
+		mockSchemaMutator.On("ApplySchemas", changeOf("testTable2", &testTable2)).Return(nil).Once()
+		mockSchemaMutator.On("ApplySchemas", changeOf("testTable4", nil)).Return(nil).Once()
 		job.FetchSchema()
 
+		var err error
+		Eventually(job.Failures()).Should(Receive(&err))
+		Ω(err.Error()).Should(ContainSubstring("some error"))
+		Ω(err.Error()).Should(ContainSubstring("[testTable2]"))
+		mockSchemaMutator.AssertExpectations(ginkgo.GinkgoT())
+		// the fetch is retried since not all tables are applied.
+		Ω(job.hash).Should(Equal("123"))
+
+		var applied []string
+		for i := 0; i < 2; i++ {
+			var table string
+			Eventually(changes).Should(Receive(&table))
+			applied = append(applied, table)
+		}
+		Ω(applied).Should(ConsistOf("testTable1", "testTable4"))
+		Consistently(changes).ShouldNot(Receive())
+
+		// a table failed to create is deleted.
 		mockControllerCli.On("GetSchemaHash", "cluster1").Return("456", nil).Once()
-		mockControllerCli.On("GetAllSchema", "cluster1").Return([]common.Table{testTable1, testTable2m, testTable3}, nil).Once()
-		mockSchemaMutator.On("ListTables").Return([]string{"testTable2", "testTable3", "testTable4"}, nil).Once()
-		mockSchemaMutator.On("CreateTable", mock.Anything).Return(nil).Once()
-		mockSchemaMutator.On("GetTable", "testTable2").Return(&testTable2, nil).Once()
-		mockSchemaMutator.On("GetTable", "testTable3").Return(nil, someError).Once()
-		mockSchemaMutator.On("UpdateTable", mock.Anything).Return(nil).Once()
+		mockControllerCli.On("GetAllSchema", "cluster1").Return([]common.Table{testTable1}, nil).Once()
+		mockSchemaMutator.On("ListTables").Return([]string{}, nil).Once()
+		mockSchemaMutator.On("ApplySchemas", changeOf("testTable1", &testTable1)).Return(someError).Once()
+		mockSchemaMutator.On("GetTable", "testTable1").Return(&testTable1, nil).Once()
+		mockSchemaMutator.On("ApplySchemas", changeOf("testTable1", nil)).Return(nil).Once()
 		job.FetchSchema()
+		Eventually(job.Failures()).Should(Receive())
+		mockSchemaMutator.AssertExpectations(ginkgo.GinkgoT())
 
+		// a table failed to delete is created again.
 		mockControllerCli.On("GetSchemaHash", "cluster1").Return("456", nil).Once()
-		mockControllerCli.On("GetAllSchema", "cluster1").Return([]common.Table{testTable1, testTable2m, testTable3}, nil).Once()
-		mockSchemaMutator.On("ListTables").Return([]string{"testTable2", "testTable3", "testTable4"}, nil).Once()
-		mockSchemaMutator.On("CreateTable", mock.Anything).Return(nil).Once()
-		mockSchemaMutator.On("GetTable", "testTable2").Return(&testTable2, nil).Once()
-		mockSchemaMutator.On("GetTable", "testTable3").Return(&testTable3, nil).Once()
-		mockSchemaMutator.On("UpdateTable", mock.Anything).Return(someError).Once()
+		mockControllerCli.On("GetAllSchema", "cluster1").Return([]common.Table{}, nil).Once()
+		mockSchemaMutator.On("ListTables").Return([]string{"testTable4"}, nil).Once()
+		mockSchemaMutator.On("GetTable", "testTable4").Return(&testTable4, nil).Once()
+		mockSchemaMutator.On("ApplySchemas", changeOf("testTable4", nil)).Return(someError).Once()
+		mockSchemaMutator.On("GetTable", "testTable4").Return(nil, ErrTableDoesNotExist).Once()
+		mockSchemaMutator.On("ApplySchemas", changeOf("testTable4", &testTable4)).Return(nil).Once()
 		job.FetchSchema()
+		Eventually(job.Failures()).Should(Receive())
+		mockSchemaMutator.AssertExpectations(ginkgo.GinkgoT())
+	})
 
+	ginkgo.It("should validate all tables before committing any", func() {
+		someError := errors.New("some error")
 		mockControllerCli.On("GetSchemaHash", "cluster1").Return("456", nil).Once()
-		mockControllerCli.On("GetAllSchema", "cluster1").Return([]common.Table{testTable1, testTable2m, testTable3}, nil).Once()
-		mockSchemaMutator.On("ListTables").Return([]string{"testTable2", "testTable3", "testTable4"}, nil).Once()
-		mockSchemaMutator.On("CreateTable", mock.Anything).Return(nil).Once()
+		mockControllerCli.On("GetAllSchema", "cluster1").Return([]common.Table{testTable1, testTable2m}, nil).Once()
+		mockSchemaMutator.On("ListTables").Return([]string{"testTable2"}, nil).Once()
 		mockSchemaMutator.On("GetTable", "testTable2").Return(&testTable2, nil).Once()
-		mockSchemaMutator.On("GetTable", "testTable3").Return(&testTable3, nil).Once()
-		mockSchemaMutator.On("UpdateTable", mock.Anything).Return(nil).Once()
-		mockSchemaMutator.On("DeleteTable", "testTable4").Return(someError).Once()
+		mockSchemaValidator.On("SetNewTable", testTable2m).Return(nil).Once()
+		mockSchemaValidator.On("SetOldTable", testTable2).Return(nil).Once()
+		mockSchemaValidator.On("Validate").Return(someError).Once()
+		var validated bool
+		mockSchemaMutator.On("ApplySchemas", changeOf("testTable1", &testTable1)).Return(nil).Once().Run(func(mock.Arguments) {
+			// testTable1 is staged first but only committed after testTable2 is validated.
+			validated = true
+			mockSchemaValidator.AssertExpectations(ginkgo.GinkgoT())
+		})
+		job.FetchSchema()
+		Eventually(job.Failures()).Should(Receive())
+		Ω(validated).Should(BeTrue())
+		mockSchemaMutator.AssertExpectations(ginkgo.GinkgoT())
+	})
+
+	ginkgo.It("should keep columns deleted when reverting and report failed reverts", func() {
+		someError := errors.New("some error")
+		changes := make(chan *common.Table, 10)
+		job.OnSchemaApplied(func(table string, schema *common.Table) {
+			changes <- schema
+		})
+		mockSchemaValidator.On("SetNewTable", mock.Anything).Return(nil)
+		mockSchemaValidator.On("SetOldTable", mock.Anything).Return(nil)
+		mockSchemaValidator.On("Validate").Return(nil)
+
+		oldTable := common.Table{Name: "testTable2", Version: 2, Columns: []common.Column{
+			{Name: "col1", Type: "Int32"}, {Name: "col2", Type: "Int32"},
+		}}
+		newTable := common.Table{Name: "testTable2", Version: 3, Columns: []common.Column{
+			{Name: "col1", Type: "Int32"}, {Name: "col2", Type: "Int32", Deleted: true}, {Name: "col3", Type: "Int32"},
+		}}
+		// the failed write deleted col2 and added col3 before failing.
+		revertedTable := common.Table{Name: "testTable2", Version: 2, Columns: []common.Column{
+			{Name: "col1", Type: "Int32"}, {Name: "col2", Type: "Int32", Deleted: true}, {Name: "col3", Type: "Int32", Deleted: true},
+		}}
+		mockControllerCli.On("GetSchemaHash", "cluster1").Return("456", nil).Once()
+		mockControllerCli.On("GetAllSchema", "cluster1").Return([]common.Table{newTable}, nil).Once()
+		mockSchemaMutator.On("ListTables").Return([]string{"testTable2"}, nil).Once()
+		mockSchemaMutator.On("GetTable", "testTable2").Return(&oldTable, nil).Once()
+		mockSchemaMutator.On("ApplySchemas", changeOf("testTable2", &newTable)).Return(someError).Once()
+		mockSchemaMutator.On("GetTable", "testTable2").Return(&newTable, nil).Once()
+		mockSchemaMutator.On("ApplySchemas", changeOf("testTable2", &revertedTable)).Return(nil).Once()
+		job.FetchSchema()
+		Eventually(job.Failures()).Should(Receive())
+		mockSchemaMutator.AssertExpectations(ginkgo.GinkgoT())
+		var schema *common.Table
+		Eventually(changes).Should(Receive(&schema))
+		Ω(*schema).Should(Equal(revertedTable))
+
+		// the revert fails too.
+		mockControllerCli.On("GetSchemaHash", "cluster1").Return("456", nil).Once()
+		mockControllerCli.On("GetAllSchema", "cluster1").Return([]common.Table{newTable}, nil).Once()
+		mockSchemaMutator.On("ListTables").Return([]string{"testTable2"}, nil).Once()
+		mockSchemaMutator.On("GetTable", "testTable2").Return(&oldTable, nil).Once()
+		mockSchemaMutator.On("ApplySchemas", changeOf("testTable2", &newTable)).Return(someError).Once()
+		mockSchemaMutator.On("GetTable", "testTable2").Return(nil, errors.New("revert error")).Once()
 		job.FetchSchema()
+		var err error
+		Eventually(job.Failures()).Should(Receive(&err))
+		Ω(err.Error()).Should(ContainSubstring("revert error"))
+		Ω(job.hash).Should(Equal("123"))
+		Consistently(changes).ShouldNot(Receive())
+	})
+
+	ginkgo.It("should apply cluster maintenance from controller", func() {
+		mockMetaStore := &metaMocks.MetaStore{}
+		job = NewSchemaFetchJob(1, mockMetaStore, &mockSchemaValidator, &mockControllerCli, "cluster1", "123")
+		maintenance := &common.Maintenance{Reason: "backfill", ExpiresAt: 100}
+		mockControllerCli.On("GetSchemaHash", "cluster1").Return("123", nil)
+		mockControllerCli.On("GetMaintenance", "cluster1").Return(maintenance, nil).Twice()
+		mockMetaStore.On("GetMaintenance").Return(nil, nil).Once()
+		mockMetaStore.On("UpdateMaintenance", maintenance).Return(nil).Once()
+		job.FetchSchema()
+		mockMetaStore.AssertCalled(ginkgo.GinkgoT(), "UpdateMaintenance", maintenance)
+
+		// unchanged maintenance is not written again.
+		mockMetaStore.On("GetMaintenance").Return(maintenance, nil).Once()
+		job.FetchSchema()
+		mockMetaStore.AssertNumberOfCalls(ginkgo.GinkgoT(), "UpdateMaintenance", 1)
+
+		// failing to fetch the maintenance does not fail the schema fetch.
+		mockControllerCli.On("GetMaintenance", "cluster1").Return(nil, errors.New("some error")).Once()
+		job.FetchSchema()
+		mockMetaStore.AssertNumberOfCalls(ginkgo.GinkgoT(), "GetMaintenance", 2)
+		Consistently(job.Failures()).ShouldNot(Receive())
+	})
+
+	ginkgo.It("should apply the tables changed in watch mode", func() {
+		mockWatcher := &metaMocks.SchemaWatcher{}
+		job.WatchSchemas(mockWatcher)
+		Ω(job.Mode).Should(Equal(SchemaFetchModeWatch))
+
+		// existing tables [          , testTable2, testTable4]
+		// watched         [testTable1, testTable2]
+		changed1 := make(chan struct{})
+		mockWatcher.On("Watch").Return(map[string]int32{"testTable1": 0, "testTable2": 0}, (<-chan struct{})(changed1), nil).Once()
+		mockWatcher.On("GetTable", "testTable1").Return(&testTable1, nil).Once()
+		mockWatcher.On("GetTable", "testTable2").Return(&testTable2, nil).Once()
+		mockSchemaMutator.On("ListTables").Return([]string{"testTable2", "testTable4"}, nil).Once()
+		mockSchemaMutator.On("ApplySchemas", changeOf("testTable1", &testTable1)).Return(nil).Once()
+		mockSchemaMutator.On("GetTable", "testTable2").Return(&testTable2, nil).Once()
+		Ω(job.watchChanges()).Should(Equal((<-chan struct{})(changed1)))
+		mockSchemaMutator.AssertExpectations(utils.TestingT)
+
+		// only the updated testTable2 is fetched, the deletion of testTable1 is watched while
+		// testTable4 is left to reconciliation.
+		changed2 := make(chan struct{})
+		mockWatcher.On("Watch").Return(map[string]int32{"testTable2": 1}, (<-chan struct{})(changed2), nil).Once()
+		mockWatcher.On("GetTable", "testTable2").Return(&testTable2m, nil).Once()
+		mockSchemaMutator.On("ListTables").Return([]string{"testTable1", "testTable2", "testTable4"}, nil).Once()
+		mockSchemaMutator.On("GetTable", "testTable2").Return(&testTable2, nil).Once()
+		mockSchemaMutator.On("ApplySchemas", changeOf("testTable2", &testTable2m)).Return(nil).Once()
+		mockSchemaMutator.On("GetTable", "testTable1").Return(&testTable1, nil).Once()
+		mockSchemaMutator.On("ApplySchemas", changeOf("testTable1", nil)).Return(nil).Once()
+		mockSchemaValidator.On("SetNewTable", mock.Anything).Return(nil)
+		mockSchemaValidator.On("SetOldTable", mock.Anything).Return(nil)
+		mockSchemaValidator.On("Validate").Return(nil)
+		job.watchChanges()
+		mockSchemaMutator.AssertExpectations(utils.TestingT)
+		mockWatcher.AssertExpectations(utils.TestingT)
+
+		// unchanged versions fetch nothing.
+		mockWatcher.On("Watch").Return(map[string]int32{"testTable2": 1}, (<-chan struct{})(changed2), nil).Once()
+		job.watchChanges()
+		mockWatcher.AssertExpectations(utils.TestingT)
+	})
+
+	ginkgo.It("should watch again after failing to watch or apply", func() {
+		mockWatcher := &metaMocks.SchemaWatcher{}
+		job.WatchSchemas(mockWatcher)
+		mockWatcher.On("Watch").Return(nil, nil, errors.New("disconnected")).Once()
+		retry := job.watchChanges()
+		Ω(retry).ShouldNot(BeNil())
+		Eventually(job.Failures()).Should(Receive())
+
+		changed := make(chan struct{})
+		mockWatcher.On("Watch").Return(map[string]int32{"testTable1": 0}, (<-chan struct{})(changed), nil)
+		mockWatcher.On("GetTable", "testTable1").Return(nil, errors.New("bad table")).Once()
+		job.watchChanges()
+		Eventually(job.Failures()).Should(Receive())
+
+		// the failed table is fetched again by the next watch.
+		mockWatcher.On("GetTable", "testTable1").Return(&testTable1, nil).Once()
+		mockSchemaMutator.On("ListTables").Return([]string{}, nil).Once()
+		mockSchemaMutator.On("ApplySchemas", changeOf("testTable1", &testTable1)).Return(nil).Once()
+		job.watchChanges()
+		mockSchemaMutator.AssertExpectations(utils.TestingT)
+		mockWatcher.AssertExpectations(utils.TestingT)
+	})
+
+	ginkgo.It("should not apply watched changes while paused", func() {
+		mockWatcher := &metaMocks.SchemaWatcher{}
+		job.WatchSchemas(mockWatcher)
+		changed := make(chan struct{})
+		mockWatcher.On("Watch").Return(map[string]int32{"testTable1": 0}, (<-chan struct{})(changed), nil).Twice()
+		job.Pause()
+		Ω(job.watchChanges()).Should(Equal((<-chan struct{})(changed)))
+		mockWatcher.AssertNotCalled(utils.TestingT, "GetTable", "testTable1")
+
+		job.Resume()
+		mockWatcher.On("GetTable", "testTable1").Return(&testTable1, nil).Once()
+		mockSchemaMutator.On("ListTables").Return([]string{}, nil).Once()
+		mockSchemaMutator.On("ApplySchemas", changeOf("testTable1", &testTable1)).Return(nil).Once()
+		job.watchChanges()
+		mockSchemaMutator.AssertExpectations(utils.TestingT)
+		mockWatcher.AssertExpectations(utils.TestingT)
+	})
+
+	ginkgo.It("should watch in Run once changed", func() {
+		mockWatcher := &metaMocks.SchemaWatcher{}
+		job.WatchSchemas(mockWatcher)
+		changed := make(chan struct{})
+		watched := make(chan struct{}, 10)
+		mockWatcher.On("Watch").Run(func(args mock.Arguments) {
+			watched <- struct{}{}
+		}).Return(map[string]int32{}, (<-chan struct{})(changed), nil).Once()
+		mockWatcher.On("Watch").Run(func(args mock.Arguments) {
+			watched <- struct{}{}
+		}).Return(map[string]int32{}, (<-chan struct{})(make(chan struct{})), nil).Once()
+		go job.Run()
+		defer job.Stop()
+		Eventually(watched).Should(Receive())
+		Consistently(watched, 100*time.Millisecond).ShouldNot(Receive())
+		close(changed)
+		Eventually(watched).Should(Receive())
+	})
+
+	ginkgo.It("should not fetch while paused", func() {
+		fetched := make(chan struct{}, 10)
+		mockControllerCli.On("GetSchemaHash", "cluster1").Run(func(args mock.Arguments) {
+			fetched <- struct{}{}
+		}).Return("123", nil)
+
+		job.Pause()
+		Ω(job.IsPaused()).Should(BeTrue())
+		go job.Run()
+		defer job.Stop()
+		Consistently(fetched, 1500*time.Millisecond).ShouldNot(Receive())
+
+		job.Resume()
+		Ω(job.IsPaused()).Should(BeFalse())
+		Eventually(fetched, 3*time.Second).Should(Receive())
+	})
+
+	ginkgo.It("should finish the fetch in flight before pausing", func() {
+		fetching := make(chan struct{})
+		release := make(chan struct{})
+		mockControllerCli.On("GetSchemaHash", "cluster1").Run(func(args mock.Arguments) {
+			close(fetching)
+			<-release
+		}).Return("123", nil).Once()
+		go job.FetchSchema()
+		<-fetching
+
+		paused := make(chan struct{})
+		go func() {
+			job.Pause()
+			close(paused)
+		}()
+		Consistently(paused, 100*time.Millisecond).ShouldNot(BeClosed())
+		close(release)
+		Eventually(paused).Should(BeClosed())
+		Ω(job.IsPaused()).Should(BeTrue())
+		Ω(job.LastSuccess()).ShouldNot(BeZero())
 	})
 })
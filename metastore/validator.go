@@ -4,15 +4,22 @@ import (
 	"fmt"
 	memCom "github.com/uber/aresdb/memstore/common"
 	"github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/query/expr"
 	"github.com/uber/aresdb/utils"
 	"reflect"
+	"sort"
+	"strings"
 )
 
 // TableSchemaValidator validates it a new table schema is valid, given existing schema
 type TableSchemaValidator interface {
 	SetOldTable(table common.Table)
 	SetNewTable(table common.Table)
+	// Validate returns the first validation error.
 	Validate() error
+	// ValidateAll returns all validation errors, at most one for each column and each check
+	// of the table.
+	ValidateAll() []error
 }
 
 // NewTableSchameValidator returns a new TableSchemaValidator. Pass nil for oldTable if none exists
@@ -34,6 +41,13 @@ func (v *tableSchemaValidatorImpl) SetNewTable(table common.Table) {
 }
 
 func (v tableSchemaValidatorImpl) Validate() (err error) {
+	if errs := v.ValidateAll(); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+func (v tableSchemaValidatorImpl) ValidateAll() []error {
 	if v.oldTable == nil {
 		return v.validateIndividualSchema(v.newTable, true)
 	}
@@ -51,74 +65,336 @@ func validateColumnHLLConfig(c common.Column) error {
 	return nil
 }
 
-// checks performed:
+// validateNewColumnName checks a column name being introduced to a table:
+//	name cannot be empty
+//	name cannot be a reserved AQL keyword
+//	name cannot duplicate (case-insensitively) any previous column name in the table
+func validateNewColumnName(table *common.Table, columnID int) error {
+	name := table.Columns[columnID].Name
+	if name == "" {
+		return fmt.Errorf("%s: column %d", ErrEmptyColumnName, columnID)
+	}
+	if expr.Lookup(name) != expr.IDENT {
+		return fmt.Errorf("%s: %s", ErrReservedColumnName, name)
+	}
+	for _, other := range table.Columns[:columnID] {
+		if strings.EqualFold(other.Name, name) {
+			return fmt.Errorf("%s: %s, %s", ErrDuplicatedColumnName, other.Name, name)
+		}
+	}
+	return nil
+}
+
+// validateColumnRename checks the new name of a column is neither empty, reserved nor used by any
+// other column case-insensitively.
+func validateColumnRename(table *common.Table, columnID int, newName string) error {
+	if newName == "" {
+		return fmt.Errorf("%s: column %d", ErrEmptyColumnName, columnID)
+	}
+	if expr.Lookup(newName) != expr.IDENT {
+		return fmt.Errorf("%s: %s", ErrReservedColumnName, newName)
+	}
+	for id, other := range table.Columns {
+		if id != columnID && strings.EqualFold(other.Name, newName) {
+			return fmt.Errorf("%s: %s, %s", ErrDuplicatedColumnName, other.Name, newName)
+		}
+	}
+	return nil
+}
+
+// validateDerivedColumn checks a derived column of the table:
+//	only fact tables can have derived columns
+//	derived column is numeric, not time, primary key, sort or hll column, and has no default value
+//	expression only combines numbers and numeric non-derived columns with +, -, * and /
+func validateDerivedColumn(table *common.Table, columnID int) error {
+	column := table.Columns[columnID]
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%s: column %s, %s", ErrInvalidDerivedColumn, column.Name, fmt.Sprintf(format, args...))
+	}
+
+	if !table.IsFactTable {
+		return invalid("only fact tables can have derived columns")
+	}
+	if columnID == 0 || column.HLLConfig.IsHLLColumn || column.DefaultValue != nil ||
+		!memCom.IsNumeric(memCom.DataTypeFromString(column.Type)) {
+		return invalid("derived column must be a numeric column without default value")
+	}
+	if utils.IndexOfInt(table.PrimaryKeyColumns, columnID) >= 0 ||
+		utils.IndexOfInt(table.ArchivingSortColumns, columnID) >= 0 {
+		return invalid("derived column cannot be primary key or sort column")
+	}
+
+	derivedExpr, err := expr.ParseExpr(column.DerivedExpr)
+	if err != nil {
+		return invalid("failed to parse %s: %s", column.DerivedExpr, err.Error())
+	}
+
+	var validate func(e expr.Expr) error
+	validate = func(e expr.Expr) error {
+		switch e := e.(type) {
+		case *expr.ParenExpr:
+			return validate(e.Expr)
+		case *expr.NumberLiteral:
+			return nil
+		case *expr.VarRef:
+			for _, source := range table.Columns {
+				if source.Name == e.Val && !source.Deleted {
+					if source.DerivedExpr != "" || source.HLLConfig.IsHLLColumn ||
+						!memCom.IsNumeric(memCom.DataTypeFromString(source.Type)) {
+						return invalid("source column %s must be a numeric non derived column", e.Val)
+					}
+					return nil
+				}
+			}
+			return invalid("source column %s does not exist", e.Val)
+		case *expr.UnaryExpr:
+			if e.Op == expr.UNARY_MINUS {
+				return validate(e.Expr)
+			}
+		case *expr.BinaryExpr:
+			switch e.Op {
+			case expr.ADD, expr.SUB, expr.MUL, expr.DIV:
+				if err := validate(e.LHS); err != nil {
+					return err
+				}
+				return validate(e.RHS)
+			}
+		}
+		return invalid("expression can only combine columns and numbers with +, -, * and /, but got %s", e.String())
+	}
+	return validate(derivedExpr)
+}
+
+// validateEnumArrayColumn validates the enum array column. Enum arrays can only be used for
+// contains filters and explode dimensions, so they cannot be primary key or sort column.
+func validateEnumArrayColumn(table *common.Table, columnID int) error {
+	column := table.Columns[columnID]
+	if utils.IndexOfInt(table.PrimaryKeyColumns, columnID) >= 0 ||
+		utils.IndexOfInt(table.ArchivingSortColumns, columnID) >= 0 ||
+		column.DefaultValue != nil || column.HLLConfig.IsHLLColumn || column.DerivedExpr != "" {
+		return fmt.Errorf("%s: column %s", ErrInvalidEnumArrayColumn, column.Name)
+	}
+	return nil
+}
+
+// findColumnMissingFromDeletePredicates returns the name of a column referenced by the delete
+// predicates but missing or deleted from the table, or empty string if there is none.
+func findColumnMissingFromDeletePredicates(table *common.Table, predicates []common.DeletePredicate) string {
+	columns := make(map[string]bool, len(table.Columns))
+	for _, column := range table.Columns {
+		if !column.Deleted {
+			columns[column.Name] = true
+		}
+	}
+	for _, predicate := range predicates {
+		filter, err := expr.ParseExpr(predicate.Filter)
+		if err != nil {
+			continue
+		}
+		var missing string
+		expr.WalkFunc(filter, func(e expr.Expr) {
+			if varRef, ok := e.(*expr.VarRef); ok && missing == "" && !columns[varRef.Val] {
+				missing = varRef.Val
+			}
+		})
+		if missing != "" {
+			return missing
+		}
+	}
+	return ""
+}
+
+// RenameDeletePredicateColumn returns the predicates with references to the column in their filters
+// renamed to the new name, and whether any predicate was changed.
+func RenameDeletePredicateColumn(predicates []common.DeletePredicate, columnName, newName string) (
+	[]common.DeletePredicate, bool) {
+	renamed := make([]common.DeletePredicate, len(predicates))
+	changed := false
+	for i, predicate := range predicates {
+		renamed[i] = predicate
+		filter, err := expr.ParseExpr(predicate.Filter)
+		if err != nil {
+			continue
+		}
+		var found bool
+		expr.WalkFunc(filter, func(e expr.Expr) {
+			if varRef, ok := e.(*expr.VarRef); ok && varRef.Val == columnName {
+				varRef.Val = newName
+				found = true
+			}
+		})
+		if found {
+			renamed[i].Filter = filter.String()
+			changed = true
+		}
+	}
+	return renamed, changed
+}
+
+// findDerivedColumnUsingSource returns the name of a derived column of the table computed from
+// the source column, or empty string if there is none.
+func findDerivedColumnUsingSource(table *common.Table, sourceColumn string) string {
+	for _, column := range table.Columns {
+		if column.Deleted || column.DerivedExpr == "" {
+			continue
+		}
+		derivedExpr, err := expr.ParseExpr(column.DerivedExpr)
+		if err != nil {
+			continue
+		}
+		var found bool
+		expr.WalkFunc(derivedExpr, func(e expr.Expr) {
+			if varRef, ok := e.(*expr.VarRef); ok && varRef.Val == sourceColumn {
+				found = true
+			}
+		})
+		if found {
+			return column.Name
+		}
+	}
+	return ""
+}
+
+// checks performed, returning the first error of each column and of each table level check:
 //	table has at least 1 valid column
 //	table has at least 1 valid primary key column
 //  fact table must have a time column as first column
 //	fact table must have sort columns that are valid
 //	each column have valid data type and default value
+//	max enum cardinality is only for enum columns and within the capacity of the enum type
+//	enum array columns are not primary key or sort columns and have no default value
 //	sort columns cannot have duplicate columnID
 //	primary key columns cannot have duplicate columnID
-//	column name cannot duplicate
-func (v tableSchemaValidatorImpl) validateIndividualSchema(table *common.Table, creation bool) (err error) {
-	var colIdDedup []bool
-
+//	column name cannot be empty or duplicate
+//	on creation, column names cannot be reserved or duplicate case-insensitively
+//	archive compression codec is supported
+//	conflict resolution column is an existing uint32 column that is not derived
+//	derived columns are valid
+//	rollup config is valid
+func (v tableSchemaValidatorImpl) validateIndividualSchema(table *common.Table, creation bool) (errs []error) {
 	nonDeletedColumnsCount := 0
 	colNameDedup := make(map[string]bool)
 	for columnID, column := range table.Columns {
 		if !column.Deleted {
 			nonDeletedColumnsCount++
-		} else {
-			if creation {
-				return ErrNewColumnWithDeletion
-			}
 		}
-		if colNameDedup[column.Name] {
-			return ErrDuplicatedColumnName
+		if err := validateColumn(table, columnID, creation, colNameDedup); err != nil {
+			errs = append(errs, err)
 		}
-		colNameDedup[column.Name] = true
+	}
+	if nonDeletedColumnsCount == 0 {
+		errs = append(errs, ErrAllColumnsInvalid)
+	}
 
-		// validate data type
-		if dataType := memCom.DataTypeFromString(column.Type); dataType == memCom.Unknown {
-			return ErrInvalidDataType
-		} else if table.IsFactTable && columnID == 0 && dataType != memCom.Uint32 {
-			return ErrMissingTimeColumn
+	for _, validate := range []func(table *common.Table) error{
+		validatePrimaryKeyColumns,
+		validateSharding,
+		validateArchiveCompression,
+		validateConflictResolutionColumn,
+		validateJSONIngestion,
+		validateRollup,
+		validateSortColumns,
+	} {
+		if err := validate(table); err != nil {
+			errs = append(errs, err)
 		}
+	}
+	return errs
+}
 
-		// validate hll config
-		if err := validateColumnHLLConfig(column); err != nil {
+// validateColumn validates a column of the table, colNameDedup has names of the columns before it.
+func validateColumn(table *common.Table, columnID int, creation bool, colNameDedup map[string]bool) (err error) {
+	column := table.Columns[columnID]
+	if column.Deleted && creation {
+		return ErrNewColumnWithDeletion
+	}
+	if column.Name == "" {
+		return fmt.Errorf("%s: column %d", ErrEmptyColumnName, columnID)
+	}
+	if colNameDedup[column.Name] {
+		return fmt.Errorf("%s: %s", ErrDuplicatedColumnName, column.Name)
+	}
+	colNameDedup[column.Name] = true
+
+	if creation {
+		if err = validateNewColumnName(table, columnID); err != nil {
 			return err
 		}
+	}
 
-		// time column does not allow hll config
-		if table.IsFactTable && columnID == 0 && column.HLLConfig.IsHLLColumn {
-			return ErrTimeColumnDoesNotAllowHLLConfig
+	// validate data type
+	if dataType := memCom.DataTypeFromString(column.Type); dataType == memCom.Unknown && !column.IsEnumArrayColumn() {
+		return ErrInvalidDataType
+	} else if table.IsFactTable && columnID == 0 && dataType != memCom.Uint32 {
+		return ErrMissingTimeColumn
+	}
+
+	// validate hll config
+	if err := validateColumnHLLConfig(column); err != nil {
+		return err
+	}
+
+	if column.IsEnumArrayColumn() && !column.Deleted {
+		if err = validateEnumArrayColumn(table, columnID); err != nil {
+			return err
 		}
+	}
 
-		if column.DefaultValue != nil {
-			if table.IsFactTable && columnID == 0 {
-				return ErrTimeColumnDoesNotAllowDefault
-			}
+	// time column does not allow hll config
+	if table.IsFactTable && columnID == 0 && column.HLLConfig.IsHLLColumn {
+		return ErrTimeColumnDoesNotAllowHLLConfig
+	}
 
-			if column.HLLConfig.IsHLLColumn {
-				return ErrHLLColumnDoesNotAllowDefaultValue
-			}
+	if column.DefaultValue != nil {
+		if table.IsFactTable && columnID == 0 {
+			return ErrTimeColumnDoesNotAllowDefault
+		}
+
+		if column.HLLConfig.IsHLLColumn {
+			return ErrHLLColumnDoesNotAllowDefaultValue
+		}
 
-			err = ValidateDefaultValue(*column.DefaultValue, column.Type)
-			if err != nil {
-				return err
+		err = ValidateDefaultValue(*column.DefaultValue, column.Type)
+		if err != nil {
+			return err
+		}
+	}
+
+	if maxCardinality := column.Config.MaxEnumCardinality; maxCardinality != 0 {
+		if !column.IsEnumColumn() || maxCardinality < 0 || maxCardinality > column.GetMaxEnumCardinality() {
+			return fmt.Errorf("%s: column %s, %d", ErrInvalidMaxEnumCardinality, column.Name, maxCardinality)
+		}
+	}
+
+	if policy := column.Config.NonFinitePolicy; policy != "" {
+		if column.Type != common.Float32 || (policy != common.NonFiniteSkip &&
+			policy != common.NonFiniteReject && policy != common.NonFinitePropagate) {
+			return fmt.Errorf("%s: column %s, %s", ErrInvalidNonFinitePolicy, column.Name, policy)
+		}
+	}
+
+	for _, tenants := range [][]string{column.Config.ReadTenants, column.Config.WriteTenants} {
+		for _, tenant := range tenants {
+			if tenant == "" {
+				return fmt.Errorf("%s: column %s, empty tenant", ErrInvalidColumnACL, column.Name)
 			}
 		}
 	}
-	if nonDeletedColumnsCount == 0 {
-		return ErrAllColumnsInvalid
+
+	if column.DerivedExpr != "" && !column.Deleted {
+		if err = validateDerivedColumn(table, columnID); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
+func validatePrimaryKeyColumns(table *common.Table) error {
 	if len(table.PrimaryKeyColumns) == 0 {
 		return ErrMissingPrimaryKey
 	}
 
-	colIdDedup = make([]bool, len(table.Columns))
+	colIdDedup := make([]bool, len(table.Columns))
 	for _, colId := range table.PrimaryKeyColumns {
 		if colId >= len(table.Columns) {
 			return ErrColumnNonExist
@@ -131,37 +407,214 @@ func (v tableSchemaValidatorImpl) validateIndividualSchema(table *common.Table,
 		}
 		colIdDedup[colId] = true
 	}
+	return nil
+}
+
+func validateSharding(table *common.Table) error {
+	if table.NumShards < 0 || (table.NumShards > 1 && !table.IsFactTable) {
+		return ErrInvalidSharding
+	}
+	if table.NumShards > 1 {
+		if utils.IndexOfInt(table.PrimaryKeyColumns, table.ShardKeyColumn) < 0 ||
+			table.ShardKeyColumn >= len(table.Columns) {
+			return ErrInvalidShardKeyColumn
+		}
+		switch memCom.DataTypeFromString(table.Columns[table.ShardKeyColumn].Type) {
+		case memCom.Uint8, memCom.Int8, memCom.Uint16, memCom.Int16, memCom.Uint32, memCom.Int32,
+			memCom.Int64, memCom.UUID:
+		default:
+			return ErrInvalidShardKeyColumn
+		}
+	}
+	return nil
+}
 
+func validateArchiveCompression(table *common.Table) error {
 	// TODO: checks for config?
+	if memCom.CompressionCodecFromString(table.Config.ArchiveCompression) == memCom.UnknownCompression {
+		return ErrInvalidArchiveCompression
+	}
+	return nil
+}
 
-	if table.IsFactTable {
-		colIdDedup = make([]bool, len(table.Columns))
-		for _, sortColumnId := range table.ArchivingSortColumns {
-			if sortColumnId >= len(table.Columns) {
-				return ErrColumnNonExist
+func validateConflictResolutionColumn(table *common.Table) error {
+	name := table.Config.ConflictResolutionColumn
+	if name == "" {
+		return nil
+	}
+	for _, column := range table.Columns {
+		if column.Name == name && !column.Deleted {
+			if memCom.DataTypeFromString(column.Type) != memCom.Uint32 || column.DerivedExpr != "" {
+				break
 			}
-			if table.Columns[sortColumnId].Deleted {
-				return ErrColumnDeleted
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: %s", ErrInvalidConflictResolutionColumn, name)
+}
+
+func validateJSONIngestion(table *common.Table) error {
+	config := table.Config.JSONIngestion
+	if config == nil {
+		return nil
+	}
+	switch config.MissingPaths {
+	case "", common.JSONMissingPathDefault, common.JSONMissingPathError:
+	default:
+		return fmt.Errorf("%s: missing paths %s", ErrInvalidJSONIngestionConfig, config.MissingPaths)
+	}
+	switch config.Arrays {
+	case "", common.JSONArrayReject, common.JSONArrayJoin:
+	default:
+		return fmt.Errorf("%s: arrays %s", ErrInvalidJSONIngestionConfig, config.Arrays)
+	}
+
+	for path, name := range config.Paths {
+		valid := false
+		for _, column := range table.Columns {
+			if column.Name == name && !column.Deleted {
+				valid = column.DerivedExpr == ""
+				break
 			}
-			if colIdDedup[sortColumnId] {
-				return ErrDuplicatedColumn
+		}
+		if path == "" || !valid {
+			return fmt.Errorf("%s: path %s of column %s", ErrInvalidJSONIngestionConfig, path, name)
+		}
+	}
+	return nil
+}
+
+// validateRollup checks the rollup config of the table:
+//	only fact tables can be rollup tables, not of themselves
+//	time bucket is hour or day
+//	dimensions and measures are distinct non time, non derived columns
+//	dimensions are numeric, bool or uuid columns, measures are numeric columns
+//	count has no base column, other aggregates have one
+//	primary key is the time column plus the dimensions
+//	late arrival window is not limited, which would reject rows of recomputed buckets
+func validateRollup(table *common.Table) error {
+	config := table.Config.Rollup
+	if config == nil {
+		return nil
+	}
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%s: %s", ErrInvalidRollupConfig, fmt.Sprintf(format, args...))
+	}
+	if !table.IsFactTable {
+		return invalid("not a fact table")
+	}
+	if config.BaseTable == "" || config.BaseTable == table.Name {
+		return invalid("base table %s", config.BaseTable)
+	}
+	if config.TimeBucket != common.RollupHour && config.TimeBucket != common.RollupDay {
+		return invalid("time bucket %s", config.TimeBucket)
+	}
+	if table.Config.LateArrivalWindowInSeconds != 0 {
+		return invalid("late arrival window is limited")
+	}
+
+	usedColumns := make(map[int]bool)
+	findColumn := func(name string) (int, error) {
+		for id, column := range table.Columns {
+			if column.Name == name && !column.Deleted {
+				if id == 0 || column.DerivedExpr != "" || usedColumns[id] {
+					break
+				}
+				usedColumns[id] = true
+				return id, nil
 			}
-			colIdDedup[sortColumnId] = true
 		}
+		return 0, invalid("column %s", name)
 	}
 
-	return
+	primaryKeyColumns := []int{0}
+	for _, name := range config.Dimensions {
+		id, err := findColumn(name)
+		if err != nil {
+			return err
+		}
+		switch memCom.DataTypeFromString(table.Columns[id].Type) {
+		case memCom.Bool, memCom.Uint8, memCom.Int8, memCom.Uint16, memCom.Int16, memCom.Uint32, memCom.Int32,
+			memCom.Int64, memCom.Float32, memCom.UUID:
+		default:
+			return invalid("dimension %s of type %s", name, table.Columns[id].Type)
+		}
+		primaryKeyColumns = append(primaryKeyColumns, id)
+	}
+
+	if len(config.Measures) == 0 {
+		return invalid("no measures")
+	}
+	for _, measure := range config.Measures {
+		id, err := findColumn(measure.Column)
+		if err != nil {
+			return err
+		}
+		switch memCom.DataTypeFromString(table.Columns[id].Type) {
+		case memCom.Uint8, memCom.Int8, memCom.Uint16, memCom.Int16, memCom.Uint32, memCom.Int32,
+			memCom.Int64, memCom.Float32:
+		default:
+			return invalid("measure %s of type %s", measure.Column, table.Columns[id].Type)
+		}
+		switch measure.Aggregate {
+		case common.RollupCount:
+			if measure.BaseColumn != "" {
+				return invalid("measure %s counts base column %s", measure.Column, measure.BaseColumn)
+			}
+		case common.RollupSum, common.RollupMin, common.RollupMax:
+			if measure.BaseColumn == "" {
+				return invalid("measure %s has no base column", measure.Column)
+			}
+		default:
+			return invalid("measure %s aggregate %s", measure.Column, measure.Aggregate)
+		}
+	}
+
+	sortedPrimaryKeyColumns := append([]int(nil), table.PrimaryKeyColumns...)
+	sort.Ints(sortedPrimaryKeyColumns)
+	sort.Ints(primaryKeyColumns)
+	if !reflect.DeepEqual(sortedPrimaryKeyColumns, primaryKeyColumns) {
+		return invalid("primary key is not the time column and the dimensions")
+	}
+	return nil
 }
 
-// checks performed
+func validateSortColumns(table *common.Table) error {
+	if !table.IsFactTable {
+		return nil
+	}
+	colIdDedup := make([]bool, len(table.Columns))
+	for _, sortColumnId := range table.ArchivingSortColumns {
+		if sortColumnId >= len(table.Columns) {
+			return ErrColumnNonExist
+		}
+		if table.Columns[sortColumnId].Deleted {
+			return ErrColumnDeleted
+		}
+		if colIdDedup[sortColumnId] {
+			return ErrDuplicatedColumn
+		}
+		colIdDedup[sortColumnId] = true
+	}
+	return nil
+}
+
+// checks performed, returning all errors of the new table and the first error of the update
 //	check that new table is valid table
 //	check new table has larger version number
-//	check no changes on immutable fields (table name, type, pk)
+//	check no changes on immutable fields (table name, type, pk, sharding, rollup config)
 //	check updates on columns and sort columns are valid
-func (v tableSchemaValidatorImpl) validateSchemaUpdate(newTable, oldTable *common.Table) (err error) {
-	if err := v.validateIndividualSchema(newTable, false); err != nil {
-		return err
+//	check names of newly added columns are not reserved or duplicate case-insensitively
+func (v tableSchemaValidatorImpl) validateSchemaUpdate(newTable, oldTable *common.Table) (errs []error) {
+	errs = v.validateIndividualSchema(newTable, false)
+	if err := validateUpdate(newTable, oldTable); err != nil {
+		errs = append(errs, err)
 	}
+	return errs
+}
+
+// validateUpdate checks the changes from the old table to the new table.
+func validateUpdate(newTable, oldTable *common.Table) (err error) {
 
 	if newTable.Name != oldTable.Name {
 		return ErrSchemaUpdateNotAllowed
@@ -181,6 +634,11 @@ func (v tableSchemaValidatorImpl) validateSchemaUpdate(newTable, oldTable *commo
 		return ErrDisallowMissingEventTime
 	}
 
+	// rows already aggregated would not match the new rollup config.
+	if !reflect.DeepEqual(newTable.Config.Rollup, oldTable.Config.Rollup) {
+		return ErrSchemaUpdateNotAllowed
+	}
+
 	var i int
 
 	for i = 0; i < len(oldTable.Columns); i++ {
@@ -197,6 +655,7 @@ func (v tableSchemaValidatorImpl) validateSchemaUpdate(newTable, oldTable *commo
 			!reflect.DeepEqual(oldCol.DefaultValue, newCol.DefaultValue) ||
 			oldCol.CaseInsensitive != newCol.CaseInsensitive ||
 			oldCol.DisableAutoExpand != newCol.DisableAutoExpand ||
+			oldCol.DerivedExpr != newCol.DerivedExpr ||
 			oldCol.HLLConfig != newCol.HLLConfig {
 			return ErrSchemaUpdateNotAllowed
 		}
@@ -207,6 +666,9 @@ func (v tableSchemaValidatorImpl) validateSchemaUpdate(newTable, oldTable *commo
 		if newCol.Deleted {
 			return ErrNewColumnWithDeletion
 		}
+		if err = validateNewColumnName(newTable, i); err != nil {
+			return err
+		}
 	}
 	// end validate columns
 
@@ -215,6 +677,10 @@ func (v tableSchemaValidatorImpl) validateSchemaUpdate(newTable, oldTable *commo
 		return ErrChangePrimaryKeyColumn
 	}
 
+	if newTable.NumShards != oldTable.NumShards || newTable.ShardKeyColumn != oldTable.ShardKeyColumn {
+		return ErrChangeSharding
+	}
+
 	// sort columns
 	if len(newTable.ArchivingSortColumns) < len(oldTable.ArchivingSortColumns) {
 		return ErrIllegalChangeSortColumn
@@ -236,6 +702,44 @@ func (v tableSchemaValidatorImpl) validateSchemaUpdate(newTable, oldTable *commo
 	return
 }
 
+// rollbackSchema builds the schema rolling back currentTable to targetTable.
+// Column ids are never reused, so columns deleted since the target version stay deleted.
+// Columns added since the target version are kept if already deleted, and rollback is
+// rejected if any of them still holds data. The result is validated as a schema update.
+func rollbackSchema(validator TableSchemaValidator, currentTable, targetTable *common.Table) (*common.Table, error) {
+	if len(targetTable.Columns) > len(currentTable.Columns) {
+		return nil, ErrSchemaUpdateNotAllowed
+	}
+
+	table := *targetTable
+	table.Columns = make([]common.Column, len(currentTable.Columns))
+	copy(table.Columns, targetTable.Columns)
+
+	var droppedColumns []string
+	for id, column := range currentTable.Columns {
+		if id >= len(targetTable.Columns) {
+			if !column.Deleted {
+				droppedColumns = append(droppedColumns, column.Name)
+			}
+			table.Columns[id] = column
+		} else if column.Deleted {
+			table.Columns[id] = column
+		}
+	}
+
+	if len(droppedColumns) > 0 {
+		return nil, fmt.Errorf("%s: table %s, version %d, columns: %s",
+			ErrRollbackDropsColumns, targetTable.Name, targetTable.Version, strings.Join(droppedColumns, ", "))
+	}
+
+	validator.SetOldTable(*currentTable)
+	validator.SetNewTable(table)
+	if err := validator.Validate(); err != nil {
+		return nil, err
+	}
+	return &table, nil
+}
+
 // ValidateDefaultValue validates default value against data type
 func ValidateDefaultValue(valueStr, dataTypeStr string) (err error) {
 	dataType := memCom.DataTypeFromString(dataTypeStr)
@@ -74,7 +74,75 @@ var _ = ginkgo.Describe("Validator", func() {
 		validator := NewTableSchameValidator()
 		validator.SetNewTable(table)
 		err := validator.Validate()
-		Ω(err).Should(Equal(ErrDuplicatedColumnName))
+		Ω(err).Should(MatchError(ErrDuplicatedColumnName.Error() + ": col1"))
+	})
+
+	ginkgo.It("should reject invalid column names", func() {
+		testCases := []struct {
+			columnNames []string
+			expectedErr string
+		}{
+			{[]string{"col1", ""}, ErrEmptyColumnName.Error() + ": column 1"},
+			{[]string{"col1", "Col1"}, ErrDuplicatedColumnName.Error() + ": col1, Col1"},
+			{[]string{"col1", "KEY"}, ErrReservedColumnName.Error() + ": KEY"},
+			{[]string{"col1", "from"}, ErrReservedColumnName.Error() + ": from"},
+			{[]string{"null", "col1"}, ErrReservedColumnName.Error() + ": null"},
+		}
+
+		for _, testCase := range testCases {
+			table := common.Table{
+				Name:              "testTable",
+				PrimaryKeyColumns: []int{0},
+			}
+			for _, name := range testCase.columnNames {
+				table.Columns = append(table.Columns, common.Column{Name: name, Type: "Uint32"})
+			}
+			validator := NewTableSchameValidator()
+			validator.SetNewTable(table)
+			Ω(validator.Validate()).Should(MatchError(testCase.expectedErr), "columns %v", testCase.columnNames)
+		}
+	})
+
+	ginkgo.It("should reject invalid names for new columns on update", func() {
+		oldTable := common.Table{
+			Name: "testTable",
+			Columns: []common.Column{
+				{Name: "col1", Type: "Uint32"},
+				// existing column with reserved name is grandfathered
+				{Name: "key", Type: "Uint32"},
+			},
+			PrimaryKeyColumns: []int{0},
+			Version:           0,
+		}
+
+		testCases := []struct {
+			columnName  string
+			expectedErr string
+		}{
+			{"", ErrEmptyColumnName.Error() + ": column 2"},
+			{"COL1", ErrDuplicatedColumnName.Error() + ": col1, COL1"},
+			{"order", ErrReservedColumnName.Error() + ": order"},
+		}
+
+		for _, testCase := range testCases {
+			newTable := oldTable
+			newTable.Columns = append([]common.Column{}, oldTable.Columns...)
+			newTable.Columns = append(newTable.Columns, common.Column{Name: testCase.columnName, Type: "Uint32"})
+			newTable.Version = 1
+			validator := NewTableSchameValidator()
+			validator.SetOldTable(oldTable)
+			validator.SetNewTable(newTable)
+			Ω(validator.Validate()).Should(MatchError(testCase.expectedErr), "column %s", testCase.columnName)
+		}
+
+		newTable := oldTable
+		newTable.Columns = append([]common.Column{}, oldTable.Columns...)
+		newTable.Columns = append(newTable.Columns, common.Column{Name: "col2", Type: "Uint32"})
+		newTable.Version = 1
+		validator := NewTableSchameValidator()
+		validator.SetOldTable(oldTable)
+		validator.SetNewTable(newTable)
+		Ω(validator.Validate()).Should(BeNil())
 	})
 
 	ginkgo.It("should return err for dup column", func() {
@@ -544,6 +612,13 @@ var _ = ginkgo.Describe("Validator", func() {
 
 		Ω(ValidateDefaultValue("1.s", common.Float32)).ShouldNot(BeNil())
 		Ω(ValidateDefaultValue("0.0", common.Float32)).Should(BeNil())
+		Ω(ValidateDefaultValue("-1.5", common.Float32)).Should(BeNil())
+
+		Ω(ValidateDefaultValue("1.5", common.Int32)).ShouldNot(BeNil())
+		Ω(ValidateDefaultValue("-1", common.Int32)).Should(BeNil())
+
+		Ω(ValidateDefaultValue("any string", common.SmallEnum)).Should(BeNil())
+		Ω(ValidateDefaultValue("any string", common.BigEnum)).Should(BeNil())
 
 		Ω(ValidateDefaultValue("00000000000000000000000000000000000000", common.UUID)).ShouldNot(BeNil())
 		Ω(ValidateDefaultValue("2cdc434e-4752-11e8-842f-0ed5f89f718b", common.UUID)).Should(BeNil())
@@ -691,6 +766,294 @@ var _ = ginkgo.Describe("Validator", func() {
 		Ω(err).Should(BeNil())
 	})
 
+	ginkgo.It("should validate archive compression", func() {
+		table := common.Table{
+			Name: "testTable",
+			Columns: []common.Column{
+				{
+					Name: "col1",
+					Type: "Uint32",
+				},
+			},
+			PrimaryKeyColumns: []int{0},
+			IsFactTable:       true,
+			Config: common.TableConfig{
+				ArchiveCompression: "gzip",
+			},
+		}
+		validator := NewTableSchameValidator()
+		validator.SetNewTable(table)
+		Ω(validator.Validate()).Should(BeNil())
+
+		table.Config.ArchiveCompression = "lzma"
+		validator.SetNewTable(table)
+		Ω(validator.Validate()).Should(Equal(ErrInvalidArchiveCompression))
+	})
+
+	ginkgo.It("should validate conflict resolution column", func() {
+		table := common.Table{
+			Name: "testTable",
+			Columns: []common.Column{
+				{
+					Name: "col1",
+					Type: "Uint32",
+				},
+				{
+					Name: "col2",
+					Type: "Uint32",
+				},
+				{
+					Name: "col3",
+					Type: "Int64",
+				},
+			},
+			PrimaryKeyColumns: []int{0},
+			Config: common.TableConfig{
+				ConflictResolutionColumn: "col2",
+			},
+		}
+		validator := NewTableSchameValidator()
+		validator.SetNewTable(table)
+		Ω(validator.Validate()).Should(BeNil())
+
+		for _, name := range []string{"col3", "col4"} {
+			table.Config.ConflictResolutionColumn = name
+			validator.SetNewTable(table)
+			Ω(validator.Validate().Error()).Should(ContainSubstring(ErrInvalidConflictResolutionColumn.Error()))
+		}
+	})
+
+	ginkgo.It("should validate json ingestion config", func() {
+		table := common.Table{
+			Name: "testTable",
+			Columns: []common.Column{
+				{
+					Name: "col1",
+					Type: "Uint32",
+				},
+				{
+					Name: "col2",
+					Type: "SmallEnum",
+				},
+			},
+			PrimaryKeyColumns: []int{0},
+			Config: common.TableConfig{
+				JSONIngestion: &common.JSONIngestionConfig{
+					Paths:        map[string]string{"user.country": "col2"},
+					MissingPaths: common.JSONMissingPathError,
+					Arrays:       common.JSONArrayJoin,
+				},
+			},
+		}
+		validator := NewTableSchameValidator()
+		validator.SetNewTable(table)
+		Ω(validator.Validate()).Should(BeNil())
+
+		for _, config := range []common.JSONIngestionConfig{
+			{MissingPaths: "null"},
+			{Arrays: "flatten"},
+			{Paths: map[string]string{"user.id": "col4"}},
+			{Paths: map[string]string{"": "col2"}},
+		} {
+			config := config
+			table.Config.JSONIngestion = &config
+			validator.SetNewTable(table)
+			Ω(validator.Validate().Error()).Should(ContainSubstring(ErrInvalidJSONIngestionConfig.Error()))
+		}
+	})
+
+	ginkgo.It("should validate sharding", func() {
+		table := common.Table{
+			Name: "testTable",
+			Columns: []common.Column{
+				{
+					Name: "col1",
+					Type: "Uint32",
+				},
+				{
+					Name: "col2",
+					Type: "UUID",
+				},
+				{
+					Name: "col3",
+					Type: "Float32",
+				},
+			},
+			PrimaryKeyColumns: []int{1, 2},
+			IsFactTable:       true,
+			NumShards:         4,
+			ShardKeyColumn:    1,
+		}
+		validator := NewTableSchameValidator()
+		validator.SetNewTable(table)
+		Ω(validator.Validate()).Should(BeNil())
+
+		// shard key must be an integer or uuid primary key column.
+		table.ShardKeyColumn = 0
+		validator.SetNewTable(table)
+		Ω(validator.Validate()).Should(Equal(ErrInvalidShardKeyColumn))
+		table.ShardKeyColumn = 2
+		validator.SetNewTable(table)
+		Ω(validator.Validate()).Should(Equal(ErrInvalidShardKeyColumn))
+		table.ShardKeyColumn = 1
+
+		table.NumShards = -1
+		validator.SetNewTable(table)
+		Ω(validator.Validate()).Should(Equal(ErrInvalidSharding))
+		table.NumShards = 4
+
+		table.IsFactTable = false
+		validator.SetNewTable(table)
+		Ω(validator.Validate()).Should(Equal(ErrInvalidSharding))
+		table.IsFactTable = true
+
+		// sharding cannot be changed.
+		newTable := table
+		newTable.NumShards = 8
+		newTable.Version = 1
+		validator.SetOldTable(table)
+		validator.SetNewTable(newTable)
+		Ω(validator.Validate()).Should(Equal(ErrChangeSharding))
+	})
+
+	ginkgo.It("should validate max enum cardinality", func() {
+		table := common.Table{
+			Name: "testTable",
+			Columns: []common.Column{
+				{
+					Name: "col1",
+					Type: "Uint32",
+				},
+				{
+					Name:   "col2",
+					Type:   "SmallEnum",
+					Config: common.ColumnConfig{MaxEnumCardinality: 100},
+				},
+			},
+			PrimaryKeyColumns: []int{0},
+		}
+		validator := NewTableSchameValidator()
+		validator.SetNewTable(table)
+		Ω(validator.Validate()).Should(BeNil())
+		Ω(table.Columns[1].GetMaxEnumCardinality()).Should(Equal(100))
+
+		table.Columns[1].Config.MaxEnumCardinality = 1000
+		validator.SetNewTable(table)
+		Ω(validator.Validate().Error()).Should(ContainSubstring(ErrInvalidMaxEnumCardinality.Error()))
+
+		table.Columns[1].Type = "BigEnum"
+		validator.SetNewTable(table)
+		Ω(validator.Validate()).Should(BeNil())
+
+		table.Columns[1].Config.MaxEnumCardinality = 0
+		Ω(table.Columns[1].GetMaxEnumCardinality()).Should(Equal(0x10000))
+
+		table.Columns[0].Config.MaxEnumCardinality = 10
+		validator.SetNewTable(table)
+		Ω(validator.Validate().Error()).Should(ContainSubstring(ErrInvalidMaxEnumCardinality.Error()))
+	})
+
+	ginkgo.It("ValidateAll should return all errors", func() {
+		defaultValue := "abc"
+		table := common.Table{
+			Name:        "testTable",
+			IsFactTable: true,
+			Columns: []common.Column{
+				{Name: "col1", Type: "Uint32"},
+				{Name: "col2", Type: "Uint32", DefaultValue: &defaultValue},
+				{Name: "col3", Type: "Unknown"},
+				{Name: "col1", Type: "Uint32"},
+			},
+			PrimaryKeyColumns:    []int{1, 1},
+			ArchivingSortColumns: []int{10},
+		}
+		validator := NewTableSchameValidator()
+		validator.SetNewTable(table)
+		errs := validator.ValidateAll()
+		Ω(errs).Should(HaveLen(5))
+		Ω(errs[0].Error()).Should(ContainSubstring("invalid value abc for type Uint32"))
+		Ω(errs[1]).Should(Equal(ErrInvalidDataType))
+		Ω(errs[2].Error()).Should(ContainSubstring(ErrDuplicatedColumnName.Error()))
+		Ω(errs[3]).Should(Equal(ErrDuplicatedColumn))
+		Ω(errs[4]).Should(Equal(ErrColumnNonExist))
+		Ω(validator.Validate().Error()).Should(ContainSubstring("invalid value abc for type Uint32"))
+
+		// update errors are returned after the errors of the new table.
+		oldTable := table
+		oldTable.IsFactTable = false
+		validator.SetOldTable(oldTable)
+		errs = validator.ValidateAll()
+		Ω(errs).Should(HaveLen(6))
+		Ω(errs[5]).Should(Equal(ErrSchemaUpdateNotAllowed))
+	})
+
+	ginkgo.It("should validate non finite policy", func() {
+		table := common.Table{
+			Name: "testTable",
+			Columns: []common.Column{
+				{
+					Name: "col1",
+					Type: "Uint32",
+				},
+				{
+					Name: "col2",
+					Type: "Float32",
+				},
+			},
+			PrimaryKeyColumns: []int{0},
+		}
+		validator := NewTableSchameValidator()
+		validator.SetNewTable(table)
+		Ω(validator.Validate()).Should(BeNil())
+		Ω(table.Columns[1].GetNonFinitePolicy()).Should(Equal(common.NonFiniteSkip))
+
+		table.Columns[1].Config.NonFinitePolicy = common.NonFiniteReject
+		validator.SetNewTable(table)
+		Ω(validator.Validate()).Should(BeNil())
+		Ω(table.Columns[1].GetNonFinitePolicy()).Should(Equal(common.NonFiniteReject))
+
+		table.Columns[1].Config.NonFinitePolicy = "ignore"
+		validator.SetNewTable(table)
+		Ω(validator.Validate().Error()).Should(ContainSubstring(ErrInvalidNonFinitePolicy.Error()))
+
+		table.Columns[1].Config.NonFinitePolicy = ""
+		table.Columns[0].Config.NonFinitePolicy = common.NonFinitePropagate
+		validator.SetNewTable(table)
+		Ω(validator.Validate().Error()).Should(ContainSubstring(ErrInvalidNonFinitePolicy.Error()))
+	})
+
+	ginkgo.It("should validate column acl", func() {
+		table := common.Table{
+			Name: "testTable",
+			Columns: []common.Column{
+				{
+					Name: "col1",
+					Type: "Uint32",
+				},
+				{
+					Name: "col2",
+					Type: "Float32",
+					Config: common.ColumnConfig{
+						ReadTenants:  []string{"finance"},
+						WriteTenants: []string{"billing"},
+					},
+				},
+			},
+			PrimaryKeyColumns: []int{0},
+		}
+		validator := NewTableSchameValidator()
+		validator.SetNewTable(table)
+		Ω(validator.Validate()).Should(BeNil())
+		Ω(table.Columns[1].CanRead("finance")).Should(BeTrue())
+		Ω(table.Columns[1].CanRead("billing")).Should(BeFalse())
+		Ω(table.Columns[1].CanWrite("billing")).Should(BeTrue())
+		Ω(table.Columns[0].CanRead("billing")).Should(BeTrue())
+
+		table.Columns[1].Config.WriteTenants = []string{""}
+		validator.SetNewTable(table)
+		Ω(validator.Validate().Error()).Should(ContainSubstring(ErrInvalidColumnACL.Error()))
+	})
+
 	ginkgo.It("should fail when hll config is invalid", func() {
 		table1 := common.Table{
 			Name: "testTable",
@@ -739,4 +1102,182 @@ var _ = ginkgo.Describe("Validator", func() {
 		err = validator.Validate()
 		Ω(err).Should(Equal(ErrTimeColumnDoesNotAllowHLLConfig))
 	})
+
+	ginkgo.It("should validate derived columns", func() {
+		table := common.Table{
+			Name: "testTable",
+			Columns: []common.Column{
+				{Name: "ts", Type: "Uint32"},
+				{Name: "id", Type: "Uint32"},
+				{Name: "clicks", Type: "Uint32"},
+				{Name: "impressions", Type: "Uint32"},
+				{Name: "ctr", Type: "Float32", DerivedExpr: "clicks * 100 / impressions"},
+			},
+			PrimaryKeyColumns: []int{1},
+			IsFactTable:       true,
+		}
+		validator := NewTableSchameValidator()
+		validator.SetNewTable(table)
+		Ω(validator.Validate()).Should(BeNil())
+		Ω(findDerivedColumnUsingSource(&table, "impressions")).Should(Equal("ctr"))
+		Ω(findDerivedColumnUsingSource(&table, "id")).Should(BeEmpty())
+
+		tests := map[string]string{
+			"clicks / missing":      "source column missing does not exist",
+			"clicks > impressions":  "expression can only combine columns and numbers",
+			"length(clicks)":        "expression can only combine columns and numbers",
+			"clicks / (impressions": "failed to parse",
+		}
+		for derivedExpr, expected := range tests {
+			table.Columns[4].DerivedExpr = derivedExpr
+			validator.SetNewTable(table)
+			err := validator.Validate()
+			Ω(err).ShouldNot(BeNil(), derivedExpr)
+			Ω(err.Error()).Should(ContainSubstring(ErrInvalidDerivedColumn.Error()), derivedExpr)
+			Ω(err.Error()).Should(ContainSubstring(expected), derivedExpr)
+		}
+
+		table.Columns[4].DerivedExpr = "clicks / impressions"
+		table.Columns[3].DerivedExpr = "clicks * 2"
+		validator.SetNewTable(table)
+		Ω(validator.Validate().Error()).Should(ContainSubstring("source column impressions must be a numeric non derived column"))
+
+		table.Columns[3].DerivedExpr = ""
+		table.IsFactTable = false
+		validator.SetNewTable(table)
+		Ω(validator.Validate().Error()).Should(ContainSubstring("only fact tables can have derived columns"))
+
+		// derived expression is immutable.
+		table.IsFactTable = true
+		newTable := table
+		newTable.Columns = append([]common.Column(nil), table.Columns...)
+		newTable.Columns[4].DerivedExpr = "clicks * impressions"
+		newTable.Version = 1
+		validator.SetOldTable(table)
+		validator.SetNewTable(newTable)
+		Ω(validator.Validate()).Should(Equal(ErrSchemaUpdateNotAllowed))
+	})
+
+	ginkgo.It("should validate rollup config", func() {
+		table := common.Table{
+			Name: "trips_hourly",
+			Columns: []common.Column{
+				{Name: "hour", Type: "Uint32"},
+				{Name: "city_id", Type: "Uint16"},
+				{Name: "trips", Type: "Uint32"},
+				{Name: "total_fare", Type: "Float32"},
+				{Name: "status", Type: "SmallEnum"},
+			},
+			PrimaryKeyColumns: []int{1, 0},
+			IsFactTable:       true,
+			Config: common.TableConfig{
+				Rollup: &common.RollupConfig{
+					BaseTable:  "trips",
+					TimeBucket: common.RollupHour,
+					Dimensions: []string{"city_id"},
+					Measures: []common.RollupMeasure{
+						{Column: "trips", Aggregate: common.RollupCount},
+						{Column: "total_fare", Aggregate: common.RollupSum, BaseColumn: "fare"},
+					},
+				},
+			},
+		}
+		validator := NewTableSchameValidator()
+		validator.SetNewTable(table)
+		Ω(validator.Validate()).Should(BeNil())
+
+		tests := map[string]func(config *common.RollupConfig){
+			"base table trips_hourly": func(config *common.RollupConfig) {
+				config.BaseTable = "trips_hourly"
+			},
+			"time bucket minute": func(config *common.RollupConfig) {
+				config.TimeBucket = "minute"
+			},
+			"dimension status of type SmallEnum": func(config *common.RollupConfig) {
+				config.Dimensions = []string{"city_id", "status"}
+			},
+			"column hour": func(config *common.RollupConfig) {
+				config.Dimensions = []string{"hour"}
+			},
+			"column city_id": func(config *common.RollupConfig) {
+				config.Measures = append(config.Measures, common.RollupMeasure{Column: "city_id", Aggregate: common.RollupMax})
+			},
+			"measure total_fare has no base column": func(config *common.RollupConfig) {
+				config.Measures[1].BaseColumn = ""
+			},
+			"measure trips counts base column fare": func(config *common.RollupConfig) {
+				config.Measures[0].BaseColumn = "fare"
+			},
+			"measure trips aggregate avg": func(config *common.RollupConfig) {
+				config.Measures[0].Aggregate = "avg"
+			},
+			"primary key is not the time column and the dimensions": func(config *common.RollupConfig) {
+				config.Dimensions = nil
+			},
+		}
+		for expected, mutate := range tests {
+			config := *table.Config.Rollup
+			config.Dimensions = append([]string(nil), config.Dimensions...)
+			config.Measures = append([]common.RollupMeasure(nil), config.Measures...)
+			mutate(&config)
+			invalidTable := table
+			invalidTable.Config.Rollup = &config
+			validator.SetNewTable(invalidTable)
+			err := validator.Validate()
+			Ω(err).ShouldNot(BeNil(), expected)
+			Ω(err.Error()).Should(ContainSubstring(ErrInvalidRollupConfig.Error()), expected)
+			Ω(err.Error()).Should(ContainSubstring(expected), expected)
+		}
+
+		// rollup config is immutable.
+		newTable := table
+		newTable.Config.Rollup = &common.RollupConfig{
+			BaseTable:  "trips",
+			TimeBucket: common.RollupDay,
+			Dimensions: []string{"city_id"},
+			Measures:   []common.RollupMeasure{{Column: "trips", Aggregate: common.RollupCount}},
+		}
+		newTable.Version = 1
+		validator.SetOldTable(table)
+		validator.SetNewTable(newTable)
+		Ω(validator.Validate()).Should(Equal(ErrSchemaUpdateNotAllowed))
+	})
+
+	ginkgo.It("should validate enum array columns", func() {
+		table := common.Table{
+			Name: "testTable",
+			Columns: []common.Column{
+				{Name: "ts", Type: "Uint32"},
+				{Name: "id", Type: "Uint32"},
+				{Name: "tags", Type: common.EnumArray},
+			},
+			PrimaryKeyColumns: []int{1},
+			IsFactTable:       true,
+		}
+		validator := NewTableSchameValidator()
+		validator.SetNewTable(table)
+		Ω(validator.Validate()).Should(BeNil())
+		Ω(table.Columns[2].IsEnumColumn()).Should(BeTrue())
+		Ω(table.Columns[2].GetMaxEnumCardinality()).Should(Equal(common.EnumArrayCapacity))
+
+		defaultValue := "a"
+		table.Columns[2].DefaultValue = &defaultValue
+		validator.SetNewTable(table)
+		Ω(validator.Validate().Error()).Should(ContainSubstring(ErrInvalidEnumArrayColumn.Error()))
+
+		table.Columns[2].DefaultValue = nil
+		table.ArchivingSortColumns = []int{2}
+		validator.SetNewTable(table)
+		Ω(validator.Validate().Error()).Should(ContainSubstring(ErrInvalidEnumArrayColumn.Error()))
+
+		table.ArchivingSortColumns = nil
+		table.PrimaryKeyColumns = []int{2}
+		validator.SetNewTable(table)
+		Ω(validator.Validate().Error()).Should(ContainSubstring(ErrInvalidEnumArrayColumn.Error()))
+
+		table.PrimaryKeyColumns = []int{1}
+		table.Columns[2].Config.MaxEnumCardinality = common.EnumArrayCapacity + 1
+		validator.SetNewTable(table)
+		Ω(validator.Validate()).ShouldNot(BeNil())
+	})
 })
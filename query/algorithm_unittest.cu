@@ -12,6 +12,7 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+#include <thrust/copy.h>
 #include <thrust/transform.h>
 #include <cstdint>
 #include <cfloat>
@@ -1194,6 +1195,83 @@ TEST(SortAndReduceTest, CheckReduceByAvg) {
   EXPECT_TRUE(equal(outputDimValues, outputDimValues + 30, expectedDimValues));
 }
 
+// cppcheck-suppress *
+TEST(SortAndReduceTest, CheckNullDimension) {
+  // 4 rows of a 4-byte dim: 7, null with value 3, null with value 5, 7.
+  uint32_t inputValuesH[4] = {7, 3, 5, 7};
+  bool inputNullsH[4] = {true, false, false, true};
+  thrust::zip_iterator<thrust::tuple<uint32_t *, bool *>> inputIter(
+      thrust::make_tuple(&inputValuesH[0], &inputNullsH[0]));
+
+  // numBytes=(4+1)*4=20
+  uint8_t inputDimValuesH[20];
+  thrust::fill(std::begin(inputDimValuesH), std::end(inputDimValuesH), 0xFF);
+  thrust::copy(inputIter, inputIter + 4,
+               make_dimension_output_iterator<uint32_t>(
+                   &inputDimValuesH[0], &inputDimValuesH[16]));
+
+  uint64_t inputHashValuesH[4] = {0};
+  uint32_t inputIndexVectorH[4] = {0, 1, 2, 3};
+  uint32_t inputCountsH[4] = {1, 1, 1, 1};
+
+  uint8_t outputDimValuesH[20] = {0};
+  uint64_t outputHashValuesH[4] = {0};
+  uint32_t outputIndexVectorH[4] = {0};
+  uint32_t outputCountsH[4] = {0};
+
+  uint8_t *inputDimValues = allocate(inputDimValuesH, 20);
+  uint64_t *inputHashValues = allocate(inputHashValuesH, 4);
+  uint32_t *inputIndexVector = allocate(inputIndexVectorH, 4);
+  uint32_t *inputCounts = allocate(inputCountsH, 4);
+
+  uint8_t *outputDimValues = allocate(outputDimValuesH, 20);
+  uint64_t *outputHashValues = allocate(outputHashValuesH, 4);
+  uint32_t *outputIndexVector = allocate(outputIndexVectorH, 4);
+  uint32_t *outputCounts = allocate(outputCountsH, 4);
+
+  int length = 4;
+  int vectorCapacity = 4;
+  DimensionColumnVector inputKeys = {
+      inputDimValues,
+      inputHashValues,
+      inputIndexVector,
+      vectorCapacity,
+      {(uint8_t)0, (uint8_t)0, (uint8_t)1, (uint8_t)0, (uint8_t)0}};
+
+  DimensionColumnVector outputKeys = {
+      outputDimValues,
+      outputHashValues,
+      outputIndexVector,
+      vectorCapacity,
+      {(uint8_t)0, (uint8_t)0, (uint8_t)1, (uint8_t)0, (uint8_t)0}};
+  Sort(inputKeys, reinterpret_cast<uint8_t *>(inputCounts), 4, length, 0, 0);
+  CGoCallResHandle
+      resHandle = Reduce(inputKeys,
+                         reinterpret_cast<uint8_t *>(inputCounts),
+                         outputKeys,
+                         reinterpret_cast<uint8_t *>(outputCounts),
+                         4,
+                         length,
+                         AGGR_SUM_UNSIGNED,
+                         0,
+                         0);
+  // both nulls form a single group whatever their value bytes were.
+  EXPECT_EQ(reinterpret_cast<int64_t>(resHandle.res), 2);
+  EXPECT_EQ(resHandle.pStrErr, nullptr);
+
+  uint32_t expectedCounts[2] = {2, 2};
+  EXPECT_TRUE(equal(outputCounts, outputCounts + 2, expectedCounts));
+
+  // groups are ordered by hash, the null group first or second.
+  uint8_t expectedDimValues1[20] = {
+      0, 0, 0, 0, 7, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0};
+  uint8_t expectedDimValues2[20] = {
+      7, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0};
+  EXPECT_TRUE(
+      equal(outputDimValues, outputDimValues + 20, expectedDimValues1) ||
+      equal(outputDimValues, outputDimValues + 20, expectedDimValues2));
+}
+
 // cppcheck-suppress *
 TEST(SortAndReduceTest, CheckHash) {
   int size = 8;
@@ -38,6 +38,12 @@ type Dimension struct {
 
 	// Bucketizes numeric dimensions for integers and floating point numbers.
 	NumericBucketizer NumericBucketizerDef `json:"numericBucketizer,omitempty"`
+
+	// Fills the time buckets within the time filter missing from the result so that the time
+	// dimension is a dense series, either "zero" or "null". Only supported for minute, hour and
+	// day based time bucketizers of the last dimension. Buckets are filled under every group of
+	// the other dimensions after having is applied and before limit.
+	Fill string `json:"fill,omitempty"`
 }
 
 // NumericBucketizerDef defines how numbers should be bucketized before being
@@ -64,7 +70,12 @@ type NumericBucketizerDef struct {
 
 // Measure specifies a group level aggregation measure.
 type Measure struct {
-	// The SQL expression for computing the measure.
+	// The SQL expression for computing the measure. It can be an aggregate, or aggregates
+	// combined with numbers using +, -, * and /, e.g. "sum(clicks)/sum(impressions)",
+	// which is evaluated per group after aggregation. Dividing by zero yields NULL.
+	// Each aggregate can only aggregate the rows matching its own filter, e.g.
+	// "sum(fare) FILTER (WHERE status = 'completed')". Filters of sum and count are evaluated
+	// within the aggregate, so other rows are still scanned and their groups yield 0.
 	Expr string `json:"sqlExpression"`
 	expr expr.Expr
 
@@ -72,6 +83,23 @@ type Measure struct {
 	// The filters are ANDed togther.
 	Filters []string `json:"rowFilters,omitempty"`
 	filters []expr.Expr
+
+	// Transforms the measure across the buckets of the last dimension ordered by their values,
+	// either "cumulative" for the running total or "moving_average" for the average over the last
+	// Window buckets. Each group of the other dimensions is transformed separately, the NULL
+	// bucket is left as is. Applied after fill and before limit.
+	Running string `json:"running,omitempty"`
+	// Number of buckets averaged by moving_average, including the current bucket.
+	Window int `json:"window,omitempty"`
+}
+
+// SortField specifies a sort key for selecting the top groups of a query with limit.
+type SortField struct {
+	// The sqlExpression of the measure or of one of the dimensions.
+	Expr string `json:"sqlExpression"`
+
+	// Sorts in descending order instead of ascending.
+	Desc bool `json:"desc,omitempty"`
 }
 
 // Join specifies a secondary table to be explicitly joined in the query.
@@ -104,22 +132,72 @@ type AQLQuery struct {
 	// Name of the main table.
 	Table string `json:"table"`
 
+	// Tables with compatible schemas to query together as one table instead of Table, e.g.
+	// daily tables. Each table is scanned with the rest of the query, and the aggregated results
+	// are merged per group. Columns with the same name must have the same type in all tables.
+	// Only count, sum, min and max aggregates are supported, and having is not supported.
+	Tables []string `json:"tables,omitempty"`
+
 	// Foreign tables to be joined.
 	Joins []Join `json:"joins,omitempty"`
 
 	// Dimensions to group by on.
 	Dimensions []Dimension `json:"dimensions,omitempty"`
 
-	// Measures/metrics to report.
+	// Measures/metrics to report. Results of queries with more than one measure have the
+	// measures as the innermost layer, keyed by their indexes in this list.
 	Measures []Measure `json:"measures"`
 
+	// Selects the columns of the rows matching the filters instead of aggregating measures,
+	// e.g. ["city_id", "status"]. Dimensions, measures and having must be empty, and a limit up
+	// to MaxRowQueryLimit is required. Rows are ordered by Sorts on the selected columns, then
+	// by column values, or returned in scan order without sorts, in which case the scan stops
+	// once limit rows are found. Identical rows are all returned, as
+	// {"headers": [columns], "matrixData": [[values]]}.
+	Select []string `json:"select,omitempty"`
+
 	// Row level filters to apply for all measures. The filters are ANDed togther.
 	Filters []string `json:"rowFilters,omitempty"`
 	filters []expr.Expr
+	// filters and measure filters were parsed when the query was prepared.
+	filtersParsed bool
+	// Boolean filter tree to apply for all measures, ANDed with the row filters.
+	FilterTree *FilterNode `json:"filterTree,omitempty"`
+
+	// Group level filter to apply on the aggregated measure, e.g.
+	// "sum(fare) > 1000 AND sum(fare) < 5000". The measure is referenced by
+	// its sqlExpression, and can be compared against number literals using
+	// comparison operators, arithmetic operators and AND/OR/NOT. Groups with
+	// NULL measure are filtered out. Having is evaluated on the final result
+	// set, so it is applied before any limit enforced by the caller.
+	Having string `json:"having,omitempty"`
+	having expr.Expr
+
+	// Maximum number of groups to return after having is applied, 0 means no limit.
+	// Groups are ranked by Sorts in order with NULLs last, and ties are broken by the
+	// dimension values in order so that identical queries return identical groups.
+	Limit int         `json:"limit,omitempty"`
+	Sorts []SortField `json:"sorts,omitempty"`
+
+	// Returns the groups in pages of limit groups, with a cursor for fetching the next page.
+	// All pages are scoped to the rows archived before the first page, so pages stay consistent
+	// while data is being ingested. Rows not archived yet are not returned. The archive is not
+	// pinned though, rows before the cutoff backfilled between two pages are read by the later
+	// pages.
+	Paginate bool `json:"paginate,omitempty"`
+	// Cursor returned with the previous page, the page after it is returned. Cursors expire
+	// after a TTL.
+	Cursor string `json:"cursor,omitempty"`
+	// Key signing cursors so clients can not forge them, set by the server.
+	CursorKey []byte `json:"-"`
 
 	// Syntax sugar for specifying a time based range filter.
 	TimeFilter TimeFilter `json:"timeFilter,omitempty"`
 
+	// Only scans these shards of the main table if set, e.g. by a client fanning a query out to
+	// one replica of each shard. Shards not held by the instance are skipped.
+	Shards []int `json:"shards,omitempty"`
+
 	// Timezone to use when converting timestamp to calendar time, specified as:
 	//   - -8:00
 	//   - GMT
@@ -133,6 +211,16 @@ type AQLQuery struct {
 
 	// This overrides "now" (in seconds)
 	Now int64 `json:"now,omitempty"`
+
+	// Stores of the main fact table to read, one of:
+	//   - default: reads the live store and the archive store.
+	//   - archiveOnly: skips the live store. Rows not archived yet, usually the rows ingested
+	//     since the last archiving run, are excluded. Queries are cheaper, and their results are
+	//     only changed by archiving and backfill, so they can be served from the result cache for
+	//     any time range, at the cost of being stale up to the cache TTL.
+	//   - liveOnly: only reads rows not archived yet. Results change with every ingestion so
+	//     they are never cached.
+	Consistency string `json:"consistency,omitempty"`
 }
 
 // AQLRequest contains multiple of AQLQueries.
@@ -145,6 +233,16 @@ type AQLResponse struct {
 	Results      []queryCom.AQLTimeSeriesResult `json:"results"`
 	Errors       []error                        `json:"errors,omitempty"`
 	QueryContext []*AQLQueryContext             `json:"context,omitempty"`
+	Profiles     []*QueryProfile                `json:"profiles,omitempty"`
+	// Cursors for fetching the next page of paginated queries, empty after the last page.
+	Cursors []string `json:"cursors,omitempty"`
+}
+
+// AQLExplainResponse contains the plans of queries in the same order as the request, and the
+// error messages of queries that can not be explained.
+type AQLExplainResponse struct {
+	Plans  []*QueryPlan `json:"plans"`
+	Errors []string     `json:"errors,omitempty"`
 }
 
 func (d Dimension) isTimeDimension() bool {
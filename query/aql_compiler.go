@@ -18,6 +18,7 @@ package query
 import "C"
 
 import (
+	"errors"
 	"sort"
 	"strings"
 	"unsafe"
@@ -25,12 +26,17 @@ import (
 	"fmt"
 	"github.com/uber/aresdb/memstore"
 	memCom "github.com/uber/aresdb/memstore/common"
+	metaCom "github.com/uber/aresdb/metastore/common"
 	"github.com/uber/aresdb/query/common"
 	"github.com/uber/aresdb/query/expr"
 	"github.com/uber/aresdb/utils"
 	"strconv"
 )
 
+// ErrTableNotFound is returned when a query references a table that does not exist or has been
+// dropped.
+var ErrTableNotFound = errors.New("Table not found")
+
 // DataTypeToExprType maps data type from the column schema format to
 // expression AST format.
 var DataTypeToExprType = map[memCom.DataType]expr.Type{
@@ -63,23 +69,56 @@ const (
 	fromUnixTimeCallName        = "from_unixtime"
 	geographyIntersectsCallName = "geography_intersects"
 	hexCallName                 = "hex"
+	// histogram is rewritten into counts of an additional bucket dimension
+	histogramCallName = "histogram"
 	// hll aggregation function applies to hll columns
 	hllCallName = "hll"
 	// countdistincthll aggregation function applies to all columns, hll value is computed on the fly
 	countDistinctHllCallName = "countdistincthll"
+	containsCallName         = "contains"
+	explodeCallName          = "explode"
 	hourCallName             = "hour"
 	listCallName             = ""
 	maxCallName              = "max"
 	minCallName              = "min"
+	percentileCallName       = "percentile"
 	sumCallName              = "sum"
 	avgCallName              = "avg"
+	// weightedavg is rewritten into an arithmetic measure of sums
+	weightedAvgCallName = "weightedavg"
+	// weightedpercentile is rewritten into a histogram of weight sums
+	weightedPercentileCallName = "weightedpercentile"
 )
 
 // Compile returns the compiled AQLQueryContext for data feeding and query
 // execution. Caller should check for AQLQueryContext.Error.
 func (q *AQLQuery) Compile(store memstore.MemStore, returnHLL bool) *AQLQueryContext {
+	if len(q.Select) > 0 {
+		return q.compileRowQuery(store, returnHLL)
+	}
+
+	measure, err := parseArithmeticMeasure(q)
+	if err != nil {
+		return &AQLQueryContext{Query: q, ReturnHLLData: returnHLL, Error: err}
+	}
+	if measure != nil {
+		if q.isPaginated() {
+			return &AQLQueryContext{Query: q, ReturnHLLData: returnHLL,
+				Error: utils.StackError(nil, "pagination is not supported for arithmetic measures and multiple measures")}
+		}
+		return q.compileArithmeticMeasure(store, returnHLL, measure)
+	}
+	if len(q.Tables) > 0 {
+		return q.compileUnion(store, returnHLL)
+	}
+
 	qc := &AQLQueryContext{Query: q, ReturnHLLData: returnHLL}
 
+	var fingerprint string
+	if q.isPaginated() {
+		fingerprint = q.fingerprint()
+	}
+
 	// processTimezone might append additional joins
 	qc.processTimezone()
 	if qc.Error != nil {
@@ -93,6 +132,17 @@ func (q *AQLQuery) Compile(store memstore.MemStore, returnHLL bool) *AQLQueryCon
 		return qc
 	}
 
+	qc.processConsistency()
+	if qc.Error != nil {
+		return qc
+	}
+
+	// Resolve the snapshot and position of paginated queries.
+	qc.processCursor(store, fingerprint)
+	if qc.Error != nil {
+		return qc
+	}
+
 	// Parse all other SQL expressions to ASTs.
 	qc.parseExprs()
 	if qc.Error != nil {
@@ -114,6 +164,11 @@ func (q *AQLQuery) Compile(store memstore.MemStore, returnHLL bool) *AQLQueryCon
 	// Identify prefilters.
 	qc.matchPrefilters()
 
+	// Skip shards not holding the shard key filtered by the query.
+	qc.pruneShards()
+	// Skip shards not requested by the query.
+	qc.restrictShards()
+
 	// Process filters.
 	qc.processFilters()
 	if qc.Error != nil {
@@ -190,7 +245,7 @@ func (qc *AQLQueryContext) adjustFilterToTimeFilter() {
 	}
 	if timeFilter.From != "" || timeFilter.To != "" {
 		// processTimeFilter will handle the from is nil case
-		if qc.fromTime, qc.toTime, qc.Error = parseTimeFilter(timeFilter, qc.fixedTimezone, utils.Now()); qc.Error != nil {
+		if qc.fromTime, qc.toTime, qc.Error = parseTimeFilter(timeFilter, qc.fixedTimezone, qc.now()); qc.Error != nil {
 			return
 		}
 		// remove from original query filter
@@ -393,17 +448,33 @@ func (qc *AQLQueryContext) parseExprs() {
 		qc.Query.Joins[i] = join
 	}
 
-	qc.fromTime, qc.toTime, qc.Error = parseTimeFilter(qc.Query.TimeFilter, qc.fixedTimezone, utils.Now())
+	qc.fromTime, qc.toTime, qc.Error = parseTimeFilter(qc.Query.TimeFilter, qc.fixedTimezone, qc.now())
 	if qc.Error != nil {
 		return
 	}
 
 	// Filters.
-	qc.Query.filters = make([]expr.Expr, len(qc.Query.Filters))
-	for i, filter := range qc.Query.Filters {
-		qc.Query.filters[i], err = expr.ParseExpr(filter)
-		if err != nil {
-			qc.Error = utils.StackError(err, "Failed to parse filter %s", filter)
+	if !qc.Query.filtersParsed {
+		qc.Query.filters = make([]expr.Expr, len(qc.Query.Filters))
+		for i, filter := range qc.Query.Filters {
+			qc.Query.filters[i], err = expr.ParseExpr(filter)
+			if err != nil {
+				qc.Error = utils.StackError(err, "Failed to parse filter %s", filter)
+				return
+			}
+		}
+	}
+	if qc.Query.FilterTree != nil {
+		var filter expr.Expr
+		if filter, err = qc.Query.FilterTree.parse(); err != nil {
+			qc.Error = utils.StackError(err, "Failed to parse filter tree")
+			return
+		}
+		qc.Query.filters = append(qc.Query.filters, filter)
+	}
+	for _, filter := range qc.Query.filters {
+		if err = checkAggregateFilters(filter); err != nil {
+			qc.Error = utils.StackError(err, "Invalid filter %s", filter.String())
 			return
 		}
 	}
@@ -414,6 +485,22 @@ func (qc *AQLQueryContext) parseExprs() {
 		}
 	}
 
+	// Exclude rows deleted from the main table.
+	if len(qc.TableScanners) > 0 {
+		schema := qc.TableScanners[0].Schema
+		for _, predicate := range schema.DeletePredicates {
+			// Keep rows for which the predicate is false or null, and rows with event time since
+			// the deletion.
+			filter, err := expr.ParseExpr(fmt.Sprintf("NOT (%s) OR (%s) IS NULL OR %s >= %d",
+				predicate.Filter, predicate.Filter, schema.Schema.Columns[0].Name, predicate.Cutoff))
+			if err != nil {
+				qc.Error = utils.StackError(err, "Failed to parse delete predicate %s", predicate.Filter)
+				return
+			}
+			qc.Query.filters = append(qc.Query.filters, filter)
+		}
+	}
+
 	// Dimensions.
 	for i, dim := range qc.Query.Dimensions {
 		dim.TimeBucketizer = strings.Trim(dim.TimeBucketizer, " ")
@@ -440,6 +527,9 @@ func (qc *AQLQueryContext) parseExprs() {
 			dim.expr, err = expr.ParseExpr(dim.Expr)
 		}
 
+		if err == nil {
+			err = checkAggregateFilters(dim.expr)
+		}
 		if err != nil {
 			qc.Error = utils.StackError(err, "Failed to parse dimension: %s", dim.Expr)
 			return
@@ -454,16 +544,309 @@ func (qc *AQLQueryContext) parseExprs() {
 			qc.Error = utils.StackError(err, "Failed to parse measure: %s", measure.Expr)
 			return
 		}
-		measure.filters = make([]expr.Expr, len(measure.Filters))
-		for j, filter := range measure.Filters {
-			measure.filters[j], err = expr.ParseExpr(filter)
-			if err != nil {
-				qc.Error = utils.StackError(err, "Failed to parse measure filter %s", filter)
-				return
+		if !qc.Query.filtersParsed {
+			measure.filters = make([]expr.Expr, len(measure.Filters))
+			for j, filter := range measure.Filters {
+				measure.filters[j], err = expr.ParseExpr(filter)
+				if err != nil {
+					qc.Error = utils.StackError(err, "Failed to parse measure filter %s", filter)
+					return
+				}
 			}
 		}
+		// the filter of the aggregate is compiled into the aggregate when possible, otherwise it
+		// is applied as a measure row filter.
+		if call, ok := measure.expr.(*expr.Call); ok && call.Filter != nil {
+			if conditional := conditionalAggregate(call); conditional != nil {
+				measure.expr = conditional
+			} else {
+				measure.filters = append(append([]expr.Expr(nil), measure.filters...), call.Filter)
+				call.Filter = nil
+			}
+		}
+		if err = checkAggregateFilters(measure.expr); err != nil {
+			qc.Error = utils.StackError(err, "Invalid measure: %s", measure.Expr)
+			return
+		}
 		qc.Query.Measures[i] = measure
 	}
+
+	// Having.
+	if qc.Query.Having != "" {
+		if qc.ReturnHLLData {
+			qc.Error = utils.StackError(nil, "having is not supported when client specify 'Accept' as 'application/hll'")
+			return
+		}
+		qc.Query.having, err = expr.ParseExpr(qc.Query.Having)
+		if err != nil {
+			qc.Error = utils.StackError(err, "Failed to parse having: %s", qc.Query.Having)
+			return
+		}
+		if len(qc.Query.Measures) > 0 {
+			if err = validateHaving(qc.Query.having, qc.Query.Measures[0].expr); err != nil {
+				qc.Error = utils.StackError(err, "Invalid having: %s", qc.Query.Having)
+				return
+			}
+		}
+	}
+
+	// Limit.
+	if qc.topN, err = compileTopN(qc.Query, qc.ReturnHLLData); err != nil {
+		qc.Error = utils.StackError(err, "Invalid limit")
+		return
+	}
+	if qc.cursor != nil && qc.cursor.DimValues != nil {
+		qc.topN.after = &topNGroup{dimValues: qc.cursor.DimValues, value: qc.cursor.Value}
+	}
+
+	// Fill.
+	if qc.gapFill, err = compileGapFill(qc.Query, qc); err != nil {
+		qc.Error = utils.StackError(err, "Invalid fill")
+		return
+	}
+
+	// Running aggregates.
+	if qc.running, err = compileRunningAggregate(qc.Query, qc.ReturnHLLData); err != nil {
+		qc.Error = utils.StackError(err, "Invalid running")
+		return
+	}
+
+	qc.rewritePercentile()
+	if qc.Error != nil {
+		return
+	}
+	qc.rewriteHistogram()
+}
+
+// rewritePercentile rewrites percentile(column, quantile, bucketWidth) into
+// count(*) grouped by an additional trailing dimension on the bucketized
+// column, so that the histogram is aggregated across batches the same way as
+// any other count. Postprocess collapses the trailing dimension into the
+// quantile, which is accurate to the bucket width. The bucket width is
+// required as grouping by the raw column yields a group per distinct value.
+// weightedPercentile(column, weight, quantile, bucketWidth) is rewritten the
+// same way into sum(weight), so each value is counted by its weight.
+func (qc *AQLQueryContext) rewritePercentile() {
+	if len(qc.Query.Measures) != 1 {
+		return
+	}
+	measure := qc.Query.Measures[0]
+	aggregate, ok := measure.expr.(*expr.Call)
+	if !ok {
+		return
+	}
+
+	// args are the value, the quantile and the bucket width.
+	args := aggregate.Args
+	var weight expr.Expr
+	switch strings.ToLower(aggregate.Name) {
+	case percentileCallName:
+		if len(args) != 3 {
+			qc.Error = utils.StackError(nil,
+				"expect three parameters for aggregate function %s, but got %d",
+				aggregate.Name, len(args))
+			return
+		}
+	case weightedPercentileCallName:
+		if len(args) != 4 {
+			qc.Error = utils.StackError(nil,
+				"expect four parameters for aggregate function %s, but got %d",
+				aggregate.Name, len(args))
+			return
+		}
+		weight = args[1]
+		args = append([]expr.Expr{args[0]}, args[2:]...)
+	default:
+		return
+	}
+
+	if len(qc.Query.Dimensions) == 0 {
+		qc.Error = utils.StackError(nil, "percentile requires at least one dimension")
+		return
+	}
+
+	quantile, ok := args[1].(*expr.NumberLiteral)
+	if !ok || quantile.Val <= 0 || quantile.Val > 100 {
+		qc.Error = utils.StackError(nil,
+			"expect quantile in (0, 100] for percentile, but got %s", args[1].String())
+		return
+	}
+	qc.percentile.quantile = quantile.Val
+
+	bucketWidth, ok := args[2].(*expr.NumberLiteral)
+	if !ok || bucketWidth.Int <= 0 || float64(bucketWidth.Int) != bucketWidth.Val {
+		qc.Error = utils.StackError(nil,
+			"expect positive integer bucket width for percentile, but got %s", args[2].String())
+		return
+	}
+	dim := Dimension{expr: &expr.BinaryExpr{
+		Op:  expr.FLOOR,
+		LHS: args[0],
+		RHS: bucketWidth,
+	}}
+	dim.Expr = dim.expr.String()
+	qc.Query.Dimensions = append(qc.Query.Dimensions, dim)
+
+	measure.expr = &expr.Call{
+		Name: countCallName,
+		Args: []expr.Expr{&expr.Wildcard{}},
+	}
+	if weight != nil {
+		measure.expr = &expr.Call{
+			Name: sumCallName,
+			Args: []expr.Expr{weight},
+		}
+	}
+	qc.Query.Measures[0] = measure
+}
+
+// rewriteHistogram rewrites histogram(value, bound1, bound2, ...) into count(*)
+// grouped by an additional trailing dimension on the index of the bucket the
+// value falls into, so that bucket counts are aggregated across batches the
+// same way as any other count. Bucket 0 counts values below the first bound,
+// the last bucket counts values not below the last bound. The bucket index is
+// the number of bounds not above the value, (value >= bound1) * 1 + ..., as the
+// OOPK engine does not evaluate CASE expressions. The index of a NULL value is
+// NULL, so rows with NULL values are not counted in any bucket. Postprocess
+// replaces bucket indexes with bucket names and fills empty buckets with zero.
+func (qc *AQLQueryContext) rewriteHistogram() {
+	if len(qc.Query.Measures) != 1 {
+		return
+	}
+	measure := qc.Query.Measures[0]
+	aggregate, ok := measure.expr.(*expr.Call)
+	if !ok || strings.ToLower(aggregate.Name) != histogramCallName {
+		return
+	}
+
+	if len(aggregate.Args) < 2 {
+		qc.Error = utils.StackError(nil,
+			"expect at least two parameters for aggregate function %s, but got %d",
+			aggregate.Name, len(aggregate.Args))
+		return
+	}
+	if qc.Query.having != nil {
+		qc.Error = utils.StackError(nil, "having is not supported for histogram")
+		return
+	}
+	if qc.gapFill != nil {
+		qc.Error = utils.StackError(nil, "fill is not supported for histogram")
+		return
+	}
+	if qc.running != nil {
+		qc.Error = utils.StackError(nil, "running is not supported for histogram")
+		return
+	}
+
+	value := aggregate.Args[0]
+	bounds := make([]float64, len(aggregate.Args)-1)
+	comparisons := make([]string, len(bounds))
+	for i, arg := range aggregate.Args[1:] {
+		bound, ok := arg.(*expr.NumberLiteral)
+		if !ok || (i > 0 && bound.Val <= bounds[i-1]) {
+			qc.Error = utils.StackError(nil,
+				"expect increasing number literals as bounds for histogram, but got %s", arg.String())
+			return
+		}
+		bounds[i] = bound.Val
+		// the comparison is multiplied by 1 so that the sum is not typed as boolean.
+		comparisons[i] = fmt.Sprintf("(%s >= %s) * 1", value.String(), bound.String())
+	}
+	qc.histogram.value = value
+	qc.histogram.bounds = bounds
+
+	dim := Dimension{Expr: strings.Join(comparisons, " + ")}
+	var err error
+	if dim.expr, err = expr.ParseExpr(dim.Expr); err != nil {
+		qc.Error = utils.StackError(err, "Failed to parse histogram buckets: %s", dim.Expr)
+		return
+	}
+	qc.Query.Dimensions = append(qc.Query.Dimensions, dim)
+
+	measure.expr = &expr.Call{
+		Name: countCallName,
+		Args: []expr.Expr{&expr.Wildcard{}},
+	}
+	qc.Query.Measures[0] = measure
+}
+
+// conditionalAggregate rewrites sum(x) FILTER (WHERE f) into sum(x * (f)) and
+// count(*) FILTER (WHERE f) into sum((f) * 1), so that the filter is evaluated as part of the
+// measure instead of filtering rows: non matching rows add 0, and rows where x or f is null
+// are skipped like any other null measure value. Groups without matching rows are returned
+// with 0 instead of being dropped. The OOPK engine does not evaluate CASE expressions, hence
+// the multiplication by the predicate. nil is returned for other aggregates, e.g. the identity
+// of min and max or the row count of avg would leak into the result, so their filter is
+// applied to rows instead.
+func conditionalAggregate(call *expr.Call) expr.Expr {
+	predicate := &expr.ParenExpr{Expr: call.Filter}
+	switch strings.ToLower(call.Name) {
+	case sumCallName:
+		if len(call.Args) != 1 {
+			return nil
+		}
+		return &expr.Call{
+			Name: call.Name,
+			Args: []expr.Expr{&expr.BinaryExpr{
+				Op:  expr.MUL,
+				LHS: &expr.ParenExpr{Expr: call.Args[0]},
+				RHS: predicate,
+			}},
+		}
+	case countCallName:
+		return &expr.Call{
+			Name: sumCallName,
+			Args: []expr.Expr{&expr.BinaryExpr{
+				Op:  expr.MUL,
+				LHS: predicate,
+				RHS: &expr.NumberLiteral{Val: 1, Int: 1, Expr: "1", ExprType: expr.Unsigned},
+			}},
+		}
+	}
+	return nil
+}
+
+// checkAggregateFilters returns an error if any call in the expression has a FILTER clause, which
+// is only supported on the aggregates of measures.
+func checkAggregateFilters(e expr.Expr) error {
+	var err error
+	expr.WalkFunc(e, func(e expr.Expr) {
+		if call, ok := e.(*expr.Call); ok && call.Filter != nil && err == nil {
+			err = utils.StackError(nil, "FILTER is only supported on the aggregate of measures, but got %s", call.String())
+		}
+	})
+	return err
+}
+
+// validateHaving checks the having expression only consists of the measure,
+// number literals and operators supported by evalHaving.
+func validateHaving(e expr.Expr, measure expr.Expr) error {
+	switch e := e.(type) {
+	case *expr.ParenExpr:
+		return validateHaving(e.Expr, measure)
+	case *expr.NumberLiteral:
+		return nil
+	case *expr.Call:
+		if !strings.EqualFold(e.String(), measure.String()) {
+			return utils.StackError(nil, "having can only reference measure %s, but got %s", measure.String(), e.String())
+		}
+		return nil
+	case *expr.UnaryExpr:
+		switch e.Op {
+		case expr.NOT, expr.UNARY_MINUS:
+			return validateHaving(e.Expr, measure)
+		}
+	case *expr.BinaryExpr:
+		switch e.Op {
+		case expr.AND, expr.OR, expr.EQ, expr.NEQ, expr.LT, expr.LTE, expr.GT, expr.GTE,
+			expr.ADD, expr.SUB, expr.MUL, expr.DIV:
+			if err := validateHaving(e.LHS, measure); err != nil {
+				return err
+			}
+			return validateHaving(e.RHS, measure)
+		}
+	}
+	return utils.StackError(nil, "unsupported having expression: %s", e.String())
 }
 
 func (qc *AQLQueryContext) processTimezone() {
@@ -494,6 +877,11 @@ func (qc *AQLQueryContext) processTimezone() {
 	}
 }
 
+// TableNotFound tells whether the query is rejected for referencing tables that do not exist.
+func (qc *AQLQueryContext) TableNotFound() bool {
+	return qc.tableNotFound
+}
+
 func (qc *AQLQueryContext) readSchema(store memstore.MemStore) {
 	qc.TableScanners = make([]*TableScanner, 1+len(qc.Query.Joins))
 	qc.TableIDByAlias = make(map[string]int)
@@ -505,14 +893,15 @@ func (qc *AQLQueryContext) readSchema(store memstore.MemStore) {
 	// Main table.
 	schema := store.GetSchemas()[qc.Query.Table]
 	if schema == nil {
-		qc.Error = utils.StackError(nil, "unknown main table %s", qc.Query.Table)
+		qc.Error = utils.StackError(ErrTableNotFound, "unknown main table %s", qc.Query.Table)
+		qc.tableNotFound = true
 		return
 	}
 	qc.TableSchemaByName[qc.Query.Table] = schema
 	schema.RLock()
 	qc.TableScanners[0] = &TableScanner{}
 	qc.TableScanners[0].Schema = schema
-	qc.TableScanners[0].Shards = []int{0}
+	qc.TableScanners[0].Shards = schema.Schema.GetShards()
 	qc.TableScanners[0].ColumnUsages = make(map[int]columnUsage)
 	if schema.Schema.IsFactTable {
 		// Archiving cutoff filter usage for fact table.
@@ -524,7 +913,8 @@ func (qc *AQLQueryContext) readSchema(store memstore.MemStore) {
 	for i, join := range qc.Query.Joins {
 		schema = store.GetSchemas()[join.Table]
 		if schema == nil {
-			qc.Error = utils.StackError(nil, "unknown join table %s", join.Table)
+			qc.Error = utils.StackError(ErrTableNotFound, "unknown join table %s", join.Table)
+			qc.tableNotFound = true
 			return
 		}
 
@@ -629,6 +1019,13 @@ func isUUIDColumn(expression expr.Expr) bool {
 	return false
 }
 
+func isEnumArrayColumn(expression expr.Expr) bool {
+	if varRef, ok := expression.(*expr.VarRef); ok {
+		return varRef.IsEnumArrayColumn
+	}
+	return false
+}
+
 // Rewrite walks the expresison AST and resolves data types bottom up.
 // In addition it also translates enum strings and rewrites their predicates.
 func (qc *AQLQueryContext) Rewrite(expression expr.Expr) expr.Expr {
@@ -657,12 +1054,22 @@ func (qc *AQLQueryContext) Rewrite(expression expr.Expr) expr.Expr {
 		e.EnumReverseDict = dict.ReverseDict
 		e.DataType = dataType
 		e.IsHLLColumn = column.HLLConfig.IsHLLColumn
+		e.IsEnumArrayColumn = column.IsEnumArrayColumn()
+	case *expr.BoundParameter:
+		qc.Error = utils.StackError(nil, "parameter %s can only be used in prepared queries", e)
+		return expression
 	case *expr.UnaryExpr:
 		if isUUIDColumn(e.Expr) && e.Op != expr.GET_HLL_VALUE {
 			qc.Error = utils.StackError(nil, "uuid column type only supports countdistincthll unary expression")
 			return expression
 		}
 
+		if isEnumArrayColumn(e.Expr) && e.Op != expr.IS_NULL && e.Op != expr.IS_NOT_NULL {
+			qc.Error = utils.StackError(nil, "enum array column %s only supports is null, is not null and contains",
+				e.Expr.String())
+			return expression
+		}
+
 		if err := blockNumericOpsForColumnOverFourBytes(e.Op, e.Expr); err != nil {
 			qc.Error = err
 			return expression
@@ -723,7 +1130,13 @@ func (qc *AQLQueryContext) Rewrite(expression expr.Expr) expr.Expr {
 			return expression
 		}
 
-		if e.Op != expr.EQ && e.Op != expr.NEQ {
+		if isEnumArrayColumn(e.LHS) || isEnumArrayColumn(e.RHS) {
+			qc.Error = utils.StackError(nil, "enum array column only supports is null, is not null and contains, got %s",
+				e.String())
+			return expression
+		}
+
+		if e.Op != expr.EQ && e.Op != expr.NEQ && !isPatternMatchOp(e.Op) {
 			_, isRHSStr := e.RHS.(*expr.StringLiteral)
 			_, isLHSStr := e.LHS.(*expr.StringLiteral)
 			if isRHSStr || isLHSStr {
@@ -827,6 +1240,14 @@ func (qc *AQLQueryContext) Rewrite(expression expr.Expr) expr.Expr {
 				Op:   expr.NOT,
 				Expr: qc.expandINop(e),
 			}
+		case expr.LIKE, expr.ILIKE, expr.REGEXP:
+			return qc.expandPatternMatch(e)
+		case expr.NOT_LIKE, expr.NOT_ILIKE, expr.NOT_REGEXP:
+			return &expr.UnaryExpr{
+				Op:       expr.NOT,
+				Expr:     qc.expandPatternMatch(e),
+				ExprType: expr.Boolean,
+			}
 		default:
 			qc.Error = utils.StackError(nil, "unsupported binary expression %s",
 				e.String())
@@ -874,6 +1295,47 @@ func (qc *AQLQueryContext) Rewrite(expression expr.Expr) expr.Expr {
 				},
 				ExprType: expr.Unsigned,
 			}
+		case containsCallName:
+			// contains(enum_array, 'case') tests the bit of the enum case: enum_array & (1 << id) != 0
+			if len(e.Args) != 2 {
+				qc.Error = utils.StackError(nil, "contains takes exactly 2 arguments")
+				break
+			}
+			colRef, isVarRef := e.Args[0].(*expr.VarRef)
+			if !isVarRef || !colRef.IsEnumArrayColumn {
+				qc.Error = utils.StackError(nil,
+					"expect 1st argument to be an enum array column for %s, but got %s", e.Name, e.Args[0].String())
+				break
+			}
+			enumCase, isStrLiteral := e.Args[1].(*expr.StringLiteral)
+			if !isStrLiteral {
+				qc.Error = utils.StackError(nil, "2nd argument of contains must be a string")
+				break
+			}
+			var mask int
+			// Same as enum equality, a missing enum case matches no rows.
+			if enumID, exists := colRef.EnumDict[enumCase.Val]; exists {
+				mask = 1 << uint(enumID)
+			}
+			return &expr.BinaryExpr{
+				Op: expr.NEQ,
+				LHS: &expr.BinaryExpr{
+					Op:  expr.BITWISE_AND,
+					LHS: colRef,
+					RHS: &expr.NumberLiteral{
+						Int:      mask,
+						Expr:     strconv.Itoa(mask),
+						ExprType: expr.Unsigned,
+					},
+					ExprType: expr.Unsigned,
+				},
+				RHS: &expr.NumberLiteral{
+					Int:      0,
+					Expr:     "0",
+					ExprType: expr.Unsigned,
+				},
+				ExprType: expr.Boolean,
+			}
 		case countCallName:
 			e.ExprType = expr.Unsigned
 		case dayOfWeekCallName:
@@ -1012,6 +1474,8 @@ func (qc *AQLQueryContext) Rewrite(expression expr.Expr) expr.Expr {
 			}
 
 			e.ExprType = expr.Boolean
+		case explodeCallName:
+			qc.Error = utils.StackError(nil, "explode can only be used as a dimension, but got %s", e.String())
 		case hexCallName:
 			if len(e.Args) != 1 {
 				qc.Error = utils.StackError(
@@ -1070,6 +1534,10 @@ func (qc *AQLQueryContext) Rewrite(expression expr.Expr) expr.Expr {
 					nil, "expect 1 argument for %s, but got %s", e.Name, e.String())
 				break
 			}
+			if isEnumArrayColumn(e.Args[0]) {
+				qc.Error = utils.StackError(nil, "enum array column is not supported in %s", e.String())
+				break
+			}
 			// For avg, the expression type should always be float.
 			if e.Name == avgCallName {
 				e.Args[0] = cast(e.Args[0], expr.Float)
@@ -1129,10 +1597,29 @@ func (qc *AQLQueryContext) resolveTypes() {
 
 	// Dimensions.
 	for i, dim := range qc.Query.Dimensions {
+		exploded := false
+		if call, ok := dim.expr.(*expr.Call); ok && strings.ToLower(call.Name) == explodeCallName {
+			if len(call.Args) != 1 {
+				qc.Error = utils.StackError(nil, "explode takes exactly 1 argument")
+				return
+			}
+			dim.expr, exploded = call.Args[0], true
+		}
 		dim.expr = expr.Rewrite(qc, dim.expr)
 		if qc.Error != nil {
 			return
 		}
+		if exploded != isEnumArrayColumn(dim.expr) {
+			qc.Error = utils.StackError(nil,
+				"enum array column must be exploded and only enum array column can be exploded, got dimension %s", dim.Expr)
+			return
+		}
+		if exploded {
+			if qc.explodedDimensions == nil {
+				qc.explodedDimensions = make(map[int]bool)
+			}
+			qc.explodedDimensions[i] = true
+		}
 		qc.Query.Dimensions[i] = dim
 	}
 
@@ -1355,6 +1842,116 @@ func (c *foreignTableColumnDetector) Visit(expression expr.Expr) expr.Visitor {
 	return c
 }
 
+// floatColumnCollector collects the distinct float columns referenced by an AST.
+type floatColumnCollector struct {
+	varRefs []*expr.VarRef
+}
+
+func (c *floatColumnCollector) Visit(expression expr.Expr) expr.Visitor {
+	switch e := expression.(type) {
+	case *expr.VarRef:
+		if e.DataType != memCom.Float32 || e.ColumnID < 0 {
+			return c
+		}
+		for _, varRef := range c.varRefs {
+			if varRef.TableID == e.TableID && varRef.ColumnID == e.ColumnID {
+				return c
+			}
+		}
+		c.varRefs = append(c.varRefs, e)
+	}
+	return c
+}
+
+// nonFiniteMeasureFilters returns the filters skipping rows with NaN or infinite values in float
+// columns aggregated by sum, avg, min or max, unless the columns propagate such values. The filters
+// only apply to the measure so count still counts the rows, and are evaluated the same way on device
+// and host as other filters.
+func (qc *AQLQueryContext) nonFiniteMeasureFilters() (filters []expr.Expr) {
+	aggregate, ok := qc.Query.Measures[0].expr.(*expr.Call)
+	if !ok || len(aggregate.Args) != 1 {
+		return nil
+	}
+	switch strings.ToLower(aggregate.Name) {
+	case sumCallName, avgCallName, minCallName, maxCallName:
+	default:
+		return nil
+	}
+
+	collector := floatColumnCollector{}
+	expr.Walk(&collector, aggregate.Args[0])
+	for _, varRef := range collector.varRefs {
+		column := qc.TableScanners[varRef.TableID].Schema.Schema.Columns[varRef.ColumnID]
+		if column.GetNonFinitePolicy() != metaCom.NonFiniteSkip {
+			continue
+		}
+		// x - x is NaN for NaN and infinite values of x, and 0 otherwise.
+		filters = append(filters, &expr.BinaryExpr{
+			Op:       expr.EQ,
+			ExprType: expr.Boolean,
+			LHS: &expr.BinaryExpr{
+				Op:       expr.SUB,
+				ExprType: expr.Float,
+				LHS:      varRef,
+				RHS:      varRef,
+			},
+			RHS: &expr.NumberLiteral{Expr: "0", ExprType: expr.Float},
+		})
+	}
+	return filters
+}
+
+// pruneShards keeps only the shard owning the shard key of a hash sharded main table if the
+// query filters the shard key by equality.
+func (qc *AQLQueryContext) pruneShards() {
+	scanner := qc.TableScanners[0]
+	numShards := scanner.Schema.Schema.NumShards
+	shardKeyColumn := scanner.Schema.Schema.ShardKeyColumn
+	if numShards <= 1 {
+		return
+	}
+
+	for _, filter := range qc.Query.filters {
+		f, _ := filter.(*expr.BinaryExpr)
+		if f == nil || f.Op != expr.EQ {
+			continue
+		}
+		lhs, _ := f.LHS.(*expr.VarRef)
+		rhs, _ := f.RHS.(*expr.NumberLiteral)
+		if lhs == nil || rhs == nil || lhs.TableID != 0 || lhs.ColumnID != shardKeyColumn ||
+			(rhs.ExprType != expr.Signed && rhs.ExprType != expr.Unsigned) {
+			continue
+		}
+		shardID, err := memCom.GetShardForValue(rhs.Int, scanner.Schema.ValueTypeByColumn[shardKeyColumn], numShards)
+		if err != nil {
+			// the key does not fit into the column, so no rows match.
+			scanner.Shards = nil
+			return
+		}
+		scanner.Shards = []int{shardID}
+		return
+	}
+}
+
+// restrictShards keeps only the shards of the main table listed by the query, if any.
+func (qc *AQLQueryContext) restrictShards() {
+	if len(qc.Query.Shards) == 0 {
+		return
+	}
+	requested := make(map[int]bool, len(qc.Query.Shards))
+	for _, shardID := range qc.Query.Shards {
+		requested[shardID] = true
+	}
+	scanner := qc.TableScanners[0]
+	shards := make([]int, 0, len(scanner.Shards))
+	for _, shardID := range scanner.Shards {
+		if requested[shardID] {
+			shards = append(shards, shardID)
+		}
+	}
+	scanner.Shards = shards
+}
+
 // processFilters processes all filters and categorize them into common filters,
 // prefilters, and time filters. It also collect column usages from the filters.
 func (qc *AQLQueryContext) processFilters() {
@@ -1377,6 +1974,7 @@ func (qc *AQLQueryContext) processFilters() {
 			prefilters = prefilters[1:]
 		}
 	}
+	commonFilters = append(commonFilters, qc.nonFiniteMeasureFilters()...)
 
 	var geoFilterFound bool
 	for _, filter := range commonFilters {
@@ -1605,7 +2203,7 @@ func (qc *AQLQueryContext) processTimeFilter() {
 		DataType: memCom.Uint32,
 	}, from, to)
 
-	qc.TableScanners[0].ArchiveBatchIDEnd = int((utils.Now().Unix() + 86399) / 86400)
+	qc.TableScanners[0].ArchiveBatchIDEnd = int((qc.now().Unix() + 86399) / 86400)
 	if timeColumnMatched {
 		qc.OOPK.TimeFilters[0] = fromExpr
 		qc.OOPK.TimeFilters[1] = toExpr
@@ -1623,6 +2221,28 @@ func (qc *AQLQueryContext) processTimeFilter() {
 			qc.OOPK.MainTableCommonFilters = append(qc.OOPK.MainTableCommonFilters, toExpr)
 		}
 	}
+
+	// Paginated queries only read rows archived before their first page.
+	if qc.cursor != nil {
+		cutoff := qc.cursor.Cutoff
+		qc.OOPK.MainTableCommonFilters = append(qc.OOPK.MainTableCommonFilters, &expr.BinaryExpr{
+			ExprType: expr.Boolean,
+			Op:       expr.LT,
+			LHS: &expr.VarRef{
+				Val:      qc.TableScanners[0].Schema.Schema.Columns[0].Name,
+				ExprType: expr.Unsigned,
+				DataType: memCom.Uint32,
+			},
+			RHS: &expr.NumberLiteral{
+				Int:      int(cutoff),
+				Expr:     strconv.FormatUint(uint64(cutoff), 10),
+				ExprType: expr.Unsigned,
+			},
+		})
+		if batchIDEnd := int((int64(cutoff) + 86399) / 86400); batchIDEnd < qc.TableScanners[0].ArchiveBatchIDEnd {
+			qc.TableScanners[0].ArchiveBatchIDEnd = batchIDEnd
+		}
+	}
 }
 
 // matchAndRewriteGeoDimension tells whether a dimension matches geo join and whether it's a valid
@@ -1776,6 +2396,38 @@ func (qc *AQLQueryContext) processMeasureAndDimensions() {
 		return
 	}
 
+	// Exploded results are merged per enum case in postprocessing, which only works for
+	// aggregates that can be combined.
+	if len(qc.explodedDimensions) > 0 {
+		switch qc.OOPK.AggregateType {
+		case C.AGGR_AVG_FLOAT, C.AGGR_HLL:
+			qc.Error = utils.StackError(nil,
+				"aggregate function %s is not supported with exploded dimensions", aggregate.Name)
+			return
+		}
+	}
+
+	if qc.percentile.quantile > 0 || qc.histogram.bounds != nil {
+		callName := percentileCallName
+		valueExpr := qc.Query.Dimensions[len(qc.Query.Dimensions)-1].expr
+		if qc.histogram.bounds != nil {
+			// the value is compared to the bounds in the bucket dimension.
+			callName = histogramCallName
+			valueExpr = expr.Rewrite(qc, qc.histogram.value)
+			if qc.Error != nil {
+				return
+			}
+		}
+		varRef, isVarRef := valueExpr.(*expr.VarRef)
+		switch {
+		case isVarRef && (varRef.DataType == memCom.SmallEnum || varRef.DataType == memCom.BigEnum),
+			valueExpr.Type() != expr.Signed && valueExpr.Type() != expr.Unsigned && valueExpr.Type() != expr.Float:
+			qc.Error = utils.StackError(nil,
+				unsupportedInputType, callName, valueExpr.String())
+			return
+		}
+	}
+
 	// Copy dimension ASTs.
 	qc.OOPK.Dimensions = make([]expr.Expr, len(qc.Query.Dimensions))
 	for i, dim := range qc.Query.Dimensions {
@@ -18,6 +18,7 @@ import (
 	"github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	"encoding/json"
 	"time"
 	"unsafe"
 
@@ -131,6 +132,196 @@ var _ = ginkgo.Describe("AQL compiler", func() {
 		Ω(qc.Error).ShouldNot(BeNil())
 	})
 
+	ginkgo.It("parses having", func() {
+		qc := &AQLQueryContext{
+			Query: &AQLQuery{
+				Table:    "trips",
+				Measures: []Measure{{Expr: "sum(fare)"}},
+				Having:   "SUM(fare) > 1000 and (sum(fare) / 2 < 5000 or not sum(fare) != -1)",
+			},
+		}
+		qc.parseExprs()
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.Query.having).ShouldNot(BeNil())
+
+		for _, having := range []string{
+			"sum(fare) >",
+			"sum(fare_total) > 1000",
+			"fare > 1000",
+			"sum(fare) in (1, 2)",
+			"sum(fare) = 'abc'",
+		} {
+			qc = &AQLQueryContext{
+				Query: &AQLQuery{
+					Table:    "trips",
+					Measures: []Measure{{Expr: "sum(fare)"}},
+					Having:   having,
+				},
+			}
+			qc.parseExprs()
+			Ω(qc.Error).ShouldNot(BeNil(), having)
+		}
+
+		qc = &AQLQueryContext{
+			Query: &AQLQuery{
+				Table:    "trips",
+				Measures: []Measure{{Expr: "hll(user_id)"}},
+				Having:   "hll(user_id) > 1000",
+			},
+			ReturnHLLData: true,
+		}
+		qc.parseExprs()
+		Ω(qc.Error).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("rewrites percentile", func() {
+		qc := &AQLQueryContext{
+			Query: &AQLQuery{
+				Table:      "trips",
+				Dimensions: []Dimension{{Expr: "city_id"}},
+				Measures:   []Measure{{Expr: "percentile(latency, 99, 5)"}},
+			},
+		}
+		qc.parseExprs()
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.percentile.quantile).Should(Equal(99.0))
+		Ω(qc.Query.Dimensions).Should(HaveLen(2))
+		Ω(qc.Query.Dimensions[1].expr).Should(Equal(&expr.BinaryExpr{
+			Op:  expr.FLOOR,
+			LHS: &expr.VarRef{Val: "latency"},
+			RHS: &expr.NumberLiteral{Val: 5, Int: 5, Expr: "5", ExprType: expr.Unsigned},
+		}))
+		Ω(qc.Query.Measures[0].expr).Should(Equal(&expr.Call{
+			Name: "count",
+			Args: []expr.Expr{&expr.Wildcard{}},
+		}))
+
+		qc = &AQLQueryContext{
+			Query: &AQLQuery{
+				Table:      "trips",
+				Dimensions: []Dimension{{Expr: "city_id"}},
+				Measures:   []Measure{{Expr: "percentile(latency, 50, 10)"}},
+				Having:     "percentile(latency, 50, 10) > 100",
+			},
+		}
+		qc.parseExprs()
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.percentile.quantile).Should(Equal(50.0))
+		Ω(qc.Query.Dimensions[1].Expr).Should(Equal("latency FLOOR 10"))
+
+		// values are counted by weight for weighted percentile.
+		qc = &AQLQueryContext{
+			Query: &AQLQuery{
+				Table:      "trips",
+				Dimensions: []Dimension{{Expr: "city_id"}},
+				Measures:   []Measure{{Expr: "weightedPercentile(latency, requests, 90, 10)"}},
+			},
+		}
+		qc.parseExprs()
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.percentile.quantile).Should(Equal(90.0))
+		Ω(qc.Query.Dimensions[1].Expr).Should(Equal("latency FLOOR 10"))
+		Ω(qc.Query.Measures[0].expr).Should(Equal(&expr.Call{
+			Name: "sum",
+			Args: []expr.Expr{&expr.VarRef{Val: "requests"}},
+		}))
+
+		for _, measure := range []string{
+			"percentile(latency)",
+			// the bucket width is required.
+			"percentile(latency, 99)",
+			"percentile(latency, 0, 10)",
+			"percentile(latency, 101, 10)",
+			"percentile(latency, p99, 10)",
+			"percentile(latency, 99, 0)",
+			"percentile(latency, 99, 0.5)",
+			"percentile(latency, 99, width)",
+			"percentile(latency, 99, 10, 1)",
+			"weightedPercentile(latency, 99)",
+			"weightedPercentile(latency, requests, 90)",
+			"weightedPercentile(latency, requests, 0, 10)",
+		} {
+			qc = &AQLQueryContext{
+				Query: &AQLQuery{
+					Table:      "trips",
+					Dimensions: []Dimension{{Expr: "city_id"}},
+					Measures:   []Measure{{Expr: measure}},
+				},
+			}
+			qc.parseExprs()
+			Ω(qc.Error).ShouldNot(BeNil(), measure)
+		}
+
+		qc = &AQLQueryContext{
+			Query: &AQLQuery{
+				Table:    "trips",
+				Measures: []Measure{{Expr: "percentile(latency, 99, 10)"}},
+			},
+		}
+		qc.parseExprs()
+		Ω(qc.Error).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("rewrites histogram", func() {
+		qc := &AQLQueryContext{
+			Query: &AQLQuery{
+				Table:      "trips",
+				Dimensions: []Dimension{{Expr: "city_id"}},
+				Measures:   []Measure{{Expr: "histogram(latency, 10, 100.5)"}},
+			},
+		}
+		qc.parseExprs()
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.histogram.bounds).Should(Equal([]float64{10, 100.5}))
+		Ω(qc.histogram.value).Should(Equal(&expr.VarRef{Val: "latency"}))
+		Ω(qc.Query.Dimensions).Should(HaveLen(2))
+		Ω(qc.Query.Dimensions[1].Expr).Should(Equal("(latency >= 10) * 1 + (latency >= 100.5) * 1"))
+		Ω(qc.Query.Dimensions[1].expr.String()).Should(Equal(qc.Query.Dimensions[1].Expr))
+		Ω(qc.Query.Measures[0].expr).Should(Equal(&expr.Call{
+			Name: "count",
+			Args: []expr.Expr{&expr.Wildcard{}},
+		}))
+
+		// the histogram is the result without dimensions.
+		qc = &AQLQueryContext{
+			Query: &AQLQuery{
+				Table:    "trips",
+				Measures: []Measure{{Expr: "histogram(latency, 10)"}},
+			},
+		}
+		qc.parseExprs()
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.Query.Dimensions).Should(HaveLen(1))
+
+		for _, measure := range []string{
+			"histogram(latency)",
+			"histogram(latency, p99)",
+			"histogram(latency, 10, 10)",
+			"histogram(latency, 100, 10)",
+		} {
+			qc = &AQLQueryContext{
+				Query: &AQLQuery{
+					Table:      "trips",
+					Dimensions: []Dimension{{Expr: "city_id"}},
+					Measures:   []Measure{{Expr: measure}},
+				},
+			}
+			qc.parseExprs()
+			Ω(qc.Error).ShouldNot(BeNil(), measure)
+		}
+
+		qc = &AQLQueryContext{
+			Query: &AQLQuery{
+				Table:      "trips",
+				Dimensions: []Dimension{{Expr: "city_id"}},
+				Measures:   []Measure{{Expr: "histogram(latency, 10)"}},
+				Having:     "histogram(latency, 10) > 1",
+			},
+		}
+		qc.parseExprs()
+		Ω(qc.Error).ShouldNot(BeNil())
+	})
+
 	ginkgo.It("reads schema", func() {
 		store := new(mocks.MemStore)
 		store.On("RLock").Return()
@@ -1047,6 +1238,283 @@ var _ = ginkgo.Describe("AQL compiler", func() {
 		Ω(qc.Error.Error()).Should(ContainSubstring("string type only support EQ and NEQ operators"))
 	})
 
+	ginkgo.It("excludes rows matching delete predicates", func() {
+		schema := &memstore.TableSchema{
+			ValueTypeByColumn: []memCom.DataType{
+				memCom.Uint32,
+				memCom.Bool,
+			},
+			ColumnIDs: map[string]int{
+				"request_at": 0,
+				"is_first":   1,
+			},
+			Schema: metaCom.Table{
+				IsFactTable: true,
+				Columns: []metaCom.Column{
+					{Name: "request_at", Type: metaCom.Uint32},
+					{Name: "is_first", Type: metaCom.Bool},
+				},
+			},
+			DeletePredicates: []metaCom.DeletePredicate{{Filter: "is_first = false", Cutoff: 100}},
+		}
+
+		qc := &AQLQueryContext{
+			TableIDByAlias: map[string]int{
+				"trips": 0,
+			},
+			TableScanners: []*TableScanner{
+				{Schema: schema, ColumnUsages: map[int]columnUsage{}},
+			},
+		}
+		qc.Query = &AQLQuery{
+			Table: "trips",
+			Measures: []Measure{
+				{Expr: "count()"},
+			},
+			Filters: []string{
+				"is_first",
+			},
+			TimeFilter: TimeFilter{From: "-1d"},
+		}
+		qc.processTimezone()
+		qc.parseExprs()
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.Query.filters).Should(HaveLen(2))
+		// rows with event time since the deletion are kept.
+		Ω(qc.Query.filters[1].String()).Should(Equal("NOT((is_first = false)) OR (is_first = false) IS NULL OR request_at >= 100"))
+
+		qc.resolveTypes()
+		qc.matchPrefilters()
+		qc.processFilters()
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.OOPK.MainTableCommonFilters).Should(HaveLen(2))
+		Ω(qc.TableScanners[0].ColumnUsages).Should(Equal(map[int]columnUsage{
+			0: columnUsedByAllBatches | columnUsedByLiveBatches | columnUsedByFirstArchiveBatch | columnUsedByLastArchiveBatch,
+			1: columnUsedByAllBatches,
+		}))
+
+		schema.DeletePredicates = []metaCom.DeletePredicate{{Filter: "is_first ="}}
+		qc.parseExprs()
+		Ω(qc.Error).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("skips non finite values in aggregations by policy", func() {
+		schema := &memstore.TableSchema{
+			ValueTypeByColumn: []memCom.DataType{
+				memCom.Uint32,
+				memCom.Float32,
+				memCom.Float32,
+				memCom.Float32,
+				memCom.Uint16,
+			},
+			ColumnIDs: map[string]int{
+				"request_at": 0,
+				"fare":       1,
+				"tip":        2,
+				"surge":      3,
+				"city_id":    4,
+			},
+			Schema: metaCom.Table{
+				Columns: []metaCom.Column{
+					{Name: "request_at", Type: metaCom.Uint32},
+					{Name: "fare", Type: metaCom.Float32},
+					{Name: "tip", Type: metaCom.Float32,
+						Config: metaCom.ColumnConfig{NonFinitePolicy: metaCom.NonFinitePropagate}},
+					{Name: "surge", Type: metaCom.Float32,
+						Config: metaCom.ColumnConfig{NonFinitePolicy: metaCom.NonFiniteReject}},
+					{Name: "city_id", Type: metaCom.Uint16},
+				},
+			},
+		}
+		commonFilters := func(measure string) []string {
+			qc := &AQLQueryContext{
+				TableIDByAlias: map[string]int{
+					"trips": 0,
+				},
+				TableScanners: []*TableScanner{
+					{Schema: schema, Shards: []int{0}, ColumnUsages: map[int]columnUsage{}},
+				},
+				Query: &AQLQuery{
+					Table:    "trips",
+					Measures: []Measure{{Expr: measure}},
+					Filters:  []string{"city_id = 1"},
+				},
+			}
+			qc.parseExprs()
+			qc.resolveTypes()
+			qc.processFilters()
+			Ω(qc.Error).Should(BeNil())
+			var filters []string
+			for _, filter := range qc.OOPK.MainTableCommonFilters {
+				filters = append(filters, filter.String())
+			}
+			return filters
+		}
+
+		// NaN and infinite values of fare are skipped by default.
+		for _, measure := range []string{"sum(fare)", "avg(fare)", "min(fare)", "max(fare)"} {
+			Ω(commonFilters(measure)).Should(Equal([]string{"city_id = 1", "fare - fare = 0"}))
+		}
+		Ω(commonFilters("sum(fare * 2 + fare)")).Should(Equal([]string{"city_id = 1", "fare - fare = 0"}))
+		// count still counts rows with NaN values.
+		Ω(commonFilters("count()")).Should(Equal([]string{"city_id = 1"}))
+		// tip propagates NaN values and surge never has them.
+		Ω(commonFilters("sum(tip)")).Should(Equal([]string{"city_id = 1"}))
+		Ω(commonFilters("avg(surge)")).Should(Equal([]string{"city_id = 1"}))
+		Ω(commonFilters("max(fare + tip)")).Should(Equal([]string{"city_id = 1", "fare - fare = 0"}))
+		// only float columns are checked.
+		Ω(commonFilters("sum(city_id)")).Should(Equal([]string{"city_id = 1"}))
+	})
+
+	ginkgo.It("prunes shards by the shard key", func() {
+		schema := &memstore.TableSchema{
+			ValueTypeByColumn: []memCom.DataType{
+				memCom.Uint32,
+				memCom.Int32,
+				memCom.Uint8,
+			},
+			ColumnIDs: map[string]int{
+				"request_at": 0,
+				"rider_id":   1,
+				"status":     2,
+			},
+			Schema: metaCom.Table{
+				Columns: []metaCom.Column{
+					{Name: "request_at", Type: metaCom.Uint32},
+					{Name: "rider_id", Type: metaCom.Int32},
+					{Name: "status", Type: metaCom.Uint8},
+				},
+				IsFactTable:    true,
+				NumShards:      8,
+				ShardKeyColumn: 1,
+			},
+		}
+		var shards []int
+		newQueryContext := func(filters ...string) *AQLQueryContext {
+			qc := &AQLQueryContext{
+				TableIDByAlias: map[string]int{
+					"trips": 0,
+				},
+				TableScanners: []*TableScanner{
+					{Schema: schema, Shards: schema.Schema.GetShards(), ColumnUsages: map[int]columnUsage{}},
+				},
+				Query: &AQLQuery{
+					Table:    "trips",
+					Measures: []Measure{{Expr: "count()"}},
+					Filters:  filters,
+					Shards:   shards,
+				},
+			}
+			qc.parseExprs()
+			qc.resolveTypes()
+			qc.pruneShards()
+			qc.restrictShards()
+			Ω(qc.Error).Should(BeNil())
+			return qc
+		}
+
+		// no filter on the shard key.
+		qc := newQueryContext("status = 1", "rider_id > 10")
+		Ω(qc.TableScanners[0].Shards).Should(Equal([]int{0, 1, 2, 3, 4, 5, 6, 7}))
+
+		// key point lookups only scan the shard owning the key.
+		shardID, err := memCom.GetShardForValue(12345, memCom.Int32, 8)
+		Ω(err).Should(BeNil())
+		qc = newQueryContext("status = 1", "rider_id = 12345")
+		Ω(qc.TableScanners[0].Shards).Should(Equal([]int{shardID}))
+		qc = newQueryContext("12345 = rider_id")
+		Ω(qc.TableScanners[0].Shards).Should(Equal([]int{shardID}))
+
+		// only the requested shards are scanned.
+		shards = []int{6, 2, 9}
+		qc = newQueryContext("status = 1")
+		Ω(qc.TableScanners[0].Shards).Should(Equal([]int{2, 6}))
+		shards = []int{(shardID + 1) % 8}
+		qc = newQueryContext("rider_id = 12345")
+		Ω(qc.TableScanners[0].Shards).Should(BeEmpty())
+		shards = nil
+
+		// unsharded tables only have shard 0.
+		Ω((&metaCom.Table{}).GetShards()).Should(Equal([]int{0}))
+	})
+
+	ginkgo.It("parses nested filter trees", func() {
+		schema := &memstore.TableSchema{
+			ValueTypeByColumn: []memCom.DataType{
+				memCom.Uint8,
+				memCom.Bool,
+				memCom.Uint16,
+			},
+			ColumnIDs: map[string]int{
+				"status":   0,
+				"is_first": 1,
+				"city_id":  2,
+			},
+			Schema: metaCom.Table{
+				Columns: []metaCom.Column{
+					{Name: "status", Type: metaCom.Uint8},
+					{Name: "is_first", Type: metaCom.Bool},
+					{Name: "city_id", Type: metaCom.Uint16},
+				},
+			},
+		}
+
+		var filterTree FilterNode
+		Ω(json.Unmarshal([]byte(`{
+			"and": [
+				{"sqlExpression": "status = 1 OR status = 2"},
+				{"or": [
+					{"sqlExpression": "city_id = 1"},
+					{"not": {"and": [
+						{"sqlExpression": "is_first"},
+						{"not": {"or": [{"sqlExpression": "city_id > 2"}, {"sqlExpression": "city_id < 10"}]}}
+					]}}
+				]},
+				{"and": [{"sqlExpression": "city_id != 3"}]}
+			]
+		}`), &filterTree)).Should(BeNil())
+
+		qc := &AQLQueryContext{
+			TableIDByAlias: map[string]int{
+				"trips": 0,
+			},
+			TableScanners: []*TableScanner{
+				{Schema: schema, ColumnUsages: map[int]columnUsage{}},
+			},
+		}
+		qc.Query = &AQLQuery{
+			Table: "trips",
+			Measures: []Measure{
+				{Expr: "count()"},
+			},
+			Filters:    []string{"city_id != 4"},
+			FilterTree: &filterTree,
+		}
+		qc.processTimezone()
+		qc.parseExprs()
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.Query.filters).Should(HaveLen(2))
+		Ω(qc.Query.filters[1].String()).Should(Equal("(status = 1 OR status = 2) AND " +
+			"((city_id = 1) OR NOT((is_first) AND NOT(((city_id > 2) OR (city_id < 10))))) AND (city_id != 3)"))
+
+		qc.resolveTypes()
+		qc.matchPrefilters()
+		qc.processFilters()
+		Ω(qc.Error).Should(BeNil())
+		// the top level ANDs are flattened into separate filters.
+		Ω(qc.OOPK.MainTableCommonFilters).Should(HaveLen(4))
+
+		for _, invalid := range []string{`{}`, `{"and": []}`, `{"sqlExpression": "status", "not": {"sqlExpression": "status"}}`,
+			`{"or": [{"sqlExpression": "status ="}]}`} {
+			filterTree = FilterNode{}
+			Ω(json.Unmarshal([]byte(invalid), &filterTree)).Should(BeNil())
+			qc.Query.filtersParsed = false
+			qc.parseExprs()
+			Ω(qc.Error).ShouldNot(BeNil(), invalid)
+			qc.Error = nil
+		}
+	})
+
 	ginkgo.It("processes matched time filters", func() {
 		table := metaCom.Table{
 			IsFactTable: true,
@@ -1425,6 +1893,70 @@ var _ = ginkgo.Describe("AQL compiler", func() {
 		}))
 	})
 
+	ginkgo.It("processes histogram bucket dimension", func() {
+		table := metaCom.Table{
+			Columns: []metaCom.Column{
+				{Name: "fare", Type: metaCom.Float32},
+			},
+		}
+		qc := &AQLQueryContext{
+			TableIDByAlias: map[string]int{
+				"trips": 0,
+			},
+			TableScanners: []*TableScanner{
+				{Schema: memstore.NewTableSchema(&table), ColumnUsages: map[int]columnUsage{}},
+			},
+		}
+		qc.Query = &AQLQuery{
+			Table:    "trips",
+			Measures: []Measure{{Expr: "histogram(fare, 10, 20)"}},
+		}
+		qc.parseExprs()
+		Ω(qc.Error).Should(BeNil())
+		qc.resolveTypes()
+		Ω(qc.Error).Should(BeNil())
+		qc.processMeasureAndDimensions()
+		Ω(qc.Error).Should(BeNil())
+
+		// the bucket index is an unsigned sum of comparisons, which is NULL for NULL fares
+		// instead of falling into a bucket.
+		bucket := func(bound int, literal string) expr.Expr {
+			return &expr.BinaryExpr{
+				Op: expr.MUL,
+				LHS: &expr.BinaryExpr{
+					Op: expr.GTE,
+					LHS: &expr.VarRef{
+						Val:      "fare",
+						ExprType: expr.Float,
+						DataType: memCom.Float32,
+					},
+					RHS: &expr.NumberLiteral{
+						Val:      float64(bound),
+						Int:      bound,
+						Expr:     literal,
+						ExprType: expr.Float,
+					},
+					ExprType: expr.Boolean,
+				},
+				RHS: &expr.NumberLiteral{
+					Val:      1,
+					Int:      1,
+					Expr:     "1",
+					ExprType: expr.Unsigned,
+				},
+				ExprType: expr.Unsigned,
+			}
+		}
+		Ω(qc.OOPK.Dimensions).Should(Equal([]expr.Expr{
+			&expr.BinaryExpr{
+				Op:       expr.ADD,
+				LHS:      bucket(10, "10"),
+				RHS:      bucket(20, "20"),
+				ExprType: expr.Unsigned,
+			},
+		}))
+	})
+
 	ginkgo.It("sorts used columns", func() {
 		schema := &memstore.TableSchema{
 			Schema: metaCom.Table{
@@ -1815,6 +2347,125 @@ var _ = ginkgo.Describe("AQL compiler", func() {
 		}))
 	})
 
+	ginkgo.It("processes dimensions from joined tables", func() {
+		tripsSchema := &memstore.TableSchema{
+			ValueTypeByColumn: []memCom.DataType{
+				memCom.Uint32,
+				memCom.Uint16,
+			},
+			ColumnIDs: map[string]int{
+				"request_at": 0,
+				"city_id":    1,
+			},
+			Schema: metaCom.Table{
+				IsFactTable: true,
+				Columns: []metaCom.Column{
+					{Name: "request_at", Type: metaCom.Uint32},
+					{Name: "city_id", Type: metaCom.Uint16},
+				},
+			},
+		}
+		apiCitiesSchema := &memstore.TableSchema{
+			ValueTypeByColumn: []memCom.DataType{
+				memCom.Uint16,
+				memCom.BigEnum,
+				memCom.SmallEnum,
+			},
+			ColumnIDs: map[string]int{
+				"id":      0,
+				"name":    1,
+				"country": 2,
+			},
+			Schema: metaCom.Table{
+				Columns: []metaCom.Column{
+					{Name: "id", Type: metaCom.Uint16},
+					{Name: "name", Type: metaCom.BigEnum},
+					{Name: "country", Type: metaCom.SmallEnum},
+				},
+			},
+			EnumDicts: map[string]memstore.EnumDict{
+				"name": {
+					Capacity:    65535,
+					Dict:        map[string]int{"paris": 0},
+					ReverseDict: []string{"paris"},
+				},
+				"country": {
+					Capacity:    255,
+					Dict:        map[string]int{"france": 0},
+					ReverseDict: []string{"france"},
+				},
+			},
+		}
+		qc := &AQLQueryContext{
+			TableIDByAlias: map[string]int{
+				"trips": 0,
+				"c":     1,
+			},
+			TableSchemaByName: map[string]*memstore.TableSchema{
+				"trips":      tripsSchema,
+				"api_cities": apiCitiesSchema,
+			},
+			TableScanners: []*TableScanner{
+				{Schema: tripsSchema, ColumnUsages: map[int]columnUsage{}},
+				{Schema: apiCitiesSchema, ColumnUsages: map[int]columnUsage{}},
+			},
+		}
+		qc.Query = &AQLQuery{
+			Table: "trips",
+			Joins: []Join{
+				{
+					Table: "api_cities",
+					Alias: "c",
+					Conditions: []string{
+						"trips.city_id = c.id",
+					},
+				},
+			},
+			Measures: []Measure{
+				{Expr: "count(1)"},
+			},
+			Dimensions: []Dimension{{Expr: "c.country"}, {Expr: "c.name"}, {Expr: "city_id"}},
+		}
+		qc.parseExprs()
+		qc.resolveTypes()
+		Ω(qc.Error).Should(BeNil())
+		qc.processMeasureAndDimensions()
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.OOPK.Dimensions).Should(Equal([]expr.Expr{
+			&expr.VarRef{
+				Val:             "c.country",
+				TableID:         1,
+				ColumnID:        2,
+				EnumDict:        map[string]int{"france": 0},
+				EnumReverseDict: []string{"france"},
+				ExprType:        expr.Unsigned,
+				DataType:        memCom.SmallEnum,
+			},
+			&expr.VarRef{
+				Val:             "c.name",
+				TableID:         1,
+				ColumnID:        1,
+				EnumDict:        map[string]int{"paris": 0},
+				EnumReverseDict: []string{"paris"},
+				ExprType:        expr.Unsigned,
+				DataType:        memCom.BigEnum,
+			},
+			&expr.VarRef{
+				Val:      "city_id",
+				ColumnID: 1,
+				ExprType: expr.Unsigned,
+				DataType: memCom.Uint16,
+			},
+		}))
+		Ω(qc.TableScanners[1].ColumnUsages).Should(Equal(map[int]columnUsage{
+			1: columnUsedByAllBatches,
+			2: columnUsedByAllBatches,
+		}))
+
+		qc.sortDimensionColumns()
+		Ω(qc.OOPK.DimensionVectorIndex).Should(Equal([]int{2, 0, 1}))
+	})
+
 	ginkgo.It("processes hyperloglog", func() {
 		table := metaCom.Table{
 			IsFactTable: true,
@@ -3106,6 +3757,96 @@ var _ = ginkgo.Describe("AQL compiler", func() {
 		Ω(qc.Query.filters[0].String()).Should(Equal("NOT(id = 1 OR id = 2 OR id = 3)"))
 	})
 
+	ginkgo.It("enum array contains and explode should work", func() {
+		query := &AQLQuery{
+			Table:      "table1",
+			Dimensions: []Dimension{{Expr: "explode(tags)"}, {Expr: "id"}},
+			Measures:   []Measure{{Expr: "count(*)"}},
+			Filters:    []string{"contains(tags, 'b')", "contains(tags, 'missing')"},
+		}
+		tableSchema := &memstore.TableSchema{
+			ColumnIDs: map[string]int{
+				"time_col": 0,
+				"id":       1,
+				"tags":     2,
+			},
+			Schema: metaCom.Table{
+				Name:        "table1",
+				IsFactTable: true,
+				Columns: []metaCom.Column{
+					{Name: "time_col", Type: metaCom.Uint32},
+					{Name: "id", Type: metaCom.Uint16},
+					{Name: "tags", Type: metaCom.EnumArray},
+				},
+			},
+			ValueTypeByColumn: []memCom.DataType{
+				memCom.Uint32,
+				memCom.Uint16,
+				memCom.Uint32,
+			},
+			EnumDicts: map[string]memstore.EnumDict{
+				"tags": {
+					Dict:        map[string]int{"a": 0, "b": 1, "c": 2},
+					ReverseDict: []string{"a", "b", "c"},
+				},
+			},
+		}
+		newQC := func() *AQLQueryContext {
+			return &AQLQueryContext{
+				Query: query,
+				TableSchemaByName: map[string]*memstore.TableSchema{
+					"table1": tableSchema,
+				},
+				TableIDByAlias: map[string]int{
+					"table1": 0,
+				},
+				TableScanners: []*TableScanner{
+					{Schema: tableSchema, ColumnUsages: make(map[int]columnUsage)},
+				},
+			}
+		}
+		qc := newQC()
+		qc.parseExprs()
+		Ω(qc.Error).Should(BeNil())
+		qc.resolveTypes()
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.Query.filters[0].String()).Should(Equal("tags & 2 != 0"))
+		Ω(qc.Query.filters[1].String()).Should(Equal("tags & 0 != 0"))
+		Ω(qc.Query.Dimensions[0].expr.String()).Should(Equal("tags"))
+		Ω(qc.explodedDimensions).Should(Equal(map[int]bool{0: true}))
+		qc.processMeasureAndDimensions()
+		Ω(qc.Error).Should(BeNil())
+
+		query.Measures[0].Expr = "avg(id)"
+		qc = newQC()
+		qc.parseExprs()
+		qc.resolveTypes()
+		Ω(qc.Error).Should(BeNil())
+		qc.processMeasureAndDimensions()
+		Ω(qc.Error.Error()).Should(ContainSubstring("not supported with exploded dimensions"))
+
+		query.Measures[0].Expr = "count(*)"
+		tests := map[string][2]string{
+			"tags = 'a'":        {"id", "enum array column only supports"},
+			"not tags":          {"id", "enum array column tags only supports"},
+			"contains(id, 'a')": {"id", "expect 1st argument to be an enum array column"},
+			"contains(tags, 1)": {"id", "2nd argument of contains must be a string"},
+			"explode(tags)":     {"id", "explode can only be used as a dimension"},
+			"id = 1":            {"tags", "enum array column must be exploded"},
+			"id = 2":            {"explode(id)", "only enum array column can be exploded"},
+		}
+		for filter, test := range tests {
+			query.Filters = []string{filter}
+			query.Dimensions = []Dimension{{Expr: test[0]}}
+			qc = newQC()
+			qc.parseExprs()
+			Ω(qc.Error).Should(BeNil(), filter)
+			qc.resolveTypes()
+			Ω(qc.Error).ShouldNot(BeNil(), filter)
+			Ω(qc.Error.Error()).Should(ContainSubstring(test[1]), filter)
+		}
+	})
+
 	ginkgo.It("dayofweek and hour should work", func() {
 		query := &AQLQuery{
 			Table:   "table1",
@@ -19,10 +19,12 @@ import "C"
 
 import (
 	"bytes"
+	"context"
 	"github.com/uber/aresdb/memstore"
 	memCom "github.com/uber/aresdb/memstore/common"
 	queryCom "github.com/uber/aresdb/query/common"
 	"github.com/uber/aresdb/query/expr"
+	"github.com/uber/aresdb/utils"
 	"strings"
 	"time"
 	"unsafe"
@@ -297,6 +299,24 @@ type timezoneTableContext struct {
 	tableColumn string
 }
 
+// percentileContext stores the parameters of a percentile measure. The measure
+// is compiled into count(*) with an additional trailing dimension on the
+// bucketized measure value.
+type percentileContext struct {
+	// Quantile in (0, 100], 0 means the query has no percentile measure.
+	quantile float64
+}
+
+// histogramContext stores the parameters of a histogram measure. The measure
+// is compiled into count(*) with an additional trailing dimension on the
+// bucket index of the measure value.
+type histogramContext struct {
+	// Value expression as specified in the query.
+	value expr.Expr
+	// Increasing bucket bounds, nil means the query has no histogram measure.
+	bounds []float64
+}
+
 // GeoIntersection is the struct to storing geo intersection related fields.
 type geoIntersection struct {
 	// Following fields are generated by compiler.
@@ -356,6 +376,24 @@ type AQLQueryContext struct {
 
 	Profiling string `json:"profiling,omitempty"`
 
+	// Records the time spent in each executor stage if not nil.
+	Profile *QueryProfile `json:"-"`
+
+	// Context of the query. Once it's cancelled or past its deadline, query
+	// processing is aborted before the next batch and device memory is released.
+	Context context.Context `json:"-"`
+
+	// Guardrails of the query, set before processing.
+	Limits QueryLimits `json:"limits,omitempty"`
+	// rows scanned and max number of result groups so far, for enforcing the limits.
+	rowsScanned   int
+	resultRows    int
+	limitExceeded bool
+	// whether the query references tables that do not exist.
+	tableNotFound bool
+	// whether the query is executed under a span of a tracer other than the no-op tracer.
+	traced bool
+
 	// We alternate with two Cuda streams between batches for pipelining.
 	// [0] stores the current stream, and [1] stores the other stream.
 	cudaStreams [2]unsafe.Pointer
@@ -371,10 +409,46 @@ type AQLQueryContext struct {
 	fixedTimezone *time.Location
 	fromTime      *alignedTime
 	toTime        *alignedTime
-	dstswitch     int64
+	dst           *utils.DSTSegments
 
 	// timezone column and time filter related
 	timezoneTable timezoneTableContext
+
+	// percentile measure related
+	percentile percentileContext
+
+	// histogram measure related
+	histogram histogramContext
+
+	// measure combining multiple aggregates, e.g. sum(a)/sum(b).
+	arithmeticMeasure *arithmeticMeasure
+
+	// other tables of a union query, nil if the query scans one table.
+	union *unionTables
+
+	// selected columns of a row query, nil if the query aggregates measures.
+	rowColumns []string
+	// rows collected by a row query so far.
+	rows []topNGroup
+
+	// indexes of dimensions exploding enum array columns into one group per enum case.
+	explodedDimensions map[int]bool
+
+	// top groups selected by limit, nil if the query has no limit.
+	topN *topN
+
+	// empty time buckets filled into the result, nil if the query has no fill.
+	gapFill *gapFill
+
+	// running aggregates of the measures, nil if no measure has running set.
+	running *runningAggregate
+
+	// position of a paginated query, nil if the query is not paginated.
+	cursor *queryCursor
+	// How long the cursor of the next page can be used, DefaultCursorTTL if 0.
+	CursorTTL time.Duration `json:"-"`
+	// Cursor of the next page of a paginated query, empty after the last page.
+	NextCursor string `json:"nextCursor,omitempty"`
 }
 
 func (ctx *OOPKContext) IsHLL() bool {
@@ -0,0 +1,213 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"github.com/uber/aresdb/memstore"
+	"github.com/uber/aresdb/query/expr"
+	"github.com/uber/aresdb/utils"
+)
+
+// QueryPlan describes how a compiled query is going to be processed. It's returned
+// instead of the query results when the query is explained.
+type QueryPlan struct {
+	Table string `json:"table"`
+	// Foreign tables joined, each of them is transferred to device as a whole.
+	Joins []string `json:"joins,omitempty"`
+
+	// Filters used on host to skip live batches by the range of values of their columns.
+	Prefilters []string `json:"prefilters,omitempty"`
+	// Filters evaluated on device for every batch, time filters are only evaluated
+	// on live batches and the first and last archive batches.
+	TimeFilters         []string `json:"timeFilters,omitempty"`
+	MainTableFilters    []string `json:"mainTableFilters,omitempty"`
+	ForeignTableFilters []string `json:"foreignTableFilters,omitempty"`
+
+	Dimensions []string `json:"dimensions"`
+	Measure    string   `json:"measure"`
+
+	// Stages of processing on device in order. Batch level stages are repeated for every batch scanned.
+	Stages []string `json:"stages"`
+	// Stages applied on host to the aggregated results in order.
+	PostprocessStages []string `json:"postprocessStages,omitempty"`
+
+	Shards []ShardPlan `json:"shards"`
+	// Total number of rows in the batches to be scanned before filters are applied.
+	EstimatedRows int `json:"estimatedRows"`
+	// Limits of the query, the query is aborted once it exceeds them.
+	Limits QueryLimits `json:"limits"`
+	// Whether the query is expected to be aborted for scanning more rows than its limit.
+	ExceedsMaxRowsScanned bool `json:"exceedsMaxRowsScanned,omitempty"`
+}
+
+// ShardPlan lists the batches of a table shard to be scanned by the query.
+type ShardPlan struct {
+	Shard           int         `json:"shard"`
+	ArchivingCutoff uint32      `json:"archivingCutoff"`
+	LiveBatches     []BatchPlan `json:"liveBatches"`
+	ArchiveBatches  []BatchPlan `json:"archiveBatches"`
+}
+
+// BatchPlan describes a batch to be scanned.
+type BatchPlan struct {
+	BatchID int32 `json:"batchID"`
+	Rows    int   `json:"rows"`
+	// Skipped tells whether the live batch is skipped by the range of values of its columns.
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+// Explain returns the plan of the compiled query without processing it. Batches to be
+// scanned are listed according to the current state of the live and archive stores.
+func (qc *AQLQueryContext) Explain(memStore memstore.MemStore) *QueryPlan {
+	plan := &QueryPlan{
+		Table:               qc.Query.Table,
+		Prefilters:          exprStrings(qc.OOPK.Prefilters),
+		TimeFilters:         exprStrings(qc.OOPK.TimeFilters[:]),
+		MainTableFilters:    exprStrings(qc.OOPK.MainTableCommonFilters),
+		ForeignTableFilters: exprStrings(qc.OOPK.ForeignTableCommonFilters),
+		Dimensions:          exprStrings(qc.OOPK.Dimensions),
+		Shards:              []ShardPlan{},
+	}
+	if qc.OOPK.Measure != nil {
+		plan.Measure = qc.OOPK.Measure.String()
+	}
+	for _, join := range qc.Query.Joins {
+		plan.Joins = append(plan.Joins, join.Table)
+	}
+	plan.Stages = qc.explainStages()
+
+	if qc.Query.having != nil {
+		plan.PostprocessStages = append(plan.PostprocessStages, "having")
+	}
+	if qc.percentile.quantile > 0 {
+		plan.PostprocessStages = append(plan.PostprocessStages, "percentile")
+	}
+	if qc.histogram.bounds != nil {
+		plan.PostprocessStages = append(plan.PostprocessStages, "histogram")
+	}
+
+	for _, shardID := range qc.TableScanners[0].Shards {
+		shardPlan := qc.explainShard(memStore, shardID)
+		if qc.Error != nil {
+			return nil
+		}
+		for _, batches := range [][]BatchPlan{shardPlan.LiveBatches, shardPlan.ArchiveBatches} {
+			for _, batch := range batches {
+				if !batch.Skipped {
+					plan.EstimatedRows += batch.Rows
+				}
+			}
+		}
+		plan.Shards = append(plan.Shards, shardPlan)
+	}
+	plan.Limits = qc.Limits
+	plan.ExceedsMaxRowsScanned = qc.Limits.MaxRowsScanned > 0 && plan.EstimatedRows > qc.Limits.MaxRowsScanned
+	return plan
+}
+
+// explainStages returns the stages the query goes through in ProcessQuery and processBatch.
+func (qc *AQLQueryContext) explainStages() []string {
+	var stages []stageName
+	hasForeignTable := false
+	for _, foreignTable := range qc.OOPK.foreignTables {
+		hasForeignTable = hasForeignTable || foreignTable != nil
+	}
+	if hasForeignTable {
+		stages = append(stages, prepareForeignTableTiming)
+	}
+	stages = append(stages, transferTiming, prepareForFilteringTiming, initIndexVectorTiming, filterEvalTiming)
+	if hasForeignTable {
+		stages = append(stages, prepareForeignRecordIDsTiming)
+	}
+	if len(qc.OOPK.ForeignTableCommonFilters) > 0 {
+		stages = append(stages, foreignTableFilterEvalTiming)
+	}
+	if qc.OOPK.geoIntersection != nil {
+		stages = append(stages, geoIntersectEvalTiming)
+	}
+	stages = append(stages, prepareForDimAndMeasureTiming, dimEvalTiming)
+	if qc.rowColumns != nil {
+		stages = append(stages, rowCollectTiming)
+	} else if qc.OOPK.IsHLL() {
+		stages = append(stages, measureEvalTiming, hllEvalTiming)
+	} else {
+		stages = append(stages, measureEvalTiming, sortEvalTiming, reduceEvalTiming)
+	}
+	stages = append(stages, resultTransferTiming)
+
+	names := make([]string, len(stages))
+	for i, stage := range stages {
+		names[i] = string(stage)
+	}
+	return names
+}
+
+// explainShard lists the batches of the shard to be scanned the same way as processShard.
+func (qc *AQLQueryContext) explainShard(memStore memstore.MemStore, shardID int) (plan ShardPlan) {
+	plan = ShardPlan{Shard: shardID, LiveBatches: []BatchPlan{}, ArchiveBatches: []BatchPlan{}}
+	shard, err := memStore.GetTableShard(qc.Query.Table, shardID)
+	if err != nil {
+		qc.Error = utils.StackError(err, "failed to get shard %d for table %s",
+			shardID, qc.Query.Table)
+		return
+	}
+	defer shard.Users.Done()
+
+	var archiveStore *memstore.ArchiveStoreVersion
+	if shard.Schema.Schema.IsFactTable {
+		archiveStore = shard.ArchiveStore.GetCurrentVersion()
+		defer archiveStore.Users.Done()
+		plan.ArchivingCutoff = archiveStore.ArchivingCutoff
+	}
+
+	if int(plan.ArchivingCutoff) < qc.TableScanners[0].ArchiveBatchIDEnd*86400 && qc.scansLiveStore() {
+		batchIDs, numRecordsInLastBatch := shard.LiveStore.GetBatchIDs()
+		for i, batchID := range batchIDs {
+			batch := shard.LiveStore.GetBatchForRead(batchID)
+			if batch == nil {
+				continue
+			}
+			batchPlan := BatchPlan{BatchID: batchID, Rows: batch.Capacity}
+			if i == len(batchIDs)-1 {
+				batchPlan.Rows = numRecordsInLastBatch
+			}
+			batchPlan.Skipped = shard.Schema.Schema.IsFactTable && qc.shouldSkipLiveBatch(batch)
+			batch.RUnlock()
+			plan.LiveBatches = append(plan.LiveBatches, batchPlan)
+		}
+	}
+
+	if archiveStore != nil && qc.scansArchiveStore() {
+		scanner := qc.TableScanners[0]
+		for batchID := scanner.ArchiveBatchIDStart; batchID < scanner.ArchiveBatchIDEnd; batchID++ {
+			archiveBatch := archiveStore.RequestBatch(int32(batchID))
+			if archiveBatch.Size == 0 {
+				continue
+			}
+			plan.ArchiveBatches = append(plan.ArchiveBatches, BatchPlan{BatchID: int32(batchID), Rows: archiveBatch.Size})
+		}
+	}
+	return
+}
+
+func exprStrings(exprs []expr.Expr) []string {
+	var strs []string
+	for _, e := range exprs {
+		if e != nil {
+			strs = append(strs, e.String())
+		}
+	}
+	return strs
+}
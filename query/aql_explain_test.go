@@ -0,0 +1,212 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"sync"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	"github.com/uber/aresdb/memstore"
+	memCom "github.com/uber/aresdb/memstore/common"
+	memComMocks "github.com/uber/aresdb/memstore/common/mocks"
+	memMocks "github.com/uber/aresdb/memstore/mocks"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	metaMocks "github.com/uber/aresdb/metastore/mocks"
+)
+
+var _ = ginkgo.Describe("aql explain", func() {
+	var memStore *memMocks.MemStore
+	var shard *memstore.TableShard
+
+	ginkgo.BeforeEach(func() {
+		hostMemoryManager := new(memComMocks.HostMemoryManager)
+		hostMemoryManager.On("ReportUnmanagedSpaceUsageChange", mock.Anything).Return()
+		metaStore := new(metaMocks.MetaStore)
+		metaStore.On("GetArchiveBatchVersion", "trips", 0, mock.Anything, mock.Anything).
+			Return(uint32(0), uint32(0), 0, nil)
+
+		shard = memstore.NewTableShard(&memstore.TableSchema{
+			Schema: metaCom.Table{
+				Name:        "trips",
+				IsFactTable: true,
+				Columns: []metaCom.Column{
+					{Name: "request_at", Type: metaCom.Uint32},
+					{Name: "status", Type: metaCom.SmallEnum},
+					{Name: "fare", Type: metaCom.Float32},
+				},
+			},
+			ColumnIDs:         map[string]int{"request_at": 0, "status": 1, "fare": 2},
+			ValueTypeByColumn: []memCom.DataType{memCom.Uint32, memCom.SmallEnum, memCom.Float32},
+			EnumDicts: map[string]memstore.EnumDict{
+				"status": {
+					Dict:        map[string]int{"completed": 0},
+					ReverseDict: []string{"completed"},
+				},
+			},
+		}, metaStore, nil, hostMemoryManager, 0, memstore.Options{})
+
+		shard.ArchiveStore = &memstore.ArchiveStore{CurrentVersion: memstore.NewArchiveStoreVersion(100, shard)}
+		shard.ArchiveStore.CurrentVersion.Batches[0] = &memstore.ArchiveBatch{
+			Size:  5,
+			Shard: shard,
+			Batch: memstore.Batch{RWMutex: &sync.RWMutex{}},
+		}
+		shard.LiveStore = &memstore.LiveStore{
+			LastReadRecord: memstore.RecordID{BatchID: -101, Index: 3},
+			Batches: map[int32]*memstore.LiveBatch{
+				-110: {Batch: memstore.Batch{RWMutex: &sync.RWMutex{}, Columns: make([]memCom.VectorParty, 3)}, Capacity: 5},
+				-101: {Batch: memstore.Batch{RWMutex: &sync.RWMutex{}, Columns: make([]memCom.VectorParty, 3)}, Capacity: 5},
+			},
+		}
+
+		memStore = new(memMocks.MemStore)
+		memStore.On("RLock").Return()
+		memStore.On("RUnlock").Return()
+		memStore.On("GetSchemas").Return(map[string]*memstore.TableSchema{"trips": shard.Schema})
+		memStore.On("GetTableShard", "trips", 0).Run(func(args mock.Arguments) {
+			shard.Users.Add(1)
+		}).Return(shard, nil)
+	})
+
+	ginkgo.It("explains live and archive batches to be scanned", func() {
+		q := &AQLQuery{
+			Table:      "trips",
+			Dimensions: []Dimension{{Expr: "status"}},
+			Measures:   []Measure{{Expr: "sum(fare)"}},
+			Filters:    []string{"fare > 1"},
+			TimeFilter: TimeFilter{
+				Column: "request_at",
+				From:   "1970-01-01",
+				To:     "1970-01-03",
+			},
+		}
+		qc := q.Compile(memStore, false)
+		Ω(qc.Error).Should(BeNil())
+
+		plan := qc.Explain(memStore)
+		Ω(qc.Error).Should(BeNil())
+		Ω(plan.Table).Should(Equal("trips"))
+		Ω(plan.MainTableFilters).Should(Equal([]string{"fare > 1", "fare - fare = 0"}))
+		Ω(plan.TimeFilters).Should(HaveLen(2))
+		Ω(plan.Dimensions).Should(Equal([]string{"status"}))
+		Ω(plan.Stages).Should(Equal([]string{
+			"transfer",
+			"prepareForFiltering",
+			"initIndexVector",
+			"filterEval",
+			"prepareForDimAndMeasure",
+			"dimEval",
+			"measureEval",
+			"sortEval",
+			"reduceEval",
+			"resultTransfer",
+		}))
+		Ω(plan.PostprocessStages).Should(BeEmpty())
+		Ω(plan.Shards).Should(Equal([]ShardPlan{
+			{
+				Shard:           0,
+				ArchivingCutoff: 100,
+				// Live batches without values of the time column are skipped.
+				LiveBatches:    []BatchPlan{{BatchID: -110, Rows: 5, Skipped: true}, {BatchID: -101, Rows: 3, Skipped: true}},
+				ArchiveBatches: []BatchPlan{{BatchID: 0, Rows: 5}},
+			},
+		}))
+		Ω(plan.EstimatedRows).Should(Equal(5))
+		Ω(plan.ExceedsMaxRowsScanned).Should(BeFalse())
+		// the query is expected to be aborted once scanning the archive batch.
+		qc.Limits = QueryLimits{MaxRowsScanned: 4}
+		plan = qc.Explain(memStore)
+		Ω(plan.Limits).Should(Equal(qc.Limits))
+		Ω(plan.ExceedsMaxRowsScanned).Should(BeTrue())
+		// Batches are not locked after explaining.
+		released := make(chan struct{})
+		go func() {
+			shard.Users.Wait()
+			close(released)
+		}()
+		Eventually(released).Should(BeClosed())
+	})
+
+	ginkgo.It("explains the batches scanned for each consistency", func() {
+		explain := func(consistency string) ShardPlan {
+			q := &AQLQuery{
+				Table:       "trips",
+				Measures:    []Measure{{Expr: "count(*)"}},
+				TimeFilter:  TimeFilter{Column: "request_at", From: "1970-01-01", To: "1970-01-03"},
+				Consistency: consistency,
+			}
+			qc := q.Compile(memStore, false)
+			Ω(qc.Error).Should(BeNil())
+			plan := qc.Explain(memStore)
+			Ω(qc.Error).Should(BeNil())
+			Ω(plan.Shards).Should(HaveLen(1))
+			return plan.Shards[0]
+		}
+
+		liveBatches := []BatchPlan{{BatchID: -110, Rows: 5, Skipped: true}, {BatchID: -101, Rows: 3, Skipped: true}}
+		archiveBatches := []BatchPlan{{BatchID: 0, Rows: 5}}
+		plan := explain(ConsistencyDefault)
+		Ω(plan.LiveBatches).Should(Equal(liveBatches))
+		Ω(plan.ArchiveBatches).Should(Equal(archiveBatches))
+
+		// rows ingested since the archiving cutoff are excluded.
+		plan = explain(ConsistencyArchiveOnly)
+		Ω(plan.LiveBatches).Should(BeEmpty())
+		Ω(plan.ArchiveBatches).Should(Equal(archiveBatches))
+
+		plan = explain(ConsistencyLiveOnly)
+		Ω(plan.LiveBatches).Should(Equal(liveBatches))
+		Ω(plan.ArchiveBatches).Should(BeEmpty())
+	})
+
+	ginkgo.It("rejects invalid consistencies", func() {
+		q := &AQLQuery{
+			Table:       "trips",
+			Measures:    []Measure{{Expr: "count(*)"}},
+			TimeFilter:  TimeFilter{Column: "request_at", From: "1970-01-01", To: "1970-01-03"},
+			Consistency: "strong",
+		}
+		Ω(q.Compile(memStore, false).Error.Error()).Should(ContainSubstring("consistency must be"))
+
+		q.Consistency, q.Paginate, q.Limit = ConsistencyLiveOnly, true, 10
+		q.Dimensions = []Dimension{{Expr: "status"}}
+		Ω(q.Compile(memStore, false).Error.Error()).Should(ContainSubstring("not supported for paginated queries"))
+
+		q.Consistency, q.Paginate = ConsistencyArchiveOnly, false
+		shard.Schema.Schema.IsFactTable = false
+		Ω(q.Compile(memStore, false).Error.Error()).Should(ContainSubstring("only supported for fact tables"))
+	})
+
+	ginkgo.It("explains hll queries", func() {
+		q := &AQLQuery{
+			Table:      "trips",
+			Dimensions: []Dimension{{Expr: "status"}},
+			Measures:   []Measure{{Expr: "hll(request_at)"}},
+			TimeFilter: TimeFilter{
+				Column: "request_at",
+				From:   "1970-01-01",
+				To:     "1970-01-03",
+			},
+		}
+		qc := q.Compile(memStore, true)
+		Ω(qc.Error).Should(BeNil())
+		plan := qc.Explain(memStore)
+		Ω(qc.Error).Should(BeNil())
+		Ω(plan.Stages).Should(ContainElement("hllEval"))
+		Ω(plan.Stages).ShouldNot(ContainElement("sortEval"))
+	})
+})
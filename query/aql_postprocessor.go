@@ -18,11 +18,15 @@ package query
 import "C"
 
 import (
+	"fmt"
 		memCom "github.com/uber/aresdb/memstore/common"
 	"github.com/uber/aresdb/memutils"
 	queryCom "github.com/uber/aresdb/query/common"
 	"github.com/uber/aresdb/query/expr"
 	"github.com/uber/aresdb/utils"
+	"math"
+	"sort"
+	"strconv"
 	"unsafe"
 )
 
@@ -30,6 +34,51 @@ import (
 // format to AQLTimeSeriesResult nested result format. It also translates enum
 // values back to their string representations.
 func (qc *AQLQueryContext) Postprocess() queryCom.AQLTimeSeriesResult {
+	if qc.rowColumns != nil {
+		return qc.postprocessRows()
+	}
+	result := qc.postprocess()
+	if qc.Error == nil && qc.union != nil {
+		result = qc.union.postprocess(qc, result)
+	}
+	if qc.Error == nil && qc.arithmeticMeasure != nil {
+		result = qc.arithmeticMeasure.postprocess(qc, result)
+	}
+	if qc.Error == nil && qc.gapFill != nil {
+		qc.gapFill.apply(result)
+	}
+	if qc.Error == nil && qc.running != nil {
+		qc.running.apply(result)
+	}
+	if qc.Error == nil && qc.topN != nil {
+		last := qc.topN.apply(result)
+		if last != nil && qc.cursor != nil {
+			ttl := qc.CursorTTL
+			if ttl == 0 {
+				ttl = DefaultCursorTTL
+			}
+			qc.NextCursor, qc.Error = qc.cursor.encodeNext(*last, utils.Now(), ttl)
+		}
+	}
+	return result
+}
+
+// timeDimensionMeta returns the meta formatting the values of the dimension, nil if it is not a
+// time dimension.
+func (qc *AQLQueryContext) timeDimensionMeta(dimIndex int) *queryCom.TimeDimensionMeta {
+	if !qc.Query.Dimensions[dimIndex].isTimeDimension() {
+		return nil
+	}
+	return &queryCom.TimeDimensionMeta{
+		TimeBucketizer:  qc.Query.Dimensions[dimIndex].TimeBucketizer,
+		TimeUnit:        qc.Query.Dimensions[dimIndex].TimeUnit,
+		IsTimezoneTable: qc.timezoneTable.tableColumn != "",
+		TimeZone:        qc.fixedTimezone,
+		DST:             qc.getDSTSegments(),
+	}
+}
+
+func (qc *AQLQueryContext) postprocess() queryCom.AQLTimeSeriesResult {
 	oopkContext := qc.OOPK
 	if oopkContext.IsHLL() {
 		result, err := queryCom.NewTimeSeriesHLLResult(qc.HLLQueryResult, queryCom.HLLDataHeader)
@@ -38,7 +87,9 @@ func (qc *AQLQueryContext) Postprocess() queryCom.AQLTimeSeriesResult {
 			qc.Error = utils.StackError(err, "failed to read hll result")
 			return nil
 		}
-		return queryCom.ComputeHLLResult(result)
+		result = queryCom.ComputeHLLResult(result)
+		qc.filterHaving(result)
+		return result
 	}
 
 	result := make(queryCom.AQLTimeSeriesResult)
@@ -54,11 +105,6 @@ func (qc *AQLQueryContext) Postprocess() queryCom.AQLTimeSeriesResult {
 		dataTypes[dimIndex], reverseDicts[dimIndex] = getDimensionDataType(dimExpr), qc.getEnumReverseDict(dimIndex, dimExpr)
 	}
 
-	var fromOffset, toOffset int
-	if qc.fromTime != nil && qc.toTime != nil {
-		_, fromOffset = qc.fromTime.Time.Zone()
-		_, toOffset = qc.toTime.Time.Zone()
-	}
 	// caches time formatted time dimension values
 	dimensionValueCache := make([]map[queryCom.TimeDimensionMeta]map[int64]string, len(oopkContext.Dimensions))
 	for i := 0; i < oopkContext.ResultSize; i++ {
@@ -71,23 +117,9 @@ func (qc *AQLQueryContext) Postprocess() queryCom.AQLTimeSeriesResult {
 				dimensionValueCache[dimIndex] = make(map[queryCom.TimeDimensionMeta]map[int64]string)
 			}
 
-			var timeDimensionMeta *queryCom.TimeDimensionMeta
-
-			if qc.Query.Dimensions[dimIndex].isTimeDimension() {
-				timeDimensionMeta = &queryCom.TimeDimensionMeta{
-					TimeBucketizer:  qc.Query.Dimensions[dimIndex].TimeBucketizer,
-					TimeUnit:        qc.Query.Dimensions[dimIndex].TimeUnit,
-					IsTimezoneTable: qc.timezoneTable.tableColumn != "",
-					TimeZone:        qc.fixedTimezone,
-					DSTSwitchTs:     qc.dstswitch,
-					FromOffset:      fromOffset,
-					ToOffset:        toOffset,
-				}
-			}
-
 			dimValues[dimIndex] = queryCom.ReadDimension(
 				valuePtr, nullPtr, i, dataTypes[dimIndex], reverseDicts[dimIndex],
-				timeDimensionMeta, dimensionValueCache[dimIndex])
+				qc.timeDimensionMeta(dimIndex), dimensionValueCache[dimIndex])
 		}
 
 		measureBytes := oopkContext.MeasureBytes
@@ -101,11 +133,298 @@ func (qc *AQLQueryContext) Postprocess() queryCom.AQLTimeSeriesResult {
 			memutils.MemAccess(oopkContext.measureVectorH, i*oopkContext.MeasureBytes), oopkContext.Measure,
 			measureBytes)
 
-		result.Set(dimValues, measureValue)
+		if qc.explodedDimensions != nil {
+			qc.setExploded(result, dimValues, measureValue)
+		} else {
+			result.Set(dimValues, measureValue)
+		}
 	}
+
+	if qc.percentile.quantile > 0 {
+		qc.percentile.collapse(result, len(oopkContext.Dimensions)-1)
+	}
+	if qc.histogram.bounds != nil {
+		qc.histogram.collapse(result, len(oopkContext.Dimensions)-1)
+	}
+	qc.filterHaving(result)
 	return result
 }
 
+// setExploded sets the measure value of the row under each enum case of the exploded dimensions.
+// Values of rows falling into the same group after exploding are merged. Rows with null or
+// empty enum arrays go to the NULL group.
+func (qc *AQLQueryContext) setExploded(result map[string]interface{}, dimValues []*string, measureValue *float64) {
+	if len(dimValues) == 0 {
+		return
+	}
+	dimIndex := len(qc.OOPK.Dimensions) - len(dimValues)
+	enumCases := []*string{dimValues[0]}
+	if qc.explodedDimensions[dimIndex] {
+		enumCases = qc.explodeEnumArray(dimIndex, dimValues[0])
+	}
+
+	null := "NULL"
+	for _, enumCase := range enumCases {
+		if enumCase == nil {
+			enumCase = &null
+		}
+		if len(dimValues) == 1 {
+			result[*enumCase] = qc.mergeExploded(result[*enumCase], measureValue)
+			continue
+		}
+		child, _ := result[*enumCase].(map[string]interface{})
+		if child == nil {
+			child = make(map[string]interface{})
+			result[*enumCase] = child
+		}
+		qc.setExploded(child, dimValues[1:], measureValue)
+	}
+}
+
+// explodeEnumArray returns the enum cases of the enum array bitmap read as dimension value.
+func (qc *AQLQueryContext) explodeEnumArray(dimIndex int, dimValue *string) []*string {
+	if dimValue == nil {
+		return []*string{nil}
+	}
+	bitmap, err := strconv.ParseUint(*dimValue, 10, 32)
+	if err != nil || bitmap == 0 {
+		return []*string{nil}
+	}
+	var reverseDict []string
+	if varRef, ok := qc.OOPK.Dimensions[dimIndex].(*expr.VarRef); ok {
+		reverseDict = varRef.EnumReverseDict
+	}
+	var enumCases []*string
+	for enumID := 0; bitmap != 0; enumID++ {
+		if bitmap&1 != 0 {
+			enumCase := strconv.Itoa(enumID)
+			if enumID < len(reverseDict) {
+				enumCase = reverseDict[enumID]
+			}
+			enumCases = append(enumCases, &enumCase)
+		}
+		bitmap >>= 1
+	}
+	return enumCases
+}
+
+// mergeExploded merges the measure value into the existing value of an exploded group.
+func (qc *AQLQueryContext) mergeExploded(existing interface{}, measureValue *float64) interface{} {
+	existingValue, ok := existing.(float64)
+	if measureValue == nil {
+		if ok {
+			return existingValue
+		}
+		return nil
+	}
+	if !ok {
+		return *measureValue
+	}
+	switch qc.OOPK.AggregateType {
+	case C.AGGR_MIN_UNSIGNED, C.AGGR_MIN_SIGNED, C.AGGR_MIN_FLOAT:
+		return math.Min(existingValue, *measureValue)
+	case C.AGGR_MAX_UNSIGNED, C.AGGR_MAX_SIGNED, C.AGGR_MAX_FLOAT:
+		return math.Max(existingValue, *measureValue)
+	default:
+		return existingValue + *measureValue
+	}
+}
+
+// collapse replaces the trailing histogram dimension layer in the nested
+// result with the quantile computed from it. depth is the number of
+// dimension layers above the histogram layer.
+func (pc percentileContext) collapse(result map[string]interface{}, depth int) {
+	for key, value := range result {
+		child, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if depth > 1 {
+			pc.collapse(child, depth-1)
+		} else {
+			result[key] = pc.compute(child)
+		}
+	}
+}
+
+// compute returns the nearest-rank quantile from the histogram of measure
+// value to count, or nil if there are no non NULL values.
+func (pc percentileContext) compute(histogram map[string]interface{}) interface{} {
+	type bucket struct {
+		value float64
+		count float64
+	}
+
+	var buckets []bucket
+	var total float64
+	for key, value := range histogram {
+		count, ok := value.(float64)
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseFloat(key, 64)
+		if err != nil {
+			// NULL values.
+			continue
+		}
+		buckets = append(buckets, bucket{v, count})
+		total += count
+	}
+
+	if total == 0 {
+		return nil
+	}
+
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].value < buckets[j].value
+	})
+
+	rank := math.Max(math.Ceil(pc.quantile/100*total), 1)
+	var cumulative float64
+	for _, b := range buckets {
+		cumulative += b.count
+		if cumulative >= rank {
+			return b.value
+		}
+	}
+	return buckets[len(buckets)-1].value
+}
+
+// collapse replaces the bucket indexes of the trailing dimension layer in the
+// nested result with the bucket names, depth is the number of dimension layers
+// above the bucket layer.
+func (hc histogramContext) collapse(result map[string]interface{}, depth int) {
+	if depth == 0 {
+		hc.nameBuckets(result)
+		return
+	}
+	for _, value := range result {
+		if child, ok := value.(map[string]interface{}); ok {
+			hc.collapse(child, depth-1)
+		}
+	}
+}
+
+// nameBuckets renames the buckets of the histogram from bucket index to bucket
+// name in place and adds empty buckets. Rows with NULL values are not counted.
+func (hc histogramContext) nameBuckets(histogram map[string]interface{}) {
+	counts := make([]float64, len(hc.bounds)+1)
+	for key, value := range histogram {
+		delete(histogram, key)
+		index, err := strconv.Atoi(key)
+		if count, ok := value.(float64); ok && err == nil && index >= 0 && index < len(counts) {
+			counts[index] += count
+		}
+	}
+	for index, count := range counts {
+		histogram[hc.bucketName(index)] = count
+	}
+}
+
+// bucketName returns the name of the bucket, underflow and overflow for values
+// out of the bounds, [lower, upper) otherwise.
+func (hc histogramContext) bucketName(index int) string {
+	switch index {
+	case 0:
+		return "underflow"
+	case len(hc.bounds):
+		return "overflow"
+	}
+	return fmt.Sprintf("[%s, %s)", strconv.FormatFloat(hc.bounds[index-1], 'g', -1, 64),
+		strconv.FormatFloat(hc.bounds[index], 'g', -1, 64))
+}
+
+// matchHaving tells whether the measure value of a group satisfies the having
+// clause. Groups with NULL measure never match a having clause.
+func (qc *AQLQueryContext) matchHaving(measureValue *float64) bool {
+	if qc.Query == nil || qc.Query.having == nil {
+		return true
+	}
+	return measureValue != nil && evalHaving(qc.Query.having, *measureValue) != 0
+}
+
+// filterHaving removes groups not matching the having clause from the nested
+// result, as well as the dimension layers left empty.
+func (qc *AQLQueryContext) filterHaving(result map[string]interface{}) {
+	if qc.Query == nil || qc.Query.having == nil {
+		return
+	}
+	for key, value := range result {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			qc.filterHaving(v)
+			if len(v) == 0 {
+				delete(result, key)
+			}
+		case float64:
+			if !qc.matchHaving(&v) {
+				delete(result, key)
+			}
+		default:
+			delete(result, key)
+		}
+	}
+}
+
+// evalHaving evaluates the validated having expression against the measure
+// value, boolean results are represented as 1 and 0.
+func evalHaving(e expr.Expr, measureValue float64) float64 {
+	boolToFloat := func(b bool) float64 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+
+	switch e := e.(type) {
+	case *expr.ParenExpr:
+		return evalHaving(e.Expr, measureValue)
+	case *expr.NumberLiteral:
+		return e.Val
+	case *expr.Call:
+		return measureValue
+	case *expr.UnaryExpr:
+		value := evalHaving(e.Expr, measureValue)
+		if e.Op == expr.NOT {
+			return boolToFloat(value == 0)
+		}
+		return -value
+	case *expr.BinaryExpr:
+		lhs := evalHaving(e.LHS, measureValue)
+		// short circuit for logical operators.
+		switch e.Op {
+		case expr.AND:
+			return boolToFloat(lhs != 0 && evalHaving(e.RHS, measureValue) != 0)
+		case expr.OR:
+			return boolToFloat(lhs != 0 || evalHaving(e.RHS, measureValue) != 0)
+		}
+		rhs := evalHaving(e.RHS, measureValue)
+		switch e.Op {
+		case expr.EQ:
+			return boolToFloat(lhs == rhs)
+		case expr.NEQ:
+			return boolToFloat(lhs != rhs)
+		case expr.LT:
+			return boolToFloat(lhs < rhs)
+		case expr.LTE:
+			return boolToFloat(lhs <= rhs)
+		case expr.GT:
+			return boolToFloat(lhs > rhs)
+		case expr.GTE:
+			return boolToFloat(lhs >= rhs)
+		case expr.ADD:
+			return lhs + rhs
+		case expr.SUB:
+			return lhs - rhs
+		case expr.MUL:
+			return lhs * rhs
+		case expr.DIV:
+			return lhs / rhs
+		}
+	}
+	return 0
+}
+
 // PostprocessAsHLLData serializes the query result into HLLData format. It will also release the device memory after
 // serialization.
 func (qc *AQLQueryContext) PostprocessAsHLLData() ([]byte, error) {
@@ -158,6 +477,17 @@ func (qc *AQLQueryContext) ReleaseHostResultsBuffers() {
 
 	// set geoIntersection to nil
 	qc.OOPK.geoIntersection = nil
+
+	if qc.arithmeticMeasure != nil {
+		for _, subQC := range qc.arithmeticMeasure.subQueryContexts {
+			subQC.ReleaseHostResultsBuffers()
+		}
+	}
+	if qc.union != nil {
+		for _, tableQC := range qc.union.tableContexts {
+			tableQC.ReleaseHostResultsBuffers()
+		}
+	}
 }
 
 func readMeasure(measureRow unsafe.Pointer, ast expr.Expr, measureBytes int) *float64 {
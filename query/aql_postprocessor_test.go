@@ -21,6 +21,9 @@ import (
 	"github.com/uber/aresdb/memutils"
 	queryCom "github.com/uber/aresdb/query/common"
 	"github.com/uber/aresdb/query/expr"
+	"math"
+	"sort"
+	"strconv"
 	"time"
 	"unsafe"
 )
@@ -109,6 +112,349 @@ var _ = ginkgo.Describe("AQL postprocessor", func() {
 		}))
 	})
 
+	ginkgo.It("streams groups from result buffers in the order of the nested result", func() {
+		ctx := &AQLQueryContext{
+			Query: &AQLQuery{
+				Dimensions: []Dimension{{Expr: ""}, {Expr: ""}},
+			},
+			OOPK: OOPKContext{
+				Dimensions: []expr.Expr{
+					&expr.VarRef{
+						ExprType:        expr.Unsigned,
+						DataType:        memCom.BigEnum,
+						EnumReverseDict: []string{"zero", "one", "two"},
+					},
+					&expr.NumberLiteral{ExprType: expr.Signed},
+				},
+				Measure:              &expr.NumberLiteral{ExprType: expr.Float},
+				MeasureBytes:         4,
+				DimRowBytes:          8,
+				NumDimsPerDimWidth:   queryCom.DimCountsPerDimWidth{0, 0, 1, 1, 0},
+				DimensionVectorIndex: []int{1, 0},
+				ResultSize:           4,
+				// values of the second dimension, values of the first, then their validities.
+				dimensionVectorH: unsafe.Pointer(&[]uint8{
+					12, 0, 0, 0, 5, 0, 0, 0, 12, 0, 0, 0, 0, 0, 0, 0,
+					2, 0, 1, 0, 2, 0, 2, 0,
+					1, 1, 1, 0,
+					1, 1, 1, 1}[0]),
+				measureVectorH: unsafe.Pointer(&[]float32{1, 2, 3, 4}[0]),
+			},
+		}
+		Ω(ctx.StreamsGroups()).Should(BeTrue())
+
+		var visited [][]interface{}
+		visit := func(dimValues []string, measureValue *float64) {
+			visited = append(visited, []interface{}{dimValues[0], dimValues[1], *measureValue})
+		}
+		ctx.StreamGroups(visit)
+		// the last of the groups with the same dimension values is kept as in Postprocess.
+		Ω(visited).Should(Equal([][]interface{}{
+			{"one", "5", 2.0},
+			{"two", "12", 3.0},
+			{"two", "NULL", 4.0},
+		}))
+		Ω(ctx.Postprocess()).Should(Equal(queryCom.AQLTimeSeriesResult{
+			"one": map[string]interface{}{"5": 2.0},
+			"two": map[string]interface{}{"12": 3.0, "NULL": 4.0},
+		}))
+
+		visited = nil
+		ctx.Query.having = &expr.BinaryExpr{Op: expr.GT, LHS: &expr.Call{Name: "sum"}, RHS: &expr.NumberLiteral{Val: 2}}
+		ctx.StreamGroups(visit)
+		Ω(visited).Should(Equal([][]interface{}{
+			{"two", "12", 3.0},
+			{"two", "NULL", 4.0},
+		}))
+
+		ctx.topN = &topN{limit: 1}
+		Ω(ctx.StreamsGroups()).Should(BeFalse())
+	})
+
+	ginkgo.It("merges exploded enum array dimensions", func() {
+		ctx := &AQLQueryContext{
+			OOPK: OOPKContext{
+				Dimensions: []expr.Expr{
+					&expr.NumberLiteral{ExprType: expr.Unsigned},
+					&expr.VarRef{
+						ExprType:          expr.Unsigned,
+						DataType:          memCom.Uint32,
+						EnumReverseDict:   []string{"a", "b"},
+						IsEnumArrayColumn: true,
+					},
+				},
+				AggregateType: 1,
+			},
+			explodedDimensions: map[int]bool{1: true},
+		}
+		str := func(s string) *string {
+			return &s
+		}
+		num := func(v float64) *float64 {
+			return &v
+		}
+
+		result := queryCom.AQLTimeSeriesResult{}
+		// bitmap 7 has an enum id not in the reverse dict.
+		ctx.setExploded(result, []*string{str("1"), str("7")}, num(2))
+		ctx.setExploded(result, []*string{str("1"), str("2")}, num(3))
+		ctx.setExploded(result, []*string{str("1"), str("0")}, num(4))
+		ctx.setExploded(result, []*string{str("1"), nil}, num(5))
+		ctx.setExploded(result, []*string{str("2"), str("1")}, nil)
+		Ω(result).Should(Equal(queryCom.AQLTimeSeriesResult{
+			"1": map[string]interface{}{
+				"a":    float64(2),
+				"b":    float64(5),
+				"2":    float64(2),
+				"NULL": float64(9),
+			},
+			"2": map[string]interface{}{
+				"a": nil,
+			},
+		}))
+
+		// AGGR_MAX_UNSIGNED
+		ctx.OOPK.AggregateType = 7
+		result = queryCom.AQLTimeSeriesResult{}
+		ctx.setExploded(result, []*string{str("1"), str("3")}, num(2))
+		ctx.setExploded(result, []*string{str("1"), str("2")}, num(3))
+		Ω(result).Should(Equal(queryCom.AQLTimeSeriesResult{
+			"1": map[string]interface{}{
+				"a": float64(2),
+				"b": float64(3),
+			},
+		}))
+	})
+
+	ginkgo.It("applies having on float measure", func() {
+		ctx := &AQLQueryContext{
+			Query: &AQLQuery{
+				Dimensions: []Dimension{
+					{Expr: ""},
+				},
+				Having: "max(fare) > 5",
+			},
+		}
+		ctx.Query.having, _ = expr.ParseExpr(ctx.Query.Having)
+		ctx.OOPK = OOPKContext{
+			Dimensions: []expr.Expr{
+				&expr.VarRef{
+					ExprType: expr.Unsigned,
+					DataType: memCom.Uint8,
+				},
+			},
+			Measure: &expr.NumberLiteral{
+				ExprType: expr.Float,
+			},
+			MeasureBytes:         4,
+			DimRowBytes:          2,
+			DimensionVectorIndex: []int{0},
+			NumDimsPerDimWidth:   queryCom.DimCountsPerDimWidth{0, 0, 0, 0, 1},
+			ResultSize:           3,
+			dimensionVectorH:     unsafe.Pointer(&[]uint8{1, 2, 3, 1, 1, 1}[0]),
+			measureVectorH:       unsafe.Pointer(&[]float32{3.2, 6.4, 5.0}[0]),
+		}
+
+		Ω(ctx.Postprocess()).Should(Equal(queryCom.AQLTimeSeriesResult{
+			"2": float64(float32(6.4)),
+		}))
+	})
+
+	ginkgo.It("applies having on integer measure", func() {
+		ctx := &AQLQueryContext{
+			Query: &AQLQuery{
+				Dimensions: []Dimension{
+					{Expr: ""},
+					{Expr: ""},
+				},
+				Having: "sum(fare) >= 10 AND sum(fare) < 20 OR sum(fare) = 3",
+			},
+		}
+		ctx.Query.having, _ = expr.ParseExpr(ctx.Query.Having)
+		ctx.OOPK = OOPKContext{
+			Dimensions: []expr.Expr{
+				&expr.VarRef{
+					ExprType: expr.Unsigned,
+					DataType: memCom.Uint8,
+				},
+				&expr.VarRef{
+					ExprType: expr.Unsigned,
+					DataType: memCom.Uint8,
+				},
+			},
+			Measure: &expr.NumberLiteral{
+				ExprType: expr.Signed,
+			},
+			MeasureBytes:         8,
+			DimRowBytes:          4,
+			DimensionVectorIndex: []int{0, 1},
+			NumDimsPerDimWidth:   queryCom.DimCountsPerDimWidth{0, 0, 0, 0, 2},
+			ResultSize:           4,
+			dimensionVectorH: unsafe.Pointer(&[]uint8{
+				1, 1, 2, 2, 1, 2, 1, 2,
+				1, 1, 1, 1, 1, 1, 1, 1}[0]),
+			measureVectorH: unsafe.Pointer(&[]int64{3, 9, 10, 20}[0]),
+		}
+
+		Ω(ctx.Postprocess()).Should(Equal(queryCom.AQLTimeSeriesResult{
+			"1": map[string]interface{}{
+				"1": float64(3),
+			},
+			"2": map[string]interface{}{
+				"1": float64(10),
+			},
+		}))
+	})
+
+	ginkgo.It("filters nested result with having", func() {
+		ctx := &AQLQueryContext{
+			Query: &AQLQuery{},
+		}
+		result := queryCom.AQLTimeSeriesResult{
+			"a": map[string]interface{}{
+				"x": 1.0,
+				"y": 5.0,
+			},
+			"b": map[string]interface{}{
+				"x": 2.0,
+				"y": nil,
+			},
+		}
+		ctx.filterHaving(result)
+		Ω(result).Should(HaveLen(2))
+
+		ctx.Query.having, _ = expr.ParseExpr("countdistincthll(user_id) > 2")
+		ctx.filterHaving(result)
+		Ω(result).Should(Equal(queryCom.AQLTimeSeriesResult{
+			"a": map[string]interface{}{
+				"y": 5.0,
+			},
+		}))
+	})
+
+	ginkgo.It("collapses percentile histogram", func() {
+		ctx := &AQLQueryContext{
+			Query: &AQLQuery{
+				Dimensions: []Dimension{
+					{Expr: ""},
+					{Expr: ""},
+				},
+			},
+			percentile: percentileContext{quantile: 50},
+		}
+		ctx.OOPK = OOPKContext{
+			Dimensions: []expr.Expr{
+				&expr.VarRef{
+					ExprType: expr.Unsigned,
+					DataType: memCom.Uint8,
+				},
+				&expr.VarRef{
+					ExprType: expr.Unsigned,
+					DataType: memCom.Uint8,
+				},
+			},
+			Measure: &expr.NumberLiteral{
+				ExprType: expr.Unsigned,
+			},
+			MeasureBytes:         8,
+			DimRowBytes:          4,
+			DimensionVectorIndex: []int{0, 1},
+			NumDimsPerDimWidth:   queryCom.DimCountsPerDimWidth{0, 0, 0, 0, 2},
+			ResultSize:           5,
+			dimensionVectorH: unsafe.Pointer(&[]uint8{
+				1, 1, 1, 2, 2, 10, 20, 30, 10, 0,
+				1, 1, 1, 1, 1, 1, 1, 1, 0, 0}[0]),
+			measureVectorH: unsafe.Pointer(&[]uint64{1, 1, 3, 2, 5}[0]),
+		}
+
+		Ω(ctx.Postprocess()).Should(Equal(queryCom.AQLTimeSeriesResult{
+			"1": float64(30),
+			"2": nil,
+		}))
+	})
+
+	ginkgo.It("names histogram buckets", func() {
+		ctx := &AQLQueryContext{
+			Query: &AQLQuery{
+				Dimensions: []Dimension{
+					{Expr: ""},
+					{Expr: ""},
+				},
+			},
+			histogram: histogramContext{bounds: []float64{10, 100, 1000}},
+		}
+		ctx.OOPK = OOPKContext{
+			Dimensions: []expr.Expr{
+				&expr.VarRef{
+					ExprType: expr.Unsigned,
+					DataType: memCom.Uint8,
+				},
+				&expr.VarRef{
+					ExprType: expr.Unsigned,
+					DataType: memCom.Uint8,
+				},
+			},
+			Measure: &expr.NumberLiteral{
+				ExprType: expr.Unsigned,
+			},
+			MeasureBytes:         8,
+			DimRowBytes:          4,
+			DimensionVectorIndex: []int{0, 1},
+			NumDimsPerDimWidth:   queryCom.DimCountsPerDimWidth{0, 0, 0, 0, 2},
+			ResultSize:           5,
+			dimensionVectorH: unsafe.Pointer(&[]uint8{
+				1, 1, 1, 2, 2, 0, 2, 3, 1, 0,
+				1, 1, 1, 1, 1, 1, 1, 1, 1, 0}[0]),
+			measureVectorH: unsafe.Pointer(&[]uint64{1, 4, 2, 3, 5}[0]),
+		}
+
+		// empty buckets are zero and NULL values, whose bucket index is NULL, are not counted.
+		Ω(ctx.Postprocess()).Should(Equal(queryCom.AQLTimeSeriesResult{
+			"1": map[string]interface{}{
+				"underflow":   1.0,
+				"[10, 100)":   0.0,
+				"[100, 1000)": 4.0,
+				"overflow":    2.0,
+			},
+			"2": map[string]interface{}{
+				"underflow":   0.0,
+				"[10, 100)":   3.0,
+				"[100, 1000)": 0.0,
+				"overflow":    0.0,
+			},
+		}))
+
+		// the result is the histogram without other dimensions.
+		hc := histogramContext{bounds: []float64{0.5}}
+		result := map[string]interface{}{"0": 2.0, "1": 3.0, "NULL": 1.0}
+		hc.collapse(result, 0)
+		Ω(result).Should(Equal(map[string]interface{}{"underflow": 2.0, "overflow": 3.0}))
+	})
+
+	ginkgo.It("computes percentile within bucket width", func() {
+		for _, quantile := range []float64{50, 90, 99, 99.9} {
+			pc := percentileContext{quantile: quantile}
+			exact, bucketized := map[string]interface{}{}, map[string]interface{}{}
+			values := make([]float64, 0, 10000)
+			for i := 0; i < 10000; i++ {
+				// skewed latency distribution.
+				value := float64(i * i % 7919)
+				values = append(values, value)
+				key := strconv.FormatFloat(value, 'g', -1, 64)
+				count, _ := exact[key].(float64)
+				exact[key] = count + 1
+				key = strconv.FormatFloat(math.Floor(value/10)*10, 'g', -1, 64)
+				count, _ = bucketized[key].(float64)
+				bucketized[key] = count + 1
+			}
+			sort.Float64s(values)
+			expected := values[int(math.Ceil(quantile/100*float64(len(values))))-1]
+
+			Ω(pc.compute(exact)).Should(Equal(expected))
+			Ω(pc.compute(bucketized)).Should(BeNumerically("~", expected, 10))
+		}
+	})
+
 	ginkgo.It("works on float dimension and nil measure", func() {
 		ctx := &AQLQueryContext{
 			Query: &AQLQuery{
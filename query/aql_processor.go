@@ -46,6 +46,35 @@ type customFilterExecutor func(stream unsafe.Pointer)
 
 // ProcessQuery processes the compiled query and executes it on GPU.
 func (qc *AQLQueryContext) ProcessQuery(memStore memstore.MemStore) {
+	span := qc.startExecuteSpan()
+	defer qc.finishExecuteSpan(span)
+	qc.processQuery(memStore)
+	qc.profileLimits()
+	if qc.Error == nil && qc.union != nil {
+		qc.union.processTables(qc, memStore)
+	}
+	if qc.Error == nil && qc.arithmeticMeasure != nil {
+		qc.arithmeticMeasure.processSubQueries(qc, memStore)
+	}
+}
+
+// processSubQuery executes the sub query on the device of the query context, with the same
+// context, limits and profile. Limits exceeded by the sub query are reported by the query context.
+func (qc *AQLQueryContext) processSubQuery(subQC *AQLQueryContext, memStore memstore.MemStore) {
+	subQC.Device = qc.Device
+	subQC.Context = qc.Context
+	subQC.Debug = qc.Debug
+	subQC.Profiling = qc.Profiling
+	subQC.Profile = qc.Profile
+	subQC.Limits = qc.Limits
+	subQC.OOPK.DeviceMemoryRequirement = qc.OOPK.DeviceMemoryRequirement
+	subQC.ProcessQuery(memStore)
+	if subQC.Error != nil {
+		qc.limitExceeded = subQC.limitExceeded
+	}
+}
+
+func (qc *AQLQueryContext) processQuery(memStore memstore.MemStore) {
 	defer func() {
 		if r := recover(); r != nil {
 			// find out exactly what the error was and set err
@@ -107,14 +136,29 @@ func (qc *AQLQueryContext) ProcessQuery(memStore memstore.MemStore) {
 	}
 
 	for _, shardID := range qc.TableScanners[0].Shards {
+		if qc.rowsCollected() {
+			break
+		}
 		previousBatchExecutor = qc.processShard(memStore, shardID, previousBatchExecutor)
 		if qc.Error != nil {
+			if qc.limitExceeded || qc.checkCancelled() {
+				// release device memory held by the pending batch.
+				qc.Release()
+			}
 			return
 		}
 	}
 
+	if qc.checkCancelled() {
+		qc.Release()
+		return
+	}
+
 	// query execution for last batch.
 	previousBatchExecutor(true)
+	if qc.Error == nil {
+		qc.checkResultRows()
+	}
 
 	// this code snippet does the followings:
 	// 1. write stats to log.
@@ -152,6 +196,10 @@ func (qc *AQLQueryContext) ProcessQuery(memStore memstore.MemStore) {
 
 func (qc *AQLQueryContext) processShard(memStore memstore.MemStore, shardID int, previousBatchExecutor func(isLastBatch bool)) func(isLastBatch bool) {
 	var liveRecordsProcessed, archiveRecordsProcessed, liveBatchProcessed, archiveBatchProcessed, liveBytesTransferred, archiveBytesTransferred int
+	if qc.checkCancelled() {
+		return previousBatchExecutor
+	}
+
 	shard, err := memStore.GetTableShard(qc.Query.Table, shardID)
 	if err != nil {
 		qc.Error = utils.StackError(err, "failed to get shard %d for table %s",
@@ -169,9 +217,12 @@ func (qc *AQLQueryContext) processShard(memStore memstore.MemStore, shardID int,
 	}
 
 	// Process live batches.
-	if int(cutoff) < qc.TableScanners[0].ArchiveBatchIDEnd*86400 {
+	if int(cutoff) < qc.TableScanners[0].ArchiveBatchIDEnd*86400 && qc.scansLiveStore() {
 		batchIDs, numRecordsInLastBatch := shard.LiveStore.GetBatchIDs()
 		for i, batchID := range batchIDs {
+			if qc.checkCancelled() || qc.rowsCollected() {
+				break
+			}
 			batch := shard.LiveStore.GetBatchForRead(batchID)
 			if batch == nil {
 				continue
@@ -186,11 +237,15 @@ func (qc *AQLQueryContext) processShard(memStore memstore.MemStore, shardID int,
 				continue
 			}
 
-			liveBatchProcessed++
 			size := batch.Capacity
 			if i == len(batchIDs)-1 {
 				size = numRecordsInLastBatch
 			}
+			if qc.checkLimits(size) {
+				batch.RUnlock()
+				break
+			}
+			liveBatchProcessed++
 			liveRecordsProcessed += size
 			previousBatchExecutor = qc.processBatch(&batch.Batch,
 				batchID,
@@ -202,14 +257,20 @@ func (qc *AQLQueryContext) processShard(memStore memstore.MemStore, shardID int,
 	}
 
 	// Process archive batches.
-	if archiveStore != nil {
+	if archiveStore != nil && qc.scansArchiveStore() {
 		scanner := qc.TableScanners[0]
 		for batchID := scanner.ArchiveBatchIDStart; batchID < scanner.ArchiveBatchIDEnd; batchID++ {
+			if qc.limitExceeded || qc.checkCancelled() || qc.rowsCollected() {
+				break
+			}
 			archiveBatch := archiveStore.RequestBatch(int32(batchID))
 			if archiveBatch.Size == 0 {
 				qc.OOPK.ArchiveBatchStats.NumBatchSkipped++
 				continue
 			}
+			if qc.checkLimits(archiveBatch.Size) {
+				break
+			}
 			isFirstOrLast := batchID == scanner.ArchiveBatchIDStart || batchID == scanner.ArchiveBatchIDEnd-1
 			previousBatchExecutor = qc.processBatch(
 				&archiveBatch.Batch,
@@ -233,6 +294,19 @@ func (qc *AQLQueryContext) processShard(memStore memstore.MemStore, shardID int,
 	return previousBatchExecutor
 }
 
+// checkCancelled tells whether the query context is cancelled or past its deadline, in which case the query
+// error is set. It's checked before each batch so that the query is aborted at batch boundaries.
+func (qc *AQLQueryContext) checkCancelled() bool {
+	if qc.Context == nil {
+		return false
+	}
+	if err := qc.Context.Err(); err != nil {
+		qc.Error = utils.StackError(err, "query is cancelled")
+		return true
+	}
+	return false
+}
+
 // Release releases all device memory it allocated. It **should only called** when any errors happens while the query is
 // processed.
 func (qc *AQLQueryContext) Release() {
@@ -385,6 +459,14 @@ func (qc *AQLQueryContext) prepareForeignTable(memStore memstore.MemStore, joinT
 	}
 	defer shard.Users.Done()
 
+	// the whole dimension table is transferred to device for every query, so limit its size.
+	maxRecords := qc.Limits.MaxJoinTableRecords
+	if numRecords := int(shard.LiveStore.PrimaryKey.Size()); maxRecords > 0 && numRecords > maxRecords {
+		qc.Error = utils.StackError(nil, "Join table %s has %d records, exceeding the limit of %d",
+			join.Table, numRecords, maxRecords)
+		return
+	}
+
 	// only need live store for dimension table
 	batchIDs, numRecordsInLastBatch := shard.LiveStore.GetBatchIDs()
 	ft.numRecordsInLastBatch = numRecordsInLastBatch
@@ -417,6 +499,7 @@ func (qc *AQLQueryContext) prepareForeignTable(memStore memstore.MemStore, joinT
 			if usage&(columnUsedByAllBatches|columnUsedByLiveBatches) != 0 {
 				sourceVP := batch.Columns[columnID]
 				if sourceVP == nil {
+					deviceBatches[batchIndex][i] = makeDefaultColumn(qc.TableScanners[joinTableID+1].Schema, columnID, size)
 					continue
 				}
 
@@ -499,6 +582,7 @@ func (qc *AQLQueryContext) transferLiveBatch(batch *memstore.LiveBatch, size int
 				}
 				sourceVP := batch.Columns[columnID]
 				if sourceVP == nil {
+					deviceColumns[i] = makeDefaultColumn(qc.TableScanners[0].Schema, columnID, size)
 					continue
 				}
 
@@ -809,7 +893,7 @@ func (qc *AQLQueryContext) processBatch(
 		}
 	}()
 
-	if qc.Debug {
+	if qc.recordsTimings() {
 		// Finish executing previous batch first to avoid timeline overlapping
 		previousBatchExecutor(false)
 		previousBatchExecutor = func(isLastBatch bool) {}
@@ -974,8 +1058,11 @@ func (qc *AQLQueryContext) processBatch(
 
 		qc.reportTimingForCurrentBatch(stream, &start, dimEvalTiming)
 
-		// measure evaluation.
+		// measure evaluation, rows of row queries have no measure.
 		qc.doProfile(func() {
+			if qc.rowColumns != nil {
+				return
+			}
 			measureExprRootAction := qc.OOPK.currentBatch.makeWriteToMeasureVectorAction(qc.OOPK.AggregateType, qc.OOPK.MeasureBytes)
 			qc.OOPK.currentBatch.processExpression(qc.OOPK.Measure, nil, qc.TableScanners, qc.OOPK.foreignTables, stream, qc.Device, measureExprRootAction)
 			qc.reportTimingForCurrentBatch(stream, &start, measureEvalTiming)
@@ -989,11 +1076,17 @@ func (qc *AQLQueryContext) processBatch(
 		if qc.OOPK.IsHLL() {
 			initIndexVector(qc.OOPK.currentBatch.dimIndexVectorD[0].getPointer(), 0, qc.OOPK.currentBatch.resultSize, stream, qc.Device)
 			initIndexVector(qc.OOPK.currentBatch.dimIndexVectorD[1].getPointer(), qc.OOPK.currentBatch.resultSize, qc.OOPK.currentBatch.resultSize+qc.OOPK.currentBatch.size, stream, qc.Device)
-		} else {
+		} else if qc.rowColumns == nil {
 			initIndexVector(qc.OOPK.currentBatch.dimIndexVectorD[0].getPointer(), 0, qc.OOPK.currentBatch.resultSize+qc.OOPK.currentBatch.size, stream, qc.Device)
 		}
 
-		if qc.OOPK.IsHLL() {
+		if qc.rowColumns != nil {
+			// rows of row queries are collected as is instead of being aggregated.
+			qc.doProfile(func() {
+				qc.collectRows(stream)
+				qc.reportTimingForCurrentBatch(stream, &start, rowCollectTiming)
+			}, "rows", stream)
+		} else if qc.OOPK.IsHLL() {
 			qc.doProfile(func() {
 				qc.OOPK.hllVectorD, qc.OOPK.hllDimRegIDCountD, qc.OOPK.hllVectorSize =
 					qc.OOPK.currentBatch.hll(qc.OOPK.NumDimsPerDimWidth, isLastBatch, stream, qc.Device)
@@ -1112,7 +1205,7 @@ func (qc *AQLQueryContext) calculateMemoryRequirement(memStore memstore.MemStore
 		}
 
 		// estimate live batch memory usage
-		if int(cutoff) < qc.TableScanners[0].ArchiveBatchIDEnd*86400 {
+		if int(cutoff) < qc.TableScanners[0].ArchiveBatchIDEnd*86400 && qc.scansLiveStore() {
 			batchIDs, _ := shard.LiveStore.GetBatchIDs()
 
 			// find first non null batch and estimate.
@@ -1133,7 +1226,7 @@ func (qc *AQLQueryContext) calculateMemoryRequirement(memStore memstore.MemStore
 		// estimate archive batch memory usage
 		if archiveStore != nil {
 			scanner := qc.TableScanners[0]
-			for batchID := scanner.ArchiveBatchIDStart; batchID < scanner.ArchiveBatchIDEnd; batchID++ {
+			for batchID := scanner.ArchiveBatchIDStart; batchID < scanner.ArchiveBatchIDEnd && qc.scansArchiveStore(); batchID++ {
 				archiveBatch := archiveStore.RequestBatch(int32(batchID))
 				if archiveBatch == nil || archiveBatch.Size == 0 {
 					continue
@@ -1372,6 +1465,23 @@ func (qc *AQLQueryContext) calculateForeignTableMemUsage(memStore memstore.MemSt
 	return memUsage
 }
 
+// getSubQueryContexts returns the contexts of all sub queries executed after the query, including
+// the sub queries of the sub queries.
+func (qc *AQLQueryContext) getSubQueryContexts() []*AQLQueryContext {
+	var directSubQCs, subQCs []*AQLQueryContext
+	if qc.union != nil {
+		directSubQCs = append(directSubQCs, qc.union.tableContexts...)
+	}
+	if qc.arithmeticMeasure != nil {
+		directSubQCs = append(directSubQCs, qc.arithmeticMeasure.subQueryContexts...)
+	}
+	for _, subQC := range directSubQCs {
+		subQCs = append(subQCs, subQC)
+		subQCs = append(subQCs, subQC.getSubQueryContexts()...)
+	}
+	return subQCs
+}
+
 // FindDeviceForQuery calls device manager to find a device for the query
 func (qc *AQLQueryContext) FindDeviceForQuery(memStore memstore.MemStore, preferredDevice int,
 	deviceManager *DeviceManager, timeout int) {
@@ -1380,8 +1490,31 @@ func (qc *AQLQueryContext) FindDeviceForQuery(memStore memstore.MemStore, prefer
 		return
 	}
 
+	// sub queries of arithmetic measure and queries of union tables run on the same device
+	// after this query finishes.
+	for _, subQC := range qc.getSubQueryContexts() {
+		subMemoryRequired := subQC.calculateMemoryRequirement(memStore)
+		if subQC.Error != nil {
+			qc.Error = subQC.Error
+			return
+		}
+		if subMemoryRequired > memoryRequired {
+			memoryRequired = subMemoryRequired
+		}
+	}
+
 	qc.OOPK.DeviceMemoryRequirement = memoryRequired
 
+	// reject the query instead of running the device out of memory.
+	if deviceManager.MaxQueryMemory > 0 && memoryRequired > deviceManager.MaxQueryMemory {
+		qc.Error = utils.StackError(ErrDeviceMemoryLimitExceeded,
+			"Query requires %d bytes of device memory, exceeding the limit of %d bytes",
+			memoryRequired, deviceManager.MaxQueryMemory)
+		qc.limitExceeded = true
+		qc.Device = -1
+		return
+	}
+
 	waitStart := utils.Now()
 	device := deviceManager.FindDevice(qc.Query, memoryRequired, preferredDevice, timeout)
 	if device == -1 {
@@ -1417,6 +1550,19 @@ func copyHostToDevice(vps memCom.HostVectorPartySlice, deviceVPSlice deviceVecto
 	return
 }
 
+// makeDefaultColumn returns the column of a live batch that has no vector party for the column, which
+// happens when none of the upsert batches applied to the live batch contains the column. All values of
+// such column are the default value of the column.
+func makeDefaultColumn(schema *memstore.TableSchema, columnID, size int) deviceVectorPartySlice {
+	schema.RLock()
+	defer schema.RUnlock()
+	return deviceVectorPartySlice{
+		length:       size,
+		valueType:    schema.ValueTypeByColumn[columnID],
+		defaultValue: *schema.DefaultValues[columnID],
+	}
+}
+
 func hostToDeviceColumn(hostColumn memCom.HostVectorPartySlice, device int) deviceVectorPartySlice {
 	deviceColumn := deviceVectorPartySlice{
 		length:          hostColumn.Length,
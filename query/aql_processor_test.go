@@ -17,6 +17,7 @@ package query
 import (
 	"unsafe"
 
+	"context"
 	"encoding/binary"
 	"encoding/json"
 	"math"
@@ -127,7 +128,7 @@ var _ = ginkgo.Describe("aql_processor", func() {
 			},
 			ValueTypeByColumn: []memCom.DataType{memCom.Uint32, memCom.Bool, memCom.Float32},
 			DefaultValues:     []*memCom.DataValue{&memCom.NullDataValue, &memCom.NullDataValue, &memCom.NullDataValue},
-		}, metaStore, diskStore, hostMemoryManager, shardID)
+		}, metaStore, diskStore, hostMemoryManager, shardID, memstore.Options{})
 
 		shardMap := map[int]*memstore.TableShard{
 			shardID: shard,
@@ -317,6 +318,47 @@ var _ = ginkgo.Describe("aql_processor", func() {
 		Ω(upperBound).Should(Equal(5))
 	})
 
+	ginkgo.It("ProcessQuery should abort cancelled or timed out queries", func() {
+		cancelledCtx, cancel := context.WithCancel(context.Background())
+		cancel()
+		timedOutCtx, cancel := context.WithTimeout(context.Background(), 0)
+		defer cancel()
+
+		for _, ctx := range []context.Context{cancelledCtx, timedOutCtx} {
+			q := &AQLQuery{
+				Table: table,
+				Dimensions: []Dimension{
+					{Expr: "c0", TimeBucketizer: "m", TimeUnit: "millisecond"},
+				},
+				Measures: []Measure{
+					{Expr: "count(c1)"},
+				},
+				TimeFilter: TimeFilter{
+					Column: "c0",
+					From:   "1970-01-01",
+					To:     "1970-01-02",
+				},
+			}
+			qc := q.Compile(memStore, false)
+			Ω(qc.Error).Should(BeNil())
+			qc.Context = ctx
+			qc.ProcessQuery(memStore)
+			Ω(qc.Error).ShouldNot(BeNil())
+			Ω(qc.Error.Error()).Should(ContainSubstring("query is cancelled"))
+			Ω(qc.Error.Error()).Should(ContainSubstring(ctx.Err().Error()))
+
+			// Check whether device memory is released.
+			bc := qc.OOPK.currentBatch
+			Ω(qc.cudaStreams[0]).Should(BeZero())
+			Ω(qc.cudaStreams[1]).Should(BeZero())
+			Ω(len(bc.columns)).Should(BeZero())
+			Ω(bc.indexVectorD).Should(BeZero())
+			Ω(bc.dimensionVectorD[0]).Should(BeZero())
+			Ω(bc.measureVectorD[0]).Should(BeZero())
+			Ω(qc.OOPK.ResultSize).Should(BeZero())
+		}
+	})
+
 	ginkgo.It("makeForeignColumnVectorInput", func() {
 		values := [64]byte{}
 		nulls := [64]byte{}
@@ -378,6 +420,23 @@ var _ = ginkgo.Describe("aql_processor", func() {
 		Ω(inputVector.Type).Should(Equal(uint32(0)))
 	})
 
+	ginkgo.It("makeDefaultColumn", func() {
+		defaultValue, _ := memCom.ValueFromString("-1", memCom.Int32)
+		schema := &memstore.TableSchema{
+			ValueTypeByColumn: []memCom.DataType{memCom.Uint16, memCom.Int32},
+			DefaultValues:     []*memCom.DataValue{&memCom.NullDataValue, &defaultValue},
+		}
+		column := makeDefaultColumn(schema, 1, 10)
+		Ω(column.length).Should(Equal(10))
+		Ω(column.valueType).Should(Equal(memCom.Int32))
+		Ω(column.defaultValue).Should(Equal(defaultValue))
+		Ω(column.basePtr.isNull()).Should(BeTrue())
+
+		column = makeDefaultColumn(schema, 0, 5)
+		Ω(column.length).Should(Equal(5))
+		Ω(column.defaultValue.Valid).Should(BeFalse())
+	})
+
 	ginkgo.It("makeConstantInput", func() {
 		inputVector := makeConstantInput(float64(1.0), false)
 		// uint32(2) corresponds to ConstantInput in C.enum_InputVectorType
@@ -939,6 +998,79 @@ var _ = ginkgo.Describe("aql_processor", func() {
 		utils.ResetDefaults()
 	})
 
+	ginkgo.It("prepareForeignTable should reject join tables exceeding size limit", func() {
+		mockMemoryManager := new(memComMocks.HostMemoryManager)
+		mockMemoryManager.On("ReportUnmanagedSpaceUsageChange", mock.Anything).Return()
+		citiesSchema := &memstore.TableSchema{
+			Schema: metaCom.Table{
+				Name: "api_cities",
+				Config: metaCom.TableConfig{
+					InitialPrimaryKeyNumBuckets: 1,
+				},
+			},
+			PrimaryKeyColumnTypes: []memCom.DataType{memCom.Uint32},
+			PrimaryKeyBytes:       4,
+		}
+		citiesTableShard := &memstore.TableShard{
+			Schema:            citiesSchema,
+			HostMemoryManager: mockMemoryManager,
+		}
+		citiesTableShard.LiveStore = memstore.NewLiveStore(10, citiesTableShard)
+		for i := 0; i < 3; i++ {
+			key := []byte{byte(i), 0, 0, 0}
+			citiesTableShard.LiveStore.PrimaryKey.FindOrInsert(key,
+				memstore.RecordID{BatchID: memstore.BaseBatchID, Index: uint32(i)}, 0)
+		}
+
+		memStore := new(memMocks.MemStore)
+		memStore.On("GetTableShard", "api_cities", 0).Run(func(args mock.Arguments) {
+			citiesTableShard.Users.Add(1)
+		}).Return(citiesTableShard, nil)
+
+		qc := &AQLQueryContext{Limits: QueryLimits{MaxJoinTableRecords: 2}}
+		qc.OOPK.foreignTables = []*foreignTable{{}}
+		qc.prepareForeignTable(memStore, 0, Join{Table: "api_cities"})
+		Ω(qc.Error).ShouldNot(BeNil())
+		Ω(qc.Error.Error()).Should(ContainSubstring("Join table api_cities has 3 records, exceeding the limit of 2"))
+	})
+
+	ginkgo.It("ProcessQuery should abort queries exceeding the limit of rows scanned", func() {
+		q := &AQLQuery{
+			Table: table,
+			Dimensions: []Dimension{
+				{Expr: "c0", TimeBucketizer: "m", TimeUnit: "millisecond"},
+			},
+			Measures: []Measure{
+				{Expr: "count(c1)"},
+			},
+			TimeFilter: TimeFilter{
+				Column: "c0",
+				From:   "1970-01-01",
+				To:     "1970-01-02",
+			},
+		}
+		qc := q.Compile(memStore, false)
+		Ω(qc.Error).Should(BeNil())
+		// each batch has 5 rows, the query is aborted before scanning the second batch.
+		qc.Limits = QueryLimits{MaxRowsScanned: 6}
+		qc.Profile = NewQueryProfile()
+		qc.ProcessQuery(memStore)
+		Ω(qc.LimitExceeded()).Should(BeTrue())
+		Ω(qc.Error).ShouldNot(BeNil())
+		Ω(qc.Error.Error()).Should(ContainSubstring(ErrRowsScannedLimitExceeded.Error()))
+		Ω(qc.Profile.RowsScanned).Should(Equal(QueryLimitUsage{Used: 5, Limit: 6}))
+
+		// Check whether device memory is released.
+		bc := qc.OOPK.currentBatch
+		Ω(qc.cudaStreams[0]).Should(BeZero())
+		Ω(qc.cudaStreams[1]).Should(BeZero())
+		Ω(len(bc.columns)).Should(BeZero())
+		Ω(bc.indexVectorD).Should(BeZero())
+		Ω(bc.dimensionVectorD[0]).Should(BeZero())
+		Ω(bc.measureVectorD[0]).Should(BeZero())
+		Ω(qc.OOPK.ResultSize).Should(BeZero())
+	})
+
 	ginkgo.It("ProcessQuery should work", func() {
 		qc := &AQLQueryContext{}
 		q := &AQLQuery{
@@ -1009,6 +1141,179 @@ var _ = ginkgo.Describe("aql_processor", func() {
 		Ω(qc.OOPK.hllDimRegIDCountD).Should(BeZero())
 	})
 
+	ginkgo.It("ProcessQuery should read data of renamed columns", func() {
+		renamed := shard.Schema.Schema
+		renamed.Columns = append([]metaCom.Column{}, renamed.Columns...)
+		renamed.Columns[1].Name = "completed"
+		shard.Schema.SetTable(&renamed)
+
+		q := &AQLQuery{
+			Table: table,
+			Dimensions: []Dimension{
+				{Expr: "c0", TimeBucketizer: "m", TimeUnit: "millisecond"},
+			},
+			Measures: []Measure{
+				{Expr: "count(completed)"},
+			},
+			TimeFilter: TimeFilter{
+				Column: "c0",
+				From:   "1970-01-01",
+				To:     "1970-01-02",
+			},
+		}
+		qc := q.Compile(memStore, false)
+		Ω(qc.Error).Should(BeNil())
+		qc.ProcessQuery(memStore)
+		Ω(qc.Error).Should(BeNil())
+		qc.Results = qc.Postprocess()
+		qc.ReleaseHostResultsBuffers()
+		bs, err := json.Marshal(qc.Results)
+		Ω(err).Should(BeNil())
+		Ω(bs).Should(MatchJSON(` {
+			"0": 5,
+			"60000": 4,
+			"120000": 3
+		  }`))
+
+		q.Measures = []Measure{{Expr: "count(c1)"}}
+		qc = q.Compile(memStore, false)
+		Ω(qc.Error).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("ProcessQuery should record the profile", func() {
+		q := &AQLQuery{
+			Table: table,
+			Dimensions: []Dimension{
+				{Expr: "c0", TimeBucketizer: "m", TimeUnit: "millisecond"},
+			},
+			Measures: []Measure{
+				{Expr: "count(c1)"},
+			},
+			TimeFilter: TimeFilter{
+				Column: "c0",
+				From:   "1970-01-01",
+				To:     "1970-01-02",
+			},
+		}
+		qc := q.Compile(memStore, false)
+		Ω(qc.Error).Should(BeNil())
+		qc.Profile = NewQueryProfile()
+		qc.ProcessQuery(memStore)
+		Ω(qc.Error).Should(BeNil())
+		qc.Results = qc.Postprocess()
+		qc.ReleaseHostResultsBuffers()
+
+		for _, stage := range []string{ProfileStageScan, ProfileStageFilter, ProfileStageAggregate, ProfileStageSort} {
+			Ω(qc.Profile.Stages).Should(HaveKey(stage))
+			Ω(qc.Profile.Stages[stage]).Should(BeNumerically(">=", 0))
+		}
+		Ω(qc.Profile.Stages).Should(HaveLen(4))
+	})
+
+	ginkgo.It("ProcessQuery should group nulls of dimensions from live and archive batches together", func() {
+		q := &AQLQuery{
+			Table: table,
+			Dimensions: []Dimension{
+				{Expr: "c1"},
+			},
+			Measures: []Measure{
+				{Expr: "count(*)"},
+			},
+			TimeFilter: TimeFilter{
+				Column: "c0",
+				From:   "1970-01-01",
+				To:     "1970-01-02",
+			},
+		}
+		qc := q.Compile(memStore, false)
+		Ω(qc.Error).Should(BeNil())
+		qc.ProcessQuery(memStore)
+		Ω(qc.Error).Should(BeNil())
+		qc.Results = qc.Postprocess()
+		qc.ReleaseHostResultsBuffers()
+		bs, err := json.Marshal(qc.Results)
+		Ω(err).Should(BeNil())
+		// 3 nulls from the archive batch and 1 null from live batch -110.
+		Ω(bs).Should(MatchJSON(` {
+			"NULL": 4,
+			"0": 5,
+			"1": 3
+		  }`))
+	})
+
+	ginkgo.It("ProcessQuery should merge results of union tables as one table", func() {
+		q := &AQLQuery{
+			Table: table,
+			Dimensions: []Dimension{
+				{Expr: "c0", TimeBucketizer: "m", TimeUnit: "millisecond"},
+			},
+			Measures: []Measure{
+				{Expr: "count(c1)"},
+			},
+			TimeFilter: TimeFilter{
+				Column: "c0",
+				From:   "1970-01-01",
+				To:     "1970-01-02",
+			},
+		}
+		qc := q.Compile(memStore, false)
+		Ω(qc.Error).Should(BeNil())
+		qc.ProcessQuery(memStore)
+		Ω(qc.Error).Should(BeNil())
+		qc.Results = qc.Postprocess()
+		qc.ReleaseHostResultsBuffers()
+		expected, err := json.Marshal(qc.Results)
+		Ω(err).Should(BeNil())
+
+		// move the live batches of table1 to table2 with the same schema.
+		table2 := "table2"
+		metaStore.(*metaMocks.MetaStore).On("GetArchiveBatchVersion", table2, 0, mock.Anything, mock.Anything).Return(uint32(0), uint32(0), 0, nil)
+		diskStore.(*diskMocks.DiskStore).On(
+			"OpenVectorPartyFileForRead", table2, mock.Anything, shardID, mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+		schema2 := shard.Schema.Schema
+		schema2.Name = table2
+		shard2 := memstore.NewTableShard(memstore.NewTableSchema(&schema2), metaStore, diskStore, hostMemoryManager, shardID, memstore.Options{})
+		shard2.ArchiveStore = &memstore.ArchiveStore{CurrentVersion: memstore.NewArchiveStoreVersion(100, shard2)}
+		shard2.LiveStore = shard.LiveStore
+		shard.LiveStore = &memstore.LiveStore{
+			LastReadRecord:    memstore.RecordID{BatchID: -101, Index: 3},
+			Batches:           map[int32]*memstore.LiveBatch{},
+			PrimaryKey:        memstore.NewPrimaryKey(16, true, 0, hostMemoryManager),
+			HostMemoryManager: hostMemoryManager,
+		}
+
+		unionMemStore := new(memMocks.MemStore)
+		unionMemStore.On("RLock").Return()
+		unionMemStore.On("RUnlock").Return()
+		unionMemStore.On("GetSchemas").Return(map[string]*memstore.TableSchema{
+			table:  shard.Schema,
+			table2: shard2.Schema,
+		})
+		unionMemStore.On("GetTableShard", table, 0).Run(func(args mock.Arguments) {
+			shard.Users.Add(1)
+		}).Return(shard, nil)
+		unionMemStore.On("GetTableShard", table2, 0).Run(func(args mock.Arguments) {
+			shard2.Users.Add(1)
+		}).Return(shard2, nil)
+
+		q.Table = ""
+		q.Tables = []string{table, table2}
+		qc = q.Compile(unionMemStore, false)
+		Ω(qc.Error).Should(BeNil())
+		qc.ProcessQuery(unionMemStore)
+		Ω(qc.Error).Should(BeNil())
+		qc.Results = qc.Postprocess()
+		qc.ReleaseHostResultsBuffers()
+		bs, err := json.Marshal(qc.Results)
+		Ω(err).Should(BeNil())
+		Ω(bs).Should(MatchJSON(expected))
+		Ω(bs).Should(MatchJSON(` {
+			"0": 5,
+			"60000": 4,
+			"120000": 3
+		  }`))
+	})
+
 	ginkgo.It("ProcessQuery should work for timezone column queries", func() {
 		timezoneTable := "table2"
 		memStore := new(memMocks.MemStore)
@@ -1083,7 +1388,7 @@ var _ = ginkgo.Describe("aql_processor", func() {
 			Schema:            timezoneTableSchema,
 			ValueTypeByColumn: []memCom.DataType{memCom.Uint32, memCom.SmallEnum},
 			DefaultValues:     []*memCom.DataValue{&memCom.NullDataValue, &memCom.NullDataValue},
-		}, metaStore, diskStore, hostMemoryManager, shardID)
+		}, metaStore, diskStore, hostMemoryManager, shardID, memstore.Options{})
 		timezoneTableBatch := memstore.LiveBatch{
 			Batch: memstore.Batch{
 				RWMutex: &sync.RWMutex{},
@@ -1112,7 +1417,7 @@ var _ = ginkgo.Describe("aql_processor", func() {
 			Schema:            mainTableSchema,
 			ValueTypeByColumn: []memCom.DataType{memCom.Uint32, memCom.Uint32},
 			DefaultValues:     []*memCom.DataValue{&memCom.NullDataValue, &memCom.NullDataValue},
-		}, metaStore, diskStore, hostMemoryManager, shardID)
+		}, metaStore, diskStore, hostMemoryManager, shardID, memstore.Options{})
 		mainTableShard.LiveStore = &memstore.LiveStore{
 			LastReadRecord: memstore.RecordID{BatchID: -90, Index: 0},
 			Batches: map[int32]*memstore.LiveBatch{
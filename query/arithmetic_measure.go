@@ -0,0 +1,366 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/uber/aresdb/memstore"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/query/expr"
+	"github.com/uber/aresdb/utils"
+)
+
+// arithmeticMeasure is a measure combining the per group results of multiple aggregates with
+// arithmetic operators, e.g. sum(clicks)/sum(impressions). Since the OOPK engine computes one
+// aggregate per query, each aggregate is computed by a sub query sharing all other parts of the
+// query, and the measure expression is evaluated on the aggregated results in Postprocess.
+//
+// Queries with more than one measure are computed the same way, each measure is evaluated on
+// the aggregates it references, e.g. sum(fare) FILTER (WHERE country = 'US') and
+// sum(fare) FILTER (WHERE country = 'UK'). The measures are returned as the innermost layer of
+// the result keyed by their indexes in the query.
+type arithmeticMeasure struct {
+	// names the measures in errors.
+	name  string
+	exprs []expr.Expr
+	// aggregate calls referenced by the measure expressions, aggregates[0] is computed by the
+	// query context owning this measure and aggregates[i] by subQueryContexts[i-1].
+	aggregates       []string
+	aggregateIndex   map[string]int
+	subQueryContexts []*AQLQueryContext
+}
+
+// parseArithmeticMeasure returns the arithmetic measure of the query, or nil if the query does
+// not have exactly one measure combining aggregates with arithmetic operators nor more than
+// one measure.
+func parseArithmeticMeasure(q *AQLQuery) (*arithmeticMeasure, error) {
+	if len(q.Measures) == 0 {
+		return nil, nil
+	}
+
+	measure := &arithmeticMeasure{
+		name:           q.Measures[0].Expr,
+		aggregateIndex: make(map[string]int),
+	}
+	if len(q.Measures) > 1 {
+		names := make([]string, len(q.Measures))
+		for i, m := range q.Measures {
+			names[i] = m.Expr
+		}
+		measure.name = strings.Join(names, ", ")
+	}
+
+	for _, m := range q.Measures {
+		measureExpr, err := expr.ParseExpr(m.Expr)
+		if err != nil {
+			if len(q.Measures) == 1 {
+				// reported when compiling the query.
+				return nil, nil
+			}
+			return nil, utils.StackError(err, "Failed to parse measure: %s", m.Expr)
+		}
+		if measureExpr, err = expandWeightedAvg(measureExpr); err != nil {
+			return nil, utils.StackError(err, "Invalid measure: %s", m.Expr)
+		}
+
+		// row filters of a single measure apply to all sub queries, while row filters of one of
+		// multiple measures only apply to its own aggregates.
+		var filter expr.Expr
+		if len(q.Measures) == 1 {
+			switch measureExpr.(type) {
+			case *expr.BinaryExpr, *expr.UnaryExpr, *expr.ParenExpr:
+			default:
+				return nil, nil
+			}
+		} else if len(m.Filters) > 0 {
+			filterStr := m.Filters[0]
+			if len(m.Filters) > 1 {
+				filterStr = "(" + strings.Join(m.Filters, ") AND (") + ")"
+			}
+			if filter, err = expr.ParseExpr(filterStr); err != nil {
+				return nil, utils.StackError(err, "Failed to parse row filters of measure: %s", m.Expr)
+			}
+		}
+
+		if err = measure.collectAggregates(measureExpr, filter); err != nil {
+			return nil, utils.StackError(err, "Invalid measure: %s", m.Expr)
+		}
+		measure.exprs = append(measure.exprs, measureExpr)
+	}
+	if len(measure.aggregates) == 0 {
+		return nil, nil
+	}
+	return measure, nil
+}
+
+// collectAggregates validates the measure expression and collects the aggregate calls in it.
+// The filter if not nil is added to the filters of the aggregates.
+func (m *arithmeticMeasure) collectAggregates(e expr.Expr, filter expr.Expr) error {
+	switch e := e.(type) {
+	case *expr.ParenExpr:
+		return m.collectAggregates(e.Expr, filter)
+	case *expr.NumberLiteral:
+		return nil
+	case *expr.Call:
+		if filter != nil {
+			if e.Filter == nil {
+				e.Filter = expr.CloneExpr(filter)
+			} else {
+				e.Filter = &expr.BinaryExpr{
+					Op:  expr.AND,
+					LHS: &expr.ParenExpr{Expr: expr.CloneExpr(filter)},
+					RHS: &expr.ParenExpr{Expr: e.Filter},
+				}
+			}
+		}
+		if _, ok := m.aggregateIndex[e.String()]; !ok {
+			m.aggregateIndex[e.String()] = len(m.aggregates)
+			m.aggregates = append(m.aggregates, e.String())
+		}
+		return nil
+	case *expr.UnaryExpr:
+		if e.Op == expr.UNARY_MINUS {
+			return m.collectAggregates(e.Expr, filter)
+		}
+	case *expr.BinaryExpr:
+		switch e.Op {
+		case expr.ADD, expr.SUB, expr.MUL, expr.DIV:
+			if err := m.collectAggregates(e.LHS, filter); err != nil {
+				return err
+			}
+			return m.collectAggregates(e.RHS, filter)
+		}
+	}
+	return utils.StackError(nil, "measure can only combine aggregates and numbers with +, -, * and /, but got %s", e.String())
+}
+
+// expandWeightedAvg rewrites weightedAvg(value, weight) in the measure expression into
+// sum(value * weight) / sum(weight + value * 0), so that both sums are aggregated across batches
+// like any other sum. Rows with null value or weight are excluded from both sums since nulls
+// propagate through the arithmetic, and a zero total weight yields null.
+func expandWeightedAvg(e expr.Expr) (expr.Expr, error) {
+	var err error
+	switch e := e.(type) {
+	case *expr.ParenExpr:
+		e.Expr, err = expandWeightedAvg(e.Expr)
+	case *expr.UnaryExpr:
+		e.Expr, err = expandWeightedAvg(e.Expr)
+	case *expr.BinaryExpr:
+		if e.LHS, err = expandWeightedAvg(e.LHS); err == nil {
+			e.RHS, err = expandWeightedAvg(e.RHS)
+		}
+	case *expr.Call:
+		if strings.ToLower(e.Name) != weightedAvgCallName {
+			return e, nil
+		}
+		if len(e.Args) != 2 {
+			return nil, utils.StackError(nil, "expect 2 arguments for %s, but got %s", e.Name, e.String())
+		}
+		value, weight := e.Args[0].String(), e.Args[1].String()
+		return expr.ParseExpr(fmt.Sprintf("(sum((%s) * (%s)) / sum((%s) + (%s) * 0))", value, weight, weight, value))
+	}
+	return e, err
+}
+
+// subQuery returns a copy of the query computing the aggregate with the measure row filters.
+// The aggregate of multiple measures already carries the row filters of its measure.
+func (q *AQLQuery) subQuery(aggregate string) *AQLQuery {
+	subQuery := *q
+	subQuery.Joins = append([]Join(nil), q.Joins...)
+	subQuery.Dimensions = append([]Dimension(nil), q.Dimensions...)
+	subQuery.Filters = append([]string(nil), q.Filters...)
+	// parsed filters are rewritten in place during compilation, so each sub query parses
+	// its own copy. Filters of prepared queries are bound to their string form.
+	subQuery.filters = nil
+	subQuery.filtersParsed = false
+	subQuery.Measures = []Measure{{Expr: aggregate}}
+	if len(q.Measures) == 1 {
+		subQuery.Measures[0].Filters = append([]string(nil), q.Measures[0].Filters...)
+	}
+	// the limit, fill and running aggregates apply to the combined result.
+	subQuery.Limit, subQuery.Sorts = 0, nil
+	clearFill(subQuery.Dimensions)
+	return &subQuery
+}
+
+// compileArithmeticMeasure compiles the sub query of each aggregate in the measure. The context
+// of the first sub query is returned with the measure attached.
+func (q *AQLQuery) compileArithmeticMeasure(store memstore.MemStore, returnHLL bool, measure *arithmeticMeasure) *AQLQueryContext {
+	if returnHLL {
+		return &AQLQueryContext{Query: q, ReturnHLLData: returnHLL, Error: utils.StackError(nil,
+			"arithmetic measure %s is not supported when client specify 'Accept' as 'application/hll'", measure.name)}
+	}
+	if q.Having != "" {
+		return &AQLQueryContext{Query: q, Error: utils.StackError(nil,
+			"having is not supported with arithmetic measure %s", measure.name)}
+	}
+	if len(measure.exprs) > 1 && q.Limit != 0 {
+		return &AQLQueryContext{Query: q, Error: utils.StackError(nil,
+			"limit is not supported with multiple measures %s", measure.name)}
+	}
+
+	var qc *AQLQueryContext
+	for i, aggregate := range measure.aggregates {
+		subQC := q.subQuery(aggregate).Compile(store, false)
+		if i == 0 {
+			qc = subQC
+		} else {
+			measure.subQueryContexts = append(measure.subQueryContexts, subQC)
+		}
+		if subQC.Error != nil {
+			qc.Error = utils.StackError(subQC.Error, "Failed to compile %s of measure %s", aggregate, measure.name)
+			return qc
+		}
+	}
+	var err error
+	if qc.topN, err = compileTopN(q, false); err != nil {
+		qc.Error = utils.StackError(err, "Invalid limit")
+		return qc
+	}
+	if qc.gapFill, err = compileGapFill(q, qc); err != nil {
+		qc.Error = utils.StackError(err, "Invalid fill")
+		return qc
+	}
+	if qc.running, err = compileRunningAggregate(q, false); err != nil {
+		qc.Error = utils.StackError(err, "Invalid running")
+		return qc
+	}
+	qc.arithmeticMeasure = measure
+	return qc
+}
+
+// processSubQueries executes the sub queries on the device of the query context one after another.
+func (m *arithmeticMeasure) processSubQueries(qc *AQLQueryContext, memStore memstore.MemStore) {
+	for i, subQC := range m.subQueryContexts {
+		qc.processSubQuery(subQC, memStore)
+		if subQC.Error != nil {
+			qc.Error = utils.StackError(subQC.Error, "Failed to process %s of measure", m.aggregates[i+1])
+			return
+		}
+	}
+}
+
+// postprocess evaluates the measure expression on the result of the first aggregate and the
+// results of the sub queries.
+func (m *arithmeticMeasure) postprocess(qc *AQLQueryContext, result queryCom.AQLTimeSeriesResult) queryCom.AQLTimeSeriesResult {
+	results := []map[string]interface{}{result}
+	for i, subQC := range m.subQueryContexts {
+		subResult := subQC.Postprocess()
+		if subQC.Error != nil {
+			qc.Error = utils.StackError(subQC.Error, "Failed to postprocess %s of measure", m.aggregates[i+1])
+			return nil
+		}
+		results = append(results, subResult)
+	}
+	return m.combine(results)
+}
+
+// combine evaluates the measure expression for each group of the first aggregate result.
+// The measure is null if any aggregate of the group is null or missing. Multiple measures are
+// evaluated for the groups of any aggregate result, since each measure can have its own filters.
+func (m *arithmeticMeasure) combine(results []map[string]interface{}) map[string]interface{} {
+	combined := make(map[string]interface{}, len(results[0]))
+	groups := results[:1]
+	if len(m.exprs) > 1 {
+		groups = results
+	}
+	for _, group := range groups {
+		for key, value := range group {
+			if _, combinedBefore := combined[key]; combinedBefore {
+				continue
+			}
+			if _, ok := value.(map[string]interface{}); ok {
+				children := make([]map[string]interface{}, len(results))
+				for i, result := range results {
+					children[i], _ = result[key].(map[string]interface{})
+				}
+				combined[key] = m.combine(children)
+				continue
+			}
+
+			values := make([]*float64, len(results))
+			for i, result := range results {
+				if v, ok := result[key].(float64); ok {
+					values[i] = &v
+				}
+			}
+			combined[key] = m.evalMeasures(values)
+		}
+	}
+	return combined
+}
+
+// evalMeasures returns the value of the measure, or the values of the measures keyed by their
+// indexes for multiple measures.
+func (m *arithmeticMeasure) evalMeasures(values []*float64) interface{} {
+	if len(m.exprs) == 1 {
+		if measureValue := m.eval(m.exprs[0], values); measureValue != nil {
+			return *measureValue
+		}
+		return nil
+	}
+
+	measures := make(map[string]interface{}, len(m.exprs))
+	for i, e := range m.exprs {
+		if measureValue := m.eval(e, values); measureValue != nil {
+			measures[strconv.Itoa(i)] = *measureValue
+		} else {
+			measures[strconv.Itoa(i)] = nil
+		}
+	}
+	return measures
+}
+
+// eval evaluates the validated measure expression against the aggregate values of a group.
+// Dividing by zero yields null.
+func (m *arithmeticMeasure) eval(e expr.Expr, values []*float64) *float64 {
+	var result float64
+	switch e := e.(type) {
+	case *expr.ParenExpr:
+		return m.eval(e.Expr, values)
+	case *expr.NumberLiteral:
+		result = e.Val
+	case *expr.Call:
+		return values[m.aggregateIndex[e.String()]]
+	case *expr.UnaryExpr:
+		value := m.eval(e.Expr, values)
+		if value == nil {
+			return nil
+		}
+		result = -*value
+	case *expr.BinaryExpr:
+		lhs, rhs := m.eval(e.LHS, values), m.eval(e.RHS, values)
+		if lhs == nil || rhs == nil {
+			return nil
+		}
+		switch e.Op {
+		case expr.ADD:
+			result = *lhs + *rhs
+		case expr.SUB:
+			result = *lhs - *rhs
+		case expr.MUL:
+			result = *lhs * *rhs
+		case expr.DIV:
+			if *rhs == 0 {
+				return nil
+			}
+			result = *lhs / *rhs
+		}
+	}
+	return &result
+}
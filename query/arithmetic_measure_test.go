@@ -0,0 +1,289 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/memstore"
+	memCom "github.com/uber/aresdb/memstore/common"
+	"github.com/uber/aresdb/memstore/mocks"
+	metaCom "github.com/uber/aresdb/metastore/common"
+)
+
+var _ = ginkgo.Describe("arithmetic measure", func() {
+	var store *mocks.MemStore
+
+	ginkgo.BeforeEach(func() {
+		store = new(mocks.MemStore)
+		store.On("RLock").Return()
+		store.On("RUnlock").Return()
+		store.On("GetSchemas").Return(map[string]*memstore.TableSchema{
+			"ads": {
+				Schema: metaCom.Table{
+					Name: "ads",
+					Columns: []metaCom.Column{
+						{Name: "id", Type: metaCom.Uint32},
+						{Name: "clicks", Type: metaCom.Uint32},
+						{Name: "impressions", Type: metaCom.Uint32},
+					},
+				},
+				ColumnIDs:         map[string]int{"id": 0, "clicks": 1, "impressions": 2},
+				ValueTypeByColumn: []memCom.DataType{memCom.Uint32, memCom.Uint32, memCom.Uint32},
+			},
+		})
+	})
+
+	compileMeasure := func(measure Measure) *AQLQueryContext {
+		q := &AQLQuery{
+			Table:      "ads",
+			Dimensions: []Dimension{{Expr: "id"}},
+			Measures:   []Measure{measure},
+			Filters:    []string{"id > 1"},
+		}
+		return q.Compile(store, false)
+	}
+
+	ginkgo.It("compiles a sub query per aggregate", func() {
+		qc := compileMeasure(Measure{
+			Expr:    "sum(clicks)/sum(impressions) + sum(clicks)",
+			Filters: []string{"impressions > 10"},
+		})
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.arithmeticMeasure.aggregates).Should(Equal([]string{"sum(clicks)", "sum(impressions)"}))
+		Ω(qc.arithmeticMeasure.subQueryContexts).Should(HaveLen(1))
+
+		subQC := qc.arithmeticMeasure.subQueryContexts[0]
+		for aggregate, c := range map[string]*AQLQueryContext{"sum(clicks)": qc, "sum(impressions)": subQC} {
+			Ω(c.Error).Should(BeNil())
+			Ω(c.Query.Measures[0].Expr).Should(Equal(aggregate))
+			Ω(c.Query.Dimensions[0].Expr).Should(Equal("id"))
+			Ω(c.OOPK.MainTableCommonFilters).Should(HaveLen(2))
+			Ω(c.OOPK.MainTableCommonFilters[0].String()).Should(Equal("impressions > 10"))
+			Ω(c.OOPK.MainTableCommonFilters[1].String()).Should(Equal("id > 1"))
+		}
+		Ω(qc.OOPK.Measure.String()).Should(Equal("clicks"))
+		Ω(subQC.OOPK.Measure.String()).Should(Equal("impressions"))
+	})
+
+	ginkgo.It("applies limit to the combined measure", func() {
+		q := &AQLQuery{
+			Table:      "ads",
+			Dimensions: []Dimension{{Expr: "id"}},
+			Measures:   []Measure{{Expr: "sum(clicks)/sum(impressions)"}},
+			Limit:      10,
+			Sorts:      []SortField{{Expr: "sum(clicks)/sum(impressions)", Desc: true}},
+		}
+		qc := q.Compile(store, false)
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.topN.keys).Should(Equal([]int{measureSortKey}))
+		Ω(qc.arithmeticMeasure.subQueryContexts[0].topN).Should(BeNil())
+	})
+
+	ginkgo.It("rejects invalid arithmetic measures", func() {
+		tests := map[string]string{
+			"sum(clicks)/impressions":          "measure can only combine aggregates and numbers",
+			"sum(clicks) > 1":                  "measure can only combine aggregates and numbers",
+			"sum(clicks)/sum(missing)":         "Failed to compile sum(missing) of measure sum(clicks)/sum(missing)",
+			"sum(clicks)/clicks(impressions)":  "unknown function clicks",
+			"-sum(clicks) * (sum(impressions)": "Failed to parse measure",
+		}
+		for measure, expected := range tests {
+			qc := compileMeasure(Measure{Expr: measure})
+			Ω(qc.Error).ShouldNot(BeNil(), measure)
+			Ω(qc.Error.Error()).Should(ContainSubstring(expected), measure)
+		}
+
+		q := &AQLQuery{
+			Table:    "ads",
+			Measures: []Measure{{Expr: "sum(clicks)/sum(impressions)"}},
+			Having:   "sum(clicks)/sum(impressions) > 1",
+		}
+		Ω(q.Compile(store, false).Error.Error()).Should(ContainSubstring("having is not supported"))
+
+		q.Having = ""
+		Ω(q.Compile(store, true).Error.Error()).Should(ContainSubstring("application/hll"))
+	})
+
+	ginkgo.It("evaluates nested expressions per group", func() {
+		measure, err := parseArithmeticMeasure(&AQLQuery{
+			Measures: []Measure{{Expr: "(sum(a) - sum(b)) / (count(1) * 2) * -1"}},
+		})
+		Ω(err).Should(BeNil())
+		Ω(measure.aggregates).Should(Equal([]string{"sum(a)", "sum(b)", "count(1)"}))
+
+		sumA := map[string]interface{}{
+			"sf": map[string]interface{}{"20": 10.0, "21": 4.0},
+			"la": map[string]interface{}{"20": 9.0, "21": nil},
+		}
+		sumB := map[string]interface{}{
+			"sf": map[string]interface{}{"20": 2.0, "21": 8.0},
+			"la": map[string]interface{}{"20": 1.0, "21": 3.0},
+		}
+		count := map[string]interface{}{
+			"sf": map[string]interface{}{"20": 2.0, "21": 1.0},
+			"la": map[string]interface{}{"21": 1.0},
+		}
+		Ω(measure.combine([]map[string]interface{}{sumA, sumB, count})).Should(Equal(map[string]interface{}{
+			"sf": map[string]interface{}{"20": -2.0, "21": 2.0},
+			// null or missing aggregates yield null.
+			"la": map[string]interface{}{"20": nil, "21": nil},
+		}))
+	})
+
+	ginkgo.It("yields null when dividing by zero", func() {
+		measure, err := parseArithmeticMeasure(&AQLQuery{
+			Measures: []Measure{{Expr: "sum(clicks)/sum(impressions)"}},
+		})
+		Ω(err).Should(BeNil())
+		clicks := map[string]interface{}{"1": 5.0, "2": 0.0, "3": 3.0}
+		impressions := map[string]interface{}{"1": 10.0, "2": 0.0, "3": 0.0}
+		Ω(measure.combine([]map[string]interface{}{clicks, impressions})).Should(Equal(map[string]interface{}{
+			"1": 0.5, "2": nil, "3": nil,
+		}))
+
+		// plain aggregates are not arithmetic measures.
+		for _, expr := range []string{"sum(clicks)", "clicks", "1 + 2"} {
+			measure, err = parseArithmeticMeasure(&AQLQuery{Measures: []Measure{{Expr: expr}}})
+			Ω(err).Should(BeNil())
+			Ω(measure).Should(BeNil())
+		}
+	})
+
+	ginkgo.It("computes weighted average across batches", func() {
+		qc := compileMeasure(Measure{Expr: "weightedAvg(clicks, impressions) * 100"})
+		Ω(qc.Error).Should(BeNil())
+		measure := qc.arithmeticMeasure
+		Ω(measure.aggregates).Should(Equal([]string{
+			"sum((clicks) * (impressions))",
+			"sum((impressions) + (clicks) * 0)",
+		}))
+		Ω(qc.OOPK.Measure.String()).Should(Equal("clicks * impressions"))
+		// rows with null clicks are excluded from the total weight.
+		Ω(measure.subQueryContexts[0].OOPK.Measure.String()).Should(Equal("impressions + clicks * 0"))
+
+		// (value, weight) rows of each group in two batches, nil for null.
+		type row struct{ value, weight interface{} }
+		batches := []map[string][]row{
+			{"1": {{2.0, 1.0}, {4.0, 3.0}}, "2": {{5.0, 0.0}}, "3": {{1.0, 1.0}}},
+			{"1": {{10.0, 0.0}, {6.0, 2.0}, {nil, 5.0}}, "2": {{7.0, 0.0}}, "3": {{3.0, 1.0}, {9.0, nil}}},
+		}
+		// sums of each batch are added up the same way the engine aggregates them.
+		sumValueWeight, sumWeight := map[string]interface{}{}, map[string]interface{}{}
+		for _, batch := range batches {
+			for group, rows := range batch {
+				for _, r := range rows {
+					if r.value == nil || r.weight == nil {
+						continue
+					}
+					value, weight := r.value.(float64), r.weight.(float64)
+					current, _ := sumValueWeight[group].(float64)
+					sumValueWeight[group] = current + value*weight
+					current, _ = sumWeight[group].(float64)
+					sumWeight[group] = current + weight
+				}
+			}
+		}
+		Ω(measure.combine([]map[string]interface{}{sumValueWeight, sumWeight})).Should(Equal(map[string]interface{}{
+			"1": (2.0*1 + 4*3 + 10*0 + 6*2) / (1 + 3 + 0 + 2) * 100,
+			// zero total weight yields null.
+			"2": nil,
+			"3": (1.0*1 + 3*1) / (1 + 1) * 100,
+		}))
+
+		for _, expr := range []string{"weightedAvg(clicks)", "weightedAvg(clicks, impressions, id)"} {
+			qc = compileMeasure(Measure{Expr: expr})
+			Ω(qc.Error).ShouldNot(BeNil(), expr)
+			Ω(qc.Error.Error()).Should(ContainSubstring("expect 2 arguments"), expr)
+		}
+	})
+
+	ginkgo.It("compiles the filter of each aggregate into its sub query", func() {
+		qc := compileMeasure(Measure{Expr: "sum(clicks) FILTER (WHERE impressions > 10)"})
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.arithmeticMeasure).Should(BeNil())
+		Ω(qc.OOPK.Measure.String()).Should(Equal("clicks * impressions > 10"))
+		Ω(qc.OOPK.MainTableCommonFilters).Should(HaveLen(1))
+
+		qc = compileMeasure(Measure{Expr: "count(*) FILTER (WHERE impressions > 10)"})
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.OOPK.Measure.String()).Should(Equal("impressions > 10 * 1"))
+
+		// the identity of max would be returned for groups without matching rows.
+		qc = compileMeasure(Measure{Expr: "max(clicks) FILTER (WHERE impressions > 10)"})
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.OOPK.Measure.String()).Should(Equal("clicks"))
+		Ω(qc.OOPK.MainTableCommonFilters).Should(HaveLen(2))
+		Ω(qc.OOPK.MainTableCommonFilters[0].String()).Should(Equal("impressions > 10"))
+
+		qc = compileMeasure(Measure{Expr: "sum(clicks) FILTER (WHERE impressions > 10) / sum(clicks)"})
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.arithmeticMeasure.aggregates).Should(Equal([]string{
+			"sum(clicks) FILTER (WHERE impressions > 10)", "sum(clicks)"}))
+		Ω(qc.OOPK.Measure.String()).Should(Equal("clicks * impressions > 10"))
+		Ω(qc.OOPK.MainTableCommonFilters).Should(HaveLen(1))
+		Ω(qc.arithmeticMeasure.subQueryContexts[0].OOPK.Measure.String()).Should(Equal("clicks"))
+		Ω(qc.arithmeticMeasure.subQueryContexts[0].OOPK.MainTableCommonFilters).Should(HaveLen(1))
+
+		for _, q := range []*AQLQuery{
+			{Table: "ads", Measures: []Measure{{Expr: "count(*)"}}, Dimensions: []Dimension{{Expr: "max(id) FILTER (WHERE id > 1)"}}},
+			{Table: "ads", Measures: []Measure{{Expr: "count(*)"}}, Filters: []string{"sum(id) FILTER (WHERE id > 1) > 1"}},
+		} {
+			Ω(q.Compile(store, false).Error.Error()).Should(ContainSubstring("FILTER is only supported"))
+		}
+	})
+
+	ginkgo.It("computes multiple measures with their own filters", func() {
+		q := &AQLQuery{
+			Table:      "ads",
+			Dimensions: []Dimension{{Expr: "id"}},
+			Measures: []Measure{
+				{Expr: "sum(clicks) FILTER (WHERE impressions > 10)"},
+				{Expr: "sum(clicks)", Filters: []string{"impressions <= 10"}},
+				{Expr: "sum(clicks) / sum(impressions)", Filters: []string{"impressions <= 10"}},
+			},
+			Filters: []string{"id > 1"},
+		}
+		qc := q.Compile(store, false)
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.arithmeticMeasure.aggregates).Should(Equal([]string{
+			"sum(clicks) FILTER (WHERE impressions > 10)",
+			"sum(clicks) FILTER (WHERE impressions <= 10)",
+			"sum(impressions) FILTER (WHERE impressions <= 10)",
+		}))
+		contexts := append([]*AQLQueryContext{qc}, qc.arithmeticMeasure.subQueryContexts...)
+		// all sub queries scan the same rows.
+		for i, measure := range []string{
+			"clicks * impressions > 10", "clicks * impressions <= 10", "impressions * impressions <= 10"} {
+			Ω(contexts[i].Error).Should(BeNil())
+			Ω(contexts[i].OOPK.Measure.String()).Should(Equal(measure))
+			Ω(contexts[i].OOPK.MainTableCommonFilters).Should(HaveLen(1))
+			Ω(contexts[i].OOPK.MainTableCommonFilters[0].String()).Should(Equal("id > 1"))
+		}
+
+		// groups of any measure are returned, missing aggregates yield null.
+		clicksOver10 := map[string]interface{}{"2": 5.0, "3": 7.0}
+		clicksUpTo10 := map[string]interface{}{"2": 1.0, "4": 2.0}
+		impressionsUpTo10 := map[string]interface{}{"2": 4.0, "4": 0.0}
+		Ω(qc.arithmeticMeasure.combine([]map[string]interface{}{clicksOver10, clicksUpTo10, impressionsUpTo10})).Should(Equal(map[string]interface{}{
+			"2": map[string]interface{}{"0": 5.0, "1": 1.0, "2": 0.25},
+			"3": map[string]interface{}{"0": 7.0, "1": nil, "2": nil},
+			"4": map[string]interface{}{"0": nil, "1": 2.0, "2": nil},
+		}))
+
+		q.Limit = 10
+		Ω(q.Compile(store, false).Error.Error()).Should(ContainSubstring("limit is not supported with multiple measures"))
+	})
+})
@@ -0,0 +1,62 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/uber/aresdb/utils"
+)
+
+// ErrColumnAccessDenied is returned when a query references columns its tenant is not allowed
+// to read.
+var ErrColumnAccessDenied = errors.New("Column access denied")
+
+// CheckColumnAccess returns ErrColumnAccessDenied if the compiled query or any of its sub queries
+// uses columns the tenant is not allowed to read. Every column used by the query is checked,
+// including columns only referenced by filters, dimensions and join conditions. The time column
+// of fact tables is always used, restricting it restricts all queries of the table.
+func (qc *AQLQueryContext) CheckColumnAccess(tenant string) error {
+	for _, subQC := range append([]*AQLQueryContext{qc}, qc.getSubQueryContexts()...) {
+		for _, scanner := range subQC.TableScanners {
+			if err := scanner.checkColumnAccess(tenant); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (ts *TableScanner) checkColumnAccess(tenant string) error {
+	columnIDs := make([]int, 0, len(ts.ColumnUsages))
+	for columnID := range ts.ColumnUsages {
+		columnIDs = append(columnIDs, columnID)
+	}
+	sort.Ints(columnIDs)
+
+	ts.Schema.RLock()
+	defer ts.Schema.RUnlock()
+	for _, columnID := range columnIDs {
+		if columnID >= len(ts.Schema.Schema.Columns) {
+			continue
+		}
+		if column := ts.Schema.Schema.Columns[columnID]; !column.CanRead(tenant) {
+			return utils.StackError(ErrColumnAccessDenied, "tenant %s is not allowed to read column %s of table %s",
+				tenant, column.Name, ts.Schema.Schema.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	"github.com/uber/aresdb/memstore"
+	memMocks "github.com/uber/aresdb/memstore/mocks"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("column acl", func() {
+	var memStore *memMocks.MemStore
+
+	ginkgo.BeforeEach(func() {
+		schema := memstore.NewTableSchema(&metaCom.Table{
+			Name:        "trips",
+			IsFactTable: true,
+			Columns: []metaCom.Column{
+				{Name: "request_at", Type: metaCom.Uint32},
+				{Name: "city_id", Type: metaCom.Uint16},
+				{Name: "rider_id", Type: metaCom.Uint32, Config: metaCom.ColumnConfig{
+					ReadTenants: []string{"finance"},
+				}},
+			},
+		})
+		shard := &memstore.TableShard{Schema: schema}
+		shard.ArchiveStore = &memstore.ArchiveStore{CurrentVersion: memstore.NewArchiveStoreVersion(0, shard)}
+
+		memStore = new(memMocks.MemStore)
+		memStore.On("RLock").Return()
+		memStore.On("RUnlock").Return()
+		memStore.On("GetSchemas").Return(map[string]*memstore.TableSchema{"trips": schema})
+		memStore.On("GetTableShard", "trips", 0).Run(func(args mock.Arguments) {
+			shard.Users.Add(1)
+		}).Return(shard, nil)
+
+		utils.SetCurrentTime(time.Unix(86400, 0))
+	})
+
+	ginkgo.AfterEach(func() {
+		utils.ResetClockImplementation()
+	})
+
+	compile := func(dimension string, filters ...string) *AQLQueryContext {
+		q := &AQLQuery{
+			Table:      "trips",
+			Dimensions: []Dimension{{Expr: dimension}},
+			Measures:   []Measure{{Expr: "count(*)"}},
+			Filters:    filters,
+			TimeFilter: TimeFilter{Column: "request_at", From: "-1d"},
+		}
+		qc := q.Compile(memStore, false)
+		Ω(qc.Error).Should(BeNil())
+		return qc
+	}
+
+	ginkgo.It("allows queries not using restricted columns", func() {
+		Ω(compile("city_id").CheckColumnAccess("marketing")).Should(BeNil())
+	})
+
+	ginkgo.It("allows queries of tenants allowed to read the columns", func() {
+		Ω(compile("rider_id").CheckColumnAccess("finance")).Should(BeNil())
+		Ω(compile("city_id", "rider_id = 1").CheckColumnAccess("finance")).Should(BeNil())
+	})
+
+	ginkgo.It("rejects restricted columns referenced anywhere in the query", func() {
+		for _, qc := range []*AQLQueryContext{
+			compile("rider_id"),
+			compile("city_id", "rider_id = 1"),
+			compile("city_id", "rider_id = 1 OR city_id = 2"),
+		} {
+			err := qc.CheckColumnAccess("marketing")
+			Ω(err).ShouldNot(BeNil())
+			Ω(err.Error()).Should(ContainSubstring(ErrColumnAccessDenied.Error()))
+			Ω(err.Error()).Should(ContainSubstring("tenant marketing is not allowed to read column rider_id of table trips"))
+		}
+	})
+
+	ginkgo.It("checks the sub queries of arithmetic measures", func() {
+		q := &AQLQuery{
+			Table:      "trips",
+			Measures:   []Measure{{Expr: "count(*) / sum(rider_id)"}},
+			TimeFilter: TimeFilter{Column: "request_at", From: "-1d"},
+		}
+		qc := q.Compile(memStore, false)
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.CheckColumnAccess("marketing")).ShouldNot(BeNil())
+		Ω(qc.CheckColumnAccess("finance")).Should(BeNil())
+	})
+})
@@ -18,7 +18,6 @@ import (
 	"encoding/hex"
 	"fmt"
 	memCom "github.com/uber/aresdb/memstore/common"
-	"github.com/uber/aresdb/utils"
 	"strconv"
 	"time"
 	"unsafe"
@@ -132,12 +131,16 @@ func GetDimensionStartOffsets(numDimsPerDimWidth DimCountsPerDimWidth, dimIndex
 	return valueOffset, nullOffset
 }
 
+// FormatTimeDimension formats the bucketized value of a time dimension the same way as ReadDimension.
+func FormatTimeDimension(val int64, meta TimeDimensionMeta, cache map[TimeDimensionMeta]map[int64]string) string {
+	return formatTimeDimension(val, meta, cache)
+}
+
 func formatTimeDimension(val int64, meta TimeDimensionMeta, cache map[TimeDimensionMeta]map[int64]string) (result string) {
 	// We will not process timeUnit for application/hll because if application/hll holds the raw uint32
 	// value. If we convert it to milliseconds, it will overflow.
 	if meta.TimeUnit != "" {
-		val = utils.AdjustOffset(meta.FromOffset, meta.ToOffset,
-			meta.DSTSwitchTs, val)
+		val = meta.DST.ToUTC(val)
 		switch meta.TimeUnit {
 		case "day":
 			val /= SecondsPerDay
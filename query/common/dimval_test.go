@@ -18,6 +18,7 @@ import (
 	"github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	memCom "github.com/uber/aresdb/memstore/common"
+	"github.com/uber/aresdb/utils"
 	"time"
 	"unsafe"
 )
@@ -143,7 +144,7 @@ var _ = ginkgo.Describe("dimval", func() {
 			memAccess(dimNullVector, 0), 1, memCom.Uint32, nil, &TimeDimensionMeta{TimeBucketizer: "minute", TimeUnit: "", IsTimezoneTable: false, TimeZone: sfLoc}, nil)).Should(Equal("1970-01-01 00:00"))
 		Ω(*ReadDimension(memAccess(dimValueVector, 0),
 			memAccess(dimNullVector, 0), 1, memCom.Uint32, nil,
-			&TimeDimensionMeta{TimeBucketizer: "minute", TimeUnit: "second", IsTimezoneTable: false, TimeZone: sfLoc, FromOffset: offsetInSeconds, ToOffset: offsetInSeconds}, nil)).Should(Equal("-3599"))
+			&TimeDimensionMeta{TimeBucketizer: "minute", TimeUnit: "second", IsTimezoneTable: false, TimeZone: sfLoc, DST: &utils.DSTSegments{Offsets: []int{offsetInSeconds}}}, nil)).Should(Equal("-3599"))
 	})
 
 	ginkgo.It("Time unit should work", func() {
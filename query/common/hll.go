@@ -34,8 +34,13 @@ const (
 	HLLDataHeader uint32 = 0xACED0102
 	// EnumDelimiter is the delimiter to delimit enum cases.
 	EnumDelimiter = "\u0000\n"
+	// HLLPrecision is the number of register index bits of hll sketches. It is fixed rather than
+	// configurable: the device kernels (HLL_BITS in time_series_aggregate.h), the dense format and
+	// the bias correction data all assume it, which keeps sketches of all batches, shards and cached
+	// results mergeable.
+	HLLPrecision = 14
 	// DenseDataLength is the length of hll dense data in bytes.
-	DenseDataLength = 1 << 14 // 16kb
+	DenseDataLength = 1 << HLLPrecision // 16kb
 	// DenseThreshold is the thresold to convert sparse value to dense value.
 	DenseThreshold = DenseDataLength / 4
 )
@@ -173,7 +178,7 @@ func (hll *HLL) ConvertToDense() {
 		return
 	}
 
-	hll.DenseData = make([]byte, 1<<hllP)
+	hll.DenseData = make([]byte, DenseDataLength)
 	for _, register := range hll.SparseData {
 		hll.DenseData[register.Index] = register.Rho
 	}
@@ -182,7 +187,7 @@ func (hll *HLL) ConvertToDense() {
 
 // ConvertToSparse try converting the hll to sparse format if it turns out to be cheaper.
 func (hll *HLL) ConvertToSparse() bool {
-	if hll.NonZeroRegisters*4 >= 1<<hllP {
+	if hll.NonZeroRegisters*4 >= DenseDataLength {
 		return false
 	}
 	if hll.SparseData != nil {
@@ -209,7 +214,7 @@ func (hll *HLL) Set(index uint16, rho byte) {
 
 	hll.SparseData = append(hll.SparseData, HLLRegister{index, rho})
 
-	if hll.NonZeroRegisters*4 >= 1<<hllP {
+	if hll.NonZeroRegisters*4 >= DenseDataLength {
 		hll.ConvertToDense()
 	}
 }
@@ -659,7 +664,7 @@ func getEstimateBias(estimate float64) float64 {
 // Decode decodes the HLL from cache cache.
 // Interprets as dense or sparse format based on len(data).
 func (hll *HLL) Decode(data []byte) {
-	if len(data) == 1<<hllP {
+	if len(data) == DenseDataLength {
 		hll.DenseData = data
 		hll.SparseData = nil
 		hll.NonZeroRegisters = 0
@@ -682,7 +687,7 @@ func (hll *HLL) Decode(data []byte) {
 }
 
 // Encode encodes the HLL for cache storage.
-// Dense format will have a length of 1<<hllP.
+// Dense format will have a length of DenseDataLength.
 // Sparse format will have a smaller length
 func (hll *HLL) Encode() []byte {
 	if len(hll.DenseData) != 0 {
@@ -700,7 +705,7 @@ func (hll *HLL) Encode() []byte {
 // Compute computes the result of the HLL.
 func (hll *HLL) Compute() float64 {
 	nonZeroRegisters := float64(hll.NonZeroRegisters)
-	m := float64(uint64(1) << hllP)
+	m := float64(DenseDataLength)
 
 	// Sum of reciproclas of rhos
 	var sumOfReciprocals float64
@@ -749,8 +754,6 @@ func (b hllBiasesByDistances) Less(i, j int) bool {
 
 // threshold and bias data taken from google's bias correction data set:
 // https://docs.google.com/document/d/1gyjfMHy43U9OWBXxfaeG-3MjGzejW1dlpyMwEYAAWEI/view?fullscreen#
-var hllP byte = 14
-
 var hllThreshold = 15500.0
 
 // precision 14
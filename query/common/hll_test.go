@@ -19,10 +19,32 @@ import (
 	"github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	memCom "github.com/uber/aresdb/memstore/common"
+	"github.com/uber/aresdb/utils"
 	"io/ioutil"
+	"math"
 	"unsafe"
 )
 
+// buildHLL builds a hll sketch over values [from, to) the same way the device does:
+// murmur3 hash the value, compute the register and keep the max rho (plus 1) per register.
+func buildHLL(from, to int) HLL {
+	registers := make(map[uint16]byte)
+	for i := from; i < to; i++ {
+		value := uint32(i)
+		hashed := utils.Murmur3Sum128(unsafe.Pointer(&value), 4, 0)[0]
+		hllValue := utils.ComputeHLLValue(hashed)
+		index, rho := uint16(hllValue&0x3FFF), byte(hllValue>>16)+1
+		if rho > registers[index] {
+			registers[index] = rho
+		}
+	}
+	hll := HLL{}
+	for index, rho := range registers {
+		hll.Set(index, rho)
+	}
+	return hll
+}
+
 var _ = ginkgo.Describe("hll", func() {
 	hllData := [DenseDataLength + 28]byte{}
 	hllData[12] = 1
@@ -198,7 +220,7 @@ var _ = ginkgo.Describe("hll", func() {
 		h2.Decode(h1.Encode())
 		Ω(h2).Should(Equal(h1))
 
-		hllDenseData := make([]byte, 1<<hllP)
+		hllDenseData := make([]byte, DenseDataLength)
 		hllDenseData[100] = 1
 		hllDenseData[200] = 2
 		h1 = HLL{
@@ -235,4 +257,21 @@ var _ = ginkgo.Describe("hll", func() {
 		Ω(h.DenseData[4300]).Should(Equal(byte(0)))
 		Ω(h.NonZeroRegisters).Should(Equal(uint16(4101)))
 	})
+
+	ginkgo.It("estimates known cardinalities", func() {
+		for _, n := range []int{100, 1000, 10000, 100000, 1000000} {
+			hll := buildHLL(0, n)
+			estimate := hll.Compute()
+			Ω(math.Abs(estimate-float64(n))/float64(n)).Should(BeNumerically("<", 0.03), fmt.Sprintf("cardinality %d, estimate %f", n, estimate))
+		}
+	})
+
+	ginkgo.It("estimates cardinality of merged sketches", func() {
+		// two shards with 50000 overlapping values.
+		hll := buildHLL(0, 100000)
+		other := buildHLL(50000, 200000)
+		hll.Merge(other)
+		estimate := hll.Compute()
+		Ω(math.Abs(estimate-200000) / 200000).Should(BeNumerically("<", 0.03))
+	})
 })
@@ -53,9 +53,8 @@ type TimeDimensionMeta struct {
 	TimeUnit        string
 	IsTimezoneTable bool
 	TimeZone        *time.Location
-	DSTSwitchTs     int64
-	FromOffset      int
-	ToOffset        int
+	// DST splits the query time range at the DST switches of TimeZone.
+	DST *utils.DSTSegments
 }
 
 // TimeSeriesBucketizer is the helper struct to express parsed time bucketizer, see comment below
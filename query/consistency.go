@@ -0,0 +1,57 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import "github.com/uber/aresdb/utils"
+
+const (
+	// ConsistencyDefault reads both the live store and the archive store.
+	ConsistencyDefault = "default"
+	// ConsistencyArchiveOnly only reads the archive store, rows not archived yet are excluded.
+	ConsistencyArchiveOnly = "archiveOnly"
+	// ConsistencyLiveOnly only reads the live store, rows already archived are excluded.
+	ConsistencyLiveOnly = "liveOnly"
+)
+
+// processConsistency validates the consistency of the query against its main table.
+func (qc *AQLQueryContext) processConsistency() {
+	switch qc.Query.Consistency {
+	case "", ConsistencyDefault:
+		return
+	case ConsistencyArchiveOnly, ConsistencyLiveOnly:
+	default:
+		qc.Error = utils.StackError(nil, "consistency must be %s, %s or %s, got %s",
+			ConsistencyDefault, ConsistencyArchiveOnly, ConsistencyLiveOnly, qc.Query.Consistency)
+		return
+	}
+	switch {
+	// dimension tables only have a live store.
+	case !qc.TableScanners[0].Schema.Schema.IsFactTable:
+		qc.Error = utils.StackError(nil, "consistency %s is only supported for fact tables", qc.Query.Consistency)
+	// pages are scoped to the rows archived before the first page.
+	case qc.Query.Consistency == ConsistencyLiveOnly && qc.Query.isPaginated():
+		qc.Error = utils.StackError(nil, "consistency %s is not supported for paginated queries", ConsistencyLiveOnly)
+	}
+}
+
+// scansLiveStore tells whether the query reads live batches.
+func (qc *AQLQueryContext) scansLiveStore() bool {
+	return qc.Query.Consistency != ConsistencyArchiveOnly
+}
+
+// scansArchiveStore tells whether the query reads archive batches.
+func (qc *AQLQueryContext) scansArchiveStore() bool {
+	return qc.Query.Consistency != ConsistencyLiveOnly
+}
@@ -0,0 +1,185 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/uber/aresdb/memstore"
+	"github.com/uber/aresdb/utils"
+)
+
+// DefaultCursorTTL is how long the cursor of a page can be used to fetch the next page.
+const DefaultCursorTTL = 10 * time.Minute
+
+var (
+	// ErrInvalidCursor is returned when the cursor cannot be decoded or was issued for another query.
+	ErrInvalidCursor = errors.New("Invalid query cursor")
+	// ErrCursorExpired is returned when the cursor is used after its TTL.
+	ErrCursorExpired = errors.New("Query cursor expired")
+)
+
+// signCursor returns the signature of the encoded cursor with the key.
+func signCursor(payload string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// queryCursor is the position of a paginated query after the last group returned, it's
+// encoded into an opaque token signed with the cursor key and returned with each page.
+//
+// All pages of a query are scoped to the rows archived before the first page was
+// processed, and relative time filters are resolved against the time of the first page,
+// so that pages are consistent with each other while new rows are being ingested. The
+// archive store version of the first page is not pinned, so rows before the cutoff merged
+// by backfill between two pages are read by the later pages.
+type queryCursor struct {
+	// Fingerprint of the query the cursor was issued for.
+	Fingerprint string `json:"f"`
+	// Archiving cutoff of the main table when the first page was processed.
+	Cutoff uint32 `json:"c"`
+	// Time in seconds when the first page was processed.
+	Now int64 `json:"n"`
+	// Dimension values and measure value of the last group returned, empty for the first page.
+	DimValues []string    `json:"d,omitempty"`
+	Value     interface{} `json:"v,omitempty"`
+	// Time in seconds after which the cursor can no longer be used.
+	ExpiresAt int64 `json:"e,omitempty"`
+	// Key the cursor is signed with.
+	key []byte
+}
+
+// isPaginated tells whether the query should be returned in pages.
+func (q *AQLQuery) isPaginated() bool {
+	return q.Paginate || q.Cursor != ""
+}
+
+// fingerprint identifies the query regardless of the page requested. It should be computed
+// before the query is compiled, as compiling rewrites parts of the query.
+func (q *AQLQuery) fingerprint() string {
+	query := *q
+	query.Paginate, query.Cursor = false, ""
+	bytes, _ := json.Marshal(query)
+	sum := sha256.Sum256(bytes)
+	return hex.EncodeToString(sum[:16])
+}
+
+// decodeCursor decodes the cursor token of the query with the fingerprint, signed with the key.
+func decodeCursor(token, fingerprint string, key []byte, now time.Time) (*queryCursor, error) {
+	dot := strings.IndexByte(token, '.')
+	if dot < 0 || !hmac.Equal([]byte(token[dot+1:]), []byte(signCursor(token[:dot], key))) {
+		return nil, ErrInvalidCursor
+	}
+	bytes, err := base64.RawURLEncoding.DecodeString(token[:dot])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor queryCursor
+	if err = json.Unmarshal(bytes, &cursor); err != nil || cursor.Fingerprint != fingerprint {
+		return nil, ErrInvalidCursor
+	}
+	if now.Unix() > cursor.ExpiresAt {
+		return nil, ErrCursorExpired
+	}
+	cursor.key = key
+	return &cursor, nil
+}
+
+// encodeNext returns the token of the cursor after the group, which expires after ttl.
+func (c queryCursor) encodeNext(group topNGroup, now time.Time, ttl time.Duration) (string, error) {
+	c.DimValues = group.dimValues
+	c.Value = nil
+	// non finite measures cannot be marshaled and are sorted as NULLs.
+	if value, ok := group.value.(float64); ok && !math.IsNaN(value) && !math.IsInf(value, 0) {
+		c.Value = value
+	}
+	c.ExpiresAt = now.Add(ttl).Unix()
+	bytes, err := json.Marshal(c)
+	if err != nil {
+		return "", utils.StackError(err, "Failed to encode query cursor")
+	}
+	payload := base64.RawURLEncoding.EncodeToString(bytes)
+	return payload + "." + signCursor(payload, c.key), nil
+}
+
+// processCursor resolves the snapshot a paginated query is scoped to, from its cursor, or from
+// the archiving cutoff of the main table for the first page. The fingerprint should be computed
+// before compiling the query.
+func (qc *AQLQueryContext) processCursor(store memstore.MemStore, fingerprint string) {
+	q := qc.Query
+	if !q.isPaginated() {
+		return
+	}
+	if q.Limit <= 0 || len(q.Dimensions) == 0 {
+		qc.Error = utils.StackError(nil, "pagination requires limit and dimensions")
+		return
+	}
+	scanner := qc.TableScanners[0]
+	if !scanner.Schema.Schema.IsFactTable {
+		qc.Error = utils.StackError(nil, "pagination is only supported for fact tables")
+		return
+	}
+	if len(q.CursorKey) == 0 {
+		qc.Error = utils.StackError(nil, "pagination requires a key to sign cursors with")
+		return
+	}
+
+	now := utils.Now()
+	if q.Cursor != "" {
+		cursor, err := decodeCursor(q.Cursor, fingerprint, q.CursorKey, now)
+		if err != nil {
+			qc.Error = err
+			return
+		}
+		if len(cursor.DimValues) != len(q.Dimensions) {
+			qc.Error = ErrInvalidCursor
+			return
+		}
+		qc.cursor = cursor
+		return
+	}
+
+	qc.cursor = &queryCursor{Fingerprint: fingerprint, Cutoff: math.MaxUint32, Now: now.Unix(), key: q.CursorKey}
+	for _, shardID := range scanner.Shards {
+		shard, err := store.GetTableShard(q.Table, shardID)
+		if err != nil {
+			qc.Error = utils.StackError(err, "failed to get shard %d for table %s", shardID, q.Table)
+			return
+		}
+		version := shard.ArchiveStore.GetCurrentVersion()
+		if version.ArchivingCutoff < qc.cursor.Cutoff {
+			qc.cursor.Cutoff = version.ArchivingCutoff
+		}
+		version.Users.Done()
+		shard.Users.Done()
+	}
+}
+
+// now returns the time relative time filters are resolved against.
+func (qc *AQLQueryContext) now() time.Time {
+	if qc.cursor != nil {
+		return time.Unix(qc.cursor.Now, 0)
+	}
+	return utils.Now()
+}
@@ -0,0 +1,182 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	"github.com/uber/aresdb/memstore"
+	memCom "github.com/uber/aresdb/memstore/common"
+	memMocks "github.com/uber/aresdb/memstore/mocks"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("query cursor", func() {
+	var memStore *memMocks.MemStore
+	var shard *memstore.TableShard
+	day := int64(86400)
+
+	ginkgo.BeforeEach(func() {
+		schema := &memstore.TableSchema{
+			Schema: metaCom.Table{
+				Name:        "trips",
+				IsFactTable: true,
+				Columns: []metaCom.Column{
+					{Name: "request_at", Type: metaCom.Uint32},
+					{Name: "city_id", Type: metaCom.Uint16},
+					{Name: "fare", Type: metaCom.Float32},
+				},
+			},
+			ColumnIDs:         map[string]int{"request_at": 0, "city_id": 1, "fare": 2},
+			ValueTypeByColumn: []memCom.DataType{memCom.Uint32, memCom.Uint16, memCom.Float32},
+		}
+		shard = &memstore.TableShard{Schema: schema}
+		shard.ArchiveStore = &memstore.ArchiveStore{CurrentVersion: memstore.NewArchiveStoreVersion(uint32(18010*day), shard)}
+
+		memStore = new(memMocks.MemStore)
+		memStore.On("RLock").Return()
+		memStore.On("RUnlock").Return()
+		memStore.On("GetSchemas").Return(map[string]*memstore.TableSchema{"trips": schema})
+		memStore.On("GetTableShard", "trips", 0).Run(func(args mock.Arguments) {
+			shard.Users.Add(1)
+		}).Return(shard, nil)
+
+		utils.SetCurrentTime(time.Unix(18012*day, 0))
+	})
+
+	ginkgo.AfterEach(func() {
+		utils.ResetClockImplementation()
+	})
+
+	newQuery := func() *AQLQuery {
+		return &AQLQuery{
+			Table:      "trips",
+			Dimensions: []Dimension{{Expr: "city_id"}},
+			Measures:   []Measure{{Expr: "sum(fare)"}},
+			TimeFilter: TimeFilter{Column: "request_at", From: "-7d"},
+			Limit:      2,
+			Sorts:      []SortField{{Expr: "sum(fare)", Desc: true}},
+			Paginate:   true,
+			CursorKey:  []byte("key"),
+		}
+	}
+
+	newResult := func() map[string]interface{} {
+		return map[string]interface{}{"1": 5.0, "2": 10.0, "3": 10.0, "4": nil, "5": 7.0}
+	}
+
+	// nextPage compiles the query from the cursor and returns the page of the result, cursors
+	// expire after two days.
+	nextPage := func(cursor string, result map[string]interface{}) (*AQLQueryContext, map[string]interface{}) {
+		q := newQuery()
+		q.Cursor = cursor
+		qc := q.Compile(memStore, false)
+		Ω(qc.Error).Should(BeNil())
+		if last := qc.topN.apply(result); last != nil {
+			qc.NextCursor, qc.Error = qc.cursor.encodeNext(*last, utils.Now(), 2*24*time.Hour)
+			Ω(qc.Error).Should(BeNil())
+		}
+		return qc, result
+	}
+
+	ginkgo.It("scopes all pages to the rows archived before the first page", func() {
+		qc, page := nextPage("", newResult())
+		Ω(page).Should(Equal(map[string]interface{}{"2": 10.0, "3": 10.0}))
+		Ω(qc.NextCursor).ShouldNot(BeEmpty())
+		filters := qc.OOPK.MainTableCommonFilters
+		Ω(filters[len(filters)-1].String()).Should(Equal("request_at < 1556064000"))
+		Ω(qc.TableScanners[0].ArchiveBatchIDEnd).Should(Equal(18010))
+		fromTime := qc.fromTime.Time
+
+		// rows ingested and archived after the first page are not read by the next pages, which
+		// resolve the time filter against the time of the first page.
+		shard.ArchiveStore.CurrentVersion = memstore.NewArchiveStoreVersion(uint32(18012*day), shard)
+		utils.SetCurrentTime(time.Unix(18013*day, 0))
+		qc, page = nextPage(qc.NextCursor, newResult())
+		Ω(page).Should(Equal(map[string]interface{}{"5": 7.0, "1": 5.0}))
+		filters = qc.OOPK.MainTableCommonFilters
+		Ω(filters[len(filters)-1].String()).Should(Equal("request_at < 1556064000"))
+		Ω(qc.TableScanners[0].ArchiveBatchIDEnd).Should(Equal(18010))
+		Ω(qc.fromTime.Time).Should(Equal(fromTime))
+
+		// the last page comes without cursor.
+		qc, page = nextPage(qc.NextCursor, newResult())
+		Ω(page).Should(Equal(map[string]interface{}{"4": nil}))
+		Ω(qc.NextCursor).Should(BeEmpty())
+
+		// the first page of the same query takes a new snapshot.
+		qc, _ = nextPage("", newResult())
+		Ω(qc.cursor.Cutoff).Should(Equal(uint32(18012 * day)))
+	})
+
+	ginkgo.It("rejects invalid and expired cursors", func() {
+		qc, _ := nextPage("", newResult())
+		cursor := qc.NextCursor
+
+		for _, token := range []string{"abc", "!!!"} {
+			q := newQuery()
+			q.Cursor = token
+			Ω(q.Compile(memStore, false).Error).Should(Equal(ErrInvalidCursor))
+		}
+
+		// cursors cannot be forged or used with another key.
+		payload := cursor[:strings.IndexByte(cursor, '.')]
+		bytes, err := base64.RawURLEncoding.DecodeString(payload)
+		Ω(err).Should(BeNil())
+		forged := base64.RawURLEncoding.EncodeToString(
+			[]byte(strings.Replace(string(bytes), `"c":1556064000`, `"c":1556150400`, 1)))
+		for _, token := range []string{forged + cursor[len(payload):], payload, payload + "."} {
+			q := newQuery()
+			q.Cursor = token
+			Ω(q.Compile(memStore, false).Error).Should(Equal(ErrInvalidCursor))
+		}
+		q := newQuery()
+		q.Cursor, q.CursorKey = cursor, []byte("another key")
+		Ω(q.Compile(memStore, false).Error).Should(Equal(ErrInvalidCursor))
+
+		// cursors cannot be used for other queries.
+		q = newQuery()
+		q.Cursor = cursor
+		q.Filters = []string{"fare > 1"}
+		Ω(q.Compile(memStore, false).Error).Should(Equal(ErrInvalidCursor))
+
+		utils.SetCurrentTime(time.Unix(18014*day+1, 0))
+		q = newQuery()
+		q.Cursor = cursor
+		Ω(q.Compile(memStore, false).Error).Should(Equal(ErrCursorExpired))
+	})
+
+	ginkgo.It("rejects pagination of unsupported queries", func() {
+		q := newQuery()
+		q.Limit, q.Sorts = 0, nil
+		Ω(q.Compile(memStore, false).Error.Error()).Should(ContainSubstring("pagination requires limit"))
+
+		q = newQuery()
+		q.Dimensions = nil
+		q.Sorts = nil
+		Ω(q.Compile(memStore, false).Error.Error()).Should(ContainSubstring("pagination requires limit"))
+
+		q = newQuery()
+		q.Measures = []Measure{{Expr: "sum(fare)/count(*)"}}
+		q.Sorts = nil
+		Ω(q.Compile(memStore, false).Error.Error()).Should(ContainSubstring("not supported for arithmetic measures"))
+	})
+})
@@ -61,7 +61,9 @@ type DeviceManager struct {
 	Timeout int `json:"timeout"`
 	// Max available memory, this can be used to early determined whether a query can be satisfied or not.
 	MaxAvailableMemory int `json:"maxAvailableMemory"`
-	deviceAvailable    *sync.Cond
+	// Max device memory a query can use, 0 means no limit.
+	MaxQueryMemory  int `json:"maxQueryMemory,omitempty"`
+	deviceAvailable *sync.Cond
 	// device choose strategy
 	strategy deviceChooseStrategy
 }
@@ -84,16 +86,19 @@ func NewDeviceManager(cfg common.QueryConfig) *DeviceManager {
 
 	// retrieve device counts
 	deviceCount := memutils.GetDeviceCount()
+	devices := selectDevices(cfg.Devices, deviceCount)
 	utils.GetLogger().With(
 		"utilization", deviceMemoryUtilization,
-		"timeout", timeout).Info("Initialized device manager")
+		"timeout", timeout,
+		"devices", devices,
+		"strategy", cfg.DeviceChoosingStrategy).Info("Initialized device manager")
 
-	deviceInfos := make([]*DeviceInfo, deviceCount)
+	deviceInfos := make([]*DeviceInfo, len(devices))
 	maxAvailableMem := 0
-	for device := 0; device < deviceCount; device++ {
-		deviceInfos[device] = getDeviceInfo(device, deviceMemoryUtilization)
-		if deviceInfos[device].TotalAvailableMemory >= maxAvailableMem {
-			maxAvailableMem = deviceInfos[device].TotalAvailableMemory
+	for i, device := range devices {
+		deviceInfos[i] = getDeviceInfo(device, deviceMemoryUtilization)
+		if deviceInfos[i].TotalAvailableMemory >= maxAvailableMem {
+			maxAvailableMem = deviceInfos[i].TotalAvailableMemory
 		}
 	}
 
@@ -101,11 +106,23 @@ func NewDeviceManager(cfg common.QueryConfig) *DeviceManager {
 		RWMutex:            &sync.RWMutex{},
 		DeviceInfos:        deviceInfos,
 		MaxAvailableMemory: maxAvailableMem,
+		MaxQueryMemory:     cfg.MaxQueryDeviceMemory * mb2bytes,
 		Timeout:            timeout,
 	}
 
-	deviceManager.strategy = leastQueryCountAndMemoryStrategy{
-		deviceManager: deviceManager,
+	switch cfg.DeviceChoosingStrategy {
+	case common.DeviceChoosingStrategyRoundRobin:
+		deviceManager.strategy = &roundRobinStrategy{
+			deviceManager: deviceManager,
+		}
+	default:
+		if cfg.DeviceChoosingStrategy != "" && cfg.DeviceChoosingStrategy != common.DeviceChoosingStrategyLeastQueryCount {
+			utils.GetLogger().With("strategy", cfg.DeviceChoosingStrategy).
+				Error("Invalid device choosing strategy config, setting to default")
+		}
+		deviceManager.strategy = leastQueryCountAndMemoryStrategy{
+			deviceManager: deviceManager,
+		}
 	}
 
 	deviceManager.deviceAvailable = sync.NewCond(deviceManager)
@@ -117,6 +134,29 @@ func NewDeviceManager(cfg common.QueryConfig) *DeviceManager {
 	return deviceManager
 }
 
+// selectDevices returns the configured devices queries run on, invalid and duplicate devices
+// are ignored. All devices are selected if none is configured or valid.
+func selectDevices(configured []int, deviceCount int) []int {
+	var devices []int
+	selected := make(map[int]bool)
+	for _, device := range configured {
+		if device < 0 || device >= deviceCount || selected[device] {
+			utils.GetLogger().With("device", device, "deviceCount", deviceCount).
+				Error("Invalid device config, ignoring it")
+			continue
+		}
+		selected[device] = true
+		devices = append(devices, device)
+	}
+
+	if len(devices) == 0 {
+		for device := 0; device < deviceCount; device++ {
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
+
 // getDeviceInfo returns the DeviceInfo struct for a given deviceID.
 func getDeviceInfo(device int, deviceMemoryUtilization float32) *DeviceInfo {
 	totalGlobalMem := memutils.GetDeviceGlobalMemoryInMB(device) * mb2bytes
@@ -187,45 +227,54 @@ func (d *DeviceManager) findDevice(query *AQLQuery, requiredMem int, preferredDe
 		"requiredMem", requiredMem,
 		"preferredDevice", preferredDevice,
 	).Debug("trying to find device for query")
-	candidateDevice := -1
-
 	// try to choose preferredDevice if it meets requirements.
-	if preferredDevice >= 0 && preferredDevice < len(d.DeviceInfos) &&
-		d.DeviceInfos[preferredDevice].FreeMemory >= requiredMem {
-		candidateDevice = preferredDevice
+	candidate := d.deviceIndex(preferredDevice)
+	if candidate >= 0 && d.DeviceInfos[candidate].FreeMemory < requiredMem {
+		candidate = -1
 	}
 
-	// choose candidateDevice if preferredDevice does not meet requirements
-	if candidateDevice < 0 {
-		candidateDevice = d.strategy.chooseDevice(requiredMem)
+	// choose candidate if preferredDevice does not meet requirements
+	if candidate < 0 {
+		candidate = d.strategy.chooseDevice(requiredMem)
 	}
 
-	if candidateDevice < 0 {
-		return candidateDevice
+	if candidate < 0 {
+		return -1
 	}
 
 	// reserve memory for this query.
-	deviceInfo := d.DeviceInfos[candidateDevice]
+	deviceInfo := d.DeviceInfos[candidate]
 	deviceInfo.QueryCount++
 	deviceInfo.QueryMemoryUsageMap[query] = requiredMem
 	deviceInfo.FreeMemory -= requiredMem
 	deviceInfo.reportMemoryUsage()
 
-	utils.GetLogger().Debugf("Assign device '%d' for query", candidateDevice)
+	utils.GetLogger().Debugf("Assign device '%d' for query", deviceInfo.DeviceID)
 	utils.GetLogger().Debugf("DeviceInfo=%+v", deviceInfo)
-	return candidateDevice
+	return deviceInfo.DeviceID
+}
+
+// deviceIndex returns the index of the device in DeviceInfos, -1 if queries don't run on it.
+func (d *DeviceManager) deviceIndex(device int) int {
+	for i, deviceInfo := range d.DeviceInfos {
+		if deviceInfo.DeviceID == device {
+			return i
+		}
+	}
+	return -1
 }
 
 // ReleaseReservedMemory adjust total free global memory for a given device after a query is complete
 func (d *DeviceManager) ReleaseReservedMemory(device int, query *AQLQuery) {
-	// Don't even need the lock,
-	if device < 0 || device >= len(d.DeviceInfos) {
+	// Don't even need the lock, DeviceInfos are not changed after creation.
+	index := d.deviceIndex(device)
+	if index < 0 {
 		return
 	}
 
 	d.Lock()
 	defer d.Unlock()
-	deviceInfo := d.DeviceInfos[device]
+	deviceInfo := d.DeviceInfos[index]
 	usage, ok := deviceInfo.QueryMemoryUsageMap[query]
 	if ok {
 		utils.GetLogger().Debugf("Freed %d bytes memory on device %d", usage, device)
@@ -237,16 +286,24 @@ func (d *DeviceManager) ReleaseReservedMemory(device int, query *AQLQuery) {
 	}
 }
 
-// reportMemoryUsage reports the memory usage of specified device. Caller needs to hold the lock.
+// reportMemoryUsage reports the memory usage and query count of specified device. Caller needs to
+// hold the lock.
 func (deviceInfo *DeviceInfo) reportMemoryUsage() {
-	utils.GetRootReporter().GetChildGauge(map[string]string{
+	tags := map[string]string{
 		"device": strconv.Itoa(deviceInfo.DeviceID),
-	}, utils.EstimatedDeviceMemory).Update(
-		float64(deviceInfo.TotalAvailableMemory - deviceInfo.FreeMemory))
+	}
+	usedMemory := deviceInfo.TotalAvailableMemory - deviceInfo.FreeMemory
+	utils.GetRootReporter().GetChildGauge(tags, utils.EstimatedDeviceMemory).Update(float64(usedMemory))
+	if deviceInfo.TotalAvailableMemory > 0 {
+		utils.GetRootReporter().GetChildGauge(tags, utils.DeviceMemoryUtilization).Update(
+			float64(usedMemory) / float64(deviceInfo.TotalAvailableMemory))
+	}
+	utils.GetRootReporter().GetChildGauge(tags, utils.DeviceQueryCount).Update(float64(deviceInfo.QueryCount))
 }
 
 // deviceChooseStrategy defines the interface to choose an available device for
-// specific query.
+// specific query. It returns the index of the device in DeviceInfos, caller needs to hold the
+// write lock of the device manager.
 type deviceChooseStrategy interface {
 	chooseDevice(requiredMem int) int
 }
@@ -263,13 +320,35 @@ func (s leastQueryCountAndMemoryStrategy) chooseDevice(requiredMem int) int {
 	candidateDevice := -1
 	leastMemory := int(math.MaxInt64)
 	leastQueryCount := int(math.MaxInt32)
-	for device, deviceInfo := range s.deviceManager.DeviceInfos {
+	for index, deviceInfo := range s.deviceManager.DeviceInfos {
 		if deviceInfo.FreeMemory >= requiredMem && (deviceInfo.QueryCount < leastQueryCount ||
 			(deviceInfo.QueryCount == leastQueryCount && deviceInfo.FreeMemory <= leastMemory)) {
-			candidateDevice = device
+			candidateDevice = index
 			leastQueryCount = deviceInfo.QueryCount
 			leastMemory = deviceInfo.FreeMemory
 		}
 	}
 	return candidateDevice
 }
+
+// roundRobinStrategy is to assign queries to devices in turn, skipping devices without enough
+// memory for the query.
+type roundRobinStrategy struct {
+	deviceManager *DeviceManager
+	// index of the device to try first for the next query.
+	next int
+}
+
+// chooseDevice finds the next device in turn having enough memory for the query.
+// If no such device, return -1.
+func (s *roundRobinStrategy) chooseDevice(requiredMem int) int {
+	deviceInfos := s.deviceManager.DeviceInfos
+	for i := range deviceInfos {
+		index := (s.next + i) % len(deviceInfos)
+		if deviceInfos[index].FreeMemory >= requiredMem {
+			s.next = (index + 1) % len(deviceInfos)
+			return index
+		}
+	}
+	return -1
+}
@@ -190,6 +190,95 @@ var _ = ginkgo.Describe("device_manager", func() {
 		Ω(device).Should(Equal(-1))
 	})
 
+	ginkgo.It("roundRobinStrategy should work", func() {
+		deviceManager.strategy = &roundRobinStrategy{
+			deviceManager: deviceManager,
+		}
+		queries := [5]*AQLQuery{{}, {}, {}, {}, {}}
+		devices := [5]int{}
+		// queries are assigned to devices in turn.
+		devices[0] = deviceManager.findDevice(queries[0], 100, -1)
+		Ω(devices[0]).Should(Equal(0))
+		devices[1] = deviceManager.findDevice(queries[1], 100, -1)
+		Ω(devices[1]).Should(Equal(1))
+		devices[2] = deviceManager.findDevice(queries[2], 100, -1)
+		Ω(devices[2]).Should(Equal(2))
+		// 300 1900 2900
+
+		// devices without enough memory are skipped.
+		devices[3] = deviceManager.findDevice(queries[3], 1000, -1)
+		Ω(devices[3]).Should(Equal(1))
+		// 300 900 2900
+		devices[4] = deviceManager.findDevice(queries[4], 1000, -1)
+		Ω(devices[4]).Should(Equal(2))
+		// 300 900 1900
+		Ω(deviceManager.findDevice(&AQLQuery{}, 2000, -1)).Should(Equal(-1))
+
+		for i := range devices {
+			deviceManager.ReleaseReservedMemory(devices[i], queries[i])
+		}
+		for device, deviceInfo := range deviceManager.DeviceInfos {
+			Ω(deviceInfo.FreeMemory).Should(Equal(freeMemory[device]))
+			Ω(deviceInfo.QueryCount).Should(Equal(queryCounts[device]))
+		}
+	})
+
+	ginkgo.It("queries should only run on selected devices", func() {
+		Ω(selectDevices([]int{2, 0, 2, 5, -1}, 3)).Should(Equal([]int{2, 0}))
+		Ω(selectDevices(nil, 3)).Should(Equal([]int{0, 1, 2}))
+		Ω(selectDevices([]int{3}, 2)).Should(Equal([]int{0, 1}))
+
+		// device ids differ from their indexes in DeviceInfos.
+		deviceManager.DeviceInfos = deviceManager.DeviceInfos[1:]
+		deviceManager.DeviceInfos[0].DeviceID = 3
+		deviceManager.DeviceInfos[1].DeviceID = 5
+		deviceManager.strategy = &roundRobinStrategy{
+			deviceManager: deviceManager,
+		}
+		query := &AQLQuery{}
+		Ω(deviceManager.findDevice(query, 100, 5)).Should(Equal(5))
+		Ω(deviceManager.DeviceInfos[1].FreeMemory).Should(Equal(2900))
+		deviceManager.ReleaseReservedMemory(5, query)
+		Ω(deviceManager.DeviceInfos[1].FreeMemory).Should(Equal(3000))
+		// preferred device is not selected.
+		Ω(deviceManager.findDevice(query, 100, 1)).Should(Equal(3))
+		deviceManager.ReleaseReservedMemory(1, query)
+		Ω(deviceManager.DeviceInfos[0].FreeMemory).Should(Equal(1900))
+	})
+
+	ginkgo.It("queries over the device memory limit should be rejected", func() {
+		deviceManager.strategy = leastMemStrategy
+		deviceManager.MaxQueryMemory = 1024
+		qc := &AQLQueryContext{
+			Query: &AQLQuery{Table: "trips"},
+			OOPK: OOPKContext{
+				// hll
+				AggregateType: 10,
+			},
+		}
+		qc.FindDeviceForQuery(nil, -1, deviceManager, 1)
+		Ω(qc.Error).ShouldNot(BeNil())
+		Ω(qc.Error.Error()).Should(ContainSubstring(ErrDeviceMemoryLimitExceeded.Error()))
+		Ω(qc.LimitExceeded()).Should(BeTrue())
+		Ω(qc.Device).Should(Equal(-1))
+		for device, deviceInfo := range deviceManager.DeviceInfos {
+			Ω(deviceInfo.FreeMemory).Should(Equal(freeMemory[device]))
+		}
+
+		// without the limit, the query waits for a device instead.
+		deviceManager.MaxQueryMemory = 0
+		qc = &AQLQueryContext{
+			Query: &AQLQuery{Table: "trips"},
+			OOPK: OOPKContext{
+				AggregateType: 10,
+			},
+		}
+		qc.FindDeviceForQuery(nil, -1, deviceManager, 1)
+		Ω(qc.Error).ShouldNot(BeNil())
+		Ω(qc.LimitExceeded()).Should(BeFalse())
+		Ω(qc.Device).Should(Equal(-1))
+	})
+
 	ginkgo.It("estimate memory usage", func() {
 		testFactory := memstore.TestFactoryT{
 			RootPath:   "../testing/data",
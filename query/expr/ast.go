@@ -73,6 +73,7 @@ type Expr interface {
 
 func (*BinaryExpr) expr()      {}
 func (*BooleanLiteral) expr()  {}
+func (*BoundParameter) expr()  {}
 func (*Call) expr()            {}
 func (*Case) expr()            {}
 func (*Distinct) expr()        {}
@@ -178,6 +179,9 @@ type VarRef struct {
 
 	// Whether this column is hll column (can run hll directly)
 	IsHLLColumn bool
+
+	// Whether this column is enum array column (stored as bitmap of enum ids).
+	IsEnumArrayColumn bool
 }
 
 // Type returns the type.
@@ -192,8 +196,11 @@ func (r *VarRef) String() string {
 
 // Call represents a function call.
 type Call struct {
-	Name     string
-	Args     []Expr
+	Name string
+	Args []Expr
+	// Filter of aggregate calls, e.g. sum(fare) FILTER (WHERE city_id = 1),
+	// only the rows matching the filter are aggregated.
+	Filter   Expr
 	ExprType Type
 }
 
@@ -215,7 +222,11 @@ func (c *Call) String() string {
 	}
 
 	// Write function name and args.
-	return fmt.Sprintf("%s(%s)", c.Name, strings.Join(strs, ", "))
+	str := fmt.Sprintf("%s(%s)", c.Name, strings.Join(strs, ", "))
+	if c.Filter != nil {
+		str += fmt.Sprintf(" FILTER (WHERE %s)", c.Filter.String())
+	}
+	return str
 }
 
 // WhenThen represents a when-then conditional expression pair in a case expression.
@@ -357,6 +368,20 @@ func (l *GeopointLiteral) String() string {
 	return fmt.Sprintf("point(%f, %f)", l.Val[0], l.Val[1])
 }
 
+// BoundParameter represents a parameter of a prepared query, e.g. $city_id.
+// It is replaced by a literal before the expression is compiled.
+type BoundParameter struct {
+	Name string
+}
+
+// Type returns the type.
+func (l *BoundParameter) Type() Type {
+	return UnknownType
+}
+
+// String returns a string representation of the parameter.
+func (l *BoundParameter) String() string { return "$" + l.Name }
+
 // NullLiteral represents a NULL literal.
 type NullLiteral struct{}
 
@@ -462,12 +487,18 @@ func CloneExpr(expr Expr) Expr {
 		return &BinaryExpr{Op: expr.Op, LHS: CloneExpr(expr.LHS), RHS: CloneExpr(expr.RHS)}
 	case *BooleanLiteral:
 		return &BooleanLiteral{Val: expr.Val}
+	case *BoundParameter:
+		return &BoundParameter{Name: expr.Name}
 	case *Call:
 		args := make([]Expr, len(expr.Args))
 		for i, arg := range expr.Args {
 			args[i] = CloneExpr(arg)
 		}
-		return &Call{Name: expr.Name, Args: args}
+		var filter Expr
+		if expr.Filter != nil {
+			filter = CloneExpr(expr.Filter)
+		}
+		return &Call{Name: expr.Name, Args: args, Filter: filter}
 	case *Case:
 		conds := make([]WhenThen, len(expr.WhenThens))
 		for i, cond := range expr.WhenThens {
@@ -482,7 +513,11 @@ func CloneExpr(expr Expr) Expr {
 	case *Distinct:
 		return &Distinct{Val: expr.Val}
 	case *NumberLiteral:
-		return &NumberLiteral{Val: expr.Val, Expr: expr.Expr}
+		return &NumberLiteral{Val: expr.Val, Int: expr.Int, Expr: expr.Expr, ExprType: expr.ExprType}
+	case *NullLiteral:
+		return &NullLiteral{}
+	case *UnknownLiteral:
+		return &UnknownLiteral{}
 	case *ParenExpr:
 		return &ParenExpr{Expr: CloneExpr(expr.Expr)}
 	case *StringLiteral:
@@ -534,6 +569,9 @@ func Walk(v Visitor, expr Expr) {
 		for _, expr := range e.Args {
 			Walk(v, expr)
 		}
+		if e.Filter != nil {
+			Walk(v, e.Filter)
+		}
 
 	case *ParenExpr:
 		Walk(v, e.Expr)
@@ -584,6 +622,9 @@ func Rewrite(r Rewriter, expr Expr) Expr {
 		for i, expr := range e.Args {
 			e.Args[i] = Rewrite(r, expr)
 		}
+		if e.Filter != nil {
+			e.Filter = Rewrite(r, e.Filter)
+		}
 	}
 
 	return r.Rewrite(expr)
@@ -319,12 +319,20 @@ func (p *Parser) ParseExpr(binOpPrcdncLb int) (Expr, error) {
 	for {
 		// If the next token is NOT an operator then return the expression.
 		op, pos, lit := p.scanIgnoreWhitespace()
+		op = lookupOperator(op, lit)
 		if op == NOT {
 			op, pos, lit = p.scanIgnoreWhitespace()
-			if op == IN {
+			switch lookupOperator(op, lit) {
+			case IN:
 				op = NOT_IN
-			} else {
-				return nil, newParseError(tokstr(op, lit), []string{"IN"}, pos)
+			case LIKE:
+				op = NOT_LIKE
+			case ILIKE:
+				op = NOT_ILIKE
+			case REGEXP:
+				op = NOT_REGEXP
+			default:
+				return nil, newParseError(tokstr(op, lit), []string{"IN", "LIKE", "ILIKE", "REGEXP"}, pos)
 			}
 		}
 		if !op.isBinaryOperator() || op.Precedence() < binOpPrcdncLb {
@@ -465,6 +473,8 @@ func (p *Parser) parseUnaryExpr(inclusion bool) (Expr, error) {
 		return &UnknownLiteral{}, nil
 	case TRUE, FALSE:
 		return &BooleanLiteral{Val: (tok == TRUE)}, nil
+	case BOUNDPARAM:
+		return &BoundParameter{Name: lit}, nil
 	case MUL:
 		return &Wildcard{}, nil
 	default:
@@ -549,7 +559,42 @@ func (p *Parser) parseCall(name string) (*Call, error) {
 		return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
 	}
 
-	return &Call{Name: name, Args: args}, nil
+	filter, err := p.parseCallFilter()
+	if err != nil {
+		return nil, err
+	}
+	return &Call{Name: name, Args: args, Filter: filter}, nil
+}
+
+// parseCallFilter parses the optional FILTER (WHERE ...) clause following a call.
+func (p *Parser) parseCallFilter() (Expr, error) {
+	tok, _, lit := p.scan()
+	whitespace := tok == WS
+	if whitespace {
+		tok, _, lit = p.scan()
+	}
+	if tok != IDENT || strings.ToLower(lit) != "filter" {
+		p.unscan()
+		if whitespace {
+			p.unscan()
+		}
+		return nil, nil
+	}
+
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != LPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{"("}, pos)
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != WHERE {
+		return nil, newParseError(tokstr(tok, lit), []string{"WHERE"}, pos)
+	}
+	filter, err := p.ParseExpr(0)
+	if err != nil {
+		return nil, err
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
+	}
+	return filter, nil
 }
 
 // scan returns the next token from the underlying scanner.
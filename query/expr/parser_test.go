@@ -41,6 +41,7 @@ func TestParser_ParseExpr(t *testing.T) {
 		{s: `true`, expr: &expr.BooleanLiteral{Val: true}},
 		{s: `false`, expr: &expr.BooleanLiteral{Val: false}},
 		{s: `my_ident`, expr: &expr.VarRef{Val: "my_ident"}},
+		{s: `$my_param`, expr: &expr.BoundParameter{Name: "my_param"}},
 
 		// Simple binary expression
 		{
@@ -166,6 +167,64 @@ func TestParser_ParseExpr(t *testing.T) {
 				}},
 			},
 		},
+		// Binary expression with pattern matching.
+		{
+			s: "city LIKE 'san%'",
+			expr: &expr.BinaryExpr{
+				Op:  expr.LIKE,
+				LHS: &expr.VarRef{Val: "city"},
+				RHS: &expr.StringLiteral{Val: "san%"},
+			},
+		},
+		{
+			s: "city NOT ILIKE 'san%'",
+			expr: &expr.BinaryExpr{
+				Op:  expr.NOT_ILIKE,
+				LHS: &expr.VarRef{Val: "city"},
+				RHS: &expr.StringLiteral{Val: "san%"},
+			},
+		},
+		{
+			s: "city regexp '^s' AND id = 1",
+			expr: &expr.BinaryExpr{
+				Op: expr.AND,
+				LHS: &expr.BinaryExpr{
+					Op:  expr.REGEXP,
+					LHS: &expr.VarRef{Val: "city"},
+					RHS: &expr.StringLiteral{Val: "^s"},
+				},
+				RHS: &expr.BinaryExpr{
+					Op:  expr.EQ,
+					LHS: &expr.VarRef{Val: "id"},
+					RHS: &expr.NumberLiteral{Val: 1, Int: 1, Expr: "1", ExprType: expr.Unsigned},
+				},
+			},
+		},
+		// Pattern matching operators are contextual keywords and can be used as identifiers.
+		{
+			s: "like LIKE 'san%'",
+			expr: &expr.BinaryExpr{
+				Op:  expr.LIKE,
+				LHS: &expr.VarRef{Val: "like"},
+				RHS: &expr.StringLiteral{Val: "san%"},
+			},
+		},
+		{
+			s: "regexp = 1 OR ilike NOT REGEXP '^s'",
+			expr: &expr.BinaryExpr{
+				Op: expr.OR,
+				LHS: &expr.BinaryExpr{
+					Op:  expr.EQ,
+					LHS: &expr.VarRef{Val: "regexp"},
+					RHS: &expr.NumberLiteral{Val: 1, Int: 1, Expr: "1", ExprType: expr.Unsigned},
+				},
+				RHS: &expr.BinaryExpr{
+					Op:  expr.NOT_REGEXP,
+					LHS: &expr.VarRef{Val: "ilike"},
+					RHS: &expr.StringLiteral{Val: "^s"},
+				},
+			},
+		},
 		// Unary expression.
 		{
 			s: "not now",
@@ -362,6 +421,28 @@ func TestParser_ParseExpr(t *testing.T) {
 				},
 			},
 		},
+
+		// Aggregate with filter
+		{
+			s: `sum(fare) FILTER (WHERE city_id = 1) + 1`,
+			expr: &expr.BinaryExpr{
+				Op: expr.ADD,
+				LHS: &expr.Call{
+					Name: "sum",
+					Args: []expr.Expr{&expr.VarRef{Val: "fare"}},
+					Filter: &expr.BinaryExpr{
+						Op:  expr.EQ,
+						LHS: &expr.VarRef{Val: "city_id"},
+						RHS: &expr.NumberLiteral{Val: 1, Int: 1, Expr: "1", ExprType: expr.Unsigned},
+					},
+				},
+				RHS: &expr.NumberLiteral{Val: 1, Int: 1, Expr: "1", ExprType: expr.Unsigned},
+			},
+		},
+		{
+			s:   `sum(fare) filter (city_id = 1)`,
+			err: "found city_id, expected WHERE at line 1, char 19",
+		},
 	}
 
 	for i, tt := range tests {
@@ -73,6 +73,12 @@ func (s *Scanner) scan() (tok Token, pos Pos, lit string) {
 	case '`':
 		s.r.unread()
 		return s.scanIdent()
+	case '$':
+		tok, _, lit = s.scanIdent()
+		if tok != IDENT || lit == "" {
+			return BADSTRING, pos, "$" + lit
+		}
+		return BOUNDPARAM, pos, lit
 	case '"':
 		return s.scanString()
 	case '\'':
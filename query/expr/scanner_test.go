@@ -87,6 +87,10 @@ func TestScanner_Scan(t *testing.T) {
 		{s: `true`, tok: expr.TRUE},
 		{s: `false`, tok: expr.FALSE},
 
+		// Bound parameters
+		{s: `$city_id`, tok: expr.BOUNDPARAM, lit: `city_id`},
+		{s: `$`, tok: expr.BADSTRING, lit: `$`},
+
 		// Strings
 		{s: `'testing 123!'`, tok: expr.STRING, lit: `testing 123!`},
 		{s: `"testing 123!"`, tok: expr.STRING, lit: `testing 123!`},
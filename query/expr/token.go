@@ -36,15 +36,16 @@ const (
 
 	literal_beg
 	// Literals
-	IDENT     // main
-	NUMBER    // 12345.67
-	STRING    // "abc"
-	BADSTRING // "abc
-	BADESCAPE // \q
-	NULL      // NULL
-	UNKNOWN   // UNKNOWN
-	TRUE      // true
-	FALSE     // false
+	IDENT      // main
+	NUMBER     // 12345.67
+	STRING     // "abc"
+	BADSTRING  // "abc
+	BADESCAPE  // \q
+	NULL       // NULL
+	UNKNOWN    // UNKNOWN
+	TRUE       // true
+	FALSE      // false
+	BOUNDPARAM // $param
 	literal_end
 
 	operator_beg
@@ -104,6 +105,14 @@ const (
 	GT  // >
 	GTE // >=
 
+	// Pattern matching operators on enum columns.
+	LIKE       // LIKE
+	NOT_LIKE   // NOT LIKE
+	ILIKE      // ILIKE
+	NOT_ILIKE  // NOT ILIKE
+	REGEXP     // REGEXP
+	NOT_REGEXP // NOT REGEXP
+
 	// Geo intersects
 	GEOGRAPHY_INTERSECTS
 	binary_operator_end
@@ -159,15 +168,16 @@ var tokens = map[Token]string{
 	EOF:     "EOF",
 	WS:      "WS",
 
-	IDENT:     "IDENT",
-	NUMBER:    "NUMBER",
-	STRING:    "STRING",
-	BADSTRING: "BADSTRING",
-	BADESCAPE: "BADESCAPE",
-	NULL:      "NULL",
-	UNKNOWN:   "UNKNOWN",
-	TRUE:      "TRUE",
-	FALSE:     "FALSE",
+	IDENT:      "IDENT",
+	NUMBER:     "NUMBER",
+	STRING:     "STRING",
+	BADSTRING:  "BADSTRING",
+	BADESCAPE:  "BADESCAPE",
+	NULL:       "NULL",
+	UNKNOWN:    "UNKNOWN",
+	TRUE:       "TRUE",
+	FALSE:      "FALSE",
+	BOUNDPARAM: "BOUNDPARAM",
 
 	EXCLAMATION: "!",
 	UNARY_MINUS: "-",
@@ -215,6 +225,13 @@ var tokens = map[Token]string{
 	GT:     ">",
 	GTE:    ">=",
 
+	LIKE:       "LIKE",
+	NOT_LIKE:   "NOT LIKE",
+	ILIKE:      "ILIKE",
+	NOT_ILIKE:  "NOT ILIKE",
+	REGEXP:     "REGEXP",
+	NOT_REGEXP: "NOT REGEXP",
+
 	GEOGRAPHY_INTERSECTS: "GEOGRAPHY_INTERSECTS",
 
 	LPAREN: "(",
@@ -261,6 +278,14 @@ var tokens = map[Token]string{
 
 var keywords map[string]Token
 
+// contextualKeywords are operators only recognized following an expression, so that they can
+// still be used as identifiers, e.g. a column named like.
+var contextualKeywords = map[string]Token{
+	"like":   LIKE,
+	"ilike":  ILIKE,
+	"regexp": REGEXP,
+}
+
 func init() {
 	keywords = make(map[string]Token)
 	for tok := keyword_beg + 1; tok < keyword_end; tok++ {
@@ -299,7 +324,7 @@ func (tok Token) Precedence() int {
 		return 2
 	case NOT:
 		return 3
-	case IN, NOT_IN, IS, EQ, NEQ, LT, LTE, GT, GTE:
+	case IN, NOT_IN, LIKE, NOT_LIKE, ILIKE, NOT_ILIKE, REGEXP, NOT_REGEXP, IS, EQ, NEQ, LT, LTE, GT, GTE:
 		return 4
 	case BITWISE_OR:
 		return 5
@@ -349,6 +374,16 @@ func Lookup(ident string) Token {
 	return IDENT
 }
 
+// lookupOperator returns the contextual keyword of an identifier scanned as operator.
+func lookupOperator(tok Token, lit string) Token {
+	if tok == IDENT {
+		if op, ok := contextualKeywords[strings.ToLower(lit)]; ok {
+			return op
+		}
+	}
+	return tok
+}
+
 // Pos specifies the line and character position of a token.
 // The Char and Line are both zero-based indexes.
 type Pos struct {
@@ -0,0 +1,85 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"github.com/uber/aresdb/query/expr"
+	"github.com/uber/aresdb/utils"
+)
+
+// FilterNode is a node of a boolean filter tree. Exactly one of its fields is set: a leaf node has the
+// predicate as a SQL expression, other nodes combine their children with AND, OR or NOT.
+type FilterNode struct {
+	Expr string       `json:"sqlExpression,omitempty"`
+	And  []FilterNode `json:"and,omitempty"`
+	Or   []FilterNode `json:"or,omitempty"`
+	Not  *FilterNode  `json:"not,omitempty"`
+}
+
+// parse converts the filter tree into an expression. ANDs are kept at the top of the expression so
+// that normalizeAndFilters can flatten them into separate filters, which are then matched against
+// prefilters and classified by the tables they reference the same way as row filters.
+func (n *FilterNode) parse() (expr.Expr, error) {
+	numSet := 0
+	for _, set := range []bool{n.Expr != "", n.And != nil, n.Or != nil, n.Not != nil} {
+		if set {
+			numSet++
+		}
+	}
+	if numSet != 1 {
+		return nil, utils.StackError(nil, "filter node must have exactly one of sqlExpression, and, or, not")
+	}
+
+	switch {
+	case n.Expr != "":
+		e, err := expr.ParseExpr(n.Expr)
+		if err != nil {
+			return nil, utils.StackError(err, "Failed to parse filter %s", n.Expr)
+		}
+		return &expr.ParenExpr{Expr: e}, nil
+	case n.Not != nil:
+		e, err := n.Not.parse()
+		if err != nil {
+			return nil, err
+		}
+		return &expr.UnaryExpr{Op: expr.NOT, Expr: e}, nil
+	case len(n.And) > 0:
+		return parseFilterNodes(n.And, expr.AND)
+	case len(n.Or) > 0:
+		e, err := parseFilterNodes(n.Or, expr.OR)
+		if err != nil {
+			return nil, err
+		}
+		return &expr.ParenExpr{Expr: e}, nil
+	}
+	return nil, utils.StackError(nil, "filter node must not have empty children")
+}
+
+// parseFilterNodes parses the nodes and combines them with op from left to right.
+func parseFilterNodes(nodes []FilterNode, op expr.Token) (expr.Expr, error) {
+	var result expr.Expr
+	for i := range nodes {
+		e, err := nodes[i].parse()
+		if err != nil {
+			return nil, err
+		}
+		if result == nil {
+			result = e
+		} else {
+			result = &expr.BinaryExpr{Op: op, LHS: result, RHS: e}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,171 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"strconv"
+
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+const (
+	// FillZero fills empty time buckets with 0.
+	FillZero = "zero"
+	// FillNull fills empty time buckets with null.
+	FillNull = "null"
+)
+
+// maxGapFillBuckets is the max number of time buckets a query can fill.
+const maxGapFillBuckets = 100000
+
+// gapFill adds the time buckets of the time range missing from the final result set.
+type gapFill struct {
+	// number of dimensions before the time dimension.
+	depth int
+	// formatted values of all time buckets of the time range.
+	buckets []string
+	// value of filled buckets, nil for null.
+	value interface{}
+	// number of measures of the query, filled buckets of queries with more than one measure have
+	// the value for each measure.
+	numMeasures int
+}
+
+// compileGapFill returns the gap fill of the time dimension with fill set, or nil if there is none.
+// q is the query as specified by the client, the time dimension and time range are read from the
+// compiled query context.
+func compileGapFill(q *AQLQuery, qc *AQLQueryContext) (*gapFill, error) {
+	dimIndex := -1
+	for i, dim := range q.Dimensions {
+		if dim.Fill == "" {
+			continue
+		}
+		if dimIndex >= 0 {
+			return nil, utils.StackError(nil, "fill can only be set on one dimension")
+		}
+		dimIndex = i
+	}
+	if dimIndex < 0 {
+		return nil, nil
+	}
+
+	fill := &gapFill{depth: dimIndex, numMeasures: len(q.Measures)}
+	switch q.Dimensions[dimIndex].Fill {
+	case FillZero:
+		fill.value = 0.0
+	case FillNull:
+	default:
+		return nil, utils.StackError(nil, "fill must be %s or %s, got %s", FillZero, FillNull, q.Dimensions[dimIndex].Fill)
+	}
+
+	switch {
+	case qc.ReturnHLLData:
+		return nil, utils.StackError(nil, "fill is not supported when client specify 'Accept' as 'application/hll'")
+	case dimIndex != len(q.Dimensions)-1:
+		return nil, utils.StackError(nil, "fill is only supported for the last dimension")
+	case qc.fromTime == nil || qc.toTime == nil:
+		return nil, utils.StackError(nil, "fill requires a time filter")
+	case qc.timezoneTable.tableColumn != "":
+		return nil, utils.StackError(nil, "fill is not supported with timezone %s", q.Timezone)
+	}
+
+	dim := qc.Query.Dimensions[dimIndex]
+	bucket, err := queryCom.ParseRegularTimeBucketizer(dim.TimeBucketizer)
+	if err != nil {
+		return nil, utils.StackError(err, "fill only supports minute, hour and day based time bucketizers, got '%s'",
+			dim.TimeBucketizer)
+	}
+	bucketSize := int64(bucket.Size * queryCom.BucketSizeToseconds[bucket.Unit])
+
+	from, to := qc.fromTime.Time.Unix(), qc.toTime.Time.Unix()
+	if numBuckets := (to - from) / bucketSize; numBuckets > maxGapFillBuckets {
+		return nil, utils.StackError(nil, "fill of %d time buckets exceeds the max of %d", numBuckets, maxGapFillBuckets)
+	}
+	meta := queryCom.TimeDimensionMeta{
+		TimeBucketizer: dim.TimeBucketizer,
+		TimeUnit:       dim.TimeUnit,
+		TimeZone:       qc.fixedTimezone,
+		DST:            qc.getDSTSegments(),
+	}
+	cache := make(map[queryCom.TimeDimensionMeta]map[int64]string)
+
+	// Buckets are sampled in steps no longer than an hour so that no bucket is skipped when the
+	// time range crosses a DST switch.
+	step := bucketSize
+	if step > queryCom.SecondsPerHour {
+		step = queryCom.SecondsPerHour
+	}
+	seen := make(map[int64]bool)
+	for t := from; t < to; t += step {
+		fill.addBucket(t, bucketSize, meta, cache, seen)
+	}
+	if to > from {
+		fill.addBucket(to-1, bucketSize, meta, cache, seen)
+	}
+	return fill, nil
+}
+
+// addBucket adds the bucket of the timestamp the same way as the time dimension is bucketized.
+func (f *gapFill) addBucket(t, bucketSize int64, meta queryCom.TimeDimensionMeta,
+	cache map[queryCom.TimeDimensionMeta]map[int64]string, seen map[int64]bool) {
+	local := t + int64(meta.DST.Offset(t))
+	value := local - local%bucketSize
+	if !seen[value] {
+		seen[value] = true
+		f.buckets = append(f.buckets, queryCom.FormatTimeDimension(value, meta, cache))
+	}
+}
+
+// apply adds the missing buckets under each group of the dimensions before the time dimension.
+func (f *gapFill) apply(result map[string]interface{}) {
+	f.fill(result, 0)
+}
+
+func (f *gapFill) fill(result map[string]interface{}, depth int) {
+	if depth < f.depth {
+		for _, child := range result {
+			if group, ok := child.(map[string]interface{}); ok {
+				f.fill(group, depth+1)
+			}
+		}
+		return
+	}
+	for _, bucket := range f.buckets {
+		if _, found := result[bucket]; !found {
+			result[bucket] = f.newValue()
+		}
+	}
+}
+
+// newValue returns the value of a filled bucket.
+func (f *gapFill) newValue() interface{} {
+	if f.numMeasures <= 1 {
+		return f.value
+	}
+	values := make(map[string]interface{}, f.numMeasures)
+	for i := 0; i < f.numMeasures; i++ {
+		values[strconv.Itoa(i)] = f.value
+	}
+	return values
+}
+
+// clearFill clears the fill of the dimensions of sub queries, the fill is applied to the result
+// the sub query results are merged into.
+func clearFill(dimensions []Dimension) {
+	for i := range dimensions {
+		dimensions[i].Fill = ""
+	}
+}
@@ -0,0 +1,163 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	"github.com/uber/aresdb/memstore"
+	memMocks "github.com/uber/aresdb/memstore/mocks"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("gap fill", func() {
+	var memStore *memMocks.MemStore
+
+	ginkgo.BeforeEach(func() {
+		schema := memstore.NewTableSchema(&metaCom.Table{
+			Name:        "trips",
+			IsFactTable: true,
+			Columns: []metaCom.Column{
+				{Name: "request_at", Type: metaCom.Uint32},
+				{Name: "city_id", Type: metaCom.Uint16},
+				{Name: "fare", Type: metaCom.Float32},
+			},
+		})
+		shard := &memstore.TableShard{Schema: schema}
+		shard.ArchiveStore = &memstore.ArchiveStore{CurrentVersion: memstore.NewArchiveStoreVersion(0, shard)}
+
+		memStore = new(memMocks.MemStore)
+		memStore.On("RLock").Return()
+		memStore.On("RUnlock").Return()
+		memStore.On("GetSchemas").Return(map[string]*memstore.TableSchema{"trips": schema})
+		memStore.On("GetTableShard", "trips", 0).Run(func(args mock.Arguments) {
+			shard.Users.Add(1)
+		}).Return(shard, nil)
+
+		// 2018-01-05 12:00:00 UTC
+		utils.SetCurrentTime(time.Unix(1515153600, 0))
+	})
+
+	ginkgo.AfterEach(func() {
+		utils.ResetClockImplementation()
+	})
+
+	dailyQuery := func(fill string, dimensions ...Dimension) *AQLQuery {
+		return &AQLQuery{
+			Table: "trips",
+			Dimensions: append(dimensions, Dimension{
+				Expr: "request_at", TimeBucketizer: "day", Fill: fill,
+			}),
+			Measures: []Measure{{Expr: "count(*)"}},
+			// to is the end of the day.
+			TimeFilter: TimeFilter{Column: "request_at", From: "2018-01-01", To: "2018-01-04"},
+		}
+	}
+
+	ginkgo.It("fills a sparse series into a dense one", func() {
+		qc := dailyQuery(FillZero).Compile(memStore, false)
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.gapFill.buckets).Should(Equal([]string{
+			"2018-01-01", "2018-01-02", "2018-01-03", "2018-01-04",
+		}))
+
+		result := map[string]interface{}{"2018-01-02": 3.0}
+		qc.gapFill.apply(result)
+		Ω(result).Should(Equal(map[string]interface{}{
+			"2018-01-01": 0.0,
+			"2018-01-02": 3.0,
+			"2018-01-03": 0.0,
+			"2018-01-04": 0.0,
+		}))
+
+		qc = dailyQuery(FillNull).Compile(memStore, false)
+		Ω(qc.Error).Should(BeNil())
+		result = map[string]interface{}{"2018-01-02": 3.0}
+		qc.gapFill.apply(result)
+		Ω(result).Should(HaveLen(4))
+		Ω(result["2018-01-01"]).Should(BeNil())
+	})
+
+	ginkgo.It("fills buckets under each group before limit", func() {
+		q := dailyQuery(FillZero, Dimension{Expr: "city_id"})
+		q.TimeFilter.To = "2018-01-02"
+		q.Limit = 3
+		q.Sorts = []SortField{{Expr: "count(*)", Desc: true}}
+		qc := q.Compile(memStore, false)
+		Ω(qc.Error).Should(BeNil())
+
+		result := queryCom.AQLTimeSeriesResult{
+			"1": map[string]interface{}{"2018-01-01": 5.0},
+			"2": map[string]interface{}{"2018-01-02": 4.0},
+		}
+		qc.gapFill.apply(result)
+		Ω(result).Should(Equal(queryCom.AQLTimeSeriesResult{
+			"1": map[string]interface{}{"2018-01-01": 5.0, "2018-01-02": 0.0},
+			"2": map[string]interface{}{"2018-01-01": 0.0, "2018-01-02": 4.0},
+		}))
+		qc.topN.apply(result)
+		Ω(result).Should(Equal(queryCom.AQLTimeSeriesResult{
+			"1": map[string]interface{}{"2018-01-01": 5.0, "2018-01-02": 0.0},
+			// ties are broken by the dimension values.
+			"2": map[string]interface{}{"2018-01-02": 4.0},
+		}))
+	})
+
+	ginkgo.It("formats buckets in the timezone and time unit of the dimension", func() {
+		q := dailyQuery(FillZero)
+		q.Dimensions[0].TimeBucketizer = "6h"
+		q.Dimensions[0].TimeUnit = "second"
+		q.TimeFilter.To = "2018-01-01"
+		q.Timezone = "America/Los_Angeles"
+		qc := q.Compile(memStore, false)
+		Ω(qc.Error).Should(BeNil())
+		// 2018-01-01 00:00 PST is 1514793600.
+		Ω(qc.gapFill.buckets).Should(Equal([]string{"1514793600", "1514815200", "1514836800", "1514858400"}))
+	})
+
+	ginkgo.It("fills each measure of multiple measures", func() {
+		q := dailyQuery(FillNull)
+		q.Measures = []Measure{{Expr: "count(*)"}, {Expr: "sum(fare)"}}
+		qc := q.Compile(memStore, false)
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.gapFill.newValue()).Should(Equal(map[string]interface{}{"0": nil, "1": nil}))
+		Ω(qc.arithmeticMeasure.subQueryContexts[0].Query.Dimensions[0].Fill).Should(BeEmpty())
+	})
+
+	ginkgo.It("rejects unsupported fills", func() {
+		q := dailyQuery("one")
+		Ω(q.Compile(memStore, false).Error.Error()).Should(ContainSubstring("fill must be zero or null"))
+
+		q = dailyQuery(FillZero)
+		q.Dimensions[0].TimeBucketizer = "month"
+		Ω(q.Compile(memStore, false).Error.Error()).Should(ContainSubstring("fill only supports"))
+
+		q = dailyQuery(FillZero)
+		q.Dimensions = append(q.Dimensions, Dimension{Expr: "city_id"})
+		Ω(q.Compile(memStore, false).Error.Error()).Should(ContainSubstring("only supported for the last dimension"))
+
+		q = dailyQuery(FillZero)
+		q.Dimensions[0].TimeBucketizer = "minute"
+		q.TimeFilter.From = "2017-01-01"
+		Ω(q.Compile(memStore, false).Error.Error()).Should(ContainSubstring("exceeds the max"))
+
+		Ω(dailyQuery(FillZero).Compile(memStore, true).Error).ShouldNot(BeNil())
+	})
+})
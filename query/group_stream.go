@@ -0,0 +1,134 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+// #include "time_series_aggregate.h"
+import "C"
+
+import (
+	"sort"
+	"unsafe"
+
+	memCom "github.com/uber/aresdb/memstore/common"
+	"github.com/uber/aresdb/memutils"
+	queryCom "github.com/uber/aresdb/query/common"
+)
+
+// StreamsGroups tells whether the groups of the query can be read from the result buffers by
+// StreamGroups instead of building the nested result with Postprocess, which is only the case
+// when no step after aggregation needs the whole result.
+func (qc *AQLQueryContext) StreamsGroups() bool {
+	return !qc.OOPK.IsHLL() && len(qc.OOPK.Dimensions) > 0 && qc.rowColumns == nil &&
+		qc.union == nil && qc.arithmeticMeasure == nil && qc.gapFill == nil && qc.running == nil &&
+		qc.topN == nil && qc.percentile.quantile == 0 && qc.histogram.bounds == nil &&
+		qc.explodedDimensions == nil
+}
+
+// StreamGroups calls visit with the dimension values and the measure of each group in the result
+// buffers, in the order the nested result of Postprocess is marshaled in, i.e. by dimension
+// values in order. Groups not matching the having filter are skipped, and of groups with the
+// same dimension values only the last one is visited as Postprocess keeps it. Only the dimension
+// values of the groups are held in memory, and dimValues must not be modified by visit.
+func (qc *AQLQueryContext) StreamGroups(visit func(dimValues []string, measureValue *float64)) {
+	oopkContext := qc.OOPK
+	numDims := len(oopkContext.Dimensions)
+	reader := qc.newDimensionReader(oopkContext.dimensionVectorH, oopkContext.ResultSize)
+	// dimension values of group i are dimValues[i*numDims : (i+1)*numDims].
+	dimValues := make([]string, oopkContext.ResultSize*numDims)
+	groups := make([]int, oopkContext.ResultSize)
+	for i := range groups {
+		groups[i] = i
+		reader.read(i, dimValues[i*numDims:(i+1)*numDims])
+	}
+	compare := func(i, j int) int {
+		for dimIndex := 0; dimIndex < numDims; dimIndex++ {
+			a, b := dimValues[i*numDims+dimIndex], dimValues[j*numDims+dimIndex]
+			if a != b {
+				if a < b {
+					return -1
+				}
+				return 1
+			}
+		}
+		return 0
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return compare(groups[i], groups[j]) < 0
+	})
+
+	measureBytes := oopkContext.MeasureBytes
+	// For avg aggregation function, we only need to read first 4 bytes which is the average.
+	if oopkContext.AggregateType == C.AGGR_AVG_FLOAT {
+		measureBytes = 4
+	}
+	for i, group := range groups {
+		if i+1 < len(groups) && compare(group, groups[i+1]) == 0 {
+			continue
+		}
+		measureValue := readMeasure(
+			memutils.MemAccess(oopkContext.measureVectorH, group*oopkContext.MeasureBytes), oopkContext.Measure,
+			measureBytes)
+		if qc.Query.having != nil && !qc.matchHaving(measureValue) {
+			continue
+		}
+		visit(dimValues[group*numDims:(group+1)*numDims], measureValue)
+	}
+}
+
+// dimensionReader reads the dimension values of rows from a dimension vector on host.
+type dimensionReader struct {
+	valuePtrs, nullPtrs []unsafe.Pointer
+	dataTypes           []memCom.DataType
+	reverseDicts        [][]string
+	timeDimensionMetas  []*queryCom.TimeDimensionMeta
+	dimensionValueCache []map[queryCom.TimeDimensionMeta]map[int64]string
+}
+
+// newDimensionReader creates a reader of the dimension vector with the capacity of rows.
+func (qc *AQLQueryContext) newDimensionReader(dimensionVectorH unsafe.Pointer, capacity int) *dimensionReader {
+	numDims := len(qc.OOPK.Dimensions)
+	r := &dimensionReader{
+		valuePtrs:           make([]unsafe.Pointer, numDims),
+		nullPtrs:            make([]unsafe.Pointer, numDims),
+		dataTypes:           make([]memCom.DataType, numDims),
+		reverseDicts:        make([][]string, numDims),
+		timeDimensionMetas:  make([]*queryCom.TimeDimensionMeta, numDims),
+		dimensionValueCache: make([]map[queryCom.TimeDimensionMeta]map[int64]string, numDims),
+	}
+	for dimIndex, dimExpr := range qc.OOPK.Dimensions {
+		valueOffset, nullOffset := queryCom.GetDimensionStartOffsets(
+			qc.OOPK.NumDimsPerDimWidth, qc.OOPK.DimensionVectorIndex[dimIndex], capacity)
+		r.valuePtrs[dimIndex] = memutils.MemAccess(dimensionVectorH, valueOffset)
+		r.nullPtrs[dimIndex] = memutils.MemAccess(dimensionVectorH, nullOffset)
+		r.dataTypes[dimIndex], r.reverseDicts[dimIndex] = getDimensionDataType(dimExpr), qc.getEnumReverseDict(dimIndex, dimExpr)
+		if r.timeDimensionMetas[dimIndex] = qc.timeDimensionMeta(dimIndex); r.timeDimensionMetas[dimIndex] != nil {
+			r.dimensionValueCache[dimIndex] = make(map[queryCom.TimeDimensionMeta]map[int64]string)
+		}
+	}
+	return r
+}
+
+// read reads the dimension values of the row into dimValues, "NULL" for nulls.
+func (r *dimensionReader) read(row int, dimValues []string) {
+	for dimIndex := range dimValues {
+		value := queryCom.ReadDimension(r.valuePtrs[dimIndex], r.nullPtrs[dimIndex], row, r.dataTypes[dimIndex],
+			r.reverseDicts[dimIndex], r.timeDimensionMetas[dimIndex], r.dimensionValueCache[dimIndex])
+		if value == nil {
+			dimValues[dimIndex] = "NULL"
+		} else {
+			dimValues[dimIndex] = *value
+		}
+	}
+}
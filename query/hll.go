@@ -148,9 +148,7 @@ func (qc *AQLQueryContext) SerializeHLL(dataTypes []memCom.DataType,
 
 				newVal := int64(*valuePtr)
 				if qc.fromTime != nil {
-					_, fromOffset := qc.fromTime.Time.Zone()
-					_, toOffset := qc.toTime.Time.Zone()
-					newVal = utils.AdjustOffset(fromOffset, toOffset, qc.dstswitch, int64(*valuePtr))
+					newVal = qc.getDSTSegments().ToUTC(int64(*valuePtr))
 				}
 
 				if newVal >= math.MaxUint32 {
@@ -0,0 +1,72 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"time"
+
+	"github.com/uber/aresdb/memstore"
+)
+
+// ImmutableTimeRange returns the time range [from, to) in unix seconds of a compiled query and whether
+// the query only reads data that can no longer change, so its result can be cached. That is the case when
+// the query scans a fact table without joins, and its time filter ends before both the archiving cutoff of
+// every shard and the retention boundary, past which records are neither ingested nor backfilled.
+// Union queries are never cached, since the archiving cutoff, retention and schema version differ per table.
+// Queries with consistency archiveOnly are subject to the same checks, since archived data within the archiving
+// cutoff and retention can still change by archiving and backfill. Queries with consistency liveOnly are never cached.
+func (qc *AQLQueryContext) ImmutableTimeRange(memStore memstore.MemStore, now time.Time) (from, to int64, ok bool) {
+	if qc.Error != nil || qc.ReturnHLLData || qc.toTime == nil || len(qc.TableScanners) != 1 ||
+		qc.timezoneTable.tableColumn != "" || qc.union != nil {
+		return
+	}
+
+	scanner := qc.TableScanners[0]
+	scanner.Schema.RLock()
+	isFactTable := scanner.Schema.Schema.IsFactTable
+	retentionDays := scanner.Schema.Schema.Config.RecordRetentionInDays
+	scanner.Schema.RUnlock()
+	if !isFactTable {
+		return
+	}
+
+	if qc.fromTime != nil {
+		from = qc.fromTime.Time.Unix()
+	}
+	to = qc.toTime.Time.Unix()
+	if qc.Query.Consistency == ConsistencyLiveOnly {
+		return
+	}
+
+	if retentionDays <= 0 || to > now.Unix()-int64(retentionDays)*86400 {
+		return
+	}
+
+	for _, shardID := range scanner.Shards {
+		shard, err := memStore.GetTableShard(qc.Query.Table, shardID)
+		if err != nil {
+			return
+		}
+		version := shard.ArchiveStore.GetCurrentVersion()
+		cutoff := version.ArchivingCutoff
+		version.Users.Done()
+		shard.Users.Done()
+		if to > int64(cutoff) {
+			return
+		}
+	}
+	ok = true
+	return
+}
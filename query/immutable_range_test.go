@@ -0,0 +1,159 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	"github.com/uber/aresdb/memstore"
+	memCom "github.com/uber/aresdb/memstore/common"
+	memMocks "github.com/uber/aresdb/memstore/mocks"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("immutable time range", func() {
+	var memStore *memMocks.MemStore
+	var schema *memstore.TableSchema
+	var shard *memstore.TableShard
+	day := int64(86400)
+
+	ginkgo.BeforeEach(func() {
+		schema = &memstore.TableSchema{
+			Schema: metaCom.Table{
+				Name:        "trips",
+				IsFactTable: true,
+				Columns: []metaCom.Column{
+					{Name: "request_at", Type: metaCom.Uint32},
+					{Name: "fare", Type: metaCom.Float32},
+				},
+				Config: metaCom.TableConfig{RecordRetentionInDays: 30},
+			},
+			ColumnIDs:         map[string]int{"request_at": 0, "fare": 1},
+			ValueTypeByColumn: []memCom.DataType{memCom.Uint32, memCom.Float32},
+		}
+		shard = &memstore.TableShard{Schema: schema}
+		shard.ArchiveStore = &memstore.ArchiveStore{CurrentVersion: memstore.NewArchiveStoreVersion(uint32(18010*day), shard)}
+
+		memStore = new(memMocks.MemStore)
+		memStore.On("RLock").Return()
+		memStore.On("RUnlock").Return()
+		memStore.On("GetSchemas").Return(map[string]*memstore.TableSchema{"trips": schema, "trips_copy": schema})
+		memStore.On("GetTableShard", mock.Anything, 0).Run(func(args mock.Arguments) {
+			shard.Users.Add(1)
+		}).Return(shard, nil)
+	})
+
+	compile := func(timeFilter TimeFilter) *AQLQueryContext {
+		q := &AQLQuery{
+			Table:      "trips",
+			Measures:   []Measure{{Expr: "sum(fare)"}},
+			TimeFilter: timeFilter,
+		}
+		qc := q.Compile(memStore, false)
+		Ω(qc.Error).Should(BeNil())
+		return qc
+	}
+
+	ginkgo.It("accepts archived time ranges past retention", func() {
+		qc := compile(TimeFilter{Column: "request_at", From: "1555200000", To: "1556064000"})
+		from, to, ok := qc.ImmutableTimeRange(memStore, time.Unix(18050*day, 0))
+		Ω(ok).Should(BeTrue())
+		Ω(from).Should(Equal(18000 * day))
+		Ω(to).Should(Equal(18010 * day))
+	})
+
+	ginkgo.It("rejects time ranges which can still change", func() {
+		qc := compile(TimeFilter{Column: "request_at", From: "1555200000", To: "1556064000"})
+		// within retention.
+		_, _, ok := qc.ImmutableTimeRange(memStore, time.Unix(18030*day, 0))
+		Ω(ok).Should(BeFalse())
+
+		// not archived yet.
+		shard.ArchiveStore.CurrentVersion.ArchivingCutoff = uint32(18009 * day)
+		_, _, ok = qc.ImmutableTimeRange(memStore, time.Unix(18050*day, 0))
+		Ω(ok).Should(BeFalse())
+
+		// live window up to now.
+		utils.SetCurrentTime(time.Unix(18050*day, 0))
+		defer utils.ResetClockImplementation()
+		qc = compile(TimeFilter{Column: "request_at", From: "1555200000"})
+		_, _, ok = qc.ImmutableTimeRange(memStore, time.Unix(18050*day, 0))
+		Ω(ok).Should(BeFalse())
+
+		// no retention.
+		qc = compile(TimeFilter{Column: "request_at", From: "1555200000", To: "1555632000"})
+		schema.Schema.Config.RecordRetentionInDays = 0
+		_, _, ok = qc.ImmutableTimeRange(memStore, time.Unix(18050*day, 0))
+		Ω(ok).Should(BeFalse())
+
+		// dimension table.
+		schema.Schema.Config.RecordRetentionInDays = 30
+		schema.Schema.IsFactTable = false
+		_, _, ok = qc.ImmutableTimeRange(memStore, time.Unix(18050*day, 0))
+		Ω(ok).Should(BeFalse())
+	})
+
+	ginkgo.It("applies the same checks to archive only queries", func() {
+		utils.SetCurrentTime(time.Unix(18050*day, 0))
+		defer utils.ResetClockImplementation()
+		q := &AQLQuery{
+			Table:       "trips",
+			Measures:    []Measure{{Expr: "sum(fare)"}},
+			TimeFilter:  TimeFilter{Column: "request_at", From: "1555200000"},
+			Consistency: ConsistencyArchiveOnly,
+		}
+		qc := q.Compile(memStore, false)
+		Ω(qc.Error).Should(BeNil())
+		// past the archiving cutoff and within retention.
+		_, _, ok := qc.ImmutableTimeRange(memStore, time.Unix(18050*day, 0))
+		Ω(ok).Should(BeFalse())
+
+		q.TimeFilter.To = "1556064000"
+		qc = q.Compile(memStore, false)
+		Ω(qc.Error).Should(BeNil())
+		from, to, ok := qc.ImmutableTimeRange(memStore, time.Unix(18050*day, 0))
+		Ω(ok).Should(BeTrue())
+		Ω(from).Should(Equal(18000 * day))
+		Ω(to).Should(Equal(18010 * day))
+
+		// live only queries over archived time ranges past retention.
+		q = &AQLQuery{
+			Table:       "trips",
+			Measures:    []Measure{{Expr: "sum(fare)"}},
+			TimeFilter:  TimeFilter{Column: "request_at", From: "1555200000", To: "1556064000"},
+			Consistency: ConsistencyLiveOnly,
+		}
+		qc = q.Compile(memStore, false)
+		Ω(qc.Error).Should(BeNil())
+		_, _, ok = qc.ImmutableTimeRange(memStore, time.Unix(18050*day, 0))
+		Ω(ok).Should(BeFalse())
+	})
+
+	ginkgo.It("rejects union queries", func() {
+		q := &AQLQuery{
+			Tables:     []string{"trips", "trips_copy"},
+			Measures:   []Measure{{Expr: "sum(fare)"}},
+			TimeFilter: TimeFilter{Column: "request_at", From: "1555200000", To: "1556064000"},
+		}
+		qc := q.Compile(memStore, false)
+		Ω(qc.Error).Should(BeNil())
+		_, _, ok := qc.ImmutableTimeRange(memStore, time.Unix(18050*day, 0))
+		Ω(ok).Should(BeFalse())
+	})
+})
@@ -489,20 +489,84 @@ inline typename ConstantIterator<UUIDT>::type make_constant_iterator(
 using GeoSimpleIterator = thrust::constant_iterator<thrust::tuple<GeoPointT,
                                                                   bool>>;
 
+template<typename Value>
+class DimensionProxy {
+ public:
+  __host__ __device__
+  DimensionProxy(Value *value, bool *valid)
+      : value(value), valid(valid) {
+  }
+
+  __host__ __device__
+  DimensionProxy operator=(thrust::tuple<Value, bool> t) const {
+    // Null values are always written as zero so that all nulls of a dimension
+    // fall into the same group no matter what is left in the value bytes of
+    // the column.
+    *value = thrust::get<1>(t) ? thrust::get<0>(t) : Value();
+    *valid = thrust::get<1>(t);
+    return *this;
+  }
+
+ private:
+  Value *value;
+  bool *valid;
+};
+
 // DimensionOutputIterator is for writing individual dimension transform
 // results into a consecutive chunk of memory which later will be used
-// as the key for sort and reduce.
+// as the key for sort and reduce. Values and validities are written into
+// separate vectors.
 template<typename Value>
-using DimensionOutputIterator = thrust::zip_iterator<
-    thrust::tuple<thrust::detail::normal_iterator<Value *>,
-                  thrust::detail::normal_iterator<bool *> > >;
+class DimensionOutputIterator : public thrust::iterator_adaptor<
+    DimensionOutputIterator<Value>, Value *, thrust::tuple<Value, bool>,
+    thrust::use_default, thrust::use_default, DimensionProxy<Value>,
+    thrust::use_default> {
+ public:
+  friend class thrust::iterator_core_access;
+
+  // shorthand for the name of the iterator_adaptor we're deriving from.
+  typedef thrust::iterator_adaptor<DimensionOutputIterator<Value>,
+                                   Value *,
+                                   thrust::tuple<Value, bool>,
+                                   thrust::use_default,
+                                   thrust::use_default,
+                                   DimensionProxy<Value>,
+                                   thrust::use_default> super_t;
+
+  __host__ __device__
+  DimensionOutputIterator(Value *values, bool *valids)
+      : super_t(values), valids(valids) {}
+
+ private:
+  bool *valids;
+
+  __host__ __device__
+  typename super_t::reference dereference() const {
+    return DimensionProxy<Value>(this->base_reference(), valids);
+  }
+
+  __host__ __device__
+  void advance(typename super_t::difference_type n) {
+    this->base_reference() += n;
+    valids += n;
+  }
+
+  __host__ __device__
+  void increment() {
+    advance(1);
+  }
+
+  __host__ __device__
+  void decrement() {
+    advance(-1);
+  }
+};
 
 template<typename Value>
 DimensionOutputIterator<Value> make_dimension_output_iterator(
     uint8_t *dimValues, uint8_t *dimNulls) {
-  return thrust::make_zip_iterator(
-      thrust::make_tuple(reinterpret_cast<Value *>(dimValues),
-                         reinterpret_cast<bool *>(dimNulls)));
+  return DimensionOutputIterator<Value>(reinterpret_cast<Value *>(dimValues),
+                                        reinterpret_cast<bool *>(dimNulls));
 }
 
 template<typename Value>
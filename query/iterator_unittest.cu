@@ -403,6 +403,22 @@ TEST(DimensionOutputIteratorTest, CheckTransform) {
   EXPECT_EQ(true, out[5]);
 }
 
+// cppcheck-suppress *
+TEST(DimensionOutputIteratorTest, CheckNull) {
+  uint16_t in1[3] = {1, 2, 3};
+  bool in2[3] = {true, false, false};
+  thrust::zip_iterator<thrust::tuple<Uint16Iter, BoolIter>> zipped1(
+      thrust::make_tuple(std::begin(in1), std::begin(in2)));
+  uint8_t out[9] = {0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF};
+  DimensionOutputIterator<uint16_t> dim_iter =
+      make_dimension_output_iterator<uint16_t>(&out[0], &out[6]);
+  thrust::copy(zipped1, zipped1 + 3, dim_iter);
+  // Values of nulls are written as zero.
+  uint8_t expected[9] = {1, 0, 0, 0, 0, 0, 1, 0, 0};
+  EXPECT_TRUE(std::equal(std::begin(out), std::end(out),
+                         std::begin(expected)));
+}
+
 // cppcheck-suppress *
 TEST(MeasureIteratorTest, CheckSum) {
   uint32_t baseCounts[5] = {0, 3, 6, 9, 10};
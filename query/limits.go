@@ -0,0 +1,95 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"errors"
+
+	"github.com/uber/aresdb/utils"
+)
+
+var (
+	// ErrRowsScannedLimitExceeded is returned when a query would scan more rows than its limit.
+	ErrRowsScannedLimitExceeded = errors.New("Query exceeds the limit of rows scanned")
+	// ErrResultRowsLimitExceeded is returned when a query aggregates more groups than its limit.
+	ErrResultRowsLimitExceeded = errors.New("Query exceeds the limit of result rows")
+	// ErrDeviceMemoryLimitExceeded is returned when a query is estimated to use more device memory
+	// than the limit of a query.
+	ErrDeviceMemoryLimitExceeded = errors.New("Query exceeds the limit of device memory")
+)
+
+// QueryLimits protects the server from queries scanning or returning too many rows, 0 means no
+// limit. Queries exceeding their limits are aborted at batch boundaries.
+type QueryLimits struct {
+	// max number of rows of the main table scanned by the query, skipped batches are not counted.
+	MaxRowsScanned int `json:"maxRowsScanned,omitempty"`
+	// max number of groups aggregated by the query, before the limit of the query is applied.
+	MaxResultRows int `json:"maxResultRows,omitempty"`
+	// max number of records of a dimension table joined by the query, checked before any batch
+	// is scanned.
+	MaxJoinTableRecords int `json:"maxJoinTableRecords,omitempty"`
+}
+
+// LimitExceeded tells whether the query is aborted for exceeding its limits.
+func (qc *AQLQueryContext) LimitExceeded() bool {
+	return qc.limitExceeded
+}
+
+// RowsScanned returns the number of rows of the batches scanned by the query so far.
+func (qc *AQLQueryContext) RowsScanned() int {
+	return qc.rowsScanned
+}
+
+// checkLimits tells whether scanning the next batch with numRows rows would exceed the limits of
+// the query, in which case the query error is set. Otherwise the rows are counted as scanned.
+// Result rows only grow from batch to batch, so a query aggregating too many groups is also
+// aborted before scanning the rest of its batches.
+func (qc *AQLQueryContext) checkLimits(numRows int) bool {
+	if qc.checkResultRows() {
+		return true
+	}
+	if maxRows := qc.Limits.MaxRowsScanned; maxRows > 0 && qc.rowsScanned+numRows > maxRows {
+		qc.Error = utils.StackError(ErrRowsScannedLimitExceeded,
+			"Query would scan more than %d rows, %d rows scanned before the batch of %d rows",
+			maxRows, qc.rowsScanned, numRows)
+		qc.limitExceeded = true
+		return true
+	}
+	qc.rowsScanned += numRows
+	return false
+}
+
+// checkResultRows tells whether the result of the processed batches has more groups than the
+// limit of the query, in which case the query error is set. It's also checked after the last batch.
+func (qc *AQLQueryContext) checkResultRows() bool {
+	resultRows := qc.OOPK.currentBatch.resultSize
+	if resultRows > qc.resultRows {
+		qc.resultRows = resultRows
+	}
+	if maxRows := qc.Limits.MaxResultRows; maxRows > 0 && resultRows > maxRows {
+		qc.Error = utils.StackError(ErrResultRowsLimitExceeded,
+			"Query aggregates %d groups, exceeding the limit of %d", resultRows, maxRows)
+		qc.limitExceeded = true
+		return true
+	}
+	return false
+}
+
+// profileLimits records how close the query came to its limits in the profile.
+func (qc *AQLQueryContext) profileLimits() {
+	if qc.Profile != nil {
+		qc.Profile.recordLimits(qc.rowsScanned, qc.resultRows, qc.Limits)
+	}
+}
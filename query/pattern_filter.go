@@ -0,0 +1,164 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+
+	"github.com/uber/aresdb/query/expr"
+	"github.com/uber/aresdb/utils"
+)
+
+const (
+	// max length of LIKE and REGEXP patterns.
+	maxPatternLength = 1024
+	// max number of instructions of the compiled pattern, repetitions like a{1000}
+	// blow up the program even when the pattern itself is short.
+	maxPatternProgramSize = 10000
+)
+
+func isPatternMatchOp(op expr.Token) bool {
+	switch op {
+	case expr.LIKE, expr.NOT_LIKE, expr.ILIKE, expr.NOT_ILIKE, expr.REGEXP, expr.NOT_REGEXP:
+		return true
+	}
+	return false
+}
+
+// expandPatternMatch rewrites enum LIKE 'pattern', enum ILIKE 'pattern' and enum REGEXP 'pattern'
+// (and their NOT forms, whose negation is added by the caller) to enum IN (matched enum cases).
+// The pattern is compiled once and matched against each case of the enum dictionary, so no
+// string matching happens on device.
+//
+// LIKE matches the whole enum case case-sensitively, where % matches any sequence of characters,
+// _ matches any single character and \ (written as \\ in string literals) escapes the next
+// character. ILIKE is the case-insensitive form of LIKE. REGEXP matches if any part of the enum
+// case matches the RE2 regular expression, it's case-sensitive unless the pattern starts with
+// the (?i) flag.
+func (qc *AQLQueryContext) expandPatternMatch(e *expr.BinaryExpr) expr.Expr {
+	lhs, ok := e.LHS.(*expr.VarRef)
+	if !ok || lhs.EnumDict == nil {
+		qc.Error = utils.StackError(nil, "lhs of %s must be an enum column: %s", e.Op, e.String())
+		return e
+	}
+	rhs, ok := e.RHS.(*expr.StringLiteral)
+	if !ok {
+		qc.Error = utils.StackError(nil, "rhs of %s must be a string: %s", e.Op, e.String())
+		return e
+	}
+
+	re, err := compilePattern(e.Op, rhs.Val)
+	if err != nil {
+		qc.Error = err
+		return e
+	}
+
+	var expandedExpr expr.Expr
+	for enumID, enumCase := range lhs.EnumReverseDict {
+		if !re.MatchString(enumCase) {
+			continue
+		}
+		equal := &expr.BinaryExpr{
+			Op:       expr.EQ,
+			LHS:      lhs,
+			RHS:      &expr.NumberLiteral{Val: float64(enumID), Int: enumID, Expr: strconv.Itoa(enumID), ExprType: expr.Unsigned},
+			ExprType: expr.Boolean,
+		}
+		if expandedExpr == nil {
+			expandedExpr = equal
+		} else {
+			expandedExpr = &expr.BinaryExpr{
+				Op:       expr.OR,
+				LHS:      expandedExpr,
+				RHS:      equal,
+				ExprType: expr.Boolean,
+			}
+		}
+	}
+
+	if expandedExpr == nil {
+		// Same as comparing against a missing enum case.
+		expandedExpr = &expr.BinaryExpr{
+			Op:       expr.EQ,
+			LHS:      lhs,
+			RHS:      &expr.NumberLiteral{Val: -1, Int: -1, Expr: "-1", ExprType: expr.Unsigned},
+			ExprType: expr.Boolean,
+		}
+	}
+	return expandedExpr
+}
+
+// compilePattern compiles the LIKE, ILIKE or REGEXP pattern into a regular expression.
+func compilePattern(op expr.Token, pattern string) (*regexp.Regexp, error) {
+	if len(pattern) > maxPatternLength {
+		return nil, utils.StackError(nil, "%s pattern is longer than %d characters", op, maxPatternLength)
+	}
+
+	switch op {
+	case expr.LIKE, expr.NOT_LIKE:
+		pattern = likeToRegexp(pattern, false)
+	case expr.ILIKE, expr.NOT_ILIKE:
+		pattern = likeToRegexp(pattern, true)
+	}
+
+	parsed, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, utils.StackError(err, "invalid %s pattern %s", op, pattern)
+	}
+	prog, err := syntax.Compile(parsed.Simplify())
+	if err != nil {
+		return nil, utils.StackError(err, "invalid %s pattern %s", op, pattern)
+	}
+	if len(prog.Inst) > maxPatternProgramSize {
+		return nil, utils.StackError(nil, "%s pattern %s is too complex", op, pattern)
+	}
+	return regexp.Compile(pattern)
+}
+
+// likeToRegexp translates the LIKE pattern into an anchored regular expression.
+func likeToRegexp(pattern string, caseInsensitive bool) string {
+	var builder strings.Builder
+	builder.WriteString("^(?s")
+	if caseInsensitive {
+		builder.WriteString("i")
+	}
+	builder.WriteString(")")
+
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			builder.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			builder.WriteString(".*")
+		case r == '_':
+			builder.WriteString(".")
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	if escaped {
+		// A trailing backslash matches itself.
+		builder.WriteString(regexp.QuoteMeta("\\"))
+	}
+	builder.WriteString("$")
+	return builder.String()
+}
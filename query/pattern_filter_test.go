@@ -0,0 +1,119 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"strings"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/memstore"
+	memCom "github.com/uber/aresdb/memstore/common"
+	"github.com/uber/aresdb/memstore/mocks"
+	metaCom "github.com/uber/aresdb/metastore/common"
+)
+
+var _ = ginkgo.Describe("pattern filter", func() {
+	var store *mocks.MemStore
+
+	ginkgo.BeforeEach(func() {
+		store = new(mocks.MemStore)
+		store.On("RLock").Return()
+		store.On("RUnlock").Return()
+		store.On("GetSchemas").Return(map[string]*memstore.TableSchema{
+			"api_cities": {
+				Schema: metaCom.Table{
+					Name: "api_cities",
+					Columns: []metaCom.Column{
+						{Name: "id", Type: metaCom.Uint16},
+						{Name: "name", Type: metaCom.BigEnum},
+					},
+				},
+				ColumnIDs:         map[string]int{"id": 0, "name": 1},
+				ValueTypeByColumn: []memCom.DataType{memCom.Uint16, memCom.BigEnum},
+				EnumDicts: map[string]memstore.EnumDict{
+					"name": {
+						Dict:        map[string]int{"San Francisco": 0, "san jose": 1, "Paris": 2, "50%_off": 3},
+						ReverseDict: []string{"San Francisco", "san jose", "Paris", "50%_off"},
+					},
+				},
+			},
+		})
+	})
+
+	compileFilter := func(filter string) *AQLQueryContext {
+		q := &AQLQuery{
+			Table:    "api_cities",
+			Measures: []Measure{{Expr: "count(1)"}},
+			Filters:  []string{filter},
+		}
+		return q.Compile(store, false)
+	}
+
+	ginkgo.It("matches enum cases against LIKE patterns", func() {
+		tests := map[string]string{
+			// anchored and case-sensitive.
+			"name LIKE 'San%'":      "name = 0",
+			"name LIKE 'san'":       "name = -1",
+			"name LIKE '%jose'":     "name = 1",
+			"name LIKE '_aris'":     "name = 2",
+			"name LIKE '%a%'":       "name = 0 OR name = 1 OR name = 2",
+			`name LIKE '50\\%\\_%'`: "name = 3",
+			`name LIKE '50_\\_%'`:   "name = 3",
+			"name ILIKE 'san%'":     "name = 0 OR name = 1",
+			"name NOT LIKE 'San%'":  "NOT(name = 0)",
+			"name NOT ILIKE 'x%'":   "NOT(name = -1)",
+		}
+		for filter, expected := range tests {
+			qc := compileFilter(filter)
+			Ω(qc.Error).Should(BeNil(), filter)
+			Ω(qc.OOPK.MainTableCommonFilters).Should(HaveLen(1), filter)
+			Ω(qc.OOPK.MainTableCommonFilters[0].String()).Should(Equal(expected), filter)
+		}
+	})
+
+	ginkgo.It("matches enum cases against REGEXP patterns", func() {
+		tests := map[string]string{
+			// not anchored unless specified.
+			"name REGEXP 'an'":             "name = 0 OR name = 1",
+			"name REGEXP '^San'":           "name = 0",
+			"name REGEXP '(?i)^san'":       "name = 0 OR name = 1",
+			"name REGEXP '^[0-9]+%'":       "name = 3",
+			"name NOT REGEXP 'is$'":        "NOT(name = 2)",
+			"name REGEXP 'ris$' OR id = 1": "name = 2 OR id = 1",
+		}
+		for filter, expected := range tests {
+			qc := compileFilter(filter)
+			Ω(qc.Error).Should(BeNil(), filter)
+			Ω(qc.OOPK.MainTableCommonFilters).Should(HaveLen(1), filter)
+			Ω(qc.OOPK.MainTableCommonFilters[0].String()).Should(Equal(expected), filter)
+		}
+	})
+
+	ginkgo.It("rejects invalid patterns", func() {
+		tests := map[string]string{
+			"name REGEXP '(abc'": "invalid REGEXP pattern (abc",
+			"name REGEXP '" + strings.Repeat("a{1000}", 11) + "'": "is too complex",
+			"name LIKE '" + strings.Repeat("a", 1025) + "'":       "LIKE pattern is longer than 1024 characters",
+			"id LIKE '1%'":   "lhs of LIKE must be an enum column",
+			"name REGEXP id": "rhs of REGEXP must be a string",
+		}
+		for filter, expected := range tests {
+			qc := compileFilter(filter)
+			Ω(qc.Error).ShouldNot(BeNil(), filter)
+			Ω(qc.Error.Error()).Should(ContainSubstring(expected), filter)
+		}
+	})
+})
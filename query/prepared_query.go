@@ -0,0 +1,233 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/uber/aresdb/memstore"
+	memCom "github.com/uber/aresdb/memstore/common"
+	"github.com/uber/aresdb/query/expr"
+	"github.com/uber/aresdb/utils"
+)
+
+// PreparedQuery is an AQL query template whose row filters reference parameters,
+// e.g. "city_id = $city_id". The filters are parsed once when the query is prepared,
+// every execution only binds the parameter values into a copy of the parsed filters.
+type PreparedQuery struct {
+	// Query template.
+	Query AQLQuery `json:"query"`
+	// Parameters maps each parameter name to the column it is compared against.
+	Parameters map[string]string `json:"parameters"`
+
+	filters        []expr.Expr
+	measureFilters [][]expr.Expr
+}
+
+// Prepare parses the row filters and measure row filters of the query template
+// and finds the column each parameter is compared against. Parameters can only
+// be used as a side of comparisons or in the list of IN against a column.
+func (q AQLQuery) Prepare() (*PreparedQuery, error) {
+	pq := &PreparedQuery{
+		Query:          q,
+		Parameters:     make(map[string]string),
+		filters:        make([]expr.Expr, len(q.Filters)),
+		measureFilters: make([][]expr.Expr, len(q.Measures)),
+	}
+
+	var err error
+	for i, filter := range q.Filters {
+		if pq.filters[i], err = pq.parseFilter(filter); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, measure := range q.Measures {
+		pq.measureFilters[i] = make([]expr.Expr, len(measure.Filters))
+		for j, filter := range measure.Filters {
+			if pq.measureFilters[i][j], err = pq.parseFilter(filter); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return pq, nil
+}
+
+func (pq *PreparedQuery) parseFilter(filter string) (expr.Expr, error) {
+	filterExpr, err := expr.ParseExpr(filter)
+	if err != nil {
+		return nil, utils.StackError(err, "Failed to parse filter %s", filter)
+	}
+
+	expr.WalkFunc(filterExpr, func(e expr.Expr) {
+		if err != nil {
+			return
+		}
+		binaryExpr, ok := e.(*expr.BinaryExpr)
+		if !ok {
+			return
+		}
+		switch binaryExpr.Op {
+		case expr.EQ, expr.NEQ, expr.LT, expr.LTE, expr.GT, expr.GTE:
+			if column, ok := binaryExpr.LHS.(*expr.VarRef); ok {
+				err = pq.addParameter(binaryExpr.RHS, column.Val)
+			} else if column, ok := binaryExpr.RHS.(*expr.VarRef); ok {
+				err = pq.addParameter(binaryExpr.LHS, column.Val)
+			}
+		case expr.IN, expr.NOT_IN:
+			column, ok := binaryExpr.LHS.(*expr.VarRef)
+			list, isList := binaryExpr.RHS.(*expr.Call)
+			if ok && isList {
+				for _, arg := range list.Args {
+					if err = pq.addParameter(arg, column.Val); err != nil {
+						return
+					}
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	expr.WalkFunc(filterExpr, func(e expr.Expr) {
+		if param, ok := e.(*expr.BoundParameter); ok && err == nil && pq.Parameters[param.Name] == "" {
+			err = utils.StackError(nil, "Parameter $%s in filter %s must be compared with a column", param.Name, filter)
+		}
+	})
+	return filterExpr, err
+}
+
+// addParameter records the column of the parameter if e is a parameter.
+func (pq *PreparedQuery) addParameter(e expr.Expr, column string) error {
+	param, ok := e.(*expr.BoundParameter)
+	if !ok {
+		return nil
+	}
+	if existing, ok := pq.Parameters[param.Name]; ok && existing != column {
+		return utils.StackError(nil, "Parameter $%s is compared with both column %s and %s",
+			param.Name, existing, column)
+	}
+	pq.Parameters[param.Name] = column
+	return nil
+}
+
+// Bind returns a copy of the query template with the parameter values bound into its filters.
+// Values are type checked against the current schema of the columns they are compared against.
+// Enum and uuid columns take string values, boolean columns take boolean values and numeric
+// columns take numbers.
+func (pq *PreparedQuery) Bind(store memstore.MemStore, parameters map[string]interface{}) (*AQLQuery, error) {
+	for name := range parameters {
+		if _, ok := pq.Parameters[name]; !ok {
+			return nil, utils.StackError(nil, "Unknown parameter $%s", name)
+		}
+	}
+
+	qc := &AQLQueryContext{Query: &pq.Query}
+	qc.readSchema(store)
+	defer qc.releaseSchema()
+	if qc.Error != nil {
+		return nil, qc.Error
+	}
+
+	literals := make(map[string]expr.Expr, len(pq.Parameters))
+	for name, column := range pq.Parameters {
+		value, ok := parameters[name]
+		if !ok {
+			return nil, utils.StackError(nil, "Missing value for parameter $%s", name)
+		}
+		tableID, columnID, err := qc.resolveColumn(column)
+		if err != nil {
+			return nil, utils.StackError(err, "Failed to resolve column of parameter $%s", name)
+		}
+		dataType := qc.TableScanners[tableID].Schema.ValueTypeByColumn[columnID]
+		if literals[name] = parameterLiteral(value, dataType); literals[name] == nil {
+			return nil, utils.StackError(nil, "Parameter $%s expects a %s value for column %s, got %v",
+				name, memCom.DataTypeName[dataType], column, value)
+		}
+	}
+
+	bind := func(e expr.Expr) expr.Expr {
+		return expr.RewriteFunc(expr.CloneExpr(e), func(e expr.Expr) expr.Expr {
+			if param, ok := e.(*expr.BoundParameter); ok {
+				return expr.CloneExpr(literals[param.Name])
+			}
+			return e
+		})
+	}
+
+	// Copy all slices since they are written during compilation.
+	query := pq.Query
+	query.Joins = append([]Join(nil), pq.Query.Joins...)
+	query.Dimensions = append([]Dimension(nil), pq.Query.Dimensions...)
+	query.Filters = make([]string, len(pq.filters))
+	query.filters = make([]expr.Expr, len(pq.filters))
+	for i, filter := range pq.filters {
+		query.filters[i] = bind(filter)
+		query.Filters[i] = query.filters[i].String()
+	}
+	query.Measures = append([]Measure(nil), pq.Query.Measures...)
+	for i, measureFilters := range pq.measureFilters {
+		measure := &query.Measures[i]
+		measure.Filters = make([]string, len(measureFilters))
+		measure.filters = make([]expr.Expr, len(measureFilters))
+		for j, filter := range measureFilters {
+			measure.filters[j] = bind(filter)
+			measure.Filters[j] = measure.filters[j].String()
+		}
+	}
+	query.filtersParsed = true
+	return &query, nil
+}
+
+// parameterLiteral converts the parameter value into a literal comparable with the data type,
+// returns nil if the value does not match the data type.
+func parameterLiteral(value interface{}, dataType memCom.DataType) expr.Expr {
+	switch dataType {
+	case memCom.SmallEnum, memCom.BigEnum, memCom.UUID:
+		if str, ok := value.(string); ok {
+			return &expr.StringLiteral{Val: str}
+		}
+		return nil
+	}
+
+	switch DataTypeToExprType[dataType] {
+	case expr.Boolean:
+		if b, ok := value.(bool); ok {
+			return &expr.BooleanLiteral{Val: b}
+		}
+	case expr.Unsigned, expr.Signed, expr.Float:
+		number, ok := value.(float64)
+		if !ok {
+			return nil
+		}
+		literal := &expr.NumberLiteral{Val: number, Int: int(number), Expr: strconv.FormatFloat(number, 'f', -1, 64)}
+		if number != math.Trunc(number) {
+			literal.ExprType = expr.Float
+		} else if number >= 0 {
+			literal.ExprType = expr.Unsigned
+		} else {
+			literal.ExprType = expr.Signed
+		}
+
+		if (literal.ExprType == expr.Float && DataTypeToExprType[dataType] != expr.Float) ||
+			(literal.ExprType == expr.Signed && DataTypeToExprType[dataType] == expr.Unsigned) {
+			return nil
+		}
+		return literal
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/memstore"
+	memCom "github.com/uber/aresdb/memstore/common"
+	"github.com/uber/aresdb/memstore/mocks"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	"github.com/uber/aresdb/query/expr"
+)
+
+var _ = ginkgo.Describe("prepared query", func() {
+	var store *mocks.MemStore
+
+	ginkgo.BeforeEach(func() {
+		store = new(mocks.MemStore)
+		store.On("RLock").Return()
+		store.On("RUnlock").Return()
+		store.On("GetSchemas").Return(map[string]*memstore.TableSchema{
+			"api_cities": {
+				Schema: metaCom.Table{
+					Name: "api_cities",
+					Columns: []metaCom.Column{
+						{Name: "id", Type: metaCom.Uint16},
+						{Name: "name", Type: metaCom.BigEnum},
+						{Name: "active", Type: metaCom.Bool},
+						{Name: "score", Type: metaCom.Float32},
+					},
+				},
+				ColumnIDs: map[string]int{
+					"id":     0,
+					"name":   1,
+					"active": 2,
+					"score":  3,
+				},
+				ValueTypeByColumn: []memCom.DataType{memCom.Uint16, memCom.BigEnum, memCom.Bool, memCom.Float32},
+				EnumDicts: map[string]memstore.EnumDict{
+					"name": {
+						Dict:        map[string]int{"paris": 0},
+						ReverseDict: []string{"paris"},
+					},
+				},
+			},
+		})
+	})
+
+	ginkgo.It("binds parameters into parsed filters", func() {
+		pq, err := AQLQuery{
+			Table:    "api_cities",
+			Measures: []Measure{{Expr: "count(1)", Filters: []string{"active = $active"}}},
+			Filters:  []string{"id IN ($id1, $id2)", "name = $name", "$min_score < score"},
+		}.Prepare()
+		Ω(err).Should(BeNil())
+		Ω(pq.Parameters).Should(Equal(map[string]string{
+			"active":    "active",
+			"id1":       "id",
+			"id2":       "id",
+			"name":      "name",
+			"min_score": "score",
+		}))
+
+		parameters := map[string]interface{}{
+			"active":    true,
+			"id1":       float64(1),
+			"id2":       float64(2),
+			"name":      "paris",
+			"min_score": 0.5,
+		}
+		query, err := pq.Bind(store, parameters)
+		Ω(err).Should(BeNil())
+		Ω(query.Filters).Should(Equal([]string{"id IN (1, 2)", "name = 'paris'", "0.5 < score"}))
+		Ω(query.Measures[0].Filters).Should(Equal([]string{"active = true"}))
+		Ω(query.filters[1]).Should(Equal(&expr.BinaryExpr{
+			Op:  expr.EQ,
+			LHS: &expr.VarRef{Val: "name"},
+			RHS: &expr.StringLiteral{Val: "paris"},
+		}))
+		// The template is not changed by binding.
+		Ω(pq.Query.Filters[1]).Should(Equal("name = $name"))
+		Ω(pq.filters[1].String()).Should(Equal("name = $name"))
+
+		qc := query.Compile(store, false)
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.OOPK.MainTableCommonFilters).Should(HaveLen(4))
+
+		// Binding again with other values.
+		parameters["name"] = "london"
+		query, err = pq.Bind(store, parameters)
+		Ω(err).Should(BeNil())
+		Ω(query.Filters[1]).Should(Equal("name = 'london'"))
+	})
+
+	ginkgo.It("rejects invalid parameters", func() {
+		_, err := AQLQuery{Table: "api_cities", Filters: []string{"$id = $id2"}}.Prepare()
+		Ω(err.Error()).Should(ContainSubstring("Parameter $id in filter $id = $id2 must be compared with a column"))
+
+		_, err = AQLQuery{Table: "api_cities", Filters: []string{"id = $id", "score = $id"}}.Prepare()
+		Ω(err.Error()).Should(ContainSubstring("Parameter $id is compared with both column id and score"))
+
+		_, err = AQLQuery{Table: "api_cities", Filters: []string{"id = $"}}.Prepare()
+		Ω(err.Error()).Should(ContainSubstring("Failed to parse filter id = $"))
+
+		pq, err := AQLQuery{Table: "api_cities", Filters: []string{"id = $id", "name = $name"}}.Prepare()
+		Ω(err).Should(BeNil())
+
+		_, err = pq.Bind(store, map[string]interface{}{"id": float64(1)})
+		Ω(err.Error()).Should(ContainSubstring("Missing value for parameter $name"))
+
+		_, err = pq.Bind(store, map[string]interface{}{"id": float64(1), "name": "paris", "foo": 1})
+		Ω(err.Error()).Should(ContainSubstring("Unknown parameter $foo"))
+
+		_, err = pq.Bind(store, map[string]interface{}{"id": float64(1), "name": float64(1)})
+		Ω(err.Error()).Should(ContainSubstring("Parameter $name expects a BigEnum value for column name, got 1"))
+
+		_, err = pq.Bind(store, map[string]interface{}{"id": float64(-1), "name": "paris"})
+		Ω(err.Error()).Should(ContainSubstring("Parameter $id expects a Uint16 value for column id, got -1"))
+
+		_, err = pq.Bind(store, map[string]interface{}{"id": 1.5, "name": "paris"})
+		Ω(err.Error()).Should(ContainSubstring("Parameter $id expects a Uint16 value for column id, got 1.5"))
+
+		pq, err = AQLQuery{Table: "api_cities", Filters: []string{"city = $city"}}.Prepare()
+		Ω(err).Should(BeNil())
+		_, err = pq.Bind(store, map[string]interface{}{"city": "paris"})
+		Ω(err.Error()).Should(ContainSubstring("Failed to resolve column of parameter $city"))
+	})
+
+	ginkgo.It("rejects parameters in queries not prepared", func() {
+		query := &AQLQuery{
+			Table:    "api_cities",
+			Measures: []Measure{{Expr: "count(1)"}},
+			Filters:  []string{"id = $id"},
+		}
+		qc := query.Compile(store, false)
+		Ω(qc.Error.Error()).Should(ContainSubstring("parameter $id can only be used in prepared queries"))
+	})
+})
@@ -0,0 +1,125 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"sync"
+	"time"
+
+	"github.com/uber/aresdb/utils"
+)
+
+// Executor stages reported in query profiles.
+const (
+	ProfileStageScan      = "scan"
+	ProfileStageFilter    = "filter"
+	ProfileStageAggregate = "aggregate"
+	ProfileStageSort      = "sort"
+	ProfileStageSerialize = "serialize"
+)
+
+// profileStageByTiming maps the timing stages of the processor to executor stages.
+var profileStageByTiming = map[stageName]string{
+	prepareForeignTableTiming:     ProfileStageScan,
+	transferTiming:                ProfileStageScan,
+	prepareForFilteringTiming:     ProfileStageScan,
+	initIndexVectorTiming:         ProfileStageFilter,
+	filterEvalTiming:              ProfileStageFilter,
+	prepareForeignRecordIDsTiming: ProfileStageFilter,
+	foreignTableFilterEvalTiming:  ProfileStageFilter,
+	geoIntersectEvalTiming:        ProfileStageFilter,
+	prepareForDimAndMeasureTiming: ProfileStageAggregate,
+	dimEvalTiming:                 ProfileStageAggregate,
+	measureEvalTiming:             ProfileStageAggregate,
+	hllEvalTiming:                 ProfileStageAggregate,
+	reduceEvalTiming:              ProfileStageAggregate,
+	rowCollectTiming:              ProfileStageAggregate,
+	cleanupTiming:                 ProfileStageAggregate,
+	resultTransferTiming:          ProfileStageAggregate,
+	finalCleanupTiming:            ProfileStageAggregate,
+	sortEvalTiming:                ProfileStageSort,
+}
+
+// QueryProfile records the wall-clock time a query spends in each executor stage and how close it
+// came to its limits. Sub queries of the query share its profile.
+type QueryProfile struct {
+	sync.Mutex
+	// Milliseconds spent in each stage, summed over all batches.
+	Stages map[string]float64 `json:"stages"`
+	// Rows scanned and result groups of the query against its limits.
+	RowsScanned QueryLimitUsage `json:"rowsScanned"`
+	ResultRows  QueryLimitUsage `json:"resultRows"`
+}
+
+// QueryLimitUsage tells how close a query came to one of its limits.
+type QueryLimitUsage struct {
+	Used int `json:"used"`
+	// 0 means no limit.
+	Limit int `json:"limit,omitempty"`
+}
+
+// NewQueryProfile creates an empty QueryProfile.
+func NewQueryProfile() *QueryProfile {
+	return &QueryProfile{Stages: make(map[string]float64)}
+}
+
+// Record adds the duration to the stage.
+func (p *QueryProfile) Record(stage string, duration time.Duration) {
+	p.add(stage, duration.Seconds()*1000)
+}
+
+func (p *QueryProfile) add(stage string, milliseconds float64) {
+	p.Lock()
+	p.Stages[stage] += milliseconds
+	p.Unlock()
+}
+
+// recordLimits records the rows scanned and result groups of a query, the rows scanned by sub
+// queries are summed up.
+func (p *QueryProfile) recordLimits(rowsScanned, resultRows int, limits QueryLimits) {
+	p.Lock()
+	p.RowsScanned.Used += rowsScanned
+	p.RowsScanned.Limit = limits.MaxRowsScanned
+	if resultRows > p.ResultRows.Used {
+		p.ResultRows.Used = resultRows
+	}
+	p.ResultRows.Limit = limits.MaxResultRows
+	p.Unlock()
+}
+
+// Report emits the time of each stage to the stage latency metric of the table.
+func (p *QueryProfile) Report(table string) {
+	p.Lock()
+	defer p.Unlock()
+	for stage, milliseconds := range p.Stages {
+		utils.GetRootReporter().GetChildTimer(map[string]string{
+			"table": table,
+			"stage": stage,
+		}, utils.QueryStageLatency).Record(time.Duration(milliseconds * float64(time.Millisecond)))
+	}
+}
+
+// recordsTimings tells whether the processor needs to wait for each stage to record its timing,
+// which is the case for debugged, profiled and traced queries.
+func (qc *AQLQueryContext) recordsTimings() bool {
+	return qc.Debug || qc.Profile != nil || qc.traced
+}
+
+// profileTiming adds the timing of a processor stage to the profile if profiling is enabled.
+func (qc *AQLQueryContext) profileTiming(name stageName, milliseconds float64) {
+	if qc.Profile != nil {
+		qc.Profile.add(profileStageByTiming[name], milliseconds)
+	}
+}
@@ -0,0 +1,150 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"sort"
+	"unsafe"
+
+	"github.com/uber/aresdb/memstore"
+	"github.com/uber/aresdb/memutils"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+const (
+	// MaxRowQueryLimit is the max limit of row queries, which return rows instead of groups.
+	MaxRowQueryLimit = 10000
+	// RowResultHeaders is the key of the selected columns in the result of row queries.
+	RowResultHeaders = "headers"
+	// RowResultMatrixData is the key of the rows in the result of row queries. Each row is the
+	// list of the selected column values in the same format as dimension values, nil for NULLs.
+	RowResultMatrixData = "matrixData"
+)
+
+// compileRowQuery compiles the query selecting rows of columns with the selected columns as
+// dimensions, so that matching rows are selected by the same filters and joins on device. Rows
+// are not aggregated: processBatch copies the dimension values of each batch to host instead of
+// reducing them, and collectRows keeps at most limit rows, so identical rows are all returned.
+func (q *AQLQuery) compileRowQuery(store memstore.MemStore, returnHLL bool) *AQLQueryContext {
+	invalid := func(format string, args ...interface{}) *AQLQueryContext {
+		return &AQLQueryContext{Query: q, ReturnHLLData: returnHLL, Error: utils.StackError(nil, format, args...)}
+	}
+	switch {
+	case returnHLL:
+		return invalid("row queries are not supported when client specify 'Accept' as 'application/hll'")
+	case len(q.Dimensions) > 0 || len(q.Measures) > 0:
+		return invalid("row queries select columns instead of dimensions and measures")
+	case q.Having != "":
+		return invalid("having is not supported for row queries")
+	case q.isPaginated():
+		return invalid("pagination is not supported for row queries")
+	case q.Limit <= 0 || q.Limit > MaxRowQueryLimit:
+		return invalid("row queries require limit between 1 and %d, got %d", MaxRowQueryLimit, q.Limit)
+	}
+
+	selected := make(map[string]bool, len(q.Select))
+	for _, column := range q.Select {
+		if column == "" || selected[column] {
+			return invalid("invalid select column '%s'", column)
+		}
+		selected[column] = true
+	}
+	for _, sortField := range q.Sorts {
+		if !selected[sortField.Expr] {
+			return invalid("sort %s is not a selected column", sortField.Expr)
+		}
+	}
+
+	rowQuery := *q
+	rowQuery.Select = nil
+	rowQuery.Dimensions = make([]Dimension, len(q.Select))
+	for i, column := range q.Select {
+		rowQuery.Dimensions[i] = Dimension{Expr: column}
+	}
+	// the measure is required to compile the query but is not evaluated.
+	rowQuery.Measures = []Measure{{Expr: "count(*)"}}
+	qc := rowQuery.Compile(store, false)
+	qc.rowColumns = q.Select
+	return qc
+}
+
+// collectRows reads the dimension values of the rows of the current batch and keeps the top rows
+// by the sorts of the query, or the first rows scanned without sorts.
+func (qc *AQLQueryContext) collectRows(stream unsafe.Pointer) {
+	bc := &qc.OOPK.currentBatch
+	if bc.size == 0 || qc.rowsCollected() {
+		return
+	}
+	dimensionVectorH := memutils.HostAlloc(bc.size * qc.OOPK.DimRowBytes)
+	defer memutils.HostFree(dimensionVectorH)
+	asyncCopyDimensionVector(dimensionVectorH, bc.dimensionVectorD[0].getPointer(), bc.size,
+		qc.OOPK.NumDimsPerDimWidth, bc.size, bc.resultCapacity,
+		memutils.AsyncCopyDeviceToHost, stream, qc.Device)
+	memutils.WaitForCudaStream(stream, qc.Device)
+	qc.addRows(qc.readRows(dimensionVectorH, bc.size))
+}
+
+// readRows reads size rows from the dimension vector on host with capacity size.
+func (qc *AQLQueryContext) readRows(dimensionVectorH unsafe.Pointer, size int) []topNGroup {
+	reader := qc.newDimensionReader(dimensionVectorH, size)
+	rows := make([]topNGroup, size)
+	for i := range rows {
+		rows[i].dimValues = make([]string, len(qc.OOPK.Dimensions))
+		reader.read(i, rows[i].dimValues)
+	}
+	return rows
+}
+
+// addRows adds the rows of a batch to the collected rows, of which at most limit are kept.
+func (qc *AQLQueryContext) addRows(rows []topNGroup) {
+	if len(qc.topN.keys) == 0 {
+		if remaining := qc.topN.limit - len(qc.rows); len(rows) > remaining {
+			rows = rows[:remaining]
+		}
+		qc.rows = append(qc.rows, rows...)
+		return
+	}
+	qc.rows = append(qc.rows, rows...)
+	sort.SliceStable(qc.rows, func(i, j int) bool {
+		return qc.topN.less(qc.rows[i], qc.rows[j])
+	})
+	if len(qc.rows) > qc.topN.limit {
+		qc.rows = qc.rows[:qc.topN.limit]
+	}
+}
+
+// rowsCollected tells whether the row query has collected enough rows to stop scanning, which is
+// only known before the scan completes when the query has no sorts.
+func (qc *AQLQueryContext) rowsCollected() bool {
+	return qc.rowColumns != nil && len(qc.topN.keys) == 0 && len(qc.rows) >= qc.topN.limit
+}
+
+// postprocessRows converts the collected rows into rows of the selected columns.
+func (qc *AQLQueryContext) postprocessRows() queryCom.AQLTimeSeriesResult {
+	rows := make([][]interface{}, len(qc.rows))
+	for i, row := range qc.rows {
+		rows[i] = make([]interface{}, len(row.dimValues))
+		for j, value := range row.dimValues {
+			if value != "NULL" {
+				rows[i][j] = value
+			}
+		}
+	}
+	return queryCom.AQLTimeSeriesResult{
+		RowResultHeaders:    qc.rowColumns,
+		RowResultMatrixData: rows,
+	}
+}
@@ -0,0 +1,172 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+	"unsafe"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	"github.com/uber/aresdb/memstore"
+	memCom "github.com/uber/aresdb/memstore/common"
+	memMocks "github.com/uber/aresdb/memstore/mocks"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("row query", func() {
+	var memStore *memMocks.MemStore
+
+	ginkgo.BeforeEach(func() {
+		schema := &memstore.TableSchema{
+			Schema: metaCom.Table{
+				Name:        "trips",
+				IsFactTable: true,
+				Columns: []metaCom.Column{
+					{Name: "request_at", Type: metaCom.Uint32},
+					{Name: "city_id", Type: metaCom.Uint16},
+					{Name: "fare", Type: metaCom.Float32},
+				},
+			},
+			ColumnIDs:         map[string]int{"request_at": 0, "city_id": 1, "fare": 2},
+			ValueTypeByColumn: []memCom.DataType{memCom.Uint32, memCom.Uint16, memCom.Float32},
+		}
+		shard := &memstore.TableShard{Schema: schema}
+		shard.ArchiveStore = &memstore.ArchiveStore{CurrentVersion: memstore.NewArchiveStoreVersion(0, shard)}
+
+		memStore = new(memMocks.MemStore)
+		memStore.On("RLock").Return()
+		memStore.On("RUnlock").Return()
+		memStore.On("GetSchemas").Return(map[string]*memstore.TableSchema{"trips": schema})
+		memStore.On("GetTableShard", "trips", 0).Run(func(args mock.Arguments) {
+			shard.Users.Add(1)
+		}).Return(shard, nil)
+
+		utils.SetCurrentTime(time.Unix(86400, 0))
+	})
+
+	ginkgo.AfterEach(func() {
+		utils.ResetClockImplementation()
+	})
+
+	newQuery := func() *AQLQuery {
+		return &AQLQuery{
+			Table:      "trips",
+			Select:     []string{"fare", "city_id"},
+			Filters:    []string{"city_id != 3"},
+			Limit:      3,
+			Sorts:      []SortField{{Expr: "fare", Desc: true}},
+			TimeFilter: TimeFilter{Column: "request_at", From: "-1d"},
+		}
+	}
+
+	ginkgo.It("returns the top rows of selected columns ordered by sorts", func() {
+		qc := newQuery().Compile(memStore, false)
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.Query.Dimensions).Should(HaveLen(2))
+		Ω(qc.Query.Dimensions[0].Expr).Should(Equal("fare"))
+		Ω(qc.Query.Dimensions[1].Expr).Should(Equal("city_id"))
+		Ω(qc.Query.Measures[0].Expr).Should(Equal("count(*)"))
+		Ω(qc.OOPK.Dimensions).Should(HaveLen(2))
+		Ω(qc.OOPK.MainTableCommonFilters[0].String()).Should(Equal("city_id != 3"))
+		Ω(qc.rowColumns).Should(Equal([]string{"fare", "city_id"}))
+
+		Ω(qc.OOPK.NumDimsPerDimWidth).Should(Equal(queryCom.DimCountsPerDimWidth{0, 0, 1, 1, 0}))
+		Ω(qc.explainStages()).Should(ContainElement(string(rowCollectTiming)))
+		Ω(qc.explainStages()).ShouldNot(ContainElement(string(reduceEvalTiming)))
+
+		// fare values, city_id values, then the validities of fare and city_id.
+		batch := func(fares []float32, cityIDs []uint16, faresValid, cityIDsValid []uint8) unsafe.Pointer {
+			buf := new(bytes.Buffer)
+			binary.Write(buf, binary.LittleEndian, fares)
+			binary.Write(buf, binary.LittleEndian, cityIDs)
+			buf.Write(faresValid)
+			buf.Write(cityIDsValid)
+			return unsafe.Pointer(&buf.Bytes()[0])
+		}
+		qc.addRows(qc.readRows(batch([]float32{2.5, 1.5, 2.5}, []uint16{1, 0, 1}, []uint8{1, 1, 1}, []uint8{1, 0, 1}), 3))
+		Ω(qc.rowsCollected()).Should(BeFalse())
+		qc.addRows(qc.readRows(batch([]float32{0, 10}, []uint16{1, 2}, []uint8{0, 1}, []uint8{1, 1}), 2))
+		// identical rows are kept, only the top rows by fare are.
+		Ω(qc.Postprocess()).Should(Equal(queryCom.AQLTimeSeriesResult{
+			RowResultHeaders: []string{"fare", "city_id"},
+			RowResultMatrixData: [][]interface{}{
+				{"10", "2"},
+				{"2.5", "1"},
+				{"2.5", "1"},
+			},
+		}))
+
+		// without sorts the first rows scanned are returned, and the scan stops once found.
+		q := newQuery()
+		q.Sorts = nil
+		qc = q.Compile(memStore, false)
+		Ω(qc.Error).Should(BeNil())
+		qc.addRows(qc.readRows(batch([]float32{2.5, 1.5}, []uint16{1, 0}, []uint8{1, 1}, []uint8{1, 0}), 2))
+		Ω(qc.rowsCollected()).Should(BeFalse())
+		qc.addRows(qc.readRows(batch([]float32{0, 10}, []uint16{1, 2}, []uint8{0, 1}, []uint8{1, 1}), 2))
+		Ω(qc.rowsCollected()).Should(BeTrue())
+		Ω(qc.Postprocess()[RowResultMatrixData]).Should(Equal([][]interface{}{
+			{"2.5", "1"},
+			{"1.5", nil},
+			{nil, "1"},
+		}))
+	})
+
+	ginkgo.It("rejects invalid row queries", func() {
+		tests := map[string]func(q *AQLQuery){
+			"require limit between 1 and 10000, got 0": func(q *AQLQuery) {
+				q.Limit = 0
+			},
+			"require limit between 1 and 10000, got 10001": func(q *AQLQuery) {
+				q.Limit = MaxRowQueryLimit + 1
+			},
+			"select columns instead of dimensions and measures": func(q *AQLQuery) {
+				q.Measures = []Measure{{Expr: "count(*)"}}
+			},
+			"sort request_at is not a selected column": func(q *AQLQuery) {
+				q.Sorts = []SortField{{Expr: "request_at"}}
+			},
+			"invalid select column 'fare'": func(q *AQLQuery) {
+				q.Select = append(q.Select, "fare")
+			},
+			"pagination is not supported for row queries": func(q *AQLQuery) {
+				q.Paginate = true
+			},
+			"having is not supported for row queries": func(q *AQLQuery) {
+				q.Having = "count(*) > 1"
+			},
+		}
+		for expected, mutate := range tests {
+			q := newQuery()
+			mutate(q)
+			qc := q.Compile(memStore, false)
+			Ω(qc.Error).ShouldNot(BeNil(), expected)
+			Ω(qc.Error.Error()).Should(ContainSubstring(expected))
+		}
+
+		qc := newQuery().Compile(memStore, true)
+		Ω(qc.Error.Error()).Should(ContainSubstring("row queries are not supported"))
+
+		q := newQuery()
+		q.Select = []string{"missing"}
+		Ω(q.Compile(memStore, false).Error).ShouldNot(BeNil())
+	})
+})
@@ -0,0 +1,191 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/uber/aresdb/utils"
+)
+
+const (
+	// RunningCumulative transforms the measure into the running total over the buckets.
+	RunningCumulative = "cumulative"
+	// RunningMovingAverage transforms the measure into the average over the last window buckets.
+	RunningMovingAverage = "moving_average"
+)
+
+// runningTransform is the running aggregate of one measure.
+type runningTransform struct {
+	// RunningCumulative, RunningMovingAverage or empty if the measure is not transformed.
+	kind string
+	// number of buckets averaged by RunningMovingAverage, including the current bucket.
+	window int
+}
+
+// runningAggregate transforms the measures across the ordered buckets of the last dimension.
+type runningAggregate struct {
+	// number of dimensions before the bucket dimension.
+	depth int
+	// transform of each measure, buckets of queries with more than one measure have the value
+	// of each measure keyed by its index.
+	transforms []runningTransform
+}
+
+// compileRunningAggregate returns the running aggregate of the measures with running set, or nil
+// if there is none.
+func compileRunningAggregate(q *AQLQuery, returnHLL bool) (*runningAggregate, error) {
+	running := &runningAggregate{transforms: make([]runningTransform, len(q.Measures))}
+	found := false
+	for i, measure := range q.Measures {
+		switch measure.Running {
+		case "":
+			if measure.Window != 0 {
+				return nil, utils.StackError(nil, "window of measure %s requires running %s", measure.Expr, RunningMovingAverage)
+			}
+			continue
+		case RunningCumulative:
+			if measure.Window != 0 {
+				return nil, utils.StackError(nil, "window is not supported for running %s", RunningCumulative)
+			}
+		case RunningMovingAverage:
+			if measure.Window <= 0 {
+				return nil, utils.StackError(nil, "window of running %s must be positive, got %d",
+					RunningMovingAverage, measure.Window)
+			}
+		default:
+			return nil, utils.StackError(nil, "running must be %s or %s, got %s",
+				RunningCumulative, RunningMovingAverage, measure.Running)
+		}
+		running.transforms[i] = runningTransform{kind: measure.Running, window: measure.Window}
+		found = true
+	}
+	if !found {
+		return nil, nil
+	}
+
+	switch {
+	case returnHLL:
+		return nil, utils.StackError(nil, "running is not supported when client specify 'Accept' as 'application/hll'")
+	case len(q.Dimensions) == 0:
+		return nil, utils.StackError(nil, "running requires at least one dimension")
+	}
+	running.depth = len(q.Dimensions) - 1
+	return running, nil
+}
+
+// apply transforms the buckets under each group of the dimensions before the bucket dimension.
+func (r *runningAggregate) apply(result map[string]interface{}) {
+	r.transform(result, 0)
+}
+
+func (r *runningAggregate) transform(result map[string]interface{}, depth int) {
+	if depth < r.depth {
+		for _, child := range result {
+			if group, ok := child.(map[string]interface{}); ok {
+				r.transform(group, depth+1)
+			}
+		}
+		return
+	}
+
+	// the NULL bucket is not part of the series.
+	buckets := make([]string, 0, len(result))
+	for bucket := range result {
+		if bucket != "NULL" {
+			buckets = append(buckets, bucket)
+		}
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return compareDimensionValues(buckets[i], buckets[j], false) < 0
+	})
+
+	for measureIndex, t := range r.transforms {
+		if t.kind == "" {
+			continue
+		}
+		values := make([]interface{}, len(buckets))
+		for i, bucket := range buckets {
+			values[i] = r.getValue(result, bucket, measureIndex)
+		}
+		for i, value := range t.compute(values) {
+			r.setValue(result, buckets[i], measureIndex, value)
+		}
+	}
+}
+
+func (r *runningAggregate) getValue(result map[string]interface{}, bucket string, measureIndex int) interface{} {
+	if len(r.transforms) <= 1 {
+		return result[bucket]
+	}
+	values, _ := result[bucket].(map[string]interface{})
+	return values[strconv.Itoa(measureIndex)]
+}
+
+func (r *runningAggregate) setValue(result map[string]interface{}, bucket string, measureIndex int, value interface{}) {
+	if len(r.transforms) <= 1 {
+		result[bucket] = value
+		return
+	}
+	if values, ok := result[bucket].(map[string]interface{}); ok {
+		values[strconv.Itoa(measureIndex)] = value
+	}
+}
+
+// compute returns the transformed values of the ordered buckets. Null values do not contribute
+// to the running aggregate, a bucket is null only if no value contributes to it.
+func (t runningTransform) compute(values []interface{}) []interface{} {
+	transformed := make([]interface{}, len(values))
+	total, count := 0.0, 0
+	for i, value := range values {
+		if t.kind == RunningMovingAverage {
+			// sums the window again rather than subtracting the value leaving it, so that the
+			// average does not accumulate rounding errors.
+			total, count = 0, 0
+			start := i - t.window + 1
+			if start < 0 {
+				start = 0
+			}
+			for _, value := range values[start:i] {
+				if v, ok := value.(float64); ok {
+					total += v
+					count++
+				}
+			}
+		}
+		if v, ok := value.(float64); ok {
+			total += v
+			count++
+		}
+		if count == 0 {
+			continue
+		}
+		if t.kind == RunningMovingAverage {
+			transformed[i] = total / float64(count)
+		} else {
+			transformed[i] = total
+		}
+	}
+	return transformed
+}
+
+// clearRunning clears the running aggregate of the measures of sub queries, it is applied to
+// the result the sub query results are merged into.
+func clearRunning(measures []Measure) {
+	for i := range measures {
+		measures[i].Running, measures[i].Window = "", 0
+	}
+}
@@ -0,0 +1,190 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"sort"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	"github.com/uber/aresdb/memstore"
+	memMocks "github.com/uber/aresdb/memstore/mocks"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("running aggregate", func() {
+	var memStore *memMocks.MemStore
+
+	ginkgo.BeforeEach(func() {
+		schema := memstore.NewTableSchema(&metaCom.Table{
+			Name:        "trips",
+			IsFactTable: true,
+			Columns: []metaCom.Column{
+				{Name: "request_at", Type: metaCom.Uint32},
+				{Name: "city_id", Type: metaCom.Uint16},
+				{Name: "fare", Type: metaCom.Float32},
+			},
+		})
+		shard := &memstore.TableShard{Schema: schema}
+		shard.ArchiveStore = &memstore.ArchiveStore{CurrentVersion: memstore.NewArchiveStoreVersion(0, shard)}
+
+		memStore = new(memMocks.MemStore)
+		memStore.On("RLock").Return()
+		memStore.On("RUnlock").Return()
+		memStore.On("GetSchemas").Return(map[string]*memstore.TableSchema{"trips": schema})
+		memStore.On("GetTableShard", "trips", 0).Run(func(args mock.Arguments) {
+			shard.Users.Add(1)
+		}).Return(shard, nil)
+
+		// 2018-01-05 12:00:00 UTC
+		utils.SetCurrentTime(time.Unix(1515153600, 0))
+	})
+
+	ginkgo.AfterEach(func() {
+		utils.ResetClockImplementation()
+	})
+
+	dailyQuery := func(measure Measure, dimensions ...Dimension) *AQLQuery {
+		return &AQLQuery{
+			Table: "trips",
+			Dimensions: append(dimensions, Dimension{
+				Expr: "request_at", TimeBucketizer: "day",
+			}),
+			Measures:   []Measure{measure},
+			TimeFilter: TimeFilter{Column: "request_at", From: "2018-01-01", To: "2018-01-04"},
+		}
+	}
+
+	// cumulate computes the running total of the series manually.
+	cumulate := func(series map[string]interface{}) map[string]interface{} {
+		var buckets []string
+		for bucket := range series {
+			buckets = append(buckets, bucket)
+		}
+		sort.Strings(buckets)
+		cumulated := make(map[string]interface{}, len(series))
+		total := 0.0
+		for _, bucket := range buckets {
+			total += series[bucket].(float64)
+			cumulated[bucket] = total
+		}
+		return cumulated
+	}
+
+	ginkgo.It("computes running totals of each group", func() {
+		q := dailyQuery(Measure{Expr: "count(*)", Running: RunningCumulative}, Dimension{Expr: "city_id"})
+		qc := q.Compile(memStore, false)
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.running).ShouldNot(BeNil())
+
+		series := []map[string]interface{}{
+			{"2018-01-03": 4.0, "2018-01-01": 1.0, "2018-01-02": 2.5, "2018-01-04": 0.5},
+			{"2018-01-02": 7.0, "2018-01-04": 3.0},
+		}
+		result := queryCom.AQLTimeSeriesResult{}
+		for i, city := range []string{"1", "2"} {
+			group := make(map[string]interface{})
+			for bucket, value := range series[i] {
+				group[bucket] = value
+			}
+			result[city] = group
+		}
+		qc.running.apply(result)
+		Ω(result).Should(Equal(queryCom.AQLTimeSeriesResult{
+			"1": cumulate(series[0]),
+			"2": cumulate(series[1]),
+		}))
+		Ω(result["2"]).Should(Equal(map[string]interface{}{"2018-01-02": 7.0, "2018-01-04": 10.0}))
+	})
+
+	ginkgo.It("computes moving averages over the window", func() {
+		q := dailyQuery(Measure{Expr: "sum(fare)", Running: RunningMovingAverage, Window: 2})
+		q.Dimensions[0].Fill = FillNull
+		qc := q.Compile(memStore, false)
+		Ω(qc.Error).Should(BeNil())
+
+		result := map[string]interface{}{"2018-01-01": 2.0, "2018-01-02": 4.0, "2018-01-04": 9.0, "NULL": 1.0}
+		qc.gapFill.apply(result)
+		qc.running.apply(result)
+		Ω(result).Should(Equal(map[string]interface{}{
+			"2018-01-01": 2.0,
+			"2018-01-02": 3.0,
+			// nulls do not count towards the average.
+			"2018-01-03": 4.0,
+			"2018-01-04": 9.0,
+			"NULL":       1.0,
+		}))
+
+		values := []interface{}{nil, 1.0, nil, nil, nil}
+		Ω(runningTransform{kind: RunningCumulative}.compute(values)).Should(Equal([]interface{}{nil, 1.0, 1.0, 1.0, 1.0}))
+		Ω(runningTransform{kind: RunningMovingAverage, window: 2}.compute(values)).Should(Equal([]interface{}{nil, 1.0, 1.0, nil, nil}))
+	})
+
+	ginkgo.It("orders numeric buckets numerically", func() {
+		q := dailyQuery(Measure{Expr: "count(*)", Running: RunningCumulative})
+		q.Dimensions[0].TimeUnit = "second"
+		qc := q.Compile(memStore, false)
+		Ω(qc.Error).Should(BeNil())
+
+		result := map[string]interface{}{"999999999": 1.0, "1000000000": 2.0}
+		qc.running.apply(result)
+		Ω(result).Should(Equal(map[string]interface{}{"999999999": 1.0, "1000000000": 3.0}))
+	})
+
+	ginkgo.It("transforms each measure of multiple measures", func() {
+		q := dailyQuery(Measure{Expr: "count(*)", Running: RunningCumulative})
+		q.Measures = append(q.Measures, Measure{Expr: "sum(fare)"})
+		qc := q.Compile(memStore, false)
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.arithmeticMeasure.subQueryContexts[0].running).Should(BeNil())
+
+		result := map[string]interface{}{
+			"2018-01-01": map[string]interface{}{"0": 1.0, "1": 5.0},
+			"2018-01-02": map[string]interface{}{"0": 2.0, "1": 6.0},
+		}
+		qc.running.apply(result)
+		Ω(result).Should(Equal(map[string]interface{}{
+			"2018-01-01": map[string]interface{}{"0": 1.0, "1": 5.0},
+			"2018-01-02": map[string]interface{}{"0": 3.0, "1": 6.0},
+		}))
+	})
+
+	ginkgo.It("rejects unsupported running aggregates", func() {
+		compileError := func(q *AQLQuery, returnHLL bool) string {
+			return q.Compile(memStore, returnHLL).Error.Error()
+		}
+		Ω(compileError(dailyQuery(Measure{Expr: "count(*)", Running: "max"}), false)).
+			Should(ContainSubstring("running must be cumulative or moving_average"))
+		Ω(compileError(dailyQuery(Measure{Expr: "count(*)", Running: RunningMovingAverage}), false)).
+			Should(ContainSubstring("must be positive"))
+		Ω(compileError(dailyQuery(Measure{Expr: "count(*)", Running: RunningCumulative, Window: 3}), false)).
+			Should(ContainSubstring("window is not supported"))
+		Ω(compileError(dailyQuery(Measure{Expr: "count(*)", Window: 3}), false)).
+			Should(ContainSubstring("requires running"))
+		Ω(compileError(dailyQuery(Measure{Expr: "count(*)", Running: RunningCumulative}), true)).
+			Should(ContainSubstring("running is not supported"))
+
+		q := dailyQuery(Measure{Expr: "count(*)", Running: RunningCumulative})
+		q.Dimensions = nil
+		Ω(compileError(q, false)).Should(ContainSubstring("at least one dimension"))
+
+		q = dailyQuery(Measure{Expr: "histogram(fare, 10, 20)", Running: RunningCumulative})
+		Ω(compileError(q, false)).Should(ContainSubstring("not supported for histogram"))
+	})
+})
@@ -44,6 +44,7 @@ const (
 	hllEvalTiming                           = "hllEval"
 	sortEvalTiming                          = "sortEval"
 	reduceEvalTiming                        = "reduceEval"
+	rowCollectTiming                        = "rowCollect"
 	cleanupTiming                           = "cleanUpEval"
 	resultTransferTiming                    = "resultTransfer"
 	finalCleanupTiming                      = "finalCleanUp"
@@ -155,12 +156,13 @@ func (stats oopkQueryStats) ColumnHeaders() []string {
 // reportTimingForCurrentBatch will first wait for current cuda stream if the debug mode is set and change the timing stat accordingly.
 // It will add to the total timing as well. Therefore this function should only be called one time for each stage.
 func (qc *AQLQueryContext) reportTimingForCurrentBatch(stream unsafe.Pointer, start *time.Time, name stageName) {
-	if qc.Debug {
+	if qc.recordsTimings() {
 		memutils.WaitForCudaStream(stream, qc.Device)
 		now := utils.Now()
 		value := now.Sub(*start).Seconds() * 1000
 		qc.OOPK.currentBatch.stats.timings[name] = value
 		qc.OOPK.currentBatch.stats.totalTiming += value
+		qc.profileTiming(name, value)
 		*start = now
 	}
 }
@@ -168,7 +170,7 @@ func (qc *AQLQueryContext) reportTimingForCurrentBatch(stream unsafe.Pointer, st
 // reportTimingForCurrentBatch is similar to reportTimingForCurrentBatch except that it modifies the query stats for the
 // whole query. It's usually should be called once for each stage
 func (qc *AQLQueryContext) reportTiming(stream unsafe.Pointer, start *time.Time, name stageName) {
-	if qc.Debug {
+	if qc.recordsTimings() {
 		if stream != nil {
 			memutils.WaitForCudaStream(stream, qc.Device)
 		}
@@ -176,6 +178,7 @@ func (qc *AQLQueryContext) reportTiming(stream unsafe.Pointer, start *time.Time,
 		value := now.Sub(*start).Seconds() * 1000
 		queryStats := &qc.OOPK.LiveBatchStats
 		queryStats.applyStageStats(name, value)
+		qc.profileTiming(name, value)
 		*start = now
 	}
 }
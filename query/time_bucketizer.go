@@ -91,27 +91,22 @@ func (qc *AQLQueryContext) buildTimeDimensionExpr(timeBucketizerString string, t
 			},
 		}
 	} else if qc.fixedTimezone.String() != time.UTC.String() {
-		_, fromOffset := qc.fromTime.Time.Zone()
-		_, toOffset := qc.toTime.Time.Zone()
-		if fromOffset != toOffset {
-			offsetDiff := fromOffset - toOffset
-			switchTs, err := utils.CalculateDSTSwitchTs(qc.fromTime.Time.Unix(), qc.toTime.Time.Unix(), qc.fixedTimezone)
-			if err != nil {
-				return nil, err
+		dst := qc.getDSTSegments()
+		fromOffset := dst.Offset(0)
+		if dst != nil && len(dst.SwitchTs) > 0 {
+			// simulate IF statement per DST segment.
+			// sub ast: timeCol + fromOffset + (timeCol >= switchTs1) * offsetDiff1 + (timeCol >= switchTs2) * offsetDiff2 ...
+			// where (timeCol >= switchTs) will return 1 or 0
+			var offsetExpr expr.Expr = &expr.NumberLiteral{
+				Expr:     strconv.Itoa(fromOffset),
+				Int:      fromOffset,
+				ExprType: expr.Signed,
 			}
-			qc.dstswitch = switchTs
-			// simulate IF statement. sub ast: timeCol + fromOffset + (timeCol > switchTs) * offsetDiff
-			// where (timeCol > switchTs) will return 1 or 0
-			timeColumnWithOffsetExpr = &expr.BinaryExpr{
-				Op:  expr.ADD,
-				LHS: timeColumn,
-				RHS: &expr.BinaryExpr{
-					Op: expr.ADD,
-					LHS: &expr.NumberLiteral{
-						Expr:     strconv.Itoa(fromOffset),
-						Int:      fromOffset,
-						ExprType: expr.Signed,
-					},
+			for i, switchTs := range dst.SwitchTs {
+				offsetDiff := dst.Offsets[i+1] - dst.Offsets[i]
+				offsetExpr = &expr.BinaryExpr{
+					Op:  expr.ADD,
+					LHS: offsetExpr,
 					RHS: &expr.BinaryExpr{
 						Op: expr.MUL,
 						LHS: &expr.NumberLiteral{
@@ -130,7 +125,12 @@ func (qc *AQLQueryContext) buildTimeDimensionExpr(timeBucketizerString string, t
 						},
 						ExprType: expr.Signed,
 					},
-				},
+				}
+			}
+			timeColumnWithOffsetExpr = &expr.BinaryExpr{
+				Op:  expr.ADD,
+				LHS: timeColumn,
+				RHS: offsetExpr,
 			}
 		} else {
 			timeColumnWithOffsetExpr = &expr.BinaryExpr{
@@ -173,6 +173,18 @@ func (qc *AQLQueryContext) buildTimeDimensionExpr(timeBucketizerString string, t
 	return bucketizerExpr, nil
 }
 
+// getDSTSegments returns the offsets of the fixed timezone within the query time range,
+// split at its DST switches.
+func (qc *AQLQueryContext) getDSTSegments() *utils.DSTSegments {
+	if qc.dst == nil {
+		if qc.fromTime == nil || qc.toTime == nil {
+			return nil
+		}
+		qc.dst = utils.GetDSTSegments(qc.fromTime.Time.Unix(), qc.toTime.Time.Unix(), qc.fromTime.Time.Location())
+	}
+	return qc.dst
+}
+
 // getRegularRecurringTimeBucketizer converts a time bucketizer string to a regularRecurringTimeBucketizer struct.
 // Nil means it does not match.
 func getRegularRecurringTimeBucketizer(tbStr string) (*regularRecurringTimeBucketizer, error) {
@@ -287,7 +287,8 @@ var _ = ginkgo.Describe("Time Bucketizer", func() {
 			  "TableID": 0,
 			  "ColumnID": 0,
 			  "DataType": 0,
-			  "IsHLLColumn": false
+			  "IsHLLColumn": false,
+			  "IsEnumArrayColumn": false
 			},
 			"RHS": {
 			  "Op": "+",
@@ -301,8 +302,8 @@ var _ = ginkgo.Describe("Time Bucketizer", func() {
 				"Op": "*",
 				"LHS": {
 				  "Val": 0,
-				  "Int": 3600,
-				  "Expr": "3600",
+				  "Int": -3600,
+				  "Expr": "-3600",
 				  "ExprType": "Signed"
 				},
 				"RHS": {
@@ -313,7 +314,8 @@ var _ = ginkgo.Describe("Time Bucketizer", func() {
 					"TableID": 0,
 					"ColumnID": 0,
 					"DataType": 0,
-					"IsHLLColumn": false
+					"IsHLLColumn": false,
+					"IsEnumArrayColumn": false
 				  },
 				  "RHS": {
 					"Val": 0,
@@ -338,4 +340,98 @@ var _ = ginkgo.Describe("Time Bucketizer", func() {
 		  "ExprType": "Unknown"
 		}`))
 	})
+
+	ginkgo.It("fixed timezone buckets should align to local days across DST switch", func() {
+		// 2017-11-04 00:00 PDT to 2017-11-06 00:00 PST, the second day has 25 hours.
+		qc = &AQLQueryContext{
+			Query: &AQLQuery{
+				Table: "trips",
+				Measures: []Measure{
+					{Expr: "count()"},
+				},
+				TimeFilter: TimeFilter{
+					From: "1509778800",
+					To:   "1509955200",
+				},
+				Dimensions: []Dimension{Dimension{Expr: "requested_at", TimeBucketizer: "day"}},
+				Timezone:   "America/Los_Angeles",
+			},
+		}
+		qc.processTimezone()
+		Ω(qc.Error).Should(BeNil())
+		qc.parseExprs()
+		Ω(qc.Error).Should(BeNil())
+
+		bucketCounts := map[int64]int{}
+		for ts := int64(1509778800); ts < 1509955200; ts += 3600 {
+			bucketCounts[evaluateTimeExpr(qc.Query.Dimensions[0].expr, ts)]++
+		}
+		Ω(bucketCounts).Should(Equal(map[int64]int{
+			1509753600: 24,
+			1509840000: 25,
+		}))
+	})
+
+	ginkgo.It("fixed timezone buckets should align to local days across multiple DST switches", func() {
+		// 2017-03-11 00:00 PST to 2017-11-06 00:00 PST, 2017-03-12 has 23 hours and 2017-11-05 has 25 hours.
+		qc = &AQLQueryContext{
+			Query: &AQLQuery{
+				Table: "trips",
+				Measures: []Measure{
+					{Expr: "count()"},
+				},
+				TimeFilter: TimeFilter{
+					From: "1489219200",
+					To:   "1509955200",
+				},
+				Dimensions: []Dimension{Dimension{Expr: "requested_at", TimeBucketizer: "day"}},
+				Timezone:   "America/Los_Angeles",
+			},
+		}
+		qc.processTimezone()
+		Ω(qc.Error).Should(BeNil())
+		qc.parseExprs()
+		Ω(qc.Error).Should(BeNil())
+
+		bucketCounts := map[int64]int{}
+		for ts := int64(1489219200); ts < 1489388400; ts += 3600 {
+			bucketCounts[evaluateTimeExpr(qc.Query.Dimensions[0].expr, ts)]++
+		}
+		for ts := int64(1509778800); ts < 1509955200; ts += 3600 {
+			bucketCounts[evaluateTimeExpr(qc.Query.Dimensions[0].expr, ts)]++
+		}
+		Ω(bucketCounts).Should(Equal(map[int64]int{
+			1489190400: 24,
+			1489276800: 23,
+			1509753600: 24,
+			1509840000: 25,
+		}))
+	})
 })
+
+// evaluateTimeExpr evaluates the compiled time dimension expression for a given timestamp.
+func evaluateTimeExpr(e expr.Expr, ts int64) int64 {
+	switch e := e.(type) {
+	case *expr.VarRef:
+		return ts
+	case *expr.NumberLiteral:
+		return int64(e.Int)
+	case *expr.BinaryExpr:
+		lhs, rhs := evaluateTimeExpr(e.LHS, ts), evaluateTimeExpr(e.RHS, ts)
+		switch e.Op {
+		case expr.ADD:
+			return lhs + rhs
+		case expr.MUL:
+			return lhs * rhs
+		case expr.GTE:
+			if lhs >= rhs {
+				return 1
+			}
+			return 0
+		case expr.FLOOR:
+			return lhs - lhs%rhs
+		}
+	}
+	ginkgo.Fail("unexpected expression " + e.String())
+	return 0
+}
@@ -0,0 +1,221 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/uber/aresdb/utils"
+)
+
+// measureSortKey is the dimension index of a sort key on the measure.
+const measureSortKey = -1
+
+// topN selects the top groups of the final result set by the sort keys.
+type topN struct {
+	limit int
+	// dimension index of each sort key, or measureSortKey.
+	keys []int
+	desc []bool
+	// number of dimensions of the final result set.
+	numDims int
+	// last group of the previous page of a paginated query, only groups after it are selected.
+	after *topNGroup
+}
+
+// topNGroup is a flattened group of the nested result.
+type topNGroup struct {
+	dimValues []string
+	value     interface{}
+}
+
+// compileTopN resolves the sorts of the query against its measure and dimensions, returns nil
+// if the query has no limit.
+func compileTopN(q *AQLQuery, returnHLL bool) (*topN, error) {
+	if q.Limit < 0 {
+		return nil, utils.StackError(nil, "limit must not be negative, got %d", q.Limit)
+	}
+	if q.Limit == 0 {
+		if len(q.Sorts) > 0 {
+			return nil, utils.StackError(nil, "sorts are only supported with limit")
+		}
+		return nil, nil
+	}
+	if returnHLL {
+		return nil, utils.StackError(nil, "limit is not supported when client specify 'Accept' as 'application/hll'")
+	}
+
+	t := &topN{limit: q.Limit, numDims: len(q.Dimensions)}
+	for _, sortField := range q.Sorts {
+		key, ok := resolveSortKey(q, sortField.Expr)
+		if !ok {
+			return nil, utils.StackError(nil, "sort %s is neither the measure nor a dimension", sortField.Expr)
+		}
+		t.keys = append(t.keys, key)
+		t.desc = append(t.desc, sortField.Desc)
+	}
+	return t, nil
+}
+
+// resolveSortKey returns the dimension index of the sort expression, or measureSortKey if it is
+// the measure.
+func resolveSortKey(q *AQLQuery, expression string) (int, bool) {
+	if expression == "" {
+		return 0, false
+	}
+	if len(q.Measures) > 0 && expression == q.Measures[0].Expr {
+		return measureSortKey, true
+	}
+	for dimIndex, dim := range q.Dimensions {
+		if expression == dim.Expr {
+			return dimIndex, true
+		}
+	}
+	return 0, false
+}
+
+// apply keeps the top groups of the nested result in place, after the last group of the previous
+// page if paginated. It returns the last group kept if more groups follow it, nil otherwise.
+func (t *topN) apply(result map[string]interface{}) *topNGroup {
+	if t.numDims == 0 {
+		return nil
+	}
+	var groups []topNGroup
+	t.flatten(result, nil, &groups)
+	if t.after == nil && len(groups) <= t.limit {
+		return nil
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return t.less(groups[i], groups[j])
+	})
+	if t.after != nil {
+		groups = groups[sort.Search(len(groups), func(i int) bool {
+			return t.less(*t.after, groups[i])
+		}):]
+	}
+	var last *topNGroup
+	if len(groups) > t.limit {
+		groups = groups[:t.limit]
+		last = &groups[t.limit-1]
+	}
+
+	for key := range result {
+		delete(result, key)
+	}
+	for _, group := range groups {
+		current := result
+		for i, dimValue := range group.dimValues {
+			if i == len(group.dimValues)-1 {
+				current[dimValue] = group.value
+				break
+			}
+			child, ok := current[dimValue].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				current[dimValue] = child
+			}
+			current = child
+		}
+	}
+	return last
+}
+
+func (t *topN) flatten(result map[string]interface{}, dimValues []string, groups *[]topNGroup) {
+	for key, value := range result {
+		values := append(append([]string(nil), dimValues...), key)
+		if len(values) < t.numDims {
+			if child, ok := value.(map[string]interface{}); ok {
+				t.flatten(child, values, groups)
+			}
+			continue
+		}
+		*groups = append(*groups, topNGroup{dimValues: values, value: value})
+	}
+}
+
+// less orders groups by the sort keys and then by the dimension values in order.
+func (t *topN) less(a, b topNGroup) bool {
+	for i, key := range t.keys {
+		var c int
+		if key == measureSortKey {
+			c = compareMeasureValues(a.value, b.value, t.desc[i])
+		} else {
+			c = compareDimensionValues(a.dimValues[key], b.dimValues[key], t.desc[i])
+		}
+		if c != 0 {
+			return c < 0
+		}
+	}
+	for dimIndex := range a.dimValues {
+		if c := compareDimensionValues(a.dimValues[dimIndex], b.dimValues[dimIndex], false); c != 0 {
+			return c < 0
+		}
+	}
+	return false
+}
+
+// compareMeasureValues compares numeric measure values with NULLs last in both directions.
+func compareMeasureValues(a, b interface{}, desc bool) int {
+	x, xOK := a.(float64)
+	y, yOK := b.(float64)
+	switch {
+	case !xOK && !yOK:
+		return 0
+	case !xOK:
+		return 1
+	case !yOK:
+		return -1
+	}
+	return compareFloats(x, y, desc)
+}
+
+// compareDimensionValues compares dimension values numerically when both are numbers and as
+// strings otherwise, with NULLs last in both directions.
+func compareDimensionValues(a, b string, desc bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "NULL":
+		return 1
+	case b == "NULL":
+		return -1
+	}
+	x, xErr := strconv.ParseFloat(a, 64)
+	y, yErr := strconv.ParseFloat(b, 64)
+	if xErr == nil && yErr == nil && x != y {
+		return compareFloats(x, y, desc)
+	}
+	c := strings.Compare(a, b)
+	if desc {
+		return -c
+	}
+	return c
+}
+
+func compareFloats(x, y float64, desc bool) int {
+	c := 0
+	if x < y {
+		c = -1
+	} else if x > y {
+		c = 1
+	}
+	if desc {
+		return -c
+	}
+	return c
+}
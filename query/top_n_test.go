@@ -0,0 +1,92 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("top n", func() {
+	query := &AQLQuery{
+		Dimensions: []Dimension{{Expr: "city_id"}, {Expr: "status"}},
+		Measures:   []Measure{{Expr: "sum(fare)"}},
+	}
+
+	newResult := func() map[string]interface{} {
+		return map[string]interface{}{
+			"1":  map[string]interface{}{"completed": 10.0, "canceled": 5.0},
+			"2":  map[string]interface{}{"completed": 10.0, "NULL": nil},
+			"10": map[string]interface{}{"completed": 10.0, "canceled": 7.0},
+		}
+	}
+
+	ginkgo.It("breaks ties on dimension values", func() {
+		query.Limit = 2
+		query.Sorts = []SortField{{Expr: "sum(fare)", Desc: true}}
+		t, err := compileTopN(query, false)
+		Ω(err).Should(BeNil())
+
+		// three groups tie on 10, the first two cities win numerically.
+		for i := 0; i < 10; i++ {
+			result := newResult()
+			t.apply(result)
+			Ω(result).Should(Equal(map[string]interface{}{
+				"1": map[string]interface{}{"completed": 10.0},
+				"2": map[string]interface{}{"completed": 10.0},
+			}))
+		}
+	})
+
+	ginkgo.It("applies multiple sort keys in order", func() {
+		query.Limit = 3
+		query.Sorts = []SortField{{Expr: "status"}, {Expr: "sum(fare)", Desc: true}}
+		t, err := compileTopN(query, false)
+		Ω(err).Should(BeNil())
+		result := newResult()
+		t.apply(result)
+		// both canceled groups, then the first of the tied completed groups.
+		Ω(result).Should(Equal(map[string]interface{}{
+			"1":  map[string]interface{}{"canceled": 5.0, "completed": 10.0},
+			"10": map[string]interface{}{"canceled": 7.0},
+		}))
+
+		// NULL measures go last in both directions.
+		query.Limit = 4
+		query.Sorts = []SortField{{Expr: "sum(fare)"}}
+		t, _ = compileTopN(query, false)
+		result = newResult()
+		t.apply(result)
+		Ω(result["2"]).Should(Equal(map[string]interface{}{"completed": 10.0}))
+	})
+
+	ginkgo.It("rejects invalid limits", func() {
+		tests := map[string]*AQLQuery{
+			"limit must not be negative":          {Limit: -1},
+			"sorts are only supported with limit": {Sorts: []SortField{{Expr: "sum(fare)"}}},
+			"neither the measure nor a dimension": {Limit: 1, Measures: query.Measures, Sorts: []SortField{{Expr: "fare"}}},
+			"application/hll":                     {Limit: 1, Measures: query.Measures},
+		}
+		for expected, q := range tests {
+			_, err := compileTopN(q, expected == "application/hll")
+			Ω(err).ShouldNot(BeNil(), expected)
+			Ω(err.Error()).Should(ContainSubstring(expected))
+		}
+
+		t, err := compileTopN(&AQLQuery{}, false)
+		Ω(err).Should(BeNil())
+		Ω(t).Should(BeNil())
+	})
+})
@@ -0,0 +1,71 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"context"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/uber/aresdb/utils"
+)
+
+// TracingOperationExecute is the operation name of the span executing a query on device.
+const TracingOperationExecute = "execute"
+
+// startExecuteSpan starts the span executing the query as a child of the span carried by the query
+// context, so that sub queries executed with the query context are traced as its children. Unless
+// the tracer is the no-op tracer, the query records the time spent in each stage for the span.
+func (qc *AQLQueryContext) startExecuteSpan() opentracing.Span {
+	ctx := qc.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	span, ctx := opentracing.StartSpanFromContext(ctx, TracingOperationExecute)
+	_, noop := span.Tracer().(opentracing.NoopTracer)
+	qc.traced = !noop
+	ext.Component.Set(span, utils.TracingComponent)
+	span.SetTag("table", qc.Query.Table)
+	span.SetTag("device", qc.Device)
+	qc.Context = ctx
+	return span
+}
+
+// finishExecuteSpan tags the span with the rows scanned and result rows of the query, and the
+// time spent in each executor stage.
+func (qc *AQLQueryContext) finishExecuteSpan(span opentracing.Span) {
+	span.SetTag("rows_scanned", qc.rowsScanned)
+	span.SetTag("result_rows", qc.resultRows)
+	span.SetTag("live_batches", qc.OOPK.LiveBatchStats.NumBatches)
+	span.SetTag("archive_batches", qc.OOPK.ArchiveBatchStats.NumBatches)
+	if qc.traced {
+		stages := make(map[string]float64)
+		for _, stats := range []oopkQueryStats{qc.OOPK.LiveBatchStats, qc.OOPK.ArchiveBatchStats} {
+			for name, stageStats := range stats.Name2Stage {
+				if stage, ok := profileStageByTiming[name]; ok {
+					stages[stage] += stageStats.total
+				}
+			}
+		}
+		for stage, milliseconds := range stages {
+			span.SetTag(stage+"_ms", milliseconds)
+		}
+	}
+	if qc.Error != nil {
+		ext.Error.Set(span, true)
+		span.LogKV("error", qc.Error.Error())
+	}
+	span.Finish()
+}
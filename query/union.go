@@ -0,0 +1,256 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"strings"
+
+	"github.com/uber/aresdb/memstore"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/query/expr"
+	"github.com/uber/aresdb/utils"
+)
+
+// unionTables scans the tables of a union query other than the first one. Each table is scanned
+// by its own query context sharing all other parts of the query, since enum dictionaries and
+// column IDs are per table, and the aggregated results of all tables are merged per group in
+// Postprocess as if the rows were in one table.
+type unionTables struct {
+	tables []string
+	// aggregate function of the measure, which decides how partial aggregates are merged.
+	aggregate string
+	// contexts scanning tables[1:], tables[0] is scanned by the query context owning this union.
+	tableContexts []*AQLQueryContext
+}
+
+// unionTableQuery returns a copy of the union query scanning the table. Limit, sorts, fill and
+// running aggregates apply to the merged result.
+func (q *AQLQuery) unionTableQuery(table string) *AQLQuery {
+	tableQuery := *q
+	tableQuery.Table = table
+	tableQuery.Tables = nil
+	tableQuery.Joins = append([]Join(nil), q.Joins...)
+	tableQuery.Dimensions = append([]Dimension(nil), q.Dimensions...)
+	tableQuery.Measures = append([]Measure(nil), q.Measures...)
+	tableQuery.Filters = append([]string(nil), q.Filters...)
+	// parsed filters are rewritten in place during compilation, so each table query parses
+	// its own copy.
+	tableQuery.filters = nil
+	tableQuery.filtersParsed = false
+	tableQuery.Limit, tableQuery.Sorts = 0, nil
+	clearFill(tableQuery.Dimensions)
+	clearRunning(tableQuery.Measures)
+	return &tableQuery
+}
+
+// compileUnion checks the tables of the union query are compatible and compiles the query of
+// each table. The context of the first table is returned with the union attached.
+func (q *AQLQuery) compileUnion(store memstore.MemStore, returnHLL bool) *AQLQueryContext {
+	invalid := func(format string, args ...interface{}) *AQLQueryContext {
+		return &AQLQueryContext{Query: q, ReturnHLLData: returnHLL, Error: utils.StackError(nil, format, args...)}
+	}
+	switch {
+	case returnHLL:
+		return invalid("union is not supported when client specify 'Accept' as 'application/hll'")
+	case q.Table != "":
+		return invalid("table %s can not be specified together with union tables", q.Table)
+	case len(q.Tables) < 2:
+		return invalid("union requires at least 2 tables, got %d", len(q.Tables))
+	case q.Having != "":
+		return invalid("having is not supported for union")
+	case q.isPaginated():
+		return invalid("pagination is not supported for union")
+	case len(q.Measures) != 1:
+		return invalid("union requires exactly one measure, got %d", len(q.Measures))
+	}
+
+	aggregate, err := getUnionAggregate(q.Measures[0].Expr)
+	if err != nil {
+		return &AQLQueryContext{Query: q, Error: err}
+	}
+	if err = checkUnionSchemas(store, q.Tables); err != nil {
+		return &AQLQueryContext{Query: q, Error: err}
+	}
+
+	union := &unionTables{tables: q.Tables, aggregate: aggregate}
+	var qc *AQLQueryContext
+	for i, table := range q.Tables {
+		tableQC := q.unionTableQuery(table).Compile(store, false)
+		if i == 0 {
+			qc = tableQC
+		} else {
+			union.tableContexts = append(union.tableContexts, tableQC)
+		}
+		if tableQC.Error != nil {
+			qc.Error = utils.StackError(tableQC.Error, "Failed to compile query of union table %s", table)
+			return qc
+		}
+	}
+	if qc.topN, err = compileTopN(q, false); err != nil {
+		qc.Error = utils.StackError(err, "Invalid limit")
+		return qc
+	}
+	if qc.gapFill, err = compileGapFill(q, qc); err != nil {
+		qc.Error = utils.StackError(err, "Invalid fill")
+		return qc
+	}
+	if qc.running, err = compileRunningAggregate(q, false); err != nil {
+		qc.Error = utils.StackError(err, "Invalid running")
+		return qc
+	}
+	qc.union = union
+	return qc
+}
+
+// getUnionAggregate returns the aggregate function of the measure if partial aggregates of the
+// function can be merged across tables.
+func getUnionAggregate(measure string) (string, error) {
+	measureExpr, err := expr.ParseExpr(measure)
+	if err != nil {
+		return "", utils.StackError(err, "Failed to parse measure: %s", measure)
+	}
+	if call, ok := measureExpr.(*expr.Call); ok {
+		switch name := strings.ToLower(call.Name); name {
+		case countCallName, sumCallName, minCallName, maxCallName:
+			return name, nil
+		case avgCallName:
+			return "", utils.StackError(nil, "avg is not supported for union, use sum(x)/count(x) instead")
+		}
+	}
+	return "", utils.StackError(nil, "union only supports count, sum, min and max aggregates, got %s", measure)
+}
+
+// checkUnionSchemas returns an error if a table is listed more than once, the tables are not
+// all fact tables or all dimension tables, or a column has different types in the tables.
+// Columns only existing in some of the tables are reported when compiling the query of a table
+// missing a column used by the query.
+func checkUnionSchemas(store memstore.MemStore, tables []string) error {
+	store.RLock()
+	defer store.RUnlock()
+
+	schemas := make([]*memstore.TableSchema, len(tables))
+	for i, table := range tables {
+		for _, previous := range tables[:i] {
+			if table == previous {
+				return utils.StackError(nil, "table %s is listed more than once in union", table)
+			}
+		}
+		if schemas[i] = store.GetSchemas()[table]; schemas[i] == nil {
+			return utils.StackError(nil, "unknown union table %s", table)
+		}
+	}
+
+	first := schemas[0]
+	first.RLock()
+	defer first.RUnlock()
+	for i, schema := range schemas[1:] {
+		if err := checkUnionSchema(tables[0], first, tables[i+1], schema); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkUnionSchema checks the schema of the table against the schema of the first table.
+func checkUnionSchema(firstTable string, first *memstore.TableSchema, table string, schema *memstore.TableSchema) error {
+	schema.RLock()
+	defer schema.RUnlock()
+
+	if schema.Schema.IsFactTable != first.Schema.IsFactTable {
+		factTable, dimTable := firstTable, table
+		if schema.Schema.IsFactTable {
+			factTable, dimTable = table, firstTable
+		}
+		return utils.StackError(nil, "union table %s is a fact table but %s is a dimension table", factTable, dimTable)
+	}
+	for _, column := range schema.Schema.Columns {
+		if column.Deleted {
+			continue
+		}
+		firstColumnID, ok := first.ColumnIDs[column.Name]
+		if !ok {
+			continue
+		}
+		if firstColumnType := first.Schema.Columns[firstColumnID].Type; firstColumnType != column.Type {
+			return utils.StackError(nil, "column %s of union table %s has type %s, but type %s in table %s",
+				column.Name, table, column.Type, firstColumnType, firstTable)
+		}
+	}
+	return nil
+}
+
+// processTables executes the queries of the other tables on the device of the query context one
+// after another.
+func (u *unionTables) processTables(qc *AQLQueryContext, memStore memstore.MemStore) {
+	for i, tableQC := range u.tableContexts {
+		qc.processSubQuery(tableQC, memStore)
+		if tableQC.Error != nil {
+			qc.Error = utils.StackError(tableQC.Error, "Failed to process query of union table %s", u.tables[i+1])
+			return
+		}
+	}
+}
+
+// postprocess merges the results of the other tables into the result of the first table.
+func (u *unionTables) postprocess(qc *AQLQueryContext, result queryCom.AQLTimeSeriesResult) queryCom.AQLTimeSeriesResult {
+	for i, tableQC := range u.tableContexts {
+		tableResult := tableQC.Postprocess()
+		if tableQC.Error != nil {
+			qc.Error = utils.StackError(tableQC.Error, "Failed to postprocess query of union table %s", u.tables[i+1])
+			return nil
+		}
+		u.merge(result, tableResult)
+	}
+	return result
+}
+
+// merge merges the groups of the table result into the result. The partial aggregates of a group
+// found in both results are merged by the aggregate function, nulls are ignored.
+func (u *unionTables) merge(result, tableResult map[string]interface{}) {
+	for key, value := range tableResult {
+		existing, found := result[key]
+		if !found || existing == nil {
+			result[key] = value
+			continue
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if child, ok := existing.(map[string]interface{}); ok {
+				u.merge(child, v)
+			}
+		case float64:
+			if e, ok := existing.(float64); ok {
+				result[key] = u.mergeAggregates(e, v)
+			}
+		}
+	}
+}
+
+// mergeAggregates merges two partial aggregates of the same group.
+func (u *unionTables) mergeAggregates(a, b float64) float64 {
+	switch u.aggregate {
+	case minCallName:
+		if b < a {
+			return b
+		}
+		return a
+	case maxCallName:
+		if b > a {
+			return b
+		}
+		return a
+	}
+	return a + b
+}
@@ -0,0 +1,177 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"time"
+
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	"github.com/uber/aresdb/memstore"
+	memMocks "github.com/uber/aresdb/memstore/mocks"
+	metaCom "github.com/uber/aresdb/metastore/common"
+	queryCom "github.com/uber/aresdb/query/common"
+	"github.com/uber/aresdb/utils"
+)
+
+var _ = ginkgo.Describe("union", func() {
+	var memStore *memMocks.MemStore
+	var schemas map[string]*memstore.TableSchema
+
+	newSchema := func(name string, isFactTable bool, fareType string) *memstore.TableSchema {
+		schema := memstore.NewTableSchema(&metaCom.Table{
+			Name:        name,
+			IsFactTable: isFactTable,
+			Columns: []metaCom.Column{
+				{Name: "request_at", Type: metaCom.Uint32},
+				{Name: "city_id", Type: metaCom.Uint16},
+				{Name: "fare", Type: fareType},
+			},
+		})
+		shard := &memstore.TableShard{Schema: schema}
+		shard.ArchiveStore = &memstore.ArchiveStore{CurrentVersion: memstore.NewArchiveStoreVersion(0, shard)}
+		memStore.On("GetTableShard", name, 0).Run(func(args mock.Arguments) {
+			shard.Users.Add(1)
+		}).Return(shard, nil)
+		return schema
+	}
+
+	ginkgo.BeforeEach(func() {
+		memStore = new(memMocks.MemStore)
+		memStore.On("RLock").Return()
+		memStore.On("RUnlock").Return()
+		schemas = map[string]*memstore.TableSchema{
+			"trips_20180101": newSchema("trips_20180101", true, metaCom.Float32),
+			"trips_20180102": newSchema("trips_20180102", true, metaCom.Float32),
+			"trips_uint":     newSchema("trips_uint", true, metaCom.Uint32),
+			"cities":         newSchema("cities", false, metaCom.Float32),
+		}
+		memStore.On("GetSchemas").Return(schemas)
+
+		utils.SetCurrentTime(time.Unix(86400, 0))
+	})
+
+	ginkgo.AfterEach(func() {
+		utils.ResetClockImplementation()
+	})
+
+	unionQuery := func(tables ...string) *AQLQuery {
+		return &AQLQuery{
+			Tables:     tables,
+			Dimensions: []Dimension{{Expr: "city_id"}},
+			Measures:   []Measure{{Expr: "sum(fare)"}},
+			TimeFilter: TimeFilter{Column: "request_at", From: "-1d"},
+		}
+	}
+
+	ginkgo.It("compiles a query per table", func() {
+		q := unionQuery("trips_20180101", "trips_20180102")
+		q.Limit = 10
+		q.Sorts = []SortField{{Expr: "sum(fare)", Desc: true}}
+		qc := q.Compile(memStore, false)
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.Query.Table).Should(Equal("trips_20180101"))
+		Ω(qc.Query.Limit).Should(BeZero())
+		Ω(qc.topN).ShouldNot(BeNil())
+		Ω(qc.topN.limit).Should(Equal(10))
+		Ω(qc.union.aggregate).Should(Equal(sumCallName))
+		Ω(qc.union.tableContexts).Should(HaveLen(1))
+		Ω(qc.union.tableContexts[0].Error).Should(BeNil())
+		Ω(qc.union.tableContexts[0].Query.Table).Should(Equal("trips_20180102"))
+		Ω(qc.union.tableContexts[0].topN).Should(BeNil())
+		Ω(qc.getSubQueryContexts()).Should(Equal(qc.union.tableContexts))
+	})
+
+	ginkgo.It("compiles a union for each aggregate of arithmetic measures", func() {
+		q := unionQuery("trips_20180101", "trips_20180102")
+		q.Measures = []Measure{{Expr: "sum(fare)/count(*)"}}
+		qc := q.Compile(memStore, false)
+		Ω(qc.Error).Should(BeNil())
+		Ω(qc.union).ShouldNot(BeNil())
+		Ω(qc.arithmeticMeasure.subQueryContexts).Should(HaveLen(1))
+		subQC := qc.arithmeticMeasure.subQueryContexts[0]
+		Ω(subQC.union.aggregate).Should(Equal(countCallName))
+		Ω(qc.getSubQueryContexts()).Should(HaveLen(3))
+	})
+
+	ginkgo.It("rejects incompatible tables", func() {
+		qc := unionQuery("trips_20180101", "trips_uint").Compile(memStore, false)
+		Ω(qc.Error).ShouldNot(BeNil())
+		Ω(qc.Error.Error()).Should(ContainSubstring(
+			"column fare of union table trips_uint has type Uint32, but type Float32 in table trips_20180101"))
+
+		qc = unionQuery("trips_20180101", "cities").Compile(memStore, false)
+		Ω(qc.Error).ShouldNot(BeNil())
+		Ω(qc.Error.Error()).Should(ContainSubstring(
+			"union table trips_20180101 is a fact table but cities is a dimension table"))
+
+		qc = unionQuery("trips_20180101", "trips_20180101").Compile(memStore, false)
+		Ω(qc.Error).ShouldNot(BeNil())
+		Ω(qc.Error.Error()).Should(ContainSubstring("table trips_20180101 is listed more than once in union"))
+
+		qc = unionQuery("trips_20180101", "trips_20180103").Compile(memStore, false)
+		Ω(qc.Error).ShouldNot(BeNil())
+		Ω(qc.Error.Error()).Should(ContainSubstring("unknown union table trips_20180103"))
+	})
+
+	ginkgo.It("rejects unsupported union queries", func() {
+		q := unionQuery("trips_20180101")
+		Ω(q.Compile(memStore, false).Error.Error()).Should(ContainSubstring("union requires at least 2 tables"))
+
+		q = unionQuery("trips_20180101", "trips_20180102")
+		q.Table = "trips_20180101"
+		Ω(q.Compile(memStore, false).Error.Error()).Should(ContainSubstring("can not be specified together"))
+
+		q = unionQuery("trips_20180101", "trips_20180102")
+		q.Measures = []Measure{{Expr: "avg(fare)"}}
+		Ω(q.Compile(memStore, false).Error.Error()).Should(ContainSubstring("avg is not supported for union"))
+
+		q = unionQuery("trips_20180101", "trips_20180102")
+		q.Measures = []Measure{{Expr: "countdistincthll(city_id)"}}
+		Ω(q.Compile(memStore, false).Error.Error()).Should(ContainSubstring("union only supports"))
+
+		q = unionQuery("trips_20180101", "trips_20180102")
+		q.Having = "sum(fare) > 10"
+		Ω(q.Compile(memStore, false).Error.Error()).Should(ContainSubstring("having is not supported for union"))
+
+		q = unionQuery("trips_20180101", "trips_20180102")
+		Ω(q.Compile(memStore, true).Error).ShouldNot(BeNil())
+	})
+
+	ginkgo.It("merges aggregates of the same groups", func() {
+		result := queryCom.AQLTimeSeriesResult{
+			"1": map[string]interface{}{"a": 1.0, "b": 2.0},
+			"2": map[string]interface{}{"a": nil},
+		}
+		tableResult := queryCom.AQLTimeSeriesResult{
+			"1": map[string]interface{}{"a": 3.0, "c": 4.0},
+			"2": map[string]interface{}{"a": 5.0},
+			"3": map[string]interface{}{"a": 6.0},
+		}
+
+		sum := &unionTables{aggregate: sumCallName}
+		sum.merge(result, tableResult)
+		Ω(result).Should(Equal(queryCom.AQLTimeSeriesResult{
+			"1": map[string]interface{}{"a": 4.0, "b": 2.0, "c": 4.0},
+			"2": map[string]interface{}{"a": 5.0},
+			"3": map[string]interface{}{"a": 6.0},
+		}))
+
+		Ω((&unionTables{aggregate: minCallName}).mergeAggregates(1, 2)).Should(Equal(1.0))
+		Ω((&unionTables{aggregate: maxCallName}).mergeAggregates(1, 2)).Should(Equal(2.0))
+		Ω((&unionTables{aggregate: countCallName}).mergeAggregates(1, 2)).Should(Equal(3.0))
+	})
+})
@@ -8,6 +8,7 @@ import (
 	"github.com/spf13/viper"
 	"github.com/uber/aresdb/common"
 	"os"
+	"strings"
 )
 
 // bindEnvironments binds environment variables to viper
@@ -63,3 +64,123 @@ func ReadConfig(defaultCfg map[string]interface{}, flags *pflag.FlagSet) (common
 	})
 	return cfg, err
 }
+
+// defaultZKServerTickTimeMillis is the default tick time of ZooKeeper servers.
+const defaultZKServerTickTimeMillis = 2000
+
+// ValidateClusterConfig checks that all configs required to start in cluster mode are present,
+// returning a single error listing every missing or invalid field.
+func ValidateClusterConfig(cfg common.AresServerConfig) error {
+	if !cfg.Cluster.Enable {
+		return nil
+	}
+
+	var problems []string
+	if cfg.Cluster.ClusterName == "" {
+		problems = append(problems, "cluster.cluster_name is missing")
+	}
+	if cfg.Cluster.InstanceName == "" {
+		problems = append(problems, "cluster.instance_name is missing")
+	}
+	switch cfg.Cluster.SchemaFetchMode {
+	case "", "poll":
+	case "watch":
+		if cfg.Clients.ZK == nil {
+			problems = append(problems, "cluster.schema_fetch_mode watch requires clients.zk")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("cluster.schema_fetch_mode %s is invalid", cfg.Cluster.SchemaFetchMode))
+	}
+	if root := cfg.Cluster.ZKRoot; root != "" && (!strings.HasPrefix(root, "/") || strings.HasSuffix(root, "/")) {
+		problems = append(problems, fmt.Sprintf("cluster.zk_root %s must begin with / and have no trailing /", root))
+	}
+
+	controllerCfg := cfg.Clients.Controller
+	if controllerCfg == nil {
+		problems = append(problems, "clients.controller is missing")
+	} else {
+		if controllerCfg.Host == "" {
+			problems = append(problems, "clients.controller.host is missing")
+		}
+		if controllerCfg.Port <= 0 || controllerCfg.Port > 65535 {
+			problems = append(problems, fmt.Sprintf("clients.controller.port %d is invalid", controllerCfg.Port))
+		}
+	}
+
+	if zkCfg := cfg.Clients.ZK; zkCfg != nil {
+		if zkCfg.Server == "" {
+			problems = append(problems, "clients.zk.server is missing")
+		}
+		tickTimeMillis := zkCfg.ServerTickTimeMillis
+		if tickTimeMillis <= 0 {
+			tickTimeMillis = defaultZKServerTickTimeMillis
+		}
+		if sessionTimeoutMillis := zkCfg.SessionTimeoutSeconds * 1000; sessionTimeoutMillis < 2*tickTimeMillis || sessionTimeoutMillis > 20*tickTimeMillis {
+			problems = append(problems, fmt.Sprintf("clients.zk.session_timeout_seconds %d is not within the %d to %d ms negotiable with tick time %d ms",
+				zkCfg.SessionTimeoutSeconds, 2*tickTimeMillis, 20*tickTimeMillis, tickTimeMillis))
+		}
+		if zkCfg.ConnectTimeoutSeconds <= 0 {
+			problems = append(problems, fmt.Sprintf("clients.zk.connect_timeout_seconds %d is invalid", zkCfg.ConnectTimeoutSeconds))
+		}
+		switch zkCfg.AuthScheme {
+		case "":
+		case "digest":
+			if zkCfg.Username == "" {
+				problems = append(problems, "clients.zk.username is missing")
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("clients.zk.auth_scheme %s is not supported", zkCfg.AuthScheme))
+		}
+		switch zkCfg.ACLScheme {
+		case "", "world":
+		case "digest":
+			if zkCfg.AuthScheme != "digest" {
+				problems = append(problems, "clients.zk.acl_scheme digest requires clients.zk.auth_scheme digest")
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("clients.zk.acl_scheme %s is not recognized", zkCfg.ACLScheme))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid cluster config: %s", strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// defaultRedoLogSyncIntervalMs is the batch interval of batched redo log fsync if neither the
+// batch records nor the batch interval is configured.
+const defaultRedoLogSyncIntervalMs = 100
+
+// GetRedoLogSyncConfig returns the effective fsync config of redo log files. The policy defaults
+// to sync if write_sync is true and to async otherwise, which is how redo logs were written
+// before the policy was configurable.
+func GetRedoLogSyncConfig(cfg common.DiskStoreConfig) common.RedoLogSyncConfig {
+	syncConfig := cfg.RedoLogSync
+	if syncConfig.Policy == "" {
+		syncConfig.Policy = common.RedoLogSyncAsync
+		if cfg.WriteSync {
+			syncConfig.Policy = common.RedoLogSyncPerRecord
+		}
+	}
+	if syncConfig.Policy != common.RedoLogSyncBatched {
+		syncConfig.BatchRecords, syncConfig.BatchIntervalMs = 0, 0
+	} else if syncConfig.BatchRecords <= 0 && syncConfig.BatchIntervalMs <= 0 {
+		syncConfig.BatchIntervalMs = defaultRedoLogSyncIntervalMs
+	}
+	return syncConfig
+}
+
+// ValidateDiskStoreConfig checks the redo log fsync config of the disk store.
+func ValidateDiskStoreConfig(cfg common.DiskStoreConfig) error {
+	syncConfig := cfg.RedoLogSync
+	switch syncConfig.Policy {
+	case "", common.RedoLogSyncPerRecord, common.RedoLogSyncBatched, common.RedoLogSyncAsync:
+	default:
+		return fmt.Errorf("invalid disk store config: disk_store.redolog_sync.policy %s is invalid", syncConfig.Policy)
+	}
+	if syncConfig.BatchRecords < 0 || syncConfig.BatchIntervalMs < 0 {
+		return fmt.Errorf("invalid disk store config: disk_store.redolog_sync batch limits must not be negative")
+	}
+	return nil
+}
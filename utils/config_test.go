@@ -0,0 +1,141 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/uber/aresdb/common"
+)
+
+var _ = ginkgo.Describe("config", func() {
+	ginkgo.It("ValidateClusterConfig should skip non cluster mode", func() {
+		Ω(ValidateClusterConfig(common.AresServerConfig{})).Should(BeNil())
+	})
+
+	ginkgo.It("ValidateClusterConfig should pass for complete config", func() {
+		cfg := common.AresServerConfig{
+			Cluster: common.ClusterConfig{
+				Enable:       true,
+				ClusterName:  "cluster1",
+				InstanceName: "instance1",
+			},
+			Clients: common.ClientsConfig{
+				Controller: &common.ControllerConfig{
+					Host: "localhost",
+					Port: 6708,
+				},
+			},
+		}
+		Ω(ValidateClusterConfig(cfg)).Should(BeNil())
+	})
+
+	ginkgo.It("ValidateClusterConfig should list every missing field", func() {
+		cfg := common.AresServerConfig{
+			Cluster: common.ClusterConfig{
+				Enable: true,
+			},
+		}
+		err := ValidateClusterConfig(cfg)
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("cluster.cluster_name"))
+		Ω(err.Error()).Should(ContainSubstring("cluster.instance_name"))
+		Ω(err.Error()).Should(ContainSubstring("clients.controller is missing"))
+
+		cfg.Clients.Controller = &common.ControllerConfig{Port: -1}
+		err = ValidateClusterConfig(cfg)
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("clients.controller.host"))
+		Ω(err.Error()).Should(ContainSubstring("clients.controller.port -1 is invalid"))
+
+		cfg.Clients.ZK = &common.ZKConfig{}
+		err = ValidateClusterConfig(cfg)
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("clients.zk.server is missing"))
+		Ω(err.Error()).Should(ContainSubstring("clients.zk.session_timeout_seconds 0 is not within the 4000 to 40000 ms negotiable with tick time 2000 ms"))
+		Ω(err.Error()).Should(ContainSubstring("clients.zk.connect_timeout_seconds 0 is invalid"))
+		cfg.Clients.ZK = &common.ZKConfig{SessionTimeoutSeconds: 60, ServerTickTimeMillis: 3000}
+		Ω(ValidateClusterConfig(cfg).Error()).ShouldNot(ContainSubstring("clients.zk.session_timeout_seconds"))
+		cfg.Clients.ZK = &common.ZKConfig{SessionTimeoutSeconds: 60}
+		Ω(ValidateClusterConfig(cfg).Error()).Should(ContainSubstring("clients.zk.session_timeout_seconds 60 is not within"))
+
+		cfg.Clients.ZK = &common.ZKConfig{AuthScheme: "sasl", ACLScheme: "ip"}
+		err = ValidateClusterConfig(cfg)
+		Ω(err.Error()).Should(ContainSubstring("clients.zk.auth_scheme sasl is not supported"))
+		Ω(err.Error()).Should(ContainSubstring("clients.zk.acl_scheme ip is not recognized"))
+		cfg.Clients.ZK = &common.ZKConfig{ACLScheme: "digest"}
+		Ω(ValidateClusterConfig(cfg).Error()).Should(ContainSubstring("clients.zk.acl_scheme digest requires clients.zk.auth_scheme digest"))
+		cfg.Clients.ZK = &common.ZKConfig{AuthScheme: "digest"}
+		Ω(ValidateClusterConfig(cfg).Error()).Should(ContainSubstring("clients.zk.username is missing"))
+		cfg.Clients.ZK = &common.ZKConfig{AuthScheme: "digest", Username: "ares", ACLScheme: "digest"}
+		Ω(ValidateClusterConfig(cfg).Error()).ShouldNot(ContainSubstring("clients.zk.a"))
+
+		for _, root := range []string{"ares", "/ares/", "/"} {
+			cfg.Cluster.ZKRoot = root
+			Ω(ValidateClusterConfig(cfg).Error()).Should(ContainSubstring("cluster.zk_root " + root + " must begin with /"))
+		}
+		cfg.Cluster.ZKRoot = "/shared/ares"
+		Ω(ValidateClusterConfig(cfg).Error()).ShouldNot(ContainSubstring("cluster.zk_root"))
+
+		cfg.Cluster.SchemaFetchMode = "push"
+		Ω(ValidateClusterConfig(cfg).Error()).Should(ContainSubstring("cluster.schema_fetch_mode push is invalid"))
+		cfg.Cluster.SchemaFetchMode = "watch"
+		Ω(ValidateClusterConfig(cfg).Error()).ShouldNot(ContainSubstring("cluster.schema_fetch_mode"))
+		cfg.Clients.ZK = nil
+		Ω(ValidateClusterConfig(cfg).Error()).Should(ContainSubstring("cluster.schema_fetch_mode watch requires clients.zk"))
+	})
+
+	ginkgo.It("GetRedoLogSyncConfig should default to the write sync behavior", func() {
+		Ω(GetRedoLogSyncConfig(common.DiskStoreConfig{WriteSync: true})).Should(Equal(common.RedoLogSyncConfig{
+			Policy: common.RedoLogSyncPerRecord,
+		}))
+		Ω(GetRedoLogSyncConfig(common.DiskStoreConfig{})).Should(Equal(common.RedoLogSyncConfig{
+			Policy: common.RedoLogSyncAsync,
+		}))
+		Ω(GetRedoLogSyncConfig(common.DiskStoreConfig{
+			WriteSync: true,
+			RedoLogSync: common.RedoLogSyncConfig{
+				Policy:       common.RedoLogSyncAsync,
+				BatchRecords: 10,
+			},
+		})).Should(Equal(common.RedoLogSyncConfig{
+			Policy: common.RedoLogSyncAsync,
+		}))
+		Ω(GetRedoLogSyncConfig(common.DiskStoreConfig{
+			RedoLogSync: common.RedoLogSyncConfig{Policy: common.RedoLogSyncBatched},
+		})).Should(Equal(common.RedoLogSyncConfig{
+			Policy:          common.RedoLogSyncBatched,
+			BatchIntervalMs: 100,
+		}))
+	})
+
+	ginkgo.It("ValidateDiskStoreConfig should check redo log sync config", func() {
+		Ω(ValidateDiskStoreConfig(common.DiskStoreConfig{})).Should(BeNil())
+		Ω(ValidateDiskStoreConfig(common.DiskStoreConfig{
+			RedoLogSync: common.RedoLogSyncConfig{Policy: common.RedoLogSyncBatched, BatchRecords: 100},
+		})).Should(BeNil())
+
+		err := ValidateDiskStoreConfig(common.DiskStoreConfig{
+			RedoLogSync: common.RedoLogSyncConfig{Policy: "always"},
+		})
+		Ω(err).ShouldNot(BeNil())
+		Ω(err.Error()).Should(ContainSubstring("policy always is invalid"))
+
+		err = ValidateDiskStoreConfig(common.DiskStoreConfig{
+			RedoLogSync: common.RedoLogSyncConfig{Policy: common.RedoLogSyncBatched, BatchIntervalMs: -1},
+		})
+		Ω(err).ShouldNot(BeNil())
+	})
+})
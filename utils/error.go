@@ -43,13 +43,16 @@ type StackedError struct {
 }
 
 func (e *StackedError) Error() string {
-	var result string
+	return e.Message() + "\n" + strings.Join(e.Stack, "\n")
+}
+
+// Message returns the lines of error messages, the last added first, without the stack trace.
+func (e *StackedError) Message() string {
+	var result []string
 	for i := len(e.Messages) - 1; i >= 0; i-- {
-		result += e.Messages[i]
-		result += "\n"
+		result = append(result, e.Messages[i])
 	}
-	result += strings.Join(e.Stack, "\n")
-	return result
+	return strings.Join(result, "\n")
 }
 
 // StackError adds one more line of message to err.
@@ -15,12 +15,17 @@
 package utils
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"github.com/uber/aresdb/common"
 	"golang.org/x/net/netutil"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 )
 
@@ -81,6 +86,15 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Hijack lets websocket handlers take over the connection of the original response writer.
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
+
 // WithMetricsFunc will send stats like latency, rps and returning status code after the http handler finishes.
 // It has to be applied to the actual handler function who serves the http request.
 func WithMetricsFunc(h http.HandlerFunc) http.HandlerFunc {
@@ -131,8 +145,13 @@ func ApplyHTTPWrappers(handler http.HandlerFunc, wrappers []HTTPHandlerWrapper)
 	return h
 }
 
+// shutdownTimeout is how long in-flight requests are waited for on graceful shutdown.
+const shutdownTimeout = 30 * time.Second
+
 // LimitServe will start a http server on the port with the handler and at most maxConnection concurrent connections.
-func LimitServe(port int, handler http.Handler, httpCfg common.HTTPConfig) {
+// It returns after shutting down the server gracefully on SIGINT or SIGTERM, once in-flight requests complete.
+// beforeShutdown are called in order on the signal while the server is still serving.
+func LimitServe(port int, handler http.Handler, httpCfg common.HTTPConfig, beforeShutdown ...func()) {
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		GetLogger().Fatal(err)
@@ -145,5 +164,28 @@ func LimitServe(port int, handler http.Handler, httpCfg common.HTTPConfig) {
 		WriteTimeout: time.Duration(httpCfg.WriteTimeOutInSeconds) * time.Second,
 		Handler:      handler,
 	}
-	GetLogger().Fatal(server.Serve(listener))
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		sig := <-signals
+		for _, f := range beforeShutdown {
+			f()
+		}
+		GetLogger().With("signal", sig).Info("Shutting down HTTP server")
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			GetLogger().With("error", err).Error("Failed to shut down HTTP server gracefully")
+		}
+	}()
+
+	if err := server.Serve(listener); err != http.ErrServerClosed {
+		GetLogger().Fatal(err)
+	}
+	// Serve returns right away on shutdown, wait for in-flight requests.
+	<-shutdownDone
 }
@@ -32,9 +32,13 @@ const (
 	ArchivingTimingTotal
 	ArchivingHighWatermark
 	ArchivingLowWatermark
+	LiveStoreMemoryBytes
+	LiveStoreEvictionCount
 	BackfillTimingTotal
 	BackfillLockTiming
 	EstimatedDeviceMemory
+	DeviceMemoryUtilization
+	DeviceQueryCount
 	HTTPHandlerCall
 	HTTPHandlerLatency
 	IngestedRecords
@@ -81,23 +85,56 @@ const (
 	QueryArchiveBytesTransferred
 	QueryRowsReturned
 	RecordsOutOfRetention
+	RecordsTooLate
+	RecordsLosingConflict
 	SnapshotTimingTotal
 	SnapshotTimingLoad
 	SnapshotTimingBuildIndex
 	TimezoneLookupTableCreationTime
 	RedoLogFileCorrupt
+	RedoLogCorruptBytesSkipped
 	MemoryOverflow
 	PreloadingZoneEvicted
 	PurgeTimingTotal
 	PurgedBatches
+	PurgedMemoryBytes
 	RecordsFromFuture
 	BatchSize
 	BatchSizeReportTime
 	SchemaFetchSuccess
 	SchemaFetchFailure
 	SchemaUpdateCount
+	SchemaRollbackCount
 	SchemaDeletionCount
 	SchemaCreationCount
+	SchemaFetchAttempt
+	SchemaApplySuccess
+	MaintenanceFetchFailure
+	PendingUpsertBatches
+	RejectedUpsertBatches
+	RedoLogWriteLatency
+	TenantRunningQueries
+	TenantThrottledQueries
+	NewEnumCasesRejected
+	DerivedColumnTimingTotal
+	DerivedColumnBackfilledBatches
+	DuplicateUpsertBatches
+	QueryCacheHits
+	QueryCacheMisses
+	QueryStageLatency
+	QueryLimitExceeded
+	SlowQueries
+	ScrubbedFiles
+	ScrubbedBytes
+	CorruptFiles
+	RollupTimingTotal
+	RolledUpBatches
+	RedoLogSyncs
+	QuerySubscriptions
+	QuerySubscriptionPushes
+	QuerySubscribersDisconnected
+	RejectedPrimaryKeys
+	TenantQuerySubscriptions
 	// Enum sentinel.
 	NumMetricNames
 )
@@ -138,6 +175,8 @@ const (
 	scopeNameArchivingRecords                = "archiving_records"
 	scopeNameArchivingHighWatermark          = "archiving_high_watermark"
 	scopeNameArchivingLowWatermark           = "archiving_low_watermark"
+	scopeNameLiveStoreMemoryBytes            = "live_store_bytes"
+	scopeNameLiveStoreEvictionCount          = "live_store_evictions"
 	scopeNameBackfillRecords                 = "backfill_records"
 	scopeNameBackfillNewRecords              = "backfill_new_records"
 	scopeNameBackfillNoEffectRecords         = "backfill_no_effect_records"
@@ -150,6 +189,8 @@ const (
 	scopeNameBackfillRecordsColumnRemoved    = "backfill_records_column_removed"
 	scopeNameDuplicateRecordRatio            = "duplicate_record_ratio"
 	scopeNameEstimatedDeviceMemory           = "estimated_device_memory"
+	scopeNameDeviceMemoryUtilization         = "device_memory_utilization"
+	scopeNameDeviceQueryCount                = "device_query_count"
 	scopeNameHTTPHandlerCall                 = "http.call"
 	scopeNameHTTPHandlerLatency              = "http.latency"
 	scopeNamePrimaryKeyMissing               = "primary_key_missing"
@@ -183,19 +224,50 @@ const (
 	scopeNameQueryBytesTransferred           = "bytes_transferred"
 	scopeNameQueryRowsReturned               = "rows_returned"
 	scopeNameRecordsOutOfRetention           = "records_out_of_retention"
+	scopeNameRecordsTooLate                  = "records_too_late"
+	scopeNameRecordsLosingConflict           = "records_losing_conflict"
 	scopeNameTimezoneLookupTableCreationTime = "timezone_lookup_table_creation_time"
 	scopeNameRedoLogFileCorrupt              = "redo_log_file_corrupt"
+	scopeNameRedoLogCorruptBytesSkipped      = "redo_log_corrupt_bytes_skipped"
 	scopeNameMemoryOverflow                  = "memory_overflow"
 	scopeNamePreloadingZoneEvicted           = "preloading_zone_evicted"
 	scopeNameBatchesPurged                   = "purged_batches"
+	scopeNameMemoryBytesPurged               = "purged_memory_bytes"
 	scopeNameFutureRecords                   = "records_from_future"
 	scopeNameBatchSize                       = "batch_size"
 	scopeNameBatchSizeReportTime             = "batch_size_report_time"
 	scopeNameSchemaFetchSuccess              = "schema_fetch_success"
 	scopeNameSchemaFetchFailure              = "schema_fetch_failure"
 	scopeNameSchemaUpdateCount               = "schema_updates"
+	scopeNameSchemaRollbackCount             = "schema_rollbacks"
 	scopeNameSchemaDeletionCount             = "schema_deletions"
 	scopeNameSchemaCreationCount             = "schema_creations"
+	scopeNameSchemaFetchAttempt              = "schema_fetch_attempts"
+	scopeNameSchemaApplySuccess              = "schema_apply_success"
+	scopeNameMaintenanceFetchFailure         = "maintenance_fetch_failure"
+	scopeNamePendingUpsertBatches            = "pending_upsert_batches"
+	scopeNameRejectedUpsertBatches           = "rejected_upsert_batches"
+	scopeNameRedoLogWriteLatency             = "redo_log_write_latency"
+	scopeNameTenantRunningQueries            = "tenant_running_queries"
+	scopeNameTenantThrottledQueries          = "tenant_throttled_queries"
+	scopeNameNewEnumCasesRejected            = "new_enum_cases_rejected"
+	scopeNameDerivedColumnBackfilledBatches  = "derived_column_backfilled_batches"
+	scopeNameDuplicateUpsertBatches          = "duplicate_upsert_batches"
+	scopeNameQueryCacheHits                  = "query_cache_hits"
+	scopeNameQueryCacheMisses                = "query_cache_misses"
+	scopeNameQueryStageLatency               = "query_stage_latency"
+	scopeNameQueryLimitExceeded              = "query_limit_exceeded"
+	scopeNameSlowQueries                     = "slow_queries"
+	scopeNameScrubbedFiles                   = "scrubbed_files"
+	scopeNameScrubbedBytes                   = "scrubbed_bytes"
+	scopeNameCorruptFiles                    = "corrupt_files"
+	scopeNameRolledUpBatches                 = "rolled_up_batches"
+	scopeNameRedoLogSyncs                    = "redo_log_syncs"
+	scopeNameQuerySubscriptions              = "query_subscriptions"
+	scopeNameQuerySubscriptionPushes         = "query_subscription_pushes"
+	scopeNameQuerySubscribersDisconnected    = "query_subscribers_disconnected"
+	scopeNameRejectedPrimaryKeys             = "rejected_primary_keys"
+	scopeNameTenantQuerySubscriptions        = "tenant_query_subscriptions"
 )
 
 // Metric tag names
@@ -233,6 +305,9 @@ const (
 	metricsOperationRecovery  = "recovery"
 	metricsOperationSnapshot  = "snapshot"
 	metricsOperationPurge     = "purge"
+	metricsOperationDerived   = "derived_column"
+	metricsOperationScrub     = "scrub"
+	metricsOperationRollup    = "rollup"
 )
 
 var metricsDefs = map[MetricName]metricDefinition{
@@ -275,6 +350,22 @@ var metricsDefs = map[MetricName]metricDefinition{
 			metricsTagComponent: metricsComponentMemStore,
 		},
 	},
+	LiveStoreMemoryBytes: {
+		name:       scopeNameLiveStoreMemoryBytes,
+		metricType: Gauge,
+		tags: map[string]string{
+			metricsTagOperation: metricsOperationArchiving,
+			metricsTagComponent: metricsComponentMemStore,
+		},
+	},
+	LiveStoreEvictionCount: {
+		name:       scopeNameLiveStoreEvictionCount,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagOperation: metricsOperationArchiving,
+			metricsTagComponent: metricsComponentMemStore,
+		},
+	},
 	ArchivingTimingTotal: {
 		name:       scopeNameTotal,
 		metricType: Timer,
@@ -306,6 +397,20 @@ var metricsDefs = map[MetricName]metricDefinition{
 			metricsTagComponent: metricsComponentQuery,
 		},
 	},
+	DeviceMemoryUtilization: {
+		name:       scopeNameDeviceMemoryUtilization,
+		metricType: Gauge,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	DeviceQueryCount: {
+		name:       scopeNameDeviceQueryCount,
+		metricType: Gauge,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
 	HTTPHandlerCall: {
 		name:       scopeNameHTTPHandlerCall,
 		metricType: Counter,
@@ -653,6 +758,22 @@ var metricsDefs = map[MetricName]metricDefinition{
 			metricsTagComponent: metricsComponentMemStore,
 		},
 	},
+	RecordsTooLate: {
+		name:       scopeNameRecordsTooLate,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagOperation: metricsOperationIngestion,
+			metricsTagComponent: metricsComponentMemStore,
+		},
+	},
+	RecordsLosingConflict: {
+		name:       scopeNameRecordsLosingConflict,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagOperation: metricsOperationIngestion,
+			metricsTagComponent: metricsComponentMemStore,
+		},
+	},
 	SnapshotTimingTotal: {
 		name:       scopeNameTotal,
 		metricType: Timer,
@@ -691,6 +812,13 @@ var metricsDefs = map[MetricName]metricDefinition{
 			metricsTagComponent: metricsComponentDiskStore,
 		},
 	},
+	RedoLogCorruptBytesSkipped: {
+		name:       scopeNameRedoLogCorruptBytesSkipped,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentDiskStore,
+		},
+	},
 	MemoryOverflow: {
 		name:       scopeNameMemoryOverflow,
 		metricType: Counter,
@@ -721,6 +849,14 @@ var metricsDefs = map[MetricName]metricDefinition{
 			metricsTagComponent: metricsComponentMemStore,
 		},
 	},
+	PurgedMemoryBytes: {
+		name:       scopeNameMemoryBytesPurged,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagOperation: metricsOperationPurge,
+			metricsTagComponent: metricsComponentMemStore,
+		},
+	},
 	RecordsFromFuture: {
 		name:       scopeNameFutureRecords,
 		metricType: Counter,
@@ -764,6 +900,13 @@ var metricsDefs = map[MetricName]metricDefinition{
 			metricsTagComponent: metricsComponentMetaStore,
 		},
 	},
+	SchemaRollbackCount: {
+		name:       scopeNameSchemaRollbackCount,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentMetaStore,
+		},
+	},
 	SchemaDeletionCount: {
 		name:       scopeNameSchemaDeletionCount,
 		metricType: Counter,
@@ -778,6 +921,213 @@ var metricsDefs = map[MetricName]metricDefinition{
 			metricsTagComponent: metricsComponentMetaStore,
 		},
 	},
+	SchemaFetchAttempt: {
+		name:       scopeNameSchemaFetchAttempt,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentMetaStore,
+		},
+	},
+	SchemaApplySuccess: {
+		name:       scopeNameSchemaApplySuccess,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentMetaStore,
+		},
+	},
+	MaintenanceFetchFailure: {
+		name:       scopeNameMaintenanceFetchFailure,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentMetaStore,
+		},
+	},
+	PendingUpsertBatches: {
+		name:       scopeNamePendingUpsertBatches,
+		metricType: Gauge,
+		tags: map[string]string{
+			metricsTagOperation: metricsOperationIngestion,
+			metricsTagComponent: metricsComponentMemStore,
+		},
+	},
+	RejectedUpsertBatches: {
+		name:       scopeNameRejectedUpsertBatches,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagOperation: metricsOperationIngestion,
+			metricsTagComponent: metricsComponentMemStore,
+		},
+	},
+	RedoLogWriteLatency: {
+		name:       scopeNameRedoLogWriteLatency,
+		metricType: Timer,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentDiskStore,
+		},
+	},
+	TenantRunningQueries: {
+		name:       scopeNameTenantRunningQueries,
+		metricType: Gauge,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	TenantThrottledQueries: {
+		name:       scopeNameTenantThrottledQueries,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	NewEnumCasesRejected: {
+		name:       scopeNameNewEnumCasesRejected,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentMetaStore,
+		},
+	},
+	DerivedColumnTimingTotal: {
+		name:       scopeNameTotal,
+		metricType: Timer,
+		tags: map[string]string{
+			metricsTagOperation: metricsOperationDerived,
+			metricsTagComponent: metricsComponentMemStore,
+		},
+	},
+	DerivedColumnBackfilledBatches: {
+		name:       scopeNameDerivedColumnBackfilledBatches,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagOperation: metricsOperationDerived,
+			metricsTagComponent: metricsComponentMemStore,
+		},
+	},
+	DuplicateUpsertBatches: {
+		name:       scopeNameDuplicateUpsertBatches,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagOperation: metricsOperationIngestion,
+			metricsTagComponent: metricsComponentMemStore,
+		},
+	},
+	QueryCacheHits: {
+		name:       scopeNameQueryCacheHits,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	QueryCacheMisses: {
+		name:       scopeNameQueryCacheMisses,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	QueryStageLatency: {
+		name:       scopeNameQueryStageLatency,
+		metricType: Timer,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	QueryLimitExceeded: {
+		name:       scopeNameQueryLimitExceeded,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	SlowQueries: {
+		name:       scopeNameSlowQueries,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	ScrubbedFiles: {
+		name:       scopeNameScrubbedFiles,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentDiskStore,
+			metricsTagOperation: metricsOperationScrub,
+		},
+	},
+	ScrubbedBytes: {
+		name:       scopeNameScrubbedBytes,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentDiskStore,
+			metricsTagOperation: metricsOperationScrub,
+		},
+	},
+	CorruptFiles: {
+		name:       scopeNameCorruptFiles,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentDiskStore,
+			metricsTagOperation: metricsOperationScrub,
+		},
+	},
+	RollupTimingTotal: {
+		name:       scopeNameTotal,
+		metricType: Timer,
+		tags: map[string]string{
+			metricsTagOperation: metricsOperationRollup,
+			metricsTagComponent: metricsComponentMemStore,
+		},
+	},
+	RolledUpBatches: {
+		name:       scopeNameRolledUpBatches,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagOperation: metricsOperationRollup,
+			metricsTagComponent: metricsComponentMemStore,
+		},
+	},
+	RedoLogSyncs: {
+		name:       scopeNameRedoLogSyncs,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentDiskStore,
+		},
+	},
+	QuerySubscriptions: {
+		name:       scopeNameQuerySubscriptions,
+		metricType: Gauge,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	QuerySubscriptionPushes: {
+		name:       scopeNameQuerySubscriptionPushes,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	QuerySubscribersDisconnected: {
+		name:       scopeNameQuerySubscribersDisconnected,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
+	RejectedPrimaryKeys: {
+		name:       scopeNameRejectedPrimaryKeys,
+		metricType: Counter,
+		tags: map[string]string{
+			metricsTagOperation: metricsOperationIngestion,
+			metricsTagComponent: metricsComponentMemStore,
+		},
+	},
+	TenantQuerySubscriptions: {
+		name:       scopeNameTenantQuerySubscriptions,
+		metricType: Gauge,
+		tags: map[string]string{
+			metricsTagComponent: metricsComponentQuery,
+		},
+	},
 }
 
 func (def *metricDefinition) init(rootScope tally.Scope) {
@@ -58,11 +58,12 @@ func (r *StreamDataReader) Read(bs []byte) error {
 	}
 
 	// We return EOF directly without wrapping so that callers can take special actions against EOF.
+	// Readers may also return EOF along with the last bytes of the stream, which is not an error.
 	if err == io.EOF {
-		return err
-	}
-
-	if err != nil {
+		if bytesRead < bytesToRead {
+			return err
+		}
+	} else if err != nil {
 		return StackError(err, "Failed to Read data from underlying reader")
 	}
 
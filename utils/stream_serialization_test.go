@@ -23,6 +23,7 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"testing/iotest"
 )
 
 var _ = ginkgo.Describe("stream serialization", func() {
@@ -204,6 +205,15 @@ var _ = ginkgo.Describe("stream serialization", func() {
 		Ω(err).ShouldNot(BeNil())
 	})
 
+	ginkgo.It("works for readers returning eof with last bytes", func() {
+		reader := NewStreamDataReader(iotest.DataErrReader(bytes.NewReader([]byte{1, 0, 0, 0})))
+		v, err := reader.ReadUint32()
+		Ω(err).Should(BeNil())
+		Ω(v).Should(Equal(uint32(1)))
+		_, err = reader.ReadUint32()
+		Ω(err).Should(Equal(io.EOF))
+	})
+
 	ginkgo.It("works for big bytes slice (>1GB)", func() {
 		sourceBytes := make([]byte, 10)
 		buf := bytes.NewBuffer(sourceBytes)
@@ -21,6 +21,7 @@ import (
 
 const (
 	secondsInHour = 3600
+	secondsInDay  = 86400
 )
 
 // NowFunc type for function of getting current time
@@ -109,6 +110,78 @@ func CalculateDSTSwitchTs(fromTs, toTs int64, loc *time.Location) (switchTs int6
 	return toTs - toTs%secondsInHour, nil
 }
 
+// CountDSTSwitches counts DST switch times within a time range for given zone
+// it assumes there is at most 1 switch within a day
+func CountDSTSwitches(fromTs, toTs int64, loc *time.Location) int {
+	count := 0
+	for start := fromTs; start < toTs; start += secondsInDay {
+		end := start + secondsInDay
+		if end > toTs {
+			end = toTs
+		}
+		if CrossDST(start, end, loc) {
+			count++
+		}
+	}
+	return count
+}
+
+// DSTSegments are the UTC offsets of a timezone within a time range, split at its DST switches.
+type DSTSegments struct {
+	// SwitchTs are the DST switch timestamps within the time range in ascending order.
+	SwitchTs []int64
+	// Offsets are the offsets in seconds before the first switch and after each switch.
+	Offsets []int
+}
+
+// GetDSTSegments splits a time range at the DST switches of the given zone
+// it assumes there is at most 1 switch within a day
+func GetDSTSegments(fromTs, toTs int64, loc *time.Location) *DSTSegments {
+	_, fromOffset := time.Unix(fromTs, 0).In(loc).Zone()
+	segments := &DSTSegments{Offsets: []int{fromOffset}}
+	for start := fromTs; start < toTs; start += secondsInDay {
+		end := start + secondsInDay
+		if end > toTs {
+			end = toTs
+		}
+		if CrossDST(start, end, loc) {
+			switchTs, _ := CalculateDSTSwitchTs(start, end, loc)
+			_, offset := time.Unix(switchTs, 0).In(loc).Zone()
+			segments.SwitchTs = append(segments.SwitchTs, switchTs)
+			segments.Offsets = append(segments.Offsets, offset)
+		}
+	}
+	return segments
+}
+
+// Offset returns the offset of the segment the UTC timestamp falls in.
+func (s *DSTSegments) Offset(ts int64) int {
+	if s == nil {
+		return 0
+	}
+	offset := s.Offsets[0]
+	for i, switchTs := range s.SwitchTs {
+		if ts >= switchTs {
+			offset = s.Offsets[i+1]
+		}
+	}
+	return offset
+}
+
+// ToUTC converts a local timestamp shifted by Offset back to the UTC timestamp.
+func (s *DSTSegments) ToUTC(ts int64) int64 {
+	if s == nil {
+		return ts
+	}
+	offset := s.Offsets[0]
+	for i, switchTs := range s.SwitchTs {
+		if ts >= switchTs+int64(s.Offsets[i+1]) {
+			offset = s.Offsets[i+1]
+		}
+	}
+	return ts - int64(offset)
+}
+
 // AdjustOffset adjusts timestamp value with time range start or end ts basing on DST switch ts
 func AdjustOffset(fromOffset, toOffset int, switchTs, ts int64) int64 {
 	offset := fromOffset
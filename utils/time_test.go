@@ -70,4 +70,39 @@ var _ = ginkgo.Describe("Time", func() {
 		Ω(err).Should(BeNil())
 		Ω(switchTs).Should(Equal(int64(1509872400)))
 	})
+
+	ginkgo.It("CountDSTSwitches should work", func() {
+		loc, _ := time.LoadLocation("America/Los_Angeles")
+		Ω(CountDSTSwitches(1509872399, 1509872401, loc)).Should(Equal(1))
+		Ω(CountDSTSwitches(1509772400, 1509882400, loc)).Should(Equal(1))
+		Ω(CountDSTSwitches(1509882400, 1509982400, loc)).Should(Equal(0))
+		// 2017-03-01 to 2017-12-01 contains both switches of the year.
+		Ω(CountDSTSwitches(1488355200, 1512115200, loc)).Should(Equal(2))
+		Ω(CountDSTSwitches(1512115200, 1488355200, loc)).Should(Equal(0))
+		Ω(CountDSTSwitches(1488355200, 1512115200, time.UTC)).Should(Equal(0))
+	})
+
+	ginkgo.It("GetDSTSegments should work", func() {
+		loc, _ := time.LoadLocation("America/Los_Angeles")
+		// 2017-03-01 to 2017-12-01 contains both switches of the year.
+		segments := GetDSTSegments(1488355200, 1512115200, loc)
+		Ω(segments.SwitchTs).Should(Equal([]int64{1489312800, 1509872400}))
+		Ω(segments.Offsets).Should(Equal([]int{-28800, -25200, -28800}))
+
+		Ω(segments.Offset(1489312799)).Should(Equal(-28800))
+		Ω(segments.Offset(1489312800)).Should(Equal(-25200))
+		Ω(segments.Offset(1509872400)).Should(Equal(-28800))
+		// local times within the hour before fall back are ambiguous.
+		for _, ts := range []int64{1488355200, 1489312799, 1489312800, 1509865200, 1509872400, 1512115199} {
+			Ω(segments.ToUTC(ts + int64(segments.Offset(ts)))).Should(Equal(ts))
+		}
+
+		segments = GetDSTSegments(1509882400, 1509982400, loc)
+		Ω(segments.SwitchTs).Should(BeEmpty())
+		Ω(segments.Offsets).Should(Equal([]int{-28800}))
+
+		var noSegments *DSTSegments
+		Ω(noSegments.Offset(1509882400)).Should(Equal(0))
+		Ω(noSegments.ToUTC(1509882400)).Should(Equal(int64(1509882400)))
+	})
 })
@@ -0,0 +1,45 @@
+//  Copyright (c) 2017-2018 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// TracingComponent is the component tag of spans created by ares.
+const TracingComponent = "aresdb"
+
+// StartSpanFromRequest starts a span serving the http request with the global tracer, which is a
+// no-op tracer unless one is set via opentracing.SetGlobalTracer. The span continues the trace
+// propagated in the request headers if any. The returned context carries the span and is cancelled
+// along with the request context.
+func StartSpanFromRequest(r *http.Request, operationName string) (opentracing.Span, context.Context) {
+	tracer := opentracing.GlobalTracer()
+	var options []opentracing.StartSpanOption
+	if parent, err := tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(r.Header)); err == nil {
+		options = append(options, ext.RPCServerOption(parent))
+	} else {
+		options = append(options, ext.SpanKindRPCServer)
+	}
+	span := tracer.StartSpan(operationName, options...)
+	ext.Component.Set(span, TracingComponent)
+	ext.HTTPMethod.Set(span, r.Method)
+	ext.HTTPUrl.Set(span, r.URL.String())
+	return span, opentracing.ContextWithSpan(r.Context(), span)
+}